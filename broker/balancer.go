@@ -0,0 +1,170 @@
+package broker
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Replica load balancing strategies.
+const (
+	// BalanceRoundRobin rotates through the replicas of a shard.
+	BalanceRoundRobin = "round_robin"
+	// BalanceLeastLatency prefers the replica with the lowest recent latency.
+	BalanceLeastLatency = "least_latency"
+	// BalanceTwoChoice picks two random replicas and prefers the faster one.
+	BalanceTwoChoice = "random_two_choice"
+
+	latencyDecay = 0.3         // Weight of the newest sample in the latency moving average
+	errorPenalty = time.Second // Latency added to a replica's average when it fails
+)
+
+// ShardKey identifies a shard within a collection.
+type ShardKey struct {
+	Collection string
+	ShardID    int
+}
+
+// ReplicaSelector decides which replica of a shard serves a request. Only the first replica
+// returned by Order is queried; the following ones are tried in order if it fails.
+type ReplicaSelector interface {
+	// Order returns the indexes of the n replicas of shard in the order they should be tried.
+	Order(shard ShardKey, n int) []int
+	// Observe records the outcome of a request to a replica.
+	Observe(shard ShardKey, replica int, latency time.Duration, err error)
+}
+
+// NewReplicaSelector returns the ReplicaSelector implementing the named strategy.
+func NewReplicaSelector(strategy string) (ReplicaSelector, error) {
+	switch strategy {
+	case "", BalanceRoundRobin:
+		return newRoundRobinSelector(), nil
+	case BalanceLeastLatency:
+		return &leastLatencySelector{latencies: newLatencyTracker()}, nil
+	case BalanceTwoChoice:
+		return &twoChoiceSelector{latencies: newLatencyTracker(), rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+	}
+	return nil, fmt.Errorf("unknown load balancing strategy %q", strategy)
+}
+
+// roundRobinSelector starts each request at the replica after the one used last time.
+type roundRobinSelector struct {
+	mu   sync.Mutex
+	next map[ShardKey]int
+}
+
+func newRoundRobinSelector() *roundRobinSelector {
+	return &roundRobinSelector{next: make(map[ShardKey]int)}
+}
+
+func (s *roundRobinSelector) Order(shard ShardKey, n int) []int {
+	s.mu.Lock()
+	start := s.next[shard] % n
+	s.next[shard] = start + 1
+	s.mu.Unlock()
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = (start + i) % n
+	}
+	return order
+}
+
+func (s *roundRobinSelector) Observe(ShardKey, int, time.Duration, error) {}
+
+// replicaKey identifies a single replica of a shard.
+type replicaKey struct {
+	shard   ShardKey
+	replica int
+}
+
+// latencyTracker keeps an exponentially weighted moving average of replica latencies.
+// Failures add errorPenalty so failing replicas are avoided until they recover.
+type latencyTracker struct {
+	mu       sync.Mutex
+	averages map[replicaKey]time.Duration
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{averages: make(map[replicaKey]time.Duration)}
+}
+
+func (t *latencyTracker) observe(key replicaKey, latency time.Duration, err error) {
+	if err != nil {
+		latency += errorPenalty
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	avg, ok := t.averages[key]
+	if !ok {
+		t.averages[key] = latency
+		return
+	}
+	t.averages[key] = time.Duration(latencyDecay*float64(latency) + (1-latencyDecay)*float64(avg))
+}
+
+// byLatency returns the replica indexes of shard sorted by average latency. Replicas
+// without samples sort first so every replica gets measured.
+func (t *latencyTracker) byLatency(shard ShardKey, n int) []int {
+	t.mu.Lock()
+	avgs := make([]time.Duration, n)
+	for i := range avgs {
+		avgs[i] = t.averages[replicaKey{shard, i}]
+	}
+	t.mu.Unlock()
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return avgs[order[a]] < avgs[order[b]] })
+	return order
+}
+
+// leastLatencySelector always prefers the replica with the lowest average latency.
+type leastLatencySelector struct {
+	latencies *latencyTracker
+}
+
+func (s *leastLatencySelector) Order(shard ShardKey, n int) []int {
+	return s.latencies.byLatency(shard, n)
+}
+
+func (s *leastLatencySelector) Observe(shard ShardKey, replica int, latency time.Duration, err error) {
+	s.latencies.observe(replicaKey{shard, replica}, latency, err)
+}
+
+// twoChoiceSelector implements the "power of two random choices": it compares two random
+// replicas and prefers the faster one, which spreads load better than always picking the
+// fastest replica. The remaining replicas follow by latency for failover.
+type twoChoiceSelector struct {
+	latencies *latencyTracker
+	mu        sync.Mutex // Protects rnd
+	rnd       *rand.Rand
+}
+
+func (s *twoChoiceSelector) Order(shard ShardKey, n int) []int {
+	order := s.latencies.byLatency(shard, n)
+	if n < 2 {
+		return order
+	}
+	s.mu.Lock()
+	a, b := s.rnd.Intn(n), s.rnd.Intn(n-1)
+	s.mu.Unlock()
+	if b >= a {
+		b++
+	}
+	// order is sorted by latency, so the candidate with the lower position is faster.
+	pick := a
+	if b < a {
+		pick = b
+	}
+	chosen := order[pick]
+	return append([]int{chosen}, append(order[:pick:pick], order[pick+1:]...)...)
+}
+
+func (s *twoChoiceSelector) Observe(shard ShardKey, replica int, latency time.Duration, err error) {
+	s.latencies.observe(replicaKey{shard, replica}, latency, err)
+}
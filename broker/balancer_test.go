@@ -0,0 +1,101 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRoundRobinSelector(t *testing.T) {
+	s := newRoundRobinSelector()
+	shard := ShardKey{Collection: DefaultCollection, ShardID: 0}
+	var firsts []int
+	for i := 0; i < 4; i++ {
+		order := s.Order(shard, 3)
+		if len(order) != 3 {
+			t.Fatalf("Expected 3 replicas in the failover order, got %v", order)
+		}
+		firsts = append(firsts, order[0])
+	}
+	if firsts[0] != 0 || firsts[1] != 1 || firsts[2] != 2 || firsts[3] != 0 {
+		t.Errorf("Expected replicas to rotate, got %v", firsts)
+	}
+}
+
+func TestLeastLatencySelector(t *testing.T) {
+	s, err := NewReplicaSelector(BalanceLeastLatency)
+	if err != nil {
+		t.Fatalf("NewReplicaSelector returned an error: %v", err)
+	}
+	shard := ShardKey{Collection: DefaultCollection, ShardID: 0}
+	s.Observe(shard, 0, 50*time.Millisecond, nil)
+	s.Observe(shard, 1, 5*time.Millisecond, nil)
+	s.Observe(shard, 2, 1*time.Millisecond, errors.New("boom"))
+
+	if order := s.Order(shard, 3); order[0] != 1 || order[1] != 0 || order[2] != 2 {
+		t.Errorf("Expected order [1 0 2] (failing replica last), got %v", order)
+	}
+}
+
+func TestTwoChoiceSelector(t *testing.T) {
+	s, err := NewReplicaSelector(BalanceTwoChoice)
+	if err != nil {
+		t.Fatalf("NewReplicaSelector returned an error: %v", err)
+	}
+	shard := ShardKey{Collection: DefaultCollection, ShardID: 0}
+	s.Observe(shard, 0, time.Millisecond, nil)
+	s.Observe(shard, 1, 100*time.Millisecond, nil)
+	s.Observe(shard, 2, 100*time.Millisecond, nil)
+
+	for i := 0; i < 20; i++ {
+		order := s.Order(shard, 3)
+		if len(order) != 3 || order[0]+order[1]+order[2] != 3 {
+			t.Fatalf("Expected a permutation of the 3 replicas, got %v", order)
+		}
+		// Replica 2 ranks last by latency (ties keep index order), so it never wins a comparison.
+		if order[0] == 2 {
+			t.Errorf("The slowest replica was chosen: %v", order)
+		}
+	}
+	if _, err := NewReplicaSelector("fastest"); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
+
+func TestBroker_Search_OneReplicaPerShardWithFailover(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: []string{"replica"}}, nil
+		},
+	}
+	var calls [3]atomic.Int32
+	replica := func(i int, err error) *MockSearcher {
+		return &MockSearcher{
+			ShardID: 0,
+			SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+				calls[i].Add(1)
+				if err != nil {
+					return nil, err
+				}
+				return []SearchResult{{ID: "doc", Score: 1}}, nil
+			},
+		}
+	}
+	b := NewBroker(mockQU, []Searcher{replica(0, nil), replica(1, errors.New("down")), replica(2, nil)})
+
+	for i := 0; i < 3; i++ {
+		resp, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{})
+		if err != nil {
+			t.Fatalf("SearchWithOptions returned an error: %v", err)
+		}
+		if len(resp.Results) != 1 || resp.Shards.Successful != 1 {
+			t.Errorf("Request %d: unexpected response %+v", i, resp)
+		}
+	}
+	// Round-robin starts at replicas 0, 1 and 2; replica 1 fails over to replica 2.
+	if calls[0].Load() != 1 || calls[1].Load() != 1 || calls[2].Load() != 2 {
+		t.Errorf("Unexpected replica calls: %d %d %d", calls[0].Load(), calls[1].Load(), calls[2].Load())
+	}
+}
@@ -0,0 +1,258 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"    // Requests flow normally
+	BreakerOpen     = "open"      // The searcher is removed from routing
+	BreakerHalfOpen = "half_open" // A single probe request is allowed through
+)
+
+// ErrCircuitOpen is returned for a shard whose replicas are all removed from routing.
+var ErrCircuitOpen = errors.New("circuit open for all replicas")
+
+// BreakerConfig controls when a searcher's circuit breaker trips and recovers.
+type BreakerConfig struct {
+	WindowSize       int           // Number of recent requests considered
+	MinRequests      int           // Requests required in the window before the breaker may trip
+	FailureRate      float64       // Fraction of failed requests in the window that trips the breaker
+	SlowCallDuration time.Duration // Requests slower than this count as failures; 0 disables
+	OpenDuration     time.Duration // Time an open breaker waits before letting a probe through
+}
+
+// DefaultBreakerConfig returns the breaker configuration used by NewBroker.
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		WindowSize:       20,
+		MinRequests:      5,
+		FailureRate:      0.5,
+		SlowCallDuration: 2 * time.Second,
+		OpenDuration:     30 * time.Second,
+	}
+}
+
+// BreakerStatus is the state of a single searcher's circuit breaker, as reported by the
+// admin endpoint.
+type BreakerStatus struct {
+	Collection   string     `json:"collection"`
+	ShardID      int        `json:"shard_id"`
+	Replica      int        `json:"replica"`
+	Searcher     string     `json:"searcher"`
+	State        string     `json:"state"`
+	Requests     int        `json:"requests"`
+	Failures     int        `json:"failures"`
+	ErrorRate    float64    `json:"error_rate"`
+	AvgLatencyMs float64    `json:"avg_latency_ms"`
+	OpenedAt     *time.Time `json:"opened_at,omitempty"`
+	Trips        int        `json:"trips"`
+}
+
+// circuitBreaker tracks the outcome of the last WindowSize requests to one searcher.
+type circuitBreaker struct {
+	searcher string
+	state    string
+	outcomes []bool          // Ring buffer of recent outcomes; true means failure
+	latency  []time.Duration // Ring buffer of recent latencies
+	next     int
+	count    int
+	openedAt time.Time
+	probing  bool // A half-open probe is in flight
+	trips    int
+}
+
+// CircuitBreakers holds the circuit breakers of every searcher known to a Broker.
+type CircuitBreakers struct {
+	mu       sync.Mutex
+	config   BreakerConfig
+	breakers map[replicaKey]*circuitBreaker
+	now      func() time.Time
+}
+
+// NewCircuitBreakers creates an empty breaker registry with the given configuration.
+func NewCircuitBreakers(config BreakerConfig) *CircuitBreakers {
+	return &CircuitBreakers{config: config, breakers: make(map[replicaKey]*circuitBreaker), now: time.Now}
+}
+
+// register adds a closed breaker for a searcher.
+func (c *CircuitBreakers) register(key replicaKey, s Searcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.breakers[key] = &circuitBreaker{
+		searcher: describeSearcher(s),
+		state:    BreakerClosed,
+		outcomes: make([]bool, c.config.WindowSize),
+		latency:  make([]time.Duration, c.config.WindowSize),
+	}
+}
+
+// Allow reports whether a request may be routed to the replica. An open breaker whose
+// OpenDuration has elapsed moves to half-open and admits a single probe request.
+func (c *CircuitBreakers) Allow(key replicaKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[key]
+	if !ok {
+		return true
+	}
+	switch b.state {
+	case BreakerOpen:
+		if c.now().Sub(b.openedAt) < c.config.OpenDuration {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// Closed reports whether the replica's breaker is closed, without admitting a probe of an
+// open one: calls whose outcome isn't recorded only go to replicas that serve searches.
+func (c *CircuitBreakers) Closed(key replicaKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[key]
+	return !ok || b.state == BreakerClosed
+}
+
+// inherit carries the state and recent outcomes of the breakers of prev over to the
+// breakers of the same searchers, so that a reload doesn't close the breakers of failing
+// searchers. A probe in flight is recorded into prev, so a half-open breaker admits a new
+// one.
+func (c *CircuitBreakers) inherit(prev *CircuitBreakers) {
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, b := range c.breakers {
+		p, ok := prev.breakers[key]
+		if !ok || p.searcher != b.searcher {
+			continue
+		}
+		b.state, b.openedAt, b.trips = p.state, p.openedAt, p.trips
+		if len(p.outcomes) == len(b.outcomes) {
+			copy(b.outcomes, p.outcomes)
+			copy(b.latency, p.latency)
+			b.next, b.count = p.next, p.count
+		}
+	}
+}
+
+// Record updates the replica's breaker with the outcome of a request.
+func (c *CircuitBreakers) Record(key replicaKey, latency time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[key]
+	if !ok {
+		return
+	}
+	failed := err != nil || (c.config.SlowCallDuration > 0 && latency > c.config.SlowCallDuration)
+
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if failed {
+			c.open(b)
+			return
+		}
+		// The probe succeeded: close the breaker with a fresh window.
+		b.state = BreakerClosed
+		b.next, b.count = 0, 0
+	}
+
+	b.outcomes[b.next] = failed
+	b.latency[b.next] = latency
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.count < len(b.outcomes) {
+		b.count++
+	}
+	if b.state == BreakerClosed && b.count >= c.config.MinRequests && b.failureRate() >= c.config.FailureRate {
+		c.open(b)
+	}
+}
+
+// open trips the breaker.
+func (c *CircuitBreakers) open(b *circuitBreaker) {
+	b.state = BreakerOpen
+	b.openedAt = c.now()
+	b.trips++
+}
+
+// failureRate returns the fraction of failed requests in the window.
+func (b *circuitBreaker) failureRate() float64 {
+	if b.count == 0 {
+		return 0
+	}
+	failures := 0
+	for i := 0; i < b.count; i++ {
+		if b.outcomes[i] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(b.count)
+}
+
+// Statuses returns the state of every breaker, ordered by collection, shard and replica.
+func (c *CircuitBreakers) Statuses() []BreakerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	statuses := make([]BreakerStatus, 0, len(c.breakers))
+	for key, b := range c.breakers {
+		status := BreakerStatus{
+			Collection: key.shard.Collection,
+			ShardID:    key.shard.ShardID,
+			Replica:    key.replica,
+			Searcher:   b.searcher,
+			State:      b.state,
+			Requests:   b.count,
+			ErrorRate:  b.failureRate(),
+			Trips:      b.trips,
+		}
+		var total time.Duration
+		for i := 0; i < b.count; i++ {
+			if b.outcomes[i] {
+				status.Failures++
+			}
+			total += b.latency[i]
+		}
+		if b.count > 0 {
+			status.AvgLatencyMs = float64(total.Microseconds()) / 1000 / float64(b.count)
+		}
+		if b.state != BreakerClosed {
+			openedAt := b.openedAt
+			status.OpenedAt = &openedAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.Collection != b.Collection {
+			return a.Collection < b.Collection
+		}
+		if a.ShardID != b.ShardID {
+			return a.ShardID < b.ShardID
+		}
+		return a.Replica < b.Replica
+	})
+	return statuses
+}
+
+// describeSearcher returns a human readable name for a searcher.
+func describeSearcher(s Searcher) string {
+	if str, ok := s.(fmt.Stringer); ok {
+		return str.String()
+	}
+	return fmt.Sprintf("%T", s)
+}
@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakers_TripAndRecover(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	breakers := NewCircuitBreakers(BreakerConfig{WindowSize: 4, MinRequests: 2, FailureRate: 0.5, OpenDuration: time.Minute})
+	breakers.now = func() time.Time { return now }
+	key := replicaKey{ShardKey{DefaultCollection, 0}, 0}
+	breakers.register(key, &MockSearcher{})
+
+	breakers.Record(key, time.Millisecond, nil)
+	breakers.Record(key, time.Millisecond, errors.New("boom"))
+	if breakers.Allow(key) {
+		t.Fatal("Expected the breaker to open at a 50% failure rate")
+	}
+
+	now = now.Add(time.Minute)
+	if !breakers.Allow(key) {
+		t.Fatal("Expected a probe to be allowed once the open duration elapsed")
+	}
+	if breakers.Allow(key) {
+		t.Error("Expected only a single probe while half-open")
+	}
+	breakers.Record(key, time.Millisecond, errors.New("still down"))
+	if breakers.Allow(key) {
+		t.Fatal("Expected a failed probe to reopen the breaker")
+	}
+
+	now = now.Add(time.Minute)
+	breakers.Allow(key)
+	breakers.Record(key, time.Millisecond, nil)
+	statuses := breakers.Statuses()
+	if len(statuses) != 1 || statuses[0].State != BreakerClosed || statuses[0].Trips != 2 || statuses[0].Requests != 1 {
+		t.Errorf("Expected a closed breaker with a fresh window after a successful probe, got %+v", statuses)
+	}
+}
+
+func TestBroker_Search_SkipsTrippedSearchers(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: []string{"breaker"}}, nil
+		},
+	}
+	unhealthyCalls := 0
+	unhealthy := &MockSearcher{ShardID: 0, SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+		unhealthyCalls++
+		return nil, errors.New("down")
+	}}
+	healthy := &MockSearcher{ShardID: 0, SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+		return []SearchResult{{ID: "doc", Score: 1}}, nil
+	}}
+	b := NewBroker(mockQU, []Searcher{unhealthy, healthy})
+	b.SetBreakerConfig(BreakerConfig{WindowSize: 10, MinRequests: 2, FailureRate: 0.5, OpenDuration: time.Hour})
+
+	for i := 0; i < 10; i++ {
+		results, err := b.Search(context.Background(), "q")
+		if err != nil || len(results) != 1 {
+			t.Fatalf("Request %d: expected one result, got %v, %v", i, results, err)
+		}
+	}
+	if unhealthyCalls != 2 {
+		t.Errorf("Expected the unhealthy searcher to be removed from routing after 2 failures, got %d calls", unhealthyCalls)
+	}
+
+	rec := httptest.NewRecorder()
+	NewHandler(b).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/breakers", nil))
+	var body struct {
+		Breakers []BreakerStatus `json:"breakers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode breakers response: %v", err)
+	}
+	if len(body.Breakers) != 2 || body.Breakers[0].State != BreakerOpen || body.Breakers[1].State != BreakerClosed {
+		t.Errorf("Unexpected breaker statuses: %+v", body.Breakers)
+	}
+}
+
+func TestBroker_Search_AllReplicasOpen(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: []string{"breaker"}}, nil
+		},
+	}
+	down := &MockSearcher{ShardID: 0, SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+		return nil, errors.New("down")
+	}}
+	b := NewBroker(mockQU, []Searcher{down})
+	b.SetBreakerConfig(BreakerConfig{WindowSize: 2, MinRequests: 1, FailureRate: 1, OpenDuration: time.Hour})
+
+	b.SearchWithOptions(context.Background(), "q", SearchOptions{})
+	resp, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	details := resp.Shards.Details
+	if resp.Shards.Failed != 1 || len(details) != 1 || details[0].Searchers != 0 || len(details[0].Errors) != 1 {
+		t.Errorf("Expected the shard to fail without querying the tripped searcher, got %+v", resp.Shards)
+	}
+}
@@ -2,20 +2,59 @@ package broker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt" // For fmt.Errorf
-	"log" // For log.Println
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"common/simhash"
+	"common/slowlog"
+	"common/tenant"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer is used to create spans for the broker's query processing stages.
+var tracer = otel.Tracer("broker")
+
 // RawQuery represents the initial query string from the user.
 type RawQuery string
 
 // StructuredQuery represents the query after being processed by the Query Understanding Service.
 // This struct should contain fields that are suitable for searching, e.g., keywords, filters, etc.
 type StructuredQuery struct {
-	Keywords []string
-	Filters  map[string]string
-	// Add other relevant fields as needed (e.g., intent, entities)
+	Keywords      []string
+	Query         *QueryNode  // Boolean query tree parsed from query syntax; nil searches Keywords
+	Filters       []Filter    // Restrictions every result must satisfy
+	Language      string      // ISO 639-1 code of the detected query language, if known
+	Intent        string      // Query intent classified by query understanding (e.g. "transactional"), if known
+	Collection    string      // Logical collection being searched; empty means DefaultCollection
+	Tenant        string      // Tenant owning the collection; empty for the default tenant
+	Sort          []SortField // Requested result order; empty means descending score
+	Geo           *GeoQuery   // Optional geo distance restriction
+	Explain       bool        // Ask searchers to explain the score of each hit
+	Fuzziness     int         // Edit distance tolerated when matching keywords and term nodes; 0 matches exactly
+	PrefixLength  int         // Leading characters a fuzzy match must share with the query term
+	Fields        []string    // Stored fields returned with every result, AllFields for all of them
+	RankingFields []string    // Stored fields results are ranked or collapsed by, fetched but not returned
+	Prefix        bool        // Match the last keyword as the prefix of a word, as in instant searches
+	PrefixFields  []string    // Edge n-gram fields the prefix is matched against; empty matches any field
+	Types         []string    // Document types results are restricted to; empty searches all types
+	KNN           *KNNQuery   // Nearest neighbor search of a vector field; nil searches text only
+	Embedding     []float32   // Vector of the query computed by query understanding, if its pipeline embeds queries
+	IDsOnly       bool        // Only return IDs, scores, sort values and ranking fields, as in the query phase of query-then-fetch searches
+	Size          int         // Results returned by every shard; 0 uses the searchers' default
+	SearchAfter   []string    // Sort key of the shard's last result of the previous scroll page; nil starts from the first
+	// Add other relevant fields as needed (e.g., entities)
 }
 
 // SearchResult represents a single search result item.
@@ -24,6 +63,33 @@ type SearchResult struct {
 	Title string
 	URL   string
 	Score float64
+	// SortValues holds the result's sort key as reported by its searcher, one value
+	// per requested sort field. It is used to merge results across shards.
+	SortValues []interface{} `json:",omitempty"`
+	// DistanceKm is the distance from the geo query origin, set for geo searches.
+	DistanceKm *float64 `json:",omitempty"`
+	// Fields holds the stored values of the fields selected by the query, keeping their
+	// JSON types (strings, numbers, booleans, arrays).
+	Fields map[string]interface{} `json:",omitempty"`
+	// Stored holds every stored field the searcher returned, including those only fetched
+	// for ranking rules.
+	Stored map[string]interface{} `json:"-"`
+	// Group is the value of the collapse field the result was grouped by, set for
+	// collapsed searches.
+	Group string `json:",omitempty"`
+	// Duplicates lists the IDs of the near-duplicates of the result left out of the
+	// response, set when near-duplicate detection is enabled.
+	Duplicates []string `json:",omitempty"`
+	// Rules names the ranking rules that moved or rescored the result.
+	Rules []string `json:"-"`
+	// Explanation is the searcher's scoring breakdown, set when the query asked for it.
+	// It is reported in the debug section of the response rather than with the result.
+	Explanation json.RawMessage `json:"-"`
+	// SortKey is the searcher's opaque sort key of the result, which scrolls resume after.
+	SortKey []string `json:"-"`
+	// shardID is the shard that returned the result, recorded by query-then-fetch
+	// searches to fetch its stored fields.
+	shardID int
 	// Add other relevant fields as needed (e.g., snippet, source)
 }
 
@@ -40,52 +106,402 @@ type Searcher interface {
 	GetShardID() int // Add method to retrieve the shard ID
 }
 
+// SegmentSearcher is implemented by searchers reporting, with the results of a search, the
+// segment of the collection they served it from, empty if they don't know. Responses are
+// versioned by the segments their shards were searched at, see Handler.HandleSearch.
+type SegmentSearcher interface {
+	Searcher
+	SearchSegment(ctx context.Context, query StructuredQuery) ([]SearchResult, string, error)
+}
+
+// DefaultCollection is the collection served by searchers that don't declare one,
+// and the collection queried when a search request doesn't name one.
+const DefaultCollection = "default"
+
+// ErrUnknownCollection is returned when a search targets a collection that has no searchers.
+var ErrUnknownCollection = errors.New("unknown collection")
+
+// CollectionSearcher is implemented by searchers that serve a named collection.
+// Searchers that don't implement it belong to DefaultCollection.
+type CollectionSearcher interface {
+	Searcher
+	GetCollection() string
+}
+
 // Broker is the service that acts as an entry point for user queries,
 // orchestrates calls to other services, and aggregates results.
 type Broker struct {
-	queryUnderstanding QueryUnderstandingService
-	searchersByShard   map[int][]Searcher // Group searchers by shard ID
+	queryUnderstanding    QueryUnderstandingService
+	searchersByShard      map[int][]Searcher            // Group searchers by shard ID (default collection)
+	collections           map[string]map[int][]Searcher // Searcher pools by pool key ([tenant/]collection), then shard ID
+	replicas              ReplicaSelector               // Chooses which replica serves each shard
+	breakers              *CircuitBreakers              // Removes unhealthy searchers from routing
+	queryLog              *QueryLogger                  // Records every search; nil disables query logging
+	slowLog               *slowlog.Logger               // Records the searches slower than its threshold; nil disables it
+	feedback              *FeedbackTracker              // Ties click feedback to searches and aggregates CTR
+	budget                TimeoutBudget                 // Default latency budget of a search
+	didYouMeanMaxHits     int                           // Searches with at most this many hits get a did-you-mean query
+	nearDuplicateDistance int                           // Results whose fingerprints differ by at most this many bits are collapsed; negative disables it
+	reranker              *Reranker                     // Applies business ranking rules after the merge; nil disables them
+	typeBoosts            map[string]float64            // Score multipliers by document type; nil disables them
+	personalizer          Personalizer                  // Re-scores the merged results of searches with a user ID
+	tenantLimiter         *tenant.Limiter               // Enforces per-tenant quotas; nil disables them
+	experiments           atomic.Pointer[[]Experiment]  // Experiments every search is assigned a bucket of; replaced by SetExperiments
+	instant               InstantConfig                 // Settings of instant searches
+	instantSearches       *instantDebouncer             // Running instant searches by client
+	hybrid                map[string]HybridConfig       // Fusion of hybrid searches by collection
+	globalStats           *GlobalStats                  // Term statistics rescoring shards with the global IDF; nil disables it
+	searchType            string                        // Search type of searches that don't choose one; empty means query-and-fetch
+	hedging               *hedgeDelays                  // Delays after which shard searches are hedged; nil disables hedging
+	segments              *replicaSegments              // Segments the replicas last answered searches from
+	pruner                *ShardPruner                  // Skips the shards that can't match the routing key filters; nil searches every shard
+	joins                 []Join                        // Enrich the returned page of every search, in order
+	fallbacks             []string                      // Relaxations retried in order for searches without results
+	cacheMaxAge           time.Duration                 // How long clients and CDNs may cache search responses
 }
 
 // NewBroker creates a new Broker instance with the given QueryUnderstandingService
 // and a slice of Searcher instances.
 func NewBroker(quService QueryUnderstandingService, searchers []Searcher) *Broker {
-	searchersByShard := make(map[int][]Searcher)
+	collections := map[string]map[int][]Searcher{
+		DefaultCollection: make(map[int][]Searcher),
+	}
 	for _, s := range searchers {
+		key := poolKey(tenantOf(s), collectionOf(s))
+		if collections[key] == nil {
+			collections[key] = make(map[int][]Searcher)
+		}
 		shardID := s.GetShardID()
-		searchersByShard[shardID] = append(searchersByShard[shardID], s)
+		collections[key][shardID] = append(collections[key][shardID], s)
+	}
+	b := &Broker{
+		queryUnderstanding:    quService,
+		searchersByShard:      collections[DefaultCollection],
+		collections:           collections,
+		replicas:              newRoundRobinSelector(),
+		feedback:              NewFeedbackTracker(),
+		budget:                DefaultTimeoutBudget(),
+		personalizer:          NoopPersonalizer{},
+		nearDuplicateDistance: DefaultNearDuplicateDistance,
+		instant:               DefaultInstantConfig(),
+		instantSearches:       newInstantDebouncer(),
+		segments:              newReplicaSegments(),
+	}
+	b.SetBreakerConfig(DefaultBreakerConfig())
+	return b
+}
+
+// inherit carries the runtime state of prev, the broker b replaces, over to b: the click
+// feedback, the running instant searches, the circuit breakers, replica statistics and
+// served segments of the searchers b keeps, the hedging latencies, the tenants' quotas
+// left, the global term statistics, the routing summaries and the records cached by its
+// joins. State b has no counterpart for, e.g. of a feature it disables, is dropped.
+// Replica statistics are kept by position in the shard, so they carry over when the
+// strategy is unchanged.
+func (b *Broker) inherit(prev *Broker) {
+	b.feedback, b.instantSearches = prev.feedback, prev.instantSearches
+	b.breakers.inherit(prev.breakers)
+	if reflect.TypeOf(b.replicas) == reflect.TypeOf(prev.replicas) {
+		b.replicas = prev.replicas
+	}
+	b.hedging.inherit(prev.hedging)
+	b.segments.inherit(prev.segments)
+	b.tenantLimiter.Inherit(prev.tenantLimiter)
+	if b.globalStats != nil && prev.globalStats != nil {
+		b.globalStats = prev.globalStats
+	}
+	if b.pruner != nil && prev.pruner != nil && b.pruner.Field() == prev.pruner.Field() {
+		b.pruner = prev.pruner
+	}
+	for _, j := range b.joins {
+		cache, ok := j.Enricher.(*CachingEnricher)
+		if !ok {
+			continue
+		}
+		for _, p := range prev.joins {
+			if prevCache, ok := p.Enricher.(*CachingEnricher); ok && p.Name == j.Name {
+				cache.inherit(prevCache)
+			}
+		}
+	}
+}
+
+// SetBreakerConfig replaces the searchers' circuit breakers with fresh ones using config.
+func (b *Broker) SetBreakerConfig(config BreakerConfig) {
+	breakers := NewCircuitBreakers(config)
+	for collection, pool := range b.collections {
+		for shardID, replicas := range pool {
+			for i, s := range replicas {
+				breakers.register(replicaKey{ShardKey{collection, shardID}, i}, s)
+			}
+		}
+	}
+	b.breakers = breakers
+}
+
+// BreakerStatuses returns the circuit breaker state of every searcher.
+func (b *Broker) BreakerStatuses() []BreakerStatus {
+	return b.breakers.Statuses()
+}
+
+// SetQueryLogger enables query logging to l; nil disables it.
+func (b *Broker) SetQueryLogger(l *QueryLogger) {
+	b.queryLog = l
+}
+
+// SetReranker applies the ranking rules of r to the merged results of every search; nil
+// disables ranking rules.
+func (b *Broker) SetReranker(r *Reranker) {
+	b.reranker = r
+}
+
+// SetReplicaSelector replaces the replica load balancing strategy (round-robin by default).
+func (b *Broker) SetReplicaSelector(selector ReplicaSelector) {
+	b.replicas = selector
+}
+
+// collectionOf returns the collection served by s.
+func collectionOf(s Searcher) string {
+	if cs, ok := s.(CollectionSearcher); ok && cs.GetCollection() != "" {
+		return cs.GetCollection()
 	}
-	return &Broker{
-		queryUnderstanding: quService,
-		searchersByShard:   searchersByShard,
+	return DefaultCollection
+}
+
+// Collections returns the names of the collections known to the broker, sorted. The
+// collections of tenants other than the default one are prefixed with "<tenant>/".
+func (b *Broker) Collections() []string {
+	names := make([]string, 0, len(b.collections))
+	for name := range b.collections {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
+
+// searcherPool returns the searchers of the given collection of tenantID grouped by
+// shard ID. A tenant only reaches its own collections.
+func (b *Broker) searcherPool(tenantID, collection string) (map[int][]Searcher, error) {
+	pool, ok := b.collections[poolKey(tenantID, collection)]
+	if !ok {
+		if tenantID != tenant.Default {
+			return nil, fmt.Errorf("%w: %q of tenant %q", ErrUnknownCollection, collection, tenantID)
+		}
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCollection, collection)
+	}
+	return pool, nil
 }
 
 // Search receives a raw query, communicates with the Query Understanding Service,
 // fans out the structured query to multiple Searcher instances, and merges their results.
+// It returns every merged result; use SearchWithOptions for pagination and shard status.
 func (b *Broker) Search(ctx context.Context, rawQuery RawQuery) ([]SearchResult, error) {
-	// 1. Communicate with the Query Understanding Service to get a structured query.
-	structuredQuery, err := b.queryUnderstanding.Process(ctx, rawQuery)
+	resp, err := b.SearchWithOptions(ctx, rawQuery, SearchOptions{})
 	if err != nil {
 		return nil, err
 	}
+	return resp.Results, nil
+}
+
+// SearchWithOptions performs a search like Search and returns the results wrapped in a
+// SearchResponse envelope, including timing, per-shard status and pagination information.
+// Every search is recorded by the query logger, if one is set, and gets a query ID that
+// feedback on its results refers to. Searches with few results get a did-you-mean query,
+// and those without results are retried along the fallback chain, see SetFallbacks.
+// Searches over the quota of their tenant fail with an error wrapping
+// tenant.ErrQuotaExceeded.
+func (b *Broker) SearchWithOptions(ctx context.Context, rawQuery RawQuery, opts SearchOptions) (*SearchResponse, error) {
+	start := time.Now()
+	if err := b.tenantLimiter.Allow(opts.Tenant); err != nil {
+		return nil, err
+	}
+	b.assignExperiments(&opts)
+	instant := opts.Mode == ModeInstant
+	if instant && opts.ClientID != "" {
+		var done func()
+		ctx, done = b.instantSearches.start(ctx, poolKey(opts.Tenant, opts.ClientID))
+		defer done()
+	}
+	var (
+		resp            *SearchResponse
+		structuredQuery StructuredQuery
+		err             error
+	)
+	if opts.Mode == ModeHybrid {
+		resp, structuredQuery, err = b.hybridSearch(ctx, rawQuery, opts, start)
+	} else {
+		resp, structuredQuery, err = b.search(ctx, rawQuery, opts, start)
+	}
+	if errors.Is(context.Cause(ctx), ErrSuperseded) {
+		resp, err = nil, ErrSuperseded
+	}
+	if err == nil && !instant && opts.Query == nil && opts.KNN == nil && b.didYouMeanMaxHits >= 0 && resp.TotalHits <= b.didYouMeanMaxHits {
+		resp, structuredQuery = b.didYouMean(ctx, rawQuery, opts, structuredQuery, resp, start)
+	}
+	if err == nil && !instant && opts.Query == nil && opts.KNN == nil && len(b.fallbacks) > 0 && resp.TotalHits == 0 {
+		resp, structuredQuery = b.fallback(ctx, rawQuery, opts, structuredQuery, resp, start)
+	}
+	if err == nil {
+		resp.QueryID = newQueryID()
+		b.feedback.recordSearch(resp.QueryID, resp.Collection, strings.Join(structuredQuery.Keywords, " "), resp.Results, resp.Pagination.From, opts.experiments)
+	}
+	if b.queryLog != nil {
+		b.queryLog.Log(newQueryLogRecord(start, rawQuery, opts, structuredQuery, resp, err))
+	}
+	b.logSlowQuery(start, rawQuery, opts, structuredQuery, resp, err)
+	return resp, err
+}
+
+// search implements SearchWithOptions, also returning the structured query it ran.
+func (b *Broker) search(ctx context.Context, rawQuery RawQuery, opts SearchOptions, start time.Time) (*SearchResponse, StructuredQuery, error) {
+	ctx, span := tracer.Start(ctx, "broker.Search")
+	defer span.End()
+
+	collection := opts.Collection
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	pool, err := b.searcherPool(opts.Tenant, collection)
+	if err != nil {
+		return nil, StructuredQuery{Collection: collection, Tenant: opts.Tenant}, err
+	}
+	span.SetAttributes(attribute.String("search.collection", collection), attribute.String("search.tenant", opts.Tenant))
+
+	// The latency budget bounds the whole search; query understanding gets a share of it.
+	instant := opts.Mode == ModeInstant
+	budget := b.budget
+	if instant && b.instant.Timeout > 0 {
+		budget.Total = b.instant.Timeout
+	}
+	if opts.Timeout > 0 {
+		budget.Total = opts.Timeout
+	}
+	debug := &SearchDebug{BudgetMs: budget.Total.Milliseconds()}
+	var deadline time.Time
+	if budget.Total > 0 {
+		deadline = start.Add(budget.Total)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+		span.SetAttributes(attribute.Int64("search.budget_ms", budget.Total.Milliseconds()))
+	}
+
+	// 1. Communicate with the Query Understanding Service to get a structured query.
+	quCtx, quSpan := tracer.Start(ctx, "query_understanding.Process")
+	quBudget := budget.quBudget()
+	if quBudget > 0 {
+		var cancel context.CancelFunc
+		quCtx, cancel = context.WithTimeout(quCtx, quBudget)
+		defer cancel()
+	}
+	quStart := time.Now()
+	pipeline := opts.pipeline
+	if instant {
+		pipeline = b.instant.Pipeline
+	}
+	quCtx = WithLanguage(WithCollection(WithPipeline(quCtx, pipeline), collection), opts.Language)
+	var structuredQuery StructuredQuery
+	switch {
+	case opts.Query != nil:
+		structuredQuery = StructuredQuery{Keywords: opts.Query.keywords(), Query: opts.Query}
+	case instant && pipeline == "":
+		structuredQuery.Keywords = instantKeywords(rawQuery)
+	case opts.KNN != nil && rawQuery == "":
+		// A vector alone has nothing to understand.
+	default:
+		structuredQuery, err = opts.understood.process(quCtx, b.queryUnderstanding, rawQuery)
+	}
+	debug.recordStage(StageQueryUnderstanding, quBudget, quStart, deadlineExceeded(quCtx))
+	structuredQuery.Collection = collection
+	structuredQuery.Tenant = opts.Tenant
+	structuredQuery.Sort = opts.Sort
+	structuredQuery.Filters = append(structuredQuery.Filters, opts.Filters...)
+	if opts.Geo != nil {
+		structuredQuery.Geo = opts.Geo
+	}
+	structuredQuery.Explain = opts.Explain
+	structuredQuery.Fuzziness = opts.Fuzziness
+	structuredQuery.PrefixLength = opts.PrefixLength
+	structuredQuery.Fields = opts.Fields
+	structuredQuery.Types = opts.Types
+	structuredQuery.KNN = opts.KNN
+	structuredQuery.Size = opts.shardSize()
+	opts.relax(&structuredQuery)
+	if instant {
+		structuredQuery.Prefix = prefixSearch(rawQuery)
+		structuredQuery.PrefixFields = b.instant.PrefixFields
+	}
+	reranker := b.reranker
+	if opts.reranker != nil {
+		reranker = opts.reranker // Set by an experiment bucket
+	}
+	if reranker != nil {
+		structuredQuery.RankingFields = reranker.Fields()
+	}
+	if opts.UserID != "" {
+		structuredQuery.RankingFields = appendMissing(structuredQuery.RankingFields, b.personalizer.Fields()...)
+	}
+	if opts.Collapse != nil {
+		structuredQuery.RankingFields = appendMissing(structuredQuery.RankingFields, opts.Collapse.Fields()...)
+	}
+	if b.nearDuplicateDistance >= 0 {
+		structuredQuery.RankingFields = appendMissing(structuredQuery.RankingFields, simhash.Field)
+	}
+	if len(b.typeBoosts) > 0 {
+		structuredQuery.RankingFields = appendMissing(structuredQuery.RankingFields, TypeField)
+	}
+	structuredQuery.RankingFields = appendMissing(structuredQuery.RankingFields, b.joinKeyFields()...)
+	if err != nil {
+		quSpan.RecordError(err)
+		quSpan.SetStatus(codes.Error, err.Error())
+		quSpan.End()
+		span.SetStatus(codes.Error, "query understanding failed")
+		if canceled(ctx) {
+			err = cancelSearch(StageQueryUnderstanding)
+		} else if deadlineExceeded(quCtx) {
+			err = fmt.Errorf("%w: query understanding did not answer within %s: %v", ErrBudgetExceeded, quBudget, err)
+		}
+		return nil, structuredQuery, err
+	}
+	quSpan.SetAttributes(
+		attribute.StringSlice("query.keywords", structuredQuery.Keywords),
+		attribute.String("query.language", structuredQuery.Language),
+		attribute.String("query.intent", structuredQuery.Intent),
+	)
+	quSpan.End()
+	if knn := structuredQuery.KNN; knn != nil && len(knn.Vector) == 0 {
+		if len(structuredQuery.Embedding) == 0 {
+			span.SetStatus(codes.Error, "no query embedding")
+			return nil, structuredQuery, fmt.Errorf("%w: the kNN search of field %s needs the query embedded by its query understanding pipeline", ErrNoQueryEmbedding, knn.Field)
+		}
+		embedded := *knn
+		embedded.Vector = structuredQuery.Embedding
+		structuredQuery.KNN = &embedded
+	}
+	if opts.vectorOnly {
+		structuredQuery.Keywords, structuredQuery.Query = nil, nil
+	}
+	queryThenFetch := b.queryThenFetch(opts, pool)
+	structuredQuery.IDsOnly = queryThenFetch
 
 	// 2. Fan out queries to multiple Searcher instances concurrently.
 	var (
-		mu         sync.Mutex // Mutex to protect allResults during concurrent writes
-		allResults []SearchResult
-		wg         sync.WaitGroup // WaitGroup to wait for all searchers to complete
+		mu            sync.Mutex // Mutex to protect resultLists and shardStatuses during concurrent writes
+		resultLists   [][]SearchResult
+		resultsMerged int
+		wg            sync.WaitGroup // WaitGroup to wait for all searchers to complete
 	)
 
 	// Determine target shards based on the structured query.
 	// For simplicity, we'll hash the first keyword to a shard ID.
 	// In a real system, this would be more complex, involving query planning
 	// from the Query Understanding Service, or a more sophisticated routing table.
+	// Nearest neighbors may be in any shard, so kNN searches query them all.
 	var targetShardIDs []int
-	if len(structuredQuery.Keywords) > 0 {
+	if len(structuredQuery.Keywords) > 0 && structuredQuery.KNN == nil {
 		// Get all available shard IDs from the map keys
 		var availableShardIDs []int
-		for shardID := range b.searchersByShard {
+		for shardID := range pool {
 			availableShardIDs = append(availableShardIDs, shardID)
 		}
 
@@ -103,79 +519,309 @@ func (b *Broker) Search(ctx context.Context, rawQuery RawQuery) ([]SearchResult,
 			}
 			targetShardIDs = append(targetShardIDs, availableShardIDs[hash%len(availableShardIDs)])
 		} else {
-			log.Println("No searchers configured for any shard.")
-			return nil, fmt.Errorf("no searchers available")
+			slog.WarnContext(ctx, "No searchers configured for any shard")
+			return nil, structuredQuery, fmt.Errorf("no searchers available")
 		}
 	} else {
 		// If no keywords, query all shards or a default shard.
 		// For now, let's query all shards if no specific keyword for sharding.
-		for shardID := range b.searchersByShard {
+		for shardID := range pool {
 			targetShardIDs = append(targetShardIDs, shardID)
 		}
 	}
 
-	// Channel to collect errors from searchers, sized to the number of *potential* searchers
-	totalTargetSearchers := 0
+	// Shards whose routing summary rules out the filters on the routing key are skipped.
+	targetShardIDs, skippedShards := b.pruneShards(poolKey(opts.Tenant, collection), targetShardIDs, structuredQuery.Filters)
+
+	// Track the outcome of every searcher call, grouped by shard.
+	shardStatuses := make(map[int]*ShardStatus, len(targetShardIDs))
 	for _, shardID := range targetShardIDs {
-		totalTargetSearchers += len(b.searchersByShard[shardID])
+		shardStatuses[shardID] = &ShardStatus{ShardID: shardID}
 	}
-	errChan := make(chan error, totalTargetSearchers)
 
+	// Query one replica per shard, failing over to the next replica on error. Shards get
+	// the rest of the budget; those still searching at the deadline count as failed.
+	var fanOutBudget time.Duration
+	if !deadline.IsZero() {
+		fanOutBudget = time.Until(deadline)
+	}
+	fanOutStart := time.Now()
 	for _, shardID := range targetShardIDs {
-		if searchersInShard, ok := b.searchersByShard[shardID]; ok {
-			for _, searcher := range searchersInShard {
-				wg.Add(1)
-				go func(s Searcher) {
-					defer wg.Done()
-					results, searchErr := s.Search(ctx, structuredQuery)
-					if searchErr != nil {
-						errChan <- searchErr
-						return
-					}
-
-					mu.Lock()
-					allResults = append(allResults, results...)
-					mu.Unlock()
-				}(searcher)
-			}
+		replicas, ok := pool[shardID]
+		if !ok || len(replicas) == 0 {
+			continue
 		}
+		wg.Add(1)
+		go func(shardID int, replicas []Searcher) {
+			defer wg.Done()
+			results, segment, ok := opts.fanOut.searchShard(ctx, b, ShardKey{Collection: poolKey(opts.Tenant, collection), ShardID: shardID}, replicas, structuredQuery, func(searchErr error, took time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				status := shardStatuses[shardID]
+				if !errors.Is(searchErr, ErrCircuitOpen) {
+					status.Searchers++
+				}
+				if tookMs := took.Milliseconds(); tookMs > status.TookMs {
+					status.TookMs = tookMs
+				}
+				if searchErr != nil {
+					status.Failed++
+					status.Errors = append(status.Errors, searchErr.Error())
+				}
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			status := shardStatuses[shardID]
+			if opts.OnShard != nil {
+				opts.OnShard(shardUpdate(status, results, ok))
+			}
+			if !ok {
+				return
+			}
+			if queryThenFetch {
+				results = fromShard(results, shardID)
+			}
+			if b.globalStats != nil && len(opts.Sort) == 0 && structuredQuery.KNN == nil {
+				results = b.globalStats.rescore(ShardKey{Collection: poolKey(opts.Tenant, collection), ShardID: shardID}, structuredQuery.Keywords, results)
+			}
+			status.Successful++
+			status.Segment = segment
+			b.servedShardRouting(ShardKey{Collection: poolKey(opts.Tenant, collection), ShardID: shardID}, segment)
+			status.Hits += len(results)
+			resultLists = append(resultLists, results)
+			resultsMerged += len(results)
+		}(shardID, replicas)
 	}
 
 	// Wait for all searcher goroutines to finish.
 	wg.Wait()
-	close(errChan) // Close the error channel once all goroutines are done sending.
+	debug.recordStage(StageFanOut, fanOutBudget, fanOutStart, deadlineExceeded(ctx))
+	if canceled(ctx) {
+		// Nobody is waiting for the results: the searchers were canceled with the client.
+		span.SetStatus(codes.Error, "search canceled")
+		return nil, structuredQuery, cancelSearch(StageFanOut)
+	}
 
-	// Collect all errors reported by searchers.
-	var searcherErrors []error
-	for err := range errChan {
-		if err != nil {
-			searcherErrors = append(searcherErrors, err)
-		}
+	// 3. Merge and de-duplicate results from Searchers, by ID and then by fingerprint.
+	mergeStart := time.Now()
+	_, mergeSpan := tracer.Start(ctx, "broker.merge")
+	// Every searcher returns its results already ordered, so a k-way merge produces
+	// the global order; duplicates keep their best-ranked occurrence.
+	deduplicatedResults := mergeSorted(resultLists, opts.Sort)
+	if structuredQuery.KNN != nil {
+		deduplicatedResults = nearestNeighbors(deduplicatedResults, structuredQuery.KNN.K, len(opts.Sort) == 0)
+	}
+
+	// In a more advanced system, this step would also involve:
+	// - Re-ranking results based on a global scoring model, freshness, personalization, etc.
+	// - Aggregation of facets or other metadata.
+	mergeSpan.SetAttributes(
+		attribute.Int("merge.input", resultsMerged),
+		attribute.Int("merge.output", len(deduplicatedResults)),
+	)
+	mergeSpan.End()
+	debug.recordStage(StageMerge, 0, mergeStart, false)
+	if b.nearDuplicateDistance >= 0 {
+		nearDuplicatesStart := time.Now()
+		deduplicatedResults = dropNearDuplicates(deduplicatedResults, b.nearDuplicateDistance)
+		debug.recordStage(StageNearDuplicates, 0, nearDuplicatesStart, false)
+	}
+
+	// 4. Boost results by type and personalize them for the user, then apply the business
+	// ranking rules.
+	if len(b.typeBoosts) > 0 {
+		typeBoostStart := time.Now()
+		deduplicatedResults = boostTypes(deduplicatedResults, b.typeBoosts, len(opts.Sort) == 0)
+		debug.recordStage(StageTypeBoost, 0, typeBoostStart, false)
+	}
+	if opts.UserID != "" {
+		personalizeStart := time.Now()
+		deduplicatedResults = b.personalize(ctx, opts.UserID, deduplicatedResults, len(opts.Sort) == 0)
+		debug.recordStage(StagePersonalize, 0, personalizeStart, false)
+	}
+	if reranker != nil {
+		rerankStart := time.Now()
+		deduplicatedResults = reranker.Rerank(string(rawQuery), deduplicatedResults, len(opts.Sort) == 0)
+		debug.recordStage(StageRerank, 0, rerankStart, false)
 	}
 
-	if len(searcherErrors) > 0 {
-		// Log all collected non-nil errors.
-		for _, err := range searcherErrors {
-			log.Printf("Warning: a searcher returned an error: %v", err)
+	// 5. Collapse the results by field, keeping the best of every group.
+	totalHits := len(deduplicatedResults)
+	var (
+		groupCounts map[string]int
+		totalGroups int
+	)
+	if opts.Collapse != nil {
+		collapseStart := time.Now()
+		deduplicatedResults, groupCounts, totalGroups = opts.Collapse.collapse(deduplicatedResults)
+		debug.recordStage(StageCollapse, 0, collapseStart, false)
+	}
+
+	// 6. Fetch the stored fields of the returned page alone, for query-then-fetch searches.
+	page, pagination := paginate(deduplicatedResults, opts.From, opts.Size)
+	if queryThenFetch && len(page) > 0 {
+		fetchStart := time.Now()
+		b.fetch(ctx, poolKey(opts.Tenant, collection), pool, structuredQuery, page, shardStatuses)
+		debug.recordStage(StageFetch, 0, fetchStart, deadlineExceeded(ctx))
+		if canceled(ctx) {
+			span.SetStatus(codes.Error, "search canceled")
+			return nil, structuredQuery, cancelSearch(StageFetch)
 		}
 	}
+	if len(b.joins) > 0 && len(page) > 0 {
+		enrichStart := time.Now()
+		b.enrich(ctx, page)
+		debug.recordStage(StageEnrich, 0, enrichStart, deadlineExceeded(ctx))
+	}
+
+	resp := &SearchResponse{
+		Version:     ResponseVersion,
+		Tenant:      opts.Tenant,
+		Collection:  collection,
+		TotalHits:   len(deduplicatedResults),
+		Shards:      summarizeShards(targetShardIDs, shardStatuses),
+		Experiments: opts.experiments,
+	}
+	resp.Results, resp.Pagination = page, pagination
+	resp.Shards.Skipped = skippedShards
+	if opts.Collapse != nil {
+		resp.Collapse = opts.Collapse.summarize(resp.Results, groupCounts, totalHits, totalGroups)
+	}
+	resp.TookMs = time.Since(start).Milliseconds()
+	resp.stages = debug.Stages
+	if opts.Explain {
+		debug.Explanations = explainResults(resp.Results)
+	}
+	if opts.Debug || opts.Explain {
+		resp.Debug = debug
+	}
+	return resp, structuredQuery, nil
+}
 
-	// 3. Merge and de-duplicate results from Searchers.
-	// Initialize a map to keep track of seen result IDs for deduplication.
-	seenIDs := make(map[string]struct{})
-	deduplicatedResults := []SearchResult{}
+// replicaAnswer is the answer of a replica to a shard search.
+type replicaAnswer struct {
+	replica int
+	hedge   bool // The search was sent to the replica because the first one was slow
+	results []SearchResult
+	segment string // Segment the replica searched, empty if unknown
+	err     error
+	took    time.Duration
+}
 
-	for _, result := range allResults {
-		if _, seen := seenIDs[result.ID]; !seen {
-			seenIDs[result.ID] = struct{}{}
-			deduplicatedResults = append(deduplicatedResults, result)
+// searchShard queries the replicas of a shard in the order chosen by the replica selector
+// until one succeeds, skipping replicas whose circuit breaker is open. With hedging, a
+// search the first replica hasn't answered within the shard's hedge delay is sent to the
+// next replica too, and the first successful answer is used, the other search being
+// canceled. record is called after every answered attempt. It returns the results, the
+// segment the replica that answered searched, empty if unknown, and false if every replica
+// failed or was skipped.
+func (b *Broker) searchShard(ctx context.Context, shard ShardKey, replicas []Searcher, query StructuredQuery, record func(err error, took time.Duration)) ([]SearchResult, string, bool) {
+	if err := ctx.Err(); err != nil {
+		record(fmt.Errorf("shard %d: %w", shard.ShardID, err), 0)
+		return nil, "", false
+	}
+	// Attempts share a context canceled once the shard is answered, stopping the search
+	// that lost a hedge.
+	attemptCtx, cancelAttempts := context.WithCancel(ctx)
+	defer cancelAttempts()
+	answers := make(chan replicaAnswer, len(replicas))
+	order := b.replicas.Order(shard, len(replicas))
+	attempts, inFlight := 0, 0
+	// start sends the search to the next replica whose breaker lets it through, returning
+	// false if there is none left.
+	start := func(hedge bool) bool {
+		for len(order) > 0 {
+			replica := order[0]
+			order = order[1:]
+			if !b.breakers.Allow(replicaKey{shard, replica}) {
+				continue // Tripped searchers are skipped until their breaker lets a probe through
+			}
+			go b.searchReplica(attemptCtx, shard, replica, replicas[replica], query, attempts, hedge, answers)
+			attempts++
+			inFlight++
+			return true
 		}
+		return false
+	}
+	if !start(false) {
+		record(fmt.Errorf("shard %d: %w", shard.ShardID, ErrCircuitOpen), 0)
+		return nil, "", false
 	}
 
-	// In a more advanced system, this step would also involve:
-	// - Re-ranking results based on a global scoring model, freshness, personalization, etc.
-	// - Pagination or result limiting.
-	// - Aggregation of facets or other metadata.
+	var hedgeTimer <-chan time.Time
+	if delay, ok := b.hedging.delay(shard); ok && len(order) > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedgeTimer = timer.C
+	}
+	for inFlight > 0 {
+		select {
+		case <-hedgeTimer:
+			hedgeTimer = nil // A search is hedged once
+			if start(true) {
+				hedgedSearchesTotal.Inc()
+				slog.DebugContext(ctx, "Hedging slow shard search", "collection", shard.Collection, "shard", shard.ShardID)
+			}
+		case a := <-answers:
+			inFlight--
+			if a.err != nil && ctx.Err() != nil {
+				// The client canceled the search or its own deadline passed: the replica
+				// is not to blame, so neither its breaker nor its stats record it, and
+				// failing over would be wasted work.
+				record(a.err, a.took)
+				return nil, "", false
+			}
+			b.replicas.Observe(shard, a.replica, a.took, a.err)
+			b.breakers.Record(replicaKey{shard, a.replica}, a.took, a.err)
+			record(a.err, a.took)
+			if a.err == nil {
+				b.hedging.observe(shard, a.took)
+				if a.hedge {
+					hedgeWinsTotal.Inc()
+				}
+				return a.results, a.segment, true
+			}
+			slog.WarnContext(ctx, "Replica failed, failing over", "collection", shard.Collection, "shard", shard.ShardID, "replica", a.replica, "error", a.err)
+			// A hedged search still in flight may yet answer; otherwise the next replica is tried.
+			if inFlight == 0 && ctx.Err() == nil {
+				start(false)
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// searchReplica runs a shard search on one replica and sends its answer to answers.
+func (b *Broker) searchReplica(ctx context.Context, shard ShardKey, replica int, searcher Searcher, query StructuredQuery, attempt int, hedge bool, answers chan<- replicaAnswer) {
+	ctx, span := tracer.Start(ctx, "searcher.Search", traceShardAttributes(shard.ShardID),
+		trace.WithAttributes(attribute.Int("search.replica", replica), attribute.Int("search.attempt", attempt), attribute.Bool("search.hedge", hedge)))
+	defer span.End()
+	start := time.Now()
+	var (
+		results []SearchResult
+		segment string
+		err     error
+	)
+	if versioned, ok := searcher.(SegmentSearcher); ok {
+		results, segment, err = versioned.SearchSegment(ctx, query)
+	} else {
+		results, err = searcher.Search(ctx, query)
+	}
+	took := time.Since(start)
+	switch {
+	case err != nil && canceled(ctx):
+		span.SetStatus(codes.Error, "search canceled")
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	default:
+		span.SetAttributes(attribute.Int("search.hits", len(results)))
+		b.segments.served(replicaKey{shard, replica}, searcher, segment)
+	}
+	answers <- replicaAnswer{replica: replica, hedge: hedge, results: results, segment: segment, err: err, took: took}
+}
 
-	return deduplicatedResults, nil
+// traceShardAttributes returns the span attributes identifying a shard.
+func traceShardAttributes(shardID int) trace.SpanStartOption {
+	return trace.WithAttributes(attribute.Int("search.shard_id", shardID))
 }
@@ -22,6 +22,7 @@ func (m *MockQueryUnderstandingService) Process(ctx context.Context, rawQuery Ra
 type MockSearcher struct {
 	ShardID    int
 	SearchFunc func(ctx context.Context, query StructuredQuery) ([]SearchResult, error)
+	Segment    string // Reported with the results of every search
 }
 
 func (m *MockSearcher) Search(ctx context.Context, query StructuredQuery) ([]SearchResult, error) {
@@ -31,6 +32,11 @@ func (m *MockSearcher) Search(ctx context.Context, query StructuredQuery) ([]Sea
 	return []SearchResult{}, nil
 }
 
+func (m *MockSearcher) SearchSegment(ctx context.Context, query StructuredQuery) ([]SearchResult, string, error) {
+	results, err := m.Search(ctx, query)
+	return results, m.Segment, err
+}
+
 func (m *MockSearcher) GetShardID() int {
 	return m.ShardID
 }
@@ -211,7 +217,8 @@ func TestBroker_Search_Deduplication(t *testing.T) {
 
 	mockQU := &MockQueryUnderstandingService{
 		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
-			return StructuredQuery{Keywords: []string{"dedup"}}, nil
+			// No keywords, so every shard is queried.
+			return StructuredQuery{}, nil
 		},
 	}
 
@@ -229,7 +236,7 @@ func TestBroker_Search_Deduplication(t *testing.T) {
 
 	// Searcher 2 provides results including one that duplicates docB
 	mockSearcher2 := &MockSearcher{
-		ShardID: 0, // Same shard to ensure both are hit
+		ShardID: 1, // Another shard holding an overlapping document
 		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
 			return []SearchResult{
 				{ID: "docB", Title: "Result B Duplicate", URL: "urlB_dup", Score: 0.85}, // Different score/URL for duplicate
@@ -374,3 +381,66 @@ func findSpace(s string) int {
 	}
 	return -1
 }
+
+// MockCollectionSearcher is a MockSearcher that serves a named collection.
+type MockCollectionSearcher struct {
+	MockSearcher
+	Collection string
+}
+
+func (m *MockCollectionSearcher) GetCollection() string {
+	return m.Collection
+}
+
+func TestBroker_Search_RoutesByCollection(t *testing.T) {
+	ctx := context.Background()
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: []string{"shoes"}}, nil
+		},
+	}
+	defaultSearcher := &MockSearcher{
+		ShardID: 0,
+		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			return []SearchResult{{ID: "default_doc"}}, nil
+		},
+	}
+	productsSearcher := &MockCollectionSearcher{
+		Collection: "products",
+		MockSearcher: MockSearcher{
+			ShardID: 0,
+			SearchFunc: func(_ context.Context, sq StructuredQuery) ([]SearchResult, error) {
+				if sq.Collection != "products" {
+					t.Errorf("Expected structured query for collection 'products', got %q", sq.Collection)
+				}
+				return []SearchResult{{ID: "product_doc"}}, nil
+			},
+		},
+	}
+	broker := NewBroker(mockQU, []Searcher{defaultSearcher, productsSearcher})
+
+	if got := broker.Collections(); len(got) != 2 || got[0] != DefaultCollection || got[1] != "products" {
+		t.Errorf("Unexpected collections: %v", got)
+	}
+
+	resp, err := broker.SearchWithOptions(ctx, "shoes", SearchOptions{Collection: "products"})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].ID != "product_doc" || resp.Collection != "products" {
+		t.Errorf("Expected only the products collection to be searched, got %+v", resp)
+	}
+
+	results, err := broker.Search(ctx, "shoes")
+	if err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != "default_doc" {
+		t.Errorf("Expected only the default collection to be searched, got %+v", results)
+	}
+
+	_, err = broker.SearchWithOptions(ctx, "shoes", SearchOptions{Collection: "missing"})
+	if !errors.Is(err, ErrUnknownCollection) {
+		t.Errorf("Expected ErrUnknownCollection, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Search stages reported in the debug section of a SearchResponse.
+const (
+	StageQueryUnderstanding = "query_understanding"
+	StageFanOut             = "fanout"
+	StageMerge              = "merge"
+	StageRerank             = "rerank" // Only reported when ranking rules are set
+)
+
+// ErrBudgetExceeded is returned when a search runs out of its latency budget before it
+// could produce any results.
+var ErrBudgetExceeded = errors.New("latency budget exceeded")
+
+// TimeoutBudget splits the latency budget of a search across its stages. Query
+// understanding gets a fixed share of the total; the shard fan-out gets whatever is left
+// until the overall deadline. Shards that miss the deadline are reported as failed.
+type TimeoutBudget struct {
+	Total      time.Duration // Latency budget of a whole search; 0 disables deadlines
+	QUFraction float64       // Share of Total granted to query understanding, between 0 and 1
+}
+
+// DefaultTimeoutBudget returns a budget without deadlines that would grant a quarter of
+// the total to query understanding once a total is set.
+func DefaultTimeoutBudget() TimeoutBudget {
+	return TimeoutBudget{QUFraction: 0.25}
+}
+
+// Validate checks the budget's values.
+func (tb TimeoutBudget) Validate() error {
+	if tb.Total < 0 {
+		return fmt.Errorf("invalid latency budget %s, must not be negative", tb.Total)
+	}
+	if tb.QUFraction <= 0 || tb.QUFraction >= 1 {
+		return fmt.Errorf("invalid query understanding budget share %g, must be between 0 and 1", tb.QUFraction)
+	}
+	return nil
+}
+
+// quBudget returns the time granted to query understanding, or 0 for no deadline.
+func (tb TimeoutBudget) quBudget() time.Duration {
+	return time.Duration(float64(tb.Total) * tb.QUFraction)
+}
+
+// SetTimeoutBudget sets the latency budget applied to searches that don't set
+// SearchOptions.Timeout. The budget must be valid.
+func (b *Broker) SetTimeoutBudget(budget TimeoutBudget) {
+	b.budget = budget
+}
+
+// StageTiming reports the time a search spent in one of its stages.
+type StageTiming struct {
+	Stage    string `json:"stage"`
+	BudgetMs int64  `json:"budget_ms,omitempty"` // Time granted to the stage; omitted without a budget
+	TookMs   int64  `json:"took_ms"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+}
+
+// SearchDebug is the debug section of a SearchResponse, included on request.
+type SearchDebug struct {
+	BudgetMs int64         `json:"budget_ms,omitempty"` // Total latency budget of the search
+	Stages   []StageTiming `json:"stages"`
+	// Explanations holds the scoring breakdown of each returned result, in result order,
+	// when the search asked for explanations.
+	Explanations []HitExplanation `json:"explanations,omitempty"`
+}
+
+// HitExplanation is the scoring breakdown of a single result as reported by its searcher.
+type HitExplanation struct {
+	ID          string          `json:"id"`
+	Explanation json.RawMessage `json:"explanation,omitempty"`
+	Rules       []string        `json:"rules,omitempty"` // Ranking rules that moved or rescored the result
+}
+
+// explainResults collects the explanations of results.
+func explainResults(results []SearchResult) []HitExplanation {
+	explanations := make([]HitExplanation, 0, len(results))
+	for _, r := range results {
+		explanations = append(explanations, HitExplanation{ID: r.ID, Explanation: r.Explanation, Rules: r.Rules})
+	}
+	return explanations
+}
+
+// recordStage appends the timing of a stage that started at start.
+func (d *SearchDebug) recordStage(stage string, budget time.Duration, start time.Time, timedOut bool) {
+	d.Stages = append(d.Stages, StageTiming{
+		Stage:    stage,
+		BudgetMs: budget.Milliseconds(),
+		TookMs:   time.Since(start).Milliseconds(),
+		TimedOut: timedOut,
+	})
+}
+
+// deadlineExceeded reports whether ctx expired because of its deadline.
+func deadlineExceeded(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
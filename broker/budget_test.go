@@ -0,0 +1,180 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBroker_Search_TimeoutBudget(t *testing.T) {
+	var quDeadline time.Duration
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(ctx context.Context, _ RawQuery) (StructuredQuery, error) {
+			if deadline, ok := ctx.Deadline(); ok {
+				quDeadline = time.Until(deadline)
+			}
+			return StructuredQuery{}, nil // No keywords, so every shard is queried
+		},
+	}
+	fast := &MockSearcher{
+		ShardID: 0,
+		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			return []SearchResult{{ID: "a", Score: 1}}, nil
+		},
+	}
+	slow := &MockSearcher{
+		ShardID: 1,
+		SearchFunc: func(ctx context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	b := NewBroker(mockQU, []Searcher{fast, slow})
+	b.SetTimeoutBudget(TimeoutBudget{Total: time.Second, QUFraction: 0.2})
+
+	start := time.Now()
+	resp, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{Timeout: 100 * time.Millisecond, Debug: true})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the search to stop at its 100ms budget, took %s", elapsed)
+	}
+	// The per-request timeout overrides the broker's budget; QU gets 20% of it.
+	if quDeadline <= 0 || quDeadline > 20*time.Millisecond {
+		t.Errorf("Expected query understanding to get a deadline of at most 20ms, got %s", quDeadline)
+	}
+	if len(resp.Results) != 1 || resp.Shards.Successful != 1 || resp.Shards.Failed != 1 {
+		t.Errorf("Expected partial results from the fast shard, got %+v / %+v", resp.Results, resp.Shards)
+	}
+
+	if resp.Debug == nil || resp.Debug.BudgetMs != 100 || len(resp.Debug.Stages) != 3 {
+		t.Fatalf("Unexpected debug section: %+v", resp.Debug)
+	}
+	qu, fanOut := resp.Debug.Stages[0], resp.Debug.Stages[1]
+	if qu.Stage != StageQueryUnderstanding || qu.BudgetMs != 20 || qu.TimedOut {
+		t.Errorf("Unexpected query understanding timing: %+v", qu)
+	}
+	if fanOut.Stage != StageFanOut || !fanOut.TimedOut || fanOut.BudgetMs > 100 {
+		t.Errorf("Unexpected fan-out timing: %+v", fanOut)
+	}
+	if resp.Debug.Stages[2].Stage != StageMerge {
+		t.Errorf("Expected the merge stage last, got %+v", resp.Debug.Stages[2])
+	}
+
+	// Without Debug the section is omitted.
+	resp, err = b.SearchWithOptions(context.Background(), "q", SearchOptions{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if resp.Debug != nil {
+		t.Errorf("Expected no debug section, got %+v", resp.Debug)
+	}
+}
+
+func TestBroker_Search_QueryUnderstandingBudgetExceeded(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(ctx context.Context, _ RawQuery) (StructuredQuery, error) {
+			<-ctx.Done()
+			return StructuredQuery{}, ctx.Err()
+		},
+	}
+	b := NewBroker(mockQU, []Searcher{&MockSearcher{ShardID: 0}})
+	b.SetTimeoutBudget(TimeoutBudget{Total: 40 * time.Millisecond, QUFraction: 0.25})
+
+	_, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{})
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Expected ErrBudgetExceeded, got %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	NewHandler(b).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=test", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status 504, got %d", rec.Code)
+	}
+}
+
+func TestHandler_Search_TimeoutAndDebugParams(t *testing.T) {
+	h := NewHandler(newTestBroker())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=test&timeout=500ms&debug=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Debug == nil || resp.Debug.BudgetMs != 500 || len(resp.Debug.Stages) != 3 {
+		t.Errorf("Unexpected debug section: %+v", resp.Debug)
+	}
+
+	for _, query := range []string{"timeout=soon", "timeout=-1s", "debug=maybe"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=test&"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestBroker_Search_Explain(t *testing.T) {
+	var explained bool
+	searcher := &MockSearcher{
+		ShardID: 0,
+		SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+			explained = query.Explain
+			return []SearchResult{
+				{ID: "a", Score: 2, Explanation: json.RawMessage(`{"value":2}`)},
+				{ID: "b", Score: 1, Explanation: json.RawMessage(`{"value":1}`)},
+			}, nil
+		},
+	}
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{searcher}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=test&explain=true&size=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !explained {
+		t.Error("Expected the searcher to be asked for explanations")
+	}
+	var resp SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	// Only the returned page is explained.
+	if resp.Debug == nil || len(resp.Debug.Explanations) != 1 {
+		t.Fatalf("Expected one explanation in the debug section, got %+v", resp.Debug)
+	}
+	if got := resp.Debug.Explanations[0]; got.ID != "a" || string(got.Explanation) != `{"value":2}` {
+		t.Errorf("Unexpected explanation: %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=test&explain=maybe", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid explain parameter, got %d", rec.Code)
+	}
+}
+
+func TestTimeoutBudget_Validate(t *testing.T) {
+	if err := DefaultTimeoutBudget().Validate(); err != nil {
+		t.Errorf("Expected the default budget to be valid, got %v", err)
+	}
+	for _, budget := range []TimeoutBudget{
+		{Total: -time.Second, QUFraction: 0.25},
+		{Total: time.Second, QUFraction: 0},
+		{Total: time.Second, QUFraction: 1},
+	} {
+		if err := budget.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", budget)
+		}
+	}
+}
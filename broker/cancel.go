@@ -0,0 +1,35 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// StatusClientClosedRequest is the status recorded for searches abandoned because their
+// client disconnected. The client never sees it.
+const StatusClientClosedRequest = 499
+
+// ErrCanceled is returned by searches whose context was canceled, as when their client
+// disconnects; the query understanding and searcher requests still in flight are canceled
+// with it.
+var ErrCanceled = errors.New("search canceled")
+
+var cancelledSearchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "broker_cancelled_searches_total",
+	Help: "Searches abandoned because their client disconnected, by the stage they were in",
+}, []string{"stage"})
+
+// canceled reports whether ctx was canceled rather than past its deadline.
+func canceled(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
+
+// cancelSearch counts a search abandoned during stage and returns its error.
+func cancelSearch(stage string) error {
+	cancelledSearchesTotal.WithLabelValues(stage).Inc()
+	return fmt.Errorf("%w during %s", ErrCanceled, stage)
+}
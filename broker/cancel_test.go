@@ -0,0 +1,89 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBroker_SearchCanceled(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{}, 2)
+	blocking := func(ctx context.Context, _ StructuredQuery) ([]SearchResult, error) {
+		calls.Add(1)
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	// Two replicas of one shard: the canceled search must not fail over to the second.
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{
+		&MockSearcher{ShardID: 0, SearchFunc: blocking},
+		&MockSearcher{ShardID: 0, SearchFunc: blocking},
+	})
+	before := testutil.ToFloat64(cancelledSearchesTotal.WithLabelValues(StageFanOut))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := b.SearchWithOptions(ctx, "", SearchOptions{}); !errors.Is(err, ErrCanceled) {
+		t.Fatalf("Expected ErrCanceled, got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Expected the canceled search not to fail over, got %d searcher calls", calls.Load())
+	}
+	for _, status := range b.BreakerStatuses() {
+		if status.Failures != 0 {
+			t.Errorf("Expected the cancellation not to count against replica %d, got %+v", status.Replica, status)
+		}
+	}
+	if got := testutil.ToFloat64(cancelledSearchesTotal.WithLabelValues(StageFanOut)) - before; got != 1 {
+		t.Errorf("Expected 1 search canceled during the fan-out, got %g", got)
+	}
+
+	// Searches past the client's own deadline don't count against the replicas either.
+	b = NewBroker(&MockQueryUnderstandingService{}, []Searcher{&MockSearcher{ShardID: 0, SearchFunc: blocking}})
+	b.SearchWithOptions(context.Background(), "", SearchOptions{Timeout: 20 * time.Millisecond})
+	for _, status := range b.BreakerStatuses() {
+		if status.Failures != 0 {
+			t.Errorf("Expected the client deadline not to count against replica %d, got %+v", status.Replica, status)
+		}
+	}
+
+	// A search canceled during query understanding never reaches the searchers.
+	qu := &MockQueryUnderstandingService{ProcessFunc: func(ctx context.Context, _ RawQuery) (StructuredQuery, error) {
+		<-ctx.Done()
+		return StructuredQuery{}, ctx.Err()
+	}}
+	b = NewBroker(qu, []Searcher{&MockSearcher{ShardID: 0, SearchFunc: blocking}})
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	calls.Store(0)
+	if _, err := b.SearchWithOptions(ctx, "shoes", SearchOptions{}); !errors.Is(err, ErrCanceled) || calls.Load() != 0 {
+		t.Errorf("Expected ErrCanceled before the fan-out, got %v after %d searcher calls", err, calls.Load())
+	}
+}
+
+func TestHandler_SearchCanceled(t *testing.T) {
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{&MockSearcher{ShardID: 0}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	NewHandler(b).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoes", nil).WithContext(ctx))
+	if rec.Code != StatusClientClosedRequest {
+		t.Errorf("Expected status %d for a disconnected client, got %d", StatusClientClosedRequest, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	NewHandler(b).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected the metrics to be served, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,360 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/logging"
+	"common/tenant"
+	"common/tracing"
+	"common/vector"
+)
+
+const defaultClientTimeout = 5 * time.Second
+
+// clientTransport is the base transport of the clients created by this package; nil
+// means http.DefaultTransport.
+var clientTransport http.RoundTripper
+
+// SetClientTransport sets the base transport used to reach the query understanding service
+// and the searchers, e.g. one configured for mutual TLS. It only affects clients created
+// afterwards, so call it before creating them.
+func SetClientTransport(transport http.RoundTripper) {
+	clientTransport = transport
+}
+
+// newTracingHTTPClient returns an HTTP client whose requests carry the trace context and
+// request ID of the request's context, so spans started by downstream services join the
+// same trace and their logs can be found by the ID of the search.
+func newTracingHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout:   defaultClientTimeout,
+		Transport: tracing.Transport(logging.Transport(clientTransport)),
+	}
+}
+
+// HTTPQueryUnderstandingClient is a QueryUnderstandingService backed by the
+// query understanding service's HTTP API.
+type HTTPQueryUnderstandingClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPQueryUnderstandingClient creates a client for the query understanding service at baseURL.
+func NewHTTPQueryUnderstandingClient(baseURL string) *HTTPQueryUnderstandingClient {
+	return &HTTPQueryUnderstandingClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  newTracingHTTPClient(),
+	}
+}
+
+// collectionKey is the context key of the collection a processed query searches.
+type collectionKey struct{}
+
+// WithCollection returns a copy of ctx telling the query understanding service which
+// collection the query searches, so that it applies the collection's stopwords and
+// synonyms.
+func WithCollection(ctx context.Context, collection string) context.Context {
+	if collection == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, collectionKey{}, collection)
+}
+
+// CollectionFromContext returns the collection set by WithCollection, or "".
+// QueryUnderstandingService implementations pass it on to the service.
+func CollectionFromContext(ctx context.Context) string {
+	collection, _ := ctx.Value(collectionKey{}).(string)
+	return collection
+}
+
+// languageKey is the context key of the language of a processed query.
+type languageKey struct{}
+
+// WithLanguage returns a copy of ctx telling the query understanding service the language
+// of the query, e.g. "fr", instead of letting it detect it.
+func WithLanguage(ctx context.Context, language string) context.Context {
+	if language == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, languageKey{}, language)
+}
+
+// LanguageFromContext returns the language set by WithLanguage, or "".
+func LanguageFromContext(ctx context.Context) string {
+	language, _ := ctx.Value(languageKey{}).(string)
+	return language
+}
+
+// processRequest is the body sent to the query understanding service's /process endpoint.
+type processRequest struct {
+	Query      string `json:"query"`
+	Pipeline   string `json:"pipeline,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	Language   string `json:"lang,omitempty"`
+}
+
+// processResponse is the body returned by the query understanding service's /process endpoint.
+type processResponse struct {
+	ProcessedQuery string     `json:"processed_query"`
+	Keywords       []string   `json:"keywords"`
+	Language       string     `json:"language"`
+	Intent         string     `json:"intent"`
+	Query          *QueryNode `json:"query"`
+	Embedding      []float32  `json:"embedding"`
+	// Ranges are the range filters parsed from expressions such as "under $50".
+	Ranges []Filter `json:"ranges"`
+}
+
+// Process sends the raw query to the query understanding service and converts
+// its response into a StructuredQuery.
+func (c *HTTPQueryUnderstandingClient) Process(ctx context.Context, rawQuery RawQuery) (StructuredQuery, error) {
+	body, err := json.Marshal(processRequest{Query: string(rawQuery), Pipeline: PipelineFromContext(ctx), Collection: CollectionFromContext(ctx), Language: LanguageFromContext(ctx)})
+	if err != nil {
+		return StructuredQuery{}, fmt.Errorf("failed to encode process request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/process", bytes.NewReader(body))
+	if err != nil {
+		return StructuredQuery{}, fmt.Errorf("failed to create process request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var resp processResponse
+	if err := doJSON(c.client, req, &resp); err != nil {
+		return StructuredQuery{}, fmt.Errorf("query understanding request failed: %w", err)
+	}
+	return StructuredQuery{Keywords: resp.Keywords, Query: resp.Query, Language: resp.Language, Intent: resp.Intent, Embedding: resp.Embedding, Filters: resp.Ranges}, nil
+}
+
+// HTTPSearcher is a Searcher that queries a remote Searcher service over HTTP.
+type HTTPSearcher struct {
+	baseURL    string
+	tenant     string
+	collection string
+	shardID    int
+	client     *http.Client
+}
+
+// NewHTTPSearcher creates a Searcher for the searcher service at baseURL serving shardID
+// of the default collection.
+func NewHTTPSearcher(baseURL string, shardID int) *HTTPSearcher {
+	return NewCollectionHTTPSearcher(DefaultCollection, baseURL, shardID)
+}
+
+// NewCollectionHTTPSearcher creates a Searcher for the searcher service at baseURL
+// serving shardID of the given collection.
+func NewCollectionHTTPSearcher(collection, baseURL string, shardID int) *HTTPSearcher {
+	return &HTTPSearcher{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		collection: collection,
+		shardID:    shardID,
+		client:     newTracingHTTPClient(),
+	}
+}
+
+// searcherHit mirrors a single Bleve hit as returned by the searcher service.
+type searcherHit struct {
+	ID          string                 `json:"id"`
+	Score       float64                `json:"score"`
+	Fields      map[string]interface{} `json:"fields"`
+	SortValues  []interface{}          `json:"sort_values"`
+	DistanceKm  *float64               `json:"distance_km"`
+	Explanation json.RawMessage        `json:"explanation"`
+	SortKey     []string               `json:"sort_key"`
+}
+
+// searcherResponse is the body returned by the searcher service's /search endpoint.
+type searcherResponse struct {
+	Segment   string        `json:"segment"` // Latest segment loaded by the searcher
+	Results   []searcherHit `json:"results"`
+	TotalHits uint64        `json:"total_hits"`
+}
+
+// Search queries the remote searcher with the structured query's keywords.
+func (s *HTTPSearcher) Search(ctx context.Context, query StructuredQuery) ([]SearchResult, error) {
+	results, _, err := s.SearchSegment(ctx, query)
+	return results, err
+}
+
+// SearchSegment is Search, also returning the segment the searcher served the results from,
+// empty for searchers that don't report it.
+func (s *HTTPSearcher) SearchSegment(ctx context.Context, query StructuredQuery) ([]SearchResult, string, error) {
+	params := url.Values{}
+	params.Set("q", strings.Join(query.Keywords, " "))
+	params.Set("collection", s.collection)
+	s.setTenant(params)
+	if query.Query != nil {
+		tree, err := json.Marshal(query.Query)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode query tree: %w", err)
+		}
+		params.Set("query", string(tree))
+	}
+	if len(query.Sort) > 0 {
+		params.Set("sort", formatSortSpec(query.Sort))
+	}
+	if len(query.Filters) > 0 {
+		filters, err := json.Marshal(query.Filters)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode filters: %w", err)
+		}
+		params.Set("filters", string(filters))
+	}
+	if geo := query.Geo; geo != nil {
+		params.Set("lat", strconv.FormatFloat(geo.Lat, 'f', -1, 64))
+		params.Set("lon", strconv.FormatFloat(geo.Lon, 'f', -1, 64))
+		if geo.Radius != "" {
+			params.Set("radius", geo.Radius)
+		}
+		if geo.Field != "" {
+			params.Set("geo_field", geo.Field)
+		}
+	}
+
+	if query.Explain {
+		params.Set("explain", "true")
+	}
+	switch {
+	case query.IDsOnly && len(query.RankingFields) > 0:
+		params.Set("fields", strings.Join(query.RankingFields, ","))
+	case query.IDsOnly:
+		params.Set("fields", noFields)
+	case len(query.Fields) > 0 || len(query.RankingFields) > 0:
+		fields := appendMissing(append([]string(nil), resultFields...), query.Fields...)
+		fields = appendMissing(fields, query.RankingFields...)
+		params.Set("fields", strings.Join(fields, ","))
+	}
+	if len(query.Types) > 0 {
+		params.Set("types", strings.Join(query.Types, ","))
+	}
+	if query.Fuzziness > 0 {
+		params.Set("fuzziness", strconv.Itoa(query.Fuzziness))
+		params.Set("prefix_length", strconv.Itoa(query.PrefixLength))
+	}
+	if knn := query.KNN; knn != nil {
+		params.Set("knn_field", knn.Field)
+		params.Set("knn_vector", vector.Format(knn.Vector))
+		params.Set("k", strconv.Itoa(knn.K))
+		if knn.NumCandidates > 0 {
+			params.Set("num_candidates", strconv.Itoa(knn.NumCandidates))
+		}
+	}
+	if query.Size > 0 {
+		params.Set("size", strconv.Itoa(query.Size))
+	}
+	if len(query.SearchAfter) > 0 {
+		params.Set("search_after", strings.Join(query.SearchAfter, ","))
+	}
+	if query.Prefix {
+		params.Set("prefix", "true")
+		if len(query.PrefixFields) > 0 {
+			params.Set("prefix_fields", strings.Join(query.PrefixFields, ","))
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create search request: %w", err)
+	}
+
+	var resp searcherResponse
+	if err := doJSON(s.client, req, &resp); err != nil {
+		return nil, "", fmt.Errorf("searcher %s (shard %d) request failed: %w", s.baseURL, s.shardID, err)
+	}
+
+	results := make([]SearchResult, 0, len(resp.Results))
+	for _, hit := range resp.Results {
+		results = append(results, SearchResult{
+			ID:          hit.ID,
+			Title:       stringField(hit.Fields, "title"),
+			URL:         stringField(hit.Fields, "url"),
+			Score:       hit.Score,
+			SortValues:  hit.SortValues,
+			DistanceKm:  hit.DistanceKm,
+			Fields:      selectFields(hit.Fields, query.Fields),
+			Stored:      hit.Fields,
+			Explanation: hit.Explanation,
+			SortKey:     hit.SortKey,
+		})
+	}
+	return results, resp.Segment, nil
+}
+
+// GetShardID returns the shard served by this searcher.
+func (s *HTTPSearcher) GetShardID() int {
+	return s.shardID
+}
+
+// String identifies the searcher by its base URL and shard, e.g. in breaker statuses.
+func (s *HTTPSearcher) String() string {
+	return fmt.Sprintf("%s (shard %d)", s.baseURL, s.shardID)
+}
+
+// GetCollection returns the collection served by this searcher.
+func (s *HTTPSearcher) GetCollection() string {
+	return s.collection
+}
+
+// SetTenant makes the searcher serve the collection of the given tenant, whose ID is sent
+// with every request.
+func (s *HTTPSearcher) SetTenant(id string) error {
+	if err := tenant.Validate(id); err != nil {
+		return err
+	}
+	s.tenant = id
+	return nil
+}
+
+// GetTenant returns the tenant served by this searcher, empty for the default tenant.
+func (s *HTTPSearcher) GetTenant() string {
+	return s.tenant
+}
+
+// setTenant adds the searcher's tenant, if any, to the request parameters.
+func (s *HTTPSearcher) setTenant(params url.Values) {
+	if s.tenant != tenant.Default {
+		params.Set(tenant.Param, s.tenant)
+	}
+}
+
+// doJSON executes req and decodes a successful JSON response into out.
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// stringField returns fields[name] if it is a string, or "" otherwise.
+func stringField(fields map[string]interface{}, name string) string {
+	if v, ok := fields[name].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// Ensure the HTTP clients implement the broker interfaces.
+var (
+	_ QueryUnderstandingService = (*HTTPQueryUnderstandingClient)(nil)
+	_ CollectionSearcher        = (*HTTPSearcher)(nil)
+	_ TenantSearcher            = (*HTTPSearcher)(nil)
+)
@@ -0,0 +1,252 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"common/logging"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHTTPSearcher_Search(t *testing.T) {
+	var gotTraceParent, gotRequestID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceParent = r.Header.Get("traceparent")
+		gotRequestID = r.Header.Get(logging.RequestIDHeader)
+		if q := r.URL.Query().Get("q"); q != "red shoes" {
+			t.Errorf("Expected q='red shoes', got %q", q)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"segment":    "index_20240601T120000Z",
+			"total_hits": 1,
+			"results": []map[string]interface{}{
+				{"id": "doc1", "score": 1.5, "fields": map[string]interface{}{"title": "Red Shoes", "url": "http://shoes"}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	spanCtx := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(logging.WithRequestID(context.Background(), "req-1"), spanCtx)
+
+	searcher := NewHTTPSearcher(server.URL, 3)
+	results, segment, err := searcher.SearchSegment(ctx, StructuredQuery{Keywords: []string{"red", "shoes"}})
+	if err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+	if segment != "index_20240601T120000Z" {
+		t.Errorf("Expected the segment reported by the searcher, got %q", segment)
+	}
+	if len(results) != 1 || results[0].ID != "doc1" || results[0].Title != "Red Shoes" || results[0].Score != 1.5 {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+	if searcher.GetShardID() != 3 {
+		t.Errorf("Expected shard ID 3, got %d", searcher.GetShardID())
+	}
+	if gotTraceParent == "" {
+		t.Error("Expected traceparent header to be propagated to the searcher")
+	}
+	if gotRequestID != "req-1" {
+		t.Errorf("Expected request ID req-1 to be propagated to the searcher, got %q", gotRequestID)
+	}
+}
+
+func TestHTTPSearcher_Search_Explain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if explain := r.URL.Query().Get("explain"); explain != "true" {
+			t.Errorf("Expected explain=true, got %q", explain)
+		}
+		w.Write([]byte(`{"total_hits":1,"results":[{"id":"doc1","score":0.5,"explanation":{"value":0.5,"message":"weight(text:shoes)"}}]}`))
+	}))
+	defer server.Close()
+
+	results, err := NewHTTPSearcher(server.URL, 0).Search(context.Background(), StructuredQuery{Keywords: []string{"shoes"}, Explain: true})
+	if err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+	if len(results) != 1 || !strings.Contains(string(results[0].Explanation), "weight(text:shoes)") {
+		t.Errorf("Expected the hit's explanation to be passed through, got %+v", results)
+	}
+}
+
+func TestFuzziness_PassedThrough(t *testing.T) {
+	var got url.Values
+	searcher := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		w.Write([]byte(`{"total_hits":1,"results":[{"id":"doc1","score":0.5}]}`))
+	}))
+	defer searcher.Close()
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{NewHTTPSearcher(searcher.URL, 0)}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoos&fuzziness=2&prefix_length=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got.Get("fuzziness") != "2" || got.Get("prefix_length") != "1" {
+		t.Errorf("Expected fuzziness=2 and prefix_length=1 to reach the searcher, got %v", got)
+	}
+
+	// Exact matching sends no fuzziness.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search?q=shoes", nil))
+	if got.Has("fuzziness") || got.Has("prefix_length") {
+		t.Errorf("Expected no fuzziness parameters, got %v", got)
+	}
+
+	for _, query := range []string{"fuzziness=3", "fuzziness=-1", "fuzziness=auto", "prefix_length=-1"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoes&"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestFields_PassedThrough(t *testing.T) {
+	var got string
+	searcher := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("fields")
+		w.Write([]byte(`{"total_hits":1,"results":[{"id":"doc1","score":0.5,"fields":{"title":"Red shoes","url":"http://example.com/1","price":59.9,"sizes":[40,41]}}]}`))
+	}))
+	defer searcher.Close()
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{NewHTTPSearcher(searcher.URL, 0)}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoes&fields=price,+sizes", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Title and URL are always fetched for the result envelope.
+	if got != "title,url,price,sizes" {
+		t.Errorf("Expected the searcher to be asked for title,url,price,sizes, got %q", got)
+	}
+	var resp SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := map[string]interface{}{"price": 59.9, "sizes": []interface{}{40.0, 41.0}}
+	if len(resp.Results) != 1 || resp.Results[0].Title != "Red shoes" || !reflect.DeepEqual(resp.Results[0].Fields, want) {
+		t.Errorf("Expected the title and the selected typed fields, got %+v", resp.Results)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoes", nil))
+	if got != "" {
+		t.Errorf("Expected no fields parameter by default, got %q", got)
+	}
+	var plain SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&plain); err != nil || plain.Results[0].Fields != nil {
+		t.Errorf("Expected no fields by default, got %+v (%v)", plain.Results, err)
+	}
+}
+
+func TestHTTPSearcher_Search_RankingFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("fields"); got != "title,url,brand" {
+			t.Errorf("Expected fields=title,url,brand, got %q", got)
+		}
+		w.Write([]byte(`{"total_hits":1,"results":[{"id":"doc1","score":0.5,"fields":{"title":"Red shoes","brand":"acme"}}]}`))
+	}))
+	defer server.Close()
+
+	results, err := NewHTTPSearcher(server.URL, 0).Search(context.Background(), StructuredQuery{Keywords: []string{"shoes"}, RankingFields: []string{"brand"}})
+	if err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+	// Fields fetched for ranking rules aren't returned with the result.
+	if len(results) != 1 || results[0].Fields != nil || results[0].Stored["brand"] != "acme" {
+		t.Errorf("Expected brand to be stored but not selected, got %+v", results)
+	}
+}
+
+func TestQueryTree_PassedThrough(t *testing.T) {
+	tree := `{"type":"bool","must":[{"type":"phrase","field":"title","text":"red shoes"}],"must_not":[{"type":"term","text":"used"}]}`
+	qu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"processed_query":"red shoes","keywords":["red","shoes"],"query":` + tree + `}`))
+	}))
+	defer qu.Close()
+	searcher := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("query"); got != tree {
+			t.Errorf("Expected the query tree %s, got %s", tree, got)
+		}
+		w.Write([]byte(`{"total_hits":0,"results":[]}`))
+	}))
+	defer searcher.Close()
+
+	sq, err := NewHTTPQueryUnderstandingClient(qu.URL).Process(context.Background(), `+title:"red shoes" -used`)
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+	if sq.Query == nil || sq.Query.Type != NodeBool || len(sq.Query.Must) != 1 || sq.Query.Must[0].Field != "title" {
+		t.Fatalf("Unexpected query tree: %+v", sq.Query)
+	}
+	if _, err := NewHTTPSearcher(searcher.URL, 0).Search(context.Background(), sq); err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+}
+
+func TestHTTPSearcher_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPSearcher(server.URL, 0).Search(context.Background(), StructuredQuery{Keywords: []string{"x"}})
+	if err == nil {
+		t.Fatal("Expected an error for a non-200 response, got nil")
+	}
+}
+
+func TestHTTPQueryUnderstandingClient_Process(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/process" || r.Method != http.MethodPost {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var req processRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Query != "The PC" {
+			t.Errorf("Expected query 'The PC', got %q", req.Query)
+		}
+		if req.Collection != "products" {
+			t.Errorf("Expected collection 'products', got %q", req.Collection)
+		}
+		if req.Language != "fr" {
+			t.Errorf("Expected language 'fr', got %q", req.Language)
+		}
+		max := 500.0
+		json.NewEncoder(w).Encode(processResponse{ProcessedQuery: "pc", Keywords: []string{"pc"}, Language: "en", Intent: "transactional",
+			Ranges: []Filter{{Type: FilterRange, Field: "price", Max: &max, ExclusiveMax: true}}})
+	}))
+	defer server.Close()
+
+	sq, err := NewHTTPQueryUnderstandingClient(server.URL).Process(WithLanguage(WithCollection(context.Background(), "products"), "fr"), "The PC")
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+	if len(sq.Keywords) != 1 || sq.Keywords[0] != "pc" {
+		t.Errorf("Unexpected keywords: %v", sq.Keywords)
+	}
+	if sq.Language != "en" {
+		t.Errorf("Expected language 'en', got %q", sq.Language)
+	}
+	if sq.Intent != "transactional" {
+		t.Errorf("Expected intent 'transactional', got %q", sq.Intent)
+	}
+	if len(sq.Filters) != 1 || sq.Filters[0].Field != "price" || *sq.Filters[0].Max != 500 || !sq.Filters[0].ExclusiveMax {
+		t.Errorf("Expected the parsed range to become a filter, got %+v", sq.Filters)
+	}
+}
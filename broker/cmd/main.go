@@ -2,25 +2,110 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"broker"
+	"common/config"
+	"common/graceful"
+	"common/logging"
+	"common/reload"
+	"common/shard"
+	"common/slowlog"
+	"common/tenant"
+	"common/tlsconfig"
+	"common/tracing"
 )
 
-// In a real application, these would be configured via environment variables or a config file.
-const (
-	defaultPort = "8080"
-)
+// Config holds the broker's settings, read from a YAML file (-config-file), environment
+// variables and flags, in increasing order of precedence. Reloads apply every setting but
+// the port, TLS, the query understanding service and the query and slow query logs, which
+// take a restart.
+type Config struct {
+	Port            string           `yaml:"port" env:"PORT" flag:"port" usage:"Port to listen on"`
+	QUURL           string           `yaml:"qu_url" env:"QU_URL" flag:"qu-url" usage:"Query understanding service URL; empty uses a mock"`
+	QUGRPCAddr      string           `yaml:"qu_grpc_addr" env:"QU_GRPC_ADDR" flag:"qu-grpc-addr" usage:"host:port of the query understanding gRPC API, used instead of qu_url if set"`
+	Searchers       string           `yaml:"searchers" env:"SEARCHERS" flag:"searchers" usage:"Comma-separated [tenant/][collection:]shardID=url searchers; empty uses mocks"`
+	ShardMaps       []string         `yaml:"shard_maps" env:"SHARD_MAPS" flag:"shard-maps" usage:"Comma-separated shard map files written by resharding; their searchers replace the searchers of their collection"`
+	ShardMapReload  time.Duration    `yaml:"shard_map_reload" env:"SHARD_MAP_RELOAD" flag:"shard-map-reload" usage:"How often the shard map files are checked for a new version, which reloads the broker"`
+	LoadBalancing   string           `yaml:"load_balancing" env:"LOAD_BALANCING" flag:"load-balancing" usage:"Replica load balancing strategy"`
+	QueryLog        string           `yaml:"query_log" env:"QUERY_LOG" flag:"query-log" usage:"Query log sink: file:<path>, http(s)://<collector> or kafka://<brokers>/<topic>"`
+	SearchTimeout   time.Duration    `yaml:"search_timeout" env:"SEARCH_TIMEOUT" flag:"search-timeout" usage:"Latency budget of a search, e.g. 200ms; 0 disables deadlines"`
+	QUBudgetShare   float64          `yaml:"qu_budget_share" env:"QU_BUDGET_SHARE" flag:"qu-budget-share" usage:"Share of the latency budget granted to query understanding"`
+	DidYouMeanHits  int              `yaml:"did_you_mean_hits" env:"DID_YOU_MEAN_HITS" flag:"did-you-mean-hits" usage:"Searches with at most this many hits get a did-you-mean query; negative disables it"`
+	Fallbacks       []string         `yaml:"fallbacks" env:"FALLBACKS" flag:"fallbacks" usage:"Comma-separated relaxations retried in order for searches without results: drop_filters, fuzzy, any_term, spell_correct"`
+	NearDuplicates  int              `yaml:"near_duplicate_distance" env:"NEAR_DUPLICATE_DISTANCE" flag:"near-duplicate-distance" usage:"Results whose fingerprints differ by at most this many bits are collapsed as near-duplicates; negative disables it"`
+	SearchType      string           `yaml:"search_type" env:"SEARCH_TYPE" flag:"search-type" usage:"Default search type: query_and_fetch, or query_then_fetch to fetch stored fields for the returned page only"`
+	GlobalStats     time.Duration    `yaml:"global_stats_interval" env:"GLOBAL_STATS_INTERVAL" flag:"global-stats-interval" usage:"How often shard term statistics are gathered to score with the global IDF; 0 keeps shard scores"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"How long in-flight searches are drained on shutdown"`
+	TLS             tlsconfig.Config `yaml:"tls"`
+	// RoutingField prunes the shards of searches filtering on it by the summaries of its
+	// values gathered from the searchers, each of the segment it was gathered from. The
+	// summaries of a collection are dropped when a commit to it is announced and when a
+	// searcher loads another segment, so list the broker in the indexers'
+	// commit_subscribers and the searchers' load_subscribers.
+	RoutingField           string        `yaml:"routing_field" env:"ROUTING_FIELD" flag:"routing-field" usage:"Field documents are sharded by; searches filtering on it skip the shards that can't match. Empty searches every shard"`
+	RoutingRefreshInterval time.Duration `yaml:"routing_refresh_interval" env:"ROUTING_REFRESH_INTERVAL" flag:"routing-refresh-interval" usage:"How often the searchers' summaries of the routing field are gathered"`
+	// CommitToken authenticates the events of the indexers' commits and of the segments
+	// the searchers load, which rerun live queries; without it they are refused. It has no
+	// flag so it doesn't show up in process listings.
+	CommitToken string `yaml:"commit_token" env:"COMMIT_TOKEN" usage:"Shared secret of the indexers' and searchers' events"`
+	// SubscribeOrigins are the origins of the pages allowed to open live queries at
+	// /subscribe besides those of the broker's host; clients that aren't browsers send none.
+	SubscribeOrigins []string `yaml:"subscribe_origins" env:"SUBSCRIBE_ORIGINS" flag:"subscribe-origins" usage:"Comma-separated origins of the pages allowed to subscribe to live queries"`
+	// CacheMaxAge sets the Cache-Control of search responses. Their ETags change with the
+	// segments the searchers report serving, so clients revalidate them once it expires.
+	CacheMaxAge time.Duration `yaml:"cache_max_age" env:"CACHE_MAX_AGE" flag:"cache-max-age" usage:"How long clients and CDNs may cache search responses, e.g. the indexers' refresh interval; 0 makes them revalidate every search"`
+	// Log sets the level and format of the logs; the level can be changed at runtime
+	// through /admin/log-level.
+	Log logging.Config `yaml:"log"`
+	// SlowQueryLog records the searches taking at least its threshold, with their stage
+	// timings and shard breakdown.
+	SlowQueryLog slowlog.Config `yaml:"slow_query_log"`
+	// RankingRules are business rules reordering the merged results; they can only be set
+	// in the configuration file.
+	RankingRules []broker.RankingRule `yaml:"ranking_rules"`
+	// TypeBoosts multiply the scores of results by their document type, e.g.
+	// {product: 2, review: 0.5}; they can only be set in the configuration file.
+	TypeBoosts map[string]float64 `yaml:"type_boosts"`
+	// TenantQuotas limit the request rate of every tenant; per-tenant overrides can only
+	// be set in the configuration file.
+	TenantQuotas tenant.QuotaConfig `yaml:"tenant_quotas"`
+	// Experiments split searches between query understanding pipelines or ranking rules;
+	// they can only be set in the configuration file.
+	Experiments []broker.Experiment `yaml:"experiments"`
+	// Hedging sends a shard search to a second replica when the first one hasn't answered
+	// within a percentile of the shard's latencies, e.g. {percentile: 95, min_delay: 10ms};
+	// it can only be set in the configuration file.
+	Hedging broker.HedgeConfig `yaml:"hedging"`
+	// Instant tunes search-as-you-type (mode=instant); it can only be set in the
+	// configuration file.
+	Instant broker.InstantConfig `yaml:"instant"`
+	// Personalization promotes the results of the categories a user likes, for searches
+	// with a user_id; it can only be set in the configuration file.
+	Personalization *broker.CategoryAffinityConfig `yaml:"personalization"`
+	// Hybrid tunes the score fusion of hybrid searches (mode=hybrid) by collection, e.g.
+	// {products: {fusion: weighted_sum, keyword_weight: 0.7, vector_weight: 0.3}};
+	// it can only be set in the configuration file.
+	Hybrid map[string]broker.HybridConfig `yaml:"hybrid"`
+	// Joins enrich the returned results with the fields of records looked up by key in
+	// other stores, e.g. live inventory counts; they can only be set in the configuration
+	// file.
+	Joins []broker.JoinConfig `yaml:"joins"`
+}
 
 // MockQueryUnderstandingService is a simple mock implementation for demonstration.
 type MockQueryUnderstandingService struct{}
 
 func (m *MockQueryUnderstandingService) Process(ctx context.Context, rawQuery broker.RawQuery) (broker.StructuredQuery, error) {
-	log.Printf("MockQueryUnderstandingService: Processing raw query: \"%s\"", rawQuery)
+	slog.DebugContext(ctx, "MockQueryUnderstandingService: processing raw query", "query", rawQuery)
 	// For simplicity, let's just split the raw query into keywords.
 	// In a real scenario, this would involve NLP, entity recognition, etc.
 	keywords := []string{string(rawQuery)} // Treat the whole raw query as one keyword for now.
@@ -34,7 +119,7 @@ type MockSearcher struct {
 }
 
 func (m *MockSearcher) Search(ctx context.Context, query broker.StructuredQuery) ([]broker.SearchResult, error) {
-	log.Printf("MockSearcher %s (Shard %d): Searching for keywords: %v", m.ID, m.ShardID, query.Keywords)
+	slog.DebugContext(ctx, "MockSearcher: searching", "searcher", m.ID, "shard", m.ShardID, "keywords", query.Keywords)
 	results := []broker.SearchResult{}
 	// Simulate some search results based on keywords
 	for _, keyword := range query.Keywords {
@@ -61,57 +146,419 @@ func (m *MockSearcher) GetShardID() int {
 // Ensure MockSearcher implements the Searcher interface
 var _ broker.Searcher = (*MockSearcher)(nil)
 
+// parseSearchers parses a comma-separated list of "[tenant/][collection:]shardID=baseURL"
+// pairs (e.g. "0=http://localhost:8081,products:0=http://localhost:8083,
+// shop/products:0=http://localhost:8085") into HTTP searchers. Entries without a
+// collection belong to the default collection, and entries without a tenant to the
+// default tenant.
+func parseSearchers(spec string) ([]broker.Searcher, error) {
+	var searchers []broker.Searcher
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, baseURL, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid searcher entry %q, expected [tenant/][collection:]shardID=url", entry)
+		}
+		tenantID, target, found := strings.Cut(target, "/")
+		if !found {
+			tenantID, target = tenant.Default, tenantID
+		}
+		collection, shard, found := strings.Cut(target, ":")
+		if !found {
+			collection, shard = broker.DefaultCollection, target
+		}
+		shardID, err := strconv.Atoi(shard)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shard ID in searcher entry %q: %w", entry, err)
+		}
+		searcher := broker.NewCollectionHTTPSearcher(collection, baseURL, shardID)
+		if err := searcher.SetTenant(tenantID); err != nil {
+			return nil, fmt.Errorf("invalid tenant in searcher entry %q: %w", entry, err)
+		}
+		searchers = append(searchers, searcher)
+	}
+	return searchers, nil
+}
+
+// applyShardMaps replaces the searchers of the collection of every shard map at paths
+// with the searchers it lists, and returns the versions of the maps by path.
+func applyShardMaps(searchers []broker.Searcher, paths []string) ([]broker.Searcher, map[string]int, error) {
+	versions := make(map[string]int, len(paths))
+	for _, path := range paths {
+		m, err := shard.ReadShardMap(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		tenantID := m.Tenant
+		if tenantID == "" {
+			tenantID = tenant.Default
+		}
+		kept := searchers[:0:0]
+		for _, s := range searchers {
+			if searcherTenant(s) != tenantID || searcherCollection(s) != m.Collection {
+				kept = append(kept, s)
+			}
+		}
+		for shardID, replicas := range m.Shards {
+			for _, baseURL := range replicas {
+				searcher := broker.NewCollectionHTTPSearcher(m.Collection, baseURL, shardID)
+				if err := searcher.SetTenant(tenantID); err != nil {
+					return nil, nil, fmt.Errorf("invalid tenant in shard map %s: %w", path, err)
+				}
+				kept = append(kept, searcher)
+			}
+		}
+		searchers = kept
+		versions[path] = m.Version
+		slog.Info("Using the searchers of a shard map", "path", path, "collection", m.Collection, "version", m.Version, "shards", len(m.Shards))
+	}
+	return searchers, versions, nil
+}
+
+// searcherTenant returns the tenant served by s.
+func searcherTenant(s broker.Searcher) string {
+	if ts, ok := s.(broker.TenantSearcher); ok {
+		return ts.GetTenant()
+	}
+	return tenant.Default
+}
+
+// searcherCollection returns the collection served by s.
+func searcherCollection(s broker.Searcher) string {
+	if cs, ok := s.(broker.CollectionSearcher); ok && cs.GetCollection() != "" {
+		return cs.GetCollection()
+	}
+	return broker.DefaultCollection
+}
+
+// watchShardMaps checks the shard maps at paths every interval in the background and
+// reloads the broker when the version of one differs from versions, those of the maps it
+// was built from. It returns the function stopping it.
+func watchShardMaps(reloader *reload.Reloader, versions map[string]int, interval time.Duration) context.CancelFunc {
+	if len(versions) == 0 || interval <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for path, version := range versions {
+				m, err := shard.ReadShardMap(path)
+				if err != nil {
+					slog.Error("Failed to read shard map", "path", path, "error", err)
+					continue
+				}
+				if m.Version != version {
+					slog.Info("Shard map changed, reloading", "path", path, "version", m.Version)
+					if err := reloader.Reload(); err != nil {
+						slog.Error("Failed to reload for the new shard map", "path", path, "error", err)
+					}
+					break
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// defaultConfig returns the settings used where the configuration leaves them out.
+func defaultConfig() Config {
+	return Config{Port: "8080", ShardMapReload: 10 * time.Second, QUBudgetShare: broker.DefaultTimeoutBudget().QUFraction, NearDuplicates: broker.DefaultNearDuplicateDistance, RoutingRefreshInterval: time.Minute, ShutdownTimeout: graceful.DefaultTimeout, Instant: broker.DefaultInstantConfig(), Hedging: broker.DefaultHedgeConfig(), Log: logging.DefaultConfig(), SlowQueryLog: slowlog.DefaultConfig()}
+}
+
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = defaultPort
+	cfg := defaultConfig()
+	config.MustLoad(&cfg)
+	if err := logging.Setup(cfg.Log); err != nil {
+		log.Fatalf("Invalid log configuration: %v", err)
+	}
+
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.ConfigFromEnv("broker"))
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// The TLS settings enable TLS on the API and, for calls to the query understanding
+	// service and the searchers, a private CA and a client certificate for mTLS.
+	transport, err := tlsconfig.ClientTransport(cfg.TLS)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	if transport != nil {
+		broker.SetClientTransport(transport)
+	}
+
+	// Use the remote query understanding service if configured, otherwise fall back to the mock.
+	var quService broker.QueryUnderstandingService = &MockQueryUnderstandingService{}
+	switch {
+	case cfg.QUGRPCAddr != "":
+		var tlsConfig *tls.Config
+		if transport != nil {
+			tlsConfig = transport.TLSClientConfig
+		}
+		client, err := broker.NewGRPCQueryUnderstandingClient(cfg.QUGRPCAddr, tlsConfig)
+		if err != nil {
+			log.Fatalf("Failed to create query understanding client: %v", err)
+		}
+		defer client.Close()
+		quService = client
+		slog.Info("Using query understanding gRPC API", "addr", cfg.QUGRPCAddr)
+	case cfg.QUURL != "":
+		quService = broker.NewHTTPQueryUnderstandingClient(cfg.QUURL)
+		slog.Info("Using query understanding service", "url", cfg.QUURL)
+	}
+
+	// query_log enables query logging, e.g. file:/var/log/queries.jsonl,
+	// http://collector:8080/queries or kafka://kafka:9092/queries.
+	var queryLog *broker.QueryLogger
+	if cfg.QueryLog != "" {
+		sink, err := broker.NewQueryLogSink(cfg.QueryLog)
+		if err != nil {
+			log.Fatalf("Invalid query log sink: %v", err)
+		}
+		queryLog = broker.NewQueryLogger(sink)
+		defer queryLog.Close()
+		slog.Info("Logging queries", "sink", cfg.QueryLog)
+	}
+	slowLog, err := slowlog.New(cfg.SlowQueryLog)
+	if err != nil {
+		log.Fatalf("Invalid slow query log configuration: %v", err)
+	}
+	defer slowLog.Close()
+	shared := sharedServices{quService: quService, queryLog: queryLog, slowLog: slowLog}
+
+	b, shardMaps, err := newBroker(cfg, shared)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler := broker.NewHandler(b)
+	handler.SetCommitToken(cfg.CommitToken)
+	handler.SetSubscribeOrigins(cfg.SubscribeOrigins)
+	stopGlobalStats := watchGlobalStats(b, cfg.GlobalStats)
+	defer func() { stopGlobalStats() }()
+	stopShardRouting := watchShardRouting(b, cfg)
+	defer func() { stopShardRouting() }()
+
+	// On SIGHUP, POST /admin/reload or a new version of a shard map the configuration is
+	// read again and a new broker built from it replaces the running one, taking over its
+	// breakers, replica statistics, quotas left and gathered statistics; a configuration it
+	// fails to build from is rejected and the running broker kept.
+	var reloader *reload.Reloader
+	stopShardMaps := func() {}
+	reloader = reload.New("broker", func() error {
+		next := defaultConfig()
+		if err := config.Load(os.Args[0], &next, os.Args[1:]); err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+		b, shardMaps, err := newBroker(next, shared)
+		if err != nil {
+			return err
+		}
+		handler.SetBroker(b)
+		stopGlobalStats()
+		stopGlobalStats = watchGlobalStats(b, next.GlobalStats)
+		stopShardRouting()
+		stopShardRouting = watchShardRouting(b, next)
+		stopShardMaps()
+		stopShardMaps = watchShardMaps(reloader, shardMaps, next.ShardMapReload)
+		return nil
+	})
+	stopShardMaps = watchShardMaps(reloader, shardMaps, cfg.ShardMapReload)
+	defer func() { stopShardMaps() }()
+	reloadCtx, stopReloading := context.WithCancel(context.Background())
+	defer stopReloading()
+	go reloader.Watch(reloadCtx)
+	mux := http.NewServeMux()
+	mux.Handle(reload.Path, reloader.Handler())
+	mux.Handle(logging.LevelPath, logging.LevelHandler())
+	mux.Handle("/", handler)
+
+	server, err := tlsconfig.NewServer(":"+cfg.Port, tracing.Middleware(logging.Middleware(mux), "broker"), cfg.TLS)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	slog.Info("Broker service starting", "port", cfg.Port)
+	// On SIGTERM/SIGINT in-flight searches are drained; the deferred calls then flush
+	// the query log and the pending spans.
+	if err := graceful.Serve(server, cfg.ShutdownTimeout); err != nil {
+		log.Fatalf("Broker service failed: %v", err)
 	}
+	slog.Info("Broker service stopped")
+}
 
-	quService := &MockQueryUnderstandingService{}
+// sharedServices are the parts of the broker kept across configuration reloads.
+type sharedServices struct {
+	quService broker.QueryUnderstandingService
+	queryLog  *broker.QueryLogger
+	slowLog   *slowlog.Logger
+}
 
-	// Create a few mock searchers to simulate sharding
+// newBroker builds a broker from cfg, failing on the first invalid setting, and returns
+// it with the versions of the shard maps it was built from.
+func newBroker(cfg Config, shared sharedServices) (*broker.Broker, map[string]int, error) {
+	// Create a few mock searchers to simulate sharding, unless remote searchers are configured.
 	searchers := []broker.Searcher{
 		&MockSearcher{ID: "searcher-1", ShardID: 0},
 		&MockSearcher{ID: "searcher-2", ShardID: 1},
 		&MockSearcher{ID: "searcher-3", ShardID: 0}, // Another searcher for shard 0
 		&MockSearcher{ID: "searcher-4", ShardID: 1}, // Another searcher for shard 1
 	}
-
-	// Initialize the broker
-	b := broker.NewBroker(quService, searchers)
-
-	// Define the HTTP handler for search queries
-	http.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	if cfg.Searchers != "" {
+		var err error
+		searchers, err = parseSearchers(cfg.Searchers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse searchers: %w", err)
 		}
+		slog.Info("Using remote searchers", "searchers", len(searchers))
+	}
+	searchers, versions, err := applyShardMaps(searchers, cfg.ShardMaps)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid shard map: %w", err)
+	}
 
-		queryParam := r.URL.Query().Get("q")
-		if queryParam == "" {
-			http.Error(w, "Missing 'q' query parameter", http.StatusBadRequest)
-			return
-		}
+	// Initialize the broker
+	b := broker.NewBroker(shared.quService, searchers)
 
-		log.Printf("Received raw query: \"%s\"", queryParam)
+	// Replicas of a shard are load balanced; load_balancing selects the strategy.
+	selector, err := broker.NewReplicaSelector(cfg.LoadBalancing)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid load balancing strategy: %w", err)
+	}
+	b.SetReplicaSelector(selector)
+	if err := b.SetHedging(cfg.Hedging); err != nil {
+		return nil, nil, fmt.Errorf("invalid hedging configuration: %w", err)
+	}
+	if cfg.Hedging.Percentile > 0 {
+		slog.Info("Hedging slow shard searches", "percentile", cfg.Hedging.Percentile)
+	}
 
-		ctx, cancel := context.WithCancel(r.Context())
-		defer cancel()
+	budget := broker.TimeoutBudget{Total: cfg.SearchTimeout, QUFraction: cfg.QUBudgetShare}
+	if err := budget.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid search timeout: %w", err)
+	}
+	b.SetTimeoutBudget(budget)
+	if err := b.SetSearchType(cfg.SearchType); err != nil {
+		return nil, nil, fmt.Errorf("invalid search type: %w", err)
+	}
+	b.SetDidYouMeanThreshold(cfg.DidYouMeanHits)
+	if err := b.SetFallbacks(cfg.Fallbacks); err != nil {
+		return nil, nil, fmt.Errorf("invalid fallbacks: %w", err)
+	}
+	if cfg.CacheMaxAge < 0 {
+		return nil, nil, fmt.Errorf("invalid cache max age %s, must not be negative", cfg.CacheMaxAge)
+	}
+	b.SetCacheMaxAge(cfg.CacheMaxAge)
+	b.SetNearDuplicateDistance(cfg.NearDuplicates)
+	if err := cfg.Instant.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid instant search configuration: %w", err)
+	}
+	b.SetInstantConfig(cfg.Instant)
 
-		results, err := b.Search(ctx, broker.RawQuery(queryParam))
+	if len(cfg.RankingRules) > 0 {
+		reranker, err := broker.NewReranker(cfg.RankingRules)
 		if err != nil {
-			log.Printf("Broker search failed: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+			return nil, nil, fmt.Errorf("invalid ranking rules: %w", err)
 		}
+		b.SetReranker(reranker)
+		slog.Info("Applying ranking rules", "rules", len(cfg.RankingRules))
+	}
+	if err := b.SetHybridConfigs(cfg.Hybrid); err != nil {
+		return nil, nil, fmt.Errorf("invalid hybrid search configuration: %w", err)
+	}
+	if len(cfg.TypeBoosts) > 0 {
+		if err := b.SetTypeBoosts(cfg.TypeBoosts); err != nil {
+			return nil, nil, fmt.Errorf("invalid type boosts: %w", err)
+		}
+		slog.Info("Boosting document types", "types", len(cfg.TypeBoosts))
+	}
+	if cfg.Personalization != nil {
+		store, err := broker.LoadAffinityFile(cfg.Personalization.AffinityFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load the user affinities: %w", err)
+		}
+		personalizer, err := broker.NewCategoryAffinityPersonalizer(*cfg.Personalization, store)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid personalization configuration: %w", err)
+		}
+		b.SetPersonalizer(personalizer)
+		slog.Info("Personalizing results by affinity", "field", cfg.Personalization.Field)
+	}
+	if len(cfg.Joins) > 0 {
+		joins := make([]broker.Join, len(cfg.Joins))
+		for i, jc := range cfg.Joins {
+			if joins[i], err = broker.NewHTTPJoin(jc); err != nil {
+				return nil, nil, fmt.Errorf("invalid join: %w", err)
+			}
+		}
+		if err := b.SetJoins(joins); err != nil {
+			return nil, nil, fmt.Errorf("invalid joins: %w", err)
+		}
+		slog.Info("Enriching results with joins", "joins", len(joins))
+	}
+	if len(cfg.Experiments) > 0 {
+		if err := b.SetExperiments(cfg.Experiments); err != nil {
+			return nil, nil, fmt.Errorf("invalid experiments: %w", err)
+		}
+		slog.Info("Running experiments", "experiments", len(cfg.Experiments))
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(results); err != nil {
-			log.Printf("Failed to encode response: %v", err)
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	// Scores of different shards are made comparable with the IDF of the whole collection,
+	// gathered from the searchers in the background by watchGlobalStats.
+	if cfg.GlobalStats > 0 {
+		b.SetGlobalStats(broker.NewGlobalStats())
+	}
+
+	if cfg.RoutingField != "" {
+		if cfg.RoutingRefreshInterval <= 0 {
+			return nil, nil, fmt.Errorf("invalid routing refresh interval %s, must be positive", cfg.RoutingRefreshInterval)
 		}
-	})
+		b.SetShardPruner(broker.NewShardPruner(cfg.RoutingField))
+	}
+
+	limiter, err := tenant.NewLimiter(cfg.TenantQuotas)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid tenant quotas: %w", err)
+	}
+	b.SetTenantLimiter(limiter)
+
+	if shared.queryLog != nil {
+		b.SetQueryLogger(shared.queryLog)
+	}
+	b.SetSlowQueryLog(shared.slowLog)
+	return b, versions, nil
+}
+
+// watchGlobalStats gathers the term statistics of the searchers of b every interval in
+// the background; an interval of 0 gathers none. It returns the function stopping it.
+func watchGlobalStats(b *broker.Broker, interval time.Duration) context.CancelFunc {
+	if interval <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go b.WatchGlobalStats(ctx, interval)
+	slog.Info("Scoring with global term statistics", "refresh_interval", interval)
+	return cancel
+}
 
-	log.Printf("Broker service starting on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+// watchShardRouting gathers the summaries of the routing field from the searchers of b
+// every refresh interval in the background, if cfg sets a routing field. It returns the
+// function stopping it.
+func watchShardRouting(b *broker.Broker, cfg Config) context.CancelFunc {
+	if cfg.RoutingField == "" {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go b.WatchShardRouting(ctx, cfg.RoutingRefreshInterval)
+	slog.Info("Pruning shards by routing field", "field", cfg.RoutingField, "refresh_interval", cfg.RoutingRefreshInterval)
+	return cancel
 }
@@ -0,0 +1,110 @@
+package broker
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// StageCollapse is the debug stage of field collapsing, reported for collapsed searches.
+const StageCollapse = "collapse"
+
+// maxCollapseSize bounds the results kept per group.
+const maxCollapseSize = 10
+
+// Collapse groups the results of a search by the value of a field, keeping only the best
+// results of every group, e.g. a few results per domain.
+type Collapse struct {
+	// Field is the stored keyword field, or a RuleField pseudo-field such as host, the
+	// results are grouped by. Results without a value form a group of their own each.
+	Field string
+	// Size is the number of results kept per group; 0 keeps one.
+	Size int
+}
+
+// ParseCollapse reads the "collapse" (a field) and "collapse_size" parameters; it returns
+// nil if no field is given.
+func ParseCollapse(field, size string) (*Collapse, error) {
+	if field == "" {
+		if size != "" {
+			return nil, fmt.Errorf("'collapse_size' requires a 'collapse' field")
+		}
+		return nil, nil
+	}
+	c := &Collapse{Field: field, Size: 1}
+	if size != "" {
+		v, err := strconv.Atoi(size)
+		if err != nil || v <= 0 || v > maxCollapseSize {
+			return nil, fmt.Errorf("invalid 'collapse_size' query parameter, must be between 1 and %d", maxCollapseSize)
+		}
+		c.Size = v
+	}
+	return c, nil
+}
+
+// Fields returns the stored fields the collapse field is read from.
+func (c *Collapse) Fields() []string {
+	return storedRuleFields(c.Field)
+}
+
+// CollapseSummary reports the groups of a collapsed search.
+type CollapseSummary struct {
+	Field string `json:"field"`
+	// TotalHits counts the merged results before collapsing; the TotalHits of the
+	// response counts those kept.
+	TotalHits   int `json:"total_hits"`
+	TotalGroups int `json:"total_groups"`
+	// Groups are the groups of the returned results, in the order of their best result.
+	Groups []ResultGroup `json:"groups"`
+}
+
+// ResultGroup is a group of a collapsed search.
+type ResultGroup struct {
+	Value    string `json:"value"`
+	Count    int    `json:"count"`    // Results of the group before collapsing
+	Returned int    `json:"returned"` // Results of the group on the returned page
+}
+
+// collapse keeps the first Size results of every group, in their order, and returns them
+// with the size of every group by value. Multi-valued fields group by their first value.
+func (c *Collapse) collapse(results []SearchResult) ([]SearchResult, map[string]int, int) {
+	counts := make(map[string]int)
+	groups := 0
+	kept := results[:0:0]
+	for _, r := range results {
+		values := ruleFieldValues(r, c.Field)
+		if len(values) == 0 || values[0] == "" {
+			groups++
+			kept = append(kept, r)
+			continue
+		}
+		r.Group = values[0]
+		if counts[r.Group] == 0 {
+			groups++
+		}
+		counts[r.Group]++
+		if counts[r.Group] <= c.Size {
+			kept = append(kept, r)
+		}
+	}
+	return kept, counts, groups
+}
+
+// summarize reports the groups of the page of collapsed results.
+func (c *Collapse) summarize(page []SearchResult, counts map[string]int, totalHits, totalGroups int) *CollapseSummary {
+	summary := &CollapseSummary{Field: c.Field, TotalHits: totalHits, TotalGroups: totalGroups, Groups: []ResultGroup{}}
+	index := make(map[string]int)
+	for _, r := range page {
+		if r.Group == "" {
+			summary.Groups = append(summary.Groups, ResultGroup{Count: 1, Returned: 1})
+			continue
+		}
+		i, ok := index[r.Group]
+		if !ok {
+			i = len(summary.Groups)
+			index[r.Group] = i
+			summary.Groups = append(summary.Groups, ResultGroup{Value: r.Group, Count: counts[r.Group]})
+		}
+		summary.Groups[i].Returned++
+	}
+	return summary
+}
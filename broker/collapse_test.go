@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func collapseTestResults() []SearchResult {
+	domain := func(d string) map[string]interface{} { return map[string]interface{}{"domain": d} }
+	return []SearchResult{
+		{ID: "a1", Score: 5, Stored: domain("a.com")},
+		{ID: "a2", Score: 4, Stored: domain("a.com")},
+		{ID: "b1", Score: 3, Stored: domain("b.com")},
+		{ID: "none", Score: 2.5},
+		{ID: "a3", Score: 2, Stored: domain("a.com")},
+		{ID: "c1", Score: 1, Stored: map[string]interface{}{"domain": []interface{}{"c.com", "a.com"}}},
+	}
+}
+
+func TestCollapse(t *testing.T) {
+	c := &Collapse{Field: "domain", Size: 2}
+	kept, counts, groups := c.collapse(collapseTestResults())
+	if got, want := resultIDs(kept), []string{"a1", "a2", "b1", "none", "c1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected collapsed results: got %v, want %v", got, want)
+	}
+	if counts["a.com"] != 3 || counts["c.com"] != 1 || groups != 4 {
+		t.Errorf("Unexpected group counts %v (%d groups)", counts, groups)
+	}
+
+	summary := c.summarize(kept[:3], counts, 6, groups)
+	want := []ResultGroup{{Value: "a.com", Count: 3, Returned: 2}, {Value: "b.com", Count: 1, Returned: 1}}
+	if !reflect.DeepEqual(summary.Groups, want) {
+		t.Errorf("Unexpected groups: got %+v, want %+v", summary.Groups, want)
+	}
+}
+
+func TestParseCollapse(t *testing.T) {
+	if c, err := ParseCollapse("", ""); c != nil || err != nil {
+		t.Errorf("Expected no collapse, got %+v (%v)", c, err)
+	}
+	if c, err := ParseCollapse("domain", ""); err != nil || c.Size != 1 {
+		t.Errorf("Expected one result per group by default, got %+v (%v)", c, err)
+	}
+	for _, size := range []string{"0", "x", "11"} {
+		if _, err := ParseCollapse("domain", size); err == nil {
+			t.Errorf("Expected an error for collapse_size %q", size)
+		}
+	}
+	if _, err := ParseCollapse("", "2"); err == nil {
+		t.Error("Expected an error for a collapse_size without a field")
+	}
+}
+
+func TestHandleSearch_Collapse(t *testing.T) {
+	var rankingFields []string
+	searcher := &MockSearcher{
+		SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+			rankingFields = query.RankingFields
+			return collapseTestResults(), nil
+		},
+	}
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{searcher}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=q&collapse=domain&size=3", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !reflect.DeepEqual(rankingFields, []string{"domain"}) {
+		t.Errorf("Expected searchers to be asked for the collapse field, got %v", rankingFields)
+	}
+	var resp SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if got, want := resultIDs(resp.Results), []string{"a1", "b1", "none"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected results: got %v, want %v", got, want)
+	}
+	if resp.TotalHits != 4 || !resp.Pagination.HasMore {
+		t.Errorf("Expected 4 collapsed hits, got %d (%+v)", resp.TotalHits, resp.Pagination)
+	}
+	if c := resp.Collapse; c == nil || c.TotalHits != 6 || c.TotalGroups != 4 || len(c.Groups) != 3 || c.Groups[0].Count != 3 {
+		t.Errorf("Unexpected collapse summary %+v", c)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=q&collapse=domain&collapse_size=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid collapse size, got %d", rec.Code)
+	}
+}
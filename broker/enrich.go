@@ -0,0 +1,365 @@
+package broker
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// StageEnrich is the debug stage of the joins enriching the returned page of results.
+const StageEnrich = "enrich"
+
+// DefaultEnrichBatchSize is the number of keys a join looks up per Enrich call when its
+// BatchSize is 0.
+const DefaultEnrichBatchSize = 100
+
+var (
+	enrichmentErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_enrichment_errors_total",
+		Help: "Batches of keys a join failed to look up, leaving their results unenriched",
+	}, []string{"join"})
+	enrichmentCacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "broker_enrichment_cache_lookups_total",
+		Help: "Keys looked up in the caches of enrichers, by whether they were cached",
+	}, []string{"result"})
+)
+
+// Enricher looks up the fields of records held outside the index by key, e.g. the live
+// inventory counts of products by product ID.
+type Enricher interface {
+	// Enrich returns the fields of the records of keys, by key. Keys without a record are
+	// left out.
+	Enrich(ctx context.Context, keys []string) (map[string]map[string]interface{}, error)
+}
+
+// Join enriches the results of searches with the fields an Enricher looks up by the key of
+// every result.
+type Join struct {
+	Name     string   // Names the join in logs and metrics
+	KeyField string   // Stored field of the results holding the key; empty joins on the result ID
+	Into     string   // Field of the results the looked-up fields are set under; empty merges them in
+	Enricher Enricher // Looks up the fields of the keys
+	// BatchSize bounds the keys of one Enrich call; the batches of a page are looked up
+	// concurrently. 0 means DefaultEnrichBatchSize.
+	BatchSize int
+}
+
+// Validate checks that the join has a name and an enricher.
+func (j Join) Validate() error {
+	if j.Name == "" {
+		return fmt.Errorf("join requires a name")
+	}
+	if j.Enricher == nil {
+		return fmt.Errorf("join %s requires an enricher", j.Name)
+	}
+	if j.BatchSize < 0 {
+		return fmt.Errorf("join %s: invalid batch size %d, must not be negative", j.Name, j.BatchSize)
+	}
+	return nil
+}
+
+// key returns the key of r in the join, "" if it has none.
+func (j Join) key(r SearchResult) string {
+	if j.KeyField == "" {
+		return r.ID
+	}
+	switch v := r.Stored[j.KeyField].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// SetJoins sets the joins enriching the returned page of every search, applied in order;
+// nil disables enrichment.
+func (b *Broker) SetJoins(joins []Join) error {
+	for _, j := range joins {
+		if err := j.Validate(); err != nil {
+			return err
+		}
+	}
+	b.joins = joins
+	return nil
+}
+
+// joinKeyFields returns the stored fields holding the keys of the joins, which searchers
+// must return with every result.
+func (b *Broker) joinKeyFields() []string {
+	var fields []string
+	for _, j := range b.joins {
+		if j.KeyField != "" {
+			fields = appendMissing(fields, j.KeyField)
+		}
+	}
+	return fields
+}
+
+// enrich applies the joins to page in place. Results whose keys a join fails to look up
+// are left as they are.
+func (b *Broker) enrich(ctx context.Context, page []SearchResult) {
+	for _, j := range b.joins {
+		b.applyJoin(ctx, j, page)
+	}
+}
+
+// applyJoin looks up the keys of page with the enricher of j in batches and sets the
+// fields found on their results.
+func (b *Broker) applyJoin(ctx context.Context, j Join, page []SearchResult) {
+	ctx, span := tracer.Start(ctx, "broker.Enrich")
+	defer span.End()
+	positions := make(map[string][]int)
+	var keys []string
+	for i, r := range page {
+		key := j.key(r)
+		if key == "" {
+			continue
+		}
+		if _, ok := positions[key]; !ok {
+			keys = append(keys, key)
+		}
+		positions[key] = append(positions[key], i)
+	}
+	batchSize := j.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultEnrichBatchSize
+	}
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		found = make(map[string]map[string]interface{}, len(keys))
+	)
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			records, err := j.Enricher.Enrich(ctx, batch)
+			if err != nil {
+				span.RecordError(err)
+				enrichmentErrorsTotal.WithLabelValues(j.Name).Inc()
+				slog.WarnContext(ctx, "Failed to enrich results", "join", j.Name, "keys", len(batch), "error", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for key, fields := range records {
+				found[key] = fields
+			}
+		}(keys[start:end])
+	}
+	wg.Wait()
+
+	for key, fields := range found {
+		for _, i := range positions[key] {
+			r := &page[i]
+			// Results may share their fields with those of other searches of a batch.
+			merged := make(map[string]interface{}, len(r.Fields)+len(fields))
+			for k, v := range r.Fields {
+				merged[k] = v
+			}
+			if j.Into != "" {
+				merged[j.Into] = fields
+			} else {
+				for k, v := range fields {
+					merged[k] = v
+				}
+			}
+			r.Fields = merged
+		}
+	}
+}
+
+// JoinConfig configures a join looking up records with an HTTPEnricher, e.g.
+// {name: inventory, key_field: sku, into: inventory, url: http://inventory/lookup}.
+type JoinConfig struct {
+	Name      string        `yaml:"name"`
+	KeyField  string        `yaml:"key_field"` // Empty joins on the result ID
+	Into      string        `yaml:"into"`      // Empty merges the looked-up fields into the results'
+	URL       string        `yaml:"url"`
+	Timeout   time.Duration `yaml:"timeout"` // 0 keeps the default timeout of the broker's clients
+	BatchSize int           `yaml:"batch_size"`
+	// CacheTTL is how long looked-up records are reused, 0 disabling the cache; CacheSize
+	// bounds the keys cached.
+	CacheTTL  time.Duration `yaml:"cache_ttl"`
+	CacheSize int           `yaml:"cache_size"`
+}
+
+// NewHTTPJoin creates the join of cfg.
+func NewHTTPJoin(cfg JoinConfig) (Join, error) {
+	if cfg.URL == "" {
+		return Join{}, fmt.Errorf("join %s requires a url", cfg.Name)
+	}
+	var enricher Enricher = NewHTTPEnricher(cfg.URL, cfg.Timeout)
+	if cfg.CacheTTL > 0 {
+		cached, err := NewCachingEnricher(enricher, cfg.CacheTTL, cfg.CacheSize)
+		if err != nil {
+			return Join{}, fmt.Errorf("join %s: %w", cfg.Name, err)
+		}
+		enricher = cached
+	}
+	j := Join{Name: cfg.Name, KeyField: cfg.KeyField, Into: cfg.Into, Enricher: enricher, BatchSize: cfg.BatchSize}
+	return j, j.Validate()
+}
+
+// HTTPEnricher looks up records with a POST of {"keys": [...]} to a URL, which answers
+// {"records": {key: {field: value}}}.
+type HTTPEnricher struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPEnricher creates an enricher querying url, with the broker's client transport.
+// A zero timeout keeps the default timeout of the broker's clients.
+func NewHTTPEnricher(url string, timeout time.Duration) *HTTPEnricher {
+	client := newTracingHTTPClient()
+	if timeout > 0 {
+		client.Timeout = timeout
+	}
+	return &HTTPEnricher{url: url, client: client}
+}
+
+// Enrich implements Enricher.
+func (e *HTTPEnricher) Enrich(ctx context.Context, keys []string) (map[string]map[string]interface{}, error) {
+	body, err := json.Marshal(map[string][]string{"keys": keys})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode enrichment request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create enrichment request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var resp struct {
+		Records map[string]map[string]interface{} `json:"records"`
+	}
+	if err := doJSON(e.client, req, &resp); err != nil {
+		return nil, fmt.Errorf("enrichment request to %s failed: %w", e.url, err)
+	}
+	return resp.Records, nil
+}
+
+// CachingEnricher keeps the records another enricher looked up, and the keys it found no
+// record of, for a TTL, evicting the least recently used keys beyond its size. It is safe
+// for concurrent use.
+type CachingEnricher struct {
+	next Enricher
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // *enrichmentEntry, most recently used first
+}
+
+// enrichmentEntry is a cached record, nil for a key without one.
+type enrichmentEntry struct {
+	key     string
+	fields  map[string]interface{}
+	expires time.Time
+}
+
+// NewCachingEnricher caches the records of next for ttl, keeping at most size keys.
+func NewCachingEnricher(next Enricher, ttl time.Duration, size int) (*CachingEnricher, error) {
+	if ttl <= 0 || size <= 0 {
+		return nil, fmt.Errorf("invalid enrichment cache, ttl and size must be positive")
+	}
+	return &CachingEnricher{next: next, ttl: ttl, size: size, now: time.Now, entries: make(map[string]*list.Element), lru: list.New()}, nil
+}
+
+// Enrich implements Enricher, looking up the keys missing from the cache with the next
+// enricher.
+func (c *CachingEnricher) Enrich(ctx context.Context, keys []string) (map[string]map[string]interface{}, error) {
+	records := make(map[string]map[string]interface{}, len(keys))
+	var missing []string
+	c.mu.Lock()
+	now := c.now()
+	for _, key := range keys {
+		elem, ok := c.entries[key]
+		if !ok || now.After(elem.Value.(*enrichmentEntry).expires) {
+			missing = append(missing, key)
+			continue
+		}
+		c.lru.MoveToFront(elem)
+		if fields := elem.Value.(*enrichmentEntry).fields; fields != nil {
+			records[key] = fields
+		}
+	}
+	c.mu.Unlock()
+	enrichmentCacheLookupsTotal.WithLabelValues("hit").Add(float64(len(keys) - len(missing)))
+	enrichmentCacheLookupsTotal.WithLabelValues("miss").Add(float64(len(missing)))
+	if len(missing) == 0 {
+		return records, nil
+	}
+
+	looked, err := c.next.Enrich(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(c.ttl)
+	for _, key := range missing {
+		fields := looked[key]
+		if fields != nil {
+			records[key] = fields
+		}
+		c.store(&enrichmentEntry{key: key, fields: fields, expires: expires})
+	}
+	return records, nil
+}
+
+// inherit carries the records prev cached over to c, up to the size of c, if both cache
+// the records of the same URL.
+func (c *CachingEnricher) inherit(prev *CachingEnricher) {
+	next, ok := c.next.(*HTTPEnricher)
+	prevNext, prevOK := prev.next.(*HTTPEnricher)
+	if !ok || !prevOK || next.url != prevNext.url {
+		return
+	}
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := prev.lru.Back(); elem != nil; elem = elem.Prev() {
+		c.store(elem.Value.(*enrichmentEntry))
+	}
+}
+
+// store caches entry, evicting the least recently used keys beyond the size. Callers must
+// hold c.mu.
+func (c *CachingEnricher) store(entry *enrichmentEntry) {
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*enrichmentEntry).key)
+	}
+}
+
+// Ensure the enrichers implement Enricher.
+var (
+	_ Enricher = (*HTTPEnricher)(nil)
+	_ Enricher = (*CachingEnricher)(nil)
+)
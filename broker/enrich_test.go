@@ -0,0 +1,168 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockEnricher looks up records in a map, recording the batches of keys it is asked for.
+type mockEnricher struct {
+	mu      sync.Mutex
+	records map[string]map[string]interface{}
+	batches [][]string
+	err     error
+}
+
+func (m *mockEnricher) Enrich(_ context.Context, keys []string) (map[string]map[string]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, append([]string(nil), keys...))
+	if m.err != nil {
+		return nil, m.err
+	}
+	found := make(map[string]map[string]interface{})
+	for _, key := range keys {
+		if r, ok := m.records[key]; ok {
+			found[key] = r
+		}
+	}
+	return found, nil
+}
+
+func TestBroker_Search_Joins(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: []string{"boots"}}, nil
+		},
+	}
+	var rankingFields []string
+	searcher := &MockSearcher{ShardID: 0, SearchFunc: func(_ context.Context, q StructuredQuery) ([]SearchResult, error) {
+		rankingFields = q.RankingFields
+		return []SearchResult{
+			{ID: "p1", Score: 3, Stored: map[string]interface{}{"sku": "A"}},
+			{ID: "p2", Score: 2, Stored: map[string]interface{}{"sku": "B"}, Fields: map[string]interface{}{"title": "Boots"}},
+			{ID: "p3", Score: 1, Stored: map[string]interface{}{"sku": "A"}},
+		}, nil
+	}}
+	inventory := &mockEnricher{records: map[string]map[string]interface{}{
+		"A": {"stock": 4.0},
+		"B": {"stock": 0.0},
+	}}
+	ratings := &mockEnricher{records: map[string]map[string]interface{}{"p2": {"rating": 4.5}}}
+	b := NewBroker(mockQU, []Searcher{searcher})
+	err := b.SetJoins([]Join{
+		{Name: "inventory", KeyField: "sku", Into: "inventory", Enricher: inventory, BatchSize: 1},
+		{Name: "ratings", Enricher: ratings},
+	})
+	if err != nil {
+		t.Fatalf("SetJoins returned an error: %v", err)
+	}
+
+	resp, err := b.SearchWithOptions(context.Background(), "boots", SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if len(rankingFields) != 1 || rankingFields[0] != "sku" {
+		t.Errorf("Expected the key field to be asked for, got %v", rankingFields)
+	}
+	// Keys are deduplicated and looked up one per batch.
+	var keys []string
+	for _, batch := range inventory.batches {
+		if len(batch) != 1 {
+			t.Errorf("Expected batches of 1 key, got %v", batch)
+		}
+		keys = append(keys, batch...)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "A" || keys[1] != "B" {
+		t.Errorf("Expected keys A and B to be looked up once, got %v", keys)
+	}
+	byID := make(map[string]SearchResult)
+	for _, r := range resp.Results {
+		byID[r.ID] = r
+	}
+	if stock := byID["p3"].Fields["inventory"].(map[string]interface{})["stock"]; stock != 4.0 {
+		t.Errorf("Expected p3 to be joined with the record of its SKU, got %v", byID["p3"].Fields)
+	}
+	if p2 := byID["p2"].Fields; p2["title"] != "Boots" || p2["rating"] != 4.5 || p2["inventory"] == nil {
+		t.Errorf("Expected p2 to keep its fields and get both joins, got %v", p2)
+	}
+	if _, ok := byID["p1"].Fields["rating"]; ok {
+		t.Errorf("Expected no rating for p1, got %v", byID["p1"].Fields)
+	}
+
+	// A failing enricher leaves the results as they are.
+	inventory.err = errors.New("store down")
+	resp, err = b.SearchWithOptions(context.Background(), "boots", SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	for _, r := range resp.Results {
+		if _, ok := r.Fields["inventory"]; ok {
+			t.Errorf("Expected no inventory when its store fails, got %v", r.Fields)
+		}
+	}
+}
+
+func TestCachingEnricher(t *testing.T) {
+	next := &mockEnricher{records: map[string]map[string]interface{}{"a": {"n": 1.0}, "b": {"n": 2.0}}}
+	c, err := NewCachingEnricher(next, time.Minute, 2)
+	if err != nil {
+		t.Fatalf("NewCachingEnricher returned an error: %v", err)
+	}
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if found, _ := c.Enrich(ctx, []string{"a", "missing"}); len(found) != 1 || found["a"]["n"] != 1.0 {
+		t.Fatalf("Unexpected records %v", found)
+	}
+	// Both the record and the missing key are cached.
+	if found, _ := c.Enrich(ctx, []string{"missing", "a"}); len(found) != 1 || len(next.batches) != 1 {
+		t.Errorf("Expected the keys to be cached, got %v after %d lookups", found, len(next.batches))
+	}
+	// b evicts the least recently used key, missing.
+	c.Enrich(ctx, []string{"b"})
+	c.Enrich(ctx, []string{"a", "missing"})
+	if last := next.batches[len(next.batches)-1]; len(last) != 1 || last[0] != "missing" {
+		t.Errorf("Expected only the evicted key to be looked up again, got %v", last)
+	}
+	now = now.Add(2 * time.Minute)
+	c.Enrich(ctx, []string{"b"})
+	if last := next.batches[len(next.batches)-1]; len(last) != 1 || last[0] != "b" {
+		t.Errorf("Expected the expired key to be looked up again, got %v", last)
+	}
+
+	if _, err := NewCachingEnricher(next, 0, 10); err == nil {
+		t.Error("Expected an error for a zero TTL")
+	}
+}
+
+func TestHTTPEnricher_Enrich(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Keys []string `json:"keys"`
+		}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil || len(req.Keys) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"records": map[string]interface{}{req.Keys[0]: map[string]interface{}{"stock": 3}}})
+	}))
+	defer server.Close()
+
+	found, err := NewHTTPEnricher(server.URL, time.Second).Enrich(context.Background(), []string{"A", "B"})
+	if err != nil {
+		t.Fatalf("Enrich returned an error: %v", err)
+	}
+	if len(found) != 1 || found["A"]["stock"] != 3.0 {
+		t.Errorf("Unexpected records %v", found)
+	}
+}
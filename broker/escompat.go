@@ -0,0 +1,606 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/tenant"
+)
+
+// maxESQueryDepth bounds the nesting of bool clauses.
+const maxESQueryDepth = 32
+
+// errESQuery is wrapped by the errors of Elasticsearch queries that can't be translated.
+var errESQuery = errors.New("unsupported query")
+
+// ESSearchRequest is the body of an Elasticsearch search request. The query supports
+// match_all, match, match_phrase, term, terms, range and bool (must, should, must_not and
+// filter) clauses; sort takes fields and "_score", plain or as {"field": "desc"} or
+// {"field": {"order": "desc"}}. A knn section searches the nearest neighbors of a vector
+// among the documents matching the query, scored by similarity.
+type ESSearchRequest struct {
+	Query   json.RawMessage   `json:"query"`
+	From    *int              `json:"from"`
+	Size    *int              `json:"size"`
+	Sort    []json.RawMessage `json:"sort"`
+	Source  json.RawMessage   `json:"_source"` // true, false, a field or a list of fields
+	Timeout string            `json:"timeout"` // e.g. "500ms"
+	KNN     *KNNQuery         `json:"knn"`
+}
+
+// ESSearchResponse is the Elasticsearch form of a SearchResponse.
+type ESSearchResponse struct {
+	Took     int64    `json:"took"`
+	TimedOut bool     `json:"timed_out"`
+	Shards   ESShards `json:"_shards"`
+	Hits     ESHits   `json:"hits"`
+}
+
+// ESShards reports the shards a search queried.
+type ESShards struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+}
+
+// ESHits holds the page of results and the total hits.
+type ESHits struct {
+	Total    ESTotal  `json:"total"`
+	MaxScore *float64 `json:"max_score"` // nil for searches sorted by field
+	Hits     []ESHit  `json:"hits"`
+}
+
+// ESTotal is the total number of hits.
+type ESTotal struct {
+	Value    int    `json:"value"`
+	Relation string `json:"relation"`
+}
+
+// ESHit is a result.
+type ESHit struct {
+	Index  string                 `json:"_index"`
+	ID     string                 `json:"_id"`
+	Score  *float64               `json:"_score"`
+	Source map[string]interface{} `json:"_source,omitempty"`
+	Sort   []interface{}          `json:"sort,omitempty"`
+}
+
+// esError is the Elasticsearch form of an error response.
+type esError struct {
+	Error struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+	Status int `json:"status"`
+}
+
+// HandleESSearch handles GET and POST /{index}/_search with an ESSearchRequest body, easing
+// the migration of Elasticsearch clients: the index names the collection, the query is
+// searched as is without query understanding, and the response has the Elasticsearch
+// layout. /_search searches the default collection. The q, from and size query parameters
+// are also accepted, q being matched against every field.
+func (h *Handler) HandleESSearch(w http.ResponseWriter, r *http.Request) {
+	index := r.PathValue("index")
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeESError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET and POST are allowed")
+		return
+	}
+	var req ESSearchRequest
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeESError(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+	}
+	opts, err := esSearchOptions(r, index, req)
+	if err != nil {
+		writeESError(w, http.StatusBadRequest, "parsing_exception", err.Error())
+		return
+	}
+
+	resp, err := h.broker().SearchWithOptions(r.Context(), RawQuery(strings.Join(opts.Query.keywords(), " ")), opts)
+	if err != nil {
+		status, message := searchErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Broker search failed", "error", err)
+		}
+		writeESError(w, status, "search_phase_execution_exception", message)
+		return
+	}
+	byScore := len(opts.Sort) == 0 || opts.Sort[0].Field == "_score"
+	writeJSON(w, "application/json", newESSearchResponse(index, resp, byScore, req.Source))
+}
+
+// esSearchOptions translates an Elasticsearch search into search options.
+func esSearchOptions(r *http.Request, index string, req ESSearchRequest) (SearchOptions, error) {
+	params := r.URL.Query()
+	opts := SearchOptions{Collection: index, Size: defaultPageSize, Fields: []string{AllFields}}
+	tenantID, err := tenant.FromRequest(r)
+	if err != nil {
+		return opts, err
+	}
+	opts.Tenant = tenantID
+	opts.ClientID = r.Header.Get("X-Client-ID")
+	opts.UserID = r.Header.Get("X-User-ID")
+
+	from, size := req.From, req.Size
+	if err := intParam(params.Get("from"), "from", &from); err != nil {
+		return opts, err
+	}
+	if err := intParam(params.Get("size"), "size", &size); err != nil {
+		return opts, err
+	}
+	if from != nil {
+		if *from < 0 {
+			return opts, fmt.Errorf("invalid from %d, must not be negative", *from)
+		}
+		opts.From = *from
+	}
+	if size != nil {
+		if *size <= 0 || *size > maxPageSize {
+			return opts, fmt.Errorf("invalid size %d, must be between 1 and %d", *size, maxPageSize)
+		}
+		opts.Size = *size
+	}
+	if req.Timeout != "" {
+		v, err := time.ParseDuration(req.Timeout)
+		if err != nil || v <= 0 {
+			return opts, fmt.Errorf("invalid timeout %q, expected a positive duration such as 500ms", req.Timeout)
+		}
+		opts.Timeout = v
+	}
+	if opts.Sort, err = esSort(req.Sort); err != nil {
+		return opts, err
+	}
+	if opts.Fields, err = esSourceFields(req.Source); err != nil {
+		return opts, err
+	}
+	if req.KNN != nil {
+		if err := req.KNN.validate(); err != nil {
+			return opts, err
+		}
+		opts.KNN = req.KNN
+	}
+
+	t := &esTranslator{}
+	var root *QueryNode
+	switch {
+	case len(req.Query) > 0 && params.Get("q") != "":
+		return opts, fmt.Errorf("the q parameter and a query body are mutually exclusive")
+	case len(req.Query) > 0:
+		if root, err = t.node(req.Query, esMust, 0); err != nil {
+			return opts, err
+		}
+	case params.Get("q") != "":
+		root = &QueryNode{Type: NodeTerm, Text: params.Get("q")}
+	}
+	if root == nil {
+		root = &QueryNode{Type: NodeMatchAll}
+	}
+	opts.Query = root
+	opts.Filters = t.filters
+	opts.Fuzziness = t.fuzziness
+	return opts, nil
+}
+
+// intParam parses the integer query parameter called name into dst unless raw is empty.
+func intParam(raw, name string, dst **int) error {
+	if raw == "" {
+		return nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s parameter %q", name, raw)
+	}
+	*dst = &v
+	return nil
+}
+
+// esSort translates an Elasticsearch sort.
+func esSort(clauses []json.RawMessage) ([]SortField, error) {
+	var fields []SortField
+	for _, raw := range clauses {
+		var name string
+		if err := json.Unmarshal(raw, &name); err == nil {
+			fields = append(fields, SortField{Field: name, Desc: name == "_score"})
+			continue
+		}
+		var clause map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &clause); err != nil || len(clause) != 1 {
+			return nil, fmt.Errorf("invalid sort clause %s", raw)
+		}
+		for field, spec := range clause {
+			var order string
+			if err := json.Unmarshal(spec, &order); err != nil {
+				var options struct {
+					Order string `json:"order"`
+				}
+				if err := json.Unmarshal(spec, &options); err != nil {
+					return nil, fmt.Errorf("invalid sort clause %s", raw)
+				}
+				order = options.Order
+			}
+			switch order {
+			case "":
+				fields = append(fields, SortField{Field: field, Desc: field == "_score"})
+			case "asc", "desc":
+				fields = append(fields, SortField{Field: field, Desc: order == "desc"})
+			default:
+				return nil, fmt.Errorf("invalid sort order %q of %s", order, field)
+			}
+		}
+	}
+	return fields, nil
+}
+
+// esSourceFields translates _source into the stored fields returned with the results.
+func esSourceFields(source json.RawMessage) ([]string, error) {
+	if len(source) == 0 {
+		return []string{AllFields}, nil
+	}
+	var enabled bool
+	if err := json.Unmarshal(source, &enabled); err == nil {
+		if enabled {
+			return []string{AllFields}, nil
+		}
+		return nil, nil
+	}
+	var field string
+	if err := json.Unmarshal(source, &field); err == nil {
+		return []string{field}, nil
+	}
+	var fields []string
+	if err := json.Unmarshal(source, &fields); err != nil {
+		return nil, fmt.Errorf("invalid _source %s, expected a boolean or field names", source)
+	}
+	return fields, nil
+}
+
+// esTranslator translates Elasticsearch queries into a query tree and filters.
+type esTranslator struct {
+	filters   []Filter
+	fuzziness int
+}
+
+// esContext is the context a query clause is translated in.
+type esContext int
+
+const (
+	esShould esContext = iota // Results may not match the clause, e.g. should clauses
+	esMust                    // Every result matches the clause, which scores them
+	esFilter                  // Every result matches the clause, which doesn't score them
+)
+
+// node translates a query clause. Term clauses of a filter context and range clauses of
+// a must or filter context, that every result must match, are turned into filters, in
+// which case node returns nil; range clauses are only supported there.
+func (t *esTranslator) node(raw json.RawMessage, context esContext, depth int) (*QueryNode, error) {
+	if depth > maxESQueryDepth {
+		return nil, fmt.Errorf("%w: query is nested deeper than %d levels", errESQuery, maxESQueryDepth)
+	}
+	var clause map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &clause); err != nil || len(clause) != 1 {
+		return nil, fmt.Errorf("%w: a query clause must be an object with a single key, got %s", errESQuery, raw)
+	}
+	for kind, body := range clause {
+		switch kind {
+		case "match_all":
+			return &QueryNode{Type: NodeMatchAll}, nil
+		case "match", "match_phrase":
+			return t.match(kind, body)
+		case "term", "terms":
+			return t.term(kind, body, context)
+		case "range":
+			if context == esShould {
+				return nil, fmt.Errorf("%w: range is only supported in must and filter clauses", errESQuery)
+			}
+			return nil, t.rangeFilter(body)
+		case "bool":
+			return t.boolean(body, context, depth)
+		default:
+			return nil, fmt.Errorf("%w: %s", errESQuery, kind)
+		}
+	}
+	return nil, nil
+}
+
+// fieldClause returns the field and body of a clause keyed by field name.
+func fieldClause(kind string, body json.RawMessage) (string, json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || len(fields) != 1 {
+		return "", nil, fmt.Errorf("%w: %s must name a single field", errESQuery, kind)
+	}
+	for field, value := range fields {
+		return field, value, nil
+	}
+	return "", nil, nil
+}
+
+// match translates match and match_phrase clauses.
+func (t *esTranslator) match(kind string, body json.RawMessage) (*QueryNode, error) {
+	field, value, err := fieldClause(kind, body)
+	if err != nil {
+		return nil, err
+	}
+	var options struct {
+		Query     json.RawMessage `json:"query"`
+		Operator  string          `json:"operator"`
+		Fuzziness json.RawMessage `json:"fuzziness"`
+	}
+	if err := json.Unmarshal(value, &options); err != nil {
+		options.Query = value // The short form {"field": "text"}
+	}
+	text, err := scalarText(options.Query)
+	if err != nil || text == "" {
+		return nil, fmt.Errorf("%w: %s on %s requires a query", errESQuery, kind, field)
+	}
+	if kind == "match_phrase" {
+		return &QueryNode{Type: NodePhrase, Field: field, Text: text}, nil
+	}
+	if len(options.Fuzziness) > 0 {
+		if err := t.setFuzziness(options.Fuzziness); err != nil {
+			return nil, err
+		}
+	}
+	switch strings.ToLower(options.Operator) {
+	case "", "or":
+		return &QueryNode{Type: NodeTerm, Field: field, Text: text}, nil
+	case "and":
+		node := &QueryNode{Type: NodeBool}
+		for _, word := range strings.Fields(text) {
+			node.Must = append(node.Must, &QueryNode{Type: NodeTerm, Field: field, Text: word})
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%w: operator %q", errESQuery, options.Operator)
+	}
+}
+
+// setFuzziness raises the fuzziness of the search to that of a match clause: an edit
+// distance, or AUTO for 1.
+func (t *esTranslator) setFuzziness(raw json.RawMessage) error {
+	text, err := scalarText(raw)
+	if err != nil {
+		return fmt.Errorf("%w: fuzziness %s", errESQuery, raw)
+	}
+	fuzziness := 1
+	if !strings.EqualFold(text, "auto") {
+		if fuzziness, err = strconv.Atoi(text); err != nil || fuzziness < 0 || fuzziness > MaxFuzziness {
+			return fmt.Errorf("%w: fuzziness %s, must be AUTO or between 0 and %d", errESQuery, text, MaxFuzziness)
+		}
+	}
+	if fuzziness > t.fuzziness {
+		t.fuzziness = fuzziness
+	}
+	return nil
+}
+
+// term translates term and terms clauses: filters of exact values in a filter context,
+// matches otherwise, which score the results of a must context.
+func (t *esTranslator) term(kind string, body json.RawMessage, context esContext) (*QueryNode, error) {
+	field, value, err := fieldClause(kind, body)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	if kind == "terms" {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(value, &raw); err != nil || len(raw) == 0 {
+			return nil, fmt.Errorf("%w: terms on %s requires a list of values", errESQuery, field)
+		}
+		for _, v := range raw {
+			text, err := scalarText(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: terms on %s: %v", errESQuery, field, err)
+			}
+			values = append(values, text)
+		}
+	} else {
+		var options struct {
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal(value, &options); err == nil {
+			value = options.Value
+		}
+		text, err := scalarText(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: term on %s: %v", errESQuery, field, err)
+		}
+		values = []string{text}
+	}
+	if context == esFilter && len(values) == 1 {
+		t.filters = append(t.filters, TermFilter(field, values[0]))
+		return nil, nil
+	}
+	if len(values) == 1 {
+		return &QueryNode{Type: NodeTerm, Field: field, Text: values[0]}, nil
+	}
+	node := &QueryNode{Type: NodeBool}
+	for _, v := range values {
+		node.Should = append(node.Should, &QueryNode{Type: NodeTerm, Field: field, Text: v})
+	}
+	return node, nil
+}
+
+// rangeFilter translates a range clause into a numeric range filter, or a date range
+// filter for string bounds; gt and lt are exclusive bounds.
+func (t *esTranslator) rangeFilter(body json.RawMessage) error {
+	field, value, err := fieldClause("range", body)
+	if err != nil {
+		return err
+	}
+	var bounds map[string]interface{}
+	if err := json.Unmarshal(value, &bounds); err != nil || len(bounds) == 0 {
+		return fmt.Errorf("%w: range on %s requires bounds", errESQuery, field)
+	}
+	numeric := Filter{Type: FilterRange, Field: field}
+	dates := Filter{Type: FilterDateRange, Field: field}
+	for name, bound := range bounds {
+		switch b := bound.(type) {
+		case float64:
+			switch name {
+			case "gt", "gte":
+				numeric.Min, numeric.ExclusiveMin = &b, name == "gt"
+			case "lt", "lte":
+				numeric.Max, numeric.ExclusiveMax = &b, name == "lt"
+			default:
+				return fmt.Errorf("%w: range option %s", errESQuery, name)
+			}
+		case string:
+			switch name {
+			case "gt", "gte":
+				dates.Start, dates.ExclusiveStart = b, name == "gt"
+			case "lt", "lte":
+				dates.End, dates.ExclusiveEnd = b, name == "lt"
+			default:
+				return fmt.Errorf("%w: range option %s", errESQuery, name)
+			}
+		default:
+			return fmt.Errorf("%w: range bound %s of %s must be a number or a date", errESQuery, name, field)
+		}
+	}
+	isNumeric := numeric.Min != nil || numeric.Max != nil
+	isDate := dates.Start != "" || dates.End != ""
+	switch {
+	case isNumeric && isDate:
+		return fmt.Errorf("%w: range on %s mixes numbers and dates", errESQuery, field)
+	case isNumeric:
+		t.filters = append(t.filters, numeric)
+	default:
+		t.filters = append(t.filters, dates)
+	}
+	return nil
+}
+
+// boolean translates a bool clause. Its must and filter clauses are translated in the
+// context of the bool, filter clauses in a filter context unless the bool is a should
+// clause; filter clauses that can't become filters are added to Must.
+func (t *esTranslator) boolean(body json.RawMessage, context esContext, depth int) (*QueryNode, error) {
+	var clauses map[string]json.RawMessage
+	if err := json.Unmarshal(body, &clauses); err != nil {
+		return nil, fmt.Errorf("%w: invalid bool clause %s", errESQuery, body)
+	}
+	node := &QueryNode{Type: NodeBool}
+	filtered := false
+	for kind, raw := range clauses {
+		var targets *[]*QueryNode
+		childContext := esShould
+		switch kind {
+		case "must":
+			targets, childContext = &node.Must, context
+		case "filter":
+			targets = &node.Must
+			if context != esShould {
+				childContext = esFilter
+			}
+		case "should":
+			targets = &node.Should
+		case "must_not":
+			targets = &node.MustNot
+		case "minimum_should_match", "boost":
+			continue // The defaults apply
+		default:
+			return nil, fmt.Errorf("%w: bool option %s", errESQuery, kind)
+		}
+		var children []json.RawMessage
+		if err := json.Unmarshal(raw, &children); err != nil {
+			children = []json.RawMessage{raw} // A single clause
+		}
+		for _, child := range children {
+			c, err := t.node(child, childContext, depth+1)
+			switch {
+			case err != nil:
+				return nil, err
+			case c != nil:
+				*targets = append(*targets, c)
+			case childContext == esShould:
+				return nil, fmt.Errorf("%w: empty %s clause", errESQuery, kind)
+			default:
+				filtered = true
+			}
+		}
+	}
+	switch {
+	case len(node.Must)+len(node.Should)+len(node.MustNot) == 0:
+		return nil, nil // Every clause became a filter
+	case filtered && len(node.Must) == 0 && len(node.Should) > 0:
+		// Should clauses only score the results of a bool whose required clauses all
+		// became filters.
+		node.Must = append(node.Must, &QueryNode{Type: NodeMatchAll})
+	}
+	return node, nil
+}
+
+// scalarText returns the text of a JSON string, number or boolean.
+func scalarText(raw json.RawMessage) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(s), nil
+	}
+	return "", fmt.Errorf("expected a string, number or boolean, got %s", raw)
+}
+
+// newESSearchResponse converts a search response. Scores are left out of searches sorted
+// by field (byScore false), and sources if _source is false. Shards return the hits up to
+// just past the page, so the total is a lower bound when more pages follow.
+func newESSearchResponse(index string, resp *SearchResponse, byScore bool, source json.RawMessage) *ESSearchResponse {
+	if index == "" {
+		index = resp.Collection
+	}
+	total := ESTotal{Value: resp.TotalHits, Relation: "eq"}
+	if resp.Pagination.HasMore {
+		total.Relation = "gte"
+	}
+	out := &ESSearchResponse{
+		Took:   resp.TookMs,
+		Shards: ESShards{Total: resp.Shards.Total, Successful: resp.Shards.Successful, Failed: resp.Shards.Failed},
+		Hits:   ESHits{Total: total, Hits: make([]ESHit, 0, len(resp.Results))},
+	}
+	withSource := string(source) != "false"
+	for _, r := range resp.Results {
+		hit := ESHit{Index: index, ID: r.ID, Sort: r.SortValues}
+		if byScore {
+			score := r.Score
+			hit.Score = &score
+			if out.Hits.MaxScore == nil || score > *out.Hits.MaxScore {
+				out.Hits.MaxScore = &score
+			}
+		}
+		if withSource {
+			hit.Source = r.Fields
+			if hit.Source == nil {
+				hit.Source = map[string]interface{}{}
+			}
+		}
+		out.Hits.Hits = append(out.Hits.Hits, hit)
+	}
+	return out
+}
+
+// writeESError writes an error in the Elasticsearch layout.
+func writeESError(w http.ResponseWriter, status int, errType, reason string) {
+	var e esError
+	e.Error.Type = errType
+	e.Error.Reason = reason
+	e.Status = status
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		slog.Warn("Failed to encode response", "error", err)
+	}
+}
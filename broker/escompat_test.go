@@ -0,0 +1,221 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandleESSearch(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(context.Context, RawQuery) (StructuredQuery, error) {
+			t.Error("Expected query understanding to be skipped")
+			return StructuredQuery{}, nil
+		},
+	}
+	var searched StructuredQuery
+	searcher := &MockCollectionSearcher{
+		MockSearcher: MockSearcher{
+			SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+				searched = query
+				return []SearchResult{
+					{ID: "a", Score: 2, Fields: map[string]interface{}{"title": "Red boots"}},
+					{ID: "b", Score: 1, Fields: map[string]interface{}{"title": "Red hat"}},
+				}, nil
+			},
+		},
+		Collection: "products",
+	}
+	h := NewHandler(NewBroker(mockQU, []Searcher{searcher}))
+
+	body := `{
+		"query": {"bool": {
+			"must": [{"match": {"title": {"query": "red boots", "operator": "and"}}}],
+			"should": {"match_phrase": {"description": "waterproof leather"}},
+			"must_not": [{"term": {"color": "blue"}}],
+			"filter": [{"term": {"brand": "acme"}}, {"range": {"price": {"gte": 10, "lt": 100}}}, {"terms": {"size": [42, 43]}}]
+		}},
+		"from": 0, "size": 5,
+		"sort": ["_score", {"price": {"order": "asc"}}],
+		"_source": ["title"]
+	}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/products/_search", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	min, max := 10.0, 100.0
+	wantFilters := []Filter{TermFilter("brand", "acme"), {Type: FilterRange, Field: "price", Min: &min, Max: &max, ExclusiveMax: true}}
+	if !reflect.DeepEqual(searched.Filters, wantFilters) {
+		t.Errorf("Expected filters %+v, got %+v", wantFilters, searched.Filters)
+	}
+	tree, _ := json.Marshal(searched.Query)
+	for _, want := range []string{
+		`{"type":"term","field":"title","text":"red"}`,
+		`{"type":"phrase","field":"description","text":"waterproof leather"}`,
+		`"must_not":[{"type":"term","field":"color","text":"blue"}]`,
+		`"should":[{"type":"term","field":"size","text":"42"},{"type":"term","field":"size","text":"43"}]`,
+	} {
+		if !strings.Contains(string(tree), want) {
+			t.Errorf("Expected the query tree to contain %s, got %s", want, tree)
+		}
+	}
+	if searched.Collection != "products" || len(searched.Sort) != 2 || !searched.Sort[0].Desc || searched.Sort[1] != (SortField{Field: "price"}) {
+		t.Errorf("Unexpected structured query %+v", searched)
+	}
+	if len(searched.Fields) != 1 || searched.Fields[0] != "title" {
+		t.Errorf("Expected the _source fields, got %v", searched.Fields)
+	}
+
+	var resp ESSearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Hits.Total.Value != 2 || len(resp.Hits.Hits) != 2 || *resp.Hits.MaxScore != 2 {
+		t.Fatalf("Unexpected hits %+v", resp.Hits)
+	}
+	if hit := resp.Hits.Hits[0]; hit.Index != "products" || hit.ID != "a" || *hit.Score != 2 || hit.Source["title"] != "Red boots" {
+		t.Errorf("Unexpected hit %+v", hit)
+	}
+}
+
+func TestHandleESSearch_MatchAllAndErrors(t *testing.T) {
+	var searched StructuredQuery
+	searcher := &MockSearcher{
+		SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+			searched = query
+			return nil, nil
+		},
+	}
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{searcher}))
+	search := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := search(http.MethodGet, "/_search?size=3", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if searched.Query == nil || searched.Query.Type != NodeMatchAll || searched.Collection != DefaultCollection {
+		t.Errorf("Expected a match_all search of the default collection, got %+v", searched)
+	}
+	if rec := search(http.MethodPost, "/_search", `{"query": {"bool": {"filter": {"term": {"brand": "acme"}}}}}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if searched.Query.Type != NodeMatchAll || len(searched.Filters) != 1 {
+		t.Errorf("Expected a filtered match_all search, got %+v", searched)
+	}
+
+	for _, body := range []string{
+		`{"query": {"fuzzy": {"title": "bots"}}}`,
+		`{"query": {"bool": {"should": [{"range": {"price": {"gte": 1}}}]}}}`,
+		`{"query": {"match": {"title": "a", "brand": "b"}}}`,
+		`{"size": 1000}`,
+		`{"sort": [{"price": "up"}]}`,
+		`{"query": `,
+	} {
+		rec := search(http.MethodPost, "/products/_search", body)
+		var e esError
+		json.NewDecoder(rec.Body).Decode(&e)
+		if rec.Code != http.StatusBadRequest || e.Status != http.StatusBadRequest || e.Error.Reason == "" {
+			t.Errorf("%s: expected a 400 error, got %d %+v", body, rec.Code, e)
+		}
+	}
+	if rec := search(http.MethodGet, "/products/_search", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown collection, got %d", rec.Code)
+	}
+	if rec := search(http.MethodGet, "/products/_doc/1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for other paths, got %d", rec.Code)
+	}
+}
+
+func TestHandleESSearch_MustAndExclusiveBounds(t *testing.T) {
+	var searched StructuredQuery
+	searcher := &MockSearcher{
+		SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+			searched = query
+			return nil, nil
+		},
+	}
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{searcher}))
+
+	// The must term scores the results, leaving the should clause optional as in
+	// Elasticsearch; the ranges are filters with exclusive gt and lt bounds.
+	body := `{"query": {"bool": {
+		"must": {"term": {"brand": "acme"}},
+		"should": {"match": {"title": "boots"}},
+		"filter": [{"range": {"released": {"gt": "2024-01-01T00:00:00Z", "lte": "2024-06-01T00:00:00Z"}}}, {"range": {"price": {"gt": 10, "lt": 100}}}]
+	}}}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_search", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if q := searched.Query; q == nil || len(q.Must) != 1 || !reflect.DeepEqual(q.Must[0], &QueryNode{Type: NodeTerm, Field: "brand", Text: "acme"}) || len(q.Should) != 1 {
+		t.Errorf("Expected the must term and the should match in the query tree, got %+v", q)
+	}
+	min, max := 10.0, 100.0
+	wantFilters := []Filter{
+		{Type: FilterDateRange, Field: "released", Start: "2024-01-01T00:00:00Z", End: "2024-06-01T00:00:00Z", ExclusiveStart: true},
+		{Type: FilterRange, Field: "price", Min: &min, Max: &max, ExclusiveMin: true, ExclusiveMax: true},
+	}
+	if !reflect.DeepEqual(searched.Filters, wantFilters) {
+		t.Errorf("Expected filters %+v, got %+v", wantFilters, searched.Filters)
+	}
+
+	// Only search paths are routed to Elasticsearch searches.
+	for _, target := range []string{"/products", "/products/_search/more", "/unknown/path"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "error") {
+			t.Errorf("%s: expected a plain 404, got %d %s", target, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestHandleESSearch_FromAndSize(t *testing.T) {
+	searcher := &MockSearcher{
+		SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+			size := query.Size
+			if size == 0 {
+				size = 10 // The searchers' default
+			}
+			var results []SearchResult
+			for n := 0; n < min(size, 100); n++ {
+				results = append(results, SearchResult{ID: fmt.Sprintf("doc%02d", n), Score: float64(100 - n)})
+			}
+			return results, nil
+		},
+	}
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{searcher}))
+
+	for _, tt := range []struct {
+		target, body string
+		first        string
+		hits         int
+		relation     string
+	}{
+		{"/_search", `{"from": 20, "size": 50}`, "doc20", 50, "gte"},
+		{"/_search?from=90&size=20", "", "doc90", 10, "eq"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body)))
+		var resp ESSearchResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: expected 200, got %d (%v)", tt.target, tt.body, rec.Code, err)
+		}
+		if len(resp.Hits.Hits) != tt.hits || resp.Hits.Hits[0].ID != tt.first {
+			t.Errorf("%s %s: expected %d hits from %s, got %+v", tt.target, tt.body, tt.hits, tt.first, resp.Hits.Hits)
+		}
+		if resp.Hits.Total.Relation != tt.relation {
+			t.Errorf("%s %s: expected a total relation %q, got %+v", tt.target, tt.body, tt.relation, resp.Hits.Total)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Command eval scores a search endpoint, or compares two, on a golden set of judged
+// queries:
+//
+//	eval -golden queries.yaml -target http://localhost:8080/search
+//	eval -golden queries.yaml -target http://old:8080/search -compare http://new:8080/search
+//
+// Targets are /search endpoints of brokers or searchers; their query parameters, e.g.
+// ?collection=products, are sent with every query.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"broker/eval"
+)
+
+func main() {
+	golden := flag.String("golden", "", "Golden set of judged queries (YAML or JSON)")
+	target := flag.String("target", "", "Search endpoint to evaluate, the baseline when comparing")
+	compare := flag.String("compare", "", "Search endpoint to compare with the target")
+	tenantID := flag.String("tenant", "", "Tenant the queries are sent for")
+	k := flag.Int("k", eval.DefaultK, "Rank cutoff of the metrics")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout of each search")
+	format := flag.String("format", "text", "Report format: text or json")
+	flag.Parse()
+
+	if *golden == "" || *target == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown report format %q", *format)
+	}
+	set, err := eval.LoadGoldenSet(*golden)
+	if err != nil {
+		log.Fatalf("Failed to load golden set: %v", err)
+	}
+
+	ctx := context.Background()
+	baseline := evaluate(ctx, set, *target, *tenantID, *timeout, *k)
+	if *compare == "" {
+		write(*format, baseline, baseline.WriteText)
+		return
+	}
+	candidate := evaluate(ctx, set, *compare, *tenantID, *timeout, *k)
+	comparison := eval.Compare(baseline, candidate)
+	write(*format, comparison, comparison.WriteText)
+}
+
+// evaluate runs the golden set on the target at rawURL.
+func evaluate(ctx context.Context, set *eval.GoldenSet, rawURL, tenantID string, timeout time.Duration, k int) *eval.Report {
+	target, err := eval.NewHTTPTarget(rawURL, tenantID, timeout)
+	if err != nil {
+		log.Fatalf("Invalid target: %v", err)
+	}
+	report := eval.Evaluate(ctx, rawURL, set, target, k)
+	if report.Failed > 0 {
+		log.Printf("%d of %d queries failed on %s", report.Failed, len(report.Queries), rawURL)
+	}
+	return report
+}
+
+// write writes v to stdout in the given format, using writeText for the text one.
+func write(format string, v interface{}, writeText func(io.Writer) error) {
+	var err error
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(v)
+	} else {
+		err = writeText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
@@ -0,0 +1,314 @@
+// Package eval measures search relevance offline: it runs a golden set of queries with
+// judged documents against a search endpoint and scores the rankings it returns.
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"common/tenant"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultK is the rank cutoff of the metrics unless another one is given.
+const DefaultK = 10
+
+// ErrInvalidGoldenSet is returned for golden sets that can't be evaluated.
+var ErrInvalidGoldenSet = errors.New("invalid golden set")
+
+// GoldenQuery is a query with the documents judged relevant to it. Judgments are graded:
+// 0 is irrelevant, higher grades are more relevant. Unjudged documents count as irrelevant.
+type GoldenQuery struct {
+	Query     string             `yaml:"query" json:"query"`
+	Params    map[string]string  `yaml:"params" json:"params,omitempty"` // Extra search parameters, e.g. a collection
+	Judgments map[string]float64 `yaml:"judgments" json:"judgments"`     // Grade by document ID
+}
+
+// GoldenSet is a set of judged queries.
+type GoldenSet struct {
+	Queries []GoldenQuery `yaml:"queries" json:"queries"`
+}
+
+// LoadGoldenSet reads a golden set from a YAML file, or a JSON one since JSON is YAML:
+//
+//	queries:
+//	  - query: red shoes
+//	    params: {collection: products}
+//	    judgments: {shoe-1: 3, shoe-7: 1}
+func LoadGoldenSet(path string) (*GoldenSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read golden set: %w", err)
+	}
+	var set GoldenSet
+	if err := yaml.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse golden set %s: %w", path, err)
+	}
+	if err := set.Validate(); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// Validate checks that every query has a text and at least one relevant document.
+func (s *GoldenSet) Validate() error {
+	if len(s.Queries) == 0 {
+		return fmt.Errorf("%w: no queries", ErrInvalidGoldenSet)
+	}
+	for n, q := range s.Queries {
+		if q.Query == "" {
+			return fmt.Errorf("%w: query %d has no text", ErrInvalidGoldenSet, n)
+		}
+		relevant := false
+		for id, grade := range q.Judgments {
+			if grade < 0 {
+				return fmt.Errorf("%w: query %q judges %q with a negative grade", ErrInvalidGoldenSet, q.Query, id)
+			}
+			relevant = relevant || grade > 0
+		}
+		if !relevant {
+			return fmt.Errorf("%w: query %q has no relevant document", ErrInvalidGoldenSet, q.Query)
+		}
+	}
+	return nil
+}
+
+// Metrics are the relevance metrics of a ranking, or their means over a golden set.
+type Metrics struct {
+	NDCG      float64 `json:"ndcg"`
+	MRR       float64 `json:"mrr"`
+	Precision float64 `json:"precision"`
+}
+
+// Score computes the metrics of the ranked document IDs at rank cutoff k.
+func Score(ranked []string, judgments map[string]float64, k int) Metrics {
+	if len(ranked) > k {
+		ranked = ranked[:k]
+	}
+	return Metrics{
+		NDCG:      ndcg(ranked, judgments, k),
+		MRR:       reciprocalRank(ranked, judgments),
+		Precision: precision(ranked, judgments, k),
+	}
+}
+
+// gain is the exponential gain of a graded judgment, rewarding highly relevant documents.
+func gain(grade float64) float64 {
+	return math.Pow(2, grade) - 1
+}
+
+// ndcg is the discounted cumulative gain of ranked, normalized by the one of the ideal
+// ranking of the judged documents.
+func ndcg(ranked []string, judgments map[string]float64, k int) float64 {
+	var dcg float64
+	for i, id := range ranked {
+		dcg += gain(judgments[id]) / math.Log2(float64(i+2))
+	}
+	grades := make([]float64, 0, len(judgments))
+	for _, grade := range judgments {
+		grades = append(grades, grade)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(grades)))
+	var ideal float64
+	for i := 0; i < len(grades) && i < k; i++ {
+		ideal += gain(grades[i]) / math.Log2(float64(i+2))
+	}
+	if ideal == 0 {
+		return 0
+	}
+	return dcg / ideal
+}
+
+// reciprocalRank is 1/rank of the first relevant document, or 0 if none was returned.
+func reciprocalRank(ranked []string, judgments map[string]float64) float64 {
+	for i, id := range ranked {
+		if judgments[id] > 0 {
+			return 1 / float64(i+1)
+		}
+	}
+	return 0
+}
+
+// precision is the share of relevant documents among the first k.
+func precision(ranked []string, judgments map[string]float64, k int) float64 {
+	relevant := 0
+	for _, id := range ranked {
+		if judgments[id] > 0 {
+			relevant++
+		}
+	}
+	return float64(relevant) / float64(k)
+}
+
+// Target runs a query and returns the IDs of its results, best first.
+type Target interface {
+	Search(ctx context.Context, query GoldenQuery, size int) ([]string, error)
+}
+
+// HTTPTarget is a Target querying a /search endpoint: the broker's, or a searcher's to
+// evaluate a shard without query understanding and reranking. Both return their hits in
+// a "results" array of objects with an "id".
+type HTTPTarget struct {
+	endpoint *url.URL
+	tenant   string
+	client   *http.Client
+}
+
+// NewHTTPTarget creates a target for the search endpoint at rawURL, e.g.
+// http://localhost:8080/search. Query parameters of rawURL, such as a collection, are
+// sent with every query; those of a golden query override them. tenantID, if set, is
+// sent in the tenant header.
+func NewHTTPTarget(rawURL, tenantID string, timeout time.Duration) (*HTTPTarget, error) {
+	endpoint, err := url.Parse(rawURL)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid target URL %q", rawURL)
+	}
+	return &HTTPTarget{endpoint: endpoint, tenant: tenantID, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Search sends the query, asking for size results.
+func (t *HTTPTarget) Search(ctx context.Context, query GoldenQuery, size int) ([]string, error) {
+	u := *t.endpoint
+	params := u.Query()
+	if params.Get("size") == "" {
+		params.Set("size", strconv.Itoa(size))
+	}
+	for name, value := range query.Params {
+		params.Set(name, value)
+	}
+	params.Set("q", query.Query)
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create search request: %w", err)
+	}
+	if t.tenant != "" {
+		req.Header.Set(tenant.Header, t.tenant)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("search request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search request failed with status %s", resp.Status)
+	}
+	var body struct {
+		Results []struct {
+			ID string `json:"id"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode search response: %w", err)
+	}
+	ids := make([]string, 0, len(body.Results))
+	for _, hit := range body.Results {
+		ids = append(ids, hit.ID)
+	}
+	return ids, nil
+}
+
+// QueryReport is the outcome of a golden query.
+type QueryReport struct {
+	Query   string   `json:"query"`
+	Metrics Metrics  `json:"metrics"`
+	Results []string `json:"results"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Report is the outcome of a golden set on a target.
+type Report struct {
+	Name    string        `json:"name"`
+	K       int           `json:"k"`
+	Mean    Metrics       `json:"mean"`   // Over all queries; failed ones score 0
+	Failed  int           `json:"failed"` // Queries whose search failed
+	Queries []QueryReport `json:"queries"`
+}
+
+// Evaluate runs every query of the golden set on the target and scores its results at
+// rank cutoff k. Failed searches are reported and score 0, so a broken configuration
+// can't look better than a working one.
+func Evaluate(ctx context.Context, name string, set *GoldenSet, target Target, k int) *Report {
+	if k <= 0 {
+		k = DefaultK
+	}
+	report := &Report{Name: name, K: k, Queries: make([]QueryReport, 0, len(set.Queries))}
+	for _, q := range set.Queries {
+		qr := QueryReport{Query: q.Query}
+		ids, err := target.Search(ctx, q, k)
+		if err != nil {
+			qr.Error = err.Error()
+			report.Failed++
+		} else {
+			qr.Results = ids
+			qr.Metrics = Score(ids, q.Judgments, k)
+		}
+		report.Mean.NDCG += qr.Metrics.NDCG
+		report.Mean.MRR += qr.Metrics.MRR
+		report.Mean.Precision += qr.Metrics.Precision
+		report.Queries = append(report.Queries, qr)
+	}
+	if n := float64(len(report.Queries)); n > 0 {
+		report.Mean.NDCG /= n
+		report.Mean.MRR /= n
+		report.Mean.Precision /= n
+	}
+	return report
+}
+
+// QueryDelta compares a golden query between two reports.
+type QueryDelta struct {
+	Query string  `json:"query"`
+	NDCG  float64 `json:"ndcg"` // Candidate minus baseline
+}
+
+// Comparison compares the reports of a golden set on a baseline and a candidate.
+type Comparison struct {
+	Baseline  *Report      `json:"baseline"`
+	Candidate *Report      `json:"candidate"`
+	Delta     Metrics      `json:"delta"`    // Candidate minus baseline means
+	Improved  []QueryDelta `json:"improved"` // By decreasing NDCG gain
+	Regressed []QueryDelta `json:"regressed"`
+}
+
+// Compare compares two reports of the same golden set.
+func Compare(baseline, candidate *Report) *Comparison {
+	c := &Comparison{
+		Baseline:  baseline,
+		Candidate: candidate,
+		Delta: Metrics{
+			NDCG:      candidate.Mean.NDCG - baseline.Mean.NDCG,
+			MRR:       candidate.Mean.MRR - baseline.Mean.MRR,
+			Precision: candidate.Mean.Precision - baseline.Mean.Precision,
+		},
+	}
+	for n := range baseline.Queries {
+		if n >= len(candidate.Queries) {
+			break
+		}
+		delta := QueryDelta{
+			Query: baseline.Queries[n].Query,
+			NDCG:  candidate.Queries[n].Metrics.NDCG - baseline.Queries[n].Metrics.NDCG,
+		}
+		switch {
+		case delta.NDCG > 0:
+			c.Improved = append(c.Improved, delta)
+		case delta.NDCG < 0:
+			c.Regressed = append(c.Regressed, delta)
+		}
+	}
+	sort.SliceStable(c.Improved, func(i, j int) bool { return c.Improved[i].NDCG > c.Improved[j].NDCG })
+	sort.SliceStable(c.Regressed, func(i, j int) bool { return c.Regressed[i].NDCG < c.Regressed[j].NDCG })
+	return c
+}
@@ -0,0 +1,143 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestScore(t *testing.T) {
+	judgments := map[string]float64{"a": 3, "b": 1, "c": 0}
+
+	perfect := Score([]string{"a", "b", "x"}, judgments, 3)
+	if !approx(perfect.NDCG, 1) || perfect.MRR != 1 || !approx(perfect.Precision, 2.0/3) {
+		t.Errorf("Unexpected metrics of the ideal ranking: %+v", perfect)
+	}
+
+	swapped := Score([]string{"c", "b", "a"}, judgments, 3)
+	dcg := 1/math.Log2(3) + 7/math.Log2(4)
+	ideal := 7 + 1/math.Log2(3)
+	if !approx(swapped.NDCG, dcg/ideal) || swapped.MRR != 0.5 {
+		t.Errorf("Unexpected metrics of a swapped ranking: %+v", swapped)
+	}
+
+	if m := Score([]string{"x", "y"}, judgments, 10); m != (Metrics{}) {
+		t.Errorf("Expected zero metrics without relevant results, got %+v", m)
+	}
+	if m := Score([]string{"x", "a"}, judgments, 1); m != (Metrics{}) {
+		t.Errorf("Expected results past k to be ignored, got %+v", m)
+	}
+}
+
+func TestLoadGoldenSet(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "golden.yaml")
+	data := "queries:\n  - query: red shoes\n    params: {collection: products}\n    judgments: {a: 2, b: 1}\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set, err := LoadGoldenSet(path)
+	if err != nil {
+		t.Fatalf("LoadGoldenSet returned an error: %v", err)
+	}
+	if len(set.Queries) != 1 || set.Queries[0].Params["collection"] != "products" || set.Queries[0].Judgments["a"] != 2 {
+		t.Errorf("Unexpected golden set: %+v", set)
+	}
+
+	for _, invalid := range []string{
+		"queries: []\n",
+		"queries:\n  - judgments: {a: 1}\n",
+		"queries:\n  - query: q\n    judgments: {a: 0}\n",
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadGoldenSet(path); !errors.Is(err, ErrInvalidGoldenSet) {
+			t.Errorf("Expected ErrInvalidGoldenSet for %q, got %v", invalid, err)
+		}
+	}
+}
+
+// newSearchServer serves /search with the given ranking per query, failing other queries.
+func newSearchServer(t *testing.T, rankings map[string][]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("collection") != "products" || r.URL.Query().Get("size") != "3" {
+			http.Error(w, "unexpected parameters", http.StatusBadRequest)
+			return
+		}
+		ids, ok := rankings[r.URL.Query().Get("q")]
+		if !ok {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		var body struct {
+			Results []map[string]string `json:"results"`
+		}
+		for _, id := range ids {
+			body.Results = append(body.Results, map[string]string{"id": id})
+		}
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestEvaluate_Compare(t *testing.T) {
+	set := &GoldenSet{Queries: []GoldenQuery{
+		{Query: "shoes", Judgments: map[string]float64{"a": 1}},
+		{Query: "boots", Params: map[string]string{"collection": "products"}, Judgments: map[string]float64{"b": 1}},
+		{Query: "hats", Judgments: map[string]float64{"c": 1}},
+	}}
+	old := newSearchServer(t, map[string][]string{"shoes": {"a", "x"}, "boots": {"x", "b"}})
+	updated := newSearchServer(t, map[string][]string{"shoes": {"x", "a"}, "boots": {"b"}, "hats": {"c"}})
+
+	evaluate := func(server *httptest.Server) *Report {
+		target, err := NewHTTPTarget(server.URL+"/search?collection=products", "", time.Second)
+		if err != nil {
+			t.Fatalf("NewHTTPTarget returned an error: %v", err)
+		}
+		return Evaluate(context.Background(), server.URL, set, target, 3)
+	}
+	baseline, candidate := evaluate(old), evaluate(updated)
+
+	if baseline.Failed != 1 || baseline.Queries[2].Error == "" {
+		t.Errorf("Expected the hats query to fail on the baseline, got %+v", baseline.Queries[2])
+	}
+	if !approx(baseline.Mean.MRR, 0.5) || !approx(candidate.Mean.MRR, 2.5/3) {
+		t.Errorf("Unexpected mean MRRs %v and %v", baseline.Mean.MRR, candidate.Mean.MRR)
+	}
+
+	c := Compare(baseline, candidate)
+	if !approx(c.Delta.MRR, 2.5/3-0.5) {
+		t.Errorf("Unexpected MRR delta %v", c.Delta.MRR)
+	}
+	if len(c.Improved) != 2 || c.Improved[0].Query != "hats" || len(c.Regressed) != 1 || c.Regressed[0].Query != "shoes" {
+		t.Errorf("Unexpected per-query deltas: improved %+v, regressed %+v", c.Improved, c.Regressed)
+	}
+
+	var out strings.Builder
+	if err := c.WriteText(&out); err != nil {
+		t.Fatalf("WriteText returned an error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Regressed (1 queries):") {
+		t.Errorf("Unexpected comparison report:\n%s", out.String())
+	}
+}
+
+func TestNewHTTPTarget_Invalid(t *testing.T) {
+	if _, err := NewHTTPTarget("localhost:8080", "", time.Second); err == nil {
+		t.Error("Expected an error for a URL without scheme")
+	}
+}
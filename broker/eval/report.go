@@ -0,0 +1,51 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// WriteText writes the report as a table with a row per query and the means.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s (k=%d)\n", r.Name, r.K)
+	fmt.Fprintf(tw, "QUERY\tNDCG@%d\tMRR\tP@%d\t\n", r.K, r.K)
+	for _, q := range r.Queries {
+		if q.Error != "" {
+			fmt.Fprintf(tw, "%s\tfailed: %s\t\t\t\n", q.Query, q.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%.4f\t%.4f\t%.4f\t\n", q.Query, q.Metrics.NDCG, q.Metrics.MRR, q.Metrics.Precision)
+	}
+	fmt.Fprintf(tw, "MEAN (%d queries, %d failed)\t%.4f\t%.4f\t%.4f\t\n", len(r.Queries), r.Failed, r.Mean.NDCG, r.Mean.MRR, r.Mean.Precision)
+	return tw.Flush()
+}
+
+// WriteText writes the comparison as a table of the means of both reports, followed by
+// the queries whose NDCG changed.
+func (c *Comparison) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	k := c.Baseline.K
+	fmt.Fprintf(tw, "\tNDCG@%d\tMRR\tP@%d\tFAILED\t\n", k, k)
+	for _, r := range []*Report{c.Baseline, c.Candidate} {
+		fmt.Fprintf(tw, "%s\t%.4f\t%.4f\t%.4f\t%d\t\n", r.Name, r.Mean.NDCG, r.Mean.MRR, r.Mean.Precision, r.Failed)
+	}
+	fmt.Fprintf(tw, "delta\t%+.4f\t%+.4f\t%+.4f\t\t\n", c.Delta.NDCG, c.Delta.MRR, c.Delta.Precision)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, section := range []struct {
+		title   string
+		queries []QueryDelta
+	}{{"Improved", c.Improved}, {"Regressed", c.Regressed}} {
+		if len(section.queries) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s (%d queries):\n", section.title, len(section.queries))
+		for _, q := range section.queries {
+			fmt.Fprintf(w, "  %+.4f  %s\n", q.NDCG, q.Query)
+		}
+	}
+	return nil
+}
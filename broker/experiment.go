@@ -0,0 +1,190 @@
+package broker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// Bucket assignment strategies of experiments.
+const (
+	// AssignByUser hashes the user ID of the request, so a user stays in the same bucket
+	// across searches. Requests without a user ID are assigned at random.
+	AssignByUser = "user"
+	// AssignRandom draws a bucket for every request.
+	AssignRandom = "random"
+)
+
+// ErrInvalidExperiment is returned for experiments that can't be run.
+var ErrInvalidExperiment = errors.New("invalid experiment")
+
+// ExperimentBucket is a variant of an experiment. Buckets without a pipeline or ranking
+// rules keep the broker's defaults, which makes them control buckets.
+type ExperimentBucket struct {
+	Name         string        `yaml:"name"`
+	Weight       int           `yaml:"weight"`        // Share of the traffic, relative to the other buckets
+	Pipeline     string        `yaml:"pipeline"`      // Query understanding pipeline; empty uses the default one
+	RankingRules []RankingRule `yaml:"ranking_rules"` // Replace the broker's ranking rules if set
+
+	reranker *Reranker
+}
+
+// Experiment splits searches between buckets using different query understanding
+// pipelines or ranking rules, so their outcomes can be compared in the query log.
+type Experiment struct {
+	Name       string             `yaml:"name"`
+	Assignment string             `yaml:"assignment"` // AssignByUser (default) or AssignRandom
+	Buckets    []ExperimentBucket `yaml:"buckets"`
+}
+
+// ExperimentAssignment records the bucket a search was assigned to in an experiment.
+type ExperimentAssignment struct {
+	Experiment string `json:"experiment"`
+	Bucket     string `json:"bucket"`
+}
+
+// Validate checks the experiment and compiles the ranking rules of its buckets.
+func (e *Experiment) Validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidExperiment)
+	}
+	switch e.Assignment {
+	case "":
+		e.Assignment = AssignByUser
+	case AssignByUser, AssignRandom:
+	default:
+		return fmt.Errorf("%w: experiment %q has unknown assignment %q", ErrInvalidExperiment, e.Name, e.Assignment)
+	}
+	if len(e.Buckets) < 2 {
+		return fmt.Errorf("%w: experiment %q needs at least two buckets", ErrInvalidExperiment, e.Name)
+	}
+	names := make(map[string]bool, len(e.Buckets))
+	for n := range e.Buckets {
+		bucket := &e.Buckets[n]
+		if bucket.Name == "" || names[bucket.Name] {
+			return fmt.Errorf("%w: buckets of experiment %q need distinct names", ErrInvalidExperiment, e.Name)
+		}
+		names[bucket.Name] = true
+		if bucket.Weight <= 0 {
+			return fmt.Errorf("%w: bucket %q of experiment %q needs a positive weight", ErrInvalidExperiment, bucket.Name, e.Name)
+		}
+		if len(bucket.RankingRules) > 0 {
+			reranker, err := NewReranker(bucket.RankingRules)
+			if err != nil {
+				return fmt.Errorf("bucket %q of experiment %q: %w", bucket.Name, e.Name, err)
+			}
+			bucket.reranker = reranker
+		}
+	}
+	return nil
+}
+
+// varies reports whether some bucket sets a pipeline, and whether some sets ranking rules.
+func (e *Experiment) varies() (pipeline, ranking bool) {
+	for _, bucket := range e.Buckets {
+		pipeline = pipeline || bucket.Pipeline != ""
+		ranking = ranking || bucket.reranker != nil
+	}
+	return pipeline, ranking
+}
+
+// assign returns the bucket of a search by the user with the given ID.
+func (e *Experiment) assign(userID string) *ExperimentBucket {
+	total := 0
+	for _, bucket := range e.Buckets {
+		total += bucket.Weight
+	}
+	var point int
+	if e.Assignment == AssignByUser && userID != "" {
+		// Salted so experiments split users independently; FNV's low bits mix too little
+		// for that, so a cryptographic hash is used.
+		sum := sha256.Sum256([]byte(e.Name + "/" + userID))
+		point = int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	} else {
+		point = rand.Intn(total)
+	}
+	for n := range e.Buckets {
+		point -= e.Buckets[n].Weight
+		if point < 0 {
+			return &e.Buckets[n]
+		}
+	}
+	return &e.Buckets[len(e.Buckets)-1]
+}
+
+// SetExperiments runs the given experiments on every search, replacing the current ones.
+// A search is assigned a bucket in each experiment, so at most one experiment may vary
+// the pipeline and one the ranking rules.
+func (b *Broker) SetExperiments(experiments []Experiment) error {
+	var pipelineBy, rankingBy string
+	names := make(map[string]bool, len(experiments))
+	for n := range experiments {
+		e := &experiments[n]
+		if err := e.Validate(); err != nil {
+			return err
+		}
+		if names[e.Name] {
+			return fmt.Errorf("%w: experiment %q is defined twice", ErrInvalidExperiment, e.Name)
+		}
+		names[e.Name] = true
+		pipeline, ranking := e.varies()
+		if pipeline && pipelineBy != "" {
+			return fmt.Errorf("%w: experiments %q and %q both vary the pipeline", ErrInvalidExperiment, pipelineBy, e.Name)
+		}
+		if ranking && rankingBy != "" {
+			return fmt.Errorf("%w: experiments %q and %q both vary the ranking rules", ErrInvalidExperiment, rankingBy, e.Name)
+		}
+		if pipeline {
+			pipelineBy = e.Name
+		}
+		if ranking {
+			rankingBy = e.Name
+		}
+	}
+	b.experiments.Store(&experiments)
+	return nil
+}
+
+// assignExperiments assigns a search to a bucket of every experiment and applies the
+// settings of the buckets to its options.
+func (b *Broker) assignExperiments(opts *SearchOptions) {
+	current := b.experiments.Load()
+	if current == nil || len(*current) == 0 || opts.experiments != nil {
+		return // Retries, e.g. of did-you-mean queries, keep their buckets
+	}
+	experiments := *current
+	opts.experiments = make([]ExperimentAssignment, 0, len(experiments))
+	for n := range experiments {
+		e := &experiments[n]
+		bucket := e.assign(opts.UserID)
+		opts.experiments = append(opts.experiments, ExperimentAssignment{Experiment: e.Name, Bucket: bucket.Name})
+		if bucket.Pipeline != "" {
+			opts.pipeline = bucket.Pipeline
+		}
+		if bucket.reranker != nil {
+			opts.reranker = bucket.reranker
+		}
+	}
+}
+
+// pipelineKey is the context key of the query understanding pipeline of a search.
+type pipelineKey struct{}
+
+// WithPipeline returns a copy of ctx asking the query understanding service to process
+// the query with the named pipeline; an empty name keeps the default pipeline.
+func WithPipeline(ctx context.Context, name string) context.Context {
+	if name == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, pipelineKey{}, name)
+}
+
+// PipelineFromContext returns the pipeline set by WithPipeline, or "" for the default one.
+// QueryUnderstandingService implementations pass it on to the service.
+func PipelineFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(pipelineKey{}).(string)
+	return name
+}
@@ -0,0 +1,169 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func testExperiments() []Experiment {
+	return []Experiment{
+		{Name: "qu", Buckets: []ExperimentBucket{
+			{Name: "control", Weight: 1},
+			{Name: "synonyms", Weight: 1, Pipeline: "synonyms_pipeline"},
+		}},
+		{Name: "ranking", Buckets: []ExperimentBucket{
+			{Name: "control", Weight: 1},
+			{Name: "pinned", Weight: 1, RankingRules: []RankingRule{
+				{Name: "pin-a", Type: RulePin, Queries: []string{"q"}, IDs: []string{"a"}},
+			}},
+		}},
+	}
+}
+
+func TestExperiment_Assign(t *testing.T) {
+	experiments := testExperiments()
+	e := &experiments[0]
+	if err := e.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %v", err)
+	}
+	if e.Assignment != AssignByUser {
+		t.Errorf("Expected the assignment to default to %q, got %q", AssignByUser, e.Assignment)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		bucket := e.assign(user)
+		if again := e.assign(user); again != bucket {
+			t.Fatalf("User %s was assigned %q, then %q", user, bucket.Name, again.Name)
+		}
+		counts[bucket.Name]++
+	}
+	if counts["control"] < 400 || counts["synonyms"] < 400 {
+		t.Errorf("Expected an even split of the users, got %v", counts)
+	}
+
+	e.Buckets[0].Weight = 9
+	counts = map[string]int{}
+	for i := 0; i < 1000; i++ {
+		counts[e.assign(fmt.Sprintf("user-%d", i)).Name]++
+	}
+	if counts["control"] < 850 {
+		t.Errorf("Expected about 90%% of the users in control, got %v", counts)
+	}
+}
+
+func TestBroker_SetExperiments_Invalid(t *testing.T) {
+	pipeline := func(name string) []ExperimentBucket {
+		return []ExperimentBucket{{Name: "a", Weight: 1}, {Name: "b", Weight: 1, Pipeline: name}}
+	}
+	for name, experiments := range map[string][]Experiment{
+		"missing name":       {{Buckets: pipeline("p")}},
+		"one bucket":         {{Name: "e", Buckets: []ExperimentBucket{{Name: "a", Weight: 1}}}},
+		"duplicate bucket":   {{Name: "e", Buckets: []ExperimentBucket{{Name: "a", Weight: 1}, {Name: "a", Weight: 1}}}},
+		"zero weight":        {{Name: "e", Buckets: []ExperimentBucket{{Name: "a", Weight: 1}, {Name: "b"}}}},
+		"unknown assignment": {{Name: "e", Assignment: "session", Buckets: pipeline("p")}},
+		"duplicate name":     {{Name: "e", Buckets: pipeline("p")}, {Name: "e", Buckets: pipeline("")}},
+		"both vary pipeline": {{Name: "e", Buckets: pipeline("p")}, {Name: "f", Buckets: pipeline("q")}},
+	} {
+		b := NewBroker(&MockQueryUnderstandingService{}, nil)
+		if err := b.SetExperiments(experiments); !errors.Is(err, ErrInvalidExperiment) {
+			t.Errorf("%s: expected ErrInvalidExperiment, got %v", name, err)
+		}
+	}
+
+	b := NewBroker(&MockQueryUnderstandingService{}, nil)
+	err := b.SetExperiments([]Experiment{{Name: "e", Buckets: []ExperimentBucket{
+		{Name: "a", Weight: 1},
+		{Name: "b", Weight: 1, RankingRules: []RankingRule{{Name: "broken", Type: RulePin}}},
+	}}})
+	if !errors.Is(err, ErrInvalidRankingRule) {
+		t.Errorf("Expected ErrInvalidRankingRule for invalid bucket rules, got %v", err)
+	}
+}
+
+func TestBroker_SetExperiments_Concurrent(t *testing.T) {
+	b := NewBroker(&MockQueryUnderstandingService{}, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 0; n < 100; n++ {
+			if err := b.SetExperiments(testExperiments()); err != nil {
+				t.Errorf("SetExperiments returned an error: %v", err)
+				return
+			}
+		}
+	}()
+	for n := 0; n < 100; n++ {
+		opts := SearchOptions{UserID: fmt.Sprintf("user%d", n)}
+		b.assignExperiments(&opts)
+		if len(opts.experiments) != 0 && len(opts.experiments) != 2 {
+			t.Fatalf("Expected the searches to see all the experiments or none, got %+v", opts.experiments)
+		}
+	}
+	<-done
+}
+
+func TestBroker_SearchWithOptions_Experiments(t *testing.T) {
+	var pipelines []string
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(ctx context.Context, _ RawQuery) (StructuredQuery, error) {
+			pipelines = append(pipelines, PipelineFromContext(ctx))
+			return StructuredQuery{}, nil
+		},
+	}
+	shard := &MockSearcher{
+		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			return []SearchResult{{ID: "a", Score: 0.5}, {ID: "b", Score: 0.9}}, nil
+		},
+	}
+	b := NewBroker(mockQU, []Searcher{shard})
+	if err := b.SetExperiments(testExperiments()); err != nil {
+		t.Fatalf("SetExperiments returned an error: %v", err)
+	}
+	sink := &recordingSink{}
+	logger := NewQueryLogger(sink)
+	b.SetQueryLogger(logger)
+
+	seen := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		pipelines = nil
+		user := fmt.Sprintf("user-%d", i)
+		resp, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{UserID: user})
+		if err != nil {
+			t.Fatalf("SearchWithOptions returned an error: %v", err)
+		}
+		if len(resp.Experiments) != 2 {
+			t.Fatalf("Expected assignments to both experiments, got %+v", resp.Experiments)
+		}
+		qu, ranking := resp.Experiments[0], resp.Experiments[1]
+		seen[qu.Bucket+"/"+ranking.Bucket] = true
+
+		wantPipeline := ""
+		if qu.Bucket == "synonyms" {
+			wantPipeline = "synonyms_pipeline"
+		}
+		if len(pipelines) != 1 || pipelines[0] != wantPipeline {
+			t.Errorf("%s in bucket %q: expected pipeline %q, got %v", user, qu.Bucket, wantPipeline, pipelines)
+		}
+		wantFirst := "b"
+		if ranking.Bucket == "pinned" {
+			wantFirst = "a"
+		}
+		if got := resultIDs(resp.Results); got[0] != wantFirst {
+			t.Errorf("%s in bucket %q: expected %s first, got %v", user, ranking.Bucket, wantFirst, got)
+		}
+	}
+	if len(seen) != 4 {
+		t.Errorf("Expected users in every combination of buckets, got %v", seen)
+	}
+
+	if err := logger.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+	if len(sink.records) != 50 || len(sink.records[0].Experiments) != 2 {
+		t.Errorf("Expected the query log to record the assignments, got %+v", sink.records[0])
+	}
+}
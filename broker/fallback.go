@@ -0,0 +1,169 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Relaxations of the fallback chain of searches without results, see SetFallbacks.
+const (
+	RelaxDropFilters  = "drop_filters"  // Search without the filters of the request and of query understanding
+	RelaxFuzzy        = "fuzzy"         // Match the terms within MaxFuzziness edits
+	RelaxAnyTerm      = "any_term"      // Turn the required clauses of the query tree into optional ones (AND→OR)
+	RelaxSpellCorrect = "spell_correct" // Search the query with the searchers' corrections of its terms
+)
+
+var relaxationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "broker_query_relaxations_total",
+	Help: "Searches without results retried with relaxed queries, by the last relaxation applied; exhausted when none found results",
+}, []string{"relaxation"})
+
+// QueryRelaxation reports how the query of a search without results was relaxed to find
+// the returned ones.
+type QueryRelaxation struct {
+	Applied        []string `json:"applied"`                   // Relaxations in effect, in the order applied
+	OriginalQuery  string   `json:"original_query"`            // The query searched first
+	CorrectedQuery string   `json:"corrected_query,omitempty"` // The query searched, if spell_correct was applied
+}
+
+// SetFallbacks sets the fallback chain of searches without results: the relaxations are
+// applied one at a time, each keeping the previous ones, until a search finds results or
+// the chain is exhausted. Relaxations that wouldn't change a query are skipped. nil
+// disables fallbacks.
+func (b *Broker) SetFallbacks(relaxations []string) error {
+	seen := make(map[string]bool, len(relaxations))
+	for _, r := range relaxations {
+		switch r {
+		case RelaxDropFilters, RelaxFuzzy, RelaxAnyTerm, RelaxSpellCorrect:
+		default:
+			return fmt.Errorf("unknown relaxation %q", r)
+		}
+		if seen[r] {
+			return fmt.Errorf("duplicate relaxation %q", r)
+		}
+		seen[r] = true
+	}
+	b.fallbacks = relaxations
+	return nil
+}
+
+// relax applies the relaxations of opts to query, the structured query of a search.
+func (opts SearchOptions) relax(query *StructuredQuery) {
+	for _, r := range opts.relaxations {
+		switch r {
+		case RelaxDropFilters:
+			query.Filters = nil
+		case RelaxFuzzy:
+			query.Fuzziness = MaxFuzziness
+		case RelaxAnyTerm:
+			query.Query = query.Query.anyTerm()
+		}
+	}
+}
+
+// relaxes reports whether relaxation changes query.
+func relaxes(relaxation string, query StructuredQuery) bool {
+	switch relaxation {
+	case RelaxDropFilters:
+		return len(query.Filters) > 0
+	case RelaxFuzzy:
+		return query.Fuzziness < MaxFuzziness
+	case RelaxAnyTerm:
+		return query.Query.hasRequired()
+	}
+	return true
+}
+
+// fallback retries a search without results along the fallback chain and returns the
+// first response with results, annotated with the relaxations applied, or resp if none
+// has any. Retries share the search's latency budget.
+func (b *Broker) fallback(ctx context.Context, rawQuery RawQuery, opts SearchOptions, query StructuredQuery, resp *SearchResponse, start time.Time) (*SearchResponse, StructuredQuery) {
+	ctx, span := tracer.Start(ctx, "broker.Fallback")
+	defer span.End()
+	// Shards already streamed their answers to the original query.
+	opts.OnShard = nil
+	searched := rawQuery
+	for _, relaxation := range b.fallbacks {
+		if relaxation == RelaxSpellCorrect {
+			corrected, ok := b.correct(ctx, searched, query, resp)
+			if !ok {
+				continue
+			}
+			searched = corrected
+		} else if !relaxes(relaxation, query) {
+			continue
+		}
+		opts.relaxations = append(opts.relaxations, relaxation)
+
+		retry, retryQuery, err := b.search(ctx, searched, opts, start)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to search the relaxed query", "query", searched, "relaxations", opts.relaxations, "error", err)
+			break
+		}
+		query = retryQuery
+		if retry.TotalHits > 0 {
+			span.SetAttributes(attribute.StringSlice("search.relaxations", opts.relaxations))
+			relaxationsTotal.WithLabelValues(relaxation).Inc()
+			retry.Relaxation = &QueryRelaxation{Applied: opts.relaxations, OriginalQuery: string(rawQuery)}
+			if searched != rawQuery {
+				retry.Relaxation.CorrectedQuery = string(searched)
+			}
+			return retry, retryQuery
+		}
+	}
+	relaxationsTotal.WithLabelValues("exhausted").Inc()
+	return resp, query
+}
+
+// correct returns rawQuery with the searchers' corrections of the keywords of query
+// applied; ok is false if there are none.
+func (b *Broker) correct(ctx context.Context, rawQuery RawQuery, query StructuredQuery, resp *SearchResponse) (corrected RawQuery, ok bool) {
+	terms := correctableTerms(query.Keywords)
+	if len(terms) == 0 {
+		return "", false
+	}
+	corrections, err := b.corrections(ctx, terms, resp.Tenant, resp.Collection)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to get corrections", "query", rawQuery, "error", err)
+		return "", false
+	}
+	corrected = RawQuery(applyCorrections(string(rawQuery), corrections))
+	return corrected, corrected != rawQuery
+}
+
+// anyTerm returns a copy of the tree matching documents with any of the clauses its bool
+// nodes require, e.g. "+red +shoes" becomes "red shoes". Excluded clauses are kept.
+func (n *QueryNode) anyTerm() *QueryNode {
+	if n == nil || n.Type != NodeBool {
+		return n
+	}
+	relaxed := &QueryNode{Type: n.Type, Field: n.Field, Text: n.Text, MustNot: n.MustNot}
+	for _, clauses := range [][]*QueryNode{n.Must, n.Should} {
+		for _, c := range clauses {
+			relaxed.Should = append(relaxed.Should, c.anyTerm())
+		}
+	}
+	return relaxed
+}
+
+// hasRequired reports whether a bool node of the tree has Must clauses.
+func (n *QueryNode) hasRequired() bool {
+	if n == nil {
+		return false
+	}
+	if len(n.Must) > 0 {
+		return true
+	}
+	for _, c := range n.Should {
+		if c.hasRequired() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package broker
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBroker_Search_Fallbacks(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, rawQuery RawQuery) (StructuredQuery, error) {
+			keywords := strings.Fields(strings.ToLower(string(rawQuery)))
+			tree := &QueryNode{Type: NodeBool}
+			for _, k := range keywords {
+				tree.Must = append(tree.Must, &QueryNode{Type: NodeTerm, Text: k})
+			}
+			return StructuredQuery{Keywords: keywords, Query: tree}, nil
+		},
+	}
+	// Documents match "red shoes" without filters, and "red" alone once fuzzy and OR'ed.
+	var searched []StructuredQuery
+	search := func(_ context.Context, q StructuredQuery) ([]SearchResult, error) {
+		searched = append(searched, q)
+		if len(q.Filters) > 0 || q.Query.hasRequired() {
+			return []SearchResult{}, nil
+		}
+		if strings.Join(q.Keywords, " ") == "red shoes" || q.Fuzziness == MaxFuzziness && q.Keywords[0] == "red" {
+			return []SearchResult{{ID: "a", Score: 1}}, nil
+		}
+		return []SearchResult{}, nil
+	}
+	shard := &mockCorrector{
+		MockSearcher: MockSearcher{ShardID: 0, SearchFunc: search},
+		corrections:  map[string]TermCorrection{"shoos": {Term: "shoos", Correction: "shoes", CorrectionFrequency: 2, Distance: 1}},
+	}
+	b := NewBroker(mockQU, []Searcher{shard})
+	b.SetDidYouMeanThreshold(-1)
+	if err := b.SetFallbacks([]string{RelaxDropFilters, RelaxSpellCorrect, RelaxFuzzy, RelaxAnyTerm}); err != nil {
+		t.Fatalf("SetFallbacks returned an error: %v", err)
+	}
+	filters := []Filter{TermFilter("color", "blue")}
+
+	// The filters are dropped, then the corrected query finds results once OR'ed.
+	resp, err := b.SearchWithOptions(context.Background(), "Red shoos", SearchOptions{Filters: filters})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if resp.TotalHits != 1 {
+		t.Fatalf("Expected the relaxed query to find the document, got %d hits", resp.TotalHits)
+	}
+	want := &QueryRelaxation{Applied: []string{RelaxDropFilters, RelaxSpellCorrect, RelaxFuzzy, RelaxAnyTerm}, OriginalQuery: "Red shoos", CorrectedQuery: "Red shoes"}
+	if !reflect.DeepEqual(resp.Relaxation, want) {
+		t.Errorf("Expected relaxation %+v, got %+v", want, resp.Relaxation)
+	}
+	if len(searched) != 5 {
+		t.Errorf("Expected the search and a retry per relaxation, got %d searches", len(searched))
+	}
+
+	// Relaxations that don't change the query are skipped; the chain stops at results.
+	searched = nil
+	resp, err = b.SearchWithOptions(context.Background(), "red boots", SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	want = &QueryRelaxation{Applied: []string{RelaxFuzzy, RelaxAnyTerm}, OriginalQuery: "red boots"}
+	if resp.TotalHits != 1 || !reflect.DeepEqual(resp.Relaxation, want) {
+		t.Errorf("Expected relaxation %+v with results, got %+v and %d hits", want, resp.Relaxation, resp.TotalHits)
+	}
+
+	// Exhausted chains return the original response.
+	resp, err = b.SearchWithOptions(context.Background(), "green boots", SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if resp.TotalHits != 0 || resp.Relaxation != nil {
+		t.Errorf("Expected no results nor relaxation, got %d hits and %+v", resp.TotalHits, resp.Relaxation)
+	}
+
+	for _, chain := range [][]string{{"drop_everything"}, {RelaxFuzzy, RelaxFuzzy}} {
+		if err := b.SetFallbacks(chain); err == nil {
+			t.Errorf("Expected an error for the fallback chain %v", chain)
+		}
+	}
+}
+
+func TestQueryNode_AnyTerm(t *testing.T) {
+	tree := &QueryNode{Type: NodeBool,
+		Must:    []*QueryNode{{Type: NodeTerm, Text: "red"}, {Type: NodeBool, Must: []*QueryNode{{Type: NodePhrase, Text: "running shoes"}}}},
+		Should:  []*QueryNode{{Type: NodeTerm, Text: "cheap"}},
+		MustNot: []*QueryNode{{Type: NodeTerm, Text: "used"}},
+	}
+	want := &QueryNode{Type: NodeBool,
+		Should:  []*QueryNode{{Type: NodeTerm, Text: "red"}, {Type: NodeBool, Should: []*QueryNode{{Type: NodePhrase, Text: "running shoes"}}}, {Type: NodeTerm, Text: "cheap"}},
+		MustNot: []*QueryNode{{Type: NodeTerm, Text: "used"}},
+	}
+	if got := tree.anyTerm(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if got := tree.anyTerm(); got.hasRequired() || !tree.hasRequired() {
+		t.Error("Expected the relaxed tree alone to have no required clauses")
+	}
+}
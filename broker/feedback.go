@@ -0,0 +1,237 @@
+package broker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Feedback event types accepted by the feedback API.
+const (
+	FeedbackClick  = "click"  // The user opened a result
+	FeedbackSelect = "select" // The user picked a result, e.g. added it to a cart
+)
+
+const (
+	// maxRecentQueries bounds how many searches can still receive feedback.
+	maxRecentQueries = 10000
+	// maxCTREntries bounds the number of (query, result) pairs tracked for CTR.
+	maxCTREntries = 100000
+)
+
+var (
+	// ErrUnknownQuery is returned for feedback on a query ID the broker doesn't remember.
+	ErrUnknownQuery = errors.New("unknown query ID")
+	// ErrInvalidFeedback is returned for malformed feedback events.
+	ErrInvalidFeedback = errors.New("invalid feedback")
+)
+
+// FeedbackEvent reports a user interaction with a result of a search, identified by the
+// query ID returned in its SearchResponse.
+type FeedbackEvent struct {
+	QueryID  string `json:"query_id"`
+	DocID    string `json:"doc_id"`
+	Type     string `json:"type"`               // FeedbackClick (default) or FeedbackSelect
+	Position int    `json:"position,omitempty"` // 1-based rank of the result; inferred if omitted
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// ResultCTR is the aggregate feedback of a result for a normalized query.
+type ResultCTR struct {
+	Collection  string  `json:"collection"`
+	Query       string  `json:"query"`
+	DocID       string  `json:"doc_id"`
+	Impressions int     `json:"impressions"`
+	Clicks      int     `json:"clicks"`
+	Selections  int     `json:"selections"`
+	CTR         float64 `json:"ctr"`
+}
+
+// recentQuery is a search that can still receive feedback.
+type recentQuery struct {
+	collection string
+	query      string         // Normalized query
+	positions  map[string]int // Returned doc IDs and their 1-based rank
+	// Experiment buckets of the search, reported with its feedback
+	experiments []ExperimentAssignment
+}
+
+// ctrKey identifies a result of a normalized query.
+type ctrKey struct {
+	collection, query, docID string
+}
+
+// ctrStats counts how often a result was shown and interacted with.
+type ctrStats struct {
+	impressions, clicks, selections int
+}
+
+// FeedbackTracker ties feedback events to recent searches and aggregates click-through
+// rates per result. Memory is bounded: the oldest searches are forgotten first, and new
+// (query, result) pairs are no longer tracked once the limit is reached.
+type FeedbackTracker struct {
+	mu      sync.Mutex
+	queries map[string]*recentQuery
+	order   []string // Query IDs, oldest first
+	stats   map[ctrKey]*ctrStats
+}
+
+// NewFeedbackTracker creates an empty tracker.
+func NewFeedbackTracker() *FeedbackTracker {
+	return &FeedbackTracker{
+		queries: make(map[string]*recentQuery),
+		stats:   make(map[ctrKey]*ctrStats),
+	}
+}
+
+// recordSearch remembers the results returned under queryID and counts an impression
+// for each of them. from is the offset of the first result.
+func (t *FeedbackTracker) recordSearch(queryID, collection, query string, results []SearchResult, from int, experiments []ExperimentAssignment) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.order) >= maxRecentQueries {
+		delete(t.queries, t.order[0])
+		t.order = t.order[1:]
+	}
+	rq := &recentQuery{collection: collection, query: query, positions: make(map[string]int, len(results)), experiments: experiments}
+	for i, r := range results {
+		rq.positions[r.ID] = from + i + 1
+		if s := t.statsFor(ctrKey{collection, query, r.ID}); s != nil {
+			s.impressions++
+		}
+	}
+	t.queries[queryID] = rq
+	t.order = append(t.order, queryID)
+}
+
+// statsFor returns the counters of key, creating them if the limit allows. Callers must hold t.mu.
+func (t *FeedbackTracker) statsFor(key ctrKey) *ctrStats {
+	if s, ok := t.stats[key]; ok {
+		return s
+	}
+	if len(t.stats) >= maxCTREntries {
+		return nil
+	}
+	s := &ctrStats{}
+	t.stats[key] = s
+	return s
+}
+
+// recordFeedback validates event against the search it refers to, counts it and
+// returns the search.
+func (t *FeedbackTracker) recordFeedback(event *FeedbackEvent) (*recentQuery, error) {
+	if event.QueryID == "" || event.DocID == "" {
+		return nil, fmt.Errorf("%w: query_id and doc_id are required", ErrInvalidFeedback)
+	}
+	if event.Type == "" {
+		event.Type = FeedbackClick
+	}
+	if event.Type != FeedbackClick && event.Type != FeedbackSelect {
+		return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidFeedback, event.Type)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rq, ok := t.queries[event.QueryID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownQuery, event.QueryID)
+	}
+	position, ok := rq.positions[event.DocID]
+	if !ok {
+		return nil, fmt.Errorf("%w: document %q was not returned for query %q", ErrInvalidFeedback, event.DocID, event.QueryID)
+	}
+	if event.Position == 0 {
+		event.Position = position
+	}
+	if s := t.statsFor(ctrKey{rq.collection, rq.query, event.DocID}); s != nil {
+		if event.Type == FeedbackClick {
+			s.clicks++
+		} else {
+			s.selections++
+		}
+	}
+	return rq, nil
+}
+
+// CTR returns the aggregate feedback of every tracked result with at least minImpressions
+// impressions, optionally restricted to a collection and a normalized query. Results are
+// grouped by collection and query, highest CTR first.
+func (t *FeedbackTracker) CTR(collection, query string, minImpressions int) []ResultCTR {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make([]ResultCTR, 0)
+	for key, s := range t.stats {
+		if (collection != "" && key.collection != collection) || (query != "" && key.query != query) {
+			continue
+		}
+		if s.impressions == 0 || s.impressions < minImpressions {
+			continue
+		}
+		out = append(out, ResultCTR{
+			Collection:  key.collection,
+			Query:       key.query,
+			DocID:       key.docID,
+			Impressions: s.impressions,
+			Clicks:      s.clicks,
+			Selections:  s.selections,
+			CTR:         float64(s.clicks) / float64(s.impressions),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Collection != b.Collection {
+			return a.Collection < b.Collection
+		}
+		if a.Query != b.Query {
+			return a.Query < b.Query
+		}
+		if a.CTR != b.CTR {
+			return a.CTR > b.CTR
+		}
+		return a.DocID < b.DocID
+	})
+	return out
+}
+
+// Feedback records a click or selection on a result of a recent search. The event is
+// counted towards the result's CTR and written to the query log, if one is set.
+func (b *Broker) Feedback(event FeedbackEvent) error {
+	rq, err := b.feedback.recordFeedback(&event)
+	if err != nil {
+		return err
+	}
+	if b.queryLog != nil {
+		b.queryLog.Log(QueryLogRecord{
+			Event:           event.Type,
+			Timestamp:       time.Now().UTC(),
+			QueryID:         event.QueryID,
+			ClientID:        event.ClientID,
+			Collection:      rq.collection,
+			NormalizedQuery: rq.query,
+			DocID:           event.DocID,
+			Position:        event.Position,
+			Experiments:     rq.experiments,
+		})
+	}
+	return nil
+}
+
+// CTR returns the aggregate feedback per result; see FeedbackTracker.CTR.
+func (b *Broker) CTR(collection, query string, minImpressions int) []ResultCTR {
+	return b.feedback.CTR(collection, query, minImpressions)
+}
+
+// newQueryID returns a random identifier for a search.
+func newQueryID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
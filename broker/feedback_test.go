@@ -0,0 +1,116 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBroker_Feedback_CTR(t *testing.T) {
+	b := newTestBroker()
+	sink := &recordingSink{}
+	logger := NewQueryLogger(sink)
+	b.SetQueryLogger(logger)
+
+	var queryIDs []string
+	for i := 0; i < 4; i++ {
+		resp, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{Size: 2})
+		if err != nil {
+			t.Fatalf("SearchWithOptions returned an error: %v", err)
+		}
+		if resp.QueryID == "" {
+			t.Fatal("Expected a query ID in the response")
+		}
+		queryIDs = append(queryIDs, resp.QueryID)
+	}
+	// Results are b and c; b is clicked twice, c is selected once.
+	for _, event := range []FeedbackEvent{
+		{QueryID: queryIDs[0], DocID: "b"},
+		{QueryID: queryIDs[1], DocID: "b", Type: FeedbackClick},
+		{QueryID: queryIDs[2], DocID: "c", Type: FeedbackSelect},
+	} {
+		if err := b.Feedback(event); err != nil {
+			t.Fatalf("Feedback(%+v) returned an error: %v", event, err)
+		}
+	}
+
+	if err := b.Feedback(FeedbackEvent{QueryID: "missing", DocID: "b"}); !errors.Is(err, ErrUnknownQuery) {
+		t.Errorf("Expected ErrUnknownQuery, got %v", err)
+	}
+	if err := b.Feedback(FeedbackEvent{QueryID: queryIDs[0], DocID: "a"}); !errors.Is(err, ErrInvalidFeedback) {
+		t.Errorf("Expected ErrInvalidFeedback for a result that wasn't returned, got %v", err)
+	}
+	if err := b.Feedback(FeedbackEvent{QueryID: queryIDs[0], DocID: "b", Type: "like"}); !errors.Is(err, ErrInvalidFeedback) {
+		t.Errorf("Expected ErrInvalidFeedback for an unknown type, got %v", err)
+	}
+
+	ctr := b.CTR("", "", 0)
+	if len(ctr) != 2 {
+		t.Fatalf("Expected CTR for 2 results, got %+v", ctr)
+	}
+	if ctr[0].DocID != "b" || ctr[0].Impressions != 4 || ctr[0].Clicks != 2 || ctr[0].CTR != 0.5 {
+		t.Errorf("Unexpected CTR for b: %+v", ctr[0])
+	}
+	if ctr[1].DocID != "c" || ctr[1].Clicks != 0 || ctr[1].Selections != 1 {
+		t.Errorf("Unexpected CTR for c: %+v", ctr[1])
+	}
+	if got := b.CTR("", "", 5); len(got) != 0 {
+		t.Errorf("Expected no results with 5 impressions, got %+v", got)
+	}
+
+	if err := logger.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+	var clicks []QueryLogRecord
+	for _, rec := range sink.records {
+		if rec.Event != QueryLogEventSearch {
+			clicks = append(clicks, rec)
+		}
+	}
+	if len(clicks) != 3 || clicks[0].QueryID != queryIDs[0] || clicks[0].Position != 1 || clicks[2].Event != FeedbackSelect {
+		t.Errorf("Unexpected feedback records: %+v", clicks)
+	}
+}
+
+func TestHandler_Feedback(t *testing.T) {
+	b := newTestBroker()
+	h := NewHandler(b)
+	resp, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{Size: 2})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/feedback", strings.NewReader(body)))
+		return rec.Code
+	}
+	if code := post(`{"query_id":"` + resp.QueryID + `","doc_id":"b"}`); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	if code := post(`{"query_id":"nope","doc_id":"b"}`); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown query, got %d", code)
+	}
+	if code := post(`{"doc_id":"b"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a query ID, got %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/ctr?min_impressions=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	var body struct {
+		Results []ResultCTR `json:"results"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode CTR response: %v", err)
+	}
+	if len(body.Results) != 2 || body.Results[0].DocID != "b" || body.Results[0].Clicks != 1 {
+		t.Errorf("Unexpected CTR response: %+v", body.Results)
+	}
+}
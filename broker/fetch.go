@@ -0,0 +1,192 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Search types: how the results of the shards are gathered.
+const (
+	// SearchTypeQueryAndFetch asks every shard for its results with their stored fields
+	// in one request.
+	SearchTypeQueryAndFetch = "query_and_fetch"
+	// SearchTypeQueryThenFetch asks the shards for the IDs, scores, sort values and ranking
+	// fields of their results first, then fetches the stored fields of the returned page
+	// alone from the shards holding it, which is cheaper for deep or wide result sets.
+	SearchTypeQueryThenFetch = "query_then_fetch"
+)
+
+// StageFetch is the debug stage of the fetch phase of query-then-fetch searches.
+const StageFetch = "fetch"
+
+// noFields asks a searcher for no stored field, as the query phase does when no ranking
+// field is needed.
+const noFields = "_none"
+
+// Fetcher is implemented by searchers that return the stored fields of documents by ID.
+// Query-then-fetch searches fall back to query-and-fetch on collections whose searchers
+// don't implement it.
+type Fetcher interface {
+	Fetch(ctx context.Context, ids []string, fields []string) (map[string]map[string]interface{}, error)
+}
+
+// isFetcher reports whether s returns documents by ID.
+func isFetcher(s Searcher) bool {
+	_, ok := s.(Fetcher)
+	return ok
+}
+
+// ParseSearchType checks a search type, empty meaning the broker's default.
+func ParseSearchType(s string) (string, error) {
+	switch s {
+	case "", SearchTypeQueryAndFetch, SearchTypeQueryThenFetch:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid search type %q, must be %q or %q", s, SearchTypeQueryAndFetch, SearchTypeQueryThenFetch)
+}
+
+// SetSearchType sets the search type of searches that don't choose one,
+// SearchTypeQueryAndFetch by default.
+func (b *Broker) SetSearchType(searchType string) error {
+	if _, err := ParseSearchType(searchType); err != nil {
+		return err
+	}
+	b.searchType = searchType
+	return nil
+}
+
+// queryThenFetch reports whether a search of opts over pool runs in two phases: every
+// searcher of the pool must fetch documents.
+func (b *Broker) queryThenFetch(opts SearchOptions, pool map[int][]Searcher) bool {
+	searchType := opts.SearchType
+	if searchType == "" {
+		searchType = b.searchType
+	}
+	if searchType != SearchTypeQueryThenFetch {
+		return false
+	}
+	for _, replicas := range pool {
+		for _, s := range replicas {
+			if !isFetcher(s) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fromShard returns copies of the results of a shard recording it, so the fetch phase
+// knows where to fetch them from. They are copied as the searches of a batch may share
+// them.
+func fromShard(results []SearchResult, shardID int) []SearchResult {
+	tagged := make([]SearchResult, len(results))
+	for i, r := range results {
+		r.shardID = shardID
+		tagged[i] = r
+	}
+	return tagged
+}
+
+// fetch fills in the stored fields of the page of results of a query-then-fetch search,
+// asking every shard for its results in one request. Results of shards that fail to
+// answer keep their ID and score alone; the failures are added to the shard statuses.
+func (b *Broker) fetch(ctx context.Context, collection string, pool map[int][]Searcher, query StructuredQuery, page []SearchResult, statuses map[int]*ShardStatus) {
+	byShard := make(map[int][]int)
+	for i, r := range page {
+		byShard[r.shardID] = append(byShard[r.shardID], i)
+	}
+	fields := append(append([]string(nil), resultFields...), query.Fields...)
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for shardID, positions := range byShard {
+		ids := make([]string, len(positions))
+		for n, i := range positions {
+			ids[n] = page[i].ID
+		}
+		wg.Add(1)
+		go func(shardID int, positions []int, ids []string) {
+			defer wg.Done()
+			var docs map[string]map[string]interface{}
+			ok := b.askShard(ctx, ShardKey{Collection: collection, ShardID: shardID}, pool[shardID], "searcher.Fetch", isFetcher, func(ctx context.Context, s Searcher) error {
+				var err error
+				docs, err = s.(Fetcher).Fetch(ctx, ids, fields)
+				return err
+			}, func(err error, _ time.Duration) {
+				if err == nil {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if status := statuses[shardID]; status != nil {
+					status.Errors = append(status.Errors, fmt.Sprintf("fetch: %v", err))
+				}
+			})
+			if !ok {
+				return
+			}
+			for _, i := range positions {
+				stored, found := docs[page[i].ID]
+				if !found {
+					continue // Deleted since the query phase
+				}
+				r := &page[i]
+				r.Title = stringField(stored, "title")
+				r.URL = stringField(stored, "url")
+				r.Fields = selectFields(stored, query.Fields)
+				merged := make(map[string]interface{}, len(r.Stored)+len(stored))
+				for k, v := range r.Stored {
+					merged[k] = v
+				}
+				for k, v := range stored {
+					merged[k] = v
+				}
+				r.Stored = merged
+			}
+		}(shardID, positions, ids)
+	}
+	wg.Wait()
+}
+
+// fetchResponse is the body returned by the searcher service's /docs endpoint.
+type fetchResponse struct {
+	Documents []struct {
+		ID     string                 `json:"id"`
+		Fields map[string]interface{} `json:"fields"`
+	} `json:"documents"`
+}
+
+// Fetch asks the remote searcher for the stored fields of the documents ids, keyed by ID.
+// Documents it doesn't hold are missing from the result.
+func (s *HTTPSearcher) Fetch(ctx context.Context, ids []string, fields []string) (map[string]map[string]interface{}, error) {
+	params := url.Values{}
+	params.Set("ids", strings.Join(ids, ","))
+	params.Set("collection", s.collection)
+	s.setTenant(params)
+	if len(fields) > 0 {
+		params.Set("fields", strings.Join(fields, ","))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/docs?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fetch request: %w", err)
+	}
+
+	var resp fetchResponse
+	if err := doJSON(s.client, req, &resp); err != nil {
+		return nil, fmt.Errorf("searcher %s (shard %d) fetch request failed: %w", s.baseURL, s.shardID, err)
+	}
+	docs := make(map[string]map[string]interface{}, len(resp.Documents))
+	for _, doc := range resp.Documents {
+		docs[doc.ID] = doc.Fields
+	}
+	return docs, nil
+}
+
+// Ensure HTTPSearcher fetches documents.
+var _ Fetcher = (*HTTPSearcher)(nil)
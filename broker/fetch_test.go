@@ -0,0 +1,107 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeShard serves /search and /docs for documents <prefix>0..<prefix>4, scored by their
+// position, recording the fields and IDs it is asked for.
+type fakeShard struct {
+	prefix  string
+	score   float64
+	mu      sync.Mutex
+	fields  []string
+	fetched []string
+}
+
+func (f *fakeShard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+	switch r.URL.Path {
+	case "/search":
+		f.fields = append(f.fields, query.Get("fields"))
+		var hits []map[string]interface{}
+		for i := 0; i < 5; i++ {
+			hit := map[string]interface{}{"id": fmt.Sprintf("%s%d", f.prefix, i), "score": f.score - float64(i)}
+			if query.Get("fields") != noFields {
+				hit["fields"] = map[string]interface{}{"title": "full", "url": fmt.Sprintf("http://%s.example.com/%d", f.prefix, i)}
+			}
+			hits = append(hits, hit)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": hits})
+	case "/docs":
+		ids := strings.Split(query.Get("ids"), ",")
+		f.fetched = append(f.fetched, ids...)
+		var docs []map[string]interface{}
+		for _, id := range ids {
+			docs = append(docs, map[string]interface{}{"id": id, "fields": map[string]interface{}{"title": "Title " + id, "url": "/" + id, "price": 3}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"documents": docs})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestBroker_Search_QueryThenFetch(t *testing.T) {
+	shards := []*fakeShard{{prefix: "a", score: 10}, {prefix: "b", score: 9.5}}
+	var searchers []Searcher
+	for i, shard := range shards {
+		server := httptest.NewServer(shard)
+		defer server.Close()
+		searchers = append(searchers, NewHTTPSearcher(server.URL, i))
+	}
+	b := NewBroker(&MockQueryUnderstandingService{}, searchers)
+	if err := b.SetSearchType("scan"); err == nil {
+		t.Errorf("Expected an invalid search type to be rejected")
+	}
+
+	resp, err := b.SearchWithOptions(context.Background(), "shoes", SearchOptions{Size: 3, Fields: []string{"price"}, SearchType: SearchTypeQueryThenFetch})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	var ids []string
+	for _, r := range resp.Results {
+		ids = append(ids, r.ID)
+		if r.Title != "Title "+r.ID || r.URL != "/"+r.ID || r.Fields["price"] != float64(3) {
+			t.Errorf("Expected %s to be fetched, got %+v", r.ID, r)
+		}
+	}
+	if strings.Join(ids, ",") != "a0,b0,a1" || resp.TotalHits != 10 {
+		t.Fatalf("Expected the global top 3 of 10 hits, got %v of %d", ids, resp.TotalHits)
+	}
+	for i, shard := range shards {
+		if len(shard.fields) != 1 || shard.fields[0] != noFields {
+			t.Errorf("Shard %d: expected the query phase to ask for no fields, got %v", i, shard.fields)
+		}
+	}
+	if strings.Join(shards[0].fetched, ",") != "a0,a1" || strings.Join(shards[1].fetched, ",") != "b0" {
+		t.Errorf("Expected only the page to be fetched from its shards, got %v and %v", shards[0].fetched, shards[1].fetched)
+	}
+
+	// Query-and-fetch remains the default.
+	resp, err = b.SearchWithOptions(context.Background(), "shoes", SearchOptions{Size: 3})
+	if err != nil || resp.Results[0].Title != "full" || len(shards[0].fetched) != 2 {
+		t.Errorf("Expected a single-phase search, got %+v, %v", resp, err)
+	}
+
+	// The query phase returns the fields results are collapsed by.
+	collapse, _ := ParseCollapse(RuleFieldHost, "")
+	resp, err = b.SearchWithOptions(context.Background(), "shoes", SearchOptions{Size: 10, Collapse: collapse, SearchType: SearchTypeQueryThenFetch})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if got := resultIDs(resp.Results); strings.Join(got, ",") != "a0,b0" {
+		t.Errorf("Expected the best result of every host, got %v", got)
+	}
+	if got := shards[0].fields[len(shards[0].fields)-1]; got != "url" {
+		t.Errorf("Expected the query phase to ask for the url, got %q", got)
+	}
+}
@@ -0,0 +1,43 @@
+package broker
+
+import "strings"
+
+// AllFields selects every stored field of the results.
+const AllFields = "*"
+
+// resultFields are the stored fields the broker always asks searchers for, to fill in
+// the Title and URL of its results.
+var resultFields = []string{"title", "url"}
+
+// parseFieldList splits a comma-separated list of field names such as "title,url,price".
+func parseFieldList(param string) []string {
+	var fields []string
+	for _, f := range strings.Split(param, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// selectFields returns the values of the requested fields among those a searcher
+// returned, or all of them if fields contains AllFields. It returns nil if fields is
+// empty or none of them is stored.
+func selectFields(stored map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	selected := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if f == AllFields {
+			return stored
+		}
+		if v, ok := stored[f]; ok {
+			selected[f] = v
+		}
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	return selected
+}
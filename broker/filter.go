@@ -0,0 +1,134 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FilterType identifies the kind of restriction a Filter applies.
+type FilterType string
+
+// Filter types supported by the searchers.
+const (
+	FilterTerm        FilterType = "term"         // Exact match of Value on Field
+	FilterRange       FilterType = "range"        // Numeric range [Min, Max] on Field
+	FilterDateRange   FilterType = "date_range"   // RFC 3339 date range [Start, End] on Field
+	FilterGeoDistance FilterType = "geo_distance" // Points of Field within Distance of (Lat, Lon)
+	FilterNested      FilterType = "nested"       // A nested object at path Field matching all of Filters
+)
+
+// Filter is a structured restriction on the documents a search may return.
+// Only the fields relevant to Type are set; range bounds left nil are open.
+// The JSON form matches the searcher service's "filters" query parameter.
+type Filter struct {
+	Type  FilterType `json:"type"`
+	Field string     `json:"field"`
+
+	Value string `json:"value,omitempty"`
+
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	ExclusiveMin bool     `json:"exclusive_min,omitempty"`
+	ExclusiveMax bool     `json:"exclusive_max,omitempty"`
+
+	Start          string `json:"start,omitempty"`
+	End            string `json:"end,omitempty"`
+	ExclusiveStart bool   `json:"exclusive_start,omitempty"`
+	ExclusiveEnd   bool   `json:"exclusive_end,omitempty"`
+
+	Lat      float64 `json:"lat,omitempty"`
+	Lon      float64 `json:"lon,omitempty"`
+	Distance string  `json:"distance,omitempty"`
+
+	Filters []Filter `json:"filters,omitempty"`
+}
+
+// TermFilter returns a filter matching documents whose field equals value exactly.
+func TermFilter(field, value string) Filter {
+	return Filter{Type: FilterTerm, Field: field, Value: value}
+}
+
+// RangeFilter returns an inclusive numeric range filter. A nil bound leaves that side open.
+func RangeFilter(field string, min, max *float64) Filter {
+	return Filter{Type: FilterRange, Field: field, Min: min, Max: max}
+}
+
+// DateRangeFilter returns an inclusive date range filter on RFC 3339 timestamps.
+// An empty bound leaves that side open.
+func DateRangeFilter(field, start, end string) Filter {
+	return Filter{Type: FilterDateRange, Field: field, Start: start, End: end}
+}
+
+// GeoDistanceFilter returns a filter matching points within distance (e.g. "10km") of lat/lon.
+func GeoDistanceFilter(field string, lat, lon float64, distance string) Filter {
+	return Filter{Type: FilterGeoDistance, Field: field, Lat: lat, Lon: lon, Distance: distance}
+}
+
+// NestedFilter returns a filter matching documents with an object in the array at path,
+// indexed as nested, that matches every filter, e.g. "items" with filters on items.price
+// and items.color. Searchers only find the nested objects of the indexer's nested fields.
+func NestedFilter(path string, filters ...Filter) Filter {
+	return Filter{Type: FilterNested, Field: path, Filters: filters}
+}
+
+// Validate checks that the filter carries the parameters required by its type.
+// Values such as dates and distances are validated by the searchers.
+func (f Filter) Validate() error {
+	if f.Field == "" {
+		return fmt.Errorf("%s filter requires a field", f.Type)
+	}
+	switch f.Type {
+	case FilterTerm:
+		if f.Value == "" {
+			return fmt.Errorf("term filter on '%s' requires a value", f.Field)
+		}
+	case FilterRange:
+		if f.Min == nil && f.Max == nil {
+			return fmt.Errorf("range filter on '%s' requires min or max", f.Field)
+		}
+	case FilterDateRange:
+		if f.Start == "" && f.End == "" {
+			return fmt.Errorf("date_range filter on '%s' requires start or end", f.Field)
+		}
+	case FilterGeoDistance:
+		if f.Distance == "" {
+			return fmt.Errorf("geo_distance filter on '%s' requires a distance", f.Field)
+		}
+	case FilterNested:
+		if len(f.Filters) == 0 {
+			return fmt.Errorf("nested filter on '%s' requires filters", f.Field)
+		}
+		for _, inner := range f.Filters {
+			if inner.Type == FilterNested {
+				return fmt.Errorf("nested filter on '%s' can't hold nested filters", f.Field)
+			}
+			if !strings.HasPrefix(inner.Field, f.Field+".") {
+				return fmt.Errorf("nested filter on '%s' holds a filter on '%s', outside of it", f.Field, inner.Field)
+			}
+			if err := inner.Validate(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown filter type '%s'", f.Type)
+	}
+	return nil
+}
+
+// ParseFilters decodes and validates a JSON array of filters.
+func ParseFilters(data string) ([]Filter, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var filters []Filter
+	if err := json.Unmarshal([]byte(data), &filters); err != nil {
+		return nil, fmt.Errorf("invalid filters: %w", err)
+	}
+	for i, f := range filters {
+		if err := f.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid filter %d: %w", i, err)
+		}
+	}
+	return filters, nil
+}
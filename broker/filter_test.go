@@ -0,0 +1,66 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseFilters(t *testing.T) {
+	filters, err := ParseFilters(`[{"type":"term","field":"color","value":"red"},{"type":"geo_distance","field":"location","lat":48.85,"lon":2.35,"distance":"5km"}]`)
+	if err != nil {
+		t.Fatalf("ParseFilters returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(filters, []Filter{TermFilter("color", "red"), GeoDistanceFilter("location", 48.85, 2.35, "5km")}) {
+		t.Errorf("Unexpected filters: %+v", filters)
+	}
+
+	filters, err = ParseFilters(`[{"type":"nested","field":"items","filters":[{"type":"term","field":"items.color","value":"red"}]}]`)
+	if err != nil {
+		t.Fatalf("ParseFilters returned an error for a nested filter: %v", err)
+	}
+	if !reflect.DeepEqual(filters, []Filter{NestedFilter("items", TermFilter("items.color", "red"))}) {
+		t.Errorf("Unexpected nested filters: %+v", filters)
+	}
+
+	for _, data := range []string{
+		`{}`,
+		`[{"type":"term","value":"red"}]`,
+		`[{"type":"range","field":"price"}]`,
+		`[{"type":"fuzzy","field":"x"}]`,
+		`[{"type":"nested","field":"items"}]`,
+		`[{"type":"nested","field":"items","filters":[{"type":"term","field":"color","value":"red"}]}]`,
+		`[{"type":"nested","field":"items","filters":[{"type":"term","field":"items.color"}]}]`,
+	} {
+		if _, err := ParseFilters(data); err == nil {
+			t.Errorf("Expected an error for filters %s", data)
+		}
+	}
+}
+
+func TestHTTPSearcher_Search_Filters(t *testing.T) {
+	var gotFilters []Filter
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &gotFilters); err != nil {
+			t.Errorf("Failed to decode filters parameter: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": []interface{}{}})
+	}))
+	defer server.Close()
+
+	max := 20.0
+	query := StructuredQuery{
+		Keywords: []string{"shoes"},
+		Filters:  []Filter{TermFilter("color", "red"), RangeFilter("price", nil, &max)},
+	}
+	if _, err := NewHTTPSearcher(server.URL, 0).Search(context.Background(), query); err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+	if len(gotFilters) != 2 || !reflect.DeepEqual(gotFilters[0], TermFilter("color", "red")) ||
+		gotFilters[1].Type != FilterRange || gotFilters[1].Max == nil || *gotFilters[1].Max != 20 {
+		t.Errorf("Unexpected filters sent to searcher: %+v", gotFilters)
+	}
+}
@@ -0,0 +1,44 @@
+package broker
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// DefaultGeoField is the geopoint field declared in the Indexer's default mapping.
+const DefaultGeoField = "location"
+
+// GeoQuery restricts a search to documents within Radius of a point. When set, searchers
+// report each result's distance from the point and results can be sorted by "_distance".
+type GeoQuery struct {
+	Field  string  // Geopoint field; empty means DefaultGeoField
+	Lat    float64 // Latitude of the origin
+	Lon    float64 // Longitude of the origin
+	Radius string  // Maximum distance, e.g. "10km"; empty means no radius limit
+}
+
+// ParseGeoQuery builds a GeoQuery from the "lat", "lon", "radius" and "geo_field" request
+// parameters. It returns nil if neither lat nor lon is set; both are required otherwise.
+func ParseGeoQuery(lat, lon, radius, field string) (*GeoQuery, error) {
+	if lat == "" && lon == "" {
+		if radius != "" {
+			return nil, fmt.Errorf("radius requires lat and lon")
+		}
+		return nil, nil
+	}
+	if lat == "" || lon == "" {
+		return nil, fmt.Errorf("both lat and lon are required for a geo query")
+	}
+	q := &GeoQuery{Field: field, Radius: radius}
+	if q.Field == "" {
+		q.Field = DefaultGeoField
+	}
+	var err error
+	if q.Lat, err = strconv.ParseFloat(lat, 64); err != nil || q.Lat < -90 || q.Lat > 90 {
+		return nil, fmt.Errorf("invalid latitude '%s'", lat)
+	}
+	if q.Lon, err = strconv.ParseFloat(lon, 64); err != nil || q.Lon < -180 || q.Lon > 180 {
+		return nil, fmt.Errorf("invalid longitude '%s'", lon)
+	}
+	return q, nil
+}
@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseGeoQuery(t *testing.T) {
+	q, err := ParseGeoQuery("48.85", "2.35", "10km", "")
+	if err != nil {
+		t.Fatalf("ParseGeoQuery returned an error: %v", err)
+	}
+	if *q != (GeoQuery{Field: DefaultGeoField, Lat: 48.85, Lon: 2.35, Radius: "10km"}) {
+		t.Errorf("Unexpected geo query: %+v", q)
+	}
+	if _, err := ParseGeoQuery("48.85", "", "", ""); err == nil {
+		t.Error("Expected an error when lon is missing")
+	}
+}
+
+func TestHTTPSearcher_Search_Geo(t *testing.T) {
+	var gotParams url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotParams = r.URL.Query()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []map[string]interface{}{
+				{"id": "orsay", "score": 1.0, "distance_km": 0.12, "sort_values": []interface{}{0.12}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	query := StructuredQuery{
+		Keywords: []string{"museum"},
+		Geo:      &GeoQuery{Lat: 48.8595, Lon: 2.325, Radius: "5km"},
+		Sort:     []SortField{{Field: SortFieldDistance}},
+	}
+	results, err := NewHTTPSearcher(server.URL, 0).Search(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+	if gotParams.Get("lat") != "48.8595" || gotParams.Get("lon") != "2.325" ||
+		gotParams.Get("radius") != "5km" || gotParams.Get("sort") != "_distance:asc" {
+		t.Errorf("Unexpected searcher parameters: %v", gotParams)
+	}
+	if len(results) != 1 || results[0].DistanceKm == nil || *results[0].DistanceKm != 0.12 {
+		t.Errorf("Expected the distance to be returned, got %+v", results)
+	}
+}
+
+func TestMergeSorted_ByDistance(t *testing.T) {
+	near, mid, far := 0.5, 2.0, 7.5
+	lists := [][]SearchResult{
+		{{ID: "near", DistanceKm: &near}, {ID: "far", DistanceKm: &far}},
+		{{ID: "mid", DistanceKm: &mid}, {ID: "nowhere"}},
+	}
+	merged := mergeSorted(lists, []SortField{{Field: SortFieldDistance}})
+	var ids []string
+	for _, r := range merged {
+		ids = append(ids, r.ID)
+	}
+	if len(ids) != 4 || ids[0] != "near" || ids[1] != "mid" || ids[2] != "far" || ids[3] != "nowhere" {
+		t.Errorf("Expected [near mid far nowhere], got %v", ids)
+	}
+}
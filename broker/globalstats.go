@@ -0,0 +1,191 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TermStats mirrors the term statistics a searcher publishes for its shard.
+type TermStats struct {
+	Documents uint64            `json:"documents"` // Documents in the shard
+	Terms     map[string]uint64 `json:"terms"`     // Documents containing each term
+	Truncated bool              `json:"truncated"` // Whether rarer terms were left out
+}
+
+// TermStatser is implemented by searchers that publish the term statistics of their
+// shard. Searchers that don't implement it keep their local scores.
+type TermStatser interface {
+	TermStats(ctx context.Context) (*TermStats, error)
+}
+
+// isTermStatser reports whether s publishes term statistics.
+func isTermStatser(s Searcher) bool {
+	_, ok := s.(TermStatser)
+	return ok
+}
+
+// GlobalStats holds the term statistics of every shard and their sums by collection.
+// Bleve scores terms by their IDF in the shard that matched them, so the same document
+// would score differently on another shard; results are rescored with the IDF of the
+// whole collection before they are merged.
+type GlobalStats struct {
+	mu     sync.RWMutex
+	shards map[ShardKey]*TermStats
+	totals map[string]*TermStats // Sums of the shards by pool key
+}
+
+// NewGlobalStats returns empty global statistics; shards without statistics are not
+// rescored.
+func NewGlobalStats() *GlobalStats {
+	return &GlobalStats{shards: make(map[ShardKey]*TermStats), totals: make(map[string]*TermStats)}
+}
+
+// Update replaces the statistics of a shard and recomputes the sums of its collection.
+func (g *GlobalStats) Update(shard ShardKey, stats *TermStats) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.shards[shard] = stats
+	total := &TermStats{Terms: make(map[string]uint64)}
+	for key, s := range g.shards {
+		if key.Collection != shard.Collection {
+			continue
+		}
+		total.Documents += s.Documents
+		total.Truncated = total.Truncated || s.Truncated
+		for term, count := range s.Terms {
+			total.Terms[term] += count
+		}
+	}
+	g.totals[shard.Collection] = total
+}
+
+// idf is Bleve's inverse document frequency of a term found in docTerm of docTotal
+// documents.
+func idf(docTerm, docTotal uint64) float64 {
+	return 1 + math.Log(float64(docTotal)/float64(docTerm+1))
+}
+
+// Factor returns the factor converting the scores of a shard for terms into scores using
+// the global IDF. Bleve's TF-IDF weighs every term by its IDF squared, normalized by the
+// square root of the sum of the squared IDFs of the query, so a document matching every
+// term scales by sqrt(sum of global IDF²) / sqrt(sum of local IDF²). Terms missing from
+// a truncated summary count as found in no document. It is 1 when either statistic is
+// unknown.
+func (g *GlobalStats) Factor(shard ShardKey, terms []string) float64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	local, total := g.shards[shard], g.totals[shard.Collection]
+	if local == nil || total == nil || local.Documents == 0 || len(terms) == 0 {
+		return 1
+	}
+	var localSum, globalSum float64
+	for _, term := range terms {
+		localSum += math.Pow(idf(local.Terms[term], local.Documents), 2)
+		globalSum += math.Pow(idf(total.Terms[term], total.Documents), 2)
+	}
+	if localSum <= 0 || globalSum <= 0 {
+		return 1
+	}
+	return math.Sqrt(globalSum / localSum)
+}
+
+// rescore returns the results of a shard with their scores multiplied by its global IDF
+// factor for the query's keywords. Results keep their order, as every score scales alike.
+// They are copied, as the searches of a batch may share them.
+func (g *GlobalStats) rescore(shard ShardKey, keywords []string, results []SearchResult) []SearchResult {
+	factor := g.Factor(shard, statsTerms(keywords))
+	if factor == 1 {
+		return results
+	}
+	rescored := make([]SearchResult, len(results))
+	for i, r := range results {
+		r.Score *= factor
+		rescored[i] = r
+	}
+	return rescored
+}
+
+// statsTerms splits keywords into the lowercased terms the standard analyzer indexes.
+func statsTerms(keywords []string) []string {
+	var terms []string
+	for _, k := range keywords {
+		terms = append(terms, strings.Fields(strings.ToLower(k))...)
+	}
+	return terms
+}
+
+// SetGlobalStats rescores the results of every shard with the global IDF of g before
+// merging them; nil keeps the shards' scores.
+func (b *Broker) SetGlobalStats(g *GlobalStats) {
+	b.globalStats = g
+}
+
+// RefreshGlobalStats asks one replica of every shard for its term statistics and updates
+// the global statistics. Shards that can't be reached keep their previous statistics.
+// The requests run outside the circuit breakers, and skip the replicas they took out of
+// routing, so that a failing refresh doesn't trip the breakers of searches.
+func (b *Broker) RefreshGlobalStats(ctx context.Context) {
+	if b.globalStats == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for collection, pool := range b.collections {
+		for shardID, replicas := range pool {
+			wg.Add(1)
+			go func(shard ShardKey, replicas []Searcher) {
+				defer wg.Done()
+				var stats *TermStats
+				ok := b.pollShard(ctx, shard, replicas, "searcher.TermStats", isTermStatser, func(ctx context.Context, s Searcher) error {
+					var err error
+					stats, err = s.(TermStatser).TermStats(ctx)
+					return err
+				})
+				if ok {
+					b.globalStats.Update(shard, stats)
+				}
+			}(ShardKey{Collection: collection, ShardID: shardID}, replicas)
+		}
+	}
+	wg.Wait()
+}
+
+// WatchGlobalStats refreshes the global statistics every interval until ctx is done.
+func (b *Broker) WatchGlobalStats(ctx context.Context, interval time.Duration) {
+	b.RefreshGlobalStats(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.RefreshGlobalStats(ctx)
+		}
+	}
+}
+
+// TermStats asks the remote searcher for the term statistics of its shard.
+func (s *HTTPSearcher) TermStats(ctx context.Context) (*TermStats, error) {
+	params := url.Values{}
+	params.Set("collection", s.collection)
+	s.setTenant(params)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/stats?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stats request: %w", err)
+	}
+
+	var stats TermStats
+	if err := doJSON(s.client, req, &stats); err != nil {
+		return nil, fmt.Errorf("searcher %s (shard %d) stats request failed: %w", s.baseURL, s.shardID, err)
+	}
+	return &stats, nil
+}
+
+// Ensure HTTPSearcher publishes term statistics.
+var _ TermStatser = (*HTTPSearcher)(nil)
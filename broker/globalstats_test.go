@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// mockTermStatser is a searcher publishing fixed term statistics, or failing with err.
+type mockTermStatser struct {
+	MockSearcher
+	stats *TermStats
+	err   error
+}
+
+func (m *mockTermStatser) TermStats(context.Context) (*TermStats, error) {
+	return m.stats, m.err
+}
+
+func TestGlobalStats_Factor(t *testing.T) {
+	g := NewGlobalStats()
+	common := ShardKey{Collection: "products", ShardID: 0}
+	rare := ShardKey{Collection: "products", ShardID: 1}
+	g.Update(common, &TermStats{Documents: 100, Terms: map[string]uint64{"shoes": 49}})
+	g.Update(rare, &TermStats{Documents: 100, Terms: map[string]uint64{"shoes": 1}})
+	g.Update(ShardKey{Collection: "other", ShardID: 0}, &TermStats{Documents: 1000, Terms: map[string]uint64{"shoes": 999}})
+
+	globalIDF := 1 + math.Log(200.0/51)
+	if got, want := g.Factor(common, []string{"shoes"}), globalIDF/(1+math.Log(100.0/50)); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected the shard where the term is common to scale by %f, got %f", want, got)
+	}
+	if got, want := g.Factor(rare, []string{"shoes"}), globalIDF/(1+math.Log(100.0/2)); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected the shard where the term is rare to scale by %f, got %f", want, got)
+	}
+	if g.Factor(common, []string{"shoes"}) <= 1 || g.Factor(rare, []string{"shoes"}) >= 1 {
+		t.Errorf("Expected the shard scores to converge")
+	}
+	if f := g.Factor(ShardKey{Collection: "products", ShardID: 2}, []string{"shoes"}); f != 1 {
+		t.Errorf("Expected shards without statistics to keep their scores, got %f", f)
+	}
+}
+
+func TestBroker_Search_GlobalStats(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, rawQuery RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: []string{string(rawQuery)}}, nil
+		},
+	}
+	searchers := make([]Searcher, 2)
+	for i, count := range []uint64{49, 1} {
+		shardID := i
+		searchers[i] = &mockTermStatser{
+			MockSearcher: MockSearcher{ShardID: shardID, SearchFunc: func(context.Context, StructuredQuery) ([]SearchResult, error) {
+				return []SearchResult{{ID: fmt.Sprintf("doc-%d", shardID), Score: 2}}, nil
+			}},
+			stats: &TermStats{Documents: 100, Terms: map[string]uint64{"shoes": count}},
+		}
+	}
+	b := NewBroker(mockQU, searchers)
+	b.SetGlobalStats(NewGlobalStats())
+	b.RefreshGlobalStats(context.Background())
+
+	results, err := b.Search(context.Background(), "Shoes")
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Unexpected results %+v, %v", results, err)
+	}
+	var shardID int
+	fmt.Sscanf(results[0].ID, "doc-%d", &shardID)
+	want := 2 * b.globalStats.Factor(ShardKey{Collection: DefaultCollection, ShardID: shardID}, []string{"shoes"})
+	if results[0].Score == 2 || math.Abs(results[0].Score-want) > 1e-9 {
+		t.Errorf("Expected the score rescored to %f, got %f", want, results[0].Score)
+	}
+}
+
+func TestBroker_RefreshGlobalStats_SkipsBreakers(t *testing.T) {
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{&mockTermStatser{err: errors.New("stats timed out")}})
+	b.SetGlobalStats(NewGlobalStats())
+	for i := 0; i < 50; i++ {
+		b.RefreshGlobalStats(context.Background())
+	}
+	statuses := b.BreakerStatuses()
+	if len(statuses) != 1 || statuses[0].State != BreakerClosed || statuses[0].Requests != 0 {
+		t.Errorf("Expected failed statistics requests not to be recorded into the breakers, got %+v", statuses)
+	}
+}
+
+func TestHTTPSearcher_TermStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" || r.URL.Query().Get("collection") != "products" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"field": "_all", "documents": 4, "terms": {"red": 2}, "truncated": true}`))
+	}))
+	defer server.Close()
+
+	stats, err := NewCollectionHTTPSearcher("products", server.URL, 0).TermStats(context.Background())
+	if err != nil {
+		t.Fatalf("TermStats returned an error: %v", err)
+	}
+	if stats.Documents != 4 || stats.Terms["red"] != 2 || !stats.Truncated {
+		t.Errorf("Unexpected term statistics %+v", stats)
+	}
+}
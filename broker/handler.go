@@ -0,0 +1,462 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"common/commitbus"
+	"common/tenant"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// MediaTypeSearchV1 selects the versioned SearchResponse envelope (the default).
+	MediaTypeSearchV1 = "application/vnd.search-engine.v1+json"
+	// MediaTypeSearchLegacy selects the legacy format: a bare JSON array of results.
+	MediaTypeSearchLegacy = "application/vnd.search-engine.legacy+json"
+
+	defaultPageSize = 10
+	maxPageSize     = 100
+
+	// MaxFuzziness is the largest edit distance accepted by searchers for fuzzy matching.
+	MaxFuzziness = 2
+)
+
+// Handler exposes the Broker over HTTP.
+type Handler struct {
+	current       atomic.Pointer[Broker] // Serves the requests; replaced by SetBroker
+	mux           *http.ServeMux
+	subscriptions *subscriptionHub
+	versions      *indexVersions // Last commit to every collection
+	commitToken   string         // Authenticates the events of the indexers and searchers; empty refuses them
+	origins       []string       // Origins allowed to subscribe besides the broker's host
+}
+
+// NewHandler creates an HTTP handler serving the broker's public API.
+func NewHandler(b *Broker) *Handler {
+	h := &Handler{mux: http.NewServeMux()}
+	h.current.Store(b)
+	h.subscriptions = newSubscriptionHub(h.broker)
+	h.versions = newIndexVersions()
+	h.mux.HandleFunc("/search", h.HandleSearch)
+	h.mux.HandleFunc("/search/stream", h.HandleSearchStream)
+	h.mux.HandleFunc("/search/scroll", h.HandleScroll)
+	h.mux.HandleFunc("/msearch", h.HandleMultiSearch)
+	h.mux.HandleFunc("/subscribe", h.HandleSubscribe)
+	// Indexers announce their commits here, and searchers the segments they loaded, which
+	// rerun the live queries of /subscribe.
+	h.mux.Handle(commitbus.Path, h.internalEvents(h.notifyCommit))
+	h.mux.Handle(commitbus.LoadPath, h.internalEvents(h.notifyLoad))
+	h.mux.HandleFunc("/suggest", h.HandleSuggest)
+	h.mux.HandleFunc("/feedback", h.HandleFeedback)
+	h.mux.HandleFunc("/admin/breakers", h.HandleBreakers)
+	h.mux.HandleFunc("/admin/ctr", h.HandleCTR)
+	h.mux.HandleFunc("/admin/topology", h.HandleTopology)
+	h.mux.Handle("/metrics", promhttp.Handler()) // Prometheus scrape endpoint
+	// Elasticsearch-compatible searches of an index, or of the default collection.
+	h.mux.HandleFunc("/{index}/_search", h.HandleESSearch)
+	h.mux.HandleFunc("/_search", h.HandleESSearch)
+	return h
+}
+
+// SetBroker makes the handler serve the next requests with b, e.g. one built from a
+// reloaded configuration; requests in flight complete with the previous broker. b takes
+// over the runtime state of the previous broker, see Broker.inherit.
+func (h *Handler) SetBroker(b *Broker) {
+	if prev := h.current.Load(); prev != b {
+		b.inherit(prev)
+	}
+	h.current.Store(b)
+}
+
+// broker returns the broker serving requests.
+func (h *Handler) broker() *Broker {
+	return h.current.Load()
+}
+
+// ServeHTTP dispatches the request to the registered endpoint.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// HandleSearch handles GET /search?q=...&collection=...&from=...&size=...&sort=field:asc,...&filters=[...]&lat=...&lon=...&radius=...&timeout=...&debug=...&explain=...&fields=...&fuzziness=...&prefix_length=...&auto_correct=...&mode=...&collapse=...&collapse_size=...&types=...&knn_field=...&knn_vector=...&k=...&num_candidates=...&lang=...
+// q may be left out of kNN searches, which rank the nearest neighbors of knn_vector;
+// those without knn_vector search the embedding of q computed by query understanding;
+// searches with mode=hybrid fuse the rankings of q and of the kNN query.
+// Clients sending "Accept: application/vnd.search-engine.legacy+json" receive the
+// pre-envelope response: a bare array of all merged results. Searches with mode=instant
+// receive an InstantResponse; those of a client (X-Client-ID) are debounced, a newer one
+// failing the previous with status 409. lang sets the language of q, e.g. "fr", which
+// query understanding otherwise detects. Responses carry an ETag versioned by the segments
+// the shards were searched at; searches sending it back in If-None-Match get 304 Not
+// Modified while their results are unchanged, see Broker.SetCacheMaxAge.
+func (h *Handler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queryParam := r.URL.Query().Get("q")
+	if queryParam == "" && r.URL.Query().Get("knn_vector") == "" {
+		http.Error(w, "Missing 'q' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	slog.DebugContext(r.Context(), "Received raw query", "query", queryParam)
+
+	opts, err := parseSearchOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wantsLegacyResponse(r) {
+		resp, err := h.broker().SearchWithOptions(r.Context(), RawQuery(queryParam), SearchOptions{Collection: opts.Collection, Tenant: opts.Tenant, Language: opts.Language, Sort: opts.Sort, Filters: opts.Filters, Geo: opts.Geo, ClientID: opts.ClientID, UserID: opts.UserID, Timeout: opts.Timeout, KNN: opts.KNN})
+		if err != nil {
+			writeSearchError(w, r, err)
+			return
+		}
+		h.writeSearch(w, r, opts, resp, MediaTypeSearchLegacy, resp.Results)
+		return
+	}
+
+	if opts.Mode == ModeInstant {
+		if r.URL.Query().Get("size") == "" {
+			opts.Size = h.broker().instant.Size
+		}
+		resp, err := h.broker().SearchWithOptions(r.Context(), RawQuery(queryParam), opts)
+		if err != nil {
+			writeSearchError(w, r, err)
+			return
+		}
+		h.writeSearch(w, r, opts, resp, "application/json", NewInstantResponse(RawQuery(queryParam), resp))
+		return
+	}
+
+	resp, err := h.broker().SearchWithOptions(r.Context(), RawQuery(queryParam), opts)
+	if err != nil {
+		writeSearchError(w, r, err)
+		return
+	}
+	h.writeSearch(w, r, opts, resp, MediaTypeSearchV1, resp)
+}
+
+// HandleSuggest handles GET /suggest?q=...&collection=...&size=..., returning the
+// completions of the prefix q merged across the collection's shards.
+func (h *Handler) HandleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	prefix := query.Get("q")
+	if prefix == "" {
+		http.Error(w, "Missing 'q' query parameter", http.StatusBadRequest)
+		return
+	}
+	size := defaultPageSize
+	if v := query.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPageSize {
+			http.Error(w, fmt.Sprintf("invalid 'size' query parameter, must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	tenantID, err := tenant.FromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.broker().Suggest(r.Context(), prefix, tenantID, query.Get("collection"), size)
+	if err != nil {
+		writeSearchError(w, r, err)
+		return
+	}
+	writeJSON(w, "application/json", resp)
+}
+
+// writeSearchError maps a search error to its HTTP status.
+func writeSearchError(w http.ResponseWriter, r *http.Request, err error) {
+	status, message := searchErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "Broker search failed", "error", err)
+	}
+	http.Error(w, message, status)
+}
+
+// searchErrorStatus returns the HTTP status and message reporting a failed search.
+func searchErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrUnknownCollection):
+		return http.StatusNotFound, err.Error()
+	case errors.Is(err, tenant.ErrQuotaExceeded):
+		return http.StatusTooManyRequests, err.Error()
+	case errors.Is(err, ErrBudgetExceeded):
+		return http.StatusGatewayTimeout, err.Error()
+	case errors.Is(err, ErrSuperseded):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, ErrNoQueryEmbedding), errors.Is(err, ErrInvalidCursor):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, ErrCanceled):
+		return StatusClientClosedRequest, err.Error()
+	case errors.Is(err, ErrScrollIncomplete):
+		return http.StatusServiceUnavailable, err.Error()
+	default:
+		return http.StatusInternalServerError, "Internal server error"
+	}
+}
+
+// SetCommitToken sets the shared secret the indexers and searchers send with their events,
+// see commitbus.Publisher.SetToken. Without one, the broker refuses their events.
+func (h *Handler) SetCommitToken(token string) {
+	h.commitToken = token
+}
+
+// internalEvents returns the webhook passing the events authenticated by the commit token
+// to fn.
+func (h *Handler) internalEvents(fn func(commitbus.Event)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commitbus.AuthHandler(h.commitToken, fn).ServeHTTP(w, r)
+	})
+}
+
+// notifyCommit handles a commit announced by an Indexer: the routing summaries of the
+// collection no longer cover its documents.
+func (h *Handler) notifyCommit(event commitbus.Event) {
+	collection := event.Collection
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	h.broker().invalidateShardRouting(poolKey(event.Tenant, collection))
+	h.versions.commit(event)
+}
+
+// notifyLoad handles a segment a searcher announced it loaded: the routing summaries of
+// its collection gathered from other segments are dropped and its live queries rerun.
+func (h *Handler) notifyLoad(event commitbus.Event) {
+	collection := event.Collection
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	h.broker().loadedShardRouting(poolKey(event.Tenant, collection), event.Segment)
+	h.subscriptions.notify(event)
+}
+
+// HandleBreakers handles GET /admin/breakers, returning the circuit breaker state of every searcher.
+func (h *Handler) HandleBreakers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, "application/json", map[string]interface{}{"breakers": h.broker().BreakerStatuses()})
+}
+
+// HandleFeedback handles POST /feedback with a JSON FeedbackEvent body, recording a click or
+// selection on a result of the search identified by query_id.
+func (h *Handler) HandleFeedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var event FeedbackEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		http.Error(w, fmt.Sprintf("invalid feedback body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if event.ClientID == "" {
+		event.ClientID = r.Header.Get("X-Client-ID")
+	}
+	err := h.broker().Feedback(event)
+	switch {
+	case errors.Is(err, ErrUnknownQuery):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidFeedback):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to record feedback", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleCTR handles GET /admin/ctr?collection=...&q=...&min_impressions=..., returning the
+// click-through rate of each result per normalized query.
+func (h *Handler) HandleCTR(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	minImpressions := 0
+	if v := query.Get("min_impressions"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid 'min_impressions' query parameter", http.StatusBadRequest)
+			return
+		}
+		minImpressions = n
+	}
+	writeJSON(w, "application/json", map[string]interface{}{"results": h.broker().CTR(query.Get("collection"), query.Get("q"), minImpressions)})
+}
+
+// wantsLegacyResponse reports whether the client explicitly asked for the legacy format.
+func wantsLegacyResponse(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			if mt, _, _ := strings.Cut(strings.TrimSpace(mediaType), ";"); mt == MediaTypeSearchLegacy {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseSearchOptions reads the pagination, sort, filter, geo, timeout (a duration such as
+// "150ms"), debug, explain, fields, fuzziness, prefix_length, mode, search_type, auto_correct, collapse, types and kNN parameters and
+// the client ID (X-Client-ID header or client_id parameter) and the tenant (tenant.Header
+// header or tenant.Param parameter) from the request.
+func parseSearchOptions(r *http.Request) (SearchOptions, error) {
+	query := r.URL.Query()
+	opts := SearchOptions{Collection: query.Get("collection"), Language: query.Get("lang"), Size: defaultPageSize}
+	opts.ClientID = r.Header.Get("X-Client-ID")
+	if opts.ClientID == "" {
+		opts.ClientID = query.Get("client_id")
+	}
+	opts.UserID = r.Header.Get("X-User-ID")
+	if opts.UserID == "" {
+		opts.UserID = query.Get("user_id")
+	}
+	tenantID, err := tenant.FromRequest(r)
+	if err != nil {
+		return opts, err
+	}
+	opts.Tenant = tenantID
+	if from := query.Get("from"); from != "" {
+		v, err := strconv.Atoi(from)
+		if err != nil || v < 0 {
+			return opts, fmt.Errorf("invalid 'from' query parameter")
+		}
+		opts.From = v
+	}
+	if size := query.Get("size"); size != "" {
+		v, err := strconv.Atoi(size)
+		if err != nil || v <= 0 || v > maxPageSize {
+			return opts, fmt.Errorf("invalid 'size' query parameter, must be between 1 and %d", maxPageSize)
+		}
+		opts.Size = v
+	}
+	if timeout := query.Get("timeout"); timeout != "" {
+		v, err := time.ParseDuration(timeout)
+		if err != nil || v <= 0 {
+			return opts, fmt.Errorf("invalid 'timeout' query parameter, expected a positive duration such as 150ms")
+		}
+		opts.Timeout = v
+	}
+	if debug := query.Get("debug"); debug != "" {
+		v, err := strconv.ParseBool(debug)
+		if err != nil {
+			return opts, fmt.Errorf("invalid 'debug' query parameter")
+		}
+		opts.Debug = v
+	}
+	if explain := query.Get("explain"); explain != "" {
+		v, err := strconv.ParseBool(explain)
+		if err != nil {
+			return opts, fmt.Errorf("invalid 'explain' query parameter")
+		}
+		opts.Explain = v
+	}
+	opts.Fields = parseFieldList(query.Get("fields"))
+	opts.Types = parseFieldList(query.Get("types"))
+	if fuzziness := query.Get("fuzziness"); fuzziness != "" {
+		v, err := strconv.Atoi(fuzziness)
+		if err != nil || v < 0 || v > MaxFuzziness {
+			return opts, fmt.Errorf("invalid 'fuzziness' query parameter, must be between 0 and %d", MaxFuzziness)
+		}
+		opts.Fuzziness = v
+	}
+	if prefixLength := query.Get("prefix_length"); prefixLength != "" {
+		v, err := strconv.Atoi(prefixLength)
+		if err != nil || v < 0 {
+			return opts, fmt.Errorf("invalid 'prefix_length' query parameter, must be a non-negative integer")
+		}
+		opts.PrefixLength = v
+	}
+	switch mode := query.Get("mode"); mode {
+	case "", ModeInstant, ModeHybrid:
+		opts.Mode = mode
+	default:
+		return opts, fmt.Errorf("invalid 'mode' query parameter, must be empty, %q or %q", ModeInstant, ModeHybrid)
+	}
+	searchType, err := ParseSearchType(query.Get("search_type"))
+	if err != nil {
+		return opts, fmt.Errorf("invalid 'search_type' query parameter: %w", err)
+	}
+	opts.SearchType = searchType
+	if autoCorrect := query.Get("auto_correct"); autoCorrect != "" {
+		v, err := strconv.ParseBool(autoCorrect)
+		if err != nil {
+			return opts, fmt.Errorf("invalid 'auto_correct' query parameter")
+		}
+		opts.AutoCorrect = v
+	}
+	collapse, err := ParseCollapse(query.Get("collapse"), query.Get("collapse_size"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Collapse = collapse
+	knn, err := ParseKNNQuery(query.Get("knn_field"), query.Get("knn_vector"), query.Get("k"), query.Get("num_candidates"))
+	if err != nil {
+		return opts, err
+	}
+	opts.KNN = knn
+	if opts.Mode == ModeHybrid {
+		switch {
+		case knn == nil || query.Get("q") == "":
+			return opts, fmt.Errorf("hybrid searches require the 'q' and kNN query parameters")
+		case query.Get("sort") != "" || opts.Collapse != nil:
+			return opts, fmt.Errorf("hybrid searches are ranked by fused score and can't be sorted or collapsed")
+		}
+	}
+	sortFields, err := ParseSortSpec(query.Get("sort"))
+	if err != nil {
+		return opts, fmt.Errorf("invalid 'sort' query parameter: %w", err)
+	}
+	opts.Sort = sortFields
+	filters, err := ParseFilters(query.Get("filters"))
+	if err != nil {
+		return opts, fmt.Errorf("invalid 'filters' query parameter: %w", err)
+	}
+	opts.Filters = filters
+	geoQuery, err := ParseGeoQuery(query.Get("lat"), query.Get("lon"), query.Get("radius"), query.Get("geo_field"))
+	if err != nil {
+		return opts, fmt.Errorf("invalid geo query: %w", err)
+	}
+	opts.Geo = geoQuery
+	for _, f := range opts.Sort {
+		if f.Field == SortFieldDistance && opts.Geo == nil {
+			return opts, fmt.Errorf("sorting by %s requires lat and lon", SortFieldDistance)
+		}
+	}
+	return opts, nil
+}
+
+// writeJSON encodes v as the response body with the given content type.
+func writeJSON(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to encode response", "error", err)
+	}
+}
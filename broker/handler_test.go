@@ -0,0 +1,222 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"common/tenant"
+)
+
+// newTestBroker returns a broker with two shards; shard 1 always fails.
+func newTestBroker() *Broker {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{}, nil // No keywords, so every shard is queried
+		},
+	}
+	shard0 := &MockSearcher{
+		ShardID: 0,
+		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			return []SearchResult{
+				{ID: "a", Score: 0.5},
+				{ID: "b", Score: 0.9},
+				{ID: "c", Score: 0.7},
+			}, nil
+		},
+	}
+	shard1 := &MockSearcher{
+		ShardID: 1,
+		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			return nil, errors.New("shard down")
+		},
+	}
+	return NewBroker(mockQU, []Searcher{shard0, shard1})
+}
+
+func TestBroker_SearchWithOptions_Envelope(t *testing.T) {
+	resp, err := newTestBroker().SearchWithOptions(context.Background(), "q", SearchOptions{From: 1, Size: 1})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+
+	if resp.Version != ResponseVersion {
+		t.Errorf("Expected version %q, got %q", ResponseVersion, resp.Version)
+	}
+	if resp.TotalHits != 3 {
+		t.Errorf("Expected 3 total hits, got %d", resp.TotalHits)
+	}
+	// Results are ordered by score: b (0.9), c (0.7), a (0.5); page [1,2) is c.
+	if len(resp.Results) != 1 || resp.Results[0].ID != "c" {
+		t.Errorf("Expected page with result 'c', got %+v", resp.Results)
+	}
+	if !resp.Pagination.HasMore || resp.Pagination.Returned != 1 || resp.Pagination.From != 1 {
+		t.Errorf("Unexpected pagination: %+v", resp.Pagination)
+	}
+	if resp.Shards.Total != 2 || resp.Shards.Successful != 1 || resp.Shards.Failed != 1 {
+		t.Errorf("Unexpected shard summary: %+v", resp.Shards)
+	}
+	if len(resp.Shards.Details) != 2 || resp.Shards.Details[1].ShardID != 1 || len(resp.Shards.Details[1].Errors) != 1 {
+		t.Errorf("Unexpected shard details: %+v", resp.Shards.Details)
+	}
+}
+
+// newPagedTestBroker returns a broker with one shard holding 30 results, returning as
+// many as asked for, 10 by default like the searchers.
+func newPagedTestBroker() *Broker {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: []string{"q"}}, nil
+		},
+	}
+	shard := &MockSearcher{
+		ShardID: 0,
+		SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+			size := query.Size
+			if size == 0 {
+				size = 10
+			}
+			var results []SearchResult
+			for n := 0; n < min(size, 30); n++ {
+				results = append(results, SearchResult{ID: fmt.Sprintf("doc%02d", n), Score: float64(30 - n)})
+			}
+			return results, nil
+		},
+	}
+	return NewBroker(mockQU, []Searcher{shard})
+}
+
+func TestBroker_SearchWithOptions_PagesPastDefaultSize(t *testing.T) {
+	b := newPagedTestBroker()
+	resp, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{From: 10, Size: 15})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if len(resp.Results) != 15 || resp.Results[0].ID != "doc10" || !resp.Pagination.HasMore {
+		t.Errorf("Expected results 10 to 24 of more, got %d results from %+v (%+v)", len(resp.Results), resp.Results[:min(1, len(resp.Results))], resp.Pagination)
+	}
+
+	resp, err = b.SearchWithOptions(context.Background(), "q", SearchOptions{From: 20, Size: 20})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if len(resp.Results) != 10 || resp.TotalHits != 30 || resp.Pagination.HasMore {
+		t.Errorf("Expected the last 10 of 30 results, got %d of %d (%+v)", len(resp.Results), resp.TotalHits, resp.Pagination)
+	}
+}
+
+func TestHandler_Search_EnvelopeByDefault(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/search?q=test&size=2", nil)
+	rec := httptest.NewRecorder()
+	NewHandler(newTestBroker()).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != MediaTypeSearchV1 {
+		t.Errorf("Expected content type %q, got %q", MediaTypeSearchV1, ct)
+	}
+	var resp SearchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode envelope: %v", err)
+	}
+	if len(resp.Results) != 2 || resp.Pagination.Size != 2 {
+		t.Errorf("Expected 2 results in page of size 2, got %d (size %d)", len(resp.Results), resp.Pagination.Size)
+	}
+}
+
+func TestHandler_SetBroker(t *testing.T) {
+	prev := newTestBroker()
+	h := NewHandler(prev)
+	search := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=test&collection=products", nil))
+		return rec.Code
+	}
+	if code := search(); code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 for an unknown collection, got %d", code)
+	}
+
+	products := &MockCollectionSearcher{Collection: "products", MockSearcher: MockSearcher{
+		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			return []SearchResult{{ID: "p1", Score: 1}}, nil
+		},
+	}}
+	next := NewBroker(prev.queryUnderstanding, []Searcher{products})
+	h.SetBroker(next)
+	if code := search(); code != http.StatusOK {
+		t.Fatalf("Expected status 200 once the collection is routed, got %d", code)
+	}
+	if next.feedback != prev.feedback || next.instantSearches != prev.instantSearches {
+		t.Error("Expected the new broker to take over the feedback and instant searches")
+	}
+}
+
+func TestHandler_SetBroker_RuntimeState(t *testing.T) {
+	searchers := []Searcher{&MockSearcher{ShardID: 0}}
+	build := func() *Broker {
+		b := NewBroker(&MockQueryUnderstandingService{}, searchers)
+		limiter, err := tenant.NewLimiter(tenant.QuotaConfig{Default: tenant.Quota{RequestsPerSecond: 1}})
+		if err != nil {
+			t.Fatalf("NewLimiter returned an error: %v", err)
+		}
+		b.SetTenantLimiter(limiter)
+		b.SetGlobalStats(NewGlobalStats())
+		b.SetShardPruner(NewShardPruner("customer"))
+		return b
+	}
+	prev := build()
+	h := NewHandler(prev)
+	key := replicaKey{ShardKey{DefaultCollection, 0}, 0}
+	for i := 0; i < DefaultBreakerConfig().MinRequests; i++ {
+		prev.breakers.Record(key, time.Millisecond, errors.New("boom"))
+	}
+	if err := prev.tenantLimiter.Allow("shop"); err != nil {
+		t.Fatalf("Expected the first request to be allowed, got %v", err)
+	}
+
+	next := build()
+	h.SetBroker(next)
+	if next.breakers.Allow(key) {
+		t.Error("Expected the open breaker to stay open across the reload")
+	}
+	if err := next.tenantLimiter.Allow("shop"); !errors.Is(err, tenant.ErrQuotaExceeded) {
+		t.Errorf("Expected the quota used to carry over, got %v", err)
+	}
+	if next.globalStats != prev.globalStats || next.pruner != prev.pruner {
+		t.Error("Expected the global statistics and the routing summaries to carry over")
+	}
+}
+
+func TestHandler_Search_LegacyAccept(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/search?q=test", nil)
+	req.Header.Set("Accept", "text/html, "+MediaTypeSearchLegacy+";q=0.9")
+	rec := httptest.NewRecorder()
+	NewHandler(newTestBroker()).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var results []SearchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatalf("Expected a bare results array, got %s: %v", rec.Body.String(), err)
+	}
+	if len(results) != 3 {
+		t.Errorf("Expected 3 results, got %d", len(results))
+	}
+}
+
+func TestHandler_Search_BadRequest(t *testing.T) {
+	for _, target := range []string{"/search", "/search?q=x&size=0", "/search?q=x&from=-1", "/search?q=x&sort=price:up", "/search?q=x&filters=notjson", "/search?q=x&sort=_distance", "/search?q=x&search_type=scan"} {
+		rec := httptest.NewRecorder()
+		NewHandler(newTestBroker()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package broker
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// minHedgeSamples is the number of latencies a shard needs before its searches are hedged;
+// with fewer the percentile would be meaningless.
+const minHedgeSamples = 10
+
+var (
+	hedgedSearchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "broker_hedged_searches_total",
+		Help: "Shard searches sent to a second replica because the first one was slow to answer",
+	})
+	hedgeWinsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "broker_hedge_wins_total",
+		Help: "Hedged shard searches answered first by the second replica",
+	})
+)
+
+// HedgeConfig sets when shard searches are hedged: a search the replica queried first
+// hasn't answered after the Percentile of the shard's recent latencies is sent to another
+// replica too, and the first answer is used.
+type HedgeConfig struct {
+	// Percentile of the shard's recent latencies a search waits for before it is hedged,
+	// e.g. 95; 0 disables hedging.
+	Percentile float64 `yaml:"percentile"`
+	// MinDelay is the least time a search waits before it is hedged, so that the searches
+	// of fast shards aren't all sent twice.
+	MinDelay time.Duration `yaml:"min_delay"`
+	// Window is the number of recent latencies of every shard the percentile is taken of.
+	Window int `yaml:"window"`
+}
+
+// DefaultHedgeConfig returns the hedging settings of a new broker, which doesn't hedge.
+func DefaultHedgeConfig() HedgeConfig {
+	return HedgeConfig{MinDelay: 10 * time.Millisecond, Window: 100}
+}
+
+// Validate checks the percentile, delay and window.
+func (c HedgeConfig) Validate() error {
+	if c.Percentile < 0 || c.Percentile >= 100 {
+		return fmt.Errorf("invalid hedging percentile %g, must be between 0 and 100", c.Percentile)
+	}
+	if c.MinDelay < 0 {
+		return fmt.Errorf("invalid hedging delay %s, must not be negative", c.MinDelay)
+	}
+	if c.Percentile > 0 && c.Window < minHedgeSamples {
+		return fmt.Errorf("invalid hedging window %d, must be at least %d", c.Window, minHedgeSamples)
+	}
+	return nil
+}
+
+// SetHedging hedges the shard searches of the broker as set by cfg; a Percentile of 0
+// disables hedging.
+func (b *Broker) SetHedging(cfg HedgeConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Percentile == 0 {
+		b.hedging = nil
+		return nil
+	}
+	b.hedging = newHedgeDelays(cfg)
+	return nil
+}
+
+// hedgeDelays keeps the recent latencies of every shard, from which the delay after
+// which its searches are hedged is taken.
+type hedgeDelays struct {
+	config    HedgeConfig
+	mu        sync.Mutex
+	latencies map[ShardKey]*latencyWindow
+}
+
+func newHedgeDelays(config HedgeConfig) *hedgeDelays {
+	return &hedgeDelays{config: config, latencies: make(map[ShardKey]*latencyWindow)}
+}
+
+// observe records the latency of a successful search of shard.
+func (h *hedgeDelays) observe(shard ShardKey, latency time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w, ok := h.latencies[shard]
+	if !ok {
+		w = &latencyWindow{samples: make([]time.Duration, h.config.Window)}
+		h.latencies[shard] = w
+	}
+	w.add(latency)
+}
+
+// inherit carries the latencies prev recorded over to h if both keep windows of the same
+// size.
+func (h *hedgeDelays) inherit(prev *hedgeDelays) {
+	if h == nil || prev == nil || h.config.Window != prev.config.Window {
+		return
+	}
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for shard, w := range prev.latencies {
+		h.latencies[shard] = &latencyWindow{samples: append([]time.Duration(nil), w.samples...), next: w.next, count: w.count}
+	}
+}
+
+// delay returns how long a search of shard waits for its replica before it is hedged, or
+// false if the search isn't hedged: hedging is disabled or the shard has too few samples.
+func (h *hedgeDelays) delay(shard ShardKey) (time.Duration, bool) {
+	if h == nil {
+		return 0, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w, ok := h.latencies[shard]
+	if !ok || w.count < minHedgeSamples {
+		return 0, false
+	}
+	d := w.percentile(h.config.Percentile)
+	if d < h.config.MinDelay {
+		d = h.config.MinDelay
+	}
+	return d, true
+}
+
+// latencyWindow is a ring buffer of the last len(samples) latencies.
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+	count   int
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % len(w.samples)
+	if w.count < len(w.samples) {
+		w.count++
+	}
+}
+
+// percentile returns the p-th percentile of the latencies, by the nearest-rank method.
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	sorted := append([]time.Duration(nil), w.samples[:w.count]...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,96 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHedgeDelays(t *testing.T) {
+	h := newHedgeDelays(HedgeConfig{Percentile: 90, MinDelay: 5 * time.Millisecond, Window: 20})
+	shard := ShardKey{Collection: DefaultCollection, ShardID: 0}
+	for i := 1; i < minHedgeSamples; i++ {
+		h.observe(shard, time.Duration(i)*time.Millisecond)
+	}
+	if _, ok := h.delay(shard); ok {
+		t.Error("Expected no hedging before the shard has enough samples")
+	}
+	h.observe(shard, 10*time.Millisecond)
+	if d, ok := h.delay(shard); !ok || d != 9*time.Millisecond {
+		t.Errorf("Expected the 90th percentile of 1..10ms, got %s %v", d, ok)
+	}
+	// The window keeps the last 20 latencies, all 1ms: the delay is raised to MinDelay.
+	for i := 0; i < 20; i++ {
+		h.observe(shard, time.Millisecond)
+	}
+	if d, _ := h.delay(shard); d != 5*time.Millisecond {
+		t.Errorf("Expected the minimum delay, got %s", d)
+	}
+	if _, ok := (*hedgeDelays)(nil).delay(shard); ok {
+		t.Error("Expected a nil hedgeDelays to disable hedging")
+	}
+}
+
+func TestHedgeConfig_Validate(t *testing.T) {
+	if err := DefaultHedgeConfig().Validate(); err != nil {
+		t.Errorf("Expected the default configuration to be valid, got %v", err)
+	}
+	for _, c := range []HedgeConfig{
+		{Percentile: 100, Window: 100},
+		{Percentile: -1, Window: 100},
+		{Percentile: 95, MinDelay: -time.Millisecond, Window: 100},
+		{Percentile: 95, Window: 5},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
+
+func TestBroker_Search_Hedged(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: []string{"hedge"}}, nil
+		},
+	}
+	abandoned := make(chan error, 1)
+	slow := &MockSearcher{ShardID: 0, SearchFunc: func(ctx context.Context, _ StructuredQuery) ([]SearchResult, error) {
+		<-ctx.Done()
+		abandoned <- ctx.Err()
+		return nil, ctx.Err()
+	}}
+	fast := &MockSearcher{ShardID: 0, SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+		return []SearchResult{{ID: "doc", Score: 1}}, nil
+	}}
+	b := NewBroker(mockQU, []Searcher{slow, fast})
+	if err := b.SetHedging(HedgeConfig{Percentile: 95, MinDelay: time.Millisecond, Window: 20}); err != nil {
+		t.Fatalf("SetHedging returned an error: %v", err)
+	}
+	shard := ShardKey{Collection: DefaultCollection, ShardID: 0}
+	for i := 0; i < minHedgeSamples; i++ {
+		b.hedging.observe(shard, 5*time.Millisecond)
+	}
+
+	// Round-robin sends the search to the slow replica first; the hedge to the fast one answers.
+	resp, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if len(resp.Results) != 1 || resp.Shards.Successful != 1 {
+		t.Errorf("Expected the hedged search's result, got %+v", resp)
+	}
+	select {
+	case err := <-abandoned:
+		if err != context.Canceled {
+			t.Errorf("Expected the slow search to be canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The search that lost the hedge wasn't canceled")
+	}
+	// The canceled search doesn't count against the slow replica.
+	for _, status := range b.BreakerStatuses() {
+		if status.Replica == 0 && status.Requests != 0 {
+			t.Errorf("Expected no request recorded for the slow replica, got %+v", status)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package broker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"common/commitbus"
+	"common/tenant"
+)
+
+// searchVary lists the request headers search responses depend on besides the URL.
+var searchVary = strings.Join([]string{"Accept", tenant.Header, "X-Client-ID", "X-User-ID"}, ", ")
+
+// SetCacheMaxAge lets clients and CDNs cache search responses for maxAge, e.g. the
+// indexers' refresh interval: results rarely change sooner than the next commit. Responses
+// whose shards all report the segment they searched carry an ETag, so caches can
+// revalidate them with If-None-Match. 0 makes caches revalidate every search.
+func (b *Broker) SetCacheMaxAge(maxAge time.Duration) {
+	b.cacheMaxAge = maxAge
+}
+
+// indexVersions tracks the last commit to every collection, by pool key, as announced by
+// the indexers.
+type indexVersions struct {
+	mu      sync.RWMutex
+	commits map[string]commitbus.Event
+}
+
+func newIndexVersions() *indexVersions {
+	return &indexVersions{commits: make(map[string]commitbus.Event)}
+}
+
+// commit records event as the version of its collection.
+func (v *indexVersions) commit(event commitbus.Event) {
+	collection := event.Collection
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.commits[poolKey(event.Tenant, collection)] = event
+}
+
+// last returns the last commit to the collection with pool key key, if any.
+func (v *indexVersions) last(key string) (commitbus.Event, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	event, ok := v.commits[key]
+	return event, ok
+}
+
+// searchETag returns the weak ETag of resp, the response to the search of r: a hash of
+// its normalized query, its other parameters, the headers of searchVary, the segment every
+// shard was searched at and the results. The segments version the response by what the
+// searchers served, not by the commits announced to the broker, which searchers load
+// later; the results cover changes made without a commit, such as documents expiring or
+// relative date ranges moving on. Responses with equal ETags hold the same results, though
+// their query IDs and timings differ. It returns "" if a shard didn't report its segment.
+func (h *Handler) searchETag(r *http.Request, opts SearchOptions, resp *SearchResponse) string {
+	params := r.URL.Query()
+	query := strings.Join(strings.Fields(strings.ToLower(params.Get("q"))), " ")
+	params.Del("q")
+	params.Del(tenant.Param)
+	params.Del("client_id")
+	params.Del("user_id")
+
+	hash := sha256.New()
+	for _, part := range []string{query, params.Encode(), fmt.Sprint(wantsLegacyResponse(r)), opts.Tenant, opts.ClientID, opts.UserID} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	for _, shard := range resp.Shards.Details {
+		if shard.Segment == "" {
+			return ""
+		}
+		fmt.Fprintf(hash, "%d:%s\x00", shard.ShardID, shard.Segment)
+	}
+	fmt.Fprintf(hash, "%d\x00", resp.TotalHits)
+	for _, result := range resp.Results {
+		fmt.Fprintf(hash, "%s:%v:%v:%s\x00", result.ID, result.Score, result.SortValues, result.Group)
+	}
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// notModified reports whether the If-None-Match header of r matches etag.
+func notModified(r *http.Request, etag string) bool {
+	for _, header := range r.Header.Values("If-None-Match") {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// ETags compare weakly: the W/ prefix is ignored.
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheableResponse reports whether resp, the response to a search with opts, may be cached:
+// debug responses and partial ones, missing the results of failed shards, may not.
+func cacheableResponse(opts SearchOptions, resp *SearchResponse) bool {
+	return !opts.Debug && resp.Shards.Failed == 0
+}
+
+// writeSearch writes resp, the response to the search of r with opts, as body, or 304 Not
+// Modified without a body if it matches the client's copy. Either way the search was run,
+// so it is logged and counted against the tenant's quota like any other.
+func (h *Handler) writeSearch(w http.ResponseWriter, r *http.Request, opts SearchOptions, resp *SearchResponse, contentType string, body interface{}) {
+	cacheable, etag := cacheableResponse(opts, resp), ""
+	if cacheable {
+		etag = h.searchETag(r, opts, resp)
+	}
+	h.setCacheHeaders(w, opts, etag, cacheable)
+	if etag != "" && notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, contentType, body)
+}
+
+// setCacheHeaders sets the caching headers of a search response, or of its 304 Not
+// Modified. The responses of clients or users are only cached by their browser; those
+// without an ETag can't be revalidated.
+func (h *Handler) setCacheHeaders(w http.ResponseWriter, opts SearchOptions, etag string, cacheable bool) {
+	header := w.Header()
+	header.Set("Vary", searchVary)
+	if !cacheable {
+		header.Set("Cache-Control", "no-store")
+		return
+	}
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	scope := "public"
+	if opts.ClientID != "" || opts.UserID != "" {
+		scope = "private"
+	}
+	if maxAge := h.broker().cacheMaxAge; maxAge > 0 {
+		header.Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+	} else {
+		header.Set("Cache-Control", scope+", no-cache")
+	}
+}
@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandler_HandleSearch_Caching(t *testing.T) {
+	searches := 0
+	results := []SearchResult{{ID: "a", Score: 1}}
+	shard := &MockSearcher{
+		ShardID: 0,
+		Segment: "segment-1",
+		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			searches++
+			return results, nil
+		},
+	}
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{shard})
+	b.SetCacheMaxAge(30 * time.Second)
+	h := NewHandler(b)
+	search := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := search("/search?q=Red+Shoes&size=5", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected 200 with an ETag, got %d and %q", rec.Code, etag)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=30" {
+		t.Errorf("Expected Cache-Control %q, got %q", "public, max-age=30", got)
+	}
+
+	// The ETag ignores the case and spacing of the query, but not the other parameters.
+	if got := search("/search?size=5&q=red%20%20shoes", "").Header().Get("ETag"); got != etag {
+		t.Errorf("Expected the normalized query to have ETag %s, got %s", etag, got)
+	}
+	if got := search("/search?q=red+shoes&size=6", "").Header().Get("ETag"); got == etag {
+		t.Error("Expected another page size to change the ETag")
+	}
+
+	// A matching If-None-Match gets 304, the search still being run, logged and counted.
+	searches = 0
+	rec = search("/search?q=red+shoes&size=5", `"other", `+etag)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || searches != 1 {
+		t.Errorf("Expected 304 after one search, got %d after %d searches", rec.Code, searches)
+	}
+	if got := rec.Header().Get("ETag"); got != etag {
+		t.Errorf("Expected the 304 to carry ETag %s, got %s", etag, got)
+	}
+
+	// A new segment served by the shard changes the ETag, and so do results changing
+	// without one, e.g. as documents expire.
+	shard.Segment = "segment-2"
+	rec = search("/search?q=red+shoes&size=5", etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("Expected 200 with a new ETag once the shard serves a new segment, got %d and %s", rec.Code, rec.Header().Get("ETag"))
+	}
+	etag = rec.Header().Get("ETag")
+	results = []SearchResult{{ID: "b", Score: 1}}
+	if rec = search("/search?q=red+shoes&size=5", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("Expected 200 with a new ETag once the results change, got %d and %s", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// Without the segment of every shard, responses can't be revalidated.
+	shard.Segment = ""
+	if rec = search("/search?q=red+shoes&size=5", "*"); rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" {
+		t.Errorf("Expected 200 without an ETag for an unknown segment, got %d and %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	shard.Segment = "segment-2"
+
+	// Debug and personalized responses aren't shared.
+	if got := search("/search?q=red+shoes&debug=true", "").Header(); got.Get("Cache-Control") != "no-store" || got.Get("ETag") != "" {
+		t.Errorf("Expected an uncached debug response, got Cache-Control %q and ETag %q", got.Get("Cache-Control"), got.Get("ETag"))
+	}
+	if got := search("/search?q=red+shoes&user_id=u1", "").Header().Get("Cache-Control"); got != "private, max-age=30" {
+		t.Errorf("Expected Cache-Control %q, got %q", "private, max-age=30", got)
+	}
+}
+
+func TestHandler_HandleSearch_PartialResponseNotCached(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHandler(newTestBroker()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoes", nil))
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" || rec.Header().Get("ETag") != "" {
+		t.Errorf("Expected a response missing a shard to be uncached, got Cache-Control %q and ETag %q", got, rec.Header().Get("ETag"))
+	}
+}
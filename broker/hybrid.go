@@ -0,0 +1,263 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ModeHybrid selects hybrid retrieval (SearchOptions.Mode): the query is searched both as
+// keywords and as the kNN query of the search, and the two rankings are fused into one by
+// the HybridConfig of the collection.
+const ModeHybrid = "hybrid"
+
+// StageFusion is the debug stage fusing the rankings of a hybrid search.
+const StageFusion = "fusion"
+
+// Fusion methods of hybrid searches.
+const (
+	// FusionRRF is reciprocal rank fusion: a result scores 1/(RankConstant + rank) in
+	// every ranking it appears in, whatever the scale of the scores.
+	FusionRRF = "rrf"
+	// FusionWeightedSum adds the scores of a result, normalized to [0, 1] in every ranking
+	// by min-max scaling, weighted by KeywordWeight and VectorWeight.
+	FusionWeightedSum = "weighted_sum"
+)
+
+// HybridConfig tunes the fusion of the hybrid searches of a collection.
+type HybridConfig struct {
+	Fusion        string  `yaml:"fusion"`         // FusionRRF (default) or FusionWeightedSum
+	RankConstant  int     `yaml:"rank_constant"`  // Dampens the weight of top ranks in FusionRRF; default 60
+	KeywordWeight float64 `yaml:"keyword_weight"` // Weight of the keyword scores in FusionWeightedSum
+	VectorWeight  float64 `yaml:"vector_weight"`  // Weight of the vector scores in FusionWeightedSum
+	// WindowSize is the number of keyword results fused; the kNN query fuses its k
+	// neighbors. Default 100.
+	WindowSize int `yaml:"window_size"`
+}
+
+// DefaultHybridConfig returns the fusion of collections without a HybridConfig.
+func DefaultHybridConfig() HybridConfig {
+	return HybridConfig{Fusion: FusionRRF, RankConstant: 60, KeywordWeight: 0.5, VectorWeight: 0.5, WindowSize: 100}
+}
+
+// withDefaults returns the config with the defaults of its unset settings.
+func (c HybridConfig) withDefaults() HybridConfig {
+	d := DefaultHybridConfig()
+	if c.Fusion == "" {
+		c.Fusion = d.Fusion
+	}
+	if c.RankConstant == 0 {
+		c.RankConstant = d.RankConstant
+	}
+	if c.KeywordWeight == 0 && c.VectorWeight == 0 {
+		c.KeywordWeight, c.VectorWeight = d.KeywordWeight, d.VectorWeight
+	}
+	if c.WindowSize == 0 {
+		c.WindowSize = d.WindowSize
+	}
+	return c
+}
+
+// Validate checks the fusion method, weights and window.
+func (c HybridConfig) Validate() error {
+	switch c.Fusion {
+	case "", FusionRRF, FusionWeightedSum:
+	default:
+		return fmt.Errorf("invalid fusion %q, must be %s or %s", c.Fusion, FusionRRF, FusionWeightedSum)
+	}
+	if c.RankConstant < 0 {
+		return fmt.Errorf("invalid rank constant %d, must not be negative", c.RankConstant)
+	}
+	if c.KeywordWeight < 0 || c.VectorWeight < 0 {
+		return fmt.Errorf("invalid weights %g and %g, must not be negative", c.KeywordWeight, c.VectorWeight)
+	}
+	if c.WindowSize < 0 || c.WindowSize > maxK {
+		return fmt.Errorf("invalid window size %d, must be between 1 and %d", c.WindowSize, maxK)
+	}
+	return nil
+}
+
+// SetHybridConfigs sets the fusion of hybrid searches by collection; collections missing
+// from configs use DefaultHybridConfig.
+func (b *Broker) SetHybridConfigs(configs map[string]HybridConfig) error {
+	hybrid := make(map[string]HybridConfig, len(configs))
+	for collection, c := range configs {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("hybrid search of collection %s: %w", collection, err)
+		}
+		hybrid[collection] = c.withDefaults()
+	}
+	b.hybrid = hybrid
+	return nil
+}
+
+// hybridConfig returns the fusion of the hybrid searches of a collection.
+func (b *Broker) hybridConfig(collection string) HybridConfig {
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	if c, ok := b.hybrid[collection]; ok {
+		return c
+	}
+	return DefaultHybridConfig()
+}
+
+// hybridSearch runs the keyword and kNN searches of a hybrid search concurrently and
+// fuses their rankings. A search missing its text or kNN query runs the other one alone;
+// if one of them fails, the results of the other are returned.
+func (b *Broker) hybridSearch(ctx context.Context, rawQuery RawQuery, opts SearchOptions, start time.Time) (*SearchResponse, StructuredQuery, error) {
+	opts.Mode = ""
+	if opts.KNN == nil || rawQuery == "" {
+		return b.search(ctx, rawQuery, opts, start)
+	}
+	cfg := b.hybridConfig(opts.Collection)
+
+	keywordOpts := opts
+	keywordOpts.KNN = nil
+	keywordOpts.From, keywordOpts.Size = 0, cfg.WindowSize
+	vectorOpts := opts
+	vectorOpts.From, vectorOpts.Size = 0, opts.KNN.K
+	vectorQuery := RawQuery("")
+	if len(opts.KNN.Vector) == 0 {
+		// The kNN search searches the embedding of the text: both searches share its
+		// understanding, so that the text is processed and embedded once.
+		vectorQuery, vectorOpts.vectorOnly = rawQuery, true
+		understood := &sharedUnderstanding{}
+		keywordOpts.understood, vectorOpts.understood = understood, understood
+	}
+
+	var (
+		wg                                  sync.WaitGroup
+		keywordResp, vectorResp             *SearchResponse
+		keywordStructured, vectorStructured StructuredQuery
+		keywordErr, vectorErr               error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		keywordResp, keywordStructured, keywordErr = b.search(ctx, rawQuery, keywordOpts, start)
+	}()
+	go func() {
+		defer wg.Done()
+		vectorResp, vectorStructured, vectorErr = b.search(ctx, vectorQuery, vectorOpts, start)
+	}()
+	wg.Wait()
+
+	base, query := keywordResp, keywordStructured
+	var keywordResults, vectorResults []SearchResult
+	switch {
+	case keywordErr != nil && vectorErr != nil:
+		return nil, keywordStructured, keywordErr
+	case vectorErr != nil:
+		slog.WarnContext(ctx, "kNN search of hybrid search failed, returning keyword results", "query", rawQuery, "error", vectorErr)
+		keywordResults = keywordResp.Results
+	case keywordErr != nil:
+		slog.WarnContext(ctx, "Keyword search of hybrid search failed, returning kNN results", "query", rawQuery, "error", keywordErr)
+		base, query = vectorResp, vectorStructured
+		vectorResults = vectorResp.Results
+	default:
+		keywordResults, vectorResults = keywordResp.Results, vectorResp.Results
+	}
+
+	fusionStart := time.Now()
+	fused := fuseRankings(cfg, keywordResults, vectorResults)
+	resp := *base
+	if vectorErr == nil && vectorResp.Shards.Failed > resp.Shards.Failed {
+		resp.Shards = vectorResp.Shards
+	}
+	resp.TotalHits = len(fused)
+	resp.Results, resp.Pagination = paginate(fused, opts.From, opts.Size)
+	if resp.Debug != nil {
+		resp.Debug.recordStage(StageFusion, 0, fusionStart, false)
+		resp.stages = resp.Debug.Stages
+	}
+	resp.TookMs = time.Since(start).Milliseconds()
+	query.KNN = opts.KNN
+	return &resp, query, nil
+}
+
+// sharedUnderstanding is the understanding of the text of a hybrid search, shared by its
+// keyword and kNN searches: the first to need it processes the text, and the other waits
+// for the outcome.
+type sharedUnderstanding struct {
+	once  sync.Once
+	query StructuredQuery
+	err   error
+}
+
+// process returns the structured query of rawQuery processed by qu. A nil
+// sharedUnderstanding, for searches outside a hybrid search, shares nothing.
+func (u *sharedUnderstanding) process(ctx context.Context, qu QueryUnderstandingService, rawQuery RawQuery) (StructuredQuery, error) {
+	if u == nil {
+		return qu.Process(ctx, rawQuery)
+	}
+	u.once.Do(func() {
+		u.query, u.err = qu.Process(ctx, rawQuery)
+	})
+	query := u.query
+	// The searches append their own filters: they mustn't share the backing array.
+	query.Filters = slices.Clip(query.Filters)
+	return query, u.err
+}
+
+// fuseRankings fuses the keyword and kNN rankings of a hybrid search into one, ordered by
+// fused score. Results found by both keep the fields and explanation of their keyword
+// result.
+func fuseRankings(cfg HybridConfig, keyword, vector []SearchResult) []SearchResult {
+	scores := make(map[string]float64, len(keyword)+len(vector))
+	results := make(map[string]SearchResult, len(keyword)+len(vector))
+	add := func(ranking []SearchResult, weight float64) {
+		normalized := normalizeScores(ranking)
+		for rank, r := range ranking {
+			if cfg.Fusion == FusionWeightedSum {
+				scores[r.ID] += weight * normalized[rank]
+			} else {
+				scores[r.ID] += 1 / float64(cfg.RankConstant+rank+1)
+			}
+			if _, ok := results[r.ID]; !ok {
+				results[r.ID] = r
+			}
+		}
+	}
+	add(keyword, cfg.KeywordWeight)
+	add(vector, cfg.VectorWeight)
+
+	fused := make([]SearchResult, 0, len(results))
+	for id, r := range results {
+		r.Score = scores[id]
+		r.SortValues = nil // The ranking is fused, not sorted by field
+		fused = append(fused, r)
+	}
+	sort.Slice(fused, func(i, j int) bool {
+		if fused[i].Score != fused[j].Score {
+			return fused[i].Score > fused[j].Score
+		}
+		return fused[i].ID < fused[j].ID
+	})
+	return fused
+}
+
+// normalizeScores scales the scores of a ranking to [0, 1]: the best scores 1 and the
+// worst 0, or 1 when they're all equal.
+func normalizeScores(ranking []SearchResult) []float64 {
+	if len(ranking) == 0 {
+		return nil
+	}
+	lo, hi := ranking[0].Score, ranking[0].Score
+	for _, r := range ranking {
+		lo, hi = min(lo, r.Score), max(hi, r.Score)
+	}
+	normalized := make([]float64, len(ranking))
+	for i, r := range ranking {
+		if hi == lo {
+			normalized[i] = 1
+		} else {
+			normalized[i] = (r.Score - lo) / (hi - lo)
+		}
+	}
+	return normalized
+}
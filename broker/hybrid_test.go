@@ -0,0 +1,144 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func joinedIDs(results []SearchResult) string {
+	return strings.Join(resultIDs(results), ",")
+}
+
+func TestFuseRankings(t *testing.T) {
+	keyword := []SearchResult{{ID: "a", Score: 12}, {ID: "b", Score: 8}, {ID: "c", Score: 2}}
+	vector := []SearchResult{{ID: "c", Score: 0.95}, {ID: "d", Score: 0.9}}
+
+	rrf := fuseRankings(DefaultHybridConfig(), keyword, vector)
+	if got := joinedIDs(rrf); got != "c,a,b,d" {
+		t.Errorf("Expected c, found by both, to rank first, got %s", got)
+	}
+	if want := 1.0/63 + 1.0/61; rrf[0].Score != want {
+		t.Errorf("Expected the RRF score %g, got %g", want, rrf[0].Score)
+	}
+
+	weighted := HybridConfig{Fusion: FusionWeightedSum, KeywordWeight: 0.8, VectorWeight: 0.2}.withDefaults()
+	fused := fuseRankings(weighted, keyword, vector)
+	if got := joinedIDs(fused); got != "a,b,c,d" {
+		t.Errorf("Expected the keyword ranking to dominate, got %s", got)
+	}
+	if fused[0].Score != 0.8 || fused[2].Score != 0.2 || fused[3].Score != 0 {
+		t.Errorf("Unexpected weighted scores %+v", fused)
+	}
+}
+
+func TestSetHybridConfigs(t *testing.T) {
+	b := NewBroker(&MockQueryUnderstandingService{}, nil)
+	for _, invalid := range []HybridConfig{{Fusion: "max"}, {RankConstant: -1}, {KeywordWeight: -1}, {WindowSize: maxK + 1}} {
+		if err := b.SetHybridConfigs(map[string]HybridConfig{"products": invalid}); err == nil {
+			t.Errorf("Expected an error for %+v", invalid)
+		}
+	}
+	if err := b.SetHybridConfigs(map[string]HybridConfig{"products": {Fusion: FusionWeightedSum, VectorWeight: 1}}); err != nil {
+		t.Fatalf("SetHybridConfigs returned an error: %v", err)
+	}
+	if c := b.hybridConfig("products"); c.Fusion != FusionWeightedSum || c.KeywordWeight != 0 || c.WindowSize != 100 {
+		t.Errorf("Expected the defaults of the unset settings, got %+v", c)
+	}
+	if c := b.hybridConfig(""); c != DefaultHybridConfig() {
+		t.Errorf("Expected the default config, got %+v", c)
+	}
+}
+
+func TestBroker_HybridSearch(t *testing.T) {
+	var quCalls int32
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, q RawQuery) (StructuredQuery, error) {
+			atomic.AddInt32(&quCalls, 1)
+			return StructuredQuery{Keywords: strings.Fields(string(q))}, nil
+		},
+	}
+	fail := false
+	searcher := &MockSearcher{SearchFunc: func(_ context.Context, q StructuredQuery) ([]SearchResult, error) {
+		if q.KNN != nil {
+			if len(q.Keywords) > 0 {
+				t.Errorf("Expected the kNN search to have no keywords, got %v", q.Keywords)
+			}
+			if fail {
+				return nil, errors.New("vector index unavailable")
+			}
+			return []SearchResult{{ID: "c", Score: 0.95}, {ID: "d", Score: 0.9}}, nil
+		}
+		return []SearchResult{{ID: "a", Score: 12}, {ID: "b", Score: 8}, {ID: "c", Score: 2}}, nil
+	}}
+	h := NewHandler(NewBroker(mockQU, []Searcher{searcher}))
+	search := func(target string) (int, SearchResponse) {
+		rec := httptest.NewRecorder()
+		h.HandleSearch(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var resp SearchResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	code, resp := search("/search?q=boots&mode=hybrid&knn_field=embedding&knn_vector=1,0&size=3&debug=true")
+	if code != http.StatusOK || joinedIDs(resp.Results) != "c,a,b" || resp.TotalHits != 4 {
+		t.Fatalf("Expected the fused ranking, got %d %s (%d hits)", code, joinedIDs(resp.Results), resp.TotalHits)
+	}
+	if quCalls != 1 {
+		t.Errorf("Expected query understanding to run for the keyword search only, got %d calls", quCalls)
+	}
+	if stages := resp.Debug.Stages; len(stages) == 0 || stages[len(stages)-1].Stage != StageFusion {
+		t.Errorf("Expected the fusion stage to be reported, got %+v", resp.Debug)
+	}
+
+	fail = true
+	code, resp = search("/search?q=boots&mode=hybrid&knn_field=embedding&knn_vector=1,0")
+	if code != http.StatusOK || joinedIDs(resp.Results) != "a,b,c" {
+		t.Errorf("Expected the keyword results when the kNN search fails, got %d %s", code, joinedIDs(resp.Results))
+	}
+
+	for _, target := range []string{
+		"/search?q=boots&mode=hybrid",
+		"/search?q=boots&mode=hybrid&knn_field=embedding&knn_vector=1,0&sort=price:asc",
+	} {
+		if code, _ := search(target); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", target, code)
+		}
+	}
+}
+
+func TestBroker_HybridSearch_SharedEmbedding(t *testing.T) {
+	var quCalls int32
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, q RawQuery) (StructuredQuery, error) {
+			atomic.AddInt32(&quCalls, 1)
+			return StructuredQuery{Keywords: strings.Fields(string(q)), Embedding: []float32{1, 0}, Filters: make([]Filter, 0, 4)}, nil
+		},
+	}
+	searcher := &MockSearcher{SearchFunc: func(_ context.Context, q StructuredQuery) ([]SearchResult, error) {
+		if q.KNN != nil {
+			if len(q.KNN.Vector) != 2 || len(q.Keywords) > 0 {
+				t.Errorf("Expected the kNN search of the embedding alone, got %+v", q)
+			}
+			return []SearchResult{{ID: "c", Score: 0.95}}, nil
+		}
+		return []SearchResult{{ID: "a", Score: 12}}, nil
+	}}
+	b := NewBroker(mockQU, []Searcher{searcher})
+
+	resp, err := b.SearchWithOptions(context.Background(), "boots", SearchOptions{Mode: ModeHybrid, KNN: &KNNQuery{Field: "embedding", K: 5}, Filters: []Filter{TermFilter("brand", "acme")}})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if joinedIDs(resp.Results) != "a,c" && joinedIDs(resp.Results) != "c,a" {
+		t.Errorf("Expected the fused results, got %s", joinedIDs(resp.Results))
+	}
+	if quCalls != 1 {
+		t.Errorf("Expected the text to be understood and embedded once, got %d calls", quCalls)
+	}
+}
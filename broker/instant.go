@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModeInstant selects search-as-you-type (SearchOptions.Mode): the query is taken as
+// typed so far, its last term matching as a prefix, under a shorter latency budget and a
+// lighter query understanding pipeline.
+const ModeInstant = "instant"
+
+// ErrSuperseded is returned for instant searches canceled by a newer instant search of the
+// same client, whose results would be discarded anyway.
+var ErrSuperseded = errors.New("superseded by a newer instant search")
+
+// InstantConfig tunes the instant search mode.
+type InstantConfig struct {
+	// Timeout is the latency budget of an instant search; 0 keeps the broker's budget.
+	Timeout time.Duration `yaml:"timeout"`
+	// Pipeline is the query understanding pipeline of instant searches, with only the
+	// cheap stages. Empty skips query understanding: the query is lower-cased and split
+	// on whitespace.
+	Pipeline string `yaml:"pipeline"`
+	// PrefixFields are the searcher fields indexed with edge n-grams the last term is
+	// matched against; empty matches it as the prefix of a term of any field.
+	PrefixFields []string `yaml:"prefix_fields"`
+	// Size is the number of results of instant searches that don't ask for a size.
+	Size int `yaml:"size"`
+}
+
+// DefaultInstantConfig returns the instant search settings of a new broker.
+func DefaultInstantConfig() InstantConfig {
+	return InstantConfig{Timeout: 100 * time.Millisecond, Pipeline: "instant_pipeline", Size: 5}
+}
+
+// Validate checks the timeout and size.
+func (c InstantConfig) Validate() error {
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid instant search timeout %s, must not be negative", c.Timeout)
+	}
+	if c.Size <= 0 || c.Size > maxPageSize {
+		return fmt.Errorf("invalid instant search size %d, must be between 1 and %d", c.Size, maxPageSize)
+	}
+	return nil
+}
+
+// SetInstantConfig sets the instant search settings, which must be valid.
+func (b *Broker) SetInstantConfig(cfg InstantConfig) {
+	b.instant = cfg
+}
+
+// instantKeywords splits a query like the cheapest query understanding pipeline.
+func instantKeywords(rawQuery RawQuery) []string {
+	return strings.Fields(strings.ToLower(string(rawQuery)))
+}
+
+// prefixSearch reports whether the last term of a query is being typed: it isn't followed
+// by a space.
+func prefixSearch(rawQuery RawQuery) bool {
+	q := string(rawQuery)
+	return strings.TrimSpace(q) != "" && !strings.HasSuffix(q, " ")
+}
+
+// instantDebouncer keeps the latest instant search of every client, canceling the one it
+// replaces: as a user types, only the search of the latest keystroke keeps running.
+type instantDebouncer struct {
+	mu       sync.Mutex
+	searches map[string]*instantSearch // By tenant and client ID
+}
+
+// instantSearch is the running instant search of a client.
+type instantSearch struct {
+	cancel context.CancelCauseFunc
+}
+
+func newInstantDebouncer() *instantDebouncer {
+	return &instantDebouncer{searches: make(map[string]*instantSearch)}
+}
+
+// start registers a new instant search of a client, superseding its previous one, and
+// returns its context and the function to call once it's done.
+func (d *instantDebouncer) start(ctx context.Context, client string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	search := &instantSearch{cancel: cancel}
+	d.mu.Lock()
+	if previous, ok := d.searches[client]; ok {
+		previous.cancel(ErrSuperseded)
+	}
+	d.searches[client] = search
+	d.mu.Unlock()
+	return ctx, func() {
+		d.mu.Lock()
+		if d.searches[client] == search {
+			delete(d.searches, client)
+		}
+		d.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// InstantResponse is the trimmed response of an instant search, holding only what a
+// type-ahead UI renders.
+type InstantResponse struct {
+	QueryID string          `json:"query_id"`
+	Query   string          `json:"query"`
+	TookMs  int64           `json:"took_ms"`
+	Results []InstantResult `json:"results"`
+}
+
+// InstantResult is a result of an instant search.
+type InstantResult struct {
+	ID     string                 `json:"id"`
+	Title  string                 `json:"title,omitempty"`
+	URL    string                 `json:"url,omitempty"`
+	Fields map[string]interface{} `json:"fields,omitempty"` // The stored fields asked for
+}
+
+// NewInstantResponse trims the response of an instant search of rawQuery.
+func NewInstantResponse(rawQuery RawQuery, resp *SearchResponse) *InstantResponse {
+	instant := &InstantResponse{QueryID: resp.QueryID, Query: string(rawQuery), TookMs: resp.TookMs, Results: make([]InstantResult, 0, len(resp.Results))}
+	for _, r := range resp.Results {
+		instant.Results = append(instant.Results, InstantResult{ID: r.ID, Title: r.Title, URL: r.URL, Fields: r.Fields})
+	}
+	return instant
+}
@@ -0,0 +1,165 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBroker_Search_Instant(t *testing.T) {
+	var pipeline string
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(ctx context.Context, rawQuery RawQuery) (StructuredQuery, error) {
+			pipeline = PipelineFromContext(ctx)
+			return StructuredQuery{Keywords: instantKeywords(rawQuery)}, nil
+		},
+	}
+	var (
+		searched StructuredQuery
+		deadline time.Duration
+	)
+	searcher := &MockSearcher{
+		SearchFunc: func(ctx context.Context, query StructuredQuery) ([]SearchResult, error) {
+			searched = query
+			if d, ok := ctx.Deadline(); ok {
+				deadline = time.Until(d)
+			}
+			return []SearchResult{{ID: "a", Score: 1}}, nil
+		},
+	}
+	b := NewBroker(mockQU, []Searcher{searcher})
+	b.SetTimeoutBudget(TimeoutBudget{Total: time.Second, QUFraction: 0.25})
+	b.SetInstantConfig(InstantConfig{Timeout: 50 * time.Millisecond, Pipeline: "light", PrefixFields: []string{"title.autocomplete"}, Size: 5})
+
+	if _, err := b.SearchWithOptions(context.Background(), "Red Sh", SearchOptions{Mode: ModeInstant}); err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if pipeline != "light" {
+		t.Errorf("Expected the instant pipeline, got %q", pipeline)
+	}
+	if !searched.Prefix || len(searched.PrefixFields) != 1 || searched.PrefixFields[0] != "title.autocomplete" {
+		t.Errorf("Expected a prefix search on the edge n-gram fields, got %+v", searched)
+	}
+	if deadline <= 0 || deadline > 50*time.Millisecond {
+		t.Errorf("Expected the instant budget of 50ms, got a deadline in %s", deadline)
+	}
+
+	// A trailing space ends the last word.
+	if _, err := b.SearchWithOptions(context.Background(), "red shoes ", SearchOptions{Mode: ModeInstant}); err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if searched.Prefix {
+		t.Error("Expected no prefix search after a complete word")
+	}
+
+	// Without an instant pipeline, query understanding is skipped.
+	b.SetInstantConfig(InstantConfig{Size: 5})
+	mockQU.ProcessFunc = func(context.Context, RawQuery) (StructuredQuery, error) {
+		t.Error("Expected query understanding to be skipped")
+		return StructuredQuery{}, nil
+	}
+	if _, err := b.SearchWithOptions(context.Background(), "Red Sh", SearchOptions{Mode: ModeInstant}); err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if len(searched.Keywords) != 2 || searched.Keywords[0] != "red" || searched.Keywords[1] != "sh" {
+		t.Errorf("Expected the lower-cased keywords, got %v", searched.Keywords)
+	}
+}
+
+func TestBroker_Search_InstantDebounce(t *testing.T) {
+	started := make(chan struct{}, 1)
+	searcher := &MockSearcher{
+		SearchFunc: func(ctx context.Context, query StructuredQuery) ([]SearchResult, error) {
+			if query.Keywords[0] == "sh" {
+				started <- struct{}{}
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return []SearchResult{{ID: "shoes", Score: 1}}, nil
+		},
+	}
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{searcher})
+	b.SetInstantConfig(InstantConfig{Size: 5})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := b.SearchWithOptions(context.Background(), "sh", SearchOptions{Mode: ModeInstant, ClientID: "alice"})
+		errs <- err
+	}()
+	<-started
+
+	// Another client's search leaves it running.
+	if _, err := b.SearchWithOptions(context.Background(), "sho", SearchOptions{Mode: ModeInstant, ClientID: "bob"}); err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("Expected the search of another client to leave it running, got %v", err)
+	default:
+	}
+
+	resp, err := b.SearchWithOptions(context.Background(), "sho", SearchOptions{Mode: ModeInstant, ClientID: "alice"})
+	if err != nil || len(resp.Results) != 1 {
+		t.Fatalf("Expected the latest search to succeed, got %+v (%v)", resp, err)
+	}
+	if err := <-errs; !errors.Is(err, ErrSuperseded) {
+		t.Errorf("Expected the previous search to be superseded, got %v", err)
+	}
+}
+
+func TestHandleSearch_Instant(t *testing.T) {
+	h := NewHandler(newTestBroker())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sh&mode=instant", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp map[string]json.RawMessage
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp["shards"]; ok {
+		t.Errorf("Expected a trimmed response, got %v", resp)
+	}
+	var results []InstantResult
+	json.Unmarshal(resp["results"], &results)
+	if len(results) != 3 || results[0].ID != "b" {
+		t.Errorf("Unexpected results %+v", results)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sh&mode=fast", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown mode, got %d", rec.Code)
+	}
+}
+
+func TestInstant_PrefixPassedThrough(t *testing.T) {
+	var got url.Values
+	searcher := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		w.Write([]byte(`{"total_hits":1,"results":[{"id":"doc1","score":0.5}]}`))
+	}))
+	defer searcher.Close()
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{NewHTTPSearcher(searcher.URL, 0)})
+	b.SetInstantConfig(InstantConfig{PrefixFields: []string{"title.autocomplete", "brand.autocomplete"}, Size: 5})
+
+	if _, err := b.SearchWithOptions(context.Background(), "red sh", SearchOptions{Mode: ModeInstant}); err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if got.Get("prefix") != "true" || got.Get("prefix_fields") != "title.autocomplete,brand.autocomplete" || got.Get("q") != "red sh" {
+		t.Errorf("Expected a prefix search on the edge n-gram fields, got %v", got)
+	}
+
+	if _, err := b.SearchWithOptions(context.Background(), "red sh", SearchOptions{}); err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if got.Has("prefix") || got.Has("prefix_fields") {
+		t.Errorf("Expected no prefix parameters outside instant mode, got %v", got)
+	}
+}
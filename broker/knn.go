@@ -0,0 +1,106 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"common/vector"
+)
+
+const (
+	// defaultK is the number of neighbors returned by kNN searches without k.
+	defaultK = 10
+	// maxK bounds the neighbors of a kNN search.
+	maxK = 1000
+)
+
+// ErrNoQueryEmbedding is returned for kNN searches without a vector whose text query
+// understanding didn't embed.
+var ErrNoQueryEmbedding = errors.New("no query embedding")
+
+// KNNQuery asks for the K documents whose vector in Field, e.g. a text embedding, is the
+// most similar to Vector. Every shard returns its K nearest neighbors among the documents
+// matching the rest of the search, which the Broker merges by similarity. NumCandidates,
+// the neighbors every shard looks up before filtering, defaults to the searchers' own.
+// Without Vector, the embedding of the search's text computed by query understanding is
+// searched, which requires a pipeline embedding queries.
+type KNNQuery struct {
+	Field         string    `json:"field"`
+	Vector        []float32 `json:"query_vector"`
+	K             int       `json:"k"`
+	NumCandidates int       `json:"num_candidates,omitempty"`
+}
+
+// ParseKNNQuery reads the "knn_field", "knn_vector" (comma-separated numbers), "k" and
+// "num_candidates" parameters. It returns nil without field and vector; knn_vector may be
+// left out to search the embedding of the query text.
+func ParseKNNQuery(field, rawVector, k, numCandidates string) (*KNNQuery, error) {
+	if field == "" && rawVector == "" {
+		if k != "" || numCandidates != "" {
+			return nil, fmt.Errorf("k and num_candidates require knn_field and knn_vector")
+		}
+		return nil, nil
+	}
+	q := &KNNQuery{Field: field}
+	var err error
+	if rawVector != "" {
+		if q.Vector, err = vector.Parse(rawVector); err != nil {
+			return nil, fmt.Errorf("invalid knn_vector: %w", err)
+		}
+	}
+	if k != "" {
+		if q.K, err = strconv.Atoi(k); err != nil {
+			return nil, fmt.Errorf("invalid k %q", k)
+		}
+	}
+	if numCandidates != "" {
+		if q.NumCandidates, err = strconv.Atoi(numCandidates); err != nil {
+			return nil, fmt.Errorf("invalid num_candidates %q", numCandidates)
+		}
+	}
+	return q, q.validate()
+}
+
+// validate checks the query, defaulting K.
+func (q *KNNQuery) validate() error {
+	if q.Field == "" {
+		return fmt.Errorf("a kNN query requires a field")
+	}
+	if q.K == 0 {
+		q.K = defaultK
+	}
+	if q.K < 0 || q.K > maxK {
+		return fmt.Errorf("invalid k %d, must be between 1 and %d", q.K, maxK)
+	}
+	if q.NumCandidates != 0 && q.NumCandidates < q.K {
+		return fmt.Errorf("invalid num_candidates %d, must be at least k", q.NumCandidates)
+	}
+	return nil
+}
+
+// nearestNeighbors keeps the k results of the best scores among the merged neighbors of
+// every shard, in their order: results ordered by score (byScore) are truncated, others
+// keep those scoring at least as well as the kth best.
+func nearestNeighbors(results []SearchResult, k int, byScore bool) []SearchResult {
+	if len(results) <= k {
+		return results
+	}
+	if byScore {
+		return results[:k]
+	}
+	scores := make([]float64, len(results))
+	for i, r := range results {
+		scores[i] = r.Score
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(scores)))
+	threshold := scores[k-1]
+	kept := make([]SearchResult, 0, k)
+	for _, r := range results {
+		if r.Score >= threshold && len(kept) < k {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,195 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseKNNQuery(t *testing.T) {
+	if q, err := ParseKNNQuery("", "", "", ""); q != nil || err != nil {
+		t.Errorf("Expected no kNN query, got %+v (%v)", q, err)
+	}
+	q, err := ParseKNNQuery("embedding", "0.5,1", "", "")
+	if err != nil || q.K != defaultK || len(q.Vector) != 2 || q.NumCandidates != 0 {
+		t.Errorf("Unexpected kNN query %+v (%v)", q, err)
+	}
+	if q, err := ParseKNNQuery("embedding", "", "5", ""); err != nil || q.K != 5 || q.Vector != nil {
+		t.Errorf("Expected a kNN query of the query embedding, got %+v (%v)", q, err)
+	}
+	invalid := [][4]string{
+		{"", "1,2", "", ""},
+		{"", "", "3", ""},
+		{"embedding", "1,x", "", ""},
+		{"embedding", "1", "-1", ""},
+		{"embedding", "1", "20", "10"},
+	}
+	for _, params := range invalid {
+		if _, err := ParseKNNQuery(params[0], params[1], params[2], params[3]); err == nil {
+			t.Errorf("Expected an error for %q", params)
+		}
+	}
+}
+
+func TestBroker_KNNSearch(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(context.Context, RawQuery) (StructuredQuery, error) {
+			t.Error("Expected query understanding to be skipped without text")
+			return StructuredQuery{}, nil
+		},
+	}
+	var (
+		mu       sync.Mutex
+		searched []StructuredQuery
+	)
+	shard := func(id int, results ...SearchResult) *MockSearcher {
+		return &MockSearcher{ShardID: id, SearchFunc: func(_ context.Context, q StructuredQuery) ([]SearchResult, error) {
+			mu.Lock()
+			searched = append(searched, q)
+			mu.Unlock()
+			return results, nil
+		}}
+	}
+	b := NewBroker(mockQU, []Searcher{
+		shard(0, SearchResult{ID: "a", Score: 0.9}, SearchResult{ID: "b", Score: 0.6}),
+		shard(1, SearchResult{ID: "c", Score: 0.8}, SearchResult{ID: "d", Score: 0.7}),
+	})
+	h := NewHandler(b)
+
+	rec := httptest.NewRecorder()
+	h.HandleSearch(rec, httptest.NewRequest(http.MethodGet, "/search?knn_field=embedding&knn_vector=1,0,0&k=3", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range resp.Results {
+		ids = append(ids, r.ID)
+	}
+	if strings.Join(ids, ",") != "a,c,d" || resp.TotalHits != 3 {
+		t.Errorf("Expected the 3 nearest neighbors across shards, got %v (%d hits)", ids, resp.TotalHits)
+	}
+	if len(searched) != 2 {
+		t.Fatalf("Expected every shard to be searched, got %d searches", len(searched))
+	}
+	if knn := searched[0].KNN; knn == nil || knn.Field != "embedding" || knn.K != 3 || len(knn.Vector) != 3 {
+		t.Errorf("Expected the kNN query to be passed to the searchers, got %+v", knn)
+	}
+
+	rec = httptest.NewRecorder()
+	h.HandleSearch(rec, httptest.NewRequest(http.MethodGet, "/search?knn_field=embedding&knn_vector=1,a", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid vector, got %d", rec.Code)
+	}
+}
+
+func TestNearestNeighbors_SortedByField(t *testing.T) {
+	results := []SearchResult{{ID: "cheap", Score: 0.2}, {ID: "mid", Score: 0.9}, {ID: "pricey", Score: 0.8}}
+	got := nearestNeighbors(results, 2, false)
+	if len(got) != 2 || got[0].ID != "mid" || got[1].ID != "pricey" {
+		t.Errorf("Expected the 2 best scores in field order, got %+v", got)
+	}
+}
+
+func TestHTTPSearcher_KNN(t *testing.T) {
+	var got url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		w.Write([]byte(`{"total_hits":1,"results":[{"id":"doc1","score":0.5}]}`))
+	}))
+	defer server.Close()
+
+	knn := &KNNQuery{Field: "embedding", Vector: []float32{0.5, -1}, K: 5, NumCandidates: 50}
+	if _, err := NewHTTPSearcher(server.URL, 0).Search(context.Background(), StructuredQuery{KNN: knn}); err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+	if got.Get("knn_field") != "embedding" || got.Get("knn_vector") != "0.5,-1" || got.Get("k") != "5" || got.Get("num_candidates") != "50" {
+		t.Errorf("Unexpected kNN parameters %v", got)
+	}
+}
+
+func TestHandleESSearch_KNN(t *testing.T) {
+	var searched StructuredQuery
+	searcher := &MockSearcher{SearchFunc: func(_ context.Context, q StructuredQuery) ([]SearchResult, error) {
+		searched = q
+		return nil, nil
+	}}
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{searcher}))
+	body := `{"knn": {"field": "embedding", "query_vector": [1, 0], "k": 4, "num_candidates": 40}, "query": {"bool": {"filter": {"term": {"brand": "acme"}}}}}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_search", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if knn := searched.KNN; knn == nil || knn.K != 4 || knn.NumCandidates != 40 || len(searched.Filters) != 1 {
+		t.Errorf("Expected a filtered kNN search, got %+v", searched)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_search", strings.NewReader(`{"knn": {"field": "embedding"}}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a kNN query without vector, got %d", rec.Code)
+	}
+}
+
+func TestBroker_KNNSearchOfQueryEmbedding(t *testing.T) {
+	embedding := []float32{0.6, 0.8}
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, q RawQuery) (StructuredQuery, error) {
+			sq := StructuredQuery{Keywords: strings.Fields(string(q))}
+			if q != "unembedded" {
+				sq.Embedding = embedding
+			}
+			return sq, nil
+		},
+	}
+	var (
+		mu       sync.Mutex
+		searched []StructuredQuery
+	)
+	searcher := &MockSearcher{SearchFunc: func(_ context.Context, q StructuredQuery) ([]SearchResult, error) {
+		mu.Lock()
+		searched = append(searched, q)
+		mu.Unlock()
+		return []SearchResult{{ID: "a", Score: 0.9}}, nil
+	}}
+	h := NewHandler(NewBroker(mockQU, []Searcher{searcher}))
+	search := func(target string) int {
+		searched = nil
+		rec := httptest.NewRecorder()
+		h.HandleSearch(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec.Code
+	}
+
+	if code := search("/search?q=summer+dress&knn_field=embedding&k=5"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if len(searched) != 1 || searched[0].KNN == nil || fmt.Sprint(searched[0].KNN.Vector) != fmt.Sprint(embedding) || len(searched[0].Keywords) != 2 {
+		t.Errorf("Expected the query embedding to be searched with the keywords, got %+v", searched)
+	}
+
+	if code := search("/search?q=summer+dress&mode=hybrid&knn_field=embedding"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	for _, q := range searched {
+		if q.KNN != nil && (len(q.Keywords) > 0 || len(q.KNN.Vector) != 2) {
+			t.Errorf("Expected the kNN search of the hybrid search to search the embedding alone, got %+v", q)
+		}
+	}
+	if len(searched) != 2 {
+		t.Errorf("Expected a keyword and a kNN search, got %d searches", len(searched))
+	}
+
+	if code := search("/search?q=unembedded&knn_field=embedding"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a query embedding, got %d", code)
+	}
+}
@@ -0,0 +1,116 @@
+// Command loadgen sends search traffic to a broker at a target rate and reports latency
+// percentiles and error rates:
+//
+//	loadgen -target http://localhost:8080 -qps 200 -duration 1m -query-log queries.log
+//	loadgen -target http://localhost:8080 -qps 50 -vocab terms.txt -mix search=9,suggest=1
+//
+// Queries are replayed from a query log written by the broker's file sink, or drawn
+// from a vocabulary file with a term per line, most frequent first.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"broker/loadgen"
+)
+
+// defaultVocabulary is used for synthetic queries when no vocabulary file is given.
+var defaultVocabulary = []string{
+	"shoes", "red", "running", "jacket", "men", "women", "black", "sale", "boots", "blue",
+	"leather", "kids", "summer", "dress", "shirt", "waterproof", "white", "bag", "hat", "wool",
+}
+
+func main() {
+	target := flag.String("target", "http://localhost:8080", "Base URL of the broker")
+	tenantID := flag.String("tenant", "", "Tenant the requests are sent for")
+	qps := flag.Float64("qps", 10, "Target request rate")
+	concurrency := flag.Int("concurrency", 50, "Maximum requests in flight; requests due beyond it are dropped")
+	duration := flag.Duration("duration", 30*time.Second, "How long requests are sent")
+	timeout := flag.Duration("timeout", 5*time.Second, "Timeout of each request")
+	mixFlag := flag.String("mix", "search=1", "Relative weights of the request kinds (search, suggest)")
+	queryLog := flag.String("query-log", "", "Query log to replay; synthetic queries are sent if empty")
+	vocabFile := flag.String("vocab", "", "Vocabulary of synthetic queries, a term per line, most frequent first")
+	maxTerms := flag.Int("max-terms", 3, "Maximum terms of synthetic queries")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "Seed of the random draws, to reproduce a run")
+	format := flag.String("format", "text", "Report format: text or json")
+	flag.Parse()
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown report format %q", *format)
+	}
+	mix, err := loadgen.ParseMix(*mixFlag)
+	if err != nil {
+		log.Fatalf("Invalid mix: %v", err)
+	}
+
+	var source loadgen.Source
+	if *queryLog != "" {
+		source, err = loadgen.NewReplayFileSource(*queryLog)
+	} else {
+		vocab := defaultVocabulary
+		if *vocabFile != "" {
+			vocab, err = readVocabulary(*vocabFile)
+			if err != nil {
+				log.Fatalf("Failed to read vocabulary: %v", err)
+			}
+		}
+		source, err = loadgen.NewSyntheticSource(vocab, *maxTerms, *seed)
+	}
+	if err != nil {
+		log.Fatalf("Failed to create query source: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Sending %.1f req/s to %s for %s", *qps, *target, *duration)
+	report, err := loadgen.Run(ctx, loadgen.Config{
+		Target:      *target,
+		Tenant:      *tenantID,
+		QPS:         *qps,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Timeout:     *timeout,
+		Mix:         mix,
+		Seed:        *seed,
+	}, source)
+	if err != nil {
+		log.Fatalf("Load test failed: %v", err)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
+
+// readVocabulary reads the non-empty lines of the file at path.
+func readVocabulary(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var vocab []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if term := strings.TrimSpace(scanner.Text()); term != "" {
+			vocab = append(vocab, term)
+		}
+	}
+	return vocab, scanner.Err()
+}
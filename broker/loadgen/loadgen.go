@@ -0,0 +1,308 @@
+// Package loadgen generates search traffic against a broker at a target rate and
+// measures its latency and errors, to validate capacity before deployments.
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"common/tenant"
+)
+
+// Kinds of requests sent to the broker.
+const (
+	KindSearch  = "search"  // GET /search with the query
+	KindSuggest = "suggest" // GET /suggest with a prefix of the query
+)
+
+// ErrInvalidConfig is returned for load tests that can't be run.
+var ErrInvalidConfig = errors.New("invalid load test")
+
+// Query is a query sent by the load test, with the collection it targets if any.
+type Query struct {
+	Text       string
+	Collection string
+}
+
+// Source provides the queries of a load test. Implementations are safe for concurrent use.
+type Source interface {
+	Next() Query
+}
+
+// replaySource replays the searches of a query log, in order, starting over at the end.
+type replaySource struct {
+	mu      sync.Mutex
+	queries []Query
+	next    int
+}
+
+// NewReplaySource returns a source replaying the searches of the query log read from r,
+// as written by the broker's file sink: a JSON record per line. Feedback and failed
+// searches are skipped.
+func NewReplaySource(r io.Reader) (Source, error) {
+	var queries []Query
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record struct {
+			Event      string `json:"event"`
+			Query      string `json:"query"`
+			Collection string `json:"collection"`
+			Error      string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid query log record on line %d: %w", line, err)
+		}
+		if record.Event != "search" || record.Error != "" || record.Query == "" {
+			continue
+		}
+		queries = append(queries, Query{Text: record.Query, Collection: record.Collection})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read query log: %w", err)
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("%w: the query log has no searches", ErrInvalidConfig)
+	}
+	return &replaySource{queries: queries}, nil
+}
+
+// NewReplayFileSource is NewReplaySource for the query log file at path.
+func NewReplayFileSource(path string) (Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open query log: %w", err)
+	}
+	defer f.Close()
+	return NewReplaySource(f)
+}
+
+func (s *replaySource) Next() Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queries[s.next]
+	s.next = (s.next + 1) % len(s.queries)
+	return q
+}
+
+// syntheticSource draws queries of 1 to maxTerms terms from a vocabulary, with a Zipf
+// distribution so a few head terms are frequent and most are rare, like real traffic.
+type syntheticSource struct {
+	mu       sync.Mutex
+	rng      *rand.Rand
+	zipf     *rand.Zipf
+	vocab    []string
+	maxTerms int
+}
+
+// NewSyntheticSource returns a source of queries of up to maxTerms terms of vocab, in
+// order of decreasing frequency. seed makes runs reproducible.
+func NewSyntheticSource(vocab []string, maxTerms int, seed int64) (Source, error) {
+	if len(vocab) == 0 {
+		return nil, fmt.Errorf("%w: empty vocabulary", ErrInvalidConfig)
+	}
+	if maxTerms <= 0 {
+		return nil, fmt.Errorf("%w: queries need at least one term", ErrInvalidConfig)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	return &syntheticSource{
+		rng:      rng,
+		zipf:     rand.NewZipf(rng, 1.1, 1, uint64(len(vocab)-1)),
+		vocab:    vocab,
+		maxTerms: maxTerms,
+	}, nil
+}
+
+func (s *syntheticSource) Next() Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	terms := make([]string, 1+s.rng.Intn(s.maxTerms))
+	for i := range terms {
+		terms[i] = s.vocab[s.zipf.Uint64()]
+	}
+	return Query{Text: strings.Join(terms, " ")}
+}
+
+// Mix is the share of each kind of request, by relative weight.
+type Mix map[string]int
+
+// ParseMix parses a mix such as "search=9,suggest=1".
+func ParseMix(raw string) (Mix, error) {
+	mix := Mix{}
+	for _, part := range strings.Split(raw, ",") {
+		kind, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: mix entry %q is not kind=weight", ErrInvalidConfig, part)
+		}
+		if kind != KindSearch && kind != KindSuggest {
+			return nil, fmt.Errorf("%w: unknown request kind %q", ErrInvalidConfig, kind)
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: invalid weight %q of %s", ErrInvalidConfig, weight, kind)
+		}
+		mix[kind] = n
+	}
+	return mix, nil
+}
+
+// pick draws a kind of request.
+func (m Mix) pick(rng *rand.Rand) string {
+	kinds := make([]string, 0, len(m))
+	total := 0
+	for kind, weight := range m {
+		kinds = append(kinds, kind)
+		total += weight
+	}
+	sort.Strings(kinds) // Map order is random; keep runs reproducible
+	point := rng.Intn(total)
+	for _, kind := range kinds {
+		point -= m[kind]
+		if point < 0 {
+			return kind
+		}
+	}
+	return kinds[len(kinds)-1]
+}
+
+// Config configures a load test.
+type Config struct {
+	Target      string        // Base URL of the broker, e.g. http://localhost:8080
+	Tenant      string        // Tenant the requests are sent for; empty for the default one
+	QPS         float64       // Target request rate
+	Concurrency int           // Maximum requests in flight
+	Duration    time.Duration // How long requests are sent
+	Timeout     time.Duration // Timeout of each request
+	Mix         Mix           // Share of each kind of request; nil sends only searches
+	Seed        int64         // Seed of the request kind draws
+}
+
+// Validate checks the configuration.
+func (c *Config) Validate() error {
+	if u, err := url.Parse(c.Target); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%w: invalid target URL %q", ErrInvalidConfig, c.Target)
+	}
+	if c.QPS <= 0 || c.Concurrency <= 0 || c.Duration <= 0 {
+		return fmt.Errorf("%w: QPS, concurrency and duration must be positive", ErrInvalidConfig)
+	}
+	total := 0
+	for _, weight := range c.Mix {
+		total += weight
+	}
+	if c.Mix != nil && total == 0 {
+		return fmt.Errorf("%w: the mix has no positive weight", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Run sends requests drawn from source to the broker at the configured rate until the
+// duration elapses or ctx is done, then waits for the requests in flight. The load is
+// open-loop: requests are due at fixed intervals whatever the broker's latency, and
+// requests due while Concurrency requests are in flight are dropped and counted, since
+// a saturated broker would otherwise hide behind a falling request rate.
+func Run(ctx context.Context, cfg Config, source Source) (*Report, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	mix := cfg.Mix
+	if mix == nil {
+		mix = Mix{KindSearch: 1}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.Concurrency // Reuse connections instead of exhausting ports
+	client := &http.Client{Timeout: cfg.Timeout, Transport: transport}
+	base := strings.TrimRight(cfg.Target, "/")
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	rec := newRecorder()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.QPS))
+	defer ticker.Stop()
+	slots := make(chan struct{}, cfg.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+		kind := mix.pick(rng)
+		select {
+		case slots <- struct{}{}:
+		default:
+			rec.drop(kind)
+			continue
+		}
+		req, err := newRequest(base, kind, source.Next(), cfg.Tenant)
+		if err != nil {
+			<-slots
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			sent := time.Now()
+			status, err := send(client, req)
+			rec.record(kind, time.Since(sent), status, err)
+		}()
+	}
+	wg.Wait()
+	return rec.report(time.Since(start)), nil
+}
+
+// newRequest builds the request of the given kind for query q.
+func newRequest(base, kind string, q Query, tenantID string) (*http.Request, error) {
+	params := url.Values{}
+	text := q.Text
+	if kind == KindSuggest {
+		// Suggestions are requested as users type: send a prefix of the query.
+		if runes := []rune(text); len(runes) > 1 {
+			text = string(runes[:len(runes)/2])
+		}
+	}
+	params.Set("q", text)
+	if q.Collection != "" {
+		params.Set("collection", q.Collection)
+	}
+	req, err := http.NewRequest(http.MethodGet, base+"/"+kind+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", kind, err)
+	}
+	if tenantID != "" {
+		req.Header.Set(tenant.Header, tenantID)
+	}
+	return req, nil
+}
+
+// send sends the request and returns its status code once the body is read.
+func send(client *http.Client, req *http.Request) (int, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
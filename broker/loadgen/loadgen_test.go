@@ -0,0 +1,141 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewReplaySource(t *testing.T) {
+	log := `{"event":"search","query":"red shoes","collection":"products"}
+{"event":"click","query":"red shoes","doc_id":"a"}
+{"event":"search","query":"broken","error":"boom"}
+
+{"event":"search","query":"boots"}
+`
+	source, err := NewReplaySource(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("NewReplaySource returned an error: %v", err)
+	}
+	want := []Query{{Text: "red shoes", Collection: "products"}, {Text: "boots"}, {Text: "red shoes", Collection: "products"}}
+	for i, w := range want {
+		if got := source.Next(); got != w {
+			t.Errorf("Query %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+
+	if _, err := NewReplaySource(strings.NewReader(`{"event":"click"}`)); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a log without searches, got %v", err)
+	}
+}
+
+func TestNewSyntheticSource(t *testing.T) {
+	vocab := []string{"head", "b", "c", "d", "e", "f", "g", "h"}
+	source, err := NewSyntheticSource(vocab, 2, 1)
+	if err != nil {
+		t.Fatalf("NewSyntheticSource returned an error: %v", err)
+	}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		terms := strings.Fields(source.Next().Text)
+		if len(terms) < 1 || len(terms) > 2 {
+			t.Fatalf("Expected 1 or 2 terms, got %v", terms)
+		}
+		for _, term := range terms {
+			counts[term]++
+		}
+	}
+	if counts["head"] <= counts["h"] {
+		t.Errorf("Expected the first term to be the most frequent, got %v", counts)
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	mix, err := ParseMix("search=9, suggest=1")
+	if err != nil || mix[KindSearch] != 9 || mix[KindSuggest] != 1 {
+		t.Errorf("Unexpected mix %v (error %v)", mix, err)
+	}
+	for _, invalid := range []string{"search", "browse=1", "search=-1", "search=x"} {
+		if _, err := ParseMix(invalid); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var mu sync.Mutex
+	paths := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+		if r.Header.Get("X-Tenant-ID") != "acme" {
+			http.Error(w, "missing tenant", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/suggest" {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"results":[]}`))
+	}))
+	defer server.Close()
+
+	source, err := NewSyntheticSource([]string{"shoes", "boots"}, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Run(context.Background(), Config{
+		Target:      server.URL,
+		Tenant:      "acme",
+		QPS:         200,
+		Concurrency: 4,
+		Duration:    250 * time.Millisecond,
+		Timeout:     time.Second,
+		Mix:         Mix{KindSearch: 1, KindSuggest: 1},
+		Seed:        1,
+	}, source)
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+
+	if report.Total.Sent < 10 || len(report.Kinds) != 2 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	search, suggest := report.Kinds[0], report.Kinds[1]
+	if search.Kind != KindSearch || search.Errors != 0 || search.Sent != paths["/search"] {
+		t.Errorf("Unexpected search report %+v (server saw %d)", search, paths["/search"])
+	}
+	if suggest.Kind != KindSuggest || suggest.Errors != suggest.Sent || suggest.ByStatus["503"] != suggest.Sent {
+		t.Errorf("Expected every suggestion to fail with 503, got %+v", suggest)
+	}
+	if report.Total.P50Ms <= 0 || report.Total.P99Ms < report.Total.P50Ms || report.Total.MaxMs < report.Total.P99Ms {
+		t.Errorf("Unexpected latency percentiles: %+v", report.Total)
+	}
+
+	var out strings.Builder
+	if err := report.WriteText(&out); err != nil {
+		t.Fatalf("WriteText returned an error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Errors by status: 503=") {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	source, _ := NewSyntheticSource([]string{"shoes"}, 1, 1)
+	for _, cfg := range []Config{
+		{Target: "localhost:8080", QPS: 1, Concurrency: 1, Duration: time.Second},
+		{Target: "http://localhost:8080", QPS: 0, Concurrency: 1, Duration: time.Second},
+		{Target: "http://localhost:8080", QPS: 1, Concurrency: 1, Duration: time.Second, Mix: Mix{KindSearch: 0}},
+	} {
+		if _, err := Run(context.Background(), cfg, source); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for %+v, got %v", cfg, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// recorder collects the outcomes of the requests of a load test.
+type recorder struct {
+	mu    sync.Mutex
+	kinds map[string]*kindRecord
+}
+
+// kindRecord collects the outcomes of a kind of request.
+type kindRecord struct {
+	latencies []time.Duration // Of the requests answered, successfully or not
+	errors    map[string]int  // By status code, or "transport" for requests without response
+	dropped   int
+}
+
+func newRecorder() *recorder {
+	return &recorder{kinds: make(map[string]*kindRecord)}
+}
+
+func (r *recorder) kind(kind string) *kindRecord {
+	k, ok := r.kinds[kind]
+	if !ok {
+		k = &kindRecord{errors: make(map[string]int)}
+		r.kinds[kind] = k
+	}
+	return k
+}
+
+// record records a request that was sent.
+func (r *recorder) record(kind string, latency time.Duration, status int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := r.kind(kind)
+	switch {
+	case err != nil && status == 0:
+		k.errors["transport"]++
+		return
+	case err != nil || status != http.StatusOK:
+		k.errors[strconv.Itoa(status)]++
+	}
+	k.latencies = append(k.latencies, latency)
+}
+
+// drop records a request that wasn't sent because too many were in flight.
+func (r *recorder) drop(kind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.kind(kind).dropped++
+}
+
+// KindReport summarizes the requests of a kind.
+type KindReport struct {
+	Kind     string         `json:"kind"`
+	Sent     int            `json:"sent"`
+	Errors   int            `json:"errors"`
+	Dropped  int            `json:"dropped"` // Due while Concurrency requests were in flight
+	ByStatus map[string]int `json:"errors_by_status,omitempty"`
+	// Latency percentiles of the requests answered, in milliseconds.
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+	MeanMs float64 `json:"mean_ms"`
+}
+
+// ErrorRate is the share of the requests sent that failed.
+func (k KindReport) ErrorRate() float64 {
+	if k.Sent == 0 {
+		return 0
+	}
+	return float64(k.Errors) / float64(k.Sent)
+}
+
+// Report is the outcome of a load test.
+type Report struct {
+	Elapsed time.Duration `json:"elapsed"`
+	QPS     float64       `json:"qps"` // Requests sent per second
+	Total   KindReport    `json:"total"`
+	Kinds   []KindReport  `json:"kinds"`
+}
+
+func (r *recorder) report(elapsed time.Duration) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &Report{Elapsed: elapsed}
+	all := &kindRecord{errors: make(map[string]int)}
+	names := make([]string, 0, len(r.kinds))
+	for name := range r.kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		k := r.kinds[name]
+		report.Kinds = append(report.Kinds, k.summarize(name))
+		all.latencies = append(all.latencies, k.latencies...)
+		all.dropped += k.dropped
+		for status, n := range k.errors {
+			all.errors[status] += n
+		}
+	}
+	report.Total = all.summarize("total")
+	if elapsed > 0 {
+		report.QPS = float64(report.Total.Sent) / elapsed.Seconds()
+	}
+	return report
+}
+
+func (k *kindRecord) summarize(name string) KindReport {
+	kr := KindReport{Kind: name, Dropped: k.dropped, Sent: len(k.latencies)}
+	if len(k.errors) > 0 {
+		kr.ByStatus = make(map[string]int, len(k.errors))
+	}
+	for status, n := range k.errors {
+		kr.ByStatus[status] = n
+		kr.Errors += n
+	}
+	kr.Sent += k.errors["transport"] // Transport errors have no latency
+	if len(k.latencies) == 0 {
+		return kr
+	}
+	sorted := append([]time.Duration(nil), k.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, l := range sorted {
+		sum += l
+	}
+	kr.P50Ms = ms(percentile(sorted, 0.50))
+	kr.P90Ms = ms(percentile(sorted, 0.90))
+	kr.P99Ms = ms(percentile(sorted, 0.99))
+	kr.MaxMs = ms(sorted[len(sorted)-1])
+	kr.MeanMs = ms(sum / time.Duration(len(sorted)))
+	return kr
+}
+
+// percentile returns the nearest-rank percentile p of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteText writes the report as a table with a row per kind of request and the total.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%d requests in %s (%.1f req/s)\n", r.Total.Sent, r.Elapsed.Round(time.Millisecond), r.QPS)
+	fmt.Fprintf(tw, "KIND\tSENT\tERRORS\tERROR RATE\tDROPPED\tP50 MS\tP90 MS\tP99 MS\tMAX MS\t\n")
+	for _, k := range append(append([]KindReport(nil), r.Kinds...), r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t\n",
+			k.Kind, k.Sent, k.Errors, 100*k.ErrorRate(), k.Dropped, k.P50Ms, k.P90Ms, k.P99Ms, k.MaxMs)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(r.Total.ByStatus) > 0 {
+		statuses := make([]string, 0, len(r.Total.ByStatus))
+		for status := range r.Total.ByStatus {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		fmt.Fprint(w, "Errors by status:")
+		for _, status := range statuses {
+			fmt.Fprintf(w, " %s=%d", status, r.Total.ByStatus[status])
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
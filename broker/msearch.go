@@ -0,0 +1,240 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxMultiSearchQueries bounds the queries of a /msearch batch.
+	maxMultiSearchQueries = 100
+	// multiSearchConcurrency bounds the queries of a batch searched at once.
+	multiSearchConcurrency = 8
+)
+
+// MultiSearchQuery is a query of a batch, with its options.
+type MultiSearchQuery struct {
+	Query   RawQuery
+	Options SearchOptions
+}
+
+// MultiSearchResult is the outcome of a query of a batch: its response, or the error it
+// failed with.
+type MultiSearchResult struct {
+	Response *SearchResponse
+	Err      error
+}
+
+// MultiSearchStats reports how a batch was searched.
+type MultiSearchStats struct {
+	ShardRequests       int `json:"shard_requests"`        // Requests sent to shards
+	SharedShardRequests int `json:"shared_shard_requests"` // Shard requests answered by an identical one of another query
+}
+
+// MultiSearch runs a batch of searches, each like SearchWithOptions, returning their
+// results in the order of the queries. Up to multiSearchConcurrency queries are searched
+// at once, and queries sending the same structured query to the same shard share a
+// single shard request, so batches of related queries (dashboards, A/B evaluations)
+// cost less than as many separate searches.
+func (b *Broker) MultiSearch(ctx context.Context, queries []MultiSearchQuery) ([]MultiSearchResult, MultiSearchStats) {
+	fanOut := &sharedFanOut{calls: make(map[string]*shardCall)}
+	results := make([]MultiSearchResult, len(queries))
+	slots := make(chan struct{}, multiSearchConcurrency)
+	var wg sync.WaitGroup
+	for n, q := range queries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(n int, q MultiSearchQuery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			opts := q.Options
+			opts.fanOut = fanOut
+			resp, err := b.SearchWithOptions(ctx, q.Query, opts)
+			results[n] = MultiSearchResult{Response: resp, Err: err}
+		}(n, q)
+	}
+	wg.Wait()
+	return results, fanOut.stats()
+}
+
+// sharedFanOut deduplicates the shard requests of the searches of a batch: the first
+// search sending a structured query to a shard makes the request, and the others sending
+// the same one wait for its outcome.
+type sharedFanOut struct {
+	mu     sync.Mutex
+	calls  map[string]*shardCall
+	shared int
+}
+
+// shardCall is a shard request shared by the searches of a batch.
+type shardCall struct {
+	done     chan struct{} // Closed once results, segment, ok and attempts are set
+	results  []SearchResult
+	segment  string
+	ok       bool
+	attempts []shardAttempt
+}
+
+// shardAttempt is an attempt of a shard request on a replica, replayed to the searches
+// sharing the request so their shard status reports it too.
+type shardAttempt struct {
+	err  error
+	took time.Duration
+}
+
+// searchShard is Broker.searchShard for a search of the batch. A nil sharedFanOut, for
+// searches outside a batch, shares nothing.
+func (f *sharedFanOut) searchShard(ctx context.Context, b *Broker, shard ShardKey, replicas []Searcher, query StructuredQuery, record func(err error, took time.Duration)) ([]SearchResult, string, bool) {
+	if f == nil {
+		return b.searchShard(ctx, shard, replicas, query, record)
+	}
+	key, err := json.Marshal(struct {
+		Shard ShardKey
+		Query StructuredQuery
+	}{shard, query})
+	if err != nil {
+		return b.searchShard(ctx, shard, replicas, query, record)
+	}
+
+	f.mu.Lock()
+	call, shared := f.calls[string(key)]
+	if !shared {
+		call = &shardCall{done: make(chan struct{})}
+		f.calls[string(key)] = call
+	} else {
+		f.shared++
+	}
+	f.mu.Unlock()
+
+	if !shared {
+		call.results, call.segment, call.ok = b.searchShard(ctx, shard, replicas, query, func(err error, took time.Duration) {
+			call.attempts = append(call.attempts, shardAttempt{err, took})
+			record(err, took)
+		})
+		close(call.done)
+		return call.results, call.segment, call.ok
+	}
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		record(fmt.Errorf("shard %d: %w", shard.ShardID, ctx.Err()), 0)
+		return nil, "", false
+	}
+	for _, a := range call.attempts {
+		record(a.err, a.took)
+	}
+	// Reranking and pagination work on the merged results, so each search gets its own copy.
+	return append([]SearchResult(nil), call.results...), call.segment, call.ok
+}
+
+// stats reports the shard requests made and shared so far.
+func (f *sharedFanOut) stats() MultiSearchStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return MultiSearchStats{ShardRequests: len(f.calls), SharedShardRequests: f.shared}
+}
+
+// multiSearchItem is the response to a query of a /msearch batch.
+type multiSearchItem struct {
+	Status   int             `json:"status"`
+	Response *SearchResponse `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// multiSearchResponse is the body returned by /msearch.
+type multiSearchResponse struct {
+	TookMs    int64             `json:"took_ms"`
+	Stats     MultiSearchStats  `json:"stats"`
+	Responses []multiSearchItem `json:"responses"`
+}
+
+// HandleMultiSearch handles POST /msearch, whose body is a JSON array of queries, each an
+// object with the query parameters of /search:
+//
+//	[{"q": "red shoes", "size": 5}, {"q": "boots", "collection": "products", "filters": [...]}]
+//
+// The tenant is taken from the request, for every query. The response holds a response
+// per query, in order, with the status the query would get from /search: a failed query
+// doesn't fail the batch.
+func (h *Handler) HandleMultiSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var items []map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		http.Error(w, fmt.Sprintf("invalid msearch body, expected a JSON array of queries: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(items) == 0 || len(items) > maxMultiSearchQueries {
+		http.Error(w, fmt.Sprintf("an msearch batch must have between 1 and %d queries", maxMultiSearchQueries), http.StatusBadRequest)
+		return
+	}
+	queries := make([]MultiSearchQuery, len(items))
+	for n, item := range items {
+		q, err := parseMultiSearchQuery(r, item)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("query %d: %v", n, err), http.StatusBadRequest)
+			return
+		}
+		queries[n] = q
+	}
+
+	start := time.Now()
+	results, stats := h.broker().MultiSearch(r.Context(), queries)
+	resp := multiSearchResponse{Stats: stats, Responses: make([]multiSearchItem, len(results))}
+	for n, result := range results {
+		if result.Err != nil {
+			status, message := searchErrorStatus(result.Err)
+			if status == http.StatusInternalServerError {
+				slog.ErrorContext(r.Context(), "Broker search of msearch batch failed", "search", n, "error", result.Err)
+			}
+			resp.Responses[n] = multiSearchItem{Status: status, Error: message}
+			continue
+		}
+		resp.Responses[n] = multiSearchItem{Status: http.StatusOK, Response: result.Response}
+	}
+	resp.TookMs = time.Since(start).Milliseconds()
+	writeJSON(w, "application/json", resp)
+}
+
+// parseMultiSearchQuery parses a query of a /msearch batch like the query parameters of
+// /search, with r providing the headers. Numbers and booleans may be given as JSON
+// values, and filters as a JSON array.
+func parseMultiSearchQuery(r *http.Request, item map[string]interface{}) (MultiSearchQuery, error) {
+	params := url.Values{}
+	for name, value := range item {
+		switch v := value.(type) {
+		case string:
+			params.Set(name, v)
+		case float64:
+			params.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			params.Set(name, strconv.FormatBool(v))
+		case nil:
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return MultiSearchQuery{}, fmt.Errorf("invalid %q parameter: %w", name, err)
+			}
+			params.Set(name, string(data))
+		}
+	}
+	if params.Get("q") == "" {
+		return MultiSearchQuery{}, fmt.Errorf("missing 'q' parameter")
+	}
+	sub := r.Clone(r.Context())
+	sub.URL.RawQuery = params.Encode()
+	opts, err := parseSearchOptions(sub)
+	if err != nil {
+		return MultiSearchQuery{}, err
+	}
+	return MultiSearchQuery{Query: RawQuery(params.Get("q")), Options: opts}, nil
+}
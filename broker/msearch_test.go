@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHandler_MultiSearch(t *testing.T) {
+	var calls int32
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, rawQuery RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: strings.Fields(string(rawQuery))}, nil
+		},
+	}
+	shard := &MockSearcher{
+		SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+			atomic.AddInt32(&calls, 1)
+			return []SearchResult{{ID: query.Keywords[0] + "-1", Score: 0.9}, {ID: query.Keywords[0] + "-2", Score: 0.5}}, nil
+		},
+	}
+	h := NewHandler(NewBroker(mockQU, []Searcher{shard}))
+
+	body := `[
+		{"q": "shoes", "size": 1},
+		{"q": "shoes", "from": 1, "size": 1},
+		{"q": "boots"},
+		{"q": "shoes", "collection": "missing"}
+	]`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/msearch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp multiSearchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Responses) != 4 {
+		t.Fatalf("Expected 4 responses, got %d", len(resp.Responses))
+	}
+
+	// Both pages of "shoes" come from a single shard request.
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("Expected 2 shard requests, got %d", got)
+	}
+	if resp.Stats.ShardRequests != 2 || resp.Stats.SharedShardRequests != 1 {
+		t.Errorf("Expected 2 shard requests with 1 shared, got %+v", resp.Stats)
+	}
+	for n, want := range []string{"shoes-1", "shoes-2", "boots-1"} {
+		item := resp.Responses[n]
+		if item.Status != http.StatusOK || item.Response == nil || len(item.Response.Results) == 0 {
+			t.Fatalf("Expected results for query %d, got %+v", n, item)
+		}
+		if id := item.Response.Results[0].ID; id != want {
+			t.Errorf("Expected %s first for query %d, got %s", want, n, id)
+		}
+		if item.Response.Shards.Successful != 1 {
+			t.Errorf("Expected a successful shard for query %d, got %+v", n, item.Response.Shards)
+		}
+	}
+	if item := resp.Responses[3]; item.Status != http.StatusNotFound || item.Error == "" {
+		t.Errorf("Expected a 404 for the unknown collection, got %+v", item)
+	}
+}
+
+func TestHandler_MultiSearch_BadRequest(t *testing.T) {
+	h := NewHandler(newTestBroker())
+	for _, body := range []string{`{"q": "shoes"}`, `[]`, `[{"q": "shoes"}, {"size": 5}]`, `[{"q": "shoes", "size": 0}]`} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/msearch", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package broker
+
+import (
+	"common/simhash"
+)
+
+// StageNearDuplicates is the debug stage of near-duplicate detection, reported when it's
+// enabled.
+const StageNearDuplicates = "near_duplicates"
+
+// DefaultNearDuplicateDistance disables near-duplicate detection.
+const DefaultNearDuplicateDistance = -1
+
+// SetNearDuplicateDistance makes the broker collapse the results whose fingerprints
+// (simhash.Field, stored by indexers) differ by at most maxDistance bits into the
+// best-ranked of them, e.g. copies of a page indexed under several IDs or on several
+// shards. It is DefaultNearDuplicateDistance by default, which disables near-duplicate
+// detection.
+func (b *Broker) SetNearDuplicateDistance(maxDistance int) {
+	b.nearDuplicateDistance = maxDistance
+}
+
+// dropNearDuplicates keeps the first of the results whose fingerprints are within
+// maxDistance bits of each other, listing the IDs of the others in its Duplicates.
+// Results without a valid fingerprint are kept.
+func dropNearDuplicates(results []SearchResult, maxDistance int) []SearchResult {
+	kept := make([]SearchResult, 0, len(results))
+	var fingerprints []uint64
+	var keptIndexes []int // Index in kept of the result of every fingerprint
+	for _, r := range results {
+		s, _ := r.Stored[simhash.Field].(string)
+		fingerprint, err := simhash.Parse(s)
+		if s == "" || err != nil {
+			kept = append(kept, r)
+			continue
+		}
+		duplicate := false
+		for i, other := range fingerprints {
+			if simhash.Distance(fingerprint, other) <= maxDistance {
+				original := &kept[keptIndexes[i]]
+				original.Duplicates = append(original.Duplicates, r.ID)
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			fingerprints = append(fingerprints, fingerprint)
+			keptIndexes = append(keptIndexes, len(kept))
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
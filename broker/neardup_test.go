@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"common/simhash"
+)
+
+func TestDropNearDuplicates(t *testing.T) {
+	fp := func(v uint64) map[string]interface{} {
+		return map[string]interface{}{simhash.Field: simhash.Format(v)}
+	}
+	results := []SearchResult{
+		{ID: "a", Stored: fp(0xff00)},
+		{ID: "b", Stored: fp(0xf000)},
+		{ID: "a-copy", Stored: fp(0xff03)}, // 2 bits away from a
+		{ID: "none"},
+		{ID: "invalid", Stored: map[string]interface{}{simhash.Field: "zz"}},
+		{ID: "b-copy", Stored: fp(0xf000)},
+	}
+	kept := dropNearDuplicates(results, 3)
+	if got, want := resultIDs(kept), []string{"a", "b", "none", "invalid"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected results: got %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(kept[0].Duplicates, []string{"a-copy"}) || !reflect.DeepEqual(kept[1].Duplicates, []string{"b-copy"}) {
+		t.Errorf("Expected the duplicates to be listed, got %v and %v", kept[0].Duplicates, kept[1].Duplicates)
+	}
+
+	if kept := dropNearDuplicates(results, 0); len(kept) != 5 {
+		t.Errorf("Expected only identical fingerprints to be collapsed at distance 0, got %v", resultIDs(kept))
+	}
+}
+
+func TestBroker_Search_NearDuplicatesAcrossShards(t *testing.T) {
+	page := simhash.Format(simhash.Fingerprint("Waterproof leather boots for hiking"))
+	// The fields each shard was asked for; shards are searched concurrently.
+	rankingFields := make([][]string, 2)
+	shard := func(id int, results ...SearchResult) *MockSearcher {
+		return &MockSearcher{
+			ShardID: id,
+			SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+				rankingFields[id] = query.RankingFields
+				return results, nil
+			},
+		}
+	}
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{
+		shard(0, SearchResult{ID: "shop/boots", Score: 2, Stored: map[string]interface{}{simhash.Field: page}}),
+		shard(1, SearchResult{ID: "mirror/boots", Score: 1.5, Stored: map[string]interface{}{simhash.Field: page}}, SearchResult{ID: "socks", Score: 1}),
+	})
+
+	resp, err := b.SearchWithOptions(context.Background(), "boots", SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if resp.TotalHits != 3 || rankingFields[0] != nil || rankingFields[1] != nil {
+		t.Errorf("Expected near-duplicates to be kept by default, got %v (fields %v)", resultIDs(resp.Results), rankingFields)
+	}
+
+	b.SetNearDuplicateDistance(3)
+	resp, err = b.SearchWithOptions(context.Background(), "boots", SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if got, want := resultIDs(resp.Results), []string{"shop/boots", "socks"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected results: got %v, want %v", got, want)
+	}
+	if want := []string{simhash.Field}; !reflect.DeepEqual(rankingFields, [][]string{want, want}) {
+		t.Errorf("Expected searchers to be asked for the fingerprints, got %v", rankingFields)
+	}
+	if !reflect.DeepEqual(resp.Results[0].Duplicates, []string{"mirror/boots"}) {
+		t.Errorf("Expected the mirror to be listed as a duplicate, got %+v", resp.Results[0])
+	}
+}
@@ -0,0 +1,203 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+)
+
+// StagePersonalize is the debug stage of personalization, reported for searches with a
+// user ID.
+const StagePersonalize = "personalize"
+
+// ErrInvalidPersonalization is returned for personalization settings that can't be applied.
+var ErrInvalidPersonalization = errors.New("invalid personalization config")
+
+// Personalizer re-scores the merged results of a search for the user who ran it. It runs
+// before the ranking rules, so pins and burials still apply to personalized results.
+type Personalizer interface {
+	// Fields returns the stored fields Personalize reads, which searchers must return
+	// with every result.
+	Fields() []string
+	// Personalize returns the results of userID with their scores adjusted, appending
+	// its name to the Rules of the results it changed. Results may be modified in place.
+	Personalize(ctx context.Context, userID string, results []SearchResult) ([]SearchResult, error)
+}
+
+// NoopPersonalizer leaves results as they are. It is the broker's default.
+type NoopPersonalizer struct{}
+
+// Fields returns nil.
+func (NoopPersonalizer) Fields() []string { return nil }
+
+// Personalize returns results unchanged.
+func (NoopPersonalizer) Personalize(_ context.Context, _ string, results []SearchResult) ([]SearchResult, error) {
+	return results, nil
+}
+
+// SetPersonalizer sets the personalizer of searches with a user ID; nil restores the
+// NoopPersonalizer.
+func (b *Broker) SetPersonalizer(p Personalizer) {
+	if p == nil {
+		p = NoopPersonalizer{}
+	}
+	b.personalizer = p
+}
+
+// personalize applies the personalizer to the results of userID, sorting them by their
+// new scores if they are ordered by score (byScore). Results are left unchanged if the
+// personalizer fails.
+func (b *Broker) personalize(ctx context.Context, userID string, results []SearchResult, byScore bool) []SearchResult {
+	ctx, span := tracer.Start(ctx, "broker.Personalize")
+	defer span.End()
+	original := make([]SearchResult, len(results))
+	copy(original, results)
+	personalized, err := b.personalizer.Personalize(ctx, userID, results)
+	if err != nil {
+		span.RecordError(err)
+		slog.WarnContext(ctx, "Failed to personalize results", "user_id", userID, "error", err)
+		return original
+	}
+	if byScore {
+		sort.SliceStable(personalized, func(i, j int) bool { return personalized[i].Score > personalized[j].Score })
+	}
+	return personalized
+}
+
+// appendMissing appends the values missing from fields.
+func appendMissing(fields []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, f := range fields {
+			found = found || f == v
+		}
+		if !found {
+			fields = append(fields, v)
+		}
+	}
+	return fields
+}
+
+// AffinityStore provides the affinities of users to the values of a field, e.g. to
+// product categories, between 0 (none) and 1 (strongest).
+type AffinityStore interface {
+	Affinities(ctx context.Context, userID string) (map[string]float64, error)
+}
+
+// MemoryAffinityStore keeps affinities in memory. It is safe for concurrent use.
+type MemoryAffinityStore struct {
+	mu         sync.RWMutex
+	affinities map[string]map[string]float64 // By user ID, then value
+}
+
+// NewMemoryAffinityStore creates an empty store.
+func NewMemoryAffinityStore() *MemoryAffinityStore {
+	return &MemoryAffinityStore{affinities: make(map[string]map[string]float64)}
+}
+
+// LoadAffinityFile creates a store with the affinities of a JSON file mapping user IDs to
+// their affinity by value, e.g. {"u1": {"shoes": 0.8, "bags": 0.2}}.
+func LoadAffinityFile(path string) (*MemoryAffinityStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read affinity file %s: %w", path, err)
+	}
+	var affinities map[string]map[string]float64
+	if err := json.Unmarshal(data, &affinities); err != nil {
+		return nil, fmt.Errorf("failed to parse affinity file %s: %w", path, err)
+	}
+	s := NewMemoryAffinityStore()
+	for userID, values := range affinities {
+		s.Set(userID, values)
+	}
+	return s, nil
+}
+
+// Set replaces the affinities of a user. Values are compared case-insensitively.
+func (s *MemoryAffinityStore) Set(userID string, affinities map[string]float64) {
+	normalized := make(map[string]float64, len(affinities))
+	for value, affinity := range affinities {
+		normalized[normalizeRuleText(value)] = affinity
+	}
+	s.mu.Lock()
+	s.affinities[userID] = normalized
+	s.mu.Unlock()
+}
+
+// Affinities returns the affinities of a user, nil for unknown users.
+func (s *MemoryAffinityStore) Affinities(_ context.Context, userID string) (map[string]float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.affinities[userID], nil
+}
+
+// CategoryAffinityPersonalizer promotes the results in the categories a user likes: the
+// score of a result is multiplied by 1 + Weight × the user's strongest affinity to the
+// values of its category field.
+type CategoryAffinityPersonalizer struct {
+	name   string
+	field  string
+	weight float64
+	store  AffinityStore
+}
+
+// CategoryAffinityConfig configures a CategoryAffinityPersonalizer.
+type CategoryAffinityConfig struct {
+	Name   string  `yaml:"name"`   // Appended to the Rules of promoted results; default "category_affinity"
+	Field  string  `yaml:"field"`  // Stored field or RuleField pseudo-field holding the category
+	Weight float64 `yaml:"weight"` // Promotion of a result of affinity 1; 0.5 scores it 1.5 times higher
+	// AffinityFile is a JSON file of affinities by user ID, see LoadAffinityFile.
+	AffinityFile string `yaml:"affinity_file"`
+}
+
+// NewCategoryAffinityPersonalizer creates a personalizer reading affinities from store.
+func NewCategoryAffinityPersonalizer(cfg CategoryAffinityConfig, store AffinityStore) (*CategoryAffinityPersonalizer, error) {
+	if cfg.Field == "" {
+		return nil, fmt.Errorf("%w: a category field is required", ErrInvalidPersonalization)
+	}
+	if cfg.Weight <= 0 {
+		return nil, fmt.Errorf("%w: weight %g must be positive", ErrInvalidPersonalization, cfg.Weight)
+	}
+	if cfg.Name == "" {
+		cfg.Name = "category_affinity"
+	}
+	return &CategoryAffinityPersonalizer{name: cfg.Name, field: cfg.Field, weight: cfg.Weight, store: store}, nil
+}
+
+// Fields returns the category field unless it's a pseudo-field.
+func (p *CategoryAffinityPersonalizer) Fields() []string {
+	switch p.field {
+	case RuleFieldID, RuleFieldTitle, RuleFieldURL, RuleFieldHost:
+		return nil
+	}
+	return []string{p.field}
+}
+
+// Personalize promotes the results of the categories userID has an affinity to.
+func (p *CategoryAffinityPersonalizer) Personalize(ctx context.Context, userID string, results []SearchResult) ([]SearchResult, error) {
+	affinities, err := p.store.Affinities(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the affinities of user %q: %w", userID, err)
+	}
+	if len(affinities) == 0 {
+		return results, nil
+	}
+	for i := range results {
+		best := 0.0
+		for _, v := range ruleFieldValues(results[i], p.field) {
+			if a := affinities[normalizeRuleText(v)]; a > best {
+				best = a
+			}
+		}
+		if best > 0 {
+			results[i].Score *= 1 + p.weight*best
+			results[i].Rules = append(results[i].Rules, p.name)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func personalizeTestResults() []SearchResult {
+	return []SearchResult{
+		{ID: "boots", Score: 2, Stored: map[string]interface{}{"category": "Shoes"}},
+		{ID: "tote", Score: 1.8, Stored: map[string]interface{}{"category": []interface{}{"bags", "gifts"}}},
+		{ID: "scarf", Score: 1.5, Stored: map[string]interface{}{"category": "accessories"}},
+	}
+}
+
+func TestCategoryAffinityPersonalizer_Personalize(t *testing.T) {
+	store := NewMemoryAffinityStore()
+	store.Set("u1", map[string]float64{"Bags": 0.5, "gifts": 1})
+	p, err := NewCategoryAffinityPersonalizer(CategoryAffinityConfig{Field: "category", Weight: 0.5}, store)
+	if err != nil {
+		t.Fatalf("NewCategoryAffinityPersonalizer returned an error: %v", err)
+	}
+	if got := p.Fields(); !reflect.DeepEqual(got, []string{"category"}) {
+		t.Errorf("Expected the category field, got %v", got)
+	}
+
+	results, err := p.Personalize(context.Background(), "u1", personalizeTestResults())
+	if err != nil {
+		t.Fatalf("Personalize returned an error: %v", err)
+	}
+	// The strongest affinity of the tote, to gifts, scores it 1.5 times higher.
+	if results[1].Score != 2.7 || !reflect.DeepEqual(results[1].Rules, []string{"category_affinity"}) {
+		t.Errorf("Expected the tote to be promoted, got %+v", results[1])
+	}
+	if results[0].Score != 2 || results[0].Rules != nil {
+		t.Errorf("Expected the boots to be left as they are, got %+v", results[0])
+	}
+
+	// Users without affinities keep their results.
+	results, _ = p.Personalize(context.Background(), "u2", personalizeTestResults())
+	if !reflect.DeepEqual(results, personalizeTestResults()) {
+		t.Errorf("Expected unknown users to keep their results, got %+v", results)
+	}
+}
+
+func TestNewCategoryAffinityPersonalizer_Invalid(t *testing.T) {
+	for _, cfg := range []CategoryAffinityConfig{{Weight: 1}, {Field: "category"}, {Field: "category", Weight: -1}} {
+		if _, err := NewCategoryAffinityPersonalizer(cfg, NewMemoryAffinityStore()); !errors.Is(err, ErrInvalidPersonalization) {
+			t.Errorf("Expected ErrInvalidPersonalization for %+v, got %v", cfg, err)
+		}
+	}
+}
+
+func TestLoadAffinityFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "affinities.json")
+	if err := os.WriteFile(path, []byte(`{"u1": {"Shoes": 0.8}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := LoadAffinityFile(path)
+	if err != nil {
+		t.Fatalf("LoadAffinityFile returned an error: %v", err)
+	}
+	if got, _ := store.Affinities(context.Background(), "u1"); got["shoes"] != 0.8 {
+		t.Errorf("Expected the normalized affinities, got %v", got)
+	}
+	if _, err := LoadAffinityFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+type failingPersonalizer struct{ NoopPersonalizer }
+
+func (failingPersonalizer) Personalize(context.Context, string, []SearchResult) ([]SearchResult, error) {
+	return nil, errors.New("affinity store unavailable")
+}
+
+func TestBroker_Search_Personalization(t *testing.T) {
+	var rankingFields []string
+	searcher := &MockSearcher{
+		SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+			rankingFields = query.RankingFields
+			return personalizeTestResults(), nil
+		},
+	}
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{searcher})
+	store := NewMemoryAffinityStore()
+	store.Set("u1", map[string]float64{"accessories": 1})
+	p, err := NewCategoryAffinityPersonalizer(CategoryAffinityConfig{Field: "category", Weight: 1}, store)
+	if err != nil {
+		t.Fatalf("NewCategoryAffinityPersonalizer returned an error: %v", err)
+	}
+	b.SetPersonalizer(p)
+
+	resp, err := b.SearchWithOptions(context.Background(), "q", SearchOptions{UserID: "u1", Explain: true})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if got, want := resultIDs(resp.Results), []string{"scarf", "boots", "tote"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected order: got %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(rankingFields, []string{"category"}) {
+		t.Errorf("Expected searchers to be asked for the category field, got %v", rankingFields)
+	}
+	if stages := resp.Debug.Stages; len(stages) == 0 || stages[len(stages)-1].Stage != StagePersonalize {
+		t.Errorf("Expected the personalize stage after the merge, got %+v", stages)
+	}
+
+	// Anonymous searches aren't personalized.
+	resp, _ = b.SearchWithOptions(context.Background(), "q", SearchOptions{})
+	if got, want := resultIDs(resp.Results), []string{"boots", "tote", "scarf"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected order of an anonymous search: got %v, want %v", got, want)
+	}
+	if rankingFields != nil {
+		t.Errorf("Expected no ranking fields for an anonymous search, got %v", rankingFields)
+	}
+
+	// A failing personalizer leaves the results as they are.
+	b.SetPersonalizer(failingPersonalizer{})
+	resp, err = b.SearchWithOptions(context.Background(), "q", SearchOptions{UserID: "u1"})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if got, want := resultIDs(resp.Results), []string{"boots", "tote", "scarf"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected order after a personalization failure: got %v, want %v", got, want)
+	}
+}
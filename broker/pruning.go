@@ -0,0 +1,262 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"common/bloom"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var prunedShardsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "broker_pruned_shards_total",
+	Help: "Shards skipped by searches filtering on routing key values the shards don't hold",
+}, []string{"collection"})
+
+// ShardRouting mirrors the summary of the routing field a searcher publishes for its shard.
+type ShardRouting struct {
+	Field     string        `json:"field"`
+	Documents uint64        `json:"documents"`
+	Terms     *bloom.Filter `json:"terms"` // Bloom filter of the terms of the field
+	MinTerm   string        `json:"min_term,omitempty"`
+	MaxTerm   string        `json:"max_term,omitempty"`
+	Min       *float64      `json:"min,omitempty"` // Numeric range of the field; nil if it has no numbers
+	Max       *float64      `json:"max,omitempty"`
+	Segment   string        `json:"segment,omitempty"` // Segment summarized; empty if unknown
+}
+
+// canMatch reports whether the shard may hold documents matching f, a filter on the
+// routing field: term filters must name a term of the shard and range filters overlap
+// its numeric range. Other filters may always match.
+func (r *ShardRouting) canMatch(f Filter) bool {
+	switch f.Type {
+	case FilterTerm:
+		if r.MinTerm == "" || f.Value < r.MinTerm || f.Value > r.MaxTerm {
+			return false
+		}
+		return r.Terms == nil || r.Terms.Test(f.Value)
+	case FilterRange:
+		if r.Min == nil || r.Max == nil {
+			return false
+		}
+		if f.Min != nil && (*f.Min > *r.Max || f.ExclusiveMin && *f.Min == *r.Max) {
+			return false
+		}
+		if f.Max != nil && (*f.Max < *r.Min || f.ExclusiveMax && *f.Max == *r.Min) {
+			return false
+		}
+	}
+	return true
+}
+
+// RoutingSummarizer is implemented by searchers that publish the summary of a field of
+// their shard. Shards without a summary are never pruned.
+type RoutingSummarizer interface {
+	RoutingSummary(ctx context.Context, field string) (*ShardRouting, error)
+}
+
+// isRoutingSummarizer reports whether s publishes routing summaries.
+func isRoutingSummarizer(s Searcher) bool {
+	_, ok := s.(RoutingSummarizer)
+	return ok
+}
+
+// ShardPruner holds the summaries of the routing field of every shard, by which searches
+// filtering on the field skip the shards that can't hold a match. A summary only covers
+// the segment of its shard it was gathered from: the summaries of a collection are dropped
+// when a commit to it is announced and when a searcher announces it loaded another
+// segment, and a summary is not used once the shard's searchers answer from a segment
+// other than the summarized one.
+type ShardPruner struct {
+	field  string
+	mu     sync.RWMutex
+	shards map[ShardKey]*ShardRouting
+	served map[ShardKey]string // Segment the shard last answered a search from
+}
+
+// NewShardPruner returns a pruner for searches filtering on field, without summaries.
+func NewShardPruner(field string) *ShardPruner {
+	return &ShardPruner{field: field, shards: make(map[ShardKey]*ShardRouting), served: make(map[ShardKey]string)}
+}
+
+// Field returns the routing field.
+func (p *ShardPruner) Field() string {
+	return p.field
+}
+
+// Update replaces the summary of a shard.
+func (p *ShardPruner) Update(shard ShardKey, routing *ShardRouting) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.shards[shard] = routing
+}
+
+// Invalidate drops the summaries of the shards of a collection, given by its pool key.
+func (p *ShardPruner) Invalidate(collection string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for shard := range p.shards {
+		if shard.Collection == collection {
+			delete(p.shards, shard)
+		}
+	}
+}
+
+// Loaded drops the summaries of the shards of a collection, given by its pool key, other
+// than the summaries of segment, which a searcher of the collection loaded.
+func (p *ShardPruner) Loaded(collection, segment string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for shard, routing := range p.shards {
+		if shard.Collection == collection && routing.Segment != segment {
+			delete(p.shards, shard)
+		}
+	}
+}
+
+// Served records the segment shard answered a search from; empty segments are unknown
+// and ignored.
+func (p *ShardPruner) Served(shard ShardKey, segment string) {
+	if segment == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.served[shard] = segment
+}
+
+// CanMatch reports whether shard may hold documents matching every filter. It is true for
+// shards without a summary and for shards answering from another segment than the one
+// summarized.
+func (p *ShardPruner) CanMatch(shard ShardKey, filters []Filter) bool {
+	p.mu.RLock()
+	routing, served := p.shards[shard], p.served[shard]
+	p.mu.RUnlock()
+	if routing == nil || routing.Segment != "" && served != "" && routing.Segment != served {
+		return true
+	}
+	for _, f := range filters {
+		if f.Field == p.field && !routing.canMatch(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetShardPruner skips the shards that can't match the filters of a search on the routing
+// field of p; nil searches every shard.
+func (b *Broker) SetShardPruner(p *ShardPruner) {
+	b.pruner = p
+}
+
+// pruneShards returns the shards of shardIDs in the collection with the given pool key
+// that may hold documents matching filters, and the number of shards skipped.
+func (b *Broker) pruneShards(collection string, shardIDs []int, filters []Filter) ([]int, int) {
+	if b.pruner == nil || len(filters) == 0 {
+		return shardIDs, 0
+	}
+	kept := shardIDs[:0:0]
+	for _, shardID := range shardIDs {
+		if b.pruner.CanMatch(ShardKey{Collection: collection, ShardID: shardID}, filters) {
+			kept = append(kept, shardID)
+		}
+	}
+	if pruned := len(shardIDs) - len(kept); pruned > 0 {
+		prunedShardsTotal.WithLabelValues(collection).Add(float64(pruned))
+		return kept, pruned
+	}
+	return shardIDs, 0
+}
+
+// invalidateShardRouting drops the routing summaries of a collection after a commit to it.
+func (b *Broker) invalidateShardRouting(collection string) {
+	if b.pruner != nil {
+		b.pruner.Invalidate(collection)
+	}
+}
+
+// loadedShardRouting drops the routing summaries of a collection other than those of
+// segment, after a searcher of the collection loaded it.
+func (b *Broker) loadedShardRouting(collection, segment string) {
+	if b.pruner != nil {
+		b.pruner.Loaded(collection, segment)
+	}
+}
+
+// servedShardRouting records the segment a shard answered a search from.
+func (b *Broker) servedShardRouting(shard ShardKey, segment string) {
+	if b.pruner != nil {
+		b.pruner.Served(shard, segment)
+	}
+}
+
+// RefreshShardRouting asks one replica of every shard for the summary of its routing
+// field. Shards that can't be reached keep their previous summary. The summaries are
+// gathered in the background, so their calls are not recorded into the circuit breakers
+// of the searches: a slow scan of a shard's terms doesn't trip them.
+func (b *Broker) RefreshShardRouting(ctx context.Context) {
+	if b.pruner == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for collection, pool := range b.collections {
+		for shardID, replicas := range pool {
+			wg.Add(1)
+			go func(shard ShardKey, replicas []Searcher) {
+				defer wg.Done()
+				var routing *ShardRouting
+				ok := b.pollShard(ctx, shard, replicas, "searcher.RoutingSummary", isRoutingSummarizer, func(ctx context.Context, s Searcher) error {
+					var err error
+					routing, err = s.(RoutingSummarizer).RoutingSummary(ctx, b.pruner.Field())
+					return err
+				})
+				if ok {
+					b.pruner.Update(shard, routing)
+				}
+			}(ShardKey{Collection: collection, ShardID: shardID}, replicas)
+		}
+	}
+	wg.Wait()
+}
+
+// WatchShardRouting refreshes the routing summaries every interval until ctx is done.
+func (b *Broker) WatchShardRouting(ctx context.Context, interval time.Duration) {
+	b.RefreshShardRouting(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.RefreshShardRouting(ctx)
+		}
+	}
+}
+
+// RoutingSummary asks the remote searcher for the summary of field in its shard.
+func (s *HTTPSearcher) RoutingSummary(ctx context.Context, field string) (*ShardRouting, error) {
+	params := url.Values{}
+	params.Set("collection", s.collection)
+	params.Set("field", field)
+	s.setTenant(params)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/routing?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create routing request: %w", err)
+	}
+
+	var routing ShardRouting
+	if err := doJSON(s.client, req, &routing); err != nil {
+		return nil, fmt.Errorf("searcher %s (shard %d) routing request failed: %w", s.baseURL, s.shardID, err)
+	}
+	return &routing, nil
+}
+
+// Ensure HTTPSearcher publishes routing summaries.
+var _ RoutingSummarizer = (*HTTPSearcher)(nil)
@@ -0,0 +1,186 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"common/bloom"
+	"common/commitbus"
+)
+
+// mockRoutingSummarizer is a searcher publishing a fixed routing summary.
+type mockRoutingSummarizer struct {
+	MockSearcher
+	routing *ShardRouting
+}
+
+func (m *mockRoutingSummarizer) RoutingSummary(context.Context, string) (*ShardRouting, error) {
+	return m.routing, nil
+}
+
+// customerRouting returns the summary of a shard holding the given customers and totals.
+func customerRouting(min, max float64, customers ...string) *ShardRouting {
+	terms := bloom.New(len(customers), 0.01)
+	for _, c := range customers {
+		terms.Add(c)
+	}
+	return &ShardRouting{Field: "customer", Terms: terms, MinTerm: customers[0], MaxTerm: customers[len(customers)-1], Min: &min, Max: &max}
+}
+
+func TestShardPruner_CanMatch(t *testing.T) {
+	p := NewShardPruner("customer")
+	shard := ShardKey{Collection: DefaultCollection, ShardID: 0}
+	p.Update(shard, customerRouting(10, 20, "acme", "globex"))
+	ten, twenty, thirty := 10.0, 20.0, 30.0
+	for _, tc := range []struct {
+		filters []Filter
+		want    bool
+	}{
+		{[]Filter{TermFilter("customer", "acme")}, true},
+		{[]Filter{TermFilter("customer", "initech")}, false}, // Out of the term range
+		{[]Filter{TermFilter("customer", "aardvark")}, false},
+		{[]Filter{TermFilter("color", "red")}, true}, // Not the routing field
+		{[]Filter{TermFilter("color", "red"), TermFilter("customer", "initech")}, false},
+		{[]Filter{RangeFilter("customer", &twenty, &thirty)}, true},
+		{[]Filter{{Type: FilterRange, Field: "customer", Min: &twenty, ExclusiveMin: true}}, false},
+		{[]Filter{RangeFilter("customer", nil, &ten)}, true},
+		{[]Filter{RangeFilter("customer", &thirty, nil)}, false},
+	} {
+		if got := p.CanMatch(shard, tc.filters); got != tc.want {
+			t.Errorf("CanMatch(%+v) = %v, want %v", tc.filters, got, tc.want)
+		}
+	}
+	if !p.CanMatch(ShardKey{Collection: DefaultCollection, ShardID: 1}, []Filter{TermFilter("customer", "initech")}) {
+		t.Error("Expected shards without a summary to be searched")
+	}
+	p.Invalidate(DefaultCollection)
+	if !p.CanMatch(shard, []Filter{TermFilter("customer", "initech")}) {
+		t.Error("Expected an invalidated shard to be searched")
+	}
+}
+
+func TestShardPruner_Segments(t *testing.T) {
+	p := NewShardPruner("customer")
+	shard := ShardKey{Collection: DefaultCollection, ShardID: 0}
+	initech := []Filter{TermFilter("customer", "initech")}
+	routing := customerRouting(0, 1, "acme")
+	routing.Segment = "segment-1"
+	p.Update(shard, routing)
+
+	p.Served(shard, "segment-1")
+	if p.CanMatch(shard, initech) {
+		t.Error("Expected the summary of the served segment to prune the shard")
+	}
+	p.Served(shard, "segment-2")
+	if !p.CanMatch(shard, initech) {
+		t.Error("Expected a shard serving another segment than the summarized one to be searched")
+	}
+	p.Served(shard, "segment-1")
+	p.Loaded(DefaultCollection, "segment-1")
+	if p.CanMatch(shard, initech) {
+		t.Error("Expected the load of the summarized segment to keep the summary")
+	}
+	p.Loaded(DefaultCollection, "segment-2")
+	if !p.CanMatch(shard, initech) {
+		t.Error("Expected the load of another segment to drop the summary")
+	}
+}
+
+// failingRoutingSummarizer is a searcher whose routing summaries fail.
+type failingRoutingSummarizer struct {
+	MockSearcher
+}
+
+func (m *failingRoutingSummarizer) RoutingSummary(context.Context, string) (*ShardRouting, error) {
+	return nil, errors.New("scan timed out")
+}
+
+func TestBroker_RefreshShardRouting_SkipsBreakers(t *testing.T) {
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{&failingRoutingSummarizer{}})
+	b.SetShardPruner(NewShardPruner("customer"))
+	for i := 0; i < 50; i++ {
+		b.RefreshShardRouting(context.Background())
+	}
+	statuses := b.BreakerStatuses()
+	if len(statuses) != 1 || statuses[0].State != BreakerClosed || statuses[0].Requests != 0 {
+		t.Errorf("Expected failed routing summaries not to be recorded into the breakers, got %+v", statuses)
+	}
+}
+
+func TestBroker_Search_PrunesShards(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{}, nil
+		},
+	}
+	var calls [2]atomic.Int32
+	searchers := make([]Searcher, 2)
+	for i, customers := range [][]string{{"acme", "globex"}, {"initech", "umbrella"}} {
+		shardID := i
+		searchers[i] = &mockRoutingSummarizer{
+			MockSearcher: MockSearcher{ShardID: shardID, SearchFunc: func(context.Context, StructuredQuery) ([]SearchResult, error) {
+				calls[shardID].Add(1)
+				return []SearchResult{{ID: "doc", Score: 1}}, nil
+			}},
+			routing: customerRouting(0, 1, customers...),
+		}
+	}
+	b := NewBroker(mockQU, searchers)
+	b.SetShardPruner(NewShardPruner("customer"))
+	b.RefreshShardRouting(context.Background())
+
+	resp, err := b.SearchWithOptions(context.Background(), "", SearchOptions{Filters: []Filter{TermFilter("customer", "umbrella")}})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if calls[0].Load() != 0 || calls[1].Load() != 1 || resp.Shards.Total != 1 || resp.Shards.Skipped != 1 {
+		t.Errorf("Expected only shard 1 to be searched, got calls %d %d and shards %+v", calls[0].Load(), calls[1].Load(), resp.Shards)
+	}
+
+	// A commit to the collection drops its summaries: every shard is searched until they
+	// are gathered again.
+	h := NewHandler(b)
+	h.SetCommitToken("secret")
+	server := httptest.NewServer(h)
+	defer server.Close()
+	body, _ := json.Marshal(commitbus.Event{Collection: DefaultCollection, Segment: "segment-2"})
+	req, _ := http.NewRequest(http.MethodPost, server.URL+commitbus.Path, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to announce the commit: %v", err)
+	}
+	res.Body.Close()
+	resp, err = b.SearchWithOptions(context.Background(), "", SearchOptions{Filters: []Filter{TermFilter("customer", "umbrella")}})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if calls[0].Load() != 1 || resp.Shards.Skipped != 0 {
+		t.Errorf("Expected every shard to be searched after the commit, got shards %+v", resp.Shards)
+	}
+}
+
+func TestHTTPSearcher_RoutingSummary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/routing" || r.URL.Query().Get("field") != "customer" || r.URL.Query().Get("collection") != "orders" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(customerRouting(0, 1, "acme"))
+	}))
+	defer server.Close()
+
+	routing, err := NewCollectionHTTPSearcher("orders", server.URL, 0).RoutingSummary(context.Background(), "customer")
+	if err != nil {
+		t.Fatalf("RoutingSummary returned an error: %v", err)
+	}
+	if routing.MinTerm != "acme" || !routing.Terms.Test("acme") {
+		t.Errorf("Unexpected routing summary %+v", routing)
+	}
+}
@@ -0,0 +1,104 @@
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"common/qupb"
+	"common/tracing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// GRPCQueryUnderstandingClient is a QueryUnderstandingService backed by the query
+// understanding service's gRPC API.
+type GRPCQueryUnderstandingClient struct {
+	conn   *grpc.ClientConn
+	client qupb.QueryUnderstandingClient
+}
+
+// NewGRPCQueryUnderstandingClient creates a client for the gRPC API of the query
+// understanding service at addr (host:port), using TLS if tlsConfig is set. The
+// connection is established lazily, on the first call.
+func NewGRPCQueryUnderstandingClient(addr string, tlsConfig *tls.Config) (*GRPCQueryUnderstandingClient, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client for %s: %w", addr, err)
+	}
+	return &GRPCQueryUnderstandingClient{conn: conn, client: qupb.NewQueryUnderstandingClient(conn)}, nil
+}
+
+// Process sends the raw query to the query understanding service and converts its
+// response into a StructuredQuery. The field restrictions of the response are already
+// part of its query tree, so they aren't added to the StructuredQuery's Filters, unlike
+// its range filters. The gRPC messages don't carry the language set by WithLanguage,
+// which the service detects instead; use the HTTP client for it.
+func (c *GRPCQueryUnderstandingClient) Process(ctx context.Context, rawQuery RawQuery) (StructuredQuery, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultClientTimeout)
+	defer cancel()
+	resp, err := c.client.Process(ctx, &qupb.RawQuery{Query: string(rawQuery), Pipeline: PipelineFromContext(ctx), Collection: CollectionFromContext(ctx)})
+	if err != nil {
+		return StructuredQuery{}, fmt.Errorf("query understanding request failed: %w", err)
+	}
+	return StructuredQuery{
+		Keywords:  resp.GetTokens(),
+		Query:     queryNodeFromProto(resp.GetQuery()),
+		Language:  resp.GetLanguage(),
+		Intent:    resp.GetIntent(),
+		Embedding: resp.GetEmbedding(),
+		Filters:   rangesFromProto(resp.GetRanges()),
+	}, nil
+}
+
+// rangesFromProto converts the range filters received over gRPC.
+func rangesFromProto(msgs []*qupb.Range) []Filter {
+	var filters []Filter
+	for _, r := range msgs {
+		filters = append(filters, Filter{
+			Type:         FilterType(r.GetType()),
+			Field:        r.GetField(),
+			Min:          r.Min,
+			Max:          r.Max,
+			ExclusiveMin: r.GetExclusiveMin(),
+			ExclusiveMax: r.GetExclusiveMax(),
+			Start:        r.GetStart(),
+			End:          r.GetEnd(),
+		})
+	}
+	return filters
+}
+
+// Close closes the connection to the query understanding service.
+func (c *GRPCQueryUnderstandingClient) Close() error {
+	return c.conn.Close()
+}
+
+// queryNodeFromProto converts a query tree received over gRPC.
+func queryNodeFromProto(msg *qupb.QueryNode) *QueryNode {
+	if msg == nil {
+		return nil
+	}
+	n := &QueryNode{Type: msg.GetType(), Field: msg.GetField(), Text: msg.GetText()}
+	for _, c := range msg.GetMust() {
+		n.Must = append(n.Must, queryNodeFromProto(c))
+	}
+	for _, c := range msg.GetShould() {
+		n.Should = append(n.Should, queryNodeFromProto(c))
+	}
+	for _, c := range msg.GetMustNot() {
+		n.MustNot = append(n.MustNot, queryNodeFromProto(c))
+	}
+	return n
+}
+
+var _ QueryUnderstandingService = (*GRPCQueryUnderstandingClient)(nil)
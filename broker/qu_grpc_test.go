@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"common/qupb"
+
+	"google.golang.org/grpc"
+)
+
+// fakeQUServer answers every query with a fixed structured query.
+type fakeQUServer struct {
+	qupb.UnimplementedQueryUnderstandingServer
+	received string
+}
+
+// maxPrice is the upper bound of the price range of the fake's answers.
+var maxPrice = 50.0
+
+func (s *fakeQUServer) Process(_ context.Context, req *qupb.RawQuery) (*qupb.StructuredQuery, error) {
+	s.received = req.GetQuery()
+	return &qupb.StructuredQuery{
+		RawQuery: req.GetQuery(),
+		Tokens:   []string{"shoes"},
+		Language: "en",
+		Intent:   "transactional",
+		Filters:  []*qupb.Filter{{Field: "brand", Value: "acme"}},
+		Ranges:   []*qupb.Range{{Type: "range", Field: "price", Max: &maxPrice, ExclusiveMax: true}},
+		Query: &qupb.QueryNode{Type: "bool",
+			Must: []*qupb.QueryNode{{Type: "term", Field: "brand", Text: "acme"}, {Type: "term", Text: "shoes"}},
+		},
+	}, nil
+}
+
+func TestGRPCQueryUnderstandingClient_Process(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeQUServer{}
+	server := grpc.NewServer()
+	qupb.RegisterQueryUnderstandingServer(server, fake)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	client, err := NewGRPCQueryUnderstandingClient(lis.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("NewGRPCQueryUnderstandingClient returned an error: %v", err)
+	}
+	defer client.Close()
+
+	sq, err := client.Process(context.Background(), "brand:acme shoes")
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+	if fake.received != "brand:acme shoes" {
+		t.Errorf("Expected the raw query to be sent, got %q", fake.received)
+	}
+	want := StructuredQuery{
+		Keywords: []string{"shoes"},
+		Language: "en",
+		Intent:   "transactional",
+		Filters:  []Filter{{Type: FilterRange, Field: "price", Max: &maxPrice, ExclusiveMax: true}},
+		Query: &QueryNode{Type: "bool",
+			Must: []*QueryNode{{Type: "term", Field: "brand", Text: "acme"}, {Type: "term", Text: "shoes"}},
+		},
+	}
+	if !reflect.DeepEqual(sq, want) {
+		t.Errorf("Expected %+v, got %+v", want, sq)
+	}
+}
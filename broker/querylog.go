@@ -0,0 +1,320 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultQueryLogBuffer    = 1024
+	defaultQueryLogBatchSize = 100
+	defaultQueryLogFlush     = time.Second
+)
+
+// QueryLogEventSearch is the Event of the records written for searches. Feedback records
+// use the feedback type (FeedbackClick or FeedbackSelect) instead.
+const QueryLogEventSearch = "search"
+
+// QueryLogRecord is the structured record written for every search and feedback event.
+type QueryLogRecord struct {
+	Event           string    `json:"event"`
+	Timestamp       time.Time `json:"timestamp"`
+	QueryID         string    `json:"query_id,omitempty"`
+	ClientID        string    `json:"client_id,omitempty"`
+	Tenant          string    `json:"tenant,omitempty"`
+	Collection      string    `json:"collection"`
+	Query           string    `json:"query"`
+	NormalizedQuery string    `json:"normalized_query"`
+	Language        string    `json:"language,omitempty"`
+	Intent          string    `json:"intent,omitempty"`
+	LatencyMs       int64     `json:"latency_ms"`
+	ShardsTotal     int       `json:"shards_total"`
+	ShardsFailed    int       `json:"shards_failed"`
+	TotalHits       int       `json:"total_hits"`
+	Returned        int       `json:"returned"`
+	From            int       `json:"from"`
+	Size            int       `json:"size"`
+	Sort            string    `json:"sort,omitempty"`
+	Filters         int       `json:"filters,omitempty"`  // Number of filters applied
+	DocID           string    `json:"doc_id,omitempty"`   // Result a feedback event refers to
+	Position        int       `json:"position,omitempty"` // 1-based rank of DocID
+	Error           string    `json:"error,omitempty"`
+	// Experiments lists the experiment buckets of the search, to compare their outcomes.
+	Experiments []ExperimentAssignment `json:"experiments,omitempty"`
+}
+
+// newQueryLogRecord describes a search started at start for the query log.
+func newQueryLogRecord(start time.Time, rawQuery RawQuery, opts SearchOptions, query StructuredQuery, resp *SearchResponse, err error) QueryLogRecord {
+	record := QueryLogRecord{
+		Event:           QueryLogEventSearch,
+		Timestamp:       start.UTC(),
+		ClientID:        opts.ClientID,
+		Tenant:          query.Tenant,
+		Collection:      query.Collection,
+		Query:           string(rawQuery),
+		NormalizedQuery: strings.Join(query.Keywords, " "),
+		Language:        query.Language,
+		Intent:          query.Intent,
+		LatencyMs:       time.Since(start).Milliseconds(),
+		From:            opts.From,
+		Size:            opts.Size,
+		Sort:            formatSortSpec(opts.Sort),
+		Filters:         len(query.Filters),
+		Experiments:     opts.experiments,
+	}
+	if resp != nil {
+		record.QueryID = resp.QueryID
+		record.ShardsTotal = resp.Shards.Total
+		record.ShardsFailed = resp.Shards.Failed
+		record.TotalHits = resp.TotalHits
+		record.Returned = resp.Pagination.Returned
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// QueryLogSink receives batches of query log records, e.g. for analytics and relevance tuning.
+type QueryLogSink interface {
+	WriteRecords(ctx context.Context, records []QueryLogRecord) error
+	Close() error
+}
+
+// NewQueryLogSink creates a sink from a spec:
+//   - "file:/path/to/queries.jsonl" appends JSON lines to a file;
+//   - "http://collector/path" (or https) POSTs batches as JSON arrays;
+//   - "kafka://broker1:9092,broker2:9092/topic" produces one message per record.
+func NewQueryLogSink(spec string) (QueryLogSink, error) {
+	scheme, rest, found := strings.Cut(spec, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid query log sink %q, expected file:, http(s):// or kafka://", spec)
+	}
+	switch scheme {
+	case "file":
+		return NewFileQueryLogSink(rest)
+	case "http", "https":
+		return NewHTTPQueryLogSink(spec)
+	case "kafka":
+		brokers, topic, found := strings.Cut(strings.TrimPrefix(rest, "//"), "/")
+		if !found || brokers == "" || topic == "" {
+			return nil, fmt.Errorf("invalid kafka query log sink %q, expected kafka://broker[,broker...]/topic", spec)
+		}
+		return NewKafkaQueryLogSink(strings.Split(brokers, ","), topic), nil
+	default:
+		return nil, fmt.Errorf("unsupported query log sink scheme %q", scheme)
+	}
+}
+
+// QueryLogger writes query log records to a sink in the background, in batches, so
+// logging never slows searches down. Records are dropped when the buffer is full.
+type QueryLogger struct {
+	sink      QueryLogSink
+	records   chan QueryLogRecord
+	batchSize int
+	flush     time.Duration
+	dropped   atomic.Int64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewQueryLogger starts a logger writing to sink.
+func NewQueryLogger(sink QueryLogSink) *QueryLogger {
+	l := &QueryLogger{
+		sink:      sink,
+		records:   make(chan QueryLogRecord, defaultQueryLogBuffer),
+		batchSize: defaultQueryLogBatchSize,
+		flush:     defaultQueryLogFlush,
+		done:      make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Log queues a record without blocking.
+func (l *QueryLogger) Log(record QueryLogRecord) {
+	select {
+	case l.records <- record:
+	default:
+		if n := l.dropped.Add(1); n == 1 || n%1000 == 0 {
+			slog.Warn("Query log buffer full, dropping records", "dropped", n)
+		}
+	}
+}
+
+// Dropped returns the number of records dropped because the buffer was full.
+func (l *QueryLogger) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// Close flushes the queued records and closes the sink. Log must not be called afterwards.
+func (l *QueryLogger) Close() error {
+	l.closeOnce.Do(func() { close(l.records) })
+	<-l.done
+	return l.sink.Close()
+}
+
+// run batches records until the channel is closed.
+func (l *QueryLogger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.flush)
+	defer ticker.Stop()
+
+	batch := make([]QueryLogRecord, 0, l.batchSize)
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := l.sink.WriteRecords(ctx, batch); err != nil {
+			slog.Error("Failed to write query log records", "records", len(batch), "error", err)
+		}
+		cancel()
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case record, ok := <-l.records:
+			if !ok {
+				write()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= l.batchSize {
+				write()
+			}
+		case <-ticker.C:
+			write()
+		}
+	}
+}
+
+// FileQueryLogSink appends records as JSON lines to a file.
+type FileQueryLogSink struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+// NewFileQueryLogSink opens (or creates) path for appending.
+func NewFileQueryLogSink(path string) (*FileQueryLogSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open query log file %s: %w", path, err)
+	}
+	return &FileQueryLogSink{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// WriteRecords appends one JSON line per record.
+func (s *FileQueryLogSink) WriteRecords(_ context.Context, records []QueryLogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enc := json.NewEncoder(s.w)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("failed to write query log record: %w", err)
+		}
+	}
+	return s.w.Flush()
+}
+
+// Close flushes and closes the file.
+func (s *FileQueryLogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// HTTPQueryLogSink POSTs batches of records as a JSON array to a collector.
+type HTTPQueryLogSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPQueryLogSink creates a sink posting to the collector at rawURL.
+func NewHTTPQueryLogSink(rawURL string) (*HTTPQueryLogSink, error) {
+	if u, err := url.Parse(rawURL); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid query log collector URL %q", rawURL)
+	}
+	return &HTTPQueryLogSink{url: rawURL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// WriteRecords posts the batch to the collector.
+func (s *HTTPQueryLogSink) WriteRecords(ctx context.Context, records []QueryLogRecord) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to encode query log records: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create query log request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post query log records: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("query log collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close is a no-op: the sink holds no resources.
+func (s *HTTPQueryLogSink) Close() error {
+	return nil
+}
+
+// KafkaQueryLogSink produces one JSON message per record to a Kafka topic, keyed by
+// collection so a collection's queries stay ordered within a partition.
+type KafkaQueryLogSink struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaQueryLogSink creates a sink producing to topic on the given brokers.
+func NewKafkaQueryLogSink(brokers []string, topic string) *KafkaQueryLogSink {
+	return &KafkaQueryLogSink{writer: &kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+	}}
+}
+
+// WriteRecords produces the batch.
+func (s *KafkaQueryLogSink) WriteRecords(ctx context.Context, records []QueryLogRecord) error {
+	messages := make([]kafka.Message, 0, len(records))
+	for _, record := range records {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode query log record: %w", err)
+		}
+		messages = append(messages, kafka.Message{Key: []byte(record.Collection), Value: value})
+	}
+	if err := s.writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to produce query log records: %w", err)
+	}
+	return nil
+}
+
+// Close flushes pending messages and closes the producer.
+func (s *KafkaQueryLogSink) Close() error {
+	return s.writer.Close()
+}
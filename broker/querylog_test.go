@@ -0,0 +1,154 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// recordingSink collects the records written to it.
+type recordingSink struct {
+	mu      sync.Mutex
+	records []QueryLogRecord
+	closed  bool
+}
+
+func (s *recordingSink) WriteRecords(_ context.Context, records []QueryLogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestBroker_SearchWithOptions_LogsQueries(t *testing.T) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, rq RawQuery) (StructuredQuery, error) {
+			if rq == "broken" {
+				return StructuredQuery{}, errors.New("qu failed")
+			}
+			return StructuredQuery{Keywords: []string{"running", "shoes"}, Language: "en"}, nil
+		},
+	}
+	searcher := &MockSearcher{
+		ShardID: 0,
+		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			return []SearchResult{{ID: "doc1", Score: 1}, {ID: "doc2", Score: 0.5}}, nil
+		},
+	}
+	b := NewBroker(mockQU, []Searcher{searcher})
+	sink := &recordingSink{}
+	logger := NewQueryLogger(sink)
+	b.SetQueryLogger(logger)
+
+	if _, err := b.SearchWithOptions(context.Background(), "Running Shoes", SearchOptions{Size: 1, ClientID: "web"}); err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if _, err := b.SearchWithOptions(context.Background(), "broken", SearchOptions{Size: 10}); err == nil {
+		t.Fatal("Expected an error from query understanding")
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	if !sink.closed {
+		t.Error("Expected the sink to be closed")
+	}
+	if len(sink.records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(sink.records))
+	}
+	rec := sink.records[0]
+	if rec.Query != "Running Shoes" || rec.NormalizedQuery != "running shoes" || rec.ClientID != "web" || rec.Language != "en" {
+		t.Errorf("Unexpected record: %+v", rec)
+	}
+	if rec.ShardsTotal != 1 || rec.ShardsFailed != 0 || rec.TotalHits != 2 || rec.Returned != 1 || rec.Error != "" {
+		t.Errorf("Unexpected result stats in record: %+v", rec)
+	}
+	if sink.records[1].Error == "" {
+		t.Errorf("Expected the failed search to be logged with its error, got %+v", sink.records[1])
+	}
+}
+
+func TestFileQueryLogSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.jsonl")
+	sink, err := NewQueryLogSink("file:" + path)
+	if err != nil {
+		t.Fatalf("NewQueryLogSink returned an error: %v", err)
+	}
+	records := []QueryLogRecord{{Query: "a", TotalHits: 1}, {Query: "b", TotalHits: 2}}
+	if err := sink.WriteRecords(context.Background(), records); err != nil {
+		t.Fatalf("WriteRecords returned an error: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open query log: %v", err)
+	}
+	defer f.Close()
+	var got []QueryLogRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec QueryLogRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, rec)
+	}
+	if len(got) != 2 || got[0].Query != "a" || got[1].TotalHits != 2 {
+		t.Errorf("Unexpected records in file: %+v", got)
+	}
+}
+
+func TestHTTPQueryLogSink(t *testing.T) {
+	var got []QueryLogRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected request %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Failed to decode posted records: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sink, err := NewQueryLogSink(server.URL + "/queries")
+	if err != nil {
+		t.Fatalf("NewQueryLogSink returned an error: %v", err)
+	}
+	if err := sink.WriteRecords(context.Background(), []QueryLogRecord{{Query: "a", ClientID: "app"}}); err != nil {
+		t.Fatalf("WriteRecords returned an error: %v", err)
+	}
+	if len(got) != 1 || got[0].Query != "a" || got[0].ClientID != "app" {
+		t.Errorf("Unexpected records posted: %+v", got)
+	}
+}
+
+func TestNewQueryLogSink_Invalid(t *testing.T) {
+	for _, spec := range []string{"queries.jsonl", "syslog:local0", "kafka://localhost:9092", "kafka:///topic", "http://"} {
+		if _, err := NewQueryLogSink(spec); err == nil {
+			t.Errorf("Expected an error for sink %q", spec)
+		}
+	}
+	sink, err := NewQueryLogSink("kafka://k1:9092,k2:9092/queries")
+	if err != nil {
+		t.Fatalf("NewQueryLogSink returned an error for a kafka sink: %v", err)
+	}
+	sink.Close()
+}
@@ -0,0 +1,41 @@
+package broker
+
+import "strings"
+
+// Query tree node types produced by query understanding's syntax parsing; match_all nodes
+// come from Elasticsearch queries (see HandleESSearch).
+const (
+	NodeTerm     = "term"      // A single word, matched after analysis
+	NodePhrase   = "phrase"    // Words that must appear next to each other, in order
+	NodeBool     = "bool"      // A combination of Must, Should and MustNot clauses
+	NodeMatchAll = "match_all" // Every document
+)
+
+// QueryNode is a node of the boolean query tree parsed from query syntax such as
+// "quoted phrases", +required and -excluded terms, field:value and OR groups. Term and
+// phrase nodes match Text, on Field if set. A bool node matches every Must clause and no
+// MustNot clause, and at least one Should clause when it has no Must clauses.
+// The JSON form matches the searcher service's "query" parameter.
+type QueryNode struct {
+	Type    string       `json:"type"`
+	Field   string       `json:"field,omitempty"`
+	Text    string       `json:"text,omitempty"`
+	Must    []*QueryNode `json:"must,omitempty"`
+	Should  []*QueryNode `json:"should,omitempty"`
+	MustNot []*QueryNode `json:"must_not,omitempty"`
+}
+
+// keywords returns the words of the term and phrase nodes a match must satisfy, leaving
+// out those of MustNot clauses.
+func (n *QueryNode) keywords() []string {
+	if n == nil {
+		return nil
+	}
+	keywords := strings.Fields(strings.ToLower(n.Text))
+	for _, clauses := range [][]*QueryNode{n.Must, n.Should} {
+		for _, c := range clauses {
+			keywords = append(keywords, c.keywords()...)
+		}
+	}
+	return keywords
+}
@@ -0,0 +1,171 @@
+package broker
+
+import (
+	"sort"
+	"time"
+)
+
+// ResponseVersion identifies the layout of SearchResponse. It is bumped whenever
+// the envelope changes in a way that is not backwards compatible.
+const ResponseVersion = "v1"
+
+// SearchOptions controls how the merged results of a search are returned.
+type SearchOptions struct {
+	Collection   string        // Collection to search; empty means DefaultCollection
+	Tenant       string        // Tenant owning the collection; empty for the default tenant
+	Language     string        // Language of the query, e.g. "fr"; empty lets query understanding detect it
+	From         int           // Offset of the first result to return
+	Size         int           // Maximum number of results to return; 0 means no limit
+	Sort         []SortField   // Result order; empty means descending score
+	Filters      []Filter      // Filters added to those produced by query understanding
+	Geo          *GeoQuery     // Geo distance restriction; overrides one produced by query understanding
+	ClientID     string        // Identifies the calling client in the query log
+	Timeout      time.Duration // Latency budget of this search; 0 uses the broker's budget
+	Debug        bool          // Include the debug section (per-stage timings) in the response
+	Explain      bool          // Ask searchers for scoring explanations, returned in the debug section
+	Fuzziness    int           // Edit distance tolerated when matching query terms, 0 to MaxFuzziness
+	PrefixLength int           // Leading characters a fuzzy match must share with the query term
+	Fields       []string      // Stored fields returned with every result; AllFields returns all of them
+	AutoCorrect  bool          // Return the results of the did-you-mean query when it finds more hits
+	Mode         string        // ModeInstant for search-as-you-type, ModeHybrid for keyword and kNN search; empty for a full search
+	Collapse     *Collapse     // Groups the results by field, keeping the best of every group; nil keeps them all
+	Types        []string      // Document types results are restricted to; empty searches all types
+	SearchType   string        // SearchTypeQueryThenFetch or SearchTypeQueryAndFetch; empty uses the broker's default
+	// Query is searched as is, skipping query understanding and did-you-mean corrections;
+	// nil runs query understanding on the raw query.
+	Query *QueryNode
+	// KNN searches the nearest neighbors of a vector, among the documents matching the
+	// query if there is one; nil searches text only.
+	KNN *KNNQuery
+	// OnShard is called with the results of every shard as it answers, one call at a time,
+	// before the merged response is returned; nil streams nothing.
+	OnShard func(ShardUpdate)
+
+	// UserID identifies the end user; experiments assign users to their buckets by it and
+	// the personalizer re-scores their results.
+	UserID string
+
+	fanOut      *sharedFanOut          // Shares identical shard requests with the other searches of a batch
+	experiments []ExperimentAssignment // Buckets the search is assigned to
+	pipeline    string                 // Query understanding pipeline set by an experiment bucket
+	reranker    *Reranker              // Ranking rules set by an experiment bucket
+	vectorOnly  bool                   // Search the kNN query alone, the text only supplying its embedding
+	understood  *sharedUnderstanding   // Shares the understanding of the text with the other search of a hybrid search
+	relaxations []string               // Relaxations of the fallback chain applied to the structured query
+}
+
+// ShardStatus reports how the searchers of a single shard answered a query.
+type ShardStatus struct {
+	ShardID    int      `json:"shard_id"`
+	Searchers  int      `json:"searchers"`
+	Successful int      `json:"successful"`
+	Failed     int      `json:"failed"`
+	Hits       int      `json:"hits"`
+	TookMs     int64    `json:"took_ms"`
+	Errors     []string `json:"errors,omitempty"`
+	Segment    string   `json:"segment,omitempty"` // Segment the answering searcher served; empty if unknown
+}
+
+// ShardsSummary aggregates the shard statuses of a query.
+type ShardsSummary struct {
+	Total      int           `json:"total"`
+	Successful int           `json:"successful"`
+	Failed     int           `json:"failed"`
+	Skipped    int           `json:"skipped,omitempty"` // Shards pruned by the routing key filters, not counted in Total
+	Details    []ShardStatus `json:"details"`
+}
+
+// Pagination describes the window of results included in a SearchResponse.
+type Pagination struct {
+	From     int  `json:"from"`
+	Size     int  `json:"size"`
+	Returned int  `json:"returned"`
+	HasMore  bool `json:"has_more"`
+}
+
+// SearchResponse is the versioned envelope returned by the broker's search API.
+// TotalHits counts the merged, de-duplicated results across all queried shards.
+type SearchResponse struct {
+	Version    string         `json:"version"`
+	QueryID    string         `json:"query_id"` // Identifies the search in feedback events
+	Tenant     string         `json:"tenant,omitempty"`
+	Collection string         `json:"collection"`
+	TotalHits  int            `json:"total_hits"`
+	TookMs     int64          `json:"took_ms"`
+	Shards     ShardsSummary  `json:"shards"`
+	Pagination Pagination     `json:"pagination"`
+	Results    []SearchResult `json:"results"`
+	Debug      *SearchDebug   `json:"debug,omitempty"`
+	DidYouMean *DidYouMean    `json:"did_you_mean,omitempty"`
+	// Relaxation reports how the query was relaxed for the results of a search that had
+	// none, see Broker.SetFallbacks.
+	Relaxation *QueryRelaxation `json:"relaxation,omitempty"`
+	// Collapse reports the groups of searches collapsed by field.
+	Collapse *CollapseSummary `json:"collapse,omitempty"`
+	// Experiments lists the experiment buckets the search was assigned to.
+	Experiments []ExperimentAssignment `json:"experiments,omitempty"`
+
+	stages []StageTiming // Timings of the search's stages, reported with Debug on request
+}
+
+// summarizeShards converts the per-shard statuses into a ShardsSummary ordered by shard ID.
+// A shard counts as successful if at least one of its searchers answered.
+func summarizeShards(shardIDs []int, statuses map[int]*ShardStatus) ShardsSummary {
+	summary := ShardsSummary{Total: len(shardIDs), Details: make([]ShardStatus, 0, len(shardIDs))}
+	for _, shardID := range shardIDs {
+		status := statuses[shardID]
+		if status.Successful > 0 {
+			summary.Successful++
+		} else {
+			summary.Failed++
+		}
+		summary.Details = append(summary.Details, *status)
+	}
+	sort.Slice(summary.Details, func(i, j int) bool {
+		return summary.Details[i].ShardID < summary.Details[j].ShardID
+	})
+	return summary
+}
+
+const (
+	// defaultShardSize is the number of results searchers return by default; smaller
+	// pages ask for as many, so that the searches of a batch paging through the same
+	// results share their shard requests.
+	defaultShardSize = 10
+	// maxShardSize bounds the results a search asks every shard for, the largest size the
+	// searchers accept: pages past it are empty.
+	maxShardSize = 1000
+)
+
+// shardSize returns the number of results every shard must return for the merged results
+// to hold the page: From+Size as one shard may hold all of them, and one more to tell
+// whether more pages follow, up to maxShardSize. It is 0, the searchers' default, for
+// searches without a size.
+func (o SearchOptions) shardSize() int {
+	if o.Size <= 0 {
+		return 0
+	}
+	return min(max(o.From+o.Size+1, defaultShardSize), maxShardSize)
+}
+
+// paginate returns the window [from, from+size) of results and its Pagination metadata.
+// A size of 0 returns every result from the offset onwards.
+func paginate(results []SearchResult, from, size int) ([]SearchResult, Pagination) {
+	if from < 0 {
+		from = 0
+	}
+	if from > len(results) {
+		from = len(results)
+	}
+	end := len(results)
+	if size > 0 && from+size < end {
+		end = from + size
+	}
+	page := results[from:end]
+	return page, Pagination{
+		From:     from,
+		Size:     size,
+		Returned: len(page),
+		HasMore:  end < len(results),
+	}
+}
@@ -0,0 +1,256 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Ranking rule types.
+const (
+	RuleBoost = "boost" // Multiplies the score of matching results by Factor
+	RulePin   = "pin"   // Moves the documents IDs to the top, in order
+	RuleBury  = "bury"  // Moves matching results below all the others
+)
+
+// Pseudo-fields ranking rules can match besides stored fields.
+const (
+	RuleFieldID    = "id"
+	RuleFieldTitle = "title"
+	RuleFieldURL   = "url"
+	RuleFieldHost  = "host" // Host name of the result's URL, to match whole sources
+)
+
+// ErrInvalidRankingRule is returned for ranking rules that can't be applied.
+var ErrInvalidRankingRule = errors.New("invalid ranking rule")
+
+// RankingRule is a business rule adjusting the order of the merged results of a search.
+// Boost and bury rules match the results whose Field has one of Values, compared
+// case-insensitively; Field is a stored field or one of the RuleField pseudo-fields. Pin
+// rules put the results with the given IDs first; documents missing from the results
+// aren't added. A rule with Queries only applies to those queries, compared after
+// lowercasing and collapsing whitespace; pin rules require them.
+type RankingRule struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Queries []string `yaml:"queries"`
+	Field   string   `yaml:"field"`  // Boost and bury rules
+	Values  []string `yaml:"values"` // Boost and bury rules
+	Factor  float64  `yaml:"factor"` // Boost rules; above 1 promotes, between 0 and 1 demotes
+	IDs     []string `yaml:"ids"`    // Pin rules, in the order they are pinned
+}
+
+// Validate checks that the rule has the settings its type requires.
+func (r RankingRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidRankingRule)
+	}
+	switch r.Type {
+	case RuleBoost, RuleBury:
+		if r.Field == "" || len(r.Values) == 0 {
+			return fmt.Errorf("%w: %s rule %q requires a field and values", ErrInvalidRankingRule, r.Type, r.Name)
+		}
+		if r.Type == RuleBoost && r.Factor <= 0 {
+			return fmt.Errorf("%w: boost rule %q requires a positive factor", ErrInvalidRankingRule, r.Name)
+		}
+	case RulePin:
+		if len(r.Queries) == 0 || len(r.IDs) == 0 {
+			return fmt.Errorf("%w: pin rule %q requires queries and ids", ErrInvalidRankingRule, r.Name)
+		}
+	default:
+		return fmt.Errorf("%w: rule %q has unknown type %q", ErrInvalidRankingRule, r.Name, r.Type)
+	}
+	return nil
+}
+
+// compiledRule is a RankingRule with its queries and values normalized for lookups.
+type compiledRule struct {
+	RankingRule
+	queries map[string]bool
+	values  map[string]bool
+}
+
+// Reranker applies ranking rules to the merged results of a search: boosts first, then
+// bury rules, then pins, so a pinned result stays on top even if a rule buries it. It is
+// immutable and safe for concurrent use.
+type Reranker struct {
+	rules []compiledRule
+}
+
+// NewReranker validates rules and returns a Reranker applying them in order.
+func NewReranker(rules []RankingRule) (*Reranker, error) {
+	r := &Reranker{rules: make([]compiledRule, 0, len(rules))}
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w: duplicate rule name %q", ErrInvalidRankingRule, rule.Name)
+		}
+		names[rule.Name] = true
+		c := compiledRule{RankingRule: rule, queries: make(map[string]bool), values: make(map[string]bool)}
+		for _, q := range rule.Queries {
+			c.queries[normalizeRuleText(q)] = true
+		}
+		for _, v := range rule.Values {
+			c.values[normalizeRuleText(v)] = true
+		}
+		r.rules = append(r.rules, c)
+	}
+	return r, nil
+}
+
+// Fields returns the stored fields the rules match on, which searchers must return with
+// every result, including the query phase of query-then-fetch searches.
+func (r *Reranker) Fields() []string {
+	var fields []string
+	for _, rule := range r.rules {
+		if rule.Field != "" {
+			fields = appendMissing(fields, storedRuleFields(rule.Field)...)
+		}
+	}
+	return fields
+}
+
+// storedRuleFields returns the stored fields the values of a rule field are read from:
+// the pseudo-fields title, url and host are read from the title and url of the result,
+// which the query phase of query-then-fetch searches doesn't return unless asked to.
+func storedRuleFields(field string) []string {
+	switch field {
+	case RuleFieldID:
+		return nil
+	case RuleFieldURL, RuleFieldHost:
+		return []string{"url"}
+	}
+	return []string{field}
+}
+
+// Rerank applies the rules to the results of query and returns them in their new order.
+// Boosts reorder the results only if they are sorted by score (byScore); otherwise they
+// only change the scores. The names of the rules that affected a result are appended to
+// its Rules.
+func (r *Reranker) Rerank(query string, results []SearchResult, byScore bool) []SearchResult {
+	query = normalizeRuleText(query)
+	var buried, pinned []*compiledRule
+	boosted := false
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if len(rule.queries) > 0 && !rule.queries[query] {
+			continue
+		}
+		switch rule.Type {
+		case RuleBoost:
+			for j := range results {
+				if rule.matches(results[j]) {
+					results[j].Score *= rule.Factor
+					results[j].Rules = append(results[j].Rules, rule.Name)
+					boosted = true
+				}
+			}
+		case RuleBury:
+			buried = append(buried, rule)
+		case RulePin:
+			pinned = append(pinned, rule)
+		}
+	}
+	if boosted && byScore {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	}
+
+	if len(buried) > 0 {
+		kept := make([]SearchResult, 0, len(results))
+		var sunk []SearchResult
+		for _, result := range results {
+			isBuried := false
+			for _, rule := range buried {
+				if rule.matches(result) {
+					result.Rules = append(result.Rules, rule.Name)
+					isBuried = true
+				}
+			}
+			if isBuried {
+				sunk = append(sunk, result)
+			} else {
+				kept = append(kept, result)
+			}
+		}
+		results = append(kept, sunk...)
+	}
+
+	if len(pinned) > 0 {
+		positions := make(map[string]int, len(results))
+		for i, result := range results {
+			positions[result.ID] = i
+		}
+		var top []SearchResult
+		moved := make(map[int]bool)
+		for _, rule := range pinned {
+			for _, id := range rule.IDs {
+				i, ok := positions[id]
+				if !ok || moved[i] {
+					continue
+				}
+				moved[i] = true
+				result := results[i]
+				result.Rules = append(result.Rules, rule.Name)
+				top = append(top, result)
+			}
+		}
+		for i, result := range results {
+			if !moved[i] {
+				top = append(top, result)
+			}
+		}
+		results = top
+	}
+	return results
+}
+
+// matches reports whether one of the values of the rule's field in result is one of the
+// rule's values.
+func (c *compiledRule) matches(result SearchResult) bool {
+	for _, v := range ruleFieldValues(result, c.Field) {
+		if c.values[normalizeRuleText(v)] {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleFieldValues returns the values of field in result, formatted as strings.
+func ruleFieldValues(result SearchResult, field string) []string {
+	switch field {
+	case RuleFieldID:
+		return []string{result.ID}
+	case RuleFieldTitle:
+		return []string{result.Title}
+	case RuleFieldURL:
+		return []string{result.URL}
+	case RuleFieldHost:
+		u, err := url.Parse(result.URL)
+		if err != nil {
+			return nil
+		}
+		return []string{u.Hostname()}
+	}
+	switch v := result.Stored[field].(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			values = append(values, fmt.Sprint(e))
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// normalizeRuleText lowercases s and collapses its whitespace.
+func normalizeRuleText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
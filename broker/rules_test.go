@@ -0,0 +1,134 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func testRankingRules() []RankingRule {
+	return []RankingRule{
+		{Name: "promote-acme", Type: RuleBoost, Field: "brand", Values: []string{"ACME"}, Factor: 3},
+		{Name: "bury-spam", Type: RuleBury, Field: RuleFieldHost, Values: []string{"spam.example.com"}},
+		{Name: "pin-sale", Type: RulePin, Queries: []string{"Summer  Sale"}, IDs: []string{"sale", "missing"}},
+	}
+}
+
+func rankingTestResults() []SearchResult {
+	return []SearchResult{
+		{ID: "spam", URL: "http://spam.example.com/1", Score: 4},
+		{ID: "a", Score: 3, Stored: map[string]interface{}{"brand": "other"}},
+		{ID: "acme", Score: 2, Stored: map[string]interface{}{"brand": []interface{}{"acme", "acme-pro"}}},
+		{ID: "sale", Score: 0.5},
+	}
+}
+
+func resultIDs(results []SearchResult) []string {
+	ids := make([]string, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestReranker_Rerank(t *testing.T) {
+	r, err := NewReranker(testRankingRules())
+	if err != nil {
+		t.Fatalf("NewReranker returned an error: %v", err)
+	}
+	if got := r.Fields(); !reflect.DeepEqual(got, []string{"brand", "url"}) {
+		t.Errorf("Expected the rules to read the brand and url fields, got %v", got)
+	}
+
+	// acme is boosted to 6 and overtakes the other results; spam sinks to the bottom.
+	results := r.Rerank("shoes", rankingTestResults(), true)
+	if got, want := resultIDs(results), []string{"acme", "a", "sale", "spam"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected order: got %v, want %v", got, want)
+	}
+	if results[0].Score != 6 || !reflect.DeepEqual(results[0].Rules, []string{"promote-acme"}) {
+		t.Errorf("Expected acme to be boosted by promote-acme, got %+v", results[0])
+	}
+	if !reflect.DeepEqual(results[3].Rules, []string{"bury-spam"}) || results[1].Rules != nil {
+		t.Errorf("Expected only spam to be buried, got %+v", results)
+	}
+
+	// The pin only applies to its query, and pinned documents missing from the results
+	// are ignored.
+	results = r.Rerank("summer sale", rankingTestResults(), true)
+	if got, want := resultIDs(results), []string{"sale", "acme", "a", "spam"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected order: got %v, want %v", got, want)
+	}
+
+	// Results sorted on a field keep their order under boosts.
+	results = r.Rerank("shoes", rankingTestResults(), false)
+	if got, want := resultIDs(results), []string{"a", "acme", "sale", "spam"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected order: got %v, want %v", got, want)
+	}
+}
+
+func TestNewReranker_InvalidRules(t *testing.T) {
+	for _, rules := range [][]RankingRule{
+		{{Type: RuleBury, Field: "host", Values: []string{"x"}}},
+		{{Name: "b", Type: RuleBoost, Field: "brand", Values: []string{"x"}}},
+		{{Name: "b", Type: RuleBury, Field: "brand"}},
+		{{Name: "p", Type: RulePin, IDs: []string{"a"}}},
+		{{Name: "x", Type: "shuffle"}},
+		{
+			{Name: "dup", Type: RuleBury, Field: "host", Values: []string{"x"}},
+			{Name: "dup", Type: RuleBury, Field: "host", Values: []string{"y"}},
+		},
+	} {
+		if _, err := NewReranker(rules); !errors.Is(err, ErrInvalidRankingRule) {
+			t.Errorf("Expected ErrInvalidRankingRule for %+v, got %v", rules, err)
+		}
+	}
+}
+
+func TestBroker_Search_RankingRules(t *testing.T) {
+	var rankingFields []string
+	searcher := &MockSearcher{
+		ShardID: 0,
+		SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+			rankingFields = query.RankingFields
+			return rankingTestResults(), nil
+		},
+	}
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{searcher})
+	r, err := NewReranker(testRankingRules())
+	if err != nil {
+		t.Fatalf("NewReranker returned an error: %v", err)
+	}
+	b.SetReranker(r)
+
+	rec := httptest.NewRecorder()
+	NewHandler(b).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=summer+sale&explain=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !reflect.DeepEqual(rankingFields, []string{"brand", "url"}) {
+		t.Errorf("Expected searchers to be asked for the brand and url fields, got %v", rankingFields)
+	}
+	var resp SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if got, want := resultIDs(resp.Results), []string{"sale", "acme", "a", "spam"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected order: got %v, want %v", got, want)
+	}
+	if resp.Debug == nil || len(resp.Debug.Explanations) != 4 {
+		t.Fatalf("Expected an explanation per result, got %+v", resp.Debug)
+	}
+	wantRules := [][]string{{"pin-sale"}, {"promote-acme"}, nil, {"bury-spam"}}
+	for i, e := range resp.Debug.Explanations {
+		if !reflect.DeepEqual(e.Rules, wantRules[i]) {
+			t.Errorf("Explanation of %s: expected rules %v, got %v", e.ID, wantRules[i], e.Rules)
+		}
+	}
+	if stages := resp.Debug.Stages; len(stages) != 4 || stages[3].Stage != StageRerank {
+		t.Errorf("Expected the rerank stage after the merge, got %+v", stages)
+	}
+}
@@ -0,0 +1,306 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"common/tenant"
+)
+
+const (
+	defaultScrollSize = 100
+	maxScrollSize     = 1000
+)
+
+var (
+	// ErrInvalidCursor is returned for scroll cursors that can't be decoded or belong to
+	// another tenant.
+	ErrInvalidCursor = errors.New("invalid scroll cursor")
+	// ErrScrollIncomplete is returned when a shard fails to return its part of a scroll
+	// page; the page can be asked again with the same cursor.
+	ErrScrollIncomplete = errors.New("scroll page incomplete")
+)
+
+// ScrollPage is a page of the results of a scroll, which exports every result of a search
+// page by page.
+type ScrollPage struct {
+	Collection string         `json:"collection"`
+	Results    []SearchResult `json:"results"`
+	Cursor     string         `json:"cursor,omitempty"` // Returns the next page; empty once every result was returned
+	TookMs     int64          `json:"took_ms"`
+	Shards     ShardsSummary  `json:"shards"`
+}
+
+// scrollCursor is the state of a scroll, carried by the client between pages: the query
+// as understood for the first page, and where every shard's results resume.
+type scrollCursor struct {
+	Query StructuredQuery  `json:"query"`
+	Size  int              `json:"size"`
+	After map[int][]string `json:"after"` // Sort key of the last result of every shard returned so far
+}
+
+// encode returns the cursor as an opaque token.
+func (c *scrollCursor) encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeScrollCursor parses a cursor token.
+func decodeScrollCursor(token string) (*scrollCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c scrollCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Size <= 0 || c.Size > maxScrollSize {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// scrollSort returns the sort of a scroll: the requested one, by descending score if
+// none, with the document ID breaking ties so that every result has a distinct sort key.
+func scrollSort(order []SortField) []SortField {
+	if len(order) == 0 {
+		order = []SortField{{Field: SortFieldScore, Desc: true}}
+	}
+	for _, f := range order {
+		if f.Field == SortFieldID {
+			return order
+		}
+	}
+	return append(append([]SortField(nil), order...), SortField{Field: SortFieldID})
+}
+
+// StartScroll runs query understanding on rawQuery and returns the first page of its
+// results, of opts.Size results, 100 by default. Results are in a stable sort order,
+// the requested one with the document ID breaking ties, and aren't reranked, collapsed
+// or personalized. Later pages are returned by Scroll; each is deterministic as long as
+// the index doesn't change.
+func (b *Broker) StartScroll(ctx context.Context, rawQuery RawQuery, opts SearchOptions) (*ScrollPage, error) {
+	if err := b.tenantLimiter.Allow(opts.Tenant); err != nil {
+		return nil, err
+	}
+	collection := opts.Collection
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	if _, err := b.searcherPool(opts.Tenant, collection); err != nil {
+		return nil, err
+	}
+	size := opts.Size
+	if size == 0 {
+		size = defaultScrollSize
+	}
+	if size < 0 || size > maxScrollSize {
+		return nil, fmt.Errorf("scroll size must be between 1 and %d", maxScrollSize)
+	}
+
+	var query StructuredQuery
+	if opts.Query != nil {
+		query = StructuredQuery{Keywords: opts.Query.keywords(), Query: opts.Query}
+	} else {
+		var err error
+		if query, err = b.queryUnderstanding.Process(WithCollection(ctx, collection), rawQuery); err != nil {
+			return nil, err
+		}
+	}
+	query.Collection = collection
+	query.Tenant = opts.Tenant
+	query.Sort = scrollSort(opts.Sort)
+	query.Filters = append(query.Filters, opts.Filters...)
+	if opts.Geo != nil {
+		query.Geo = opts.Geo
+	}
+	query.Fuzziness = opts.Fuzziness
+	query.PrefixLength = opts.PrefixLength
+	query.Fields = opts.Fields
+	query.Types = opts.Types
+	query.Size = size
+	query.Embedding = nil
+	return b.scroll(ctx, &scrollCursor{Query: query, Size: size, After: map[int][]string{}})
+}
+
+// Scroll returns the page of results following the cursor returned with the previous
+// page. The cursor must have been returned to tenantID.
+func (b *Broker) Scroll(ctx context.Context, token, tenantID string) (*ScrollPage, error) {
+	cursor, err := decodeScrollCursor(token)
+	if err != nil {
+		return nil, err
+	}
+	if cursor.Query.Tenant != tenantID {
+		return nil, fmt.Errorf("%w: it belongs to another tenant", ErrInvalidCursor)
+	}
+	if err := b.tenantLimiter.Allow(tenantID); err != nil {
+		return nil, err
+	}
+	return b.scroll(ctx, cursor)
+}
+
+// scroll asks every shard for a page of results following its position in the cursor,
+// merges them and returns the first cursor.Size, advancing the cursor of every shard past
+// its results returned. The page fails if any shard fails, as skipping a shard would
+// lose its results.
+func (b *Broker) scroll(ctx context.Context, cursor *scrollCursor) (*ScrollPage, error) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "broker.Scroll")
+	defer span.End()
+	collection := poolKey(cursor.Query.Tenant, cursor.Query.Collection)
+	pool, err := b.searcherPool(cursor.Query.Tenant, cursor.Query.Collection)
+	if err != nil {
+		return nil, err
+	}
+	if b.budget.Total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.budget.Total)
+		defer cancel()
+	}
+
+	type shardPage struct {
+		results []SearchResult
+		ok      bool
+	}
+	shardIDs := make([]int, 0, len(pool))
+	statuses := make(map[int]*ShardStatus, len(pool))
+	pages := make(map[int]*shardPage, len(pool))
+	for shardID := range pool {
+		shardIDs = append(shardIDs, shardID)
+		statuses[shardID] = &ShardStatus{ShardID: shardID}
+		pages[shardID] = &shardPage{}
+	}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, shardID := range shardIDs {
+		wg.Add(1)
+		go func(shardID int) {
+			defer wg.Done()
+			query := cursor.Query
+			query.SearchAfter = cursor.After[shardID]
+			key := ShardKey{Collection: collection, ShardID: shardID}
+			results, _, ok := b.searchShard(ctx, key, pool[shardID], query, func(err error, took time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				statuses[shardID].recordAttempt(err, took)
+			})
+			if ok && b.globalStats != nil && query.Sort[0].Field == SortFieldScore {
+				results = b.globalStats.rescore(key, query.Keywords, results)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			pages[shardID].results, pages[shardID].ok = fromShard(results, shardID), ok
+		}(shardID)
+	}
+	wg.Wait()
+	if canceled(ctx) {
+		return nil, cancelSearch(StageFanOut)
+	}
+
+	var (
+		lists [][]SearchResult
+		more  bool
+	)
+	for _, shardID := range shardIDs {
+		page := pages[shardID]
+		if !page.ok {
+			return nil, fmt.Errorf("%w: shard %d failed", ErrScrollIncomplete, shardID)
+		}
+		statuses[shardID].Successful++
+		statuses[shardID].Hits += len(page.results)
+		lists = append(lists, page.results)
+		more = more || len(page.results) >= cursor.Size
+	}
+	merged := mergeSorted(lists, cursor.Query.Sort)
+	results := merged
+	if len(results) > cursor.Size {
+		results, more = results[:cursor.Size], true
+	}
+	after := make(map[int][]string, len(cursor.After))
+	for shardID, key := range cursor.After {
+		after[shardID] = key
+	}
+	for _, r := range results {
+		after[r.shardID] = r.SortKey
+	}
+
+	page := &ScrollPage{
+		Collection: cursor.Query.Collection,
+		Results:    results,
+		Shards:     summarizeShards(shardIDs, statuses),
+	}
+	if more && len(results) > 0 {
+		next := &scrollCursor{Query: cursor.Query, Size: cursor.Size, After: after}
+		if page.Cursor, err = next.encode(); err != nil {
+			return nil, err
+		}
+	}
+	page.TookMs = time.Since(start).Milliseconds()
+	return page, nil
+}
+
+// scrollRequest is the body of POST /search/scroll: a cursor returned with a page, or
+// the search parameters of a new scroll.
+type scrollRequest map[string]interface{}
+
+// HandleScroll handles POST /search/scroll. A body with the search parameters of /search,
+// such as {"q": "shoes", "size": 500, "sort": "price:asc"}, starts a scroll and returns
+// its first page; {"cursor": "..."} returns the page following the one the cursor came
+// with. Pages keep coming until one is returned without a cursor.
+func (h *Handler) HandleScroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req scrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid scroll body, expected a JSON object: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		page *ScrollPage
+		err  error
+	)
+	if token, ok := req["cursor"].(string); ok {
+		tenantID, terr := tenant.FromRequest(r)
+		if terr != nil {
+			http.Error(w, terr.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err = h.broker().Scroll(r.Context(), token, tenantID)
+	} else {
+		// Scroll pages may be larger than search pages.
+		size, _ := req["size"].(float64)
+		delete(req, "size")
+		q, perr := parseMultiSearchQuery(r, req)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+		if q.Options.KNN != nil || q.Options.Mode != "" || q.Options.Collapse != nil {
+			http.Error(w, "scrolls don't support kNN, instant, hybrid or collapsed searches", http.StatusBadRequest)
+			return
+		}
+		if size != float64(int(size)) || size < 0 || size > maxScrollSize {
+			http.Error(w, fmt.Sprintf("invalid 'size', must be between 1 and %d", maxScrollSize), http.StatusBadRequest)
+			return
+		}
+		q.Options.Size = int(size)
+		page, err = h.broker().StartScroll(r.Context(), q.Query, q.Options)
+	}
+	if err != nil {
+		writeSearchError(w, r, err)
+		return
+	}
+	writeJSON(w, "application/json", page)
+}
@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// newScrollTestBroker returns a broker over three shards of ten documents each, scored
+// so that they interleave, whose searchers page with search_after like the searchers do.
+func newScrollTestBroker() *Broker {
+	var searchers []Searcher
+	for shardID := 0; shardID < 3; shardID++ {
+		var docs []SearchResult
+		for i := 0; i < 10; i++ {
+			docs = append(docs, SearchResult{ID: fmt.Sprintf("s%d-%d", shardID, i), Score: float64((i*3 + shardID) % 7)})
+		}
+		sort.Slice(docs, func(i, j int) bool { return resultLess(docs[i], docs[j], scrollSort(nil)) })
+		searchers = append(searchers, &MockSearcher{ShardID: shardID, SearchFunc: func(_ context.Context, q StructuredQuery) ([]SearchResult, error) {
+			var page []SearchResult
+			for _, doc := range docs {
+				if q.SearchAfter != nil {
+					score, _ := strconv.ParseFloat(q.SearchAfter[0], 64)
+					after := SearchResult{ID: q.SearchAfter[1], Score: score}
+					if !resultLess(after, doc, q.Sort) {
+						continue
+					}
+				}
+				doc.SortKey = []string{strconv.FormatFloat(doc.Score, 'g', -1, 64), doc.ID}
+				if page = append(page, doc); len(page) == q.Size {
+					break
+				}
+			}
+			return page, nil
+		}})
+	}
+	return NewBroker(&MockQueryUnderstandingService{}, searchers)
+}
+
+func TestBroker_Scroll(t *testing.T) {
+	b := newScrollTestBroker()
+	ctx := context.Background()
+
+	page, err := b.StartScroll(ctx, "shoes", SearchOptions{Size: 7})
+	if err != nil {
+		t.Fatalf("StartScroll returned an error: %v", err)
+	}
+	var all []SearchResult
+	for pages := 1; ; pages++ {
+		all = append(all, page.Results...)
+		if page.Cursor == "" {
+			if pages != 5 {
+				t.Errorf("Expected 5 pages of at most 7 results, got %d", pages)
+			}
+			break
+		}
+		if page, err = b.Scroll(ctx, page.Cursor, ""); err != nil {
+			t.Fatalf("Scroll returned an error: %v", err)
+		}
+	}
+	if len(all) != 30 {
+		t.Fatalf("Expected the 30 documents, got %d", len(all))
+	}
+	seen := make(map[string]bool)
+	for i, r := range all {
+		if seen[r.ID] {
+			t.Errorf("Document %s returned twice", r.ID)
+		}
+		seen[r.ID] = true
+		if i > 0 && resultLess(r, all[i-1], scrollSort(nil)) {
+			t.Errorf("Results %s and %s out of order", all[i-1].ID, r.ID)
+		}
+	}
+
+	if _, err := b.Scroll(ctx, "not a cursor", ""); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+	page, _ = b.StartScroll(ctx, "shoes", SearchOptions{Size: 7})
+	if _, err := b.Scroll(ctx, page.Cursor, "acme"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected the cursor of another tenant to be rejected, got %v", err)
+	}
+}
+
+func TestHandler_Scroll(t *testing.T) {
+	handler := NewHandler(newScrollTestBroker())
+	post := func(body string) (*httptest.ResponseRecorder, ScrollPage) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search/scroll", bytes.NewBufferString(body)))
+		var page ScrollPage
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		return rec, page
+	}
+
+	rec, page := post(`{"q": "shoes", "size": 500}`)
+	if rec.Code != http.StatusOK || len(page.Results) != 30 || page.Cursor != "" {
+		t.Fatalf("Expected every result in one page, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec, page = post(`{"q": "shoes", "size": 20}`)
+	if len(page.Results) != 20 || page.Cursor == "" {
+		t.Fatalf("Expected a first page of 20, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec, page = post(`{"cursor": "` + page.Cursor + `"}`); len(page.Results) != 10 || page.Cursor != "" {
+		t.Errorf("Expected a last page of 10, got %d %s", rec.Code, rec.Body.String())
+	}
+	for _, body := range []string{`{"q": "shoes", "size": 5000}`, `{"cursor": "!"}`, `{"q": "shoes", "mode": "instant"}`, `[]`} {
+		if rec, _ := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, rec.Code)
+		}
+	}
+}
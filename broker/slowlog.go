@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"log/slog"
+	"time"
+
+	"common/slowlog"
+)
+
+// SlowQueryRecord is the record written to the slow query log for searches that took at
+// least its threshold, failed ones included.
+type SlowQueryRecord struct {
+	Timestamp       time.Time       `json:"timestamp"`
+	QueryID         string          `json:"query_id,omitempty"`
+	ClientID        string          `json:"client_id,omitempty"`
+	Tenant          string          `json:"tenant,omitempty"`
+	Collection      string          `json:"collection"`
+	Query           string          `json:"query"`
+	StructuredQuery StructuredQuery `json:"structured_query"`
+	TookMs          int64           `json:"took_ms"`
+	ThresholdMs     int64           `json:"threshold_ms"`
+	Stages          []StageTiming   `json:"stages,omitempty"` // Time spent in every stage of the search
+	Shards          ShardsSummary   `json:"shards"`
+	TotalHits       int             `json:"total_hits"`
+	Returned        int             `json:"returned"`
+	Error           string          `json:"error,omitempty"`
+}
+
+// SetSlowQueryLog writes the searches slower than the threshold of l to it; nil disables
+// the slow query log.
+func (b *Broker) SetSlowQueryLog(l *slowlog.Logger) {
+	b.slowLog = l
+}
+
+// logSlowQuery writes a search started at start to the slow query log if it took too long.
+func (b *Broker) logSlowQuery(start time.Time, rawQuery RawQuery, opts SearchOptions, query StructuredQuery, resp *SearchResponse, err error) {
+	took := time.Since(start)
+	if !b.slowLog.Slow(took) {
+		return
+	}
+	record := SlowQueryRecord{
+		Timestamp:       start.UTC(),
+		ClientID:        opts.ClientID,
+		Tenant:          query.Tenant,
+		Collection:      query.Collection,
+		Query:           string(rawQuery),
+		StructuredQuery: query,
+		TookMs:          took.Milliseconds(),
+		ThresholdMs:     b.slowLog.Threshold().Milliseconds(),
+	}
+	if resp != nil {
+		record.QueryID = resp.QueryID
+		record.Stages = resp.stages
+		record.Shards = resp.Shards
+		record.TotalHits = resp.TotalHits
+		record.Returned = resp.Pagination.Returned
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := b.slowLog.Log(record); err != nil {
+		slog.Error("Failed to log slow query", "error", err)
+	}
+}
@@ -0,0 +1,53 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"common/slowlog"
+)
+
+func TestBroker_SlowQueryLog(t *testing.T) {
+	qu := &MockQueryUnderstandingService{ProcessFunc: func(_ context.Context, raw RawQuery) (StructuredQuery, error) {
+		return StructuredQuery{Keywords: []string{string(raw)}, Intent: "transactional"}, nil
+	}}
+	slow := &MockSearcher{ShardID: 0, SearchFunc: func(context.Context, StructuredQuery) ([]SearchResult, error) {
+		time.Sleep(20 * time.Millisecond)
+		return []SearchResult{{ID: "doc-1", Score: 1}, {ID: "doc-2", Score: 0.5}}, nil
+	}}
+	b := NewBroker(qu, []Searcher{slow})
+	b.SetDidYouMeanThreshold(-1)
+	var buf bytes.Buffer
+	b.SetSlowQueryLog(slowlog.NewWriter(10*time.Millisecond, &buf))
+
+	resp, err := b.SearchWithOptions(context.Background(), "shoes", SearchOptions{Size: 1})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	var record SlowQueryRecord
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a slow query record, got %q: %v", buf.String(), err)
+	}
+	if record.QueryID != resp.QueryID || record.Query != "shoes" || record.StructuredQuery.Intent != "transactional" || record.TookMs < 20 || record.ThresholdMs != 10 {
+		t.Errorf("Unexpected slow query record %+v", record)
+	}
+	if record.TotalHits != 2 || record.Returned != 1 || len(record.Shards.Details) != 1 || record.Shards.Details[0].Hits != 2 {
+		t.Errorf("Expected the hit counts and shard breakdown, got %+v", record)
+	}
+	stages := make(map[string]bool)
+	for _, stage := range record.Stages {
+		stages[stage.Stage] = true
+	}
+	if !stages[StageQueryUnderstanding] || !stages[StageFanOut] {
+		t.Errorf("Expected the stage timings, got %+v", record.Stages)
+	}
+
+	buf.Reset()
+	b.SetSlowQueryLog(slowlog.NewWriter(time.Hour, &buf))
+	if _, err := b.SearchWithOptions(context.Background(), "shoes", SearchOptions{}); err != nil || buf.Len() != 0 {
+		t.Errorf("Expected searches under the threshold not to be logged, got %v %q", err, buf.String())
+	}
+}
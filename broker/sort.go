@@ -0,0 +1,233 @@
+package broker
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// SortFieldScore sorts results by relevance score.
+	SortFieldScore = "_score"
+	// SortFieldID sorts results by document ID.
+	SortFieldID = "_id"
+	// SortFieldDistance sorts results by distance from the geo query origin.
+	SortFieldDistance = "_distance"
+)
+
+// SortField is a single sort criterion. Results are ordered by the first field,
+// ties are broken by the following ones.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// String returns the field in the "field:asc|desc" form understood by the searchers.
+func (f SortField) String() string {
+	if f.Desc {
+		return f.Field + ":desc"
+	}
+	return f.Field + ":asc"
+}
+
+// ParseSortSpec parses a comma-separated sort specification such as
+// "price:asc,created_at:desc". The direction defaults to ascending, except for
+// "_score" which defaults to descending. An empty spec returns no sort fields.
+func ParseSortSpec(spec string) ([]SortField, error) {
+	var fields []SortField
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, direction, _ := strings.Cut(part, ":")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("invalid sort expression %q: missing field name", part)
+		}
+		field := SortField{Field: name, Desc: name == SortFieldScore}
+		switch strings.ToLower(strings.TrimSpace(direction)) {
+		case "":
+		case "asc":
+			field.Desc = false
+		case "desc":
+			field.Desc = true
+		default:
+			return nil, fmt.Errorf("invalid sort direction %q for field %q, expected asc or desc", direction, name)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// formatSortSpec is the inverse of ParseSortSpec.
+func formatSortSpec(fields []SortField) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// resultLess reports whether a ranks before b under the given sort order.
+// Without sort fields, results are ordered by descending score.
+func resultLess(a, b SearchResult, order []SortField) bool {
+	if len(order) == 0 {
+		return a.Score > b.Score
+	}
+	for i, f := range order {
+		va, vb := sortValueOf(a, f, i), sortValueOf(b, f, i)
+		c := compareSortValues(va, vb)
+		if c == 0 {
+			continue
+		}
+		// Missing values sort last regardless of direction.
+		if va == nil || vb == nil {
+			return c < 0
+		}
+		if f.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// sortValueOf returns the value of the i-th sort field of r, falling back to the
+// result's own score and ID for the pseudo-fields.
+func sortValueOf(r SearchResult, f SortField, i int) interface{} {
+	switch f.Field {
+	case SortFieldScore:
+		return r.Score
+	case SortFieldID:
+		return r.ID
+	case SortFieldDistance:
+		if r.DistanceKm != nil {
+			return *r.DistanceKm
+		}
+	}
+	if i < len(r.SortValues) {
+		return r.SortValues[i]
+	}
+	return nil
+}
+
+// compareSortValues compares two sort values, returning -1, 0 or 1. Numbers compare
+// numerically, everything else lexically; nil compares greater than any value so that
+// missing values end up last.
+func compareSortValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	af, aNum := toFloat(a)
+	bf, bNum := toFloat(b)
+	if aNum && bNum {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// toFloat converts numeric sort values (as decoded from JSON or set in-process) to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// mergeSorted performs a k-way merge of per-searcher result lists into a single list
+// ordered by order, keeping only the best-ranked occurrence of each result ID.
+// Each list is expected to be sorted already; lists are sorted defensively so a
+// misbehaving searcher cannot break the global order.
+func mergeSorted(lists [][]SearchResult, order []SortField) []SearchResult {
+	h := &resultHeap{order: order}
+	total := 0
+	for _, list := range lists {
+		if len(list) == 0 {
+			continue
+		}
+		if !sort.SliceIsSorted(list, func(i, j int) bool { return resultLess(list[i], list[j], order) }) {
+			sort.SliceStable(list, func(i, j int) bool { return resultLess(list[i], list[j], order) })
+		}
+		h.cursors = append(h.cursors, resultCursor{results: list, source: len(h.cursors)})
+		total += len(list)
+	}
+	heap.Init(h)
+
+	merged := make([]SearchResult, 0, total)
+	seenIDs := make(map[string]struct{}, total)
+	for h.Len() > 0 {
+		c := &h.cursors[0]
+		result := c.results[c.pos]
+		if _, seen := seenIDs[result.ID]; !seen {
+			seenIDs[result.ID] = struct{}{}
+			merged = append(merged, result)
+		}
+		c.pos++
+		if c.pos == len(c.results) {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	return merged
+}
+
+// resultCursor is the read position within one searcher's result list.
+type resultCursor struct {
+	results []SearchResult
+	pos     int
+	source  int // Index of the list, used to break ties deterministically
+}
+
+// resultHeap is a min-heap of cursors ordered by their current result.
+type resultHeap struct {
+	cursors []resultCursor
+	order   []SortField
+}
+
+func (h *resultHeap) Len() int { return len(h.cursors) }
+
+func (h *resultHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	ra, rb := a.results[a.pos], b.results[b.pos]
+	if resultLess(ra, rb, h.order) {
+		return true
+	}
+	if resultLess(rb, ra, h.order) {
+		return false
+	}
+	return a.source < b.source
+}
+
+func (h *resultHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *resultHeap) Push(x interface{}) { h.cursors = append(h.cursors, x.(resultCursor)) }
+
+func (h *resultHeap) Pop() interface{} {
+	old := h.cursors
+	c := old[len(old)-1]
+	h.cursors = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSortSpec(t *testing.T) {
+	fields, err := ParseSortSpec("price:asc, created_at:DESC,_score,_id")
+	if err != nil {
+		t.Fatalf("ParseSortSpec returned an error: %v", err)
+	}
+	expected := []SortField{
+		{Field: "price"},
+		{Field: "created_at", Desc: true},
+		{Field: "_score", Desc: true},
+		{Field: "_id"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, fields)
+	}
+	if got := formatSortSpec(fields); got != "price:asc,created_at:desc,_score:desc,_id:asc" {
+		t.Errorf("Unexpected formatted sort spec: %s", got)
+	}
+
+	for _, spec := range []string{"price:up", ":asc"} {
+		if _, err := ParseSortSpec(spec); err == nil {
+			t.Errorf("Expected an error for sort spec %q", spec)
+		}
+	}
+}
+
+func TestMergeSorted_ByField(t *testing.T) {
+	order := []SortField{{Field: "price"}, {Field: "_id"}}
+	shard1 := []SearchResult{
+		{ID: "a", SortValues: []interface{}{1.0, "a"}},
+		{ID: "c", SortValues: []interface{}{5.0, "c"}},
+		{ID: "e", SortValues: []interface{}{nil, "e"}},
+	}
+	shard2 := []SearchResult{
+		{ID: "b", SortValues: []interface{}{2.0, "b"}},
+		{ID: "d", SortValues: []interface{}{10.0, "d"}},
+	}
+	// A replica of shard1 returning a duplicate must not produce duplicate results.
+	replica := []SearchResult{{ID: "c", SortValues: []interface{}{5.0, "c"}}}
+
+	merged := mergeSorted([][]SearchResult{shard1, shard2, replica}, order)
+	var ids []string
+	for _, r := range merged {
+		ids = append(ids, r.ID)
+	}
+	// Numeric comparison puts 10 after 5; the missing price sorts last.
+	if expected := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected merge order %v, got %v", expected, ids)
+	}
+
+	merged = mergeSorted([][]SearchResult{shard1, shard2}, []SortField{{Field: "price", Desc: true}})
+	ids = ids[:0]
+	for _, r := range merged {
+		ids = append(ids, r.ID)
+	}
+	if expected := []string{"d", "c", "b", "a", "e"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected descending merge order %v, got %v", expected, ids)
+	}
+}
+
+func TestMergeSorted_DefaultScoreOrder(t *testing.T) {
+	// The second list is deliberately unsorted; it must be ordered before merging.
+	lists := [][]SearchResult{
+		{{ID: "x", Score: 0.9}, {ID: "y", Score: 0.3}},
+		{{ID: "z", Score: 0.1}, {ID: "w", Score: 0.5}},
+	}
+	merged := mergeSorted(lists, nil)
+	var ids []string
+	for _, r := range merged {
+		ids = append(ids, r.ID)
+	}
+	if expected := []string{"x", "w", "y", "z"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected %v, got %v", expected, ids)
+	}
+}
@@ -0,0 +1,260 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// TermCorrection mirrors the correction a searcher proposes for a query term from its
+// term dictionary.
+type TermCorrection struct {
+	Term                string `json:"term"`
+	Frequency           uint64 `json:"frequency"`                      // Documents containing Term
+	Correction          string `json:"correction,omitempty"`           // Closest known term, if Term is unknown
+	CorrectionFrequency uint64 `json:"correction_frequency,omitempty"` // Documents containing Correction
+	Distance            int    `json:"distance,omitempty"`             // Edit distance between Term and Correction
+}
+
+// Corrector is implemented by searchers that propose corrections for query terms. Searchers
+// that don't implement it are skipped when looking for a did-you-mean query.
+type Corrector interface {
+	Corrections(ctx context.Context, terms []string) ([]TermCorrection, error)
+}
+
+// DidYouMean is the corrected query proposed for a search with few results.
+type DidYouMean struct {
+	Query         string            `json:"query"`                    // The raw query with every correction applied
+	Corrections   map[string]string `json:"corrections"`              // Misspelled term -> correction
+	Applied       bool              `json:"applied"`                  // Whether the results are those of Query
+	OriginalQuery string            `json:"original_query,omitempty"` // The query searched first, if Applied
+}
+
+// SetDidYouMeanThreshold sets the number of hits at or below which a search gets a
+// did-you-mean query. It is 0 by default, so only searches without results get one; a
+// negative threshold disables did-you-mean.
+func (b *Broker) SetDidYouMeanThreshold(maxHits int) {
+	b.didYouMeanMaxHits = maxHits
+}
+
+// didYouMean looks for corrections of the keywords of a search with few results and
+// proposes the corrected query in resp. With opts.AutoCorrect the corrected query is also
+// searched, and its response is returned instead if it has more hits. Corrections share
+// the search's latency budget; failing to get them leaves resp unchanged.
+func (b *Broker) didYouMean(ctx context.Context, rawQuery RawQuery, opts SearchOptions, query StructuredQuery, resp *SearchResponse, start time.Time) (*SearchResponse, StructuredQuery) {
+	terms := correctableTerms(query.Keywords)
+	if len(terms) == 0 {
+		return resp, query
+	}
+	ctx, span := tracer.Start(ctx, "broker.DidYouMean")
+	defer span.End()
+	total := b.budget.Total
+	if opts.Timeout > 0 {
+		total = opts.Timeout
+	}
+	if total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(total))
+		defer cancel()
+	}
+
+	corrections, err := b.corrections(ctx, terms, resp.Tenant, resp.Collection)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to get corrections", "query", rawQuery, "error", err)
+		return resp, query
+	}
+	corrected := applyCorrections(string(rawQuery), corrections)
+	if len(corrections) == 0 || corrected == string(rawQuery) {
+		return resp, query
+	}
+	span.SetAttributes(attribute.String("search.did_you_mean", corrected))
+	resp.DidYouMean = &DidYouMean{Query: corrected, Corrections: corrections}
+	if !opts.AutoCorrect {
+		return resp, query
+	}
+
+	// Shards already streamed their answers to the original query.
+	opts.OnShard = nil
+	retry, retryQuery, err := b.search(ctx, RawQuery(corrected), opts, start)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to search the corrected query", "query", corrected, "error", err)
+		return resp, query
+	}
+	if retry.TotalHits <= resp.TotalHits {
+		return resp, query
+	}
+	retry.DidYouMean = &DidYouMean{Query: corrected, Corrections: corrections, Applied: true, OriginalQuery: string(rawQuery)}
+	return retry, retryQuery
+}
+
+// corrections asks one replica of every shard of collection for corrections of terms and
+// merges them. A term found on any shard is left alone; other terms get the correction
+// with the smallest edit distance, then the highest document frequency summed over the
+// shards. It fails only if no shard answered.
+func (b *Broker) corrections(ctx context.Context, terms []string, tenantID, collection string) (map[string]string, error) {
+	pool, err := b.searcherPool(tenantID, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		distance  int
+		frequency uint64
+	}
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		answered   int
+		known      = make(map[string]bool)
+		candidates = make(map[string]map[string]*candidate) // Term -> correction -> candidate
+	)
+	for shardID, replicas := range pool {
+		wg.Add(1)
+		go func(shardID int, replicas []Searcher) {
+			defer wg.Done()
+			var shardCorrections []TermCorrection
+			ok := b.askShard(ctx, ShardKey{Collection: poolKey(tenantID, collection), ShardID: shardID}, replicas, "searcher.Corrections", isCorrector, func(ctx context.Context, s Searcher) error {
+				var err error
+				shardCorrections, err = s.(Corrector).Corrections(ctx, terms)
+				return err
+			}, func(error, time.Duration) {})
+			if !ok {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			answered++
+			for _, c := range shardCorrections {
+				if c.Frequency > 0 {
+					known[c.Term] = true
+				}
+				if c.Correction == "" {
+					continue
+				}
+				if candidates[c.Term] == nil {
+					candidates[c.Term] = make(map[string]*candidate)
+				}
+				if cand := candidates[c.Term][c.Correction]; cand != nil {
+					cand.frequency += c.CorrectionFrequency
+				} else {
+					candidates[c.Term][c.Correction] = &candidate{distance: c.Distance, frequency: c.CorrectionFrequency}
+				}
+			}
+		}(shardID, replicas)
+	}
+	wg.Wait()
+	if answered == 0 {
+		return nil, fmt.Errorf("no searcher of collection %q returned corrections", collection)
+	}
+
+	corrections := make(map[string]string)
+	for term, byCorrection := range candidates {
+		if known[term] {
+			continue
+		}
+		best, bestCandidate := "", (*candidate)(nil)
+		for correction, cand := range byCorrection {
+			if bestCandidate == nil || cand.distance < bestCandidate.distance ||
+				(cand.distance == bestCandidate.distance && cand.frequency > bestCandidate.frequency) ||
+				(cand.distance == bestCandidate.distance && cand.frequency == bestCandidate.frequency && correction < best) {
+				best, bestCandidate = correction, cand
+			}
+		}
+		corrections[term] = best
+	}
+	return corrections, nil
+}
+
+// isCorrector reports whether s proposes term corrections.
+func isCorrector(s Searcher) bool {
+	_, ok := s.(Corrector)
+	return ok
+}
+
+// maxCorrectedTerms is the largest number of terms searchers are asked to correct.
+const maxCorrectedTerms = 32
+
+// correctableTerms returns the distinct lowercased words of keywords, sorted, keeping the
+// first maxCorrectedTerms.
+func correctableTerms(keywords []string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, keyword := range keywords {
+		for _, word := range strings.FieldsFunc(strings.ToLower(keyword), isNotWordRune) {
+			if !seen[word] {
+				seen[word] = true
+				terms = append(terms, word)
+			}
+		}
+	}
+	if len(terms) > maxCorrectedTerms {
+		terms = terms[:maxCorrectedTerms]
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// applyCorrections replaces the words of query having a correction, matched
+// case-insensitively, keeping everything else (spacing, quotes, operators) as is.
+func applyCorrections(query string, corrections map[string]string) string {
+	var sb strings.Builder
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		if isNotWordRune(runes[i]) {
+			sb.WriteRune(runes[i])
+			i++
+			continue
+		}
+		end := i
+		for end < len(runes) && !isNotWordRune(runes[end]) {
+			end++
+		}
+		word := string(runes[i:end])
+		if correction, ok := corrections[strings.ToLower(word)]; ok {
+			word = correction
+		}
+		sb.WriteString(word)
+		i = end
+	}
+	return sb.String()
+}
+
+// isNotWordRune reports whether r separates the words of a query.
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// correctionsResponse is the body returned by the searcher service's /spell endpoint.
+type correctionsResponse struct {
+	Corrections []TermCorrection `json:"corrections"`
+}
+
+// Corrections asks the remote searcher for corrections of terms from its term dictionary.
+func (s *HTTPSearcher) Corrections(ctx context.Context, terms []string) ([]TermCorrection, error) {
+	params := url.Values{}
+	params.Set("q", strings.Join(terms, " "))
+	params.Set("collection", s.collection)
+	s.setTenant(params)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/spell?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spell request: %w", err)
+	}
+
+	var resp correctionsResponse
+	if err := doJSON(s.client, req, &resp); err != nil {
+		return nil, fmt.Errorf("searcher %s (shard %d) spell request failed: %w", s.baseURL, s.shardID, err)
+	}
+	return resp.Corrections, nil
+}
+
+// Ensure HTTPSearcher proposes corrections.
+var _ Corrector = (*HTTPSearcher)(nil)
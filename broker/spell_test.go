@@ -0,0 +1,158 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mockCorrector is a searcher proposing fixed corrections.
+type mockCorrector struct {
+	MockSearcher
+	corrections map[string]TermCorrection
+	asked       []string
+}
+
+func (m *mockCorrector) Corrections(_ context.Context, terms []string) ([]TermCorrection, error) {
+	m.asked = terms
+	corrections := make([]TermCorrection, len(terms))
+	for i, term := range terms {
+		corrections[i] = TermCorrection{Term: term}
+		if c, ok := m.corrections[term]; ok {
+			corrections[i] = c
+		}
+	}
+	return corrections, nil
+}
+
+// newDidYouMeanTestBroker returns a broker over three shards whose documents only match
+// "red shoes".
+func newDidYouMeanTestBroker() (*Broker, *mockCorrector) {
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, rawQuery RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{Keywords: strings.Fields(strings.ToLower(string(rawQuery)))}, nil
+		},
+	}
+	search := func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+		if strings.Join(query.Keywords, " ") == "red shoes" {
+			return []SearchResult{{ID: "a", Score: 1}, {ID: "b", Score: 0.5}}, nil
+		}
+		return []SearchResult{}, nil
+	}
+	shard0 := &mockCorrector{
+		MockSearcher: MockSearcher{ShardID: 0, SearchFunc: search},
+		corrections: map[string]TermCorrection{
+			"shoos": {Term: "shoos", Correction: "shoes", CorrectionFrequency: 2, Distance: 1},
+			"red":   {Term: "red", Correction: "rod", CorrectionFrequency: 5, Distance: 1},
+		},
+	}
+	shard1 := &mockCorrector{
+		MockSearcher: MockSearcher{ShardID: 1, SearchFunc: search},
+		corrections: map[string]TermCorrection{
+			"shoos": {Term: "shoos", Correction: "shoe", CorrectionFrequency: 3, Distance: 1},
+			"red":   {Term: "red", Frequency: 1},
+		},
+	}
+	shard1Replica := &mockCorrector{MockSearcher: MockSearcher{ShardID: 1, SearchFunc: search}, corrections: shard1.corrections}
+	shard2 := &mockCorrector{MockSearcher: MockSearcher{ShardID: 2, SearchFunc: search}, corrections: shard0.corrections}
+	return NewBroker(mockQU, []Searcher{shard0, shard1, shard1Replica, shard2}), shard0
+}
+
+func TestBroker_Search_DidYouMean(t *testing.T) {
+	b, shard0 := newDidYouMeanTestBroker()
+
+	resp, err := b.SearchWithOptions(context.Background(), "Red SHOOS", SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(shard0.asked, []string{"red", "shoos"}) {
+		t.Errorf("Expected the lowercased keywords to be corrected, got %v", shard0.asked)
+	}
+	// "red" is known to shard 1 so it stays; "shoe" is found on 3 documents of shard 1 but
+	// "shoes" on 2 of both shards 0 and 2, so it wins once frequencies are summed.
+	want := &DidYouMean{Query: "Red shoes", Corrections: map[string]string{"shoos": "shoes"}}
+	if resp.TotalHits != 0 || !reflect.DeepEqual(resp.DidYouMean, want) {
+		t.Errorf("Expected no hits and %+v, got %d hits and %+v", want, resp.TotalHits, resp.DidYouMean)
+	}
+
+	resp, err = b.SearchWithOptions(context.Background(), "Red SHOOS", SearchOptions{AutoCorrect: true})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	want = &DidYouMean{Query: "Red shoes", Corrections: map[string]string{"shoos": "shoes"}, Applied: true, OriginalQuery: "Red SHOOS"}
+	if resp.TotalHits != 2 || !reflect.DeepEqual(resp.DidYouMean, want) {
+		t.Errorf("Expected the results of the corrected query and %+v, got %d hits and %+v", want, resp.TotalHits, resp.DidYouMean)
+	}
+
+	// Searches with enough results get no suggestion.
+	resp, err = b.SearchWithOptions(context.Background(), "red shoes", SearchOptions{})
+	if err != nil || resp.DidYouMean != nil {
+		t.Errorf("Expected no did-you-mean for a search with hits, got %+v (%v)", resp.DidYouMean, err)
+	}
+
+	b.SetDidYouMeanThreshold(-1)
+	resp, err = b.SearchWithOptions(context.Background(), "red shoos", SearchOptions{})
+	if err != nil || resp.DidYouMean != nil {
+		t.Errorf("Expected did-you-mean to be disabled, got %+v (%v)", resp.DidYouMean, err)
+	}
+}
+
+func TestHandler_Search_AutoCorrect(t *testing.T) {
+	b, _ := newDidYouMeanTestBroker()
+	h := NewHandler(b)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=red+shoos&auto_correct=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.DidYouMean == nil || !resp.DidYouMean.Applied || len(resp.Results) != 2 {
+		t.Errorf("Expected the corrected query to be searched, got %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=red&auto_correct=maybe", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid auto_correct parameter, got %d", rec.Code)
+	}
+}
+
+func TestApplyCorrections(t *testing.T) {
+	corrections := map[string]string{"shoos": "shoes", "rde": "red"}
+	got := applyCorrections(`+RDE title:"running shoos" -blue`, corrections)
+	if want := `+red title:"running shoes" -blue`; got != want {
+		t.Errorf("applyCorrections: got %q, want %q", got, want)
+	}
+}
+
+func TestHTTPSearcher_Corrections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/spell" || r.URL.Query().Get("q") != "red shoos" {
+			t.Errorf("Unexpected request: %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"corrections":[{"term":"red","frequency":4},{"term":"shoos","frequency":0,"correction":"shoes","correction_frequency":2,"distance":1}]}`))
+	}))
+	defer server.Close()
+
+	s := NewHTTPSearcher(server.URL, 0)
+	got, err := s.Corrections(context.Background(), []string{"red", "shoos"})
+	if err != nil {
+		t.Fatalf("Corrections returned an error: %v", err)
+	}
+	want := []TermCorrection{
+		{Term: "red", Frequency: 4},
+		{Term: "shoos", Correction: "shoes", CorrectionFrequency: 2, Distance: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Corrections: got %+v, want %+v", got, want)
+	}
+}
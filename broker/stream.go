@@ -0,0 +1,104 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// MediaTypeEventStream is the media type of the server-sent events of /search/stream.
+const MediaTypeEventStream = "text/event-stream"
+
+// ShardUpdate reports the answer of one shard while a search is in progress.
+type ShardUpdate struct {
+	ShardID int            `json:"shard_id"`
+	Results []SearchResult `json:"results"`         // The shard's own results, in its order
+	TookMs  int64          `json:"took_ms"`         // Time taken by the slowest replica tried
+	Error   string         `json:"error,omitempty"` // Set when no replica of the shard answered
+}
+
+// sseWriter writes server-sent events, sending the response header with the first one so
+// errors found before any shard answered still get a proper status.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+// event writes an event named name with v encoded as JSON, and flushes it to the client.
+func (s *sseWriter) event(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Warn("Failed to encode event", "event", name, "error", err)
+		return
+	}
+	if !s.started {
+		h := s.w.Header()
+		h.Set("Content-Type", MediaTypeEventStream)
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no") // Keeps reverse proxies from buffering the stream
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data)
+	s.flusher.Flush()
+}
+
+// HandleSearchStream handles GET /search/stream with the parameters of /search, streaming
+// the search as server-sent events: a "shard" event with a ShardUpdate as each shard
+// answers, then a "done" event with the SearchResponse of the merged results, or an
+// "error" event if the search fails after shards answered. UIs can render the results
+// of fast shards without waiting for the slowest one.
+func (h *Handler) HandleSearchStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	queryParam := r.URL.Query().Get("q")
+	if queryParam == "" {
+		http.Error(w, "Missing 'q' query parameter", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseSearchOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	stream := &sseWriter{w: w, flusher: flusher}
+	opts.OnShard = func(update ShardUpdate) {
+		stream.event("shard", update)
+	}
+	resp, err := h.broker().SearchWithOptions(r.Context(), RawQuery(queryParam), opts)
+	switch {
+	case err != nil && !stream.started:
+		writeSearchError(w, r, err)
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Broker streaming search failed", "error", err)
+		stream.event("error", map[string]string{"error": err.Error()})
+	default:
+		stream.event("done", resp)
+	}
+}
+
+// shardUpdate builds the update of a shard from its status.
+func shardUpdate(status *ShardStatus, results []SearchResult, ok bool) ShardUpdate {
+	update := ShardUpdate{ShardID: status.ShardID, Results: results, TookMs: status.TookMs}
+	if update.Results == nil {
+		update.Results = []SearchResult{}
+	}
+	if !ok {
+		update.Error = strings.Join(status.Errors, "; ")
+		if update.Error == "" {
+			update.Error = "no replica answered"
+		}
+	}
+	return update
+}
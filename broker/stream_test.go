@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readEvents parses a server-sent event stream into its event names and data.
+func readEvents(t *testing.T, body string) (names []string, data []string) {
+	t.Helper()
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			names = append(names, strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	return names, data
+}
+
+func TestHandler_SearchStream(t *testing.T) {
+	h := NewHandler(newTestBroker())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/stream?q=test&size=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != MediaTypeEventStream {
+		t.Errorf("Expected Content-Type %s, got %s", MediaTypeEventStream, ct)
+	}
+
+	names, data := readEvents(t, rec.Body.String())
+	if strings.Join(names, ",") != "shard,shard,done" {
+		t.Fatalf("Expected two shard events and a done event, got %v", names)
+	}
+	updates := map[int]ShardUpdate{}
+	for _, d := range data[:2] {
+		var update ShardUpdate
+		if err := json.Unmarshal([]byte(d), &update); err != nil {
+			t.Fatalf("Failed to decode shard event %s: %v", d, err)
+		}
+		updates[update.ShardID] = update
+	}
+	if len(updates[0].Results) != 3 || updates[0].Error != "" {
+		t.Errorf("Expected the 3 results of shard 0, got %+v", updates[0])
+	}
+	if len(updates[1].Results) != 0 || !strings.Contains(updates[1].Error, "shard down") {
+		t.Errorf("Expected the error of shard 1, got %+v", updates[1])
+	}
+
+	var resp SearchResponse
+	if err := json.Unmarshal([]byte(data[2]), &resp); err != nil {
+		t.Fatalf("Failed to decode done event: %v", err)
+	}
+	if resp.TotalHits != 3 || len(resp.Results) != 2 || resp.Results[0].ID != "b" {
+		t.Errorf("Expected the first page of the merged results, got %+v", resp)
+	}
+}
+
+func TestHandler_SearchStream_Error(t *testing.T) {
+	h := NewHandler(newTestBroker())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/stream?q=test&collection=missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown collection before streaming, got %d", rec.Code)
+	}
+}
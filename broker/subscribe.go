@@ -0,0 +1,276 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"common/commitbus"
+
+	"golang.org/x/net/websocket"
+)
+
+// maxSubscriptions bounds the live queries the broker keeps open at once.
+const maxSubscriptions = 1000
+
+// Types of the messages sent to live-query subscribers.
+const (
+	UpdateSnapshot = "snapshot" // The results when the subscription starts
+	UpdateChanges  = "update"   // The changes of the results once a new segment is served
+	UpdateError    = "error"    // The standing query failed; the subscription ends
+)
+
+// SubscriptionUpdate is a message sent to a live-query subscriber.
+type SubscriptionUpdate struct {
+	Type    string         `json:"type"`
+	Segment string         `json:"segment,omitempty"` // Segment whose load changed the results
+	Results []SearchResult `json:"results,omitempty"` // Current results, in snapshots
+	Added   []SearchResult `json:"added,omitempty"`   // Results that entered the page
+	Changed []SearchResult `json:"changed,omitempty"` // Results whose score or fields changed
+	Removed []string       `json:"removed,omitempty"` // IDs of the results that left the page
+	Error   string         `json:"error,omitempty"`
+}
+
+// empty reports whether an update carries no change.
+func (u SubscriptionUpdate) empty() bool {
+	return len(u.Added) == 0 && len(u.Changed) == 0 && len(u.Removed) == 0
+}
+
+// subscription is a standing query of a subscriber.
+type subscription struct {
+	query RawQuery
+	opts  SearchOptions
+	key   string      // Pool key of the collection the query runs against
+	loads chan string // Segments loaded since the last rerun; full coalesces loads
+	last  map[string]SearchResult
+}
+
+// subscriptionHub holds the live queries and reruns them when a searcher loads a segment.
+type subscriptionHub struct {
+	broker func() *Broker // Returns the broker serving requests
+
+	mu   sync.Mutex
+	subs map[*subscription]struct{}
+}
+
+func newSubscriptionHub(broker func() *Broker) *subscriptionHub {
+	return &subscriptionHub{broker: broker, subs: make(map[*subscription]struct{})}
+}
+
+// add registers sub, failing when the broker holds maxSubscriptions already.
+func (h *subscriptionHub) add(sub *subscription) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) >= maxSubscriptions {
+		return fmt.Errorf("too many live queries, at most %d are served", maxSubscriptions)
+	}
+	h.subs[sub] = struct{}{}
+	return nil
+}
+
+func (h *subscriptionHub) remove(sub *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// notify wakes up the subscriptions to the collection a searcher loaded a segment of. The
+// searchers announce their loads rather than the indexers their commits, which searchers
+// download later, so reruns see the new segment.
+func (h *subscriptionHub) notify(event commitbus.Event) {
+	collection := event.Collection
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	key := poolKey(event.Tenant, collection)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	woken := 0
+	for sub := range h.subs {
+		if sub.key != key {
+			continue
+		}
+		select {
+		case sub.loads <- event.Segment:
+		default: // A rerun is already pending
+		}
+		woken++
+	}
+	slog.Info("Segment loaded, rerunning live queries", "segment", event.Segment, "collection", key, "live_queries", woken)
+}
+
+// run sends the results of sub to conn, then their changes after every segment loaded,
+// until the subscriber disconnects or ctx is done.
+func (h *subscriptionHub) run(ctx context.Context, conn *websocket.Conn, sub *subscription) {
+	resp, _, err := h.broker().search(ctx, sub.query, sub.opts, time.Now())
+	if err != nil {
+		websocket.JSON.Send(conn, SubscriptionUpdate{Type: UpdateError, Error: err.Error()})
+		return
+	}
+	sub.last = resultsByID(resp.Results)
+	if err := websocket.JSON.Send(conn, SubscriptionUpdate{Type: UpdateSnapshot, Results: resp.Results}); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case segment := <-sub.loads:
+			resp, _, err := h.broker().search(ctx, sub.query, sub.opts, time.Now())
+			if err != nil {
+				// Searchers may be swapping segments; the next load retries.
+				slog.WarnContext(ctx, "Failed to rerun live query after load", "query", sub.query, "segment", segment, "error", err)
+				continue
+			}
+			if stale(resp, segment) {
+				// A replica that hasn't loaded the segment yet answered; its own load
+				// reruns the query, rather than reporting results about to change back.
+				continue
+			}
+			update := diffResults(sub.last, resp.Results)
+			if update.empty() {
+				continue
+			}
+			update.Segment = segment
+			sub.last = resultsByID(resp.Results)
+			if err := websocket.JSON.Send(conn, update); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// stale reports whether every shard of resp reports searching a segment other than
+// segment. Shards that don't report theirs can't be told apart, so they aren't stale.
+func stale(resp *SearchResponse, segment string) bool {
+	for _, shard := range resp.Shards.Details {
+		if shard.Segment == "" || shard.Segment == segment {
+			return false
+		}
+	}
+	return len(resp.Shards.Details) > 0
+}
+
+// resultsByID indexes results by ID.
+func resultsByID(results []SearchResult) map[string]SearchResult {
+	byID := make(map[string]SearchResult, len(results))
+	for _, r := range results {
+		byID[r.ID] = r
+	}
+	return byID
+}
+
+// diffResults returns the changes between the previous results, by ID, and the current ones.
+func diffResults(previous map[string]SearchResult, current []SearchResult) SubscriptionUpdate {
+	update := SubscriptionUpdate{Type: UpdateChanges}
+	seen := make(map[string]bool, len(current))
+	for _, r := range current {
+		seen[r.ID] = true
+		old, ok := previous[r.ID]
+		switch {
+		case !ok:
+			update.Added = append(update.Added, r)
+		case !reflect.DeepEqual(old, r):
+			update.Changed = append(update.Changed, r)
+		}
+	}
+	for id := range previous {
+		if !seen[id] {
+			update.Removed = append(update.Removed, id)
+		}
+	}
+	return update
+}
+
+// HandleSubscribe handles GET /subscribe with the parameters of /search, upgraded to a
+// WebSocket. The subscriber first receives a "snapshot" message with the requested page
+// of results, then an "update" message with the added, changed and removed results
+// whenever a segment the searchers load changes them. Messages sent by the subscriber are
+// ignored; closing the connection ends the subscription. Browsers may only subscribe from
+// pages of the broker's host or of an origin allowed by SetSubscribeOrigins.
+func (h *Handler) HandleSubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	queryParam := r.URL.Query().Get("q")
+	if queryParam == "" {
+		http.Error(w, "Missing 'q' query parameter", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseSearchOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Collection == "" {
+		opts.Collection = DefaultCollection
+	}
+	if _, err := h.broker().searcherPool(opts.Tenant, opts.Collection); err != nil {
+		writeSearchError(w, r, err)
+		return
+	}
+	if err := h.broker().tenantLimiter.Allow(opts.Tenant); err != nil {
+		writeSearchError(w, r, err)
+		return
+	}
+	sub := &subscription{
+		query: RawQuery(queryParam),
+		opts:  opts,
+		key:   poolKey(opts.Tenant, opts.Collection),
+		loads: make(chan string, 1),
+	}
+	if err := h.subscriptions.add(sub); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer h.subscriptions.remove(sub)
+
+	server := websocket.Server{
+		Handshake: h.checkOrigin,
+		Handler: func(conn *websocket.Conn) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// Reading detects the subscriber closing the connection.
+			go func() {
+				defer cancel()
+				var discard string
+				for websocket.Message.Receive(conn, &discard) == nil {
+				}
+			}()
+			h.subscriptions.run(ctx, conn, sub)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// SetSubscribeOrigins allows the pages of origins, e.g. "https://shop.example.com", to
+// open live queries besides those of the broker's host.
+func (h *Handler) SetSubscribeOrigins(origins []string) {
+	h.origins = origins
+}
+
+// checkOrigin accepts the WebSocket handshakes of subscribers that aren't browsers, which
+// send no Origin, and of pages of the broker's host or of an allowed origin, so that other
+// sites can't open live queries on behalf of their visitors.
+func (h *Handler) checkOrigin(_ *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range h.origins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s may not subscribe", origin)
+}
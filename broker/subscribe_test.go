@@ -0,0 +1,157 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"common/commitbus"
+
+	"golang.org/x/net/websocket"
+)
+
+// servingSearcher is a MockSearcher reporting the segment it serves, which changes as it
+// loads new ones.
+type servingSearcher struct {
+	*MockSearcher
+	mu      sync.Mutex
+	segment string
+}
+
+func (s *servingSearcher) SearchSegment(ctx context.Context, query StructuredQuery) ([]SearchResult, string, error) {
+	results, err := s.Search(ctx, query)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return results, s.segment, err
+}
+
+func (s *servingSearcher) load(segment string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.segment = segment
+}
+
+func TestHandler_Subscribe(t *testing.T) {
+	var mu sync.Mutex
+	results := []SearchResult{{ID: "a", Score: 0.5}, {ID: "b", Score: 0.9}}
+	mockQU := &MockQueryUnderstandingService{
+		ProcessFunc: func(_ context.Context, _ RawQuery) (StructuredQuery, error) {
+			return StructuredQuery{}, nil
+		},
+	}
+	shard := &servingSearcher{segment: "segment-1"}
+	shard.MockSearcher = &MockSearcher{
+		SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]SearchResult(nil), results...), nil
+		},
+	}
+	h := NewHandler(NewBroker(mockQU, []Searcher{shard}))
+	h.SetCommitToken("secret")
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/subscribe?q=test"
+	conn, err := websocket.Dial(wsURL, "", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var snapshot SubscriptionUpdate
+	if err := websocket.JSON.Receive(conn, &snapshot); err != nil {
+		t.Fatalf("Failed to receive the snapshot: %v", err)
+	}
+	if snapshot.Type != UpdateSnapshot || len(snapshot.Results) != 2 || snapshot.Results[0].ID != "b" {
+		t.Fatalf("Expected a snapshot of b and a, got %+v", snapshot)
+	}
+
+	load := func(token string, event commitbus.Event) int {
+		t.Helper()
+		body, _ := json.Marshal(event)
+		req, _ := http.NewRequest(http.MethodPost, server.URL+commitbus.LoadPath, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to post load: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := load("wrong", commitbus.Event{Collection: DefaultCollection, Segment: "segment-2"}); status != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 for an unauthenticated load, got %d", status)
+	}
+	// Loads of other collections don't rerun the query, and reruns answered by a replica
+	// that hasn't loaded the segment yet don't report its results.
+	mu.Lock()
+	results = []SearchResult{{ID: "z", Score: 1}}
+	mu.Unlock()
+	for _, event := range []commitbus.Event{
+		{Collection: "other", Segment: "other-1"},
+		{Collection: DefaultCollection, Segment: "segment-2"},
+	} {
+		if status := load("secret", event); status != http.StatusAccepted {
+			t.Fatalf("Expected status 202 for load, got %d", status)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	results = []SearchResult{{ID: "b", Score: 0.95}, {ID: "c", Score: 0.7}}
+	mu.Unlock()
+	shard.load("segment-2")
+	load("secret", commitbus.Event{Collection: DefaultCollection, Segment: "segment-2"})
+
+	var update SubscriptionUpdate
+	if err := websocket.JSON.Receive(conn, &update); err != nil {
+		t.Fatalf("Failed to receive the update: %v", err)
+	}
+	if update.Type != UpdateChanges || update.Segment != "segment-2" {
+		t.Fatalf("Expected an update for segment-2, got %+v", update)
+	}
+	if len(update.Added) != 1 || update.Added[0].ID != "c" {
+		t.Errorf("Expected c to be added, got %+v", update.Added)
+	}
+	if len(update.Changed) != 1 || update.Changed[0].ID != "b" || update.Changed[0].Score != 0.95 {
+		t.Errorf("Expected the new score of b, got %+v", update.Changed)
+	}
+	if len(update.Removed) != 1 || update.Removed[0] != "a" {
+		t.Errorf("Expected a to be removed, got %v", update.Removed)
+	}
+}
+
+func TestHandler_Subscribe_Rejected(t *testing.T) {
+	h := NewHandler(newTestBroker())
+	for target, want := range map[string]int{
+		"/subscribe":                           http.StatusBadRequest,
+		"/subscribe?q=test&collection=missing": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected status %d before upgrading, got %d", target, want, rec.Code)
+		}
+	}
+
+	// Pages of other sites may not subscribe.
+	server := httptest.NewServer(h)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/subscribe?q=test"
+	if conn, err := websocket.Dial(wsURL, "", "https://evil.example.com"); err == nil {
+		conn.Close()
+		t.Error("Expected a subscription from another origin to be refused")
+	}
+	h.SetSubscribeOrigins([]string{"https://shop.example.com"})
+	conn, err := websocket.Dial(wsURL, "", "https://shop.example.com")
+	if err != nil {
+		t.Fatalf("Expected an allowed origin to subscribe, got %v", err)
+	}
+	conn.Close()
+}
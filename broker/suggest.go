@@ -0,0 +1,251 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"common/suggest"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Suggester is implemented by searchers that serve completions for a prefix. Searchers
+// that don't implement it are skipped by Broker.Suggest.
+type Suggester interface {
+	Suggest(ctx context.Context, prefix string, size int) ([]suggest.Suggestion, error)
+}
+
+// SuggestResponse is returned by the broker's suggest API.
+type SuggestResponse struct {
+	Query       string               `json:"query"`
+	Collection  string               `json:"collection"`
+	TookMs      int64                `json:"took_ms"`
+	Shards      ShardsSummary        `json:"shards"`
+	Suggestions []suggest.Suggestion `json:"suggestions"`
+}
+
+// Suggest returns up to size completions of prefix from every shard of the collection of
+// tenantID (empty means DefaultCollection), by descending score. Each shard is asked for size
+// completions from one replica, failing over like searches do. A completion offered by
+// several shards keeps its highest score: titles are counted per shard, while popular
+// queries are usually shared by all of them.
+func (b *Broker) Suggest(ctx context.Context, prefix, tenantID, collection string, size int) (*SuggestResponse, error) {
+	start := time.Now()
+	if err := b.tenantLimiter.Allow(tenantID); err != nil {
+		return nil, err
+	}
+	ctx, span := tracer.Start(ctx, "broker.Suggest")
+	defer span.End()
+
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	pool, err := b.searcherPool(tenantID, collection)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("search.collection", collection))
+	if b.budget.Total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.budget.Total)
+		defer cancel()
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		shardIDs = make([]int, 0, len(pool))
+		statuses = make(map[int]*ShardStatus, len(pool))
+		best     = make(map[string]suggest.Suggestion)
+	)
+	for shardID := range pool {
+		shardIDs = append(shardIDs, shardID)
+		statuses[shardID] = &ShardStatus{ShardID: shardID}
+	}
+	for _, shardID := range shardIDs {
+		wg.Add(1)
+		go func(shardID int, replicas []Searcher) {
+			defer wg.Done()
+			var suggestions []suggest.Suggestion
+			ok := b.askShard(ctx, ShardKey{Collection: poolKey(tenantID, collection), ShardID: shardID}, replicas, "searcher.Suggest", isSuggester, func(ctx context.Context, s Searcher) error {
+				var err error
+				suggestions, err = s.(Suggester).Suggest(ctx, prefix, size)
+				return err
+			}, func(err error, took time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				statuses[shardID].recordAttempt(err, took)
+			})
+			if !ok {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			status := statuses[shardID]
+			status.Successful++
+			status.Hits += len(suggestions)
+			for _, s := range suggestions {
+				key := suggest.Normalize(s.Text)
+				if prev, seen := best[key]; !seen || s.Score > prev.Score {
+					best[key] = s
+				}
+			}
+		}(shardID, pool[shardID])
+	}
+	wg.Wait()
+
+	merged := make([]suggest.Suggestion, 0, len(best))
+	for _, s := range best {
+		merged = append(merged, s)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Score != merged[j].Score {
+			return merged[i].Score > merged[j].Score
+		}
+		return merged[i].Text < merged[j].Text
+	})
+	if len(merged) > size {
+		merged = merged[:size]
+	}
+	return &SuggestResponse{
+		Query:       prefix,
+		Collection:  collection,
+		TookMs:      time.Since(start).Milliseconds(),
+		Shards:      summarizeShards(shardIDs, statuses),
+		Suggestions: merged,
+	}, nil
+}
+
+// askShard calls the replicas of a shard supporting an operation in the order chosen by
+// the replica selector until one succeeds, skipping replicas whose circuit breaker is
+// open. Each attempt is traced as a span named op. record is called after every attempt;
+// attempts failing once ctx is done are not recorded into the breakers. It returns false
+// if no replica answered.
+func (b *Broker) askShard(ctx context.Context, shard ShardKey, replicas []Searcher, op string, supports func(Searcher) bool, call func(ctx context.Context, s Searcher) error, record func(err error, took time.Duration)) bool {
+	attempts := 0
+	for _, replica := range b.replicas.Order(shard, len(replicas)) {
+		if !supports(replicas[replica]) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			if attempts == 0 {
+				record(fmt.Errorf("shard %d: %w", shard.ShardID, err), 0)
+			}
+			return false
+		}
+		key := replicaKey{shard, replica}
+		if !b.breakers.Allow(key) {
+			continue
+		}
+		attempts++
+		shardCtx, shardSpan := tracer.Start(ctx, op, traceShardAttributes(shard.ShardID),
+			trace.WithAttributes(attribute.Int("search.replica", replica)))
+		callStart := time.Now()
+		err := call(shardCtx, replicas[replica])
+		took := time.Since(callStart)
+		if err != nil && ctx.Err() != nil {
+			// Canceled by the client or past its deadline: the replica is not to blame.
+			record(err, took)
+			shardSpan.SetStatus(codes.Error, "canceled")
+			shardSpan.End()
+			return false
+		}
+		b.breakers.Record(key, took, err)
+		record(err, took)
+		if err != nil {
+			shardSpan.RecordError(err)
+			shardSpan.SetStatus(codes.Error, err.Error())
+			shardSpan.End()
+			slog.WarnContext(ctx, "Replica failed, failing over", "operation", op, "collection", shard.Collection, "shard", shard.ShardID, "replica", replica, "error", err)
+			continue
+		}
+		shardSpan.End()
+		return true
+	}
+	return false
+}
+
+// pollShard calls the replicas of a shard supporting an operation like askShard, for
+// background calls such as gathering summaries of the shard: their outcome and latency
+// are not recorded into the circuit breakers, and replicas whose breaker isn't closed are
+// skipped. It returns false if no replica answered.
+func (b *Broker) pollShard(ctx context.Context, shard ShardKey, replicas []Searcher, op string, supports func(Searcher) bool, call func(ctx context.Context, s Searcher) error) bool {
+	for _, replica := range b.replicas.Order(shard, len(replicas)) {
+		if !supports(replicas[replica]) || !b.breakers.Closed(replicaKey{shard, replica}) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		shardCtx, shardSpan := tracer.Start(ctx, op, traceShardAttributes(shard.ShardID),
+			trace.WithAttributes(attribute.Int("search.replica", replica)))
+		err := call(shardCtx, replicas[replica])
+		if err != nil {
+			shardSpan.RecordError(err)
+			shardSpan.SetStatus(codes.Error, err.Error())
+			shardSpan.End()
+			if ctx.Err() != nil {
+				return false
+			}
+			slog.WarnContext(ctx, "Replica failed, failing over", "operation", op, "collection", shard.Collection, "shard", shard.ShardID, "replica", replica, "error", err)
+			continue
+		}
+		shardSpan.End()
+		return true
+	}
+	return false
+}
+
+// recordAttempt counts a call to one of the shard's searchers that took took.
+func (status *ShardStatus) recordAttempt(err error, took time.Duration) {
+	status.Searchers++
+	if tookMs := took.Milliseconds(); tookMs > status.TookMs {
+		status.TookMs = tookMs
+	}
+	if err != nil {
+		status.Failed++
+		status.Errors = append(status.Errors, err.Error())
+	}
+}
+
+// isSuggester reports whether s serves completions.
+func isSuggester(s Searcher) bool {
+	_, ok := s.(Suggester)
+	return ok
+}
+
+// suggestResponse is the body returned by the searcher service's /suggest endpoint.
+type suggestResponse struct {
+	Suggestions []suggest.Suggestion `json:"suggestions"`
+}
+
+// Suggest asks the remote searcher for up to size completions of prefix.
+func (s *HTTPSearcher) Suggest(ctx context.Context, prefix string, size int) ([]suggest.Suggestion, error) {
+	params := url.Values{}
+	params.Set("q", prefix)
+	params.Set("collection", s.collection)
+	s.setTenant(params)
+	params.Set("size", strconv.Itoa(size))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/suggest?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create suggest request: %w", err)
+	}
+
+	var resp suggestResponse
+	if err := doJSON(s.client, req, &resp); err != nil {
+		return nil, fmt.Errorf("searcher %s (shard %d) suggest request failed: %w", s.baseURL, s.shardID, err)
+	}
+	return resp.Suggestions, nil
+}
+
+// Ensure HTTPSearcher serves completions.
+var _ Suggester = (*HTTPSearcher)(nil)
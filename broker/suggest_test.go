@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"common/suggest"
+)
+
+// mockSuggester is a searcher serving fixed completions.
+type mockSuggester struct {
+	MockSearcher
+	suggestions []suggest.Suggestion
+	err         error
+}
+
+func (m *mockSuggester) Suggest(_ context.Context, _ string, size int) ([]suggest.Suggestion, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if len(m.suggestions) > size {
+		return m.suggestions[:size], nil
+	}
+	return m.suggestions, nil
+}
+
+func TestBroker_Suggest_MergesShards(t *testing.T) {
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{
+		&mockSuggester{MockSearcher: MockSearcher{ShardID: 0}, suggestions: []suggest.Suggestion{{Text: "red shoes", Score: 4}, {Text: "rain jacket", Score: 2}}},
+		&mockSuggester{MockSearcher: MockSearcher{ShardID: 1}, err: errors.New("down")},
+		&mockSuggester{MockSearcher: MockSearcher{ShardID: 1}, suggestions: []suggest.Suggestion{{Text: "Red Shoes", Score: 3}, {Text: "running", Score: 3}}},
+		&MockSearcher{ShardID: 2}, // Doesn't serve completions
+	})
+
+	resp, err := b.Suggest(context.Background(), "r", "", "", 10)
+	if err != nil {
+		t.Fatalf("Suggest returned an error: %v", err)
+	}
+	want := []suggest.Suggestion{{Text: "red shoes", Score: 4}, {Text: "running", Score: 3}, {Text: "rain jacket", Score: 2}}
+	if !reflect.DeepEqual(resp.Suggestions, want) {
+		t.Errorf("Unexpected suggestions: got %+v, want %+v", resp.Suggestions, want)
+	}
+	if resp.Shards.Total != 3 || resp.Shards.Successful != 2 || resp.Shards.Failed != 1 {
+		t.Errorf("Unexpected shard summary: %+v", resp.Shards)
+	}
+
+	resp, err = b.Suggest(context.Background(), "r", "", "", 1)
+	if err != nil || len(resp.Suggestions) != 1 || resp.Suggestions[0].Text != "red shoes" {
+		t.Errorf("Expected only the top completion, got %+v (%v)", resp, err)
+	}
+	if _, err := b.Suggest(context.Background(), "r", "", "missing", 10); !errors.Is(err, ErrUnknownCollection) {
+		t.Errorf("Expected ErrUnknownCollection, got %v", err)
+	}
+}
+
+func TestHandler_Suggest(t *testing.T) {
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{
+		&mockSuggester{MockSearcher: MockSearcher{ShardID: 0}, suggestions: []suggest.Suggestion{{Text: "red shoes", Score: 1}}},
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/suggest?q=re&size=5", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp SuggestResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Query != "re" || len(resp.Suggestions) != 1 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	for query, status := range map[string]int{
+		"/suggest":                       http.StatusBadRequest,
+		"/suggest?q=re&size=0":           http.StatusBadRequest,
+		"/suggest?q=re&collection=other": http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, query, nil))
+		if rec.Code != status {
+			t.Errorf("Expected status %d for %s, got %d", status, query, rec.Code)
+		}
+	}
+}
+
+func TestHTTPSearcher_Suggest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/suggest" || r.URL.Query().Get("q") != "re" || r.URL.Query().Get("size") != "3" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"query":"re","suggestions":[{"text":"red shoes","score":2}]}`))
+	}))
+	defer server.Close()
+
+	got, err := NewHTTPSearcher(server.URL, 0).Suggest(context.Background(), "re", 3)
+	if err != nil {
+		t.Fatalf("Suggest returned an error: %v", err)
+	}
+	if len(got) != 1 || got[0] != (suggest.Suggestion{Text: "red shoes", Score: 2}) {
+		t.Errorf("Unexpected suggestions: %+v", got)
+	}
+}
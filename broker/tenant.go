@@ -0,0 +1,38 @@
+package broker
+
+import (
+	"common/tenant"
+)
+
+// TenantSearcher is implemented by searchers that serve a collection of a tenant other
+// than the default one. Searchers that don't implement it belong to tenant.Default.
+type TenantSearcher interface {
+	Searcher
+	GetTenant() string
+}
+
+// tenantOf returns the tenant served by s.
+func tenantOf(s Searcher) string {
+	if ts, ok := s.(TenantSearcher); ok {
+		return ts.GetTenant()
+	}
+	return tenant.Default
+}
+
+// poolKey returns the key of the searcher pool serving collection for tenantID:
+// "tenant/collection", or just the collection for the default tenant. Keys are
+// unambiguous since neither tenant IDs nor collection names contain slashes. Pool keys
+// also name the collection in shard keys, so breakers and replica statistics are kept
+// per tenant.
+func poolKey(tenantID, collection string) string {
+	if tenantID == tenant.Default {
+		return collection
+	}
+	return tenantID + "/" + collection
+}
+
+// SetTenantLimiter enforces per-tenant quotas on searches and suggestions; requests over
+// quota fail with an error wrapping tenant.ErrQuotaExceeded. nil disables quotas.
+func (b *Broker) SetTenantLimiter(limiter *tenant.Limiter) {
+	b.tenantLimiter = limiter
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"common/tenant"
+)
+
+// MockTenantSearcher is a MockCollectionSearcher that serves a collection of a tenant.
+type MockTenantSearcher struct {
+	MockCollectionSearcher
+	Tenant string
+}
+
+func (m *MockTenantSearcher) GetTenant() string {
+	return m.Tenant
+}
+
+func docSearcher(id string) MockSearcher {
+	return MockSearcher{SearchFunc: func(_ context.Context, _ StructuredQuery) ([]SearchResult, error) {
+		return []SearchResult{{ID: id}}, nil
+	}}
+}
+
+func TestBroker_Search_RoutesByTenant(t *testing.T) {
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{
+		&MockCollectionSearcher{Collection: "products", MockSearcher: docSearcher("default_doc")},
+		&MockTenantSearcher{Tenant: "shop", MockCollectionSearcher: MockCollectionSearcher{Collection: "products", MockSearcher: docSearcher("shop_doc")}},
+	})
+	if got, want := b.Collections(), []string{DefaultCollection, "products", "shop/products"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected collections: got %v, want %v", got, want)
+	}
+	handler := NewHandler(b)
+
+	search := func(target, tenantID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if tenantID != "" {
+			req.Header.Set(tenant.Header, tenantID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := search("/search?q=shoes&collection=products", "shop")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp SearchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if got := resultIDs(resp.Results); !reflect.DeepEqual(got, []string{"shop_doc"}) || resp.Tenant != "shop" {
+		t.Errorf("Expected only the tenant's collection to be searched, got %+v", resp)
+	}
+
+	// Tenants only reach their own collections.
+	if rec := search("/search?q=shoes", "shop"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for the default collection of a tenant without one, got %d", rec.Code)
+	}
+	if rec := search("/search?q=shoes&collection=products", "blog"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown tenant, got %d", rec.Code)
+	}
+	if rec := search("/search?q=shoes&tenant=Not+Valid", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid tenant, got %d", rec.Code)
+	}
+	if rec := search("/search?q=shoes&collection=products", ""); rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+		t.Errorf("Expected the default tenant to be served, got %d", rec.Code)
+	}
+}
+
+func TestBroker_TenantQuota(t *testing.T) {
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{&MockSearcher{ShardID: 0}})
+	limiter, err := tenant.NewLimiter(tenant.QuotaConfig{Tenants: map[string]tenant.Quota{"": {RequestsPerSecond: 0.001, Burst: 1}}})
+	if err != nil {
+		t.Fatalf("NewLimiter returned an error: %v", err)
+	}
+	b.SetTenantLimiter(limiter)
+
+	if _, err := b.SearchWithOptions(context.Background(), "shoes", SearchOptions{}); err != nil {
+		t.Fatalf("Expected the first search to be allowed, got %v", err)
+	}
+	if _, err := b.Suggest(context.Background(), "sh", "", "", 5); !errors.Is(err, tenant.ErrQuotaExceeded) {
+		t.Errorf("Expected suggestions to share the search quota, got %v", err)
+	}
+	rec := httptest.NewRecorder()
+	NewHandler(b).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoes", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", rec.Code)
+	}
+}
+
+func TestHTTPSearcher_SendsTenant(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get(tenant.Param)
+		w.Write([]byte(`{"results":[],"total_hits":0}`))
+	}))
+	defer server.Close()
+
+	s := NewCollectionHTTPSearcher("products", server.URL, 0)
+	if err := s.SetTenant("../x"); err == nil {
+		t.Error("Expected an error for an invalid tenant, got nil")
+	}
+	if err := s.SetTenant("shop"); err != nil {
+		t.Fatalf("SetTenant returned an error: %v", err)
+	}
+	if _, err := s.Search(context.Background(), StructuredQuery{Keywords: []string{"shoes"}}); err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+	if got != "shop" {
+		t.Errorf("Expected the tenant to be sent to the searcher, got %q", got)
+	}
+}
@@ -0,0 +1,196 @@
+package broker
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"common/tenant"
+)
+
+// Health of a collection in its topology, by the circuit breakers of its searchers.
+const (
+	// HealthGreen collections have every replica in routing.
+	HealthGreen = "green"
+	// HealthYellow collections have a replica of every shard in routing, but not all.
+	HealthYellow = "yellow"
+	// HealthRed collections have a shard without any replica in routing; their searches
+	// miss its results.
+	HealthRed = "red"
+)
+
+// Topology describes the searchers the broker routes searches to, as reported by
+// GET /admin/topology.
+type Topology struct {
+	GeneratedAt time.Time            `json:"generated_at"`
+	Collections []CollectionTopology `json:"collections"`
+}
+
+// CollectionTopology describes the shards of a collection, the last index version announced
+// for it and the segment each replica serves.
+type CollectionTopology struct {
+	Name       string `json:"name"` // Pool key: the collection, prefixed with "<tenant>/" for other tenants
+	Tenant     string `json:"tenant,omitempty"`
+	Collection string `json:"collection"`
+	Health     string `json:"health"`
+	// IndexVersion is the last commit announced by the indexer of the collection; nil
+	// until one is announced. Replicas lag behind it until they load it: see their Segment.
+	IndexVersion *IndexVersion   `json:"index_version,omitempty"`
+	Shards       []ShardTopology `json:"shards"`
+}
+
+// IndexVersion identifies the segment last committed to a collection.
+type IndexVersion struct {
+	Segment     string    `json:"segment"`
+	DocCount    uint64    `json:"doc_count"`
+	CommittedAt time.Time `json:"committed_at"`
+}
+
+// ShardTopology describes the replicas of a shard.
+type ShardTopology struct {
+	ShardID         int               `json:"shard_id"`
+	HealthyReplicas int               `json:"healthy_replicas"` // Replicas whose breaker is closed
+	Replicas        []ReplicaTopology `json:"replicas"`
+}
+
+// ReplicaTopology describes a searcher serving a shard and the state of its circuit breaker.
+type ReplicaTopology struct {
+	Replica  int    `json:"replica"`
+	Searcher string `json:"searcher"`
+	Endpoint string `json:"endpoint,omitempty"` // Base URL of HTTP searchers
+	// Healthy replicas are routed to normally; the others are out of routing or probed.
+	Healthy bool          `json:"healthy"`
+	Breaker BreakerStatus `json:"breaker"`
+	// Segment is the one the replica last answered a search from; empty until it answers
+	// one, or if it doesn't report its segment.
+	Segment string `json:"segment,omitempty"`
+}
+
+// replicaSegments records the segment every replica last answered a search from.
+type replicaSegments struct {
+	mu       sync.RWMutex
+	segments map[replicaKey]servedSegment
+}
+
+// servedSegment is a segment a searcher answered a search from.
+type servedSegment struct {
+	searcher string // Description of the searcher, telling replicas of a reload apart
+	segment  string
+}
+
+func newReplicaSegments() *replicaSegments {
+	return &replicaSegments{segments: make(map[replicaKey]servedSegment)}
+}
+
+// served records that searcher, the replica with key, answered a search from segment.
+func (r *replicaSegments) served(key replicaKey, searcher Searcher, segment string) {
+	if segment == "" {
+		return
+	}
+	served := servedSegment{searcher: describeSearcher(searcher), segment: segment}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.segments[key] = served
+}
+
+// segment returns the segment searcher, the replica with key, last answered a search
+// from, empty if unknown.
+func (r *replicaSegments) segment(key replicaKey, searcher Searcher) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	served, ok := r.segments[key]
+	if !ok || served.searcher != describeSearcher(searcher) {
+		return ""
+	}
+	return served.segment
+}
+
+// inherit carries the segments prev recorded over to r.
+func (r *replicaSegments) inherit(prev *replicaSegments) {
+	prev.mu.RLock()
+	defer prev.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, served := range prev.segments {
+		r.segments[key] = served
+	}
+}
+
+// topology returns the shard map of every collection with searchers, with the breaker
+// state of their replicas, the segments they serve and the index versions of versions.
+func (b *Broker) topology(versions *indexVersions) Topology {
+	breakers := make(map[replicaKey]BreakerStatus)
+	for _, status := range b.BreakerStatuses() {
+		breakers[replicaKey{ShardKey{status.Collection, status.ShardID}, status.Replica}] = status
+	}
+	topology := Topology{GeneratedAt: time.Now().UTC(), Collections: []CollectionTopology{}}
+	for _, name := range b.Collections() {
+		pool := b.collections[name]
+		if len(pool) == 0 {
+			continue
+		}
+		c := CollectionTopology{Name: name, Collection: name, Health: HealthGreen}
+		if tenantID, collection, ok := strings.Cut(name, "/"); ok {
+			c.Tenant, c.Collection = tenantID, collection
+		}
+		if event, ok := versions.last(name); ok {
+			c.IndexVersion = &IndexVersion{Segment: event.Segment, DocCount: event.DocCount, CommittedAt: event.CommittedAt}
+		}
+		shardIDs := make([]int, 0, len(pool))
+		for shardID := range pool {
+			shardIDs = append(shardIDs, shardID)
+		}
+		sort.Ints(shardIDs)
+		for _, shardID := range shardIDs {
+			shard := ShardTopology{ShardID: shardID, Replicas: make([]ReplicaTopology, 0, len(pool[shardID]))}
+			for i, s := range pool[shardID] {
+				key := replicaKey{ShardKey{name, shardID}, i}
+				replica := ReplicaTopology{Replica: i, Searcher: describeSearcher(s), Breaker: breakers[key], Segment: b.segments.segment(key, s)}
+				if hs, ok := s.(*HTTPSearcher); ok {
+					replica.Endpoint = hs.baseURL
+				}
+				// Searchers without a breaker are always routed to.
+				replica.Healthy = replica.Breaker.State == "" || replica.Breaker.State == BreakerClosed
+				if replica.Healthy {
+					shard.HealthyReplicas++
+				}
+				shard.Replicas = append(shard.Replicas, replica)
+			}
+			switch {
+			case shard.HealthyReplicas == 0:
+				c.Health = HealthRed
+			case shard.HealthyReplicas < len(shard.Replicas) && c.Health == HealthGreen:
+				c.Health = HealthYellow
+			}
+			c.Shards = append(c.Shards, shard)
+		}
+		topology.Collections = append(topology.Collections, c)
+	}
+	return topology
+}
+
+// HandleTopology handles GET /admin/topology, returning the shard map of every collection:
+// the searchers of each shard, their endpoints, health, circuit breaker states and the
+// segments they serve, and the index version announced by the indexer. ?tenant= and ?collection= narrow it down to the
+// collections of a tenant, or to one of them.
+func (h *Handler) HandleTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	topology := h.broker().topology(h.versions)
+	query := r.URL.Query()
+	if query.Has(tenant.Param) || query.Has("collection") {
+		tenantID, collection := query.Get(tenant.Param), query.Get("collection")
+		kept := topology.Collections[:0]
+		for _, c := range topology.Collections {
+			if c.Tenant == tenantID && (collection == "" || c.Collection == collection) {
+				kept = append(kept, c)
+			}
+		}
+		topology.Collections = kept
+	}
+	writeJSON(w, "application/json", topology)
+}
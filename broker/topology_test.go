@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"common/commitbus"
+)
+
+func TestHandler_HandleTopology(t *testing.T) {
+	products := []Searcher{
+		NewCollectionHTTPSearcher("products", "http://searcher-0a:8081/", 0),
+		NewCollectionHTTPSearcher("products", "http://searcher-0b:8081", 0),
+		NewCollectionHTTPSearcher("products", "http://searcher-1a:8081", 1),
+	}
+	acme := NewCollectionHTTPSearcher("products", "http://acme-0:8081", 0)
+	if err := acme.SetTenant("acme"); err != nil {
+		t.Fatalf("SetTenant returned an error: %v", err)
+	}
+	b := NewBroker(&MockQueryUnderstandingService{}, append(products, acme))
+	// The second replica of shard 0 fails until its breaker opens.
+	for n := 0; n < DefaultBreakerConfig().MinRequests; n++ {
+		b.breakers.Record(replicaKey{ShardKey{"products", 0}, 1}, time.Millisecond, errors.New("down"))
+	}
+	h := NewHandler(b)
+	committedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	h.notifyCommit(commitbus.Event{Collection: "products", Segment: "index_20240601T120000Z", DocCount: 42, CommittedAt: committedAt})
+
+	topology := func(target string) Topology {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var got Topology
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("Failed to decode topology: %v", err)
+		}
+		return got
+	}
+
+	got := topology("/admin/topology")
+	if len(got.Collections) != 2 {
+		t.Fatalf("Expected the collections of both tenants, got %+v", got.Collections)
+	}
+	c := got.Collections[1]
+	if c.Name != "products" || c.Tenant != "" || c.Health != HealthYellow || len(c.Shards) != 2 {
+		t.Fatalf("Expected the yellow products collection with 2 shards, got %+v", c)
+	}
+	want := IndexVersion{Segment: "index_20240601T120000Z", DocCount: 42, CommittedAt: committedAt}
+	if c.IndexVersion == nil || *c.IndexVersion != want {
+		t.Errorf("Expected index version %+v, got %+v", want, c.IndexVersion)
+	}
+	shard := c.Shards[0]
+	if shard.HealthyReplicas != 1 || len(shard.Replicas) != 2 {
+		t.Fatalf("Expected 1 healthy replica of 2, got %+v", shard)
+	}
+	if r := shard.Replicas[0]; r.Endpoint != "http://searcher-0a:8081" || !r.Healthy || r.Breaker.State != BreakerClosed {
+		t.Errorf("Expected a healthy first replica, got %+v", r)
+	}
+	if r := shard.Replicas[1]; r.Healthy || r.Breaker.State != BreakerOpen || r.Breaker.Failures != 5 {
+		t.Errorf("Expected the open breaker of the second replica, got %+v", r)
+	}
+
+	got = topology("/admin/topology?tenant=acme")
+	if len(got.Collections) != 1 || got.Collections[0].Name != "acme/products" || got.Collections[0].Collection != "products" || got.Collections[0].Health != HealthGreen || got.Collections[0].IndexVersion != nil {
+		t.Errorf("Expected the green collection of acme alone, got %+v", got.Collections)
+	}
+}
+
+func TestBroker_Topology_Segments(t *testing.T) {
+	// The second replica lags behind the first.
+	replicas := []Searcher{&MockSearcher{ShardID: 0, Segment: "index_2"}, &MockSearcher{ShardID: 0, Segment: "index_1"}}
+	b := NewBroker(&MockQueryUnderstandingService{}, replicas)
+	segments := func(b *Broker) []string {
+		t.Helper()
+		var got []string
+		for _, c := range b.topology(newIndexVersions()).Collections {
+			for _, shard := range c.Shards {
+				for _, r := range shard.Replicas {
+					got = append(got, r.Segment)
+				}
+			}
+		}
+		return got
+	}
+
+	if got := segments(b); len(got) != 2 || got[0] != "" || got[1] != "" {
+		t.Fatalf("Expected no segments before a search, got %v", got)
+	}
+	// Replicas are searched in turn.
+	for n := 0; n < 2; n++ {
+		if _, err := b.Search(context.Background(), RawQuery("shoes")); err != nil {
+			t.Fatalf("Search returned an error: %v", err)
+		}
+	}
+	if got := segments(b); len(got) != 2 || got[0] != "index_2" || got[1] != "index_1" {
+		t.Errorf("Expected the segment of each replica, got %v", got)
+	}
+
+	reloaded := NewBroker(&MockQueryUnderstandingService{}, replicas)
+	reloaded.inherit(b)
+	if got := segments(reloaded); len(got) != 2 || got[0] != "index_2" || got[1] != "index_1" {
+		t.Errorf("Expected the segments to carry over a reload, got %v", got)
+	}
+}
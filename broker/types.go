@@ -0,0 +1,45 @@
+package broker
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TypeField is the field naming the document type of a result, the type_field of the
+// index mapping. Searchers report the mapping's default type for untyped documents.
+const TypeField = "_type"
+
+// StageTypeBoost is the debug stage of type boosts, reported when they're set.
+const StageTypeBoost = "type_boost"
+
+// SetTypeBoosts multiplies the scores of the merged results by the boost of their
+// document type, e.g. {"product": 2, "review": 0.5}, then orders them again by score.
+// Boosts apply after the merge, so results of every shard are weighed alike; types
+// without a boost keep their scores. Boosts must be positive; nil disables them.
+func (b *Broker) SetTypeBoosts(boosts map[string]float64) error {
+	for t, boost := range boosts {
+		if boost <= 0 {
+			return fmt.Errorf("invalid boost %g of type %q, must be positive", boost, t)
+		}
+	}
+	b.typeBoosts = boosts
+	return nil
+}
+
+// boostTypes applies the type boosts to the scores of results, naming the boosted type in
+// their Rules, and orders them by score if they are ordered by score (byScore).
+func boostTypes(results []SearchResult, boosts map[string]float64, byScore bool) []SearchResult {
+	for i := range results {
+		t, _ := results[i].Stored[TypeField].(string)
+		boost, ok := boosts[t]
+		if !ok || boost == 1 {
+			continue
+		}
+		results[i].Score *= boost
+		results[i].Rules = append(results[i].Rules, "type:"+t)
+	}
+	if byScore {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	}
+	return results
+}
@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestBroker_Search_TypeBoosts(t *testing.T) {
+	typed := func(id, docType string, score float64) SearchResult {
+		return SearchResult{ID: id, Score: score, Stored: map[string]interface{}{TypeField: docType}}
+	}
+	// The fields each shard was asked for; shards are searched concurrently.
+	rankingFields := make([][]string, 2)
+	shard := func(id int, results ...SearchResult) *MockSearcher {
+		return &MockSearcher{
+			ShardID: id,
+			SearchFunc: func(_ context.Context, query StructuredQuery) ([]SearchResult, error) {
+				rankingFields[id] = query.RankingFields
+				return results, nil
+			},
+		}
+	}
+	b := NewBroker(&MockQueryUnderstandingService{}, []Searcher{
+		shard(0, typed("review", "review", 3), typed("boots", "product", 2)),
+		shard(1, typed("guide", "article", 2.5), typed("socks", "product", 1)),
+	})
+	if err := b.SetTypeBoosts(map[string]float64{"product": 2, "review": 0.5}); err != nil {
+		t.Fatalf("SetTypeBoosts returned an error: %v", err)
+	}
+
+	resp, err := b.SearchWithOptions(context.Background(), "boots", SearchOptions{Debug: true})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if got, want := resultIDs(resp.Results), []string{"boots", "guide", "socks", "review"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected order: got %v, want %v", got, want)
+	}
+	if want := []string{TypeField}; !reflect.DeepEqual(rankingFields, [][]string{want, want}) {
+		t.Errorf("Expected searchers to be asked for the document types, got %v", rankingFields)
+	}
+	if !reflect.DeepEqual(resp.Results[0].Rules, []string{"type:product"}) || resp.Results[1].Rules != nil {
+		t.Errorf("Expected the boosted types to be named, got %v and %v", resp.Results[0].Rules, resp.Results[1].Rules)
+	}
+
+	// An explicit sort keeps its order.
+	resp, err = b.SearchWithOptions(context.Background(), "boots", SearchOptions{Sort: []SortField{{Field: "price"}}})
+	if err != nil {
+		t.Fatalf("SearchWithOptions returned an error: %v", err)
+	}
+	if resp.Results[0].ID == "boots" {
+		t.Errorf("Expected the sort order to be kept, got %v", resultIDs(resp.Results))
+	}
+
+	if err := b.SetTypeBoosts(map[string]float64{"product": 0}); err == nil {
+		t.Error("Expected an error for a boost that isn't positive")
+	}
+}
+
+func TestHTTPSearcher_Types(t *testing.T) {
+	var got url.Values
+	searcher := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		w.Write([]byte(`{"total_hits":0,"results":[]}`))
+	}))
+	defer searcher.Close()
+	h := NewHandler(NewBroker(&MockQueryUnderstandingService{}, []Searcher{NewHTTPSearcher(searcher.URL, 0)}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=boots&types=product,+review", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got.Get("types") != "product,review" {
+		t.Errorf("Expected the types to be passed to searchers, got %v", got)
+	}
+}
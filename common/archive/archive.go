@@ -0,0 +1,157 @@
+// Package archive packs index segment directories into compressed archives for transfer
+// between the Indexer and Searchers, and unpacks them again.
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Compression identifies how segments are packaged for transfer.
+type Compression string
+
+const (
+	// CompressionNone transfers segment files individually.
+	CompressionNone Compression = "none"
+	// CompressionGzip transfers a segment as a single tar+gzip archive.
+	CompressionGzip Compression = "gzip"
+)
+
+// ParseCompression parses a compression name. An empty name means CompressionNone.
+func ParseCompression(name string) (Compression, error) {
+	switch c := Compression(strings.ToLower(name)); c {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip:
+		return c, nil
+	}
+	return "", fmt.Errorf("unsupported compression %q, expected %q or %q", name, CompressionNone, CompressionGzip)
+}
+
+// FileName returns the name of the archive holding a segment packed with c,
+// or "" for CompressionNone.
+func (c Compression) FileName() string {
+	if c == CompressionGzip {
+		return "segment.tar.gz"
+	}
+	return ""
+}
+
+// PackTarGz writes the regular files and directories under srcDir to w as a gzip-compressed tar.
+// Paths in the archive are relative to srcDir and use forward slashes.
+func PackTarGz(srcDir string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == srcDir {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil // Skip symlinks, sockets, etc.
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", path, err)
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("failed to create tar header for %s: %w", path, err)
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header for %s: %w", path, err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish gzip stream: %w", err)
+	}
+	return nil
+}
+
+// UnpackTarGz extracts a gzip-compressed tar read from r into destDir. Entries that
+// would escape destDir are rejected.
+func UnpackTarGz(r io.Reader, destDir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(header.Name))
+		if rel, err := filepath.Rel(destDir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %q escapes destination directory", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", target, err)
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tr, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeFile creates path with the given permissions and copies r into it.
+func writeFile(path string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to extract %s: %w", path, err)
+	}
+	return f.Close()
+}
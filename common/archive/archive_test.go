@@ -0,0 +1,73 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackUnpackTarGz(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "store"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"index_meta.json": `{"storage":"scorch"}`,
+		"store/root.bolt": "root",
+		"store/00001.zap": "segment data",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := PackTarGz(src, &buf); err != nil {
+		t.Fatalf("PackTarGz returned an error: %v", err)
+	}
+
+	dest := t.TempDir()
+	if err := UnpackTarGz(&buf, dest); err != nil {
+		t.Fatalf("UnpackTarGz returned an error: %v", err)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("Expected %s to be extracted: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("Unexpected content for %s: %q", name, data)
+		}
+	}
+}
+
+func TestUnpackTarGz_RejectsEscapingEntries(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("evil"))
+	tw.Close()
+	gz.Close()
+
+	if err := UnpackTarGz(&buf, t.TempDir()); err == nil {
+		t.Error("Expected an error for an entry escaping the destination directory")
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	if c, err := ParseCompression(""); err != nil || c != CompressionNone {
+		t.Errorf("Expected CompressionNone for an empty name, got %q, %v", c, err)
+	}
+	if c, err := ParseCompression("GZIP"); err != nil || c != CompressionGzip || c.FileName() != "segment.tar.gz" {
+		t.Errorf("Expected CompressionGzip, got %q, %v", c, err)
+	}
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Error("Expected an error for an unsupported compression")
+	}
+}
@@ -0,0 +1,84 @@
+// Package bloom implements the Bloom filters searchers summarize the keys of their shard
+// with, so that Brokers skip the shards that can't hold the key a query filters on.
+package bloom
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// Filter is a Bloom filter of strings: Test never misses a string that was added, and
+// reports a string that wasn't with a small probability.
+type Filter struct {
+	bits   []byte
+	hashes int
+}
+
+// New returns a filter sized for n strings with a false positive rate of about fpRate,
+// which must be between 0 and 1.
+func New(n int, fpRate float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{bits: make([]byte, (int(m)+7)/8), hashes: k}
+}
+
+// positions returns the bits of s, derived from two halves of its 64-bit FNV-1a hash.
+func (f *Filter) positions(s string) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	m := uint64(len(f.bits)) * 8
+	positions := make([]uint64, f.hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % m
+	}
+	return positions
+}
+
+// Add adds s to the filter.
+func (f *Filter) Add(s string) {
+	for _, p := range f.positions(s) {
+		f.bits[p/8] |= 1 << (p % 8)
+	}
+}
+
+// Test reports whether s may have been added to the filter.
+func (f *Filter) Test(s string) bool {
+	for _, p := range f.positions(s) {
+		if f.bits[p/8]&(1<<(p%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// filterJSON is the JSON form of a Filter; the bits are base64-encoded.
+type filterJSON struct {
+	Bits   []byte `json:"bits"`
+	Hashes int    `json:"hashes"`
+}
+
+func (f *Filter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(filterJSON{Bits: f.bits, Hashes: f.hashes})
+}
+
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	var v filterJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Bits) == 0 || v.Hashes < 1 {
+		return fmt.Errorf("invalid bloom filter of %d bytes and %d hashes", len(v.Bits), v.Hashes)
+	}
+	f.bits, f.hashes = v.Bits, v.Hashes
+	return nil
+}
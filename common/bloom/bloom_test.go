@@ -0,0 +1,47 @@
+package bloom
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add(fmt.Sprintf("customer-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Test(fmt.Sprintf("customer-%d", i)) {
+			t.Fatalf("customer-%d was added but isn't found", i)
+		}
+	}
+	falsePositives := 0
+	for i := 1000; i < 11000; i++ {
+		if f.Test(fmt.Sprintf("customer-%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.03 {
+		t.Errorf("false positive rate %.3f, want about 0.01", rate)
+	}
+}
+
+func TestFilter_JSON(t *testing.T) {
+	f := New(10, 0.01)
+	f.Add("acme")
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Filter
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Test("acme") || decoded.Test("globex") {
+		t.Errorf("decoded filter answers differently: %s", data)
+	}
+	if err := json.Unmarshal([]byte(`{"bits":"","hashes":3}`), &decoded); err == nil {
+		t.Error("expected an empty filter to be rejected")
+	}
+}
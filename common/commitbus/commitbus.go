@@ -0,0 +1,187 @@
+// Package commitbus notifies the services serving an index when the Indexer commits and
+// uploads a new segment, so they can react right away instead of polling. Events are
+// delivered as JSON webhooks: the Indexer POSTs them to the Path of every subscriber, and
+// searchers POST the segments they loaded to the LoadPath of theirs.
+package commitbus
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Path is the endpoint receiving commit events on subscribers.
+const Path = "/internal/commits"
+
+// LoadPath is the endpoint receiving the segments searchers loaded, once they serve them.
+const LoadPath = "/internal/loads"
+
+// Delivery settings of the Publisher.
+const (
+	publishAttempts = 3
+	publishBackoff  = 500 * time.Millisecond
+	publishTimeout  = 5 * time.Second
+)
+
+// Event announces a segment uploaded by the Indexer.
+type Event struct {
+	Tenant      string    `json:"tenant,omitempty"` // Empty for the default tenant
+	Collection  string    `json:"collection"`       // Empty for the collection of an unnamed indexer
+	Segment     string    `json:"segment"`
+	DocCount    uint64    `json:"doc_count"`
+	CommittedAt time.Time `json:"committed_at"`
+}
+
+// Publisher delivers events to the webhooks of the subscribers.
+type Publisher struct {
+	urls   []string
+	client *http.Client
+	token  string // Sent as a bearer token; empty sends none
+}
+
+// NewPublisher returns a Publisher posting commit events to urls, which are subscriber
+// base URLs such as "http://broker:8080"; Path is appended to them. transport may be nil
+// for the default transport.
+func NewPublisher(urls []string, transport http.RoundTripper) (*Publisher, error) {
+	return newPublisher(urls, Path, transport)
+}
+
+// NewLoadPublisher returns a Publisher posting the segments a searcher loaded to urls, as
+// NewPublisher does commit events; LoadPath is appended to them.
+func NewLoadPublisher(urls []string, transport http.RoundTripper) (*Publisher, error) {
+	return newPublisher(urls, LoadPath, transport)
+}
+
+func newPublisher(urls []string, path string, transport http.RoundTripper) (*Publisher, error) {
+	p := &Publisher{client: &http.Client{Transport: transport, Timeout: publishTimeout}}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("invalid commit subscriber URL %q, expected http(s)://host[:port]", u)
+		}
+		p.urls = append(p.urls, strings.TrimSuffix(u, "/")+path)
+	}
+	return p, nil
+}
+
+// SetToken makes the Publisher authenticate its deliveries with token, the shared secret
+// subscribers serving AuthHandler check.
+func (p *Publisher) SetToken(token string) {
+	p.token = token
+}
+
+// Publish delivers event to every subscriber, retrying failed deliveries a few times. It
+// returns the errors of the subscribers that could not be reached.
+func (p *Publisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode commit event: %w", err)
+	}
+	var errs []error
+	for _, url := range p.urls {
+		if err := p.deliver(ctx, url, body); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PublishAsync publishes event in the background, logging failed deliveries, so commits
+// aren't held up by slow subscribers. A nil Publisher publishes nothing.
+func (p *Publisher) PublishAsync(event Event) {
+	if p == nil || len(p.urls) == 0 {
+		return
+	}
+	go func() {
+		if err := p.Publish(context.Background(), event); err != nil {
+			slog.Error("Failed to publish commit", "segment", event.Segment, "error", err)
+		}
+	}()
+}
+
+// deliver posts body to url, retrying with a growing delay.
+func (p *Publisher) deliver(ctx context.Context, url string, body []byte) error {
+	var err error
+	for attempt := 0; attempt < publishAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(publishBackoff << (attempt - 1)):
+			case <-ctx.Done():
+				return fmt.Errorf("%s: %w", url, ctx.Err())
+			}
+		}
+		if err = p.post(ctx, url, body); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", url, err)
+}
+
+func (p *Publisher) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Handler returns the webhook receiving events at Path, passing each one to fn. fn runs
+// on the request goroutine and should hand slow work off.
+func Handler(fn func(Event)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			http.Error(w, fmt.Sprintf("invalid commit event: %v", err), http.StatusBadRequest)
+			return
+		}
+		if event.Segment == "" {
+			http.Error(w, "invalid commit event: segment is required", http.StatusBadRequest)
+			return
+		}
+		fn(event)
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// AuthHandler is Handler for events authenticated by token, the shared secret of the
+// Publishers: deliveries without it get 401. An empty token disables the webhook, which
+// then answers 403, so an unconfigured secret doesn't leave it open.
+func AuthHandler(token string, fn func(Event)) http.Handler {
+	events := Handler(fn)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "commit events are disabled without a token", http.StatusForbidden)
+			return
+		}
+		sent, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			http.Error(w, "invalid or missing commit token", http.StatusUnauthorized)
+			return
+		}
+		events.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,92 @@
+package commitbus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPublisher_Publish(t *testing.T) {
+	events := make(chan Event, 1)
+	var failures atomic.Int32
+	failures.Store(1)
+	mux := http.NewServeMux()
+	receive := Handler(func(e Event) { events <- e })
+	mux.HandleFunc(Path, func(w http.ResponseWriter, r *http.Request) {
+		// The first delivery fails and is retried.
+		if failures.Add(-1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		receive.ServeHTTP(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p, err := NewPublisher([]string{server.URL + "/"}, nil)
+	if err != nil {
+		t.Fatalf("NewPublisher returned an error: %v", err)
+	}
+	event := Event{Tenant: "shop", Collection: "products", Segment: "index", DocCount: 3, CommittedAt: time.Unix(100, 0).UTC()}
+	if err := p.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish returned an error: %v", err)
+	}
+	if got := <-events; got != event {
+		t.Errorf("Expected %+v to be delivered, got %+v", event, got)
+	}
+
+	if _, err := NewPublisher([]string{"broker:8080"}, nil); err == nil {
+		t.Error("Expected an error for a URL without a scheme")
+	}
+}
+
+func TestHandler_Invalid(t *testing.T) {
+	h := Handler(func(Event) { t.Error("Expected no event to be delivered") })
+	for _, tc := range []struct {
+		method, body string
+		status       int
+	}{
+		{http.MethodGet, "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "{", http.StatusBadRequest},
+		{http.MethodPost, `{"collection": "products"}`, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tc.method, Path, strings.NewReader(tc.body)))
+		if rec.Code != tc.status {
+			t.Errorf("%s %q: expected status %d, got %d", tc.method, tc.body, tc.status, rec.Code)
+		}
+	}
+}
+
+func TestAuthHandler(t *testing.T) {
+	delivered := 0
+	server := httptest.NewServer(AuthHandler("secret", func(Event) { delivered++ }))
+	defer server.Close()
+	event := Event{Collection: "products", Segment: "index"}
+
+	for _, token := range []string{"", "wrong"} {
+		p, err := NewLoadPublisher([]string{server.URL}, nil)
+		if err != nil {
+			t.Fatalf("NewLoadPublisher returned an error: %v", err)
+		}
+		p.SetToken(token)
+		if err := p.Publish(context.Background(), event); err == nil || !strings.Contains(err.Error(), "401") {
+			t.Errorf("Expected a 401 for token %q, got %v", token, err)
+		}
+	}
+	p, _ := NewLoadPublisher([]string{server.URL}, nil)
+	p.SetToken("secret")
+	if err := p.Publish(context.Background(), event); err != nil || delivered != 1 {
+		t.Errorf("Expected the authenticated event to be delivered, got %v after %d deliveries", err, delivered)
+	}
+
+	rec := httptest.NewRecorder()
+	AuthHandler("", func(Event) { t.Error("Expected no event to be delivered") }).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, LoadPath, strings.NewReader(`{"segment": "index"}`)))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without a token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,248 @@
+// Package config loads a service's settings from a YAML file, environment variables and
+// command-line flags. Later sources take precedence: defaults < file < environment < flags.
+//
+// Settings are the fields of a struct, described by tags:
+//
+//	type Config struct {
+//		ListenAddr string        `yaml:"listen_addr" env:"LISTEN_ADDR" flag:"listen-addr" usage:"Address to listen on"`
+//		Timeout    time.Duration `yaml:"timeout" env:"TIMEOUT" flag:"timeout" usage:"Request timeout"`
+//		TLS        tlsconfig.Config `yaml:"tls"`
+//	}
+//
+// The values already in the struct are the defaults. Nested structs are read from nested
+// YAML mappings; their fields declare their own environment variables and flags. Supported
+// field types are string, bool, integers, float64, time.Duration and []string (comma
+// separated in environment variables and flags).
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FileFlag names the command-line flag selecting the YAML file.
+	FileFlag = "config-file"
+	// FileEnv names the environment variable selecting the YAML file if the flag isn't set.
+	FileEnv = "CONFIG_FILE"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is a struct field that can be set from the environment or a flag.
+type setting struct {
+	value reflect.Value
+	env   string
+	flag  string
+	usage string
+}
+
+// flagValue records the raw value of a flag so it can be applied after the file and the
+// environment; it reports the field's default in the usage message.
+type flagValue struct {
+	setting *setting
+	def     string
+	raw     string
+	isBool  bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(s string) error {
+	if err := setValue(reflect.New(f.setting.value.Type()).Elem(), s); err != nil {
+		return err
+	}
+	f.raw = s
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f != nil && f.isBool
+}
+
+// Load fills cfg, a pointer to a struct holding the defaults, from the YAML file named by
+// the -config-file flag or the CONFIG_FILE environment variable, then from environment
+// variables and finally from the command-line flags in args (usually os.Args[1:]).
+func Load(name string, cfg interface{}, args []string) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
+	}
+	settings, err := collectSettings(v.Elem())
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	file := fs.String(FileFlag, "", "YAML configuration file (env "+FileEnv+")")
+	flags := make([]*flagValue, 0, len(settings))
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		f := &flagValue{setting: s, isBool: s.value.Kind() == reflect.Bool}
+		if !s.value.IsZero() {
+			f.def = formatValue(s.value)
+		}
+		usage := s.usage
+		if s.env != "" {
+			usage += " (env " + s.env + ")"
+		}
+		fs.Var(f, s.flag, usage)
+		flags = append(flags, f)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := *file
+	if path == "" {
+		path = os.Getenv(FileEnv)
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read config file %s: %w", path, err)
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if raw, ok := os.LookupEnv(s.env); ok {
+			if err := setValue(s.value, raw); err != nil {
+				return fmt.Errorf("invalid value %q for environment variable %s: %w", raw, s.env, err)
+			}
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, f := range flags {
+		if set[f.setting.flag] {
+			if err := setValue(f.setting.value, f.raw); err != nil {
+				return fmt.Errorf("invalid value %q for flag -%s: %w", f.raw, f.setting.flag, err)
+			}
+		}
+	}
+	return nil
+}
+
+// MustLoad loads cfg from os.Args like Load. It exits after printing the usage for -h and
+// on invalid configuration.
+func MustLoad(cfg interface{}) {
+	err := Load(os.Args[0], cfg, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+}
+
+// collectSettings returns the settings of the struct v, recursing into nested structs.
+func collectSettings(v reflect.Value) ([]*setting, error) {
+	var settings []*setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct {
+			nested, err := collectSettings(value)
+			if err != nil {
+				return nil, err
+			}
+			settings = append(settings, nested...)
+			continue
+		}
+		s := &setting{value: value, env: field.Tag.Get("env"), flag: field.Tag.Get("flag"), usage: field.Tag.Get("usage")}
+		if s.env == "" && s.flag == "" {
+			continue
+		}
+		if err := setValue(reflect.New(value.Type()).Elem(), formatValue(value)); err != nil {
+			return nil, fmt.Errorf("unsupported type %s for setting %s", field.Type, field.Name)
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+// setValue parses s into v according to v's type.
+func setValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// formatValue formats v the way setValue parses it.
+func formatValue(v reflect.Value) string {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = v.Index(i).String()
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
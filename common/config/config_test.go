@@ -0,0 +1,123 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testTLS struct {
+	CertFile string `yaml:"cert_file" env:"TEST_TLS_CERT_FILE" flag:"tls-cert-file"`
+}
+
+type testConfig struct {
+	ListenAddr string        `yaml:"listen_addr" env:"TEST_LISTEN_ADDR" flag:"listen-addr" usage:"Address to listen on"`
+	Collection string        `yaml:"collection" env:"TEST_COLLECTION" flag:"collection"`
+	Workers    int           `yaml:"workers" env:"TEST_WORKERS" flag:"workers"`
+	Ratio      float64       `yaml:"ratio" env:"TEST_RATIO"`
+	Verbose    bool          `yaml:"verbose" flag:"verbose"`
+	Timeout    time.Duration `yaml:"timeout" env:"TEST_TIMEOUT" flag:"timeout"`
+	Searchers  []string      `yaml:"searchers" env:"TEST_SEARCHERS" flag:"searchers"`
+	FileOnly   string        `yaml:"file_only"`
+	TLS        testTLS       `yaml:"tls"`
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfig(t, `
+listen_addr: ":9000"
+collection: products
+workers: 4
+ratio: 0.5
+timeout: 10s
+searchers: [a, b]
+file_only: from-file
+tls:
+  cert_file: /file/tls.crt
+`)
+	t.Setenv("TEST_COLLECTION", "from-env")
+	t.Setenv("TEST_WORKERS", "8")
+	t.Setenv("TEST_SEARCHERS", "c, d")
+	t.Setenv("TEST_TLS_CERT_FILE", "/env/tls.crt")
+
+	cfg := testConfig{ListenAddr: ":8080", Workers: 1, Timeout: time.Second}
+	args := []string{"-config-file", path, "-workers", "16", "-verbose", "-tls-cert-file", "/flag/tls.crt"}
+	if err := Load("test", &cfg, args); err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+
+	want := testConfig{
+		ListenAddr: ":9000",                            // File overrides the default
+		Collection: "from-env",                         // Environment overrides the file
+		Workers:    16,                                 // Flag overrides the environment
+		Ratio:      0.5,                                // File only
+		Verbose:    true,                               // Boolean flag without a value
+		Timeout:    10 * time.Second,                   // Duration from the file
+		Searchers:  []string{"c", "d"},                 // Comma separated list from the environment
+		FileOnly:   "from-file",                        // Field without env or flag tags
+		TLS:        testTLS{CertFile: "/flag/tls.crt"}, // Nested struct
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Unexpected configuration:\n got %+v\nwant %+v", cfg, want)
+	}
+}
+
+func TestLoad_DefaultsAndFileFromEnv(t *testing.T) {
+	t.Setenv(FileEnv, writeConfig(t, "collection: from-file\n"))
+	cfg := testConfig{ListenAddr: ":8080"}
+	if err := Load("test", &cfg, nil); err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+	if cfg.ListenAddr != ":8080" || cfg.Collection != "from-file" {
+		t.Errorf("Unexpected configuration: %+v", cfg)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{name: "unknown file key", args: []string{"-config-file", writeConfig(t, "colection: typo\n")}, want: "colection"},
+		{name: "missing file", args: []string{"-config-file", filepath.Join(t.TempDir(), "missing.yaml")}, want: "failed to read config file"},
+		{name: "invalid env", env: map[string]string{"TEST_WORKERS": "many"}, want: "TEST_WORKERS"},
+		{name: "invalid flag", args: []string{"-timeout", "soon"}, want: "timeout"},
+		{name: "unknown flag", args: []string{"-nope"}, want: "nope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var cfg testConfig
+			err := Load("test", &cfg, tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error mentioning %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	var notStruct string
+	if err := Load("test", &notStruct, nil); err == nil {
+		t.Error("Expected an error for a non-struct config")
+	}
+	type unsupported struct {
+		Values map[string]string `env:"TEST_VALUES"`
+	}
+	if err := Load("test", &unsupported{}, nil); err == nil {
+		t.Error("Expected an error for an unsupported field type")
+	}
+}
@@ -0,0 +1,55 @@
+// Package graceful runs HTTP servers until the process is asked to stop, then drains
+// in-flight requests before returning so services can release their resources cleanly.
+package graceful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultTimeout is the recommended bound on how long in-flight requests are drained on shutdown.
+const DefaultTimeout = 30 * time.Second
+
+// Serve runs server until SIGINT or SIGTERM, then stops accepting connections and waits up
+// to timeout for in-flight requests to complete. The server uses TLS if its TLSConfig is set.
+func Serve(server *http.Server, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return ServeContext(ctx, server, timeout)
+}
+
+// ServeContext is like Serve but shuts the server down when ctx is done.
+func ServeContext(ctx context.Context, server *http.Server, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			errCh <- server.ListenAndServeTLS("", "")
+		} else {
+			errCh <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("server on %s failed: %w", server.Addr, err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down server, draining in-flight requests", "addr", server.Addr, "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to drain server on %s: %w", server.Addr, err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server on %s failed: %w", server.Addr, err)
+	}
+	slog.Info("Server stopped", "addr", server.Addr)
+	return nil
+}
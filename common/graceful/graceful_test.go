@@ -0,0 +1,82 @@
+package graceful
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestServeContext_DrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	addr := freeAddr(t)
+	server := &http.Server{Addr: addr, Handler: handler}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- ServeContext(ctx, server, 5*time.Second) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ { // Wait for the server to listen
+			if resp, err = http.Get("http://" + addr); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{string(body), err}
+	}()
+
+	<-started
+	cancel()
+	if r := <-results; r.err != nil || r.body != "done" {
+		t.Errorf("Expected the in-flight request to complete, got %q (%v)", r.body, r.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ServeContext returned an error: %v", err)
+	}
+	if _, err := http.Get("http://" + addr); err == nil {
+		t.Error("Expected the server to stop accepting connections")
+	}
+}
+
+func TestServeContext_ListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	server := &http.Server{Addr: l.Addr().String()}
+	if err := ServeContext(context.Background(), server, time.Second); err == nil {
+		t.Error("Expected an error when the address is in use")
+	}
+}
@@ -0,0 +1,138 @@
+// Package logging sets up the structured logger of the services: leveled records written
+// as text or JSON lines, carrying the ID of the request they were logged for. Services log
+// with log/slog once Setup installed the logger; records of the standard log package go
+// through it too, at the info level.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// LevelPath is the admin endpoint reading and changing the level of a running service.
+const LevelPath = "/admin/log-level"
+
+// Config sets the level and format of a service's logs. The tags let services load it with
+// common/config, from a "log" section or the LOG_* environment variables.
+type Config struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"Lowest level logged: debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"log-format" usage:"Log format: text, or json for log aggregation"`
+}
+
+// DefaultConfig returns text logs of the info level and above.
+func DefaultConfig() Config {
+	return Config{Level: "info", Format: FormatText}
+}
+
+// Validate checks the configuration's values.
+func (c Config) Validate() error {
+	if _, err := parseLevel(c.Level); err != nil {
+		return err
+	}
+	if c.Format != FormatText && c.Format != FormatJSON {
+		return fmt.Errorf("invalid log format %q, expected %s or %s", c.Format, FormatText, FormatJSON)
+	}
+	return nil
+}
+
+// level is the lowest level logged by the installed logger, changed by SetLevel.
+var level = new(slog.LevelVar)
+
+// Setup installs the logger of cfg, writing to standard error, as the default logger of
+// log/slog and of the log package.
+func Setup(cfg Config) error {
+	logger, err := New(cfg, os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// New returns the logger of cfg writing to w. Its level is shared with the installed
+// logger and follows SetLevel.
+func New(cfg Config, w io.Writer) (*slog.Logger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	l, _ := parseLevel(cfg.Level)
+	level.Set(l)
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if cfg.Format == FormatJSON {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(requestIDHandler{handler}), nil
+}
+
+// Level returns the name of the lowest level logged.
+func Level() string {
+	return strings.ToLower(level.Level().String())
+}
+
+// SetLevel changes the lowest level logged, e.g. to debug an issue without a restart.
+func SetLevel(name string) error {
+	l, err := parseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// parseLevel parses a level name, case-insensitively.
+func parseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", name)
+	}
+}
+
+// LevelHandler serves LevelPath: GET returns {"level": "info"} and PUT with such a body
+// changes the level.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body struct {
+				Level string `json:"level"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body, expected {\"level\": ...}: %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := SetLevel(body.Level); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Info("Changed the log level", "level", Level())
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"level": Level()})
+	})
+}
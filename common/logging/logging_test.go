@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew_JSONWithRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(Config{Level: "info", Format: FormatJSON}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithRequestID(context.Background(), "req-42")
+	logger.InfoContext(ctx, "Searched", "collection", "products")
+	logger.DebugContext(ctx, "Not logged at the info level")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d records, want 1: %s", len(lines), buf.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("record isn't JSON: %v", err)
+	}
+	if record["level"] != "INFO" || record["msg"] != "Searched" || record["collection"] != "products" || record["request_id"] != "req-42" {
+		t.Errorf("unexpected record %v", record)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(DefaultConfig(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetLevel("info")
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+	if err := SetLevel("DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("Shard pool refreshed")
+	if !strings.Contains(buf.String(), "Shard pool refreshed") {
+		t.Errorf("debug record not logged after SetLevel: %q", buf.String())
+	}
+	if Level() != "debug" {
+		t.Errorf("Level() = %q, want debug", Level())
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if err := (Config{Level: "info", Format: "xml"}).Validate(); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+	if err := (Config{Level: "loud", Format: FormatText}).Validate(); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	defer SetLevel("info")
+	h := LevelHandler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, LevelPath, strings.NewReader(`{"level":"warn"}`)))
+	if w.Code != http.StatusOK || Level() != "warn" {
+		t.Fatalf("PUT answered %d, level %q", w.Code, Level())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, LevelPath, strings.NewReader(`{"level":"trace"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid level answered %d, want 400", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, LevelPath, nil))
+	if !strings.Contains(w.Body.String(), `"warn"`) {
+		t.Errorf("GET returned %s", w.Body.String())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got, forwarded string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, forwarded = RequestID(r.Context()), r.Header.Get(RequestIDHeader)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/search", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got != "abc" || w.Header().Get(RequestIDHeader) != "abc" {
+		t.Errorf("RequestID = %q, response header %q, want abc", got, w.Header().Get(RequestIDHeader))
+	}
+
+	for _, sent := range []string{"", "forged\nline", strings.Repeat("x", maxRequestIDLength+1)} {
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		req.Header.Set(RequestIDHeader, sent)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if got == "" || got == sent || forwarded != got || w.Header().Get(RequestIDHeader) != got {
+			t.Errorf("request ID %q: got %q, forwarded %q, response header %q", sent, got, forwarded, w.Header().Get(RequestIDHeader))
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(RequestIDHeader))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: Transport(nil)}
+
+	ctx := WithRequestID(context.Background(), "req-7")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get(RequestIDHeader) != "" {
+		t.Error("Transport modified the request")
+	}
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	req.Header.Set(RequestIDHeader, "explicit")
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "req-7,explicit," {
+		t.Errorf("forwarded request IDs %q", got)
+	}
+}
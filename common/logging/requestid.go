@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// RequestIDHeader carries the ID of a request between services, so that the records
+// logged for a query by every service can be found by it.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request ID id; records logged with it
+// have a request_id attribute.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// maxRequestIDLength bounds the request IDs accepted from clients, so that they can't
+// flood the logs.
+const maxRequestIDLength = 128
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether id, sent by a client, is kept as the request ID: it must
+// be short and printable ASCII, so that it can't forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware wraps an HTTP handler so that every request has a request ID: the one sent in
+// RequestIDHeader, or a new one if it is missing or invalid. The ID is carried by the
+// context and the header of the request, so that proxies forwarding the headers pass it on,
+// and is returned in the RequestIDHeader of the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// Transport wraps an http.RoundTripper so that outgoing requests carry the request ID of
+// their context in RequestIDHeader, unless they set one already. A nil base uses
+// http.DefaultTransport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return requestIDTransport{base}
+}
+
+type requestIDTransport struct {
+	base http.RoundTripper
+}
+
+func (t requestIDTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if id := RequestID(r.Context()); id != "" && r.Header.Get(RequestIDHeader) == "" {
+		// A RoundTripper must not modify the request it is given.
+		r = r.Clone(r.Context())
+		r.Header.Set(RequestIDHeader, id)
+	}
+	return t.base.RoundTrip(r)
+}
+
+// requestIDHandler adds the request ID of the context to the records it handles.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
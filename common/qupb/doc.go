@@ -0,0 +1,6 @@
+// Package qupb holds the gRPC contract of the query understanding service, generated from
+// query_understanding.proto, so the Broker and other services can call it with typed
+// messages.
+package qupb
+
+//go:generate protoc --proto_path=.. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative qupb/query_understanding.proto
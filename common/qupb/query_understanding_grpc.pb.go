@@ -0,0 +1,111 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: qupb/query_understanding.proto
+
+package qupb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	QueryUnderstanding_Process_FullMethodName = "/queryunderstanding.v1.QueryUnderstanding/Process"
+)
+
+// QueryUnderstandingClient is the client API for QueryUnderstanding service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type QueryUnderstandingClient interface {
+	// Process runs a pipeline, the default one unless named, on a raw query.
+	Process(ctx context.Context, in *RawQuery, opts ...grpc.CallOption) (*StructuredQuery, error)
+}
+
+type queryUnderstandingClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewQueryUnderstandingClient(cc grpc.ClientConnInterface) QueryUnderstandingClient {
+	return &queryUnderstandingClient{cc}
+}
+
+func (c *queryUnderstandingClient) Process(ctx context.Context, in *RawQuery, opts ...grpc.CallOption) (*StructuredQuery, error) {
+	out := new(StructuredQuery)
+	err := c.cc.Invoke(ctx, QueryUnderstanding_Process_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// QueryUnderstandingServer is the server API for QueryUnderstanding service.
+// All implementations must embed UnimplementedQueryUnderstandingServer
+// for forward compatibility
+type QueryUnderstandingServer interface {
+	// Process runs a pipeline, the default one unless named, on a raw query.
+	Process(context.Context, *RawQuery) (*StructuredQuery, error)
+	mustEmbedUnimplementedQueryUnderstandingServer()
+}
+
+// UnimplementedQueryUnderstandingServer must be embedded to have forward compatible implementations.
+type UnimplementedQueryUnderstandingServer struct {
+}
+
+func (UnimplementedQueryUnderstandingServer) Process(context.Context, *RawQuery) (*StructuredQuery, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Process not implemented")
+}
+func (UnimplementedQueryUnderstandingServer) mustEmbedUnimplementedQueryUnderstandingServer() {}
+
+// UnsafeQueryUnderstandingServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to QueryUnderstandingServer will
+// result in compilation errors.
+type UnsafeQueryUnderstandingServer interface {
+	mustEmbedUnimplementedQueryUnderstandingServer()
+}
+
+func RegisterQueryUnderstandingServer(s grpc.ServiceRegistrar, srv QueryUnderstandingServer) {
+	s.RegisterService(&QueryUnderstanding_ServiceDesc, srv)
+}
+
+func _QueryUnderstanding_Process_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RawQuery)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryUnderstandingServer).Process(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: QueryUnderstanding_Process_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryUnderstandingServer).Process(ctx, req.(*RawQuery))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// QueryUnderstanding_ServiceDesc is the grpc.ServiceDesc for QueryUnderstanding service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var QueryUnderstanding_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "queryunderstanding.v1.QueryUnderstanding",
+	HandlerType: (*QueryUnderstandingServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Process",
+			Handler:    _QueryUnderstanding_Process_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "qupb/query_understanding.proto",
+}
@@ -0,0 +1,77 @@
+// Package reload re-reads a service's configuration while it runs, on SIGHUP or on a POST
+// to Path. A reload builds the new configuration in full before swapping it in, so an
+// invalid configuration is rejected and the running one kept.
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Path is the admin endpoint triggering a reload.
+const Path = "/admin/reload"
+
+// Reloader runs the reload function of a service, one reload at a time.
+type Reloader struct {
+	name   string
+	mu     sync.Mutex
+	reload func() error
+}
+
+// New returns a Reloader calling reload, which must validate the whole configuration
+// before applying any of it. name identifies the service in logs.
+func New(name string, reload func() error) *Reloader {
+	return &Reloader{name: name, reload: reload}
+}
+
+// Reload reloads the configuration, waiting for a reload in progress to complete first.
+// It returns the error rejecting the new configuration, the old one being kept.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.reload(); err != nil {
+		slog.Error("Rejected the new configuration, keeping the running one", "service", r.name, "error", err)
+		return err
+	}
+	slog.Info("Reloaded the configuration", "service", r.name)
+	return nil
+}
+
+// Watch reloads the configuration on every SIGHUP until ctx is done.
+func (r *Reloader) Watch(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			r.Reload()
+		}
+	}
+}
+
+// Handler returns the handler of POST Path, which answers 200 once the configuration is
+// reloaded and 422 with the reason a new configuration was rejected.
+func (r *Reloader) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := r.Reload(); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]string{"status": "rejected", "error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "reloaded"})
+	})
+}
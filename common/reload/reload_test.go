@@ -0,0 +1,86 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloader_Handler(t *testing.T) {
+	var fail atomic.Bool
+	var reloads atomic.Int32
+	r := New("test", func() error {
+		reloads.Add(1)
+		if fail.Load() {
+			return errors.New("invalid pipeline")
+		}
+		return nil
+	})
+	h := r.Handler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, Path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	fail.Store(true)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, Path, nil))
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "invalid pipeline") {
+		t.Fatalf("rejected reload answered %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, Path, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET answered %d, want 405", w.Code)
+	}
+	if n := reloads.Load(); n != 2 {
+		t.Fatalf("reloaded %d times, want 2", n)
+	}
+}
+
+func TestReloader_Watch(t *testing.T) {
+	reloaded := make(chan struct{}, 1)
+	r := New("test", func() error {
+		reloaded <- struct{}{}
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		r.Watch(ctx)
+		close(done)
+	}()
+
+	// Catch SIGHUP here too so a signal sent before Watch subscribes doesn't kill the test,
+	// and keep sending until it reloads.
+	caught := make(chan os.Signal, 8)
+	signal.Notify(caught, syscall.SIGHUP)
+	defer signal.Stop(caught)
+	deadline := time.After(5 * time.Second)
+	for sent := false; !sent; {
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-reloaded:
+			sent = true
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("SIGHUP didn't reload the configuration")
+		}
+	}
+	cancel()
+	<-done
+}
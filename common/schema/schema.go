@@ -0,0 +1,172 @@
+// Package schema describes the fields of an index: their types, analyzers and whether
+// they're indexed and stored. The query understanding service reads it from its
+// configuration and the indexer generates its index mapping from the same YAML.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"common/vector"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Field types.
+const (
+	TypeString   = "string"   // Matched exactly, e.g. IDs and categories
+	TypeText     = "text"     // Analyzed full text
+	TypeInteger  = "integer"  // Numeric
+	TypeFloat    = "float"    // Numeric
+	TypeBoolean  = "boolean"  // true or false
+	TypeDatetime = "datetime" // RFC 3339 dates
+	TypeVector   = "vector"   // Dense vectors of Dims numbers, e.g. embeddings, searched by kNN
+)
+
+// Options of an IndexSchema.
+const (
+	// OptionAnalyzer is the analyzer of text fields without one.
+	OptionAnalyzer = "analyzer"
+	// OptionDynamic set to "false" leaves fields missing from the schema unindexed.
+	OptionDynamic = "dynamic"
+)
+
+// ErrInvalidSchema is wrapped by the errors of schemas failing validation.
+var ErrInvalidSchema = errors.New("invalid index schema")
+
+// IndexSchema represents the configuration for an index schema.
+type IndexSchema struct {
+	Name    string            `yaml:"name"`
+	Fields  []Field           `yaml:"fields"`
+	Options map[string]string `yaml:"options"`
+}
+
+// Field represents a field within an index schema. Analyzer names the analyzer of a text
+// field, e.g. "standard", "en" or one of the indexer's custom analyzers such as
+// "autocomplete"; it defaults to the analyzer option of the schema. Vector fields
+// have Dims dimensions and are compared by Similarity, one of the similarities of
+// package vector.
+type Field struct {
+	Name       string `yaml:"name"`
+	Type       string `yaml:"type"`
+	Indexed    bool   `yaml:"indexed"`
+	Stored     bool   `yaml:"stored"`
+	Analyzer   string `yaml:"analyzer,omitempty"`
+	Dims       int    `yaml:"dims,omitempty"`
+	Similarity string `yaml:"similarity,omitempty"`
+}
+
+// Validate checks that the schema has a name and fields with names and supported types.
+func (s IndexSchema) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("%w: index schema name cannot be empty", ErrInvalidSchema)
+	}
+	if len(s.Fields) == 0 {
+		return fmt.Errorf("%w: index schema '%s' must define at least one field", ErrInvalidSchema, s.Name)
+	}
+	seen := make(map[string]bool, len(s.Fields))
+	for _, field := range s.Fields {
+		if field.Name == "" {
+			return fmt.Errorf("%w: field name in schema '%s' cannot be empty", ErrInvalidSchema, s.Name)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("%w: field '%s' is defined twice in schema '%s'", ErrInvalidSchema, field.Name, s.Name)
+		}
+		seen[field.Name] = true
+		if field.Type == "" {
+			return fmt.Errorf("%w: field '%s' in schema '%s' must have a type", ErrInvalidSchema, field.Name, s.Name)
+		}
+		switch field.Type {
+		case TypeString, TypeText, TypeInteger, TypeFloat, TypeBoolean, TypeDatetime:
+		case TypeVector:
+			vf := vector.Field{Dims: field.Dims, Similarity: field.Similarity}
+			if err := vf.Validate(); err != nil || field.Dims == 0 {
+				return fmt.Errorf("%w: vector field '%s' in schema '%s' needs dims between 1 and %d and a supported similarity", ErrInvalidSchema, field.Name, s.Name, vector.MaxDims)
+			}
+		default:
+			return fmt.Errorf("%w: field '%s' in schema '%s' has an unsupported type '%s'", ErrInvalidSchema, field.Name, s.Name, field.Type)
+		}
+		if field.Analyzer != "" && field.Type != TypeText {
+			return fmt.Errorf("%w: field '%s' in schema '%s' has an analyzer but isn't a text field", ErrInvalidSchema, field.Name, s.Name)
+		}
+		if (field.Dims != 0 || field.Similarity != "") && field.Type != TypeVector {
+			return fmt.Errorf("%w: field '%s' in schema '%s' has dims or a similarity but isn't a vector field", ErrInvalidSchema, field.Name, s.Name)
+		}
+	}
+	if dynamic, ok := s.Options[OptionDynamic]; ok && dynamic != "true" && dynamic != "false" {
+		return fmt.Errorf("%w: option dynamic of schema '%s' must be true or false", ErrInvalidSchema, s.Name)
+	}
+	return nil
+}
+
+// Analyzer returns the analyzer of a text field, "" if neither the field nor the schema
+// names one.
+func (s IndexSchema) Analyzer(field Field) string {
+	if field.Analyzer != "" {
+		return field.Analyzer
+	}
+	return s.Options[OptionAnalyzer]
+}
+
+// Dynamic reports whether fields missing from the schema are indexed.
+func (s IndexSchema) Dynamic() bool {
+	return s.Options[OptionDynamic] != "false"
+}
+
+// VectorFields returns the vector fields of the schema by name.
+func (s IndexSchema) VectorFields() map[string]vector.Field {
+	var fields map[string]vector.Field
+	for _, field := range s.Fields {
+		if field.Type != TypeVector {
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string]vector.Field)
+		}
+		vf := vector.Field{Dims: field.Dims, Similarity: field.Similarity}
+		vf.Validate() // Defaults the similarity; the schema was validated
+		fields[field.Name] = vf
+	}
+	return fields
+}
+
+// Load reads the index_schemas of a YAML file, e.g. the query understanding
+// configuration, ignoring its other settings, and validates them.
+func Load(path string) ([]IndexSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index schemas from %s: %w", path, err)
+	}
+	var file struct {
+		IndexSchemas []IndexSchema `yaml:"index_schemas"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal index schemas from %s: %w", path, err)
+	}
+	if len(file.IndexSchemas) == 0 {
+		return nil, fmt.Errorf("%w: %s defines no index_schemas", ErrInvalidSchema, path)
+	}
+	for _, s := range file.IndexSchemas {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return file.IndexSchemas, nil
+}
+
+// Find returns the schema called name. An empty name selects the only schema.
+func Find(schemas []IndexSchema, name string) (IndexSchema, error) {
+	if name == "" {
+		if len(schemas) != 1 {
+			return IndexSchema{}, fmt.Errorf("%w: %d schemas defined, name the one to use", ErrInvalidSchema, len(schemas))
+		}
+		return schemas[0], nil
+	}
+	for _, s := range schemas {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return IndexSchema{}, fmt.Errorf("%w: no schema named '%s'", ErrInvalidSchema, name)
+}
@@ -0,0 +1,85 @@
+package schema
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"common/vector"
+)
+
+func TestIndexSchema_Validate(t *testing.T) {
+	valid := IndexSchema{Name: "products", Fields: []Field{{Name: "id", Type: TypeString}, {Name: "title", Type: TypeText, Analyzer: "en"}}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %v", err)
+	}
+	for name, s := range map[string]IndexSchema{
+		"no name":           {Fields: valid.Fields},
+		"no fields":         {Name: "products"},
+		"unnamed field":     {Name: "products", Fields: []Field{{Type: TypeText}}},
+		"duplicate field":   {Name: "products", Fields: []Field{{Name: "id", Type: TypeString}, {Name: "id", Type: TypeText}}},
+		"untyped field":     {Name: "products", Fields: []Field{{Name: "id"}}},
+		"unknown type":      {Name: "products", Fields: []Field{{Name: "id", Type: "uuid"}}},
+		"keyword analyzer":  {Name: "products", Fields: []Field{{Name: "id", Type: TypeString, Analyzer: "en"}}},
+		"bad dynamic value": {Name: "products", Fields: valid.Fields, Options: map[string]string{OptionDynamic: "no"}},
+		"vector sans dims":  {Name: "products", Fields: []Field{{Name: "embedding", Type: TypeVector}}},
+		"bad similarity":    {Name: "products", Fields: []Field{{Name: "embedding", Type: TypeVector, Dims: 3, Similarity: "jaccard"}}},
+		"text with dims":    {Name: "products", Fields: []Field{{Name: "title", Type: TypeText, Dims: 3}}},
+	} {
+		if err := s.Validate(); !errors.Is(err, ErrInvalidSchema) {
+			t.Errorf("%s: expected ErrInvalidSchema, got %v", name, err)
+		}
+	}
+
+	withVector := IndexSchema{Name: "products", Fields: append(valid.Fields, Field{Name: "embedding", Type: TypeVector, Dims: 3})}
+	if err := withVector.Validate(); err != nil {
+		t.Fatalf("Validate returned an error: %v", err)
+	}
+	if got := withVector.VectorFields(); len(got) != 1 || got["embedding"].Dims != 3 || got["embedding"].Similarity != vector.Cosine {
+		t.Errorf("Expected the embedding field with the cosine similarity, got %v", got)
+	}
+}
+
+func TestLoadAndFind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `
+index_schemas:
+  - name: products
+    fields:
+      - {name: id, type: string, indexed: true, stored: true}
+      - {name: title, type: text, indexed: true, stored: true}
+    options:
+      analyzer: en
+      dynamic: "false"
+  - name: articles
+    fields:
+      - {name: body, type: text, analyzer: standard, indexed: true}
+rewrite_rules:
+  - {name: ignored, match: literal, pattern: a, replace: b}
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	schemas, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+	products, err := Find(schemas, "products")
+	if err != nil {
+		t.Fatalf("Find returned an error: %v", err)
+	}
+	if products.Dynamic() || products.Analyzer(products.Fields[1]) != "en" {
+		t.Errorf("Unexpected options of %+v", products)
+	}
+	articles, _ := Find(schemas, "articles")
+	if !articles.Dynamic() || articles.Analyzer(articles.Fields[0]) != "standard" {
+		t.Errorf("Unexpected options of %+v", articles)
+	}
+	if _, err := Find(schemas, ""); !errors.Is(err, ErrInvalidSchema) {
+		t.Errorf("Expected an ambiguous schema to fail, got %v", err)
+	}
+	if _, err := Find(schemas, "users"); !errors.Is(err, ErrInvalidSchema) {
+		t.Errorf("Expected a missing schema to fail, got %v", err)
+	}
+}
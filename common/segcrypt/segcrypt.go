@@ -0,0 +1,222 @@
+// Package segcrypt encrypts the files of index segments on the Indexer before they are
+// uploaded, and decrypts them on the Searchers that download them.
+//
+// An encrypted file starts with a magic header and a random salt. The file's key is
+// derived from the master key and the salt with HKDF-SHA256, so no two files share a key,
+// and its contents are sealed with AES-GCM in chunks whose nonce is the chunk counter and
+// a flag set on the last chunk, which authenticates them against reordering and truncation.
+package segcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Layout of the encrypted files.
+const (
+	// ChunkSize is the number of plaintext bytes sealed together.
+	ChunkSize = 64 << 10
+	// Overhead is the number of bytes AES-GCM adds to every chunk.
+	Overhead = 16
+
+	magic     = "SEGENC2\n"
+	saltSize  = 32
+	headerLen = len(magic) + saltSize
+	nonceSize = 12
+	maxChunk  = 1<<64 - 1 // Last chunk counter before it would wrap
+	hkdfInfo  = "search-engine segment file"
+)
+
+// ErrDecryption is returned for encrypted files that are corrupted, truncated, not
+// encrypted or encrypted with another key.
+var ErrDecryption = errors.New("failed to decrypt")
+
+// Cipher encrypts and decrypts segment files with a master key.
+type Cipher struct {
+	key []byte
+}
+
+// New returns a Cipher with key, which must be 16, 24 or 32 bytes long to select AES-128,
+// AES-192 or AES-256.
+func New(key []byte) (*Cipher, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return &Cipher{key: append([]byte(nil), key...)}, nil
+}
+
+// ParseKey decodes a hex-encoded AES key, such as the output of "openssl rand -hex 32".
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be hex-encoded: %w", err)
+	}
+	if _, err := New(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// fileAEAD returns the AEAD of the file with salt: AES-GCM with a key derived from the
+// master key by HKDF-SHA256 (RFC 5869).
+func (c *Cipher) fileAEAD(salt []byte) (cipher.AEAD, error) {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(c.key)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(hkdfInfo))
+	expand.Write([]byte{1})
+	block, err := aes.NewCipher(expand.Sum(nil)[:len(c.key)])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt writes the header, then r sealed in chunks of ChunkSize bytes.
+func (c *Cipher) Encrypt(w io.Writer, r io.Reader) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := c.fileAEAD(salt)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return err
+	}
+	nonce := make([]byte, nonceSize)
+	return processChunks(bufio.NewReader(r), ChunkSize, func(counter uint64, chunk []byte, last bool) error {
+		setNonce(nonce, counter, last)
+		_, err := w.Write(aead.Seal(nil, nonce, chunk, nil))
+		return err
+	})
+}
+
+// Decrypt checks the header of r and writes its chunks, opened.
+func (c *Cipher) Decrypt(w io.Writer, r io.Reader) error {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, []byte(magic)) {
+		return fmt.Errorf("%w: not an encrypted segment file", ErrDecryption)
+	}
+	aead, err := c.fileAEAD(header[len(magic):])
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, nonceSize)
+	return processChunks(bufio.NewReader(r), ChunkSize+Overhead, func(counter uint64, chunk []byte, last bool) error {
+		setNonce(nonce, counter, last)
+		plain, err := aead.Open(nil, nonce, chunk, nil)
+		if err != nil {
+			return fmt.Errorf("%w: chunk %d: %v", ErrDecryption, counter, err)
+		}
+		_, err = w.Write(plain)
+		return err
+	})
+}
+
+// EncryptFile writes the encryption of src to dst, creating the directories of dst.
+func (c *Cipher) EncryptFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return transformFile(src, dst, c.Encrypt)
+}
+
+// DecryptDir decrypts in place every file under dir except those named skip at its root,
+// such as the manifest of a segment. Each file is decrypted into a temporary file first,
+// so a failure leaves it encrypted. Decryption fails with an error wrapping ErrDecryption
+// if a file was tampered with, truncated or encrypted with another key.
+func (c *Cipher) DecryptDir(dir string, skip ...string) error {
+	skipped := make(map[string]bool, len(skip))
+	for _, name := range skip {
+		skipped[filepath.Join(dir, name)] = true
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || skipped[path] {
+			return err
+		}
+		tmp := path + ".decrypting"
+		if err := transformFile(path, tmp, c.Decrypt); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return os.Rename(tmp, path)
+	})
+}
+
+// transformFile writes src, transformed by fn, to dst.
+func transformFile(src, dst string, fn func(w io.Writer, r io.Reader) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	err = fn(w, in)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// processChunks calls fn with consecutive chunks of size bytes read from r, flagging the
+// last one, which is shorter and possibly empty.
+func processChunks(r *bufio.Reader, size int, fn func(counter uint64, chunk []byte, last bool) error) error {
+	buf := make([]byte, size)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < size
+		if !last {
+			if _, err := r.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		if err := fn(counter, buf[:n], last); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == maxChunk {
+			return fmt.Errorf("file too large to encrypt")
+		}
+	}
+}
+
+// setNonce writes the chunk counter and the last-chunk flag into nonce. Nonces only need
+// to be unique per file, as every file has its own key.
+func setNonce(nonce []byte, counter uint64, last bool) {
+	binary.BigEndian.PutUint64(nonce, counter)
+	nonce[nonceSize-1] = 0
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+}
@@ -0,0 +1,101 @@
+package segcrypt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCipher_RoundTrip(t *testing.T) {
+	c, err := New(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, 2*ChunkSize + 5} {
+		plain := []byte(strings.Repeat("a", size))
+		var sealed bytes.Buffer
+		if err := c.Encrypt(&sealed, bytes.NewReader(plain)); err != nil {
+			t.Fatalf("Encrypt(%d bytes) returned an error: %v", size, err)
+		}
+		// Short plaintexts may show up in random ciphertext by chance.
+		if size >= 16 && bytes.Contains(sealed.Bytes(), plain) {
+			t.Errorf("Expected %d bytes to be encrypted", size)
+		}
+		var opened bytes.Buffer
+		if err := c.Decrypt(&opened, bytes.NewReader(sealed.Bytes())); err != nil {
+			t.Fatalf("Decrypt(%d bytes) returned an error: %v", size, err)
+		}
+		if !bytes.Equal(opened.Bytes(), plain) {
+			t.Errorf("Round trip of %d bytes returned %d different bytes", size, opened.Len())
+		}
+
+		// Dropping the last chunk when there are several is detected.
+		if size > ChunkSize {
+			truncated := sealed.Bytes()[:headerLen+ChunkSize+Overhead]
+			if err := c.Decrypt(&opened, bytes.NewReader(truncated)); !errors.Is(err, ErrDecryption) {
+				t.Errorf("Expected ErrDecryption for a dropped chunk, got %v", err)
+			}
+		}
+	}
+
+	// Every file gets its own salt, hence its own key.
+	var a, b bytes.Buffer
+	c.Encrypt(&a, strings.NewReader("secret"))
+	c.Encrypt(&b, strings.NewReader("secret"))
+	if bytes.Equal(a.Bytes()[len(magic):headerLen], b.Bytes()[len(magic):headerLen]) || bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Error("Expected files to be encrypted with distinct salts")
+	}
+
+	other, err := New(bytes.Repeat([]byte{8}, 32))
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	var opened bytes.Buffer
+	if err := other.Decrypt(&opened, &a); !errors.Is(err, ErrDecryption) {
+		t.Errorf("Expected ErrDecryption with another key, got %v", err)
+	}
+	if err := c.Decrypt(&opened, strings.NewReader("plain text file, long enough for a header")); !errors.Is(err, ErrDecryption) {
+		t.Errorf("Expected ErrDecryption for a plain file, got %v", err)
+	}
+}
+
+func TestCipher_DecryptDir(t *testing.T) {
+	c, err := New(bytes.Repeat([]byte{7}, 16))
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	src, dir := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(src, "root.bolt"), []byte("top secret"), 0644)
+	if err := c.EncryptFile(filepath.Join(src, "root.bolt"), filepath.Join(dir, "store", "root.bolt")); err != nil {
+		t.Fatalf("EncryptFile returned an error: %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, "manifest.json"), []byte("{}"), 0644)
+
+	if err := c.DecryptDir(dir, "manifest.json"); err != nil {
+		t.Fatalf("DecryptDir returned an error: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "store", "root.bolt")); string(data) != "top secret" {
+		t.Errorf("Expected the decrypted contents, got %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "manifest.json")); string(data) != "{}" {
+		t.Errorf("Expected the manifest to be skipped, got %q", data)
+	}
+	// The files are plain now, which a second pass rejects.
+	if err := c.DecryptDir(dir, "manifest.json"); !errors.Is(err, ErrDecryption) {
+		t.Errorf("Expected ErrDecryption for plain files, got %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey(strings.Repeat("ab", 32)); err != nil {
+		t.Errorf("Expected a valid AES-256 key, got %v", err)
+	}
+	for _, s := range []string{"not hex", "abcd"} {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package shard assigns documents to the shards of a collection by their ID, so writers
+// and readers agree on the shard holding a document.
+package shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// For returns the shard, out of n, of the document id: the 32-bit FNV-1a hash of the ID
+// modulo n. n must be positive.
+func For(id string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(n))
+}
+
+// Table maps the shards of a collection, numbered from 0, to the base URLs of the
+// services serving them.
+type Table []string
+
+// NewTable returns the table of the shard URLs keyed by shard ID, which must number the
+// shards from 0 without gaps.
+func NewTable(urls map[int]string) (Table, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no shards")
+	}
+	ids := make([]int, 0, len(urls))
+	for id := range urls {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	table := make(Table, len(ids))
+	for i, id := range ids {
+		if id != i {
+			return nil, fmt.Errorf("shard IDs must number the shards from 0 without gaps, missing shard %d", i)
+		}
+		if urls[id] == "" {
+			return nil, fmt.Errorf("shard %d has no URL", id)
+		}
+		table[i] = urls[id]
+	}
+	return table, nil
+}
+
+// Lookup returns the shard of the document id and the URL serving it.
+func (t Table) Lookup(id string) (int, string) {
+	s := For(id, len(t))
+	return s, t[s]
+}
+
+// RoutingTable is the shard table of a collection persisted to a file, which writers
+// such as the router reload when resharding replaces it.
+type RoutingTable struct {
+	Version int   `json:"version"` // Incremented by every resharding
+	Shards  Table `json:"shards"`
+	// Next is the shard table a resharding migrates to: while it is set, writers write
+	// every document to its shard in Shards and mirror it to its shard in Next, so the
+	// new shards miss no write made while they are built.
+	Next      Table     `json:"next,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReadRoutingTable reads the routing table at path.
+func ReadRoutingTable(path string) (*RoutingTable, error) {
+	var t RoutingTable
+	if err := readJSON(path, "routing table", &t); err != nil {
+		return nil, err
+	}
+	if len(t.Shards) == 0 {
+		return nil, fmt.Errorf("routing table %s has no shards", path)
+	}
+	return &t, nil
+}
+
+// WriteRoutingTable replaces the routing table at path atomically: readers see either
+// the previous table or t, never part of it.
+func WriteRoutingTable(path string, t *RoutingTable) error {
+	return writeJSON(path, "routing table", t)
+}
+
+// ShardMap lists the searchers of the shards of a collection, numbered from 0, persisted
+// to a file that resharding replaces for the brokers to reload.
+type ShardMap struct {
+	Version    int        `json:"version"` // Incremented by every resharding
+	Tenant     string     `json:"tenant,omitempty"`
+	Collection string     `json:"collection"`
+	Shards     [][]string `json:"shards"` // Base URLs of the replicas of every shard
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ReadShardMap reads the shard map at path.
+func ReadShardMap(path string) (*ShardMap, error) {
+	var m ShardMap
+	if err := readJSON(path, "shard map", &m); err != nil {
+		return nil, err
+	}
+	if m.Collection == "" || len(m.Shards) == 0 {
+		return nil, fmt.Errorf("shard map %s has no collection or no shards", path)
+	}
+	for n, replicas := range m.Shards {
+		if len(replicas) == 0 {
+			return nil, fmt.Errorf("shard map %s has no searcher for shard %d", path, n)
+		}
+	}
+	return &m, nil
+}
+
+// WriteShardMap replaces the shard map at path atomically, like WriteRoutingTable.
+func WriteShardMap(path string, m *ShardMap) error {
+	return writeJSON(path, "shard map", m)
+}
+
+// readJSON decodes the file at path into v.
+func readJSON(path, what string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s %s: %w", what, path, err)
+	}
+	return nil
+}
+
+// writeJSON replaces the file at path with the JSON encoding of v atomically, through a
+// temporary file renamed over it.
+func writeJSON(path, what string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", what, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s %s: %w", what, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package shard
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFor(t *testing.T) {
+	counts := make([]int, 4)
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("doc-%d", i)
+		s := For(id, 4)
+		if s != For(id, 4) {
+			t.Fatalf("Expected the shard of %s to be stable", id)
+		}
+		counts[s]++
+	}
+	for s, n := range counts {
+		if n < 150 {
+			t.Errorf("Expected the documents to spread over the shards, shard %d got %d of 1000", s, n)
+		}
+	}
+}
+
+func TestNewTable(t *testing.T) {
+	table, err := NewTable(map[int]string{1: "http://b", 0: "http://a"})
+	if err != nil {
+		t.Fatalf("NewTable returned an error: %v", err)
+	}
+	if s, url := table.Lookup("doc-1"); url != table[s] || len(table) != 2 {
+		t.Errorf("Unexpected lookup %d %q in %v", s, url, table)
+	}
+	if _, err := NewTable(map[int]string{0: "http://a", 2: "http://c"}); err == nil {
+		t.Errorf("Expected an error for a gap in the shard IDs")
+	}
+	if _, err := NewTable(nil); err == nil {
+		t.Errorf("Expected an error for an empty table")
+	}
+}
+
+func TestRoutingTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routing.json")
+	if _, err := ReadRoutingTable(path); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing table to be reported, got %v", err)
+	}
+	if err := WriteRoutingTable(path, &RoutingTable{Version: 2, Shards: Table{"http://a", "http://b"}}); err != nil {
+		t.Fatalf("WriteRoutingTable returned an error: %v", err)
+	}
+	table, err := ReadRoutingTable(path)
+	if err != nil || table.Version != 2 || len(table.Shards) != 2 || table.Shards[1] != "http://b" {
+		t.Errorf("Unexpected routing table %+v, %v", table, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary file to be left, got %d entries", len(entries))
+	}
+}
+
+func TestShardMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "products.json")
+	m := &ShardMap{Version: 3, Collection: "products", Shards: [][]string{{"http://s-0a", "http://s-0b"}, {"http://s-1"}}}
+	if err := WriteShardMap(path, m); err != nil {
+		t.Fatalf("WriteShardMap returned an error: %v", err)
+	}
+	got, err := ReadShardMap(path)
+	if err != nil || got.Version != 3 || len(got.Shards) != 2 || got.Shards[0][1] != "http://s-0b" {
+		t.Errorf("Unexpected shard map %+v, %v", got, err)
+	}
+	if err := WriteShardMap(path, &ShardMap{Collection: "products", Shards: [][]string{{}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadShardMap(path); err == nil {
+		t.Error("Expected an error for a shard without searchers")
+	}
+}
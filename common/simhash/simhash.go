@@ -0,0 +1,69 @@
+// Package simhash fingerprints document text for near-duplicate detection. The Indexer
+// stores the SimHash of every document in Field; Brokers collapse results whose
+// fingerprints differ by only a few bits, such as copies of a page under several URLs.
+package simhash
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Field is the stored keyword field holding the fingerprint of a document, as formatted
+// by Format.
+const Field = "fingerprint"
+
+// Fingerprint computes the 64-bit SimHash of text: every word votes on each bit with the
+// bit of its hash, weighted by how often it occurs. Texts sharing most of their words get
+// fingerprints that differ by few bits. Case and punctuation are ignored; text without
+// words has the fingerprint 0.
+func Fingerprint(text string) uint64 {
+	counts := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		counts[word]++
+	}
+	var votes [64]int
+	for word, count := range counts {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				votes[bit] += count
+			} else {
+				votes[bit] -= count
+			}
+		}
+	}
+	var fingerprint uint64
+	for bit, v := range votes {
+		if v > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+// Distance returns the number of bits in which two fingerprints differ.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Format encodes a fingerprint as 16 hexadecimal digits.
+func Format(fingerprint uint64) string {
+	return fmt.Sprintf("%016x", fingerprint)
+}
+
+// Parse decodes a fingerprint encoded by Format.
+func Parse(s string) (uint64, error) {
+	fingerprint, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid fingerprint %q: %w", s, err)
+	}
+	return fingerprint, nil
+}
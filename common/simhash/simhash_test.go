@@ -0,0 +1,38 @@
+package simhash
+
+import "testing"
+
+func TestFingerprint_NearDuplicates(t *testing.T) {
+	page := "The quick brown fox jumps over the lazy dog while the farmer watches from the porch of the old red barn"
+	copied := "The quick brown fox jumps over the lazy dog, while the farmer watches from the porch of the old red barn!"
+	edited := "The quick brown fox jumps over the lazy dog while the farmer watches from the porch of the old blue barn"
+	other := "Stock markets rallied on Tuesday after the central bank left interest rates unchanged"
+
+	if d := Distance(Fingerprint(page), Fingerprint(copied)); d != 0 {
+		t.Errorf("Expected punctuation and case to be ignored, got a distance of %d", d)
+	}
+	near := Distance(Fingerprint(page), Fingerprint(edited))
+	far := Distance(Fingerprint(page), Fingerprint(other))
+	if near >= far || near > 10 {
+		t.Errorf("Expected an edited copy to be closer than another text, got %d and %d", near, far)
+	}
+	if Fingerprint("  ...  ") != 0 {
+		t.Error("Expected text without words to have the fingerprint 0")
+	}
+}
+
+func TestFormatParse(t *testing.T) {
+	for _, fp := range []uint64{0, 1, 0xdeadbeefcafef00d, ^uint64(0)} {
+		s := Format(fp)
+		if len(s) != 16 {
+			t.Errorf("Expected 16 digits, got %q", s)
+		}
+		got, err := Parse(s)
+		if err != nil || got != fp {
+			t.Errorf("Parse(%q) = %x, %v; want %x", s, got, err, fp)
+		}
+	}
+	if _, err := Parse("not-hex"); err == nil {
+		t.Error("Expected an error for an invalid fingerprint")
+	}
+}
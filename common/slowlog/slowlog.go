@@ -0,0 +1,221 @@
+// Package slowlog writes the queries slower than a latency threshold as JSON lines to a
+// dedicated log, either a file rotated by size or standard error.
+package slowlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxSizeMB is the size at which a slow query log file is rotated.
+	DefaultMaxSizeMB = 100
+	// DefaultMaxBackups is the number of rotated files kept.
+	DefaultMaxBackups = 5
+)
+
+// Config sets the threshold and destination of a slow query log. The tags let services
+// load it with common/config, from a "slow_query_log" section or the SLOW_QUERY_*
+// environment variables.
+type Config struct {
+	Threshold  time.Duration `yaml:"threshold" env:"SLOW_QUERY_THRESHOLD" flag:"slow-query-threshold" usage:"Queries taking at least this long are written to the slow query log; 0 disables it"`
+	File       string        `yaml:"file" env:"SLOW_QUERY_LOG" flag:"slow-query-log" usage:"Slow query log file, rotated by size; empty or - writes to standard error"`
+	MaxSizeMB  int           `yaml:"max_size_mb" env:"SLOW_QUERY_LOG_MAX_SIZE_MB" flag:"slow-query-log-max-size-mb" usage:"Size in MB at which the slow query log file is rotated"`
+	MaxBackups int           `yaml:"max_backups" env:"SLOW_QUERY_LOG_MAX_BACKUPS" flag:"slow-query-log-max-backups" usage:"Number of rotated slow query log files kept"`
+}
+
+// DefaultConfig returns a disabled slow query log that would write to standard error.
+func DefaultConfig() Config {
+	return Config{MaxSizeMB: DefaultMaxSizeMB, MaxBackups: DefaultMaxBackups}
+}
+
+// Enabled reports whether slow queries are logged.
+func (c Config) Enabled() bool {
+	return c.Threshold > 0
+}
+
+// Validate checks the configuration's values.
+func (c Config) Validate() error {
+	if c.Threshold < 0 {
+		return fmt.Errorf("invalid slow query threshold %s, must not be negative", c.Threshold)
+	}
+	if c.MaxSizeMB <= 0 {
+		return fmt.Errorf("invalid slow query log size %d MB, must be positive", c.MaxSizeMB)
+	}
+	if c.MaxBackups < 0 {
+		return fmt.Errorf("invalid number of slow query log backups %d, must not be negative", c.MaxBackups)
+	}
+	return nil
+}
+
+// Logger writes the records of slow queries as JSON lines. A nil Logger logs nothing.
+type Logger struct {
+	threshold time.Duration
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New opens the slow query log of cfg, or returns nil if it is disabled.
+func New(cfg Config) (*Logger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if cfg.File == "" || cfg.File == "-" {
+		return NewWriter(cfg.Threshold, os.Stderr), nil
+	}
+	file, err := OpenRotatingFile(cfg.File, int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(cfg.Threshold, file), nil
+}
+
+// NewWriter creates a logger writing the queries taking at least threshold to w.
+func NewWriter(threshold time.Duration, w io.Writer) *Logger {
+	return &Logger{threshold: threshold, w: w}
+}
+
+// Threshold returns the latency from which queries are logged.
+func (l *Logger) Threshold() time.Duration {
+	return l.threshold
+}
+
+// Slow reports whether a query that took took is to be logged.
+func (l *Logger) Slow(took time.Duration) bool {
+	return l != nil && took >= l.threshold
+}
+
+// Log writes record as a JSON line.
+func (l *Logger) Log(record interface{}) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode slow query record: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write slow query record: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log file, if the logger writes to one it opened.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f, ok := l.w.(*RotatingFile); ok {
+		return f.Close()
+	}
+	return nil
+}
+
+// RotatingFile is a file that is renamed to path.1 once writing to it would make it larger
+// than its maximum size; older files shift to path.2 and so on, up to the number of
+// backups kept.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens (or creates) path for appending.
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	if maxBytes <= 0 || maxBackups < 0 {
+		return nil, errors.New("rotating file size must be positive and backups not negative")
+	}
+	f := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the current file.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file %s: %w", f.path, err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would not fit. A single write larger than
+// the maximum size goes to a file of its own.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, moves the current file to path.1 and opens a new one.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file %s: %w", f.path, err)
+	}
+	f.file = nil
+	if f.maxBackups == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove log file %s: %w", f.path, err)
+		}
+		return f.open()
+	}
+	os.Remove(f.backup(f.maxBackups))
+	for n := f.maxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(f.backup(n), f.backup(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to rotate log file %s: %w", f.backup(n), err)
+		}
+	}
+	if err := os.Rename(f.path, f.backup(1)); err != nil {
+		return fmt.Errorf("failed to rotate log file %s: %w", f.path, err)
+	}
+	return f.open()
+}
+
+// backup returns the path of the nth most recent rotated file.
+func (f *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+// Close closes the current file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,73 @@
+package slowlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewWriter(100*time.Millisecond, &buf)
+	if l.Slow(99*time.Millisecond) || !l.Slow(100*time.Millisecond) {
+		t.Errorf("Expected queries from 100ms to be slow")
+	}
+	if err := l.Log(map[string]int{"took_ms": 120}); err != nil {
+		t.Fatalf("Log returned an error: %v", err)
+	}
+	var record map[string]int
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil || record["took_ms"] != 120 || !strings.HasSuffix(buf.String(), "\n") {
+		t.Errorf("Expected a JSON line, got %q", buf.String())
+	}
+
+	var disabled *Logger
+	if disabled.Slow(time.Hour) {
+		t.Errorf("Expected a nil logger to log nothing")
+	}
+	if l, err := New(DefaultConfig()); l != nil || err != nil {
+		t.Errorf("Expected no logger without a threshold, got %v %v", l, err)
+	}
+	if _, err := New(Config{Threshold: time.Second}); err == nil {
+		t.Errorf("Expected an error without a file size")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile returned an error: %v", err)
+	}
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write returned an error: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{path: "gggg\n", path + ".1": "eeee\nffff\n", path + ".2": "cccc\ndddd\n"} {
+		if got, _ := os.ReadFile(file); string(got) != want {
+			t.Errorf("Expected %s to hold %q, got %q", file, want, got)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups to be kept, got %v", err)
+	}
+
+	// Reopening appends to the current file and keeps counting its size.
+	f, err = OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("hhhh\n"))
+	f.Write([]byte("iiii\n"))
+	f.Close()
+	if got, _ := os.ReadFile(path + ".1"); string(got) != "gggg\nhhhh\n" {
+		t.Errorf("Expected the reopened file to be rotated when full, got %q", got)
+	}
+}
@@ -0,0 +1,227 @@
+// Package suggest implements the completion dictionary behind autocomplete. The Indexer
+// builds the dictionary from document titles and popular queries and ships it with every
+// uploaded segment as FileName; Searchers load it into an Index answering prefix lookups
+// from edge n-grams.
+package suggest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// FileName is the name of the dictionary file stored in an index segment.
+	FileName = "suggest.json"
+
+	// MaxPrefixLength is the longest prefix, in runes, indexed as an edge n-gram. Longer
+	// prefixes are answered by filtering the candidates of their first MaxPrefixLength runes.
+	MaxPrefixLength = 24
+	// MaxPerPrefix is the number of top-weighted entries kept for every prefix.
+	MaxPerPrefix = 20
+)
+
+// Entry is a completion with its weight, e.g. the number of documents carrying a title or
+// the number of times a query was searched.
+type Entry struct {
+	Text   string  `json:"text"`
+	Weight float64 `json:"weight"`
+}
+
+// dictionaryFile is the JSON layout of FileName.
+type dictionaryFile struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Normalize lowercases text and collapses its whitespace, as done for completions and
+// prefixes before they are compared.
+func Normalize(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// Builder aggregates completions; the weights of completions that normalize to the same
+// text are added up.
+type Builder struct {
+	weights map[string]float64
+	texts   map[string]string // First spelling added for every normalized text
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{weights: make(map[string]float64), texts: make(map[string]string)}
+}
+
+// Add records text with weight. Empty texts and non-positive weights are ignored.
+func (b *Builder) Add(text string, weight float64) {
+	key := Normalize(text)
+	if key == "" || weight <= 0 {
+		return
+	}
+	if _, ok := b.texts[key]; !ok {
+		b.texts[key] = strings.Join(strings.Fields(text), " ")
+	}
+	b.weights[key] += weight
+}
+
+// Entries returns the aggregated completions by descending weight.
+func (b *Builder) Entries() []Entry {
+	entries := make([]Entry, 0, len(b.weights))
+	for key, weight := range b.weights {
+		entries = append(entries, Entry{Text: b.texts[key], Weight: weight})
+	}
+	sortEntries(entries)
+	return entries
+}
+
+// WriteFile stores entries at path, replacing the file atomically.
+func WriteFile(path string, entries []Entry) error {
+	data, err := json.Marshal(dictionaryFile{Entries: entries})
+	if err != nil {
+		return fmt.Errorf("failed to marshal suggestions: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create suggestions file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write suggestions file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write suggestions file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace suggestions file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadFile loads the entries stored at path by WriteFile.
+func ReadFile(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read suggestions file %s: %w", path, err)
+	}
+	var file dictionaryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal suggestions file %s: %w", path, err)
+	}
+	return file.Entries, nil
+}
+
+// Suggestion is a completion returned for a prefix.
+type Suggestion struct {
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
+
+// Index answers prefix lookups over a set of completions. Every completion is indexed
+// under the edge n-grams starting at each of its words, so "run" completes both
+// "running shoes" and "red running shoes". Each n-gram keeps its MaxPerPrefix
+// top-weighted completions. An Index is immutable and safe for concurrent use.
+type Index struct {
+	entries  []Entry
+	keys     []string         // Normalized text of every entry
+	prefixes map[string][]int // Edge n-gram -> entries by descending weight
+}
+
+// NewIndex builds an Index over entries.
+func NewIndex(entries []Entry) *Index {
+	b := NewBuilder()
+	for _, e := range entries {
+		b.Add(e.Text, e.Weight)
+	}
+	idx := &Index{entries: b.Entries(), prefixes: make(map[string][]int)}
+	idx.keys = make([]string, len(idx.entries))
+	for i, e := range idx.entries {
+		key := Normalize(e.Text)
+		idx.keys[i] = key
+		for start := 0; start < len(key); start++ {
+			if start > 0 && key[start-1] != ' ' {
+				continue // Only word starts
+			}
+			idx.addPrefixes(key[start:], i)
+		}
+	}
+	return idx
+}
+
+// addPrefixes indexes entry i under the edge n-grams of text.
+func (idx *Index) addPrefixes(text string, i int) {
+	n := 0
+	for end := range text {
+		if end == 0 {
+			continue
+		}
+		if n++; n > MaxPrefixLength {
+			return
+		}
+		idx.addPrefix(text[:end], i)
+	}
+	if n < MaxPrefixLength {
+		idx.addPrefix(text, i)
+	}
+}
+
+func (idx *Index) addPrefix(prefix string, i int) {
+	list := idx.prefixes[prefix]
+	if len(list) >= MaxPerPrefix || (len(list) > 0 && list[len(list)-1] == i) {
+		return // Entries are added by descending weight, so the list is already complete
+	}
+	idx.prefixes[prefix] = append(list, i)
+}
+
+// Len returns the number of completions in the index.
+func (idx *Index) Len() int {
+	if idx == nil {
+		return 0
+	}
+	return len(idx.entries)
+}
+
+// Suggest returns up to size completions having a word starting with prefix, by
+// descending weight. A trailing space in prefix only matches completions continuing after
+// its last word.
+func (idx *Index) Suggest(prefix string, size int) []Suggestion {
+	if idx == nil || size <= 0 {
+		return nil
+	}
+	key := Normalize(prefix)
+	if key == "" {
+		return nil
+	}
+	if strings.HasSuffix(prefix, " ") {
+		key += " "
+	}
+
+	lookup := key
+	if utf8.RuneCountInString(lookup) > MaxPrefixLength {
+		lookup = string([]rune(lookup)[:MaxPrefixLength])
+	}
+	var suggestions []Suggestion
+	for _, i := range idx.prefixes[lookup] {
+		if lookup != key && !strings.HasPrefix(idx.keys[i], key) && !strings.Contains(idx.keys[i], " "+key) {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{Text: idx.entries[i].Text, Score: idx.entries[i].Weight})
+		if len(suggestions) == size {
+			break
+		}
+	}
+	return suggestions
+}
+
+// sortEntries orders entries by descending weight, then text.
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Weight != entries[j].Weight {
+			return entries[i].Weight > entries[j].Weight
+		}
+		return entries[i].Text < entries[j].Text
+	})
+}
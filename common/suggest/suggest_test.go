@@ -0,0 +1,103 @@
+package suggest
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuilder_AggregatesWeights(t *testing.T) {
+	b := NewBuilder()
+	b.Add("Red  Shoes", 1)
+	b.Add("red shoes", 2)
+	b.Add("boots", 5)
+	b.Add("   ", 1)
+	b.Add("ignored", 0)
+
+	want := []Entry{{Text: "boots", Weight: 5}, {Text: "Red Shoes", Weight: 3}}
+	if got := b.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected entries: got %+v, want %+v", got, want)
+	}
+}
+
+func TestIndex_Suggest(t *testing.T) {
+	idx := NewIndex([]Entry{
+		{Text: "Running Shoes", Weight: 10},
+		{Text: "red running shoes", Weight: 5},
+		{Text: "rain jacket", Weight: 7},
+		{Text: "red", Weight: 1},
+	})
+	if idx.Len() != 4 {
+		t.Fatalf("Expected 4 entries, got %d", idx.Len())
+	}
+
+	tests := []struct {
+		prefix string
+		size   int
+		want   []string
+	}{
+		{"r", 10, []string{"Running Shoes", "rain jacket", "red running shoes", "red"}},
+		{"R", 2, []string{"Running Shoes", "rain jacket"}},
+		{"run", 10, []string{"Running Shoes", "red running shoes"}},
+		{"red ", 10, []string{"red running shoes"}},
+		{"red  running sh", 10, []string{"red running shoes"}},
+		{"shoe", 10, []string{"Running Shoes", "red running shoes"}},
+		{"x", 10, nil},
+		{"", 10, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, s := range idx.Suggest(tt.prefix, tt.size) {
+			got = append(got, s.Text)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Suggest(%q): got %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+	if got := idx.Suggest("run", 1); len(got) != 1 || got[0].Score != 10 {
+		t.Errorf("Expected the top completion with its weight as score, got %+v", got)
+	}
+}
+
+func TestIndex_SuggestLongPrefix(t *testing.T) {
+	long := "extraordinarily comfortable waterproof hiking boots"
+	idx := NewIndex([]Entry{{Text: long, Weight: 1}, {Text: "extraordinarily comfortable waterproof sandals", Weight: 2}})
+
+	got := idx.Suggest(long[:len(long)-3], 10)
+	if len(got) != 1 || got[0].Text != long {
+		t.Errorf("Expected only %q for a prefix beyond %d runes, got %+v", long, MaxPrefixLength, got)
+	}
+	if got := idx.Suggest("comfortable waterproof hik", 10); len(got) != 1 || got[0].Text != long {
+		t.Errorf("Expected a long prefix to match at a word start, got %+v", got)
+	}
+}
+
+func TestIndex_MaxPerPrefix(t *testing.T) {
+	var entries []Entry
+	for i := 0; i < MaxPerPrefix+5; i++ {
+		entries = append(entries, Entry{Text: "item " + strings.Repeat("x", i+1), Weight: float64(i + 1)})
+	}
+	got := NewIndex(entries).Suggest("item", 100)
+	if len(got) != MaxPerPrefix || got[0].Score != float64(MaxPerPrefix+5) {
+		t.Errorf("Expected the %d heaviest completions, got %d starting with %+v", MaxPerPrefix, len(got), got[0])
+	}
+}
+
+func TestWriteAndReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	entries := []Entry{{Text: "boots", Weight: 2}, {Text: "shoes", Weight: 1}}
+	if err := WriteFile(path, entries); err != nil {
+		t.Fatalf("WriteFile returned an error: %v", err)
+	}
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("Unexpected entries: got %+v, want %+v", got, entries)
+	}
+	if _, err := ReadFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,207 @@
+// Package tenant identifies the tenant, i.e. the application, a request belongs to and
+// enforces per-tenant quotas, so one deployment can serve several applications in
+// isolation. Requests without a tenant belong to the default tenant, whose data keeps the
+// single-tenant layout.
+package tenant
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// Header carries the tenant ID of a request.
+	Header = "X-Tenant-ID"
+	// Param carries the tenant ID of a request that doesn't set Header.
+	Param = "tenant"
+	// Default is the tenant of requests that don't name one.
+	Default = ""
+
+	// storageRoot is the top-level storage prefix under which tenants keep their data.
+	storageRoot = "tenants"
+	// sweepInterval is how often the Limiter drops the buckets of idle tenants.
+	sweepInterval = time.Minute
+)
+
+var (
+	// ErrInvalidTenant is returned for malformed tenant IDs.
+	ErrInvalidTenant = errors.New("invalid tenant")
+	// ErrQuotaExceeded is returned when a tenant makes more requests than its quota allows.
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+)
+
+// idPattern restricts tenant IDs to characters that are safe in storage keys, file names
+// and URLs.
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Validate checks that id is a valid tenant ID. The default tenant is valid.
+func Validate(id string) error {
+	if id != Default && !idPattern.MatchString(id) {
+		return fmt.Errorf("%w %q: use up to 63 lowercase letters, digits, '_' and '-', starting with a letter or digit", ErrInvalidTenant, id)
+	}
+	return nil
+}
+
+// FromRequest returns the tenant of r, read from the Header header or else the Param
+// query parameter.
+func FromRequest(r *http.Request) (string, error) {
+	id := r.Header.Get(Header)
+	if id == "" {
+		id = r.URL.Query().Get(Param)
+	}
+	if err := Validate(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// StoragePrefix returns the key prefix, ending with a slash, under which the tenant's
+// segments and snapshots are stored. It is empty for the default tenant.
+func StoragePrefix(id string) string {
+	if id == Default {
+		return ""
+	}
+	return storageRoot + "/" + id + "/"
+}
+
+// Quota limits the request rate of a tenant with a token bucket.
+type Quota struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second" env:"TENANT_REQUESTS_PER_SECOND" flag:"tenant-requests-per-second" usage:"Requests per second allowed to each tenant; 0 is unlimited"`
+	Burst             int     `yaml:"burst" env:"TENANT_BURST" flag:"tenant-burst" usage:"Requests a tenant can make at once; 0 allows one second of requests"`
+}
+
+// QuotaConfig holds the quota of every tenant.
+type QuotaConfig struct {
+	Default Quota            `yaml:"default"` // Quota of tenants without an override
+	Tenants map[string]Quota `yaml:"tenants"` // Per-tenant overrides, by tenant ID
+}
+
+// Validate checks the quotas and the tenant IDs of the overrides.
+func (c QuotaConfig) Validate() error {
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default quota: %w", err)
+	}
+	for id, q := range c.Tenants {
+		if err := Validate(id); err != nil {
+			return err
+		}
+		if err := q.validate(); err != nil {
+			return fmt.Errorf("quota of tenant %q: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func (q Quota) validate() error {
+	if q.RequestsPerSecond < 0 || q.Burst < 0 {
+		return fmt.Errorf("requests_per_second and burst must not be negative")
+	}
+	return nil
+}
+
+// burst returns the bucket capacity.
+func (q Quota) burst() float64 {
+	if q.Burst > 0 {
+		return float64(q.Burst)
+	}
+	return math.Max(1, math.Ceil(q.RequestsPerSecond))
+}
+
+// bucket is the token bucket of one tenant.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter enforces the request quotas of the tenants. It is safe for concurrent use.
+// Buckets refilled since their tenant's last request are dropped, so tenant IDs seen once
+// don't accumulate.
+type Limiter struct {
+	config QuotaConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewLimiter returns a Limiter enforcing config.
+func NewLimiter(config QuotaConfig) (*Limiter, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Limiter{config: config, now: time.Now, buckets: make(map[string]*bucket)}, nil
+}
+
+// Allow takes one request from the quota of tenant. It returns an error wrapping
+// ErrQuotaExceeded if the tenant has none left. A nil Limiter allows every request.
+func (l *Limiter) Allow(id string) error {
+	if l == nil {
+		return nil
+	}
+	q := l.quota(id)
+	if q.RequestsPerSecond == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: q.burst(), last: now}
+		l.buckets[id] = b
+	}
+	b.tokens = math.Min(q.burst(), b.tokens+now.Sub(b.last).Seconds()*q.RequestsPerSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return fmt.Errorf("%w: tenant %q is limited to %g requests per second", ErrQuotaExceeded, id, q.RequestsPerSecond)
+	}
+	b.tokens--
+	return nil
+}
+
+// Inherit carries the buckets of prev, the Limiter l replaces, over to l, so that the
+// tenants keep the quota they have left; tokens beyond the bursts of l are dropped on the
+// next request. Nil Limiters inherit and pass on nothing.
+func (l *Limiter) Inherit(prev *Limiter) {
+	if l == nil || prev == nil {
+		return
+	}
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, b := range prev.buckets {
+		l.buckets[id] = &bucket{tokens: b.tokens, last: b.last}
+	}
+}
+
+// quota returns the quota of tenant id.
+func (l *Limiter) quota(id string) Quota {
+	if q, ok := l.config.Tenants[id]; ok {
+		return q
+	}
+	return l.config.Default
+}
+
+// sweep drops the buckets refilled since their last request, at most every sweepInterval;
+// a new bucket is full, so dropping them changes no quota. Callers must hold l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for id, b := range l.buckets {
+		q := l.quota(id)
+		if b.tokens+now.Sub(b.last).Seconds()*q.RequestsPerSecond >= q.burst() {
+			delete(l.buckets, id)
+		}
+	}
+}
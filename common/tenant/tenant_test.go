@@ -0,0 +1,128 @@
+package tenant
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/search?tenant=shop", nil)
+	if id, err := FromRequest(r); err != nil || id != "shop" {
+		t.Errorf("Expected the tenant from the query parameter, got %q (%v)", id, err)
+	}
+	r.Header.Set(Header, "blog")
+	if id, err := FromRequest(r); err != nil || id != "blog" {
+		t.Errorf("Expected the header to take precedence, got %q (%v)", id, err)
+	}
+	if id, err := FromRequest(httptest.NewRequest("GET", "/search", nil)); err != nil || id != Default {
+		t.Errorf("Expected the default tenant, got %q (%v)", id, err)
+	}
+	for _, id := range []string{"Shop", "../etc", "-shop", "a/b"} {
+		r := httptest.NewRequest("GET", "/search", nil)
+		r.Header.Set(Header, id)
+		if _, err := FromRequest(r); !errors.Is(err, ErrInvalidTenant) {
+			t.Errorf("Expected ErrInvalidTenant for %q, got %v", id, err)
+		}
+	}
+}
+
+func TestStoragePrefix(t *testing.T) {
+	if got := StoragePrefix(Default); got != "" {
+		t.Errorf("Expected no prefix for the default tenant, got %q", got)
+	}
+	if got := StoragePrefix("shop"); got != "tenants/shop/" {
+		t.Errorf("Expected tenants/shop/, got %q", got)
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	l, err := NewLimiter(QuotaConfig{
+		Default: Quota{RequestsPerSecond: 2},
+		Tenants: map[string]Quota{"big": {RequestsPerSecond: 0}, "bursty": {RequestsPerSecond: 1, Burst: 3}},
+	})
+	if err != nil {
+		t.Fatalf("NewLimiter returned an error: %v", err)
+	}
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+
+	// The default quota allows a burst of 2, then 2 requests per second.
+	for i := 0; i < 2; i++ {
+		if err := l.Allow("shop"); err != nil {
+			t.Fatalf("Request %d: expected to be allowed, got %v", i, err)
+		}
+	}
+	if err := l.Allow("shop"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	// Tenants have separate buckets.
+	if err := l.Allow("blog"); err != nil {
+		t.Errorf("Expected another tenant to be allowed, got %v", err)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if err := l.Allow("shop"); err != nil {
+		t.Errorf("Expected a request to be allowed after refill, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := l.Allow("bursty"); err != nil {
+			t.Errorf("Burst request %d: expected to be allowed, got %v", i, err)
+		}
+	}
+	if err := l.Allow("bursty"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded after the burst, got %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := l.Allow("big"); err != nil {
+			t.Fatalf("Expected an unlimited tenant, got %v", err)
+		}
+	}
+
+	// Idle tenants' buckets are dropped once refilled.
+	now = now.Add(2 * sweepInterval)
+	if err := l.Allow("shop"); err != nil {
+		t.Errorf("Expected a request to be allowed after a long pause, got %v", err)
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("Expected the idle buckets to be dropped, got %d buckets", len(l.buckets))
+	}
+
+	var nilLimiter *Limiter
+	if err := nilLimiter.Allow("shop"); err != nil {
+		t.Errorf("Expected a nil limiter to allow everything, got %v", err)
+	}
+}
+
+func TestLimiter_Inherit(t *testing.T) {
+	config := QuotaConfig{Default: Quota{RequestsPerSecond: 1}}
+	prev, _ := NewLimiter(config)
+	next, _ := NewLimiter(config)
+	now := time.Unix(0, 0)
+	prev.now = func() time.Time { return now }
+	next.now = prev.now
+
+	if err := prev.Allow("shop"); err != nil {
+		t.Fatalf("Expected the first request to be allowed, got %v", err)
+	}
+	next.Inherit(prev)
+	if err := next.Allow("shop"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected the quota left to carry over, got %v", err)
+	}
+	if err := next.Allow("other"); err != nil {
+		t.Errorf("Expected other tenants to keep their quota, got %v", err)
+	}
+}
+
+func TestQuotaConfig_Validate(t *testing.T) {
+	for _, c := range []QuotaConfig{
+		{Default: Quota{RequestsPerSecond: -1}},
+		{Tenants: map[string]Quota{"shop": {Burst: -1}}},
+		{Tenants: map[string]Quota{"Not Valid": {}}},
+	} {
+		if _, err := NewLimiter(c); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Package textnorm normalizes the Unicode forms of text so that variants of the same
+// word compare equal: "café" typed with a precomposed é or with e and a combining accent,
+// "ｃａｆé" in full-width letters and "cafe" all normalize to "cafe". Query
+// understanding normalizes queries with it and the indexer's folding analyzers
+// documents, so both sides of a search agree.
+package textnorm
+
+import (
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// Options selects the normalizations of Normalize; the result is always in NFC.
+type Options struct {
+	// Compatibility applies the compatibility decomposition (NFKD) rather than the
+	// canonical one (NFD), e.g. turning the ligature "ﬁ" into "fi" and "²" into "2".
+	Compatibility bool
+	// StripDiacritics removes the combining marks of decomposed letters, e.g. é→e.
+	StripDiacritics bool
+	// FoldWidth turns full-width letters, digits and punctuation into their ASCII forms
+	// and half-width katakana into full-width ones.
+	FoldWidth bool
+}
+
+// Fold is the normalization of the query understanding stage and the indexer's
+// analyzers: compatibility decomposition, diacritics stripped and widths folded.
+var Fold = Options{Compatibility: true, StripDiacritics: true, FoldWidth: true}
+
+// Normalize returns s with the normalizations of opts applied.
+func Normalize(s string, opts Options) string {
+	decompose := norm.NFD
+	if opts.Compatibility {
+		decompose = norm.NFKD
+	}
+	transformers := make([]transform.Transformer, 0, 4)
+	if opts.FoldWidth {
+		transformers = append(transformers, width.Fold)
+	}
+	transformers = append(transformers, decompose)
+	if opts.StripDiacritics {
+		transformers = append(transformers, runes.Remove(runes.In(unicode.Mn)))
+	}
+	transformers = append(transformers, norm.NFC)
+	out, _, err := transform.String(transform.Chain(transformers...), s)
+	if err != nil {
+		// The transformers only fail on invalid UTF-8, which is composed as is.
+		return norm.NFC.String(s)
+	}
+	return out
+}
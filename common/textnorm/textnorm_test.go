@@ -0,0 +1,27 @@
+package textnorm
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		opts Options
+		want string
+	}{
+		{"café", Fold, "cafe"},
+		{"café", Fold, "cafe"},
+		{"ｃａｆé １２", Fold, "cafe 12"},
+		{"Ｆｉｎｅ ﬁsh", Fold, "Fine fish"},
+		{"Ærøskøbing Straße", Fold, "Ærøskøbing Straße"},
+		{"ｶﾀｶﾅ", Fold, "カタカナ"},
+		// Without stripping, decomposed letters are recomposed.
+		{"café", Options{}, "café"},
+		{"ﬁ ｃ", Options{StripDiacritics: true}, "ﬁ ｃ"},
+		{"ﬁ ｃ", Options{Compatibility: true}, "fi c"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.in, tt.opts); got != tt.want {
+			t.Errorf("Normalize(%q, %+v) = %q, want %q", tt.in, tt.opts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package tlsconfig builds TLS configurations for the services' HTTP servers and clients,
+// including mutual TLS, from PEM files that are reloaded when they change on disk.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval is how often certificate files are checked for changes.
+const DefaultReloadInterval = 30 * time.Second
+
+// Config locates the PEM files a service uses for TLS. The tags let services load it
+// with common/config, from a "tls" section or the TLS_* environment variables.
+type Config struct {
+	CertFile       string        `yaml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert-file" usage:"PEM certificate served, and presented to peers for mTLS"`
+	KeyFile        string        `yaml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key-file" usage:"PEM private key of the certificate"`
+	CAFile         string        `yaml:"ca_file" env:"TLS_CA_FILE" flag:"tls-ca-file" usage:"PEM CA bundle used to verify peers; empty uses the system roots"`
+	ClientAuth     bool          `yaml:"client_auth" env:"TLS_CLIENT_AUTH" flag:"tls-client-auth" usage:"Require client certificates signed by the CA bundle (mTLS)"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL" flag:"tls-reload-interval" usage:"How often certificate files are checked for changes"`
+}
+
+// ServerEnabled reports whether servers should terminate TLS.
+func (c Config) ServerEnabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// ClientEnabled reports whether clients need a custom TLS configuration, either to
+// trust a private CA or to present a client certificate.
+func (c Config) ClientEnabled() bool {
+	return c.CAFile != "" || c.ServerEnabled()
+}
+
+// Validate checks that the configuration is consistent.
+func (c Config) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("TLS certificate and key must be set together")
+	}
+	if c.ClientAuth && (c.CAFile == "" || !c.ServerEnabled()) {
+		return errors.New("client certificate verification requires a certificate, a key and a CA bundle")
+	}
+	return nil
+}
+
+// Reloader holds the certificate and CA pool loaded from a Config and reloads them when
+// the files change, so certificates can be rotated without restarting the service. Files
+// are checked at most once per reload interval, during TLS handshakes.
+type Reloader struct {
+	cfg Config
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+}
+
+// NewReloader loads the files of cfg.
+func NewReloader(cfg Config) (*Reloader, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = DefaultReloadInterval
+	}
+	r := &Reloader{cfg: cfg}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files returns the paths watched for changes.
+func (r *Reloader) files() []string {
+	var files []string
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// load reads the certificate and CA bundle. Callers must hold r.mu or own r exclusively.
+func (r *Reloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", f, err)
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	var cert *tls.Certificate
+	if r.cfg.ServerEnabled() {
+		c, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS key pair %s: %w", r.cfg.CertFile, err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.cfg.CAFile != "" {
+		pem, err := os.ReadFile(r.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle %s: %w", r.cfg.CAFile, err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", r.cfg.CAFile)
+		}
+	}
+
+	r.cert, r.pool, r.modTimes = cert, pool, modTimes
+	r.lastCheck = time.Now()
+	return nil
+}
+
+// current returns the certificate and CA pool, reloading them first if a file changed.
+// A failed reload is logged and the previous files stay in use.
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.lastCheck) >= r.cfg.ReloadInterval {
+		r.lastCheck = time.Now()
+		if r.changed() {
+			if err := r.load(); err != nil {
+				slog.Error("Failed to reload TLS files, keeping the previous ones", "error", err)
+			} else {
+				slog.Info("Reloaded TLS certificate", "path", r.cfg.CertFile)
+			}
+		}
+	}
+	return r.cert, r.pool
+}
+
+// changed reports whether a watched file was modified since it was loaded. Callers must hold r.mu.
+func (r *Reloader) changed() bool {
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil || !info.ModTime().Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerTLSConfig returns a server configuration serving the current certificate and,
+// with ClientAuth, requiring client certificates signed by the current CA bundle.
+func (r *Reloader) ServerTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			if cert == nil {
+				return nil, errors.New("no server certificate configured")
+			}
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if r.cfg.ClientAuth {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = pool
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientTLSConfig returns a client configuration presenting the current certificate, if
+// any, and verifying servers against the current CA bundle, or the system roots if none.
+func (r *Reloader) ClientTLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, _ := r.current(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil // No client certificate
+		},
+	}
+	if r.cfg.CAFile != "" {
+		// The CA bundle can change at runtime, so the built-in verification, which uses a
+		// fixed RootCAs pool, is replaced by verifyServer against the current pool.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = r.verifyServer
+	}
+	return cfg
+}
+
+// verifyServer verifies the server's certificate chain and host name against the current CA pool.
+func (r *Reloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	_, pool := r.current()
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return fmt.Errorf("failed to verify server certificate: %w", err)
+	}
+	return nil
+}
+
+// ClientTransport returns an HTTP transport using cfg for TLS, or nil if cfg doesn't
+// need a custom client configuration, in which case callers use their default transport.
+func ClientTransport(cfg Config) (*http.Transport, error) {
+	if !cfg.ClientEnabled() {
+		return nil, nil
+	}
+	r, err := NewReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = r.ClientTLSConfig()
+	return transport, nil
+}
+
+// NewServer returns an HTTP server for addr and handler, with TLS configured from cfg
+// if it has a certificate. Start it with ListenAndServe when TLSConfig is nil, and with
+// ListenAndServeTLS("", "") otherwise; graceful.Serve does either.
+func NewServer(addr string, handler http.Handler, cfg Config) (*http.Server, error) {
+	server := &http.Server{Addr: addr, Handler: handler}
+	if !cfg.ServerEnabled() {
+		return server, nil
+	}
+	r, err := NewReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+	server.TLSConfig = r.ServerTLSConfig()
+	return server, nil
+}
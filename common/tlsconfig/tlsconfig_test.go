@@ -0,0 +1,202 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate for 127.0.0.1 with the given serial number to dir and
+// returns the paths of the certificate and key.
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// newMTLSServer starts a server requiring client certificates from ca.
+func newMTLSServer(t *testing.T, dir string, ca *testCA, interval time.Duration) (*httptest.Server, string, string) {
+	t.Helper()
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+	certFile, keyFile := ca.issue(t, dir, "server", 10)
+	reloader, err := NewReloader(Config{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ClientAuth: true, ReloadInterval: interval})
+	if err != nil {
+		t.Fatalf("NewReloader returned an error: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = reloader.ServerTLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, caFile, certFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	server, caFile, _ := newMTLSServer(t, dir, ca, time.Hour)
+
+	certFile, keyFile := ca.issue(t, dir, "broker", 20)
+	transport, err := ClientTransport(Config{CertFile: certFile, KeyFile: keyFile, CAFile: caFile})
+	if err != nil {
+		t.Fatalf("ClientTransport returned an error: %v", err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("mTLS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	// Without a client certificate the server rejects the handshake.
+	anonymous, err := ClientTransport(Config{CAFile: caFile})
+	if err != nil {
+		t.Fatalf("ClientTransport returned an error: %v", err)
+	}
+	if resp, err := (&http.Client{Transport: anonymous}).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Error("Expected the request without a client certificate to fail")
+	}
+
+	// A client trusting another CA rejects the server.
+	otherCA := filepath.Join(dir, "other-ca.crt")
+	writeFile(t, otherCA, newTestCA(t).pem)
+	untrusting, err := ClientTransport(Config{CertFile: certFile, KeyFile: keyFile, CAFile: otherCA})
+	if err != nil {
+		t.Fatalf("ClientTransport returned an error: %v", err)
+	}
+	if resp, err := (&http.Client{Transport: untrusting}).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Error("Expected the request to a server signed by an unknown CA to fail")
+	}
+}
+
+func TestReloader_ReloadsChangedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	server, caFile, serverCert := newMTLSServer(t, dir, ca, time.Nanosecond)
+	certFile, keyFile := ca.issue(t, dir, "client", 20)
+	transport, err := ClientTransport(Config{CertFile: certFile, KeyFile: keyFile, CAFile: caFile})
+	if err != nil {
+		t.Fatalf("ClientTransport returned an error: %v", err)
+	}
+	// Every check makes a new handshake, rather than reusing a connection made with the
+	// previous certificate.
+	transport.DisableKeepAlives = true
+
+	serial := func() int64 {
+		t.Helper()
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 10 {
+		t.Fatalf("Expected server certificate serial 10, got %d", got)
+	}
+
+	// Rotate the server certificate in place; make sure the modification time changes.
+	ca.issue(t, dir, "server", 11)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(serverCert, future, future)
+	if got := serial(); got != 11 {
+		t.Errorf("Expected the rotated certificate with serial 11, got %d", got)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	invalid := []Config{
+		{CertFile: "tls.crt"},
+		{KeyFile: "tls.key"},
+		{CertFile: "tls.crt", KeyFile: "tls.key", ClientAuth: true},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", cfg)
+		}
+	}
+	if transport, err := ClientTransport(Config{}); err != nil || transport != nil {
+		t.Errorf("Expected no transport without TLS files, got %v (%v)", transport, err)
+	}
+	if _, err := NewReloader(Config{CAFile: filepath.Join(t.TempDir(), "missing.crt")}); err == nil {
+		t.Error("Expected an error for a missing CA bundle")
+	}
+}
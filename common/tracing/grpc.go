@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+
+	"common/logging"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// metadataCarrier adapts gRPC metadata to a propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// UnaryServerInterceptor is the gRPC counterpart of Middleware: incoming trace context is
+// extracted from the request metadata and a server span named after the method is started.
+// The context carries the request ID of the metadata too, or a new one if it has none.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md.Copy()))
+		id := metadataCarrier(md).Get(logging.RequestIDHeader)
+		if id == "" {
+			id = logging.NewRequestID()
+		}
+		ctx = logging.WithRequestID(ctx, id)
+		ctx, span := otel.Tracer("grpc").Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		resp, err := handler(ctx, req)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, status.Convert(err).Message())
+		}
+		return resp, err
+	}
+}
+
+// UnaryClientInterceptor is the gRPC counterpart of Transport: outgoing requests carry the
+// trace context and request ID of their context in their metadata.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+		if id := logging.RequestID(ctx); id != "" && len(md.Get(logging.RequestIDHeader)) == 0 {
+			md.Set(logging.RequestIDHeader, id)
+		}
+		return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,163 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone disables span export. Trace context is still propagated across services.
+	ExporterNone = "none"
+	// ExporterOTLP exports spans to an OTLP/HTTP collector.
+	ExporterOTLP = "otlp"
+	// ExporterJaeger exports spans to Jaeger through its native OTLP/HTTP receiver.
+	ExporterJaeger = "jaeger"
+	// ExporterStdout writes spans to stdout, useful for local debugging.
+	ExporterStdout = "stdout"
+
+	defaultOTLPEndpoint   = "localhost:4318"
+	defaultJaegerEndpoint = "localhost:4318" // Jaeger's OTLP/HTTP receiver
+)
+
+// Config controls how a service exports its spans.
+type Config struct {
+	ServiceName string
+	Exporter    string  // One of ExporterNone, ExporterOTLP, ExporterJaeger or ExporterStdout
+	Endpoint    string  // Collector host:port; defaults depend on the exporter
+	Insecure    bool    // Use plain HTTP to talk to the collector
+	SampleRatio float64 // Fraction of root spans to sample, between 0 and 1
+}
+
+// ConfigFromEnv builds a Config from the standard OTEL_* environment variables:
+// OTEL_TRACES_EXPORTER, OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_INSECURE
+// and OTEL_TRACES_SAMPLER_ARG. OTEL_SERVICE_NAME overrides serviceName if set.
+func ConfigFromEnv(serviceName string) Config {
+	cfg := Config{
+		ServiceName: serviceName,
+		Exporter:    ExporterNone,
+		Endpoint:    os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		Insecure:    true,
+		SampleRatio: 1.0,
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		cfg.ServiceName = name
+	}
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter != "" {
+		cfg.Exporter = strings.ToLower(exporter)
+	}
+	if insecure := os.Getenv("OTEL_EXPORTER_OTLP_INSECURE"); insecure != "" {
+		if v, err := strconv.ParseBool(insecure); err == nil {
+			cfg.Insecure = v
+		}
+	}
+	if ratio := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); ratio != "" {
+		if v, err := strconv.ParseFloat(ratio, 64); err == nil {
+			cfg.SampleRatio = v
+		}
+	}
+	return cfg
+}
+
+// Init installs a global TracerProvider and W3C trace-context propagator for the service.
+// The returned shutdown function flushes any buffered spans and must be called on exit.
+func Init(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	// Always install the propagator so trace context flows through this service
+	// even when it doesn't export spans itself.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newExporter creates the span exporter selected by cfg.Exporter, or nil for ExporterNone.
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		return exporter, nil
+	case ExporterOTLP, ExporterJaeger:
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+			if cfg.Exporter == ExporterJaeger {
+				endpoint = defaultJaegerEndpoint
+			}
+		}
+		// The OTLP exporter expects host:port, so tolerate a full URL in the environment.
+		endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s exporter for %s: %w", cfg.Exporter, endpoint, err)
+		}
+		return exporter, nil
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q", cfg.Exporter)
+	}
+}
+
+// Tracer returns a named tracer from the global TracerProvider.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Middleware wraps an HTTP handler so that incoming trace context is extracted from
+// request headers and a server span named after the operation is started.
+func Middleware(handler http.Handler, operation string) http.Handler {
+	return otelhttp.NewHandler(handler, operation)
+}
+
+// Transport wraps an http.RoundTripper so that outgoing requests carry the trace
+// context of the request's context in their headers. A nil base uses http.DefaultTransport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base)
+}
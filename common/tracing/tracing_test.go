@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "JAEGER")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "collector:4318")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0.25")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+
+	cfg := ConfigFromEnv("broker")
+	if cfg.ServiceName != "broker" {
+		t.Errorf("Expected service name 'broker', got %q", cfg.ServiceName)
+	}
+	if cfg.Exporter != ExporterJaeger {
+		t.Errorf("Expected exporter %q, got %q", ExporterJaeger, cfg.Exporter)
+	}
+	if cfg.Endpoint != "collector:4318" {
+		t.Errorf("Expected endpoint 'collector:4318', got %q", cfg.Endpoint)
+	}
+	if cfg.SampleRatio != 0.25 {
+		t.Errorf("Expected sample ratio 0.25, got %f", cfg.SampleRatio)
+	}
+}
+
+func TestInit_UnsupportedExporter(t *testing.T) {
+	_, err := Init(context.Background(), Config{ServiceName: "test", Exporter: "carrier-pigeon"})
+	if err == nil {
+		t.Fatal("Expected an error for an unsupported exporter, got nil")
+	}
+}
+
+func TestInit_NoneExporter(t *testing.T) {
+	shutdown, err := Init(context.Background(), Config{ServiceName: "test", Exporter: ExporterNone})
+	if err != nil {
+		t.Fatalf("Init returned an error: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown returned an error: %v", err)
+	}
+}
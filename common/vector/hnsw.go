@@ -0,0 +1,239 @@
+package vector
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Defaults of the HNSW graph parameters.
+const (
+	DefaultM              = 16  // Neighbors linked to every node above layer 0, twice as many on it
+	DefaultEfConstruction = 100 // Candidates considered when linking a new node
+)
+
+// Neighbor is a vector found by a search and its similarity to the query.
+type Neighbor struct {
+	ID    string
+	Score float64
+}
+
+// HNSW is a Hierarchical Navigable Small World graph (Malkov and Yashunin, 2016): an
+// approximate nearest neighbor index whose searches visit a small part of the vectors,
+// descending from sparse upper layers linking distant vectors to layer 0 linking every
+// vector to its nearest ones. It is safe for concurrent use.
+type HNSW struct {
+	mu             sync.RWMutex
+	similarity     string
+	m              int
+	efConstruction int
+	levelFactor    float64
+	rng            *rand.Rand
+	nodes          []hnswNode
+	ids            map[string]int32 // Node by ID
+	entry          int32            // Node of the top layer searches start from; -1 when empty
+	maxLevel       int
+}
+
+type hnswNode struct {
+	id     string
+	vector []float32
+	links  [][]int32 // Neighbors by layer, from 0 to the level of the node
+}
+
+// NewHNSW creates an empty graph comparing vectors by similarity. m and efConstruction
+// trade build time and memory for recall; zero values use DefaultM and
+// DefaultEfConstruction.
+func NewHNSW(similarity string, m, efConstruction int) *HNSW {
+	if m <= 1 {
+		m = DefaultM
+	}
+	if efConstruction <= 0 {
+		efConstruction = DefaultEfConstruction
+	}
+	return &HNSW{
+		similarity:     similarity,
+		m:              m,
+		efConstruction: efConstruction,
+		levelFactor:    1 / math.Log(float64(m)),
+		rng:            rand.New(rand.NewSource(1)), // Deterministic graphs ease debugging
+		ids:            make(map[string]int32),
+		entry:          -1,
+	}
+}
+
+// Len returns the number of vectors in the graph.
+func (h *HNSW) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.nodes)
+}
+
+// Add inserts the vector of a document. Vectors already added under id are left as they
+// are: graphs are rebuilt rather than updated.
+func (h *HNSW) Add(id string, v []float32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.ids[id]; ok {
+		return
+	}
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelFactor)
+	n := int32(len(h.nodes))
+	h.nodes = append(h.nodes, hnswNode{id: id, vector: v, links: make([][]int32, level+1)})
+	h.ids[id] = n
+	if h.entry < 0 {
+		h.entry, h.maxLevel = n, level
+		return
+	}
+
+	entry := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		entry = h.closest(v, entry, l)
+	}
+	entries := []int32{entry}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(v, entries, h.efConstruction, l)
+		neighbors := candidates
+		if len(neighbors) > h.m {
+			neighbors = neighbors[:h.m]
+		}
+		for _, c := range neighbors {
+			h.nodes[n].links[l] = append(h.nodes[n].links[l], c.node)
+			h.link(c.node, n, l)
+		}
+		entries = entries[:0]
+		for _, c := range candidates {
+			entries = append(entries, c.node)
+		}
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = n, level
+	}
+}
+
+// link adds to from a link to to on layer l, dropping its farthest link if it has more
+// than the layer allows.
+func (h *HNSW) link(from, to int32, l int) {
+	links := append(h.nodes[from].links[l], to)
+	limit := h.m
+	if l == 0 {
+		limit = 2 * h.m
+	}
+	if len(links) > limit {
+		origin := h.nodes[from].vector
+		sort.Slice(links, func(i, j int) bool {
+			return h.score(origin, links[i]) > h.score(origin, links[j])
+		})
+		links = links[:limit]
+	}
+	h.nodes[from].links[l] = links
+}
+
+// Search returns the k vectors closest to q, best first. ef, the number of candidates
+// kept while searching, raises recall above k; it is at least k.
+func (h *HNSW) Search(q []float32, k, ef int) []Neighbor {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.entry < 0 || k <= 0 {
+		return nil
+	}
+	if ef < k {
+		ef = k
+	}
+	entry := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		entry = h.closest(q, entry, l)
+	}
+	candidates := h.searchLayer(q, []int32{entry}, ef, 0)
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	out := make([]Neighbor, len(candidates))
+	for i, c := range candidates {
+		out[i] = Neighbor{ID: h.nodes[c.node].id, Score: c.score}
+	}
+	return out
+}
+
+// closest greedily walks layer l from entry to the node closest to q.
+func (h *HNSW) closest(q []float32, entry int32, l int) int32 {
+	best, bestScore := entry, h.score(q, entry)
+	for improved := true; improved; {
+		improved = false
+		for _, n := range h.nodes[best].links[l] {
+			if s := h.score(q, n); s > bestScore {
+				best, bestScore, improved = n, s, true
+			}
+		}
+	}
+	return best
+}
+
+// searchLayer returns the ef nodes of layer l closest to q found from entries, best first.
+func (h *HNSW) searchLayer(q []float32, entries []int32, ef int, l int) []scored {
+	visited := make(map[int32]bool, ef*4)
+	candidates := &scoredHeap{best: true}
+	results := &scoredHeap{}
+	for _, e := range entries {
+		visited[e] = true
+		c := scored{e, h.score(q, e)}
+		heap.Push(candidates, c)
+		heap.Push(results, c)
+	}
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(scored)
+		if results.Len() >= ef && c.score < results.items[0].score {
+			break // Every remaining candidate is worse than the worst result
+		}
+		for _, n := range h.nodes[c.node].links[l] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+			s := h.score(q, n)
+			if results.Len() < ef || s > results.items[0].score {
+				heap.Push(candidates, scored{n, s})
+				heap.Push(results, scored{n, s})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	out := results.items
+	sort.Slice(out, func(i, j int) bool { return out[i].score > out[j].score })
+	return out
+}
+
+func (h *HNSW) score(q []float32, n int32) float64 {
+	return Score(h.similarity, q, h.nodes[n].vector)
+}
+
+// scored is a node and its similarity to the query.
+type scored struct {
+	node  int32
+	score float64
+}
+
+// scoredHeap pops the best node first if best is set, the worst otherwise.
+type scoredHeap struct {
+	items []scored
+	best  bool
+}
+
+func (s *scoredHeap) Len() int { return len(s.items) }
+func (s *scoredHeap) Less(i, j int) bool {
+	if s.best {
+		return s.items[i].score > s.items[j].score
+	}
+	return s.items[i].score < s.items[j].score
+}
+func (s *scoredHeap) Swap(i, j int)      { s.items[i], s.items[j] = s.items[j], s.items[i] }
+func (s *scoredHeap) Push(x interface{}) { s.items = append(s.items, x.(scored)) }
+func (s *scoredHeap) Pop() interface{} {
+	last := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return last
+}
@@ -0,0 +1,152 @@
+// Package vector holds dense vectors, e.g. text embeddings, and finds the nearest
+// neighbors of a query vector. The Indexer validates the vector fields of documents
+// against their Field; Searchers index them in an HNSW graph for kNN queries.
+package vector
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Similarities, named after those of Elasticsearch. Scores are non-negative and higher for
+// closer vectors.
+const (
+	Cosine     = "cosine"      // (1 + cos θ) / 2; vectors must not be zero
+	DotProduct = "dot_product" // (1 + a·b) / 2; vectors should be normalized to unit length
+	L2Norm     = "l2_norm"     // 1 / (1 + |a - b|²)
+)
+
+// MaxDims bounds the dimensions of vectors.
+const MaxDims = 4096
+
+// ErrInvalidVector is wrapped by the errors of vectors that don't fit their field.
+var ErrInvalidVector = errors.New("invalid vector")
+
+// Field describes a vector field, e.g. in YAML:
+//
+//	embedding:
+//	  dims: 384
+//	  similarity: cosine
+type Field struct {
+	Dims       int    `yaml:"dims" json:"dims"`             // Dimensions of every vector; 0 accepts any
+	Similarity string `yaml:"similarity" json:"similarity"` // Defaults to Cosine
+}
+
+// Validate checks the dimensions and similarity of the field, defaulting the latter.
+func (f *Field) Validate() error {
+	if f.Dims < 0 || f.Dims > MaxDims {
+		return fmt.Errorf("dims %d must be between 0, for any, and %d", f.Dims, MaxDims)
+	}
+	switch f.Similarity {
+	case "":
+		f.Similarity = Cosine
+	case Cosine, DotProduct, L2Norm:
+	default:
+		return fmt.Errorf("unsupported similarity %q, must be %s, %s or %s", f.Similarity, Cosine, DotProduct, L2Norm)
+	}
+	return nil
+}
+
+// Check returns an error wrapping ErrInvalidVector if v doesn't have the dimensions of the
+// field, holds a NaN or infinite value, or is zero under Cosine.
+func (f Field) Check(v []float32) error {
+	if len(v) == 0 {
+		return fmt.Errorf("%w: empty vector", ErrInvalidVector)
+	}
+	if f.Dims > 0 && len(v) != f.Dims {
+		return fmt.Errorf("%w: %d dimensions, expected %d", ErrInvalidVector, len(v), f.Dims)
+	}
+	if len(v) > MaxDims {
+		return fmt.Errorf("%w: %d dimensions, at most %d are supported", ErrInvalidVector, len(v), MaxDims)
+	}
+	zero := true
+	for _, x := range v {
+		if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+			return fmt.Errorf("%w: %v is not a finite number", ErrInvalidVector, x)
+		}
+		zero = zero && x == 0
+	}
+	if zero && (f.Similarity == Cosine || f.Similarity == "") {
+		return fmt.Errorf("%w: the zero vector has no cosine similarity", ErrInvalidVector)
+	}
+	return nil
+}
+
+// FromValue converts the JSON form of a vector, an array of numbers, into a vector.
+func FromValue(value interface{}) ([]float32, error) {
+	values, ok := value.([]interface{})
+	if !ok {
+		if v, ok := value.([]float64); ok {
+			out := make([]float32, len(v))
+			for i, x := range v {
+				out[i] = float32(x)
+			}
+			return out, nil
+		}
+		return nil, fmt.Errorf("%w: expected an array of numbers, got %T", ErrInvalidVector, value)
+	}
+	out := make([]float32, len(values))
+	for i, x := range values {
+		n, ok := x.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%w: element %d is %T, expected a number", ErrInvalidVector, i, x)
+		}
+		out[i] = float32(n)
+	}
+	return out, nil
+}
+
+// Parse reads a vector formatted by Format: comma-separated numbers.
+func Parse(s string) ([]float32, error) {
+	parts := strings.Split(s, ",")
+	out := make([]float32, 0, len(parts))
+	for _, p := range parts {
+		x, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a number", ErrInvalidVector, p)
+		}
+		out = append(out, float32(x))
+	}
+	return out, nil
+}
+
+// Format formats a vector as comma-separated numbers.
+func Format(v []float32) string {
+	parts := make([]string, len(v))
+	for i, x := range v {
+		parts[i] = strconv.FormatFloat(float64(x), 'g', -1, 32)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Score returns the similarity of a and b, which must have the same dimensions.
+func Score(similarity string, a, b []float32) float64 {
+	switch similarity {
+	case DotProduct:
+		return (1 + dot(a, b)) / 2
+	case L2Norm:
+		var sum float64
+		for i := range a {
+			d := float64(a[i]) - float64(b[i])
+			sum += d * d
+		}
+		return 1 / (1 + sum)
+	default:
+		na, nb := math.Sqrt(dot(a, a)), math.Sqrt(dot(b, b))
+		if na == 0 || nb == 0 {
+			return 0
+		}
+		return (1 + dot(a, b)/(na*nb)) / 2
+	}
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,131 @@
+package vector
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	a, b, c := []float32{1, 0}, []float32{0, 1}, []float32{-1, 0}
+	cases := []struct {
+		similarity string
+		x, y       []float32
+		want       float64
+	}{
+		{Cosine, a, a, 1},
+		{Cosine, a, b, 0.5},
+		{Cosine, a, c, 0},
+		{Cosine, []float32{2, 0}, a, 1},
+		{DotProduct, a, a, 1},
+		{DotProduct, a, b, 0.5},
+		{L2Norm, a, a, 1},
+		{L2Norm, a, b, 1.0 / 3},
+	}
+	for _, tc := range cases {
+		if got := Score(tc.similarity, tc.x, tc.y); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("Score(%s, %v, %v) = %g, want %g", tc.similarity, tc.x, tc.y, got, tc.want)
+		}
+	}
+}
+
+func TestFieldCheck(t *testing.T) {
+	f := Field{Dims: 3}
+	if err := f.Validate(); err != nil || f.Similarity != Cosine {
+		t.Fatalf("Expected the similarity to default to cosine, got %q, %v", f.Similarity, err)
+	}
+	if err := f.Check([]float32{1, 2, 3}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, v := range [][]float32{nil, {1, 2}, {0, 0, 0}, {1, float32(math.NaN()), 2}} {
+		if err := f.Check(v); !errors.Is(err, ErrInvalidVector) {
+			t.Errorf("Check(%v) = %v, want ErrInvalidVector", v, err)
+		}
+	}
+	if err := (&Field{Similarity: "hamming"}).Validate(); err == nil {
+		t.Error("Expected an error for an unsupported similarity")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	v := []float32{0.5, -1, 3.25}
+	got, err := Parse(Format(v))
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(v) {
+		t.Errorf("Parse(Format(%v)) = %v, %v", v, got, err)
+	}
+	if _, err := Parse("1,x"); !errors.Is(err, ErrInvalidVector) {
+		t.Errorf("Expected ErrInvalidVector, got %v", err)
+	}
+	if v, err := FromValue([]interface{}{1.0, 2.5}); err != nil || fmt.Sprint(v) != "[1 2.5]" {
+		t.Errorf("FromValue = %v, %v", v, err)
+	}
+	if _, err := FromValue("1,2"); !errors.Is(err, ErrInvalidVector) {
+		t.Errorf("Expected ErrInvalidVector, got %v", err)
+	}
+}
+
+func TestHNSW_Recall(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	const n, dims, k = 2000, 16, 10
+	vectors := make([][]float32, n)
+	h := NewHNSW(Cosine, 0, 0)
+	for i := range vectors {
+		v := make([]float32, dims)
+		for j := range v {
+			v[j] = float32(rng.NormFloat64())
+		}
+		vectors[i] = v
+		h.Add(fmt.Sprint(i), v)
+	}
+	if h.Len() != n {
+		t.Fatalf("Expected %d vectors, got %d", n, h.Len())
+	}
+
+	found, total := 0, 0
+	for q := 0; q < 20; q++ {
+		query := vectors[rng.Intn(n)]
+		exact := make([]Neighbor, n)
+		for i, v := range vectors {
+			exact[i] = Neighbor{ID: fmt.Sprint(i), Score: Score(Cosine, query, v)}
+		}
+		sort.Slice(exact, func(i, j int) bool { return exact[i].Score > exact[j].Score })
+		want := make(map[string]bool, k)
+		for _, nb := range exact[:k] {
+			want[nb.ID] = true
+		}
+
+		got := h.Search(query, k, 100)
+		if len(got) != k {
+			t.Fatalf("Expected %d neighbors, got %d", k, len(got))
+		}
+		for i, nb := range got {
+			if i > 0 && nb.Score > got[i-1].Score {
+				t.Fatalf("Expected neighbors sorted by score, got %v", got)
+			}
+			if want[nb.ID] {
+				found++
+			}
+		}
+		total += k
+	}
+	if recall := float64(found) / float64(total); recall < 0.9 {
+		t.Errorf("Expected a recall of at least 0.9, got %.2f", recall)
+	}
+}
+
+func TestHNSW_Empty(t *testing.T) {
+	h := NewHNSW(L2Norm, 0, 0)
+	if got := h.Search([]float32{1}, 3, 0); got != nil {
+		t.Errorf("Expected no neighbors, got %v", got)
+	}
+	h.Add("a", []float32{1})
+	h.Add("a", []float32{5})
+	h.Add("b", []float32{3})
+	got := h.Search([]float32{4}, 3, 0)
+	if len(got) != 2 || got[0].ID != "b" || got[0].Score != 0.5 {
+		t.Errorf("Expected b then a, got %v", got)
+	}
+}
@@ -0,0 +1,463 @@
+// Package control_plane operates a search cluster through the admin APIs of its services:
+// the broker, the indexer, the searchers and query understanding. It backs the searchctl
+// command.
+package control_plane
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/commitbus"
+	"common/tenant"
+)
+
+// ErrNoService is returned when a command needs the URL of a service that wasn't given.
+var ErrNoService = errors.New("service URL not set")
+
+// Client calls the admin APIs of the services of a cluster. Empty URLs leave out the
+// service: commands needing it fail with ErrNoService, and dumps skip its settings.
+type Client struct {
+	Broker     string
+	Indexer    string
+	Searchers  []string
+	QU         string
+	Tenant     string // Sent as tenant.Header; empty for the default tenant
+	Collection string // Collection of the searchers' segments and of test queries
+	HTTP       *http.Client
+}
+
+// NewClient returns a client of the services at the given base URLs, timing requests out
+// after timeout.
+func NewClient(broker, indexer string, searchers []string, qu string, timeout time.Duration) *Client {
+	return &Client{Broker: broker, Indexer: indexer, Searchers: searchers, QU: qu, HTTP: &http.Client{Timeout: timeout}}
+}
+
+// StatusError is returned for responses with an unexpected HTTP status.
+type StatusError struct {
+	URL    string
+	Status int
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.URL, e.Status, e.Body)
+}
+
+// do sends a request to base+path with a JSON body, unless body is nil, and decodes the
+// JSON response into out, unless out is nil. Responses with a status over 299 fail with a
+// StatusError.
+func (c *Client) do(ctx context.Context, method, base, path string, body, out interface{}) error {
+	if base == "" {
+		return ErrNoService
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	u := strings.TrimSuffix(base, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Tenant != "" {
+		req.Header.Set(tenant.Header, c.Tenant)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response of %s: %w", u, err)
+	}
+	if resp.StatusCode > 299 {
+		return &StatusError{URL: u, Status: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+	if out == nil {
+		return nil
+	}
+	if raw, ok := out.(*json.RawMessage); ok {
+		*raw = append((*raw)[:0], data...)
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", u, err)
+	}
+	return nil
+}
+
+// Shard is a searcher of a shard, as routed by the broker.
+type Shard struct {
+	Collection string `json:"collection"`
+	ShardID    int    `json:"shard_id"`
+	Replica    int    `json:"replica"`
+	Searcher   string `json:"searcher"`
+	State      string `json:"state"` // State of the broker's circuit breaker of the searcher
+	Requests   int    `json:"requests"`
+	Failures   int    `json:"failures"`
+}
+
+// Shards lists the searchers of every shard known to the broker.
+func (c *Client) Shards(ctx context.Context) ([]Shard, error) {
+	var resp struct {
+		Breakers []Shard `json:"breakers"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.Broker, "/admin/breakers", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Breakers, nil
+}
+
+// Topology is the shard map of the collections routed by the broker.
+type Topology struct {
+	GeneratedAt time.Time            `json:"generated_at"`
+	Collections []CollectionTopology `json:"collections"`
+}
+
+// CollectionTopology is the shard map of a collection, with its health: green when every
+// replica is in routing, yellow when some aren't, red when a shard has none.
+type CollectionTopology struct {
+	Name         string          `json:"name"`
+	Tenant       string          `json:"tenant,omitempty"`
+	Collection   string          `json:"collection"`
+	Health       string          `json:"health"`
+	IndexVersion *IndexVersion   `json:"index_version,omitempty"` // Last commit announced by the indexer
+	Shards       []ShardTopology `json:"shards"`
+}
+
+// IndexVersion identifies the segment last committed to a collection.
+type IndexVersion struct {
+	Segment     string    `json:"segment"`
+	DocCount    uint64    `json:"doc_count"`
+	CommittedAt time.Time `json:"committed_at"`
+}
+
+// ShardTopology lists the replicas of a shard.
+type ShardTopology struct {
+	ShardID         int               `json:"shard_id"`
+	HealthyReplicas int               `json:"healthy_replicas"`
+	Replicas        []ReplicaTopology `json:"replicas"`
+}
+
+// ReplicaTopology is a searcher serving a shard, with its circuit breaker.
+type ReplicaTopology struct {
+	Replica  int    `json:"replica"`
+	Searcher string `json:"searcher"`
+	Endpoint string `json:"endpoint"`
+	Healthy  bool   `json:"healthy"`
+	Breaker  Shard  `json:"breaker"`
+}
+
+// Topology returns the shard map of the broker, narrowed down to the client's tenant and
+// collection when they are set.
+func (c *Client) Topology(ctx context.Context) (*Topology, error) {
+	query := url.Values{}
+	if c.Tenant != "" || c.Collection != "" {
+		query.Set(tenant.Param, c.Tenant)
+	}
+	if c.Collection != "" {
+		query.Set("collection", c.Collection)
+	}
+	path := "/admin/topology"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var topology Topology
+	if err := c.do(ctx, http.MethodGet, c.Broker, path, nil, &topology); err != nil {
+		return nil, err
+	}
+	return &topology, nil
+}
+
+// Segment is a segment of a searcher, with its storage tier.
+type Segment struct {
+	Searcher  string    `json:"searcher"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+	Tier      string    `json:"tier"`
+	Pinned    bool      `json:"pinned"`
+	Local     bool      `json:"local"`
+}
+
+// Segments lists the segments of the collection on every searcher.
+func (c *Client) Segments(ctx context.Context) ([]Segment, error) {
+	if len(c.Searchers) == 0 {
+		return nil, ErrNoService
+	}
+	var segments []Segment
+	for _, searcher := range c.Searchers {
+		var resp struct {
+			Segments []Segment `json:"segments"`
+		}
+		if err := c.do(ctx, http.MethodGet, searcher, "/segments"+c.collectionQuery(), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, segment := range resp.Segments {
+			segment.Searcher = searcher
+			segments = append(segments, segment)
+		}
+	}
+	return segments, nil
+}
+
+// collectionQuery returns the query string selecting the client's collection, if any.
+func (c *Client) collectionQuery() string {
+	if c.Collection == "" {
+		return ""
+	}
+	return "?collection=" + url.QueryEscape(c.Collection)
+}
+
+// Commit commits the indexer's pending writes and uploads the new segment.
+func (c *Client) Commit(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, c.Indexer, "/commit", nil, nil)
+}
+
+// Refresh makes every searcher check for new segments right away, as if the indexer had
+// announced a commit. It returns the errors of the searchers that could not be reached.
+func (c *Client) Refresh(ctx context.Context) error {
+	if len(c.Searchers) == 0 {
+		return ErrNoService
+	}
+	event := commitbus.Event{Tenant: c.Tenant, Collection: c.Collection, CommittedAt: time.Now().UTC()}
+	var errs []error
+	for _, searcher := range c.Searchers {
+		if err := c.do(ctx, http.MethodPost, searcher, commitbus.Path, event, nil); err != nil {
+			errs = append(errs, fmt.Errorf("searcher %s: %w", searcher, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Health is the state of a service, probed on one of its admin endpoints.
+type Health struct {
+	Service   string `json:"service"`
+	URL       string `json:"url"`
+	Healthy   bool   `json:"healthy"`
+	LatencyMs int64  `json:"latency_ms"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// Health probes every service that has a URL.
+func (c *Client) Health(ctx context.Context) []Health {
+	var health []Health
+	if c.Broker != "" {
+		h := c.probe(ctx, "broker", c.Broker, "/admin/breakers", func(data json.RawMessage) string {
+			var resp struct{ Breakers []Shard }
+			json.Unmarshal(data, &resp)
+			open := 0
+			for _, b := range resp.Breakers {
+				if b.State != "closed" {
+					open++
+				}
+			}
+			return fmt.Sprintf("%d searchers, %d not closed", len(resp.Breakers), open)
+		})
+		health = append(health, h)
+	}
+	if c.Indexer != "" {
+		health = append(health, c.probe(ctx, "indexer", c.Indexer, "/stats", func(data json.RawMessage) string {
+			var stats struct {
+				DocCount uint64 `json:"doc_count"`
+				Uptime   string `json:"uptime"`
+			}
+			json.Unmarshal(data, &stats)
+			return fmt.Sprintf("%d documents, up %s", stats.DocCount, stats.Uptime)
+		}))
+	}
+	for _, searcher := range c.Searchers {
+		health = append(health, c.probe(ctx, "searcher", searcher, "/metrics", nil))
+	}
+	if c.QU != "" {
+		health = append(health, c.probe(ctx, "query_understanding", c.QU, "/admin/stopwords", nil))
+	}
+	return health
+}
+
+// probe requests path on a service, describing its response with detail, if not nil.
+func (c *Client) probe(ctx context.Context, service, base, path string, detail func(json.RawMessage) string) Health {
+	h := Health{Service: service, URL: base}
+	start := time.Now()
+	var data json.RawMessage
+	err := c.do(ctx, http.MethodGet, base, path, nil, &data)
+	h.LatencyMs = time.Since(start).Milliseconds()
+	switch {
+	case err != nil:
+		h.Detail = err.Error()
+	case detail != nil:
+		h.Healthy, h.Detail = true, detail(data)
+	default:
+		h.Healthy = true
+	}
+	return h
+}
+
+// QueryResult is a result of a test query with its scoring breakdown.
+type QueryResult struct {
+	ID          string          `json:"id"`
+	Score       float64         `json:"score"`
+	Explanation json.RawMessage `json:"explanation,omitempty"`
+}
+
+// QueryResponse is the outcome of a test query run through the broker.
+type QueryResponse struct {
+	TotalHits int             `json:"total_hits"`
+	TookMs    int64           `json:"took_ms"`
+	Shards    json.RawMessage `json:"shards"`
+	Results   []QueryResult   `json:"results"`
+	Stages    json.RawMessage `json:"stages,omitempty"`
+}
+
+// Query runs a search through the broker, explaining the scores of its results.
+func (c *Client) Query(ctx context.Context, q string, size int) (*QueryResponse, error) {
+	params := url.Values{"q": {q}, "explain": {"true"}, "size": {strconv.Itoa(size)}}
+	if c.Collection != "" {
+		params.Set("collection", c.Collection)
+	}
+	var resp struct {
+		TotalHits int             `json:"total_hits"`
+		TookMs    int64           `json:"took_ms"`
+		Shards    json.RawMessage `json:"shards"`
+		Results   []struct {
+			ID    string
+			Score float64
+		} `json:"results"`
+		Debug struct {
+			Stages       json.RawMessage `json:"stages"`
+			Explanations []struct {
+				ID          string          `json:"id"`
+				Explanation json.RawMessage `json:"explanation"`
+			} `json:"explanations"`
+		} `json:"debug"`
+	}
+	if err := c.do(ctx, http.MethodGet, c.Broker, "/search?"+params.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	out := &QueryResponse{TotalHits: resp.TotalHits, TookMs: resp.TookMs, Shards: resp.Shards, Stages: resp.Debug.Stages}
+	for i, r := range resp.Results {
+		result := QueryResult{ID: r.ID, Score: r.Score}
+		if i < len(resp.Debug.Explanations) && resp.Debug.Explanations[i].ID == r.ID {
+			result.Explanation = resp.Debug.Explanations[i].Explanation
+		}
+		out.Results = append(out.Results, result)
+	}
+	return out, nil
+}
+
+// ClusterConfig holds the settings of the cluster that can be changed at runtime: the
+// indexer's commit policy and mapping, and the query understanding lexicon. Sections are
+// kept as the services serve them.
+type ClusterConfig struct {
+	CommitPolicy json.RawMessage   `json:"commit_policy,omitempty"`
+	Mapping      json.RawMessage   `json:"mapping,omitempty"`
+	Stopwords    []json.RawMessage `json:"stopwords,omitempty"`
+	Synonyms     []json.RawMessage `json:"synonyms,omitempty"`
+}
+
+// DumpConfig reads the settings of the indexer and query understanding, leaving out the
+// sections of the services without a URL.
+func (c *Client) DumpConfig(ctx context.Context) (*ClusterConfig, error) {
+	cfg := &ClusterConfig{}
+	if c.Indexer != "" {
+		if err := c.do(ctx, http.MethodGet, c.Indexer, "/commit/policy", nil, &cfg.CommitPolicy); err != nil {
+			return nil, err
+		}
+		if err := c.do(ctx, http.MethodGet, c.Indexer, "/mapping", nil, &cfg.Mapping); err != nil {
+			return nil, err
+		}
+	}
+	if c.QU != "" {
+		var stopwords struct {
+			Stopwords []json.RawMessage `json:"stopwords"`
+		}
+		if err := c.do(ctx, http.MethodGet, c.QU, "/admin/stopwords", nil, &stopwords); err != nil {
+			return nil, err
+		}
+		var synonyms struct {
+			Synonyms []json.RawMessage `json:"synonyms"`
+		}
+		if err := c.do(ctx, http.MethodGet, c.QU, "/admin/synonyms", nil, &synonyms); err != nil {
+			return nil, err
+		}
+		cfg.Stopwords, cfg.Synonyms = stopwords.Stopwords, synonyms.Synonyms
+	}
+	return cfg, nil
+}
+
+// ApplyConfig writes the sections set in cfg to their services, returning a line per
+// change. The mapping is only applied if it differs from the current one, since a new
+// mapping reindexes the collection. Stopword lists and synonym sets are created or
+// replaced; those missing from cfg are left alone.
+func (c *Client) ApplyConfig(ctx context.Context, cfg *ClusterConfig) ([]string, error) {
+	var changes []string
+	if cfg.CommitPolicy != nil {
+		if err := c.do(ctx, http.MethodPut, c.Indexer, "/commit/policy", cfg.CommitPolicy, nil); err != nil {
+			return changes, fmt.Errorf("failed to apply the commit policy: %w", err)
+		}
+		changes = append(changes, "commit policy applied")
+	}
+	if cfg.Mapping != nil {
+		var current json.RawMessage
+		if err := c.do(ctx, http.MethodGet, c.Indexer, "/mapping", nil, &current); err != nil {
+			return changes, fmt.Errorf("failed to read the mapping: %w", err)
+		}
+		if sameJSON(current, cfg.Mapping) {
+			changes = append(changes, "mapping unchanged")
+		} else {
+			var job struct {
+				ID string `json:"id"`
+			}
+			if err := c.do(ctx, http.MethodPut, c.Indexer, "/mapping", cfg.Mapping, &job); err != nil {
+				return changes, fmt.Errorf("failed to apply the mapping: %w", err)
+			}
+			changes = append(changes, fmt.Sprintf("mapping applied, reindex job %s", job.ID))
+		}
+	}
+	for _, list := range cfg.Stopwords {
+		if err := c.do(ctx, http.MethodPut, c.QU, "/admin/stopwords", list, nil); err != nil {
+			return changes, fmt.Errorf("failed to apply stopword list %s: %w", list, err)
+		}
+		changes = append(changes, "stopword list applied")
+	}
+	for _, set := range cfg.Synonyms {
+		var id struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(set, &id); err != nil || id.ID == "" {
+			return changes, fmt.Errorf("synonym set %s has no id", set)
+		}
+		if err := c.do(ctx, http.MethodPut, c.QU, "/admin/synonyms/"+url.PathEscape(id.ID), set, nil); err != nil {
+			return changes, fmt.Errorf("failed to apply synonym set %s: %w", id.ID, err)
+		}
+		changes = append(changes, fmt.Sprintf("synonym set %s applied", id.ID))
+	}
+	return changes, nil
+}
+
+// sameJSON reports whether two JSON documents hold the same values.
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
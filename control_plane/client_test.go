@@ -0,0 +1,181 @@
+package control_plane
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"common/commitbus"
+	"common/tenant"
+)
+
+// fakeCluster serves the admin endpoints used by the client, recording the writes.
+type fakeCluster struct {
+	mu      sync.Mutex
+	mapping string
+	writes  []string // Method and path of every write, with the tenant
+	events  []commitbus.Event
+}
+
+func (f *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if r.Method != http.MethodGet {
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path+" "+r.Header.Get(tenant.Header))
+	}
+	switch {
+	case r.URL.Path == "/admin/breakers":
+		w.Write([]byte(`{"breakers": [{"collection": "products", "shard_id": 0, "replica": 0, "searcher": "http://s0", "state": "closed"}, {"collection": "products", "shard_id": 1, "replica": 0, "searcher": "http://s1", "state": "open", "failures": 5}]}`))
+	case r.URL.Path == "/admin/topology":
+		if q := r.URL.Query(); q.Get(tenant.Param) != "acme" || q.Get("collection") != "products" {
+			http.Error(w, "tenant and collection expected", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"collections": [{"name": "acme/products", "tenant": "acme", "collection": "products", "health": "yellow", "index_version": {"segment": "index_1", "doc_count": 3}, "shards": [{"shard_id": 0, "healthy_replicas": 1, "replicas": [{"replica": 0, "endpoint": "http://s0", "healthy": true, "breaker": {"state": "closed"}}, {"replica": 1, "endpoint": "http://s1", "breaker": {"state": "open"}}]}]}]}`))
+	case r.URL.Path == "/search":
+		if r.URL.Query().Get("explain") != "true" {
+			http.Error(w, "explain expected", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"total_hits": 2, "took_ms": 3, "results": [{"ID": "a", "Score": 2}, {"ID": "b", "Score": 1}], "debug": {"explanations": [{"id": "a", "explanation": {"message": "sum of:"}}, {"id": "b"}]}}`))
+	case r.URL.Path == "/segments":
+		w.Write([]byte(`{"segments": [{"name": "seg-1", "size": 100, "tier": "hot", "local": true}]}`))
+	case r.URL.Path == commitbus.Path:
+		var event commitbus.Event
+		json.Unmarshal(body, &event)
+		f.events = append(f.events, event)
+	case r.URL.Path == "/commit":
+		w.Write([]byte("Index committed and uploaded successfully"))
+	case r.URL.Path == "/commit/policy":
+		w.Write([]byte(`{"max_docs": 1000}`))
+	case r.URL.Path == "/mapping":
+		if r.Method == http.MethodPut {
+			f.mapping = string(body)
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id": "job-1"}`))
+			return
+		}
+		w.Write([]byte(f.mapping))
+	case r.URL.Path == "/admin/stopwords":
+		w.Write([]byte(`{"stopwords": [{"language": "en", "words": ["the"]}]}`))
+	case r.URL.Path == "/admin/synonyms":
+		w.Write([]byte(`{"synonyms": [{"id": "shoes", "terms": ["shoes", "sneakers"]}]}`))
+	case strings.HasPrefix(r.URL.Path, "/admin/synonyms/"):
+		w.Write(body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestClient(t *testing.T) (*Client, *fakeCluster) {
+	t.Helper()
+	fake := &fakeCluster{mapping: `{"default_analyzer": "standard"}`}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := NewClient(server.URL, server.URL, []string{server.URL}, server.URL, 5*time.Second)
+	client.Tenant, client.Collection = "acme", "products"
+	return client, fake
+}
+
+func TestClient_Operations(t *testing.T) {
+	client, fake := newTestClient(t)
+	ctx := context.Background()
+
+	shards, err := client.Shards(ctx)
+	if err != nil || len(shards) != 2 || shards[1].State != "open" || shards[1].Failures != 5 {
+		t.Errorf("Expected the 2 shards of the broker, got %+v %v", shards, err)
+	}
+	topology, err := client.Topology(ctx)
+	if err != nil || len(topology.Collections) != 1 || topology.Collections[0].Health != "yellow" || topology.Collections[0].IndexVersion.Segment != "index_1" || topology.Collections[0].Shards[0].Replicas[1].Breaker.State != "open" {
+		t.Errorf("Expected the topology of acme/products, got %+v %v", topology, err)
+	}
+	segments, err := client.Segments(ctx)
+	if err != nil || len(segments) != 1 || segments[0].Name != "seg-1" || segments[0].Searcher != client.Searchers[0] {
+		t.Errorf("Expected the segment of the searcher, got %+v %v", segments, err)
+	}
+	if err := client.Commit(ctx); err != nil {
+		t.Errorf("Commit returned an error: %v", err)
+	}
+	if err := client.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh returned an error: %v", err)
+	}
+	if len(fake.events) != 1 || fake.events[0].Tenant != "acme" || fake.events[0].Collection != "products" {
+		t.Errorf("Expected a commit event for acme/products, got %+v", fake.events)
+	}
+	if want := "POST /commit acme"; fake.writes[0] != want {
+		t.Errorf("Expected %q, got %q", want, fake.writes[0])
+	}
+
+	resp, err := client.Query(ctx, "shoes", 5)
+	if err != nil {
+		t.Fatalf("Query returned an error: %v", err)
+	}
+	if resp.TotalHits != 2 || len(resp.Results) != 2 || !strings.Contains(string(resp.Results[0].Explanation), "sum of") {
+		t.Errorf("Expected the explained results, got %+v", resp)
+	}
+
+	client.Searchers = append(client.Searchers, "http://127.0.0.1:1")
+	health := client.Health(ctx)
+	if len(health) != 5 || !health[0].Healthy || health[0].Detail != "2 searchers, 1 not closed" || health[3].Healthy {
+		t.Errorf("Expected the unreachable searcher to be down, got %+v", health)
+	}
+
+	if err := (&Client{}).Commit(ctx); !errors.Is(err, ErrNoService) {
+		t.Errorf("Expected ErrNoService without an indexer, got %v", err)
+	}
+}
+
+func TestClient_Config(t *testing.T) {
+	client, fake := newTestClient(t)
+	ctx := context.Background()
+
+	cfg, err := client.DumpConfig(ctx)
+	if err != nil {
+		t.Fatalf("DumpConfig returned an error: %v", err)
+	}
+	if len(cfg.Stopwords) != 1 || len(cfg.Synonyms) != 1 || !sameJSON(cfg.Mapping, json.RawMessage(fake.mapping)) {
+		t.Fatalf("Expected every section to be dumped, got %+v", cfg)
+	}
+	changes, err := client.ApplyConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("ApplyConfig returned an error: %v", err)
+	}
+	if strings.Join(changes, "; ") != "commit policy applied; mapping unchanged; stopword list applied; synonym set shoes applied" {
+		t.Errorf("Unexpected changes %q", changes)
+	}
+
+	cfg = &ClusterConfig{Mapping: json.RawMessage(`{"default_analyzer": "en"}`)}
+	if changes, err := client.ApplyConfig(ctx, cfg); err != nil || changes[0] != "mapping applied, reindex job job-1" {
+		t.Errorf("Expected the new mapping to be applied, got %q %v", changes, err)
+	}
+	if _, err := client.ApplyConfig(ctx, &ClusterConfig{Synonyms: []json.RawMessage{json.RawMessage(`{"terms": ["a"]}`)}}); err == nil {
+		t.Errorf("Expected an error for a synonym set without an id")
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	var buf bytes.Buffer
+	p := &Printer{Format: OutputTable, W: &buf}
+	p.Print(nil, []string{"ID", "STATE"}, [][]string{{"searcher-10", "open"}})
+	if want := "ID           STATE\nsearcher-10  open\n"; buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+	buf.Reset()
+	p.Format = OutputJSON
+	p.Print(map[string]int{"n": 1}, nil, nil)
+	if buf.String() != "{\n  \"n\": 1\n}\n" {
+		t.Errorf("Expected indented JSON, got %q", buf.String())
+	}
+	if _, err := ParseOutput("yaml"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,220 @@
+// Command searchctl operates a search cluster through the admin APIs of its services:
+//
+//	searchctl [flags] shards                list the searchers of every shard, from the broker
+//	searchctl [flags] topology              print the shard map, health and index version of every collection
+//	searchctl [flags] segments              list the segments of every searcher
+//	searchctl [flags] commit                commit and upload the indexer's pending writes
+//	searchctl [flags] refresh               make the searchers download new segments now
+//	searchctl [flags] health                probe every service; exits with 1 if one is down
+//	searchctl [flags] query [-size n] text  run a query through the broker with explanations
+//	searchctl [flags] config dump           print the commit policy, mapping and lexicon
+//	searchctl [flags] config apply file     apply a dumped configuration ("-" reads stdin)
+//
+// Service URLs default to the SEARCHCTL_BROKER, SEARCHCTL_INDEXER, SEARCHCTL_SEARCHERS
+// and SEARCHCTL_QU environment variables. Every command prints a table, or JSON with
+// -o json.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	cp "control_plane"
+)
+
+func main() {
+	broker := flag.String("broker", os.Getenv("SEARCHCTL_BROKER"), "Broker URL")
+	indexer := flag.String("indexer", os.Getenv("SEARCHCTL_INDEXER"), "Indexer URL")
+	searchers := flag.String("searchers", os.Getenv("SEARCHCTL_SEARCHERS"), "Comma-separated searcher URLs")
+	qu := flag.String("qu", os.Getenv("SEARCHCTL_QU"), "Query understanding service URL")
+	tenantID := flag.String("tenant", "", "Tenant of the requests; empty for the default tenant")
+	collection := flag.String("collection", "", "Collection of segments, refreshes and queries")
+	output := flag.String("o", cp.OutputTable, "Output format: table or json")
+	timeout := flag.Duration("timeout", 30*time.Second, "Timeout of every request")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: searchctl [flags] shards|topology|segments|commit|refresh|health|query|config\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	format, err := cp.ParseOutput(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var searcherURLs []string
+	if *searchers != "" {
+		searcherURLs = strings.Split(*searchers, ",")
+	}
+	client := cp.NewClient(*broker, *indexer, searcherURLs, *qu, *timeout)
+	client.Tenant, client.Collection = *tenantID, *collection
+	p := &cp.Printer{Format: format, W: os.Stdout}
+	if err := run(context.Background(), client, p, flag.Args()); err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+}
+
+// run executes the command of args.
+func run(ctx context.Context, client *cp.Client, p *cp.Printer, args []string) error {
+	switch args[0] {
+	case "shards":
+		shards, err := client.Shards(ctx)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(shards))
+		for _, s := range shards {
+			rows = append(rows, []string{s.Collection, strconv.Itoa(s.ShardID), strconv.Itoa(s.Replica), s.Searcher, s.State, strconv.Itoa(s.Requests), strconv.Itoa(s.Failures)})
+		}
+		return p.Print(shards, []string{"COLLECTION", "SHARD", "REPLICA", "SEARCHER", "STATE", "REQUESTS", "FAILURES"}, rows)
+	case "topology":
+		topology, err := client.Topology(ctx)
+		if err != nil {
+			return err
+		}
+		var rows [][]string
+		for _, c := range topology.Collections {
+			version := ""
+			if c.IndexVersion != nil {
+				version = c.IndexVersion.Segment
+			}
+			for _, shard := range c.Shards {
+				for _, r := range shard.Replicas {
+					rows = append(rows, []string{c.Name, c.Health, strconv.Itoa(shard.ShardID), strconv.Itoa(r.Replica), r.Endpoint, r.Breaker.State, version})
+				}
+			}
+		}
+		return p.Print(topology, []string{"COLLECTION", "HEALTH", "SHARD", "REPLICA", "ENDPOINT", "STATE", "VERSION"}, rows)
+	case "segments":
+		segments, err := client.Segments(ctx)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(segments))
+		for _, s := range segments {
+			rows = append(rows, []string{s.Searcher, s.Name, s.CreatedAt.Format(time.RFC3339), strconv.FormatInt(s.Size, 10), s.Tier, strconv.FormatBool(s.Pinned), strconv.FormatBool(s.Local)})
+		}
+		return p.Print(segments, []string{"SEARCHER", "SEGMENT", "CREATED", "BYTES", "TIER", "PINNED", "LOCAL"}, rows)
+	case "commit":
+		if err := client.Commit(ctx); err != nil {
+			return err
+		}
+		return p.Print(map[string]string{"status": "committed"}, []string{"STATUS"}, [][]string{{"committed"}})
+	case "refresh":
+		if err := client.Refresh(ctx); err != nil {
+			return err
+		}
+		return p.Print(map[string]interface{}{"refreshed": client.Searchers}, []string{"REFRESHED"}, [][]string{{strings.Join(client.Searchers, ",")}})
+	case "health":
+		health := client.Health(ctx)
+		rows := make([][]string, 0, len(health))
+		healthy := true
+		for _, h := range health {
+			status := "ok"
+			if !h.Healthy {
+				status, healthy = "down", false
+			}
+			rows = append(rows, []string{h.Service, h.URL, status, strconv.FormatInt(h.LatencyMs, 10), h.Detail})
+		}
+		if err := p.Print(health, []string{"SERVICE", "URL", "STATUS", "LATENCY_MS", "DETAIL"}, rows); err != nil {
+			return err
+		}
+		if !healthy {
+			os.Exit(1)
+		}
+		return nil
+	case "query":
+		return runQuery(ctx, client, p, args[1:])
+	case "config":
+		return runConfig(ctx, client, p, args[1:])
+	default:
+		return fmt.Errorf("unknown command, expected shards, topology, segments, commit, refresh, health, query or config")
+	}
+}
+
+// runQuery runs a test query with the arguments of the query command.
+func runQuery(ctx context.Context, client *cp.Client, p *cp.Printer, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	size := fs.Int("size", 10, "Number of results")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: searchctl query [-size n] text")
+	}
+	resp, err := client.Query(ctx, strings.Join(fs.Args(), " "), *size)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(resp.Results))
+	for i, r := range resp.Results {
+		rows = append(rows, []string{strconv.Itoa(i + 1), r.ID, strconv.FormatFloat(r.Score, 'f', 4, 64), explanationSummary(r.Explanation)})
+	}
+	if err := p.Print(resp, []string{"RANK", "ID", "SCORE", "EXPLANATION"}, rows); err != nil {
+		return err
+	}
+	if p.Format == cp.OutputTable {
+		fmt.Fprintf(p.W, "\n%d hits in %dms\n", resp.TotalHits, resp.TookMs)
+	}
+	return nil
+}
+
+// explanationSummary returns the top-level message of a Bleve explanation.
+func explanationSummary(explanation json.RawMessage) string {
+	var e struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(explanation, &e) != nil {
+		return ""
+	}
+	return e.Message
+}
+
+// runConfig dumps or applies the configuration with the arguments of the config command.
+func runConfig(ctx context.Context, client *cp.Client, p *cp.Printer, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "dump":
+		cfg, err := client.DumpConfig(ctx)
+		if err != nil {
+			return err
+		}
+		// A dump is always JSON, to be applied back.
+		enc := json.NewEncoder(p.W)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cfg)
+	case len(args) == 2 && args[0] == "apply":
+		var r io.Reader = os.Stdin
+		if args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		var cfg cp.ClusterConfig
+		if err := json.NewDecoder(r).Decode(&cfg); err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+		changes, err := client.ApplyConfig(ctx, &cfg)
+		rows := make([][]string, 0, len(changes))
+		for _, change := range changes {
+			rows = append(rows, []string{change})
+		}
+		if printErr := p.Print(map[string][]string{"changes": changes}, []string{"CHANGE"}, rows); printErr != nil && err == nil {
+			err = printErr
+		}
+		return err
+	default:
+		return fmt.Errorf("usage: searchctl config dump | config apply file")
+	}
+}
@@ -0,0 +1,46 @@
+package control_plane
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats of the Printer.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// Printer writes command outputs as aligned tables or as indented JSON.
+type Printer struct {
+	Format string
+	W      io.Writer
+}
+
+// ParseOutput validates an output format.
+func ParseOutput(format string) (string, error) {
+	switch format {
+	case OutputTable, OutputJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid output format %q, expected %s or %s", format, OutputTable, OutputJSON)
+	}
+}
+
+// Print writes v as JSON, or the rows under headers as a table.
+func (p *Printer) Print(v interface{}, headers []string, rows [][]string) error {
+	if p.Format == OutputJSON {
+		enc := json.NewEncoder(p.W)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(p.W, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,178 @@
+// Package analyzers registers custom Bleve analyzers that index mappings, e.g. index
+// schemas or mapping.json, reference by name. Every analyzer normalizes text like the
+// normalize_unicode stage of query understanding (see common/textnorm), folds the
+// remaining accents and ligatures to ASCII (ø→o, ß→ss) and lower-cases words, so search
+// is accent-insensitive; the built-in ones are:
+//
+//   - autocomplete: edge n-grams of 2 to 20 characters for prefix search ("sho" matches
+//     "shoes"), to pair with autocomplete_search at query time
+//   - autocomplete_search: words only, the query-time side of autocomplete
+//   - folding: words only
+//   - folding_en: words with English stop words removed and stemmed
+//   - trigram: n-grams of 3 characters for infix and typo-tolerant matching
+//   - shingle: words and runs of 2 to 3 words, boosting documents matching phrases
+//
+// More can be defined with Register. Processes opening an index whose mapping uses them
+// must import this package, e.g. for its side effects.
+package analyzers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"common/textnorm"
+
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/analysis/char/asciifolding"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/analysis/token/edgengram"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/token/ngram"
+	"github.com/blevesearch/bleve/v2/analysis/token/shingle"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/registry"
+	"gopkg.in/yaml.v2"
+)
+
+// Types of analyzer definitions.
+const (
+	TypeEdgeNgram = "edge_ngram" // Prefixes of Min to Max characters of every word
+	TypeNgram     = "ngram"      // Substrings of Min to Max characters of every word
+	TypeShingle   = "shingle"    // Words and runs of Min to Max words
+	TypeFolding   = "folding"    // Words
+	TypeFoldingEN = "folding_en" // Words without English stop words, stemmed
+)
+
+// Names of the built-in analyzers.
+const (
+	Autocomplete       = "autocomplete"
+	AutocompleteSearch = "autocomplete_search"
+	Folding            = "folding"
+	FoldingEN          = "folding_en"
+	Trigram            = "trigram"
+	Shingle            = "shingle"
+)
+
+// ErrInvalidDefinition is returned for analyzer definitions that can't be registered.
+var ErrInvalidDefinition = errors.New("invalid analyzer definition")
+
+// Definition defines a custom analyzer. Min and Max bound the n-grams of the n-gram types
+// and the words of shingles; they must be zero for the other types.
+type Definition struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	Min  int    `yaml:"min"`
+	Max  int    `yaml:"max"`
+}
+
+// builtIn are the analyzers registered by the package.
+var builtIn = []Definition{
+	{Name: Autocomplete, Type: TypeEdgeNgram, Min: 2, Max: 20},
+	{Name: AutocompleteSearch, Type: TypeFolding},
+	{Name: Folding, Type: TypeFolding},
+	{Name: FoldingEN, Type: TypeFoldingEN},
+	{Name: Trigram, Type: TypeNgram, Min: 3, Max: 3},
+	{Name: Shingle, Type: TypeShingle, Min: 2, Max: 3},
+}
+
+func init() {
+	for _, def := range builtIn {
+		if err := Register(def); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Validate checks the name, the type and the bounds of the definition.
+func (d Definition) Validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("%w: missing name", ErrInvalidDefinition)
+	}
+	switch d.Type {
+	case TypeEdgeNgram, TypeNgram:
+		if d.Min < 1 || d.Max < d.Min {
+			return fmt.Errorf("%w: analyzer %s needs 1 <= min <= max, got %d and %d", ErrInvalidDefinition, d.Name, d.Min, d.Max)
+		}
+	case TypeShingle:
+		if d.Min < 2 || d.Max < d.Min {
+			return fmt.Errorf("%w: analyzer %s needs 2 <= min <= max, got %d and %d", ErrInvalidDefinition, d.Name, d.Min, d.Max)
+		}
+	case TypeFolding, TypeFoldingEN:
+		if d.Min != 0 || d.Max != 0 {
+			return fmt.Errorf("%w: analyzer %s of type %s takes no min or max", ErrInvalidDefinition, d.Name, d.Type)
+		}
+	default:
+		return fmt.Errorf("%w: analyzer %s has unknown type %q", ErrInvalidDefinition, d.Name, d.Type)
+	}
+	return nil
+}
+
+// Register makes the analyzer of the definition available to index mappings under its
+// name, which must not be taken, including by Bleve's analyzers such as "en". Analyzers
+// must be registered before the indexes using them are opened.
+func Register(def Definition) error {
+	if err := def.Validate(); err != nil {
+		return err
+	}
+	err := registry.RegisterAnalyzer(def.Name, func(config map[string]interface{}, cache *registry.Cache) (analysis.Analyzer, error) {
+		return def.build(cache)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+	}
+	return nil
+}
+
+// build creates the analyzer of the definition.
+func (d Definition) build(cache *registry.Cache) (analysis.Analyzer, error) {
+	tokenizer, err := cache.TokenizerNamed(unicode.Name)
+	if err != nil {
+		return nil, err
+	}
+	filters := []analysis.TokenFilter{lowercase.NewLowerCaseFilter()}
+	switch d.Type {
+	case TypeEdgeNgram:
+		filters = append(filters, edgengram.NewEdgeNgramFilter(edgengram.FRONT, d.Min, d.Max))
+	case TypeNgram:
+		filters = append(filters, ngram.NewNgramFilter(d.Min, d.Max))
+	case TypeShingle:
+		filters = append(filters, shingle.NewShingleFilter(d.Min, d.Max, true, " ", "_"))
+	case TypeFoldingEN:
+		stop, err := cache.TokenFilterNamed(en.StopName)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, en.NewPossessiveFilter(), stop, en.NewEnglishStemmerFilter())
+	}
+	return &analysis.DefaultAnalyzer{
+		CharFilters:  []analysis.CharFilter{unicodeFolding{}, asciifolding.New()},
+		Tokenizer:    tokenizer,
+		TokenFilters: filters,
+	}, nil
+}
+
+// unicodeFolding is the char filter normalizing text with textnorm.Fold, e.g. composing
+// the combining accents ASCII folding leaves and folding full-width letters.
+type unicodeFolding struct{}
+
+// Filter implements analysis.CharFilter.
+func (unicodeFolding) Filter(input []byte) []byte {
+	return []byte(textnorm.Normalize(string(input), textnorm.Fold))
+}
+
+// Load reads the analyzer definitions listed under analyzers in a YAML file, e.g. the
+// indexer configuration, ignoring its other settings.
+func Load(path string) ([]Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read analyzers from %s: %w", path, err)
+	}
+	var file struct {
+		Analyzers []Definition `yaml:"analyzers"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal analyzers from %s: %w", path, err)
+	}
+	return file.Analyzers, nil
+}
@@ -0,0 +1,91 @@
+package analyzers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// terms returns the terms the named analyzer produces for text.
+func terms(t *testing.T, analyzer, text string) []string {
+	t.Helper()
+	tokens, err := bleve.NewIndexMapping().AnalyzeText(analyzer, []byte(text))
+	if err != nil {
+		t.Fatalf("AnalyzeText(%s) returned an error: %v", analyzer, err)
+	}
+	var out []string
+	for _, token := range tokens {
+		out = append(out, string(token.Term))
+	}
+	return out
+}
+
+func TestBuiltIn(t *testing.T) {
+	tests := []struct {
+		analyzer string
+		text     string
+		want     []string
+	}{
+		{Autocomplete, "Crème a", []string{"cr", "cre", "crem", "creme"}},
+		{AutocompleteSearch, "CRÈME Brûlée", []string{"creme", "brulee"}},
+		{Folding, "Ångström Straße", []string{"angstrom", "strasse"}},
+		{Folding, "Cafe\u0301 ｃａｆé ﬁne", []string{"cafe", "cafe", "fine"}},
+		{FoldingEN, "The cafés' running", []string{"cafe", "run"}},
+		{Trigram, "Naïve", []string{"nai", "aiv", "ive"}},
+		{Shingle, "red running shoes", []string{"red", "running", "red running", "shoes", "running shoes", "red running shoes"}},
+	}
+	for _, tt := range tests {
+		if got := terms(t, tt.analyzer, tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s(%q) = %q, expected %q", tt.analyzer, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestAutocompleteSearch(t *testing.T) {
+	m := bleve.NewIndexMapping()
+	field := bleve.NewTextFieldMapping()
+	field.Analyzer = Autocomplete
+	m.DefaultMapping.AddFieldMappingsAt("title", field)
+	index, err := bleve.NewMemOnly(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	if err := index.Index("1", map[string]interface{}{"title": "Crème brûlée"}); err != nil {
+		t.Fatal(err)
+	}
+	q := bleve.NewMatchQuery("BRUL")
+	q.SetField("title")
+	q.Analyzer = AutocompleteSearch
+	result, err := index.Search(bleve.NewSearchRequest(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 1 {
+		t.Errorf("Expected the accented prefix to match, got %d hits", result.Total)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if err := Register(Definition{Name: "prefix_short", Type: TypeEdgeNgram, Min: 1, Max: 3}); err != nil {
+		t.Fatalf("Register returned an error: %v", err)
+	}
+	if got, want := terms(t, "prefix_short", "Éclair"), []string{"e", "ec", "ecl"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	for _, def := range []Definition{
+		{Name: "prefix_short", Type: TypeFolding}, // Taken
+		{Name: "en", Type: TypeFolding},           // Taken by Bleve
+		{Type: TypeFolding},
+		{Name: "x", Type: "soundex"},
+		{Name: "x", Type: TypeNgram, Min: 3, Max: 2},
+		{Name: "x", Type: TypeShingle, Min: 1, Max: 2},
+		{Name: "x", Type: TypeFolding, Max: 2},
+	} {
+		if err := Register(def); !errors.Is(err, ErrInvalidDefinition) {
+			t.Errorf("%+v: expected ErrInvalidDefinition, got %v", def, err)
+		}
+	}
+}
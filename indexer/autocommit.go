@@ -0,0 +1,320 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Guardrails of the automatic commits.
+const (
+	// autoCommitMinGap is the least time between two commit attempts, so a flood of writes
+	// past a small threshold doesn't upload segments back to back.
+	autoCommitMinGap = time.Second
+	// autoCommitRetryDelay is how long automatic commits pause after a failed commit.
+	autoCommitRetryDelay = 30 * time.Second
+)
+
+// ErrInvalidCommitPolicy is returned for commit policies with negative thresholds.
+var ErrInvalidCommitPolicy = errors.New("invalid commit policy")
+
+// CommitPolicy sets when the indexer commits and uploads its changes on its own: as soon
+// as any threshold is reached. A zero threshold is disabled; the zero policy leaves
+// commits to CommitAndUpload callers.
+type CommitPolicy struct {
+	MaxDocs     int64         `yaml:"max_docs" env:"COMMIT_MAX_DOCS" flag:"commit-max-docs" usage:"Commit automatically once this many documents changed; 0 disables"`
+	MaxBytes    int64         `yaml:"max_bytes" env:"COMMIT_MAX_BYTES" flag:"commit-max-bytes" usage:"Commit automatically once this many bytes of documents changed; 0 disables"`
+	MaxInterval time.Duration `yaml:"max_interval" env:"COMMIT_MAX_INTERVAL" flag:"commit-max-interval" usage:"Commit automatically once the oldest uncommitted change is this old, e.g. 30s; 0 disables"`
+}
+
+// commitPolicyJSON is the JSON encoding of CommitPolicy, with a readable interval.
+type commitPolicyJSON struct {
+	MaxDocs     int64  `json:"max_docs"`
+	MaxBytes    int64  `json:"max_bytes"`
+	MaxInterval string `json:"max_interval"`
+}
+
+// MarshalJSON encodes the interval as a duration string such as "30s".
+func (p CommitPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(commitPolicyJSON{MaxDocs: p.MaxDocs, MaxBytes: p.MaxBytes, MaxInterval: p.MaxInterval.String()})
+}
+
+// UnmarshalJSON decodes a policy whose interval is a duration string; omitted thresholds
+// are disabled.
+func (p *CommitPolicy) UnmarshalJSON(data []byte) error {
+	var raw commitPolicyJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var interval time.Duration
+	if raw.MaxInterval != "" {
+		d, err := time.ParseDuration(raw.MaxInterval)
+		if err != nil {
+			return fmt.Errorf("%w: max_interval: %v", ErrInvalidCommitPolicy, err)
+		}
+		interval = d
+	}
+	*p = CommitPolicy{MaxDocs: raw.MaxDocs, MaxBytes: raw.MaxBytes, MaxInterval: interval}
+	return nil
+}
+
+// Validate checks that no threshold is negative.
+func (p CommitPolicy) Validate() error {
+	if p.MaxDocs < 0 || p.MaxBytes < 0 || p.MaxInterval < 0 {
+		return fmt.Errorf("%w: thresholds must not be negative", ErrInvalidCommitPolicy)
+	}
+	return nil
+}
+
+// enabled reports whether the policy has any threshold.
+func (p CommitPolicy) enabled() bool {
+	return p.MaxDocs > 0 || p.MaxBytes > 0 || p.MaxInterval > 0
+}
+
+// CommitPolicyStatus reports the commit policy and the changes it is waiting on.
+type CommitPolicyStatus struct {
+	Policy         CommitPolicy `json:"policy"`
+	PendingDocs    int64        `json:"pending_docs"`             // Documents changed since the last commit
+	PendingBytes   int64        `json:"pending_bytes"`            // Bytes changed, measured while MaxBytes is set
+	OldestPending  *time.Time   `json:"oldest_pending,omitempty"` // Time of the oldest uncommitted change
+	AutoCommits    int64        `json:"auto_commits"`
+	LastAutoCommit *time.Time   `json:"last_auto_commit,omitempty"`
+	LastError      string       `json:"last_error,omitempty"` // Error of the last commit, if it failed
+	RetryAt        *time.Time   `json:"retry_at,omitempty"`   // When automatic commits resume after a failure
+}
+
+// autoCommitter tracks the uncommitted changes and runs the automatic commits. Commits
+// run one at a time on its goroutine, started by the first enabling policy.
+type autoCommitter struct {
+	mu             sync.Mutex
+	policy         CommitPolicy
+	docs           int64
+	bytes          int64
+	oldest         time.Time
+	lastAttempt    time.Time
+	retryAt        time.Time
+	lastError      string
+	autoCommits    int64
+	lastAutoCommit time.Time
+	running        bool // The goroutine was started
+	closed         bool // The indexer is closing; no goroutine may start
+
+	wake     chan struct{} // Signals a change, buffered so writers never block
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newAutoCommitter() *autoCommitter {
+	return &autoCommitter{wake: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// record accounts for a change of docs documents totalling size bytes.
+func (a *autoCommitter) record(docs int, size int64) {
+	a.mu.Lock()
+	if a.docs == 0 {
+		a.oldest = time.Now()
+	}
+	a.docs += int64(docs)
+	a.bytes += size
+	enabled := a.policy.enabled()
+	a.mu.Unlock()
+	if enabled {
+		select {
+		case a.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// measuresBytes reports whether changes must be measured for the policy.
+func (a *autoCommitter) measuresBytes() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.policy.MaxBytes > 0
+}
+
+// pending returns the number of uncommitted document changes.
+func (a *autoCommitter) pending() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.docs
+}
+
+// committed records the outcome of a commit attempt made at now. A successful commit
+// uploads every change; a failed one pauses automatic commits for autoCommitRetryDelay.
+func (a *autoCommitter) committed(now time.Time, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastAttempt = now
+	if err != nil {
+		a.lastError = err.Error()
+		a.retryAt = now.Add(autoCommitRetryDelay)
+		return
+	}
+	a.docs, a.bytes, a.oldest = 0, 0, time.Time{}
+	a.lastError, a.retryAt = "", time.Time{}
+}
+
+// next returns why a commit is due at now, or else how long to wait before checking
+// again; 0 waits for the next change.
+func (a *autoCommitter) next(now time.Time) (reason string, wait time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := a.policy
+	if !p.enabled() || a.docs == 0 {
+		return "", 0
+	}
+	switch {
+	case p.MaxDocs > 0 && a.docs >= p.MaxDocs:
+		reason = fmt.Sprintf("%d documents changed", a.docs)
+	case p.MaxBytes > 0 && a.bytes >= p.MaxBytes:
+		reason = fmt.Sprintf("%d bytes changed", a.bytes)
+	case p.MaxInterval > 0 && now.Sub(a.oldest) >= p.MaxInterval:
+		reason = fmt.Sprintf("oldest change is %s old", now.Sub(a.oldest).Round(time.Millisecond))
+	case p.MaxInterval > 0:
+		return "", a.oldest.Add(p.MaxInterval).Sub(now)
+	default:
+		return "", 0
+	}
+	notBefore := a.lastAttempt.Add(autoCommitMinGap)
+	if a.retryAt.After(notBefore) {
+		notBefore = a.retryAt
+	}
+	if now.Before(notBefore) {
+		return "", notBefore.Sub(now)
+	}
+	return reason, 0
+}
+
+// run commits through commit whenever the policy says so, until shutdown.
+func (a *autoCommitter) run(commit func(reason string) bool) {
+	defer close(a.done)
+	for {
+		select {
+		case <-a.stop:
+			return
+		default:
+		}
+		reason, wait := a.next(time.Now())
+		if reason != "" {
+			if commit(reason) {
+				a.mu.Lock()
+				a.autoCommits++
+				a.lastAutoCommit = time.Now()
+				a.mu.Unlock()
+			}
+			continue
+		}
+		var timer *time.Timer
+		var fired <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			fired = timer.C
+		}
+		select {
+		case <-a.stop:
+			return
+		case <-a.wake:
+		case <-fired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// shutdown stops the automatic commits, waiting for one in progress.
+func (a *autoCommitter) shutdown() {
+	a.stopOnce.Do(func() { close(a.stop) })
+	a.mu.Lock()
+	a.closed = true
+	running := a.running
+	a.mu.Unlock()
+	if running {
+		<-a.done
+	}
+}
+
+// SetCommitPolicy replaces the automatic commit policy. Commits are triggered as soon as
+// any threshold of the policy is reached, never less than a second apart and paused for
+// 30 seconds after a failure; the zero policy stops them.
+func (i *Indexer) SetCommitPolicy(policy CommitPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	a := i.autoCommit
+	a.mu.Lock()
+	a.policy = policy
+	start := policy.enabled() && !a.running && !a.closed
+	if start {
+		a.running = true
+	}
+	a.mu.Unlock()
+	if start {
+		go a.run(i.autoCommitAndUpload)
+	}
+	// Reevaluate the pending changes against the new thresholds.
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+	slog.Info("Commit policy set", "max_docs", policy.MaxDocs, "max_bytes", policy.MaxBytes, "max_interval", policy.MaxInterval)
+	return nil
+}
+
+// CommitPolicy returns the automatic commit policy and its progress.
+func (i *Indexer) CommitPolicy() CommitPolicyStatus {
+	a := i.autoCommit
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status := CommitPolicyStatus{
+		Policy:       a.policy,
+		PendingDocs:  a.docs,
+		PendingBytes: a.bytes,
+		AutoCommits:  a.autoCommits,
+		LastError:    a.lastError,
+	}
+	for _, t := range []struct {
+		src time.Time
+		dst **time.Time
+	}{{a.oldest, &status.OldestPending}, {a.lastAutoCommit, &status.LastAutoCommit}, {a.retryAt, &status.RetryAt}} {
+		if !t.src.IsZero() {
+			v := t.src.UTC()
+			*t.dst = &v
+		}
+	}
+	return status
+}
+
+// autoCommitAndUpload commits for the policy, reporting whether it did. Changes committed
+// by a CommitAndUpload call while it waited for the mutex leave nothing to commit.
+func (i *Indexer) autoCommitAndUpload(reason string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.autoCommit.pending() == 0 {
+		return false
+	}
+	slog.Info("Automatic commit", "reason", reason)
+	if err := i.commitAndUpload(); err != nil {
+		slog.Error("Automatic commit failed", "retry_in", autoCommitRetryDelay, "error", err)
+		return false
+	}
+	return true
+}
+
+// recordChange accounts for a successful write of docs documents for the commit policy,
+// measuring the JSON size of data while the policy has a size threshold. Callers must
+// hold i.mu.
+func (i *Indexer) recordChange(docs int, data interface{}) {
+	var size int64
+	if i.autoCommit.measuresBytes() {
+		if b, err := json.Marshal(data); err == nil {
+			size = int64(len(b))
+		}
+	}
+	i.autoCommit.record(docs, size)
+}
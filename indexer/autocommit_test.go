@@ -0,0 +1,105 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexer_AutoCommit(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+
+	if err := idx.SetCommitPolicy(CommitPolicy{MaxDocs: 2}); err != nil {
+		t.Fatalf("SetCommitPolicy returned an error: %v", err)
+	}
+	if err := idx.IndexDocument("doc1", map[string]interface{}{"title": "first"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if status := idx.CommitPolicy(); status.PendingDocs != 1 || status.OldestPending == nil {
+		t.Errorf("Expected 1 pending change, got %+v", status)
+	}
+	if err := idx.IndexDocument("doc2", map[string]interface{}{"title": "second"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for idx.CommitPolicy().AutoCommits == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected an automatic commit, got %+v", idx.CommitPolicy())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := idx.CommitPolicy(); status.PendingDocs != 0 || status.LastAutoCommit == nil {
+		t.Errorf("Expected no pending change after the commit, got %+v", status)
+	}
+	if _, err := ReadSegmentManifest(filepath.Join(tempDir, "segments", "index")); err != nil {
+		t.Errorf("Expected the segment to be uploaded: %v", err)
+	}
+}
+
+func TestAutoCommitter_Next(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := newAutoCommitter()
+	a.policy = CommitPolicy{MaxBytes: 100, MaxInterval: time.Minute}
+	if reason, wait := a.next(now); reason != "" || wait != 0 {
+		t.Errorf("Expected to wait for a change, got %q, %s", reason, wait)
+	}
+
+	a.docs, a.bytes, a.oldest = 1, 10, now
+	if reason, wait := a.next(now.Add(20 * time.Second)); reason != "" || wait != 40*time.Second {
+		t.Errorf("Expected to wait for the interval, got %q, %s", reason, wait)
+	}
+	if reason, _ := a.next(now.Add(time.Minute)); !strings.Contains(reason, "old") {
+		t.Errorf("Expected the interval to trigger a commit, got %q", reason)
+	}
+	a.bytes = 100
+	if reason, _ := a.next(now); !strings.Contains(reason, "bytes") {
+		t.Errorf("Expected the size to trigger a commit, got %q", reason)
+	}
+
+	// Commits are spaced, and paused after a failure.
+	a.committed(now, nil)
+	a.docs, a.bytes, a.oldest = 1, 100, now
+	if reason, wait := a.next(now.Add(100 * time.Millisecond)); reason != "" || wait != autoCommitMinGap-100*time.Millisecond {
+		t.Errorf("Expected commits to be spaced, got %q, %s", reason, wait)
+	}
+	a.committed(now, errors.New("upload failed"))
+	if reason, wait := a.next(now.Add(time.Second)); reason != "" || wait != autoCommitRetryDelay-time.Second {
+		t.Errorf("Expected commits to pause after a failure, got %q, %s", reason, wait)
+	}
+	if reason, _ := a.next(now.Add(autoCommitRetryDelay)); reason == "" {
+		t.Error("Expected commits to resume after the retry delay")
+	}
+}
+
+func TestCommitPolicy_JSON(t *testing.T) {
+	var p CommitPolicy
+	if err := json.Unmarshal([]byte(`{"max_docs": 1000, "max_interval": "30s"}`), &p); err != nil {
+		t.Fatalf("Unmarshal returned an error: %v", err)
+	}
+	if p != (CommitPolicy{MaxDocs: 1000, MaxInterval: 30 * time.Second}) {
+		t.Errorf("Unexpected policy %+v", p)
+	}
+	b, err := json.Marshal(p)
+	if err != nil || string(b) != `{"max_docs":1000,"max_bytes":0,"max_interval":"30s"}` {
+		t.Errorf("Unexpected encoding %s (%v)", b, err)
+	}
+	if err := json.Unmarshal([]byte(`{"max_interval": "soon"}`), &p); !errors.Is(err, ErrInvalidCommitPolicy) {
+		t.Errorf("Expected ErrInvalidCommitPolicy, got %v", err)
+	}
+	if err := (CommitPolicy{MaxDocs: -1}).Validate(); !errors.Is(err, ErrInvalidCommitPolicy) {
+		t.Errorf("Expected ErrInvalidCommitPolicy, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"indexer/extract"
+	"indexer/ingest"
+)
+
+// Errors of the documents of a bulk write left out by the writer.
+var (
+	// ErrMapping is the error of documents that don't fit the index mapping.
+	ErrMapping = errors.New("failed to map document")
+	// ErrIndexing is the error of documents Bleve fails to index, found by splitting the
+	// failed batch of their write until they fail alone.
+	ErrIndexing = errors.New("failed to index document")
+)
+
+// BulkResponse reports the outcome of every document of a bulk write, in the shape of
+// Elasticsearch's bulk API response.
+type BulkResponse struct {
+	Took   int64      `json:"took"`   // Milliseconds the write took
+	Errors bool       `json:"errors"` // Whether any document failed
+	Items  []BulkItem `json:"items"`  // One per document, ordered by ID
+}
+
+// BulkItem holds the outcome of the index action on a document.
+type BulkItem struct {
+	Index BulkItemResult `json:"index"`
+}
+
+// BulkItemResult is the outcome of indexing a document of a bulk write.
+type BulkItemResult struct {
+	ID      string         `json:"_id"`
+	Version int64          `json:"_version,omitempty"` // Version of the indexed document
+	Result  string         `json:"result,omitempty"`   // created or updated; empty if failed
+	Status  int            `json:"status"`             // HTTP status of the document
+	Error   *BulkItemError `json:"error,omitempty"`
+}
+
+// BulkItemError explains why a document of a bulk write failed.
+type BulkItemError struct {
+	Type   string `json:"type"` // Elasticsearch error type, e.g. mapper_parsing_exception
+	Reason string `json:"reason"`
+}
+
+// Failures returns the errors of the documents that failed, by ID; nil if none did.
+func (r *BulkResponse) Failures() map[string]error {
+	var failures map[string]error
+	for _, item := range r.Items {
+		if item.Index.Error == nil {
+			continue
+		}
+		if failures == nil {
+			failures = make(map[string]error)
+		}
+		failures[item.Index.ID] = fmt.Errorf("%s: %s", item.Index.Error.Type, item.Index.Error.Reason)
+	}
+	return failures
+}
+
+// Status returns the HTTP status of the response: 200 if every document was written, the
+// status of the documents if they all failed with the same one, 207 otherwise.
+func (r *BulkResponse) Status() int {
+	if !r.Errors {
+		return http.StatusOK
+	}
+	status := 0
+	for _, item := range r.Items {
+		switch {
+		case item.Index.Error == nil:
+			return http.StatusMultiStatus
+		case status == 0:
+			status = item.Index.Status
+		case status != item.Index.Status:
+			return http.StatusMultiStatus
+		}
+	}
+	return status
+}
+
+// BulkIndexDocumentsWithPipeline indexes documents like BulkIndexDocuments, enriching them
+// with the named ingest pipeline like IndexDocumentWithPipeline. Documents whose content
+// can't be extracted, that the pipeline fails on or with invalid vectors fail alone, like
+// those that can't be mapped or indexed; the others are indexed.
+func (i *Indexer) BulkIndexDocumentsWithPipeline(docs map[string]interface{}, pipeline string) (*BulkResponse, error) {
+	start := time.Now()
+	p, err := i.pipelines.Get(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[string]error)
+	valid := make(map[string]interface{}, len(docs))
+	for id, data := range docs {
+		data, err := i.extractContent(id, data)
+		if err == nil {
+			data, err = ingestDocument(p, id, data)
+		}
+		if err == nil {
+			err = i.checkVectors(id, data)
+		}
+		if err != nil {
+			slog.Warn("Skipping document in bulk index", "error", err)
+			failed[id] = err
+			continue
+		}
+		valid[id] = data
+	}
+
+	var written map[string]interface{}
+	if len(valid) > 0 {
+		op := &writeOp{record: walRecord{Op: walOpBulk, Docs: i.fingerprintBulk(valid)}, version: AnyVersion}
+		if err := i.submitOp(op); err != nil {
+			return nil, err
+		}
+		written = op.record.Docs
+		for id, err := range op.failed {
+			failed[id] = err
+		}
+	}
+	return newBulkResponse(docs, written, failed, time.Since(start)), nil
+}
+
+// newBulkResponse reports the outcome of a bulk write of docs: the written documents,
+// with their versions, and the failed ones.
+func newBulkResponse(docs, written map[string]interface{}, failed map[string]error, took time.Duration) *BulkResponse {
+	resp := &BulkResponse{Took: took.Milliseconds(), Items: make([]BulkItem, 0, len(docs))}
+	for id := range docs {
+		result := BulkItemResult{ID: id}
+		if err, ok := failed[id]; ok {
+			resp.Errors = true
+			result.Status, result.Error = bulkError(err)
+		} else {
+			fields, _ := written[id].(map[string]interface{})
+			result.Version, _ = fields[VersionField].(int64)
+			result.Result, result.Status = "created", http.StatusCreated
+			if result.Version > 1 {
+				result.Result, result.Status = "updated", http.StatusOK
+			}
+		}
+		resp.Items = append(resp.Items, BulkItem{Index: result})
+	}
+	sort.Slice(resp.Items, func(a, b int) bool { return resp.Items[a].Index.ID < resp.Items[b].Index.ID })
+	return resp
+}
+
+// bulkError returns the HTTP status and the error reported for a document failing with
+// err; documents with invalid vectors fail with an illegal_argument_exception, and those
+// held by a read-only index with a conflict.
+func bulkError(err error) (int, *BulkItemError) {
+	status, kind := http.StatusBadRequest, "illegal_argument_exception"
+	switch {
+	case errors.Is(err, extract.ErrExtraction):
+		kind = "extraction_exception"
+	case errors.Is(err, ingest.ErrProcessing):
+		kind = "ingest_processor_exception"
+	case errors.Is(err, ErrMapping):
+		kind = "mapper_parsing_exception"
+	case errors.Is(err, ErrReadOnlyDocument):
+		status, kind = http.StatusConflict, "read_only_document_exception"
+	case errors.Is(err, ErrIndexing):
+		status, kind = http.StatusInternalServerError, "index_failed_exception"
+	}
+	return status, &BulkItemError{Type: kind, Reason: err.Error()}
+}
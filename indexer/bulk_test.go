@@ -0,0 +1,114 @@
+package indexer
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"common/vector"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+func TestIndexer_BulkIndexDocuments_PartialFailures(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	if err := idx.SetVectorFields(map[string]vector.Field{"embedding": {Dims: 2}}); err != nil {
+		t.Fatalf("SetVectorFields returned an error: %v", err)
+	}
+	if err := idx.IndexDocument("a", map[string]interface{}{"title": "Boots"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+
+	resp, err := idx.BulkIndexDocuments(map[string]interface{}{
+		"a":      map[string]interface{}{"title": "Red boots"},
+		"b":      map[string]interface{}{"title": "Gloves"},
+		"":       map[string]interface{}{"title": "No ID"},
+		"vector": map[string]interface{}{"embedding": []interface{}{1.0}},
+	})
+	if err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+	if !resp.Errors || len(resp.Items) != 4 {
+		t.Fatalf("Expected 4 items with errors, got %+v", resp)
+	}
+	want := []struct {
+		id, result, errType string
+		status              int
+		version             int64
+	}{
+		{"", "", "mapper_parsing_exception", http.StatusBadRequest, 0},
+		{"a", "updated", "", http.StatusOK, 2},
+		{"b", "created", "", http.StatusCreated, 1},
+		{"vector", "", "illegal_argument_exception", http.StatusBadRequest, 0},
+	}
+	for n, w := range want {
+		got := resp.Items[n].Index
+		if got.ID != w.id || got.Result != w.result || got.Status != w.status || got.Version != w.version {
+			t.Errorf("Item %d: expected %+v, got %+v", n, w, got)
+		}
+		if (got.Error == nil) != (w.errType == "") || got.Error != nil && got.Error.Type != w.errType {
+			t.Errorf("Item %d: expected error type %q, got %+v", n, w.errType, got.Error)
+		}
+	}
+	if failures := resp.Failures(); len(failures) != 2 || failures["vector"] == nil {
+		t.Errorf("Expected the failures of the 2 failed documents, got %v", failures)
+	}
+
+	if doc, err := idx.GetDocument("b", nil); err != nil || doc.Fields["title"] != "Gloves" {
+		t.Errorf("Expected the valid documents to be indexed, got %+v (%v)", doc, err)
+	}
+	if _, err := idx.GetDocument("vector", nil); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected the failed document not to be indexed, got %v", err)
+	}
+}
+
+func TestIndexer_IndexIsolated(t *testing.T) {
+	idx, err := NewIndexer(filepath.Join(t.TempDir(), "index"), nil)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+
+	// The document without an ID fails its halves until it fails alone.
+	docs := map[string]interface{}{"a": map[string]interface{}{"n": 1.0}, "b": map[string]interface{}{"n": 2.0}, "": map[string]interface{}{"n": 3.0}, "c": map[string]interface{}{"n": 4.0}}
+	idx.mu.Lock()
+	failed, err := idx.indexIsolated(docs, true)
+	idx.mu.Unlock()
+	if err != nil || len(failed) != 1 || failed[""] == nil {
+		t.Fatalf("Expected the document without an ID to be isolated, got %v", failed)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := idx.GetDocument(id, nil); err != nil {
+			t.Errorf("Expected document %s to be indexed, got %v", id, err)
+		}
+	}
+
+	// Writes failing for every document, or because of the index, fail as a whole.
+	idx.mu.Lock()
+	failed, err = idx.indexIsolated(map[string]interface{}{"": map[string]interface{}{"n": 5.0}}, true)
+	idx.mu.Unlock()
+	if err == nil || failed != nil {
+		t.Errorf("Expected a write whose every document fails to fail, got %v and %v", failed, err)
+	}
+	closed, err := NewIndexer(filepath.Join(t.TempDir(), "index"), nil)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	closed.Close()
+	closed.mu.Lock()
+	failed, err = closed.indexIsolated(docs, true)
+	closed.mu.Unlock()
+	if !errors.Is(err, bleve.ErrorIndexClosed) || failed != nil {
+		t.Errorf("Expected the closed index to fail the write, got %v and %v", failed, err)
+	}
+}
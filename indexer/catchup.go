@@ -0,0 +1,229 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// segmentSync is implemented by storages a new leader catches up from: S3Storage,
+// LocalFileStorage and an EncryptedStorage wrapping either.
+type segmentSync interface {
+	SegmentSource
+	ReadManifest(segment string) (*SegmentManifest, error)
+}
+
+// syncedSegment identifies the uploaded segment the index holds: the last one it was
+// uploaded as or caught up with.
+type syncedSegment struct {
+	Segment   string    `json:"segment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// matches reports whether s identifies the segment of manifest.
+func (s syncedSegment) matches(manifest *SegmentManifest) bool {
+	return s.Segment == manifest.Segment && s.CreatedAt.Equal(manifest.CreatedAt)
+}
+
+// syncedSegmentPath returns the file recording the synced segment of the index at
+// indexPath, kept next to it like the upload state.
+func syncedSegmentPath(indexPath string) string {
+	return filepath.Join(filepath.Dir(indexPath), "."+filepath.Base(indexPath)+".synced-segment.json")
+}
+
+// loadSyncedSegment returns the synced segment of the index at indexPath, the zero value
+// if none was recorded.
+func loadSyncedSegment(indexPath string) (syncedSegment, error) {
+	var synced syncedSegment
+	data, err := os.ReadFile(syncedSegmentPath(indexPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return synced, nil
+	}
+	if err != nil {
+		return synced, fmt.Errorf("failed to read the synced segment of %s: %w", indexPath, err)
+	}
+	if err := json.Unmarshal(data, &synced); err != nil {
+		return synced, fmt.Errorf("failed to unmarshal the synced segment of %s: %w", indexPath, err)
+	}
+	return synced, nil
+}
+
+// saveSyncedSegment records manifest as the synced segment of the index at indexPath.
+func saveSyncedSegment(indexPath string, manifest *SegmentManifest) error {
+	data, err := json.Marshal(syncedSegment{Segment: manifest.Segment, CreatedAt: manifest.CreatedAt})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(syncedSegmentPath(indexPath), data, 0644); err != nil {
+		return fmt.Errorf("failed to record the synced segment of %s: %w", indexPath, err)
+	}
+	return nil
+}
+
+// latestSegment returns the manifest of the latest upload of the index at indexPath, nil
+// if it was never uploaded.
+func latestSegment(source segmentSync, indexPath string) (*SegmentManifest, error) {
+	return latestUpload(source, filepath.Base(indexPath))
+}
+
+// latestUpload returns the manifest of the latest upload of the index named name, nil if
+// it was never uploaded.
+func latestUpload(source segmentSync, name string) (*SegmentManifest, error) {
+	segments, err := source.ListSegments(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the segments of %s: %w", name, err)
+	}
+	latest := ""
+	for _, segment := range segments {
+		// Sorted, and timestamps sort in time order.
+		if uploadIndexName(segment) == name {
+			latest = segment
+		}
+	}
+	if latest == "" {
+		return nil, nil
+	}
+	return source.ReadManifest(latest)
+}
+
+// recordSyncedSegment records the segment just uploaded as the one the index holds, so
+// the replica doesn't catch up with its own upload once it leads again. Only replicas
+// with a leader election catch up. Callers must hold i.mu and the upload lock.
+func (i *Indexer) recordSyncedSegment() {
+	source, ok := i.storage.(segmentSync)
+	if !ok || i.election.Load() == nil {
+		return
+	}
+	manifest, err := latestSegment(source, i.indexPath)
+	if err == nil && manifest != nil {
+		err = saveSyncedSegment(i.indexPath, manifest)
+	}
+	if err != nil {
+		slog.Error("Failed to record the uploaded segment, the next leadership will download it", "error", err)
+	}
+}
+
+// startCatchUp catches up with the latest segment in the background, unless a catch-up is
+// running, and marks the leadership term as synced once it's done.
+func (i *Indexer) startCatchUp(term uint64) {
+	if !i.catchingUp.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer i.catchingUp.Store(false)
+		if err := i.catchUp(); err != nil {
+			// Writes are refused until a retry, triggered by the next one, succeeds.
+			recordOperation("catch_up", err)
+			slog.Error("Failed to catch up with the latest segment, refusing writes", "error", err)
+			return
+		}
+		recordOperation("catch_up", nil)
+		i.syncedTerm.Store(term)
+	}()
+}
+
+// catchUp replaces the index with the latest upload of it, unless the index already holds
+// it, so a new leader continues from the segments of the previous one rather than from
+// its own stale index. The segment is downloaded next to the index, then swapped in.
+func (i *Indexer) catchUp() error {
+	source, ok := i.storage.(segmentSync)
+	if !ok {
+		slog.Warn("The storage can't download segments, leading with the local index")
+		return nil
+	}
+	i.mu.Lock()
+	indexPath := i.indexPath
+	i.mu.Unlock()
+
+	latest, err := latestSegment(source, indexPath)
+	if err != nil || latest == nil {
+		return err
+	}
+	synced, err := loadSyncedSegment(indexPath)
+	if err != nil {
+		return err
+	}
+	if synced.matches(latest) {
+		slog.Info("The index holds the latest segment", "segment", latest.Segment)
+		return nil
+	}
+
+	slog.Info("Catching up with the latest segment before accepting writes", "segment", latest.Segment)
+	return i.installSegment(source, latest, indexPath, func() {
+		// Writes logged by this replica went to the stale index.
+		if err := i.wal.truncate(); err != nil {
+			slog.Error("Failed to truncate the write-ahead log", "error", err)
+		}
+		if err := saveSyncedSegment(indexPath, latest); err != nil {
+			slog.Error("Failed to record the synced segment", "error", err)
+		}
+		slog.Info("Caught up with the latest segment", "segment", latest.Segment, "path", indexPath)
+	})
+}
+
+// installSegment replaces the index at indexPath with the uploaded segment of manifest:
+// the segment is downloaded next to the index, then swapped in under i.mu, and installed
+// is called before the mutex is released, so no write reaches the new index before it.
+// Callers must not hold i.mu.
+func (i *Indexer) installSegment(source segmentSync, manifest *SegmentManifest, indexPath string, installed func()) error {
+	downloaded := indexPath + ".catch-up"
+	if err := os.RemoveAll(downloaded); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", downloaded, err)
+	}
+	if err := source.DownloadSegment(manifest.Segment, downloaded); err != nil {
+		os.RemoveAll(downloaded)
+		return fmt.Errorf("failed to download segment %s: %w", manifest.Segment, err)
+	}
+	if err := os.Remove(filepath.Join(downloaded, ManifestFileName)); err != nil {
+		os.RemoveAll(downloaded)
+		return fmt.Errorf("failed to remove the manifest of segment %s: %w", manifest.Segment, err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.waitForMerge()
+	if i.indexPath != indexPath {
+		os.RemoveAll(downloaded)
+		return fmt.Errorf("index %s was rolled over while downloading segment %s", indexPath, manifest.Segment)
+	}
+	if err := i.index.Close(); err != nil {
+		slog.Error("Failed to close the stale index", "path", indexPath, "error", err)
+	}
+	stale := indexPath + ".stale"
+	os.RemoveAll(stale)
+	if err := os.Rename(indexPath, stale); err != nil {
+		return i.reopen(fmt.Errorf("failed to move the stale index aside: %w", err))
+	}
+	if err := os.Rename(downloaded, indexPath); err != nil {
+		os.Rename(stale, indexPath)
+		return i.reopen(fmt.Errorf("failed to move segment %s in place: %w", manifest.Segment, err))
+	}
+	index, err := bleve.Open(indexPath)
+	if err != nil {
+		os.RemoveAll(indexPath)
+		os.Rename(stale, indexPath)
+		return i.reopen(fmt.Errorf("failed to open segment %s: %w", manifest.Segment, err))
+	}
+	i.index = index
+	os.RemoveAll(stale)
+	installed()
+	return nil
+}
+
+// reopen opens the index at its path again after a failed swap, and returns err.
+// Callers must hold i.mu.
+func (i *Indexer) reopen(err error) error {
+	index, openErr := bleve.Open(i.indexPath)
+	if openErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to reopen the index: %w", openErr))
+	}
+	i.index = index
+	return err
+}
@@ -1,40 +1,537 @@
 package main
 
 import (
-	"flag"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"common/archive"
+	"common/commitbus"
+	"common/config"
+	"common/graceful"
+	"common/logging"
+	"common/schema"
+	"common/tenant"
+	"common/tlsconfig"
+	"common/vector"
 	"indexer"
+	"indexer/analyzers"
+	"indexer/extract"
+	"indexer/ingest"
 	"indexer/service"
+
+	"github.com/blevesearch/bleve/v2/mapping"
 )
 
+// Config holds the indexer's settings, read from a YAML file (-config-file), environment
+// variables and flags, in increasing order of precedence.
+type Config struct {
+	IndexPath       string           `yaml:"index_path" env:"INDEX_PATH" flag:"index-path" usage:"Path to the Bleve index"`
+	StorageDir      string           `yaml:"storage_dir" env:"STORAGE_DIR" flag:"storage-dir" usage:"Directory for segment storage"`
+	ListenAddr      string           `yaml:"listen_addr" env:"LISTEN_ADDR" flag:"listen-addr" usage:"Address to listen on"`
+	Collection      string           `yaml:"collection" env:"COLLECTION" flag:"collection" usage:"Collection served by this indexer; used to name uploaded segments"`
+	Compression     string           `yaml:"compression" env:"COMPRESSION" flag:"compression" usage:"Segment packaging for upload: none or gzip (tar+gzip archive)"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"How long in-flight requests are drained on shutdown"`
+	WAL             bool             `yaml:"wal" env:"WAL" flag:"wal" usage:"Log writes ahead of applying them, replaying uncommitted writes on startup"`
+	TLS             tlsconfig.Config `yaml:"tls"`
+	// Log sets the level and format of the logs; the level can be changed at runtime
+	// through /admin/log-level.
+	Log logging.Config `yaml:"log"`
+	// CommitSubscribers are notified of every uploaded segment: searchers download it right
+	// away, and brokers rerun their live queries.
+	CommitSubscribers []string `yaml:"commit_subscribers" env:"COMMIT_SUBSCRIBERS" flag:"commit-subscribers" usage:"Comma-separated base URLs notified of every uploaded segment"`
+	// CommitToken authenticates the commit events, which brokers refuse without it. It has
+	// no flag so it doesn't show up in process listings.
+	CommitToken string `yaml:"commit_token" env:"COMMIT_TOKEN" usage:"Shared secret sent with commit events"`
+	// Admission bounds the write requests applied and queued at once.
+	Admission service.AdmissionConfig `yaml:"admission"`
+	// Ingest enables /ingest for callers with its admin token, reading directories under
+	// its directory root and pages of its allowed hosts. The token has no flag so it
+	// doesn't show up in process listings.
+	Ingest service.IngestConfig `yaml:"ingest"`
+	// CommitPolicy commits and uploads automatically; it can be overridden at /commit/policy.
+	CommitPolicy indexer.CommitPolicy `yaml:"commit_policy"`
+	// Compaction merges the segments of the index during low-traffic windows once they
+	// pile up; its progress is reported at /compaction.
+	Compaction indexer.CompactionPolicy `yaml:"compaction"`
+	// Rollover starts a new physical index once the active one is full or old, for
+	// append-only data; the indexes of the alias are reported at /rollover.
+	Rollover indexer.RolloverPolicy `yaml:"rollover"`
+	// Retention garbage collects old uploads of the segments; the segments held by the
+	// RetentionSearchers are kept whatever their age. Collections are reported at /gc.
+	Retention          indexer.RetentionPolicy `yaml:"retention"`
+	RetentionSearchers []string                `yaml:"retention_searchers" env:"RETENTION_SEARCHERS" flag:"retention-searchers" usage:"Comma-separated base URLs of the searchers whose segments are never garbage collected"`
+	// UploadLock coordinates the uploads of indexer replicas writing to the same storage;
+	// the default lock file only excludes indexers on the same host.
+	UploadLock indexer.UploadLockConfig `yaml:"upload_lock"`
+	// LeaderElection lets replicas of a collection run side by side: only the elected
+	// leader accepts writes and commits, followers redirect writes to it. A new leader
+	// first loads the latest segment uploaded by the previous one.
+	LeaderElection indexer.ElectionConfig `yaml:"leader_election"`
+	// EncryptionKey enables client-side AES-GCM encryption of uploaded segments and
+	// snapshots; Searchers decrypt them with the same key, their tiering.encryption_key.
+	// It has no flag so the key doesn't show up in process listings.
+	EncryptionKey string `yaml:"encryption_key" env:"ENCRYPTION_KEY" usage:"Hex-encoded 16, 24 or 32 byte AES key encrypting uploaded segments"`
+	// Tenants other than the default one get their own index under
+	// <index path dir>/tenants/<tenant>/ and their own segments under storage_dir/tenants/<tenant>/.
+	// Only provisioned tenants are served: those listed in Tenants and those whose index
+	// exists, so requests can't create indexes for arbitrary tenant IDs.
+	MultiTenant  bool               `yaml:"multi_tenant" env:"MULTI_TENANT" flag:"multi-tenant" usage:"Serve requests naming a tenant from per-tenant indexes"`
+	Tenants      []string           `yaml:"tenants" env:"TENANTS" flag:"tenants" usage:"Comma-separated tenants whose index is created on their first request; tenants with an index are served too"`
+	MaxTenants   int                `yaml:"max_tenants" env:"MAX_TENANTS" flag:"max-tenants" usage:"Tenant indexes open at once; 0 uses the default"`
+	TenantQuotas tenant.QuotaConfig `yaml:"tenant_quotas"`
+	// ExtractContent turns HTML and PDF documents into text, a title and metadata, as
+	// configured per media type by ContentExtraction, e.g.
+	// {"application/pdf": {max_text_length: 100000}}.
+	ExtractContent    bool                      `yaml:"extract_content" env:"EXTRACT_CONTENT" flag:"extract-content" usage:"Extract the text, title and metadata of HTML and PDF documents by their content_type"`
+	ContentExtraction map[string]extract.Config `yaml:"content_extraction"`
+	// IngestPipelines enrich documents after content extraction, e.g.
+	// {logs: {processors: [{type: grok, field: message, pattern: "..."}]}}; writes select
+	// one with the pipeline parameter or get DefaultIngestPipeline.
+	IngestPipelines       map[string]ingest.Config `yaml:"ingest_pipelines"`
+	DefaultIngestPipeline string                   `yaml:"default_ingest_pipeline" env:"DEFAULT_INGEST_PIPELINE" flag:"default-ingest-pipeline" usage:"Ingest pipeline of writes that don't select one; empty applies none"`
+	// FingerprintFields are the text fields whose SimHash is stored with every document,
+	// letting brokers collapse near-duplicate results.
+	FingerprintFields []string `yaml:"fingerprint_fields" env:"FINGERPRINT_FIELDS" flag:"fingerprint-fields" usage:"Comma-separated text fields fingerprinted for near-duplicate detection; empty disables it"`
+	// NestedFields are the fields holding arrays of objects, e.g. items, flattened into
+	// sub-documents so that nested filters match within a single object.
+	NestedFields []string `yaml:"nested_fields" env:"NESTED_FIELDS" flag:"nested-fields" usage:"Comma-separated fields whose arrays of objects are indexed as nested sub-documents"`
+	// Documents with an expires_at date stop being searchable once it passes, and are
+	// deleted by a sweep every ExpirySweepInterval.
+	ExpirySweepInterval  time.Duration `yaml:"expiry_sweep_interval" env:"EXPIRY_SWEEP_INTERVAL" flag:"expiry-sweep-interval" usage:"How often expired documents are deleted; 0 disables the sweeper"`
+	ExpirySweepBatchSize int           `yaml:"expiry_sweep_batch_size" env:"EXPIRY_SWEEP_BATCH_SIZE" flag:"expiry-sweep-batch-size" usage:"Number of expired documents deleted per write"`
+	// Analyzers defines custom analyzers that schemas and mappings can name, in addition
+	// to the built-in ones of package analyzers.
+	Analyzers []analyzers.Definition `yaml:"analyzers"`
+	// Schema generates the mapping of new indexes from an index schema, e.g. of the query
+	// understanding configuration, instead of mapping.json.
+	Schema     string `yaml:"schema" env:"SCHEMA" flag:"schema" usage:"YAML file whose index_schemas define the mapping of new indexes"`
+	SchemaName string `yaml:"schema_name" env:"SCHEMA_NAME" flag:"schema-name" usage:"Index schema to use; may be omitted if the file defines only one"`
+}
+
+// newStorage returns the segment storage of a tenant, encrypting segments if an
+// encryption key is configured.
+func newStorage(cfg Config, compression archive.Compression, tenantID string) (indexer.IndexSegmentStorage, error) {
+	storage, err := indexer.NewLocalFileStorage(cfg.StorageDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.SetTenant(tenantID); err != nil {
+		return nil, err
+	}
+	if cfg.Collection != "" {
+		if err := storage.SetCollection(cfg.Collection); err != nil {
+			return nil, err
+		}
+	}
+	if err := storage.SetCompression(compression); err != nil {
+		return nil, err
+	}
+	if cfg.EncryptionKey == "" {
+		return storage, nil
+	}
+	key, err := indexer.ParseEncryptionKey(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return indexer.NewEncryptedStorage(storage, key)
+}
+
+// newUploadLock returns the upload lock of a tenant's segments, nil for the default lock
+// file.
+func newUploadLock(cfg Config, tenantID string) (indexer.UploadLock, error) {
+	return indexer.NewUploadLock(cfg.UploadLock, path.Join("indexer/upload", tenant.StoragePrefix(tenantID), cfg.Collection))
+}
+
+// newContentExtraction returns the content extraction pipeline, nil if it's disabled.
+func newContentExtraction(cfg Config) (*extract.Pipeline, error) {
+	if !cfg.ExtractContent {
+		return nil, nil
+	}
+	pipeline := extract.NewPipeline()
+	if err := pipeline.Configure(cfg.ContentExtraction); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+// loadSchema returns the configured index schema and the mapping it generates, nil
+// without schema.
+func loadSchema(cfg Config) (*schema.IndexSchema, mapping.IndexMapping, error) {
+	if cfg.Schema == "" {
+		return nil, nil, nil
+	}
+	schemas, err := schema.Load(cfg.Schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := schema.Find(schemas, cfg.SchemaName)
+	if err != nil {
+		return nil, nil, err
+	}
+	indexMapping, err := indexer.MappingFromSchema(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &s, indexMapping, nil
+}
+
+// checkSchema logs where the mapping of an existing index differs from the schema.
+func checkSchema(s *schema.IndexSchema, idx *indexer.Indexer) {
+	mismatches, err := indexer.CompareMapping(*s, idx.Mapping())
+	if err != nil {
+		slog.Warn("Could not compare the index mapping with the schema", "schema", s.Name, "error", err)
+		return
+	}
+	for _, m := range mismatches {
+		slog.Warn("Index mapping differs from the schema", "schema", s.Name, "difference", m)
+	}
+	if len(mismatches) > 0 {
+		slog.Info("The index predates the schema; update its mapping at /mapping to apply the schema", "schema", s.Name)
+	}
+}
+
+// tenantIndexPath returns the index path of a tenant other than the default one.
+func tenantIndexPath(indexPath, tenantID string) string {
+	return filepath.Join(filepath.Dir(indexPath), "tenants", tenantID, filepath.Base(indexPath))
+}
+
+// newCommitPublisher returns the publisher notifying the commit subscribers, nil if there
+// are none.
+func newCommitPublisher(cfg Config, transport *http.Transport) (*commitbus.Publisher, error) {
+	if len(cfg.CommitSubscribers) == 0 {
+		return nil, nil
+	}
+	var rt http.RoundTripper
+	if transport != nil {
+		rt = transport
+	}
+	publisher, err := commitbus.NewPublisher(cfg.CommitSubscribers, rt)
+	if err != nil {
+		return nil, err
+	}
+	publisher.SetToken(cfg.CommitToken)
+	return publisher, nil
+}
+
+// newSegmentRegistry returns the registry of the segments the searchers of the tenant's
+// collection use, nil if no searchers are configured.
+func newSegmentRegistry(cfg Config, tenantID string, transport *http.Transport) (indexer.SegmentRegistry, error) {
+	if len(cfg.RetentionSearchers) == 0 {
+		return nil, nil
+	}
+	var rt http.RoundTripper
+	if transport != nil {
+		rt = transport
+	}
+	return indexer.NewSearcherRegistry(cfg.RetentionSearchers, tenantID, cfg.Collection, rt)
+}
+
+// tenantIndexers returns the factory of the indexes of tenants other than the default one,
+// opening those of the configured tenants and of the tenants with an index directory.
+func tenantIndexers(cfg Config, compression archive.Compression, transport *http.Transport, publisher *commitbus.Publisher, extraction *extract.Pipeline, pipelines *ingest.Pipelines, vectorFields map[string]vector.Field, indexMapping mapping.IndexMapping, election *indexer.Election) service.TenantIndexerFactory {
+	provisioned := make(map[string]bool, len(cfg.Tenants))
+	for _, id := range cfg.Tenants {
+		provisioned[id] = true
+	}
+	return func(tenantID string) (*indexer.Indexer, error) {
+		if !provisioned[tenantID] {
+			if _, err := os.Stat(filepath.Dir(tenantIndexPath(cfg.IndexPath, tenantID))); err != nil {
+				return nil, service.ErrUnknownTenant
+			}
+		}
+		storage, err := newStorage(cfg, compression, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := indexer.NewIndexerWithMapping(tenantIndexPath(cfg.IndexPath, tenantID), storage, indexMapping)
+		if err != nil {
+			return nil, err
+		}
+		lock, err := newUploadLock(cfg, tenantID)
+		if err != nil {
+			idx.Close()
+			return nil, err
+		}
+		if lock != nil {
+			idx.SetUploadLock(lock)
+		}
+		if election != nil {
+			idx.SetLeaderElection(election)
+		}
+		// Replayed writes flatten their nested objects too.
+		idx.SetNestedFields(cfg.NestedFields)
+		if cfg.WAL {
+			if _, err := idx.EnableWAL(); err != nil {
+				idx.Close()
+				return nil, err
+			}
+		}
+		if transport != nil {
+			idx.SetClientTransport(transport)
+		}
+		if err := idx.SetCommitPolicy(cfg.CommitPolicy); err != nil {
+			idx.Close()
+			return nil, err
+		}
+		if err := idx.SetCompactionPolicy(cfg.Compaction); err != nil {
+			idx.Close()
+			return nil, err
+		}
+		if err := idx.SetRolloverPolicy(cfg.Rollover); err != nil {
+			idx.Close()
+			return nil, err
+		}
+		registry, err := newSegmentRegistry(cfg, tenantID, transport)
+		if err != nil {
+			idx.Close()
+			return nil, err
+		}
+		if registry != nil {
+			idx.SetSegmentRegistry(registry)
+		}
+		if err := idx.SetRetentionPolicy(cfg.Retention); err != nil {
+			idx.Close()
+			return nil, err
+		}
+		if publisher != nil {
+			idx.SetCommitPublisher(publisher, tenantID, cfg.Collection)
+		}
+		if extraction != nil {
+			idx.SetContentExtraction(extraction)
+		}
+		idx.SetIngestPipelines(pipelines)
+		if err := idx.SetVectorFields(vectorFields); err != nil {
+			idx.Close()
+			return nil, err
+		}
+		idx.SetFingerprintFields(cfg.FingerprintFields)
+		if cfg.ExpirySweepInterval > 0 {
+			if err := idx.StartExpirySweeper(cfg.ExpirySweepInterval, cfg.ExpirySweepBatchSize); err != nil {
+				idx.Close()
+				return nil, err
+			}
+		}
+		return idx, nil
+	}
+}
+
 func main() {
-	var (
-		indexPath  = flag.String("index-path", "/tmp/data/bleve_index", "Path to the Bleve index")
-		storageDir = flag.String("storage-dir", "/tmp/data/uploaded_segments", "Directory for segment storage")
-		listenAddr = flag.String("listen-addr", ":8081", "Address to listen on")
-	)
-	flag.Parse()
+	cfg := Config{
+		IndexPath:            "/tmp/data/bleve_index",
+		StorageDir:           "/tmp/data/uploaded_segments",
+		ListenAddr:           ":8081",
+		Compression:          "none",
+		ShutdownTimeout:      graceful.DefaultTimeout,
+		WAL:                  true,
+		ExtractContent:       true,
+		FingerprintFields:    []string{"title", "content"},
+		ExpirySweepInterval:  time.Minute,
+		ExpirySweepBatchSize: indexer.DefaultSweepBatchSize,
+		Admission: service.AdmissionConfig{
+			MaxInFlight:  4,
+			MaxQueue:     64,
+			QueueTimeout: 5 * time.Second,
+			RetryAfter:   time.Second,
+		},
+		Log: logging.DefaultConfig(),
+	}
+	config.MustLoad(&cfg)
+	if err := logging.Setup(cfg.Log); err != nil {
+		log.Fatalf("Invalid log configuration: %v", err)
+	}
 
-	log.Println("Starting Indexer service...")
+	slog.Info("Starting Indexer service")
+
+	if err := cfg.CommitPolicy.Validate(); err != nil {
+		log.Fatalf("Invalid commit policy: %v", err)
+	}
+	if err := cfg.Compaction.Validate(); err != nil {
+		log.Fatalf("Invalid compaction policy: %v", err)
+	}
+	if err := cfg.Rollover.Validate(); err != nil {
+		log.Fatalf("Invalid rollover policy: %v", err)
+	}
+	if err := cfg.Retention.Validate(); err != nil {
+		log.Fatalf("Invalid retention policy: %v", err)
+	}
+	uploadLock, err := newUploadLock(cfg, tenant.Default)
+	if err != nil {
+		log.Fatalf("Invalid upload lock: %v", err)
+	}
+	election, err := indexer.NewElection(cfg.LeaderElection)
+	if err != nil {
+		log.Fatalf("Invalid leader election: %v", err)
+	}
+	compression, err := archive.ParseCompression(cfg.Compression)
+	if err != nil {
+		log.Fatalf("Invalid compression: %v", err)
+	}
+	for _, id := range cfg.Tenants {
+		if id == tenant.Default {
+			log.Fatalf("Invalid tenants: the default tenant is always served")
+		}
+		if err := tenant.Validate(id); err != nil {
+			log.Fatalf("Invalid tenants: %v", err)
+		}
+	}
+	limiter, err := tenant.NewLimiter(cfg.TenantQuotas)
+	if err != nil {
+		log.Fatalf("Invalid tenant quotas: %v", err)
+	}
+	extraction, err := newContentExtraction(cfg)
+	if err != nil {
+		log.Fatalf("Invalid content extraction: %v", err)
+	}
+	pipelines, err := ingest.NewPipelines(cfg.IngestPipelines, cfg.DefaultIngestPipeline)
+	if err != nil {
+		log.Fatalf("Invalid ingest pipelines: %v", err)
+	}
+	for _, def := range cfg.Analyzers {
+		if err := analyzers.Register(def); err != nil {
+			log.Fatalf("Invalid analyzer: %v", err)
+		}
+	}
+	indexSchema, indexMapping, err := loadSchema(cfg)
+	if err != nil {
+		log.Fatalf("Invalid index schema: %v", err)
+	}
 
 	// Initialize local file storage
-	storage, err := indexer.NewLocalFileStorage(*storageDir)
+	storage, err := newStorage(cfg, compression, tenant.Default)
 	if err != nil {
 		log.Fatalf("Failed to initialize local file storage: %v", err)
 	}
-	log.Printf("Local file storage initialized at %s", *storageDir)
+	slog.Info("Local file storage initialized", "dir", cfg.StorageDir, "compression", compression, "encrypted", cfg.EncryptionKey != "")
 
 	// Initialize the Indexer service
-	indexer, err := indexer.NewIndexer(*indexPath, storage)
+	indexer, err := indexer.NewIndexerWithMapping(cfg.IndexPath, storage, indexMapping)
 	if err != nil {
 		log.Fatalf("Failed to initialize Indexer: %v", err)
 	}
-	log.Println("Indexer service initialized.")
+	if indexSchema != nil {
+		checkSchema(indexSchema, indexer)
+	}
+	if uploadLock != nil {
+		indexer.SetUploadLock(uploadLock)
+		slog.Info("Coordinating uploads with an upload lock", "type", cfg.UploadLock.Type)
+	}
+	if election != nil {
+		election.Start()
+		indexer.SetLeaderElection(election)
+		slog.Info("Campaigning for the leadership", "key", cfg.LeaderElection.Key, "type", cfg.LeaderElection.Type, "advertise", cfg.LeaderElection.Advertise)
+	}
+	if len(cfg.NestedFields) > 0 {
+		// Replayed writes flatten their nested objects too.
+		indexer.SetNestedFields(cfg.NestedFields)
+		slog.Info("Indexing nested objects as sub-documents", "fields", strings.Join(cfg.NestedFields, ", "))
+	}
+	if cfg.WAL {
+		replayed, err := indexer.EnableWAL()
+		if err != nil {
+			log.Fatalf("Failed to enable the write-ahead log: %v", err)
+		}
+		slog.Info("Write-ahead log enabled", "replayed", replayed)
+	}
+	slog.Info("Indexer service initialized")
+
+	// The TLS settings enable TLS on the API and mTLS towards external document stores.
+	transport, err := tlsconfig.ClientTransport(cfg.TLS)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	if transport != nil {
+		indexer.SetClientTransport(transport)
+	}
+	if err := indexer.SetCommitPolicy(cfg.CommitPolicy); err != nil {
+		log.Fatalf("Invalid commit policy: %v", err)
+	}
+	if err := indexer.SetCompactionPolicy(cfg.Compaction); err != nil {
+		log.Fatalf("Invalid compaction policy: %v", err)
+	}
+	if err := indexer.SetRolloverPolicy(cfg.Rollover); err != nil {
+		log.Fatalf("Invalid rollover policy: %v", err)
+	}
+	registry, err := newSegmentRegistry(cfg, tenant.Default, transport)
+	if err != nil {
+		log.Fatalf("Invalid retention searchers: %v", err)
+	}
+	if registry != nil {
+		indexer.SetSegmentRegistry(registry)
+	}
+	if err := indexer.SetRetentionPolicy(cfg.Retention); err != nil {
+		log.Fatalf("Invalid retention policy: %v", err)
+	}
+	publisher, err := newCommitPublisher(cfg, transport)
+	if err != nil {
+		log.Fatalf("Invalid commit subscribers: %v", err)
+	}
+	if publisher != nil {
+		indexer.SetCommitPublisher(publisher, tenant.Default, cfg.Collection)
+		slog.Info("Notifying commit subscribers of uploaded segments", "subscribers", len(cfg.CommitSubscribers))
+	}
+	if extraction != nil {
+		indexer.SetContentExtraction(extraction)
+		slog.Info("Extracting the content of documents", "media_types", strings.Join(extraction.MediaTypes(), ", "))
+	}
+	if len(cfg.IngestPipelines) > 0 {
+		indexer.SetIngestPipelines(pipelines)
+		slog.Info("Ingest pipelines loaded", "pipelines", strings.Join(pipelines.Names(), ", "))
+	}
+	var vectorFields map[string]vector.Field
+	if indexSchema != nil {
+		vectorFields = indexSchema.VectorFields()
+	}
+	if len(vectorFields) > 0 {
+		if err := indexer.SetVectorFields(vectorFields); err != nil {
+			log.Fatalf("Invalid vector fields: %v", err)
+		}
+		slog.Info("Checking the vectors of vector fields", "fields", len(vectorFields))
+	}
+	if len(cfg.FingerprintFields) > 0 {
+		indexer.SetFingerprintFields(cfg.FingerprintFields)
+		slog.Info("Fingerprinting fields for near-duplicate detection", "fields", strings.Join(cfg.FingerprintFields, ", "))
+	}
+	if cfg.ExpirySweepInterval > 0 {
+		if err := indexer.StartExpirySweeper(cfg.ExpirySweepInterval, cfg.ExpirySweepBatchSize); err != nil {
+			log.Fatalf("Invalid expiry sweeper configuration: %v", err)
+		}
+		slog.Info("Deleting expired documents periodically", "interval", cfg.ExpirySweepInterval)
+	}
 
 	// Create and start the web service
-	ws := service.NewWebService(indexer, *listenAddr)
+	ws := service.NewWebService(indexer, cfg.ListenAddr)
+	ws.SetTLSConfig(cfg.TLS)
+	ws.SetShutdownTimeout(cfg.ShutdownTimeout)
+	ws.SetTenantLimiter(limiter)
+	if err := ws.SetAdmission(cfg.Admission); err != nil {
+		log.Fatalf("Invalid admission configuration: %v", err)
+	}
+	if err := ws.SetIngest(cfg.Ingest); err != nil {
+		log.Fatalf("Invalid ingest configuration: %v", err)
+	}
+	if cfg.MultiTenant {
+		ws.SetTenantIndexers(tenantIndexers(cfg, compression, transport, publisher, extraction, pipelines, vectorFields, indexMapping, election), cfg.MaxTenants)
+	}
 	if err := ws.Start(); err != nil {
 		log.Fatalf("Failed to start web service: %v", err)
 	}
+
+	// Requests are drained; closing the indexes flushes them to disk and releases their file locks.
+	if err := ws.CloseTenantIndexers(); err != nil {
+		log.Fatalf("Failed to close tenant indexes: %v", err)
+	}
+	if err := indexer.Close(); err != nil {
+		log.Fatalf("Failed to close index: %v", err)
+	}
+	// No commit is in flight anymore: a leader hands over to another replica.
+	if election != nil {
+		election.Stop()
+	}
+	slog.Info("Indexer service stopped")
 }
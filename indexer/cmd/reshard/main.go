@@ -0,0 +1,131 @@
+// Command reshard splits or merges the shards of a collection: it streams the documents
+// of the existing shards into a new number of shards, routing every document by the hash
+// of its ID, uploads the new indexes, has the indexers of the new shards load them and
+// replaces the routing table read by the router and the shard map read by the brokers.
+//
+// An online resharding keeps the collection writable: the routers mirror writes to the
+// new shards, whose indexers journal them, while snapshots of the live shards, taken
+// through -source-indexers, are copied:
+//
+//	reshard -source-indexers http://idx-a:8081,http://idx-b:8081 \
+//	    -source-collections products-0,products-1 -shards 4 -target-dir /data/reshard \
+//	    -storage-dir /segments -collection products \
+//	    -indexers http://idx-0:8081,http://idx-1:8081,http://idx-2:8081,http://idx-3:8081 \
+//	    -routing-table /etc/router/routing.json -rate 2000 \
+//	    -shard-map /etc/broker/products.json -searchers 'http://s-0a|http://s-0b,http://s-1,http://s-2,http://s-3'
+//
+// An offline resharding reads -source Bleve index paths, of stopped indexers, or http(s)
+// document store URLs instead. An interrupted resharding resumes where it stopped when
+// run again with the same sources and shards. New shard n is uploaded as the index
+// shard-<n> of the collection <collection>-shard-<n>, to -storage-dir or to the
+// -s3-bucket, which the indexer of the shard must read its segments from.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"indexer"
+)
+
+func main() {
+	sources := flag.String("source", "", "Comma-separated source shards of an offline resharding: Bleve index paths or document store URLs")
+	sourceIndexers := flag.String("source-indexers", "", "Comma-separated indexer URLs of the source shards of an online resharding, snapshotted instead of -source")
+	sourceCollections := flag.String("source-collections", "", "Comma-separated collections the source indexers store their snapshots in, in shard order")
+	settleDelay := flag.Duration("settle-delay", 30*time.Second, "How long the routers are given to mirror writes to the new shards before the sources are snapshotted")
+	shards := flag.Int("shards", 0, "Number of new shards")
+	targetDir := flag.String("target-dir", "", "Directory where the new shard indexes are built")
+	batchSize := flag.Int("batch-size", 500, "Documents read from a source at a time")
+	rate := flag.Float64("rate", 0, "Maximum documents copied per second; 0 is unlimited")
+	storageDir := flag.String("storage-dir", "", "Local segment storage directory the new shards are uploaded to")
+	s3Bucket := flag.String("s3-bucket", "", "S3 bucket the new shards are uploaded to")
+	collection := flag.String("collection", "", "Collection of the shards; new shard n is uploaded as <collection>-shard-<n>")
+	indexers := flag.String("indexers", "", "Comma-separated indexer URLs of the new shards, in shard order")
+	routingTable := flag.String("routing-table", "", "Routing table file replaced once the new shards are loaded")
+	shardMap := flag.String("shard-map", "", "Broker shard map file of the collection replaced once the routing table is")
+	tenantID := flag.String("tenant", "", "Tenant of the collection in the shard map")
+	searchers := flag.String("searchers", "", "Comma-separated searchers of the new shards for the shard map, in shard order, the replicas of a shard separated by '|'")
+	flag.Parse()
+
+	if (*sources == "") == (*sourceIndexers == "") || *shards <= 0 || *targetDir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg := indexer.ReshardConfig{
+		TargetDir:    *targetDir,
+		Shards:       *shards,
+		BatchSize:    *batchSize,
+		Rate:         *rate,
+		SettleDelay:  *settleDelay,
+		RoutingTable: *routingTable,
+		ShardMap:     *shardMap,
+		Tenant:       *tenantID,
+		Collection:   *collection,
+	}
+	if *sources != "" {
+		cfg.Sources = strings.Split(*sources, ",")
+	}
+	if *indexers != "" {
+		cfg.Indexers = strings.Split(*indexers, ",")
+	}
+	if *searchers != "" {
+		for _, replicas := range strings.Split(*searchers, ",") {
+			cfg.Searchers = append(cfg.Searchers, strings.Split(replicas, "|"))
+		}
+	}
+	if *sourceIndexers != "" {
+		cfg.SourceIndexers = strings.Split(*sourceIndexers, ",")
+		for _, c := range strings.Split(*sourceCollections, ",") {
+			storage, err := newStorage(*storageDir, *s3Bucket, c)
+			if err != nil {
+				log.Fatalf("Failed to initialize storage of source collection %s: %v", c, err)
+			}
+			snapshots, ok := storage.(indexer.SnapshotStorage)
+			if !ok {
+				log.Fatalf("The storage of source collection %s can't store snapshots", c)
+			}
+			cfg.SourceStorages = append(cfg.SourceStorages, snapshots)
+		}
+	}
+	if *storageDir != "" || *s3Bucket != "" {
+		if *collection == "" {
+			log.Fatal("-collection is required to upload the new shards")
+		}
+		for n := 0; n < *shards; n++ {
+			storage, err := newStorage(*storageDir, *s3Bucket, fmt.Sprintf("%s-shard-%d", *collection, n))
+			if err != nil {
+				log.Fatalf("Failed to initialize storage of shard %d: %v", n, err)
+			}
+			cfg.Storages = append(cfg.Storages, storage)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if _, err := indexer.Reshard(ctx, cfg); err != nil {
+		log.Fatalf("Resharding failed, run again to resume: %v", err)
+	}
+}
+
+// newStorage returns the storage a new shard is uploaded to as the given collection.
+func newStorage(dir, bucket, collection string) (indexer.IndexSegmentStorage, error) {
+	if bucket != "" {
+		s, err := indexer.NewS3Storage(bucket)
+		if err != nil {
+			return nil, err
+		}
+		return s, s.SetCollection(collection)
+	}
+	s, err := indexer.NewLocalFileStorage(dir)
+	if err != nil {
+		return nil, err
+	}
+	return s, s.SetCollection(collection)
+}
@@ -0,0 +1,109 @@
+// Command schemacheck validates an index schema and reports where an index mapping
+// differs from the mapping the schema generates:
+//
+//	schemacheck -schema config.yaml -name products -index /tmp/data/bleve_index
+//	schemacheck -schema config.yaml -name products -mapping mapping.json
+//	schemacheck -schema config.yaml -name products -print > mapping.json
+//
+// Schemas may name the custom analyzers of package analyzers; those defined in the
+// indexer configuration are registered with -analyzers indexer.yaml.
+// It exits with status 1 if there are mismatches, so it can guard deployments.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"common/schema"
+	"indexer"
+	"indexer/analyzers"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+func main() {
+	schemaFile := flag.String("schema", "", "YAML file defining index_schemas, e.g. the query understanding config")
+	name := flag.String("name", "", "Index schema to check; may be omitted if the file defines only one")
+	indexPath := flag.String("index", "", "Existing Bleve index whose mapping is compared with the schema")
+	mappingFile := flag.String("mapping", "", "JSON index mapping compared with the schema, e.g. mapping.json")
+	analyzerFile := flag.String("analyzers", "", "Indexer configuration file whose custom analyzers are registered first")
+	printMapping := flag.Bool("print", false, "Print the mapping generated from the schema as JSON")
+	flag.Parse()
+
+	if *schemaFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *analyzerFile != "" {
+		defs, err := analyzers.Load(*analyzerFile)
+		if err != nil {
+			log.Fatalf("Invalid analyzers: %v", err)
+		}
+		for _, def := range defs {
+			if err := analyzers.Register(def); err != nil {
+				log.Fatalf("Invalid analyzer: %v", err)
+			}
+		}
+	}
+	schemas, err := schema.Load(*schemaFile)
+	if err != nil {
+		log.Fatalf("Invalid schema file: %v", err)
+	}
+	s, err := schema.Find(schemas, *name)
+	if err != nil {
+		log.Fatalf("Invalid schema: %v", err)
+	}
+	generated, err := indexer.MappingFromSchema(s)
+	if err != nil {
+		log.Fatalf("Invalid schema: %v", err)
+	}
+	if *printMapping {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(generated); err != nil {
+			log.Fatalf("Failed to write mapping: %v", err)
+		}
+	}
+
+	mismatched := false
+	if *indexPath != "" {
+		index, err := bleve.OpenUsing(*indexPath, map[string]interface{}{"read_only": true})
+		if err != nil {
+			log.Fatalf("Failed to open index %s: %v", *indexPath, err)
+		}
+		mismatched = report(s, *indexPath, index.Mapping()) || mismatched
+		index.Close()
+	}
+	if *mappingFile != "" {
+		m, err := indexer.LoadIndexMapping(*mappingFile)
+		if err != nil {
+			log.Fatalf("Invalid mapping: %v", err)
+		}
+		mismatched = report(s, *mappingFile, m) || mismatched
+	}
+	if mismatched {
+		os.Exit(1)
+	}
+}
+
+// report prints the mismatches between the schema and the mapping read from source,
+// reporting whether there are any.
+func report(s schema.IndexSchema, source string, m mapping.IndexMapping) bool {
+	mismatches, err := indexer.CompareMapping(s, m)
+	if err != nil {
+		log.Fatalf("Failed to compare %s with schema '%s': %v", source, s.Name, err)
+	}
+	if len(mismatches) == 0 {
+		fmt.Fprintf(os.Stderr, "%s matches schema '%s'\n", source, s.Name)
+		return false
+	}
+	fmt.Printf("%s differs from schema '%s':\n", source, s.Name)
+	for _, mismatch := range mismatches {
+		fmt.Printf("  %s\n", mismatch)
+	}
+	return true
+}
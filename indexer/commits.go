@@ -0,0 +1,46 @@
+package indexer
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"common/commitbus"
+)
+
+// commitPublisher announces the segments uploaded by CommitAndUpload.
+type commitPublisher struct {
+	publisher  *commitbus.Publisher
+	tenant     string
+	collection string
+}
+
+// SetCommitPublisher makes every successful CommitAndUpload announce the uploaded segment
+// of the given tenant and collection through p, in the background; nil stops announcing.
+func (i *Indexer) SetCommitPublisher(p *commitbus.Publisher, tenantID, collection string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if p == nil {
+		i.commits = nil
+		return
+	}
+	i.commits = &commitPublisher{publisher: p, tenant: tenantID, collection: collection}
+}
+
+// publishCommit announces the segment just uploaded. Callers must hold i.mu.
+func (i *Indexer) publishCommit() {
+	if i.commits == nil {
+		return
+	}
+	docCount, err := i.index.DocCount()
+	if err != nil {
+		slog.Error("Failed to count documents of the committed segment", "error", err)
+	}
+	i.commits.publisher.PublishAsync(commitbus.Event{
+		Tenant:      i.commits.tenant,
+		Collection:  i.commits.collection,
+		Segment:     filepath.Base(i.indexPath),
+		DocCount:    docCount,
+		CommittedAt: time.Now().UTC(),
+	})
+}
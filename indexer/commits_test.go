@@ -0,0 +1,47 @@
+package indexer
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"common/commitbus"
+)
+
+func TestIndexer_PublishCommit(t *testing.T) {
+	events := make(chan commitbus.Event, 1)
+	server := httptest.NewServer(commitbus.Handler(func(e commitbus.Event) { events <- e }))
+	defer server.Close()
+	publisher, err := commitbus.NewPublisher([]string{server.URL}, nil)
+	if err != nil {
+		t.Fatalf("NewPublisher returned an error: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	idx.SetCommitPublisher(publisher, "shop", "products")
+
+	if err := idx.IndexDocument("doc1", map[string]interface{}{"title": "first"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if err := idx.CommitAndUpload(); err != nil {
+		t.Fatalf("CommitAndUpload returned an error: %v", err)
+	}
+	select {
+	case e := <-events:
+		if e.Tenant != "shop" || e.Collection != "products" || e.Segment != "index" || e.DocCount != 1 {
+			t.Errorf("Unexpected commit event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the commit to be published")
+	}
+}
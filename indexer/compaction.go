@@ -0,0 +1,535 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2/index/scorch"
+	"github.com/blevesearch/bleve/v2/index/scorch/mergeplan"
+)
+
+// DefaultCompactionCheckInterval is how often the compaction scheduler checks the
+// segments of the index when its policy sets no interval.
+const DefaultCompactionCheckInterval = 10 * time.Minute
+
+var (
+	// ErrInvalidCompactionPolicy is returned for compaction policies with negative
+	// thresholds or malformed windows.
+	ErrInvalidCompactionPolicy = errors.New("invalid compaction policy")
+	// ErrCompactionRunning is returned when a compaction is requested while one runs.
+	ErrCompactionRunning = errors.New("compaction already running")
+	// ErrCompactionUnsupported is returned for indexes without segments to merge, i.e.
+	// not stored by Bleve's scorch engine.
+	ErrCompactionUnsupported = errors.New("index does not support compaction")
+)
+
+// CompactionPolicy sets when the indexer merges the segments of its index on its own: once
+// any threshold is exceeded, during one of Windows. Bleve merges small segments as they're
+// written, but long-running indexes still accumulate segments of skewed sizes and deleted
+// documents, slowing searches down; a compaction merges them into TargetSegments. The zero
+// policy leaves compactions to Compact callers.
+type CompactionPolicy struct {
+	MaxSegments     int     `yaml:"max_segments" env:"COMPACTION_MAX_SEGMENTS" flag:"compaction-max-segments" usage:"Compact once the index has more segments than this; 0 disables"`
+	MaxSizeSkew     float64 `yaml:"max_size_skew" env:"COMPACTION_MAX_SIZE_SKEW" flag:"compaction-max-size-skew" usage:"Compact once the largest segment is this many times the size of the smallest; 0 disables"`
+	MaxDeletedRatio float64 `yaml:"max_deleted_ratio" env:"COMPACTION_MAX_DELETED_RATIO" flag:"compaction-max-deleted-ratio" usage:"Compact once this share of the documents in segments is deleted, e.g. 0.2; 0 disables"`
+	// Windows are the low-traffic times of day compactions may start in, as local
+	// "HH:MM-HH:MM" ranges, e.g. "01:00-05:00" or "22:00-02:00"; empty allows any time.
+	Windows        []string      `yaml:"windows" env:"COMPACTION_WINDOWS" flag:"compaction-windows" usage:"Comma-separated HH:MM-HH:MM local times compactions may start in; empty allows any time"`
+	CheckInterval  time.Duration `yaml:"check_interval" env:"COMPACTION_CHECK_INTERVAL" flag:"compaction-check-interval" usage:"How often the segments are checked against the compaction thresholds"`
+	TargetSegments int           `yaml:"target_segments" env:"COMPACTION_TARGET_SEGMENTS" flag:"compaction-target-segments" usage:"Segments left by a compaction; 0 merges them into one"`
+}
+
+// compactionPolicyJSON is the JSON encoding of CompactionPolicy, with a readable interval.
+type compactionPolicyJSON struct {
+	MaxSegments     int      `json:"max_segments"`
+	MaxSizeSkew     float64  `json:"max_size_skew"`
+	MaxDeletedRatio float64  `json:"max_deleted_ratio"`
+	Windows         []string `json:"windows,omitempty"`
+	CheckInterval   string   `json:"check_interval"`
+	TargetSegments  int      `json:"target_segments"`
+}
+
+// MarshalJSON encodes the check interval as a duration string such as "10m0s".
+func (p CompactionPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(compactionPolicyJSON{
+		MaxSegments:     p.MaxSegments,
+		MaxSizeSkew:     p.MaxSizeSkew,
+		MaxDeletedRatio: p.MaxDeletedRatio,
+		Windows:         p.Windows,
+		CheckInterval:   p.CheckInterval.String(),
+		TargetSegments:  p.TargetSegments,
+	})
+}
+
+// Validate checks the thresholds and windows of the policy.
+func (p CompactionPolicy) Validate() error {
+	if p.MaxSegments < 0 || p.MaxSizeSkew < 0 || p.MaxDeletedRatio < 0 || p.CheckInterval < 0 || p.TargetSegments < 0 {
+		return fmt.Errorf("%w: thresholds must not be negative", ErrInvalidCompactionPolicy)
+	}
+	if p.MaxSizeSkew > 0 && p.MaxSizeSkew < 1 {
+		return fmt.Errorf("%w: max_size_skew %g must be at least 1", ErrInvalidCompactionPolicy, p.MaxSizeSkew)
+	}
+	if p.MaxDeletedRatio >= 1 {
+		return fmt.Errorf("%w: max_deleted_ratio %g must be below 1", ErrInvalidCompactionPolicy, p.MaxDeletedRatio)
+	}
+	if p.MaxSegments > 0 && p.TargetSegments >= p.MaxSegments {
+		return fmt.Errorf("%w: target_segments %d must be below max_segments %d", ErrInvalidCompactionPolicy, p.TargetSegments, p.MaxSegments)
+	}
+	if _, err := parseCompactionWindows(p.Windows); err != nil {
+		return err
+	}
+	return nil
+}
+
+// enabled reports whether the policy compacts the segments at all: without a segment
+// count, size skew or deleted ratio limit, only Compact merges them.
+func (p CompactionPolicy) enabled() bool {
+	return p.MaxSegments > 0 || p.MaxSizeSkew > 0 || p.MaxDeletedRatio > 0
+}
+
+// trigger returns why the segments call for a compaction, "" if they don't. Deleted
+// documents are only dropped by merging their segment with others, so skewed or deleted
+// segments call for a compaction only if there are more than the target.
+func (p CompactionPolicy) trigger(s SegmentStats) string {
+	switch {
+	case p.MaxSegments > 0 && s.Segments > p.MaxSegments:
+		return fmt.Sprintf("%d segments, more than %d", s.Segments, p.MaxSegments)
+	case p.MaxSizeSkew > 0 && s.SizeSkew > p.MaxSizeSkew && s.Segments > max(p.TargetSegments, 1):
+		return fmt.Sprintf("segment size skew %.1f, more than %g", s.SizeSkew, p.MaxSizeSkew)
+	case p.MaxDeletedRatio > 0 && s.DeletedRatio > p.MaxDeletedRatio && s.Segments > max(p.TargetSegments, 1):
+		return fmt.Sprintf("%.0f%% of the documents deleted, more than %.0f%%", s.DeletedRatio*100, p.MaxDeletedRatio*100)
+	default:
+		return ""
+	}
+}
+
+// compactionWindow is a range of times of day, in minutes since midnight; it wraps around
+// midnight if end is before start.
+type compactionWindow struct {
+	start, end int
+}
+
+// contains reports whether the time of day of t falls in the window.
+func (w compactionWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// parseCompactionWindows parses "HH:MM-HH:MM" windows.
+func parseCompactionWindows(windows []string) ([]compactionWindow, error) {
+	parsed := make([]compactionWindow, 0, len(windows))
+	for _, w := range windows {
+		start, end, ok := strings.Cut(strings.TrimSpace(w), "-")
+		var cw compactionWindow
+		var err error
+		if ok {
+			if cw.start, err = parseTimeOfDay(start); err == nil {
+				cw.end, err = parseTimeOfDay(end)
+			}
+		}
+		if !ok || err != nil || cw.start == cw.end {
+			return nil, fmt.Errorf("%w: window %q must be HH:MM-HH:MM", ErrInvalidCompactionPolicy, w)
+		}
+		parsed = append(parsed, cw)
+	}
+	return parsed, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into minutes since midnight.
+func parseTimeOfDay(s string) (int, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	hours, err := strconv.Atoi(h)
+	if !ok || err != nil || hours < 0 || hours > 23 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	minutes, err := strconv.Atoi(m)
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// SegmentStats describes the segments of the index.
+type SegmentStats struct {
+	Segments       int     `json:"segments"`        // Segments persisted to files
+	MemorySegments int     `json:"memory_segments"` // Segments of recent writes not persisted yet
+	Docs           uint64  `json:"docs"`            // Live documents
+	DeletedDocs    uint64  `json:"deleted_docs"`    // Deleted or updated documents still taking space in segments
+	DeletedRatio   float64 `json:"deleted_ratio"`   // Share of the documents in segments that are deleted
+	Bytes          int64   `json:"bytes"`           // Size of the segment files
+	LargestBytes   int64   `json:"largest_bytes"`
+	SmallestBytes  int64   `json:"smallest_bytes"`
+	SizeSkew       float64 `json:"size_skew"` // Largest over smallest segment file size
+}
+
+// SegmentStats returns the segments of the index. Indexes not stored by scorch fail with
+// ErrCompactionUnsupported.
+func (i *Indexer) SegmentStats() (SegmentStats, error) {
+	i.mu.Lock()
+	engine, err := i.scorchEngine()
+	i.mu.Unlock()
+	if err != nil {
+		return SegmentStats{}, err
+	}
+	return segmentStats(engine)
+}
+
+// scorchEngine returns the scorch engine of the index. Callers must hold i.mu.
+func (i *Indexer) scorchEngine() (*scorch.Scorch, error) {
+	advanced, err := i.index.Advanced()
+	if err != nil {
+		return nil, fmt.Errorf("failed to access the index engine: %w", err)
+	}
+	engine, ok := advanced.(*scorch.Scorch)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T has no segments", ErrCompactionUnsupported, advanced)
+	}
+	return engine, nil
+}
+
+// segmentStats reads the segments of the current snapshot of engine.
+func segmentStats(engine *scorch.Scorch) (SegmentStats, error) {
+	reader, err := engine.Reader()
+	if err != nil {
+		return SegmentStats{}, fmt.Errorf("failed to open an index snapshot: %w", err)
+	}
+	defer reader.Close()
+	snapshot, ok := reader.(*scorch.IndexSnapshot)
+	if !ok || snapshot == nil {
+		return SegmentStats{}, fmt.Errorf("%w: unexpected snapshot %T", ErrCompactionUnsupported, reader)
+	}
+	var s SegmentStats
+	for _, segment := range snapshot.Segments() {
+		live := segment.Count()
+		s.Docs += live
+		s.DeletedDocs += uint64(segment.FullSize()) - live
+		size := segment.FileSize()
+		if size == 0 {
+			s.MemorySegments++
+			continue
+		}
+		if s.Segments == 0 || size < s.SmallestBytes {
+			s.SmallestBytes = size
+		}
+		s.LargestBytes = max(s.LargestBytes, size)
+		s.Bytes += size
+		s.Segments++
+	}
+	if total := s.Docs + s.DeletedDocs; total > 0 {
+		s.DeletedRatio = float64(s.DeletedDocs) / float64(total)
+	}
+	if s.SmallestBytes > 0 {
+		s.SizeSkew = float64(s.LargestBytes) / float64(s.SmallestBytes)
+	}
+	return s, nil
+}
+
+// CompactionResult reports a finished compaction.
+type CompactionResult struct {
+	Reason     string       `json:"reason"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Duration   string       `json:"duration"`
+	Before     SegmentStats `json:"before"`
+	After      SegmentStats `json:"after"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// CompactionStatus reports the compaction policy, the segments of the index and the
+// progress of a running compaction.
+type CompactionStatus struct {
+	Policy   CompactionPolicy `json:"policy"`
+	Segments *SegmentStats    `json:"segments,omitempty"`
+	Running  bool             `json:"running"`
+	// Reason, StartedAt and Progress describe the running compaction. Progress is the
+	// share of the segments to merge away that are merged, from 0 to 1.
+	Reason      string            `json:"reason,omitempty"`
+	StartedAt   *time.Time        `json:"started_at,omitempty"`
+	Progress    float64           `json:"progress,omitempty"`
+	Compactions int64             `json:"compactions"` // Compactions finished since the indexer started
+	Last        *CompactionResult `json:"last,omitempty"`
+}
+
+// compactor runs the compactions of the index, one at a time, and the scheduler starting
+// them, on its goroutine started by the first enabling policy.
+type compactor struct {
+	mu          sync.Mutex
+	policy      CompactionPolicy
+	windows     []compactionWindow
+	running     bool // A compaction is running
+	engine      *scorch.Scorch
+	reason      string
+	startedAt   time.Time
+	before      SegmentStats
+	target      int
+	cancel      context.CancelFunc
+	compactions int64
+	last        *CompactionResult
+
+	scheduled bool          // The scheduler goroutine was started
+	closed    bool          // The indexer is closing; no compaction may start
+	wake      chan struct{} // Signals a policy change
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+func newCompactor() *compactor {
+	return &compactor{wake: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// SetCompactionPolicy replaces the compaction policy. The segments are checked every
+// CheckInterval (DefaultCompactionCheckInterval if 0), compacting them when a threshold is
+// exceeded inside a window; the zero policy stops scheduled compactions.
+func (i *Indexer) SetCompactionPolicy(policy CompactionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	windows, _ := parseCompactionWindows(policy.Windows)
+	c := i.compactor
+	c.mu.Lock()
+	c.policy, c.windows = policy, windows
+	start := policy.enabled() && !c.scheduled && !c.closed
+	if start {
+		c.scheduled = true
+	}
+	c.mu.Unlock()
+	if start {
+		go i.runCompactionScheduler()
+	}
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	if policy.enabled() {
+		slog.Info("Compaction policy set", "max_segments", policy.MaxSegments, "max_size_skew", policy.MaxSizeSkew, "max_deleted_ratio", policy.MaxDeletedRatio, "windows", policy.Windows)
+	}
+	return nil
+}
+
+// runCompactionScheduler checks the segments against the policy until the indexer closes.
+func (i *Indexer) runCompactionScheduler() {
+	c := i.compactor
+	defer close(c.done)
+	for {
+		c.mu.Lock()
+		interval := c.policy.CheckInterval
+		c.mu.Unlock()
+		if interval == 0 {
+			interval = DefaultCompactionCheckInterval
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-c.stop:
+			timer.Stop()
+			return
+		case <-c.wake:
+			timer.Stop()
+			continue // Wait for the interval of the new policy
+		case <-timer.C:
+		}
+		i.maybeCompact(time.Now())
+	}
+}
+
+// maybeCompact compacts the index if the policy calls for it at now.
+func (i *Indexer) maybeCompact(now time.Time) {
+	c := i.compactor
+	c.mu.Lock()
+	policy, inWindow := c.policy, len(c.windows) == 0
+	for _, w := range c.windows {
+		inWindow = inWindow || w.contains(now)
+	}
+	c.mu.Unlock()
+	if !policy.enabled() || !inWindow {
+		return
+	}
+	stats, err := i.SegmentStats()
+	if err != nil {
+		slog.Error("Compaction check failed", "error", err)
+		return
+	}
+	reason := policy.trigger(stats)
+	if reason == "" {
+		return
+	}
+	if _, err := i.Compact(context.Background(), reason); err != nil && !errors.Is(err, ErrCompactionRunning) {
+		slog.Error("Scheduled compaction failed", "error", err)
+	}
+}
+
+// Compact merges the segments of the index into the policy's TargetSegments, one if
+// unset, recording reason in its status. Writes go on while the segments merge; commits,
+// uploads and the operations closing the index wait for the merge, so that no upload
+// copies segments being merged away. Only one compaction runs at a time: others
+// fail with ErrCompactionRunning. Cancelling ctx stops the compaction between merges.
+func (i *Indexer) Compact(ctx context.Context, reason string) (*CompactionResult, error) {
+	ctx, target, err := i.compactor.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return i.compact(ctx, reason, target)
+}
+
+// StartCompaction runs Compact in the background, failing right away with
+// ErrCompactionRunning if a compaction is running and ErrCompactionUnsupported if the
+// index has no segments. Its progress and result are reported
+// by CompactionStatus.
+func (i *Indexer) StartCompaction(reason string) error {
+	if _, err := i.SegmentStats(); err != nil {
+		return err
+	}
+	ctx, target, err := i.compactor.begin(context.Background())
+	if err != nil {
+		return err
+	}
+	go func() {
+		if _, err := i.compact(ctx, reason, target); err != nil {
+			slog.Error("Compaction failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+// begin marks a compaction as running and returns its context and target segments.
+func (c *compactor) begin(ctx context.Context) (context.Context, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running || c.closed {
+		return nil, 0, ErrCompactionRunning
+	}
+	c.running = true
+	ctx, c.cancel = context.WithCancel(ctx)
+	return ctx, max(c.policy.TargetSegments, 1), nil
+}
+
+// compact runs the compaction begun with ctx.
+func (i *Indexer) compact(ctx context.Context, reason string, target int) (*CompactionResult, error) {
+	c := i.compactor
+	i.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		i.mu.Unlock()
+		c.finish(nil) // The indexer closed while the compaction waited for the mutex
+		return nil, fmt.Errorf("compaction cancelled: %w", err)
+	}
+	result := &CompactionResult{Reason: reason, StartedAt: time.Now().UTC()}
+	engine, err := i.scorchEngine()
+	if err == nil {
+		result.Before, err = segmentStats(engine)
+	}
+	if err != nil {
+		i.mu.Unlock()
+		c.finish(nil)
+		return nil, err
+	}
+	// Scorch merges concurrently with writes: the mutex is only held again by those that
+	// must wait for the merge, see waitForMerge.
+	i.merging = true
+	i.mu.Unlock()
+	c.mu.Lock()
+	c.engine, c.reason, c.startedAt, c.before, c.target = engine, reason, result.StartedAt, result.Before, target
+	c.mu.Unlock()
+	slog.InfoContext(ctx, "Compacting segments", "segments", result.Before.Segments, "bytes", result.Before.Bytes, "target", target, "reason", reason)
+
+	options := mergeplan.SingleSegmentMergePlanOptions
+	options.MaxSegmentsPerTier = target
+	err = engine.ForceMerge(ctx, &options)
+	if err == nil {
+		err = ctx.Err()
+	}
+	result.After, _ = segmentStats(engine)
+	i.mu.Lock()
+	i.merging = false
+	i.merged.Broadcast()
+	i.mu.Unlock()
+	result.FinishedAt = time.Now().UTC()
+	result.Duration = result.FinishedAt.Sub(result.StartedAt).Round(time.Millisecond).String()
+	recordOperation("compact", err)
+	if err != nil {
+		result.Error = err.Error()
+		c.finish(result)
+		return result, fmt.Errorf("failed to compact the index: %w", err)
+	}
+	slog.InfoContext(ctx, "Compacted segments", "segments", result.Before.Segments, "into", result.After.Segments, "took", result.Duration, "bytes_before", result.Before.Bytes, "bytes_after", result.After.Bytes)
+	c.finish(result)
+	return result, nil
+}
+
+// waitForMerge waits for the merge of a running compaction to end, releasing i.mu
+// meanwhile so that writes go on. Uploads, which must not copy segments being merged away,
+// and the operations closing the index call it first. Callers must hold i.mu.
+func (i *Indexer) waitForMerge() {
+	for i.merging {
+		i.merged.Wait()
+	}
+}
+
+// finish records the end of the running compaction.
+func (c *compactor) finish(result *CompactionResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancel()
+	c.running, c.engine, c.cancel = false, nil, nil
+	if result != nil {
+		c.last = result
+		if result.Error == "" {
+			c.compactions++
+		}
+	}
+}
+
+// CompactionStatus returns the compaction policy and the state of the segments and
+// compactions. Segments is nil if the index doesn't support compaction.
+func (i *Indexer) CompactionStatus() CompactionStatus {
+	c := i.compactor
+	c.mu.Lock()
+	status := CompactionStatus{Policy: c.policy, Running: c.running, Compactions: c.compactions, Last: c.last}
+	engine, before, target := c.engine, c.before, c.target
+	if c.running && engine != nil {
+		started := c.startedAt
+		status.Reason, status.StartedAt = c.reason, &started
+	}
+	c.mu.Unlock()
+
+	var stats SegmentStats
+	var err error
+	if engine != nil {
+		// The indexer's mutex is held by the compaction; its engine reports its progress.
+		stats, err = segmentStats(engine)
+		if err == nil && before.Segments > target {
+			merged := before.Segments - max(stats.Segments, target)
+			status.Progress = float64(max(merged, 0)) / float64(before.Segments-target)
+		}
+	} else if !status.Running {
+		stats, err = i.SegmentStats()
+	}
+	if err == nil && (engine != nil || !status.Running) {
+		status.Segments = &stats
+	}
+	return status
+}
+
+// shutdown stops the scheduler and cancels a running compaction, waiting for both.
+func (c *compactor) shutdown() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.mu.Lock()
+	c.closed = true
+	scheduled := c.scheduled
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+	if scheduled {
+		<-c.done
+	}
+}
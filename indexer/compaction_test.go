@@ -0,0 +1,232 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/index/scorch"
+)
+
+func TestCompactionPolicy_Validate(t *testing.T) {
+	valid := CompactionPolicy{MaxSegments: 20, MaxSizeSkew: 10, MaxDeletedRatio: 0.3, Windows: []string{"01:00-05:00", "22:30-00:30"}, TargetSegments: 2}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, p := range []CompactionPolicy{
+		{MaxSegments: -1},
+		{MaxSizeSkew: 0.5},
+		{MaxDeletedRatio: 1},
+		{MaxSegments: 4, TargetSegments: 4},
+		{Windows: []string{"1am-5am"}},
+		{Windows: []string{"01:00-24:00"}},
+		{Windows: []string{"03:00-03:00"}},
+	} {
+		if err := p.Validate(); !errors.Is(err, ErrInvalidCompactionPolicy) {
+			t.Errorf("Expected ErrInvalidCompactionPolicy for %+v, got %v", p, err)
+		}
+	}
+}
+
+func TestCompactionWindow(t *testing.T) {
+	windows, err := parseCompactionWindows([]string{"01:00-05:00", "22:30-00:30"})
+	if err != nil {
+		t.Fatalf("parseCompactionWindows returned an error: %v", err)
+	}
+	cases := map[string]bool{"00:59": false, "01:00": true, "04:59": true, "05:00": false, "22:29": false, "23:15": true, "00:10": true, "00:30": false}
+	for clock, want := range cases {
+		at, _ := time.Parse("15:04", clock)
+		got := windows[0].contains(at) || windows[1].contains(at)
+		if got != want {
+			t.Errorf("Expected %s in a window to be %t", clock, want)
+		}
+	}
+}
+
+func TestCompactionPolicy_Trigger(t *testing.T) {
+	p := CompactionPolicy{MaxSegments: 10, MaxSizeSkew: 8, MaxDeletedRatio: 0.25}
+	cases := []struct {
+		stats SegmentStats
+		want  bool
+	}{
+		{SegmentStats{Segments: 11}, true},
+		{SegmentStats{Segments: 3, SizeSkew: 9}, true},
+		{SegmentStats{Segments: 1, SizeSkew: 9}, false},
+		{SegmentStats{Segments: 1, DeletedRatio: 0.3}, false},
+		{SegmentStats{Segments: 2, DeletedRatio: 0.3}, true},
+		{SegmentStats{Segments: 5, SizeSkew: 2, DeletedRatio: 0.1}, false},
+	}
+	for _, tc := range cases {
+		if got := p.trigger(tc.stats) != ""; got != tc.want {
+			t.Errorf("trigger(%+v) = %t, want %t", tc.stats, got, tc.want)
+		}
+	}
+}
+
+// newSegmentedIndexer creates an indexer whose index merges no segments on its own, so
+// that every persisted batch stays a segment until compacted.
+func newSegmentedIndexer(t *testing.T) *Indexer {
+	t.Helper()
+	tempDir := t.TempDir()
+	indexPath := filepath.Join(tempDir, "index")
+	index, err := bleve.NewUsing(indexPath, bleve.NewIndexMapping(), scorch.Name, scorch.Name, map[string]interface{}{
+		"scorchMergePlanOptions": map[string]interface{}{
+			"MaxSegmentsPerTier":   1000,
+			"MaxSegmentSize":       1 << 20,
+			"TierGrowth":           10.0,
+			"SegmentsPerMergeTask": 10,
+			"FloorSegmentSize":     1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	index.Close()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+// waitPersisted waits until every segment of the index is persisted to a file.
+func waitPersisted(t *testing.T, idx *Indexer) SegmentStats {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		stats, err := idx.SegmentStats()
+		if err != nil {
+			t.Fatalf("SegmentStats returned an error: %v", err)
+		}
+		if stats.MemorySegments == 0 {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the segments to be persisted, got %+v", stats)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestIndexer_Compact(t *testing.T) {
+	idx := newSegmentedIndexer(t)
+
+	for b := 0; b < 4; b++ {
+		docs := make(map[string]interface{})
+		for n := 0; n < 10; n++ {
+			docs[fmt.Sprintf("doc%d-%d", b, n)] = map[string]interface{}{"title": fmt.Sprintf("document %d of batch %d", n, b)}
+		}
+		if _, err := idx.BulkIndexDocuments(docs); err != nil {
+			t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+		}
+		waitPersisted(t, idx)
+	}
+	for n := 0; n < 5; n++ {
+		if err := idx.DeleteDocument(fmt.Sprintf("doc0-%d", n)); err != nil {
+			t.Fatalf("DeleteDocument returned an error: %v", err)
+		}
+	}
+	before := waitPersisted(t, idx)
+	if before.Segments < 2 || before.Docs != 35 || before.DeletedDocs == 0 {
+		t.Fatalf("Expected several segments holding deleted documents, got %+v", before)
+	}
+
+	result, err := idx.Compact(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Compact returned an error: %v", err)
+	}
+	if result.After.Segments != 1 || result.After.Docs != 35 || result.After.DeletedDocs != 0 || result.Before.Segments != before.Segments {
+		t.Errorf("Expected one segment of the live documents, got %+v", result)
+	}
+	status := idx.CompactionStatus()
+	if status.Running || status.Compactions != 1 || status.Last == nil || status.Last.Reason != "test" || status.Segments == nil || status.Segments.Segments != 1 {
+		t.Errorf("Unexpected compaction status %+v", status)
+	}
+	if n, err := idx.index.DocCount(); err != nil || n != 35 {
+		t.Errorf("Expected 35 documents after the compaction, got %d (%v)", n, err)
+	}
+}
+
+func TestIndexer_WritesDuringMerge(t *testing.T) {
+	idx := newSegmentedIndexer(t)
+
+	// A compaction merging the segments leaves the mutex to the writes.
+	idx.mu.Lock()
+	idx.merging = true
+	idx.mu.Unlock()
+	if err := idx.IndexDocument("doc1", map[string]interface{}{"title": "document"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+
+	// A snapshot, copying the segments, waits for the merge to end.
+	done := make(chan error, 1)
+	go func() {
+		_, err := idx.Snapshot("merged")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the snapshot to wait for the merge, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	idx.mu.Lock()
+	idx.merging = false
+	idx.merged.Broadcast()
+	idx.mu.Unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Snapshot returned an error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the snapshot once the merge ended")
+	}
+}
+
+func TestIndexer_ScheduledCompaction(t *testing.T) {
+	idx := newSegmentedIndexer(t)
+
+	for b := 0; b < 3; b++ {
+		if err := idx.IndexDocument(fmt.Sprintf("doc%d", b), map[string]interface{}{"title": "document"}); err != nil {
+			t.Fatalf("IndexDocument returned an error: %v", err)
+		}
+		waitPersisted(t, idx)
+	}
+	if stats := waitPersisted(t, idx); stats.Segments < 2 {
+		t.Fatalf("Expected several segments, got %+v", stats)
+	}
+
+	// Outside of its window, the policy leaves the segments alone.
+	now := time.Now()
+	closed := fmt.Sprintf("%s-%s", now.Add(2*time.Hour).Format("15:04"), now.Add(3*time.Hour).Format("15:04"))
+	if err := idx.SetCompactionPolicy(CompactionPolicy{MaxSegments: 1, Windows: []string{closed}}); err != nil {
+		t.Fatalf("SetCompactionPolicy returned an error: %v", err)
+	}
+	idx.maybeCompact(now)
+	if status := idx.CompactionStatus(); status.Compactions != 0 {
+		t.Fatalf("Expected no compaction outside of the window, got %+v", status)
+	}
+
+	if err := idx.SetCompactionPolicy(CompactionPolicy{MaxSegments: 1, CheckInterval: 10 * time.Millisecond}); err != nil {
+		t.Fatalf("SetCompactionPolicy returned an error: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for idx.CompactionStatus().Compactions == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a scheduled compaction, got %+v", idx.CompactionStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if last := idx.CompactionStatus().Last; last.After.Segments != 1 {
+		t.Errorf("Expected one segment left, got %+v", last)
+	}
+}
@@ -0,0 +1,113 @@
+package indexer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"common/archive"
+)
+
+// SetCompression configures how segments are packaged before upload. With
+// archive.CompressionGzip every segment is uploaded as a single tar+gzip archive,
+// which cuts transfer size and the number of PUT requests at the cost of incremental uploads.
+func (s *S3Storage) SetCompression(c archive.Compression) error {
+	if _, err := archive.ParseCompression(string(c)); err != nil {
+		return err
+	}
+	s.compression = c
+	return nil
+}
+
+// SetCompression configures how segments are packaged in local storage.
+// See S3Storage.SetCompression.
+func (s *LocalFileStorage) SetCompression(c archive.Compression) error {
+	if _, err := archive.ParseCompression(string(c)); err != nil {
+		return err
+	}
+	s.compression = c
+	return nil
+}
+
+// compressed reports whether c packages segments into an archive.
+func compressed(c archive.Compression) bool {
+	return c.FileName() != ""
+}
+
+// packSegment packs segmentPath into a temporary archive using compression c and records
+// the archive in the manifest. The caller must remove the returned file.
+func packSegment(segmentPath string, c archive.Compression, manifest *SegmentManifest) (string, error) {
+	tmp, err := os.CreateTemp("", "segment-*-"+c.FileName())
+	if err != nil {
+		return "", fmt.Errorf("failed to create segment archive: %w", err)
+	}
+	if err := archive.PackTarGz(segmentPath, tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to pack segment %s: %w", segmentPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write segment archive: %w", err)
+	}
+	manifest.Compression = string(c)
+	manifest.Archive = c.FileName()
+
+	if info, err := os.Stat(tmp.Name()); err == nil {
+		var total int64
+		for _, f := range manifest.Files {
+			total += f.Size
+		}
+		slog.Info("Packed segment", "segment", segmentPath, "files", len(manifest.Files), "bytes", total, "packed_bytes", info.Size())
+	}
+	return tmp.Name(), nil
+}
+
+// uploadArchive uploads the segment as a single archive followed by its manifest.
+func (s *S3Storage) uploadArchive(segmentPath, s3Prefix string, manifest *SegmentManifest) error {
+	archivePath, err := packSegment(segmentPath, s.compression, manifest)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archivePath)
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open segment archive: %w", err)
+	}
+	defer file.Close()
+	if err := s.uploadFileWithRetry(archivePath, s3Prefix+manifest.Archive, file); err != nil {
+		return fmt.Errorf("error during segment upload to S3: %w", err)
+	}
+	if err := s.uploadManifest(s3Prefix, manifest); err != nil {
+		return err
+	}
+	// Archived uploads can't serve as a base for incremental uploads.
+	if err := os.Remove(uploadStatePath(segmentPath)); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to clear upload state", "segment", segmentPath, "error", err)
+	}
+	slog.Info("Uploaded archived index segment to S3", "segment", segmentPath, "bucket", s.bucket, "prefix", s3Prefix)
+	return nil
+}
+
+// uploadArchive replaces the contents of destSegmentDir with the segment archive and its manifest.
+func (s *LocalFileStorage) uploadArchive(segmentPath, destSegmentDir string, manifest *SegmentManifest) error {
+	archivePath, err := packSegment(segmentPath, s.compression, manifest)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archivePath)
+
+	if err := os.RemoveAll(destSegmentDir); err != nil {
+		return fmt.Errorf("failed to clear destination directory %s: %w", destSegmentDir, err)
+	}
+	if err := copyFile(archivePath, filepath.Join(destSegmentDir, manifest.Archive)); err != nil {
+		return err
+	}
+	if err := writeManifest(destSegmentDir, manifest); err != nil {
+		return err
+	}
+	slog.Info("Uploaded archived index segment to local storage", "segment", segmentPath, "dest", destSegmentDir)
+	return nil
+}
@@ -0,0 +1,68 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"common/archive"
+)
+
+func TestLocalFileStorage_UploadSegment_Compressed(t *testing.T) {
+	segmentSourceDir, err := os.MkdirTemp("", "segment_source_compressed")
+	if err != nil {
+		t.Fatalf("Failed to create segment source temp dir: %v", err)
+	}
+	defer os.RemoveAll(segmentSourceDir)
+	if err := os.MkdirAll(filepath.Join(segmentSourceDir, "store"), 0755); err != nil {
+		t.Fatalf("Failed to create subdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(segmentSourceDir, "store", "root.bolt"), []byte("12345"), 0644); err != nil {
+		t.Fatalf("Failed to write segment file: %v", err)
+	}
+
+	storageDestDir, err := os.MkdirTemp("", "storage_dest_compressed")
+	if err != nil {
+		t.Fatalf("Failed to create storage destination temp dir: %v", err)
+	}
+	defer os.RemoveAll(storageDestDir)
+
+	storage, err := NewLocalFileStorage(storageDestDir)
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	if err := storage.SetCompression("lz4"); err == nil {
+		t.Error("Expected an error for an unsupported compression")
+	}
+	if err := storage.SetCompression(archive.CompressionGzip); err != nil {
+		t.Fatalf("SetCompression returned an error: %v", err)
+	}
+	if err := storage.UploadSegment(segmentSourceDir); err != nil {
+		t.Fatalf("UploadSegment returned an error: %v", err)
+	}
+
+	destSegmentDir := filepath.Join(storageDestDir, filepath.Base(segmentSourceDir))
+	manifest, err := ReadSegmentManifest(destSegmentDir)
+	if err != nil {
+		t.Fatalf("ReadSegmentManifest returned an error: %v", err)
+	}
+	if manifest.Compression != "gzip" || manifest.Archive != "segment.tar.gz" || len(manifest.Files) != 1 {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+	if _, err := os.Stat(filepath.Join(destSegmentDir, "store", "root.bolt")); !os.IsNotExist(err) {
+		t.Errorf("Expected segment files to be stored only inside the archive, got %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(destSegmentDir, manifest.Archive))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer f.Close()
+	unpacked := t.TempDir()
+	if err := archive.UnpackTarGz(f, unpacked); err != nil {
+		t.Fatalf("UnpackTarGz returned an error: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(unpacked, "store", "root.bolt")); err != nil || string(data) != "12345" {
+		t.Errorf("Unexpected archived file content %q, %v", data, err)
+	}
+}
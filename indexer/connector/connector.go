@@ -0,0 +1,206 @@
+// Package connector ingests documents from external sources, such as a directory of
+// JSON and CSV files or a web site, into an index.
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Connector types accepted in a Spec.
+const (
+	TypeDirectory = "directory" // JSON, JSON lines and CSV files of a local directory
+	TypeSitemap   = "sitemap"   // Pages listed by a sitemap
+	TypeURLs      = "urls"      // An explicit list of pages
+)
+
+const (
+	defaultBatchSize = 500
+	// defaultTimeout bounds the requests of the default client fetching web pages.
+	defaultTimeout = 30 * time.Second
+	// maxRecordErrors bounds the record errors kept in Stats.
+	maxRecordErrors = 1000
+)
+
+var (
+	// ErrInvalidSpec is returned for connector specs that can't be run.
+	ErrInvalidSpec = errors.New("invalid connector spec")
+	// ErrNotFound is returned by Fetch for documents the source doesn't have.
+	ErrNotFound = errors.New("document not found in source")
+	// ErrInvalidDocument is wrapped by the errors of documents failing validation.
+	ErrInvalidDocument = errors.New("invalid document")
+)
+
+// Document is a document read from a Source.
+type Document struct {
+	ID     string
+	Fields map[string]interface{}
+	// Err is set for records that couldn't be read, e.g. a malformed line or a page that
+	// couldn't be fetched; Run reports them without stopping.
+	Err error
+}
+
+// Source is a collection of documents outside the index.
+type Source interface {
+	// Iterate calls fn for every document of the source until fn returns an error, which
+	// Iterate then returns, or ctx is done.
+	Iterate(ctx context.Context, fn func(Document) error) error
+	// Fetch reads a single document by ID, failing with ErrNotFound if it's missing.
+	Fetch(ctx context.Context, id string) (Document, error)
+}
+
+// Spec describes a source, e.g. in an ingestion request.
+type Spec struct {
+	Type    string   `json:"type"`
+	Path    string   `json:"path,omitempty"`     // Directory of TypeDirectory
+	IDField string   `json:"id_field,omitempty"` // Field holding the document IDs of TypeDirectory; default "id"
+	URL     string   `json:"url,omitempty"`      // Sitemap of TypeSitemap
+	URLs    []string `json:"urls,omitempty"`     // Pages of TypeURLs
+}
+
+// New creates the source described by spec. client fetches web pages; nil uses a client
+// with a default timeout.
+func New(spec Spec, client *http.Client) (Source, error) {
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	switch spec.Type {
+	case TypeDirectory:
+		return NewDirectorySource(spec.Path, spec.IDField)
+	case TypeSitemap:
+		return NewSitemapSource(spec.URL, client)
+	case TypeURLs:
+		return NewURLSource(spec.URLs, client)
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidSpec, spec.Type)
+	}
+}
+
+// Indexer is the index documents are ingested into.
+type Indexer interface {
+	// BulkIndexDocuments indexes docs and returns the errors of the documents it couldn't
+	// index, by ID. The error is set if the batch as a whole failed.
+	BulkIndexDocuments(docs map[string]interface{}) (map[string]error, error)
+}
+
+// Processor transforms a document before it's indexed, e.g. to add computed fields. An
+// error rejects the document.
+type Processor func(doc *Document) error
+
+// Options controls Run.
+type Options struct {
+	BatchSize  int         // Documents per BulkIndexDocuments call; default 500
+	Processors []Processor // Applied in order after validation
+}
+
+// RecordError reports a record that wasn't indexed, being invalid or failed by the index.
+type RecordError struct {
+	Record int    `json:"record"` // 1-based position among the records read
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error"`
+}
+
+// Stats reports the outcome of a Run.
+type Stats struct {
+	Read    int           `json:"read"`
+	Indexed int           `json:"indexed"`
+	Invalid int           `json:"invalid"`
+	Failed  int           `json:"failed"` // Valid records the index failed to index
+	Batches int           `json:"batches"`
+	Errors  []RecordError `json:"errors,omitempty"` // The first maxRecordErrors invalid or failed records
+}
+
+// Run ingests every document of source into idx in batches. Records that can't be
+// decoded or fail validation or a processor are skipped and reported, like the documents
+// the index fails to index; failing to index a batch as a whole stops the run. The stats are returned even if the run fails, so callers know how
+// far it got.
+func Run(ctx context.Context, source Source, idx Indexer, opts Options) (Stats, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	var stats Stats
+	report := func(record int, id string, err error) {
+		if len(stats.Errors) < maxRecordErrors {
+			stats.Errors = append(stats.Errors, RecordError{Record: record, ID: id, Error: err.Error()})
+		}
+	}
+	batch := make(map[string]interface{}, opts.BatchSize)
+	records := make(map[string]int, opts.BatchSize) // Record number of the documents of batch
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		failed, err := idx.BulkIndexDocuments(batch)
+		if err != nil {
+			return fmt.Errorf("failed to index a batch of %d documents: %w", len(batch), err)
+		}
+		ids := make([]string, 0, len(failed))
+		for id := range failed {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(a, b int) bool { return records[ids[a]] < records[ids[b]] })
+		for _, id := range ids {
+			report(records[id], id, failed[id])
+		}
+		stats.Indexed += len(batch) - len(failed)
+		stats.Failed += len(failed)
+		stats.Batches++
+		batch = make(map[string]interface{}, opts.BatchSize)
+		records = make(map[string]int, opts.BatchSize)
+		return nil
+	}
+
+	err := source.Iterate(ctx, func(doc Document) error {
+		stats.Read++
+		if err := prepare(&doc, opts.Processors); err != nil {
+			stats.Invalid++
+			report(stats.Read, doc.ID, err)
+			return nil
+		}
+		batch[doc.ID] = doc.Fields
+		records[doc.ID] = stats.Read
+		if len(batch) >= opts.BatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	return stats, flush()
+}
+
+// prepare validates the document and applies the processors to it.
+func prepare(doc *Document, processors []Processor) error {
+	if doc.Err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDocument, doc.Err)
+	}
+	if err := Validate(*doc); err != nil {
+		return err
+	}
+	for _, process := range processors {
+		if err := process(doc); err != nil {
+			return fmt.Errorf("%w: document %q: %v", ErrInvalidDocument, doc.ID, err)
+		}
+	}
+	return Validate(*doc)
+}
+
+// Validate checks that the document has an ID without control characters and fields.
+func Validate(doc Document) error {
+	if strings.TrimSpace(doc.ID) == "" {
+		return fmt.Errorf("%w: missing ID", ErrInvalidDocument)
+	}
+	if strings.IndexFunc(doc.ID, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+		return fmt.Errorf("%w: ID %q has control characters", ErrInvalidDocument, doc.ID)
+	}
+	if len(doc.Fields) == 0 {
+		return fmt.Errorf("%w: document %q has no fields", ErrInvalidDocument, doc.ID)
+	}
+	return nil
+}
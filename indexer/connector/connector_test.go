@@ -0,0 +1,332 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"indexer/extract"
+)
+
+// recordingIndexer records the batches indexed.
+type recordingIndexer struct {
+	batches []map[string]interface{}
+	err     error
+}
+
+func (r *recordingIndexer) BulkIndexDocuments(docs map[string]interface{}) (map[string]error, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	r.batches = append(r.batches, docs)
+	return nil, nil
+}
+
+func (r *recordingIndexer) docs() map[string]interface{} {
+	all := map[string]interface{}{}
+	for _, batch := range r.batches {
+		for id, doc := range batch {
+			all[id] = doc
+		}
+	}
+	return all
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestDirectorySource(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.json":          `{"id": "doc-1", "title": "One"}`,
+		"b.json":          `[{"sku": 2, "title": "Two"}, {"sku": 3, "title": "Three"}]`,
+		"sub/c.jsonl":     "{\"title\": \"Four\"}\n\n{\"id\": \"doc-5\", \"title\": \"Five\"}\n",
+		"sub/d.csv":       "id,title,brand\ndoc-6,Six,acme\ndoc-7,Seven,\n",
+		"notes.txt":       "ignored",
+		"sub/empty.csv":   "",
+		"sub/nested/e.js": "ignored",
+	})
+	source, err := NewDirectorySource(dir, "")
+	if err != nil {
+		t.Fatalf("NewDirectorySource returned an error: %v", err)
+	}
+	var ids []string
+	docs := map[string]map[string]interface{}{}
+	if err := source.Iterate(context.Background(), func(doc Document) error {
+		ids = append(ids, doc.ID)
+		docs[doc.ID] = doc.Fields
+		return nil
+	}); err != nil {
+		t.Fatalf("Iterate returned an error: %v", err)
+	}
+	want := []string{"doc-1", "b.json#0", "b.json#1", "sub/c.jsonl#1", "doc-5", "doc-6", "doc-7"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("Expected documents %v, got %v", want, ids)
+	}
+	if _, ok := docs["doc-1"]["id"]; ok {
+		t.Error("Expected the ID field to be removed from the fields")
+	}
+	if !reflect.DeepEqual(docs["doc-7"], map[string]interface{}{"title": "Seven"}) {
+		t.Errorf("Expected empty CSV values to be skipped, got %v", docs["doc-7"])
+	}
+
+	bySKU, _ := NewDirectorySource(dir, "sku")
+	doc, err := bySKU.Fetch(context.Background(), "3")
+	if err != nil || doc.Fields["title"] != "Three" {
+		t.Errorf("Expected Fetch to find document 3 by its sku, got %+v (error %v)", doc, err)
+	}
+	if _, err := source.Fetch(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDirectorySource_Invalid(t *testing.T) {
+	if _, err := NewDirectorySource(filepath.Join(t.TempDir(), "missing"), ""); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Expected ErrInvalidSpec for a missing directory, got %v", err)
+	}
+	dir := writeFiles(t, map[string]string{"bad.json": `{"id": `})
+	source, err := NewDirectorySource(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := Run(context.Background(), source, &recordingIndexer{}, Options{})
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	if stats.Invalid != 1 || len(stats.Errors) != 1 || stats.Errors[0].ID != "bad.json" {
+		t.Errorf("Expected the malformed file to be reported, got %+v", stats)
+	}
+}
+
+func TestURLSource_Sitemap(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%s/pages.xml</loc></sitemap></sitemapindex>`, server.URL)
+		case "/pages.xml":
+			fmt.Fprintf(w, `<urlset><url><loc>%[1]s/a</loc></url><url><loc> %[1]s/b.txt </loc></url></urlset>`, server.URL)
+		case "/a":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<html><head><title>Red &amp; Shoes</title><style>p {}</style></head>
+<body><h1>Red shoes</h1><script>var x;</script><p>On   sale</p></body></html>`)
+		case "/b.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "plain text")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source, err := New(Spec{Type: TypeSitemap, URL: server.URL + "/sitemap.xml"}, server.Client())
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	idx := &recordingIndexer{}
+	stats, err := Run(context.Background(), source, idx, Options{})
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	if stats.Read != 2 || stats.Indexed != 2 || stats.Batches != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	page, err := extract.NewPipeline().Process(idx.docs()[server.URL+"/a"].(map[string]interface{}))
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+	if page["title"] != "Red & Shoes" || page["content"] != "Red shoes On sale" || page["url"] != server.URL+"/a" {
+		t.Errorf("Unexpected HTML document %v", page)
+	}
+	if text := idx.docs()[server.URL+"/b.txt"].(map[string]interface{}); text["content"] != "plain text" {
+		t.Errorf("Unexpected text document %v", text)
+	}
+
+	missing, _ := NewURLSource([]string{server.URL + "/missing"}, server.Client())
+	if _, err := missing.Fetch(context.Background(), server.URL+"/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := NewURLSource([]string{"ftp://example.com"}, nil); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Expected ErrInvalidSpec for a non-HTTP URL, got %v", err)
+	}
+}
+
+func TestURLSource_PageErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "fine")
+	}))
+	defer server.Close()
+
+	source, err := NewURLSource([]string{server.URL + "/broken", server.URL + "/ok"}, server.Client())
+	if err != nil {
+		t.Fatalf("NewURLSource returned an error: %v", err)
+	}
+	idx := &recordingIndexer{}
+	stats, err := Run(context.Background(), source, idx, Options{})
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	// The failing page is reported, and the next one still indexed.
+	if stats.Read != 2 || stats.Indexed != 1 || stats.Invalid != 1 || len(stats.Errors) != 1 || stats.Errors[0].ID != server.URL+"/broken" {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestNewScoped(t *testing.T) {
+	root := writeFiles(t, map[string]string{"products/a.json": `{"id": "a", "title": "A"}`})
+	outside := writeFiles(t, map[string]string{"secret.json": `{"id": "s", "title": "Secret"}`})
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.json"), filepath.Join(root, "products", "secret.json")); err != nil {
+		t.Fatal(err)
+	}
+	scope := Scope{Root: root}
+
+	for _, path := range []string{outside, filepath.Join(root, ".."), "escape", "../" + filepath.Base(outside)} {
+		if _, err := NewScoped(Spec{Type: TypeDirectory, Path: path}, nil, scope); !errors.Is(err, ErrForbidden) {
+			t.Errorf("Expected ErrForbidden for %s, got %v", path, err)
+		}
+	}
+	source, err := NewScoped(Spec{Type: TypeDirectory, Path: "products"}, nil, scope)
+	if err != nil {
+		t.Fatalf("NewScoped returned an error: %v", err)
+	}
+	idx := &recordingIndexer{}
+	if _, err := Run(context.Background(), source, idx, Options{}); err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	// The symbolic link to a file outside the root isn't followed.
+	if docs := idx.docs(); len(docs) != 1 || docs["a"] == nil {
+		t.Errorf("Expected the document under the root alone, got %v", docs)
+	}
+	if _, err := NewScoped(Spec{Type: TypeDirectory, Path: root}, nil, Scope{}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden without a root, got %v", err)
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			fmt.Fprintf(w, `<urlset><url><loc>%[1]s/page</loc></url><url><loc>http://internal.example/admin</loc></url><url><loc>%[1]s/redirect</loc></url></urlset>`, server.URL)
+		case "/page":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "public")
+		case "/redirect":
+			http.Redirect(w, r, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/page", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+	web := Scope{Hosts: []string{"127.0.0.1", "*.example.com"}}
+
+	if _, err := NewScoped(Spec{Type: TypeURLs, URLs: []string{"http://example.com/"}}, nil, web); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a host off the allowlist, got %v", err)
+	}
+	if _, err := NewScoped(Spec{Type: TypeURLs, URLs: []string{"https://shop.example.com/"}}, nil, web); err != nil {
+		t.Errorf("Expected subdomains to be allowed, got %v", err)
+	}
+	if _, err := NewScoped(Spec{Type: TypeSitemap, URL: server.URL + "/sitemap.xml"}, nil, Scope{Root: root}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden without hosts, got %v", err)
+	}
+	source, err = NewScoped(Spec{Type: TypeSitemap, URL: server.URL + "/sitemap.xml"}, server.Client(), web)
+	if err != nil {
+		t.Fatalf("NewScoped returned an error: %v", err)
+	}
+	idx = &recordingIndexer{}
+	stats, err := Run(context.Background(), source, idx, Options{})
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	// The listed page of another host and the redirect to one fail on their own.
+	if stats.Indexed != 1 || stats.Invalid != 2 || idx.docs()[server.URL+"/page"] == nil {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	for _, e := range stats.Errors {
+		if !strings.Contains(e.Error, ErrForbidden.Error()) {
+			t.Errorf("Expected %s to be forbidden, got %s", e.ID, e.Error)
+		}
+	}
+}
+
+func TestRun_BatchesAndValidation(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"docs.jsonl": strings.Join([]string{
+			`{"id": "a", "price": "10"}`,
+			`{"id": "b", "price": "oops"}`,
+			`{"id": "c", "price": "30"}`,
+			`{"id": "d"}`,
+			`{"id": "e", "price": "50"}`,
+		}, "\n"),
+	})
+	source, err := New(Spec{Type: TypeDirectory, Path: dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	computePrice := func(doc *Document) error {
+		raw, ok := doc.Fields["price"].(string)
+		if !ok {
+			return nil
+		}
+		var cents int
+		if _, err := fmt.Sscanf(raw, "%d", &cents); err != nil {
+			return fmt.Errorf("invalid price %q", raw)
+		}
+		doc.Fields["price_cents"] = cents * 100
+		return nil
+	}
+	idx := &recordingIndexer{}
+	stats, err := Run(context.Background(), source, idx, Options{BatchSize: 2, Processors: []Processor{computePrice}})
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+	// b has an invalid price, and d no fields once its ID is taken out.
+	if stats.Read != 5 || stats.Indexed != 3 || stats.Invalid != 2 || stats.Batches != 2 || len(stats.Errors) != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	var ids []string
+	for id := range idx.docs() {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"a", "c", "e"}) {
+		t.Errorf("Unexpected documents indexed: %v", ids)
+	}
+	if idx.docs()["e"].(map[string]interface{})["price_cents"] != 5000 {
+		t.Errorf("Expected the processor to compute price_cents, got %v", idx.docs()["e"])
+	}
+
+	failing := &recordingIndexer{err: errors.New("disk full")}
+	if stats, err := Run(context.Background(), source, failing, Options{BatchSize: 2}); err == nil || stats.Indexed != 0 {
+		t.Errorf("Expected the run to stop on indexing errors, got %+v (error %v)", stats, err)
+	}
+}
+
+func TestNew_UnknownType(t *testing.T) {
+	if _, err := New(Spec{Type: "ftp"}, nil); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Expected ErrInvalidSpec, got %v", err)
+	}
+}
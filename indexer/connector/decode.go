@@ -0,0 +1,151 @@
+package connector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxLineSize bounds the size of a JSON line.
+const maxLineSize = 16 << 20
+
+// decoder decodes records of JSON, JSON lines and CSV streams into documents, one at a
+// time, so streams of any size are decoded in constant memory. Records that can't be
+// decoded are passed on with their error, and the stream stops only if it can't be read
+// any further.
+type decoder struct {
+	idField string
+	// mapping renames CSV columns to fields; columns mapped to "" are dropped and
+	// columns missing from it keep their name.
+	mapping map[string]string
+}
+
+// document builds a document from fields, taking its ID from the ID field, which is
+// removed from its fields, or else from fallbackID.
+func (d *decoder) document(fields map[string]interface{}, fallbackID string) Document {
+	id := fallbackID
+	if v, ok := fields[d.idField]; ok {
+		switch v := v.(type) {
+		case string:
+			id = v
+		case float64:
+			id = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		delete(fields, d.idField)
+	}
+	return Document{ID: id, Fields: fields}
+}
+
+// recordID returns the fallback ID of the nth record of the named stream, or "" for
+// streams without name, whose records must have an ID.
+func recordID(name string, n int) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s#%d", name, n)
+}
+
+// json decodes a document, or an array of documents.
+func (d *decoder) json(r io.Reader, name string, fn func(Document) error) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var docs []map[string]interface{}
+		if err := json.Unmarshal(data, &docs); err != nil {
+			return fn(Document{ID: name, Err: fmt.Errorf("invalid JSON array of documents: %w", err)})
+		}
+		for n, fields := range docs {
+			if err := fn(d.document(fields, recordID(name, n))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fn(Document{ID: name, Err: fmt.Errorf("invalid JSON document: %w", err)})
+	}
+	return fn(d.document(fields, name))
+}
+
+// jsonLines decodes a document per non-empty line.
+func (d *decoder) jsonLines(r io.Reader, name string, fn func(Document) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var doc Document
+		var fields map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			doc = Document{ID: recordID(name, line), Err: fmt.Errorf("line %d: invalid JSON document: %w", line, err)}
+		} else {
+			doc = d.document(fields, recordID(name, line))
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read JSON lines: %w", err)
+	}
+	return nil
+}
+
+// csv decodes a document per row after the header row, which names the fields. Empty
+// values are left out.
+func (d *decoder) csv(r io.Reader, name string, fn func(Document) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Rows of the wrong size are reported as records
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid CSV header: %w", err)
+	}
+	fieldNames := make([]string, len(header))
+	for n, column := range header {
+		fieldNames[n] = column
+		if field, ok := d.mapping[column]; ok {
+			fieldNames[n] = field
+		}
+	}
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var doc Document
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			doc = Document{ID: recordID(name, row), Err: fmt.Errorf("row %d: %w", row, err)}
+		case err != nil:
+			return fmt.Errorf("failed to read CSV: %w", err)
+		case len(record) != len(header):
+			doc = Document{ID: recordID(name, row), Err: fmt.Errorf("row %d has %d values, the header %d", row, len(record), len(header))}
+		default:
+			fields := make(map[string]interface{}, len(header))
+			for n, value := range record {
+				if value != "" && fieldNames[n] != "" {
+					fields[fieldNames[n]] = value
+				}
+			}
+			doc = d.document(fields, recordID(name, row))
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultIDField is the field holding document IDs in files of a directory source.
+const defaultIDField = "id"
+
+// errStop stops the iteration of a file once Fetch found its document.
+var errStop = errors.New("stop")
+
+// DirectorySource reads documents from the files of a directory and its subdirectories:
+//   - .json files hold a document or an array of documents,
+//   - .jsonl and .ndjson files hold a document per line,
+//   - .csv files have a header row naming the fields of the documents of the other rows.
+//
+// Documents take their ID from the ID field, which is removed from their fields, or
+// else from their file path, with their line or index for files of several documents.
+// Malformed records are passed on with their error. Other files, and symbolic links, are
+// ignored.
+type DirectorySource struct {
+	root    string
+	decoder decoder
+}
+
+// NewDirectorySource creates a source for the directory at root. idField names the
+// field holding document IDs; empty means "id".
+func NewDirectorySource(root, idField string) (*DirectorySource, error) {
+	if root == "" {
+		return nil, fmt.Errorf("%w: directory path is required", ErrInvalidSpec)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSpec, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrInvalidSpec, root)
+	}
+	if idField == "" {
+		idField = defaultIDField
+	}
+	return &DirectorySource{root: root, decoder: decoder{idField: idField}}, nil
+}
+
+// Iterate reads the files in lexical order.
+func (s *DirectorySource) Iterate(ctx context.Context, fn func(Document) error) error {
+	return filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			// Symbolic links aren't followed, so the files read stay under the root.
+			return nil
+		}
+		return s.readFile(path, fn)
+	})
+}
+
+// Fetch scans the files for the document with the given ID.
+func (s *DirectorySource) Fetch(ctx context.Context, id string) (Document, error) {
+	var found Document
+	err := s.Iterate(ctx, func(doc Document) error {
+		if doc.ID != id {
+			return nil
+		}
+		found = doc
+		return errStop
+	})
+	if errors.Is(err, errStop) {
+		return found, nil
+	}
+	if err != nil {
+		return Document{}, err
+	}
+	return Document{}, fmt.Errorf("%w: %q", ErrNotFound, id)
+}
+
+// readFile calls fn for the documents of the file at path, if it has a supported format.
+func (s *DirectorySource) readFile(path string, fn func(Document) error) error {
+	var read func(io.Reader, string, func(Document) error) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		read = s.decoder.json
+	case ".jsonl", ".ndjson":
+		read = s.decoder.jsonLines
+	case ".csv":
+		read = s.decoder.csv
+	default:
+		return nil
+	}
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := read(f, filepath.ToSlash(rel), fn); err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	return nil
+}
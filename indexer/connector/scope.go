@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// ErrForbidden is returned for specs and pages outside the Scope of a source.
+var ErrForbidden = errors.New("source outside the allowed scope")
+
+// maxRedirects bounds the redirects followed by scoped web sources, like http.Client does.
+const maxRedirects = 10
+
+// Scope confines the sources of specs taken from requests, so callers can't make the
+// indexer read arbitrary files or fetch arbitrary URLs.
+type Scope struct {
+	// Root is the directory holding every directory source; empty allows none.
+	Root string
+	// Hosts are the hosts web sources may fetch pages from, "*.example.com" matching the
+	// subdomains of example.com; empty allows none.
+	Hosts []string
+}
+
+// NewScoped is New for a spec confined to scope, failing with ErrForbidden outside of it.
+// Directory sources must be under scope.Root once symbolic links are resolved; relative
+// paths are relative to it. Web sources only fetch pages from scope.Hosts, be they listed,
+// found in a sitemap or redirected to; the pages of other hosts fail on their own.
+func NewScoped(spec Spec, client *http.Client, scope Scope) (Source, error) {
+	switch spec.Type {
+	case TypeDirectory:
+		path, err := scope.directory(spec.Path)
+		if err != nil {
+			return nil, err
+		}
+		spec.Path = path
+		return New(spec, client)
+	case TypeSitemap, TypeURLs:
+		if len(scope.Hosts) == 0 {
+			return nil, fmt.Errorf("%w: web sources are disabled", ErrForbidden)
+		}
+		for _, raw := range append([]string{spec.URL}, spec.URLs...) {
+			if u, err := url.Parse(raw); err == nil && raw != "" && !scope.allowsURL(u) {
+				return nil, fmt.Errorf("%w: host of %q is not allowed", ErrForbidden, raw)
+			}
+		}
+		source, err := New(spec, scope.client(client))
+		if err != nil {
+			return nil, err
+		}
+		source.(*URLSource).allowed = scope.allowsURL
+		return source, nil
+	default:
+		return New(spec, client)
+	}
+}
+
+// directory returns the absolute path of the directory at path, failing unless it's
+// under the root.
+func (s Scope) directory(path string) (string, error) {
+	if s.Root == "" {
+		return "", fmt.Errorf("%w: directory sources are disabled", ErrForbidden)
+	}
+	if path == "" {
+		return "", fmt.Errorf("%w: directory path is required", ErrInvalidSpec)
+	}
+	root, err := filepath.Abs(s.Root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid directory root: %w", err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	dir, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSpec, err)
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is outside the directory root", ErrForbidden, path)
+	}
+	return dir, nil
+}
+
+// allowsURL reports whether pages may be fetched from the host of u.
+func (s Scope) allowsURL(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range s.Hosts {
+		allowed = strings.ToLower(allowed)
+		if domain, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns a copy of client, or of a client with a default timeout, that refuses
+// redirects to hosts outside the scope.
+func (s Scope) client(client *http.Client) *http.Client {
+	scoped := http.Client{Timeout: defaultTimeout}
+	if client != nil {
+		scoped = *client
+	}
+	scoped.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if !s.allowsURL(req.URL) {
+			return fmt.Errorf("%w: redirect to %s", ErrForbidden, req.URL)
+		}
+		return nil
+	}
+	return &scoped
+}
@@ -0,0 +1,68 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Formats of a StreamSource.
+const (
+	FormatNDJSON = "ndjson" // A JSON document per line
+	FormatCSV    = "csv"    // A header row naming the fields, then a document per row
+)
+
+// StreamOptions controls how a StreamSource decodes its records.
+type StreamOptions struct {
+	IDField string // Field holding the document IDs; default "id"
+	// Mapping renames CSV columns to fields; columns mapped to "" are dropped and
+	// columns missing from it keep their name.
+	Mapping map[string]string
+}
+
+// StreamSource reads documents from a stream, e.g. a request body, decoding a record at
+// a time so the stream is never held in memory. Records must have an ID. A stream can
+// only be iterated once.
+type StreamSource struct {
+	r       io.Reader
+	format  string
+	decoder decoder
+	once    sync.Once
+}
+
+// NewStreamSource creates a source reading records of the given format from r.
+func NewStreamSource(r io.Reader, format string, opts StreamOptions) (*StreamSource, error) {
+	if format != FormatNDJSON && format != FormatCSV {
+		return nil, fmt.Errorf("%w: unknown stream format %q", ErrInvalidSpec, format)
+	}
+	if opts.IDField == "" {
+		opts.IDField = defaultIDField
+	}
+	return &StreamSource{r: r, format: format, decoder: decoder{idField: opts.IDField, mapping: opts.Mapping}}, nil
+}
+
+// Iterate decodes the records of the stream. Calls after the first fail.
+func (s *StreamSource) Iterate(ctx context.Context, fn func(Document) error) error {
+	first := false
+	s.once.Do(func() { first = true })
+	if !first {
+		return errors.New("stream source already iterated")
+	}
+	read := s.decoder.jsonLines
+	if s.format == FormatCSV {
+		read = s.decoder.csv
+	}
+	return read(s.r, "", func(doc Document) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(doc)
+	})
+}
+
+// Fetch is unsupported: a stream can't be read by ID.
+func (s *StreamSource) Fetch(ctx context.Context, id string) (Document, error) {
+	return Document{}, fmt.Errorf("stream sources can't fetch documents by ID: %w", errors.ErrUnsupported)
+}
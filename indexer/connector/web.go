@@ -0,0 +1,179 @@
+package connector
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"indexer/extract"
+)
+
+const (
+	// maxPageSize bounds the bytes read from a page or sitemap.
+	maxPageSize = 10 << 20
+	// maxSitemapDepth bounds the nesting of sitemap indexes.
+	maxSitemapDepth = 3
+)
+
+// URLSource reads web pages. Documents are identified by their URL and have the fields
+// url, content_type and content, the page as it is served for text, HTML and PDF pages;
+// an indexer with content extraction turns HTML and PDF pages into text, a title and
+// metadata.
+type URLSource struct {
+	urls   []string
+	client *http.Client
+	list   func(ctx context.Context) ([]string, error) // Lists the pages on every Iterate if set
+	// allowed reports whether pages may be fetched from a URL's host; nil allows all.
+	allowed func(u *url.URL) bool
+}
+
+// NewURLSource creates a source for the pages at urls.
+func NewURLSource(urls []string, client *http.Client) (*URLSource, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%w: at least one URL is required", ErrInvalidSpec)
+	}
+	for _, u := range urls {
+		if err := validateURL(u); err != nil {
+			return nil, err
+		}
+	}
+	return &URLSource{urls: urls, client: client}, nil
+}
+
+// NewSitemapSource creates a source for the pages listed by the sitemap at sitemapURL.
+// Sitemap indexes are followed. The sitemap is read again on every Iterate, so new pages
+// are picked up.
+func NewSitemapSource(sitemapURL string, client *http.Client) (*URLSource, error) {
+	if err := validateURL(sitemapURL); err != nil {
+		return nil, err
+	}
+	s := &URLSource{client: client}
+	s.list = func(ctx context.Context) ([]string, error) {
+		return s.readSitemap(ctx, sitemapURL, 0)
+	}
+	return s, nil
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %q is not an http(s) URL", ErrInvalidSpec, raw)
+	}
+	return nil
+}
+
+// Iterate fetches the pages in order. A page that can't be fetched is passed on with its
+// error, so the others are still read; failing to list the pages stops the iteration.
+func (s *URLSource) Iterate(ctx context.Context, fn func(Document) error) error {
+	urls := s.urls
+	if s.list != nil {
+		var err error
+		if urls, err = s.list(ctx); err != nil {
+			return err
+		}
+	}
+	for _, u := range urls {
+		doc, err := s.Fetch(ctx, u)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			doc = Document{ID: u, Err: err}
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch fetches the page at the URL id.
+func (s *URLSource) Fetch(ctx context.Context, id string) (Document, error) {
+	body, contentType, err := s.get(ctx, id)
+	if err != nil {
+		return Document{}, err
+	}
+	fields := map[string]interface{}{"url": id, "content_type": contentType}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == extract.MediaTypePDF:
+		// Binary; the indexer's content extraction reads it from the bytes.
+		fields["content"] = body
+	case strings.HasPrefix(mediaType, "text/") || mediaType == extract.MediaTypeXHTML:
+		fields["content"] = string(body)
+	}
+	return Document{ID: id, Fields: fields}, nil
+}
+
+// get fetches rawURL, failing with ErrNotFound on 404 and 410, and with ErrForbidden for
+// hosts that aren't allowed.
+func (s *URLSource) get(ctx context.Context, rawURL string) ([]byte, string, error) {
+	if u, err := url.Parse(rawURL); err == nil && s.allowed != nil && !s.allowed(u) {
+		return nil, "", fmt.Errorf("%w: host of %s is not allowed", ErrForbidden, rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request for %s: %w", rawURL, err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, "", fmt.Errorf("%w: %s", ErrNotFound, rawURL)
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("failed to fetch %s: status %s", rawURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", rawURL, err)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// sitemap is a sitemap (urlset) or a sitemap index (sitemapindex); see sitemaps.org.
+type sitemap struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// readSitemap returns the page URLs listed by the sitemap at rawURL and the sitemaps it
+// refers to.
+func (s *URLSource) readSitemap(ctx context.Context, rawURL string, depth int) ([]string, error) {
+	if depth >= maxSitemapDepth {
+		return nil, fmt.Errorf("sitemap %s: sitemap indexes nested more than %d deep", rawURL, maxSitemapDepth)
+	}
+	body, _, err := s.get(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var sm sitemap
+	if err := xml.Unmarshal(body, &sm); err != nil {
+		return nil, fmt.Errorf("invalid sitemap %s: %w", rawURL, err)
+	}
+	var urls []string
+	for _, u := range sm.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			urls = append(urls, loc)
+		}
+	}
+	for _, child := range sm.Sitemaps {
+		childURLs, err := s.readSitemap(ctx, strings.TrimSpace(child.Loc), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, childURLs...)
+	}
+	return urls, nil
+}
@@ -0,0 +1,157 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// Document source types accepted in a SourceSpec.
+const (
+	SourceIndex = "index" // Stored fields of the documents in the live index
+	SourceHTTP  = "http"  // An external document store served over HTTP
+)
+
+// ErrInvalidSource is returned when a reindex names an unknown or malformed document source.
+var ErrInvalidSource = errors.New("invalid document source")
+
+// SourceDocument is a document read from a DocumentSource.
+type SourceDocument struct {
+	ID   string      `json:"id"`
+	Data interface{} `json:"data"`
+}
+
+// DocumentSource streams the documents to reindex in ascending ID order, so a reindex
+// can be resumed after the last document it copied.
+type DocumentSource interface {
+	// Next returns up to limit documents with IDs greater than after (all documents
+	// if after is empty), together with the total number of documents in the source.
+	Next(after string, limit int) ([]SourceDocument, uint64, error)
+}
+
+// SourceSpec describes where a reindex reads documents from. It is persisted with the
+// job so the source can be recreated when resuming.
+type SourceSpec struct {
+	Type string `json:"type"`          // SourceIndex (default) or SourceHTTP
+	URL  string `json:"url,omitempty"` // Document store endpoint for SourceHTTP
+}
+
+// SetClientTransport sets the transport used to reach external document stores, e.g. one
+// configured for mutual TLS.
+func (i *Indexer) SetClientTransport(transport http.RoundTripper) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.transport = transport
+}
+
+// newDocumentSource creates the source described by spec. Callers must hold i.mu.
+func (i *Indexer) newDocumentSource(spec SourceSpec) (DocumentSource, error) {
+	switch spec.Type {
+	case "", SourceIndex:
+		return &indexSource{index: i.index}, nil
+	case SourceHTTP:
+		source, err := NewHTTPDocumentSource(spec.URL)
+		if err != nil {
+			return nil, err
+		}
+		if i.transport != nil {
+			source.client.Transport = i.transport
+		}
+		return source, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidSource, spec.Type)
+	}
+}
+
+// indexSource reads documents back from the stored fields of a Bleve index. Fields
+// that are not stored cannot be recovered.
+type indexSource struct {
+	index bleve.Index
+}
+
+// Next returns the next page of documents sorted by ID.
+func (s *indexSource) Next(after string, limit int) ([]SourceDocument, uint64, error) {
+	total, err := s.index.DocCount()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get document count: %w", err)
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), limit, 0, false)
+	req.Fields = []string{"*"}
+	req.SortBy([]string{"_id"})
+	if after != "" {
+		req.SearchAfter = []string{after}
+	}
+	result, err := s.index.Search(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read documents after %q: %w", after, err)
+	}
+	docs := make([]SourceDocument, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		docs = append(docs, SourceDocument{ID: hit.ID, Data: hit.Fields})
+	}
+	return docs, total, nil
+}
+
+// HTTPDocumentSource reads documents from an external document store. The store must
+// answer GET <url>?after=<id>&limit=<n> with
+//
+//	{"total": <documents in the store>, "documents": [{"id": "...", "data": {...}}, ...]}
+//
+// listing the documents with IDs greater than after in ascending ID order.
+type HTTPDocumentSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPDocumentSource creates a source reading from the document store at rawURL.
+func NewHTTPDocumentSource(rawURL string) (*HTTPDocumentSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: invalid document store URL %q", ErrInvalidSource, rawURL)
+	}
+	return &HTTPDocumentSource{url: rawURL, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Next fetches the next page of documents from the store.
+func (s *HTTPDocumentSource) Next(after string, limit int) ([]SourceDocument, uint64, error) {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid document store URL %q: %w", s.url, err)
+	}
+	q := u.Query()
+	q.Set("after", after)
+	q.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = q.Encode()
+
+	resp, err := s.client.Get(u.String())
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch documents from %s: %w", s.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("document store %s returned status %d", s.url, resp.StatusCode)
+	}
+
+	var page struct {
+		Total     uint64           `json:"total"`
+		Documents []SourceDocument `json:"documents"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode documents from %s: %w", s.url, err)
+	}
+	prev := after
+	for _, doc := range page.Documents {
+		// Out-of-order pages would make the job skip documents or never finish.
+		if doc.ID == "" || (prev != "" && doc.ID <= prev) {
+			return nil, 0, fmt.Errorf("document store %s returned document %q out of ascending ID order after %q", s.url, doc.ID, prev)
+		}
+		prev = doc.ID
+	}
+	return page.Documents, page.Total, nil
+}
@@ -0,0 +1,73 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// ErrDocumentNotFound is returned when a document ID is not in the index.
+var ErrDocumentNotFound = errors.New("document not found")
+
+// StoredDocument is a document as stored in the index: only fields mapped with
+// Store enabled are returned.
+type StoredDocument struct {
+	ID      string                 `json:"id"`
+	Version int64                  `json:"version,omitempty"` // See VersionField
+	Fields  map[string]interface{} `json:"fields"`
+}
+
+// GetDocument returns the stored fields of the document with the given ID, restricted to
+// fields if any are given.
+func (i *Indexer) GetDocument(id string, fields []string) (*StoredDocument, error) {
+	if len(fields) == 0 {
+		fields = []string{"*"}
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery([]string{id}), 1, 0, false)
+	req.Fields = fields
+	if !containsField(fields, VersionField) {
+		req.Fields = append(append([]string(nil), fields...), VersionField)
+	}
+
+	i.mu.Lock()
+	result, err := i.index.Search(req)
+	i.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document %s: %w", id, err)
+	}
+	if len(result.Hits) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, id)
+	}
+	stored := result.Hits[0].Fields
+	if stored == nil {
+		stored = make(map[string]interface{})
+	}
+	version := versionOf(stored)
+	if !containsField(fields, VersionField) {
+		delete(stored, VersionField)
+	}
+	return &StoredDocument{ID: id, Version: version, Fields: stored}, nil
+}
+
+// containsField reports whether the projection fields selects field.
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field || f == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFieldsParam splits a comma-separated "fields" parameter, ignoring empty entries.
+func ParseFieldsParam(param string) []string {
+	var fields []string
+	for _, f := range strings.Split(param, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,45 @@
+package indexer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIndexer_GetDocument(t *testing.T) {
+	idx, _ := newJobTestIndexer(t)
+	defer idx.Close()
+
+	if err := idx.IndexDocument("doc1", map[string]interface{}{"title": "hello", "category": "news"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+
+	doc, err := idx.GetDocument("doc1", nil)
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	if doc.ID != "doc1" || doc.Fields["title"] != "hello" || doc.Fields["category"] != "news" {
+		t.Errorf("Unexpected document: %+v", doc)
+	}
+
+	doc, err = idx.GetDocument("doc1", []string{"title"})
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(doc.Fields, map[string]interface{}{"title": "hello"}) {
+		t.Errorf("Expected only the projected field, got %v", doc.Fields)
+	}
+
+	if _, err := idx.GetDocument("missing", nil); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound, got %v", err)
+	}
+}
+
+func TestParseFieldsParam(t *testing.T) {
+	if got := ParseFieldsParam(" title, ,price "); !reflect.DeepEqual(got, []string{"title", "price"}) {
+		t.Errorf("Unexpected fields: %v", got)
+	}
+	if got := ParseFieldsParam(""); got != nil {
+		t.Errorf("Expected no fields, got %v", got)
+	}
+}
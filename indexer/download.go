@@ -0,0 +1,279 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"common/archive"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	defaultDownloadConcurrency = 4       // Files of a segment downloaded at once
+	downloadPartSize           = 8 << 20 // Size of the ranged GETs a file is downloaded with
+)
+
+// ErrChecksumMismatch is returned when a downloaded file doesn't match its manifest.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// SegmentSource is implemented by storages that uploaded segments can be listed and
+// downloaded from, such as the storage Searchers pull segments from, and new leaders
+// catch up from.
+type SegmentSource interface {
+	// ListSegments returns the names of the complete segments starting with prefix, sorted.
+	ListSegments(prefix string) ([]string, error)
+	// DownloadSegment downloads a segment and its manifest into destDir.
+	DownloadSegment(segment, destDir string) error
+}
+
+// SetDownloadConcurrency sets how many files of a segment DownloadSegment fetches at once.
+func (s *S3Storage) SetDownloadConcurrency(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("download concurrency must be positive, got %d", n)
+	}
+	s.downloadConcurrency = n
+	return nil
+}
+
+// ListSegments returns the names of the segments of the storage's tenant and collection
+// that start with prefix, sorted. Only complete segments, whose manifest was uploaded
+// last, are listed; snapshots aren't.
+func (s *S3Storage) ListSegments(prefix string) ([]string, error) {
+	base := s.keyPrefix()
+	var segments []string
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(base + prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			segment, file, ok := strings.Cut(strings.TrimPrefix(aws.StringValue(obj.Key), base), "/")
+			if ok && file == ManifestFileName {
+				segments = append(segments, segment)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments in S3 bucket %s with prefix %s: %w", s.bucket, base+prefix, err)
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// DownloadSegment downloads segment into destDir, laid out like LocalFileStorage keeps
+// segments: its files, unpacked if it was archived, followed by its manifest. Files are
+// downloaded concurrently, each with parallel ranged GETs, and files an incremental upload
+// left in an earlier segment are fetched from there. Every file is verified against the
+// size and checksum of the manifest; a mismatch fails with an error wrapping
+// ErrChecksumMismatch.
+func (s *S3Storage) DownloadSegment(segment, destDir string) error {
+	if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, `/\`) {
+		return fmt.Errorf("invalid segment name %q", segment)
+	}
+	base := s.keyPrefix()
+	manifest, err := s.downloadManifest(base + segment + "/")
+	if err != nil {
+		return err
+	}
+	for _, f := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) {
+			return fmt.Errorf("segment %s: manifest file path %q escapes the segment directory", segment, f.Path)
+		}
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
+	}
+
+	slog.Info("Downloading segment from S3", "segment", segment, "files", len(manifest.Files), "bucket", s.bucket, "dest", destDir)
+	if manifest.Archive != "" {
+		err = s.downloadArchive(base+segment+"/", destDir, manifest)
+	} else {
+		err = s.downloadFiles(base, segment, destDir, manifest)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Write the manifest last so its presence signals a complete, verified segment.
+	if err := writeManifest(destDir, manifest); err != nil {
+		return err
+	}
+	slog.Info("Downloaded segment", "segment", segment, "dest", destDir)
+	return nil
+}
+
+// DownloadSegment copies a segment directory of the storage and its manifest into
+// destDir, unpacking its archive if it has one, and verifies the files.
+func (s *LocalFileStorage) DownloadSegment(segment, destDir string) error {
+	manifest, err := s.ReadManifest(segment)
+	if err != nil {
+		return err
+	}
+	srcDir := filepath.Join(s.baseDir(), segment)
+	if manifest.Archive != "" {
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
+		}
+		f, err := os.Open(filepath.Join(srcDir, manifest.Archive))
+		if err != nil {
+			return fmt.Errorf("failed to open segment archive: %w", err)
+		}
+		defer f.Close()
+		if err := archive.UnpackTarGz(f, destDir); err != nil {
+			return fmt.Errorf("failed to unpack segment archive %s: %w", f.Name(), err)
+		}
+	}
+	for _, f := range manifest.Files {
+		rel := filepath.FromSlash(f.Path)
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("segment %s: manifest file path %q escapes the segment directory", segment, f.Path)
+		}
+		if manifest.Archive == "" {
+			holder := srcDir
+			if f.Segment != "" {
+				if err := checkSegmentName(f.Segment); err != nil {
+					return err
+				}
+				holder = filepath.Join(s.baseDir(), f.Segment)
+			}
+			if err := copyFile(filepath.Join(holder, rel), filepath.Join(destDir, rel)); err != nil {
+				return err
+			}
+		}
+		if err := verifyFile(destDir, f); err != nil {
+			return err
+		}
+	}
+	return writeManifest(destDir, manifest)
+}
+
+// downloadManifest downloads and decodes the manifest under the given key prefix.
+func (s *S3Storage) downloadManifest(prefix string) (*SegmentManifest, error) {
+	buf := aws.NewWriteAtBuffer(nil)
+	if _, err := s.downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(prefix + ManifestFileName),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to download segment manifest s3://%s/%s%s: %w", s.bucket, prefix, ManifestFileName, err)
+	}
+	var manifest SegmentManifest
+	if err := json.Unmarshal(buf.Bytes(), &manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal segment manifest s3://%s/%s%s: %w", s.bucket, prefix, ManifestFileName, err)
+	}
+	return &manifest, nil
+}
+
+// isS3NotFound reports whether err is S3's answer for a missing key.
+func isS3NotFound(err error) bool {
+	var reqErr awserr.RequestFailure
+	return errors.As(err, &reqErr) && (reqErr.Code() == s3.ErrCodeNoSuchKey || reqErr.StatusCode() == http.StatusNotFound)
+}
+
+// downloadFiles downloads the files of manifest concurrently into destDir.
+func (s *S3Storage) downloadFiles(base, segment, destDir string, manifest *SegmentManifest) error {
+	return forEachFile(manifest.Files, s.downloadConcurrency, func(f ManifestFile) error {
+		holder := segment
+		if f.Segment != "" {
+			holder = f.Segment
+		}
+		return s.downloadFile(base+holder+"/"+f.Path, destDir, f)
+	})
+}
+
+// downloadFile downloads the object at key to the path of f under destDir and verifies it.
+func (s *S3Storage) downloadFile(key, destDir string, f ManifestFile) error {
+	path := filepath.Join(destDir, filepath.FromSlash(f.Path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	_, err = s.downloader.Download(file, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return verifyFile(destDir, f)
+}
+
+// downloadArchive downloads the segment archive under prefix, unpacks it into destDir
+// and verifies the unpacked files.
+func (s *S3Storage) downloadArchive(prefix, destDir string, manifest *SegmentManifest) error {
+	compression, err := archive.ParseCompression(manifest.Compression)
+	if err != nil {
+		return fmt.Errorf("segment %s: %w", manifest.Segment, err)
+	}
+	if compression != archive.CompressionGzip {
+		return fmt.Errorf("segment %s has archive %s but compression %q", manifest.Segment, manifest.Archive, manifest.Compression)
+	}
+	tmp, err := os.CreateTemp("", "segment-*-"+manifest.Archive)
+	if err != nil {
+		return fmt.Errorf("failed to create segment archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	key := prefix + manifest.Archive
+	if _, err := s.downloader.Download(tmp, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmt.Errorf("failed to download s3://%s/%s: %w", s.bucket, key, err)
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return fmt.Errorf("failed to rewind segment archive: %w", err)
+	}
+	if err := archive.UnpackTarGz(tmp, destDir); err != nil {
+		return fmt.Errorf("failed to unpack segment archive s3://%s/%s: %w", s.bucket, key, err)
+	}
+	for _, f := range manifest.Files {
+		if err := verifyFile(destDir, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyFile checks the file f of the segment in dir against its size and checksums.
+// Files recorded without checksums are only checked for size.
+func verifyFile(dir string, f ManifestFile) error {
+	path := filepath.Join(dir, filepath.FromSlash(f.Path))
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat downloaded file %s: %w", path, err)
+	}
+	if info.Size() != f.Size {
+		return fmt.Errorf("%w: %s has %d bytes, manifest lists %d", ErrChecksumMismatch, f.Path, info.Size(), f.Size)
+	}
+	if f.Checksum == "" && f.SHA256 == "" {
+		return nil
+	}
+	sum, sha, err := fileChecksums(path)
+	if err != nil {
+		return err
+	}
+	if f.Checksum != "" && sum != f.Checksum {
+		return fmt.Errorf("%w: %s has checksum %s, manifest lists %s", ErrChecksumMismatch, f.Path, sum, f.Checksum)
+	}
+	if f.SHA256 != "" && sha != f.SHA256 {
+		return fmt.Errorf("%w: %s has SHA-256 %s, manifest lists %s", ErrChecksumMismatch, f.Path, sha, f.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"common/archive"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 is an in-memory S3 client serving listings and ranged GETs.
+type fakeS3 struct {
+	s3iface.S3API
+	mu      sync.Mutex
+	objects map[string][]byte
+	ranges  int // Number of ranged GETs served
+}
+
+func (f *fakeS3) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, aws.StringValue(in.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	// Serve one key per page to exercise pagination.
+	for i, key := range keys {
+		if !fn(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(key)}}}, i == len(keys)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeS3) GetObjectWithContext(_ aws.Context, in *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil), http.StatusNotFound, "")
+	}
+	out := &s3.GetObjectOutput{}
+	if in.Range != nil && len(data) > 0 {
+		f.ranges++
+		var start, end int
+		if _, err := fmt.Sscanf(aws.StringValue(in.Range), "bytes=%d-%d", &start, &end); err != nil {
+			return nil, err
+		}
+		if end >= len(data) {
+			end = len(data) - 1
+		}
+		out.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+	}
+	out.ContentLength = aws.Int64(int64(len(data)))
+	out.Body = io.NopCloser(bytes.NewReader(data))
+	return out, nil
+}
+
+// putSegment stores the files of a segment and its manifest under prefix.
+func (f *fakeS3) putSegment(t *testing.T, prefix string, files map[string]string, manifest *SegmentManifest) {
+	t.Helper()
+	for path, data := range files {
+		f.objects[prefix+path] = []byte(data)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("Failed to marshal manifest: %v", err)
+	}
+	f.objects[prefix+ManifestFileName] = data
+}
+
+// testSegment writes files to a temporary directory and returns their manifest.
+func testSegment(t *testing.T, name string, files map[string]string) *SegmentManifest {
+	t.Helper()
+	dir := t.TempDir()
+	for path, data := range files {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	manifest, err := buildSegmentManifest(dir, "", "products", name)
+	if err != nil {
+		t.Fatalf("buildSegmentManifest returned an error: %v", err)
+	}
+	return manifest
+}
+
+func TestS3Storage_ListSegments(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{
+		"products/index_1/" + ManifestFileName:           []byte("{}"),
+		"products/index_1/store/root.bolt":               []byte("x"),
+		"products/index_2/store/root.bolt":               []byte("x"), // Upload still in progress
+		"products/other_1/" + ManifestFileName:           []byte("{}"),
+		"products/snapshots/nightly/" + ManifestFileName: []byte("{}"),
+		"articles/index_3/" + ManifestFileName:           []byte("{}"),
+	}}
+	storage := newS3StorageWithClient("bucket", fake)
+	if err := storage.SetCollection("products"); err != nil {
+		t.Fatalf("SetCollection returned an error: %v", err)
+	}
+
+	got, err := storage.ListSegments("")
+	if err != nil {
+		t.Fatalf("ListSegments returned an error: %v", err)
+	}
+	if want := []string{"index_1", "other_1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected segments: got %v, want %v", got, want)
+	}
+	if got, _ := storage.ListSegments("index_"); !reflect.DeepEqual(got, []string{"index_1"}) {
+		t.Errorf("Expected only the segments matching the prefix, got %v", got)
+	}
+}
+
+func TestS3Storage_DownloadSegment(t *testing.T) {
+	large := strings.Repeat("0123456789", 1000)
+	files := map[string]string{"store/root.bolt": large, "store/000.zap": "zap", "empty": ""}
+	manifest := testSegment(t, "index_2", files)
+
+	// store/000.zap is unchanged since index_1, which holds its contents.
+	fake := &fakeS3{objects: map[string][]byte{}}
+	for i, f := range manifest.Files {
+		if f.Path == "store/000.zap" {
+			manifest.Files[i].Segment = "index_1"
+		}
+	}
+	fake.putSegment(t, "products/index_2/", map[string]string{"store/root.bolt": large, "empty": ""}, manifest)
+	fake.objects["products/index_1/store/000.zap"] = []byte("zap")
+
+	storage := newS3StorageWithClient("bucket", fake)
+	storage.downloader.PartSize = 4096 // Split root.bolt into several ranged GETs
+	if err := storage.SetCollection("products"); err != nil {
+		t.Fatalf("SetCollection returned an error: %v", err)
+	}
+	if err := storage.SetDownloadConcurrency(0); err == nil {
+		t.Error("Expected an error for a zero download concurrency, got nil")
+	}
+
+	dest := filepath.Join(t.TempDir(), "index_2")
+	if err := storage.DownloadSegment("index_2", dest); err != nil {
+		t.Fatalf("DownloadSegment returned an error: %v", err)
+	}
+	for path, want := range files {
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(path)))
+		if err != nil || string(got) != want {
+			t.Errorf("Unexpected contents of %s (%v)", path, err)
+		}
+	}
+	if fake.ranges < 3 {
+		t.Errorf("Expected root.bolt to be downloaded in ranged parts, got %d ranged GETs", fake.ranges)
+	}
+	if _, err := ReadSegmentManifest(dest); err != nil {
+		t.Errorf("Expected the manifest to be written: %v", err)
+	}
+
+	// A corrupted file fails the download and leaves no manifest behind.
+	fake.objects["products/index_2/store/root.bolt"] = []byte(strings.Repeat("x", len(large)))
+	dest = filepath.Join(t.TempDir(), "index_2")
+	if err := storage.DownloadSegment("index_2", dest); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, ManifestFileName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no manifest after a failed download, got %v", err)
+	}
+
+	if err := storage.DownloadSegment("../index_2", dest); err == nil {
+		t.Error("Expected an error for an invalid segment name, got nil")
+	}
+}
+
+func TestS3Storage_DownloadSegment_Archive(t *testing.T) {
+	files := map[string]string{"store/root.bolt": "12345"}
+	manifest := testSegment(t, "index_1", files)
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "store"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "store", "root.bolt"), []byte("12345"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	archivePath, err := packSegment(src, archive.CompressionGzip, manifest)
+	if err != nil {
+		t.Fatalf("packSegment returned an error: %v", err)
+	}
+	defer os.Remove(archivePath)
+	packed, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+
+	fake := &fakeS3{objects: map[string][]byte{}}
+	fake.putSegment(t, "index_1/", map[string]string{manifest.Archive: string(packed)}, manifest)
+	dest := t.TempDir()
+	if err := newS3StorageWithClient("bucket", fake).DownloadSegment("index_1", dest); err != nil {
+		t.Fatalf("DownloadSegment returned an error: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dest, "store", "root.bolt")); err != nil || string(got) != "12345" {
+		t.Errorf("Expected the archive to be unpacked, got %q (%v)", got, err)
+	}
+}
+
+func TestS3Storage_DownloadSnapshot(t *testing.T) {
+	files := map[string]string{"index_meta.json": "{}"}
+	fake := &fakeS3{objects: map[string][]byte{}}
+	fake.putSegment(t, "snapshots/nightly/", files, testSegment(t, "nightly", files))
+	storage := newS3StorageWithClient("bucket", fake)
+
+	dest := t.TempDir()
+	if err := storage.DownloadSnapshot("nightly", dest); err != nil {
+		t.Fatalf("DownloadSnapshot returned an error: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dest, "index_meta.json")); err != nil || string(got) != "{}" {
+		t.Errorf("Unexpected snapshot contents %q (%v)", got, err)
+	}
+	if err := storage.DownloadSnapshot("missing", dest); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Expected ErrSnapshotNotFound, got %v", err)
+	}
+}
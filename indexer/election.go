@@ -0,0 +1,446 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Leader election implementations selected by ElectionConfig.Type.
+const (
+	ElectionEtcd   = "etcd"
+	ElectionConsul = "consul"
+)
+
+// defaultElectionTTL is how long a leader that stopped renewing its leadership, e.g.
+// because it crashed, keeps it before another replica can take over.
+const defaultElectionTTL = 15 * time.Second
+
+// ErrNotLeader is returned for commits of a replica that isn't the leader.
+var ErrNotLeader = errors.New("this indexer is not the leader")
+
+// leaderGauge is 1 while this replica is the leader, exposed by promhttp.Handler().
+var leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "indexer_leader",
+	Help: "Whether this indexer replica is the elected leader (1) or a follower (0).",
+})
+
+// LeaderElector elects one leader among the indexer replicas, through a coordinator
+// holding a leadership record that expires unless its holder renews it.
+type LeaderElector interface {
+	// Campaign makes address the leader unless another replica is, renews the leadership
+	// if address already leads, and returns the address of the leader, empty if none.
+	Campaign(address string) (leader string, err error)
+	// Resign gives up the leadership, if held, so another replica can take over at once.
+	Resign() error
+}
+
+// ElectionConfig enables leader election among indexer replicas: only the leader accepts
+// writes and commits; followers redirect writes to it.
+type ElectionConfig struct {
+	Type      string        `yaml:"type" env:"LEADER_ELECTION" flag:"leader-election" usage:"Leader election among indexer replicas: etcd or consul; empty disables it"`
+	Endpoint  string        `yaml:"endpoint" env:"LEADER_ELECTION_ENDPOINT" flag:"leader-election-endpoint" usage:"Base URL of the coordinator, e.g. http://etcd:2379 or http://consul:8500"`
+	Key       string        `yaml:"key" env:"LEADER_ELECTION_KEY" flag:"leader-election-key" usage:"Key of the leadership record, shared by the replicas of a collection"`
+	TTL       time.Duration `yaml:"ttl" env:"LEADER_ELECTION_TTL" flag:"leader-election-ttl" usage:"How long a leader that stopped renewing its leadership keeps it"`
+	Advertise string        `yaml:"advertise_url" env:"ADVERTISE_URL" flag:"advertise-url" usage:"Base URL of this replica that followers redirect writes to, e.g. http://indexer-0:8081"`
+}
+
+// Validate checks that an enabled election has its settings.
+func (c ElectionConfig) Validate() error {
+	switch c.Type {
+	case "":
+		return nil
+	case ElectionEtcd, ElectionConsul:
+	default:
+		return fmt.Errorf("unknown leader election %q (expected etcd or consul)", c.Type)
+	}
+	if c.Endpoint == "" || c.Key == "" || c.Advertise == "" {
+		return fmt.Errorf("leader election requires an endpoint, a key and an advertise URL")
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("leader election ttl must not be negative")
+	}
+	return nil
+}
+
+// NewElection returns the configured election, nil if it's disabled. It must be started.
+func NewElection(cfg ElectionConfig) (*Election, error) {
+	if err := cfg.Validate(); err != nil || cfg.Type == "" {
+		return nil, err
+	}
+	ttl := cfg.TTL
+	if ttl == 0 {
+		ttl = defaultElectionTTL
+	}
+	var elector LeaderElector
+	if cfg.Type == ElectionEtcd {
+		elector = NewEtcdElector(cfg.Endpoint, cfg.Key, ttl, nil)
+	} else {
+		elector = NewConsulElector(cfg.Endpoint, cfg.Key, ttl, nil)
+	}
+	return NewElectionWithElector(elector, strings.TrimSuffix(cfg.Advertise, "/"), ttl), nil
+}
+
+// Election campaigns for the leadership of a replica in the background. The replica only
+// considers itself the leader for two thirds of the TTL after its last renewal, so it
+// steps down before the coordinator lets another replica take over: two replicas never
+// upload at once, even when the coordinator is unreachable.
+type Election struct {
+	elector LeaderElector
+	address string
+	ttl     time.Duration
+
+	mu        sync.Mutex
+	leader    string    // Address of the leader at the last campaign
+	renewedAt time.Time // Start of the last campaign that made or kept this replica the leader
+	term      uint64    // Number of times this replica became the leader
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewElectionWithElector returns the election of the replica at address through elector,
+// whose leadership expires after ttl.
+func NewElectionWithElector(elector LeaderElector, address string, ttl time.Duration) *Election {
+	return &Election{elector: elector, address: address, ttl: ttl}
+}
+
+// Start campaigns right away, then every third of the TTL until Stop.
+func (e *Election) Start() {
+	e.stop, e.done = make(chan struct{}), make(chan struct{})
+	e.campaign()
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.campaign()
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// campaign runs one round of the election.
+func (e *Election) campaign() {
+	start := time.Now()
+	leader, err := e.elector.Campaign(e.address)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	wasLeading := e.leadingLocked()
+	if err != nil {
+		slog.Error("Leader election failed", "error", err)
+	} else {
+		if leader != e.leader {
+			slog.Info("Indexer leader changed", "leader", leader)
+		}
+		e.leader = leader
+		if leader == e.address {
+			e.renewedAt = start
+		}
+	}
+	if leading := e.leadingLocked(); leading != wasLeading {
+		if leading {
+			e.term++
+			slog.Info("This indexer became the leader", "address", e.address, "term", e.term)
+			leaderGauge.Set(1)
+		} else {
+			slog.Info("This indexer stepped down to follower", "address", e.address)
+			leaderGauge.Set(0)
+		}
+	}
+}
+
+// Stop stops campaigning and resigns the leadership, if held.
+func (e *Election) Stop() {
+	if e.stop == nil {
+		return
+	}
+	close(e.stop)
+	<-e.done
+	e.stop = nil
+	e.mu.Lock()
+	e.leader, e.renewedAt = "", time.Time{}
+	e.mu.Unlock()
+	leaderGauge.Set(0)
+	if err := e.elector.Resign(); err != nil {
+		slog.Error("Failed to resign the leadership", "error", err)
+	}
+}
+
+// leadingLocked reports whether this replica holds a leadership that can't have expired.
+// Callers must hold e.mu.
+func (e *Election) leadingLocked() bool {
+	return e.leader == e.address && time.Since(e.renewedAt) < e.ttl*2/3
+}
+
+// Status reports whether this replica is the leader and, if not, the address of the
+// leader, empty while none is known.
+func (e *Election) Status() (leading bool, leader string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leadingLocked() {
+		return true, e.address
+	}
+	if e.leader == e.address {
+		return false, "" // Our leadership may have expired
+	}
+	return false, e.leader
+}
+
+// leaderTerm returns the number of times this replica became the leader, which tells a
+// new leadership from a renewed one.
+func (e *Election) leaderTerm() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.term
+}
+
+// SetLeaderElection makes the indexer accept commits only while e elects it. The web
+// service redirects writes of followers to the leader.
+func (i *Indexer) SetLeaderElection(e *Election) {
+	i.election.Store(e)
+}
+
+// Leadership reports whether the indexer may accept writes and, if not, the address of
+// the leader. Indexers without an election always lead. Every time the replica becomes
+// the leader, it first catches up with the latest segment uploaded, by the previous
+// leader, in the background; until then, it reports no leader, so writes are retried.
+func (i *Indexer) Leadership() (leading bool, leader string) {
+	e := i.election.Load()
+	if e == nil {
+		return true, ""
+	}
+	if leading, leader = e.Status(); !leading {
+		return false, leader
+	}
+	if term := e.leaderTerm(); i.syncedTerm.Load() != term {
+		i.startCatchUp(term)
+		return false, ""
+	}
+	return true, leader
+}
+
+// EtcdElector is a LeaderElector keeping the leader's address in an etcd key attached to
+// a lease, created by a transaction only if the key doesn't exist and kept alive by every
+// campaign of the leader.
+type EtcdElector struct {
+	etcd  etcdClient
+	key   string
+	ttl   time.Duration
+	lease string // Lease granted to this replica; empty if none
+}
+
+// NewEtcdElector returns the elector of key at the etcd cluster behind endpoint. A nil
+// client uses http.DefaultClient.
+func NewEtcdElector(endpoint, key string, ttl time.Duration, client *http.Client) *EtcdElector {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &EtcdElector{etcd: etcdClient{endpoint: strings.TrimSuffix(endpoint, "/"), client: client}, key: key, ttl: ttl}
+}
+
+// Campaign keeps the lease of this replica alive, granting a new one if it expired, and
+// creates the key with it unless another replica holds it.
+func (el *EtcdElector) Campaign(address string) (string, error) {
+	if el.lease != "" {
+		var alive struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		if err := el.etcd.call("lease/keepalive", map[string]string{"ID": el.lease}, &alive); err != nil {
+			return "", err
+		}
+		if alive.Result.TTL == "" || alive.Result.TTL == "0" {
+			el.lease = "" // Expired
+		}
+	}
+	if el.lease == "" {
+		var grant struct {
+			ID string `json:"ID"`
+		}
+		ttlSeconds := int64((el.ttl + time.Second - 1) / time.Second)
+		if err := el.etcd.call("lease/grant", map[string]interface{}{"TTL": ttlSeconds}, &grant); err != nil {
+			return "", err
+		}
+		if grant.ID == "" {
+			return "", fmt.Errorf("etcd lease not granted")
+		}
+		el.lease = grant.ID
+	}
+
+	key := base64.StdEncoding.EncodeToString([]byte(el.key))
+	var txn struct {
+		Succeeded bool `json:"succeeded"`
+		Responses []struct {
+			Range struct {
+				KVs []struct {
+					Value string `json:"value"`
+				} `json:"kvs"`
+			} `json:"response_range"`
+		} `json:"responses"`
+	}
+	err := el.etcd.call("kv/txn", map[string]interface{}{
+		"compare": []map[string]string{{"key": key, "target": "CREATE", "result": "EQUAL", "create_revision": "0"}},
+		"success": []map[string]interface{}{{"request_put": map[string]string{
+			"key":   key,
+			"value": base64.StdEncoding.EncodeToString([]byte(address)),
+			"lease": el.lease,
+		}}},
+		"failure": []map[string]interface{}{{"request_range": map[string]string{"key": key}}},
+	}, &txn)
+	if err != nil {
+		return "", err
+	}
+	if txn.Succeeded {
+		return address, nil
+	}
+	if len(txn.Responses) == 0 || len(txn.Responses[0].Range.KVs) == 0 {
+		return "", nil // Deleted since the comparison
+	}
+	leader, err := base64.StdEncoding.DecodeString(txn.Responses[0].Range.KVs[0].Value)
+	if err != nil {
+		return "", fmt.Errorf("invalid etcd leader value: %w", err)
+	}
+	return string(leader), nil
+}
+
+// Resign revokes the lease of this replica, deleting the key if it leads.
+func (el *EtcdElector) Resign() error {
+	if el.lease == "" {
+		return nil
+	}
+	err := el.etcd.call("lease/revoke", map[string]string{"ID": el.lease}, nil)
+	el.lease = ""
+	return err
+}
+
+// ConsulElector is a LeaderElector keeping the leader's address in a Consul KV key locked
+// by a session whose TTL every campaign renews. The session is deleted with its key when
+// it expires.
+type ConsulElector struct {
+	endpoint string
+	key      string
+	ttl      time.Duration
+	client   *http.Client
+	session  string // Session of this replica; empty if none
+}
+
+// NewConsulElector returns the elector of key at the Consul agent behind endpoint, e.g.
+// http://consul:8500. Consul sessions last at least 10 seconds. A nil client uses
+// http.DefaultClient.
+func NewConsulElector(endpoint, key string, ttl time.Duration, client *http.Client) *ConsulElector {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ConsulElector{endpoint: strings.TrimSuffix(endpoint, "/"), key: strings.TrimPrefix(key, "/"), ttl: ttl, client: client}
+}
+
+// do sends a request to the Consul HTTP API and decodes its response into resp. It
+// returns the status of responses other than 200 and 404 as an error.
+func (c *ConsulElector) do(method, path string, body []byte, resp interface{}) (int, error) {
+	req, err := http.NewRequest(method, c.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("consul %s %s failed: %w", method, path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		io.Copy(io.Discard, res.Body)
+		return res.StatusCode, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, fmt.Errorf("consul %s %s failed with status %s", method, path, res.Status)
+	}
+	if resp != nil {
+		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+			return res.StatusCode, fmt.Errorf("failed to decode consul %s response: %w", path, err)
+		}
+	}
+	return res.StatusCode, nil
+}
+
+// Campaign renews the session of this replica, creating one if it expired, acquires the
+// key with it and reads the leader back.
+func (c *ConsulElector) Campaign(address string) (string, error) {
+	if c.session != "" {
+		status, err := c.do(http.MethodPut, "/v1/session/renew/"+c.session, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		if status == http.StatusNotFound {
+			c.session = "" // Expired
+		}
+	}
+	if c.session == "" {
+		body, _ := json.Marshal(map[string]string{
+			"Name":      "indexer-leader " + c.key,
+			"TTL":       fmt.Sprintf("%ds", int64((c.ttl+time.Second-1)/time.Second)),
+			"Behavior":  "delete",
+			"LockDelay": "0s",
+		})
+		var created struct {
+			ID string `json:"ID"`
+		}
+		if _, err := c.do(http.MethodPut, "/v1/session/create", body, &created); err != nil {
+			return "", err
+		}
+		if created.ID == "" {
+			return "", fmt.Errorf("consul session not created")
+		}
+		c.session = created.ID
+	}
+
+	var acquired bool
+	if _, err := c.do(http.MethodPut, "/v1/kv/"+c.key+"?acquire="+url.QueryEscape(c.session), []byte(address), &acquired); err != nil {
+		return "", err
+	}
+	if acquired {
+		return address, nil
+	}
+	var entries []struct {
+		Value   string `json:"Value"`
+		Session string `json:"Session"`
+	}
+	status, err := c.do(http.MethodGet, "/v1/kv/"+c.key, nil, &entries)
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusNotFound || len(entries) == 0 || entries[0].Session == "" {
+		return "", nil // Not locked
+	}
+	leader, err := base64.StdEncoding.DecodeString(entries[0].Value)
+	if err != nil {
+		return "", fmt.Errorf("invalid consul leader value: %w", err)
+	}
+	return string(leader), nil
+}
+
+// Resign destroys the session of this replica, deleting the key if it leads.
+func (c *ConsulElector) Resign() error {
+	if c.session == "" {
+		return nil
+	}
+	_, err := c.do(http.MethodPut, "/v1/session/destroy/"+c.session, nil, nil)
+	c.session = ""
+	return err
+}
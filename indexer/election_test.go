@@ -0,0 +1,231 @@
+package indexer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeElector elects leader, or fails with err.
+type fakeElector struct {
+	mu       sync.Mutex
+	leader   string
+	err      error
+	resigned bool
+}
+
+func (f *fakeElector) Campaign(address string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return "", f.err
+	}
+	if f.leader == "" {
+		f.leader = address
+	}
+	return f.leader, nil
+}
+
+func (f *fakeElector) Resign() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resigned, f.leader = true, ""
+	return nil
+}
+
+func TestElection(t *testing.T) {
+	elector := &fakeElector{}
+	e := NewElectionWithElector(elector, "http://indexer-0:8081", time.Minute)
+	e.Start()
+	if leading, leader := e.Status(); !leading || leader != "http://indexer-0:8081" {
+		t.Errorf("Expected to lead, got %t %q", leading, leader)
+	}
+
+	// A leader whose renewals fail steps down before its leadership may expire.
+	elector.mu.Lock()
+	elector.err = errors.New("coordinator unreachable")
+	elector.mu.Unlock()
+	e.mu.Lock()
+	e.renewedAt = time.Now().Add(-time.Minute)
+	e.mu.Unlock()
+	e.campaign()
+	if leading, _ := e.Status(); leading {
+		t.Errorf("Expected the leader to step down once its leadership may have expired")
+	}
+	e.Stop()
+	if !elector.resigned {
+		t.Errorf("Expected Stop to resign")
+	}
+
+	follower := NewElectionWithElector(&fakeElector{leader: "http://indexer-1:8081"}, "http://indexer-0:8081", time.Minute)
+	follower.Start()
+	defer follower.Stop()
+	if leading, leader := follower.Status(); leading || leader != "http://indexer-1:8081" {
+		t.Errorf("Expected to follow indexer-1, got %t %q", leading, leader)
+	}
+}
+
+func TestIndexer_CommitRequiresLeadership(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+
+	follower := NewElectionWithElector(&fakeElector{leader: "http://indexer-1:8081"}, "http://indexer-0:8081", time.Minute)
+	follower.Start()
+	defer follower.Stop()
+	idx.SetLeaderElection(follower)
+	if err := idx.CommitAndUpload(); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("Expected ErrNotLeader for a follower's commit, got %v", err)
+	}
+}
+
+func TestIndexer_LeaderCatchesUp(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	previous, err := NewIndexer(filepath.Join(tempDir, "previous", "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer previous.Close()
+	if err := previous.IndexDocument("a", map[string]interface{}{"title": "A"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if err := previous.CommitAndUpload(); err != nil {
+		t.Fatalf("CommitAndUpload returned an error: %v", err)
+	}
+
+	idx, err := NewIndexer(filepath.Join(tempDir, "next", "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	e := NewElectionWithElector(&fakeElector{}, "http://indexer-1:8081", time.Minute)
+	e.Start()
+	defer e.Stop()
+	idx.SetLeaderElection(e)
+	lead := func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for leading, _ := idx.Leadership(); !leading; leading, _ = idx.Leadership() {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the new leader to catch up")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Writes are refused until the new leader holds the previous leader's segment.
+	if leading, leader := idx.Leadership(); leading || leader != "" {
+		t.Errorf("Expected no leader while catching up, got %t %q", leading, leader)
+	}
+	lead()
+	if _, err := idx.GetDocument("a", nil); err != nil {
+		t.Errorf("Expected the document of the previous leader, got %v", err)
+	}
+
+	// Leading again after its own upload keeps the writes made since.
+	if err := idx.CommitAndUpload(); err != nil {
+		t.Fatalf("CommitAndUpload returned an error: %v", err)
+	}
+	if err := idx.IndexDocument("b", map[string]interface{}{"title": "B"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	e.mu.Lock()
+	e.term++
+	e.mu.Unlock()
+	lead()
+	if _, err := idx.GetDocument("b", nil); err != nil {
+		t.Errorf("Expected the uncommitted document to be kept, got %v", err)
+	}
+}
+
+// fakeConsul serves the Consul session and KV API methods used by ConsulElector.
+type fakeConsul struct {
+	mu       sync.Mutex
+	sessions map[string]bool
+	lock     struct{ session, value string }
+	next     int
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/v1/session/create":
+		f.next++
+		id := "s" + string(rune('0'+f.next))
+		f.sessions[id] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		if !f.sessions[strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")] {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[]`))
+	case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/")
+		delete(f.sessions, id)
+		if f.lock.session == id {
+			f.lock.session, f.lock.value = "", ""
+		}
+		w.Write([]byte(`true`))
+	case r.URL.Path == "/v1/kv/indexer/leader" && r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		id := r.URL.Query().Get("acquire")
+		if f.lock.session != "" && f.lock.session != id {
+			w.Write([]byte(`false`))
+			return
+		}
+		f.lock.session, f.lock.value = id, string(body)
+		w.Write([]byte(`true`))
+	case r.URL.Path == "/v1/kv/indexer/leader":
+		if f.lock.session == "" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]string{{"Session": f.lock.session, "Value": base64.StdEncoding.EncodeToString([]byte(f.lock.value))}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestConsulElector(t *testing.T) {
+	server := httptest.NewServer(&fakeConsul{sessions: map[string]bool{}})
+	defer server.Close()
+	a := NewConsulElector(server.URL, "indexer/leader", 10*time.Second, nil)
+	b := NewConsulElector(server.URL, "indexer/leader", 10*time.Second, nil)
+
+	if leader, err := a.Campaign("http://a"); err != nil || leader != "http://a" {
+		t.Fatalf("Expected a to lead, got %q, %v", leader, err)
+	}
+	if leader, err := b.Campaign("http://b"); err != nil || leader != "http://a" {
+		t.Fatalf("Expected b to follow a, got %q, %v", leader, err)
+	}
+	if leader, err := a.Campaign("http://a"); err != nil || leader != "http://a" {
+		t.Errorf("Expected a to renew its leadership, got %q, %v", leader, err)
+	}
+	if err := a.Resign(); err != nil {
+		t.Fatalf("Resign returned an error: %v", err)
+	}
+	if leader, err := b.Campaign("http://b"); err != nil || leader != "http://b" {
+		t.Errorf("Expected b to take over, got %q, %v", leader, err)
+	}
+}
@@ -0,0 +1,264 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"common/segcrypt"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Server-side encryption modes of S3Storage.
+const (
+	SSEModeNone = ""                            // Objects are encrypted as the bucket's defaults dictate
+	SSEModeS3   = s3.ServerSideEncryptionAes256 // SSE-S3: keys managed by S3
+	SSEModeKMS  = s3.ServerSideEncryptionAwsKms // SSE-KMS: keys managed by AWS KMS
+)
+
+// ErrDecryption is returned for encrypted files that are corrupted, truncated or were
+// encrypted with another key.
+var ErrDecryption = segcrypt.ErrDecryption
+
+// ErrDownloadUnsupported is returned when the configured storage cannot download segments.
+var ErrDownloadUnsupported = errors.New("storage does not support segment downloads")
+
+// encryptionState records the plaintext of the files of an encrypted staging copy, by path
+// relative to the segment, so unchanged files keep their ciphertext.
+type encryptionState map[string]ManifestFile
+
+// SetServerSideEncryption makes S3 encrypt the uploaded objects at rest, with keys it
+// manages (SSEModeS3) or with a KMS key (SSEModeKMS). kmsKeyID selects the KMS key; empty
+// uses the account's default key. SSEModeNone leaves encryption to the bucket's defaults.
+func (s *S3Storage) SetServerSideEncryption(mode, kmsKeyID string) error {
+	switch mode {
+	case SSEModeNone, SSEModeS3:
+		if kmsKeyID != "" {
+			return fmt.Errorf("a KMS key ID requires server-side encryption mode %s", SSEModeKMS)
+		}
+	case SSEModeKMS:
+	default:
+		return fmt.Errorf("unknown server-side encryption mode %q, expected %s or %s", mode, SSEModeS3, SSEModeKMS)
+	}
+	s.sseMode = mode
+	s.sseKMSKeyID = kmsKeyID
+	return nil
+}
+
+// EncryptedStorage wraps an IndexSegmentStorage to encrypt segments on the client before
+// they reach the storage, so they are protected at rest in any backend; see package
+// segcrypt for the file format. Every file except the manifest listing the encrypted files
+// is encrypted. Segments are encrypted into a staging copy kept next to them, where files
+// unchanged since the previous upload keep their ciphertext, so uploads stay incremental.
+// Searchers decrypt the segments they download with the same key, see
+// searcher.TieringConfig.
+type EncryptedStorage struct {
+	inner  IndexSegmentStorage
+	cipher *segcrypt.Cipher
+	mu     sync.Mutex // Serializes the updates of the staging copies
+}
+
+// NewEncryptedStorage returns a storage encrypting segments with key, which must be 16,
+// 24 or 32 bytes long to select AES-128, AES-192 or AES-256, before uploading them to inner.
+func NewEncryptedStorage(inner IndexSegmentStorage, key []byte) (*EncryptedStorage, error) {
+	c, err := segcrypt.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedStorage{inner: inner, cipher: c}, nil
+}
+
+// ParseEncryptionKey decodes a hex-encoded AES key, such as the output of
+// "openssl rand -hex 32".
+func ParseEncryptionKey(s string) ([]byte, error) {
+	return segcrypt.ParseKey(s)
+}
+
+// UploadSegment brings the encrypted staging copy of the segment up to date and uploads it
+// to the wrapped storage under the segment's name.
+func (e *EncryptedStorage) UploadSegment(segmentPath string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	staged, err := e.stage(segmentPath)
+	if err != nil {
+		return err
+	}
+	return e.inner.UploadSegment(staged)
+}
+
+// UploadSnapshot encrypts a copy of the snapshot and uploads it to the wrapped storage.
+func (e *EncryptedStorage) UploadSnapshot(name, snapshotPath string) error {
+	snapshots, ok := e.inner.(SnapshotStorage)
+	if !ok {
+		return ErrSnapshotsUnsupported
+	}
+	// Snapshots are uploaded once, so they are encrypted into a temporary copy with the
+	// same base name, for the wrapped storage to name the upload alike.
+	tmp, err := os.MkdirTemp("", "encrypted-snapshot-*")
+	if err != nil {
+		return fmt.Errorf("failed to create encryption staging directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	dst := filepath.Join(tmp, filepath.Base(snapshotPath))
+	if err := e.encryptDir(snapshotPath, dst, nil); err != nil {
+		return err
+	}
+	return snapshots.UploadSnapshot(name, dst)
+}
+
+// DownloadSnapshot downloads the snapshot from the wrapped storage and decrypts it in place.
+func (e *EncryptedStorage) DownloadSnapshot(name, destPath string) error {
+	snapshots, ok := e.inner.(SnapshotStorage)
+	if !ok {
+		return ErrSnapshotsUnsupported
+	}
+	if err := snapshots.DownloadSnapshot(name, destPath); err != nil {
+		return err
+	}
+	return e.cipher.DecryptDir(destPath, ManifestFileName)
+}
+
+// ListSegments lists the segments of the wrapped storage.
+func (e *EncryptedStorage) ListSegments(prefix string) ([]string, error) {
+	source, ok := e.inner.(SegmentSource)
+	if !ok {
+		return nil, ErrDownloadUnsupported
+	}
+	return source.ListSegments(prefix)
+}
+
+// ReadManifest returns the manifest of a segment of the wrapped storage, which lists the
+// sizes and checksums of the encrypted files.
+func (e *EncryptedStorage) ReadManifest(segment string) (*SegmentManifest, error) {
+	collector, ok := e.inner.(interface {
+		ReadManifest(segment string) (*SegmentManifest, error)
+	})
+	if !ok {
+		return nil, ErrDownloadUnsupported
+	}
+	return collector.ReadManifest(segment)
+}
+
+// DeleteSegment deletes a segment from the wrapped storage.
+func (e *EncryptedStorage) DeleteSegment(segment string) error {
+	collector, ok := e.inner.(SegmentCollector)
+	if !ok {
+		return ErrGCUnsupported
+	}
+	return collector.DeleteSegment(segment)
+}
+
+// DownloadSegment downloads a segment from the wrapped storage, which verifies it against
+// its manifest, and decrypts it in place.
+func (e *EncryptedStorage) DownloadSegment(segment, destDir string) error {
+	source, ok := e.inner.(SegmentSource)
+	if !ok {
+		return ErrDownloadUnsupported
+	}
+	if err := source.DownloadSegment(segment, destDir); err != nil {
+		return err
+	}
+	return e.cipher.DecryptDir(destDir, ManifestFileName)
+}
+
+// UploadAlias uploads the alias to the wrapped storage unencrypted, like the manifests: it
+// only names the physical indexes, and searchers read it to tell them apart.
+func (e *EncryptedStorage) UploadAlias(alias *IndexAlias) error {
+	aliases, ok := e.inner.(AliasStorage)
+	if !ok {
+		return ErrAliasUnsupported
+	}
+	return aliases.UploadAlias(alias)
+}
+
+// stagingPath returns the encrypted staging copy of segmentPath. It is kept next to the
+// index directory, like the upload state, and has the same base name, for the wrapped
+// storage to name the upload alike.
+func stagingPath(segmentPath string) string {
+	base := filepath.Base(segmentPath)
+	return filepath.Join(filepath.Dir(segmentPath), "."+base+".encrypted", base)
+}
+
+// stage brings the staging copy of segmentPath up to date and returns its path: files
+// whose size or checksum changed since they were staged are encrypted again, and files
+// gone from the segment are removed. Callers must hold e.mu.
+func (e *EncryptedStorage) stage(segmentPath string) (string, error) {
+	staged := stagingPath(segmentPath)
+	statePath := filepath.Join(filepath.Dir(staged), "state.json")
+	var previous encryptionState
+	if data, err := os.ReadFile(statePath); err == nil {
+		if json.Unmarshal(data, &previous) != nil {
+			previous = nil // Encrypt everything again
+		}
+	}
+	state := make(encryptionState)
+	if err := e.encryptDir(segmentPath, staged, func(rel string, f ManifestFile) bool {
+		state[rel] = f
+		prev, ok := previous[rel]
+		_, err := os.Stat(filepath.Join(staged, rel))
+		return ok && err == nil && prev.Size == f.Size && prev.Checksum == f.Checksum
+	}); err != nil {
+		return "", err
+	}
+	err := filepath.WalkDir(staged, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(staged, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := state[filepath.ToSlash(rel)]; !ok {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to prune staging copy of %s: %w", segmentPath, err)
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = os.WriteFile(statePath, data, 0644)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to save the encryption state of %s: %w", segmentPath, err)
+	}
+	return staged, nil
+}
+
+// encryptDir encrypts the files of srcDir into dstDir, except those for which unchanged,
+// if set, reports that their staged ciphertext is still current.
+func (e *EncryptedStorage) encryptDir(srcDir, dstDir string, unchanged func(rel string, f ManifestFile) bool) error {
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if unchanged != nil {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			checksum, _, err := fileChecksums(path)
+			if err != nil {
+				return err
+			}
+			if unchanged(filepath.ToSlash(rel), ManifestFile{Size: info.Size(), Checksum: checksum}) {
+				return nil
+			}
+		}
+		return e.cipher.EncryptFile(path, filepath.Join(dstDir, rel))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", srcDir, err)
+	}
+	return nil
+}
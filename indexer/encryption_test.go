@@ -0,0 +1,117 @@
+package indexer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"common/segcrypt"
+)
+
+func testEncryptionKey() []byte {
+	return bytes.Repeat([]byte{7}, 32)
+}
+
+func TestEncryptedStorage_UploadSegment(t *testing.T) {
+	segmentDir := filepath.Join(t.TempDir(), "myindex")
+	if err := os.MkdirAll(filepath.Join(segmentDir, "store"), 0755); err != nil {
+		t.Fatalf("Failed to create segment dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(segmentDir, "store", "root.bolt"), []byte("top secret"), 0644); err != nil {
+		t.Fatalf("Failed to write segment file: %v", err)
+	}
+	storageDir := t.TempDir()
+	local, err := NewLocalFileStorage(storageDir)
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	if _, err := NewEncryptedStorage(local, []byte("short")); err == nil {
+		t.Error("Expected an error for an invalid key, got nil")
+	}
+	storage, err := NewEncryptedStorage(local, testEncryptionKey())
+	if err != nil {
+		t.Fatalf("NewEncryptedStorage returned an error: %v", err)
+	}
+	if err := storage.UploadSegment(segmentDir); err != nil {
+		t.Fatalf("UploadSegment returned an error: %v", err)
+	}
+
+	uploaded := filepath.Join(storageDir, "myindex")
+	data, err := os.ReadFile(filepath.Join(uploaded, "store", "root.bolt"))
+	if err != nil {
+		t.Fatalf("Expected the segment under its own name: %v", err)
+	}
+	if bytes.Contains(data, []byte("top secret")) {
+		t.Error("Expected the stored segment file to be encrypted")
+	}
+	if _, err := ReadSegmentManifest(uploaded); err != nil {
+		t.Errorf("Expected the manifest to stay readable: %v", err)
+	}
+	if err := storage.UploadAlias(&IndexAlias{Name: "myindex", WriteIndex: "myindex"}); err != nil {
+		t.Errorf("UploadAlias returned an error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storageDir, AliasFileName)); err != nil {
+		t.Errorf("Expected the alias to be uploaded through the wrapper: %v", err)
+	}
+
+	// Unchanged files keep their ciphertext, so the next upload skips them; changed files
+	// are encrypted again and removed ones are dropped from the staging copy.
+	if err := os.WriteFile(filepath.Join(segmentDir, "index_meta.json"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write segment file: %v", err)
+	}
+	if err := storage.UploadSegment(segmentDir); err != nil {
+		t.Fatalf("UploadSegment returned an error: %v", err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(uploaded, "store", "root.bolt")); !bytes.Equal(again, data) {
+		t.Error("Expected the unchanged file to keep its ciphertext")
+	}
+	if err := os.WriteFile(filepath.Join(segmentDir, "store", "root.bolt"), []byte("new secret"), 0644); err != nil {
+		t.Fatalf("Failed to write segment file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(segmentDir, "index_meta.json")); err != nil {
+		t.Fatalf("Failed to remove segment file: %v", err)
+	}
+	if err := storage.UploadSegment(segmentDir); err != nil {
+		t.Fatalf("UploadSegment returned an error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(stagingPath(segmentDir), "index_meta.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected the removed file to be dropped from the staging copy, got %v", err)
+	}
+
+	c, err := segcrypt.New(testEncryptionKey())
+	if err != nil {
+		t.Fatalf("segcrypt.New returned an error: %v", err)
+	}
+	if err := c.DecryptDir(uploaded, ManifestFileName); err != nil {
+		t.Fatalf("DecryptDir returned an error: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(uploaded, "store", "root.bolt")); err != nil || string(data) != "new secret" {
+		t.Errorf("Expected the decrypted contents, got %q (%v)", data, err)
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	if _, err := ParseEncryptionKey(strings.Repeat("ab", 32)); err != nil {
+		t.Errorf("Expected a valid AES-256 key, got %v", err)
+	}
+	for _, s := range []string{"not hex", "abcd"} {
+		if _, err := ParseEncryptionKey(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
+
+func TestS3Storage_SetServerSideEncryption(t *testing.T) {
+	storage := newS3StorageWithClient("bucket", &fakeS3{})
+	if err := storage.SetServerSideEncryption("rot13", ""); err == nil {
+		t.Error("Expected an error for an unknown mode, got nil")
+	}
+	if err := storage.SetServerSideEncryption(SSEModeS3, "key"); err == nil {
+		t.Error("Expected an error for a KMS key without SSE-KMS, got nil")
+	}
+	if err := storage.SetServerSideEncryption(SSEModeKMS, "alias/search"); err != nil {
+		t.Fatalf("SetServerSideEncryption returned an error: %v", err)
+	}
+}
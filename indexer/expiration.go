@@ -0,0 +1,104 @@
+package indexer
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// ExpiresAtField is the datetime field, e.g. "2024-06-01T00:00:00Z", after which a
+// document expires. Searchers stop returning expired documents right away; the indexer
+// deletes them on its next sweep.
+const ExpiresAtField = "expires_at"
+
+// DefaultSweepBatchSize is the number of expired documents deleted per write when
+// StartExpirySweeper is given no batch size.
+const DefaultSweepBatchSize = 500
+
+// expirySweeper periodically deletes expired documents.
+type expirySweeper struct {
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// SweepExpired deletes the documents that expired at or before now, batchSize at a time,
+// and returns how many it deleted.
+func (i *Indexer) SweepExpired(now time.Time, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultSweepBatchSize
+	}
+	expired := bleve.NewDateRangeQuery(time.Time{}, now)
+	inclusive := true
+	expired.InclusiveEnd = &inclusive
+	expired.SetField(ExpiresAtField)
+
+	deleted := 0
+	for {
+		req := bleve.NewSearchRequestOptions(expired, batchSize, 0, false)
+		i.mu.Lock()
+		result, err := i.index.Search(req)
+		i.mu.Unlock()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to find expired documents: %w", err)
+		}
+		if len(result.Hits) == 0 {
+			return deleted, nil
+		}
+		ids := make([]string, 0, len(result.Hits))
+		for _, hit := range result.Hits {
+			ids = append(ids, hit.ID)
+		}
+		if err := i.submit(walRecord{Op: walOpBulkDelete, IDs: ids}); err != nil {
+			return deleted, fmt.Errorf("failed to delete %d expired documents: %w", len(ids), err)
+		}
+		deleted += len(ids)
+		if len(result.Hits) < batchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// StartExpirySweeper deletes expired documents every interval, in batches of batchSize
+// (DefaultSweepBatchSize if 0), until Close. It may only be called once.
+func (i *Indexer) StartExpirySweeper(interval time.Duration, batchSize int) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid expiry sweep interval %s, must be positive", interval)
+	}
+	if i.sweeper != nil {
+		return fmt.Errorf("expiry sweeper already started")
+	}
+	s := &expirySweeper{stop: make(chan struct{}), done: make(chan struct{})}
+	i.sweeper = s
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+			deleted, err := i.SweepExpired(time.Now(), batchSize)
+			if err != nil {
+				slog.Error("Expiry sweep failed", "deleted", deleted, "error", err)
+			} else if deleted > 0 {
+				slog.Info("Expiry sweep deleted expired documents", "deleted", deleted)
+			}
+		}
+	}()
+	return nil
+}
+
+// shutdown stops the sweeper, waiting for a sweep in progress.
+func (s *expirySweeper) shutdown() {
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
@@ -0,0 +1,88 @@
+package indexer
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndexer_SweepExpired(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	docs := map[string]interface{}{
+		"live":      map[string]interface{}{"title": "live", ExpiresAtField: now.Add(time.Hour).Format(time.RFC3339)},
+		"permanent": map[string]interface{}{"title": "permanent"},
+	}
+	for n := 0; n < 5; n++ {
+		docs[fmt.Sprintf("expired%d", n)] = map[string]interface{}{"title": "expired", ExpiresAtField: now.Add(-time.Duration(n) * time.Hour).Format(time.RFC3339)}
+	}
+	if _, err := idx.BulkIndexDocuments(docs); err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+
+	deleted, err := idx.SweepExpired(now, 2)
+	if err != nil {
+		t.Fatalf("SweepExpired returned an error: %v", err)
+	}
+	if deleted != 5 {
+		t.Errorf("Expected 5 expired documents deleted, got %d", deleted)
+	}
+	for id := range docs {
+		_, err := idx.GetDocument(id, nil)
+		if expired := id != "live" && id != "permanent"; expired != (err != nil) {
+			t.Errorf("Unexpected lookup of %s after the sweep: %v", id, err)
+		}
+	}
+
+	if deleted, err := idx.SweepExpired(now, 2); err != nil || deleted != 0 {
+		t.Errorf("Expected nothing left to sweep, got %d (%v)", deleted, err)
+	}
+}
+
+func TestIndexer_StartExpirySweeper(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+
+	if err := idx.StartExpirySweeper(0, 0); err == nil {
+		t.Error("Expected an error for a zero interval")
+	}
+	doc := map[string]interface{}{"title": "expired", ExpiresAtField: time.Now().Add(-time.Minute).Format(time.RFC3339)}
+	if err := idx.IndexDocument("expired", doc); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if err := idx.StartExpirySweeper(10*time.Millisecond, 0); err != nil {
+		t.Fatalf("StartExpirySweeper returned an error: %v", err)
+	}
+	if err := idx.StartExpirySweeper(10*time.Millisecond, 0); err == nil {
+		t.Error("Expected an error starting a second sweeper")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := idx.GetDocument("expired", nil); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the sweeper to delete the expired document")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,298 @@
+// Package extract turns the raw content of documents, such as HTML pages and PDF files,
+// into clean text, a title and metadata before they're indexed.
+package extract
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Media types of the built-in extractors.
+const (
+	MediaTypeHTML  = "text/html"
+	MediaTypeXHTML = "application/xhtml+xml"
+	MediaTypePDF   = "application/pdf"
+)
+
+// Encodings of string contents; []byte contents are always raw.
+const (
+	EncodingText   = "text"   // The string is the content
+	EncodingBase64 = "base64" // The string is the standard base64 encoding of the content
+)
+
+const (
+	// ContentTypeField is the document field whose media type selects the extractor.
+	ContentTypeField = "content_type"
+	// maxContentSize bounds the size of the content handed to an extractor.
+	maxContentSize = 64 << 20
+)
+
+var (
+	// ErrExtraction is wrapped by the errors of documents whose content can't be extracted.
+	ErrExtraction = errors.New("content extraction failed")
+	// ErrInvalidConfig is returned for extraction configurations that can't be applied.
+	ErrInvalidConfig = errors.New("invalid extraction config")
+)
+
+// Result is the content extracted from a document.
+type Result struct {
+	Title string
+	Text  string
+	// Metadata holds other properties of the content, e.g. author, description,
+	// keywords and language, keyed by lower-case names.
+	Metadata map[string]string
+}
+
+// Extractor extracts the content of one media type.
+type Extractor interface {
+	Extract(content []byte) (*Result, error)
+}
+
+// ExtractorFunc adapts a function to an Extractor.
+type ExtractorFunc func(content []byte) (*Result, error)
+
+// Extract calls f.
+func (f ExtractorFunc) Extract(content []byte) (*Result, error) {
+	return f(content)
+}
+
+// Config controls how the documents of a media type are extracted. Zero fields take the
+// defaults of the media type.
+type Config struct {
+	Disabled bool `yaml:"disabled" json:"disabled,omitempty"` // Index the documents of the type as they are
+	// SourceField holds the raw content, which is removed from the document; default "content".
+	SourceField string `yaml:"source_field" json:"source_field,omitempty"`
+	// Encoding of string contents, "text" or "base64"; default "text", "base64" for PDF.
+	Encoding string `yaml:"encoding" json:"encoding,omitempty"`
+	// TextField receives the extracted text; default "content".
+	TextField string `yaml:"text_field" json:"text_field,omitempty"`
+	// TitleField receives the extracted title unless the document has one; default "title".
+	TitleField string `yaml:"title_field" json:"title_field,omitempty"`
+	// MetadataPrefix prefixes the fields receiving the metadata; default "meta_".
+	MetadataPrefix string `yaml:"metadata_prefix" json:"metadata_prefix,omitempty"`
+	// Metadata lists the metadata kept, e.g. [author, description]; empty keeps all.
+	Metadata []string `yaml:"metadata" json:"metadata,omitempty"`
+	// MaxTextLength truncates the text to this many bytes; 0 keeps all of it.
+	MaxTextLength int `yaml:"max_text_length" json:"max_text_length,omitempty"`
+}
+
+// withDefaults returns c with its zero fields set from defaults.
+func (c Config) withDefaults(defaults Config) Config {
+	if c.SourceField == "" {
+		c.SourceField = defaults.SourceField
+	}
+	if c.Encoding == "" {
+		c.Encoding = defaults.Encoding
+	}
+	if c.TextField == "" {
+		c.TextField = defaults.TextField
+	}
+	if c.TitleField == "" {
+		c.TitleField = defaults.TitleField
+	}
+	if c.MetadataPrefix == "" {
+		c.MetadataPrefix = defaults.MetadataPrefix
+	}
+	return c
+}
+
+// Validate checks the encoding and the text length.
+func (c Config) Validate() error {
+	if c.Encoding != "" && c.Encoding != EncodingText && c.Encoding != EncodingBase64 {
+		return fmt.Errorf("%w: unknown encoding %q", ErrInvalidConfig, c.Encoding)
+	}
+	if c.MaxTextLength < 0 {
+		return fmt.Errorf("%w: negative max_text_length", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// defaultConfig is the base configuration of every media type.
+var defaultConfig = Config{
+	SourceField:    "content",
+	Encoding:       EncodingText,
+	TextField:      "content",
+	TitleField:     "title",
+	MetadataPrefix: "meta_",
+}
+
+// extractor is a registered extractor and the configuration of its media type.
+type extractor struct {
+	extractor Extractor
+	defaults  Config // Configuration the media type was registered with
+	config    Config
+}
+
+// Pipeline extracts the content of documents by their content_type field. Documents
+// without one, or of a media type without extractor, are indexed as they are. Register
+// and Configure must not be called once the pipeline is in use.
+type Pipeline struct {
+	extractors map[string]*extractor // By media type
+}
+
+// NewPipeline creates a pipeline with the built-in HTML and PDF extractors.
+func NewPipeline() *Pipeline {
+	p := &Pipeline{extractors: make(map[string]*extractor)}
+	p.Register(MediaTypeHTML, ExtractorFunc(HTML), Config{})
+	p.Register(MediaTypeXHTML, ExtractorFunc(HTML), Config{})
+	p.Register(MediaTypePDF, ExtractorFunc(PDF), Config{Encoding: EncodingBase64})
+	return p
+}
+
+// Register sets the extractor of a media type, replacing any previous one. Zero fields
+// of cfg take the pipeline defaults.
+func (p *Pipeline) Register(mediaType string, e Extractor, cfg Config) {
+	cfg = cfg.withDefaults(defaultConfig)
+	p.extractors[strings.ToLower(mediaType)] = &extractor{extractor: e, defaults: cfg, config: cfg}
+}
+
+// Configure applies per media type configurations, whose zero fields take the defaults
+// the type was registered with.
+func (p *Pipeline) Configure(configs map[string]Config) error {
+	for mediaType, cfg := range configs {
+		e, ok := p.extractors[strings.ToLower(mediaType)]
+		if !ok {
+			return fmt.Errorf("%w: no extractor for media type %q", ErrInvalidConfig, mediaType)
+		}
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("media type %q: %w", mediaType, err)
+		}
+		e.config = cfg.withDefaults(e.defaults)
+	}
+	return nil
+}
+
+// MediaTypes returns the media types with an enabled extractor, sorted.
+func (p *Pipeline) MediaTypes() []string {
+	var types []string
+	for mediaType, e := range p.extractors {
+		if !e.config.Disabled {
+			types = append(types, mediaType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Process returns the document with its content extracted: the raw content is replaced
+// by the text, and the title and metadata are added to the fields the document doesn't
+// have yet. fields itself is left unchanged. Documents without content to extract are
+// returned as they are.
+func (p *Pipeline) Process(fields map[string]interface{}) (map[string]interface{}, error) {
+	contentType, _ := fields[ContentTypeField].(string)
+	if contentType == "" {
+		return fields, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fields, nil
+	}
+	e, ok := p.extractors[mediaType]
+	if !ok || e.config.Disabled {
+		return fields, nil
+	}
+	cfg := e.config
+	content, err := rawContent(fields[cfg.SourceField], cfg.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s field %q: %v", ErrExtraction, mediaType, cfg.SourceField, err)
+	}
+	if len(content) == 0 {
+		return fields, nil
+	}
+	result, err := safeExtract(e.extractor, content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrExtraction, mediaType, err)
+	}
+
+	out := make(map[string]interface{}, len(fields)+len(result.Metadata)+1)
+	for name, value := range fields {
+		out[name] = value
+	}
+	delete(out, cfg.SourceField)
+	out[cfg.TextField] = truncate(result.Text, cfg.MaxTextLength)
+	if _, ok := out[cfg.TitleField]; !ok && result.Title != "" {
+		out[cfg.TitleField] = result.Title
+	}
+	for name, value := range result.Metadata {
+		if !cfg.keeps(name) || value == "" {
+			continue
+		}
+		if _, ok := out[cfg.MetadataPrefix+name]; !ok {
+			out[cfg.MetadataPrefix+name] = value
+		}
+	}
+	return out, nil
+}
+
+// safeExtract runs e on content, turning a panic on malformed content into an error, so
+// a document can't take down the request extracting it.
+func safeExtract(e Extractor, content []byte) (result *Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("extractor panicked: %v", r)
+		}
+	}()
+	return e.Extract(content)
+}
+
+// keeps reports whether the metadata called name is kept.
+func (c Config) keeps(name string) bool {
+	if len(c.Metadata) == 0 {
+		return true
+	}
+	for _, kept := range c.Metadata {
+		if strings.EqualFold(kept, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// rawContent returns the content held by a field value: a string in the given encoding,
+// or bytes.
+func rawContent(value interface{}, encoding string) ([]byte, error) {
+	var content []byte
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		content = v
+	case string:
+		if encoding != EncodingBase64 {
+			content = []byte(v)
+			break
+		}
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %w", err)
+		}
+		content = decoded
+	default:
+		return nil, fmt.Errorf("expected a string, got %T", value)
+	}
+	if len(content) > maxContentSize {
+		return nil, fmt.Errorf("content of %d bytes exceeds the limit of %d", len(content), maxContentSize)
+	}
+	return content, nil
+}
+
+// truncate cuts s to at most n bytes without splitting a character; n <= 0 keeps s.
+func truncate(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// collapseSpace replaces runs of whitespace with single spaces and trims s.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,147 @@
+package extract
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	page := `<!DOCTYPE html>
+<html lang="fr"><head>
+  <title> Red &amp; Blue
+  Shoes </title>
+  <meta name="Description" content="Shoes   for every day">
+  <meta property="og:site_name" content="Shop">
+  <meta name="viewport" content="width=device-width">
+  <style>body { color: red }</style>
+  <script>var title = "<b>not text</b>";</script>
+</head>
+<body>
+  <nav><ul><li>Home</li><li>Sale</li></ul></nav>
+  <h1>Red shoes</h1><p>Now <em>on</em> sale &ndash; 50%</p>
+  <noscript>Enable JavaScript</noscript>
+  <svg><text>icon</text></svg>
+</body></html>`
+	result, err := HTML([]byte(page))
+	if err != nil {
+		t.Fatalf("HTML returned an error: %v", err)
+	}
+	if result.Title != "Red & Blue Shoes" {
+		t.Errorf("Expected the title, got %q", result.Title)
+	}
+	if want := "Home Sale Red shoes Now on sale – 50%"; result.Text != want {
+		t.Errorf("Expected text %q, got %q", want, result.Text)
+	}
+	want := map[string]string{"description": "Shoes for every day", "site_name": "Shop", "language": "fr"}
+	if !reflect.DeepEqual(result.Metadata, want) {
+		t.Errorf("Expected metadata %v, got %v", want, result.Metadata)
+	}
+}
+
+func TestPipeline_Process(t *testing.T) {
+	page := `<html><head><title>Page title</title><meta name="author" content="Ann"><meta name="keywords" content="a, b"></head><body>Some long text</body></html>`
+	tests := []struct {
+		name    string
+		configs map[string]Config
+		fields  map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:   "html",
+			fields: map[string]interface{}{"content_type": "text/html; charset=utf-8", "content": page},
+			want: map[string]interface{}{
+				"content_type": "text/html; charset=utf-8", "content": "Some long text", "title": "Page title",
+				"meta_author": "Ann", "meta_keywords": "a, b",
+			},
+		},
+		{
+			name: "configured fields",
+			configs: map[string]Config{"TEXT/HTML": {
+				SourceField: "body", TextField: "text", MetadataPrefix: "html_", Metadata: []string{"author"}, MaxTextLength: 9,
+			}},
+			fields: map[string]interface{}{"content_type": "text/html", "body": page, "title": "Own title"},
+			want: map[string]interface{}{
+				"content_type": "text/html", "text": "Some long", "title": "Own title", "html_author": "Ann",
+			},
+		},
+		{
+			name:    "disabled",
+			configs: map[string]Config{MediaTypeHTML: {Disabled: true}},
+			fields:  map[string]interface{}{"content_type": "text/html", "content": page},
+			want:    map[string]interface{}{"content_type": "text/html", "content": page},
+		},
+		{
+			name:   "no extractor",
+			fields: map[string]interface{}{"content_type": "text/plain", "content": "<b>x</b>"},
+			want:   map[string]interface{}{"content_type": "text/plain", "content": "<b>x</b>"},
+		},
+		{
+			name:   "no content type",
+			fields: map[string]interface{}{"content": "<b>x</b>"},
+			want:   map[string]interface{}{"content": "<b>x</b>"},
+		},
+		{
+			name:   "base64 pdf",
+			fields: map[string]interface{}{"content_type": "application/pdf", "content": base64.StdEncoding.EncodeToString(testPDF(t))},
+			want: map[string]interface{}{
+				"content_type": "application/pdf", "content": "Hello PDF world (again)", "title": "Quarterly report",
+				"meta_author": "Ann Smith", "meta_created": "2024-01-31T12:00:00+01:00",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline()
+			if err := p.Configure(tt.configs); err != nil {
+				t.Fatalf("Configure returned an error: %v", err)
+			}
+			got, err := p.Process(tt.fields)
+			if err != nil {
+				t.Fatalf("Process returned an error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPipeline_Errors(t *testing.T) {
+	p := NewPipeline()
+	for _, fields := range []map[string]interface{}{
+		{"content_type": "application/pdf", "content": "%PDF-1.4 not base64"},
+		{"content_type": "application/pdf", "content": []byte("not a PDF")},
+		{"content_type": "text/html", "content": 42.0},
+	} {
+		if _, err := p.Process(fields); !errors.Is(err, ErrExtraction) {
+			t.Errorf("%v: expected ErrExtraction, got %v", fields, err)
+		}
+	}
+
+	for _, configs := range []map[string]Config{
+		{"image/png": {}},
+		{MediaTypePDF: {Encoding: "hex"}},
+		{MediaTypePDF: {MaxTextLength: -1}},
+	} {
+		if err := NewPipeline().Configure(configs); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%v: expected ErrInvalidConfig, got %v", configs, err)
+		}
+	}
+
+	p.Register("text/markdown", ExtractorFunc(func(content []byte) (*Result, error) {
+		return &Result{Text: "custom"}, nil
+	}), Config{})
+	got, err := p.Process(map[string]interface{}{"content_type": "text/markdown", "content": "# x"})
+	if err != nil || got["content"] != "custom" {
+		t.Errorf("Expected the registered extractor to run, got %v, %v", got, err)
+	}
+
+	p.Register("text/x-broken", ExtractorFunc(func(content []byte) (*Result, error) {
+		panic("slice bounds out of range")
+	}), Config{})
+	if _, err := p.Process(map[string]interface{}{"content_type": "text/x-broken", "content": "x"}); !errors.Is(err, ErrExtraction) {
+		t.Errorf("Expected a panicking extractor to fail with ErrExtraction, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package extract
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlMetadata maps the names of <meta> elements to the metadata they're kept as.
+var htmlMetadata = map[string]string{
+	"description":    "description",
+	"keywords":       "keywords",
+	"author":         "author",
+	"og:title":       "og_title",
+	"og:description": "og_description",
+	"og:site_name":   "site_name",
+}
+
+// HTML extracts the title, the visible text and the metadata of an HTML page: the
+// description, keywords and author meta elements, their Open Graph equivalents and the
+// language of the page. Scripts, styles and other invisible elements are left out and
+// whitespace is collapsed.
+func HTML(content []byte) (*Result, error) {
+	z := html.NewTokenizer(bytes.NewReader(content))
+	result := &Result{Metadata: make(map[string]string)}
+	var title, text strings.Builder
+	hidden := 0 // Depth inside invisible elements
+	inTitle := false
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			result.Title = collapseSpace(title.String())
+			result.Text = collapseSpace(text.String())
+			if result.Title == "" {
+				result.Title = result.Metadata["og_title"]
+			}
+			return result, nil
+		case html.TextToken:
+			switch {
+			case inTitle:
+				title.Write(z.Text())
+			case hidden == 0:
+				text.Write(z.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			a := atom.Lookup(name)
+			switch {
+			case a == atom.Title:
+				inTitle = tt == html.StartTagToken
+			case invisible(a):
+				if tt == html.StartTagToken {
+					hidden++
+				}
+			case a == atom.Meta && hasAttr:
+				htmlMeta(z, result.Metadata)
+			case a == atom.Html && hasAttr:
+				if lang := attr(z, "lang"); lang != "" {
+					result.Metadata["language"] = lang
+				}
+			}
+			separate(&text, a)
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			switch {
+			case a == atom.Title:
+				inTitle = false
+			case invisible(a) && hidden > 0:
+				hidden--
+			}
+			separate(&text, a)
+		}
+	}
+}
+
+// invisible reports whether the content of an element isn't shown.
+func invisible(a atom.Atom) bool {
+	switch a {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg, atom.Iframe, atom.Object:
+		return true
+	}
+	return false
+}
+
+// separate ends the word before an element other than inline markup, so that
+// "<li>a</li><li>b</li>" reads "a b" but "<b>a</b>b" reads "ab".
+func separate(text *strings.Builder, a atom.Atom) {
+	switch a {
+	case atom.A, atom.Abbr, atom.B, atom.Bdi, atom.Bdo, atom.Cite, atom.Code, atom.Data, atom.Dfn, atom.Em,
+		atom.Font, atom.I, atom.Kbd, atom.Mark, atom.Q, atom.S, atom.Samp, atom.Small, atom.Span,
+		atom.Strong, atom.Sub, atom.Sup, atom.Time, atom.U, atom.Var:
+		return
+	}
+	text.WriteByte(' ')
+}
+
+// htmlMeta records the metadata of a <meta> element, named by its name or property
+// attribute.
+func htmlMeta(z *html.Tokenizer, metadata map[string]string) {
+	var name, content string
+	for {
+		key, value, more := z.TagAttr()
+		switch string(key) {
+		case "name", "property":
+			name = strings.ToLower(string(value))
+		case "content":
+			content = collapseSpace(string(value))
+		}
+		if !more {
+			break
+		}
+	}
+	if kept, ok := htmlMetadata[name]; ok && content != "" {
+		metadata[kept] = content
+	}
+}
+
+// attr returns the value of the named attribute of the current tag.
+func attr(z *html.Tokenizer, name string) string {
+	for {
+		key, value, more := z.TagAttr()
+		if string(key) == name {
+			return string(value)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
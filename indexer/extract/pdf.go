@@ -0,0 +1,389 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// pdfInfoKeys maps the entries of a PDF document information dictionary to the metadata
+// they're kept as.
+var pdfInfoKeys = map[string]string{
+	"Title":        "title",
+	"Author":       "author",
+	"Subject":      "subject",
+	"Keywords":     "keywords",
+	"Creator":      "creator",
+	"Producer":     "producer",
+	"CreationDate": "created",
+	"ModDate":      "modified",
+}
+
+var (
+	pdfStreamPattern  = regexp.MustCompile(`\bstream\r?\n`)
+	pdfInfoRefPattern = regexp.MustCompile(`/Info\s+(\d+)\s+(\d+)\s+R`)
+	pdfInfoKeyPattern = regexp.MustCompile(`/(Title|Author|Subject|Keywords|Creator|Producer|CreationDate|ModDate)\s*[(<]`)
+	// pdfNonContentPattern matches the dictionaries of streams other than page contents:
+	// images, fonts, object and cross-reference streams.
+	pdfNonContentPattern = regexp.MustCompile(`/(Type|Subtype|Length1|Length2|Length3)\b`)
+)
+
+// PDF extracts the text and the document information (title, author, subject,
+// keywords, creator, producer and dates) of a PDF file. Text is read from the page
+// content streams, uncompressed or Flate-compressed, as shown by the text operators;
+// text in fonts without a standard encoding, e.g. most CID fonts, and information
+// dictionaries held in compressed object streams aren't recovered.
+func PDF(content []byte) (*Result, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(content, " \t\r\n"), []byte("%PDF-")) {
+		return nil, errors.New("not a PDF file")
+	}
+	result := &Result{Metadata: pdfInfo(content)}
+	result.Title = result.Metadata["title"]
+	delete(result.Metadata, "title")
+
+	var text strings.Builder
+	for _, stream := range pdfContentStreams(content) {
+		pdfText(stream, &text)
+		text.WriteByte(' ')
+	}
+	result.Text = collapseSpace(text.String())
+	return result, nil
+}
+
+// pdfContentStreams returns the decoded page content streams of a PDF file, skipping
+// streams that aren't contents or use filters other than Flate.
+func pdfContentStreams(content []byte) [][]byte {
+	var streams [][]byte
+	for _, loc := range pdfStreamPattern.FindAllIndex(content, -1) {
+		dictStart := bytes.LastIndex(content[:loc[0]], []byte("obj"))
+		if dictStart < 0 {
+			continue
+		}
+		dict := content[dictStart:loc[0]]
+		end := bytes.Index(content[loc[1]:], []byte("endstream"))
+		if end < 0 || pdfNonContentPattern.Match(dict) {
+			continue
+		}
+		data := bytes.TrimRight(content[loc[1]:loc[1]+end], "\r\n")
+		switch {
+		case bytes.Contains(dict, []byte("/Filter")) && !bytes.Contains(dict, []byte("/FlateDecode")):
+			continue
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			r, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+			// Streams are often followed by padding the decompressor complains about, so
+			// what was decoded before an error is kept.
+			decoded, _ := io.ReadAll(io.LimitReader(r, maxContentSize))
+			data = decoded
+		}
+		streams = append(streams, data)
+	}
+	return streams
+}
+
+// pdfText appends the text shown by a content stream to text. Words are separated where
+// the stream moves to a new line or leaves a wide gap.
+func pdfText(stream []byte, text *strings.Builder) {
+	var operands []pdfOperand
+	for i := 0; i < len(stream); {
+		c := stream[i]
+		switch {
+		case isPDFSpace(c):
+			i++
+		case c == '%':
+			for i < len(stream) && stream[i] != '\n' && stream[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, n := pdfLiteralString(stream[i:])
+			operands = append(operands, pdfOperand{str: s, isString: true})
+			i += n
+		case c == '<' && i+1 < len(stream) && stream[i+1] == '<', c == '>' && i+1 < len(stream) && stream[i+1] == '>':
+			i += 2
+		case c == '<':
+			s, n := pdfHexString(stream[i:])
+			operands = append(operands, pdfOperand{str: s, isString: true})
+			i += n
+		case c == '[', c == ']', c == '{', c == '}':
+			i++
+		case c == '/':
+			i++
+			for i < len(stream) && isPDFRegular(stream[i]) {
+				i++
+			}
+			operands = append(operands, pdfOperand{})
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			start := i
+			for i++; i < len(stream) && isPDFRegular(stream[i]); i++ {
+			}
+			n, _ := strconv.ParseFloat(string(stream[start:i]), 64)
+			operands = append(operands, pdfOperand{num: n})
+		default:
+			start := i
+			for i < len(stream) && isPDFRegular(stream[i]) {
+				i++
+			}
+			if i == start { // A stray delimiter
+				i++
+				continue
+			}
+			op := string(stream[start:i])
+			if op == "ID" {
+				i = skipInlineImage(stream, i)
+			}
+			pdfShow(op, operands, text)
+			operands = operands[:0]
+		}
+	}
+}
+
+// pdfOperand is an operand of a content stream operator; only strings and numbers are
+// of interest.
+type pdfOperand struct {
+	str      string
+	isString bool
+	num      float64
+}
+
+// pdfShow appends the text shown by an operator to text.
+func pdfShow(op string, operands []pdfOperand, text *strings.Builder) {
+	switch op {
+	case "Tj":
+		if n := len(operands); n > 0 && operands[n-1].isString {
+			text.WriteString(operands[n-1].str)
+		}
+	case "'", "\"":
+		text.WriteByte(' ')
+		if n := len(operands); n > 0 && operands[n-1].isString {
+			text.WriteString(operands[n-1].str)
+		}
+	case "TJ":
+		for _, operand := range operands {
+			switch {
+			case operand.isString:
+				text.WriteString(operand.str)
+			case operand.num < -200: // A gap of more than a fifth of the font size
+				text.WriteByte(' ')
+			}
+		}
+	case "Td", "TD", "Tm", "T*", "BT", "ET":
+		text.WriteByte(' ')
+	}
+}
+
+// skipInlineImage returns the position after the data of an inline image starting at i,
+// which ends with the EI operator.
+func skipInlineImage(stream []byte, i int) int {
+	for j := i; j+2 < len(stream); j++ {
+		if isPDFSpace(stream[j]) && stream[j+1] == 'E' && stream[j+2] == 'I' && (j+3 == len(stream) || !isPDFRegular(stream[j+3])) {
+			return j + 3
+		}
+	}
+	return len(stream)
+}
+
+// pdfLiteralString decodes the literal string, e.g. "(a \(b\))", at the start of data,
+// returning it and the number of bytes it takes.
+func pdfLiteralString(data []byte) (string, int) {
+	var s []byte
+	depth := 0
+	i := 0
+	for ; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '(':
+			if depth > 0 {
+				s = append(s, c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return pdfDecodeText(s), i + 1
+			}
+			s = append(s, c)
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch e := data[i]; e {
+			case 'n':
+				s = append(s, '\n')
+			case 'r':
+				s = append(s, '\r')
+			case 't':
+				s = append(s, '\t')
+			case 'b':
+				s = append(s, '\b')
+			case 'f':
+				s = append(s, '\f')
+			case '\r':
+				if i+1 < len(data) && data[i+1] == '\n' {
+					i++
+				}
+			case '\n': // A line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for n := 0; n < 3 && i < len(data) && data[i] >= '0' && data[i] <= '7'; n++ {
+						v = v*8 + int(data[i]-'0')
+						i++
+					}
+					i--
+					s = append(s, byte(v))
+				} else {
+					s = append(s, e)
+				}
+			}
+		default:
+			s = append(s, c)
+		}
+	}
+	return pdfDecodeText(s), i
+}
+
+// pdfHexString decodes the hexadecimal string, e.g. "<48656C6C6F>", at the start of
+// data, returning it and the number of bytes it takes.
+func pdfHexString(data []byte) (string, int) {
+	end := bytes.IndexByte(data, '>')
+	next := end + 1
+	if end < 0 {
+		// An unterminated string runs to the end of data, which may be the "<" alone.
+		end, next = len(data), len(data)
+	}
+	var digits []byte
+	for _, c := range data[1:end] {
+		if _, err := strconv.ParseUint(string(c), 16, 8); err == nil {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make([]byte, len(digits)/2)
+	for n := range s {
+		v, _ := strconv.ParseUint(string(digits[2*n:2*n+2]), 16, 8)
+		s[n] = byte(v)
+	}
+	return pdfDecodeText(s), next
+}
+
+// pdfDecodeText decodes a PDF text string: UTF-16BE if it starts with a byte order
+// mark, else a single-byte encoding read as Latin-1. Control characters, which are
+// usually glyph IDs of fonts without a standard encoding, are dropped.
+func pdfDecodeText(s []byte) string {
+	var runes []rune
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		runes = utf16.Decode(units)
+	} else {
+		runes = make([]rune, len(s))
+		for i, b := range s {
+			runes[i] = rune(b)
+		}
+	}
+	var b strings.Builder
+	for _, r := range runes {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r >= 0x20 && !(r >= 0x7f && r < 0xa0):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// pdfInfo returns the metadata of the document information dictionary referenced by the
+// trailer of a PDF file.
+func pdfInfo(content []byte) map[string]string {
+	metadata := make(map[string]string)
+	refs := pdfInfoRefPattern.FindAllSubmatch(content, -1)
+	if len(refs) == 0 {
+		return metadata
+	}
+	ref := refs[len(refs)-1] // The trailer of the last update
+	objPattern, err := regexp.Compile(fmt.Sprintf(`(?:^|[^0-9])%s\s+%s\s+obj\b`, ref[1], ref[2]))
+	if err != nil {
+		return metadata
+	}
+	locs := objPattern.FindAllIndex(content, -1)
+	if len(locs) == 0 {
+		return metadata
+	}
+	obj := content[locs[len(locs)-1][1]:]
+	if end := bytes.Index(obj, []byte("endobj")); end >= 0 {
+		obj = obj[:end]
+	}
+	for _, loc := range pdfInfoKeyPattern.FindAllSubmatchIndex(obj, -1) {
+		key := string(obj[loc[2]:loc[3]])
+		var value string
+		if start := loc[1] - 1; obj[start] == '(' {
+			value, _ = pdfLiteralString(obj[start:])
+		} else {
+			value, _ = pdfHexString(obj[start:])
+		}
+		if key == "CreationDate" || key == "ModDate" {
+			value = pdfDate(value)
+		}
+		if value = collapseSpace(value); value != "" {
+			metadata[pdfInfoKeys[key]] = value
+		}
+	}
+	return metadata
+}
+
+// pdfDate converts a PDF date, e.g. "D:20240131120000+01'00'", to RFC 3339, returning
+// dates it can't parse as they are.
+func pdfDate(s string) string {
+	raw := strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	digits := raw
+	if i := strings.IndexAny(raw, "Z+-"); i >= 0 {
+		digits = raw[:i]
+	}
+	if len(digits) < 4 || len(digits) > 14 || len(digits)%2 != 0 {
+		return s
+	}
+	// Missing parts default to the start of the period, e.g. "2024" is January 1st.
+	full := digits + "0101000000"[len(digits)-4:]
+	t, err := time.Parse("20060102150405", full)
+	if err != nil {
+		return s
+	}
+	if zone := raw[len(digits):]; len(zone) >= 3 && zone[0] != 'Z' {
+		zone = strings.ReplaceAll(zone, "'", "")
+		hours, errH := strconv.Atoi(zone[1:3])
+		minutes := 0
+		if len(zone) >= 5 {
+			minutes, _ = strconv.Atoi(zone[3:5])
+		}
+		if errH == nil {
+			offset := hours*3600 + minutes*60
+			if zone[0] == '-' {
+				offset = -offset
+			}
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.FixedZone("", offset))
+		}
+	}
+	return t.Format(time.RFC3339)
+}
+
+// isPDFSpace reports whether c is PDF whitespace.
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+// isPDFRegular reports whether c is neither whitespace nor a delimiter.
+func isPDFRegular(c byte) bool {
+	return !isPDFSpace(c) && !strings.ContainsRune("()<>[]{}/%", rune(c))
+}
@@ -0,0 +1,98 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPDF returns a one page PDF file with a compressed content stream, an image whose
+// stream isn't text and a document information dictionary.
+func testPDF(t *testing.T) []byte {
+	t.Helper()
+	var content bytes.Buffer
+	zw := zlib.NewWriter(&content)
+	fmt.Fprint(zw, "BT /F1 12 Tf 72 712 Td (Hello) Tj [( P) -50 (DF)] TJ ( world) Tj T* (\\(again\\)) Tj ET")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	pdf.WriteString("1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	pdf.WriteString("2 0 obj\n<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n")
+	pdf.WriteString("3 0 obj\n<< /Type /Page /Parent 2 0 R /Contents 4 0 R /Resources << /XObject << /Im1 5 0 R >> >> >>\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", content.Len())
+	pdf.Write(content.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n")
+	pdf.WriteString("5 0 obj\n<< /Type /XObject /Subtype /Image /Length 20 >>\nstream\nBT (junk) Tj ET xxxx\nendstream\nendobj\n")
+	pdf.WriteString("16 0 obj\n<< /Title (Outline entry) >>\nendobj\n")
+	pdf.WriteString("6 0 obj\n<< /Title (Quarterly report) /Author <FEFF0041006E006E00200053006D006900740068>\n/CreationDate (D:20240131120000+01'00') /Producer () >>\nendobj\n")
+	pdf.WriteString("trailer\n<< /Root 1 0 R /Info 6 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestPDF(t *testing.T) {
+	result, err := PDF(testPDF(t))
+	if err != nil {
+		t.Fatalf("PDF returned an error: %v", err)
+	}
+	if result.Title != "Quarterly report" || result.Text != "Hello PDF world (again)" {
+		t.Errorf("Unexpected title %q and text %q", result.Title, result.Text)
+	}
+	want := map[string]string{"author": "Ann Smith", "created": "2024-01-31T12:00:00+01:00"}
+	if !reflect.DeepEqual(result.Metadata, want) {
+		t.Errorf("Expected metadata %v, got %v", want, result.Metadata)
+	}
+}
+
+func TestPDFText(t *testing.T) {
+	tests := []struct {
+		stream string
+		want   string
+	}{
+		{"BT <48656C6C6F> Tj ET", "Hello"},
+		{"BT [(Wide)-300(gap)] TJ ET", "Wide gap"},
+		{"BT (a\\101\\n b) Tj (line\\\ncontinued) ' ET", "aA b linecontinued"},
+		{"BT (nested (parens)) Tj % a comment (ignored) Tj\n ET", "nested (parens)"},
+		{"BI /W 2 /H 1 ID \x00(\xff) Tj\x01 EI BT (after) Tj ET", "after"},
+		// Unterminated strings at the end of the stream
+		{"BT (Hi) Tj <", "Hi"},
+		{"BT <4869", ""},
+		{"BT (open", ""},
+	}
+	for _, tt := range tests {
+		var text strings.Builder
+		pdfText([]byte(tt.stream), &text)
+		if got := collapseSpace(text.String()); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.stream, tt.want, got)
+		}
+	}
+}
+
+func TestPDFDate(t *testing.T) {
+	for raw, want := range map[string]string{
+		"D:20240131120000Z":       "2024-01-31T12:00:00Z",
+		"D:2024":                  "2024-01-01T00:00:00Z",
+		"D:199812231952-08'00'":   "1998-12-23T19:52:00-08:00",
+		"yesterday":               "yesterday",
+		"D:20241301000000+01'00'": "D:20241301000000+01'00'",
+	} {
+		if got := pdfDate(raw); got != want {
+			t.Errorf("pdfDate(%q) = %q, expected %q", raw, got, want)
+		}
+	}
+}
+
+func FuzzPDFText(f *testing.F) {
+	for _, seed := range []string{"BT <48656C6C6F> Tj ET", "BT [(a)-300(b)] TJ ET", "<", "(", "(\\", "BI ID", "<4"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		var text strings.Builder
+		pdfText(stream, &text)
+		pdfInfo(append([]byte("/Info 1 0 R 1 0 obj << /Title "), stream...))
+	})
+}
@@ -0,0 +1,28 @@
+package indexer
+
+import (
+	"fmt"
+
+	"indexer/extract"
+)
+
+// SetContentExtraction makes the indexer extract the content of documents by their
+// content_type field, e.g. the text and title of HTML pages, before indexing them. Nil
+// indexes documents as they are.
+func (i *Indexer) SetContentExtraction(p *extract.Pipeline) {
+	i.extraction = p
+}
+
+// extractContent returns the document with its content extracted. Errors wrap
+// extract.ErrExtraction.
+func (i *Indexer) extractContent(id string, data interface{}) (interface{}, error) {
+	fields, ok := data.(map[string]interface{})
+	if i.extraction == nil || !ok {
+		return data, nil
+	}
+	extracted, err := i.extraction.Process(fields)
+	if err != nil {
+		return nil, fmt.Errorf("document %s: %w", id, err)
+	}
+	return extracted, nil
+}
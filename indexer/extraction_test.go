@@ -0,0 +1,57 @@
+package indexer
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"indexer/extract"
+)
+
+func TestIndexer_ContentExtraction(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	idx.SetContentExtraction(extract.NewPipeline())
+
+	page := map[string]interface{}{
+		"content_type": "text/html; charset=utf-8",
+		"content":      `<html lang="en"><head><title>Boots</title><meta name="author" content="Ann"></head><body><p>Waterproof <b>leather</b> boots</p></body></html>`,
+	}
+	if err := idx.IndexDocument("page", page); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	doc, err := idx.GetDocument("page", nil)
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	if doc.Fields["title"] != "Boots" || doc.Fields["content"] != "Waterproof leather boots" || doc.Fields["meta_author"] != "Ann" {
+		t.Errorf("Expected the extracted content, got %v", doc.Fields)
+	}
+
+	broken := map[string]interface{}{"content_type": "application/pdf", "content": "not base64!"}
+	if err := idx.IndexDocument("broken", broken); !errors.Is(err, extract.ErrExtraction) {
+		t.Errorf("Expected ErrExtraction, got %v", err)
+	}
+	// Bulk indexing skips the documents that can't be extracted.
+	_, err = idx.BulkIndexDocuments(map[string]interface{}{
+		"broken": broken,
+		"plain":  map[string]interface{}{"title": "Plain"},
+	})
+	if err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+	if _, err := idx.GetDocument("plain", nil); err != nil {
+		t.Errorf("Expected the plain document to be indexed: %v", err)
+	}
+	if _, err := idx.GetDocument("broken", nil); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected the broken document to be skipped, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+
+	"common/simhash"
+)
+
+// SetFingerprintFields makes the indexer store the SimHash of the text of fields, e.g.
+// title and content, in the simhash.Field of every document, so brokers can collapse
+// near-duplicate results. Nil stores no fingerprints.
+func (i *Indexer) SetFingerprintFields(fields []string) {
+	i.fingerprintFields = fields
+}
+
+// fingerprint returns the document with its fingerprint. Documents without text in the
+// fingerprint fields are returned as they are.
+func (i *Indexer) fingerprint(data interface{}) interface{} {
+	fields, ok := data.(map[string]interface{})
+	if len(i.fingerprintFields) == 0 || !ok {
+		return data
+	}
+	var text strings.Builder
+	for _, field := range i.fingerprintFields {
+		appendText(&text, fields[field])
+	}
+	if strings.TrimSpace(text.String()) == "" {
+		return data
+	}
+	fingerprinted := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		fingerprinted[k] = v
+	}
+	fingerprinted[simhash.Field] = simhash.Format(simhash.Fingerprint(text.String()))
+	return fingerprinted
+}
+
+// fingerprintBulk returns the documents with their fingerprints.
+func (i *Indexer) fingerprintBulk(docs map[string]interface{}) map[string]interface{} {
+	if len(i.fingerprintFields) == 0 {
+		return docs
+	}
+	fingerprinted := make(map[string]interface{}, len(docs))
+	for id, data := range docs {
+		fingerprinted[id] = i.fingerprint(data)
+	}
+	return fingerprinted
+}
+
+// appendText appends the text of a field value, including the strings of arrays.
+func appendText(text *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+	case string:
+		text.WriteString(v)
+		text.WriteByte(' ')
+	case []interface{}:
+		for _, e := range v {
+			appendText(text, e)
+		}
+	case []string:
+		for _, e := range v {
+			appendText(text, e)
+		}
+	default:
+		fmt.Fprint(text, v, " ")
+	}
+}
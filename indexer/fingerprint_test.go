@@ -0,0 +1,57 @@
+package indexer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"common/simhash"
+)
+
+func TestIndexer_Fingerprint(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	idx.SetFingerprintFields([]string{"title", "content"})
+
+	doc := map[string]interface{}{"title": "Leather boots", "content": "Waterproof leather boots for hiking"}
+	if err := idx.IndexDocument("a", doc); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if _, ok := doc[simhash.Field]; ok {
+		t.Error("Expected the indexed document to be left unchanged")
+	}
+	_, err = idx.BulkIndexDocuments(map[string]interface{}{
+		"b":     map[string]interface{}{"title": "Leather Boots!", "content": "Waterproof leather boots for hiking"},
+		"empty": map[string]interface{}{"price": 10},
+	})
+	if err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+
+	a, err := idx.GetDocument("a", []string{simhash.Field})
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	b, err := idx.GetDocument("b", []string{simhash.Field})
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	want := simhash.Format(simhash.Fingerprint("Leather boots Waterproof leather boots for hiking"))
+	if a.Fields[simhash.Field] != want || b.Fields[simhash.Field] != want {
+		t.Errorf("Expected both copies to get the fingerprint %s, got %v and %v", want, a.Fields, b.Fields)
+	}
+	empty, err := idx.GetDocument("empty", []string{simhash.Field})
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	if _, ok := empty.Fields[simhash.Field]; ok {
+		t.Errorf("Expected no fingerprint without text, got %v", empty.Fields)
+	}
+}
@@ -0,0 +1,566 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"common/tenant"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// DefaultGCInterval is how often the garbage collector runs when the retention policy
+// sets no interval.
+const DefaultGCInterval = time.Hour
+
+// Settings of the SearcherRegistry.
+const (
+	registryTimeout = 10 * time.Second
+	maxDeleteKeys   = 1000 // Keys S3 deletes per request
+)
+
+var (
+	// ErrInvalidRetentionPolicy is returned for retention policies with negative settings.
+	ErrInvalidRetentionPolicy = errors.New("invalid retention policy")
+	// ErrGCUnsupported is returned for storages whose segments can't be listed and deleted.
+	ErrGCUnsupported = errors.New("storage does not support segment garbage collection")
+	// ErrGCRunning is returned when a garbage collection is requested while one runs.
+	ErrGCRunning = errors.New("garbage collection already running")
+)
+
+// uploadTimestampLayout is the suffix of the names of the uploads of S3Storage, e.g.
+// index_20240601T120000Z.
+const uploadTimestampLayout = "20060102T150405Z"
+
+// RetentionPolicy sets which uploaded segments the garbage collector keeps. Every upload
+// of an index is a version of it; versions are kept if they're among the KeepLast newest
+// of their index or younger than KeepFor, and the newest version of every index, such as
+// the read-only indexes of a rollover, is always kept. Segments used by searchers or
+// holding files of kept segments are kept as well. The zero policy keeps every segment.
+type RetentionPolicy struct {
+	KeepLast int           `yaml:"keep_last" env:"RETENTION_KEEP_LAST" flag:"retention-keep-last" usage:"Uploads of every index kept however old; 0 disables unless keep_for is set"`
+	KeepFor  time.Duration `yaml:"keep_for" env:"RETENTION_KEEP_FOR" flag:"retention-keep-for" usage:"Uploads younger than this are kept, e.g. 168h; 0 disables unless keep_last is set"`
+	Interval time.Duration `yaml:"interval" env:"RETENTION_INTERVAL" flag:"retention-interval" usage:"How often old uploads are garbage collected"`
+}
+
+// retentionPolicyJSON is the JSON encoding of RetentionPolicy, with readable durations.
+type retentionPolicyJSON struct {
+	KeepLast int    `json:"keep_last"`
+	KeepFor  string `json:"keep_for"`
+	Interval string `json:"interval"`
+}
+
+// MarshalJSON encodes the durations as strings such as "168h0m0s".
+func (p RetentionPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(retentionPolicyJSON{KeepLast: p.KeepLast, KeepFor: p.KeepFor.String(), Interval: p.Interval.String()})
+}
+
+// Validate checks the settings of the policy.
+func (p RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 || p.KeepFor < 0 || p.Interval < 0 {
+		return fmt.Errorf("%w: settings must not be negative", ErrInvalidRetentionPolicy)
+	}
+	return nil
+}
+
+// enabled reports whether the policy lets any segment be deleted.
+func (p RetentionPolicy) enabled() bool {
+	return p.KeepLast > 0 || p.KeepFor > 0
+}
+
+// SegmentCollector is implemented by IndexSegmentStorage backends whose uploaded
+// segments can be garbage collected.
+type SegmentCollector interface {
+	// ListSegments returns the names of the complete segments starting with prefix, sorted.
+	ListSegments(prefix string) ([]string, error)
+	// ReadManifest returns the manifest of a segment.
+	ReadManifest(segment string) (*SegmentManifest, error)
+	// DeleteSegment deletes a segment, its manifest first so it stops being listed.
+	DeleteSegment(segment string) error
+}
+
+// SegmentRegistry reports the uploaded segments readers use, which the garbage collector
+// keeps whatever the retention policy.
+type SegmentRegistry interface {
+	ReferencedSegments(ctx context.Context) (map[string]bool, error)
+}
+
+// SearcherRegistry is a SegmentRegistry asking searchers for the segments they list
+// through their segment admin API, be they in their local cache or cold.
+type SearcherRegistry struct {
+	urls       []string
+	tenant     string
+	collection string
+	client     *http.Client
+}
+
+// NewSearcherRegistry returns a registry of the searchers at urls, base URLs such as
+// "http://searcher:8081", serving the collection of tenantID. transport may be nil for the
+// default transport.
+func NewSearcherRegistry(urls []string, tenantID, collection string, transport http.RoundTripper) (*SearcherRegistry, error) {
+	r := &SearcherRegistry{tenant: tenantID, collection: collection, client: &http.Client{Transport: transport, Timeout: registryTimeout}}
+	for _, u := range urls {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("invalid searcher URL %q, expected http(s)://host[:port]", u)
+		}
+		r.urls = append(r.urls, strings.TrimSuffix(u, "/"))
+	}
+	return r, nil
+}
+
+// ReferencedSegments returns the segments any searcher lists: those it holds locally and
+// the cold ones it fetches when a request needs them. Searchers without tiering don't read
+// uploaded segments, and those serving another tenant or collection don't read these;
+// both reference none. It fails if a searcher can't be asked, as its segments are then
+// unknown.
+func (r *SearcherRegistry) ReferencedSegments(ctx context.Context) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	for _, base := range r.urls {
+		if err := r.collect(ctx, base, referenced); err != nil {
+			return nil, err
+		}
+	}
+	return referenced, nil
+}
+
+// collect adds the segments referenced by the searcher at base to referenced.
+func (r *SearcherRegistry) collect(ctx context.Context, base string, referenced map[string]bool) error {
+	target := base + "/segments"
+	if r.collection != "" {
+		target += "?collection=" + url.QueryEscape(r.collection)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	if r.tenant != tenant.Default {
+		req.Header.Set(tenant.Header, r.tenant)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to list the segments of searcher %s: %w", base, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotImplemented:
+		return nil // No tiering
+	case http.StatusNotFound:
+		return nil // Not a reader of this tenant or collection
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to list the segments of searcher %s: status %d", base, resp.StatusCode)
+	}
+	var body struct {
+		Segments []struct {
+			Name string `json:"name"`
+		} `json:"segments"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("failed to decode the segments of searcher %s: %w", base, err)
+	}
+	for _, seg := range body.Segments {
+		referenced[seg.Name] = true
+	}
+	return nil
+}
+
+// GCResult describes a garbage collection of the uploaded segments.
+type GCResult struct {
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	DryRun    bool      `json:"dry_run,omitempty"` // Deleted lists the segments that would be deleted
+	Segments  int       `json:"segments"`          // Segments in storage before the collection
+	Deleted   []string  `json:"deleted"`
+	// Referenced lists the segments the policy would delete that searchers use.
+	Referenced []string `json:"referenced,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// GCStatus reports the retention policy and the garbage collections of the segments.
+type GCStatus struct {
+	Policy      RetentionPolicy `json:"policy"`
+	Running     bool            `json:"running"`
+	Collections int64           `json:"collections"` // Collections finished since the indexer started
+	Last        *GCResult       `json:"last,omitempty"`
+}
+
+// segmentGC runs the garbage collections of the uploaded segments, one at a time, and
+// the scheduler starting them, on its goroutine started by the first enabling policy.
+type segmentGC struct {
+	mu          sync.Mutex
+	policy      RetentionPolicy
+	registry    SegmentRegistry
+	running     bool
+	collections int64
+	last        *GCResult
+
+	scheduled bool          // The scheduler goroutine was started
+	closed    bool          // The indexer is closing; no collection may start
+	wake      chan struct{} // Signals a policy change
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+func newSegmentGC() *segmentGC {
+	return &segmentGC{wake: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// SetSegmentRegistry sets the registry of the segments used by readers, kept by garbage
+// collections; nil keeps only the segments of the retention policy.
+func (i *Indexer) SetSegmentRegistry(registry SegmentRegistry) {
+	i.gc.mu.Lock()
+	defer i.gc.mu.Unlock()
+	i.gc.registry = registry
+}
+
+// SetRetentionPolicy replaces the retention policy. Garbage collections run every
+// Interval (DefaultGCInterval if 0) on the leader while the policy is enabled. Enabling
+// policies fail with ErrGCUnsupported if the storage can't collect segments.
+func (i *Indexer) SetRetentionPolicy(policy RetentionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	if policy.enabled() && i.segmentCollector() == nil {
+		return ErrGCUnsupported
+	}
+	g := i.gc
+	g.mu.Lock()
+	g.policy = policy
+	start := policy.enabled() && !g.scheduled && !g.closed
+	if start {
+		g.scheduled = true
+	}
+	g.mu.Unlock()
+	if start {
+		go i.runGCScheduler()
+	}
+	select {
+	case g.wake <- struct{}{}:
+	default:
+	}
+	if policy.enabled() {
+		slog.Info("Retention policy set", "keep_last", policy.KeepLast, "keep_for", policy.KeepFor, "interval", policy.Interval)
+	}
+	return nil
+}
+
+// runGCScheduler collects the segments every interval until the indexer closes. With
+// leader election, only the leader collects, like it alone serves /gc.
+func (i *Indexer) runGCScheduler() {
+	g := i.gc
+	defer close(g.done)
+	for {
+		g.mu.Lock()
+		interval, enabled := g.policy.Interval, g.policy.enabled()
+		g.mu.Unlock()
+		if interval == 0 {
+			interval = DefaultGCInterval
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-g.stop:
+			timer.Stop()
+			return
+		case <-g.wake:
+			timer.Stop()
+			continue // Wait for the interval of the new policy
+		case <-timer.C:
+		}
+		if !enabled {
+			continue
+		}
+		if leading, _ := i.Leadership(); !leading {
+			slog.Debug("Skipping segment garbage collection on a follower")
+			continue
+		}
+		result, err := i.CollectSegments(context.Background(), false)
+		switch {
+		case errors.Is(err, ErrGCRunning):
+		case err != nil:
+			slog.Error("Segment garbage collection failed", "error", err)
+		case len(result.Deleted) > 0:
+			slog.Info("Segment garbage collection deleted segments", "deleted", len(result.Deleted), "segments", result.Segments)
+		}
+	}
+}
+
+// shutdown stops the scheduler, waiting for a collection in progress.
+func (g *segmentGC) shutdown() {
+	g.stopOnce.Do(func() { close(g.stop) })
+	g.mu.Lock()
+	g.closed = true
+	scheduled := g.scheduled
+	g.mu.Unlock()
+	if scheduled {
+		<-g.done
+	}
+}
+
+// GCStatus returns the retention policy and the last garbage collection.
+func (i *Indexer) GCStatus() GCStatus {
+	g := i.gc
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return GCStatus{Policy: g.policy, Running: g.running, Collections: g.collections, Last: g.last}
+}
+
+// CollectSegments deletes the uploaded segments the retention policy doesn't keep and no
+// reader of the segment registry uses; with dryRun, it only reports them. A registry that
+// can't be asked fails the collection before anything is deleted. The zero policy
+// deletes nothing.
+func (i *Indexer) CollectSegments(ctx context.Context, dryRun bool) (*GCResult, error) {
+	storage := i.segmentCollector()
+	if storage == nil {
+		return nil, ErrGCUnsupported
+	}
+	g := i.gc
+	g.mu.Lock()
+	if g.running {
+		g.mu.Unlock()
+		return nil, ErrGCRunning
+	}
+	g.running = true
+	policy, registry := g.policy, g.registry
+	g.mu.Unlock()
+
+	result := &GCResult{StartedAt: time.Now().UTC(), DryRun: dryRun, Deleted: []string{}}
+	err := collectSegments(ctx, storage, registry, policy, result)
+	result.Duration = time.Since(result.StartedAt).Round(time.Millisecond).String()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if !dryRun {
+		recordOperation("gc", err)
+	}
+
+	g.mu.Lock()
+	g.running = false
+	if !dryRun {
+		g.collections++
+		g.last = result
+	}
+	g.mu.Unlock()
+	return result, err
+}
+
+// segmentCollector returns the storage if its segments can be collected, nil otherwise.
+// An EncryptedStorage can collect the segments its wrapped storage can.
+func (i *Indexer) segmentCollector() SegmentCollector {
+	switch storage := i.storage.(type) {
+	case *EncryptedStorage:
+		if _, ok := storage.inner.(SegmentCollector); !ok {
+			return nil
+		}
+		return storage
+	case SegmentCollector:
+		return storage
+	}
+	return nil
+}
+
+// collectSegments implements CollectSegments, recording its outcome in result.
+func collectSegments(ctx context.Context, storage SegmentCollector, registry SegmentRegistry, policy RetentionPolicy, result *GCResult) error {
+	if !policy.enabled() {
+		return nil
+	}
+	names, err := storage.ListSegments("")
+	if err != nil {
+		return err
+	}
+	result.Segments = len(names)
+	manifests := make(map[string]*SegmentManifest, len(names))
+	for _, name := range names {
+		manifest, err := storage.ReadManifest(name)
+		if err != nil {
+			return err
+		}
+		manifests[name] = manifest
+	}
+	var referenced map[string]bool
+	if registry != nil {
+		if referenced, err = registry.ReferencedSegments(ctx); err != nil {
+			return fmt.Errorf("failed to find the segments used by searchers, keeping every segment: %w", err)
+		}
+	}
+
+	expired := planGC(policy, manifests, time.Now())
+	for _, name := range expired {
+		if referenced[name] {
+			result.Referenced = append(result.Referenced, name)
+			continue
+		}
+		if result.DryRun {
+			result.Deleted = append(result.Deleted, name)
+			continue
+		}
+		if err := storage.DeleteSegment(name); err != nil {
+			return err
+		}
+		slog.Info("Deleted expired segment", "segment", name)
+		result.Deleted = append(result.Deleted, name)
+	}
+	return nil
+}
+
+// planGC returns the segments of manifests the policy doesn't keep, sorted. Segments
+// holding files of kept segments, left there by incremental uploads, are kept too.
+func planGC(policy RetentionPolicy, manifests map[string]*SegmentManifest, now time.Time) []string {
+	versions := make(map[string][]string)
+	for name := range manifests {
+		index := uploadIndexName(name)
+		versions[index] = append(versions[index], name)
+	}
+	keep := make(map[string]bool)
+	for _, names := range versions {
+		// Newest first
+		sort.Slice(names, func(a, b int) bool {
+			ta, tb := manifests[names[a]].CreatedAt, manifests[names[b]].CreatedAt
+			if !ta.Equal(tb) {
+				return ta.After(tb)
+			}
+			return names[a] > names[b]
+		})
+		for n, name := range names {
+			if n < max(policy.KeepLast, 1) || (policy.KeepFor > 0 && now.Sub(manifests[name].CreatedAt) < policy.KeepFor) {
+				keep[name] = true
+			}
+		}
+	}
+	for name := range keep {
+		for _, f := range manifests[name].Files {
+			if f.Segment != "" {
+				keep[f.Segment] = true
+			}
+		}
+	}
+	var expired []string
+	for name := range manifests {
+		if !keep[name] {
+			expired = append(expired, name)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// uploadIndexName returns the name of the index a segment is an upload of: its name
+// without the timestamp of S3Storage uploads.
+func uploadIndexName(segment string) string {
+	i := strings.LastIndexByte(segment, '_')
+	if i < 0 {
+		return segment
+	}
+	if _, err := time.Parse(uploadTimestampLayout, segment[i+1:]); err != nil {
+		return segment
+	}
+	return segment[:i]
+}
+
+// checkSegmentName rejects segment names that aren't a single path element.
+func checkSegmentName(segment string) error {
+	if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, `/\`) {
+		return fmt.Errorf("invalid segment name %q", segment)
+	}
+	return nil
+}
+
+// ListSegments returns the names of the segment directories of the storage's tenant and
+// collection that start with prefix and hold a manifest, sorted.
+func (s *LocalFileStorage) ListSegments(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(s.baseDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments in %s: %w", s.baseDir(), err)
+	}
+	var segments []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) && fileExists(filepath.Join(s.baseDir(), entry.Name(), ManifestFileName)) {
+			segments = append(segments, entry.Name())
+		}
+	}
+	return segments, nil
+}
+
+// ReadManifest returns the manifest of a segment directory.
+func (s *LocalFileStorage) ReadManifest(segment string) (*SegmentManifest, error) {
+	if err := checkSegmentName(segment); err != nil {
+		return nil, err
+	}
+	return ReadSegmentManifest(filepath.Join(s.baseDir(), segment))
+}
+
+// DeleteSegment removes a segment directory, its manifest first.
+func (s *LocalFileStorage) DeleteSegment(segment string) error {
+	if err := checkSegmentName(segment); err != nil {
+		return err
+	}
+	dir := filepath.Join(s.baseDir(), segment)
+	if err := os.Remove(filepath.Join(dir, ManifestFileName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete manifest of segment %s: %w", segment, err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to delete segment %s: %w", segment, err)
+	}
+	return nil
+}
+
+// ReadManifest downloads the manifest of a segment.
+func (s *S3Storage) ReadManifest(segment string) (*SegmentManifest, error) {
+	if err := checkSegmentName(segment); err != nil {
+		return nil, err
+	}
+	return s.downloadManifest(s.keyPrefix() + segment + "/")
+}
+
+// DeleteSegment deletes the objects under the prefix of a segment, its manifest first.
+func (s *S3Storage) DeleteSegment(segment string) error {
+	if err := checkSegmentName(segment); err != nil {
+		return err
+	}
+	prefix := s.keyPrefix() + segment + "/"
+	if _, err := s.client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(prefix + ManifestFileName)}); err != nil {
+		return fmt.Errorf("failed to delete manifest of segment %s: %w", segment, err)
+	}
+	var keys []*s3.ObjectIdentifier
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, &s3.ObjectIdentifier{Key: obj.Key})
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list objects of segment %s: %w", segment, err)
+	}
+	for start := 0; start < len(keys); start += maxDeleteKeys {
+		batch := keys[start:min(start+maxDeleteKeys, len(keys))]
+		out, err := s.client.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{Objects: batch, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete objects of segment %s: %w", segment, err)
+		}
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("failed to delete %d objects of segment %s, e.g. %s: %s", len(out.Errors), segment, aws.StringValue(out.Errors[0].Key), aws.StringValue(out.Errors[0].Message))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func (f *fakeS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, aws.StringValue(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjects(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, obj := range in.Delete.Objects {
+		delete(f.objects, aws.StringValue(obj.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+// fakeRegistry references fixed segments, or fails with err.
+type fakeRegistry struct {
+	segments map[string]bool
+	err      error
+}
+
+func (r *fakeRegistry) ReferencedSegments(context.Context) (map[string]bool, error) {
+	return r.segments, r.err
+}
+
+func TestRetentionPolicy_Validate(t *testing.T) {
+	if err := (RetentionPolicy{KeepLast: 3, KeepFor: 24 * time.Hour, Interval: time.Hour}).Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, p := range []RetentionPolicy{{KeepLast: -1}, {KeepFor: -time.Hour}, {Interval: -time.Hour}} {
+		if err := p.Validate(); !errors.Is(err, ErrInvalidRetentionPolicy) {
+			t.Errorf("Expected ErrInvalidRetentionPolicy for %+v, got %v", p, err)
+		}
+	}
+}
+
+func TestPlanGC(t *testing.T) {
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	manifest := func(daysAgo int, holders ...string) *SegmentManifest {
+		m := &SegmentManifest{CreatedAt: now.AddDate(0, 0, -daysAgo)}
+		for _, h := range holders {
+			m.Files = append(m.Files, ManifestFile{Path: "store/" + h, Segment: h})
+		}
+		return m
+	}
+	manifests := map[string]*SegmentManifest{
+		"index_20240601T000000Z": manifest(9),
+		"index_20240605T000000Z": manifest(5),
+		"index_20240608T000000Z": manifest(2),
+		// The newest upload holds a file left in the oldest one.
+		"index_20240609T000000Z": manifest(1, "index_20240601T000000Z"),
+		// Rolled-over indexes keep their last upload however old.
+		"index-000001_20240301T000000Z": manifest(100),
+		"index-000001_20240302T000000Z": manifest(99),
+	}
+
+	got := planGC(RetentionPolicy{KeepLast: 2}, manifests, now)
+	if want := []string{"index_20240605T000000Z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v to expire, got %v", want, got)
+	}
+	got = planGC(RetentionPolicy{KeepFor: 72 * time.Hour}, manifests, now)
+	if want := []string{"index-000001_20240301T000000Z", "index_20240605T000000Z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v to expire, got %v", want, got)
+	}
+	got = planGC(RetentionPolicy{KeepLast: 1, KeepFor: 6 * 24 * time.Hour}, manifests, now)
+	if want := []string{"index-000001_20240301T000000Z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v to expire, got %v", want, got)
+	}
+}
+
+func TestUploadIndexName(t *testing.T) {
+	for segment, want := range map[string]string{
+		"index_20240601T120000Z":        "index",
+		"index-000002_20240601T120000Z": "index-000002",
+		"my_index":                      "my_index",
+		"index":                         "index",
+	} {
+		if got := uploadIndexName(segment); got != want {
+			t.Errorf("Expected %s to be an upload of %s, got %s", segment, want, got)
+		}
+	}
+}
+
+func TestIndexer_CollectSegments(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	names := []string{"index_20240601T000000Z", "index_20240602T000000Z", "index_20240603T000000Z", "index_20240604T000000Z"}
+	for _, name := range names {
+		dir := filepath.Join(tempDir, "uploads", name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create segment: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "data"), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write segment: %v", err)
+		}
+		if err := storage.UploadSegment(dir); err != nil {
+			t.Fatalf("UploadSegment returned an error: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond) // Distinct creation times
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+
+	// The zero policy keeps every segment.
+	if result, err := idx.CollectSegments(context.Background(), false); err != nil || len(result.Deleted) != 0 {
+		t.Fatalf("Expected nothing to be deleted without a policy, got %+v (%v)", result, err)
+	}
+	if err := idx.SetRetentionPolicy(RetentionPolicy{KeepLast: 1}); err != nil {
+		t.Fatalf("SetRetentionPolicy returned an error: %v", err)
+	}
+
+	// A registry that can't be asked keeps everything.
+	idx.SetSegmentRegistry(&fakeRegistry{err: errors.New("searcher unreachable")})
+	if _, err := idx.CollectSegments(context.Background(), false); err == nil {
+		t.Fatal("Expected an error when the registry fails")
+	}
+	if got, _ := storage.ListSegments(""); len(got) != len(names) {
+		t.Fatalf("Expected every segment to be kept, got %v", got)
+	}
+
+	idx.SetSegmentRegistry(&fakeRegistry{segments: map[string]bool{names[1]: true}})
+	result, err := idx.CollectSegments(context.Background(), true)
+	if err != nil {
+		t.Fatalf("CollectSegments returned an error: %v", err)
+	}
+	if want := []string{names[0], names[2]}; !reflect.DeepEqual(result.Deleted, want) || !reflect.DeepEqual(result.Referenced, []string{names[1]}) {
+		t.Errorf("Expected a dry run to delete %v and keep %s, got %+v", want, names[1], result)
+	}
+	if got, _ := storage.ListSegments(""); len(got) != len(names) {
+		t.Fatalf("Expected a dry run to keep every segment, got %v", got)
+	}
+
+	if _, err := idx.CollectSegments(context.Background(), false); err != nil {
+		t.Fatalf("CollectSegments returned an error: %v", err)
+	}
+	if got, _ := storage.ListSegments(""); !reflect.DeepEqual(got, []string{names[1], names[3]}) {
+		t.Errorf("Expected the referenced and newest segments to be kept, got %v", got)
+	}
+	if status := idx.GCStatus(); status.Collections != 3 || status.Last == nil || len(status.Last.Deleted) != 2 {
+		t.Errorf("Expected 3 collections besides the dry run, the last deleting 2 segments, got %+v", status)
+	}
+}
+
+func TestIndexer_SetRetentionPolicy_Unsupported(t *testing.T) {
+	idx, err := NewIndexer(filepath.Join(t.TempDir(), "index"), nil)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	if err := idx.SetRetentionPolicy(RetentionPolicy{KeepLast: 1}); !errors.Is(err, ErrGCUnsupported) {
+		t.Errorf("Expected ErrGCUnsupported without a collecting storage, got %v", err)
+	}
+	if err := idx.SetRetentionPolicy(RetentionPolicy{}); err != nil {
+		t.Errorf("Expected the zero policy to be accepted, got %v", err)
+	}
+
+	local, err := NewLocalFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	if idx.storage, err = NewEncryptedStorage(local, testEncryptionKey()); err != nil {
+		t.Fatalf("NewEncryptedStorage returned an error: %v", err)
+	}
+	if err := idx.SetRetentionPolicy(RetentionPolicy{KeepLast: 1}); err != nil {
+		t.Errorf("Expected an encrypted collecting storage to be accepted, got %v", err)
+	}
+}
+
+func TestSearcherRegistry_ReferencedSegments(t *testing.T) {
+	var tenantHeader, collection string
+	tiered := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantHeader, collection = r.Header.Get("X-Tenant-ID"), r.URL.Query().Get("collection")
+		w.Write([]byte(`{"segments": [{"name": "a", "local": true}, {"name": "b", "pinned": true}, {"name": "c"}]}`))
+	}))
+	defer tiered.Close()
+	untiered := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotImplemented)
+	}))
+	defer untiered.Close()
+	otherTenant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer otherTenant.Close()
+
+	registry, err := NewSearcherRegistry([]string{tiered.URL, untiered.URL + "/", otherTenant.URL}, "acme", "products", nil)
+	if err != nil {
+		t.Fatalf("NewSearcherRegistry returned an error: %v", err)
+	}
+	got, err := registry.ReferencedSegments(context.Background())
+	if err != nil {
+		t.Fatalf("ReferencedSegments returned an error: %v", err)
+	}
+	if want := map[string]bool{"a": true, "b": true, "c": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected every listed segment %v, got %v", want, got)
+	}
+	if tenantHeader != "acme" || collection != "products" {
+		t.Errorf("Expected the tenant and collection to be scoped, got %q and %q", tenantHeader, collection)
+	}
+
+	untiered.Close()
+	if _, err := registry.ReferencedSegments(context.Background()); err == nil {
+		t.Error("Expected an error for an unreachable searcher")
+	}
+	if _, err := NewSearcherRegistry([]string{"searcher:8081"}, "", "", nil); err == nil {
+		t.Error("Expected an error for a URL without scheme")
+	}
+}
+
+func TestS3Storage_DeleteSegment(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{
+		"products/index_1/" + ManifestFileName:  []byte("{}"),
+		"products/index_1/store/root.bolt":      []byte("x"),
+		"products/index_10/" + ManifestFileName: []byte("{}"),
+	}}
+	storage := newS3StorageWithClient("bucket", fake)
+	if err := storage.SetCollection("products"); err != nil {
+		t.Fatalf("SetCollection returned an error: %v", err)
+	}
+	if err := storage.DeleteSegment("index_1"); err != nil {
+		t.Fatalf("DeleteSegment returned an error: %v", err)
+	}
+	if want := []string{"products/index_10/" + ManifestFileName}; len(fake.objects) != 1 || fake.objects[want[0]] == nil {
+		t.Errorf("Expected only %v to be left, got %v", want, fake.objects)
+	}
+	if err := storage.DeleteSegment("../index_10"); err == nil {
+		t.Error("Expected an error for an invalid segment name")
+	}
+}
@@ -0,0 +1,99 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// uploadedFilesTotal counts the files considered by segment uploads, partitioned by
+// whether they were uploaded or skipped because they were unchanged.
+var uploadedFilesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indexer_upload_files_total",
+	Help: "Total number of segment files considered for upload, partitioned by result (uploaded, skipped).",
+}, []string{"result"})
+
+// manifestFiles indexes the files of a manifest by path. A nil manifest yields an empty map.
+func manifestFiles(m *SegmentManifest) map[string]ManifestFile {
+	files := make(map[string]ManifestFile)
+	if m == nil {
+		return files
+	}
+	for _, f := range m.Files {
+		files[f.Path] = f
+	}
+	return files
+}
+
+// unchanged reports whether f has the same size and checksum as its previous upload.
+// Files without a checksum are always considered changed.
+func unchanged(f ManifestFile, previous map[string]ManifestFile) bool {
+	prev, ok := previous[f.Path]
+	return ok && prev.Checksum != "" && prev.Checksum == f.Checksum && prev.Size == f.Size
+}
+
+// planIncrementalUpload compares the current manifest against the previous upload and
+// returns the files that must be uploaded. Unchanged files are not returned; their
+// Segment is set in current to the upload that already holds their contents.
+func planIncrementalUpload(current, previous *SegmentManifest) []ManifestFile {
+	prevFiles := manifestFiles(previous)
+	var changed []ManifestFile
+	for i, f := range current.Files {
+		if !unchanged(f, prevFiles) {
+			changed = append(changed, f)
+			continue
+		}
+		holder := prevFiles[f.Path].Segment
+		if holder == "" {
+			holder = previous.Segment
+		}
+		if holder != current.Segment {
+			current.Files[i].Segment = holder
+		}
+	}
+	skipped := len(current.Files) - len(changed)
+	uploadedFilesTotal.WithLabelValues("uploaded").Add(float64(len(changed)))
+	uploadedFilesTotal.WithLabelValues("skipped").Add(float64(skipped))
+	return changed
+}
+
+// uploadStatePath returns the file recording the manifest of the last successful upload of
+// segmentPath. It is kept next to the index directory, like the upload lock file.
+func uploadStatePath(segmentPath string) string {
+	return filepath.Join(filepath.Dir(segmentPath), "."+filepath.Base(segmentPath)+".last-upload.json")
+}
+
+// loadUploadState returns the manifest of the last successful upload of segmentPath,
+// or nil if there is none.
+func loadUploadState(segmentPath string) (*SegmentManifest, error) {
+	data, err := os.ReadFile(uploadStatePath(segmentPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload state for %s: %w", segmentPath, err)
+	}
+	var manifest SegmentManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal upload state for %s: %w", segmentPath, err)
+	}
+	return &manifest, nil
+}
+
+// saveUploadState records the manifest of a successful upload of segmentPath.
+func saveUploadState(segmentPath string, manifest *SegmentManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload state: %w", err)
+	}
+	if err := os.WriteFile(uploadStatePath(segmentPath), data, 0644); err != nil {
+		return fmt.Errorf("failed to write upload state for %s: %w", segmentPath, err)
+	}
+	return nil
+}
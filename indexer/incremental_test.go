@@ -0,0 +1,109 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanIncrementalUpload(t *testing.T) {
+	previous := &SegmentManifest{
+		Segment: "index_20240101T000000Z",
+		Files: []ManifestFile{
+			{Path: "a", Size: 1, Checksum: "aaaa"},
+			{Path: "b", Size: 1, Checksum: "bbbb", Segment: "index_20231231T000000Z"},
+			{Path: "c", Size: 1, Checksum: "cccc"},
+		},
+	}
+	current := &SegmentManifest{
+		Segment: "index_20240102T000000Z",
+		Files: []ManifestFile{
+			{Path: "a", Size: 1, Checksum: "aaaa"},
+			{Path: "b", Size: 1, Checksum: "bbbb"},
+			{Path: "c", Size: 1, Checksum: "cccd"},
+			{Path: "d", Size: 1, Checksum: "dddd"},
+		},
+	}
+
+	changed := planIncrementalUpload(current, previous)
+	if len(changed) != 2 || changed[0].Path != "c" || changed[1].Path != "d" {
+		t.Fatalf("Expected files c and d to be uploaded, got %+v", changed)
+	}
+	// Unchanged files point at the upload that actually holds their contents.
+	if current.Files[0].Segment != "index_20240101T000000Z" || current.Files[1].Segment != "index_20231231T000000Z" {
+		t.Errorf("Unexpected segments for unchanged files: %+v", current.Files)
+	}
+	if current.Files[2].Segment != "" || current.Files[3].Segment != "" {
+		t.Errorf("Expected changed files to belong to the new segment: %+v", current.Files)
+	}
+
+	if changed := planIncrementalUpload(&SegmentManifest{Files: []ManifestFile{{Path: "a", Checksum: "aaaa"}}}, nil); len(changed) != 1 {
+		t.Errorf("Expected every file to be uploaded without a previous manifest, got %+v", changed)
+	}
+}
+
+func TestLocalFileStorage_UploadSegment_Incremental(t *testing.T) {
+	segmentSourceDir, err := os.MkdirTemp("", "segment_source_incremental")
+	if err != nil {
+		t.Fatalf("Failed to create segment source temp dir: %v", err)
+	}
+	defer os.RemoveAll(segmentSourceDir)
+	storageDestDir, err := os.MkdirTemp("", "storage_dest_incremental")
+	if err != nil {
+		t.Fatalf("Failed to create storage destination temp dir: %v", err)
+	}
+	defer os.RemoveAll(storageDestDir)
+
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(segmentSourceDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	writeFile("stable.zap", "stable")
+	writeFile("changing.zap", "v1")
+	writeFile("merged.zap", "gone soon")
+
+	storage, err := NewLocalFileStorage(storageDestDir)
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	if err := storage.UploadSegment(segmentSourceDir); err != nil {
+		t.Fatalf("First UploadSegment returned an error: %v", err)
+	}
+
+	destDir := filepath.Join(storageDestDir, filepath.Base(segmentSourceDir))
+	// Mark the stored copy of the unchanged file: a second upload must not copy it again.
+	if err := os.WriteFile(filepath.Join(destDir, "stable.zap"), []byte("marker"), 0644); err != nil {
+		t.Fatalf("Failed to mark stored file: %v", err)
+	}
+	writeFile("changing.zap", "v2")
+	if err := os.Remove(filepath.Join(segmentSourceDir, "merged.zap")); err != nil {
+		t.Fatalf("Failed to remove source file: %v", err)
+	}
+
+	if err := storage.UploadSegment(segmentSourceDir); err != nil {
+		t.Fatalf("Second UploadSegment returned an error: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "stable.zap")); string(data) != "marker" {
+		t.Errorf("Expected the unchanged file to be skipped, got content %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destDir, "changing.zap")); string(data) != "v2" {
+		t.Errorf("Expected the changed file to be copied, got content %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "merged.zap")); !os.IsNotExist(err) {
+		t.Errorf("Expected the removed file to be deleted from storage, got %v", err)
+	}
+
+	manifest, err := ReadSegmentManifest(destDir)
+	if err != nil {
+		t.Fatalf("ReadSegmentManifest returned an error: %v", err)
+	}
+	if len(manifest.Files) != 2 {
+		t.Errorf("Expected 2 files in the manifest, got %+v", manifest.Files)
+	}
+	for _, f := range manifest.Files {
+		if f.Checksum == "" {
+			t.Errorf("Expected a checksum for %s", f.Path)
+		}
+	}
+}
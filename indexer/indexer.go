@@ -2,37 +2,99 @@ package indexer
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"common/suggest"
+	"common/vector"
+	"indexer/extract"
+	"indexer/ingest"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
 )
 
 // Indexer represents the Indexer service responsible for managing the search index.
 type Indexer struct {
-	indexPath string
-	index     bleve.Index
-	storage   IndexSegmentStorage // Use the interface defined elsewhere
-	mu        sync.Mutex          // Mutex to protect concurrent access to the index
+	basePath   string // Path given to NewIndexer, naming the alias of the physical indexes
+	indexPath  string // Path of the active physical index, basePath until a rollover
+	index      bleve.Index
+	storage    IndexSegmentStorage // Use the interface defined elsewhere
+	uploadLock UploadLock          // Serializes uploads with other replicas; nil uses a lock file
+	mu         sync.Mutex          // Mutex to protect concurrent access to the index
+	counters   *indexCounters      // Throughput counters reported by Stats
+	reindex    *Job                // Unfinished reindex job, whose index mirrors writes
+	jobs       map[string]*Job     // Reindex jobs by ID
+	transport  http.RoundTripper   // Base transport for HTTP document sources; nil means the default
+	wal        *writeAheadLog      // Writes since the last upload; nil when the WAL is disabled
+	journal    *writeAheadLog      // Writes staged until the shard of a resharding is loaded; nil applies them
+	autoCommit *autoCommitter      // Commit policy and the changes it is waiting on
+	writer     *writer             // Applies IndexDocument, DeleteDocument and BulkIndexDocuments in batches
+	commits    *commitPublisher    // Announces uploaded segments; nil announces nothing
+	extraction *extract.Pipeline   // Extracts the content of documents before they're indexed; nil indexes them as they are
+	pipelines  *ingest.Pipelines   // Ingest pipelines enriching documents after extraction; nil enriches none
+
+	fingerprintFields []string                // Text fields whose SimHash is stored with every document; nil stores none
+	sweeper           *expirySweeper          // Deletes expired documents; nil if not started
+	compactor         *compactor              // Merges the segments of the index by its policy
+	merging           bool                    // A compaction is merging the segments, without holding mu
+	merged            *sync.Cond              // Signaled on mu when the merge of a compaction ends
+	gc                *segmentGC              // Deletes uploaded segments by the retention policy
+	vectorFields      map[string]vector.Field // Dense vector fields checked on writes; nil checks none
+	nestedFields      []string                // Arrays of objects flattened into sub-documents; nil flattens none
+
+	popularQueries []suggest.Entry // Completions offered in addition to the stored titles
+
+	rolloverPolicy RolloverPolicy // When the active index is rolled over
+	alias          *IndexAlias    // Physical indexes of the index; nil until the first rollover policy or rollover
+	readOnly       []bleve.Index  // Read-only indexes of the alias, opened to reject writes to their documents
+
+	election   atomic.Pointer[Election] // Elects the replica accepting commits; nil always accepts them
+	syncedTerm atomic.Uint64            // Leadership term the index caught up with the latest segment for
+	catchingUp atomic.Bool              // Whether a catch-up with the latest segment is running
 }
 
 // NewIndexer creates a new Indexer instance, opening or creating the Bleve index.
 func NewIndexer(indexPath string, storage IndexSegmentStorage) (*Indexer, error) {
+	return NewIndexerWithMapping(indexPath, storage, nil)
+}
+
+// NewIndexerWithMapping is like NewIndexer but creates a missing index with indexMapping,
+// e.g. one generated by MappingFromSchema, rather than mapping.json. An existing index
+// keeps its own mapping. An index that was rolled over opens the write index of its alias.
+func NewIndexerWithMapping(indexPath string, storage IndexSegmentStorage, indexMapping mapping.IndexMapping) (*Indexer, error) {
 	// Ensure parent directory for index exists
 	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
 		return nil, fmt.Errorf("failed to create index parent directory %s: %w", filepath.Dir(indexPath), err)
 	}
+	basePath := indexPath
+	alias, err := loadAlias(basePath)
+	if err != nil {
+		return nil, err
+	}
+	if alias != nil {
+		indexPath = filepath.Join(filepath.Dir(basePath), alias.WriteIndex)
+	}
 
 	// Open or create the Bleve index
 	index, err := bleve.Open(indexPath)
-	if err == bleve.ErrorIndexPathDoesNotExist {
-		log.Printf("Creating new index at %s using mapping from mapping.json", indexPath)
+	if err == bleve.ErrorIndexPathDoesNotExist && indexMapping != nil {
+		slog.Info("Creating new index using the configured mapping", "path", indexPath)
+		index, err = bleve.New(indexPath, indexMapping)
+		if err != nil {
+			return nil, fmt.Errorf("could not create new bleve index at %s: %w", indexPath, err)
+		}
+	} else if err == bleve.ErrorIndexPathDoesNotExist {
+		slog.Info("Creating new index using mapping from mapping.json", "path", indexPath)
 		mapping, err := LoadIndexMapping("search-engine/indexer/mapping.json")
 		if err != nil {
 			// Log the failure to load the mapping and proceed with a default. This is a recoverable state.
-			log.Printf("Could not load index mapping from 'search-engine/indexer/mapping.json': %v. Falling back to default mapping.", err)
+			slog.Warn("Could not load index mapping from mapping.json, falling back to the default mapping", "error", err)
 			mapping = CreateDefaultIndexMapping()
 		}
 
@@ -44,117 +106,253 @@ func NewIndexer(indexPath string, storage IndexSegmentStorage) (*Indexer, error)
 		return nil, fmt.Errorf("could not open existing bleve index at %s: %w", indexPath, err)
 	}
 
-	log.Printf("Bleve index opened/created at %s", indexPath)
+	slog.Info("Bleve index opened", "path", indexPath)
 
-	return &Indexer{
-		indexPath: indexPath,
-		index:     index,
-		storage:   storage,
-	}, nil
+	i := &Indexer{
+		basePath:   basePath,
+		indexPath:  indexPath,
+		index:      index,
+		storage:    storage,
+		counters:   newIndexCounters(),
+		autoCommit: newAutoCommitter(),
+		writer:     newWriter(),
+		compactor:  newCompactor(),
+		gc:         newSegmentGC(),
+		alias:      alias,
+	}
+	i.merged = sync.NewCond(&i.mu)
+	i.loadJobs()
+	if i.readOnly, err = openReadOnlyIndexes(basePath, alias); err != nil {
+		index.Close()
+		return nil, err
+	}
+	if err := i.resumeStaging(); err != nil {
+		i.closeReadOnly()
+		index.Close()
+		return nil, err
+	}
+	if i.popularQueries, err = loadPopularQueries(basePath); err != nil {
+		slog.Warn("Ignoring saved popular queries", "error", err)
+	}
+	go i.runWriter()
+	return i, nil
 }
 
-// IndexDocument adds or updates a document in the index.
+// IndexDocument adds or updates a document in the index. Concurrent writes are applied
+// together in one batch; IndexDocument returns once the document is indexed. Documents
+// whose content can't be extracted are rejected with an error wrapping
+// extract.ErrExtraction.
 func (i *Indexer) IndexDocument(id string, data interface{}) error {
-	i.mu.Lock()
-	defer i.mu.Unlock()
+	_, err := i.IndexDocumentIfVersion(id, data, AnyVersion)
+	return err
+}
 
-	log.Printf("Attempting to index document with ID: %s", id)
+// indexDocument indexes a single document. Callers must hold i.mu.
+func (i *Indexer) indexDocument(id string, data interface{}) error {
+	slog.Debug("Indexing document", "id", id)
 	// Bleve automatically handles updates if the ID exists
 	if err := i.index.Index(id, data); err != nil {
-		log.Printf("ERROR: Failed to index document with ID '%s': %v", id, err)
+		recordOperation("index", err)
+		slog.Error("Failed to index document", "id", id, "error", err)
 		return fmt.Errorf("error indexing document with ID '%s': %w", id, err)
 	}
-	log.Printf("Successfully indexed document with ID: %s", id)
+	i.mirrorWrite([]string{id}, func(target bleve.Index) error { return target.Index(id, data) })
+	recordOperation("index", nil)
+	i.counters.recordIndexed(1)
+	i.recordChange(1, data)
+	slog.Debug("Indexed document", "id", id)
 	return nil
 }
 
-// DeleteDocument removes a document from the index.
+// DeleteDocument removes a document from the index, batched like IndexDocument.
 func (i *Indexer) DeleteDocument(id string) error {
-	i.mu.Lock()
-	defer i.mu.Unlock()
+	return i.submit(walRecord{Op: walOpDelete, ID: id})
+}
 
-	log.Printf("Attempting to delete document with ID: %s", id)
+// deleteDocument deletes a single document. Callers must hold i.mu.
+func (i *Indexer) deleteDocument(id string) error {
+	slog.Debug("Deleting document", "id", id)
 	if err := i.index.Delete(id); err != nil {
 		// Bleve's Delete might return an error if the document doesn't exist,
 		// or depending on configuration. Handle specific errors if necessary.
-		log.Printf("Failed to delete document %s: %v", id, err)
+		recordOperation("delete", err)
+		slog.Error("Failed to delete document", "id", id, "error", err)
 		return fmt.Errorf("failed to delete document %s: %w", id, err)
 	}
-	log.Printf("Successfully deleted document with ID: %s", id)
+	i.mirrorWrite([]string{id}, func(target bleve.Index) error { return target.Delete(id) })
+	recordOperation("delete", nil)
+	i.counters.recordDeleted(1)
+	i.recordChange(1, id)
+	slog.Debug("Deleted document", "id", id)
 	return nil
 }
 
-// BulkIndexDocuments adds or updates multiple documents in the index using a batch.
-func (i *Indexer) BulkIndexDocuments(docs map[string]interface{}) error {
-	i.mu.Lock()
-	defer i.mu.Unlock()
+// BulkIndexDocuments adds or updates multiple documents in the index using a batch, shared
+// with concurrent writes, and reports the outcome of every document. Documents that can't
+// be mapped or whose content can't be extracted fail alone; when Bleve fails the batch,
+// it is split until the documents failing it are isolated. The error is only set if the
+// write as a whole failed.
+func (i *Indexer) BulkIndexDocuments(docs map[string]interface{}) (*BulkResponse, error) {
+	return i.BulkIndexDocumentsWithPipeline(docs, "")
+}
 
-	log.Printf("Attempting to bulk index %d documents", len(docs))
+// bulkIndexDocuments indexes docs in a batch of their own. When the batch fails, it is
+// split until the documents failing it are isolated, which are logged and skipped; it
+// fails when the index does or every document does. Callers must hold i.mu.
+func (i *Indexer) bulkIndexDocuments(docs map[string]interface{}) error {
+	slog.Debug("Bulk indexing documents", "documents", len(docs))
 	batch := i.index.NewBatch()
 
 	for id, data := range docs {
-		log.Printf("Adding document %s to batch", id)
+		slog.Debug("Adding document to batch", "id", id)
 		batch.Index(id, data)
 	}
 
 	if err := i.index.Batch(batch); err != nil {
-		log.Printf("ERROR: Failed to execute batch index operation for %d documents: %v", len(docs), err)
-		return fmt.Errorf("error executing batch index operation for %d documents: %w", len(docs), err)
+		failed, err := i.indexIsolated(docs, false)
+		if err != nil {
+			recordOperation("bulk_index", err)
+			slog.Error("Failed to execute batch index operation", "documents", len(docs), "error", err)
+			return fmt.Errorf("error executing batch index operation for %d documents: %w", len(docs), err)
+		}
+		indexed := make(map[string]interface{}, len(docs)-len(failed))
+		for id, data := range docs {
+			if err, ok := failed[id]; ok {
+				slog.Warn("Skipping document of bulk write", "id", id, "error", err)
+				continue
+			}
+			indexed[id] = data
+		}
+		docs = indexed
 	}
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+	}
+	i.mirrorWrite(ids, func(target bleve.Index) error {
+		mirror := target.NewBatch()
+		for id, data := range docs {
+			if err := mirror.Index(id, data); err != nil {
+				return err
+			}
+		}
+		return target.Batch(mirror)
+	})
+	recordOperation("bulk_index", nil)
+	i.counters.recordBatch(len(docs))
+	i.counters.recordIndexed(len(docs))
+	i.recordChange(len(docs), docs)
 
-	log.Printf("Successfully processed batch for %d documents", len(docs))
+	slog.Debug("Processed batch", "documents", len(docs))
 	return nil
 }
 
-// CommitAndUpload commits index changes and uploads the segment. It uses a file-based lock
-// to prevent race conditions from multiple indexer instances. This is crucial if indexers
-// might run concurrently (e.g., in a distributed setup before a distributed lock manager is in place).
+// CommitAndUpload commits index changes and uploads the segment. It holds the upload lock
+// to prevent race conditions from multiple indexer instances: a lock file by default, or
+// the distributed lock set by SetUploadLock when replicas share the storage.
 func (i *Indexer) CommitAndUpload() error {
 	i.mu.Lock()
 	defer i.mu.Unlock()
+	return i.commitAndUpload()
+}
 
-	// Define a lock file path. Placing it alongside the index directory is a common pattern.
-	lockFilePath := filepath.Join(filepath.Dir(i.indexPath), ".indexer.lock")
-	log.Printf("Attempting to acquire lock: %s", lockFilePath)
+// commitAndUpload implements CommitAndUpload, rolling the committed index over if the
+// rollover policy calls for it. Callers must hold i.mu.
+func (i *Indexer) commitAndUpload() error {
+	if err := i.commitSegment(); err != nil {
+		return err
+	}
+	i.maybeRollover()
+	return nil
+}
 
-	// Create a lock file with O_EXCL to ensure atomic creation. If it exists, another process holds the lock.
-	lockFile, err := os.OpenFile(lockFilePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+// commitSegment uploads the active index as a segment and announces it. Callers must hold
+// i.mu.
+func (i *Indexer) commitSegment() (err error) {
+	defer func(start time.Time) { i.autoCommit.committed(start, err) }(time.Now())
+
+	if leading, leader := i.Leadership(); !leading {
+		return fmt.Errorf("%w: the leader is %q", ErrNotLeader, leader)
+	}
+	i.waitForMerge()
+	release, err := i.acquireUploadLock()
 	if err != nil {
-		if os.IsExist(err) {
-			log.Printf("Index is locked by another process. Path: %s", lockFilePath)
-			return fmt.Errorf("index is locked, another upload may be in progress")
-		}
-		return fmt.Errorf("failed to create lock file %s: %w", lockFilePath, err)
+		return err
 	}
+	defer release()
+	slog.Info("Lock acquired successfully. Proceeding with commit and upload")
 
-	// Defer the closing and removal of the lock file to ensure it's cleaned up.
-	defer func() {
-		lockFile.Close() // Close the file handle.
-		if err := os.Remove(lockFilePath); err != nil {
-			log.Printf("CRITICAL: Failed to remove lock file %s: %v. Manual intervention may be required.", lockFilePath, err)
-		} else {
-			log.Printf("Successfully released lock: %s", lockFilePath)
-		}
-	}()
-	log.Printf("Lock acquired successfully. Proceeding with commit and upload.")
-
-	log.Println("Committing index changes and preparing for upload...")
+	slog.Info("Committing index changes and preparing for upload")
+	start := time.Now()
+	// A stale completion dictionary must not hold back the index itself.
+	if n, err := i.writeSuggestions(); err != nil {
+		slog.Error("Failed to build the completion dictionary, uploading the previous one if any", "error", err)
+	} else {
+		slog.Info("Built completion dictionary", "entries", n)
+	}
 	// The core logic of uploading the segment.
-	log.Printf("Triggering upload of index data from %s", i.indexPath)
+	slog.Info("Triggering upload of index data", "path", i.indexPath)
 	if err := i.storage.UploadSegment(i.indexPath); err != nil {
-		log.Printf("ERROR: Error during segment upload from path %s: %v", i.indexPath, err)
+		recordOperation("commit", err)
+		i.counters.recordCommit(time.Since(start), false)
+		slog.Error("Failed to upload segment", "path", i.indexPath, "error", err)
 		// Return a specific error to indicate that the upload failed.
 		return fmt.Errorf("failed to upload index segment from %s: %w", i.indexPath, err)
 	}
 
-	log.Println("Index commit and upload completed successfully.")
+	recordOperation("commit", nil)
+	i.counters.recordCommit(time.Since(start), true)
+	i.recordSyncedSegment()
+	i.uploadAlias()
+	i.publishCommit()
+	// The uploaded segment holds every logged write.
+	if err := i.wal.truncate(); err != nil {
+		slog.Error("Failed to truncate the write-ahead log, its writes will be replayed", "error", err)
+	}
+	slog.Info("Index commit and upload completed successfully")
 	return nil
 }
 
-// Close closes the bleve index.
+// Close closes the bleve index, flushing it to disk and releasing its file lock. Writes,
+// commits and uploads hold the indexer's mutex, so Close waits for those in flight and
+// no upload lock file is left behind; it also waits for the merge of the compaction it
+// cancels. A running reindex job is paused for a later resume.
 func (i *Indexer) Close() error {
+	// An automatic commit, expiry sweep, compaction or batch of writes in progress needs
+	// the mutex to finish.
+	i.autoCommit.shutdown()
+	i.sweeper.shutdown()
+	i.compactor.shutdown()
+	i.gc.shutdown()
+	i.stopWriter()
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	log.Printf("Closing bleve index at %s", i.indexPath)
+	i.waitForMerge()
+	slog.Info("Closing bleve index", "path", i.indexPath)
+	if job := i.reindex; job != nil && job.target != nil {
+		// A running job stops at its next batch; its state allows resuming it after a restart.
+		job.run++
+		if err := job.target.Close(); err != nil {
+			slog.Error("Failed to close index of job", "job", job.ID, "error", err)
+		}
+		job.target = nil
+	}
+	if err := i.wal.close(); err != nil {
+		slog.Error("Failed to close write-ahead log", "error", err)
+	}
+	if err := i.journal.close(); err != nil {
+		slog.Error("Failed to close the shard journal", "error", err)
+	}
+	i.closeReadOnly()
 	return i.index.Close()
 }
+
+// closeReadOnly closes the read-only indexes of the alias.
+func (i *Indexer) closeReadOnly() {
+	for _, index := range i.readOnly {
+		if err := index.Close(); err != nil {
+			slog.Error("Failed to close read-only index", "error", err)
+		}
+	}
+	i.readOnly = nil
+}
@@ -0,0 +1,55 @@
+package indexer
+
+import (
+	"fmt"
+
+	"indexer/ingest"
+)
+
+// SetIngestPipelines sets the ingest pipelines writes can select, the default one
+// applying to writes that don't. Nil disables ingest pipelines.
+func (i *Indexer) SetIngestPipelines(p *ingest.Pipelines) {
+	i.pipelines = p
+}
+
+// IngestPipeline returns the ingest pipeline a write selecting name applies, nil for
+// none, failing with ingest.ErrUnknownPipeline for unknown names.
+func (i *Indexer) IngestPipeline(name string) (*ingest.Pipeline, error) {
+	return i.pipelines.Get(name)
+}
+
+// IndexDocumentWithPipeline indexes a document like IndexDocumentIfVersion, enriching it
+// with the named ingest pipeline after content extraction: "" selects the default
+// pipeline and ingest.None skips it. Unknown pipelines fail with ingest.ErrUnknownPipeline
+// and documents the pipeline fails on with ingest.ErrProcessing; documents with invalid
+// vectors fail with vector.ErrInvalidVector.
+func (i *Indexer) IndexDocumentWithPipeline(id string, data interface{}, version int64, pipeline string) (int64, error) {
+	p, err := i.pipelines.Get(pipeline)
+	if err != nil {
+		return 0, err
+	}
+	data, err = i.extractContent(id, data)
+	if err != nil {
+		return 0, err
+	}
+	if data, err = ingestDocument(p, id, data); err != nil {
+		return 0, err
+	}
+	if err := i.checkVectors(id, data); err != nil {
+		return 0, err
+	}
+	return i.submitIfVersion(walRecord{Op: walOpIndex, ID: id, Data: i.fingerprint(data)}, version)
+}
+
+// ingestDocument returns the document enriched by p; nil returns it as it is.
+func ingestDocument(p *ingest.Pipeline, id string, data interface{}) (interface{}, error) {
+	fields, ok := data.(map[string]interface{})
+	if p == nil || !ok {
+		return data, nil
+	}
+	ingested, err := p.Process(fields)
+	if err != nil {
+		return nil, fmt.Errorf("document %s: %w", id, err)
+	}
+	return ingested, nil
+}
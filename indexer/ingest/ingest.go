@@ -0,0 +1,142 @@
+// Package ingest enriches documents before they're indexed with pipelines of processors,
+// like the ingest nodes of Elasticsearch: fields are renamed, defaulted, extracted from
+// text with grok patterns, parsed as dates, geolocated or computed by expressions.
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// None selects no pipeline, not even the default one.
+const None = "_none"
+
+var (
+	// ErrInvalidConfig is returned for pipeline configurations that can't be applied.
+	ErrInvalidConfig = errors.New("invalid ingest pipeline config")
+	// ErrUnknownPipeline is returned when selecting a pipeline that isn't configured.
+	ErrUnknownPipeline = errors.New("unknown ingest pipeline")
+	// ErrProcessing is wrapped by the errors of documents a processor fails on.
+	ErrProcessing = errors.New("ingest processing failed")
+)
+
+// Config defines a pipeline, e.g. in YAML:
+//
+//	description: Web server logs
+//	processors:
+//	  - type: grok
+//	    field: message
+//	    pattern: "%{IP:client} %{WORD:method} %{NOTSPACE:path} %{INT:status:int}"
+//	  - type: date
+//	    field: timestamp
+//	    formats: [ISO8601, UNIX]
+type Config struct {
+	Description string            `yaml:"description" json:"description,omitempty"`
+	Processors  []ProcessorConfig `yaml:"processors" json:"processors"`
+}
+
+// Pipeline applies its processors to documents in order. It is safe for concurrent use.
+type Pipeline struct {
+	name       string
+	processors []processor
+}
+
+// NewPipeline creates a pipeline, failing with ErrInvalidConfig if a processor is invalid.
+func NewPipeline(name string, cfg Config) (*Pipeline, error) {
+	p := &Pipeline{name: name, processors: make([]processor, 0, len(cfg.Processors))}
+	for n, pc := range cfg.Processors {
+		proc, err := newProcessor(pc)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %q, processor %d (%s): %w", name, n+1, pc.Type, err)
+		}
+		p.processors = append(p.processors, proc)
+	}
+	return p, nil
+}
+
+// Name returns the name of the pipeline.
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+// Process returns the document with the processors applied; doc itself is left
+// unchanged. Errors wrap ErrProcessing.
+func (p *Pipeline) Process(doc map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(doc))
+	for name, value := range doc {
+		out[name] = value
+	}
+	for n, proc := range p.processors {
+		if err := proc.process(out); err != nil {
+			if proc.config().IgnoreFailure {
+				continue
+			}
+			return nil, fmt.Errorf("%w: pipeline %q, processor %d (%s): %v", ErrProcessing, p.name, n+1, proc.config().Type, err)
+		}
+	}
+	return out, nil
+}
+
+// Pipelines holds the configured pipelines and the default one, applied to documents
+// whose write doesn't select a pipeline.
+type Pipelines struct {
+	pipelines       map[string]*Pipeline
+	defaultPipeline string
+}
+
+// NewPipelines creates the pipelines of configs by name. defaultPipeline, if not empty,
+// must be one of them.
+func NewPipelines(configs map[string]Config, defaultPipeline string) (*Pipelines, error) {
+	ps := &Pipelines{pipelines: make(map[string]*Pipeline, len(configs)), defaultPipeline: defaultPipeline}
+	for name, cfg := range configs {
+		if name == "" || name == None {
+			return nil, fmt.Errorf("%w: invalid pipeline name %q", ErrInvalidConfig, name)
+		}
+		p, err := NewPipeline(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		ps.pipelines[name] = p
+	}
+	if _, ok := ps.pipelines[defaultPipeline]; defaultPipeline != "" && !ok {
+		return nil, fmt.Errorf("%w: default pipeline %q is not defined", ErrInvalidConfig, defaultPipeline)
+	}
+	return ps, nil
+}
+
+// Get returns the pipeline called name: the default pipeline for "" and nil, meaning no
+// processing, for None or if there is no default. Unknown names fail with
+// ErrUnknownPipeline.
+func (ps *Pipelines) Get(name string) (*Pipeline, error) {
+	if ps == nil {
+		if name == "" || name == None {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPipeline, name)
+	}
+	switch name {
+	case "":
+		return ps.pipelines[ps.defaultPipeline], nil
+	case None:
+		return nil, nil
+	}
+	p, ok := ps.pipelines[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPipeline, name)
+	}
+	return p, nil
+}
+
+// Names returns the names of the pipelines, sorted.
+func (ps *Pipelines) Names() []string {
+	if ps == nil {
+		return nil
+	}
+	names := make([]string, 0, len(ps.pipelines))
+	for name := range ps.pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
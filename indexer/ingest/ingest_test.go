@@ -0,0 +1,149 @@
+package ingest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestPipeline_Process(t *testing.T) {
+	var cfg Config
+	err := yaml.Unmarshal([]byte(`
+processors:
+  - type: grok
+    field: message
+    pattern: "%{IP:client} %{WORD:method} %{NOTSPACE:path} %{INT:status:int} (?P<took>\\d+)ms"
+  - type: rename
+    field: msg_time
+    target_field: timestamp
+  - type: date
+    field: timestamp
+    formats: ["02/Jan/2006:15:04:05", ISO8601]
+    timezone: Europe/Paris
+  - type: set
+    field: source
+    value: {name: web, tier: 1}
+  - type: geoip
+    field: client
+    networks:
+      10.0.0.0/8: {country_code: FR, lat: 48.85, lon: 2.35}
+      10.1.0.0/16: {country_code: FR, city: Lyon, lat: 45.76, lon: 4.83}
+  - type: script
+    field: slow
+    expression: "status >= 500 || int(took) > 1000"
+`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPipeline("logs", cfg)
+	if err != nil {
+		t.Fatalf("NewPipeline returned an error: %v", err)
+	}
+
+	doc := map[string]interface{}{"message": "10.1.2.3 GET /cart 503 12ms", "msg_time": "01/Jun/2024:14:00:00"}
+	got, err := p.Process(doc)
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+	want := map[string]interface{}{
+		"message":   "10.1.2.3 GET /cart 503 12ms",
+		"client":    "10.1.2.3",
+		"method":    "GET",
+		"path":      "/cart",
+		"status":    int64(503),
+		"took":      "12",
+		"timestamp": "2024-06-01T12:00:00Z",
+		"source":    map[string]interface{}{"name": "web", "tier": 1},
+		"geoip": map[string]interface{}{
+			"country_code": "FR",
+			"city":         "Lyon",
+			"location":     map[string]interface{}{"lat": 45.76, "lon": 4.83},
+		},
+		"slow": true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if _, ok := doc["client"]; ok {
+		t.Error("Expected the processed document to be left unchanged")
+	}
+
+	if _, err := p.Process(map[string]interface{}{"message": "not a log line"}); !errors.Is(err, ErrProcessing) {
+		t.Errorf("Expected ErrProcessing for a line not matching the pattern, got %v", err)
+	}
+}
+
+func TestPipeline_IgnoreMissingAndFailure(t *testing.T) {
+	p, err := NewPipeline("lenient", Config{Processors: []ProcessorConfig{
+		{Type: TypeRename, Field: "name", TargetField: "title", IgnoreMissing: true},
+		{Type: TypeDate, Field: "published", Formats: []string{FormatUnix}, IgnoreFailure: true},
+		{Type: TypeSet, Field: "lang", Value: "en"},
+		{Type: TypeDate, Field: "updated", Formats: []string{FormatUnixMs}},
+	}})
+	if err != nil {
+		t.Fatalf("NewPipeline returned an error: %v", err)
+	}
+	got, err := p.Process(map[string]interface{}{"published": "yesterday", "lang": "fr", "updated": 1717243200000.0})
+	if err != nil {
+		t.Fatalf("Process returned an error: %v", err)
+	}
+	want := map[string]interface{}{"published": "yesterday", "lang": "fr", "updated": "2024-06-01T12:00:00Z"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if _, err := p.Process(map[string]interface{}{}); !errors.Is(err, ErrProcessing) {
+		t.Errorf("Expected ErrProcessing for a missing field, got %v", err)
+	}
+}
+
+func TestNewPipeline_Invalid(t *testing.T) {
+	tests := []ProcessorConfig{
+		{Type: "lowercase", Field: "title"},
+		{Type: TypeRename, Field: "title"},
+		{Type: TypeSet, Field: "title"},
+		{Type: TypeGrok, Field: "message", Pattern: "%{HOSTNAME:host}"},
+		{Type: TypeGrok, Field: "message", Pattern: "(unclosed"},
+		{Type: TypeDate, Field: "date"},
+		{Type: TypeDate, Field: "date", Formats: []string{FormatISO8601}, Timezone: "Nowhere/City"},
+		{Type: TypeGeoIP, Field: "ip", Networks: map[string]GeoLocation{"10.0.0.0": {}}},
+		{Type: TypeScript, Field: "total", Expression: "price *"},
+		{Type: TypeSet, Value: "x"},
+	}
+	for _, pc := range tests {
+		if _, err := NewPipeline("invalid", Config{Processors: []ProcessorConfig{pc}}); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%+v: expected ErrInvalidConfig, got %v", pc, err)
+		}
+	}
+}
+
+func TestPipelines_Get(t *testing.T) {
+	configs := map[string]Config{
+		"defaults": {Processors: []ProcessorConfig{{Type: TypeSet, Field: "lang", Value: "en"}}},
+		"logs":     {Processors: []ProcessorConfig{{Type: TypeSet, Field: "kind", Value: "log"}}},
+	}
+	if _, err := NewPipelines(configs, "missing"); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for an undefined default, got %v", err)
+	}
+	ps, err := NewPipelines(configs, "defaults")
+	if err != nil {
+		t.Fatalf("NewPipelines returned an error: %v", err)
+	}
+	if p, err := ps.Get(""); err != nil || p.Name() != "defaults" {
+		t.Errorf("Expected the default pipeline, got %v (%v)", p, err)
+	}
+	if p, err := ps.Get("logs"); err != nil || p.Name() != "logs" {
+		t.Errorf("Expected the logs pipeline, got %v (%v)", p, err)
+	}
+	if p, err := ps.Get(None); err != nil || p != nil {
+		t.Errorf("Expected no pipeline, got %v (%v)", p, err)
+	}
+	if _, err := ps.Get("metrics"); !errors.Is(err, ErrUnknownPipeline) {
+		t.Errorf("Expected ErrUnknownPipeline, got %v", err)
+	}
+	var none *Pipelines
+	if p, err := none.Get(""); err != nil || p != nil {
+		t.Errorf("Expected no pipeline without pipelines, got %v (%v)", p, err)
+	}
+}
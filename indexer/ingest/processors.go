@@ -0,0 +1,459 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Processor types accepted in a ProcessorConfig.
+const (
+	TypeRename = "rename" // Moves Field to TargetField
+	TypeSet    = "set"    // Sets Field to Value if it's missing, or always with Override
+	TypeGrok   = "grok"   // Extracts fields from the text of Field with Pattern
+	TypeDate   = "date"   // Parses Field with Formats into an RFC 3339 date in TargetField
+	TypeGeoIP  = "geoip"  // Looks up the IP address of Field in Networks
+	TypeScript = "script" // Sets Field to the value of Expression, removing it for nil
+)
+
+// Date formats of the date processor besides Go layouts.
+const (
+	FormatISO8601 = "ISO8601" // RFC 3339, with or without fractional seconds
+	FormatUnix    = "UNIX"    // Seconds since the epoch, possibly fractional
+	FormatUnixMs  = "UNIX_MS" // Milliseconds since the epoch
+)
+
+// ProcessorConfig configures a processor; which fields apply depends on its Type.
+type ProcessorConfig struct {
+	Type string `yaml:"type" json:"type"`
+	// Field is the field the processor reads, or sets for set and script.
+	Field string `yaml:"field" json:"field"`
+	// TargetField receives the result of rename, date and geoip; date defaults to Field
+	// and geoip to "geoip".
+	TargetField string `yaml:"target_field" json:"target_field,omitempty"`
+	// Value is set by set; Override replaces existing values.
+	Value    interface{} `yaml:"value" json:"value,omitempty"`
+	Override bool        `yaml:"override" json:"override,omitempty"`
+	// Pattern of grok: a regular expression in which %{NAME:field} or %{NAME:field:type}
+	// captures a built-in pattern (e.g. WORD, INT, IP) into field, converted to an int or
+	// float type, and named groups (?P<field>...) capture into field.
+	Pattern string `yaml:"pattern" json:"pattern,omitempty"`
+	// Formats of date, tried in order: Go layouts, ISO8601, UNIX or UNIX_MS. Layouts
+	// without a zone are in Timezone, UTC by default.
+	Formats  []string `yaml:"formats" json:"formats,omitempty"`
+	Timezone string   `yaml:"timezone" json:"timezone,omitempty"`
+	// Networks of geoip map CIDR blocks to their location. It stands in for a GeoIP
+	// database; addresses outside every block are left unlocated.
+	Networks map[string]GeoLocation `yaml:"networks" json:"networks,omitempty"`
+	// Expression of script, in the expr language, whose variables are the document fields,
+	// e.g. "price * quantity".
+	Expression string `yaml:"expression" json:"expression,omitempty"`
+	// IgnoreMissing skips documents without Field instead of failing.
+	IgnoreMissing bool `yaml:"ignore_missing" json:"ignore_missing,omitempty"`
+	// IgnoreFailure continues the pipeline when the processor fails on a document.
+	IgnoreFailure bool `yaml:"ignore_failure" json:"ignore_failure,omitempty"`
+}
+
+// GeoLocation is the location of a network.
+type GeoLocation struct {
+	CountryCode string  `yaml:"country_code" json:"country_code,omitempty"`
+	City        string  `yaml:"city" json:"city,omitempty"`
+	Lat         float64 `yaml:"lat" json:"lat"`
+	Lon         float64 `yaml:"lon" json:"lon"`
+}
+
+// processor transforms a document in place.
+type processor interface {
+	process(doc map[string]interface{}) error
+	config() ProcessorConfig
+}
+
+// newProcessor validates cfg and creates its processor.
+func newProcessor(cfg ProcessorConfig) (processor, error) {
+	if cfg.Field == "" {
+		return nil, fmt.Errorf("%w: field is required", ErrInvalidConfig)
+	}
+	switch cfg.Type {
+	case TypeRename:
+		if cfg.TargetField == "" {
+			return nil, fmt.Errorf("%w: target_field is required", ErrInvalidConfig)
+		}
+		return &renameProcessor{cfg: cfg}, nil
+	case TypeSet:
+		if cfg.Value == nil {
+			return nil, fmt.Errorf("%w: value is required", ErrInvalidConfig)
+		}
+		cfg.Value = normalizeValue(cfg.Value)
+		return &setProcessor{cfg: cfg}, nil
+	case TypeGrok:
+		return newGrokProcessor(cfg)
+	case TypeDate:
+		return newDateProcessor(cfg)
+	case TypeGeoIP:
+		return newGeoIPProcessor(cfg)
+	case TypeScript:
+		return newScriptProcessor(cfg)
+	default:
+		return nil, fmt.Errorf("%w: unknown processor type %q", ErrInvalidConfig, cfg.Type)
+	}
+}
+
+// source returns the value of the field a processor reads. ok is false if the field is
+// missing and the processor ignores missing fields.
+func source(cfg ProcessorConfig, doc map[string]interface{}) (value interface{}, ok bool, err error) {
+	value, found := doc[cfg.Field]
+	if !found || value == nil {
+		if cfg.IgnoreMissing {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("field %q is missing", cfg.Field)
+	}
+	return value, true, nil
+}
+
+// renameProcessor moves a field.
+type renameProcessor struct {
+	cfg ProcessorConfig
+}
+
+func (p *renameProcessor) config() ProcessorConfig { return p.cfg }
+
+func (p *renameProcessor) process(doc map[string]interface{}) error {
+	value, ok, err := source(p.cfg, doc)
+	if !ok {
+		return err
+	}
+	delete(doc, p.cfg.Field)
+	doc[p.cfg.TargetField] = value
+	return nil
+}
+
+// setProcessor sets a field to a constant, by default only where it's missing.
+type setProcessor struct {
+	cfg ProcessorConfig
+}
+
+func (p *setProcessor) config() ProcessorConfig { return p.cfg }
+
+func (p *setProcessor) process(doc map[string]interface{}) error {
+	if value, ok := doc[p.cfg.Field]; ok && value != nil && !p.cfg.Override {
+		return nil
+	}
+	doc[p.cfg.Field] = p.cfg.Value
+	return nil
+}
+
+// normalizeValue converts the map[interface{}]interface{} values YAML decodes objects to
+// into the map[string]interface{} of JSON documents.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, e := range v {
+			m[fmt.Sprint(key)] = normalizeValue(e)
+		}
+		return m
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for n, e := range v {
+			values[n] = normalizeValue(e)
+		}
+		return values
+	}
+	return value
+}
+
+// grokPatterns are the built-in patterns of grok.
+var grokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?\d+`,
+	"NUMBER":            `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"IP":                `(?:\d{1,3}(?:\.\d{1,3}){3}|[0-9A-Fa-f:]*:[0-9A-Fa-f:.]+)`,
+	"UUID":              `[0-9A-Fa-f]{8}(?:-[0-9A-Fa-f]{4}){3}-[0-9A-Fa-f]{12}`,
+	"EMAILADDRESS":      `[\w.+-]+@[\w-]+(?:\.[\w-]+)+`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:Z|[+-]\d{2}:?\d{2})?`,
+}
+
+// grokReference matches the %{NAME}, %{NAME:field} and %{NAME:field:type} references of
+// grok patterns.
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(int|float))?\}`)
+
+// grokCapture is a field captured by a grok pattern.
+type grokCapture struct {
+	field string
+	kind  string // "int", "float" or "" for strings
+}
+
+// grokProcessor extracts fields from text with a regular expression.
+type grokProcessor struct {
+	cfg      ProcessorConfig
+	re       *regexp.Regexp
+	captures map[string]grokCapture // By regexp group name
+}
+
+func newGrokProcessor(cfg ProcessorConfig) (*grokProcessor, error) {
+	if cfg.Pattern == "" {
+		return nil, fmt.Errorf("%w: pattern is required", ErrInvalidConfig)
+	}
+	p := &grokProcessor{cfg: cfg, captures: make(map[string]grokCapture)}
+	var unknown string
+	expanded := grokReference.ReplaceAllStringFunc(cfg.Pattern, func(ref string) string {
+		m := grokReference.FindStringSubmatch(ref)
+		pattern, ok := grokPatterns[m[1]]
+		if !ok {
+			unknown = m[1]
+			return ref
+		}
+		if m[2] == "" {
+			return "(?:" + pattern + ")"
+		}
+		group := fmt.Sprintf("grok%d", len(p.captures))
+		p.captures[group] = grokCapture{field: m[2], kind: m[3]}
+		return "(?P<" + group + ">" + pattern + ")"
+	})
+	if unknown != "" {
+		return nil, fmt.Errorf("%w: unknown grok pattern %q", ErrInvalidConfig, unknown)
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid pattern: %v", ErrInvalidConfig, err)
+	}
+	for _, name := range re.SubexpNames() {
+		if _, ok := p.captures[name]; name != "" && !ok {
+			p.captures[name] = grokCapture{field: name}
+		}
+	}
+	p.re = re
+	return p, nil
+}
+
+func (p *grokProcessor) config() ProcessorConfig { return p.cfg }
+
+func (p *grokProcessor) process(doc map[string]interface{}) error {
+	value, ok, err := source(p.cfg, doc)
+	if !ok {
+		return err
+	}
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("field %q is not a string", p.cfg.Field)
+	}
+	match := p.re.FindStringSubmatch(text)
+	if match == nil {
+		return fmt.Errorf("field %q doesn't match the pattern", p.cfg.Field)
+	}
+	for n, name := range p.re.SubexpNames() {
+		capture, ok := p.captures[name]
+		if !ok || match[n] == "" {
+			continue
+		}
+		switch capture.kind {
+		case "int":
+			v, err := strconv.ParseInt(match[n], 10, 64)
+			if err != nil {
+				return fmt.Errorf("field %q: %q is not an int", capture.field, match[n])
+			}
+			doc[capture.field] = v
+		case "float":
+			v, err := strconv.ParseFloat(match[n], 64)
+			if err != nil {
+				return fmt.Errorf("field %q: %q is not a float", capture.field, match[n])
+			}
+			doc[capture.field] = v
+		default:
+			doc[capture.field] = match[n]
+		}
+	}
+	return nil
+}
+
+// dateProcessor parses dates into RFC 3339.
+type dateProcessor struct {
+	cfg      ProcessorConfig
+	location *time.Location
+}
+
+func newDateProcessor(cfg ProcessorConfig) (*dateProcessor, error) {
+	if len(cfg.Formats) == 0 {
+		return nil, fmt.Errorf("%w: formats are required", ErrInvalidConfig)
+	}
+	if cfg.TargetField == "" {
+		cfg.TargetField = cfg.Field
+	}
+	location := time.UTC
+	if cfg.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("%w: invalid timezone %q: %v", ErrInvalidConfig, cfg.Timezone, err)
+		}
+	}
+	return &dateProcessor{cfg: cfg, location: location}, nil
+}
+
+func (p *dateProcessor) config() ProcessorConfig { return p.cfg }
+
+func (p *dateProcessor) process(doc map[string]interface{}) error {
+	value, ok, err := source(p.cfg, doc)
+	if !ok {
+		return err
+	}
+	for _, format := range p.cfg.Formats {
+		if t, ok := p.parse(value, format); ok {
+			doc[p.cfg.TargetField] = t.UTC().Format(time.RFC3339Nano)
+			return nil
+		}
+	}
+	return fmt.Errorf("field %q: %v matches none of the formats %v", p.cfg.Field, value, p.cfg.Formats)
+}
+
+// parse parses a date in format.
+func (p *dateProcessor) parse(value interface{}, format string) (time.Time, bool) {
+	switch format {
+	case FormatUnix, FormatUnixMs:
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case int:
+			n = float64(v)
+		case int64:
+			n = float64(v)
+		case string:
+			var err error
+			if n, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				return time.Time{}, false
+			}
+		default:
+			return time.Time{}, false
+		}
+		if format == FormatUnixMs {
+			n /= 1000
+		}
+		sec, frac := math.Modf(n)
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	}
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	layout := format
+	if format == FormatISO8601 {
+		layout = time.RFC3339Nano
+	}
+	t, err := time.ParseInLocation(layout, strings.TrimSpace(s), p.location)
+	return t, err == nil
+}
+
+// geoIPProcessor locates IP addresses with a static table of networks.
+type geoIPProcessor struct {
+	cfg      ProcessorConfig
+	networks []geoIPNetwork
+}
+
+// geoIPNetwork is a network of the table and its location.
+type geoIPNetwork struct {
+	network  *net.IPNet
+	location GeoLocation
+}
+
+func newGeoIPProcessor(cfg ProcessorConfig) (*geoIPProcessor, error) {
+	if cfg.TargetField == "" {
+		cfg.TargetField = "geoip"
+	}
+	p := &geoIPProcessor{cfg: cfg}
+	for cidr, location := range cfg.Networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid network %q: %v", ErrInvalidConfig, cidr, err)
+		}
+		p.networks = append(p.networks, geoIPNetwork{network: network, location: location})
+	}
+	return p, nil
+}
+
+func (p *geoIPProcessor) config() ProcessorConfig { return p.cfg }
+
+func (p *geoIPProcessor) process(doc map[string]interface{}) error {
+	value, ok, err := source(p.cfg, doc)
+	if !ok {
+		return err
+	}
+	s, _ := value.(string)
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return fmt.Errorf("field %q: %v is not an IP address", p.cfg.Field, value)
+	}
+	// The most specific network wins.
+	var best *geoIPNetwork
+	for n := range p.networks {
+		network := &p.networks[n]
+		if !network.network.Contains(ip) {
+			continue
+		}
+		if best == nil || maskSize(network.network) > maskSize(best.network) {
+			best = network
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	location := map[string]interface{}{
+		"location": map[string]interface{}{"lat": best.location.Lat, "lon": best.location.Lon},
+	}
+	if best.location.CountryCode != "" {
+		location["country_code"] = best.location.CountryCode
+	}
+	if best.location.City != "" {
+		location["city"] = best.location.City
+	}
+	doc[p.cfg.TargetField] = location
+	return nil
+}
+
+// maskSize returns the prefix length of a network.
+func maskSize(network *net.IPNet) int {
+	ones, _ := network.Mask.Size()
+	return ones
+}
+
+// scriptProcessor computes a field with an expression.
+type scriptProcessor struct {
+	cfg     ProcessorConfig
+	program *vm.Program
+}
+
+func newScriptProcessor(cfg ProcessorConfig) (*scriptProcessor, error) {
+	if cfg.Expression == "" {
+		return nil, fmt.Errorf("%w: expression is required", ErrInvalidConfig)
+	}
+	program, err := expr.Compile(cfg.Expression, expr.AllowUndefinedVariables())
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid expression: %v", ErrInvalidConfig, err)
+	}
+	return &scriptProcessor{cfg: cfg, program: program}, nil
+}
+
+func (p *scriptProcessor) config() ProcessorConfig { return p.cfg }
+
+func (p *scriptProcessor) process(doc map[string]interface{}) error {
+	value, err := expr.Run(p.program, doc)
+	if err != nil {
+		return fmt.Errorf("expression failed: %v", err)
+	}
+	if value == nil {
+		delete(doc, p.cfg.Field)
+		return nil
+	}
+	doc[p.cfg.Field] = value
+	return nil
+}
@@ -0,0 +1,476 @@
+package indexer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+// reindexBatchSize is the number of documents copied into the new index per batch.
+const reindexBatchSize = 500
+
+// Job types.
+const (
+	JobTypeMappingUpdate = "mapping_update" // Reindex into a new mapping
+	JobTypeReindex       = "reindex"        // Reindex from a document source with the current mapping
+)
+
+// Job states. Failed and canceled jobs keep their partially built index and can be resumed.
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+var (
+	// ErrReindexInProgress is returned when a reindex is requested while another one is running.
+	ErrReindexInProgress = errors.New("a reindex is already in progress")
+	// ErrJobNotFound is returned when looking up an unknown job ID.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobNotResumable is returned when resuming a job that is running, completed or discarded.
+	ErrJobNotResumable = errors.New("job cannot be resumed")
+	// ErrJobNotRunning is returned when canceling a job that is not running.
+	ErrJobNotRunning = errors.New("job is not running")
+)
+
+// Job is a background reindex of documents from a DocumentSource into a new physical
+// index, which replaces the live index at the same path once all documents are copied.
+// While the job's index exists, writes to the live index are mirrored into it.
+type Job struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"`
+	Source     SourceSpec `json:"source"`
+	Total      uint64     `json:"total"`                // Documents in the source, as last reported
+	Processed  uint64     `json:"processed"`            // Documents copied so far
+	Checkpoint string     `json:"checkpoint,omitempty"` // ID of the last copied document; resuming continues after it
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	targetPath string              // Where the new physical index is built
+	target     bleve.Index         // The new physical index, nil once completed or discarded
+	source     DocumentSource      // Where documents are read from
+	written    map[string]struct{} // IDs written to the live index while the current batch was read
+	run        int                 // Incremented on each resume so a stale runner stops
+}
+
+// jobState is the on-disk representation of a job, stored next to its index so the job
+// can be resumed after a restart.
+type jobState struct {
+	Job
+	TargetPath string `json:"target_path"`
+}
+
+// Job returns the current state of the job with the given ID.
+func (i *Indexer) Job(id string) (*Job, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	job, ok := i.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return job.snapshot(), nil
+}
+
+// Jobs returns the state of all jobs, most recent first.
+func (i *Indexer) Jobs() []*Job {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	jobs := make([]*Job, 0, len(i.jobs))
+	for _, job := range i.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].StartedAt.After(jobs[b].StartedAt) })
+	return jobs
+}
+
+// CancelJob stops a running job after its current batch. The partially built index is
+// kept, and keeps receiving mirrored writes, so the job can be resumed later.
+func (i *Indexer) CancelJob(id string) (*Job, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	job, ok := i.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if job.Status != JobRunning {
+		return nil, fmt.Errorf("%w: %s is %s", ErrJobNotRunning, id, job.Status)
+	}
+	i.stopJob(job, JobCanceled, nil)
+	return job.snapshot(), nil
+}
+
+// ResumeJob restarts a failed or canceled job after the last document it copied.
+func (i *Indexer) ResumeJob(id string) (*Job, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	job, ok := i.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if i.reindex != job || job.target == nil || (job.Status != JobFailed && job.Status != JobCanceled) {
+		return nil, fmt.Errorf("%w: %s is %s", ErrJobNotResumable, id, job.Status)
+	}
+	if job.source == nil {
+		source, err := i.newDocumentSource(job.Source)
+		if err != nil {
+			return nil, err
+		}
+		job.source = source
+	}
+
+	job.Status = JobRunning
+	job.Error = ""
+	job.FinishedAt = nil
+	job.run++
+	i.saveJobState(job)
+	slog.Info("Resuming job", "type", job.Type, "job", job.ID, "after", job.Checkpoint)
+
+	go i.runJob(job, job.run)
+	return job.snapshot(), nil
+}
+
+// Reindex starts a job rebuilding the index from the documents of the given source into
+// a fresh index with the current mapping.
+func (i *Indexer) Reindex(spec SourceSpec) (*Job, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.startJob(JobTypeReindex, i.index.Mapping(), spec)
+}
+
+// startJob creates a new physical index with mapping m and starts a job copying the
+// documents of source into it. A previous failed or canceled job is discarded.
+// Callers must hold i.mu.
+func (i *Indexer) startJob(jobType string, m mapping.IndexMapping, spec SourceSpec) (*Job, error) {
+	if i.reindex != nil {
+		if i.reindex.Status == JobRunning {
+			return nil, fmt.Errorf("%w: job %s", ErrReindexInProgress, i.reindex.ID)
+		}
+		i.discardJob(i.reindex)
+	}
+	source, err := i.newDocumentSource(spec)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Type == "" {
+		spec.Type = SourceIndex
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		ID:         id,
+		Type:       jobType,
+		Status:     JobRunning,
+		Source:     spec,
+		StartedAt:  time.Now().UTC(),
+		targetPath: fmt.Sprintf("%s.reindex-%s", i.indexPath, id),
+		source:     source,
+	}
+	job.target, err = bleve.New(job.targetPath, m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index at %s: %w", job.targetPath, err)
+	}
+
+	if i.jobs == nil {
+		i.jobs = make(map[string]*Job)
+	}
+	i.jobs[id] = job
+	i.reindex = job
+	i.saveJobState(job)
+	slog.Info("Started job", "type", jobType, "job", id, "source", spec.Type, "target", job.targetPath)
+
+	go i.runJob(job, job.run)
+	return job.snapshot(), nil
+}
+
+// runJob copies batches of documents until the source is exhausted, then swaps the
+// indexes. Documents are read without holding the index mutex; a document written to the
+// live index meanwhile is not overwritten, since the mirrored write is newer than the copy.
+// The runner stops when the job is no longer running or has been resumed by another runner.
+func (i *Indexer) runJob(job *Job, run int) {
+	for {
+		i.mu.Lock()
+		if job.Status != JobRunning || job.run != run {
+			i.mu.Unlock()
+			return
+		}
+		after, source := job.Checkpoint, job.source
+		job.written = make(map[string]struct{})
+		i.mu.Unlock()
+
+		docs, total, err := source.Next(after, reindexBatchSize)
+
+		i.mu.Lock()
+		if err == nil && len(docs) == 0 {
+			i.waitForMerge() // Swapping the indexes closes the live one
+		}
+		if job.Status != JobRunning || job.run != run {
+			i.mu.Unlock()
+			return
+		}
+		switch {
+		case err != nil:
+			i.stopJob(job, JobFailed, err)
+		case len(docs) == 0:
+			i.completeJob(job)
+		default:
+			job.Total = total
+			err = i.copyBatch(job, docs)
+			if err != nil {
+				i.stopJob(job, JobFailed, err)
+			}
+		}
+		done := job.Status != JobRunning
+		i.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// copyBatch writes docs into the job's index and advances its checkpoint.
+// Callers must hold i.mu.
+func (i *Indexer) copyBatch(job *Job, docs []SourceDocument) error {
+	batch := job.target.NewBatch()
+	for _, doc := range docs {
+		if _, ok := job.written[doc.ID]; ok {
+			continue
+		}
+		if err := batch.Index(doc.ID, doc.Data); err != nil {
+			return fmt.Errorf("failed to reindex document %s: %w", doc.ID, err)
+		}
+	}
+	if err := job.target.Batch(batch); err != nil {
+		return fmt.Errorf("failed to write batch into %s: %w", job.targetPath, err)
+	}
+	job.Processed += uint64(len(docs))
+	job.Checkpoint = docs[len(docs)-1].ID
+	i.saveJobState(job)
+	return nil
+}
+
+// stopJob marks a job failed or canceled, keeping its index for a later resume.
+// Callers must hold i.mu.
+func (i *Indexer) stopJob(job *Job, status string, err error) {
+	now := time.Now().UTC()
+	job.Status = status
+	job.FinishedAt = &now
+	job.written = nil
+	if err != nil {
+		job.Error = err.Error()
+		recordOperation(job.Type, err)
+		slog.Error("Job failed", "type", job.Type, "job", job.ID, "processed", job.Processed, "error", err)
+	} else {
+		slog.Info("Job stopped", "type", job.Type, "job", job.ID, "status", status, "processed", job.Processed)
+	}
+	i.saveJobState(job)
+}
+
+// completeJob swaps the job's index in place of the live index. Callers must hold i.mu.
+func (i *Indexer) completeJob(job *Job) {
+	if err := i.swapIndex(job); err != nil {
+		i.stopJob(job, JobFailed, err)
+		i.discardJob(job)
+		return
+	}
+	now := time.Now().UTC()
+	job.Status = JobCompleted
+	job.FinishedAt = &now
+	job.written = nil
+	job.source = nil
+	os.Remove(jobStatePath(job.targetPath))
+	i.reindex = nil
+	recordOperation(job.Type, nil)
+	slog.Info("Job completed", "type", job.Type, "job", job.ID, "processed", job.Processed)
+}
+
+// discardJob deletes the index of a job that is not running, which can then no longer
+// be resumed. Callers must hold i.mu.
+func (i *Indexer) discardJob(job *Job) {
+	if job.target != nil {
+		if err := job.target.Close(); err != nil {
+			slog.Error("Failed to close index of job", "job", job.ID, "error", err)
+		}
+		job.target = nil
+	}
+	os.RemoveAll(job.targetPath)
+	os.Remove(jobStatePath(job.targetPath))
+	job.source = nil
+	if i.reindex == job {
+		i.reindex = nil
+	}
+	slog.Info("Discarded index of job", "type", job.Type, "job", job.ID)
+}
+
+// swapIndex moves the live index aside, moves the job's index to the index path and
+// opens it. Callers must hold i.mu.
+func (i *Indexer) swapIndex(job *Job) error {
+	if err := job.target.Close(); err != nil {
+		return fmt.Errorf("failed to close reindexed index: %w", err)
+	}
+	job.target = nil
+	if err := i.index.Close(); err != nil {
+		slog.Error("Failed to close previous index", "path", i.indexPath, "error", err)
+	}
+
+	previousPath := fmt.Sprintf("%s.pre-%s", i.indexPath, job.ID)
+	if err := os.Rename(i.indexPath, previousPath); err != nil {
+		return i.reopenIndex(fmt.Errorf("failed to move previous index aside: %w", err))
+	}
+	if err := os.Rename(job.targetPath, i.indexPath); err != nil {
+		if rerr := os.Rename(previousPath, i.indexPath); rerr != nil {
+			slog.Error("CRITICAL: Failed to move previous index back", "path", previousPath, "error", rerr)
+		}
+		return i.reopenIndex(fmt.Errorf("failed to move reindexed index into place: %w", err))
+	}
+	if err := i.reopenIndex(nil); err != nil {
+		return err
+	}
+	slog.Info("Index replaced by the reindexed index", "path", i.indexPath, "previous_path", previousPath)
+	return nil
+}
+
+// reopenIndex opens the index at i.indexPath and returns cause, or the open error if
+// the index cannot be opened. Callers must hold i.mu.
+func (i *Indexer) reopenIndex(cause error) error {
+	index, err := bleve.Open(i.indexPath)
+	if err != nil {
+		slog.Error("CRITICAL: Failed to reopen index", "path", i.indexPath, "error", err)
+		return fmt.Errorf("failed to reopen index at %s: %w", i.indexPath, err)
+	}
+	i.index = index
+	return cause
+}
+
+// mirrorWrite applies a write of the given document IDs to the index of the current job,
+// so the write is not lost when the indexes are swapped. If the mirrored write fails,
+// the job's index is incomplete and is discarded. Callers must hold i.mu.
+func (i *Indexer) mirrorWrite(ids []string, op func(bleve.Index) error) {
+	job := i.reindex
+	if job == nil || job.target == nil {
+		return
+	}
+	if err := op(job.target); err != nil {
+		err = fmt.Errorf("failed to mirror write into new index: %w", err)
+		if job.Status == JobRunning {
+			i.stopJob(job, JobFailed, err)
+		} else {
+			job.Error = err.Error()
+		}
+		i.discardJob(job)
+		return
+	}
+	if job.written != nil {
+		for _, id := range ids {
+			job.written[id] = struct{}{}
+		}
+	}
+}
+
+// saveJobState persists the job next to its index. Failures are logged: they only
+// prevent resuming the job after a restart. Callers must hold i.mu.
+func (i *Indexer) saveJobState(job *Job) {
+	data, err := json.Marshal(jobState{Job: *job.snapshot(), TargetPath: job.targetPath})
+	if err != nil {
+		slog.Error("Failed to encode state of job", "job", job.ID, "error", err)
+		return
+	}
+	if err := os.WriteFile(jobStatePath(job.targetPath), data, 0644); err != nil {
+		slog.Error("Failed to save state of job", "job", job.ID, "error", err)
+	}
+}
+
+// loadJobs reopens the index of the most recent unfinished job left by a previous run,
+// so it can be resumed, and discards older ones. A job that was running is marked failed.
+// Callers must hold i.mu or own the Indexer exclusively.
+func (i *Indexer) loadJobs() {
+	paths, err := filepath.Glob(jobStatePath(i.indexPath + ".reindex-*"))
+	if err != nil || len(paths) == 0 {
+		return
+	}
+	var states []jobState
+	for _, path := range paths {
+		var state jobState
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &state)
+		}
+		if err != nil {
+			slog.Warn("Ignoring unreadable job state", "path", path, "error", err)
+			continue
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(a, b int) bool { return states[a].StartedAt.After(states[b].StartedAt) })
+
+	i.jobs = make(map[string]*Job, len(states))
+	for n, state := range states {
+		job := state.Job
+		job.targetPath = state.TargetPath
+		i.jobs[job.ID] = &job
+		if n > 0 {
+			i.discardJob(&job)
+			continue
+		}
+		target, err := bleve.Open(job.targetPath)
+		if err != nil {
+			slog.Error("Failed to reopen index of job, discarding it", "job", job.ID, "error", err)
+			i.discardJob(&job)
+			continue
+		}
+		job.target = target
+		if job.Status == JobRunning {
+			now := time.Now().UTC()
+			job.Status = JobFailed
+			job.Error = "interrupted by indexer restart"
+			job.FinishedAt = &now
+		}
+		i.reindex = &job
+		slog.Info("Loaded unfinished job, it can be resumed", "type", job.Type, "job", job.ID, "processed", job.Processed)
+	}
+}
+
+// jobStatePath returns the path of the state file of the job building targetPath.
+func jobStatePath(targetPath string) string {
+	return targetPath + ".job.json"
+}
+
+// snapshot returns a copy of the job's exported state.
+func (j *Job) snapshot() *Job {
+	return &Job{
+		ID:         j.ID,
+		Type:       j.Type,
+		Status:     j.Status,
+		Source:     j.Source,
+		Total:      j.Total,
+		Processed:  j.Processed,
+		Checkpoint: j.Checkpoint,
+		Error:      j.Error,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+	}
+}
+
+// newJobID returns a random job identifier.
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
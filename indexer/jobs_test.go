@@ -0,0 +1,184 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitForJob polls the job until it is no longer running.
+func waitForJob(t *testing.T, idx *Indexer, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := idx.Job(id)
+		if err != nil {
+			t.Fatalf("Job returned an error: %v", err)
+		}
+		if job.Status != JobRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not finish in time", id)
+	return nil
+}
+
+// newJobTestIndexer creates an Indexer in a temporary directory.
+func newJobTestIndexer(t *testing.T) (*Indexer, string) {
+	t.Helper()
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	indexPath := filepath.Join(tempDir, "index")
+	idx, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	return idx, indexPath
+}
+
+// docStore serves documents following the HTTPDocumentSource protocol. If gate is set,
+// requests after the first one wait for it to be closed.
+type docStore struct {
+	docs     map[string]interface{}
+	requests chan string // Receives the "after" parameter of each request
+	gate     chan struct{}
+}
+
+func (s *docStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	after := r.URL.Query().Get("after")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if s.requests != nil {
+		s.requests <- after
+	}
+	if s.gate != nil && after != "" {
+		<-s.gate
+	}
+
+	ids := make([]string, 0, len(s.docs))
+	for id := range s.docs {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	page := struct {
+		Total     int              `json:"total"`
+		Documents []SourceDocument `json:"documents"`
+	}{Total: len(s.docs)}
+	for _, id := range ids {
+		page.Documents = append(page.Documents, SourceDocument{ID: id, Data: s.docs[id]})
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func TestIndexer_Reindex_HTTPSource(t *testing.T) {
+	idx, _ := newJobTestIndexer(t)
+	defer idx.Close()
+
+	if err := idx.IndexDocument("stale", map[string]interface{}{"title": "not in the store"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	store := &docStore{docs: map[string]interface{}{
+		"a": map[string]interface{}{"title": "alpha"},
+		"b": map[string]interface{}{"title": "beta"},
+	}}
+	server := httptest.NewServer(store)
+	defer server.Close()
+
+	job, err := idx.Reindex(SourceSpec{Type: SourceHTTP, URL: server.URL})
+	if err != nil {
+		t.Fatalf("Reindex returned an error: %v", err)
+	}
+	job = waitForJob(t, idx, job.ID)
+	if job.Status != JobCompleted || job.Processed != 2 || job.Total != 2 || job.Checkpoint != "b" {
+		t.Fatalf("Unexpected job: %+v", job)
+	}
+
+	count, err := idx.Stats()
+	if err != nil || count.DocCount != 2 {
+		t.Errorf("Expected the index to hold the 2 store documents, got %+v (%v)", count, err)
+	}
+	if jobs := idx.Jobs(); len(jobs) != 1 || jobs[0].ID != job.ID {
+		t.Errorf("Unexpected jobs: %+v", jobs)
+	}
+	if _, err := idx.Reindex(SourceSpec{Type: "ftp"}); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("Expected ErrInvalidSource, got %v", err)
+	}
+}
+
+func TestIndexer_Reindex_CancelAndResume(t *testing.T) {
+	idx, indexPath := newJobTestIndexer(t)
+
+	docs := make(map[string]interface{})
+	for n := 0; n < reindexBatchSize+10; n++ {
+		docs["doc"+strconv.Itoa(1000+n)] = map[string]interface{}{"n": n}
+	}
+	store := &docStore{docs: docs, requests: make(chan string, 10), gate: make(chan struct{})}
+	server := httptest.NewServer(store)
+	defer server.Close()
+
+	job, err := idx.Reindex(SourceSpec{Type: SourceHTTP, URL: server.URL})
+	if err != nil {
+		t.Fatalf("Reindex returned an error: %v", err)
+	}
+	<-store.requests // First batch
+	<-store.requests // Second batch, held by the gate
+	if _, err := idx.CancelJob(job.ID); err != nil {
+		t.Fatalf("CancelJob returned an error: %v", err)
+	}
+	close(store.gate)
+	job = waitForJob(t, idx, job.ID)
+	if job.Status != JobCanceled || job.Processed != reindexBatchSize {
+		t.Fatalf("Expected job canceled after one batch, got %+v", job)
+	}
+	if _, err := idx.CancelJob(job.ID); !errors.Is(err, ErrJobNotRunning) {
+		t.Errorf("Expected ErrJobNotRunning, got %v", err)
+	}
+
+	// A restart reloads the canceled job so it can still be resumed.
+	idx.Close()
+	idx, err = NewIndexer(indexPath, idx.storage)
+	if err != nil {
+		t.Fatalf("Failed to reopen indexer: %v", err)
+	}
+	defer idx.Close()
+
+	if _, err := idx.ResumeJob(job.ID); err != nil {
+		t.Fatalf("ResumeJob returned an error: %v", err)
+	}
+	if after := <-store.requests; after != job.Checkpoint {
+		t.Errorf("Expected resume after checkpoint %q, got %q", job.Checkpoint, after)
+	}
+	go func() {
+		for range store.requests {
+		}
+	}()
+	job = waitForJob(t, idx, job.ID)
+	if job.Status != JobCompleted || job.Processed != uint64(len(docs)) {
+		t.Fatalf("Expected resumed job to complete, got %+v", job)
+	}
+	if _, err := idx.ResumeJob(job.ID); !errors.Is(err, ErrJobNotResumable) {
+		t.Errorf("Expected ErrJobNotResumable, got %v", err)
+	}
+	if _, err := os.Stat(jobStatePath(indexPath + ".reindex-" + job.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected job state to be removed after completion, got %v", err)
+	}
+	stats, err := idx.Stats()
+	if err != nil || stats.DocCount != uint64(len(docs)) {
+		t.Errorf("Expected %d documents, got %+v (%v)", len(docs), stats, err)
+	}
+}
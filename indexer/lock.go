@@ -0,0 +1,377 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Upload lock implementations selected by UploadLockConfig.Type.
+const (
+	LockTypeFile     = "file"
+	LockTypeDynamoDB = "dynamodb"
+	LockTypeEtcd     = "etcd"
+)
+
+// defaultLockTTL is how long a distributed upload lock outlives an indexer that stopped
+// renewing it, e.g. because it crashed mid-upload.
+const defaultLockTTL = 30 * time.Second
+
+// ErrUploadLocked is returned when another indexer holds the upload lock.
+var ErrUploadLocked = errors.New("index is locked, another upload may be in progress")
+
+// UploadLock serializes the commits, uploads and snapshots of indexer replicas writing to
+// the same storage.
+type UploadLock interface {
+	// Acquire takes the lock without waiting, failing with an error wrapping
+	// ErrUploadLocked if another holder has it, and returns a function releasing it.
+	Acquire() (release func(), err error)
+}
+
+// SetUploadLock makes commits, uploads and snapshots hold lock. By default they hold a
+// lock file next to the index directory, which only excludes indexers on the same host.
+func (i *Indexer) SetUploadLock(lock UploadLock) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.uploadLock = lock
+}
+
+// UploadLockConfig selects the upload lock of indexer replicas sharing a storage.
+type UploadLockConfig struct {
+	Type          string        `yaml:"type" env:"UPLOAD_LOCK" flag:"upload-lock" usage:"Upload lock coordinating indexer replicas: file (same host only), dynamodb or etcd"`
+	DynamoDBTable string        `yaml:"dynamodb_table" env:"UPLOAD_LOCK_DYNAMODB_TABLE" flag:"upload-lock-dynamodb-table" usage:"DynamoDB table of the upload locks, with a string partition key lock_key"`
+	EtcdEndpoint  string        `yaml:"etcd_endpoint" env:"UPLOAD_LOCK_ETCD_ENDPOINT" flag:"upload-lock-etcd-endpoint" usage:"Base URL of the etcd v3 JSON API, e.g. http://etcd:2379"`
+	TTL           time.Duration `yaml:"ttl" env:"UPLOAD_LOCK_TTL" flag:"upload-lock-ttl" usage:"How long a distributed upload lock outlives an indexer that stopped renewing it"`
+}
+
+// Validate checks that the selected lock has its settings.
+func (c UploadLockConfig) Validate() error {
+	switch c.Type {
+	case "", LockTypeFile:
+	case LockTypeDynamoDB:
+		if c.DynamoDBTable == "" {
+			return fmt.Errorf("the dynamodb upload lock requires a table")
+		}
+	case LockTypeEtcd:
+		if c.EtcdEndpoint == "" {
+			return fmt.Errorf("the etcd upload lock requires an endpoint")
+		}
+	default:
+		return fmt.Errorf("unknown upload lock %q (expected file, dynamodb or etcd)", c.Type)
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("upload lock ttl must not be negative")
+	}
+	return nil
+}
+
+// NewUploadLock returns the configured lock guarding the storage named key, e.g. the
+// storage prefix of a tenant's collection; nil keeps the default lock file. The AWS region
+// and credentials of the DynamoDB lock come from the environment, like NewS3Storage's.
+func NewUploadLock(cfg UploadLockConfig, key string) (UploadLock, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Type {
+	case LockTypeDynamoDB:
+		sess, err := session.NewSession(&aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AWS session: %w", err)
+		}
+		return NewDynamoDBLock(dynamodb.New(sess), cfg.DynamoDBTable, key, cfg.TTL), nil
+	case LockTypeEtcd:
+		return NewEtcdLock(cfg.EtcdEndpoint, key, cfg.TTL, nil), nil
+	}
+	return nil, nil
+}
+
+// acquireUploadLock takes the configured upload lock, or the lock file next to the index
+// directory, and returns a function releasing it. Callers must hold i.mu.
+func (i *Indexer) acquireUploadLock() (func(), error) {
+	lock := i.uploadLock
+	if lock == nil {
+		lock = NewFileLock(filepath.Join(filepath.Dir(i.indexPath), ".indexer.lock"))
+	}
+	release, err := lock.Acquire()
+	if err != nil {
+		if errors.Is(err, ErrUploadLocked) {
+			slog.Warn("Index is locked by another process", "error", err)
+		}
+		return nil, err
+	}
+	return release, nil
+}
+
+// FileLock is an UploadLock held by creating a file, excluding indexers sharing a file
+// system.
+type FileLock struct {
+	path string
+}
+
+// NewFileLock returns the lock held by creating the file at path.
+func NewFileLock(path string) *FileLock {
+	return &FileLock{path: path}
+}
+
+// Acquire creates the lock file with O_EXCL, so it fails if another process holds it.
+func (l *FileLock) Acquire() (func(), error) {
+	slog.Debug("Attempting to acquire lock", "path", l.path)
+	lockFile, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%w: lock file %s exists", ErrUploadLocked, l.path)
+		}
+		return nil, fmt.Errorf("failed to create lock file %s: %w", l.path, err)
+	}
+
+	// The returned function closes and removes the lock file to ensure it's cleaned up.
+	return func() {
+		lockFile.Close()
+		if err := os.Remove(l.path); err != nil {
+			slog.Error("CRITICAL: Failed to remove lock file, manual intervention may be required", "path", l.path, "error", err)
+		} else {
+			slog.Debug("Released lock", "path", l.path)
+		}
+	}, nil
+}
+
+// lockOwner returns a token identifying one acquisition of a distributed lock.
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+// keepAlive calls renew every interval until the returned function is called, which waits
+// for a renewal in progress. A failed renewal is logged: the lock may then expire before
+// the upload is over, letting another indexer take it.
+func keepAlive(name string, interval time.Duration, renew func() error) func() {
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := renew(); err != nil {
+					slog.Error("CRITICAL: Failed to renew upload lock", "lock", name, "error", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// DynamoDBLock is an UploadLock held by an item of a DynamoDB table, written with a
+// conditional put. The item expires after the lock's TTL unless its holder renews it, so
+// a crashed indexer doesn't keep the lock forever.
+type DynamoDBLock struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+	key    string
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewDynamoDBLock returns the lock held by the item key of table, whose partition key is
+// the string attribute lock_key. A zero ttl defaults to 30 seconds.
+func NewDynamoDBLock(client dynamodbiface.DynamoDBAPI, table, key string, ttl time.Duration) *DynamoDBLock {
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	return &DynamoDBLock{client: client, table: table, key: key, ttl: ttl, now: time.Now}
+}
+
+// expiry returns the expires_at attribute of an item written now.
+func (l *DynamoDBLock) expiry() *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(l.now().Add(l.ttl).UnixMilli(), 10))}
+}
+
+// Acquire writes the lock item unless another holder's item hasn't expired.
+func (l *DynamoDBLock) Acquire() (func(), error) {
+	owner := lockOwner()
+	_, err := l.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"lock_key":   {S: aws.String(l.key)},
+			"owner":      {S: aws.String(owner)},
+			"expires_at": l.expiry(),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(lock_key) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(l.now().UnixMilli(), 10))},
+		},
+	})
+	if err != nil {
+		if isConditionFailed(err) {
+			return nil, fmt.Errorf("%w: DynamoDB lock %s is held", ErrUploadLocked, l.key)
+		}
+		return nil, fmt.Errorf("failed to acquire DynamoDB lock %s: %w", l.key, err)
+	}
+	slog.Info("Acquired DynamoDB lock", "key", l.key)
+
+	ownedBy := map[string]*dynamodb.AttributeValue{":owner": {S: aws.String(owner)}}
+	stop := keepAlive(l.key, l.ttl/3, func() error {
+		values := map[string]*dynamodb.AttributeValue{":owner": ownedBy[":owner"], ":expires": l.expiry()}
+		_, err := l.client.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:                 aws.String(l.table),
+			Key:                       map[string]*dynamodb.AttributeValue{"lock_key": {S: aws.String(l.key)}},
+			UpdateExpression:          aws.String("SET expires_at = :expires"),
+			ConditionExpression:       aws.String("owner = :owner"),
+			ExpressionAttributeValues: values,
+		})
+		return err
+	})
+	return func() {
+		stop()
+		_, err := l.client.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName:                 aws.String(l.table),
+			Key:                       map[string]*dynamodb.AttributeValue{"lock_key": {S: aws.String(l.key)}},
+			ConditionExpression:       aws.String("owner = :owner"),
+			ExpressionAttributeValues: ownedBy,
+		})
+		if err != nil && !isConditionFailed(err) {
+			slog.Error("CRITICAL: Failed to release DynamoDB lock, it expires after its TTL", "key", l.key, "ttl", l.ttl, "error", err)
+			return
+		}
+		slog.Info("Released DynamoDB lock", "key", l.key)
+	}, nil
+}
+
+// isConditionFailed reports whether a DynamoDB write failed on its condition expression.
+func isConditionFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// EtcdLock is an UploadLock held by a key of etcd attached to a lease, created by a
+// transaction only if the key doesn't exist. The lease is kept alive while the lock is
+// held, and expires after the lock's TTL if its holder stops. EtcdLock speaks etcd's v3
+// JSON gateway, so it needs no etcd client.
+type EtcdLock struct {
+	etcd etcdClient
+	key  string
+	ttl  time.Duration
+}
+
+// etcdClient calls the v3 JSON gateway of an etcd cluster.
+type etcdClient struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewEtcdLock returns the lock held by key at the etcd cluster behind endpoint, e.g.
+// http://etcd:2379. A nil client uses http.DefaultClient and a zero ttl 30 seconds.
+func NewEtcdLock(endpoint, key string, ttl time.Duration, client *http.Client) *EtcdLock {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	return &EtcdLock{etcd: etcdClient{endpoint: strings.TrimSuffix(endpoint, "/"), client: client}, key: key, ttl: ttl}
+}
+
+// call posts req to an etcd v3 JSON API method and decodes its response into resp.
+func (c etcdClient) call(method string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Post(c.endpoint+"/v3/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("etcd %s failed: %w", method, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s failed with status %s", method, res.Status)
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+		return fmt.Errorf("failed to decode etcd %s response: %w", method, err)
+	}
+	return nil
+}
+
+// Acquire grants a lease and creates the lock key with it, unless the key exists.
+func (l *EtcdLock) Acquire() (func(), error) {
+	var grant struct {
+		ID    string `json:"ID"`
+		Error string `json:"error"`
+	}
+	ttlSeconds := int64((l.ttl + time.Second - 1) / time.Second)
+	if err := l.etcd.call("lease/grant", map[string]interface{}{"TTL": ttlSeconds}, &grant); err != nil {
+		return nil, fmt.Errorf("failed to acquire etcd lock %s: %w", l.key, err)
+	}
+	if grant.ID == "" {
+		return nil, fmt.Errorf("failed to acquire etcd lock %s: lease not granted: %s", l.key, grant.Error)
+	}
+	revoke := func() error {
+		return l.etcd.call("lease/revoke", map[string]string{"ID": grant.ID}, nil)
+	}
+
+	key := base64.StdEncoding.EncodeToString([]byte(l.key))
+	var txn struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	err := l.etcd.call("kv/txn", map[string]interface{}{
+		"compare": []map[string]string{{"key": key, "target": "CREATE", "result": "EQUAL", "create_revision": "0"}},
+		"success": []map[string]interface{}{{"request_put": map[string]string{
+			"key":   key,
+			"value": base64.StdEncoding.EncodeToString([]byte(lockOwner())),
+			"lease": grant.ID,
+		}}},
+	}, &txn)
+	if err == nil && !txn.Succeeded {
+		err = fmt.Errorf("%w: etcd lock %s is held", ErrUploadLocked, l.key)
+	}
+	if err != nil {
+		if rerr := revoke(); rerr != nil {
+			slog.Error("Failed to revoke etcd lease", "lease", grant.ID, "error", rerr)
+		}
+		if errors.Is(err, ErrUploadLocked) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to acquire etcd lock %s: %w", l.key, err)
+	}
+	slog.Info("Acquired etcd lock", "key", l.key, "lease", grant.ID)
+
+	stop := keepAlive(l.key, l.ttl/3, func() error {
+		return l.etcd.call("lease/keepalive", map[string]string{"ID": grant.ID}, nil)
+	})
+	return func() {
+		stop()
+		// Revoking the lease deletes the key.
+		if err := revoke(); err != nil {
+			slog.Error("CRITICAL: Failed to release etcd lock, it expires after its TTL", "key", l.key, "ttl", l.ttl, "error", err)
+			return
+		}
+		slog.Info("Released etcd lock", "key", l.key)
+	}, nil
+}
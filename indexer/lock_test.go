@@ -0,0 +1,208 @@
+package indexer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func TestFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".indexer.lock")
+	release, err := NewFileLock(path).Acquire()
+	if err != nil {
+		t.Fatalf("Acquire returned an error: %v", err)
+	}
+	if _, err := NewFileLock(path).Acquire(); !errors.Is(err, ErrUploadLocked) {
+		t.Errorf("Expected ErrUploadLocked while the lock is held, got %v", err)
+	}
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock file to be removed, got %v", err)
+	}
+}
+
+// fakeDynamoDB is an in-memory DynamoDB client evaluating the conditions of DynamoDBLock.
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (f *fakeDynamoDB) conditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+}
+
+func (f *fakeDynamoDB) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := *in.Item["lock_key"].S
+	if item, ok := f.items[key]; ok && *item["expires_at"].N >= *in.ExpressionAttributeValues[":now"].N {
+		return nil, f.conditionFailed()
+	}
+	f.items[key] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) UpdateItem(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[*in.Key["lock_key"].S]
+	if !ok || *item["owner"].S != *in.ExpressionAttributeValues[":owner"].S {
+		return nil, f.conditionFailed()
+	}
+	item["expires_at"] = in.ExpressionAttributeValues[":expires"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := *in.Key["lock_key"].S
+	item, ok := f.items[key]
+	if !ok || *item["owner"].S != *in.ExpressionAttributeValues[":owner"].S {
+		return nil, f.conditionFailed()
+	}
+	delete(f.items, key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestDynamoDBLock(t *testing.T) {
+	fake := &fakeDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{}}
+	now := time.Unix(1700000000, 0)
+	newLock := func() *DynamoDBLock {
+		l := NewDynamoDBLock(fake, "locks", "indexer/upload/products", time.Minute)
+		l.now = func() time.Time { return now }
+		return l
+	}
+
+	release, err := newLock().Acquire()
+	if err != nil {
+		t.Fatalf("Acquire returned an error: %v", err)
+	}
+	if _, err := newLock().Acquire(); !errors.Is(err, ErrUploadLocked) {
+		t.Errorf("Expected ErrUploadLocked while the lock is held, got %v", err)
+	}
+	release()
+	if len(fake.items) != 0 {
+		t.Errorf("Expected the lock item to be deleted, got %v", fake.items)
+	}
+
+	// The lock of a crashed indexer can be taken once it expires.
+	if _, err := newLock().Acquire(); err != nil {
+		t.Fatalf("Acquire returned an error: %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	release, err = newLock().Acquire()
+	if err != nil {
+		t.Fatalf("Expected the expired lock to be taken over, got %v", err)
+	}
+	release()
+}
+
+// fakeEtcd serves the etcd v3 JSON API methods used by EtcdLock.
+type fakeEtcd struct {
+	mu     sync.Mutex
+	leases int
+	keys   map[string]string // Leases by key
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var req map[string]json.RawMessage
+	json.NewDecoder(r.Body).Decode(&req)
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		f.leases++
+		json.NewEncoder(w).Encode(map[string]string{"ID": strconv.Itoa(f.leases), "TTL": "60"})
+	case "/v3/kv/txn":
+		var txn struct {
+			Compare []struct{ Key string }
+			Success []struct {
+				RequestPut struct{ Key, Lease string } `json:"request_put"`
+			}
+		}
+		body, _ := json.Marshal(req)
+		json.Unmarshal(body, &txn)
+		key, _ := base64.StdEncoding.DecodeString(txn.Compare[0].Key)
+		if _, ok := f.keys[string(key)]; ok {
+			json.NewEncoder(w).Encode(map[string]bool{"succeeded": false})
+			return
+		}
+		f.keys[string(key)] = txn.Success[0].RequestPut.Lease
+		json.NewEncoder(w).Encode(map[string]bool{"succeeded": true})
+	case "/v3/lease/keepalive":
+		w.Write([]byte(`{"result":{}}`))
+	case "/v3/lease/revoke":
+		var id string
+		json.Unmarshal(req["ID"], &id)
+		for key, lease := range f.keys {
+			if lease == id {
+				delete(f.keys, key)
+			}
+		}
+		w.Write([]byte(`{}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEtcdLock(t *testing.T) {
+	fake := &fakeEtcd{keys: map[string]string{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	release, err := NewEtcdLock(server.URL, "indexer/upload/products", time.Minute, nil).Acquire()
+	if err != nil {
+		t.Fatalf("Acquire returned an error: %v", err)
+	}
+	if _, err := NewEtcdLock(server.URL, "indexer/upload/products", time.Minute, nil).Acquire(); !errors.Is(err, ErrUploadLocked) {
+		t.Errorf("Expected ErrUploadLocked while the lock is held, got %v", err)
+	}
+	release()
+	if len(fake.keys) != 0 {
+		t.Errorf("Expected the lock key to be deleted with its lease, got %v", fake.keys)
+	}
+	if _, err := NewEtcdLock(server.URL, "indexer/upload/products", time.Minute, nil).Acquire(); err != nil {
+		t.Errorf("Expected the released lock to be acquired again, got %v", err)
+	}
+}
+
+func TestIndexer_SetUploadLock(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	lockPath := filepath.Join(tempDir, "shared.lock")
+	idx.SetUploadLock(NewFileLock(lockPath))
+
+	release, err := NewFileLock(lockPath).Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.CommitAndUpload(); !errors.Is(err, ErrUploadLocked) {
+		t.Errorf("Expected the commit to fail on the held lock, got %v", err)
+	}
+	release()
+	if err := idx.CommitAndUpload(); err != nil {
+		t.Errorf("CommitAndUpload returned an error: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package indexer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// ManifestFileName is the name of the manifest stored alongside every uploaded segment.
+const ManifestFileName = "manifest.json"
+
+// collectionNamePattern restricts collection names to characters that are safe in
+// directory names and S3 keys.
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ManifestFile describes a single file of an uploaded segment.
+type ManifestFile struct {
+	Path     string `json:"path"` // Path relative to the segment root, using forward slashes
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"` // Hex CRC-32C of the file contents
+	SHA256   string `json:"sha256,omitempty"`   // Hex SHA-256 of the file contents, verified by Searchers
+	// Segment names the upload holding the file's contents when it was unchanged since
+	// an earlier upload and therefore not uploaded again. Empty means this segment.
+	Segment string `json:"segment,omitempty"`
+}
+
+// SegmentManifest describes an uploaded index segment so consumers such as Searchers
+// can discover which tenant and collection it belongs to and which files it contains.
+// When the segment was packaged for transfer, Archive names the single archive file
+// holding every listed file, and Compression how it was packed.
+type SegmentManifest struct {
+	Tenant      string         `json:"tenant,omitempty"`
+	Collection  string         `json:"collection,omitempty"`
+	Segment     string         `json:"segment"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Compression string         `json:"compression,omitempty"`
+	Archive     string         `json:"archive,omitempty"`
+	Files       []ManifestFile `json:"files"`
+}
+
+// castagnoliTable is used to compute file checksums; CRC-32C is hardware accelerated on most CPUs.
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ValidateCollectionName checks that name can be used as a collection name.
+func ValidateCollectionName(name string) error {
+	if !collectionNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid collection name %q: only letters, digits, '_' and '-' are allowed", name)
+	}
+	return nil
+}
+
+// buildSegmentManifest walks segmentPath and returns a manifest listing its files and their checksums.
+func buildSegmentManifest(segmentPath, tenant, collection, segment string) (*SegmentManifest, error) {
+	manifest := &SegmentManifest{
+		Tenant:     tenant,
+		Collection: collection,
+		Segment:    segment,
+		CreatedAt:  time.Now().UTC(),
+	}
+	err := filepath.WalkDir(segmentPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(segmentPath, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", path, err)
+		}
+		checksum, sha, err := fileChecksums(path)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{Path: filepath.ToSlash(relPath), Size: info.Size(), Checksum: checksum, SHA256: sha})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build manifest for %s: %w", segmentPath, err)
+	}
+	return manifest, nil
+}
+
+// writeManifest writes the manifest into dir.
+func writeManifest(dir string, manifest *SegmentManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal segment manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), data, 0644); err != nil {
+		return fmt.Errorf("failed to write segment manifest: %w", err)
+	}
+	return nil
+}
+
+// ReadSegmentManifest reads the manifest stored in an uploaded segment directory.
+func ReadSegmentManifest(segmentDir string) (*SegmentManifest, error) {
+	data, err := os.ReadFile(filepath.Join(segmentDir, ManifestFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read segment manifest in %s: %w", segmentDir, err)
+	}
+	var manifest SegmentManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal segment manifest in %s: %w", segmentDir, err)
+	}
+	return &manifest, nil
+}
+
+// fileChecksums returns the hex CRC-32C and SHA-256 of the file at path, read once.
+func fileChecksums(path string) (string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open %s for checksum: %w", path, err)
+	}
+	defer f.Close()
+	crc, sha := crc32.New(castagnoliTable), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(crc, sha), f); err != nil {
+		return "", "", fmt.Errorf("failed to read %s for checksum: %w", path, err)
+	}
+	return hex.EncodeToString(crc.Sum(nil)), hex.EncodeToString(sha.Sum(nil)), nil
+}
@@ -0,0 +1,96 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalFileStorage_UploadSegment_CollectionAndManifest(t *testing.T) {
+	segmentSourceDir, err := os.MkdirTemp("", "segment_source_collection")
+	if err != nil {
+		t.Fatalf("Failed to create segment source temp dir: %v", err)
+	}
+	defer os.RemoveAll(segmentSourceDir)
+	if err := os.MkdirAll(filepath.Join(segmentSourceDir, "store"), 0755); err != nil {
+		t.Fatalf("Failed to create subdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(segmentSourceDir, "store", "root.bolt"), []byte("12345"), 0644); err != nil {
+		t.Fatalf("Failed to write segment file: %v", err)
+	}
+
+	storageDestDir, err := os.MkdirTemp("", "storage_dest_collection")
+	if err != nil {
+		t.Fatalf("Failed to create storage destination temp dir: %v", err)
+	}
+	defer os.RemoveAll(storageDestDir)
+
+	storage, err := NewLocalFileStorage(storageDestDir)
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	if err := storage.SetCollection("../escape"); err == nil {
+		t.Error("Expected an error for an invalid collection name, got nil")
+	}
+	if err := storage.SetCollection("products"); err != nil {
+		t.Fatalf("SetCollection returned an error: %v", err)
+	}
+
+	if err := storage.UploadSegment(segmentSourceDir); err != nil {
+		t.Fatalf("UploadSegment returned an error: %v", err)
+	}
+
+	destSegmentDir := filepath.Join(storageDestDir, "products", filepath.Base(segmentSourceDir))
+	if _, err := os.Stat(filepath.Join(destSegmentDir, "store", "root.bolt")); err != nil {
+		t.Errorf("Expected segment file under the collection directory: %v", err)
+	}
+
+	manifest, err := ReadSegmentManifest(destSegmentDir)
+	if err != nil {
+		t.Fatalf("ReadSegmentManifest returned an error: %v", err)
+	}
+	if manifest.Collection != "products" || manifest.Segment != filepath.Base(segmentSourceDir) {
+		t.Errorf("Unexpected manifest header: %+v", manifest)
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].Path != "store/root.bolt" || manifest.Files[0].Size != 5 {
+		t.Errorf("Unexpected manifest files: %+v", manifest.Files)
+	}
+	// SHA-256 of "12345"
+	if sha := "5994471abb01112afcc18159f6cc74b4f511b99806da59b3caf5a9c173cacfc5"; len(manifest.Files) == 1 && manifest.Files[0].SHA256 != sha {
+		t.Errorf("Expected SHA-256 %s, got %s", sha, manifest.Files[0].SHA256)
+	}
+}
+
+func TestLocalFileStorage_UploadSegment_Tenant(t *testing.T) {
+	segmentSourceDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(segmentSourceDir, "root.bolt"), []byte("12345"), 0644); err != nil {
+		t.Fatalf("Failed to write segment file: %v", err)
+	}
+	storageDestDir := t.TempDir()
+
+	storage, err := NewLocalFileStorage(storageDestDir)
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	if err := storage.SetTenant("../escape"); err == nil {
+		t.Error("Expected an error for an invalid tenant, got nil")
+	}
+	if err := storage.SetTenant("shop"); err != nil {
+		t.Fatalf("SetTenant returned an error: %v", err)
+	}
+	if err := storage.SetCollection("products"); err != nil {
+		t.Fatalf("SetCollection returned an error: %v", err)
+	}
+	if err := storage.UploadSegment(segmentSourceDir); err != nil {
+		t.Fatalf("UploadSegment returned an error: %v", err)
+	}
+
+	destSegmentDir := filepath.Join(storageDestDir, "tenants", "shop", "products", filepath.Base(segmentSourceDir))
+	manifest, err := ReadSegmentManifest(destSegmentDir)
+	if err != nil {
+		t.Fatalf("Expected the segment under the tenant directory: %v", err)
+	}
+	if manifest.Tenant != "shop" || manifest.Collection != "products" {
+		t.Errorf("Unexpected manifest header: %+v", manifest)
+	}
+}
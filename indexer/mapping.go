@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 
+	"common/simhash"
+
 	"github.com/blevesearch/bleve/v2"
 	"github.com/blevesearch/bleve/v2/mapping"
 )
@@ -16,8 +18,8 @@ func LoadIndexMapping(filePath string) (mapping.IndexMapping, error) {
 		return nil, fmt.Errorf("failed to read mapping file %s: %w", filePath, err)
 	}
 
-	var indexMapping mapping.IndexMapping
-	if err := json.Unmarshal(data, &indexMapping); err != nil {
+	indexMapping := bleve.NewIndexMapping()
+	if err := json.Unmarshal(data, indexMapping); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal mapping JSON from %s: %w", filePath, err)
 	}
 
@@ -70,6 +72,34 @@ func CreateDefaultIndexMapping() *mapping.IndexMappingImpl {
 	geoFieldMapping.Store = true
 	docMapping.AddFieldMappingsAt("location", geoFieldMapping)
 
+	// Keyword field holding the SimHash of near-duplicate detection
+	fingerprintFieldMapping := bleve.NewKeywordFieldMapping()
+	fingerprintFieldMapping.Store = true
+	fingerprintFieldMapping.IncludeInAll = false
+	docMapping.AddFieldMappingsAt(simhash.Field, fingerprintFieldMapping)
+
+	// Numeric field holding the version of optimistic concurrency control
+	versionFieldMapping := bleve.NewNumericFieldMapping()
+	versionFieldMapping.Store = true
+	versionFieldMapping.IncludeInAll = false
+	docMapping.AddFieldMappingsAt(VersionField, versionFieldMapping)
+
+	// Date after which a document expires
+	expiresAtFieldMapping := bleve.NewDateTimeFieldMapping()
+	expiresAtFieldMapping.Store = true
+	expiresAtFieldMapping.IncludeInAll = false
+	docMapping.AddFieldMappingsAt(ExpiresAtField, expiresAtFieldMapping)
+
+	// Keyword fields linking the sub-documents of nested objects to their parents, which
+	// may be untyped
+	for _, field := range []string{ParentField, NestedPathField} {
+		nestedFieldMapping := bleve.NewKeywordFieldMapping()
+		nestedFieldMapping.Store = true
+		nestedFieldMapping.IncludeInAll = false
+		docMapping.AddFieldMappingsAt(field, nestedFieldMapping)
+		indexMapping.DefaultMapping.AddFieldMappingsAt(field, nestedFieldMapping)
+	}
+
 	// Add the document mapping to the index mapping with the type name "document"
 	indexMapping.AddDocumentMapping("document", docMapping)
 
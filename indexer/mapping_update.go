@@ -0,0 +1,35 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+// ErrInvalidMapping is returned when a new mapping fails validation.
+var ErrInvalidMapping = errors.New("invalid mapping")
+
+// Mapping returns the mapping of the active index.
+func (i *Indexer) Mapping() mapping.IndexMapping {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.index.Mapping()
+}
+
+// UpdateMapping validates the new mapping, creates a new physical index with it and starts
+// a job reindexing the existing documents into it. The current index keeps serving until
+// the job completes; the new index then replaces it at the same path and the previous
+// index is kept on disk next to it.
+//
+// Documents are rebuilt from their stored fields, so fields that are not stored in the
+// current mapping are lost.
+func (i *Indexer) UpdateMapping(m *mapping.IndexMappingImpl) (*Job, error) {
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMapping, err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.startJob(JobTypeMappingUpdate, m, SourceSpec{Type: SourceIndex})
+}
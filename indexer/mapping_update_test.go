@@ -0,0 +1,112 @@
+package indexer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+func TestIndexer_UpdateMapping(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "indexer_mapping")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	indexPath := filepath.Join(tempDir, "index")
+	idx, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+
+	docs := map[string]interface{}{
+		"doc1": map[string]interface{}{"title": "First Title"},
+		"doc2": map[string]interface{}{"title": "Second Title"},
+		"doc3": map[string]interface{}{"title": "Third Title"},
+	}
+	if _, err := idx.BulkIndexDocuments(docs); err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+
+	// Map "title" as a keyword so only exact titles match.
+	newMapping := bleve.NewIndexMapping()
+	keyword := bleve.NewKeywordFieldMapping()
+	keyword.Store = true
+	newMapping.DefaultMapping.AddFieldMappingsAt("title", keyword)
+
+	job, err := idx.UpdateMapping(newMapping)
+	if err != nil {
+		t.Fatalf("UpdateMapping returned an error: %v", err)
+	}
+	if job.ID == "" || job.Type != JobTypeMappingUpdate || job.Status != JobRunning {
+		t.Errorf("Unexpected job: %+v", job)
+	}
+	// A write during the reindex must reach the new index, either copied or mirrored.
+	if err := idx.IndexDocument("doc4", map[string]interface{}{"title": "Fourth Title"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+
+	job = waitForJob(t, idx, job.ID)
+	if job.Status != JobCompleted {
+		t.Fatalf("Expected job to complete, got %+v", job)
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.indexPath != indexPath {
+		t.Errorf("Expected index to stay at %s, got %s", indexPath, idx.indexPath)
+	}
+	count, err := idx.index.DocCount()
+	if err != nil || count != 4 {
+		t.Errorf("Expected 4 documents after reindex, got %d (%v)", count, err)
+	}
+	query := bleve.NewTermQuery("Second Title")
+	query.SetField("title")
+	result, err := idx.index.Search(bleve.NewSearchRequest(query))
+	if err != nil {
+		t.Fatalf("Search returned an error: %v", err)
+	}
+	if result.Total != 1 || result.Hits[0].ID != "doc2" {
+		t.Errorf("Expected exact keyword match on doc2, got %v", result.Hits)
+	}
+}
+
+func TestIndexer_UpdateMapping_Invalid(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "indexer_mapping")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+
+	idx.reindex = &Job{ID: "busy", Status: JobRunning}
+	if _, err := idx.UpdateMapping(bleve.NewIndexMapping()); !errors.Is(err, ErrReindexInProgress) {
+		t.Errorf("Expected ErrReindexInProgress, got %v", err)
+	}
+	idx.reindex = nil
+
+	invalid := bleve.NewIndexMapping()
+	invalid.DefaultAnalyzer = "no-such-analyzer"
+	if _, err := idx.UpdateMapping(invalid); !errors.Is(err, ErrInvalidMapping) {
+		t.Errorf("Expected ErrInvalidMapping, got %v", err)
+	}
+	if _, err := idx.Job("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package indexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Fields of the sub-documents flattened from nested objects. A sub-document holds one
+// object of the array at its path, under the same path, so its fields have the names and
+// mappings of the parent's; its ID is <parent>#<path>.<n>, n being the object's index.
+const (
+	ParentField     = "_parent"      // ID of the document the object is nested in
+	NestedPathField = "_nested_path" // Field of the parent holding the array of objects
+)
+
+// nestedLookupPage is the number of sub-documents looked up per search when their parents
+// are written.
+const nestedLookupPage = 1000
+
+// SetNestedFields makes the indexer flatten the arrays of objects of fields, e.g. "items",
+// into sub-documents, which Searchers match nested filters against so that all of the
+// filters on the objects of a document must match the same object. Parents keep their
+// arrays; writing or deleting a parent replaces or deletes its sub-documents. Nil flattens
+// none.
+func (i *Indexer) SetNestedFields(fields []string) {
+	i.nestedFields = fields
+}
+
+// nestedID returns the ID of the sub-document of the n-th object of path in document id.
+func nestedID(id, path string, n int) string {
+	return id + "#" + path + "." + strconv.Itoa(n)
+}
+
+// isNestedID reports whether child is the ID of a sub-document of parent.
+func isNestedID(child, parent string) bool {
+	rest, ok := strings.CutPrefix(child, parent+"#")
+	if !ok {
+		return false
+	}
+	dot := strings.LastIndexByte(rest, '.')
+	if dot <= 0 {
+		return false
+	}
+	_, err := strconv.Atoi(rest[dot+1:])
+	return err == nil
+}
+
+// subDocuments returns the sub-documents flattened from the nested objects of a document,
+// by ID. They carry the document type of their parent, if any, so they are mapped alike.
+func (i *Indexer) subDocuments(target bleve.Index, id string, data interface{}) map[string]interface{} {
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	typeField := ""
+	if m, ok := target.Mapping().(*mapping.IndexMappingImpl); ok {
+		typeField = m.TypeField
+	}
+	var docs map[string]interface{}
+	for _, path := range i.nestedFields {
+		objects, ok := fields[path].([]interface{})
+		if !ok {
+			continue
+		}
+		for n, object := range objects {
+			if _, ok := object.(map[string]interface{}); !ok {
+				continue
+			}
+			doc := map[string]interface{}{path: object, ParentField: id, NestedPathField: path}
+			if t, ok := fields[typeField]; ok && typeField != "" {
+				doc[typeField] = t
+			}
+			if docs == nil {
+				docs = make(map[string]interface{})
+			}
+			docs[nestedID(id, path, n)] = doc
+		}
+	}
+	return docs
+}
+
+// existingSubDocuments returns the IDs of the sub-documents of parents in target, by parent.
+func existingSubDocuments(target bleve.Index, parents []string) (map[string][]string, error) {
+	children := make(map[string][]string, len(parents))
+	if len(parents) == 0 {
+		return children, nil
+	}
+	// Parent IDs are matched with the analyzer of the field, a keyword in the generated
+	// mappings; the IDs of the hits weed out the other parents it leads to.
+	matches := make([]query.Query, len(parents))
+	for n, parent := range parents {
+		match := bleve.NewMatchQuery(parent)
+		match.SetField(ParentField)
+		match.SetOperator(query.MatchQueryOperatorAnd)
+		matches[n] = match
+	}
+	q := bleve.NewDisjunctionQuery(matches...)
+	for from := 0; ; from += nestedLookupPage {
+		req := bleve.NewSearchRequestOptions(q, nestedLookupPage, from, false)
+		req.Fields = []string{ParentField}
+		result, err := target.Search(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up sub-documents: %w", err)
+		}
+		for _, hit := range result.Hits {
+			if parent, ok := hit.Fields[ParentField].(string); ok && isNestedID(hit.ID, parent) {
+				children[parent] = append(children[parent], hit.ID)
+			}
+		}
+		if len(result.Hits) < nestedLookupPage {
+			return children, nil
+		}
+	}
+}
+
+// addNestedToBatch adds to batch the writes of the sub-documents of the documents records
+// write in target: the previous sub-documents of every written or deleted document are
+// deleted and those of its nested objects indexed, in the order of records.
+func (i *Indexer) addNestedToBatch(target bleve.Index, batch *bleve.Batch, records []walRecord) error {
+	if len(i.nestedFields) == 0 {
+		return nil
+	}
+	var parents []string
+	for _, record := range records {
+		parents = append(parents, record.ids()...)
+	}
+	children, err := existingSubDocuments(target, parents)
+	if err != nil {
+		return err
+	}
+	write := func(id string, data interface{}) error {
+		for _, child := range children[id] {
+			batch.Delete(child)
+		}
+		docs := i.subDocuments(target, id, data)
+		children[id] = children[id][:0]
+		for child, doc := range docs {
+			if err := batch.Index(child, doc); err != nil {
+				return fmt.Errorf("sub-document %s: %w", child, err)
+			}
+			children[id] = append(children[id], child)
+		}
+		return nil
+	}
+	for _, record := range records {
+		switch record.Op {
+		case walOpIndex:
+			if err := write(record.ID, record.Data); err != nil {
+				return err
+			}
+		case walOpBulk:
+			for id, data := range record.Docs {
+				if err := write(id, data); err != nil {
+					return err
+				}
+			}
+		case walOpDelete, walOpBulkDelete:
+			for _, id := range record.ids() {
+				if err := write(id, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package indexer
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestIndexer_NestedFields(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	idx.SetNestedFields([]string{"items"})
+
+	subDocuments := func(parent string) []string {
+		t.Helper()
+		children, err := existingSubDocuments(idx.index, []string{parent})
+		if err != nil {
+			t.Fatalf("Failed to look up sub-documents: %v", err)
+		}
+		sort.Strings(children[parent])
+		return children[parent]
+	}
+
+	order := map[string]interface{}{"title": "Order", "items": []interface{}{
+		map[string]interface{}{"color": "red", "price": 20.0},
+		map[string]interface{}{"color": "blue", "price": 5.0},
+	}}
+	if err := idx.IndexDocument("Order-1", order); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if got := subDocuments("Order-1"); len(got) != 2 || got[0] != "Order-1#items.0" || got[1] != "Order-1#items.1" {
+		t.Fatalf("Expected a sub-document per item, got %v", got)
+	}
+	child, err := idx.GetDocument("Order-1#items.1", []string{"items.color", ParentField, NestedPathField})
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	if child.Fields["items.color"] != "blue" || child.Fields[ParentField] != "Order-1" || child.Fields[NestedPathField] != "items" {
+		t.Errorf("Unexpected sub-document %v", child.Fields)
+	}
+
+	// Rewriting the parent replaces its sub-documents.
+	order["items"] = []interface{}{map[string]interface{}{"color": "green", "price": 1.0}}
+	if _, err := idx.BulkIndexDocuments(map[string]interface{}{"Order-1": order}); err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+	if got := subDocuments("Order-1"); len(got) != 1 || got[0] != "Order-1#items.0" {
+		t.Errorf("Expected the stale sub-document to be deleted, got %v", got)
+	}
+
+	if err := idx.DeleteDocument("Order-1"); err != nil {
+		t.Fatalf("DeleteDocument returned an error: %v", err)
+	}
+	if got := subDocuments("Order-1"); len(got) != 0 {
+		t.Errorf("Expected the sub-documents to be deleted with their parent, got %v", got)
+	}
+}
+
+func TestIsNestedID(t *testing.T) {
+	for _, tc := range []struct {
+		child, parent string
+		want          bool
+	}{
+		{"a#items.0", "a", true},
+		{"a#b#items.12", "a#b", true},
+		{"a#items.0", "a#items", false},
+		{"ab#items.0", "a", false},
+		{"a#items", "a", false},
+	} {
+		if got := isNestedID(tc.child, tc.parent); got != tc.want {
+			t.Errorf("isNestedID(%q, %q) = %v, want %v", tc.child, tc.parent, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"common/shard"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	bolterrors "go.etcd.io/bbolt/errors"
+)
+
+const (
+	// reshardStateFile records the progress of a resharding in its target directory.
+	reshardStateFile = "reshard.json"
+	// defaultSettleDelay gives the routers three reloads of the routing table to start
+	// mirroring writes before the sources are snapshotted.
+	defaultSettleDelay = 30 * time.Second
+	// sourceLockTimeout bounds the wait for the lock of a source index, which a running
+	// indexer holds for as long as it serves it.
+	sourceLockTimeout = "1s"
+)
+
+var (
+	// ErrReshardMismatch is returned when resuming a resharding with other sources or
+	// another number of shards than it was started with.
+	ErrReshardMismatch = errors.New("resharding state does not match the configuration")
+	// ErrSourceInUse is returned for a source index held open by a running indexer.
+	ErrSourceInUse = errors.New("source index is in use")
+)
+
+// ReshardConfig describes a resharding: the documents of the existing shards are streamed
+// into Shards new indexes, each document going to the shard its ID hashes to, as routed
+// by common/shard.
+//
+// An online resharding, with SourceIndexers, keeps the collection writable. The indexers
+// of the new shards are staged, so they journal the writes they receive, and the routing
+// table makes the routers mirror every write to the new shards. The live source shards
+// are then snapshotted, so every write is in a snapshot, in a journal, or both; the
+// snapshots are copied and uploaded, and every new indexer loads its shard and replays
+// its journal over it. The routing table then cuts the writes over to the new shards and
+// the shard map moves the brokers' searches to their searchers.
+type ReshardConfig struct {
+	// Sources are the existing shards of an offline resharding: paths of their Bleve
+	// indexes, opened read-only, or http(s) URLs of document stores as read by
+	// HTTPDocumentSource. Only stored fields can be recovered from indexes, and an index
+	// still served by an indexer fails with ErrSourceInUse.
+	Sources []string
+	// SourceIndexers are the indexers of the existing shards of an online resharding,
+	// instead of Sources. Each one takes a snapshot of its shard, downloaded from the
+	// SnapshotStorage at the same position in SourceStorages.
+	SourceIndexers []string
+	SourceStorages []SnapshotStorage
+	// SettleDelay is how long the routers are given to load the routing table mirroring
+	// writes to the new shards before the sources are snapshotted; 0 uses 30s, three
+	// reloads of the routers' default period.
+	SettleDelay time.Duration
+	// TargetDir holds the new indexes, as shard-<n>, and the progress of the resharding.
+	TargetDir string
+	Shards    int
+	// Mapping of the new indexes; nil uses the mapping of the first index source, or the
+	// default mapping.
+	Mapping mapping.IndexMapping
+	// BatchSize is the number of documents read from a source at a time; 0 uses 500.
+	BatchSize int
+	// Rate throttles the copy to this many documents per second; 0 is unlimited.
+	Rate float64
+	// Storages upload the new indexes, one per shard; nil skips the upload. New shard n
+	// is uploaded as the index shard-<n>, which Indexers[n] then loads.
+	Storages []IndexSegmentStorage
+	// RoutingTable is the routing table file replaced once every new index is loaded,
+	// listing Indexers as the shards; empty leaves routing alone.
+	RoutingTable string
+	Indexers     []string
+	// ShardMap is the shard map file of the brokers replaced after the routing table,
+	// listing Searchers as the replicas of every new shard of the collection of Tenant;
+	// empty leaves the brokers alone.
+	ShardMap   string
+	Tenant     string
+	Collection string
+	Searchers  [][]string
+}
+
+// ReshardState is the progress of a resharding, saved after every batch so an
+// interrupted resharding resumes where it stopped.
+type ReshardState struct {
+	Sources     []string  `json:"sources"`
+	Shards      int       `json:"shards"`
+	Staged      bool      `json:"staged,omitempty"`      // New indexers journaling writes
+	Mirrored    bool      `json:"mirrored,omitempty"`    // Routing table mirroring writes to the new shards
+	Snapshotted []bool    `json:"snapshotted,omitempty"` // Sources snapshotted and downloaded
+	Checkpoints []string  `json:"checkpoints"`           // ID of the last document copied from every source
+	Copied      []bool    `json:"copied"`                // Sources copied entirely
+	Documents   uint64    `json:"documents"`             // Documents copied so far
+	Uploaded    []bool    `json:"uploaded"`              // New shards uploaded
+	Loaded      []bool    `json:"loaded,omitempty"`      // New shards loaded by their indexers
+	Routed      bool      `json:"routed"`                // Routing table cut over to the new shards
+	Mapped      bool      `json:"mapped,omitempty"`      // Shard map replaced
+	StartedAt   time.Time `json:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Validate checks the resharding settings.
+func (c ReshardConfig) Validate() error {
+	if (len(c.Sources) == 0) == (len(c.SourceIndexers) == 0) {
+		return fmt.Errorf("resharding requires either source shards or source indexers")
+	}
+	if c.online() {
+		if len(c.SourceStorages) != len(c.SourceIndexers) {
+			return fmt.Errorf("the snapshots of %d source indexers need %d storages, got %d", len(c.SourceIndexers), len(c.SourceIndexers), len(c.SourceStorages))
+		}
+		if c.RoutingTable == "" || c.Storages == nil {
+			return fmt.Errorf("online resharding requires a routing table and storages for the new shards")
+		}
+	}
+	if c.SettleDelay < 0 {
+		return fmt.Errorf("settle delay must not be negative")
+	}
+	if c.TargetDir == "" {
+		return fmt.Errorf("resharding requires a target directory")
+	}
+	if c.Shards <= 0 {
+		return fmt.Errorf("resharding requires a positive number of shards, got %d", c.Shards)
+	}
+	if c.BatchSize < 0 || c.Rate < 0 {
+		return fmt.Errorf("batch size and rate must not be negative")
+	}
+	if c.Storages != nil && len(c.Storages) != c.Shards {
+		return fmt.Errorf("resharding into %d shards needs %d storages, got %d", c.Shards, c.Shards, len(c.Storages))
+	}
+	if c.RoutingTable != "" && len(c.Indexers) != c.Shards {
+		return fmt.Errorf("the routing table of %d shards needs %d indexers, got %d", c.Shards, c.Shards, len(c.Indexers))
+	}
+	if c.Indexers != nil && len(c.Indexers) != c.Shards {
+		return fmt.Errorf("resharding into %d shards needs %d indexers, got %d", c.Shards, c.Shards, len(c.Indexers))
+	}
+	if c.ShardMap != "" {
+		if c.Collection == "" || len(c.Searchers) != c.Shards {
+			return fmt.Errorf("the shard map of %d shards needs a collection and the searchers of every shard", c.Shards)
+		}
+	}
+	return nil
+}
+
+// online reports whether the sources are live shards, snapshotted through their indexers.
+func (c ReshardConfig) online() bool {
+	return len(c.SourceIndexers) > 0
+}
+
+// sources returns the sources identifying the resharding in its state.
+func (c ReshardConfig) sources() []string {
+	if c.online() {
+		return c.SourceIndexers
+	}
+	return c.Sources
+}
+
+// sourcePath returns the source shard s is copied from: its snapshot in the target
+// directory for an online resharding.
+func (c ReshardConfig) sourcePath(s int) string {
+	if c.online() {
+		return filepath.Join(c.TargetDir, fmt.Sprintf("source-%d", s))
+	}
+	return c.Sources[s]
+}
+
+// Reshard copies the documents of the source shards into the new shards, uploads them,
+// has their indexers load them and replaces the routing table and the shard map; an
+// online resharding first mirrors writes to the new shards and snapshots the sources, see
+// ReshardConfig. It is resumable: run again with the same configuration after a failure
+// or cancellation of ctx, it continues after the last step it saved. Documents copied
+// twice by a resumed batch are simply indexed again.
+func Reshard(ctx context.Context, cfg ReshardConfig) (*ReshardState, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = reindexBatchSize
+	}
+	if cfg.SettleDelay == 0 {
+		cfg.SettleDelay = defaultSettleDelay
+	}
+	if err := os.MkdirAll(cfg.TargetDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create resharding directory: %w", err)
+	}
+	state, err := loadReshardState(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []func(context.Context, ReshardConfig, *ReshardState) error{copyShards, uploadShards, loadShards, cutOver, publishShardMap}
+	if cfg.online() {
+		steps = append([]func(context.Context, ReshardConfig, *ReshardState) error{stageShards, mirrorWrites, snapshotSources}, steps...)
+	}
+	for _, step := range steps {
+		if err := step(ctx, cfg, state); err != nil {
+			return state, err
+		}
+	}
+	slog.Info("Resharded documents", "documents", state.Documents, "source_shards", len(state.Sources), "shards", cfg.Shards)
+	return state, nil
+}
+
+// stageShards stages the indexers of the new shards, so they journal the writes mirrored
+// to them until they load their shard.
+func stageShards(ctx context.Context, cfg ReshardConfig, state *ReshardState) error {
+	if state.Staged {
+		return nil
+	}
+	for n, indexer := range cfg.Indexers {
+		if err := postIndexer(ctx, indexer, "/reshard/stage", nil); err != nil {
+			return fmt.Errorf("failed to stage the indexer of new shard %d: %w", n, err)
+		}
+	}
+	state.Staged = true
+	return saveReshardState(cfg, state)
+}
+
+// mirrorWrites makes the routers mirror writes to the new shards, and gives them the
+// settle delay to load the routing table before the sources are snapshotted.
+func mirrorWrites(ctx context.Context, cfg ReshardConfig, state *ReshardState) error {
+	if state.Mirrored {
+		return nil
+	}
+	current, err := shard.ReadRoutingTable(cfg.RoutingTable)
+	if err != nil {
+		return fmt.Errorf("online resharding requires the current routing table: %w", err)
+	}
+	if len(current.Next) == 0 {
+		table := &shard.RoutingTable{Version: current.Version + 1, Shards: current.Shards, Next: cfg.Indexers, UpdatedAt: time.Now()}
+		if err := shard.WriteRoutingTable(cfg.RoutingTable, table); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Mirroring writes to the new shards", "routing_table", cfg.RoutingTable, "version", table.Version, "settle_delay", cfg.SettleDelay)
+	}
+	select {
+	case <-time.After(cfg.SettleDelay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	state.Mirrored = true
+	return saveReshardState(cfg, state)
+}
+
+// snapshotSources snapshots the source shards through their indexers and downloads the
+// snapshots into the target directory, to be copied instead of the live indexes.
+func snapshotSources(ctx context.Context, cfg ReshardConfig, state *ReshardState) error {
+	name := "reshard-" + state.StartedAt.UTC().Format(uploadTimestampLayout)
+	for s, indexer := range cfg.SourceIndexers {
+		if state.Snapshotted[s] {
+			continue
+		}
+		slog.InfoContext(ctx, "Snapshotting source shard", "shard", s, "indexer", indexer, "snapshot", name)
+		err := postIndexer(ctx, indexer, "/snapshot", map[string]string{"name": name})
+		// A resumed resharding may have taken the snapshot already.
+		if err != nil && !strings.Contains(err.Error(), "already exists") {
+			return fmt.Errorf("failed to snapshot source shard %d: %w", s, err)
+		}
+		path := cfg.sourcePath(s)
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", path, err)
+		}
+		if err := cfg.SourceStorages[s].DownloadSnapshot(name, path); err != nil {
+			return fmt.Errorf("failed to download the snapshot of source shard %d: %w", s, err)
+		}
+		state.Snapshotted[s] = true
+		if err := saveReshardState(cfg, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadShards uploads the new shards not uploaded yet.
+func uploadShards(ctx context.Context, cfg ReshardConfig, state *ReshardState) error {
+	for n, storage := range cfg.Storages {
+		if state.Uploaded[n] {
+			continue
+		}
+		slog.InfoContext(ctx, "Uploading new shard", "shard", n)
+		if err := storage.UploadSegment(reshardTargetPath(cfg, n)); err != nil {
+			return fmt.Errorf("failed to upload shard %d: %w", n, err)
+		}
+		state.Uploaded[n] = true
+		if err := saveReshardState(cfg, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadShards has the indexers of the new shards load their uploads, replaying the writes
+// they journaled meanwhile. Indexers not staged by an online resharding are staged first,
+// with nothing to replay.
+func loadShards(ctx context.Context, cfg ReshardConfig, state *ReshardState) error {
+	if cfg.Storages == nil {
+		return nil
+	}
+	for n, indexer := range cfg.Indexers {
+		if state.Loaded[n] {
+			continue
+		}
+		if !cfg.online() {
+			if err := postIndexer(ctx, indexer, "/reshard/stage", nil); err != nil {
+				return fmt.Errorf("failed to stage the indexer of new shard %d: %w", n, err)
+			}
+		}
+		index := filepath.Base(reshardTargetPath(cfg, n))
+		slog.InfoContext(ctx, "Loading new shard", "shard", n, "indexer", indexer)
+		if err := postIndexer(ctx, indexer, "/reshard/load", map[string]string{"index": index}); err != nil {
+			return fmt.Errorf("failed to load new shard %d: %w", n, err)
+		}
+		state.Loaded[n] = true
+		if err := saveReshardState(cfg, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cutOver replaces the routing table with the new shards.
+func cutOver(ctx context.Context, cfg ReshardConfig, state *ReshardState) error {
+	if cfg.RoutingTable == "" || state.Routed {
+		return nil
+	}
+	if err := replaceRoutingTable(cfg); err != nil {
+		return err
+	}
+	state.Routed = true
+	return saveReshardState(cfg, state)
+}
+
+// publishShardMap replaces the shard map of the brokers with the searchers of the new
+// shards, with the version following the current map's.
+func publishShardMap(ctx context.Context, cfg ReshardConfig, state *ReshardState) error {
+	if cfg.ShardMap == "" || state.Mapped {
+		return nil
+	}
+	version := 1
+	current, err := shard.ReadShardMap(cfg.ShardMap)
+	if err == nil {
+		version = current.Version + 1
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	m := &shard.ShardMap{Version: version, Tenant: cfg.Tenant, Collection: cfg.Collection, Shards: cfg.Searchers, UpdatedAt: time.Now()}
+	if err := shard.WriteShardMap(cfg.ShardMap, m); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Shard map replaced", "shard_map", cfg.ShardMap, "version", version, "shards", len(cfg.Searchers))
+	state.Mapped = true
+	return saveReshardState(cfg, state)
+}
+
+// postIndexer posts the JSON encoding of body, if any, to path of the indexer at baseURL,
+// failing unless it answers 200.
+func postIndexer(ctx context.Context, baseURL, path string, body interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s answered %d: %s", path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// loadReshardState reads the progress of a resharding of cfg, or starts one.
+func loadReshardState(cfg ReshardConfig) (*ReshardState, error) {
+	data, err := os.ReadFile(filepath.Join(cfg.TargetDir, reshardStateFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		now := time.Now()
+		sources := cfg.sources()
+		return &ReshardState{
+			Sources:     sources,
+			Shards:      cfg.Shards,
+			Snapshotted: make([]bool, len(sources)),
+			Checkpoints: make([]string, len(sources)),
+			Copied:      make([]bool, len(sources)),
+			Uploaded:    make([]bool, cfg.Shards),
+			Loaded:      make([]bool, cfg.Shards),
+			StartedAt:   now,
+			UpdatedAt:   now,
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resharding state: %w", err)
+	}
+	var state ReshardState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse resharding state: %w", err)
+	}
+	if state.Shards != cfg.Shards || strings.Join(state.Sources, "\n") != strings.Join(cfg.sources(), "\n") {
+		return nil, fmt.Errorf("%w: it was started from %d sources into %d shards", ErrReshardMismatch, len(state.Sources), state.Shards)
+	}
+	// States saved before a step was tracked have not done it.
+	if len(state.Snapshotted) == 0 {
+		state.Snapshotted = make([]bool, len(state.Sources))
+	}
+	if len(state.Loaded) == 0 {
+		state.Loaded = make([]bool, state.Shards)
+	}
+	slog.Info("Resuming resharding", "started_at", state.StartedAt.Format(time.RFC3339), "documents", state.Documents)
+	return &state, nil
+}
+
+// saveReshardState writes the progress of a resharding.
+func saveReshardState(cfg ReshardConfig, state *ReshardState) error {
+	state.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(cfg.TargetDir, reshardStateFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to save resharding state: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to save resharding state: %w", err)
+	}
+	return nil
+}
+
+// reshardTargetPath returns the path of the index of new shard n.
+func reshardTargetPath(cfg ReshardConfig, n int) string {
+	return filepath.Join(cfg.TargetDir, fmt.Sprintf("shard-%d", n))
+}
+
+// openReshardSource opens a source shard, returning its index to be closed when it is one.
+// An index still served by an indexer fails with ErrSourceInUse rather than waiting for
+// its lock, which the indexer holds until it stops.
+func openReshardSource(source string) (DocumentSource, bleve.Index, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		s, err := NewHTTPDocumentSource(source)
+		return s, nil, err
+	}
+	index, err := bleve.OpenUsing(source, map[string]interface{}{"read_only": true, "bolt_timeout": sourceLockTimeout})
+	if errors.Is(err, bolterrors.ErrTimeout) {
+		return nil, nil, fmt.Errorf("%w: %s is open by a running indexer; stop it or reshard online through the indexers", ErrSourceInUse, source)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open source index %s: %w", source, err)
+	}
+	return &indexSource{index: index}, index, nil
+}
+
+// copyShards streams the documents of the sources not copied yet into the new shards.
+func copyShards(ctx context.Context, cfg ReshardConfig, state *ReshardState) error {
+	done := true
+	for _, copied := range state.Copied {
+		done = done && copied
+	}
+	if done {
+		return nil
+	}
+
+	indexMapping := cfg.Mapping
+	targets := make([]bleve.Index, cfg.Shards)
+	defer func() {
+		for n, target := range targets {
+			if target != nil {
+				if err := target.Close(); err != nil {
+					slog.ErrorContext(ctx, "Failed to close new shard", "shard", n, "error", err)
+				}
+			}
+		}
+	}()
+
+	for s := range state.Sources {
+		if state.Copied[s] {
+			continue
+		}
+		src, index, err := openReshardSource(cfg.sourcePath(s))
+		if err != nil {
+			return err
+		}
+		if indexMapping == nil {
+			if index != nil {
+				indexMapping = index.Mapping()
+			} else {
+				indexMapping = CreateDefaultIndexMapping()
+			}
+		}
+		for n := range targets {
+			if targets[n] != nil {
+				continue
+			}
+			if targets[n], err = openOrCreateIndex(reshardTargetPath(cfg, n), indexMapping); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = copySource(ctx, cfg, state, s, src, targets)
+		}
+		if index != nil {
+			index.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openOrCreateIndex opens the index at path, creating it with m if it doesn't exist.
+func openOrCreateIndex(path string, m mapping.IndexMapping) (bleve.Index, error) {
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		index, err = bleve.New(path, m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open new shard index %s: %w", path, err)
+	}
+	return index, nil
+}
+
+// copySource copies the documents of source s into the new shards, a batch at a time,
+// saving the checkpoint after every batch and sleeping as needed to keep to the rate.
+func copySource(ctx context.Context, cfg ReshardConfig, state *ReshardState, s int, src DocumentSource, targets []bleve.Index) error {
+	slog.InfoContext(ctx, "Copying source shard", "shard", s, "source", state.Sources[s], "after", state.Checkpoints[s])
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start := time.Now()
+		docs, total, err := src.Next(state.Checkpoints[s], cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to read source shard %d: %w", s, err)
+		}
+		if len(docs) == 0 {
+			state.Copied[s] = true
+			return saveReshardState(cfg, state)
+		}
+
+		batches := make([]*bleve.Batch, len(targets))
+		for _, doc := range docs {
+			n := shard.For(doc.ID, len(targets))
+			if batches[n] == nil {
+				batches[n] = targets[n].NewBatch()
+			}
+			if err := batches[n].Index(doc.ID, doc.Data); err != nil {
+				return fmt.Errorf("failed to add document %s to shard %d: %w", doc.ID, n, err)
+			}
+		}
+		for n, batch := range batches {
+			if batch == nil {
+				continue
+			}
+			if err := targets[n].Batch(batch); err != nil {
+				return fmt.Errorf("failed to write shard %d: %w", n, err)
+			}
+		}
+		state.Checkpoints[s] = docs[len(docs)-1].ID
+		state.Documents += uint64(len(docs))
+		if err := saveReshardState(cfg, state); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Copied documents of source shard", "shard", s, "documents", len(docs), "source_documents", total, "total", state.Documents)
+
+		if cfg.Rate > 0 {
+			budget := time.Duration(float64(len(docs)) / cfg.Rate * float64(time.Second))
+			if wait := budget - time.Since(start); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// replaceRoutingTable writes the routing table of the new shards, with the version
+// following the current table's and no longer mirroring writes.
+func replaceRoutingTable(cfg ReshardConfig) error {
+	version := 1
+	current, err := shard.ReadRoutingTable(cfg.RoutingTable)
+	if err == nil {
+		version = current.Version + 1
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	table := &shard.RoutingTable{Version: version, Shards: cfg.Indexers, UpdatedAt: time.Now()}
+	if err := shard.WriteRoutingTable(cfg.RoutingTable, table); err != nil {
+		return err
+	}
+	slog.Info("Routing table replaced", "routing_table", cfg.RoutingTable, "version", version, "shards", len(cfg.Indexers))
+	return nil
+}
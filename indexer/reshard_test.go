@@ -0,0 +1,297 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"common/shard"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// newSourceShard creates a Bleve index at path holding the documents doc-<from>..doc-<to-1>.
+func newSourceShard(t *testing.T, path string, from, to int) {
+	t.Helper()
+	index, err := bleve.New(path, CreateDefaultIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	batch := index.NewBatch()
+	for i := from; i < to; i++ {
+		batch.Index(fmt.Sprintf("doc-%02d", i), map[string]interface{}{"title": fmt.Sprintf("title %d", i)})
+	}
+	if err := index.Batch(batch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveReshardIndexer serves the endpoints a resharding calls on ix, calling snapshotted
+// after every snapshot, and returns its URL.
+func serveReshardIndexer(t *testing.T, ix *Indexer, snapshotted func()) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reshard/stage", func(w http.ResponseWriter, r *http.Request) {
+		if err := ix.StageShard(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/reshard/load", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Index string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if err := ix.LoadShard(req.Index); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Name string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if _, err := ix.Snapshot(req.Name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if snapshotted != nil {
+			snapshotted()
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// newShardIndexers returns the indexers of n new shards, loading from storageDir.
+func newShardIndexers(t *testing.T, dir, storageDir string, n int) ([]*Indexer, []string) {
+	t.Helper()
+	indexers := make([]*Indexer, n)
+	urls := make([]string, n)
+	for i := range indexers {
+		storage, err := NewLocalFileStorage(storageDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storage.SetCollection(fmt.Sprintf("products-shard-%d", i))
+		if indexers[i], err = NewIndexer(filepath.Join(dir, fmt.Sprintf("indexer-%d", i), "index"), storage); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { indexers[i].Close() })
+		urls[i] = serveReshardIndexer(t, indexers[i], nil)
+	}
+	return indexers, urls
+}
+
+func TestReshard(t *testing.T) {
+	dir := t.TempDir()
+	sources := []string{filepath.Join(dir, "old-0"), filepath.Join(dir, "old-1")}
+	newSourceShard(t, sources[0], 0, 25)
+	newSourceShard(t, sources[1], 25, 40)
+	storageDir := filepath.Join(dir, "segments")
+	storages := make([]IndexSegmentStorage, 3)
+	for n := range storages {
+		s, err := NewLocalFileStorage(storageDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetCollection(fmt.Sprintf("products-shard-%d", n))
+		storages[n] = s
+	}
+	routing := filepath.Join(dir, "routing.json")
+	if err := shard.WriteRoutingTable(routing, &shard.RoutingTable{Version: 4, Shards: shard.Table{"http://old-0", "http://old-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	indexers, urls := newShardIndexers(t, dir, storageDir, 3)
+	cfg := ReshardConfig{
+		Sources:      sources,
+		TargetDir:    filepath.Join(dir, "new"),
+		Shards:       3,
+		BatchSize:    10,
+		Storages:     storages,
+		RoutingTable: routing,
+		Indexers:     urls,
+	}
+
+	// A cancelled resharding stops before copying and resumes from its state.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Reshard(ctx, cfg); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancelled resharding to fail, got %v", err)
+	}
+	state, err := Reshard(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Reshard returned an error: %v", err)
+	}
+	if state.Documents != 40 || !state.Routed || !state.Loaded[2] {
+		t.Errorf("Expected 40 documents copied, loaded and routed, got %+v", state)
+	}
+
+	total := uint64(0)
+	for n := 0; n < 3; n++ {
+		index, err := bleve.Open(reshardTargetPath(cfg, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, _ := index.DocCount()
+		total += count
+		for i := 0; i < 40; i++ {
+			id := fmt.Sprintf("doc-%02d", i)
+			doc, _ := index.Document(id)
+			if (doc != nil) != (shard.For(id, 3) == n) {
+				t.Errorf("Document %s misplaced on shard %d", id, n)
+			}
+		}
+		index.Close()
+		if entries, _ := os.ReadDir(filepath.Join(storageDir, fmt.Sprintf("products-shard-%d", n))); len(entries) == 0 {
+			t.Errorf("Expected shard %d to be uploaded", n)
+		}
+	}
+	if total != 40 {
+		t.Errorf("Expected 40 documents in the new shards, got %d", total)
+	}
+	loaded := uint64(0)
+	for _, ix := range indexers {
+		count, _ := ix.index.DocCount()
+		loaded += count
+	}
+	if loaded != 40 {
+		t.Errorf("Expected the new indexers to serve the 40 documents, got %d", loaded)
+	}
+	table, err := shard.ReadRoutingTable(routing)
+	if err != nil || table.Version != 5 || len(table.Shards) != 3 {
+		t.Errorf("Expected routing table version 5 with 3 shards, got %+v %v", table, err)
+	}
+
+	// A completed resharding is not redone; another one needs a new target directory.
+	if state, err := Reshard(context.Background(), cfg); err != nil || state.Documents != 40 {
+		t.Errorf("Expected the completed resharding to be left alone, got %+v %v", state, err)
+	}
+	cfg.Shards, cfg.Storages, cfg.RoutingTable, cfg.Indexers = 4, nil, "", nil
+	if _, err := Reshard(context.Background(), cfg); !errors.Is(err, ErrReshardMismatch) {
+		t.Errorf("Expected ErrReshardMismatch, got %v", err)
+	}
+}
+
+func TestReshard_SourceInUse(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "old-0")
+	newSourceShard(t, source, 0, 5)
+	live, err := bleve.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := Reshard(context.Background(), ReshardConfig{Sources: []string{source}, TargetDir: filepath.Join(dir, "new"), Shards: 2})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrSourceInUse) {
+			t.Errorf("Expected ErrSourceInUse, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Reshard blocked on the lock of the live source index")
+	}
+}
+
+func TestReshard_Online(t *testing.T) {
+	dir := t.TempDir()
+	storageDir := filepath.Join(dir, "segments")
+	indexers, urls := newShardIndexers(t, dir, storageDir, 2)
+
+	// The single live source shard holds doc-00..doc-19. Once it's snapshotted, the
+	// router deletes doc-00 and indexes doc-new, mirroring both to the new shards.
+	sourceStorage, err := NewLocalFileStorage(storageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceStorage.SetCollection("products-0")
+	source, err := NewIndexer(filepath.Join(dir, "source", "index"), sourceStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	docs := make(map[string]interface{})
+	for i := 0; i < 20; i++ {
+		docs[fmt.Sprintf("doc-%02d", i)] = map[string]interface{}{"title": fmt.Sprintf("title %d", i)}
+	}
+	if _, err := source.BulkIndexDocuments(docs); err != nil {
+		t.Fatal(err)
+	}
+	route := func(id string) *Indexer { return indexers[shard.For(id, 2)] }
+	sourceURL := serveReshardIndexer(t, source, func() {
+		for _, ix := range []*Indexer{source, route("doc-00")} {
+			if err := ix.DeleteDocument("doc-00"); err != nil {
+				t.Error(err)
+			}
+		}
+		for _, ix := range []*Indexer{source, route("doc-new")} {
+			if err := ix.IndexDocument("doc-new", map[string]interface{}{"title": "new"}); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	storages := make([]IndexSegmentStorage, 2)
+	for n := range storages {
+		s, _ := NewLocalFileStorage(storageDir)
+		s.SetCollection(fmt.Sprintf("products-shard-%d", n))
+		storages[n] = s
+	}
+	routing := filepath.Join(dir, "routing.json")
+	if err := shard.WriteRoutingTable(routing, &shard.RoutingTable{Version: 1, Shards: shard.Table{sourceURL}}); err != nil {
+		t.Fatal(err)
+	}
+	shardMap := filepath.Join(dir, "products.json")
+	cfg := ReshardConfig{
+		SourceIndexers: []string{sourceURL},
+		SourceStorages: []SnapshotStorage{sourceStorage},
+		SettleDelay:    time.Millisecond,
+		TargetDir:      filepath.Join(dir, "new"),
+		Shards:         2,
+		Storages:       storages,
+		RoutingTable:   routing,
+		Indexers:       urls,
+		ShardMap:       shardMap,
+		Collection:     "products",
+		Searchers:      [][]string{{"http://s-0"}, {"http://s-1"}},
+	}
+	state, err := Reshard(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Reshard returned an error: %v", err)
+	}
+	if !state.Staged || !state.Mirrored || !state.Snapshotted[0] || !state.Mapped {
+		t.Errorf("Expected every step to be done, got %+v", state)
+	}
+
+	for n, ix := range indexers {
+		if ix.Staged() {
+			t.Errorf("Expected new shard %d to stop journaling once loaded", n)
+		}
+		for id := range docs {
+			doc, _ := ix.index.Document(id)
+			want := shard.For(id, 2) == n && id != "doc-00"
+			if (doc != nil) != want {
+				t.Errorf("Expected document %s on new shard %d: %v, got %v", id, n, want, doc != nil)
+			}
+		}
+	}
+	if doc, _ := route("doc-new").index.Document("doc-new"); doc == nil {
+		t.Error("Expected the write made during the copy on its new shard")
+	}
+	table, err := shard.ReadRoutingTable(routing)
+	if err != nil || table.Version != 3 || len(table.Shards) != 2 || table.Next != nil {
+		t.Errorf("Expected routing table version 3 cut over to the 2 new shards, got %+v %v", table, err)
+	}
+	m, err := shard.ReadShardMap(shardMap)
+	if err != nil || m.Version != 1 || m.Collection != "products" || len(m.Shards) != 2 {
+		t.Errorf("Expected the shard map of the new shards, got %+v %v", m, err)
+	}
+}
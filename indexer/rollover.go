@@ -0,0 +1,442 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// AliasFileName is the name of the alias of the physical indexes, stored next to their
+// segments.
+const AliasFileName = "alias.json"
+
+var (
+	// ErrInvalidRolloverPolicy is returned for rollover policies with negative thresholds.
+	ErrInvalidRolloverPolicy = errors.New("invalid rollover policy")
+	// ErrReadOnlyDocument is returned for updates and deletes of documents held by a
+	// read-only index of the alias.
+	ErrReadOnlyDocument = errors.New("document is held by a read-only index")
+	// ErrAliasUnsupported is returned by wrapping storages whose wrapped storage doesn't
+	// publish aliases.
+	ErrAliasUnsupported = errors.New("storage does not support index aliases")
+)
+
+// RolloverPolicy sets when the indexer rolls its active physical index over: once it
+// reaches any threshold, checked after every commit, the indexer creates a new empty
+// index with the same mapping, writes go to it, and the previous one is kept read-only.
+// Rollovers suit append-only data such as logs and events, whose indexes stop changing
+// once full; documents of rolled-over indexes can't be updated or deleted. The zero
+// policy leaves rollovers to Rollover callers.
+type RolloverPolicy struct {
+	MaxDocs  int64         `yaml:"max_docs" env:"ROLLOVER_MAX_DOCS" flag:"rollover-max-docs" usage:"Roll over to a new index once the active one holds this many documents; 0 disables"`
+	MaxBytes int64         `yaml:"max_bytes" env:"ROLLOVER_MAX_BYTES" flag:"rollover-max-bytes" usage:"Roll over to a new index once the active one takes this many bytes on disk; 0 disables"`
+	MaxAge   time.Duration `yaml:"max_age" env:"ROLLOVER_MAX_AGE" flag:"rollover-max-age" usage:"Roll over to a new index once the active one is this old, e.g. 168h; 0 disables"`
+}
+
+// rolloverPolicyJSON is the JSON encoding of RolloverPolicy, with a readable age.
+type rolloverPolicyJSON struct {
+	MaxDocs  int64  `json:"max_docs"`
+	MaxBytes int64  `json:"max_bytes"`
+	MaxAge   string `json:"max_age"`
+}
+
+// MarshalJSON encodes the maximum age as a duration string such as "168h0m0s".
+func (p RolloverPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rolloverPolicyJSON{MaxDocs: p.MaxDocs, MaxBytes: p.MaxBytes, MaxAge: p.MaxAge.String()})
+}
+
+// Validate checks the thresholds of the policy.
+func (p RolloverPolicy) Validate() error {
+	if p.MaxDocs < 0 || p.MaxBytes < 0 || p.MaxAge < 0 {
+		return fmt.Errorf("%w: thresholds must not be negative", ErrInvalidRolloverPolicy)
+	}
+	return nil
+}
+
+// enabled reports whether the index is rolled over automatically, i.e. once it reaches a
+// document count, a size or an age.
+func (p RolloverPolicy) enabled() bool {
+	return p.MaxDocs > 0 || p.MaxBytes > 0 || p.MaxAge > 0
+}
+
+// trigger returns why an index holding docs documents in size bytes, created at
+// createdAt, calls for a rollover, "" if it doesn't. Empty indexes are never rolled over.
+func (p RolloverPolicy) trigger(docs uint64, size int64, createdAt, now time.Time) string {
+	switch {
+	case docs == 0:
+		return ""
+	case p.MaxDocs > 0 && docs >= uint64(p.MaxDocs):
+		return fmt.Sprintf("%d documents, at least %d", docs, p.MaxDocs)
+	case p.MaxBytes > 0 && size >= p.MaxBytes:
+		return fmt.Sprintf("%d bytes, at least %d", size, p.MaxBytes)
+	case p.MaxAge > 0 && now.Sub(createdAt) >= p.MaxAge:
+		return fmt.Sprintf("%s old, at least %s", now.Sub(createdAt).Round(time.Second), p.MaxAge)
+	default:
+		return ""
+	}
+}
+
+// IndexAlias maps the name of the index given to NewIndexer to its physical indexes: the
+// write index receiving writes and the read-only indexes it rolled over from. Every
+// physical index is uploaded as a segment of its own name.
+type IndexAlias struct {
+	Name       string          `json:"name"`
+	WriteIndex string          `json:"write_index"`
+	Indices    []PhysicalIndex `json:"indices"` // Oldest first
+}
+
+// PhysicalIndex describes an index of an alias.
+type PhysicalIndex struct {
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ReadOnly     bool       `json:"read_only"`
+	RolledOverAt *time.Time `json:"rolled_over_at,omitempty"`
+	Reason       string     `json:"reason,omitempty"`    // Why it was rolled over
+	DocCount     uint64     `json:"doc_count,omitempty"` // Documents when it was rolled over
+}
+
+// AliasStorage is implemented by IndexSegmentStorage backends that publish the alias next
+// to the segments, for readers to tell the write index from the read-only ones.
+type AliasStorage interface {
+	UploadAlias(alias *IndexAlias) error
+}
+
+// RolloverStatus reports the rollover policy and the physical indexes of the alias, nil
+// until the first rollover policy or rollover.
+type RolloverStatus struct {
+	Policy RolloverPolicy `json:"policy"`
+	Alias  *IndexAlias    `json:"alias,omitempty"`
+}
+
+// aliasPath returns the file persisting the alias of the index at basePath. It is kept
+// next to the index directories, like the write-ahead log.
+func aliasPath(basePath string) string {
+	return filepath.Join(filepath.Dir(basePath), "."+filepath.Base(basePath)+".alias.json")
+}
+
+// loadAlias returns the alias of the index at basePath, or nil if it was never rolled over.
+func loadAlias(basePath string) (*IndexAlias, error) {
+	data, err := os.ReadFile(aliasPath(basePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index alias of %s: %w", basePath, err)
+	}
+	var alias IndexAlias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal index alias of %s: %w", basePath, err)
+	}
+	if alias.WriteIndex == "" || filepath.Base(alias.WriteIndex) != alias.WriteIndex {
+		return nil, fmt.Errorf("index alias of %s has an invalid write index %q", basePath, alias.WriteIndex)
+	}
+	return &alias, nil
+}
+
+// saveAlias persists the alias of the index at basePath, replacing the previous file
+// atomically so a crash leaves either alias.
+func saveAlias(basePath string, alias *IndexAlias) error {
+	data, err := json.MarshalIndent(alias, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index alias: %w", err)
+	}
+	path := aliasPath(basePath)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write index alias: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to save index alias: %w", err)
+	}
+	return nil
+}
+
+// openReadOnlyIndexes opens the read-only indexes of alias, next to basePath, for writes
+// to tell whether they hold their documents. Indexes missing on disk are skipped.
+func openReadOnlyIndexes(basePath string, alias *IndexAlias) ([]bleve.Index, error) {
+	if alias == nil {
+		return nil, nil
+	}
+	var indexes []bleve.Index
+	for _, physical := range alias.Indices {
+		if !physical.ReadOnly {
+			continue
+		}
+		path := filepath.Join(filepath.Dir(basePath), physical.Name)
+		index, err := openReadOnly(path)
+		if err == bleve.ErrorIndexPathDoesNotExist {
+			slog.Warn("Read-only index is missing, writes to its documents won't be rejected", "path", path)
+			continue
+		}
+		if err != nil {
+			for _, index := range indexes {
+				index.Close()
+			}
+			return nil, fmt.Errorf("could not open read-only index at %s: %w", path, err)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// openReadOnly opens the index at path without write access.
+func openReadOnly(path string) (bleve.Index, error) {
+	return bleve.OpenUsing(path, map[string]interface{}{"read_only": true})
+}
+
+// readOnlyDocuments returns the IDs of the documents written by group that a read-only
+// index of the alias holds. Bulk deletes, only made by the expiry sweep of the write
+// index, aren't looked up. Callers must hold i.mu.
+func (i *Indexer) readOnlyDocuments(group []*writeOp) (map[string]bool, error) {
+	if len(i.readOnly) == 0 {
+		return nil, nil
+	}
+	var ids []string
+	for _, op := range group {
+		if op.record.Op != walOpBulkDelete {
+			ids = append(ids, op.record.ids()...)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	held := make(map[string]bool)
+	for _, index := range i.readOnly {
+		req := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery(ids), len(ids), 0, false)
+		result, err := index.Search(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up documents of read-only indexes: %w", err)
+		}
+		for _, hit := range result.Hits {
+			held[hit.ID] = true
+		}
+	}
+	return held, nil
+}
+
+// rejectReadOnly fails an index or delete of a document held by a read-only index, which
+// would otherwise add a duplicate to the write index or do nothing, and leaves such
+// documents out of a bulk write, setting their errors in op.failed.
+func rejectReadOnly(op *writeOp, readOnly map[string]bool) error {
+	switch op.record.Op {
+	case walOpIndex, walOpDelete:
+		if readOnly[op.record.ID] {
+			return fmt.Errorf("%w: document %s was rolled over and can't be changed", ErrReadOnlyDocument, op.record.ID)
+		}
+	case walOpBulk:
+		docs := make(map[string]interface{}, len(op.record.Docs))
+		for id, data := range op.record.Docs {
+			if !readOnly[id] {
+				docs[id] = data
+				continue
+			}
+			if op.failed == nil {
+				op.failed = make(map[string]error)
+			}
+			op.failed[id] = fmt.Errorf("%w: document %s was rolled over and can't be changed", ErrReadOnlyDocument, id)
+		}
+		op.record.Docs = docs
+	}
+	return nil
+}
+
+// clone returns a deep copy of the alias.
+func (a *IndexAlias) clone() *IndexAlias {
+	c := *a
+	c.Indices = append([]PhysicalIndex(nil), a.Indices...)
+	return &c
+}
+
+// SetRolloverPolicy replaces the rollover policy. The active index is checked against it
+// after every commit. An enabling policy starts the alias with the current index as its
+// write index.
+func (i *Indexer) SetRolloverPolicy(policy RolloverPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if policy.enabled() {
+		if err := i.startAlias(); err != nil {
+			return err
+		}
+		slog.Info("Rollover policy set", "max_docs", policy.MaxDocs, "max_bytes", policy.MaxBytes, "max_age", policy.MaxAge)
+	}
+	i.rolloverPolicy = policy
+	return nil
+}
+
+// startAlias creates the alias of the index, with the current index as its write index,
+// unless there is one. Callers must hold i.mu.
+func (i *Indexer) startAlias() error {
+	if i.alias != nil {
+		return nil
+	}
+	alias := &IndexAlias{
+		Name:       filepath.Base(i.basePath),
+		WriteIndex: filepath.Base(i.indexPath),
+		Indices:    []PhysicalIndex{{Name: filepath.Base(i.indexPath), CreatedAt: time.Now().UTC()}},
+	}
+	if err := saveAlias(i.basePath, alias); err != nil {
+		return err
+	}
+	i.alias = alias
+	return nil
+}
+
+// RolloverStatus returns the rollover policy and the alias of the index.
+func (i *Indexer) RolloverStatus() RolloverStatus {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	status := RolloverStatus{Policy: i.rolloverPolicy}
+	if i.alias != nil {
+		status.Alias = i.alias.clone()
+	}
+	return status
+}
+
+// Rollover commits and uploads the active index, then rolls it over regardless of the
+// policy and returns the updated alias. It fails with ErrReindexInProgress while a
+// reindex job runs.
+func (i *Indexer) Rollover() (*IndexAlias, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := i.commitSegment(); err != nil {
+		return nil, err
+	}
+	return i.rollover("requested")
+}
+
+// maybeRollover rolls the just committed index over if the policy calls for it. Callers
+// must hold i.mu.
+func (i *Indexer) maybeRollover() {
+	if !i.rolloverPolicy.enabled() || i.alias == nil {
+		return
+	}
+	docs, err := i.index.DocCount()
+	if err != nil {
+		slog.Error("Failed to count documents for rollover", "error", err)
+		return
+	}
+	var size int64
+	if i.rolloverPolicy.MaxBytes > 0 {
+		if size, err = dirSize(i.indexPath); err != nil {
+			slog.Error("Failed to compute index size for rollover", "path", i.indexPath, "error", err)
+			return
+		}
+	}
+	createdAt := i.alias.Indices[len(i.alias.Indices)-1].CreatedAt
+	reason := i.rolloverPolicy.trigger(docs, size, createdAt, time.Now())
+	if reason == "" {
+		return
+	}
+	if _, err := i.rollover(reason); err != nil {
+		slog.Error("Failed to roll the index over", "reason", reason, "error", err)
+	}
+}
+
+// rollover makes a new empty index with the mapping of the committed active index the
+// write index of the alias, and reopens the previous one read-only, marked so. The new index
+// is uploaded and announced like any commit, so searchers pick it up as a new segment.
+// Callers must hold i.mu.
+func (i *Indexer) rollover(reason string) (alias *IndexAlias, err error) {
+	defer func() { recordOperation("rollover", err) }()
+	if i.reindex != nil && i.reindex.Status == JobRunning {
+		return nil, fmt.Errorf("%w: job %s", ErrReindexInProgress, i.reindex.ID)
+	}
+	if err := i.startAlias(); err != nil {
+		return nil, err
+	}
+	docs, err := i.index.DocCount()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count documents of the rolled-over index: %w", err)
+	}
+	name := fmt.Sprintf("%s-%06d", i.alias.Name, len(i.alias.Indices))
+	path := filepath.Join(filepath.Dir(i.basePath), name)
+	index, err := bleve.New(path, i.index.Mapping())
+	if err != nil {
+		return nil, fmt.Errorf("could not create new bleve index at %s: %w", path, err)
+	}
+
+	now := time.Now().UTC()
+	next := i.alias.clone()
+	previous := &next.Indices[len(next.Indices)-1]
+	previous.ReadOnly, previous.RolledOverAt, previous.Reason, previous.DocCount = true, &now, reason, docs
+	next.Indices = append(next.Indices, PhysicalIndex{Name: name, CreatedAt: now})
+	next.WriteIndex = name
+	if err := saveAlias(i.basePath, next); err != nil {
+		index.Close()
+		os.RemoveAll(path)
+		return nil, err
+	}
+
+	if err := i.index.Close(); err != nil {
+		slog.Error("Failed to close rolled-over index", "path", i.indexPath, "error", err)
+	}
+	if readOnly, err := openReadOnly(i.indexPath); err != nil {
+		slog.Error("Failed to open rolled-over index read-only, writes to its documents won't be rejected", "path", i.indexPath, "error", err)
+	} else {
+		i.readOnly = append(i.readOnly, readOnly)
+	}
+	previousPath := i.indexPath
+	i.index, i.indexPath, i.alias = index, path, next
+	slog.Info("Index rolled over", "reason", reason, "path", path, "previous_path", previousPath, "documents", docs)
+
+	// The previous index is committed, so the new one is uploaded empty; a failed upload
+	// is retried by the next commit.
+	if err := i.commitSegment(); err != nil {
+		slog.Error("Failed to upload the new index after rollover", "path", path, "error", err)
+	}
+	return next.clone(), nil
+}
+
+// uploadAlias publishes the alias next to the segments, if the storage supports it.
+// Callers must hold i.mu.
+func (i *Indexer) uploadAlias() {
+	aliases, ok := i.storage.(AliasStorage)
+	if !ok || i.alias == nil {
+		return
+	}
+	if err := aliases.UploadAlias(i.alias); err != nil {
+		slog.Error("Failed to upload index alias", "error", err)
+	}
+}
+
+// UploadAlias writes the alias to storageDir/[tenants/<tenant>/][collection/]alias.json,
+// replacing the previous one atomically.
+func (s *LocalFileStorage) UploadAlias(alias *IndexAlias) error {
+	data, err := json.MarshalIndent(alias, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index alias: %w", err)
+	}
+	if err := os.MkdirAll(s.baseDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create storage directory %s: %w", s.baseDir(), err)
+	}
+	path := filepath.Join(s.baseDir(), AliasFileName)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write index alias: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to upload index alias: %w", err)
+	}
+	return nil
+}
+
+// UploadAlias uploads the alias under the [tenants/<tenant>/][collection/]alias.json key.
+func (s *S3Storage) UploadAlias(alias *IndexAlias) error {
+	data, err := json.MarshalIndent(alias, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index alias: %w", err)
+	}
+	if err := s.uploadFileWithRetry(AliasFileName, s.keyPrefix()+AliasFileName, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to upload index alias: %w", err)
+	}
+	return nil
+}
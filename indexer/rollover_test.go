@@ -0,0 +1,156 @@
+package indexer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRolloverPolicy_Validate(t *testing.T) {
+	if err := (RolloverPolicy{MaxDocs: 1000, MaxBytes: 1 << 30, MaxAge: 24 * time.Hour}).Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, p := range []RolloverPolicy{{MaxDocs: -1}, {MaxBytes: -1}, {MaxAge: -time.Hour}} {
+		if err := p.Validate(); !errors.Is(err, ErrInvalidRolloverPolicy) {
+			t.Errorf("Expected ErrInvalidRolloverPolicy for %+v, got %v", p, err)
+		}
+	}
+}
+
+func TestRolloverPolicy_Trigger(t *testing.T) {
+	p := RolloverPolicy{MaxDocs: 100, MaxBytes: 1000, MaxAge: time.Hour}
+	now := time.Now()
+	cases := []struct {
+		docs    uint64
+		size    int64
+		age     time.Duration
+		trigger bool
+	}{
+		{docs: 10, size: 100, age: time.Minute, trigger: false},
+		{docs: 100, size: 100, age: time.Minute, trigger: true},
+		{docs: 10, size: 1000, age: time.Minute, trigger: true},
+		{docs: 10, size: 100, age: 2 * time.Hour, trigger: true},
+		{docs: 0, size: 5000, age: 2 * time.Hour, trigger: false}, // Empty indexes stay active
+	}
+	for _, c := range cases {
+		if got := p.trigger(c.docs, c.size, now.Add(-c.age), now); (got != "") != c.trigger {
+			t.Errorf("Expected trigger %t for %+v, got %q", c.trigger, c, got)
+		}
+	}
+}
+
+func TestIndexer_Rollover(t *testing.T) {
+	tempDir := t.TempDir()
+	storageDir := filepath.Join(tempDir, "segments")
+	storage, err := NewLocalFileStorage(storageDir)
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	indexPath := filepath.Join(tempDir, "index")
+	idx, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	if err := idx.SetRolloverPolicy(RolloverPolicy{MaxDocs: 2}); err != nil {
+		t.Fatalf("SetRolloverPolicy returned an error: %v", err)
+	}
+	if _, err := idx.EnableWAL(); err != nil {
+		t.Fatalf("EnableWAL returned an error: %v", err)
+	}
+
+	// One document doesn't fill the index; the second one rolls it over on commit.
+	if err := idx.IndexDocument("a", map[string]interface{}{"title": "Boots"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if err := idx.CommitAndUpload(); err != nil {
+		t.Fatalf("CommitAndUpload returned an error: %v", err)
+	}
+	if status := idx.RolloverStatus(); len(status.Alias.Indices) != 1 {
+		t.Fatalf("Expected no rollover below the threshold, got %+v", status.Alias)
+	}
+	if err := idx.IndexDocument("b", map[string]interface{}{"title": "Gloves"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if err := idx.CommitAndUpload(); err != nil {
+		t.Fatalf("CommitAndUpload returned an error: %v", err)
+	}
+
+	alias := idx.RolloverStatus().Alias
+	if alias == nil || alias.WriteIndex != "index-000001" || len(alias.Indices) != 2 {
+		t.Fatalf("Expected the alias to write to index-000001, got %+v", alias)
+	}
+	if old := alias.Indices[0]; old.Name != "index" || !old.ReadOnly || old.RolledOverAt == nil || old.DocCount != 2 {
+		t.Errorf("Expected the rolled-over index to be read-only with 2 documents, got %+v", old)
+	}
+	// Both indexes are uploaded as segments, next to the alias.
+	for _, name := range []string{"index", "index-000001"} {
+		if _, err := ReadSegmentManifest(filepath.Join(storageDir, name)); err != nil {
+			t.Errorf("Expected segment %s to be uploaded: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(storageDir, AliasFileName)); err != nil {
+		t.Errorf("Expected the alias to be uploaded: %v", err)
+	}
+
+	// Writes go to the new index, logged in the same write-ahead log.
+	if err := idx.IndexDocument("c", map[string]interface{}{"title": "Scarf"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if docs, _ := idx.index.DocCount(); docs != 1 {
+		t.Errorf("Expected the new index to hold the new document alone, got %d documents", docs)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	// A restart opens the write index and replays the writes into it.
+	idx, err = NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to reopen indexer: %v", err)
+	}
+	defer idx.Close()
+	if replayed, err := idx.EnableWAL(); err != nil || replayed != 1 {
+		t.Fatalf("Expected 1 replayed write, got %d (%v)", replayed, err)
+	}
+	if idx.indexPath != filepath.Join(tempDir, "index-000001") {
+		t.Errorf("Expected the write index to be opened, got %s", idx.indexPath)
+	}
+	if _, err := idx.GetDocument("c", nil); err != nil {
+		t.Errorf("Expected the replayed document in the write index, got %v", err)
+	}
+
+	// Documents of the read-only index can't be updated or deleted.
+	if err := idx.IndexDocument("a", map[string]interface{}{"title": "Boots v2"}); !errors.Is(err, ErrReadOnlyDocument) {
+		t.Errorf("Expected ErrReadOnlyDocument updating a rolled-over document, got %v", err)
+	}
+	if err := idx.DeleteDocument("b"); !errors.Is(err, ErrReadOnlyDocument) {
+		t.Errorf("Expected ErrReadOnlyDocument deleting a rolled-over document, got %v", err)
+	}
+	resp, err := idx.BulkIndexDocuments(map[string]interface{}{
+		"b": map[string]interface{}{"title": "Gloves v2"},
+		"d": map[string]interface{}{"title": "Hat"},
+	})
+	if err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+	if failures := resp.Failures(); len(failures) != 1 || failures["b"] == nil {
+		t.Errorf("Expected the rolled-over document alone to fail, got %v", failures)
+	}
+	if docs, _ := idx.index.DocCount(); docs != 2 {
+		t.Errorf("Expected the write index to hold c and d, got %d documents", docs)
+	}
+
+	// A requested rollover rolls the index over regardless of the policy.
+	alias, err = idx.Rollover()
+	if err != nil {
+		t.Fatalf("Rollover returned an error: %v", err)
+	}
+	if alias.WriteIndex != "index-000002" || alias.Indices[1].Reason != "requested" {
+		t.Errorf("Expected a requested rollover to index-000002, got %+v", alias)
+	}
+	if err := idx.DeleteDocument("c"); !errors.Is(err, ErrReadOnlyDocument) {
+		t.Errorf("Expected ErrReadOnlyDocument deleting a document rolled over at runtime, got %v", err)
+	}
+}
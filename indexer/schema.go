@@ -0,0 +1,223 @@
+package indexer
+
+import (
+	"fmt"
+	"sort"
+
+	"common/schema"
+	"common/simhash"
+	_ "indexer/analyzers" // Custom analyzers a schema can name
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/mapping"
+
+	// Analyzers a schema can name besides the built-in ones.
+	_ "github.com/blevesearch/bleve/v2/analysis/analyzer/web"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/cjk"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/de"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/en"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/es"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/fr"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/it"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/nl"
+	_ "github.com/blevesearch/bleve/v2/analysis/lang/pt"
+)
+
+// schemaDefaultType is the document type the fields of a schema are mapped on, the
+// default type of mapping.json.
+const schemaDefaultType = "document"
+
+// MappingFromSchema generates the index mapping of an index schema. String fields are
+// keywords, text fields use their analyzer (standard by default), and numbers, booleans
+// and dates get the matching field type, all keeping the indexed and stored flags of
+// the schema; vectors are stored but not indexed. Fields missing from the schema are
+// mapped dynamically unless the schema disables it. The fields the indexer sets itself
+// (simhash.Field, VersionField, ExpiresAtField, ParentField and NestedPathField) are
+// always mapped and stored. The mapping is validated, so an unknown analyzer fails with
+// ErrInvalidMapping.
+func MappingFromSchema(s schema.IndexSchema) (*mapping.IndexMappingImpl, error) {
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMapping, err)
+	}
+	docMapping := bleve.NewDocumentMapping()
+	docMapping.Dynamic = s.Dynamic()
+	for _, field := range s.Fields {
+		docMapping.AddFieldMappingsAt(field.Name, schemaFieldMapping(s, field))
+	}
+	for name, fm := range map[string]*mapping.FieldMapping{
+		simhash.Field:   bleve.NewKeywordFieldMapping(),
+		VersionField:    bleve.NewNumericFieldMapping(),
+		ExpiresAtField:  bleve.NewDateTimeFieldMapping(),
+		ParentField:     bleve.NewKeywordFieldMapping(),
+		NestedPathField: bleve.NewKeywordFieldMapping(),
+	} {
+		if _, ok := docMapping.Properties[name]; !ok {
+			fm.Store = true
+			fm.IncludeInAll = false
+			docMapping.AddFieldMappingsAt(name, fm)
+		}
+	}
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.AddDocumentMapping(schemaDefaultType, docMapping)
+	indexMapping.DefaultType = schemaDefaultType
+	indexMapping.DefaultMapping.Dynamic = s.Dynamic()
+	indexMapping.IndexDynamic = s.Dynamic()
+	if analyzer := s.Options[schema.OptionAnalyzer]; analyzer != "" {
+		indexMapping.DefaultAnalyzer = analyzer
+	}
+	if err := indexMapping.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: schema '%s': %v", ErrInvalidMapping, s.Name, err)
+	}
+	return indexMapping, nil
+}
+
+// schemaFieldMapping returns the mapping of a schema field.
+func schemaFieldMapping(s schema.IndexSchema, field schema.Field) *mapping.FieldMapping {
+	var fm *mapping.FieldMapping
+	switch field.Type {
+	case schema.TypeString:
+		fm = bleve.NewKeywordFieldMapping()
+	case schema.TypeText:
+		fm = bleve.NewTextFieldMapping()
+		fm.Analyzer = schemaAnalyzer(s, field)
+		fm.IncludeTermVectors = field.Stored // Needed to highlight the field
+	case schema.TypeInteger, schema.TypeFloat:
+		fm = bleve.NewNumericFieldMapping()
+	case schema.TypeBoolean:
+		fm = bleve.NewBooleanFieldMapping()
+	case schema.TypeDatetime:
+		fm = bleve.NewDateTimeFieldMapping()
+	case schema.TypeVector:
+		// Vectors are stored for the Searchers' kNN indexes, not indexed as numbers.
+		fm = bleve.NewNumericFieldMapping()
+		fm.Index = false
+		fm.Store = true
+		fm.IncludeInAll = false
+		fm.DocValues = false
+		return fm
+	}
+	fm.Index = field.Indexed
+	fm.Store = field.Stored
+	fm.IncludeInAll = field.Indexed
+	return fm
+}
+
+// schemaAnalyzer returns the analyzer of a text field.
+func schemaAnalyzer(s schema.IndexSchema, field schema.Field) string {
+	if analyzer := s.Analyzer(field); analyzer != "" {
+		return analyzer
+	}
+	return standard.Name
+}
+
+// SchemaMismatch is a difference between an index schema and an index mapping.
+type SchemaMismatch struct {
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+}
+
+func (m SchemaMismatch) String() string {
+	return fmt.Sprintf("%s: %s", m.Field, m.Problem)
+}
+
+// CompareMapping reports where an index mapping, e.g. the one of an existing index or
+// mapping.json, differs from the mapping its schema generates: fields it doesn't map or
+// maps with another type, analyzer or flags, and fields it maps that the schema lacks.
+// Mismatches are sorted by field.
+func CompareMapping(s schema.IndexSchema, m mapping.IndexMapping) ([]SchemaMismatch, error) {
+	impl, ok := m.(*mapping.IndexMappingImpl)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported mapping type %T", ErrInvalidMapping, m)
+	}
+	want, err := MappingFromSchema(s)
+	if err != nil {
+		return nil, err
+	}
+	wantFields := mappedFields(want)
+	gotFields := mappedFields(impl)
+
+	var mismatches []SchemaMismatch
+	report := func(field, format string, args ...interface{}) {
+		mismatches = append(mismatches, SchemaMismatch{Field: field, Problem: fmt.Sprintf(format, args...)})
+	}
+	for _, field := range s.Fields {
+		w := wantFields[field.Name]
+		g, ok := gotFields[field.Name]
+		if !ok {
+			report(field.Name, "not mapped, expected %s", describeField(w))
+			continue
+		}
+		if fieldKind(g) != fieldKind(w) {
+			report(field.Name, "mapped as %s, expected %s", fieldKind(g), fieldKind(w))
+			continue
+		}
+		if fieldKind(w) == "text" && g.Analyzer != w.Analyzer {
+			report(field.Name, "analyzer %q, expected %q", g.Analyzer, w.Analyzer)
+		}
+		if g.Index != w.Index {
+			report(field.Name, "indexed is %t, expected %t", g.Index, w.Index)
+		}
+		if g.Store != w.Store {
+			report(field.Name, "stored is %t, expected %t", g.Store, w.Store)
+		}
+	}
+	for name, g := range gotFields {
+		if _, ok := wantFields[name]; !ok {
+			report(name, "mapped as %s but missing from schema '%s'", describeField(g), s.Name)
+		}
+	}
+	sort.SliceStable(mismatches, func(a, b int) bool { return mismatches[a].Field < mismatches[b].Field })
+	return mismatches, nil
+}
+
+// mappedFields returns the field mappings of the default document type, or of the
+// default mapping if there is no such type, by path, with inherited analyzers resolved.
+func mappedFields(m *mapping.IndexMappingImpl) map[string]mapping.FieldMapping {
+	doc := m.DefaultMapping
+	if typed, ok := m.TypeMapping[m.DefaultType]; ok {
+		doc = typed
+	}
+	fields := make(map[string]mapping.FieldMapping)
+	var walk func(doc *mapping.DocumentMapping, prefix, analyzer string)
+	walk = func(doc *mapping.DocumentMapping, prefix, analyzer string) {
+		if doc.DefaultAnalyzer != "" {
+			analyzer = doc.DefaultAnalyzer
+		}
+		for name, property := range doc.Properties {
+			path := prefix + name
+			for _, fm := range property.Fields {
+				f := *fm
+				if f.Analyzer == "" {
+					f.Analyzer = analyzer
+				}
+				fields[path] = f
+			}
+			walk(property, path+".", analyzer)
+		}
+	}
+	analyzer := m.DefaultAnalyzer
+	if analyzer == "" {
+		analyzer = standard.Name
+	}
+	walk(doc, "", analyzer)
+	return fields
+}
+
+// fieldKind names the kind of a field mapping; text fields analyzed as keywords are
+// keywords.
+func fieldKind(f mapping.FieldMapping) string {
+	if f.Type == "text" && f.Analyzer == keyword.Name {
+		return "keyword"
+	}
+	return f.Type
+}
+
+func describeField(f mapping.FieldMapping) string {
+	if fieldKind(f) == "text" {
+		return fmt.Sprintf("text analyzed by %q", f.Analyzer)
+	}
+	return fieldKind(f)
+}
@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"common/schema"
+)
+
+var testSchema = schema.IndexSchema{
+	Name: "articles",
+	Fields: []schema.Field{
+		{Name: "id", Type: schema.TypeString, Indexed: true, Stored: true},
+		{Name: "title", Type: schema.TypeText, Analyzer: "standard", Indexed: true, Stored: true},
+		{Name: "content", Type: schema.TypeText, Indexed: true},
+		{Name: "views", Type: schema.TypeInteger, Indexed: true, Stored: true},
+		{Name: "published", Type: schema.TypeDatetime, Indexed: true, Stored: true},
+	},
+	Options: map[string]string{schema.OptionAnalyzer: "en"},
+}
+
+func TestMappingFromSchema(t *testing.T) {
+	m, err := MappingFromSchema(testSchema)
+	if err != nil {
+		t.Fatalf("MappingFromSchema returned an error: %v", err)
+	}
+	fields := mappedFields(m)
+	for name, want := range map[string]string{"id": "keyword", "title": "text", "content": "text", "views": "number", "published": "datetime"} {
+		if got := fieldKind(fields[name]); got != want {
+			t.Errorf("Expected %s to be mapped as %s, got %s", name, want, got)
+		}
+	}
+	if fields["title"].Analyzer != "standard" || fields["content"].Analyzer != "en" || fields["content"].Store {
+		t.Errorf("Unexpected text fields %+v, %+v", fields["title"], fields["content"])
+	}
+
+	withVector := testSchema
+	withVector.Fields = append(append([]schema.Field(nil), testSchema.Fields...), schema.Field{Name: "embedding", Type: schema.TypeVector, Dims: 3, Indexed: true})
+	m, err = MappingFromSchema(withVector)
+	if err != nil {
+		t.Fatalf("MappingFromSchema returned an error: %v", err)
+	}
+	if fm := mappedFields(m)["embedding"]; fieldKind(fm) != "number" || fm.Index || !fm.Store {
+		t.Errorf("Expected the vector to be a stored, unindexed number, got %+v", fm)
+	}
+
+	invalid := testSchema
+	invalid.Options = map[string]string{schema.OptionAnalyzer: "klingon"}
+	if _, err := MappingFromSchema(invalid); !errors.Is(err, ErrInvalidMapping) {
+		t.Errorf("Expected an unknown analyzer to fail with ErrInvalidMapping, got %v", err)
+	}
+}
+
+func TestCompareMapping(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	m, err := MappingFromSchema(testSchema)
+	if err != nil {
+		t.Fatalf("MappingFromSchema returned an error: %v", err)
+	}
+	idx, err := NewIndexerWithMapping(filepath.Join(tempDir, "index"), storage, m)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	mismatches, err := CompareMapping(testSchema, idx.Mapping())
+	if err != nil || len(mismatches) != 0 {
+		t.Fatalf("Expected the index to match its schema, got %v (%v)", mismatches, err)
+	}
+
+	changed := schema.IndexSchema{Name: "articles", Fields: []schema.Field{
+		{Name: "id", Type: schema.TypeText, Indexed: true, Stored: true},
+		{Name: "title", Type: schema.TypeText, Analyzer: "en", Indexed: true, Stored: true},
+		{Name: "content", Type: schema.TypeText, Indexed: true, Stored: true},
+		{Name: "views", Type: schema.TypeInteger, Indexed: true, Stored: true},
+		{Name: "author", Type: schema.TypeString, Indexed: true},
+	}, Options: testSchema.Options}
+	mismatches, err = CompareMapping(changed, idx.Mapping())
+	if err != nil {
+		t.Fatalf("CompareMapping returned an error: %v", err)
+	}
+	want := []SchemaMismatch{
+		{Field: "author", Problem: "not mapped, expected keyword"},
+		{Field: "content", Problem: "stored is false, expected true"},
+		{Field: "id", Problem: "mapped as keyword, expected text"},
+		{Field: "published", Problem: "mapped as datetime but missing from schema 'articles'"},
+		{Field: "title", Problem: `analyzer "standard", expected "en"`},
+	}
+	if !reflect.DeepEqual(mismatches, want) {
+		t.Errorf("Expected mismatches %v, got %v", want, mismatches)
+	}
+}
+
+func TestLoadIndexMapping_MappingJSON(t *testing.T) {
+	if _, err := LoadIndexMapping("mapping.json"); err != nil {
+		t.Errorf("Expected mapping.json to load, got %v", err)
+	}
+}
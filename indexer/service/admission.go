@@ -0,0 +1,157 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus collectors of the admission control of write requests.
+var (
+	admissionInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "indexer_admission_in_flight",
+		Help: "Number of write requests being applied.",
+	})
+
+	admissionQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "indexer_admission_queue_depth",
+		Help: "Number of write requests waiting for an in-flight slot.",
+	})
+
+	admissionRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "indexer_admission_rejected_total",
+		Help: "Total number of write requests rejected with 429, partitioned by reason.",
+	}, []string{"reason"})
+
+	admissionWaitHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "indexer_admission_wait_seconds",
+		Help:    "Time admitted write requests waited in the queue.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// AdmissionConfig bounds the write requests (index, delete and bulk index) the service
+// works on, so bursts of producers queue up to a limit and are then turned away with a
+// 429 instead of piling up behind the index mutex.
+type AdmissionConfig struct {
+	MaxInFlight  int           `yaml:"max_in_flight" env:"MAX_IN_FLIGHT" flag:"max-in-flight" usage:"Write requests applied at once; 0 disables admission control"`
+	MaxQueue     int           `yaml:"max_queue" env:"MAX_QUEUE" flag:"max-queue" usage:"Write requests waiting for an in-flight slot before new ones get a 429"`
+	QueueTimeout time.Duration `yaml:"queue_timeout" env:"QUEUE_TIMEOUT" flag:"queue-timeout" usage:"How long a write request waits in the queue before it gets a 429"`
+	RetryAfter   time.Duration `yaml:"retry_after" env:"RETRY_AFTER" flag:"retry-after" usage:"Delay suggested to rejected producers in the Retry-After header"`
+}
+
+// Validate checks the limits.
+func (c AdmissionConfig) Validate() error {
+	if c.MaxInFlight < 0 || c.MaxQueue < 0 || c.QueueTimeout < 0 || c.RetryAfter < 0 {
+		return fmt.Errorf("admission limits must not be negative")
+	}
+	return nil
+}
+
+// admission is the admission control of write requests. Requests take a queue slot, then
+// wait for an in-flight slot; the queue slots also cover the in-flight requests.
+type admission struct {
+	config   AdmissionConfig
+	slots    chan struct{} // In-flight and queued requests
+	inFlight chan struct{} // Requests being applied
+}
+
+func newAdmission(config AdmissionConfig) *admission {
+	return &admission{
+		config:   config,
+		slots:    make(chan struct{}, config.MaxInFlight+config.MaxQueue),
+		inFlight: make(chan struct{}, config.MaxInFlight),
+	}
+}
+
+// acquire admits a request, returning a function releasing it, or the reason it was
+// rejected.
+func (a *admission) acquire(r *http.Request) (release func(), reason string) {
+	select {
+	case a.slots <- struct{}{}:
+	default:
+		return nil, "queue_full"
+	}
+	admissionQueueDepth.Inc()
+	start := time.Now()
+
+	var timeout <-chan time.Time
+	if a.config.QueueTimeout > 0 {
+		timer := time.NewTimer(a.config.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case a.inFlight <- struct{}{}:
+	case <-timeout:
+		reason = "queue_timeout"
+	case <-r.Context().Done():
+		reason = "canceled"
+	}
+	admissionQueueDepth.Dec()
+	if reason != "" {
+		<-a.slots
+		return nil, reason
+	}
+	admissionWaitHistogram.Observe(time.Since(start).Seconds())
+	admissionInFlight.Inc()
+	return func() {
+		admissionInFlight.Dec()
+		<-a.inFlight
+		<-a.slots
+	}, ""
+}
+
+// retryAfter returns the Retry-After header value, in whole seconds.
+func (a *admission) retryAfter() string {
+	d := a.config.RetryAfter
+	if d <= 0 {
+		d = time.Second
+	}
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// SetAdmission enables admission control of write requests; a zero MaxInFlight disables it.
+func (ws *WebService) SetAdmission(config AdmissionConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.MaxInFlight == 0 {
+		ws.admission = nil
+		return nil
+	}
+	ws.admission = newAdmission(config)
+	return nil
+}
+
+// admitted applies admission control to h: requests beyond the in-flight and queue limits,
+// or queued for longer than the queue timeout, get a 429 with a Retry-After header.
+func (ws *WebService) admitted(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a := ws.admission
+		if a == nil {
+			h(w, r)
+			return
+		}
+		release, reason := a.acquire(r)
+		if release == nil {
+			admissionRejectedTotal.WithLabelValues(reason).Inc()
+			if reason == "canceled" {
+				// The producer is gone; nobody reads the response.
+				return
+			}
+			slog.WarnContext(r.Context(), "Rejecting request", "path", r.URL.Path, "reason", reason)
+			w.Header().Set("Retry-After", a.retryAfter())
+			http.Error(w, fmt.Sprintf("Indexer is saturated (%s), retry later", reason), http.StatusTooManyRequests)
+			return
+		}
+		defer release()
+		h(w, r)
+	}
+}
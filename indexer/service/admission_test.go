@@ -0,0 +1,53 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdmitted(t *testing.T) {
+	ws := &WebService{}
+	if err := ws.SetAdmission(AdmissionConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 100 * time.Millisecond, RetryAfter: 1500 * time.Millisecond}); err != nil {
+		t.Fatalf("SetAdmission returned an error: %v", err)
+	}
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	h := ws.admitted(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-unblock
+	})
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest("POST", "/index", nil))
+		return rec
+	}
+
+	// The first request is applied, the second is queued behind it.
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- serve() }()
+	<-entered
+	queued := make(chan *httptest.ResponseRecorder)
+	go func() { queued <- serve() }()
+	for len(ws.admission.slots) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := serve()
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected a 429 with Retry-After 2 when the queue is full, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := <-queued; rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a 429 once the queue timeout elapsed, got %d", rec.Code)
+	}
+
+	close(unblock)
+	if rec := <-first; rec.Code != http.StatusOK {
+		t.Errorf("Expected the in-flight request to complete, got %d", rec.Code)
+	}
+	go func() { <-entered }()
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Errorf("Expected a request to be admitted once the service is idle, got %d", rec.Code)
+	}
+}
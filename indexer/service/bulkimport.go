@@ -0,0 +1,127 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"indexer"
+	"indexer/connector"
+)
+
+// maxImportBatchSize bounds the batch_size of /bulk_import.
+const maxImportBatchSize = 10000
+
+// HandleBulkImportRequest is an HTTP handler that indexes a stream of NDJSON or CSV
+// records, decoded and indexed in batches as the body is read so imports of any size run
+// in constant memory. The format is taken from the format query parameter ("ndjson" or
+// "csv") or else from the Content-Type. Optional query parameters:
+//
+//   - batch_size: documents per batch, default 500
+//   - id_field: field holding the document IDs, default "id"
+//   - mapping: CSV header-to-field mapping, e.g. "Product Name:title,SKU:id,Notes:"
+//     where a column mapped to nothing is dropped
+//   - pipeline: ingest pipeline enriching the documents, "_none" skipping the default
+//
+// Records that can't be decoded or are invalid are skipped; the response reports them
+// with their position in the stream.
+func (ws *WebService) HandleBulkImportRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = importFormat(r.Header.Get("Content-Type"))
+	}
+	opts := connector.Options{}
+	if raw := query.Get("batch_size"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxImportBatchSize {
+			http.Error(w, fmt.Sprintf("invalid batch_size, must be between 1 and %d", maxImportBatchSize), http.StatusBadRequest)
+			return
+		}
+		opts.BatchSize = n
+	}
+	idx := ws.indexerFor(r)
+	target := &pipelineIndexer{indexer: idx, pipeline: query.Get("pipeline")}
+	if _, err := idx.IngestPipeline(target.pipeline); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mapping, err := parseImportMapping(query.Get("mapping"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	source, err := connector.NewStreamSource(r.Body, format, connector.StreamOptions{IDField: query.Get("id_field"), Mapping: mapping})
+	if err != nil {
+		http.Error(w, "Unknown import format, set format to ndjson or csv", http.StatusBadRequest)
+		return
+	}
+
+	stats, err := connector.Run(r.Context(), source, target, opts)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error importing records", "format", format, "indexed", stats.Indexed, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "stats": stats})
+		return
+	}
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding bulk import response", "error", err)
+	}
+	slog.InfoContext(r.Context(), "Handled bulk import", "format", format, "records", stats.Read, "indexed", stats.Indexed, "invalid", stats.Invalid)
+}
+
+// pipelineIndexer indexes the documents of a connector with an ingest pipeline, the
+// default one if empty.
+type pipelineIndexer struct {
+	indexer  *indexer.Indexer
+	pipeline string
+}
+
+// BulkIndexDocuments indexes docs with the pipeline.
+func (p *pipelineIndexer) BulkIndexDocuments(docs map[string]interface{}) (map[string]error, error) {
+	resp, err := p.indexer.BulkIndexDocumentsWithPipeline(docs, p.pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Failures(), nil
+}
+
+// importFormat returns the import format of a Content-Type, "" if it's neither NDJSON
+// nor CSV.
+func importFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return connector.FormatNDJSON
+	case "text/csv", "application/csv":
+		return connector.FormatCSV
+	}
+	return ""
+}
+
+// parseImportMapping parses a comma-separated list of column:field pairs.
+func parseImportMapping(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		column, field, ok := strings.Cut(pair, ":")
+		if !ok || strings.TrimSpace(column) == "" {
+			return nil, fmt.Errorf("invalid mapping entry %q, expected column:field", pair)
+		}
+		mapping[strings.TrimSpace(column)] = strings.TrimSpace(field)
+	}
+	return mapping, nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"indexer"
+	"indexer/connector"
+)
+
+func newTestWebService(t *testing.T) (*WebService, *indexer.Indexer) {
+	t.Helper()
+	dir := t.TempDir()
+	storage, err := indexer.NewLocalFileStorage(filepath.Join(dir, "segments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := indexer.NewIndexer(filepath.Join(dir, "index"), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return NewWebService(idx, ""), idx
+}
+
+func TestHandleBulkImportRequest(t *testing.T) {
+	ws, idx := newTestWebService(t)
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		body        string
+		indexed     int
+		errors      []connector.RecordError
+	}{
+		{
+			name:        "ndjson",
+			url:         "/bulk_import?batch_size=2",
+			contentType: "application/x-ndjson",
+			body:        "{\"id\": \"a\", \"title\": \"Red shoes\"}\n{\"title\": \"no id\"}\n{oops\n{\"id\": \"b\", \"title\": \"Boots\"}\n{\"id\": \"c\", \"title\": \"Hat\"}\n",
+			indexed:     3,
+			errors:      []connector.RecordError{{Record: 2}, {Record: 3}},
+		},
+		{
+			name: "csv with mapping",
+			url:  "/bulk_import?format=csv&mapping=" + "SKU:id,Product%20Name:title,Internal:",
+			body: "SKU,Product Name,Internal\nd,Scarf,secret\n\"e,Gloves,x\ne,Gloves\n",
+			// The second row has an unterminated quote and swallows the rest of the stream.
+			indexed: 1,
+			errors:  []connector.RecordError{{Record: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			ws.HandleBulkImportRequest(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			var stats connector.Stats
+			if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+				t.Fatal(err)
+			}
+			if stats.Indexed != tt.indexed || len(stats.Errors) != len(tt.errors) {
+				t.Fatalf("Unexpected stats %+v", stats)
+			}
+			for n, want := range tt.errors {
+				if stats.Errors[n].Record != want.Record || stats.Errors[n].Error == "" {
+					t.Errorf("Expected record %d to be reported, got %+v", want.Record, stats.Errors[n])
+				}
+			}
+		})
+	}
+
+	doc, err := idx.GetDocument("d", nil)
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	if doc.Fields["title"] != "Scarf" || doc.Fields["Internal"] != nil {
+		t.Errorf("Expected the mapped CSV fields, got %v", doc.Fields)
+	}
+	if _, err := idx.GetDocument("b", nil); err != nil {
+		t.Errorf("Expected the NDJSON document b to be indexed: %v", err)
+	}
+}
+
+func TestHandleBulkImportRequest_Invalid(t *testing.T) {
+	ws, _ := newTestWebService(t)
+	for _, url := range []string{"/bulk_import", "/bulk_import?format=xml", "/bulk_import?format=csv&batch_size=0", "/bulk_import?format=csv&mapping=oops"} {
+		rec := httptest.NewRecorder()
+		ws.HandleBulkImportRequest(rec, httptest.NewRequest(http.MethodPost, url, strings.NewReader("")))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", url, rec.Code)
+		}
+	}
+}
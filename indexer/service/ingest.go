@@ -0,0 +1,73 @@
+package service
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"indexer/connector"
+)
+
+// IngestConfig secures /ingest, which makes the indexer read files and fetch web pages on
+// behalf of its callers: requests must carry the admin token, and their sources are
+// confined to a directory and the hosts of an allowlist. Without a token, /ingest is
+// disabled.
+type IngestConfig struct {
+	AdminToken    string   `yaml:"admin_token" env:"INGEST_ADMIN_TOKEN" usage:"Bearer token required by /ingest and /restore; empty disables them"`
+	DirectoryRoot string   `yaml:"directory_root" env:"INGEST_DIRECTORY_ROOT" flag:"ingest-directory-root" usage:"Directory holding the directories /ingest may read; empty disables directory sources"`
+	AllowedHosts  []string `yaml:"allowed_hosts" env:"INGEST_ALLOWED_HOSTS" flag:"ingest-allowed-hosts" usage:"Comma-separated hosts /ingest may fetch pages from, *.example.com matching subdomains; empty disables web sources"`
+}
+
+// Validate checks that the directory root exists and that sources are only allowed along
+// with a token.
+func (c IngestConfig) Validate() error {
+	if c.AdminToken == "" && (c.DirectoryRoot != "" || len(c.AllowedHosts) > 0) {
+		return fmt.Errorf("ingest sources require an admin token")
+	}
+	if c.DirectoryRoot != "" {
+		if info, err := os.Stat(c.DirectoryRoot); err != nil || !info.IsDir() {
+			return fmt.Errorf("ingest directory root %q is not a directory", c.DirectoryRoot)
+		}
+	}
+	for _, host := range c.AllowedHosts {
+		if strings.TrimPrefix(host, "*.") == "" || strings.ContainsAny(host, "/:") {
+			return fmt.Errorf("invalid ingest host %q, expected a host name such as example.com", host)
+		}
+	}
+	return nil
+}
+
+// scope returns the sources ingestion is confined to.
+func (c IngestConfig) scope() connector.Scope {
+	return connector.Scope{Root: c.DirectoryRoot, Hosts: c.AllowedHosts}
+}
+
+// SetIngest enables /ingest for callers presenting the admin token, confined to the
+// sources of config, and /restore for them.
+func (ws *WebService) SetIngest(config IngestConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	ws.ingest = config
+	return nil
+}
+
+// adminOnly requires the ingest admin token as a bearer token before passing requests on
+// to h, rejecting them all while no token is set. It guards /ingest and /restore.
+func (ws *WebService) adminOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ws.ingest.AdminToken == "" {
+			http.Error(w, "Admin endpoints are disabled: no admin token is configured", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(ws.ingest.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="indexer"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
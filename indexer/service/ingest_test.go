@@ -0,0 +1,47 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleIngestRequest_Secured(t *testing.T) {
+	ws := &WebService{}
+	h := ws.adminOnly(http.HandlerFunc(ws.HandleIngestRequest))
+	serve := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	directory := `{"source": {"type": "directory", "path": "/etc"}}`
+
+	if rec := serve("", directory); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 while ingestion is disabled, got %d", rec.Code)
+	}
+	if err := ws.SetIngest(IngestConfig{DirectoryRoot: t.TempDir()}); err == nil {
+		t.Error("Expected an error for sources without a token")
+	}
+	if err := ws.SetIngest(IngestConfig{AdminToken: "secret", DirectoryRoot: t.TempDir(), AllowedHosts: []string{"docs.example.com"}}); err != nil {
+		t.Fatalf("SetIngest returned an error: %v", err)
+	}
+	for _, token := range []string{"", "wrong"} {
+		if rec := serve(token, directory); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for token %q, got %d", token, rec.Code)
+		}
+	}
+	for _, body := range []string{
+		directory,
+		`{"source": {"type": "urls", "urls": ["http://169.254.169.254/latest/meta-data"]}}`,
+		`{"source": {"type": "sitemap", "url": "http://localhost:8080/sitemap.xml"}}`,
+	} {
+		if rec := serve("secret", body); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for %s, got %d: %s", body, rec.Code, rec.Body.String())
+		}
+	}
+}
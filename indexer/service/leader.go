@@ -0,0 +1,31 @@
+package service
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// LeaderHeader names the leader in the responses of followers to write requests.
+const LeaderHeader = "X-Indexer-Leader"
+
+// leaderOnly wraps a write handler so that, with leader election, only the leader applies
+// writes. Followers redirect them to the leader with a 307, which keeps the method and
+// body, and name it in the LeaderHeader; while no leader is known, or a new leader catches
+// up with the segments of the previous one, they answer 503.
+func (ws *WebService) leaderOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		leading, leader := ws.indexerFor(r).Leadership()
+		if leading {
+			h(w, r)
+			return
+		}
+		if leader == "" {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "No indexer leader is elected, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		slog.DebugContext(r.Context(), "Redirecting request to the leader", "path", r.URL.Path, "leader", leader)
+		w.Header().Set(LeaderHeader, leader)
+		http.Redirect(w, r, leader+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	}
+}
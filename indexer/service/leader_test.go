@@ -0,0 +1,47 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"indexer"
+)
+
+// staticElector always elects the same leader.
+type staticElector struct{ leader string }
+
+func (e staticElector) Campaign(string) (string, error) { return e.leader, nil }
+func (e staticElector) Resign() error                   { return nil }
+
+func TestLeaderOnly(t *testing.T) {
+	ws, idx := newTestWebService(t)
+	election := indexer.NewElectionWithElector(staticElector{leader: "http://indexer-0:8081"}, "http://indexer-1:8081", time.Minute)
+	election.Start()
+	defer election.Stop()
+	idx.SetLeaderElection(election)
+
+	rec := httptest.NewRecorder()
+	ws.leaderOnly(ws.HandleIndexRequest)(rec, httptest.NewRequest(http.MethodPost, "/index?pipeline=logs", strings.NewReader(`{"id": "a", "data": {}}`)))
+	if rec.Code != http.StatusTemporaryRedirect || rec.Header().Get(LeaderHeader) != "http://indexer-0:8081" {
+		t.Fatalf("Expected a follower to redirect to the leader, got %d %q", rec.Code, rec.Header().Get(LeaderHeader))
+	}
+	if loc := rec.Header().Get("Location"); loc != "http://indexer-0:8081/index?pipeline=logs" {
+		t.Errorf("Unexpected redirect location %q", loc)
+	}
+	if _, err := idx.GetDocument("a", nil); err == nil {
+		t.Errorf("Expected the follower not to index the document")
+	}
+
+	leader := indexer.NewElectionWithElector(staticElector{}, "http://indexer-1:8081", time.Minute)
+	leader.Start()
+	defer leader.Stop()
+	idx.SetLeaderElection(leader)
+	rec = httptest.NewRecorder()
+	ws.leaderOnly(ws.HandleIndexRequest)(rec, httptest.NewRequest(http.MethodPost, "/index", strings.NewReader(`{"id": "a", "data": {}}`)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while no leader is elected, got %d", rec.Code)
+	}
+}
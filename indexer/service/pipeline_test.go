@@ -0,0 +1,80 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"indexer"
+	"indexer/ingest"
+)
+
+func TestHandleIndexRequest_Pipeline(t *testing.T) {
+	ws, idx := newTestWebService(t)
+	pipelines, err := ingest.NewPipelines(map[string]ingest.Config{
+		"defaults": {Processors: []ingest.ProcessorConfig{{Type: ingest.TypeSet, Field: "lang", Value: "en"}}},
+		"products": {Processors: []ingest.ProcessorConfig{{Type: ingest.TypeRename, Field: "name", TargetField: "title"}}},
+	}, "defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.SetIngestPipelines(pipelines)
+	index := func(url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		ws.HandleIndexRequest(rec, httptest.NewRequest(http.MethodPost, url, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := index("/index", `{"id": "a", "data": {"title": "Boots"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := index("/index?pipeline=products", `{"id": "b", "data": {"name": "Hat"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := index("/index?pipeline=products", `{"id": "c", "data": {"title": "no name"}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a document the pipeline fails on, got %d", rec.Code)
+	}
+	if rec := index("/index?pipeline=logs", `{"id": "d", "data": {"title": "Scarf"}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown pipeline, got %d", rec.Code)
+	}
+
+	a, err := idx.GetDocument("a", nil)
+	if err != nil || a.Fields["lang"] != "en" {
+		t.Errorf("Expected the default pipeline to set the language, got %+v (%v)", a, err)
+	}
+	b, err := idx.GetDocument("b", nil)
+	if err != nil || b.Fields["title"] != "Hat" || b.Fields["lang"] != nil {
+		t.Errorf("Expected only the products pipeline to apply, got %+v (%v)", b, err)
+	}
+
+	rec := httptest.NewRecorder()
+	ws.HandleBulkIndexRequest(rec, httptest.NewRequest(http.MethodPost, "/bulk_index?pipeline=_none", strings.NewReader(`{"e": {"title": "Gloves"}}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp indexer.BulkResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Errors || len(resp.Items) != 1 || resp.Items[0].Index.Result != "created" {
+		t.Errorf("Expected the document to be reported created, got %+v (%v)", resp, err)
+	}
+	if e, err := idx.GetDocument("e", nil); err != nil || e.Fields["lang"] != nil {
+		t.Errorf("Expected _none to skip the default pipeline, got %+v (%v)", e, err)
+	}
+
+	// Documents the pipeline fails on are reported with their error.
+	rec = httptest.NewRecorder()
+	ws.HandleBulkIndexRequest(rec, httptest.NewRequest(http.MethodPost, "/bulk_index?pipeline=products", strings.NewReader(`{"f": {"name": "Belt"}, "g": {"title": "no name"}}`)))
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("Expected 207 for a document the pipeline fails on, got %d: %s", rec.Code, rec.Body.String())
+	}
+	resp = indexer.BulkResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || !resp.Errors || len(resp.Items) != 2 || resp.Items[1].Index.Error == nil {
+		t.Errorf("Expected the failure of g, got %+v (%v)", resp, err)
+	}
+	rec = httptest.NewRecorder()
+	ws.HandleBulkIndexRequest(rec, httptest.NewRequest(http.MethodPost, "/bulk_index?pipeline=products", strings.NewReader(`{"h": {"title": "no name"}}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when the pipeline fails on every document, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"indexer"
+)
+
+// LoadShardRequest names the uploaded index a staged indexer loads as its shard.
+type LoadShardRequest struct {
+	Index string `json:"index"`
+}
+
+// HandleReshardStageRequest is an HTTP handler that stages the indexer of a new shard:
+// until its shard is loaded, it journals the writes the routers mirror to it.
+func (ws *WebService) HandleReshardStageRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := ws.indexerFor(r).StageShard(); err != nil {
+		slog.ErrorContext(r.Context(), "Error staging the indexer", "error", err)
+		http.Error(w, fmt.Sprintf("Failed to stage the indexer: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Indexer staged"))
+}
+
+// HandleReshardLoadRequest is an HTTP handler that loads the new shard uploaded by a
+// resharding into a staged indexer and replays the writes it journaled.
+func (ws *WebService) HandleReshardLoadRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req LoadShardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(r.Context(), "Error unmarshalling load shard request body", "error", err)
+		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Index == "" {
+		http.Error(w, "Index name is required", http.StatusBadRequest)
+		return
+	}
+	if err := ws.indexerFor(r).LoadShard(req.Index); err != nil {
+		slog.ErrorContext(r.Context(), "Error loading the new shard", "index", req.Index, "error", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, indexer.ErrNotStaged) {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Failed to load shard %s: %v", req.Index, err), status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Shard loaded"))
+	slog.InfoContext(r.Context(), "Handled load shard request", "index", req.Index)
+}
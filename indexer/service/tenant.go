@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"common/tenant"
+	"indexer"
+)
+
+// DefaultMaxTenants bounds the tenant indexes open at once when SetTenantIndexers is given
+// no bound.
+const DefaultMaxTenants = 100
+
+var (
+	// ErrUnknownTenant is returned by TenantIndexerFactory for tenants that aren't
+	// provisioned, whose requests get a 404.
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrTooManyTenants is returned when opening the index of a tenant would exceed the
+	// bound of open tenant indexes.
+	ErrTooManyTenants = errors.New("too many open tenant indexes")
+)
+
+// TenantIndexerFactory opens the index of a tenant other than the default one. It fails
+// with ErrUnknownTenant, before creating anything, for tenants that aren't provisioned.
+type TenantIndexerFactory func(tenantID string) (*indexer.Indexer, error)
+
+// tenantIndexers holds the indexes of the non-default tenants, opened on their first request.
+type tenantIndexers struct {
+	factory TenantIndexerFactory
+	max     int // Indexes open or opening at once
+
+	mu       sync.Mutex
+	indexers map[string]*tenantIndexer
+}
+
+// tenantIndexer is the index of a tenant, set along with err once done is closed.
+type tenantIndexer struct {
+	done chan struct{}
+	idx  *indexer.Indexer
+	err  error
+}
+
+// get returns the index of tenantID, opening it if needed. Indexes are opened without
+// holding the mutex, so opening one doesn't hold up the requests of other tenants;
+// concurrent requests of the tenant wait for the same open.
+func (t *tenantIndexers) get(tenantID string) (*indexer.Indexer, error) {
+	t.mu.Lock()
+	if opened, ok := t.indexers[tenantID]; ok {
+		t.mu.Unlock()
+		<-opened.done
+		return opened.idx, opened.err
+	}
+	if len(t.indexers) >= t.max {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("%w: opening tenant %q would exceed %d", ErrTooManyTenants, tenantID, t.max)
+	}
+	opened := &tenantIndexer{done: make(chan struct{})}
+	t.indexers[tenantID] = opened
+	t.mu.Unlock()
+
+	opened.idx, opened.err = t.factory(tenantID)
+	if opened.err != nil {
+		opened.err = fmt.Errorf("failed to open the index of tenant %q: %w", tenantID, opened.err)
+		t.mu.Lock()
+		delete(t.indexers, tenantID) // Retried by the next request
+		t.mu.Unlock()
+	} else {
+		slog.Info("Opened index of tenant", "tenant", tenantID)
+	}
+	close(opened.done)
+	return opened.idx, opened.err
+}
+
+// close closes the indexes opened so far, waiting for those being opened.
+func (t *tenantIndexers) close() error {
+	t.mu.Lock()
+	indexers := t.indexers
+	t.indexers = make(map[string]*tenantIndexer)
+	t.mu.Unlock()
+	var errs []error
+	for id, opened := range indexers {
+		<-opened.done
+		if opened.idx == nil {
+			continue
+		}
+		if err := opened.idx.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// indexerKey is the request context key of the tenant's index.
+type indexerKey struct{}
+
+// SetTenantIndexers serves requests of tenants other than the default one, identified by
+// the tenant.Header header or tenant.Param parameter, from the indexes factory opens, up
+// to maxOpen at once (DefaultMaxTenants if 0); requests of further tenants get a 503.
+// Without a factory, such requests are rejected.
+func (ws *WebService) SetTenantIndexers(factory TenantIndexerFactory, maxOpen int) {
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxTenants
+	}
+	ws.tenants = &tenantIndexers{factory: factory, max: maxOpen, indexers: make(map[string]*tenantIndexer)}
+}
+
+// SetTenantLimiter enforces per-tenant request quotas; requests over quota get a 429.
+func (ws *WebService) SetTenantLimiter(limiter *tenant.Limiter) {
+	ws.limiter = limiter
+}
+
+// CloseTenantIndexers closes the indexes opened for non-default tenants.
+func (ws *WebService) CloseTenantIndexers() error {
+	if ws.tenants == nil {
+		return nil
+	}
+	return ws.tenants.close()
+}
+
+// tenantScoped resolves the tenant of the request, enforces its quota and passes its
+// index to h through the request context.
+func (ws *WebService) tenantScoped(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := tenant.FromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := ws.limiter.Allow(id); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if id == tenant.Default {
+			h(w, r)
+			return
+		}
+		if ws.tenants == nil {
+			http.Error(w, fmt.Sprintf("tenant %q not found: this indexer only serves the default tenant", id), http.StatusNotFound)
+			return
+		}
+		idx, err := ws.tenants.get(id)
+		switch {
+		case errors.Is(err, ErrUnknownTenant):
+			http.Error(w, fmt.Sprintf("tenant %q not found", id), http.StatusNotFound)
+			return
+		case errors.Is(err, ErrTooManyTenants):
+			slog.Warn("Rejecting request of tenant", "tenant", id, "error", err)
+			http.Error(w, "Too many tenant indexes are open, retry later", http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			slog.Error("Error resolving tenant", "error", err)
+			http.Error(w, "Error opening the tenant's index", http.StatusInternalServerError)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), indexerKey{}, idx)))
+	})
+}
+
+// indexerFor returns the index of the request's tenant.
+func (ws *WebService) indexerFor(r *http.Request) *indexer.Indexer {
+	if idx, ok := r.Context().Value(indexerKey{}).(*indexer.Indexer); ok {
+		return idx
+	}
+	return ws.indexer
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"common/tenant"
+	"indexer"
+)
+
+func TestTenantScoped(t *testing.T) {
+	ws, _ := newTestWebService(t)
+	dir := t.TempDir()
+	ws.SetTenantIndexers(func(id string) (*indexer.Indexer, error) {
+		if id == "unknown" {
+			return nil, ErrUnknownTenant
+		}
+		return indexer.NewIndexer(filepath.Join(dir, id, "index"), nil)
+	}, 1)
+	defer ws.CloseTenantIndexers()
+	h := ws.tenantScoped(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	serve := func(id string) int {
+		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		req.Header.Set(tenant.Header, id)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve("unknown"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown tenant, got %d", code)
+	}
+	for range 2 {
+		if code := serve("shop"); code != http.StatusOK {
+			t.Errorf("Expected a provisioned tenant to be served, got %d", code)
+		}
+	}
+	if code := serve("blog"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 beyond the open tenant indexes, got %d", code)
+	}
+}
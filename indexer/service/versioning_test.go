@@ -0,0 +1,37 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleIndexRequest_Versioning(t *testing.T) {
+	ws, _ := newTestWebService(t)
+	index := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		ws.HandleIndexRequest(rec, httptest.NewRequest(http.MethodPost, "/index", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := index(`{"id": "a", "data": {"title": "v1"}, "version": 0}`)
+	if rec.Code != http.StatusOK || rec.Header().Get(VersionHeader) != "1" {
+		t.Fatalf("Expected version 1, got %d %q: %s", rec.Code, rec.Header().Get(VersionHeader), rec.Body.String())
+	}
+	if rec := index(`{"id": "a", "data": {"title": "stale"}, "version": 0}`); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a stale write, got %d", rec.Code)
+	}
+	if rec := index(`{"id": "a", "data": {"title": "v2"}, "version": -2}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative version, got %d", rec.Code)
+	}
+	if rec := index(`{"id": "a", "data": {"title": "v2"}}`); rec.Code != http.StatusOK || rec.Header().Get(VersionHeader) != "2" {
+		t.Errorf("Expected an unconditional write to version 2, got %d %q", rec.Code, rec.Header().Get(VersionHeader))
+	}
+
+	rec = httptest.NewRecorder()
+	ws.HandleDeleteRequest(rec, httptest.NewRequest(http.MethodPost, "/delete", strings.NewReader(`{"id": "a", "version": 1}`)))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a stale delete, got %d", rec.Code)
+	}
+}
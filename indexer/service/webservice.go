@@ -2,22 +2,80 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"common/graceful"
+	"common/logging"
+	"common/suggest"
+	"common/tenant"
+	"common/tlsconfig"
+	"common/vector"
 	"indexer"
+	"indexer/connector"
+	"indexer/extract"
+	"indexer/ingest"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// VersionHeader reports the version of a document after an index request.
+const VersionHeader = "X-Document-Version"
+
 // Structs for request bodies
 type IndexRequest struct {
 	ID   string      `json:"id"`
 	Data interface{} `json:"data"` // Use interface{} to accept any JSON object
+	// Version makes the write conditional: it fails with status 409 unless the document
+	// is at this version, 0 if it must not exist yet.
+	Version *int64 `json:"version,omitempty"`
 }
 
 type DeleteRequest struct {
-	ID string `json:"id"`
+	ID      string `json:"id"`
+	Version *int64 `json:"version,omitempty"` // Like IndexRequest.Version
+}
+
+// expectedVersion returns the version a write is conditional on, AnyVersion if none.
+func expectedVersion(version *int64) (int64, error) {
+	if version == nil {
+		return indexer.AnyVersion, nil
+	}
+	if *version < 0 {
+		return 0, fmt.Errorf("invalid version %d, must not be negative", *version)
+	}
+	return *version, nil
+}
+
+// SnapshotRequest names the snapshot to create.
+type SnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// RestoreRequest names the snapshot to restore.
+type RestoreRequest struct {
+	Name string `json:"name"`
+}
+
+// ReindexRequest starts a reindex from the given document source, or resumes a failed
+// or canceled job when ResumeJob is set.
+type ReindexRequest struct {
+	Source    indexer.SourceSpec `json:"source"`
+	ResumeJob string             `json:"resume_job,omitempty"`
+}
+
+// IngestRequest ingests the documents of an external source. Directory paths are read on
+// the indexer's host, under the ingest directory root; see IngestConfig.
+type IngestRequest struct {
+	Source    connector.Spec `json:"source"`
+	BatchSize int            `json:"batch_size,omitempty"`
 }
 
 // BulkIndexRequest represents a request to index multiple documents in a batch.
@@ -26,34 +84,80 @@ type BulkIndexRequest map[string]interface{}
 
 // WebService handles HTTP requests for the indexer.
 type WebService struct {
-	indexer    *indexer.Indexer
-	listenAddr string
+	indexer         *indexer.Indexer
+	listenAddr      string
+	tls             tlsconfig.Config
+	shutdownTimeout time.Duration // How long in-flight requests are drained on shutdown
+	tenants         *tenantIndexers
+	limiter         *tenant.Limiter
+	admission       *admission // Bounds the write requests; nil admits every request
+	ingest          IngestConfig
 }
 
 // NewWebService creates a new WebService instance.
 func NewWebService(indexer *indexer.Indexer, listenAddr string) *WebService {
 	return &WebService{
-		indexer:    indexer,
-		listenAddr: listenAddr,
+		indexer:         indexer,
+		listenAddr:      listenAddr,
+		shutdownTimeout: graceful.DefaultTimeout,
 	}
 }
 
-// Start starts the web service and listens for incoming requests.
+// SetTLSConfig makes Start serve over TLS, and require client certificates if cfg.ClientAuth is set.
+func (ws *WebService) SetTLSConfig(cfg tlsconfig.Config) {
+	ws.tls = cfg
+}
+
+// SetShutdownTimeout bounds how long Start drains in-flight requests on shutdown.
+func (ws *WebService) SetShutdownTimeout(d time.Duration) {
+	ws.shutdownTimeout = d
+}
+
+// Start starts the web service and listens for incoming requests until SIGTERM or SIGINT.
+// It then stops accepting requests and returns once in-flight requests, including
+// indexing batches and commits, have completed.
 func (ws *WebService) Start() error {
-	// Set up HTTP endpoints for receiving indexing requests
-	http.HandleFunc("/index", ws.HandleIndexRequest)
-	http.HandleFunc("/delete", ws.HandleDeleteRequest)
-	http.HandleFunc("/commit", ws.HandleCommitRequest)
-	http.HandleFunc("/bulk_index", ws.HandleBulkIndexRequest) // New endpoint for bulk indexing
+	// Set up HTTP endpoints for receiving indexing requests, scoped to the request's tenant.
+	// With leader election, followers redirect writes to the leader.
+	http.Handle("/index", ws.tenantScoped(ws.leaderOnly(ws.admitted(ws.HandleIndexRequest))))
+	http.Handle("/delete", ws.tenantScoped(ws.leaderOnly(ws.admitted(ws.HandleDeleteRequest))))
+	http.Handle("/commit", ws.tenantScoped(ws.leaderOnly(ws.HandleCommitRequest)))
+	http.Handle("/commit/policy", ws.tenantScoped(ws.HandleCommitPolicyRequest))
+	http.Handle("/compaction", ws.tenantScoped(ws.HandleCompactionRequest))
+	http.Handle("/rollover", ws.tenantScoped(ws.leaderOnly(ws.HandleRolloverRequest)))
+	http.Handle("/gc", ws.tenantScoped(ws.leaderOnly(ws.HandleGCRequest)))
+	http.Handle("/bulk_index", ws.tenantScoped(ws.leaderOnly(ws.admitted(ws.HandleBulkIndexRequest)))) // New endpoint for bulk indexing
+	http.Handle("/bulk_import", ws.tenantScoped(ws.leaderOnly(ws.admitted(ws.HandleBulkImportRequest))))
+	http.Handle("/stats", ws.tenantScoped(ws.HandleStatsRequest))
+	http.Handle("/snapshot", ws.tenantScoped(ws.leaderOnly(ws.HandleSnapshotRequest)))
+	http.Handle("/restore", ws.adminOnly(ws.tenantScoped(ws.leaderOnly(ws.HandleRestoreRequest))))
+	http.Handle("/reshard/stage", ws.tenantScoped(ws.leaderOnly(ws.HandleReshardStageRequest)))
+	http.Handle("/reshard/load", ws.tenantScoped(ws.leaderOnly(ws.HandleReshardLoadRequest)))
+	http.Handle("/mapping", ws.tenantScoped(ws.HandleMappingRequest))
+	http.Handle("/reindex", ws.tenantScoped(ws.leaderOnly(ws.HandleReindexRequest)))
+	http.Handle("/ingest", ws.adminOnly(ws.tenantScoped(ws.leaderOnly(ws.HandleIngestRequest))))
+	http.Handle("/doc/", ws.tenantScoped(ws.HandleDocumentRequest))
+	http.Handle("/jobs", ws.tenantScoped(ws.HandleJobsRequest))
+	http.Handle("/jobs/", ws.tenantScoped(ws.HandleJobRequest))
+	http.Handle("/suggest/queries", ws.tenantScoped(ws.HandlePopularQueriesRequest))
+	http.Handle("/metrics", promhttp.Handler()) // Prometheus scrape endpoint
+	http.Handle(logging.LevelPath, logging.LevelHandler())
 
-	log.Printf("Web service listening on %s", ws.listenAddr)
-	if err := http.ListenAndServe(ws.listenAddr, nil); err != nil {
-		return fmt.Errorf("failed to start web service: %w", err)
+	server, err := tlsconfig.NewServer(ws.listenAddr, logging.Middleware(http.DefaultServeMux), ws.tls)
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	slog.Info("Web service listening", "addr", ws.listenAddr)
+	if err := graceful.Serve(server, ws.shutdownTimeout); err != nil {
+		return fmt.Errorf("web service failed: %w", err)
 	}
 	return nil
 }
 
-// HandleIndexRequest is an HTTP handler for adding/updating documents.
+// HandleIndexRequest is an HTTP handler for adding/updating documents. The new version of
+// the document is returned in the VersionHeader; stale conditional writes and updates of
+// documents of read-only indexes get status 409.
+// The pipeline query parameter selects the ingest pipeline, "_none" skipping the default.
 func (ws *WebService) HandleIndexRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
@@ -62,7 +166,7 @@ func (ws *WebService) HandleIndexRequest(w http.ResponseWriter, r *http.Request)
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading index request body: %v", err)
+		slog.WarnContext(r.Context(), "Error reading index request body", "error", err)
 		http.Error(w, "Error reading request body", http.StatusInternalServerError)
 		return
 	}
@@ -70,7 +174,7 @@ func (ws *WebService) HandleIndexRequest(w http.ResponseWriter, r *http.Request)
 
 	var req IndexRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		log.Printf("Error unmarshalling index request body: %v", err)
+		slog.WarnContext(r.Context(), "Error unmarshalling index request body", "error", err)
 		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
 		return
 	}
@@ -79,19 +183,36 @@ func (ws *WebService) HandleIndexRequest(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Document ID is required", http.StatusBadRequest)
 		return
 	}
+	expected, err := expectedVersion(req.Version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if err := ws.indexer.IndexDocument(req.ID, req.Data); err != nil {
-		log.Printf("Error indexing document %s: %v", req.ID, err)
-		http.Error(w, fmt.Sprintf("Failed to index document %s", req.ID), http.StatusInternalServerError)
+	version, err := ws.indexerFor(r).IndexDocumentWithPipeline(req.ID, req.Data, expected, r.URL.Query().Get("pipeline"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error indexing document", "id", req.ID, "error", err)
+		switch {
+		case errors.Is(err, extract.ErrExtraction), errors.Is(err, ingest.ErrProcessing), errors.Is(err, ingest.ErrUnknownPipeline), errors.Is(err, vector.ErrInvalidVector):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, indexer.ErrVersionConflict), errors.Is(err, indexer.ErrReadOnlyDocument):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to index document %s", req.ID), http.StatusInternalServerError)
+		}
 		return
 	}
 
+	if version > 0 {
+		w.Header().Set(VersionHeader, strconv.FormatInt(version, 10))
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf("Document %s indexed successfully", req.ID)))
-	log.Printf("Handled index request for document %s", req.ID)
+	slog.DebugContext(r.Context(), "Handled index request", "id", req.ID)
 }
 
-// HandleDeleteRequest is an HTTP handler for deleting documents.
+// HandleDeleteRequest is an HTTP handler for deleting documents, conditionally on their
+// version like HandleIndexRequest.
 func (ws *WebService) HandleDeleteRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost { // Using POST as discussed, could be DELETE
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
@@ -100,7 +221,7 @@ func (ws *WebService) HandleDeleteRequest(w http.ResponseWriter, r *http.Request
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading delete request body: %v", err)
+		slog.WarnContext(r.Context(), "Error reading delete request body", "error", err)
 		http.Error(w, "Error reading request body", http.StatusInternalServerError)
 		return
 	}
@@ -108,7 +229,7 @@ func (ws *WebService) HandleDeleteRequest(w http.ResponseWriter, r *http.Request
 
 	var req DeleteRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		log.Printf("Error unmarshalling delete request body: %v", err)
+		slog.WarnContext(r.Context(), "Error unmarshalling delete request body", "error", err)
 		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
 		return
 	}
@@ -118,18 +239,32 @@ func (ws *WebService) HandleDeleteRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if err := ws.indexer.DeleteDocument(req.ID); err != nil {
-		log.Printf("Error deleting document %s: %v", req.ID, err)
+	expected, err := expectedVersion(req.Version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := ws.indexerFor(r).DeleteDocumentIfVersion(req.ID, expected); err != nil {
+		slog.ErrorContext(r.Context(), "Error deleting document", "id", req.ID, "error", err)
+		if errors.Is(err, indexer.ErrVersionConflict) || errors.Is(err, indexer.ErrReadOnlyDocument) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Failed to delete document %s", req.ID), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf("Document %s deleted successfully", req.ID)))
-	log.Printf("Handled delete request for document %s", req.ID)
+	slog.DebugContext(r.Context(), "Handled delete request", "id", req.ID)
 }
 
-// HandleBulkIndexRequest is an HTTP handler for bulk adding/updating documents.
+// HandleBulkIndexRequest is an HTTP handler for bulk adding/updating documents, enriched
+// by the ingest pipeline of the pipeline query parameter like HandleIndexRequest. It
+// responds with the outcome of every document, an indexer.BulkResponse: documents fail
+// alone, e.g. those the ingest pipeline fails on, so the status is 207 if some did and
+// theirs if all did.
 func (ws *WebService) HandleBulkIndexRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
@@ -138,7 +273,7 @@ func (ws *WebService) HandleBulkIndexRequest(w http.ResponseWriter, r *http.Requ
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading bulk index request body: %v", err)
+		slog.WarnContext(r.Context(), "Error reading bulk index request body", "error", err)
 		http.Error(w, "Error reading request body", http.StatusInternalServerError)
 		return
 	}
@@ -146,7 +281,7 @@ func (ws *WebService) HandleBulkIndexRequest(w http.ResponseWriter, r *http.Requ
 
 	var req BulkIndexRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		log.Printf("Error unmarshalling bulk index request body: %v", err)
+		slog.WarnContext(r.Context(), "Error unmarshalling bulk index request body", "error", err)
 		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
 		return
 	}
@@ -156,15 +291,23 @@ func (ws *WebService) HandleBulkIndexRequest(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err := ws.indexer.BulkIndexDocuments(req); err != nil {
-		log.Printf("Error bulk indexing documents: %v", err)
+	resp, err := ws.indexerFor(r).BulkIndexDocumentsWithPipeline(req, r.URL.Query().Get("pipeline"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error bulk indexing documents", "error", err)
+		if errors.Is(err, ingest.ErrUnknownPipeline) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Failed to bulk index documents", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Successfully processed bulk index request for %d documents", len(req))))
-	log.Printf("Handled bulk index request for %d documents", len(req))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.Status())
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding bulk index response", "error", err)
+	}
+	slog.InfoContext(r.Context(), "Handled bulk index request", "documents", len(req), "errors", resp.Errors)
 }
 
 // HandleCommitRequest is an HTTP handler for committing and uploading index segments.
@@ -174,14 +317,486 @@ func (ws *WebService) HandleCommitRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	log.Println("Received commit and upload request.")
-	if err := ws.indexer.CommitAndUpload(); err != nil {
-		log.Printf("Error during commit and upload: %v", err)
+	slog.InfoContext(r.Context(), "Received commit and upload request")
+	if err := ws.indexerFor(r).CommitAndUpload(); err != nil {
+		slog.ErrorContext(r.Context(), "Error during commit and upload", "error", err)
 		http.Error(w, "Failed to commit and upload index", http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Index committed and uploaded successfully"))
-	log.Println("Handled commit and upload request.")
+	slog.InfoContext(r.Context(), "Handled commit and upload request")
+}
+
+// HandleCommitPolicyRequest is an HTTP handler that returns the automatic commit policy
+// with the changes it is waiting on (GET) or overrides it until the next restart (PUT),
+// with a JSON object such as {"max_docs": 1000, "max_bytes": 0, "max_interval": "30s"}.
+func (ws *WebService) HandleCommitPolicyRequest(w http.ResponseWriter, r *http.Request) {
+	idx := ws.indexerFor(r)
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var policy indexer.CommitPolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			slog.WarnContext(r.Context(), "Error unmarshalling commit policy request body", "error", err)
+			http.Error(w, fmt.Sprintf("Error parsing request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := idx.SetCommitPolicy(policy); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, indexer.ErrInvalidCommitPolicy) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("Failed to set commit policy: %v", err), status)
+			return
+		}
+		slog.InfoContext(r.Context(), "Handled commit policy update")
+	default:
+		http.Error(w, "Only GET and PUT methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(idx.CommitPolicy()); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding commit policy response", "error", err)
+	}
+}
+
+// HandleCompactionRequest is an HTTP handler reporting the segments of the index and the
+// progress of compactions at GET /compaction, and starting a compaction in the background
+// at POST /compaction, answered with 202 Accepted and the compaction status.
+func (ws *WebService) HandleCompactionRequest(w http.ResponseWriter, r *http.Request) {
+	idx := ws.indexerFor(r)
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := idx.StartCompaction("requested through the API"); err != nil {
+			switch {
+			case errors.Is(err, indexer.ErrCompactionRunning):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case errors.Is(err, indexer.ErrCompactionUnsupported):
+				http.Error(w, err.Error(), http.StatusNotImplemented)
+			default:
+				slog.ErrorContext(r.Context(), "Error starting compaction", "error", err)
+				http.Error(w, "Failed to start compaction", http.StatusInternalServerError)
+			}
+			return
+		}
+		slog.InfoContext(r.Context(), "Handled compaction request")
+		status = http.StatusAccepted
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(idx.CompactionStatus()); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding compaction status response", "error", err)
+	}
+}
+
+// HandleRolloverRequest is an HTTP handler reporting the rollover policy and the physical
+// indexes of the alias at GET /rollover, and rolling the active index over at
+// POST /rollover, answered with the updated status.
+func (ws *WebService) HandleRolloverRequest(w http.ResponseWriter, r *http.Request) {
+	idx := ws.indexerFor(r)
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if _, err := idx.Rollover(); err != nil {
+			switch {
+			case errors.Is(err, indexer.ErrReindexInProgress), errors.Is(err, indexer.ErrNotLeader):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				slog.ErrorContext(r.Context(), "Error rolling the index over", "error", err)
+				http.Error(w, "Failed to roll the index over", http.StatusInternalServerError)
+			}
+			return
+		}
+		slog.InfoContext(r.Context(), "Handled rollover request")
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(idx.RolloverStatus()); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding rollover status response", "error", err)
+	}
+}
+
+// HandleGCRequest is an HTTP handler reporting the retention policy and the last garbage
+// collection of the uploaded segments at GET /gc, and collecting them at POST /gc,
+// answered with the result. POST /gc?dry_run=true only lists the segments it would delete.
+func (ws *WebService) HandleGCRequest(w http.ResponseWriter, r *http.Request) {
+	idx := ws.indexerFor(r)
+	var resp interface{}
+	switch r.Method {
+	case http.MethodGet:
+		resp = idx.GCStatus()
+	case http.MethodPost:
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		result, err := idx.CollectSegments(r.Context(), dryRun)
+		if err != nil {
+			switch {
+			case errors.Is(err, indexer.ErrGCRunning):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case errors.Is(err, indexer.ErrGCUnsupported):
+				http.Error(w, err.Error(), http.StatusNotImplemented)
+			default:
+				slog.ErrorContext(r.Context(), "Error collecting segments", "error", err)
+				http.Error(w, "Failed to collect segments: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		slog.InfoContext(r.Context(), "Handled garbage collection request", "deleted", len(result.Deleted), "dry_run", dryRun)
+		resp = result
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding garbage collection response", "error", err)
+	}
+}
+
+// HandleDocumentRequest is an HTTP handler that returns the stored fields of the document
+// at GET /doc/{id}. The optional "fields" query parameter is a comma-separated projection.
+func (ws *WebService) HandleDocumentRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/doc/")
+	if id == "" {
+		http.Error(w, "Document ID is required", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := ws.indexerFor(r).GetDocument(id, indexer.ParseFieldsParam(r.URL.Query().Get("fields")))
+	if err != nil {
+		if errors.Is(err, indexer.ErrDocumentNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error getting document", "id", id, "error", err)
+		http.Error(w, fmt.Sprintf("Failed to get document %s", id), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding document response", "error", err)
+	}
+}
+
+// HandleStatsRequest is an HTTP handler that returns indexing statistics as JSON.
+func (ws *WebService) HandleStatsRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := ws.indexerFor(r).Stats()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error collecting index stats", "error", err)
+		http.Error(w, "Failed to collect index stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding stats response", "error", err)
+	}
+}
+
+// HandleSnapshotRequest is an HTTP handler that snapshots the index under the requested name.
+func (ws *WebService) HandleSnapshotRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(r.Context(), "Error unmarshalling snapshot request body", "error", err)
+		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := indexer.ValidateSnapshotName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, err := ws.indexerFor(r).Snapshot(req.Name)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating snapshot", "snapshot", req.Name, "error", err)
+		http.Error(w, fmt.Sprintf("Failed to create snapshot %s: %v", req.Name, err), snapshotErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding snapshot response", "error", err)
+	}
+	slog.InfoContext(r.Context(), "Handled snapshot request", "snapshot", req.Name)
+}
+
+// HandleRestoreRequest is an HTTP handler that restores a named snapshot into a fresh
+// index next to the current one. It is restricted to admins, as it replaces the index.
+func (ws *WebService) HandleRestoreRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(r.Context(), "Error unmarshalling restore request body", "error", err)
+		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := indexer.ValidateSnapshotName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, err := ws.indexerFor(r).Restore(req.Name)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error restoring snapshot", "snapshot", req.Name, "error", err)
+		http.Error(w, fmt.Sprintf("Failed to restore snapshot %s: %v", req.Name, err), snapshotErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding restore response", "error", err)
+	}
+	slog.InfoContext(r.Context(), "Handled restore request", "snapshot", req.Name, "path", info.IndexPath)
+}
+
+// snapshotErrorStatus maps snapshot and restore errors to HTTP status codes.
+func snapshotErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, indexer.ErrSnapshotNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, indexer.ErrSnapshotsUnsupported):
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+// HandleMappingRequest is an HTTP handler that returns the active index mapping (GET) or
+// applies a new one (PUT). Applying a mapping starts a reindex job and responds with
+// 202 Accepted and the job, whose progress is available at /jobs/{id}.
+func (ws *WebService) HandleMappingRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ws.indexerFor(r).Mapping()); err != nil {
+			slog.WarnContext(r.Context(), "Error encoding mapping response", "error", err)
+		}
+	case http.MethodPut:
+		newMapping := bleve.NewIndexMapping()
+		if err := json.NewDecoder(r.Body).Decode(newMapping); err != nil {
+			slog.WarnContext(r.Context(), "Error unmarshalling mapping request body", "error", err)
+			http.Error(w, "Error parsing request body: invalid mapping JSON", http.StatusBadRequest)
+			return
+		}
+
+		job, err := ws.indexerFor(r).UpdateMapping(newMapping)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error applying new mapping", "error", err)
+			http.Error(w, fmt.Sprintf("Failed to apply mapping: %v", err), jobErrorStatus(err))
+			return
+		}
+
+		writeAcceptedJob(w, job)
+		slog.InfoContext(r.Context(), "Handled mapping update request", "job", job.ID)
+	default:
+		http.Error(w, "Only GET and PUT methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandlePopularQueriesRequest is an HTTP handler that returns (GET) or replaces (PUT) the
+// popular queries offered as completions, a JSON array of {"text", "weight"} objects. They
+// are included in the completion dictionary uploaded by the next commit.
+func (ws *WebService) HandlePopularQueriesRequest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"queries": ws.indexerFor(r).PopularQueries()}); err != nil {
+			slog.WarnContext(r.Context(), "Error encoding popular queries response", "error", err)
+		}
+	case http.MethodPut:
+		var queries []suggest.Entry
+		if err := json.NewDecoder(r.Body).Decode(&queries); err != nil {
+			slog.WarnContext(r.Context(), "Error unmarshalling popular queries request body", "error", err)
+			http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := ws.indexerFor(r).SetPopularQueries(queries); err != nil {
+			slog.ErrorContext(r.Context(), "Error setting popular queries", "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, indexer.ErrInvalidSuggestions) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("Failed to set popular queries: %v", err), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		slog.InfoContext(r.Context(), "Handled popular queries update", "queries", len(queries))
+	default:
+		http.Error(w, "Only GET and PUT methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleReindexRequest is an HTTP handler that starts (or resumes) a reindex job and
+// responds with 202 Accepted and the job, whose progress is available at /jobs/{id}.
+func (ws *WebService) HandleReindexRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReindexRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		slog.WarnContext(r.Context(), "Error unmarshalling reindex request body", "error", err)
+		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		job *indexer.Job
+		err error
+	)
+	if req.ResumeJob != "" {
+		job, err = ws.indexerFor(r).ResumeJob(req.ResumeJob)
+	} else {
+		job, err = ws.indexerFor(r).Reindex(req.Source)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error starting reindex", "error", err)
+		http.Error(w, fmt.Sprintf("Failed to start reindex: %v", err), jobErrorStatus(err))
+		return
+	}
+
+	writeAcceptedJob(w, job)
+	slog.InfoContext(r.Context(), "Handled reindex request", "job", job.ID)
+}
+
+// HandleIngestRequest is an HTTP handler that ingests the documents of a connector source
+// and responds once they are indexed, with the ingestion stats. Documents failing
+// validation or fetching are skipped and reported in the stats. Sources outside the
+// IngestConfig scope are rejected with 403.
+func (ws *WebService) HandleIngestRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req IngestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(r.Context(), "Error unmarshalling ingest request body", "error", err)
+		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+		return
+	}
+	source, err := connector.NewScoped(req.Source, nil, ws.ingest.scope())
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, connector.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	stats, err := connector.Run(r.Context(), source, &pipelineIndexer{indexer: ws.indexerFor(r)}, connector.Options{BatchSize: req.BatchSize})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error ingesting source", "source", req.Source.Type, "indexed", stats.Indexed, "error", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "stats": stats})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding ingest response", "error", err)
+	}
+	slog.InfoContext(r.Context(), "Handled ingest request", "source", req.Source.Type, "indexed", stats.Indexed, "invalid", stats.Invalid)
+}
+
+// HandleJobsRequest is an HTTP handler that lists all jobs, most recent first.
+func (ws *WebService) HandleJobsRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"jobs": ws.indexerFor(r).Jobs()}); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding jobs response", "error", err)
+	}
+}
+
+// HandleJobRequest is an HTTP handler that returns the state of the job at GET /jobs/{id}
+// and cancels it with POST /jobs/{id}/cancel.
+func (ws *WebService) HandleJobRequest(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+
+	var (
+		job *indexer.Job
+		err error
+	)
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		job, err = ws.indexerFor(r).Job(id)
+	case action == "cancel" && r.Method == http.MethodPost:
+		job, err = ws.indexerFor(r).CancelJob(id)
+	case action == "" || action == "cancel":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), jobErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		slog.WarnContext(r.Context(), "Error encoding job response", "error", err)
+	}
+}
+
+// writeAcceptedJob responds with 202 Accepted, the job and its location.
+func writeAcceptedJob(w http.ResponseWriter, job *indexer.Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		slog.Warn("Error encoding job response", "error", err)
+	}
+}
+
+// jobErrorStatus maps job errors to HTTP status codes.
+func jobErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, indexer.ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, indexer.ErrReindexInProgress), errors.Is(err, indexer.ErrJobNotResumable), errors.Is(err, indexer.ErrJobNotRunning):
+		return http.StatusConflict
+	case errors.Is(err, indexer.ErrInvalidMapping), errors.Is(err, indexer.ErrInvalidSource):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
 }
@@ -0,0 +1,302 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// snapshotsDir is the directory (or key prefix) under which snapshots are stored,
+// separate from the regularly uploaded segments.
+const snapshotsDir = "snapshots"
+
+var (
+	// ErrSnapshotsUnsupported is returned when the configured storage cannot store snapshots.
+	ErrSnapshotsUnsupported = errors.New("storage does not support snapshots")
+	// ErrSnapshotNotFound is returned when restoring a snapshot that does not exist.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+)
+
+// SnapshotStorage is implemented by IndexSegmentStorage backends that can store named
+// snapshots of the whole index and retrieve them for restore.
+type SnapshotStorage interface {
+	UploadSnapshot(name, snapshotPath string) error
+	DownloadSnapshot(name, destPath string) error
+}
+
+// SnapshotInfo describes a snapshot created or restored by the Indexer.
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	IndexPath string    `json:"index_path,omitempty"` // Set on restore: the index now being served
+	DocCount  uint64    `json:"doc_count"`
+	Files     int       `json:"files"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidateSnapshotName checks that name can be used as a snapshot name.
+func ValidateSnapshotName(name string) error {
+	if !collectionNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q: only letters, digits, '_' and '-' are allowed", name)
+	}
+	return nil
+}
+
+// Snapshot creates a consistent copy of the index and stores it under the given name.
+// The index mutex and the upload file lock are held while the index directory is copied,
+// so no writes or segment uploads can interleave with the copy; a compaction merging the
+// segments is waited for.
+func (i *Indexer) Snapshot(name string) (*SnapshotInfo, error) {
+	if err := ValidateSnapshotName(name); err != nil {
+		return nil, err
+	}
+	snapshots, ok := i.storage.(SnapshotStorage)
+	if !ok {
+		return nil, ErrSnapshotsUnsupported
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.waitForMerge()
+
+	release, err := i.acquireUploadLock()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	docCount, err := i.index.DocCount()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document count: %w", err)
+	}
+
+	tempDir, err := os.MkdirTemp(filepath.Dir(i.indexPath), ".snapshot-"+name+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot staging directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	slog.Info("Creating snapshot", "snapshot", name, "path", i.indexPath)
+	stagedPath := filepath.Join(tempDir, filepath.Base(i.indexPath))
+	if err := copyDir(i.indexPath, stagedPath); err != nil {
+		recordOperation("snapshot", err)
+		return nil, fmt.Errorf("failed to copy index for snapshot %s: %w", name, err)
+	}
+	manifest, err := buildSegmentManifest(stagedPath, "", "", name)
+	if err != nil {
+		recordOperation("snapshot", err)
+		return nil, err
+	}
+	if err := snapshots.UploadSnapshot(name, stagedPath); err != nil {
+		recordOperation("snapshot", err)
+		return nil, fmt.Errorf("failed to upload snapshot %s: %w", name, err)
+	}
+	recordOperation("snapshot", nil)
+
+	info := &SnapshotInfo{Name: name, DocCount: docCount, Files: len(manifest.Files), CreatedAt: manifest.CreatedAt}
+	for _, f := range manifest.Files {
+		info.SizeBytes += f.Size
+	}
+	slog.Info("Snapshot created", "snapshot", name, "documents", info.DocCount, "files", info.Files)
+	return info, nil
+}
+
+// Restore downloads the named snapshot next to the index and switches the Indexer to serve
+// the restored index, which becomes the write index of the alias so that it is reopened
+// after a restart. The previous index is closed but left on disk.
+func (i *Indexer) Restore(name string) (*SnapshotInfo, error) {
+	if err := ValidateSnapshotName(name); err != nil {
+		return nil, err
+	}
+	snapshots, ok := i.storage.(SnapshotStorage)
+	if !ok {
+		return nil, ErrSnapshotsUnsupported
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.waitForMerge()
+
+	if i.reindex != nil {
+		if i.reindex.Status == JobRunning {
+			return nil, fmt.Errorf("%w: job %s", ErrReindexInProgress, i.reindex.ID)
+		}
+		// An unfinished job was built from the index being replaced.
+		i.discardJob(i.reindex)
+	}
+	indexName := fmt.Sprintf("%s_restored_%s_%s", filepath.Base(i.basePath), name, time.Now().UTC().Format("20060102T150405Z"))
+	indexPath := filepath.Join(filepath.Dir(i.basePath), indexName)
+	if _, err := os.Stat(indexPath); err == nil {
+		return nil, fmt.Errorf("restore target %s already exists", indexPath)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to stat restore target %s: %w", indexPath, err)
+	}
+
+	slog.Info("Restoring snapshot", "snapshot", name, "path", indexPath)
+	if err := snapshots.DownloadSnapshot(name, indexPath); err != nil {
+		recordOperation("restore", err)
+		os.RemoveAll(indexPath)
+		return nil, fmt.Errorf("failed to download snapshot %s: %w", name, err)
+	}
+	restored, err := bleve.Open(indexPath)
+	if err != nil {
+		recordOperation("restore", err)
+		return nil, fmt.Errorf("failed to open restored index at %s: %w", indexPath, err)
+	}
+	docCount, err := restored.DocCount()
+	if err != nil {
+		restored.Close()
+		recordOperation("restore", err)
+		return nil, fmt.Errorf("failed to get document count of restored index: %w", err)
+	}
+	if err := i.restoreAlias(indexName); err != nil {
+		restored.Close()
+		os.RemoveAll(indexPath)
+		recordOperation("restore", err)
+		return nil, err
+	}
+
+	if err := i.index.Close(); err != nil {
+		slog.Error("Failed to close previous index", "path", i.indexPath, "error", err)
+	}
+	previousPath := i.indexPath
+	i.index = restored
+	i.indexPath = indexPath
+	// Writes logged since the last upload went to the replaced index.
+	if err := i.wal.truncate(); err != nil {
+		slog.Error("Failed to truncate the write-ahead log", "error", err)
+	}
+	recordOperation("restore", nil)
+	slog.Info("Snapshot restored", "snapshot", name, "path", indexPath, "previous_path", previousPath)
+
+	return &SnapshotInfo{Name: name, IndexPath: indexPath, DocCount: docCount, CreatedAt: time.Now().UTC()}, nil
+}
+
+// restoreAlias makes the restored index of the given name the write index of the alias in
+// place of the current one, starting the alias if the index was never rolled over. The
+// read-only indexes are kept. Callers must hold i.mu.
+func (i *Indexer) restoreAlias(name string) error {
+	next := &IndexAlias{Name: filepath.Base(i.basePath)}
+	if i.alias != nil {
+		next = i.alias.clone()
+		if n := len(next.Indices); n > 0 && next.Indices[n-1].Name == next.WriteIndex {
+			next.Indices = next.Indices[:n-1]
+		}
+	}
+	next.WriteIndex = name
+	next.Indices = append(next.Indices, PhysicalIndex{Name: name, CreatedAt: time.Now().UTC()})
+	if err := saveAlias(i.basePath, next); err != nil {
+		return err
+	}
+	i.alias = next
+	i.uploadAlias()
+	return nil
+}
+
+// UploadSnapshot copies the snapshot directory to
+// storageDir/[tenants/<tenant>/][collection/]snapshots/<name>
+// together with a manifest.
+func (s *LocalFileStorage) UploadSnapshot(name, snapshotPath string) error {
+	destDir := s.snapshotDir(name)
+	if _, err := os.Stat(destDir); err == nil {
+		return fmt.Errorf("snapshot %s already exists", name)
+	}
+	if err := copyDir(snapshotPath, destDir); err != nil {
+		os.RemoveAll(destDir)
+		return fmt.Errorf("failed to store snapshot %s: %w", name, err)
+	}
+
+	manifest, err := buildSegmentManifest(snapshotPath, s.tenant, s.collection, name)
+	if err != nil {
+		return err
+	}
+	if err := writeManifest(destDir, manifest); err != nil {
+		return err
+	}
+	slog.Info("Stored snapshot", "snapshot", name, "dest", destDir)
+	return nil
+}
+
+// DownloadSnapshot copies the files listed in the snapshot's manifest to destPath.
+func (s *LocalFileStorage) DownloadSnapshot(name, destPath string) error {
+	srcDir := s.snapshotDir(name)
+	manifest, err := ReadSegmentManifest(srcDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+		}
+		return err
+	}
+	for _, f := range manifest.Files {
+		rel := filepath.FromSlash(f.Path)
+		if err := copyFile(filepath.Join(srcDir, rel), filepath.Join(destPath, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotDir returns the directory holding the named snapshot.
+func (s *LocalFileStorage) snapshotDir(name string) string {
+	return filepath.Join(s.baseDir(), snapshotsDir, name)
+}
+
+// UploadSnapshot uploads the snapshot directory under the
+// [tenants/<tenant>/][collection/]snapshots/<name>/ prefix, followed by its manifest.
+func (s *S3Storage) UploadSnapshot(name, snapshotPath string) error {
+	prefix := s.keyPrefix() + snapshotsDir + "/" + name + "/"
+	manifest, err := buildSegmentManifest(snapshotPath, s.tenant, s.collection, name)
+	if err != nil {
+		return err
+	}
+	if err := s.uploadFiles(snapshotPath, prefix, manifest.Files); err != nil {
+		return err
+	}
+	return s.uploadManifest(prefix, manifest)
+}
+
+// DownloadSnapshot downloads the files listed in the snapshot's manifest to destPath,
+// concurrently and verified like DownloadSegment does.
+func (s *S3Storage) DownloadSnapshot(name, destPath string) error {
+	prefix := s.keyPrefix() + snapshotsDir + "/"
+	manifest, err := s.downloadManifest(prefix + name + "/")
+	if err != nil {
+		if isS3NotFound(err) {
+			return fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+		}
+		return err
+	}
+	for _, f := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) {
+			return fmt.Errorf("snapshot %s: manifest file path %q escapes the snapshot directory", name, f.Path)
+		}
+	}
+	return s.downloadFiles(prefix, name, destPath, manifest)
+}
+
+// copyDir recursively copies the regular files of src into dst.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", path, err)
+		}
+		destPath := filepath.Join(dst, relPath)
+		if d.IsDir() {
+			if err := os.MkdirAll(destPath, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", destPath, err)
+			}
+			return nil
+		}
+		return copyFile(path, destPath)
+	})
+}
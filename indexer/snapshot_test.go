@@ -0,0 +1,90 @@
+package indexer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexer_SnapshotAndRestore(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "indexer_snapshot")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	indexPath := filepath.Join(tempDir, "index")
+	idx, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+
+	docs := map[string]interface{}{
+		"doc1": map[string]interface{}{"title": "first"},
+		"doc2": map[string]interface{}{"title": "second"},
+	}
+	if _, err := idx.BulkIndexDocuments(docs); err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+
+	info, err := idx.Snapshot("nightly")
+	if err != nil {
+		t.Fatalf("Snapshot returned an error: %v", err)
+	}
+	if info.DocCount != 2 || info.Files == 0 {
+		t.Errorf("Unexpected snapshot info: %+v", info)
+	}
+	if _, err := ReadSegmentManifest(filepath.Join(tempDir, "segments", snapshotsDir, "nightly")); err != nil {
+		t.Errorf("Expected a manifest in the stored snapshot: %v", err)
+	}
+	if _, err := idx.Snapshot("nightly"); err == nil {
+		t.Error("Expected an error when overwriting an existing snapshot")
+	}
+
+	// Changes made after the snapshot must be gone once it is restored.
+	if err := idx.IndexDocument("doc3", map[string]interface{}{"title": "third"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	info, err = idx.Restore("nightly")
+	if err != nil {
+		t.Fatalf("Restore returned an error: %v", err)
+	}
+	if filepath.Dir(info.IndexPath) != tempDir || info.IndexPath == indexPath || info.DocCount != 2 {
+		t.Errorf("Unexpected restore info: %+v", info)
+	}
+	stats, err := idx.Stats()
+	if err != nil {
+		t.Fatalf("Stats returned an error: %v", err)
+	}
+	if stats.DocCount != 2 {
+		t.Errorf("Expected the restored index to serve 2 documents, got %d", stats.DocCount)
+	}
+
+	if _, err := idx.Restore("missing"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Expected ErrSnapshotNotFound, got %v", err)
+	}
+	if _, err := idx.Snapshot("../escape"); err == nil {
+		t.Error("Expected an error for an invalid snapshot name")
+	}
+
+	// The restored index is the write index of the alias, so it is reopened on restart.
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+	reopened, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to reopen indexer: %v", err)
+	}
+	defer reopened.Close()
+	if stats, err := reopened.Stats(); err != nil || stats.DocCount != 2 {
+		t.Errorf("Expected the reopened indexer to serve the 2 restored documents, got %+v (%v)", stats, err)
+	}
+	if alias := reopened.RolloverStatus().Alias; alias == nil || alias.WriteIndex != filepath.Base(info.IndexPath) || len(alias.Indices) != 1 {
+		t.Errorf("Expected the restored index as the write index of the alias, got %+v", alias)
+	}
+}
@@ -0,0 +1,156 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotStaged is returned when loading a shard on an indexer that was not staged.
+var ErrNotStaged = errors.New("indexer is not staged for a resharding")
+
+// shardJournalPath returns the journal of the writes staged on the index at basePath
+// while it waits for its shard to be loaded. It is kept next to the index directory, like
+// the write-ahead log, so the download of the shard doesn't replace it.
+func shardJournalPath(basePath string) string {
+	return filepath.Join(filepath.Dir(basePath), "."+filepath.Base(basePath)+".shard-journal")
+}
+
+// resumeStaging reopens the journal left by a staged indexer that was restarted before
+// its shard was loaded.
+func (i *Indexer) resumeStaging() error {
+	path := shardJournalPath(i.basePath)
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	journal, records, err := openWAL(path)
+	if err != nil {
+		return err
+	}
+	slog.Info("Indexer is staged for a resharding, journaling writes until its shard is loaded", "journaled", len(records))
+	i.journal = journal
+	return nil
+}
+
+// StageShard makes the indexer of a new shard journal the writes it receives, mirrored by
+// the routers while a resharding copies the documents of the existing shards, instead of
+// applying them. LoadShard then replaces the index with the copy of the shard and replays
+// the journal over it, so writes made during the copy are neither lost nor overwritten by
+// it, and documents deleted during the copy stay deleted. Staging a staged indexer does
+// nothing.
+func (i *Indexer) StageShard() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.journal != nil {
+		return nil
+	}
+	journal, _, err := openWAL(shardJournalPath(i.basePath))
+	if err != nil {
+		return err
+	}
+	i.journal = journal
+	slog.Info("Indexer staged for a resharding, journaling writes until its shard is loaded")
+	return nil
+}
+
+// Staged reports whether the indexer journals its writes until its shard is loaded.
+func (i *Indexer) Staged() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.journal != nil
+}
+
+// LoadShard replaces the index with the latest upload of the index named name, the new
+// shard built by a resharding, then replays the writes journaled since StageShard over it
+// and stops journaling. It fails with ErrNotStaged unless the indexer was staged.
+func (i *Indexer) LoadShard(name string) error {
+	if err := checkSegmentName(name); err != nil {
+		return err
+	}
+	source, ok := i.storage.(segmentSync)
+	if !ok {
+		return fmt.Errorf("the storage can't download segments")
+	}
+	i.mu.Lock()
+	indexPath, staged := i.indexPath, i.journal != nil
+	i.mu.Unlock()
+	if !staged {
+		return ErrNotStaged
+	}
+
+	manifest, err := latestUpload(source, name)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return fmt.Errorf("index %s was never uploaded", name)
+	}
+	slog.Info("Loading new shard", "segment", manifest.Segment, "path", indexPath)
+	var replayErr error
+	err = i.installSegment(source, manifest, indexPath, func() {
+		// Writes logged before the swap went to the empty staged index.
+		if err := i.wal.truncate(); err != nil {
+			slog.Error("Failed to truncate the write-ahead log", "error", err)
+		}
+		replayErr = i.replayJournal()
+	})
+	recordOperation("load_shard", errors.Join(err, replayErr))
+	if err != nil {
+		return err
+	}
+	if replayErr != nil {
+		return replayErr
+	}
+	slog.Info("Loaded new shard", "segment", manifest.Segment, "path", indexPath)
+	return nil
+}
+
+// replayJournal applies the journaled writes to the index, logging those applied, and
+// removes the journal. Writes that fail on replay are skipped, as when replaying the
+// write-ahead log. Callers must hold i.mu.
+func (i *Indexer) replayJournal() error {
+	records, _, err := readWAL(i.journal.path)
+	if err != nil {
+		return err
+	}
+	for n, record := range records {
+		if err := i.applyWrite(record); err != nil {
+			slog.Warn("Skipping journaled write", "record", n, "op", record.Op, "error", err)
+			continue
+		}
+		if err := i.logWrite(record); err != nil {
+			return err
+		}
+		i.recordWrite(record)
+	}
+	journal := i.journal
+	i.journal = nil
+	if err := journal.close(); err != nil {
+		slog.Error("Failed to close the shard journal", "error", err)
+	}
+	if err := os.Remove(journal.path); err != nil {
+		// Left behind, it would stage the indexer again on restart.
+		return fmt.Errorf("failed to remove the shard journal: %w", err)
+	}
+	slog.Info("Replayed the writes journaled during the resharding", "writes", len(records))
+	return nil
+}
+
+// journalGroup journals a group of writes on a staged indexer instead of applying them,
+// reporting the result to every caller. Callers must hold i.mu.
+func (i *Indexer) journalGroup(group []*writeOp) {
+	records := make([]walRecord, len(group))
+	for n, op := range group {
+		records[n] = op.record
+	}
+	err := i.journal.append(records...)
+	for _, op := range group {
+		if err != nil {
+			recordOperation(op.record.Op, err)
+		}
+		op.done <- err
+	}
+}
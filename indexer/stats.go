@@ -0,0 +1,195 @@
+package indexer
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus collectors for indexing operations. They are registered with the default
+// registry so they are exposed by promhttp.Handler() on the web service.
+var (
+	indexOperationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "indexer_operations_total",
+		Help: "Total number of indexing operations, partitioned by operation and result.",
+	}, []string{"operation", "result"})
+
+	indexedDocumentsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "indexer_documents_indexed_total",
+		Help: "Total number of documents indexed (including bulk operations).",
+	})
+
+	deletedDocumentsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "indexer_documents_deleted_total",
+		Help: "Total number of documents deleted.",
+	})
+
+	batchSizeHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "indexer_batch_size_documents",
+		Help:    "Number of documents per bulk index batch.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8), // 1 .. 16384
+	})
+
+	writeGroupSizeHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "indexer_write_group_documents",
+		Help:    "Number of documents per batch of concurrent writes applied together.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 6), // 1 .. 1024
+	})
+
+	commitDurationHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "indexer_commit_duration_seconds",
+		Help:    "Duration of commit and upload operations.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	lastUploadTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "indexer_last_upload_timestamp_seconds",
+		Help: "Unix timestamp of the last successful segment upload.",
+	})
+)
+
+// IndexStats is a point-in-time snapshot of the indexer's state and throughput counters.
+type IndexStats struct {
+	DocCount           uint64     `json:"doc_count"`
+	IndexSizeBytes     int64      `json:"index_size_bytes"`
+	DocsIndexed        int64      `json:"docs_indexed"`
+	DocsDeleted        int64      `json:"docs_deleted"`
+	Batches            int64      `json:"batches"`
+	LastBatchSize      int64      `json:"last_batch_size"`
+	AvgBatchSize       float64    `json:"avg_batch_size"`
+	Commits            int64      `json:"commits"`
+	LastCommitDuration string     `json:"last_commit_duration"`
+	LastUploadTime     *time.Time `json:"last_upload_time,omitempty"`
+	WALEntries         int64      `json:"wal_entries"` // Writes logged since the last upload
+	StartTime          time.Time  `json:"start_time"`
+	Uptime             string     `json:"uptime"`
+}
+
+// indexCounters tracks throughput counters since the indexer was started.
+// Counters are updated atomically so they can be read without holding the index mutex.
+type indexCounters struct {
+	startTime       time.Time
+	docsIndexed     atomic.Int64
+	docsDeleted     atomic.Int64
+	batches         atomic.Int64
+	batchedDocs     atomic.Int64
+	lastBatchSize   atomic.Int64
+	commits         atomic.Int64
+	lastCommitNanos atomic.Int64
+
+	mu             sync.RWMutex // Protects lastUploadTime
+	lastUploadTime time.Time
+}
+
+func newIndexCounters() *indexCounters {
+	return &indexCounters{startTime: time.Now().UTC()}
+}
+
+func (c *indexCounters) recordIndexed(n int) {
+	c.docsIndexed.Add(int64(n))
+	indexedDocumentsTotal.Add(float64(n))
+}
+
+func (c *indexCounters) recordDeleted(n int) {
+	c.docsDeleted.Add(int64(n))
+	deletedDocumentsTotal.Add(float64(n))
+}
+
+func (c *indexCounters) recordBatch(size int) {
+	c.batches.Add(1)
+	c.batchedDocs.Add(int64(size))
+	c.lastBatchSize.Store(int64(size))
+	batchSizeHistogram.Observe(float64(size))
+}
+
+func (c *indexCounters) recordCommit(d time.Duration, uploaded bool) {
+	c.commits.Add(1)
+	c.lastCommitNanos.Store(int64(d))
+	commitDurationHistogram.Observe(d.Seconds())
+	if uploaded {
+		now := time.Now().UTC()
+		c.mu.Lock()
+		c.lastUploadTime = now
+		c.mu.Unlock()
+		lastUploadTimestamp.Set(float64(now.Unix()))
+	}
+}
+
+// recordOperation increments the operation counter with a success/error result label.
+func recordOperation(operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	indexOperationsTotal.WithLabelValues(operation, result).Inc()
+}
+
+// Stats returns a snapshot of the index statistics, including the document count
+// reported by Bleve and the size of the index directory on disk.
+func (i *Indexer) Stats() (IndexStats, error) {
+	// A restore may swap the index, so read it under the mutex.
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	docCount, err := i.index.DocCount()
+	if err != nil {
+		return IndexStats{}, fmt.Errorf("failed to get document count: %w", err)
+	}
+
+	size, err := dirSize(i.indexPath)
+	if err != nil {
+		return IndexStats{}, fmt.Errorf("failed to compute index size for %s: %w", i.indexPath, err)
+	}
+
+	c := i.counters
+	stats := IndexStats{
+		DocCount:           docCount,
+		IndexSizeBytes:     size,
+		DocsIndexed:        c.docsIndexed.Load(),
+		DocsDeleted:        c.docsDeleted.Load(),
+		Batches:            c.batches.Load(),
+		LastBatchSize:      c.lastBatchSize.Load(),
+		Commits:            c.commits.Load(),
+		LastCommitDuration: time.Duration(c.lastCommitNanos.Load()).String(),
+		WALEntries:         i.walEntries(),
+		StartTime:          c.startTime,
+		Uptime:             time.Since(c.startTime).Round(time.Second).String(),
+	}
+	if stats.Batches > 0 {
+		stats.AvgBatchSize = float64(c.batchedDocs.Load()) / float64(stats.Batches)
+	}
+	c.mu.RLock()
+	if !c.lastUploadTime.IsZero() {
+		lastUpload := c.lastUploadTime
+		stats.LastUploadTime = &lastUpload
+	}
+	c.mu.RUnlock()
+
+	return stats, nil
+}
+
+// dirSize returns the total size in bytes of all regular files under path.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,69 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexer_Stats(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "indexer_stats")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+
+	if err := idx.IndexDocument("doc1", map[string]interface{}{"title": "first"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	docs := map[string]interface{}{
+		"doc2": map[string]interface{}{"title": "second"},
+		"doc3": map[string]interface{}{"title": "third"},
+	}
+	if _, err := idx.BulkIndexDocuments(docs); err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+	if err := idx.DeleteDocument("doc1"); err != nil {
+		t.Fatalf("DeleteDocument returned an error: %v", err)
+	}
+	if err := idx.CommitAndUpload(); err != nil {
+		t.Fatalf("CommitAndUpload returned an error: %v", err)
+	}
+
+	stats, err := idx.Stats()
+	if err != nil {
+		t.Fatalf("Stats returned an error: %v", err)
+	}
+
+	if stats.DocCount != 2 {
+		t.Errorf("Expected doc count 2, got %d", stats.DocCount)
+	}
+	if stats.DocsIndexed != 3 {
+		t.Errorf("Expected 3 docs indexed, got %d", stats.DocsIndexed)
+	}
+	if stats.DocsDeleted != 1 {
+		t.Errorf("Expected 1 doc deleted, got %d", stats.DocsDeleted)
+	}
+	if stats.Batches != 1 || stats.LastBatchSize != 2 || stats.AvgBatchSize != 2 {
+		t.Errorf("Unexpected batch stats: batches=%d last=%d avg=%f", stats.Batches, stats.LastBatchSize, stats.AvgBatchSize)
+	}
+	if stats.Commits != 1 {
+		t.Errorf("Expected 1 commit, got %d", stats.Commits)
+	}
+	if stats.LastUploadTime == nil {
+		t.Error("Expected last upload time to be set after a successful commit")
+	}
+	if stats.IndexSizeBytes <= 0 {
+		t.Errorf("Expected positive index size, got %d", stats.IndexSizeBytes)
+	}
+}
@@ -1,15 +1,23 @@
 package indexer
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"common/archive"
+	"common/tenant"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
@@ -17,19 +25,32 @@ const (
 	maxS3UploadRetries = 3               // Number of retries for S3 uploads
 	initialS3Backoff   = 1 * time.Second // Initial backoff duration
 	maxS3Backoff       = 8 * time.Second // Maximum backoff duration
+
+	defaultUploadConcurrency = 4 // Files of a segment uploaded at once
 )
 
 // IndexSegmentStorage defines the interface for storing index segments.
 // In a real system, this would interact with S3, GCS, etc.
+// Storages that segments can also be read back from implement SegmentSource.
 type IndexSegmentStorage interface {
 	UploadSegment(segmentPath string) error
-	// Potentially add methods for listing/downloading segments if needed later by other services.
 }
 
-// S3Storage implements IndexSegmentStorage for AWS S3.
+// S3Storage implements IndexSegmentStorage and SegmentSource for AWS S3.
 type S3Storage struct {
-	uploader *s3manager.Uploader
-	bucket   string
+	client      s3iface.S3API
+	uploader    *s3manager.Uploader
+	downloader  *s3manager.Downloader
+	bucket      string
+	tenant      string              // Optional tenant whose keys are prefixed with tenants/<tenant>/
+	collection  string              // Optional collection name used as the top-level key prefix
+	compression archive.Compression // How segments are packaged for upload; empty means none
+	sseMode     string              // Server-side encryption of uploaded objects; see SetServerSideEncryption
+	sseKMSKeyID string              // KMS key of SSEModeKMS; empty uses the account's default key
+	// Files of a segment uploaded and downloaded at once; each file is itself transferred
+	// in parallel parts once it is larger than a part.
+	uploadConcurrency   int
+	downloadConcurrency int
 }
 
 // NewS3Storage creates a new S3Storage instance.
@@ -47,14 +68,75 @@ func NewS3Storage(bucketName string) (*S3Storage, error) {
 		return nil, fmt.Errorf("failed to create AWS session: %w", err)
 	}
 
-	// Create an S3 Uploader from the session
-	uploader := s3manager.NewUploader(sess)
+	slog.Info("Initialized S3Storage", "bucket", bucketName)
+	return newS3StorageWithClient(bucketName, s3.New(sess)), nil
+}
 
-	log.Printf("Initialized S3Storage for bucket: %s", bucketName)
+// newS3StorageWithClient returns an S3Storage using the given S3 client.
+func newS3StorageWithClient(bucketName string, client s3iface.S3API) *S3Storage {
 	return &S3Storage{
-		uploader: uploader,
-		bucket:   bucketName,
-	}, nil
+		client:   client,
+		uploader: s3manager.NewUploaderWithClient(client),
+		downloader: s3manager.NewDownloaderWithClient(client, func(d *s3manager.Downloader) {
+			d.PartSize = downloadPartSize
+		}),
+		bucket:              bucketName,
+		uploadConcurrency:   defaultUploadConcurrency,
+		downloadConcurrency: defaultDownloadConcurrency,
+	}
+}
+
+// SetUploadConcurrency sets how many files of a segment UploadSegment uploads at once.
+func (s *S3Storage) SetUploadConcurrency(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("upload concurrency must be positive, got %d", n)
+	}
+	s.uploadConcurrency = n
+	return nil
+}
+
+// SetMultipartOptions tunes the multipart uploads of files larger than partSize bytes:
+// they are split into parts of partSize bytes, concurrency of which are uploaded at once.
+// Up to the upload concurrency times concurrency parts are buffered in memory at a time.
+func (s *S3Storage) SetMultipartOptions(partSize int64, concurrency int) error {
+	if partSize < s3manager.MinUploadPartSize {
+		return fmt.Errorf("multipart part size must be at least %d bytes, got %d", s3manager.MinUploadPartSize, partSize)
+	}
+	if concurrency <= 0 {
+		return fmt.Errorf("multipart concurrency must be positive, got %d", concurrency)
+	}
+	s.uploader.PartSize = partSize
+	s.uploader.Concurrency = concurrency
+	return nil
+}
+
+// SetCollection scopes subsequent uploads to the given collection: segment keys are
+// prefixed with the collection name and the manifest records it.
+func (s *S3Storage) SetCollection(collection string) error {
+	if err := ValidateCollectionName(collection); err != nil {
+		return err
+	}
+	s.collection = collection
+	return nil
+}
+
+// SetTenant scopes subsequent uploads to the given tenant: keys are prefixed with
+// tenants/<tenant>/, before the collection, and the manifest records the tenant.
+func (s *S3Storage) SetTenant(id string) error {
+	if err := tenant.Validate(id); err != nil {
+		return err
+	}
+	s.tenant = id
+	return nil
+}
+
+// keyPrefix returns the prefix of the keys of the storage's tenant and collection.
+func (s *S3Storage) keyPrefix() string {
+	prefix := tenant.StoragePrefix(s.tenant)
+	if s.collection != "" {
+		prefix += s.collection + "/"
+	}
+	return prefix
 }
 
 // uploadFileWithRetry handles the S3 upload of a single file with retry logic.
@@ -69,23 +151,30 @@ func (s *S3Storage) uploadFileWithRetry(filePath, s3Key string, file io.ReadSeek
 			return fmt.Errorf("failed to seek file %s to start for retry: %w", filePath, err)
 		}
 
-		_, uploadErr = s.uploader.Upload(&s3manager.UploadInput{
+		input := &s3manager.UploadInput{
 			Bucket: aws.String(s.bucket),
 			Key:    aws.String(s3Key),
 			Body:   file,
-		})
+		}
+		if s.sseMode != SSEModeNone {
+			input.ServerSideEncryption = aws.String(s.sseMode)
+		}
+		if s.sseKMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(s.sseKMSKeyID)
+		}
+		_, uploadErr = s.uploader.Upload(input)
 
 		if uploadErr == nil {
 			break // Success
 		}
 
-		log.Printf("Attempt %d/%d failed to upload file %s to S3: %v", attempt+1, maxS3UploadRetries, filePath, uploadErr)
+		slog.Warn("Failed to upload file to S3", "file", filePath, "attempt", attempt+1, "max_attempts", maxS3UploadRetries, "error", uploadErr)
 		if attempt < maxS3UploadRetries-1 {
 			backoff := time.Duration(1<<attempt) * initialS3Backoff
 			if backoff > maxS3Backoff {
 				backoff = maxS3Backoff
 			}
-			log.Printf("Retrying in %v...", backoff)
+			slog.Info("Retrying upload", "file", filePath, "backoff", backoff)
 			time.Sleep(backoff)
 		}
 	}
@@ -96,10 +185,81 @@ func (s *S3Storage) uploadFileWithRetry(filePath, s3Key string, file io.ReadSeek
 	return nil
 }
 
+// uploadFiles uploads the given files of dir under prefix, uploadConcurrency at a time.
+// It stops starting uploads after the first failure and returns that failure.
+func (s *S3Storage) uploadFiles(dir, prefix string, files []ManifestFile) error {
+	return forEachFile(files, s.uploadConcurrency, func(f ManifestFile) error {
+		path := filepath.Join(dir, filepath.FromSlash(f.Path))
+		// Construct the S3 key; manifest paths already use forward slashes as S3 expects.
+		s3Key := prefix + f.Path
+
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", path, err)
+		}
+		defer file.Close()
+		slog.Debug("Uploading file to S3", "file", path, "bucket", s.bucket, "key", s3Key)
+		return s.uploadFileWithRetry(path, s3Key, file)
+	})
+}
+
+// forEachFile calls fn for every file, from up to concurrency goroutines. Once a call
+// fails no more calls are started, and the first error is returned.
+func forEachFile(files []ManifestFile, concurrency int, fn func(ManifestFile) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		queue    = make(chan ManifestFile)
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range queue {
+				if err := fn(f); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, f := range files {
+		if failed() {
+			break
+		}
+		queue <- f
+	}
+	close(queue)
+	wg.Wait()
+	return firstErr
+}
+
+// uploadManifest uploads the manifest under the given key prefix.
+func (s *S3Storage) uploadManifest(prefix string, manifest *SegmentManifest) error {
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal segment manifest: %w", err)
+	}
+	manifestKey := prefix + ManifestFileName
+	if err := s.uploadFileWithRetry(ManifestFileName, manifestKey, bytes.NewReader(manifestData)); err != nil {
+		return fmt.Errorf("failed to upload segment manifest: %w", err)
+	}
+	return nil
+}
+
 // UploadSegment uploads the contents of the segment directory to S3.
 // The segmentPath is expected to be a directory.
-// Each file within the directory (and its subdirectories) will be uploaded
-// to S3 with a key prefixed by a timestamped segment name.
+// Each file within the directory (and its subdirectories) that changed since the last
+// upload will be uploaded to S3 with a key prefixed by a timestamped segment name.
 // For example, if segmentPath is "/tmp/myindex" and a file is "/tmp/myindex/data/file1.dat",
 // the S3 key might be "myindex_20230101T120000Z/data/file1.dat".
 func (s *S3Storage) UploadSegment(segmentPath string) error {
@@ -117,60 +277,57 @@ func (s *S3Storage) UploadSegment(segmentPath string) error {
 
 	// Create a unique prefix for this segment upload (e.g., base name + timestamp)
 	segmentBaseName := filepath.Base(segmentPath)
-	timestamp := time.Now().UTC().Format("20060102T150405Z")      // YYYYMMDDTHHMMSSZ
-	s3Prefix := fmt.Sprintf("%s_%s/", segmentBaseName, timestamp) // Add trailing slash for directory-like prefix
-
-	log.Printf("Starting upload of index segment from %s to S3 bucket %s with prefix %s", segmentPath, s.bucket, s3Prefix)
-
-	err = filepath.WalkDir(segmentPath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err // Return error if walking fails
-		}
+	timestamp := time.Now().UTC().Format("20060102T150405Z") // YYYYMMDDTHHMMSSZ
+	segmentName := fmt.Sprintf("%s_%s", segmentBaseName, timestamp)
+	s3Prefix := s.keyPrefix() + segmentName + "/" // Add trailing slash for directory-like prefix
 
-		if d.IsDir() {
-			return nil // Skip directories, we only upload files
-		}
-
-		// Calculate the relative path from the segmentPath
-		// e.g., if segmentPath="/tmp/myindex" and path="/tmp/myindex/data/file1.dat",
-		// then relPath will be "data/file1.dat"
-		relPath, err := filepath.Rel(segmentPath, path)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path for %s: %w", path, err)
-		}
-
-		// Construct the S3 key
-		s3Key := filepath.Join(s3Prefix, relPath)
-		// S3 uses forward slashes, ensure that even on Windows
-		s3Key = filepath.ToSlash(s3Key)
-
-		file, err := os.Open(path)
-		if err != nil {
-			return fmt.Errorf("failed to open file %s: %w", path, err)
-		}
-		defer file.Close() // Ensure file is closed after upload
+	manifest, err := buildSegmentManifest(segmentPath, s.tenant, s.collection, segmentName)
+	if err != nil {
+		return err
+	}
 
-		log.Printf("Uploading %s to s3://%s/%s", path, s.bucket, s3Key)
+	if compressed(s.compression) {
+		return s.uploadArchive(segmentPath, s3Prefix, manifest)
+	}
 
-		if err := s.uploadFileWithRetry(path, s3Key, file); err != nil {
-			return err
-		}
+	// Only files that changed since the last successful upload are uploaded; the manifest
+	// points unchanged files at the earlier upload holding them.
+	previous, err := loadUploadState(segmentPath)
+	if err != nil {
+		slog.Warn("Ignoring previous upload state, uploading all files", "error", err)
+		previous = nil
+	}
+	if previous != nil && (previous.Tenant != s.tenant || previous.Collection != s.collection) {
+		previous = nil
+	}
+	changed := planIncrementalUpload(manifest, previous)
 
-		return nil
-	})
+	slog.Info("Starting upload of index segment to S3", "segment", segmentPath, "bucket", s.bucket, "prefix", s3Prefix, "changed_files", len(changed), "files", len(manifest.Files))
 
-	if err != nil {
+	if err := s.uploadFiles(segmentPath, s3Prefix, changed); err != nil {
 		return fmt.Errorf("error during segment upload to S3: %w", err)
 	}
 
-	log.Printf("Successfully uploaded index segment from %s to S3 bucket %s with prefix %s", segmentPath, s.bucket, s3Prefix)
+	// Upload the manifest last so its presence signals a complete segment.
+	if err := s.uploadManifest(s3Prefix, manifest); err != nil {
+		return err
+	}
+	if err := saveUploadState(segmentPath, manifest); err != nil {
+		// The upload succeeded; the next one will just upload every file again.
+		slog.Info("Warning", "error", err)
+	}
+
+	slog.Info("Uploaded index segment to S3", "segment", segmentPath, "bucket", s.bucket, "prefix", s3Prefix)
 	return nil
 }
 
 // LocalFileStorage implements IndexSegmentStorage for local filesystem.
 // This is a stand-in for cloud storage like S3, kept for local testing/development purposes.
 type LocalFileStorage struct {
-	storageDir string
+	storageDir  string
+	tenant      string              // Optional tenant whose data is kept under storageDir/tenants/<tenant>/
+	collection  string              // Optional collection name used as a subdirectory of storageDir
+	compression archive.Compression // How segments are packaged in storage; empty means none
 }
 
 // NewLocalFileStorage creates a new LocalFileStorage instance, ensuring the directory exists.
@@ -219,10 +376,37 @@ func NewLocalFileStorage(dir string) (*LocalFileStorage, error) {
 	return &LocalFileStorage{storageDir: dir}, nil
 }
 
+// SetCollection scopes subsequent uploads to the given collection: segments are stored
+// under storageDir/<collection>/ and the manifest records the collection.
+func (s *LocalFileStorage) SetCollection(collection string) error {
+	if err := ValidateCollectionName(collection); err != nil {
+		return err
+	}
+	s.collection = collection
+	return nil
+}
+
+// SetTenant scopes subsequent uploads to the given tenant: segments are stored under
+// storageDir/tenants/<tenant>/, before the collection, and the manifest records the tenant.
+func (s *LocalFileStorage) SetTenant(id string) error {
+	if err := tenant.Validate(id); err != nil {
+		return err
+	}
+	s.tenant = id
+	return nil
+}
+
+// baseDir returns the directory holding the segments of the storage's tenant and collection.
+func (s *LocalFileStorage) baseDir() string {
+	return filepath.Join(s.storageDir, filepath.FromSlash(tenant.StoragePrefix(s.tenant)), s.collection)
+}
+
 // UploadSegment copies the contents of the segment directory to the local storage directory.
-// It creates a subdirectory within storageDir that mirrors the structure of the segmentPath.
+// It creates a subdirectory within storageDir that mirrors the structure of the segmentPath,
+// and writes a SegmentManifest describing the copied files. Files whose checksum matches the
+// previous upload's manifest are not copied again, and files removed from the segment are deleted.
 func (s *LocalFileStorage) UploadSegment(segmentPath string) error {
-	log.Printf("Uploading index segment from %s to local storage %s", segmentPath, s.storageDir)
+	slog.Info("Uploading index segment to local storage", "segment", segmentPath, "storage_dir", s.storageDir)
 
 	info, err := os.Stat(segmentPath)
 	if err != nil {
@@ -238,12 +422,31 @@ func (s *LocalFileStorage) UploadSegment(segmentPath string) error {
 
 	// Create a subdirectory within the storage directory that matches the base name of the segment path.
 	// This keeps uploads organized, especially if multiple segments are uploaded.
-	destSegmentDir := filepath.Join(s.storageDir, filepath.Base(segmentPath))
+	destSegmentDir := filepath.Join(s.baseDir(), filepath.Base(segmentPath))
 	if err := os.MkdirAll(destSegmentDir, 0755); err != nil {
 		return fmt.Errorf("failed to create destination directory %s: %w", destSegmentDir, err)
 	}
 
-	// Walk the source segment directory and copy files to the destination.
+	manifest, err := buildSegmentManifest(segmentPath, s.tenant, s.collection, filepath.Base(segmentPath))
+	if err != nil {
+		return err
+	}
+	if compressed(s.compression) {
+		return s.uploadArchive(segmentPath, destSegmentDir, manifest)
+	}
+
+	// A previous upload of the segment lets unchanged files be skipped.
+	previous, err := ReadSegmentManifest(destSegmentDir)
+	if err != nil {
+		previous = nil
+	}
+	prevFiles := manifestFiles(previous)
+	changed := make(map[string]bool)
+	for _, f := range planIncrementalUpload(manifest, previous) {
+		changed[f.Path] = true
+	}
+
+	// Walk the source segment directory and copy changed files to the destination.
 	err = filepath.WalkDir(segmentPath, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err // Propagate errors during walk
@@ -266,7 +469,7 @@ func (s *LocalFileStorage) UploadSegment(segmentPath string) error {
 			if err := os.MkdirAll(destPath, 0755); err != nil {
 				return fmt.Errorf("failed to create destination subdirectory %s: %w", destPath, err)
 			}
-		} else {
+		} else if changed[filepath.ToSlash(relPath)] || !fileExists(destPath) {
 			// Copy the file.
 			if err := copyFile(path, destPath); err != nil {
 				return fmt.Errorf("failed to copy file from %s to %s: %w", path, destPath, err)
@@ -279,10 +482,30 @@ func (s *LocalFileStorage) UploadSegment(segmentPath string) error {
 		return fmt.Errorf("error during local segment upload: %w", err)
 	}
 
-	log.Printf("Successfully 'uploaded' index segment from %s to local storage %s", segmentPath, destSegmentDir)
+	// Remove files that no longer exist in the segment, e.g. merged away by Bleve.
+	current := manifestFiles(manifest)
+	for path := range prevFiles {
+		if _, ok := current[path]; !ok {
+			if err := os.Remove(filepath.Join(destSegmentDir, filepath.FromSlash(path))); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove stale segment file %s: %w", path, err)
+			}
+		}
+	}
+
+	if err := writeManifest(destSegmentDir, manifest); err != nil {
+		return err
+	}
+
+	slog.Info("Uploaded index segment to local storage", "segment", segmentPath, "dest", destSegmentDir)
 	return nil
 }
 
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 // copyFile is a helper function to copy a file from src to dst.
 func copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
@@ -2,10 +2,21 @@ package indexer
 
 import (
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 func TestLocalFileStorage_New(t *testing.T) {
@@ -238,3 +249,96 @@ func TestLocalFileStorage_UploadSegment(t *testing.T) {
 		}
 	})
 }
+
+// s3TestServer is an S3 endpoint accepting PUTs and recording the objects and the
+// highest number of concurrent uploads.
+type s3TestServer struct {
+	mu          sync.Mutex
+	objects     map[string]string
+	encryption  map[string]string // Server-side encryption requested for each object
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *s3TestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+		return
+	}
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.mu.Unlock()
+	time.Sleep(20 * time.Millisecond) // Let concurrent uploads overlap
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.inFlight--
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	s.objects[key] = string(body)
+	s.encryption[key] = r.Header.Get("X-Amz-Server-Side-Encryption") + " " + r.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")
+	s.mu.Unlock()
+}
+
+func TestS3Storage_UploadSegment_Concurrent(t *testing.T) {
+	backend := &s3TestServer{objects: make(map[string]string), encryption: make(map[string]string)}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	storage := newS3StorageWithClient("bucket", s3.New(sess))
+	if err := storage.SetUploadConcurrency(0); err == nil {
+		t.Error("Expected an error for a zero upload concurrency, got nil")
+	}
+	if err := storage.SetMultipartOptions(1024, 2); err == nil {
+		t.Error("Expected an error for a part size below the S3 minimum, got nil")
+	}
+	if err := storage.SetMultipartOptions(2*s3manager.MinUploadPartSize, 2); err != nil {
+		t.Fatalf("SetMultipartOptions returned an error: %v", err)
+	}
+	if err := storage.SetUploadConcurrency(3); err != nil {
+		t.Fatalf("SetUploadConcurrency returned an error: %v", err)
+	}
+	if err := storage.SetServerSideEncryption(SSEModeKMS, "alias/search"); err != nil {
+		t.Fatalf("SetServerSideEncryption returned an error: %v", err)
+	}
+
+	segmentDir := filepath.Join(t.TempDir(), "myindex")
+	for i := 0; i < 6; i++ {
+		path := filepath.Join(segmentDir, "store", fmt.Sprintf("%03d.zap", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("segment %d", i)), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := storage.UploadSegment(segmentDir); err != nil {
+		t.Fatalf("UploadSegment returned an error: %v", err)
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if len(backend.objects) != 7 {
+		t.Errorf("Expected 6 files and a manifest to be uploaded, got %d objects", len(backend.objects))
+	}
+	for key, body := range backend.objects {
+		if strings.HasSuffix(key, "/store/002.zap") && body != "segment 2" {
+			t.Errorf("Unexpected contents of %s: %q", key, body)
+		}
+		if got := backend.encryption[key]; got != "aws:kms alias/search" {
+			t.Errorf("Expected %s to be encrypted with the KMS key, got %q", key, got)
+		}
+	}
+	if backend.maxInFlight < 2 || backend.maxInFlight > 3 {
+		t.Errorf("Expected between 2 and 3 concurrent uploads, got %d", backend.maxInFlight)
+	}
+}
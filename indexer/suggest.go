@@ -0,0 +1,118 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"common/suggest"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// SuggestTitleField is the stored document field whose values become completions.
+const SuggestTitleField = "title"
+
+// suggestScanBatch is the number of documents read per page when collecting titles.
+const suggestScanBatch = 1000
+
+// ErrInvalidSuggestions is returned for popular queries that can't be used as completions.
+var ErrInvalidSuggestions = errors.New("invalid suggestions")
+
+// popularQueriesPath returns the file persisting the popular queries of the index at
+// indexPath. It is kept next to the index directory so it isn't uploaded with segments.
+func popularQueriesPath(indexPath string) string {
+	return indexPath + ".queries.json"
+}
+
+// SetPopularQueries replaces the popular queries offered as completions, weighted e.g.
+// by how often they were searched. They are persisted and included in the completion
+// dictionary from the next commit on.
+func (i *Indexer) SetPopularQueries(queries []suggest.Entry) error {
+	for _, q := range queries {
+		if strings.TrimSpace(q.Text) == "" || q.Weight <= 0 {
+			return fmt.Errorf("%w: query %q must have text and a positive weight", ErrInvalidSuggestions, q.Text)
+		}
+	}
+	data, err := json.Marshal(queries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal popular queries: %w", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if err := os.WriteFile(popularQueriesPath(i.basePath), data, 0644); err != nil {
+		return fmt.Errorf("failed to save popular queries: %w", err)
+	}
+	i.popularQueries = queries
+	return nil
+}
+
+// PopularQueries returns the popular queries offered as completions.
+func (i *Indexer) PopularQueries() []suggest.Entry {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]suggest.Entry(nil), i.popularQueries...)
+}
+
+// loadPopularQueries reads the popular queries saved by SetPopularQueries, if any.
+func loadPopularQueries(indexPath string) ([]suggest.Entry, error) {
+	data, err := os.ReadFile(popularQueriesPath(indexPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read popular queries: %w", err)
+	}
+	var queries []suggest.Entry
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal popular queries: %w", err)
+	}
+	return queries, nil
+}
+
+// writeSuggestions builds the completion dictionary from the stored titles and the
+// popular queries and writes it into the index directory, so it is uploaded with the
+// segment. Every document adds 1 to the weight of its title. Callers must hold i.mu.
+func (i *Indexer) writeSuggestions() (int, error) {
+	b := suggest.NewBuilder()
+	for _, q := range i.popularQueries {
+		b.Add(q.Text, q.Weight)
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), suggestScanBatch, 0, false)
+	req.Fields = []string{SuggestTitleField}
+	req.SortBy([]string{"_id"})
+	for {
+		result, err := i.index.Search(req)
+		if err != nil {
+			return 0, fmt.Errorf("failed to collect titles: %w", err)
+		}
+		for _, hit := range result.Hits {
+			switch title := hit.Fields[SuggestTitleField].(type) {
+			case string:
+				b.Add(title, 1)
+			case []interface{}:
+				for _, t := range title {
+					if s, ok := t.(string); ok {
+						b.Add(s, 1)
+					}
+				}
+			}
+		}
+		if len(result.Hits) < suggestScanBatch {
+			break
+		}
+		req.SearchAfter = []string{result.Hits[len(result.Hits)-1].ID}
+	}
+
+	entries := b.Entries()
+	if err := suggest.WriteFile(filepath.Join(i.indexPath, suggest.FileName), entries); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,75 @@
+package indexer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"common/suggest"
+)
+
+func TestIndexer_CommitBuildsSuggestions(t *testing.T) {
+	tempDir := t.TempDir()
+	storageDir := filepath.Join(tempDir, "segments")
+	storage, err := NewLocalFileStorage(storageDir)
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	indexPath := filepath.Join(tempDir, "index")
+	idx, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+
+	docs := map[string]interface{}{
+		"doc1": map[string]interface{}{"title": "Red Running Shoes"},
+		"doc2": map[string]interface{}{"title": "red running shoes"},
+		"doc3": map[string]interface{}{"title": "Rain Jacket"},
+		"doc4": map[string]interface{}{"body": "no title"},
+	}
+	if _, err := idx.BulkIndexDocuments(docs); err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+	queries := []suggest.Entry{{Text: "running shoes", Weight: 5}}
+	if err := idx.SetPopularQueries(queries); err != nil {
+		t.Fatalf("SetPopularQueries returned an error: %v", err)
+	}
+	if err := idx.SetPopularQueries([]suggest.Entry{{Text: " ", Weight: 1}}); !errors.Is(err, ErrInvalidSuggestions) {
+		t.Errorf("Expected ErrInvalidSuggestions for an empty query, got %v", err)
+	}
+	if err := idx.CommitAndUpload(); err != nil {
+		t.Fatalf("CommitAndUpload returned an error: %v", err)
+	}
+
+	// The dictionary is uploaded with the segment.
+	entries, err := suggest.ReadFile(filepath.Join(storageDir, "index", suggest.FileName))
+	if err != nil {
+		t.Fatalf("Failed to read the uploaded dictionary: %v", err)
+	}
+	want := []suggest.Entry{
+		{Text: "running shoes", Weight: 5},
+		{Text: "Red Running Shoes", Weight: 2},
+		{Text: "Rain Jacket", Weight: 1},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Unexpected dictionary: got %+v, want %+v", entries, want)
+	}
+
+	// Popular queries survive a restart.
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+	reopened, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to reopen indexer: %v", err)
+	}
+	defer reopened.Close()
+	if got := reopened.PopularQueries(); !reflect.DeepEqual(got, queries) {
+		t.Errorf("Expected the saved popular queries %+v, got %+v", queries, got)
+	}
+	if _, err := os.Stat(popularQueriesPath(indexPath)); err != nil {
+		t.Errorf("Expected the popular queries file next to the index: %v", err)
+	}
+}
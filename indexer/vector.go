@@ -0,0 +1,48 @@
+package indexer
+
+import (
+	"fmt"
+
+	"common/vector"
+)
+
+// SetVectorFields sets the dense vector fields of documents, e.g. embeddings of their text
+// computed by an upstream model, by name. Documents are indexed with their vectors as
+// arrays of numbers, which Searchers index for kNN queries; vectors that don't fit their
+// field fail the write with vector.ErrInvalidVector. Nil checks none.
+func (i *Indexer) SetVectorFields(fields map[string]vector.Field) error {
+	var checked map[string]vector.Field
+	for name, f := range fields {
+		if err := f.Validate(); err != nil {
+			return fmt.Errorf("vector field %s: %w", name, err)
+		}
+		if checked == nil {
+			checked = make(map[string]vector.Field, len(fields))
+		}
+		checked[name] = f
+	}
+	i.vectorFields = checked
+	return nil
+}
+
+// checkVectors checks the vector fields of a document. Documents without them pass.
+func (i *Indexer) checkVectors(id string, data interface{}) error {
+	fields, ok := data.(map[string]interface{})
+	if len(i.vectorFields) == 0 || !ok {
+		return nil
+	}
+	for name, f := range i.vectorFields {
+		value, ok := fields[name]
+		if !ok || value == nil {
+			continue
+		}
+		v, err := vector.FromValue(value)
+		if err == nil {
+			err = f.Check(v)
+		}
+		if err != nil {
+			return fmt.Errorf("document %s, field %s: %w", id, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"common/vector"
+)
+
+func TestIndexer_VectorFields(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	defer idx.Close()
+	if err := idx.SetVectorFields(map[string]vector.Field{"embedding": {Dims: 3, Similarity: "hamming"}}); err == nil {
+		t.Fatal("Expected an error for an unsupported similarity")
+	}
+	if err := idx.SetVectorFields(map[string]vector.Field{"embedding": {Dims: 3}}); err != nil {
+		t.Fatalf("SetVectorFields returned an error: %v", err)
+	}
+
+	valid := map[string]interface{}{"title": "Boots", "embedding": []interface{}{0.1, 0.2, 0.3}}
+	if err := idx.IndexDocument("a", valid); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if err := idx.IndexDocument("plain", map[string]interface{}{"title": "No embedding"}); err != nil {
+		t.Errorf("Expected documents without vectors to be indexed, got %v", err)
+	}
+	for name, embedding := range map[string]interface{}{
+		"wrong dims": []interface{}{0.1, 0.2},
+		"zero":       []interface{}{0.0, 0.0, 0.0},
+		"not array":  "0.1,0.2,0.3",
+	} {
+		err := idx.IndexDocument("b", map[string]interface{}{"embedding": embedding})
+		if !errors.Is(err, vector.ErrInvalidVector) {
+			t.Errorf("%s: expected ErrInvalidVector, got %v", name, err)
+		}
+	}
+
+	_, err = idx.BulkIndexDocuments(map[string]interface{}{
+		"c": map[string]interface{}{"embedding": []interface{}{1.0, 0.0, 0.0}},
+		"d": map[string]interface{}{"embedding": []interface{}{1.0}},
+	})
+	if err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+	if _, err := idx.GetDocument("c", nil); err != nil {
+		t.Errorf("Expected the valid document to be indexed, got %v", err)
+	}
+	if _, err := idx.GetDocument("d", nil); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected the document with an invalid vector to be skipped, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// VersionField is the stored field holding the version of a document: 1 once it's first
+// indexed, incremented by every write of it. Only documents that are JSON objects carry
+// a version; a document that doesn't exist has version 0.
+const VersionField = "_version"
+
+// AnyVersion makes a write unconditional.
+const AnyVersion int64 = -1
+
+// ErrVersionConflict is returned for conditional writes of a document whose current
+// version isn't the expected one, e.g. because another producer updated it meanwhile.
+var ErrVersionConflict = errors.New("version conflict")
+
+// IndexDocumentIfVersion indexes a document like IndexDocument if its current version is
+// version (0 if it must not exist yet), failing with ErrVersionConflict otherwise; with
+// AnyVersion the write is unconditional. It returns the new version of the document.
+func (i *Indexer) IndexDocumentIfVersion(id string, data interface{}, version int64) (int64, error) {
+	return i.IndexDocumentWithPipeline(id, data, version, "")
+}
+
+// DeleteDocumentIfVersion deletes a document like DeleteDocument if its current version
+// is version, failing with ErrVersionConflict otherwise; with AnyVersion the delete is
+// unconditional.
+func (i *Indexer) DeleteDocumentIfVersion(id string, version int64) error {
+	_, err := i.submitIfVersion(walRecord{Op: walOpDelete, ID: id}, version)
+	return err
+}
+
+// versionOf returns the version of a stored document.
+func versionOf(fields map[string]interface{}) int64 {
+	if v, ok := fields[VersionField].(float64); ok {
+		return int64(v)
+	}
+	return 0
+}
+
+// currentVersions returns the versions of the documents of the writes of group that
+// exist. Callers must hold i.mu.
+func (i *Indexer) currentVersions(group []*writeOp) (map[string]int64, error) {
+	var ids []string
+	for _, op := range group {
+		ids = append(ids, op.record.ids()...)
+	}
+	versions := make(map[string]int64, len(ids))
+	if len(ids) == 0 {
+		return versions, nil
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery(ids), len(ids), 0, false)
+	req.Fields = []string{VersionField}
+	result, err := i.index.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up document versions: %w", err)
+	}
+	for _, hit := range result.Hits {
+		versions[hit.ID] = versionOf(hit.Fields)
+	}
+	return versions, nil
+}
+
+// versionWrite checks the expected version of op against the current versions and
+// returns its record with the new versions set in its documents, and the new version of
+// the document of an index or delete. Bulk documents are never conditional.
+func versionWrite(op *writeOp, versions map[string]int64) (walRecord, int64, error) {
+	record := op.record
+	switch record.Op {
+	case walOpIndex, walOpDelete:
+		current := versions[record.ID]
+		if op.version != AnyVersion && op.version != current {
+			return record, 0, fmt.Errorf("%w: document %s is at version %d, not %d", ErrVersionConflict, record.ID, current, op.version)
+		}
+		if record.Op == walOpDelete {
+			return record, 0, nil
+		}
+		record.Data = withVersion(record.Data, current+1)
+		return record, current + 1, nil
+	case walOpBulk:
+		docs := make(map[string]interface{}, len(record.Docs))
+		for id, data := range record.Docs {
+			docs[id] = withVersion(data, versions[id]+1)
+		}
+		record.Docs = docs
+	}
+	return record, 0, nil
+}
+
+// commitVersions records the versions set by an applied write.
+func commitVersions(record walRecord, versions map[string]int64) {
+	switch record.Op {
+	case walOpDelete:
+		delete(versions, record.ID)
+	case walOpBulkDelete:
+		for _, id := range record.IDs {
+			delete(versions, id)
+		}
+	case walOpIndex:
+		versions[record.ID]++
+	case walOpBulk:
+		for id := range record.Docs {
+			versions[id]++
+		}
+	}
+}
+
+// withVersion returns the document with its version set, leaving data unchanged.
+// Documents that aren't JSON objects are returned as they are.
+func withVersion(data interface{}, version int64) interface{} {
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+	versioned := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		versioned[k] = v
+	}
+	versioned[VersionField] = version
+	return versioned
+}
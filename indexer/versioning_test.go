@@ -0,0 +1,172 @@
+package indexer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newVersioningTestIndexer(t *testing.T) *Indexer {
+	t.Helper()
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return idx
+}
+
+func TestIndexer_Versioning(t *testing.T) {
+	idx := newVersioningTestIndexer(t)
+
+	version, err := idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "v1"}, 0)
+	if err != nil || version != 1 {
+		t.Fatalf("Expected version 1 for a new document, got %d (%v)", version, err)
+	}
+	if _, err := idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "again"}, 0); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected a conflict creating an existing document, got %v", err)
+	}
+	if err := idx.IndexDocument("a", map[string]interface{}{"title": "v2"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if _, err := idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "stale"}, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected a conflict for a stale write, got %v", err)
+	}
+	if version, err = idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "v3"}, 2); err != nil || version != 3 {
+		t.Fatalf("Expected version 3, got %d (%v)", version, err)
+	}
+	doc, err := idx.GetDocument("a", []string{"title"})
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	if doc.Version != 3 || doc.Fields["title"] != "v3" || doc.Fields[VersionField] != nil {
+		t.Errorf("Expected version 3 of the document, got %+v", doc)
+	}
+
+	// Bulk writes bump the versions of their documents.
+	if _, err := idx.BulkIndexDocuments(map[string]interface{}{"a": map[string]interface{}{"title": "v4"}, "b": map[string]interface{}{"title": "new"}}); err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+	for id, want := range map[string]int64{"a": 4, "b": 1} {
+		if doc, err := idx.GetDocument(id, nil); err != nil || doc.Version != want {
+			t.Errorf("Expected version %d of %s, got %+v (%v)", want, id, doc, err)
+		}
+	}
+
+	if err := idx.DeleteDocumentIfVersion("a", 3); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected a conflict for a stale delete, got %v", err)
+	}
+	if err := idx.DeleteDocumentIfVersion("a", 4); err != nil {
+		t.Fatalf("DeleteDocumentIfVersion returned an error: %v", err)
+	}
+	if _, err := idx.GetDocument("a", nil); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected the document to be deleted, got %v", err)
+	}
+	if version, err = idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "reborn"}, 0); err != nil || version != 1 {
+		t.Errorf("Expected a deleted document to start over at version 1, got %d (%v)", version, err)
+	}
+}
+
+func TestIndexer_VersioningConcurrentWriters(t *testing.T) {
+	idx := newVersioningTestIndexer(t)
+	if err := idx.IndexDocument("counter", map[string]interface{}{"title": "start"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+
+	// Writers all read version 1; exactly one of them may update the document.
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := idx.IndexDocumentIfVersion("counter", map[string]interface{}{"title": "update"}, 1)
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrVersionConflict) {
+				t.Errorf("Unexpected error %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Errorf("Expected exactly one writer to succeed, got %d", succeeded)
+	}
+}
+
+func TestIndexer_VersioningWALReplay(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	indexPath := filepath.Join(tempDir, "index")
+	idx, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	if _, err := idx.EnableWAL(); err != nil {
+		t.Fatalf("EnableWAL returned an error: %v", err)
+	}
+	if _, err := idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "v1"}, 0); err != nil {
+		t.Fatalf("IndexDocumentIfVersion returned an error: %v", err)
+	}
+	if _, err := idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "v2"}, 1); err != nil {
+		t.Fatalf("IndexDocumentIfVersion returned an error: %v", err)
+	}
+	if _, err := idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "stale"}, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Expected a conflict for a stale write, got %v", err)
+	}
+
+	// Conditional writes whose batch fails aren't replayed either.
+	idx.mu.Lock()
+	index := idx.index
+	idx.index = failingBatchIndex{index}
+	idx.mu.Unlock()
+	if _, err := idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "lost"}, 2); err == nil {
+		t.Fatal("Expected IndexDocumentIfVersion to fail with the batch")
+	}
+	if err := idx.DeleteDocumentIfVersion("a", 2); err == nil {
+		t.Fatal("Expected DeleteDocumentIfVersion to fail with the batch")
+	}
+	idx.mu.Lock()
+	idx.index = index
+	idx.mu.Unlock()
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	if err := os.RemoveAll(indexPath); err != nil {
+		t.Fatalf("Failed to remove index: %v", err)
+	}
+	idx, err = NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to reopen indexer: %v", err)
+	}
+	defer idx.Close()
+	if n, err := idx.EnableWAL(); err != nil || n != 2 {
+		t.Fatalf("Expected 2 writes to be replayed, got %d (%v)", n, err)
+	}
+	doc, err := idx.GetDocument("a", []string{"title"})
+	if err != nil {
+		t.Fatalf("GetDocument returned an error: %v", err)
+	}
+	if doc.Version != 2 || doc.Fields["title"] != "v2" {
+		t.Errorf("Expected version 2 of the document after replay, got %+v", doc)
+	}
+	if version, err := idx.IndexDocumentIfVersion("a", map[string]interface{}{"title": "v3"}, 2); err != nil || version != 3 {
+		t.Errorf("Expected version 3 after replay, got %d (%v)", version, err)
+	}
+}
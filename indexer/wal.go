@@ -0,0 +1,266 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// Operations recorded in the write-ahead log.
+const (
+	walOpIndex      = "index"
+	walOpDelete     = "delete"
+	walOpBulk       = "bulk_index"
+	walOpBulkDelete = "bulk_delete"
+)
+
+// walRecord is a write recorded in the write-ahead log.
+type walRecord struct {
+	Op   string                 `json:"op"`
+	ID   string                 `json:"id,omitempty"`   // Index and delete operations
+	Data interface{}            `json:"data,omitempty"` // Index operations
+	Docs map[string]interface{} `json:"docs,omitempty"` // Bulk index operations
+	IDs  []string               `json:"ids,omitempty"`  // Bulk delete operations
+}
+
+// writeAheadLog records the writes made since the last successful upload, so they can be
+// replayed into the index after a crash. Every record is one line holding the hex CRC-32C
+// of its JSON encoding, a space and the JSON itself; a line that doesn't match its
+// checksum is a write torn by the crash, and ends the log. Records are synced to disk
+// once their write is applied and before it is acknowledged, so writes that failed are
+// never replayed.
+type writeAheadLog struct {
+	path    string
+	file    *os.File
+	entries int64 // Records appended since the last truncation
+}
+
+// walPath returns the write-ahead log of the index at indexPath. It is kept next to the
+// index directory, like the upload state, so it isn't uploaded with the segment.
+func walPath(indexPath string) string {
+	return filepath.Join(filepath.Dir(indexPath), "."+filepath.Base(indexPath)+".wal")
+}
+
+// openWAL opens the write-ahead log at path, creating it if needed, and returns it with
+// the records it holds. A torn record at the end is dropped from the file.
+func openWAL(path string) (*writeAheadLog, []walRecord, error) {
+	records, valid, err := readWAL(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open write-ahead log %s: %w", path, err)
+	}
+	// Drop a torn record so new records aren't appended after it.
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to truncate write-ahead log %s: %w", path, err)
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to seek write-ahead log %s: %w", path, err)
+	}
+	return &writeAheadLog{path: path, file: file, entries: int64(len(records))}, records, nil
+}
+
+// readWAL returns the records of the log at path and the length of the valid prefix of
+// the file. A missing file holds no records.
+func readWAL(path string) ([]walRecord, int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open write-ahead log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var (
+		records []walRecord
+		valid   int64
+		r       = bufio.NewReader(f)
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				slog.Warn("Dropping torn record at the end of the write-ahead log", "path", path)
+			}
+			return records, valid, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read write-ahead log %s: %w", path, err)
+		}
+		record, ok := decodeWALRecord(line)
+		if !ok {
+			slog.Warn("Dropping the write-ahead log from a record not matching its checksum", "path", path, "offset", valid)
+			return records, valid, nil
+		}
+		records = append(records, record)
+		valid += int64(len(line))
+	}
+}
+
+// decodeWALRecord decodes a log line, reporting whether it is intact.
+func decodeWALRecord(line []byte) (walRecord, bool) {
+	sum, payload, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	var record walRecord
+	if !ok || string(sum) != walChecksum(payload) {
+		return record, false
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, false
+	}
+	return record, true
+}
+
+// walChecksum returns the hex CRC-32C of a record's JSON encoding.
+func walChecksum(payload []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(payload, castagnoliTable))
+}
+
+// append writes records and syncs them to disk at once. A nil log records nothing.
+func (w *writeAheadLog) append(records ...walRecord) error {
+	if w == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, record := range records {
+		payload, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode write-ahead log record: %w", err)
+		}
+		buf.WriteString(walChecksum(payload))
+		buf.WriteByte(' ')
+		buf.Write(payload)
+		buf.WriteByte('\n')
+	}
+	if _, err := w.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to append to write-ahead log %s: %w", w.path, err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log %s: %w", w.path, err)
+	}
+	w.entries += int64(len(records))
+	return nil
+}
+
+// truncate discards every record, once the writes they hold are safely uploaded.
+func (w *writeAheadLog) truncate() error {
+	if w == nil {
+		return nil
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate write-ahead log %s: %w", w.path, err)
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek write-ahead log %s: %w", w.path, err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log %s: %w", w.path, err)
+	}
+	w.entries = 0
+	return nil
+}
+
+// close closes the log file, keeping its records for the next start.
+func (w *writeAheadLog) close() error {
+	if w == nil {
+		return nil
+	}
+	return w.file.Close()
+}
+
+// EnableWAL makes the indexer record every applied write in a write-ahead log next to
+// the index before acknowledging it, so writes made since the last successful
+// CommitAndUpload survive a crash. Writes left in the log by a previous run are replayed
+// into the index first; it returns how many. Writes that fail again on replay are
+// logged and skipped.
+func (i *Indexer) EnableWAL() (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.wal != nil {
+		return 0, fmt.Errorf("write-ahead log already enabled")
+	}
+	wal, records, err := openWAL(walPath(i.basePath))
+	if err != nil {
+		return 0, err
+	}
+	for n, record := range records {
+		if err := i.applyWrite(record); err != nil {
+			slog.Warn("Skipping write-ahead log record", "record", n, "op", record.Op, "error", err)
+		}
+	}
+	if len(records) > 0 {
+		slog.Info("Replayed writes from the write-ahead log", "writes", len(records), "path", wal.path)
+	}
+	i.wal = wal
+	return len(records), nil
+}
+
+// logWrite records applied writes in the write-ahead log, if enabled.
+// Callers must hold i.mu.
+func (i *Indexer) logWrite(records ...walRecord) error {
+	if err := i.wal.append(records...); err != nil {
+		for _, record := range records {
+			recordOperation(record.Op, err)
+		}
+		slog.Error("Failed to append to the write-ahead log", "error", err)
+		return err
+	}
+	return nil
+}
+
+// applyWrite applies a write read from the write-ahead log, then the writes of the
+// sub-documents of its documents. Callers must hold i.mu.
+func (i *Indexer) applyWrite(record walRecord) error {
+	if err := i.applyDocumentWrite(record); err != nil {
+		return err
+	}
+	if len(i.nestedFields) == 0 {
+		return nil
+	}
+	batch := i.index.NewBatch()
+	if err := i.addNestedToBatch(i.index, batch, []walRecord{record}); err != nil {
+		return err
+	}
+	return i.index.Batch(batch)
+}
+
+// applyDocumentWrite applies the write of record to its documents. Callers must hold i.mu.
+func (i *Indexer) applyDocumentWrite(record walRecord) error {
+	switch record.Op {
+	case walOpIndex:
+		return i.indexDocument(record.ID, record.Data)
+	case walOpDelete:
+		return i.deleteDocument(record.ID)
+	case walOpBulk:
+		return i.bulkIndexDocuments(record.Docs)
+	case walOpBulkDelete:
+		for _, id := range record.IDs {
+			if err := i.deleteDocument(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", record.Op)
+	}
+}
+
+// walEntries returns the number of writes in the write-ahead log. Callers must hold i.mu.
+func (i *Indexer) walEntries() int64 {
+	if i.wal == nil {
+		return 0
+	}
+	return i.wal.entries
+}
@@ -0,0 +1,154 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// bleveIndex embeds a bleve.Index under a name that doesn't clash with its Index method.
+type bleveIndex = bleve.Index
+
+// failingBatchIndex is an index whose batches fail, as when its disk is full.
+type failingBatchIndex struct {
+	bleveIndex
+}
+
+func (failingBatchIndex) Batch(*bleve.Batch) error {
+	return &os.PathError{Op: "write", Path: "index", Err: syscall.ENOSPC}
+}
+
+func TestIndexer_WALReplay(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	indexPath := filepath.Join(tempDir, "index")
+	idx, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	if n, err := idx.EnableWAL(); err != nil || n != 0 {
+		t.Fatalf("EnableWAL on a fresh index returned %d, %v", n, err)
+	}
+	if err := idx.IndexDocument("doc1", map[string]interface{}{"title": "first"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+	if _, err := idx.BulkIndexDocuments(map[string]interface{}{
+		"doc2": map[string]interface{}{"title": "second"},
+		"doc3": map[string]interface{}{"title": "third"},
+	}); err != nil {
+		t.Fatalf("BulkIndexDocuments returned an error: %v", err)
+	}
+	if err := idx.DeleteDocument("doc3"); err != nil {
+		t.Fatalf("DeleteDocument returned an error: %v", err)
+	}
+	if stats, err := idx.Stats(); err != nil || stats.WALEntries != 3 {
+		t.Errorf("Expected 3 logged writes, got %+v (%v)", stats, err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	// Lose the index, as if the process died before its writes reached the disk, and tear
+	// a record being written at the time.
+	if err := os.RemoveAll(indexPath); err != nil {
+		t.Fatalf("Failed to remove index: %v", err)
+	}
+	f, err := os.OpenFile(walPath(indexPath), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	f.WriteString(`0badf00d {"op":"index","id":"doc4"`)
+	f.Close()
+
+	idx, err = NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to reopen indexer: %v", err)
+	}
+	defer idx.Close()
+	if n, err := idx.EnableWAL(); err != nil || n != 3 {
+		t.Fatalf("Expected 3 writes to be replayed, got %d (%v)", n, err)
+	}
+	for id, want := range map[string]bool{"doc1": true, "doc2": true, "doc3": false, "doc4": false} {
+		_, err := idx.GetDocument(id, nil)
+		if got := err == nil; got != want {
+			t.Errorf("Document %s: expected present=%t after replay, got %v", id, want, err)
+		}
+	}
+
+	// A successful upload makes the logged writes durable.
+	if err := idx.CommitAndUpload(); err != nil {
+		t.Fatalf("CommitAndUpload returned an error: %v", err)
+	}
+	if info, err := os.Stat(walPath(indexPath)); err != nil || info.Size() != 0 {
+		t.Errorf("Expected an empty WAL after the upload, got %v (%v)", info, err)
+	}
+	if stats, _ := idx.Stats(); stats.WALEntries != 0 {
+		t.Errorf("Expected no logged writes after the upload, got %d", stats.WALEntries)
+	}
+}
+
+func TestIndexer_WALSkipsFailedWrites(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	indexPath := filepath.Join(tempDir, "index")
+	idx, err := NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	if _, err := idx.EnableWAL(); err != nil {
+		t.Fatalf("EnableWAL returned an error: %v", err)
+	}
+	if err := idx.IndexDocument("doc1", map[string]interface{}{"title": "first"}); err != nil {
+		t.Fatalf("IndexDocument returned an error: %v", err)
+	}
+
+	idx.mu.Lock()
+	index := idx.index
+	idx.index = failingBatchIndex{index}
+	idx.mu.Unlock()
+	if err := idx.IndexDocument("doc2", map[string]interface{}{"title": "second"}); err == nil {
+		t.Fatal("Expected IndexDocument to fail with the batch")
+	}
+	if _, err := idx.BulkIndexDocuments(map[string]interface{}{
+		"doc3": map[string]interface{}{"title": "third"},
+	}); err == nil {
+		t.Fatal("Expected BulkIndexDocuments to fail with the batch")
+	}
+	if err := idx.DeleteDocument("doc1"); err == nil {
+		t.Fatal("Expected DeleteDocument to fail with the batch")
+	}
+	idx.mu.Lock()
+	idx.index = index
+	idx.mu.Unlock()
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	// Only the write that was applied is replayed after a restart.
+	if err := os.RemoveAll(indexPath); err != nil {
+		t.Fatalf("Failed to remove index: %v", err)
+	}
+	idx, err = NewIndexer(indexPath, storage)
+	if err != nil {
+		t.Fatalf("Failed to reopen indexer: %v", err)
+	}
+	defer idx.Close()
+	if n, err := idx.EnableWAL(); err != nil || n != 1 {
+		t.Fatalf("Expected 1 write to be replayed, got %d (%v)", n, err)
+	}
+	for id, want := range map[string]bool{"doc1": true, "doc2": false, "doc3": false} {
+		_, err := idx.GetDocument(id, nil)
+		if got := err == nil; got != want {
+			t.Errorf("Document %s: expected present=%t after replay, got %v", id, want, err)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"syscall"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// maxWriteGroup bounds the documents the writer applies in one Bleve batch.
+const maxWriteGroup = 1000
+
+// ErrIndexerClosed is returned for writes submitted after Close.
+var ErrIndexerClosed = errors.New("indexer is closed")
+
+// writeOp is a write waiting for the writer goroutine, and the channel receiving its result.
+type writeOp struct {
+	record     walRecord
+	version    int64 // Expected current version of the document, AnyVersion if unconditional
+	newVersion int64 // Version of the document once written, set before done receives nil
+	// failed holds why the documents of a bulk write left out of it failed, by ID; it is
+	// set before done receives nil.
+	failed map[string]error
+	done   chan error
+}
+
+// size returns the number of documents the write touches.
+func (op *writeOp) size() int {
+	switch op.record.Op {
+	case walOpBulk:
+		return len(op.record.Docs)
+	case walOpBulkDelete:
+		return len(op.record.IDs)
+	}
+	return 1
+}
+
+// writer applies the writes of concurrent callers in groups: while one group is applied,
+// the writes submitted meanwhile queue up and are applied together next, taking the index
+// mutex once, logging them with a single sync and indexing them through one Bleve batch.
+type writer struct {
+	ops  chan *writeOp
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newWriter() *writer {
+	return &writer{ops: make(chan *writeOp), stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// submit hands record to the writer goroutine and waits for it to be applied.
+func (i *Indexer) submit(record walRecord) error {
+	_, err := i.submitIfVersion(record, AnyVersion)
+	return err
+}
+
+// submitIfVersion is like submit for a write conditional on the version of its document,
+// returning the new version.
+func (i *Indexer) submitIfVersion(record walRecord, version int64) (int64, error) {
+	op := &writeOp{record: record, version: version}
+	if err := i.submitOp(op); err != nil {
+		return 0, err
+	}
+	return op.newVersion, nil
+}
+
+// submitOp hands op to the writer goroutine and waits for it to be applied. The record
+// of an applied op holds the documents written, as versioned.
+func (i *Indexer) submitOp(op *writeOp) error {
+	op.done = make(chan error, 1)
+	select {
+	case i.writer.ops <- op:
+	case <-i.writer.done:
+		return ErrIndexerClosed
+	}
+	return <-op.done
+}
+
+// runWriter applies the submitted writes until the writer is stopped.
+func (i *Indexer) runWriter() {
+	defer close(i.writer.done)
+	for {
+		select {
+		case op := <-i.writer.ops:
+			i.applyGroup(i.collectGroup(op))
+		case <-i.writer.stop:
+			return
+		}
+	}
+}
+
+// collectGroup returns first followed by the writes already waiting, up to maxWriteGroup
+// documents.
+func (i *Indexer) collectGroup(first *writeOp) []*writeOp {
+	group := []*writeOp{first}
+	docs := first.size()
+	for docs < maxWriteGroup {
+		select {
+		case op := <-i.writer.ops:
+			group = append(group, op)
+			docs += op.size()
+		default:
+			return group
+		}
+	}
+	return group
+}
+
+// stopWriter stops the writer goroutine once the group it is applying is done. Later
+// writes fail with ErrIndexerClosed.
+func (i *Indexer) stopWriter() {
+	select {
+	case <-i.writer.stop:
+	default:
+		close(i.writer.stop)
+	}
+	<-i.writer.done
+}
+
+// applyGroup applies and logs a group of writes, reporting every write's result to its
+// caller. Writes set the versions of their documents; a write Bleve can't map, whose
+// expected version doesn't match or whose document a read-only index holds fails alone,
+// as do such documents of bulk writes. When the batch of the group fails, its writes are
+// applied one at a time, see applyIsolated. Writes are logged once applied, and only
+// acknowledged once logged. A staged indexer journals the group instead.
+func (i *Indexer) applyGroup(group []*writeOp) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.journal != nil {
+		i.journalGroup(group)
+		return
+	}
+
+	versions, err := i.currentVersions(group)
+	var readOnly map[string]bool
+	if err == nil {
+		readOnly, err = i.readOnlyDocuments(group)
+	}
+	if err != nil {
+		for _, op := range group {
+			recordOperation(op.record.Op, err)
+			op.done <- err
+		}
+		return
+	}
+	batch := i.index.NewBatch()
+	applied := make([]*writeOp, 0, len(group))
+	for _, op := range group {
+		if err := rejectReadOnly(op, readOnly); err != nil {
+			recordOperation(op.record.Op, err)
+			op.done <- err
+			continue
+		}
+		record, newVersion, err := versionWrite(op, versions)
+		if err != nil {
+			recordOperation(op.record.Op, err)
+			op.done <- err
+			continue
+		}
+		if record.Op == walOpBulk {
+			var failed map[string]error
+			record, failed = addBulkToBatch(batch, record)
+			for id, err := range failed {
+				if op.failed == nil {
+					op.failed = make(map[string]error, len(failed))
+				}
+				op.failed[id] = err
+			}
+		} else if err := addToBatch(batch, record); err != nil {
+			recordOperation(op.record.Op, err)
+			op.done <- fmt.Errorf("error preparing %s of document %s: %w", op.record.Op, op.record.ID, err)
+			continue
+		}
+		op.record, op.newVersion = record, newVersion
+		commitVersions(record, versions)
+		applied = append(applied, op)
+	}
+	if len(applied) == 0 {
+		return
+	}
+
+	records := make([]walRecord, len(applied))
+	for n, op := range applied {
+		records[n] = op.record
+	}
+	if err := i.addNestedToBatch(i.index, batch, records); err != nil {
+		for _, op := range applied {
+			recordOperation(op.record.Op, err)
+			op.done <- err
+		}
+		return
+	}
+	if err := i.index.Batch(batch); err != nil {
+		if !isDocumentError(err) {
+			slog.Error("Failed to apply writes in one batch", "writes", len(applied), "error", err)
+			for _, op := range applied {
+				recordOperation(op.record.Op, err)
+				op.done <- fmt.Errorf("error applying %s: %w", op.record.Op, err)
+			}
+			return
+		}
+		slog.Warn("Failed to apply writes in one batch, applying them one at a time", "writes", len(applied), "error", err)
+		if applied = i.applyIsolated(applied); len(applied) == 0 {
+			return
+		}
+		records = records[:0]
+		for _, op := range applied {
+			records = append(records, op.record)
+		}
+	}
+	// Only applied writes are logged, so failed ones aren't replayed on restart. Writes
+	// that can't be logged stay applied but fail, as they wouldn't survive a crash.
+	if err := i.logWrite(records...); err != nil {
+		for _, op := range applied {
+			op.done <- err
+		}
+		return
+	}
+
+	var ids []string
+	for _, op := range applied {
+		ids = append(ids, op.record.ids()...)
+	}
+	i.mirrorWrite(ids, func(target bleve.Index) error {
+		mirror := target.NewBatch()
+		for _, op := range applied {
+			if err := addToBatch(mirror, op.record); err != nil {
+				return err
+			}
+		}
+		if err := i.addNestedToBatch(target, mirror, records); err != nil {
+			return err
+		}
+		return target.Batch(mirror)
+	})
+	for _, op := range applied {
+		i.recordWrite(op.record)
+		op.done <- nil
+	}
+	writeGroupSizeHistogram.Observe(float64(len(ids)))
+	slog.Debug("Applied writes in one batch", "writes", len(applied), "documents", len(ids))
+}
+
+// applyIsolated applies the writes of a group whose batch failed one at a time, and
+// returns those applied. Writes failing alone fail to their callers; bulk writes are
+// split until the documents failing them are isolated, which are left out of them, and
+// fail as a whole when the index fails or every document does. Callers must hold i.mu.
+func (i *Indexer) applyIsolated(group []*writeOp) []*writeOp {
+	var applied []*writeOp
+	for _, op := range group {
+		if op.record.Op != walOpBulk {
+			if err := i.batchWrites(true, op.record); err != nil {
+				recordOperation(op.record.Op, err)
+				op.done <- fmt.Errorf("error applying %s: %w", op.record.Op, err)
+				continue
+			}
+			applied = append(applied, op)
+			continue
+		}
+		failed, err := i.indexIsolated(op.record.Docs, true)
+		if err != nil {
+			recordOperation(op.record.Op, err)
+			op.done <- fmt.Errorf("error applying %s: %w", op.record.Op, err)
+			continue
+		}
+		if len(failed) > 0 {
+			if op.failed == nil {
+				op.failed = make(map[string]error, len(failed))
+			}
+			docs := make(map[string]interface{}, len(op.record.Docs))
+			for id, data := range op.record.Docs {
+				if err, ok := failed[id]; ok {
+					op.failed[id] = fmt.Errorf("%w %s: %v", ErrIndexing, id, err)
+					continue
+				}
+				docs[id] = data
+			}
+			op.record.Docs = docs
+		}
+		applied = append(applied, op)
+	}
+	return applied
+}
+
+// indexIsolated indexes docs in batches, halving those that fail until the documents
+// failing alone are isolated, and returns their errors by ID. The sub-documents of docs
+// are indexed with them if nested is set. It stops at the first error of the index
+// rather than of its documents, which it returns, and fails when every document does;
+// the batches that succeeded before stay indexed. Callers must hold i.mu.
+func (i *Indexer) indexIsolated(docs map[string]interface{}, nested bool) (map[string]error, error) {
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	failed := make(map[string]error)
+	var indexErr error
+	var index func(ids []string)
+	index = func(ids []string) {
+		part := make(map[string]interface{}, len(ids))
+		for _, id := range ids {
+			part[id] = docs[id]
+		}
+		err := i.batchWrites(nested, walRecord{Op: walOpBulk, Docs: part})
+		switch {
+		case err == nil:
+		case !isDocumentError(err):
+			indexErr = err
+		case len(ids) == 1:
+			failed[ids[0]] = err
+		default:
+			index(ids[:len(ids)/2])
+			if indexErr == nil {
+				index(ids[len(ids)/2:])
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return failed, nil
+	}
+	index(ids)
+	if indexErr != nil {
+		return nil, indexErr
+	}
+	if len(failed) == len(ids) {
+		return nil, fmt.Errorf("every document failed, e.g. %q: %w", ids[0], failed[ids[0]])
+	}
+	return failed, nil
+}
+
+// isDocumentError reports whether err, failing a batch, may come from its documents
+// rather than from the index, e.g. closed or out of disk space, which fails any batch.
+func isDocumentError(err error) bool {
+	var pathErr *fs.PathError
+	var errno syscall.Errno
+	return !errors.Is(err, bleve.ErrorIndexClosed) && !errors.As(err, &pathErr) && !errors.As(err, &errno)
+}
+
+// batchWrites applies records in one batch, with the writes of the sub-documents of their
+// documents if nested is set. Callers must hold i.mu.
+func (i *Indexer) batchWrites(nested bool, records ...walRecord) error {
+	batch := i.index.NewBatch()
+	for _, record := range records {
+		if err := addToBatch(batch, record); err != nil {
+			return err
+		}
+	}
+	if nested {
+		if err := i.addNestedToBatch(i.index, batch, records); err != nil {
+			return err
+		}
+	}
+	return i.index.Batch(batch)
+}
+
+// addBulkToBatch adds the documents of a bulk write to batch and returns the write
+// without those that can't be mapped, with their errors.
+func addBulkToBatch(batch *bleve.Batch, record walRecord) (walRecord, map[string]error) {
+	var failed map[string]error
+	for id, data := range record.Docs {
+		if err := batch.Index(id, data); err != nil {
+			slog.Warn("Skipping document of bulk write", "id", id, "error", err)
+			if failed == nil {
+				failed = make(map[string]error)
+			}
+			failed[id] = fmt.Errorf("%w %s: %v", ErrMapping, id, err)
+		}
+	}
+	if len(failed) == 0 {
+		return record, nil
+	}
+	docs := make(map[string]interface{}, len(record.Docs)-len(failed))
+	for id, data := range record.Docs {
+		if _, ok := failed[id]; !ok {
+			docs[id] = data
+		}
+	}
+	record.Docs = docs
+	return record, failed
+}
+
+// addToBatch adds the write of record to batch. Documents of a bulk write that can't be
+// mapped fail the write.
+func addToBatch(batch *bleve.Batch, record walRecord) error {
+	switch record.Op {
+	case walOpIndex:
+		return batch.Index(record.ID, record.Data)
+	case walOpDelete:
+		if record.ID == "" {
+			return bleve.ErrorEmptyID
+		}
+		batch.Delete(record.ID)
+		return nil
+	case walOpBulk:
+		for id, data := range record.Docs {
+			if err := batch.Index(id, data); err != nil {
+				return fmt.Errorf("document %s: %w", id, err)
+			}
+		}
+		return nil
+	case walOpBulkDelete:
+		for _, id := range record.IDs {
+			if id == "" {
+				return bleve.ErrorEmptyID
+			}
+			batch.Delete(id)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", record.Op)
+	}
+}
+
+// ids returns the IDs of the documents the write touches.
+func (r walRecord) ids() []string {
+	if r.Op == walOpBulkDelete {
+		return r.IDs
+	}
+	if r.Op != walOpBulk {
+		return []string{r.ID}
+	}
+	ids := make([]string, 0, len(r.Docs))
+	for id := range r.Docs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// recordWrite updates the metrics, counters and commit policy for an applied write.
+// Callers must hold i.mu.
+func (i *Indexer) recordWrite(record walRecord) {
+	recordOperation(record.Op, nil)
+	switch record.Op {
+	case walOpIndex:
+		i.counters.recordIndexed(1)
+		i.recordChange(1, record.Data)
+	case walOpDelete:
+		i.counters.recordDeleted(1)
+		i.recordChange(1, record.ID)
+	case walOpBulk:
+		i.counters.recordBatch(len(record.Docs))
+		i.counters.recordIndexed(len(record.Docs))
+		i.recordChange(len(record.Docs), record.Docs)
+	case walOpBulkDelete:
+		i.counters.recordDeleted(len(record.IDs))
+		i.recordChange(len(record.IDs), record.IDs)
+	}
+}
@@ -0,0 +1,73 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestIndexer_ConcurrentWrites(t *testing.T) {
+	tempDir := t.TempDir()
+	storage, err := NewLocalFileStorage(filepath.Join(tempDir, "segments"))
+	if err != nil {
+		t.Fatalf("Failed to initialize LocalFileStorage: %v", err)
+	}
+	idx, err := NewIndexer(filepath.Join(tempDir, "index"), storage)
+	if err != nil {
+		t.Fatalf("Failed to create indexer: %v", err)
+	}
+	if _, err := idx.EnableWAL(); err != nil {
+		t.Fatalf("EnableWAL returned an error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for n := 0; n < 50; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			id := fmt.Sprintf("doc%d", n)
+			errs <- idx.IndexDocument(id, map[string]interface{}{"title": id})
+			// Every other document is deleted right after, possibly in the same batch.
+			if n%2 == 0 {
+				errs <- idx.DeleteDocument(id)
+			}
+		}(n)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := idx.BulkIndexDocuments(map[string]interface{}{
+			"bulk1": map[string]interface{}{"title": "bulk one"},
+			"bulk2": map[string]interface{}{"title": "bulk two"},
+		})
+		errs <- err
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Write returned an error: %v", err)
+		}
+	}
+
+	if err := idx.IndexDocument("", map[string]interface{}{"title": "no ID"}); err == nil {
+		t.Error("Expected a document without an ID to fail")
+	}
+	stats, err := idx.Stats()
+	if err != nil {
+		t.Fatalf("Stats returned an error: %v", err)
+	}
+	if stats.DocCount != 27 || stats.DocsIndexed != 52 || stats.DocsDeleted != 25 || stats.WALEntries != 76 {
+		t.Errorf("Unexpected stats after the writes: %+v", stats)
+	}
+
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+	if err := idx.IndexDocument("late", map[string]interface{}{}); !errors.Is(err, ErrIndexerClosed) {
+		t.Errorf("Expected ErrIndexerClosed after Close, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"common/config"
+	"common/graceful"
+	"common/logging"
+	"common/qupb"
+	"common/reload"
+	"common/tlsconfig"
+	"common/tracing"
+	"query_understanding"
+	"query_understanding/lexicon"
+	"query_understanding/processing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ProcessRequest is the body accepted by the /process endpoint.
+type ProcessRequest struct {
+	Query    string `json:"query"`
+	Explain  bool   `json:"explain"`  // Report which rewrite rules fired
+	Pipeline string `json:"pipeline"` // Pipeline to run; empty runs the default one
+	// Collection the query searches, selecting its stopwords and synonyms.
+	Collection string `json:"collection"`
+	// Language of the query, e.g. "fr"; empty detects it.
+	Language string `json:"lang"`
+}
+
+// DebugPipelineRequest is the body accepted by the /debug/pipeline endpoint.
+type DebugPipelineRequest struct {
+	Query      string   `json:"query"`
+	Pipeline   string   `json:"pipeline"` // Pipeline to run; empty runs the default one
+	Steps      []string `json:"steps"`    // Stages to run instead of a configured pipeline
+	Collection string   `json:"collection"`
+	Language   string   `json:"lang"`
+}
+
+var tracer = tracing.Tracer("query_understanding")
+
+// Config holds the service's settings, read from a YAML file (-config-file), environment
+// variables and flags, in increasing order of precedence. The processing pipeline itself
+// is configured in the file named by PipelineConfig.
+type Config struct {
+	ListenAddr      string           `yaml:"listen_addr" env:"LISTEN_ADDR" flag:"listen-addr" usage:"Address to listen on"`
+	PipelineConfig  string           `yaml:"pipeline_config" env:"PIPELINE_CONFIG" flag:"config" usage:"Path to the pipeline configuration file"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"How long in-flight requests are drained on shutdown"`
+	TLS             tlsconfig.Config `yaml:"tls"`
+	// Log sets the level and format of the logs; the level can be changed at runtime
+	// through /admin/log-level.
+	Log logging.Config `yaml:"log"`
+	// GRPCListenAddr serves the pipeline over gRPC too, with the same TLS settings.
+	GRPCListenAddr string `yaml:"grpc_listen_addr" env:"GRPC_LISTEN_ADDR" flag:"grpc-listen-addr" usage:"Address the gRPC API listens on; empty disables it"`
+	// LexiconStore persists the stopword lists and synonym sets edited through /admin/stopwords
+	// and /admin/synonyms. Replicas sharing the file pick up each other's changes every
+	// LexiconReloadInterval.
+	LexiconStore          string        `yaml:"lexicon_store" env:"LEXICON_STORE" flag:"lexicon-store" usage:"JSON file persisting the stopword lists and synonym sets; empty keeps them in memory"`
+	LexiconReloadInterval time.Duration `yaml:"lexicon_reload_interval" env:"LEXICON_RELOAD_INTERVAL" flag:"lexicon-reload-interval" usage:"How often the lexicon is reloaded from its store; 0 disables it"`
+	// EmbeddingURL is the endpoint computing the query embeddings of the embed_query stage,
+	// which must use the model that embedded the documents. Without it, EmbeddingDims > 0
+	// embeds queries locally by hashing their words, for development.
+	EmbeddingURL     string        `yaml:"embedding_url" env:"EMBEDDING_URL" flag:"embedding-url" usage:"Endpoint embedding queries for vector search; empty disables it"`
+	EmbeddingModel   string        `yaml:"embedding_model" env:"EMBEDDING_MODEL" flag:"embedding-model" usage:"Model name sent to the embedding endpoint"`
+	EmbeddingTimeout time.Duration `yaml:"embedding_timeout" env:"EMBEDDING_TIMEOUT" flag:"embedding-timeout" usage:"How long a query embedding may take"`
+	EmbeddingDims    int           `yaml:"embedding_dims" env:"EMBEDDING_DIMS" flag:"embedding-dims" usage:"Dimensions of the local hashing embedder used without an embedding endpoint; 0 disables it"`
+}
+
+func main() {
+	svcConfig := Config{
+		ListenAddr:      ":8082",
+		GRPCListenAddr:  ":9082",
+		PipelineConfig:  "config/config.yaml",
+		ShutdownTimeout: graceful.DefaultTimeout,
+		Log:             logging.DefaultConfig(),
+	}
+	config.MustLoad(&svcConfig)
+	if err := logging.Setup(svcConfig.Log); err != nil {
+		log.Fatalf("Invalid log configuration: %v", err)
+	}
+
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.ConfigFromEnv("query_understanding"))
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	lex, err := newLexicon(svcConfig.LexiconStore)
+	if err != nil {
+		log.Fatalf("Failed to load lexicon: %v", err)
+	}
+	// The pipeline configuration, default stopwords and lexicon are reloaded on SIGHUP or
+	// POST /admin/reload; a configuration failing validation leaves the running one in place.
+	live, err := query_understanding.NewLiveConfiguration(svcConfig.PipelineConfig, lex)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	reloader := reload.New("query understanding", live.Reload)
+	reloadCtx, stopReloading := context.WithCancel(context.Background())
+	defer stopReloading()
+	go reloader.Watch(reloadCtx)
+	query_understanding.SetLexicon(lex)
+	query_understanding.SetEmbedder(newEmbedder(svcConfig))
+	if svcConfig.LexiconStore != "" && svcConfig.LexiconReloadInterval > 0 {
+		ctx, stopWatching := context.WithCancel(context.Background())
+		defer stopWatching()
+		go lex.Watch(ctx, svcConfig.LexiconReloadInterval)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", lexicon.NewHandler(lex))
+	mux.Handle(reload.Path, reloader.Handler())
+	mux.Handle(logging.LevelPath, logging.LevelHandler())
+	mux.HandleFunc("/process", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req ProcessRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		ctx, span := tracer.Start(r.Context(), "query_understanding.ProcessClientQuery")
+		sq, err := query_understanding.ProcessClientQueryContext(ctx, req.Query, live.Get(), query_understanding.ProcessOptions{Explain: req.Explain, Pipeline: req.Pipeline, Collection: req.Collection, Language: req.Language})
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			span.End()
+			if errors.Is(err, query_understanding.ErrUnknownPipeline) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.ErrorContext(r.Context(), "Failed to process query", "query", req.Query, "error", err)
+			http.Error(w, "Failed to process query", http.StatusInternalServerError)
+			return
+		}
+		span.SetAttributes(
+			attribute.String("query.processed", sq.ProcessedQuery),
+			attribute.String("query.language", sq.Language),
+			attribute.String("query.intent", sq.Intent),
+		)
+		span.End()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(sq); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
+		}
+	})
+
+	mux.HandleFunc("/debug/pipeline", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req DebugPipelineRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		opts := query_understanding.DebugOptions{
+			ProcessOptions: query_understanding.ProcessOptions{Pipeline: req.Pipeline, Collection: req.Collection, Language: req.Language},
+			Steps:          req.Steps,
+		}
+		debug, err := query_understanding.DebugPipeline(r.Context(), req.Query, live.Get(), opts)
+		if err != nil {
+			if errors.Is(err, query_understanding.ErrUnknownPipeline) || errors.Is(err, processing.ErrUnknownStage) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.ErrorContext(r.Context(), "Failed to debug query", "query", req.Query, "error", err)
+			http.Error(w, "Failed to process query", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(debug); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
+		}
+	})
+
+	server, err := tlsconfig.NewServer(svcConfig.ListenAddr, tracing.Middleware(logging.Middleware(mux), "query_understanding"), svcConfig.TLS)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	var grpcServer *grpc.Server
+	if svcConfig.GRPCListenAddr != "" {
+		grpcServer, err = serveGRPC(svcConfig.GRPCListenAddr, query_understanding.NewLiveGRPCServer(live), server.TLSConfig)
+		if err != nil {
+			log.Fatalf("Failed to start gRPC API: %v", err)
+		}
+		slog.Info("Query understanding gRPC API listening", "addr", svcConfig.GRPCListenAddr)
+	}
+	slog.Info("Query understanding service listening", "addr", svcConfig.ListenAddr)
+	if err := graceful.Serve(server, svcConfig.ShutdownTimeout); err != nil {
+		log.Fatalf("Query understanding service failed: %v", err)
+	}
+	if grpcServer != nil {
+		stopGRPC(grpcServer, svcConfig.ShutdownTimeout)
+	}
+	slog.Info("Query understanding service stopped")
+}
+
+// newLexicon loads the lexicon persisted at path, or creates an in-memory one if path is
+// empty.
+func newLexicon(path string) (*lexicon.Lexicon, error) {
+	if path == "" {
+		slog.Warn("No lexicon store configured, stopword and synonym changes won't survive restarts")
+		return lexicon.New(lexicon.NewMemoryStore())
+	}
+	return lexicon.New(lexicon.NewFileStore(path))
+}
+
+// newEmbedder returns the Embedder of the embed_query stage, nil if queries aren't embedded.
+func newEmbedder(cfg Config) processing.Embedder {
+	switch {
+	case cfg.EmbeddingURL != "":
+		slog.Info("Embedding queries with an embedding endpoint", "url", cfg.EmbeddingURL)
+		return processing.NewHTTPEmbedder(cfg.EmbeddingURL, cfg.EmbeddingModel, cfg.EmbeddingTimeout)
+	case cfg.EmbeddingDims > 0:
+		slog.Info("No embedding endpoint configured, embedding queries by hashing their words", "dims", cfg.EmbeddingDims)
+		return processing.HashEmbedder{Dims: cfg.EmbeddingDims}
+	default:
+		return nil
+	}
+}
+
+// serveGRPC starts serving the gRPC API on addr in the background, with TLS if tlsConfig
+// is set.
+func serveGRPC(addr string, svc qupb.QueryUnderstandingServer, tlsConfig *tls.Config) (*grpc.Server, error) {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(tracing.UnaryServerInterceptor())}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := grpc.NewServer(opts...)
+	qupb.RegisterQueryUnderstandingServer(server, svc)
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			slog.Error("gRPC API stopped", "error", err)
+		}
+	}()
+	return server, nil
+}
+
+// stopGRPC lets in-flight RPCs complete for up to timeout, then closes the remaining ones.
+func stopGRPC(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		server.Stop()
+	}
+}
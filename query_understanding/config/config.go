@@ -1,19 +1,13 @@
 package config
 
-// IndexSchema represents the configuration for an index schema.
-type IndexSchema struct {
-	Name    string            `yaml:"name"`
-	Fields  []SchemaField     `yaml:"fields"`
-	Options map[string]string `yaml:"options"`
-}
+import "common/schema"
+
+// IndexSchema represents the configuration for an index schema. It's shared with the
+// indexer, which generates its index mapping from it.
+type IndexSchema = schema.IndexSchema
 
 // SchemaField represents a field within an index schema.
-type SchemaField struct {
-	Name    string `yaml:"name"`
-	Type    string `yaml:"type"`
-	Indexed bool   `yaml:"indexed"`
-	Stored  bool   `yaml:"stored"`
-}
+type SchemaField = schema.Field
 
 // ComputedField represents the configuration for a computed field.
 type ComputedField struct {
@@ -23,10 +17,34 @@ type ComputedField struct {
 }
 
 // QueryPlanningPipeline represents the configuration for a query planning pipeline.
+// Pipelines read from YAML are enabled unless they set enabled: false.
+//
+// Steps run in sequence unless DependsOn is set, which turns them into a DAG: each step
+// runs once the steps listed under its name have, an empty list running it first, and
+// steps it doesn't list run after the step before them in Steps. Steps that are ready
+// together run concurrently on the same query, e.g.
+//
+//	steps: [normalize_unicode, detect_language, classify_intent, rewrite_query]
+//	depends_on:
+//	  detect_language: [normalize_unicode]
+//	  classify_intent: [normalize_unicode]
+//	  rewrite_query: [detect_language, classify_intent]
 type QueryPlanningPipeline struct {
-	Name    string   `yaml:"name"`
-	Steps   []string `yaml:"steps"`
-	Enabled bool     `yaml:"enabled"`
+	Name      string              `yaml:"name"`
+	Steps     []string            `yaml:"steps"`
+	DependsOn map[string][]string `yaml:"depends_on"`
+	Enabled   bool                `yaml:"enabled"`
+}
+
+// UnmarshalYAML decodes a pipeline, enabling it when enabled is omitted.
+func (p *QueryPlanningPipeline) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain QueryPlanningPipeline
+	decoded := plain{Enabled: true}
+	if err := unmarshal(&decoded); err != nil {
+		return err
+	}
+	*p = QueryPlanningPipeline(decoded)
+	return nil
 }
 
 // Configuration is the root structure for the entire service configuration.
@@ -34,4 +52,79 @@ type Configuration struct {
 	IndexSchemas           []IndexSchema           `yaml:"index_schemas"`
 	ComputedFields         []ComputedField         `yaml:"computed_fields"`
 	QueryPlanningPipelines []QueryPlanningPipeline `yaml:"query_planning_pipelines"`
+	// LanguagePipelines names the pipeline of the queries of a language, by ISO 639-1
+	// code, run when the query names none; queries of other languages run the default one.
+	LanguagePipelines map[string]string `yaml:"language_pipelines"`
+	IntentRules       []IntentRule      `yaml:"intent_rules"`
+	RewriteRules      []RewriteRule     `yaml:"rewrite_rules"`
+	QuerySyntax       QuerySyntaxConfig `yaml:"query_syntax"`
+	// RangeParsing configures the parse_ranges stage by collection, e.g. "default" for the
+	// Broker's unnamed collection: the queries of other collections, or naming none, keep
+	// their range expressions, since filters on fields missing from a collection match
+	// nothing.
+	RangeParsing map[string]RangeParsingConfig `yaml:"range_parsing"`
+	// VectorCollections lists the collections with vector fields, e.g. "default" for the
+	// Broker's unnamed collection, whose queries the embed_query stage embeds: the
+	// queries of other collections, or naming none, skip the embedding model.
+	VectorCollections []string `yaml:"vector_collections"`
+	// LanguageDetection configures the detect_language stage, which also picks the
+	// language pipeline of queries.
+	LanguageDetection LanguageDetectionConfig `yaml:"language_detection"`
+}
+
+// LanguageDetectionConfig sets when the detect_language stage accepts the detected
+// language of a query rather than DefaultLanguage: with at least MinConfidence, for
+// queries of at least MinTokens words. Zero values keep the defaults of the stage.
+type LanguageDetectionConfig struct {
+	DefaultLanguage string  `yaml:"default_language"`
+	MinConfidence   float64 `yaml:"min_confidence"`
+	MinTokens       int     `yaml:"min_tokens"`
+}
+
+// QuerySyntaxConfig configures the parse_syntax stage. DefaultOperator combines clauses
+// without a + or - prefix, "or" (the default) or "and". Fields restricts the names
+// recognised in field:value syntax; any name is recognised when it is empty.
+type QuerySyntaxConfig struct {
+	DefaultOperator string   `yaml:"default_operator"`
+	Fields          []string `yaml:"fields"`
+}
+
+// RangeParsingConfig configures the fields of a collection the parse_ranges stage turns
+// range expressions into filters on. Expressions of a kind whose field is empty are left in the query.
+//   - PriceField: numeric field of prices, e.g. "under $50" or "between $20 and $50".
+//   - YearField: numeric field of years, e.g. "between 2019 and 2021" or "since 2019".
+//   - DateField: date field of relative dates, e.g. "last 7 days", and of years when
+//     YearField is empty.
+type RangeParsingConfig struct {
+	PriceField string `yaml:"price_field"`
+	YearField  string `yaml:"year_field"`
+	DateField  string `yaml:"date_field"`
+}
+
+// IntentRule configures a query intent recognised by the classify_intent stage.
+// A query matches the rule when it contains one of the keywords (whole words or
+// phrases, case-insensitive) or matches one of the regular expressions.
+type IntentRule struct {
+	Intent   string   `yaml:"intent"`
+	Keywords []string `yaml:"keywords"`
+	Patterns []string `yaml:"patterns"`
+	Weight   float64  `yaml:"weight"` // Score of each match; defaults to 1
+}
+
+// RewriteRule configures a query rewrite applied by the rewrite_query stage.
+// Match selects how Pattern is interpreted:
+//   - "literal": a case-insensitive word or phrase.
+//   - "regex": a regular expression; Replace may reference groups as $1 or ${name}.
+//   - "tokens": space-separated tokens where "*" matches any single token;
+//     Replace may reference the wildcards as $1, $2, ...
+//
+// Rules with a higher Priority run first. Stop prevents lower-priority rules from
+// running once this rule has fired.
+type RewriteRule struct {
+	Name     string `yaml:"name"`
+	Match    string `yaml:"match"`
+	Pattern  string `yaml:"pattern"`
+	Replace  string `yaml:"replace"`
+	Priority int    `yaml:"priority"`
+	Stop     bool   `yaml:"stop"`
 }
@@ -1,15 +1,38 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 
+	"common/schema"
+
+	"github.com/expr-lang/expr"
 	"gopkg.in/yaml.v2"
 )
 
+// DefaultAnalyzer is the analyzer of text fields when neither the field nor its schema
+// names one, the standard analyzer of the indexer.
+const DefaultAnalyzer = "standard"
+
+// LoadOptions holds what a configuration is checked against besides its own contents.
+type LoadOptions struct {
+	// StageExists reports whether a stage is registered under a name; every step of an
+	// enabled pipeline must name one. Nil leaves steps unchecked.
+	StageExists func(name string) bool
+}
+
 // LoadConfig reads a YAML configuration file from the given path
 // and unmarshals it into a Configuration struct.
 func LoadConfig(filePath string) (*Configuration, error) {
+	return LoadConfigWithOptions(filePath, LoadOptions{})
+}
+
+// LoadConfigWithOptions is LoadConfig checking the configuration against opts. Omitted
+// settings are defaulted, see ApplyDefaults, and every problem found is reported at once.
+func LoadConfigWithOptions(filePath string, opts LoadOptions) (*Configuration, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read configuration file %s: %w", filePath, err)
@@ -20,83 +43,176 @@ func LoadConfig(filePath string) (*Configuration, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal configuration from %s: %w", filePath, err)
 	}
+	ApplyDefaults(&config)
 
 	// Schema Validation
-	if err := ValidateConfiguration(&config); err != nil {
+	if err := ValidateConfigurationWithOptions(&config, opts); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
 	}
 
 	return &config, nil
 }
 
-// validateConfiguration performs validation on the loaded Configuration struct.
+// ApplyDefaults fills in the settings a configuration omits: the analyzer of text fields,
+// the schema's analyzer option or DefaultAnalyzer, and the weight of intent rules, 1.
+// Pipelines are enabled unless they set enabled: false, see QueryPlanningPipeline.
+func ApplyDefaults(cfg *Configuration) {
+	for i := range cfg.IndexSchemas {
+		s := &cfg.IndexSchemas[i]
+		for j := range s.Fields {
+			field := &s.Fields[j]
+			if field.Type != schema.TypeText || field.Analyzer != "" {
+				continue
+			}
+			if field.Analyzer = s.Analyzer(*field); field.Analyzer == "" {
+				field.Analyzer = DefaultAnalyzer
+			}
+		}
+	}
+	for i := range cfg.IntentRules {
+		if cfg.IntentRules[i].Weight == 0 {
+			cfg.IntentRules[i].Weight = 1
+		}
+	}
+}
+
+// ValidateConfiguration performs validation on the loaded Configuration struct.
 func ValidateConfiguration(cfg *Configuration) error {
+	return ValidateConfigurationWithOptions(cfg, LoadOptions{})
+}
+
+// ValidateConfigurationWithOptions is ValidateConfiguration also checking cross-references
+// against opts. It reports every problem found, joined in a single error.
+func ValidateConfigurationWithOptions(cfg *Configuration, opts LoadOptions) error {
 	if cfg == nil {
 		return fmt.Errorf("configuration cannot be nil")
 	}
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
 
 	// Validate IndexSchemas
 	if len(cfg.IndexSchemas) == 0 {
-		return fmt.Errorf("at least one index schema must be defined")
+		fail("at least one index schema must be defined")
 	}
 	for _, schema := range cfg.IndexSchemas {
-		if schema.Name == "" {
-			return fmt.Errorf("index schema name cannot be empty")
-		}
-		if len(schema.Fields) == 0 {
-			return fmt.Errorf("index schema '%s' must define at least one field", schema.Name)
-		}
-		for _, field := range schema.Fields {
-			if field.Name == "" {
-				return fmt.Errorf("field name in schema '%s' cannot be empty", schema.Name)
-			}
-			if field.Type == "" {
-				return fmt.Errorf("field '%s' in schema '%s' must have a type", field.Name, schema.Name)
-			}
-			// Basic type validation (can be extended with a map of valid types)
-			switch field.Type {
-			case "string", "text", "integer", "float", "boolean", "datetime":
-				// Valid type
-			default:
-				return fmt.Errorf("field '%s' in schema '%s' has an unsupported type '%s'", field.Name, schema.Name, field.Type)
-			}
+		if err := schema.Validate(); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
 	// Validate ComputedFields
 	for _, cField := range cfg.ComputedFields {
 		if cField.Name == "" {
-			return fmt.Errorf("computed field name cannot be empty")
+			fail("computed field name cannot be empty")
+			continue
 		}
 		if cField.Expression == "" {
-			return fmt.Errorf("computed field '%s' must have an expression", cField.Name)
+			fail("computed field '%s' must have an expression", cField.Name)
+		} else if _, err := expr.Compile(cField.Expression, expr.AllowUndefinedVariables()); err != nil {
+			fail("computed field '%s' has an invalid expression: %w", cField.Name, err)
 		}
 		if cField.Type == "" {
-			return fmt.Errorf("computed field '%s' must have a type", cField.Name)
+			fail("computed field '%s' must have a type", cField.Name)
+			continue
 		}
 		// Basic type validation for computed fields
 		switch cField.Type {
 		case "string", "integer", "float", "boolean":
 			// Valid type
 		default:
-			return fmt.Errorf("computed field '%s' has an unsupported type '%s'", cField.Name, cField.Type)
+			fail("computed field '%s' has an unsupported type '%s'", cField.Name, cField.Type)
 		}
 	}
 
 	// Validate QueryPlanningPipelines
 	for _, pipeline := range cfg.QueryPlanningPipelines {
 		if pipeline.Name == "" {
-			return fmt.Errorf("query planning pipeline name cannot be empty")
+			fail("query planning pipeline name cannot be empty")
+			continue
 		}
 		if len(pipeline.Steps) == 0 {
-			return fmt.Errorf("query planning pipeline '%s' must define at least one step", pipeline.Name)
+			fail("query planning pipeline '%s' must define at least one step", pipeline.Name)
 		}
 		for _, step := range pipeline.Steps {
-			if step == "" {
-				return fmt.Errorf("query planning pipeline '%s' contains an empty step", pipeline.Name)
+			switch {
+			case step == "":
+				fail("query planning pipeline '%s' contains an empty step", pipeline.Name)
+			case pipeline.Enabled && opts.StageExists != nil && !opts.StageExists(step):
+				// Disabled pipelines may name stages that aren't deployed yet.
+				fail("query planning pipeline '%s' has an unknown step '%s'", pipeline.Name, step)
 			}
 		}
+		if _, err := pipeline.Waves(); err != nil {
+			fail("query planning pipeline '%s' has invalid dependencies: %w", pipeline.Name, err)
+		}
+	}
+
+	// Validate LanguagePipelines
+	for language, name := range cfg.LanguagePipelines {
+		found := false
+		for _, pipeline := range cfg.QueryPlanningPipelines {
+			found = found || pipeline.Name == name
+		}
+		if !found {
+			fail("language '%s' names an undefined query planning pipeline '%s'", language, name)
+		}
+	}
+
+	// Validate IntentRules
+	for _, rule := range cfg.IntentRules {
+		if rule.Intent == "" {
+			fail("intent rule name cannot be empty")
+			continue
+		}
+		if len(rule.Keywords) == 0 && len(rule.Patterns) == 0 {
+			fail("intent rule '%s' must define at least one keyword or pattern", rule.Intent)
+		}
+		if rule.Weight < 0 {
+			fail("intent rule '%s' has a negative weight", rule.Intent)
+		}
+		for _, pattern := range rule.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				fail("intent rule '%s' has an invalid pattern '%s': %w", rule.Intent, pattern, err)
+			}
+		}
+	}
+
+	// Validate RewriteRules
+	ruleNames := make(map[string]bool, len(cfg.RewriteRules))
+	for _, rule := range cfg.RewriteRules {
+		if rule.Name == "" {
+			fail("rewrite rule name cannot be empty")
+			continue
+		}
+		if ruleNames[rule.Name] {
+			fail("duplicate rewrite rule '%s'", rule.Name)
+		}
+		ruleNames[rule.Name] = true
+		if strings.TrimSpace(rule.Pattern) == "" {
+			fail("rewrite rule '%s' must have a pattern", rule.Name)
+			continue
+		}
+		switch rule.Match {
+		case "literal", "tokens":
+			// Valid match type
+		case "regex":
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				fail("rewrite rule '%s' has an invalid pattern '%s': %w", rule.Name, rule.Pattern, err)
+			}
+		default:
+			fail("rewrite rule '%s' has an unsupported match type '%s'", rule.Name, rule.Match)
+		}
+	}
+
+	// Validate QuerySyntax
+	switch cfg.QuerySyntax.DefaultOperator {
+	case "", "or", "and":
+		// Valid operator
+	default:
+		fail("query syntax has an unsupported default operator '%s'", cfg.QuerySyntax.DefaultOperator)
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
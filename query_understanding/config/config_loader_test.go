@@ -348,8 +348,221 @@ query_planning_pipelines:
 	assert.Nil(t, config)
 }
 
+func TestLoadConfig_ValidationFailed_InvalidIntentPattern(t *testing.T) {
+	configYAML := `
+index_schemas:
+  - name: products
+    fields:
+      - name: id
+        type: integer
+intent_rules:
+  - intent: support
+    patterns: ["order ("]
+`
+	filePath, cleanup := createTempConfigFile(t, configYAML)
+	defer cleanup()
+
+	config, err := LoadConfig(filePath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "intent rule 'support' has an invalid pattern")
+	assert.Nil(t, config)
+}
+
+func TestLoadConfig_ValidationFailed_UnsupportedRewriteMatch(t *testing.T) {
+	configYAML := `
+index_schemas:
+  - name: products
+    fields:
+      - name: id
+        type: integer
+rewrite_rules:
+  - name: laptops
+    match: fuzzy
+    pattern: notebook
+    replace: laptop
+`
+	filePath, cleanup := createTempConfigFile(t, configYAML)
+	defer cleanup()
+
+	config, err := LoadConfig(filePath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "rewrite rule 'laptops' has an unsupported match type 'fuzzy'")
+	assert.Nil(t, config)
+}
+
+func TestLoadConfig_ValidationFailed_UndefinedLanguagePipeline(t *testing.T) {
+	configYAML := `
+index_schemas:
+  - name: products
+    fields:
+      - name: id
+        type: integer
+query_planning_pipelines:
+  - name: default_pipeline
+    steps: ["tokenize"]
+language_pipelines:
+  fr: french_pipeline
+`
+	filePath, cleanup := createTempConfigFile(t, configYAML)
+	defer cleanup()
+
+	config, err := LoadConfig(filePath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "language 'fr' names an undefined query planning pipeline 'french_pipeline'")
+	assert.Nil(t, config)
+}
+
 func TestValidateConfiguration_NilConfig(t *testing.T) {
 	err := ValidateConfiguration(nil) // Assuming ValidateConfiguration is exported for testing
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "configuration cannot be nil")
 }
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	configYAML := `
+index_schemas:
+  - name: products
+    fields:
+      - name: name
+        type: text
+      - name: description
+        type: text
+        analyzer: en
+      - name: brand
+        type: string
+  - name: articles
+    fields:
+      - name: title
+        type: text
+    options:
+      analyzer: fr
+query_planning_pipelines:
+  - name: default_pipeline
+    steps: ["tokenize"]
+  - name: admin_pipeline
+    steps: ["tokenize"]
+    enabled: false
+intent_rules:
+  - intent: support
+    keywords: ["refund"]
+`
+	filePath, cleanup := createTempConfigFile(t, configYAML)
+	defer cleanup()
+
+	config, err := LoadConfig(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultAnalyzer, config.IndexSchemas[0].Fields[0].Analyzer)
+	assert.Equal(t, "en", config.IndexSchemas[0].Fields[1].Analyzer)
+	assert.Empty(t, config.IndexSchemas[0].Fields[2].Analyzer, "only text fields have analyzers")
+	assert.Equal(t, "fr", config.IndexSchemas[1].Fields[0].Analyzer)
+	assert.True(t, config.QueryPlanningPipelines[0].Enabled)
+	assert.False(t, config.QueryPlanningPipelines[1].Enabled)
+	assert.Equal(t, 1.0, config.IntentRules[0].Weight)
+}
+
+func TestLoadConfig_ValidationFailed_InvalidComputedFieldExpression(t *testing.T) {
+	configYAML := `
+index_schemas:
+  - name: products
+    fields:
+      - name: id
+        type: integer
+computed_fields:
+  - name: price_range
+    expression: "price < 50 ? 'low' :"
+    type: string
+`
+	filePath, cleanup := createTempConfigFile(t, configYAML)
+	defer cleanup()
+
+	config, err := LoadConfig(filePath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "computed field 'price_range' has an invalid expression")
+	assert.Nil(t, config)
+}
+
+func TestLoadConfigWithOptions_UnknownStep(t *testing.T) {
+	configYAML := `
+index_schemas:
+  - name: products
+    fields:
+      - name: id
+        type: integer
+query_planning_pipelines:
+  - name: default_pipeline
+    steps: ["tokenize", "spell_check"]
+  - name: admin_pipeline
+    steps: ["debug_logging"]
+    enabled: false
+`
+	filePath, cleanup := createTempConfigFile(t, configYAML)
+	defer cleanup()
+	opts := LoadOptions{StageExists: func(name string) bool { return name == "tokenize" }}
+
+	config, err := LoadConfigWithOptions(filePath, opts)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "query planning pipeline 'default_pipeline' has an unknown step 'spell_check'")
+	assert.NotContains(t, err.Error(), "debug_logging", "disabled pipelines aren't checked")
+	assert.Nil(t, config)
+}
+
+func TestLoadConfig_ValidationFailed_ReportsEveryProblem(t *testing.T) {
+	configYAML := `
+index_schemas:
+  - name: products
+    fields:
+      - name: id
+        type: uuid
+computed_fields:
+  - name: discounted_price
+    expression: "price * 0.9"
+    type: date
+query_planning_pipelines:
+  - name: default_pipeline
+    steps: []
+rewrite_rules:
+  - name: laptops
+    match: fuzzy
+    pattern: "laptop"
+`
+	filePath, cleanup := createTempConfigFile(t, configYAML)
+	defer cleanup()
+
+	_, err := LoadConfig(filePath)
+	assert.Error(t, err)
+	for _, problem := range []string{
+		"field 'id' in schema 'products' has an unsupported type 'uuid'",
+		"computed field 'discounted_price' has an unsupported type 'date'",
+		"query planning pipeline 'default_pipeline' must define at least one step",
+		"rewrite rule 'laptops' has an unsupported match type 'fuzzy'",
+	} {
+		assert.Contains(t, err.Error(), problem)
+	}
+}
+
+func TestLoadConfig_ValidationFailed_PipelineDependencies(t *testing.T) {
+	configYAML := `
+index_schemas:
+  - name: products
+    fields:
+      - name: id
+        type: integer
+query_planning_pipelines:
+  - name: cyclic_pipeline
+    steps: ["lowercase", "detect_language", "classify_intent"]
+    depends_on:
+      detect_language: ["classify_intent"]
+      classify_intent: ["lowercase", "detect_language"]
+  - name: dangling_pipeline
+    steps: ["lowercase", "tokenize"]
+    depends_on:
+      tokenize: ["normalize_unicode"]
+`
+	filePath, cleanup := createTempConfigFile(t, configYAML)
+	defer cleanup()
+
+	_, err := LoadConfig(filePath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "query planning pipeline 'cyclic_pipeline' has invalid dependencies: steps have a dependency cycle: detect_language -> classify_intent -> detect_language")
+	assert.Contains(t, err.Error(), "query planning pipeline 'dangling_pipeline' has invalid dependencies: step 'tokenize' depends on an unknown step 'normalize_unicode'")
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Waves returns the steps of the pipeline in the order they run: the steps of a wave run
+// once those of the previous waves have, concurrently, and are listed in the order of
+// Steps. Steps without a DependsOn entry depend on the step before them, so a pipeline
+// without DependsOn runs a wave per step. It fails for DependsOn naming steps the
+// pipeline doesn't have, for steps listed twice and for dependency cycles.
+func (p *QueryPlanningPipeline) Waves() ([][]string, error) {
+	if len(p.DependsOn) == 0 {
+		waves := make([][]string, 0, len(p.Steps))
+		for _, step := range p.Steps {
+			waves = append(waves, []string{step})
+		}
+		return waves, nil
+	}
+
+	var errs []error
+	steps := make(map[string]bool, len(p.Steps))
+	for _, step := range p.Steps {
+		if steps[step] {
+			errs = append(errs, fmt.Errorf("step '%s' is listed twice, which a DAG can't tell apart", step))
+		}
+		steps[step] = true
+	}
+	for step, deps := range p.DependsOn {
+		if !steps[step] {
+			errs = append(errs, fmt.Errorf("depends_on names an unknown step '%s'", step))
+		}
+		for _, dep := range deps {
+			if !steps[dep] {
+				errs = append(errs, fmt.Errorf("step '%s' depends on an unknown step '%s'", step, dep))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	deps := p.dependencies()
+	if cycle := p.cycle(deps); cycle != nil {
+		return nil, fmt.Errorf("steps have a dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	done := make(map[string]bool, len(p.Steps))
+	var waves [][]string
+	for len(done) < len(p.Steps) {
+		var wave []string
+		for _, step := range p.Steps {
+			if !done[step] && ready(deps[step], done) {
+				wave = append(wave, step)
+			}
+		}
+		for _, step := range wave {
+			done[step] = true
+		}
+		waves = append(waves, wave)
+	}
+	return waves, nil
+}
+
+// dependencies returns the dependencies of every step: those DependsOn lists for it, or
+// else the step before it in Steps. An empty DependsOn entry makes a step run first.
+func (p *QueryPlanningPipeline) dependencies() map[string][]string {
+	deps := make(map[string][]string, len(p.Steps))
+	for i, step := range p.Steps {
+		if listed, ok := p.DependsOn[step]; ok {
+			deps[step] = listed
+		} else if i > 0 {
+			deps[step] = []string{p.Steps[i-1]}
+		}
+	}
+	return deps
+}
+
+// ready reports whether every dependency of a step, deps, is done.
+func ready(deps []string, done map[string]bool) bool {
+	for _, dep := range deps {
+		if !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// cycle returns the steps of a dependency cycle of deps, the first one repeated last, or
+// nil if the dependencies are acyclic.
+func (p *QueryPlanningPipeline) cycle(deps map[string][]string) []string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(p.Steps))
+	var path []string
+	var visit func(step string) []string
+	visit = func(step string) []string {
+		switch state[step] {
+		case visiting:
+			for i, s := range path {
+				if s == step {
+					return append(append([]string(nil), path[i:]...), step)
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[step] = visiting
+		path = append(path, step)
+		for _, dep := range deps[step] {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[step] = visited
+		return nil
+	}
+	for _, step := range p.Steps {
+		if cycle := visit(step); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryPlanningPipeline_Waves(t *testing.T) {
+	sequence := &QueryPlanningPipeline{Steps: []string{"lowercase", "tokenize"}}
+	waves, err := sequence.Waves()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"lowercase"}, {"tokenize"}}, waves)
+
+	dag := &QueryPlanningPipeline{
+		Steps: []string{"normalize_unicode", "detect_language", "parse_ranges", "classify_intent", "rewrite_query"},
+		DependsOn: map[string][]string{
+			"detect_language": {"normalize_unicode"},
+			"parse_ranges":    {}, // Runs first
+			"classify_intent": {"normalize_unicode"},
+			"rewrite_query":   {"classify_intent", "detect_language"},
+		},
+	}
+	waves, err = dag.Waves()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"normalize_unicode", "parse_ranges"},
+		{"detect_language", "classify_intent"},
+		{"rewrite_query"},
+	}, waves)
+
+	// Steps without an entry keep running after the step before them.
+	partial := &QueryPlanningPipeline{
+		Steps:     []string{"lowercase", "tokenize", "detect_language"},
+		DependsOn: map[string][]string{"detect_language": {"tokenize"}},
+	}
+	waves, err = partial.Waves()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"lowercase"}, {"tokenize"}, {"detect_language"}}, waves)
+
+	dag.Steps = append(dag.Steps, "detect_language")
+	_, err = dag.Waves()
+	assert.ErrorContains(t, err, "step 'detect_language' is listed twice")
+}
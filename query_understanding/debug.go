@@ -0,0 +1,95 @@
+package query_understanding
+
+import (
+	"context"
+	"time"
+
+	"query_understanding/config"
+	"query_understanding/processing"
+)
+
+// DebugOptions controls DebugPipeline.
+type DebugOptions struct {
+	ProcessOptions
+	// Steps runs these stages instead of a configured pipeline, e.g. to try a pipeline
+	// before adding it to the configuration.
+	Steps []string
+}
+
+// PipelineDebug reports how a pipeline processed a query, stage by stage.
+type PipelineDebug struct {
+	Pipeline string       `json:"pipeline"`
+	RawQuery string       `json:"raw_query"`
+	Stages   []StageDebug `json:"stages"`
+	TookMs   float64      `json:"took_ms"`
+	// Result is the structured query the pipeline produced; nil if a stage failed.
+	Result *StructuredQuery `json:"result,omitempty"`
+	Error  string           `json:"error,omitempty"` // Error of the failed stage
+}
+
+// StageDebug is the work of a single stage.
+type StageDebug struct {
+	Stage  string `json:"stage"`
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	// Annotations holds the annotations the stage set or changed, e.g. the language.
+	Annotations processing.Annotations `json:"annotations,omitempty"`
+	TookMs      float64                `json:"took_ms"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// adHocPipeline names the pipelines of DebugOptions.Steps.
+const adHocPipeline = "ad_hoc"
+
+// DebugPipeline processes a query like ProcessClientQueryContext, with rewrites
+// explained, and reports the output, annotations and duration of every stage. Failing
+// stages are reported in the returned PipelineDebug; errors are only returned for unknown
+// pipelines (ErrUnknownPipeline) and stages (processing.ErrUnknownStage).
+func DebugPipeline(ctx context.Context, rawQuery string, cfg *config.Configuration, opts DebugOptions) (*PipelineDebug, error) {
+	opts.Explain = true
+	configs := stageConfigs(ctx, cfg, opts.ProcessOptions)
+	pipeline, annotations, err := selectPipeline(rawQuery, cfg, opts.ProcessOptions, configs)
+	if len(opts.Steps) > 0 {
+		// The stages run instead of a pipeline still start from the query's language.
+		pipeline, err = &config.QueryPlanningPipeline{Name: adHocPipeline, Steps: opts.Steps}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	result, err := pipelineExecutor.ExecuteTracedWithAnnotations(pipeline, rawQuery, configs, annotations)
+	if result == nil {
+		return nil, err
+	}
+	debug := &PipelineDebug{
+		Pipeline: pipeline.Name,
+		RawQuery: rawQuery,
+		Stages:   make([]StageDebug, 0, len(result.Stages)),
+		TookMs:   milliseconds(time.Since(start)),
+	}
+	for _, stage := range result.Stages {
+		sd := StageDebug{
+			Stage:       stage.Stage,
+			Input:       stage.Input,
+			Output:      stage.Output,
+			Annotations: stage.Annotations,
+			TookMs:      milliseconds(stage.Duration),
+		}
+		if stage.Err != nil {
+			sd.Error = stage.Err.Error()
+		}
+		debug.Stages = append(debug.Stages, sd)
+	}
+	if err != nil {
+		debug.Error = err.Error()
+		return debug, nil
+	}
+	debug.Result = newStructuredQuery(rawQuery, result, opts.ProcessOptions)
+	return debug, nil
+}
+
+// milliseconds converts d to fractional milliseconds, as stages often take less than one.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,87 @@
+package query_understanding
+
+import (
+	"context"
+	"errors"
+
+	"common/qupb"
+	"query_understanding/config"
+	"query_understanding/processing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer serves the query understanding pipeline over gRPC, as the typed counterpart
+// of the /process HTTP endpoint.
+type GRPCServer struct {
+	qupb.UnimplementedQueryUnderstandingServer
+	cfg func() *config.Configuration
+}
+
+// NewGRPCServer creates a gRPC server processing queries with the given configuration.
+func NewGRPCServer(cfg *config.Configuration) *GRPCServer {
+	return &GRPCServer{cfg: func() *config.Configuration { return cfg }}
+}
+
+// NewLiveGRPCServer creates a gRPC server processing queries with the current
+// configuration of live, following its reloads.
+func NewLiveGRPCServer(live *LiveConfiguration) *GRPCServer {
+	return &GRPCServer{cfg: live.Get}
+}
+
+// Process runs the requested pipeline, or the default one, on a raw query. Unknown
+// pipelines are reported with the InvalidArgument status code and pipeline failures with
+// the Internal one.
+func (s *GRPCServer) Process(ctx context.Context, req *qupb.RawQuery) (*qupb.StructuredQuery, error) {
+	sq, err := ProcessClientQueryContext(ctx, req.GetQuery(), s.cfg(), ProcessOptions{Explain: req.GetExplain(), Pipeline: req.GetPipeline(), Collection: req.GetCollection()})
+	if errors.Is(err, ErrUnknownPipeline) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to process query: %v", err)
+	}
+	return sq.Proto(), nil
+}
+
+// Proto converts the structured query to its gRPC message.
+func (sq *StructuredQuery) Proto() *qupb.StructuredQuery {
+	msg := &qupb.StructuredQuery{
+		RawQuery:         sq.RawQuery,
+		ProcessedQuery:   sq.ProcessedQuery,
+		Tokens:           sq.Keywords,
+		Language:         sq.Language,
+		Intent:           sq.Intent,
+		IntentConfidence: sq.IntentConfidence,
+		Query:            queryNodeProto(sq.Query),
+		Embedding:        sq.Embedding,
+	}
+	for _, f := range sq.Filters {
+		msg.Filters = append(msg.Filters, &qupb.Filter{Field: f.Field, Value: f.Value, Exclude: f.Exclude})
+	}
+	for _, r := range sq.Rewrites {
+		msg.Rewrites = append(msg.Rewrites, &qupb.Rewrite{Rule: r.Rule, Before: r.Before, After: r.After})
+	}
+	for _, r := range sq.Ranges {
+		msg.Ranges = append(msg.Ranges, &qupb.Range{Type: r.Type, Field: r.Field, Min: r.Min, Max: r.Max, ExclusiveMin: r.ExclusiveMin, ExclusiveMax: r.ExclusiveMax, Start: r.Start, End: r.End})
+	}
+	return msg
+}
+
+// queryNodeProto converts a query tree to its gRPC message.
+func queryNodeProto(n *processing.QueryNode) *qupb.QueryNode {
+	if n == nil {
+		return nil
+	}
+	msg := &qupb.QueryNode{Type: n.Type, Field: n.Field, Text: n.Text}
+	for _, c := range n.Must {
+		msg.Must = append(msg.Must, queryNodeProto(c))
+	}
+	for _, c := range n.Should {
+		msg.Should = append(msg.Should, queryNodeProto(c))
+	}
+	for _, c := range n.MustNot {
+		msg.MustNot = append(msg.MustNot, queryNodeProto(c))
+	}
+	return msg
+}
@@ -0,0 +1,89 @@
+package query_understanding
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"common/qupb"
+	"query_understanding/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// newGRPCClient serves cfg over gRPC on a local port and returns a client for it.
+func newGRPCClient(t *testing.T, cfg *config.Configuration) qupb.QueryUnderstandingClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	qupb.RegisterQueryUnderstandingServer(server, NewGRPCServer(cfg))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return qupb.NewQueryUnderstandingClient(conn)
+}
+
+func TestGRPCServer_Process(t *testing.T) {
+	client := newGRPCClient(t, &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"parse_syntax", "detect_language", "lowercase", "rewrite_query", "tokenize"}},
+		},
+		QuerySyntax: config.QuerySyntaxConfig{DefaultOperator: "and"},
+		RewriteRules: []config.RewriteRule{
+			{Name: "sneakers", Match: "literal", Pattern: "sneakers", Replace: "trainers"},
+		},
+	})
+
+	resp, err := client.Process(context.Background(), &qupb.RawQuery{Query: "brand:acme sneakers -color:red", Explain: true})
+	require.NoError(t, err)
+	assert.Equal(t, "brand:acme sneakers -color:red", resp.GetRawQuery())
+	assert.Equal(t, []string{"acme", "trainers"}, resp.GetTokens())
+	require.Len(t, resp.GetFilters(), 2)
+	assert.Equal(t, "brand", resp.GetFilters()[0].GetField())
+	assert.Equal(t, "acme", resp.GetFilters()[0].GetValue())
+	assert.True(t, resp.GetFilters()[1].GetExclude())
+	require.NotNil(t, resp.GetQuery())
+	assert.Equal(t, "bool", resp.GetQuery().GetType())
+	assert.Len(t, resp.GetQuery().GetMust(), 2)
+	assert.Len(t, resp.GetQuery().GetMustNot(), 1)
+	require.Len(t, resp.GetRewrites(), 1)
+	assert.Equal(t, "sneakers", resp.GetRewrites()[0].GetRule())
+}
+
+func TestGRPCServer_Process_Ranges(t *testing.T) {
+	client := newGRPCClient(t, &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"parse_ranges", "tokenize"}},
+		},
+		RangeParsing: map[string]config.RangeParsingConfig{"products": {PriceField: "price"}},
+	})
+
+	resp, err := client.Process(context.Background(), &qupb.RawQuery{Query: "red shoes under $50", Collection: "products"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"red", "shoes"}, resp.GetTokens())
+	require.Len(t, resp.GetRanges(), 1)
+	assert.Equal(t, "price", resp.GetRanges()[0].GetField())
+	assert.Nil(t, resp.GetRanges()[0].Min)
+	assert.Equal(t, 50.0, resp.GetRanges()[0].GetMax())
+
+	// Collections without range fields keep the expression as keywords.
+	resp, err = client.Process(context.Background(), &qupb.RawQuery{Query: "red shoes under $50", Collection: "articles"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"red", "shoes", "under", "$50"}, resp.GetTokens())
+	assert.Empty(t, resp.GetRanges())
+}
+
+func TestGRPCServer_Process_MissingPipeline(t *testing.T) {
+	client := newGRPCClient(t, &config.Configuration{})
+	_, err := client.Process(context.Background(), &qupb.RawQuery{Query: "shoes"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
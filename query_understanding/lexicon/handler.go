@@ -0,0 +1,178 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Paths of the admin endpoints served by NewHandler.
+const (
+	StopwordsPath = "/admin/stopwords"
+	SynonymsPath  = "/admin/synonyms"
+	ReloadPath    = "/admin/lexicon/reload"
+)
+
+// handler serves the admin API of a lexicon.
+type handler struct {
+	lexicon *Lexicon
+	mux     *http.ServeMux
+}
+
+// NewHandler creates the admin API of a lexicon:
+//
+//   - GET /admin/stopwords?collection=...&language=... lists the stopword lists, filtered
+//     on the given parameters
+//   - PUT /admin/stopwords with a StopwordList body creates or replaces the list of its scope
+//   - DELETE /admin/stopwords?collection=...&language=... deletes the list of that scope
+//   - GET /admin/synonyms?collection=...&language=... lists the synonym sets
+//   - POST /admin/synonyms with a SynonymSet body creates a set, with a generated ID
+//     unless the body has one
+//   - GET, PUT and DELETE /admin/synonyms/{id} read, create or replace, and delete a set
+//   - POST /admin/lexicon/reload reloads the lexicon from its store
+//
+// Changes are persisted, then used by the next queries.
+func NewHandler(l *Lexicon) http.Handler {
+	h := &handler{lexicon: l, mux: http.NewServeMux()}
+	h.mux.HandleFunc(StopwordsPath, h.handleStopwords)
+	h.mux.HandleFunc(SynonymsPath, h.handleSynonyms)
+	h.mux.HandleFunc(SynonymsPath+"/", h.handleSynonymSet)
+	h.mux.HandleFunc(ReloadPath, h.handleReload)
+	return h.mux
+}
+
+func (h *handler) handleStopwords(w http.ResponseWriter, r *http.Request) {
+	scope := Scope{Collection: r.URL.Query().Get("collection"), Language: r.URL.Query().Get("language")}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"stopwords": h.lexicon.StopwordLists(scope)})
+	case http.MethodPut:
+		var list StopwordList
+		if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+			http.Error(w, fmt.Sprintf("invalid stopword list: %v", err), http.StatusBadRequest)
+			return
+		}
+		list, err := h.lexicon.PutStopwordList(list)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Updated stopword list", "scope", describeScope(list.Scope), "words", len(list.Words))
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodDelete:
+		if err := h.lexicon.DeleteStopwordList(scope); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Deleted stopword list", "scope", describeScope(scope.normalize()))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) handleSynonyms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		scope := Scope{Collection: r.URL.Query().Get("collection"), Language: r.URL.Query().Get("language")}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"synonyms": h.lexicon.SynonymSets(scope)})
+	case http.MethodPost:
+		var set SynonymSet
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+			http.Error(w, fmt.Sprintf("invalid synonym set: %v", err), http.StatusBadRequest)
+			return
+		}
+		set, err := h.lexicon.CreateSynonymSet(set)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Created synonym set", "id", set.ID, "terms", len(set.Terms))
+		writeJSON(w, http.StatusCreated, set)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) handleSynonymSet(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, SynonymsPath+"/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		set, err := h.lexicon.SynonymSet(id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, set)
+	case http.MethodPut:
+		var set SynonymSet
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+			http.Error(w, fmt.Sprintf("invalid synonym set: %v", err), http.StatusBadRequest)
+			return
+		}
+		if set.ID != "" && set.ID != id {
+			http.Error(w, fmt.Sprintf("synonym set ID %q doesn't match the path", set.ID), http.StatusBadRequest)
+			return
+		}
+		set.ID = id
+		set, err := h.lexicon.PutSynonymSet(set)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Updated synonym set", "id", set.ID, "terms", len(set.Terms))
+		writeJSON(w, http.StatusOK, set)
+	case http.MethodDelete:
+		if err := h.lexicon.DeleteSynonymSet(id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "Deleted synonym set", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.lexicon.Reload(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	data := h.lexicon.snapshot()
+	writeJSON(w, http.StatusOK, map[string]int{"stopword_lists": len(data.Stopwords), "synonym_sets": len(data.Synonyms)})
+}
+
+// writeError reports a lexicon error with the status code of its kind.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		slog.ErrorContext(r.Context(), "Lexicon admin request failed", "error", err)
+		http.Error(w, "Failed to update the lexicon", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+	}
+}
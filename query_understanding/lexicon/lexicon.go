@@ -0,0 +1,406 @@
+// Package lexicon manages the stopword lists and synonym sets used by the query
+// understanding pipeline. Lists and sets are scoped to a collection and a language, kept
+// in a Store and edited at runtime: changes apply to the next query without a restart.
+package lexicon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"common/textnorm"
+)
+
+var (
+	// ErrNotFound is returned for stopword lists and synonym sets that don't exist.
+	ErrNotFound = errors.New("not found in lexicon")
+	// ErrExists is returned when creating a synonym set whose ID is taken.
+	ErrExists = errors.New("already exists in lexicon")
+	// ErrInvalid is returned for stopword lists and synonym sets that can't be stored.
+	ErrInvalid = errors.New("invalid lexicon entry")
+)
+
+// Scope selects the queries a stopword list or synonym set applies to. An empty
+// collection or language matches any.
+type Scope struct {
+	Collection string `json:"collection,omitempty"`
+	Language   string `json:"language,omitempty"` // ISO 639-1 code, as detected by detect_language
+}
+
+// matches reports whether the scope applies to queries of a collection and language.
+func (s Scope) matches(collection, language string) bool {
+	return (s.Collection == "" || s.Collection == collection) && (s.Language == "" || s.Language == language)
+}
+
+// selects reports whether a filter selects the entries of another scope: those with the
+// same collection and language, where the filter sets them.
+func (s Scope) selects(other Scope) bool {
+	return (s.Collection == "" || s.Collection == other.Collection) && (s.Language == "" || s.Language == other.Language)
+}
+
+// normalize trims the scope and lower-cases its language.
+func (s Scope) normalize() Scope {
+	return Scope{Collection: strings.TrimSpace(s.Collection), Language: strings.ToLower(strings.TrimSpace(s.Language))}
+}
+
+// StopwordList is the list of words removed from the queries of a scope. There is at
+// most one list per scope; the most specific list matching a query is used.
+type StopwordList struct {
+	Scope
+	Words     []string  `json:"words"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SynonymSet is a group of equivalent terms: a query containing one of them is expanded
+// with the others. Terms are words or phrases.
+type SynonymSet struct {
+	ID string `json:"id"`
+	Scope
+	Terms     []string  `json:"terms"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Lexicon holds the stopword lists and synonym sets in memory and persists every change
+// to its store before applying it. It is safe for concurrent use.
+type Lexicon struct {
+	store Store
+
+	mu   sync.RWMutex
+	data *Data // Never modified in place; writes replace it
+	// folded is data with its words and terms folded like normalize_unicode folds the
+	// queries they are matched against, e.g. "für" as "fur".
+	folded *Data
+}
+
+// New creates a lexicon with the content of store.
+func New(store Store) (*Lexicon, error) {
+	l := &Lexicon{store: store}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload replaces the lexicon with the content of its store, e.g. after another replica
+// sharing the store changed it.
+func (l *Lexicon) Reload() error {
+	data, err := l.store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load lexicon: %w", err)
+	}
+	l.mu.Lock()
+	l.set(data)
+	l.mu.Unlock()
+	return nil
+}
+
+// set makes data current. Callers must hold l.mu.
+func (l *Lexicon) set(data *Data) {
+	folded := &Data{
+		Stopwords: make([]StopwordList, len(data.Stopwords)),
+		Synonyms:  make([]SynonymSet, len(data.Synonyms)),
+	}
+	for i, list := range data.Stopwords {
+		list.Words = foldTerms(list.Words)
+		folded.Stopwords[i] = list
+	}
+	for i, set := range data.Synonyms {
+		set.Terms = foldTerms(set.Terms)
+		folded.Synonyms[i] = set
+	}
+	l.data, l.folded = data, folded
+}
+
+// Watch reloads the lexicon from its store every interval until ctx is done.
+func (l *Lexicon) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Reload(); err != nil {
+				slog.ErrorContext(ctx, "Failed to reload lexicon", "error", err)
+			}
+		}
+	}
+}
+
+// snapshot returns the current content, which must not be modified.
+func (l *Lexicon) snapshot() *Data {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.data
+}
+
+// foldedSnapshot returns the current content folded like queries, which must not be
+// modified.
+func (l *Lexicon) foldedSnapshot() *Data {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.folded
+}
+
+// update applies change to the content of the store, saves it and makes it current. The
+// content is re-read under the lock rather than taken from memory, so that changes saved
+// by other replicas since the last reload aren't overwritten.
+func (l *Lexicon) update(change func(data *Data) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	data, err := l.store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load lexicon: %w", err)
+	}
+	if err := change(data); err != nil {
+		return err
+	}
+	if err := l.store.Save(data); err != nil {
+		return fmt.Errorf("failed to save lexicon: %w", err)
+	}
+	l.set(data)
+	return nil
+}
+
+// Stopwords returns the words to remove from the queries of a collection and language,
+// from the most specific matching list: the one of the collection and language, then of
+// the collection, then of the language, then the global one. The words are folded like
+// queries. ok is false when no list matches.
+func (l *Lexicon) Stopwords(collection, language string) (words []string, ok bool) {
+	best := -1
+	for _, list := range l.foldedSnapshot().Stopwords {
+		if !list.matches(collection, language) {
+			continue
+		}
+		// Collections are more specific than languages.
+		rank := 0
+		if list.Collection != "" {
+			rank += 2
+		}
+		if list.Language != "" {
+			rank++
+		}
+		if rank > best {
+			best, words = rank, list.Words
+		}
+	}
+	return words, best >= 0
+}
+
+// Synonyms returns the terms of every synonym set matching a collection and language,
+// folded like queries.
+func (l *Lexicon) Synonyms(collection, language string) [][]string {
+	var groups [][]string
+	for _, set := range l.foldedSnapshot().Synonyms {
+		if set.matches(collection, language) {
+			groups = append(groups, set.Terms)
+		}
+	}
+	return groups
+}
+
+// StopwordLists returns the lists matching filter exactly on its non-empty fields,
+// sorted by scope.
+func (l *Lexicon) StopwordLists(filter Scope) []StopwordList {
+	filter = filter.normalize()
+	lists := []StopwordList{}
+	for _, list := range l.snapshot().Stopwords {
+		if filter.selects(list.Scope) {
+			lists = append(lists, list)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool { return scopeLess(lists[i].Scope, lists[j].Scope) })
+	return lists
+}
+
+// StopwordList returns the list of exactly the given scope.
+func (l *Lexicon) StopwordList(scope Scope) (StopwordList, error) {
+	scope = scope.normalize()
+	for _, list := range l.snapshot().Stopwords {
+		if list.Scope == scope {
+			return list, nil
+		}
+	}
+	return StopwordList{}, fmt.Errorf("%w: stopword list %s", ErrNotFound, describeScope(scope))
+}
+
+// PutStopwordList creates or replaces the list of a scope. Words are trimmed,
+// lower-cased and deduplicated.
+func (l *Lexicon) PutStopwordList(list StopwordList) (StopwordList, error) {
+	list.Scope = list.Scope.normalize()
+	list.Words = normalizeTerms(list.Words)
+	if len(list.Words) == 0 {
+		return StopwordList{}, fmt.Errorf("%w: stopword list %s has no words", ErrInvalid, describeScope(list.Scope))
+	}
+	list.UpdatedAt = time.Now().UTC()
+	err := l.update(func(data *Data) error {
+		for i := range data.Stopwords {
+			if data.Stopwords[i].Scope == list.Scope {
+				data.Stopwords[i] = list
+				return nil
+			}
+		}
+		data.Stopwords = append(data.Stopwords, list)
+		return nil
+	})
+	if err != nil {
+		return StopwordList{}, err
+	}
+	return list, nil
+}
+
+// DeleteStopwordList deletes the list of exactly the given scope.
+func (l *Lexicon) DeleteStopwordList(scope Scope) error {
+	scope = scope.normalize()
+	return l.update(func(data *Data) error {
+		for i := range data.Stopwords {
+			if data.Stopwords[i].Scope == scope {
+				data.Stopwords = append(data.Stopwords[:i], data.Stopwords[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: stopword list %s", ErrNotFound, describeScope(scope))
+	})
+}
+
+// SynonymSets returns the sets matching filter exactly on its non-empty fields, sorted
+// by ID.
+func (l *Lexicon) SynonymSets(filter Scope) []SynonymSet {
+	filter = filter.normalize()
+	sets := []SynonymSet{}
+	for _, set := range l.snapshot().Synonyms {
+		if filter.selects(set.Scope) {
+			sets = append(sets, set)
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].ID < sets[j].ID })
+	return sets
+}
+
+// SynonymSet returns the set with the given ID.
+func (l *Lexicon) SynonymSet(id string) (SynonymSet, error) {
+	for _, set := range l.snapshot().Synonyms {
+		if set.ID == id {
+			return set, nil
+		}
+	}
+	return SynonymSet{}, fmt.Errorf("%w: synonym set %q", ErrNotFound, id)
+}
+
+// CreateSynonymSet adds a set, generating its ID unless set has one. Creating a set
+// whose ID is taken fails with ErrExists.
+func (l *Lexicon) CreateSynonymSet(set SynonymSet) (SynonymSet, error) {
+	if set.ID == "" {
+		set.ID = newID()
+	}
+	return l.putSynonymSet(set, false)
+}
+
+// PutSynonymSet creates or replaces the set with the ID of set. Terms are trimmed,
+// lower-cased and deduplicated; a set needs two terms or more.
+func (l *Lexicon) PutSynonymSet(set SynonymSet) (SynonymSet, error) {
+	return l.putSynonymSet(set, true)
+}
+
+// putSynonymSet stores set, replacing the set with the same ID if replace is set.
+func (l *Lexicon) putSynonymSet(set SynonymSet, replace bool) (SynonymSet, error) {
+	if set.ID == "" || strings.ContainsAny(set.ID, "/ ") {
+		return SynonymSet{}, fmt.Errorf("%w: synonym set ID %q must be non-empty without slashes or spaces", ErrInvalid, set.ID)
+	}
+	set.Scope = set.Scope.normalize()
+	set.Terms = normalizeTerms(set.Terms)
+	if len(set.Terms) < 2 {
+		return SynonymSet{}, fmt.Errorf("%w: synonym set %q needs at least two distinct terms", ErrInvalid, set.ID)
+	}
+	set.UpdatedAt = time.Now().UTC()
+	err := l.update(func(data *Data) error {
+		for i := range data.Synonyms {
+			if data.Synonyms[i].ID != set.ID {
+				continue
+			}
+			if !replace {
+				return fmt.Errorf("%w: synonym set %q", ErrExists, set.ID)
+			}
+			data.Synonyms[i] = set
+			return nil
+		}
+		data.Synonyms = append(data.Synonyms, set)
+		return nil
+	})
+	if err != nil {
+		return SynonymSet{}, err
+	}
+	return set, nil
+}
+
+// DeleteSynonymSet deletes the set with the given ID.
+func (l *Lexicon) DeleteSynonymSet(id string) error {
+	return l.update(func(data *Data) error {
+		for i := range data.Synonyms {
+			if data.Synonyms[i].ID == id {
+				data.Synonyms = append(data.Synonyms[:i], data.Synonyms[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: synonym set %q", ErrNotFound, id)
+	})
+}
+
+// normalizeTerms lower-cases terms and collapses their whitespace, dropping empty and
+// duplicate ones. The order of the first occurrences is kept.
+func normalizeTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	var out []string
+	for _, term := range terms {
+		term = strings.Join(strings.Fields(strings.ToLower(term)), " ")
+		if term == "" || seen[term] {
+			continue
+		}
+		seen[term] = true
+		out = append(out, term)
+	}
+	return out
+}
+
+// foldTerms returns terms folded like normalize_unicode folds queries.
+func foldTerms(terms []string) []string {
+	folded := make([]string, len(terms))
+	for i, term := range terms {
+		folded[i] = textnorm.Normalize(term, textnorm.Fold)
+	}
+	return folded
+}
+
+// scopeLess orders scopes by collection, then language.
+func scopeLess(a, b Scope) bool {
+	if a.Collection != b.Collection {
+		return a.Collection < b.Collection
+	}
+	return a.Language < b.Language
+}
+
+// describeScope names a scope in error messages.
+func describeScope(s Scope) string {
+	collection, language := s.Collection, s.Language
+	if collection == "" {
+		collection = "*"
+	}
+	if language == "" {
+		language = "*"
+	}
+	return fmt.Sprintf("(collection %s, language %s)", collection, language)
+}
+
+// newID returns a random synonym set ID.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,166 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLexicon_Stopwords(t *testing.T) {
+	l, err := New(NewMemoryStore())
+	require.NoError(t, err)
+
+	_, ok := l.Stopwords("products", "en")
+	assert.False(t, ok)
+
+	for _, list := range []StopwordList{
+		{Words: []string{"the"}},
+		{Scope: Scope{Language: "FR"}, Words: []string{"le", " LA ", "le"}},
+		{Scope: Scope{Collection: "products"}, Words: []string{"buy"}},
+		{Scope: Scope{Collection: "products", Language: "en"}, Words: []string{"cheap"}},
+	} {
+		_, err := l.PutStopwordList(list)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		collection, language string
+		expected             []string
+	}{
+		{"products", "en", []string{"cheap"}},
+		{"products", "fr", []string{"buy"}},
+		{"articles", "fr", []string{"le", "la"}},
+		{"articles", "de", []string{"the"}},
+	}
+	for _, tt := range tests {
+		words, ok := l.Stopwords(tt.collection, tt.language)
+		assert.True(t, ok)
+		assert.Equal(t, tt.expected, words, "%s/%s", tt.collection, tt.language)
+	}
+
+	require.NoError(t, l.DeleteStopwordList(Scope{Collection: "products", Language: "en"}))
+	words, _ := l.Stopwords("products", "en")
+	assert.Equal(t, []string{"buy"}, words)
+	assert.ErrorIs(t, l.DeleteStopwordList(Scope{Collection: "products", Language: "en"}), ErrNotFound)
+
+	_, err = l.PutStopwordList(StopwordList{Words: []string{" "}})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// Lists are served folded like normalized queries, but kept as written.
+	_, err = l.PutStopwordList(StopwordList{Scope: Scope{Language: "de"}, Words: []string{"für", "Über"}})
+	require.NoError(t, err)
+	words, _ = l.Stopwords("articles", "de")
+	assert.Equal(t, []string{"fur", "uber"}, words)
+	list, err := l.StopwordList(Scope{Language: "de"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"für", "über"}, list.Words)
+}
+
+func TestLexicon_SynonymSets(t *testing.T) {
+	l, err := New(NewMemoryStore())
+	require.NoError(t, err)
+
+	created, err := l.CreateSynonymSet(SynonymSet{Terms: []string{"PC", "personal  computer"}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, []string{"pc", "personal computer"}, created.Terms)
+
+	_, err = l.CreateSynonymSet(SynonymSet{ID: "tv", Scope: Scope{Collection: "products"}, Terms: []string{"tv", "television"}})
+	require.NoError(t, err)
+	_, err = l.CreateSynonymSet(SynonymSet{ID: "tv", Terms: []string{"tv", "telly"}})
+	assert.ErrorIs(t, err, ErrExists)
+	_, err = l.PutSynonymSet(SynonymSet{ID: "single", Terms: []string{"tv", "TV"}})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	assert.Len(t, l.Synonyms("products", "en"), 2)
+	assert.Equal(t, [][]string{{"pc", "personal computer"}}, l.Synonyms("articles", "en"))
+	assert.Len(t, l.SynonymSets(Scope{Collection: "products"}), 1)
+
+	_, err = l.PutSynonymSet(SynonymSet{ID: "tv", Terms: []string{"tv", "telly"}})
+	require.NoError(t, err)
+	set, err := l.SynonymSet("tv")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tv", "telly"}, set.Terms)
+	assert.Empty(t, set.Collection)
+
+	_, err = l.PutSynonymSet(SynonymSet{ID: "coffee", Scope: Scope{Collection: "menus"}, Terms: []string{"café", "coffee"}})
+	require.NoError(t, err)
+	assert.Contains(t, l.Synonyms("menus", "fr"), []string{"cafe", "coffee"})
+
+	require.NoError(t, l.DeleteSynonymSet("tv"))
+	_, err = l.SynonymSet("tv")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLexicon_FileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lexicon.json")
+	l, err := New(NewFileStore(path))
+	require.NoError(t, err)
+	other, err := New(NewFileStore(path))
+	require.NoError(t, err)
+	_, err = l.PutStopwordList(StopwordList{Scope: Scope{Language: "en"}, Words: []string{"the"}})
+	require.NoError(t, err)
+
+	// Another replica sharing the file keeps the change when it saves its own before
+	// reloading, and sees it once it does.
+	_, err = other.CreateSynonymSet(SynonymSet{ID: "tv", Terms: []string{"tv", "television"}})
+	require.NoError(t, err)
+	words, ok := other.Stopwords("", "en")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"the"}, words)
+	assert.Empty(t, l.Synonyms("", "en"))
+	require.NoError(t, l.Reload())
+	assert.Len(t, l.Synonyms("", "en"), 1)
+	words, ok = l.Stopwords("", "en")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"the"}, words)
+}
+
+func TestHandler(t *testing.T) {
+	l, err := New(NewMemoryStore())
+	require.NoError(t, err)
+	h := NewHandler(l)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPut, "/admin/stopwords", `{"collection": "products", "language": "en", "words": ["Cheap", "buy"]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	words, _ := l.Stopwords("products", "en")
+	assert.Equal(t, []string{"cheap", "buy"}, words)
+
+	rec = do(http.MethodGet, "/admin/stopwords?collection=products", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var lists struct{ Stopwords []StopwordList }
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&lists))
+	assert.Len(t, lists.Stopwords, 1)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/admin/stopwords", `{"words": []}`).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/admin/stopwords?collection=products&language=en", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/admin/stopwords?collection=products&language=en", "").Code)
+
+	rec = do(http.MethodPost, "/admin/synonyms", `{"id": "tv", "terms": ["tv", "television"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/admin/synonyms", `{"id": "tv", "terms": ["tv", "telly"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/admin/synonyms/tv", `{"id": "other", "terms": ["tv", "telly"]}`).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/admin/synonyms/tv", `{"terms": ["tv", "telly"]}`).Code)
+
+	rec = do(http.MethodGet, "/admin/synonyms/tv", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var set SynonymSet
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&set))
+	assert.Equal(t, []string{"tv", "telly"}, set.Terms)
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/admin/synonyms/tv", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/admin/synonyms/tv", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/admin/lexicon/reload", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/admin/lexicon/reload", "").Code)
+}
@@ -0,0 +1,112 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Data is the content of a lexicon as persisted by a Store.
+type Data struct {
+	Stopwords []StopwordList `json:"stopwords"`
+	Synonyms  []SynonymSet   `json:"synonyms"`
+}
+
+// Store persists the stopword lists and synonym sets of a lexicon.
+type Store interface {
+	// Load returns the stored lexicon, empty if nothing was saved yet.
+	Load() (*Data, error)
+	// Save replaces the stored lexicon.
+	Save(data *Data) error
+}
+
+// FileStore keeps the lexicon in a JSON file, replaced atomically on every save so that
+// other replicas reading it never see a partial write.
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a store persisting to path. The file is created on the first save.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load reads the lexicon file; a missing file is an empty lexicon.
+func (s *FileStore) Load() (*Data, error) {
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Data{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lexicon file %s: %w", s.path, err)
+	}
+	var data Data
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse lexicon file %s: %w", s.path, err)
+	}
+	return &data, nil
+}
+
+// Save writes the lexicon file.
+func (s *FileStore) Save(data *Data) error {
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal lexicon: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create lexicon file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write lexicon file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write lexicon file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace lexicon file %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// MemoryStore keeps the lexicon in memory only, losing the changes on restart.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Load returns a copy of the last saved lexicon.
+func (s *MemoryStore) Load() (*Data, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data Data
+	if s.data == nil {
+		return &data, nil
+	}
+	if err := json.Unmarshal(s.data, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// Save keeps a copy of the lexicon.
+func (s *MemoryStore) Save(data *Data) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lexicon: %w", err)
+	}
+	s.mu.Lock()
+	s.data = raw
+	s.mu.Unlock()
+	return nil
+}
@@ -1,16 +1,31 @@
 package query_understanding
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 
+	"common/textnorm"
 	"query_understanding/config"
+	"query_understanding/lexicon"
 	"query_understanding/processing"
 
 	"gopkg.in/yaml.v2"
 )
 
+// stopwordsFilePath is the file the default stopwords are read from.
+const stopwordsFilePath = "config/default_stopwords.yaml"
+
+// stopwordsDir holds the stopwords of every language, in files named by its ISO 639-1
+// code, e.g. fr.yaml.
+const stopwordsDir = "config/stopwords"
+
 // stopwordsConfig is a helper struct to unmarshal the stopwords YAML file.
 type stopwordsConfig struct {
 	Stopwords []string `yaml:"stopwords"`
@@ -19,7 +34,16 @@ type stopwordsConfig struct {
 var (
 	stageRegistry    *processing.StageRegistry
 	pipelineExecutor *processing.PipelineExecutor
-	defaultStopwords []string
+	// defaultStopwords are the stopwords of queries without a matching lexicon list,
+	// replaced by LiveConfiguration.Reload.
+	defaultStopwords atomic.Pointer[[]string]
+	// languageStopwords are the stopwords of stopwordsDir by language, which apply to the
+	// queries of their language without a matching lexicon list.
+	languageStopwords atomic.Pointer[map[string][]string]
+	// vocabulary provides the stopwords and synonyms managed at runtime, see SetLexicon.
+	vocabulary processing.Vocabulary
+	// embedder computes the query embeddings of the embed_query stage, see SetEmbedder.
+	embedder processing.Embedder
 )
 
 // init initializes the query understanding service components.
@@ -35,17 +59,16 @@ func init() {
 	}
 
 	// Load default stopwords
-	stopwordsFilePath := "config/default_stopwords.yaml"
-	data, err := os.ReadFile(stopwordsFilePath)
+	stopwords, err := loadStopwords(stopwordsFilePath)
 	if err != nil {
-		log.Fatalf("Failed to read stopwords file %s: %v", stopwordsFilePath, err)
+		log.Fatal(err)
 	}
-
-	var swConfig stopwordsConfig
-	if err := yaml.Unmarshal(data, &swConfig); err != nil {
-		log.Fatalf("Failed to unmarshal stopwords file %s: %v", stopwordsFilePath, err)
+	defaultStopwords.Store(&stopwords)
+	byLanguage, err := loadLanguageStopwords(stopwordsDir)
+	if err != nil {
+		log.Fatal(err)
 	}
-	defaultStopwords = swConfig.Stopwords
+	languageStopwords.Store(&byLanguage)
 
 	// Register RemoveStopwordsStage
 	// Note: The stopwords are passed as config during pipeline execution if needed,
@@ -58,47 +81,297 @@ func init() {
 		log.Fatalf("Failed to register synonym_expansion stage: %v", err)
 	}
 
+	if err := stageRegistry.Register("detect_language", &processing.LanguageDetectionStage{}); err != nil {
+		log.Fatalf("Failed to register detect_language stage: %v", err)
+	}
+
+	if err := stageRegistry.Register("classify_intent", &processing.IntentClassificationStage{}); err != nil {
+		log.Fatalf("Failed to register classify_intent stage: %v", err)
+	}
+
+	if err := stageRegistry.Register("parse_syntax", &processing.QuerySyntaxStage{}); err != nil {
+		log.Fatalf("Failed to register parse_syntax stage: %v", err)
+	}
+
+	if err := stageRegistry.Register("normalize_unicode", &processing.UnicodeNormalizationStage{}); err != nil {
+		log.Fatalf("Failed to register normalize_unicode stage: %v", err)
+	}
+
+	if err := stageRegistry.Register("parse_ranges", &processing.RangeParsingStage{}); err != nil {
+		log.Fatalf("Failed to register parse_ranges stage: %v", err)
+	}
+
+	if err := stageRegistry.Register("rewrite_query", &processing.QueryRewriteStage{}); err != nil {
+		log.Fatalf("Failed to register rewrite_query stage: %v", err)
+	}
+
+	if err := stageRegistry.Register("embed_query", &processing.EmbedQueryStage{}); err != nil {
+		log.Fatalf("Failed to register embed_query stage: %v", err)
+	}
+
 	pipelineExecutor = processing.NewPipelineExecutor(stageRegistry)
 }
 
-// LoadConfiguration loads the main service configuration from a YAML file.
+// loadStopwords reads a stopwords YAML file. The words are folded like normalize_unicode
+// folds queries, so that "für" is removed from "schuhe für kinder" once it reads "fur".
+func loadStopwords(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stopwords file %s: %w", path, err)
+	}
+	var swConfig stopwordsConfig
+	if err := yaml.Unmarshal(data, &swConfig); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stopwords file %s: %w", path, err)
+	}
+	for i, word := range swConfig.Stopwords {
+		swConfig.Stopwords[i] = textnorm.Normalize(word, textnorm.Fold)
+	}
+	return swConfig.Stopwords, nil
+}
+
+// loadLanguageStopwords reads the stopwords YAML files of dir by language, the names of
+// the files without their extension.
+func loadLanguageStopwords(dir string) (map[string][]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stopwords files of %s: %w", dir, err)
+	}
+	byLanguage := make(map[string][]string, len(paths))
+	for _, path := range paths {
+		stopwords, err := loadStopwords(path)
+		if err != nil {
+			return nil, err
+		}
+		byLanguage[strings.TrimSuffix(filepath.Base(path), ".yaml")] = stopwords
+	}
+	return byLanguage, nil
+}
+
+// SetLexicon makes the remove_stopwords and synonym_expansion stages use the stopword
+// lists and synonym sets of l, falling back to the default stopwords for queries without
+// a matching list. Changes made to l apply to the next queries. It must be called before
+// queries are processed.
+func SetLexicon(l *lexicon.Lexicon) {
+	if l == nil {
+		vocabulary = nil
+		return
+	}
+	vocabulary = l
+}
+
+// SetEmbedder makes the embed_query stage attach the embedding of queries computed by e
+// to their StructuredQuery; nil leaves queries unembedded. It must be called before
+// queries are processed.
+func SetEmbedder(e processing.Embedder) {
+	embedder = e
+}
+
+// LoadConfiguration loads the main service configuration from a YAML file. The steps of
+// enabled pipelines must name registered stages.
 func LoadConfiguration(filePath string) (*config.Configuration, error) {
-	cfg, err := config.LoadConfig(filePath)
+	cfg, err := config.LoadConfigWithOptions(filePath, config.LoadOptions{StageExists: stageRegistry.Has})
 	if err != nil {
 		return nil, fmt.Errorf("failed to load main configuration: %w", err)
 	}
 	return cfg, nil
 }
 
+// StructuredQuery is the result of processing a raw client query, as returned to the Broker.
+type StructuredQuery struct {
+	RawQuery       string   `json:"raw_query"`
+	ProcessedQuery string   `json:"processed_query"`
+	Keywords       []string `json:"keywords"`
+	Language       string   `json:"language,omitempty"`
+	// Intent is the query intent (e.g. "navigational", "transactional") the Broker can
+	// use to choose pipelines or boosts.
+	Intent           string  `json:"intent,omitempty"`
+	IntentConfidence float64 `json:"intent_confidence,omitempty"`
+	// Query is the boolean query tree parsed from query syntax (phrases, +/- operators,
+	// field:value, OR). It is nil for plain keyword queries.
+	Query *processing.QueryNode `json:"query,omitempty"`
+	// Filters are the field restrictions of the query tree, e.g. brand:acme or -color:red.
+	Filters []processing.FieldFilter `json:"filters,omitempty"`
+	// Ranges are the numeric and date ranges parsed from expressions such as "under $50"
+	// or "last 7 days", which were removed from the keywords.
+	Ranges []processing.RangeFilter `json:"ranges,omitempty"`
+	// Rewrites lists the rewrite rules that fired, in order. It is only set in explain mode.
+	Rewrites []processing.RewriteTrace `json:"rewrites,omitempty"`
+	// Embedding is the vector of the query computed by the embed_query stage, which the
+	// Broker searches vector fields with. It is nil if the pipeline doesn't embed queries.
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// DefaultPipeline is the pipeline queries are processed with unless another one is named.
+const DefaultPipeline = "default_pipeline"
+
+// ErrUnknownPipeline is returned for queries naming a pipeline missing from the configuration.
+var ErrUnknownPipeline = errors.New("unknown query planning pipeline")
+
+// ProcessOptions controls optional behaviour of ProcessClientQueryWithOptions.
+type ProcessOptions struct {
+	Explain  bool   // Report which rewrite rules fired
+	Pipeline string // Pipeline to run, e.g. one under experiment; empty is DefaultPipeline
+	// Collection the query searches, selecting its stopwords and synonyms.
+	Collection string
+	// Language of the query, e.g. "fr", selecting its stopwords and pipeline; empty lets
+	// the detect_language stage detect it.
+	Language string
+}
+
 // ProcessClientQuery is the main entry point for processing a raw client query.
 // It takes the raw query string and the specific service configuration,
-// then processes it through the "default_pipeline" or a pipeline specified by the configuration.
+// then processes it through DefaultPipeline.
 func ProcessClientQuery(rawQuery string, cfg *config.Configuration) (string, error) {
-	pipelineName := "default_pipeline" // For simplicity, assume default_pipeline
+	sq, err := ProcessClientQueryStructured(rawQuery, cfg)
+	if err != nil {
+		return "", err
+	}
+	return sq.ProcessedQuery, nil
+}
+
+// ProcessClientQueryStructured processes a raw client query like ProcessClientQuery and
+// returns the processed query together with the annotations produced by the pipeline.
+func ProcessClientQueryStructured(rawQuery string, cfg *config.Configuration) (*StructuredQuery, error) {
+	return ProcessClientQueryWithOptions(rawQuery, cfg, ProcessOptions{})
+}
+
+// ProcessClientQueryWithOptions is ProcessClientQueryStructured with options, e.g. to
+// explain how the query was rewritten or to run another pipeline. Naming a pipeline
+// missing from cfg fails with an error wrapping ErrUnknownPipeline.
+func ProcessClientQueryWithOptions(rawQuery string, cfg *config.Configuration, opts ProcessOptions) (*StructuredQuery, error) {
+	return ProcessClientQueryContext(context.Background(), rawQuery, cfg, opts)
+}
+
+// ProcessClientQueryContext is ProcessClientQueryWithOptions for a query whose stages
+// calling out to models, such as embed_query, stop when ctx is done.
+func ProcessClientQueryContext(ctx context.Context, rawQuery string, cfg *config.Configuration, opts ProcessOptions) (*StructuredQuery, error) {
+	configs := stageConfigs(ctx, cfg, opts)
+	pipeline, annotations, err := selectPipeline(rawQuery, cfg, opts, configs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute the pipeline using the PipelineExecutor
+	result, err := pipelineExecutor.ExecuteWithAnnotations(pipeline, rawQuery, configs, annotations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process query with pipeline '%s': %w", pipeline.Name, err)
+	}
+	return newStructuredQuery(rawQuery, result, opts), nil
+}
+
+// selectPipeline returns the pipeline of a query together with the annotations it starts
+// with: the pipeline named by opts, else the one of the query's language in
+// cfg.LanguagePipelines, else DefaultPipeline. The language is the one of opts, or the
+// detected one if cfg has language pipelines; the detect_language stage keeps it. Queries
+// too short or too ambiguous for a reliable detection get the default language, see
+// config.LanguageDetectionConfig, so they aren't sent down another language's pipeline.
+func selectPipeline(rawQuery string, cfg *config.Configuration, opts ProcessOptions, configs map[string]map[string]interface{}) (*config.QueryPlanningPipeline, processing.Annotations, error) {
+	annotations := processing.Annotations{}
+	if opts.Language != "" {
+		annotations[processing.AnnotationLanguage] = strings.ToLower(opts.Language)
+	} else if opts.Pipeline == "" && len(cfg.LanguagePipelines) > 0 {
+		if _, err := (&processing.LanguageDetectionStage{}).ProcessAnnotated(rawQuery, configs["detect_language"], annotations); err != nil {
+			return nil, nil, err
+		}
+	}
+	name := opts.Pipeline
+	if name == "" {
+		name = cfg.LanguagePipelines[annotations.String(processing.AnnotationLanguage)]
+	}
+	pipeline, err := findPipeline(cfg, name)
+	return pipeline, annotations, err
+}
 
-	var defaultPipeline *config.QueryPlanningPipeline
+// findPipeline returns the named pipeline of cfg, DefaultPipeline if name is empty.
+func findPipeline(cfg *config.Configuration, name string) (*config.QueryPlanningPipeline, error) {
+	pipelineName := name
+	if pipelineName == "" {
+		pipelineName = DefaultPipeline
+	}
 	for i := range cfg.QueryPlanningPipelines {
 		if cfg.QueryPlanningPipelines[i].Name == pipelineName {
-			defaultPipeline = &cfg.QueryPlanningPipelines[i]
-			break
+			return &cfg.QueryPlanningPipelines[i], nil
 		}
 	}
-
-	if defaultPipeline == nil {
-		return "", fmt.Errorf("query planning pipeline '%s' not found in the provided configuration", pipelineName)
+	if name != "" {
+		return nil, fmt.Errorf("%w: '%s' not found in the provided configuration", ErrUnknownPipeline, pipelineName)
 	}
+	return nil, fmt.Errorf("query planning pipeline '%s' not found in the provided configuration", pipelineName)
+}
 
-	// Prepare stage-specific configurations.
+// stageConfigs prepares the stage-specific configurations of a query, whose context is ctx.
+func stageConfigs(ctx context.Context, cfg *config.Configuration, opts ProcessOptions) map[string]map[string]interface{} {
 	stageConfigs := make(map[string]map[string]interface{})
 	stageConfigs["remove_stopwords"] = map[string]interface{}{
-		"stopwords": defaultStopwords,
+		"stopwords":          *defaultStopwords.Load(),
+		"language_stopwords": *languageStopwords.Load(),
 	}
-
-	// Execute the pipeline using the PipelineExecutor
-	processedQuery, err := pipelineExecutor.ExecutePipeline(defaultPipeline, rawQuery, stageConfigs)
-	if err != nil {
-		return "", fmt.Errorf("failed to process query with pipeline '%s': %w", pipelineName, err)
+	if vocabulary != nil {
+		stageConfigs["remove_stopwords"]["vocabulary"] = vocabulary
+		stageConfigs["remove_stopwords"]["collection"] = opts.Collection
+		stageConfigs["synonym_expansion"] = map[string]interface{}{
+			"vocabulary": vocabulary,
+			"collection": opts.Collection,
+		}
+	}
+	stageConfigs["classify_intent"] = map[string]interface{}{
+		"rules": cfg.IntentRules,
+	}
+	stageConfigs["rewrite_query"] = map[string]interface{}{
+		"rules": cfg.RewriteRules,
+	}
+	if embedder != nil && slices.Contains(cfg.VectorCollections, opts.Collection) {
+		stageConfigs["embed_query"] = map[string]interface{}{
+			"embedder": embedder,
+			"context":  ctx,
+		}
 	}
+	syntaxConfig := map[string]interface{}{}
+	if cfg.QuerySyntax.DefaultOperator != "" {
+		syntaxConfig["default_operator"] = cfg.QuerySyntax.DefaultOperator
+	}
+	if len(cfg.QuerySyntax.Fields) > 0 {
+		syntaxConfig["fields"] = cfg.QuerySyntax.Fields
+	}
+	stageConfigs["parse_syntax"] = syntaxConfig
+	detectionConfig := map[string]interface{}{}
+	if cfg.LanguageDetection.DefaultLanguage != "" {
+		detectionConfig["default_language"] = cfg.LanguageDetection.DefaultLanguage
+	}
+	if cfg.LanguageDetection.MinConfidence > 0 {
+		detectionConfig["min_confidence"] = cfg.LanguageDetection.MinConfidence
+	}
+	if cfg.LanguageDetection.MinTokens > 0 {
+		detectionConfig["min_tokens"] = cfg.LanguageDetection.MinTokens
+	}
+	stageConfigs["detect_language"] = detectionConfig
+	ranges := cfg.RangeParsing[opts.Collection]
+	stageConfigs["parse_ranges"] = map[string]interface{}{
+		"price_field": ranges.PriceField,
+		"year_field":  ranges.YearField,
+		"date_field":  ranges.DateField,
+	}
+	return stageConfigs
+}
 
-	return processedQuery, nil
+// newStructuredQuery builds the structured query of a pipeline result.
+func newStructuredQuery(rawQuery string, result *processing.PipelineResult, opts ProcessOptions) *StructuredQuery {
+	sq := &StructuredQuery{
+		RawQuery:       rawQuery,
+		ProcessedQuery: result.Query,
+		Keywords:       strings.Fields(result.Query),
+		Language:       result.Annotations.String(processing.AnnotationLanguage),
+		Intent:         result.Annotations.String(processing.AnnotationIntent),
+	}
+	sq.Query, _ = result.Annotations[processing.AnnotationQueryTree].(*processing.QueryNode)
+	sq.Filters = sq.Query.Filters()
+	sq.Ranges, _ = result.Annotations[processing.AnnotationRanges].([]processing.RangeFilter)
+	sq.Embedding, _ = result.Annotations[processing.AnnotationEmbedding].([]float32)
+	if confidence, ok := result.Annotations[processing.AnnotationIntentConfidence].(float64); ok {
+		sq.IntentConfidence = confidence
+	}
+	if opts.Explain {
+		sq.Rewrites, _ = result.Annotations[processing.AnnotationRewrites].([]processing.RewriteTrace)
+	}
+	return sq
 }
@@ -0,0 +1,179 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"common/vector"
+)
+
+// AnnotationEmbedding is the annotation key holding the query embedding ([]float32).
+const AnnotationEmbedding = "embedding"
+
+// Embedder is implemented by text embedding models, e.g. a sentence transformer served
+// out of process. Embed returns the vector of a text, to be compared with the document
+// vectors the same model computed at indexing time. Embedding stops when ctx is done.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// HTTPEmbedder is an Embedder calling an external embedding endpoint. It POSTs
+//
+//	{"input": "<text>", "model": "<model>"}
+//
+// and accepts either {"embedding": [...]} or the OpenAI-compatible
+// {"data": [{"embedding": [...]}]} in response.
+type HTTPEmbedder struct {
+	url    string
+	model  string
+	client *http.Client
+}
+
+// defaultEmbedTimeout bounds the embedding requests of HTTPEmbedders without a timeout.
+const defaultEmbedTimeout = 2 * time.Second
+
+// NewHTTPEmbedder creates an Embedder for the endpoint at url. model, if not empty, is
+// sent with every request for endpoints serving several models; requests taking longer
+// than timeout (default 2s) fail.
+func NewHTTPEmbedder(url, model string, timeout time.Duration) *HTTPEmbedder {
+	if timeout <= 0 {
+		timeout = defaultEmbedTimeout
+	}
+	return &HTTPEmbedder{url: url, model: model, client: &http.Client{Timeout: timeout}}
+}
+
+// embedRequest is the body sent to embedding endpoints.
+type embedRequest struct {
+	Input string `json:"input"`
+	Model string `json:"model,omitempty"`
+}
+
+// embedResponse is the body returned by embedding endpoints, in either format.
+type embedResponse struct {
+	Embedding []float32 `json:"embedding"`
+	Data      []struct {
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed returns the embedding of text computed by the endpoint.
+func (e *HTTPEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	body, err := json.Marshal(embedRequest{Input: text, Model: e.model})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode embedding request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedding request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("embedding endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var out embedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+	v := out.Embedding
+	if len(v) == 0 && len(out.Data) > 0 {
+		v = out.Data[0].Embedding
+	}
+	if err := (vector.Field{}).Check(v); err != nil {
+		return nil, fmt.Errorf("embedding endpoint returned an invalid embedding: %w", err)
+	}
+	return v, nil
+}
+
+// HashEmbedder is a local Embedder for development and tests, needing no model: it hashes
+// the words of a text into Dims buckets (the hashing trick) and normalizes the result to
+// unit length. Texts sharing words get similar vectors, but synonyms don't.
+type HashEmbedder struct {
+	Dims int
+}
+
+// Embed returns the hashed bag of words of text.
+func (e HashEmbedder) Embed(_ context.Context, text string) ([]float32, error) {
+	if e.Dims <= 0 {
+		return nil, fmt.Errorf("hash embedder dimensions must be positive, got %d", e.Dims)
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return nil, fmt.Errorf("text %q has no words to embed", text)
+	}
+	v := make([]float32, e.Dims)
+	for _, w := range words {
+		h := fnv.New64a()
+		h.Write([]byte(w))
+		sum := h.Sum64()
+		sign := float32(1)
+		if sum>>63 == 1 { // The top bit signs the bucket, so collisions tend to cancel out
+			sign = -1
+		}
+		v[sum%uint64(e.Dims)] += sign
+	}
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		v[0], norm = 1, 1 // Every word cancelled out; any unit vector will do
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] = float32(float64(v[i]) / norm)
+	}
+	return v, nil
+}
+
+// EmbedQueryStage implements the QueryStage interface to attach the embedding of the
+// query to its annotations, for the Broker to search vector fields with it. The query is
+// returned unchanged. The Embedder is found in the config under the "embedder" key;
+// without one, the query is left unembedded. The context of the query, under the
+// "context" key, cancels the embedding with the query.
+//
+// Embedding failures are logged rather than returned, so that keyword searches don't
+// depend on the embedding model: searches needing the embedding fail in the Broker instead.
+type EmbedQueryStage struct{}
+
+// Process returns the query unchanged; embedding requires annotations.
+func (s *EmbedQueryStage) Process(query string, config map[string]interface{}) (string, error) {
+	return query, nil
+}
+
+// ProcessAnnotated embeds the query as rewritten by the earlier stages and stores the
+// vector under AnnotationEmbedding.
+func (s *EmbedQueryStage) ProcessAnnotated(query string, config map[string]interface{}, annotations Annotations) (string, error) {
+	embedder, _ := config["embedder"].(Embedder)
+	if embedder == nil || strings.TrimSpace(query) == "" {
+		return query, nil
+	}
+	ctx, _ := config["context"].(context.Context)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	v, err := embedder.Embed(ctx, query)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to embed query", "query", query, "error", err)
+		return query, nil
+	}
+	annotations[AnnotationEmbedding] = v
+	return query, nil
+}
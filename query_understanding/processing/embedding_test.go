@@ -0,0 +1,111 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"common/vector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashEmbedder(t *testing.T) {
+	e := HashEmbedder{Dims: 64}
+	shoes, err := e.Embed(context.Background(), "Red running shoes")
+	require.NoError(t, err)
+	require.Len(t, shoes, 64)
+	again, err := e.Embed(context.Background(), "running shoes, red")
+	require.NoError(t, err)
+	assert.Equal(t, shoes, again, "Expected the embedding to ignore case, punctuation and word order")
+	assert.InDelta(t, 1, vector.Score(vector.DotProduct, shoes, shoes)*2-1, 1e-6, "Expected a unit vector")
+
+	boots, err := e.Embed(context.Background(), "red running boots")
+	require.NoError(t, err)
+	pizza, err := e.Embed(context.Background(), "margherita pizza delivery")
+	require.NoError(t, err)
+	assert.Greater(t, vector.Score(vector.Cosine, shoes, boots), vector.Score(vector.Cosine, shoes, pizza))
+
+	_, err = e.Embed(context.Background(), "  ?! ")
+	assert.Error(t, err)
+	_, err = HashEmbedder{}.Embed(context.Background(), "shoes")
+	assert.Error(t, err)
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	var received embedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		switch received.Input {
+		case "openai":
+			w.Write([]byte(`{"data": [{"embedding": [0.5, 0.5]}]}`))
+		case "zero":
+			w.Write([]byte(`{"embedding": [0, 0]}`))
+		case "down":
+			http.Error(w, "model loading", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"embedding": [1, 0, 0]}`))
+		}
+	}))
+	defer server.Close()
+
+	e := NewHTTPEmbedder(server.URL, "minilm", 0)
+	v, err := e.Embed(context.Background(), "red shoes")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1, 0, 0}, v)
+	assert.Equal(t, embedRequest{Input: "red shoes", Model: "minilm"}, received)
+
+	v, err = e.Embed(context.Background(), "openai")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0.5, 0.5}, v)
+
+	_, err = e.Embed(context.Background(), "zero")
+	assert.True(t, errors.Is(err, vector.ErrInvalidVector), "Expected ErrInvalidVector, got %v", err)
+	_, err = e.Embed(context.Background(), "down")
+	assert.ErrorContains(t, err, "status 503")
+}
+
+func TestHTTPEmbedder_Canceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // A model slower than the query
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewHTTPEmbedder(server.URL, "", time.Minute).Embed(ctx, "red shoes")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "Expected the embedding to stop with the query")
+}
+
+type failingEmbedder struct{}
+
+func (failingEmbedder) Embed(context.Context, string) ([]float32, error) {
+	return nil, errors.New("embedding endpoint down")
+}
+
+func TestEmbedQueryStage(t *testing.T) {
+	stage := &EmbedQueryStage{}
+
+	annotations := Annotations{}
+	out, err := stage.ProcessAnnotated("red shoes", map[string]interface{}{"embedder": HashEmbedder{Dims: 8}}, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "red shoes", out)
+	assert.Len(t, annotations[AnnotationEmbedding], 8)
+
+	for _, cfg := range []map[string]interface{}{{}, {"embedder": failingEmbedder{}}} {
+		annotations := Annotations{}
+		out, err := stage.ProcessAnnotated("red shoes", cfg, annotations)
+		require.NoError(t, err, "Expected embedding failures not to fail the pipeline")
+		assert.Equal(t, "red shoes", out)
+		assert.NotContains(t, annotations, AnnotationEmbedding)
+	}
+}
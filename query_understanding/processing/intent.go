@@ -0,0 +1,164 @@
+package processing
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+
+	"query_understanding/config"
+)
+
+const (
+	// AnnotationIntent is the annotation key holding the classified query intent.
+	AnnotationIntent = "intent"
+	// AnnotationIntentConfidence is the annotation key holding the classification confidence (0..1).
+	AnnotationIntentConfidence = "intent_confidence"
+
+	// Built-in intents.
+	IntentNavigational  = "navigational"  // The user looks for a specific site or page
+	IntentInformational = "informational" // The user looks for information about a topic
+	IntentTransactional = "transactional" // The user wants to buy or perform an action
+
+	defaultIntentMinConfidence = 0.5
+)
+
+// DefaultIntentRules are the built-in keyword rules for the navigational, informational
+// and transactional intents. Rules from the configuration are evaluated in addition to them.
+var DefaultIntentRules = []config.IntentRule{
+	{
+		Intent:   IntentNavigational,
+		Keywords: []string{"www", "com", "org", "net", "login", "log in", "sign in", "homepage", "official site", "website"},
+	},
+	{
+		Intent:   IntentInformational,
+		Keywords: []string{"how", "what", "why", "who", "when", "where", "guide", "tutorial", "definition", "meaning", "history", "vs"},
+	},
+	{
+		Intent:   IntentTransactional,
+		Keywords: []string{"buy", "price", "prices", "cheap", "order", "deal", "deals", "discount", "coupon", "sale", "shipping", "subscribe", "download"},
+	},
+}
+
+// IntentModel is implemented by pluggable intent classifiers (e.g. a trained model
+// served out of process). Classify returns the predicted intent and a confidence
+// between 0 and 1; an empty intent means the model has no prediction.
+type IntentModel interface {
+	Classify(query string) (intent string, confidence float64, err error)
+}
+
+// IntentClassificationStage implements the QueryStage interface to label the query
+// with an intent. The query is returned unchanged; the intent is recorded in the
+// annotations so the Broker can choose pipelines or boosts based on it.
+//
+// When a Model is set, its prediction is used if it is confident enough; otherwise the
+// keyword/regex rules decide. Each matching keyword or pattern adds the rule's weight to
+// its intent's score and the highest score wins.
+//
+// Supported config keys:
+//   - "rules" ([]config.IntentRule): additional rules, evaluated with DefaultIntentRules.
+//   - "default_intent" (string): intent used when no rule matches (default "informational").
+//   - "min_confidence" (float64): minimum model confidence to accept its prediction (default 0.5).
+type IntentClassificationStage struct {
+	Model IntentModel // Optional model consulted before the rules
+}
+
+// Process returns the query unchanged; intent classification requires annotations.
+func (s *IntentClassificationStage) Process(query string, config map[string]interface{}) (string, error) {
+	return query, nil
+}
+
+// ProcessAnnotated classifies the query and stores the intent under AnnotationIntent.
+// An intent already present in the annotations (e.g. set explicitly by the client) is kept.
+func (s *IntentClassificationStage) ProcessAnnotated(query string, cfg map[string]interface{}, annotations Annotations) (string, error) {
+	if annotations.String(AnnotationIntent) != "" {
+		return query, nil
+	}
+
+	minConfidence := defaultIntentMinConfidence
+	if v, ok := cfg["min_confidence"].(float64); ok {
+		minConfidence = v
+	}
+	if s.Model != nil {
+		intent, confidence, err := s.Model.Classify(query)
+		if err != nil {
+			slog.Warn("Intent model failed, falling back to rules", "query", query, "error", err)
+		} else if intent != "" && confidence >= minConfidence {
+			annotations[AnnotationIntent] = intent
+			annotations[AnnotationIntentConfidence] = confidence
+			return query, nil
+		}
+	}
+
+	rules := DefaultIntentRules
+	if extra, ok := cfg["rules"].([]config.IntentRule); ok && len(extra) > 0 {
+		rules = append(append([]config.IntentRule{}, extra...), DefaultIntentRules...)
+	}
+	intent, confidence, err := ClassifyIntent(query, rules)
+	if err != nil {
+		return "", err
+	}
+	if intent == "" {
+		intent = IntentInformational
+		if v, ok := cfg["default_intent"].(string); ok && v != "" {
+			intent = v
+		}
+	}
+	annotations[AnnotationIntent] = intent
+	annotations[AnnotationIntentConfidence] = confidence
+	return query, nil
+}
+
+// ClassifyIntent scores the query against the rules and returns the best intent with
+// its share of the total score as confidence. Ties go to the intent whose rule comes
+// first. An empty intent is returned if no rule matches.
+func ClassifyIntent(query string, rules []config.IntentRule) (string, float64, error) {
+	// Pad with spaces so keywords and phrases only match whole words.
+	normalized := " " + strings.Join(strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ") + " "
+
+	scores := make(map[string]float64)
+	var order []string
+	total := 0.0
+	for _, rule := range rules {
+		weight := rule.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		score := 0.0
+		for _, kw := range rule.Keywords {
+			if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" && strings.Contains(normalized, " "+kw+" ") {
+				score += weight
+			}
+		}
+		for _, pattern := range rule.Patterns {
+			re, err := compilePattern("(?i)" + pattern)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid pattern for intent '%s': %w", rule.Intent, err)
+			}
+			if re.MatchString(query) {
+				score += weight
+			}
+		}
+		if score == 0 {
+			continue
+		}
+		if _, seen := scores[rule.Intent]; !seen {
+			order = append(order, rule.Intent)
+		}
+		scores[rule.Intent] += score
+		total += score
+	}
+
+	best, bestScore := "", 0.0
+	for _, intent := range order {
+		if scores[intent] > bestScore {
+			best, bestScore = intent, scores[intent]
+		}
+	}
+	if best == "" {
+		return "", 0, nil
+	}
+	return best, bestScore / total, nil
+}
@@ -0,0 +1,107 @@
+package processing
+
+import (
+	"errors"
+	"testing"
+
+	"query_understanding/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyIntent_DefaultRules(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"buy cheap running shoes", IntentTransactional},
+		{"how to tie shoelaces", IntentInformational},
+		{"www.example.com login", IntentNavigational},
+		{"red shoes", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			intent, confidence, err := ClassifyIntent(tt.query, DefaultIntentRules)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, intent)
+			if tt.expected == "" {
+				assert.Zero(t, confidence)
+			} else {
+				assert.Greater(t, confidence, 0.0)
+				assert.LessOrEqual(t, confidence, 1.0)
+			}
+		})
+	}
+}
+
+func TestClassifyIntent_WholeWordsAndPatterns(t *testing.T) {
+	rules := []config.IntentRule{
+		{Intent: "support", Keywords: []string{"track order"}, Patterns: []string{`\border\s*#?\d{6,}\b`}, Weight: 2},
+	}
+
+	intent, _, err := ClassifyIntent("Where is ORDER #1234567", rules)
+	require.NoError(t, err)
+	assert.Equal(t, "support", intent, "patterns are case-insensitive")
+
+	intent, _, err = ClassifyIntent("track orders", rules)
+	require.NoError(t, err)
+	assert.Empty(t, intent, "keywords must match whole words")
+
+	_, _, err = ClassifyIntent("x", []config.IntentRule{{Intent: "bad", Patterns: []string{"("}}})
+	assert.Error(t, err)
+}
+
+func TestIntentClassificationStage_ProcessAnnotated(t *testing.T) {
+	stage := &IntentClassificationStage{}
+	cfg := map[string]interface{}{
+		"rules": []config.IntentRule{{Intent: "support", Keywords: []string{"refund"}, Weight: 3}},
+	}
+
+	annotations := make(Annotations)
+	out, err := stage.ProcessAnnotated("refund price", cfg, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "refund price", out, "query must not be modified")
+	assert.Equal(t, "support", annotations.String(AnnotationIntent), "configured rule outweighs the built-in one")
+	assert.InDelta(t, 0.75, annotations[AnnotationIntentConfidence], 1e-9)
+
+	annotations = make(Annotations)
+	_, err = stage.ProcessAnnotated("red shoes", map[string]interface{}{"default_intent": "product"}, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "product", annotations.String(AnnotationIntent))
+
+	annotations = Annotations{AnnotationIntent: "navigational"}
+	_, err = stage.ProcessAnnotated("buy shoes", nil, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "navigational", annotations.String(AnnotationIntent), "explicit intent must be kept")
+}
+
+// stubIntentModel returns a fixed prediction.
+type stubIntentModel struct {
+	intent     string
+	confidence float64
+	err        error
+}
+
+func (m stubIntentModel) Classify(string) (string, float64, error) {
+	return m.intent, m.confidence, m.err
+}
+
+func TestIntentClassificationStage_Model(t *testing.T) {
+	annotations := make(Annotations)
+	stage := &IntentClassificationStage{Model: stubIntentModel{intent: "recipe", confidence: 0.9}}
+	_, err := stage.ProcessAnnotated("buy shoes", nil, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "recipe", annotations.String(AnnotationIntent))
+
+	for _, model := range []IntentModel{
+		stubIntentModel{intent: "recipe", confidence: 0.2},
+		stubIntentModel{err: errors.New("model unavailable")},
+	} {
+		annotations = make(Annotations)
+		stage = &IntentClassificationStage{Model: model}
+		_, err = stage.ProcessAnnotated("buy shoes", nil, annotations)
+		require.NoError(t, err)
+		assert.Equal(t, IntentTransactional, annotations.String(AnnotationIntent), "rules are used when the model is not confident")
+	}
+}
@@ -6,3 +6,23 @@ package processing
 type QueryStage interface {
 	Process(query string, config map[string]interface{}) (string, error)
 }
+
+// Annotations carries metadata produced by pipeline stages about the query being processed
+// (e.g. the detected language). Later stages can read annotations set by earlier ones.
+type Annotations map[string]interface{}
+
+// String returns the annotation stored under key if it is a string, or "" otherwise.
+func (a Annotations) String(key string) string {
+	if v, ok := a[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// AnnotatingStage is implemented by stages that read or attach query annotations in
+// addition to rewriting the query string. The PipelineExecutor calls ProcessAnnotated
+// instead of Process for stages implementing it.
+type AnnotatingStage interface {
+	QueryStage
+	ProcessAnnotated(query string, config map[string]interface{}, annotations Annotations) (string, error)
+}
@@ -0,0 +1,182 @@
+package processing
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// AnnotationLanguage is the annotation key holding the detected ISO 639-1 language code.
+	AnnotationLanguage = "language"
+	// AnnotationLanguageConfidence is the annotation key holding the detection confidence (0..1).
+	AnnotationLanguageConfidence = "language_confidence"
+
+	defaultLanguage = "en"
+	// Detection on short queries is unreliable: "die hard" looks German, so queries need
+	// both a high confidence and a few words before the detected language is accepted.
+	defaultMinConfidence = 0.9
+	defaultMinTokens     = 3
+)
+
+// languageSamples are short representative texts used to build the built-in trigram
+// profiles. They favour common function words, which dominate short search queries.
+var languageSamples = map[string]string{
+	"en": "the and for with this that from what where how are you have will your which when there their about " +
+		"cheap best shoes near me price buy online free shipping new used sale women men kids home garden " +
+		"the quick brown fox jumps over the lazy dog while the weather is nice and the people are walking in the park",
+	"fr": "le la les des une pour avec dans est que qui sur pas plus sont mais nous vous leur cette comme tout " +
+		"pas cher meilleur chaussures pres de moi prix acheter en ligne livraison gratuite nouveau femme homme enfant maison jardin " +
+		"le renard brun rapide saute par dessus le chien paresseux pendant que les gens se promenent dans le parc",
+	"de": "der die das und ist nicht mit sich auf fur ein eine dem den von zu sind auch wie wird oder aber " +
+		"gunstig beste schuhe in der nahe preis kaufen online kostenloser versand neu gebraucht damen herren kinder haus garten " +
+		"der schnelle braune fuchs springt uber den faulen hund wahrend die leute im park spazieren gehen",
+	"es": "el la los las de que y en un una para con por como mas pero sus este esta entre cuando todo " +
+		"barato mejor zapatos cerca de mi precio comprar en linea envio gratis nuevo usado mujer hombre ninos casa jardin " +
+		"el rapido zorro marron salta sobre el perro perezoso mientras la gente camina por el parque",
+}
+
+// accentHints boosts languages whose alphabets contain characters found in the query.
+var accentHints = map[rune]string{
+	'ß': "de", 'ä': "de", 'ö': "de", 'ü': "de",
+	'ñ': "es", '¿': "es", '¡': "es", 'á': "es", 'í': "es", 'ó': "es", 'ú': "es",
+	'ç': "fr", 'è': "fr", 'ê': "fr", 'à': "fr", 'â': "fr", 'î': "fr", 'ô': "fr", 'û': "fr", 'œ': "fr",
+}
+
+// trigramProfile holds log-probabilities of character trigrams for a language.
+type trigramProfile struct {
+	logProbs map[string]float64
+	unseen   float64 // Log-probability assigned to trigrams absent from the profile
+}
+
+// builtinProfiles are computed once from languageSamples.
+var builtinProfiles = buildProfiles(languageSamples)
+
+// buildProfiles computes add-one smoothed trigram profiles from sample texts.
+func buildProfiles(samples map[string]string) map[string]*trigramProfile {
+	profiles := make(map[string]*trigramProfile, len(samples))
+	for lang, text := range samples {
+		counts := make(map[string]int)
+		total := 0
+		for _, tri := range trigrams(text) {
+			counts[tri]++
+			total++
+		}
+		denom := float64(total + len(counts) + 1)
+		profile := &trigramProfile{
+			logProbs: make(map[string]float64, len(counts)),
+			unseen:   math.Log(1 / denom),
+		}
+		for tri, c := range counts {
+			profile.logProbs[tri] = math.Log(float64(c+1) / denom)
+		}
+		profiles[lang] = profile
+	}
+	return profiles
+}
+
+// trigrams returns the character trigrams of each word in text, padded with spaces
+// so word boundaries are represented.
+func trigrams(text string) []string {
+	var result []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			result = append(result, string(runes[i:i+3]))
+		}
+	}
+	return result
+}
+
+// DetectLanguage returns the most likely language of text among the built-in profiles
+// together with a confidence between 0 and 1. An empty language is returned if the
+// text has no letters.
+func DetectLanguage(text string) (string, float64) {
+	grams := trigrams(text)
+	if len(grams) == 0 {
+		return "", 0
+	}
+
+	scores := make(map[string]float64, len(builtinProfiles))
+	for lang, profile := range builtinProfiles {
+		score := 0.0
+		for _, tri := range grams {
+			if lp, ok := profile.logProbs[tri]; ok {
+				score += lp
+			} else {
+				score += profile.unseen
+			}
+		}
+		scores[lang] = score / float64(len(grams))
+	}
+	for _, r := range strings.ToLower(text) {
+		if lang, ok := accentHints[r]; ok {
+			scores[lang] += 1.0
+		}
+	}
+
+	// Convert average log-likelihoods into a softmax distribution to get a confidence.
+	langs := make([]string, 0, len(scores))
+	for lang := range scores {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs) // Deterministic tie-breaking
+	best, bestScore := "", math.Inf(-1)
+	for _, lang := range langs {
+		if scores[lang] > bestScore {
+			best, bestScore = lang, scores[lang]
+		}
+	}
+	sum := 0.0
+	for _, lang := range langs {
+		sum += math.Exp((scores[lang] - bestScore) * float64(len(grams)))
+	}
+	return best, 1 / sum
+}
+
+// LanguageDetectionStage implements the QueryStage interface to detect the query language.
+// The query is returned unchanged; the detected language is recorded in the annotations
+// so later stages (stopwords, stemming, analyzers) can select language-specific resources.
+//
+// Supported config keys:
+//   - "default_language" (string): language used when detection confidence is too low (default "en").
+//   - "min_confidence" (float64): minimum confidence to accept the detected language (default 0.9).
+//   - "min_tokens" (int): minimum words of a query to accept the detected language (default 3).
+type LanguageDetectionStage struct{}
+
+// Process returns the query unchanged; language detection requires annotations.
+func (s *LanguageDetectionStage) Process(query string, config map[string]interface{}) (string, error) {
+	return query, nil
+}
+
+// ProcessAnnotated detects the query language and stores it under AnnotationLanguage.
+// A language already present in the annotations (e.g. set explicitly by the client) is kept.
+func (s *LanguageDetectionStage) ProcessAnnotated(query string, config map[string]interface{}, annotations Annotations) (string, error) {
+	if annotations.String(AnnotationLanguage) != "" {
+		return query, nil
+	}
+
+	fallback := defaultLanguage
+	if v, ok := config["default_language"].(string); ok && v != "" {
+		fallback = v
+	}
+	minConfidence := defaultMinConfidence
+	if v, ok := config["min_confidence"].(float64); ok {
+		minConfidence = v
+	}
+	minTokens := defaultMinTokens
+	if v, ok := config["min_tokens"].(int); ok {
+		minTokens = v
+	}
+
+	lang, confidence := DetectLanguage(query)
+	if lang == "" || confidence < minConfidence || len(strings.Fields(query)) < minTokens {
+		lang = fallback
+	}
+	annotations[AnnotationLanguage] = lang
+	annotations[AnnotationLanguageConfidence] = confidence
+	return query, nil
+}
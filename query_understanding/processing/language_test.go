@@ -0,0 +1,64 @@
+package processing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"where can i buy the best running shoes", "en"},
+		{"chaussures pas cher pour femme", "fr"},
+		{"günstige schuhe für damen", "de"},
+		{"zapatos baratos para mujer", "es"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			lang, confidence := DetectLanguage(tt.query)
+			assert.Equal(t, tt.expected, lang)
+			assert.Greater(t, confidence, 0.0)
+			assert.LessOrEqual(t, confidence, 1.0)
+		})
+	}
+
+	lang, confidence := DetectLanguage("1234 !!")
+	assert.Empty(t, lang)
+	assert.Zero(t, confidence)
+}
+
+func TestLanguageDetectionStage_ProcessAnnotated(t *testing.T) {
+	stage := &LanguageDetectionStage{}
+
+	annotations := make(Annotations)
+	out, err := stage.ProcessAnnotated("chaussures pas cher pour femme", map[string]interface{}{}, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "chaussures pas cher pour femme", out, "query must not be modified")
+	assert.Equal(t, "fr", annotations.String(AnnotationLanguage))
+
+	// Low-confidence detection falls back to the configured default language.
+	annotations = make(Annotations)
+	_, err = stage.ProcessAnnotated("xyz", map[string]interface{}{"default_language": "de", "min_confidence": 1.1}, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "de", annotations.String(AnnotationLanguage))
+
+	// Queries of too few words get the default language.
+	annotations = make(Annotations)
+	_, err = stage.ProcessAnnotated("die hard", map[string]interface{}{}, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "en", annotations.String(AnnotationLanguage))
+	annotations = make(Annotations)
+	_, err = stage.ProcessAnnotated("schuhe kinder", map[string]interface{}{"min_tokens": 2}, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "de", annotations.String(AnnotationLanguage))
+
+	// An explicitly provided language is kept.
+	annotations = Annotations{AnnotationLanguage: "es"}
+	_, err = stage.ProcessAnnotated("the best shoes", map[string]interface{}{}, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "es", annotations.String(AnnotationLanguage))
+}
@@ -0,0 +1,38 @@
+package processing
+
+import (
+	"fmt"
+
+	"common/textnorm"
+)
+
+// UnicodeNormalizationStage implements the QueryStage interface to normalize the Unicode
+// forms of the query like the indexer's folding analyzers normalize documents, so that
+// "café", "cafe" typed with a combining accent and "ｃａｆｅ" all become "cafe". The
+// query is returned in NFC. It should run after detect_language, which relies on accents.
+//
+// Supported config keys, all true by default:
+//   - "compatibility" (bool): decompose with NFKD rather than NFD, e.g. "ﬁ" becomes "fi".
+//   - "strip_diacritics" (bool): remove the combining marks of letters, e.g. é becomes e.
+//   - "fold_width" (bool): turn full-width characters into ASCII, e.g. "１２" becomes "12",
+//     which the NFKD decomposition also does, and half-width katakana into full-width.
+type UnicodeNormalizationStage struct{}
+
+// Process normalizes the query.
+func (s *UnicodeNormalizationStage) Process(query string, config map[string]interface{}) (string, error) {
+	opts := textnorm.Fold
+	for key, option := range map[string]*bool{
+		"compatibility":    &opts.Compatibility,
+		"strip_diacritics": &opts.StripDiacritics,
+		"fold_width":       &opts.FoldWidth,
+	} {
+		if v, ok := config[key]; ok {
+			enabled, ok := v.(bool)
+			if !ok {
+				return "", fmt.Errorf("%s config must be a boolean", key)
+			}
+			*option = enabled
+		}
+	}
+	return textnorm.Normalize(query, opts), nil
+}
@@ -0,0 +1,24 @@
+package processing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnicodeNormalizationStage(t *testing.T) {
+	stage := &UnicodeNormalizationStage{}
+
+	out, err := stage.Process("Crème brûlée ｃａｆé ﬁne", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Creme brulee cafe fine", out)
+
+	// With every normalization disabled, the query is only composed.
+	out, err = stage.Process("café ｃａｆé", map[string]interface{}{"compatibility": false, "strip_diacritics": false, "fold_width": false})
+	require.NoError(t, err)
+	assert.Equal(t, "café ｃａｆé", out)
+
+	_, err = stage.Process("café", map[string]interface{}{"compatibility": "yes"})
+	assert.Error(t, err)
+}
@@ -1,12 +1,24 @@
 package processing
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"sync"
+	"time"
 
 	"query_understanding/config"
 )
 
-// PipelineExecutor is responsible for executing a sequence of query processing stages.
+// ErrUnknownStage is returned for pipelines naming a stage missing from the registry.
+var ErrUnknownStage = errors.New("unknown query stage")
+
+// ErrStageConflict is returned when stages running concurrently rewrite the query or set
+// an annotation differently, which can't be merged; one of them must depend on the other.
+var ErrStageConflict = errors.New("concurrent query stages conflict")
+
+// PipelineExecutor is responsible for executing a sequence, or a DAG, of query processing
+// stages.
 type PipelineExecutor struct {
 	registry *StageRegistry
 }
@@ -18,32 +30,245 @@ func NewPipelineExecutor(registry *StageRegistry) *PipelineExecutor {
 	}
 }
 
+// PipelineResult is the outcome of running a query through a pipeline.
+type PipelineResult struct {
+	Query       string
+	Annotations Annotations
+	// Stages traces every stage that ran, in order; the stages of a wave in the order of
+	// the pipeline's steps. It is only set by ExecuteTraced.
+	Stages []StageTrace
+}
+
+// StageTrace records what a stage did to the query.
+type StageTrace struct {
+	Stage  string
+	Input  string
+	Output string // Empty if the stage failed
+	// Annotations holds the annotations the stage set or changed.
+	Annotations Annotations
+	Duration    time.Duration
+	Err         error
+}
+
 // ExecutePipeline processes a raw query string through a specified query planning pipeline.
 // It retrieves the pipeline definition from the provided IndexConfiguration and applies
-// each stage in sequence.
+// each stage in sequence, or in the waves of its DAG, see QueryPlanningPipeline.Waves.
 func (pe *PipelineExecutor) ExecutePipeline(pipeline *config.QueryPlanningPipeline, rawQuery string, stageConfigs map[string]map[string]interface{}) (string, error) {
+	result, err := pe.Execute(pipeline, rawQuery, stageConfigs)
+	if err != nil {
+		return "", err
+	}
+	return result.Query, nil
+}
+
+// Execute processes a raw query like ExecutePipeline and additionally returns the
+// annotations collected from stages implementing AnnotatingStage.
+func (pe *PipelineExecutor) Execute(pipeline *config.QueryPlanningPipeline, rawQuery string, stageConfigs map[string]map[string]interface{}) (*PipelineResult, error) {
+	return pe.execute(pipeline, rawQuery, stageConfigs, nil, false)
+}
+
+// ExecuteWithAnnotations is Execute with the stages starting from annotations, e.g. the
+// language the client set, which they may read and change.
+func (pe *PipelineExecutor) ExecuteWithAnnotations(pipeline *config.QueryPlanningPipeline, rawQuery string, stageConfigs map[string]map[string]interface{}, annotations Annotations) (*PipelineResult, error) {
+	return pe.execute(pipeline, rawQuery, stageConfigs, annotations, false)
+}
+
+// ExecuteTraced processes a raw query like Execute and traces the input, output,
+// annotations and duration of every stage. When a stage fails, the result holds the
+// stages that ran, the failed one or its wave last, together with the error.
+func (pe *PipelineExecutor) ExecuteTraced(pipeline *config.QueryPlanningPipeline, rawQuery string, stageConfigs map[string]map[string]interface{}) (*PipelineResult, error) {
+	return pe.execute(pipeline, rawQuery, stageConfigs, nil, true)
+}
+
+// ExecuteTracedWithAnnotations is ExecuteTraced with the stages starting from annotations.
+func (pe *PipelineExecutor) ExecuteTracedWithAnnotations(pipeline *config.QueryPlanningPipeline, rawQuery string, stageConfigs map[string]map[string]interface{}, annotations Annotations) (*PipelineResult, error) {
+	return pe.execute(pipeline, rawQuery, stageConfigs, annotations, true)
+}
+
+// execute runs the stages of pipeline from a copy of annotations, tracing them if trace
+// is set. The stages of a wave of the pipeline run concurrently, see runWave.
+func (pe *PipelineExecutor) execute(pipeline *config.QueryPlanningPipeline, rawQuery string, stageConfigs map[string]map[string]interface{}, annotations Annotations, trace bool) (*PipelineResult, error) {
 	if pipeline == nil {
-		return "", fmt.Errorf("query planning pipeline cannot be nil")
+		return nil, fmt.Errorf("query planning pipeline cannot be nil")
+	}
+	waves, err := pipeline.Waves()
+	if err != nil {
+		return nil, fmt.Errorf("query planning pipeline '%s' has invalid dependencies: %w", pipeline.Name, err)
 	}
 
-	currentQuery := rawQuery
-	for _, stageName := range pipeline.Steps {
-		stage, found := pe.registry.Get(stageName)
-		if !found {
-			return "", fmt.Errorf("query stage '%s' not found in registry for pipeline '%s'", stageName, pipeline.Name)
+	result := &PipelineResult{Query: rawQuery, Annotations: make(Annotations, len(annotations))}
+	for key, value := range annotations {
+		result.Annotations[key] = value
+	}
+	for _, wave := range waves {
+		stages := make([]QueryStage, len(wave))
+		for i, stageName := range wave {
+			stage, found := pe.registry.Get(stageName)
+			if !found {
+				return nil, fmt.Errorf("%w: '%s' not found in registry for pipeline '%s'", ErrUnknownStage, stageName, pipeline.Name)
+			}
+			stages[i] = stage
 		}
 
-		configForStage := stageConfigs[stageName]
-		if configForStage == nil {
-			configForStage = make(map[string]interface{}) // Ensure it's not nil
+		var runs []stageRun
+		if len(wave) == 1 {
+			runs = []stageRun{runStage(wave[0], stages[0], result.Query, stageConfigs[wave[0]], result.Annotations, trace)}
+		} else {
+			runs, err = runWave(wave, stages, result, stageConfigs)
+		}
+		for _, run := range runs {
+			if trace {
+				result.Stages = append(result.Stages, run.trace)
+			}
+			if run.err != nil && err == nil {
+				err = fmt.Errorf("failed to execute stage '%s' in pipeline '%s': %w", run.trace.Stage, pipeline.Name, run.err)
+			}
 		}
-
-		processedQuery, err := stage.Process(currentQuery, configForStage)
 		if err != nil {
-			return "", fmt.Errorf("failed to execute stage '%s' in pipeline '%s': %w", stageName, pipeline.Name, err)
+			if errors.Is(err, ErrStageConflict) {
+				err = fmt.Errorf("%w in pipeline '%s'", err, pipeline.Name)
+			}
+			if trace {
+				return result, err
+			}
+			return nil, err
+		}
+		if len(wave) == 1 {
+			result.Query = runs[0].query
+		}
+	}
+
+	return result, nil
+}
+
+// stageRun is the outcome of running a stage. Its trace is always set, its Annotations
+// and Duration, like the annotations deleted, only when tracing or running concurrently.
+type stageRun struct {
+	query   string
+	trace   StageTrace
+	deleted []string // Annotations the stage deleted
+	err     error
+}
+
+// runStage runs stage on query, letting it change annotations, and traces it if trace is
+// set.
+func runStage(stageName string, stage QueryStage, query string, configForStage map[string]interface{}, annotations Annotations, trace bool) stageRun {
+	if configForStage == nil {
+		configForStage = make(map[string]interface{}) // Ensure it's not nil
+	}
+
+	var (
+		run    = stageRun{trace: StageTrace{Stage: stageName, Input: query}}
+		before Annotations
+		start  time.Time
+	)
+	if trace {
+		before = make(Annotations, len(annotations))
+		for key, value := range annotations {
+			before[key] = value
+		}
+		start = time.Now()
+	}
+	if annotating, ok := stage.(AnnotatingStage); ok {
+		run.query, run.err = annotating.ProcessAnnotated(query, configForStage, annotations)
+	} else {
+		run.query, run.err = stage.Process(query, configForStage)
+	}
+	run.trace.Output, run.trace.Err = run.query, run.err
+	if trace {
+		run.trace.Annotations = changedAnnotations(before, annotations)
+		run.deleted = deletedAnnotations(before, annotations)
+		run.trace.Duration = time.Since(start)
+	}
+	return run
+}
+
+// runWave runs stages concurrently, each on the query of result and a copy of its
+// annotations, then merges their outcomes into result: the annotations each stage set,
+// changed or deleted, and the query rewritten by at most one of them. It fails with
+// ErrStageConflict when stages rewrite the query or set an annotation differently, or one
+// deletes an annotation another sets, leaving result as it was. The runs are returned in the order of the stages.
+func runWave(wave []string, stages []QueryStage, result *PipelineResult, stageConfigs map[string]map[string]interface{}) ([]stageRun, error) {
+	runs := make([]stageRun, len(wave))
+	var wg sync.WaitGroup
+	for i := range wave {
+		annotations := make(Annotations, len(result.Annotations))
+		for key, value := range result.Annotations {
+			annotations[key] = value
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runs[i] = runStage(wave[i], stages[i], result.Query, stageConfigs[wave[i]], annotations, true)
+		}(i)
+	}
+	wg.Wait()
+	for _, run := range runs {
+		if run.err != nil {
+			return runs, nil
 		}
-		currentQuery = processedQuery
 	}
 
-	return currentQuery, nil
+	query, rewrittenBy := result.Query, ""
+	merged := make(Annotations)
+	deleted := make(map[string]bool)
+	setBy := make(map[string]string)
+	for _, run := range runs {
+		if run.query != result.Query {
+			if rewrittenBy != "" && run.query != query {
+				return runs, fmt.Errorf("%w: stages '%s' and '%s' both rewrote the query", ErrStageConflict, rewrittenBy, run.trace.Stage)
+			}
+			query, rewrittenBy = run.query, run.trace.Stage
+		}
+		for key, value := range run.trace.Annotations {
+			other, ok := setBy[key]
+			if ok && deleted[key] {
+				return runs, fmt.Errorf("%w: stage '%s' deleted annotation '%s' that stage '%s' set", ErrStageConflict, other, key, run.trace.Stage)
+			}
+			if ok && !reflect.DeepEqual(merged[key], value) {
+				return runs, fmt.Errorf("%w: stages '%s' and '%s' both set annotation '%s'", ErrStageConflict, other, run.trace.Stage, key)
+			}
+			merged[key], setBy[key] = value, run.trace.Stage
+		}
+		for _, key := range run.deleted {
+			if other, ok := setBy[key]; ok && !deleted[key] {
+				return runs, fmt.Errorf("%w: stage '%s' deleted annotation '%s' that stage '%s' set", ErrStageConflict, run.trace.Stage, key, other)
+			}
+			deleted[key], setBy[key] = true, run.trace.Stage
+		}
+	}
+	result.Query = query
+	for key, value := range merged {
+		result.Annotations[key] = value
+	}
+	for key := range deleted {
+		delete(result.Annotations, key)
+	}
+	return runs, nil
+}
+
+// changedAnnotations returns the annotations of after that aren't in before or differ.
+func changedAnnotations(before, after Annotations) Annotations {
+	var changed Annotations
+	for key, value := range after {
+		if old, ok := before[key]; ok && reflect.DeepEqual(old, value) {
+			continue
+		}
+		if changed == nil {
+			changed = make(Annotations)
+		}
+		changed[key] = value
+	}
+	return changed
+}
+
+// deletedAnnotations returns the keys of the annotations of before missing from after.
+func deletedAnnotations(before, after Annotations) []string {
+	var deleted []string
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	return deleted
 }
@@ -0,0 +1,129 @@
+package processing
+
+import (
+	"testing"
+	"time"
+
+	"query_understanding/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// funcStage is an annotating stage running a function.
+type funcStage func(query string, annotations Annotations) (string, error)
+
+func (f funcStage) Process(query string, cfg map[string]interface{}) (string, error) {
+	return f(query, Annotations{})
+}
+
+func (f funcStage) ProcessAnnotated(query string, cfg map[string]interface{}, annotations Annotations) (string, error) {
+	return f(query, annotations)
+}
+
+// annotate returns a stage setting key to value, after the started channels are closed
+// when there are any.
+func annotate(key string, value interface{}, started chan struct{}, others ...chan struct{}) funcStage {
+	return func(query string, annotations Annotations) (string, error) {
+		if started != nil {
+			close(started)
+		}
+		for _, other := range others {
+			select {
+			case <-other:
+			case <-time.After(5 * time.Second):
+				return "", assert.AnError
+			}
+		}
+		annotations[key] = value
+		return query, nil
+	}
+}
+
+func TestPipelineExecutor_ConcurrentStages(t *testing.T) {
+	language, entities := make(chan struct{}), make(chan struct{})
+	registry := NewStageRegistry()
+	require.NoError(t, registry.Register("lowercase", &LowerCaseStage{}))
+	// Each stage waits for the other to start, which only happens when they run concurrently.
+	require.NoError(t, registry.Register("language", annotate("language", "en", language, entities)))
+	require.NoError(t, registry.Register("entities", annotate("entities", []string{"nike"}, entities, language)))
+	require.NoError(t, registry.Register("rewrite", funcStage(func(query string, annotations Annotations) (string, error) {
+		return query + " " + annotations.String("language"), nil
+	})))
+	pipeline := &config.QueryPlanningPipeline{
+		Name:  "dag",
+		Steps: []string{"lowercase", "language", "entities", "rewrite"},
+		DependsOn: map[string][]string{
+			"language": {"lowercase"},
+			"entities": {"lowercase"},
+			"rewrite":  {"language", "entities"},
+		},
+	}
+
+	result, err := NewPipelineExecutor(registry).ExecuteTraced(pipeline, "Nike Shoes", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "nike shoes en", result.Query)
+	assert.Equal(t, Annotations{"language": "en", "entities": []string{"nike"}}, result.Annotations)
+	require.Len(t, result.Stages, 4)
+	for i, stage := range []string{"lowercase", "language", "entities", "rewrite"} {
+		assert.Equal(t, stage, result.Stages[i].Stage)
+	}
+	assert.Equal(t, "nike shoes", result.Stages[2].Input)
+	assert.Equal(t, Annotations{"entities": []string{"nike"}}, result.Stages[2].Annotations)
+}
+
+func TestPipelineExecutor_ConcurrentStageConflicts(t *testing.T) {
+	registry := NewStageRegistry()
+	require.NoError(t, registry.Register("lowercase", &LowerCaseStage{}))
+	require.NoError(t, registry.Register("tokenize", &TokenizeStage{}))
+	require.NoError(t, registry.Register("english", annotate("language", "en", nil)))
+	require.NoError(t, registry.Register("french", annotate("language", "fr", nil)))
+	require.NoError(t, registry.Register("also_english", annotate("language", "en", nil)))
+	executor := NewPipelineExecutor(registry)
+	independent := func(steps ...string) *config.QueryPlanningPipeline {
+		// Empty dependency lists make a DAG of independent steps.
+		dependsOn := make(map[string][]string, len(steps))
+		for _, step := range steps {
+			dependsOn[step] = nil
+		}
+		return &config.QueryPlanningPipeline{Name: "dag", Steps: steps, DependsOn: dependsOn}
+	}
+
+	_, err := executor.Execute(independent("english", "french"), "shoes", nil)
+	require.ErrorIs(t, err, ErrStageConflict)
+	assert.Contains(t, err.Error(), "stages 'english' and 'french' both set annotation 'language'")
+
+	_, err = executor.Execute(independent("lowercase", "tokenize"), "Running  Shoes", nil)
+	require.ErrorIs(t, err, ErrStageConflict)
+	assert.Contains(t, err.Error(), "stages 'lowercase' and 'tokenize' both rewrote the query")
+
+	// Stages agreeing, or only one of them rewriting the query, merge.
+	result, err := executor.Execute(independent("english", "also_english", "lowercase"), "Shoes", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "shoes", result.Query)
+	assert.Equal(t, "en", result.Annotations.String("language"))
+
+	// Annotations deleted by one of the stages are deleted from the result.
+	require.NoError(t, registry.Register("forget_language", funcStage(func(query string, annotations Annotations) (string, error) {
+		delete(annotations, "language")
+		return query, nil
+	})))
+	result, err = executor.Execute(&config.QueryPlanningPipeline{
+		Name:      "dag",
+		Steps:     []string{"english", "forget_language", "lowercase"},
+		DependsOn: map[string][]string{"forget_language": {"english"}, "lowercase": {"english"}},
+	}, "Shoes", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "shoes", result.Query)
+	assert.NotContains(t, result.Annotations, "language")
+	_, err = executor.Execute(&config.QueryPlanningPipeline{
+		Name:      "dag",
+		Steps:     []string{"english", "forget_language", "french"},
+		DependsOn: map[string][]string{"forget_language": {"english"}, "french": {"english"}},
+	}, "shoes", nil)
+	require.ErrorIs(t, err, ErrStageConflict)
+	assert.Contains(t, err.Error(), "stage 'forget_language' deleted annotation 'language' that stage 'french' set")
+
+	_, err = executor.Execute(&config.QueryPlanningPipeline{Name: "cyclic", Steps: []string{"english", "french"}, DependsOn: map[string][]string{"english": {"french"}, "french": {"english"}}}, "shoes", nil)
+	assert.ErrorContains(t, err, "dependency cycle")
+}
@@ -0,0 +1,231 @@
+package processing
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnnotationRanges is the annotation key holding the []RangeFilter parsed from the query.
+const AnnotationRanges = "ranges"
+
+// Types of RangeFilter, named like the filters of the Broker and the Searchers.
+const (
+	RangeNumeric = "range"      // Numeric range [Min, Max]
+	RangeDate    = "date_range" // RFC 3339 date range [Start, End]
+)
+
+// RangeFilter is a numeric or date range restricting the results of a query, parsed from
+// an expression such as "under $50" or "last 7 days". Bounds left unset are open.
+type RangeFilter struct {
+	Type         string   `json:"type"`
+	Field        string   `json:"field"`
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	ExclusiveMin bool     `json:"exclusive_min,omitempty"`
+	ExclusiveMax bool     `json:"exclusive_max,omitempty"`
+	Start        string   `json:"start,omitempty"`
+	End          string   `json:"end,omitempty"`
+}
+
+// Range expressions. A price is a number with a currency, a "$" prefix or a dollars, usd
+// or bucks suffix, so that "under 15 inches" isn't read as a price; a price range needs
+// it on one of its bounds. Years run from 1900 to 2099, so that "between 2019 and 2021"
+// is read as years rather than prices.
+const (
+	pricePattern = `(\$\s*)?(\d+(?:\.\d+)?)(\s*(?:dollars?|usd|bucks)\b)?`
+	yearPattern  = `((?:19|20)\d{2})`
+)
+
+var (
+	yearBetweenPattern  = regexp.MustCompile(`(?i)\b(?:between|from)\s+` + yearPattern + `\s+(?:and|to|-)\s+` + yearPattern + `\b`)
+	yearSpanPattern     = regexp.MustCompile(`\b` + yearPattern + `\s*-\s*` + yearPattern + `\b`)
+	yearBoundPattern    = regexp.MustCompile(`(?i)\b(since|after|before)\s+` + yearPattern + `\b`)
+	relativeDatePattern = regexp.MustCompile(`(?i)\b(?:in\s+the\s+)?(?:last|past)\s+(?:(\d+)\s+)?(day|week|month|year)s?\b`)
+	priceBetweenPattern = regexp.MustCompile(`(?i)\b(?:between|from)\s+` + pricePattern + `\s*(?:and|to|-)\s*` + pricePattern)
+	priceSpanPattern    = regexp.MustCompile(`(?i)\$\s*(\d+(?:\.\d+)?)\s*(?:-|to)\s*\$?\s*(\d+(?:\.\d+)?)`)
+	priceBoundPattern   = regexp.MustCompile(`(?i)\b(under|below|less\s+than|cheaper\s+than|up\s+to|at\s+most|over|above|more\s+than|at\s+least)\s+` + pricePattern)
+)
+
+// RangeParsingStage implements the QueryStage interface to turn range expressions into
+// structured filters, removing them from the query:
+//   - prices: "under $50", "over 100 dollars", "between $20 and $50", "$20-$50";
+//   - years: "between 2019 and 2021", "2019-2021", "since 2019", "after 2019", "before 2020";
+//   - relative dates: "last 7 days", "past month", "in the last 2 weeks".
+//
+// The filters are stored under AnnotationRanges. Expressions of a kind without a
+// configured field are left in the query.
+//
+// Supported config keys:
+//   - "price_field" (string): numeric field of prices.
+//   - "year_field" (string): numeric field of years.
+//   - "date_field" (string): date field of relative dates, and of years without a year field.
+type RangeParsingStage struct {
+	Now func() time.Time // Clock of relative dates; nil uses time.Now
+}
+
+// Process removes the range expressions from the query, dropping their filters.
+func (s *RangeParsingStage) Process(query string, config map[string]interface{}) (string, error) {
+	return s.ProcessAnnotated(query, config, Annotations{})
+}
+
+// ProcessAnnotated removes the range expressions from the query and appends their
+// filters to AnnotationRanges.
+func (s *RangeParsingStage) ProcessAnnotated(query string, config map[string]interface{}, annotations Annotations) (string, error) {
+	priceField, _ := config["price_field"].(string)
+	yearField, _ := config["year_field"].(string)
+	dateField, _ := config["date_field"].(string)
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+
+	var filters []RangeFilter
+	// extract removes the matches of pattern the parse function turns into a filter.
+	extract := func(pattern *regexp.Regexp, parse func(groups []string) (RangeFilter, bool)) {
+		query = pattern.ReplaceAllStringFunc(query, func(match string) string {
+			f, ok := parse(pattern.FindStringSubmatch(match))
+			if !ok {
+				return match
+			}
+			filters = append(filters, f)
+			return " "
+		})
+	}
+
+	// Years come before prices, which would read them as amounts.
+	if yearField != "" || dateField != "" {
+		years := func(from, to int) RangeFilter {
+			if yearField != "" {
+				return numericRange(yearField, float64(from), float64(to))
+			}
+			return dateRange(dateField, yearStart(from), yearEnd(to))
+		}
+		extract(yearBetweenPattern, func(g []string) (RangeFilter, bool) {
+			from, to := atoi(g[1]), atoi(g[2])
+			return years(from, to), from <= to
+		})
+		extract(yearSpanPattern, func(g []string) (RangeFilter, bool) {
+			from, to := atoi(g[1]), atoi(g[2])
+			return years(from, to), from < to
+		})
+		extract(yearBoundPattern, func(g []string) (RangeFilter, bool) {
+			year := atoi(g[2])
+			f := RangeFilter{Type: RangeNumeric, Field: yearField}
+			switch strings.ToLower(g[1]) {
+			case "since":
+				f.Min = floatPtr(float64(year))
+			case "after":
+				f.Min, f.ExclusiveMin = floatPtr(float64(year)), true
+			case "before":
+				f.Max, f.ExclusiveMax = floatPtr(float64(year)), true
+			}
+			if yearField != "" {
+				return f, true
+			}
+			f = RangeFilter{Type: RangeDate, Field: dateField}
+			switch strings.ToLower(g[1]) {
+			case "since":
+				f.Start = yearStart(year)
+			case "after":
+				f.Start = yearStart(year + 1)
+			case "before":
+				f.End = yearEnd(year - 1)
+			}
+			return f, true
+		})
+	}
+	if dateField != "" {
+		extract(relativeDatePattern, func(g []string) (RangeFilter, bool) {
+			n := 1
+			if g[1] != "" {
+				n = atoi(g[1])
+			}
+			t := now().UTC()
+			switch strings.ToLower(g[2]) {
+			case "day":
+				t = t.AddDate(0, 0, -n)
+			case "week":
+				t = t.AddDate(0, 0, -7*n)
+			case "month":
+				t = t.AddDate(0, -n, 0)
+			case "year":
+				t = t.AddDate(-n, 0, 0)
+			}
+			return RangeFilter{Type: RangeDate, Field: dateField, Start: t.Format(time.RFC3339)}, n > 0
+		})
+	}
+	if priceField != "" {
+		extract(priceBetweenPattern, func(g []string) (RangeFilter, bool) {
+			min, max := atof(g[2]), atof(g[5])
+			return numericRange(priceField, min, max), min <= max && (isPrice(g[1], g[3]) || isPrice(g[4], g[6]))
+		})
+		extract(priceSpanPattern, func(g []string) (RangeFilter, bool) {
+			min, max := atof(g[1]), atof(g[2])
+			return numericRange(priceField, min, max), min <= max
+		})
+		extract(priceBoundPattern, func(g []string) (RangeFilter, bool) {
+			amount := floatPtr(atof(g[3]))
+			f := RangeFilter{Type: RangeNumeric, Field: priceField}
+			switch strings.Join(strings.Fields(strings.ToLower(g[1])), " ") {
+			case "under", "below", "less than", "cheaper than":
+				f.Max, f.ExclusiveMax = amount, true
+			case "up to", "at most":
+				f.Max = amount
+			case "over", "above", "more than":
+				f.Min, f.ExclusiveMin = amount, true
+			case "at least":
+				f.Min = amount
+			}
+			return f, isPrice(g[2], g[4])
+		})
+	}
+
+	if len(filters) == 0 {
+		return query, nil
+	}
+	previous, _ := annotations[AnnotationRanges].([]RangeFilter)
+	annotations[AnnotationRanges] = append(previous, filters...)
+	return strings.Join(strings.Fields(query), " "), nil
+}
+
+// isPrice reports whether an amount matched by pricePattern has a currency, given its
+// prefix and suffix groups.
+func isPrice(prefix, suffix string) bool {
+	return prefix != "" || suffix != ""
+}
+
+// numericRange returns the inclusive range [min, max] on field.
+func numericRange(field string, min, max float64) RangeFilter {
+	return RangeFilter{Type: RangeNumeric, Field: field, Min: &min, Max: &max}
+}
+
+// dateRange returns the inclusive date range [start, end] on field.
+func dateRange(field, start, end string) RangeFilter {
+	return RangeFilter{Type: RangeDate, Field: field, Start: start, End: end}
+}
+
+// yearStart and yearEnd return the first and last second of year, in RFC 3339.
+func yearStart(year int) string {
+	return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+}
+
+func yearEnd(year int) string {
+	return time.Date(year, time.December, 31, 23, 59, 59, 0, time.UTC).Format(time.RFC3339)
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// atoi and atof parse the digits matched by the range patterns.
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func atof(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
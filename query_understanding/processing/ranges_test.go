@@ -0,0 +1,140 @@
+package processing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeParsingStage(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.UTC)
+	stage := &RangeParsingStage{Now: func() time.Time { return now }}
+	config := map[string]interface{}{"price_field": "price", "year_field": "year", "date_field": "published"}
+	numeric := func(min, max *float64, exclusiveMin, exclusiveMax bool) RangeFilter {
+		return RangeFilter{Type: RangeNumeric, Field: "price", Min: min, Max: max, ExclusiveMin: exclusiveMin, ExclusiveMax: exclusiveMax}
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		config   map[string]interface{}
+		expected []RangeFilter
+		keywords string
+	}{
+		{
+			name:     "under price",
+			query:    "red shoes under $50",
+			expected: []RangeFilter{numeric(nil, floatPtr(50), false, true)},
+			keywords: "red shoes",
+		},
+		{
+			name:     "at least price",
+			query:    "laptop at least 300 dollars",
+			expected: []RangeFilter{numeric(floatPtr(300), nil, false, false)},
+			keywords: "laptop",
+		},
+		{
+			name:     "price span",
+			query:    "Headphones $20-$49.99",
+			expected: []RangeFilter{numeric(floatPtr(20), floatPtr(49.99), false, false)},
+			keywords: "Headphones",
+		},
+		{
+			name:     "price between",
+			query:    "desk between $100 and $250 oak",
+			expected: []RangeFilter{numeric(floatPtr(100), floatPtr(250), false, false)},
+			keywords: "desk oak",
+		},
+		{
+			name:     "year between",
+			query:    "novels between 2019 and 2021",
+			expected: []RangeFilter{{Type: RangeNumeric, Field: "year", Min: floatPtr(2019), Max: floatPtr(2021)}},
+			keywords: "novels",
+		},
+		{
+			name:     "year after",
+			query:    "cars after 2015",
+			expected: []RangeFilter{{Type: RangeNumeric, Field: "year", Min: floatPtr(2015), ExclusiveMin: true}},
+			keywords: "cars",
+		},
+		{
+			name:     "years as dates",
+			query:    "news 2019-2021",
+			config:   map[string]interface{}{"date_field": "published"},
+			expected: []RangeFilter{{Type: RangeDate, Field: "published", Start: "2019-01-01T00:00:00Z", End: "2021-12-31T23:59:59Z"}},
+			keywords: "news",
+		},
+		{
+			name:     "last days",
+			query:    "outages in the last 7 days",
+			expected: []RangeFilter{{Type: RangeDate, Field: "published", Start: "2024-03-08T12:00:00Z"}},
+			keywords: "outages",
+		},
+		{
+			name:     "past month",
+			query:    "Past Month releases",
+			expected: []RangeFilter{{Type: RangeDate, Field: "published", Start: "2024-02-15T12:00:00Z"}},
+			keywords: "releases",
+		},
+		{
+			name:     "several ranges",
+			query:    "phones under $500 since 2022",
+			expected: []RangeFilter{{Type: RangeNumeric, Field: "year", Min: floatPtr(2022)}, numeric(nil, floatPtr(500), false, true)},
+			keywords: "phones",
+		},
+		{
+			name:     "unconfigured field",
+			query:    "shoes under $50 last week",
+			config:   map[string]interface{}{"date_field": "published"},
+			expected: []RangeFilter{{Type: RangeDate, Field: "published", Start: "2024-03-08T12:00:00Z"}},
+			keywords: "shoes under $50",
+		},
+		{
+			name:     "price between with one currency",
+			query:    "chairs between 20 and 50 bucks",
+			expected: []RangeFilter{numeric(floatPtr(20), floatPtr(50), false, false)},
+			keywords: "chairs",
+		},
+		{
+			name:     "screen size",
+			query:    "laptops under 15 inches",
+			keywords: "laptops under 15 inches",
+		},
+		{
+			name:     "age",
+			query:    "toys for kids over 3 years",
+			keywords: "toys for kids over 3 years",
+		},
+		{
+			name:     "bedrooms",
+			query:    "house at least 2 bedrooms",
+			keywords: "house at least 2 bedrooms",
+		},
+		{
+			name:     "number between",
+			query:    "tents between 2 and 4 persons",
+			keywords: "tents between 2 and 4 persons",
+		},
+		{
+			name:     "no range",
+			query:    "under armour 2019-1999 shirt",
+			keywords: "under armour 2019-1999 shirt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.config
+			if cfg == nil {
+				cfg = config
+			}
+			annotations := Annotations{}
+			keywords, err := stage.ProcessAnnotated(tt.query, cfg, annotations)
+			require.NoError(t, err)
+			assert.Equal(t, tt.keywords, keywords)
+			ranges, _ := annotations[AnnotationRanges].([]RangeFilter)
+			assert.Equal(t, tt.expected, ranges)
+		})
+	}
+}
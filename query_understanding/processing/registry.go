@@ -40,3 +40,9 @@ func (sr *StageRegistry) Get(name string) (QueryStage, bool) {
 	stage, found := sr.stages[name]
 	return stage, found
 }
+
+// Has reports whether a stage is registered under name.
+func (sr *StageRegistry) Has(name string) bool {
+	_, found := sr.Get(name)
+	return found
+}
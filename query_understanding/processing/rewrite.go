@@ -0,0 +1,182 @@
+package processing
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"query_understanding/config"
+)
+
+const (
+	// AnnotationRewrites is the annotation key holding the []RewriteTrace of the rewrite
+	// rules that fired, in the order they were applied.
+	AnnotationRewrites = "rewrites"
+
+	// Rewrite rule match types.
+	RewriteMatchLiteral = "literal"
+	RewriteMatchRegex   = "regex"
+	RewriteMatchTokens  = "tokens"
+
+	tokenWildcard = "*"
+)
+
+// tokenReference matches $N references to wildcards in token rule replacements.
+var tokenReference = regexp.MustCompile(`\$(\d+)`)
+
+// RewriteTrace records a rewrite rule that changed the query, for explain mode.
+type RewriteTrace struct {
+	Rule   string `json:"rule"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// QueryRewriteStage implements the QueryStage interface to rewrite the query with
+// admin-defined match/replace rules, e.g. for merchandising overrides. Rules run by
+// descending priority (configuration order breaks ties) and each fired rule is recorded
+// under AnnotationRewrites.
+//
+// Supported config keys:
+//   - "rules" ([]config.RewriteRule): the rules to apply (none by default).
+type QueryRewriteStage struct{}
+
+// Process applies the configured rewrite rules to the query.
+func (s *QueryRewriteStage) Process(query string, config map[string]interface{}) (string, error) {
+	return s.ProcessAnnotated(query, config, make(Annotations))
+}
+
+// ProcessAnnotated applies the configured rewrite rules and appends the fired rules to
+// the AnnotationRewrites annotation.
+func (s *QueryRewriteStage) ProcessAnnotated(query string, cfg map[string]interface{}, annotations Annotations) (string, error) {
+	rulesInterface, ok := cfg["rules"]
+	if !ok {
+		return query, nil
+	}
+	rules, ok := rulesInterface.([]config.RewriteRule)
+	if !ok {
+		return "", fmt.Errorf("rewrite rules config must be a list of rewrite rules")
+	}
+
+	rewritten, traces, err := ApplyRewriteRules(query, rules)
+	if err != nil {
+		return "", err
+	}
+	if len(traces) > 0 {
+		previous, _ := annotations[AnnotationRewrites].([]RewriteTrace)
+		annotations[AnnotationRewrites] = append(previous, traces...)
+	}
+	return rewritten, nil
+}
+
+// ApplyRewriteRules applies the rules to query by descending priority and returns the
+// rewritten query with a trace of the rules that changed it. A fired rule with Stop set
+// ends the processing.
+func ApplyRewriteRules(query string, rules []config.RewriteRule) (string, []RewriteTrace, error) {
+	ordered := append([]config.RewriteRule(nil), rules...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority > ordered[j].Priority
+	})
+
+	var traces []RewriteTrace
+	for _, rule := range ordered {
+		rewritten, err := applyRewriteRule(query, rule)
+		if err != nil {
+			return "", nil, err
+		}
+		if rewritten == query {
+			continue
+		}
+		traces = append(traces, RewriteTrace{Rule: rule.Name, Before: query, After: rewritten})
+		query = rewritten
+		if rule.Stop {
+			break
+		}
+	}
+	return query, traces, nil
+}
+
+// applyRewriteRule applies a single rule. The result is whitespace-normalized so that
+// removing words does not leave empty tokens behind.
+func applyRewriteRule(query string, rule config.RewriteRule) (string, error) {
+	var rewritten string
+	switch rule.Match {
+	case RewriteMatchLiteral:
+		rewritten = replaceTokens(query, strings.Fields(rule.Pattern), false, rule.Replace)
+	case RewriteMatchTokens:
+		rewritten = replaceTokens(query, strings.Fields(rule.Pattern), true, rule.Replace)
+	case RewriteMatchRegex:
+		re, err := compilePattern(rule.Pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern for rewrite rule '%s': %w", rule.Name, err)
+		}
+		rewritten = re.ReplaceAllString(query, rule.Replace)
+	default:
+		return "", fmt.Errorf("rewrite rule '%s' has an unsupported match type '%s'", rule.Name, rule.Match)
+	}
+	if rewritten == query {
+		return query, nil
+	}
+	return strings.Join(strings.Fields(rewritten), " "), nil
+}
+
+// replaceTokens replaces every non-overlapping occurrence of the token sequence pattern
+// in query, comparing tokens case-insensitively. With wildcards, a "*" pattern token
+// matches any single token and the replacement may reference the matched tokens as
+// $1, $2, ... If nothing matches, query is returned unchanged.
+func replaceTokens(query string, pattern []string, wildcards bool, replace string) string {
+	tokens := strings.Fields(query)
+	if len(pattern) == 0 || len(pattern) > len(tokens) {
+		return query
+	}
+
+	var (
+		out     []string
+		matched bool
+	)
+	for i := 0; i < len(tokens); {
+		captures, ok := matchTokens(tokens[i:], pattern, wildcards)
+		if !ok {
+			out = append(out, tokens[i])
+			i++
+			continue
+		}
+		matched = true
+		replacement := replace
+		if wildcards {
+			replacement = tokenReference.ReplaceAllStringFunc(replace, func(ref string) string {
+				n, _ := strconv.Atoi(ref[1:])
+				if n < 1 || n > len(captures) {
+					return ""
+				}
+				return captures[n-1]
+			})
+		}
+		out = append(out, strings.Fields(replacement)...)
+		i += len(pattern)
+	}
+	if !matched {
+		return query
+	}
+	return strings.Join(out, " ")
+}
+
+// matchTokens reports whether tokens starts with pattern, returning the tokens matched
+// by wildcards.
+func matchTokens(tokens, pattern []string, wildcards bool) ([]string, bool) {
+	if len(tokens) < len(pattern) {
+		return nil, false
+	}
+	var captures []string
+	for i, p := range pattern {
+		if wildcards && p == tokenWildcard {
+			captures = append(captures, tokens[i])
+			continue
+		}
+		if !strings.EqualFold(tokens[i], p) {
+			return nil, false
+		}
+	}
+	return captures, true
+}
@@ -0,0 +1,84 @@
+package processing
+
+import (
+	"testing"
+
+	"query_understanding/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyRewriteRules_MatchTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     config.RewriteRule
+		query    string
+		expected string
+	}{
+		{"literal phrase", config.RewriteRule{Match: "literal", Pattern: "Cheap iPhone", Replace: "iphone refurbished"}, "best cheap iphone deals", "best iphone refurbished deals"},
+		{"literal whole words", config.RewriteRule{Match: "literal", Pattern: "tv", Replace: "television"}, "tvs", "tvs"},
+		{"literal removal", config.RewriteRule{Match: "literal", Pattern: "official", Replace: ""}, "official nike store", "nike store"},
+		{"regex groups", config.RewriteRule{Match: "regex", Pattern: `(\d+)\s*inch`, Replace: "${1}in"}, "55 inch tv", "55in tv"},
+		{"token wildcards", config.RewriteRule{Match: "tokens", Pattern: "case for *", Replace: "$1 case"}, "red case for iphone", "red iphone case"},
+		{"token no match", config.RewriteRule{Match: "tokens", Pattern: "case for *", Replace: "$1 case"}, "case for", "case for"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = tt.name
+			out, traces, err := ApplyRewriteRules(tt.query, []config.RewriteRule{tt.rule})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, out)
+			if tt.expected == tt.query {
+				assert.Empty(t, traces)
+			} else {
+				assert.Equal(t, []RewriteTrace{{Rule: tt.name, Before: tt.query, After: tt.expected}}, traces)
+			}
+		})
+	}
+}
+
+func TestApplyRewriteRules_PriorityAndStop(t *testing.T) {
+	rules := []config.RewriteRule{
+		{Name: "low", Match: "literal", Pattern: "phone", Replace: "smartphone"},
+		{Name: "high", Match: "literal", Pattern: "cell", Replace: "mobile", Priority: 10},
+		{Name: "middle", Match: "literal", Pattern: "mobile phone", Replace: "phone", Priority: 5},
+	}
+
+	out, traces, err := ApplyRewriteRules("cell phone", rules)
+	require.NoError(t, err)
+	assert.Equal(t, "smartphone", out)
+	require.Len(t, traces, 3)
+	assert.Equal(t, "high", traces[0].Rule)
+	assert.Equal(t, "middle", traces[1].Rule)
+	assert.Equal(t, "low", traces[2].Rule)
+
+	rules[1].Stop = true
+	out, traces, err = ApplyRewriteRules("cell phone", rules)
+	require.NoError(t, err)
+	assert.Equal(t, "mobile phone", out)
+	assert.Len(t, traces, 1)
+
+	_, _, err = ApplyRewriteRules("x", []config.RewriteRule{{Name: "bad", Match: "fuzzy", Pattern: "x"}})
+	assert.Error(t, err)
+}
+
+func TestQueryRewriteStage_ProcessAnnotated(t *testing.T) {
+	stage := &QueryRewriteStage{}
+	cfg := map[string]interface{}{
+		"rules": []config.RewriteRule{{Name: "laptop", Match: "literal", Pattern: "notebook", Replace: "laptop"}},
+	}
+
+	annotations := make(Annotations)
+	out, err := stage.ProcessAnnotated("gaming notebook", cfg, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "gaming laptop", out)
+	assert.Equal(t, []RewriteTrace{{Rule: "laptop", Before: "gaming notebook", After: "gaming laptop"}}, annotations[AnnotationRewrites])
+
+	out, err = stage.Process("gaming notebook", map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, "gaming notebook", out, "no rules configured")
+
+	_, err = stage.Process("q", map[string]interface{}{"rules": "notebook=laptop"})
+	assert.Error(t, err)
+}
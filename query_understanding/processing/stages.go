@@ -2,7 +2,11 @@ package processing
 
 import (
 	"errors"
+	"regexp"
 	"strings"
+	"sync"
+
+	"common/textnorm"
 )
 
 // LowerCaseStage implements the QueryStage interface to convert the query to lowercase.
@@ -27,31 +31,68 @@ func (s *TokenizeStage) Process(query string, config map[string]interface{}) (st
 	return strings.Join(tokens, " "), nil
 }
 
+// Vocabulary provides the stopwords and synonyms of the queries of a collection and
+// language, e.g. as edited through the lexicon admin API. Stages find it in their config
+// under the "vocabulary" key, with the collection under "collection".
+type Vocabulary interface {
+	// Stopwords returns the stopwords of the queries; ok is false when the vocabulary
+	// has none for them, in which case the stage's configured list applies.
+	Stopwords(collection, language string) (words []string, ok bool)
+	// Synonyms returns the groups of equivalent terms of the queries.
+	Synonyms(collection, language string) [][]string
+}
+
+// vocabularyConfig returns the vocabulary, collection and language a stage works with.
+func vocabularyConfig(config map[string]interface{}, annotations Annotations) (vocabulary Vocabulary, collection, language string) {
+	vocabulary, _ = config["vocabulary"].(Vocabulary)
+	collection, _ = config["collection"].(string)
+	return vocabulary, collection, annotations.String(AnnotationLanguage)
+}
+
 // RemoveStopwordsStage implements the QueryStage interface to remove stopwords from the query.
 type RemoveStopwordsStage struct{}
 
 // Process removes predefined stopwords from the query.
 // Stopwords are expected in the config map under the "stopwords" key as a []string.
 func (s *RemoveStopwordsStage) Process(query string, config map[string]interface{}) (string, error) {
+	return s.ProcessAnnotated(query, config, Annotations{})
+}
+
+// ProcessAnnotated removes the stopwords of the query's collection and language when a
+// vocabulary has them, else those of its language under the "language_stopwords" key (a
+// map[string][]string by language code), and the ones under the "stopwords" key otherwise.
+// The lists are folded like normalize_unicode folds queries, so tokens are looked up
+// folded: "für" is removed whether the query was normalized or not.
+func (s *RemoveStopwordsStage) ProcessAnnotated(query string, config map[string]interface{}, annotations Annotations) (string, error) {
 	if query == "" {
 		return "", nil
 	}
 
-	stopwordsInterface, ok := config["stopwords"]
-	if !ok {
-		// If no stopwords are provided in config, simply return the original query.
-		// Alternatively, this could return an error or use a default list.
-		return query, nil
+	stopwordsList, found := []string(nil), false
+	vocabulary, collection, language := vocabularyConfig(config, annotations)
+	if vocabulary != nil {
+		stopwordsList, found = vocabulary.Stopwords(collection, language)
 	}
+	if languageStopwords, ok := config["language_stopwords"].(map[string][]string); ok && !found {
+		stopwordsList, found = languageStopwords[language]
+	}
+	if !found {
+		stopwordsInterface, ok := config["stopwords"]
+		if !ok {
+			// If no stopwords are provided in config, simply return the original query.
+			// Alternatively, this could return an error or use a default list.
+			return query, nil
+		}
 
-	stopwordsList, ok := stopwordsInterface.([]string)
-	if !ok {
-		return "", errors.New("stopwords config must be a list of strings")
+		stopwordsList, ok = stopwordsInterface.([]string)
+		if !ok {
+			return "", errors.New("stopwords config must be a list of strings")
+		}
 	}
 
 	stopwordMap := make(map[string]struct{})
 	for _, sw := range stopwordsList {
-		stopwordMap[sw] = struct{}{}
+		stopwordMap[textnorm.Normalize(sw, textnorm.Fold)] = struct{}{}
 	}
 
 	// Assuming the query is already tokenized by a previous stage or is space-separated.
@@ -59,7 +100,7 @@ func (s *RemoveStopwordsStage) Process(query string, config map[string]interface
 	filteredTokens := make([]string, 0, len(tokens))
 
 	for _, token := range tokens {
-		if _, isStopword := stopwordMap[token]; !isStopword {
+		if _, isStopword := stopwordMap[textnorm.Normalize(token, textnorm.Fold)]; !isStopword {
 			filteredTokens = append(filteredTokens, token)
 		}
 	}
@@ -68,16 +109,87 @@ func (s *RemoveStopwordsStage) Process(query string, config map[string]interface
 }
 
 // SynonymExpansionStage implements the QueryStage interface for synonym expansion.
-// This is a placeholder and would require a more complex lookup mechanism.
 type SynonymExpansionStage struct{}
 
-// Process currently returns the query as is, demonstrating a placeholder.
-// In a real scenario, this would expand terms based on a synonym dictionary.
+// Process expands the query without annotations, see ProcessAnnotated.
 func (s *SynonymExpansionStage) Process(query string, config map[string]interface{}) (string, error) {
-	// For demonstration, let's say "pc" expands to "personal computer"
-	// This logic would typically come from a configurable synonym map
-	if strings.Contains(query, "pc") {
-		query = strings.ReplaceAll(query, "pc", "pc personal computer")
+	return s.ProcessAnnotated(query, config, Annotations{})
+}
+
+// ProcessAnnotated appends to every term of the query found in a synonym group of the
+// query's collection and detected language the other terms of the group, e.g. "cheap pc"
+// becomes "cheap pc personal computer". Terms are words or phrases, the longest match
+// wins. Without a vocabulary, "pc" expands to "personal computer" as a demonstration.
+func (s *SynonymExpansionStage) ProcessAnnotated(query string, config map[string]interface{}, annotations Annotations) (string, error) {
+	vocabulary, collection, language := vocabularyConfig(config, annotations)
+	if vocabulary == nil {
+		if strings.Contains(query, "pc") {
+			query = strings.ReplaceAll(query, "pc", "pc personal computer")
+		}
+		return query, nil
+	}
+	return expandSynonyms(query, vocabulary.Synonyms(collection, language)), nil
+}
+
+// expandSynonyms appends the synonyms of the terms of query from groups of equivalent
+// terms, leaving out the terms and synonyms already in the expanded query. The groups are folded like
+// normalize_unicode folds queries, so terms are looked up folded.
+func expandSynonyms(query string, groups [][]string) string {
+	tokens := strings.Fields(query)
+	if len(tokens) == 0 || len(groups) == 0 {
+		return query
+	}
+	synonyms := make(map[string][]string) // By term, the other terms of its groups
+	longest := 1                          // Words of the longest term
+	for _, group := range groups {
+		for _, term := range group {
+			longest = max(longest, len(strings.Fields(term)))
+			for _, other := range group {
+				if other != term {
+					synonyms[term] = append(synonyms[term], other)
+				}
+			}
+		}
+	}
+
+	out := make([]string, 0, len(tokens))
+	added := make(map[string]bool)
+	for i := 0; i < len(tokens); {
+		n := min(longest, len(tokens)-i)
+		for ; n > 1; n-- {
+			if _, ok := synonyms[textnorm.Normalize(strings.Join(tokens[i:i+n], " "), textnorm.Fold)]; ok {
+				break
+			}
+		}
+		term := strings.Join(tokens[i:i+n], " ")
+		if !added[term] {
+			added[term] = true
+			out = append(out, term)
+		}
+		for _, synonym := range synonyms[textnorm.Normalize(term, textnorm.Fold)] {
+			if !added[synonym] {
+				added[synonym] = true
+				out = append(out, synonym)
+			}
+		}
+		i += n
+	}
+	return strings.Join(out, " ")
+}
+
+// patterns caches compiled regular expressions from the configuration, which are
+// shared by every query.
+var patterns sync.Map
+
+// compilePattern compiles expr, reusing earlier compilations.
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
 	}
-	return query, nil
+	patterns.Store(expr, re)
+	return re, nil
 }
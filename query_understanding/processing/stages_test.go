@@ -0,0 +1,91 @@
+package processing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testVocabulary has stopwords and synonyms for English queries only.
+type testVocabulary struct{}
+
+func (testVocabulary) Stopwords(collection, language string) ([]string, bool) {
+	if language != "en" {
+		return nil, false
+	}
+	return []string{"cheap"}, true
+}
+
+func (testVocabulary) Synonyms(collection, language string) [][]string {
+	if language != "en" {
+		return nil
+	}
+	return [][]string{{"pc", "personal computer"}, {"tv", "television", "telly"}}
+}
+
+func TestRemoveStopwordsStage_Vocabulary(t *testing.T) {
+	config := map[string]interface{}{"stopwords": []string{"the"}, "vocabulary": testVocabulary{}}
+	stage := &RemoveStopwordsStage{}
+
+	out, err := stage.ProcessAnnotated("the cheap tv", config, Annotations{AnnotationLanguage: "en"})
+	require.NoError(t, err)
+	assert.Equal(t, "the tv", out)
+
+	out, err = stage.ProcessAnnotated("the cheap tv", config, Annotations{AnnotationLanguage: "fr"})
+	require.NoError(t, err)
+	assert.Equal(t, "cheap tv", out)
+}
+
+func TestRemoveStopwordsStage_Language(t *testing.T) {
+	config := map[string]interface{}{
+		"stopwords":          []string{"the"},
+		"language_stopwords": map[string][]string{"fr": {"le", "pour"}, "de": {"der"}},
+		"vocabulary":         testVocabulary{},
+	}
+	stage := &RemoveStopwordsStage{}
+
+	out, err := stage.ProcessAnnotated("le the pour chat", config, Annotations{AnnotationLanguage: "fr"})
+	require.NoError(t, err)
+	assert.Equal(t, "the chat", out)
+
+	// The vocabulary's list wins over the language's, the default list applies to others.
+	out, err = stage.ProcessAnnotated("the cheap der tv", config, Annotations{AnnotationLanguage: "en"})
+	require.NoError(t, err)
+	assert.Equal(t, "the der tv", out)
+	out, err = stage.ProcessAnnotated("the cheap der tv", config, Annotations{AnnotationLanguage: "es"})
+	require.NoError(t, err)
+	assert.Equal(t, "cheap der tv", out)
+}
+
+func TestRemoveStopwordsStage_Folded(t *testing.T) {
+	config := map[string]interface{}{"language_stopwords": map[string][]string{"de": {"für"}, "fr": {"a"}}}
+	stage := &RemoveStopwordsStage{}
+
+	// Lists are folded, so accented tokens match whether the query was normalized or not.
+	for _, query := range []string{"schuhe für kinder", "schuhe fur kinder"} {
+		out, err := stage.ProcessAnnotated(query, config, Annotations{AnnotationLanguage: "de"})
+		require.NoError(t, err)
+		assert.Equal(t, "schuhe kinder", out, query)
+	}
+	out, err := stage.ProcessAnnotated("tarte à la crème", config, Annotations{AnnotationLanguage: "fr"})
+	require.NoError(t, err)
+	assert.Equal(t, "tarte la crème", out)
+}
+
+func TestExpandSynonyms(t *testing.T) {
+	groups := append(testVocabulary{}.Synonyms("", "en"), []string{"cafe", "coffee"})
+	tests := []struct {
+		query, expected string
+	}{
+		{"cheap pc", "cheap pc personal computer"},
+		{"personal computer desk", "personal computer pc desk"},
+		{"tv and telly", "tv television telly and"},
+		{"pcs", "pcs"},
+		{"café au lait", "café coffee au lait"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, expandSynonyms(tt.query, groups), tt.query)
+	}
+}
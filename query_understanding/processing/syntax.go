@@ -0,0 +1,359 @@
+package processing
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	// AnnotationQueryTree is the annotation key holding the *QueryNode parsed from a query
+	// that uses query syntax.
+	AnnotationQueryTree = "query_tree"
+
+	// Query tree node types.
+	NodeTerm   = "term"   // A single word, matched after analysis
+	NodePhrase = "phrase" // Words that must appear next to each other, in order
+	NodeBool   = "bool"   // A combination of Must, Should and MustNot clauses
+
+	// Operators combining adjacent clauses without a + or - prefix.
+	OperatorOr  = "or"
+	OperatorAnd = "and"
+
+	orKeyword = "OR"
+)
+
+// QueryNode is a node of the boolean query tree produced by the QuerySyntaxStage. Term
+// and phrase nodes match Text, on Field if set or on any field otherwise. A bool node
+// matches the documents matching every Must clause and no MustNot clause; when it has no
+// Must clauses, a document must also match at least one Should clause. Should clauses
+// next to Must clauses only contribute to the score.
+type QueryNode struct {
+	Type    string       `json:"type"`
+	Field   string       `json:"field,omitempty"`
+	Text    string       `json:"text,omitempty"`
+	Must    []*QueryNode `json:"must,omitempty"`
+	Should  []*QueryNode `json:"should,omitempty"`
+	MustNot []*QueryNode `json:"must_not,omitempty"`
+}
+
+// Keywords returns the words of the term and phrase nodes the query looks for, skipping
+// excluded clauses.
+func (n *QueryNode) Keywords() []string {
+	if n == nil {
+		return nil
+	}
+	switch n.Type {
+	case NodeTerm, NodePhrase:
+		return strings.Fields(n.Text)
+	}
+	var keywords []string
+	for _, c := range n.Must {
+		keywords = append(keywords, c.Keywords()...)
+	}
+	for _, c := range n.Should {
+		keywords = append(keywords, c.Keywords()...)
+	}
+	return keywords
+}
+
+// FieldFilter is a field restriction every result of a query must satisfy, such as
+// brand:acme or -color:red.
+type FieldFilter struct {
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	Exclude bool   `json:"exclude,omitempty"` // The results must not match instead
+}
+
+// Filters returns the field-scoped term and phrase clauses the query requires or excludes
+// at its top level. Field clauses that are alternatives (Should) are not restrictions and
+// are left out.
+func (n *QueryNode) Filters() []FieldFilter {
+	if n == nil {
+		return nil
+	}
+	if n.Type != NodeBool {
+		if n.Field == "" {
+			return nil
+		}
+		return []FieldFilter{{Field: n.Field, Value: n.Text}}
+	}
+	var filters []FieldFilter
+	for _, c := range n.Must {
+		if c.Type != NodeBool && c.Field != "" {
+			filters = append(filters, FieldFilter{Field: c.Field, Value: c.Text})
+		}
+	}
+	for _, c := range n.MustNot {
+		if c.Type != NodeBool && c.Field != "" {
+			filters = append(filters, FieldFilter{Field: c.Field, Value: c.Text, Exclude: true})
+		}
+	}
+	return filters
+}
+
+// QuerySyntaxStage implements the QueryStage interface to parse search syntax:
+//
+//	"red shoes"        phrase
+//	+red -blue         required and excluded terms
+//	title:shoes        term restricted to a field; also title:"red shoes" and title:(a OR b)
+//	shoes OR boots     either side matches
+//	(red OR blue) shoe grouping
+//
+// The parsed tree is stored under AnnotationQueryTree and the query string is reduced to
+// the words the query looks for, so later stages keep working on plain keywords. Queries
+// without any syntax produce no tree and keep flowing as flat keywords, so stopword
+// removal and synonym expansion still apply to them. Parsing is lenient: unbalanced quotes
+// and parentheses are closed at the end of the query and stray operators are ignored.
+// The stage must run before lowercasing, which would hide the OR keyword.
+//
+// Supported config keys:
+//   - "default_operator" (string): how clauses without a prefix are combined, "or"
+//     (default) or "and".
+//   - "fields" ([]string): field names recognised in field:value syntax; any name is
+//     recognised if unset. Other prefixes (e.g. in "http://...") are left as text.
+type QuerySyntaxStage struct{}
+
+// Process strips the query syntax, returning the words the query looks for.
+func (s *QuerySyntaxStage) Process(query string, config map[string]interface{}) (string, error) {
+	return s.ProcessAnnotated(query, config, make(Annotations))
+}
+
+// ProcessAnnotated parses the query and stores the tree under AnnotationQueryTree if the
+// query uses any syntax.
+func (s *QuerySyntaxStage) ProcessAnnotated(query string, cfg map[string]interface{}, annotations Annotations) (string, error) {
+	p := &syntaxParser{input: []rune(query), defaultOperator: OperatorOr}
+	if v, ok := cfg["default_operator"]; ok {
+		op, ok := v.(string)
+		if !ok || (op != OperatorOr && op != OperatorAnd) {
+			return "", fmt.Errorf("default_operator must be %q or %q", OperatorOr, OperatorAnd)
+		}
+		p.defaultOperator = op
+	}
+	if v, ok := cfg["fields"]; ok {
+		fields, ok := v.([]string)
+		if !ok {
+			return "", fmt.Errorf("fields config must be a list of strings")
+		}
+		p.fields = make(map[string]bool, len(fields))
+		for _, f := range fields {
+			p.fields[f] = true
+		}
+	}
+
+	tree := p.parseOr(0)
+	if tree == nil || !p.syntax {
+		return query, nil
+	}
+	annotations[AnnotationQueryTree] = tree
+	return strings.Join(tree.Keywords(), " "), nil
+}
+
+// syntaxParser is a recursive descent parser for the query syntax.
+type syntaxParser struct {
+	input           []rune
+	pos             int
+	defaultOperator string
+	fields          map[string]bool // Recognised field names; nil recognises any
+	syntax          bool            // Whether the query used any syntax
+}
+
+// clause is a parsed clause together with its +/- prefix.
+type clause struct {
+	prefix rune
+	node   *QueryNode
+}
+
+// parseOr parses clauses separated by OR up to the end of the input or, when nested,
+// the closing parenthesis. It returns nil if there are no clauses.
+func (p *syntaxParser) parseOr(depth int) *QueryNode {
+	var groups []*QueryNode
+	var current []clause
+	for {
+		p.skipSpaces()
+		if p.eof() {
+			break
+		}
+		if p.peek() == ')' {
+			p.pos++
+			p.syntax = true
+			if depth > 0 {
+				break
+			}
+			continue // Stray closing parenthesis
+		}
+		if p.atOr() {
+			p.pos += len(orKeyword)
+			p.syntax = true
+			if node := p.combine(current); node != nil {
+				groups = append(groups, node)
+			}
+			current = nil
+			continue
+		}
+		if c, ok := p.parseClause(depth); ok {
+			current = append(current, c)
+		}
+	}
+	if node := p.combine(current); node != nil {
+		groups = append(groups, node)
+	}
+
+	switch len(groups) {
+	case 0:
+		return nil
+	case 1:
+		return groups[0]
+	}
+	return &QueryNode{Type: NodeBool, Should: groups}
+}
+
+// parseClause parses an optionally prefixed and field-restricted term, phrase or group.
+func (p *syntaxParser) parseClause(depth int) (clause, bool) {
+	c := clause{}
+	if r := p.peek(); r == '+' || r == '-' {
+		p.pos++
+		p.syntax = true
+		if p.eof() || unicode.IsSpace(p.peek()) {
+			return c, false // Lone operator
+		}
+		c.prefix = r
+	}
+	field := p.parseField()
+
+	switch p.peek() {
+	case '"':
+		p.pos++
+		p.syntax = true
+		start := p.pos
+		for !p.eof() && p.peek() != '"' {
+			p.pos++
+		}
+		text := strings.Join(strings.Fields(string(p.input[start:p.pos])), " ")
+		if !p.eof() {
+			p.pos++ // Closing quote
+		}
+		if text == "" {
+			return c, false
+		}
+		c.node = &QueryNode{Type: NodePhrase, Field: field, Text: text}
+	case '(':
+		p.pos++
+		p.syntax = true
+		c.node = p.parseOr(depth + 1)
+		if c.node == nil {
+			return c, false
+		}
+		if field != "" {
+			setDefaultField(c.node, field)
+		}
+	default:
+		start := p.pos
+		for !p.eof() && !isSyntaxBoundary(p.peek()) {
+			p.pos++
+		}
+		if p.pos == start {
+			return c, false // Operator followed by a closing parenthesis
+		}
+		c.node = &QueryNode{Type: NodeTerm, Field: field, Text: string(p.input[start:p.pos])}
+	}
+	return c, true
+}
+
+// parseField consumes a "field:" prefix if one starts at the current position and names a
+// recognised field, returning the field name.
+func (p *syntaxParser) parseField() string {
+	end := p.pos
+	for end < len(p.input) && isFieldRune(p.input[end], end == p.pos) {
+		end++
+	}
+	if end == p.pos || end+1 >= len(p.input) || p.input[end] != ':' {
+		return ""
+	}
+	if next := p.input[end+1]; unicode.IsSpace(next) || next == ')' {
+		return ""
+	}
+	field := string(p.input[p.pos:end])
+	if p.fields != nil && !p.fields[field] {
+		return ""
+	}
+	p.pos = end + 1
+	p.syntax = true
+	return field
+}
+
+// combine turns the clauses between two ORs into a single node.
+func (p *syntaxParser) combine(clauses []clause) *QueryNode {
+	node := &QueryNode{Type: NodeBool}
+	for _, c := range clauses {
+		switch {
+		case c.prefix == '-':
+			node.MustNot = append(node.MustNot, c.node)
+		case c.prefix == '+' || p.defaultOperator == OperatorAnd:
+			node.Must = append(node.Must, c.node)
+		default:
+			node.Should = append(node.Should, c.node)
+		}
+	}
+	switch {
+	case len(clauses) == 0:
+		return nil
+	case len(node.Must) == 1 && len(node.Should) == 0 && len(node.MustNot) == 0:
+		return node.Must[0]
+	case len(node.Should) == 1 && len(node.Must) == 0 && len(node.MustNot) == 0:
+		return node.Should[0]
+	}
+	return node
+}
+
+// atOr reports whether the OR keyword starts at the current position.
+func (p *syntaxParser) atOr() bool {
+	end := p.pos + len(orKeyword)
+	if end > len(p.input) || string(p.input[p.pos:end]) != orKeyword {
+		return false
+	}
+	return end == len(p.input) || isSyntaxBoundary(p.input[end])
+}
+
+func (p *syntaxParser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *syntaxParser) peek() rune {
+	return p.input[p.pos]
+}
+
+func (p *syntaxParser) skipSpaces() {
+	for !p.eof() && unicode.IsSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+// setDefaultField restricts the term and phrase nodes of a group to field unless they
+// name a field themselves.
+func setDefaultField(n *QueryNode, field string) {
+	if n.Type != NodeBool {
+		if n.Field == "" {
+			n.Field = field
+		}
+		return
+	}
+	for _, clauses := range [][]*QueryNode{n.Must, n.Should, n.MustNot} {
+		for _, c := range clauses {
+			setDefaultField(c, field)
+		}
+	}
+}
+
+// isSyntaxBoundary reports whether r ends a term.
+func isSyntaxBoundary(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+}
+
+// isFieldRune reports whether r can appear in a field name, at its start if first is set.
+func isFieldRune(r rune, first bool) bool {
+	if r == '_' || unicode.IsLetter(r) {
+		return true
+	}
+	return !first && (r == '.' || unicode.IsDigit(r))
+}
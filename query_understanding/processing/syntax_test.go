@@ -0,0 +1,110 @@
+package processing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func term(field, text string) *QueryNode {
+	return &QueryNode{Type: NodeTerm, Field: field, Text: text}
+}
+
+func TestQuerySyntaxStage_Parse(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		config   map[string]interface{}
+		expected *QueryNode
+		keywords string
+	}{
+		{
+			name:     "phrase",
+			query:    `"red shoes" cheap`,
+			expected: &QueryNode{Type: NodeBool, Should: []*QueryNode{{Type: NodePhrase, Text: "red shoes"}, term("", "cheap")}},
+			keywords: "red shoes cheap",
+		},
+		{
+			name:     "required and excluded",
+			query:    "+shoes red -blue",
+			expected: &QueryNode{Type: NodeBool, Must: []*QueryNode{term("", "shoes")}, Should: []*QueryNode{term("", "red")}, MustNot: []*QueryNode{term("", "blue")}},
+			keywords: "shoes red",
+		},
+		{
+			name:     "field value",
+			query:    `title:shoes brand:"acme corp"`,
+			expected: &QueryNode{Type: NodeBool, Should: []*QueryNode{term("title", "shoes"), {Type: NodePhrase, Field: "brand", Text: "acme corp"}}},
+			keywords: "shoes acme corp",
+		},
+		{
+			name:     "or groups",
+			query:    "red shoes OR boots",
+			config:   map[string]interface{}{"default_operator": "and"},
+			expected: &QueryNode{Type: NodeBool, Should: []*QueryNode{{Type: NodeBool, Must: []*QueryNode{term("", "red"), term("", "shoes")}}, term("", "boots")}},
+			keywords: "red shoes boots",
+		},
+		{
+			name:     "grouping with field",
+			query:    "+color:(red OR blue) -(cheap OR used)",
+			expected: &QueryNode{Type: NodeBool, Must: []*QueryNode{{Type: NodeBool, Should: []*QueryNode{term("color", "red"), term("color", "blue")}}}, MustNot: []*QueryNode{{Type: NodeBool, Should: []*QueryNode{term("", "cheap"), term("", "used")}}}},
+			keywords: "red blue",
+		},
+		{
+			name:     "lenient",
+			query:    `(shoes "red - OR`,
+			expected: &QueryNode{Type: NodeBool, Should: []*QueryNode{term("", "shoes"), {Type: NodePhrase, Text: "red - OR"}}},
+			keywords: "shoes red - OR",
+		},
+		{
+			name:     "unknown field",
+			query:    "http://example.com -spam",
+			config:   map[string]interface{}{"fields": []string{"title"}},
+			expected: &QueryNode{Type: NodeBool, Should: []*QueryNode{term("", "http://example.com")}, MustNot: []*QueryNode{term("", "spam")}},
+			keywords: "http://example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.config
+			if cfg == nil {
+				cfg = map[string]interface{}{}
+			}
+			annotations := make(Annotations)
+			out, err := (&QuerySyntaxStage{}).ProcessAnnotated(tt.query, cfg, annotations)
+			require.NoError(t, err)
+			assert.Equal(t, tt.keywords, out)
+			assert.Equal(t, tt.expected, annotations[AnnotationQueryTree])
+		})
+	}
+}
+
+func TestQuerySyntaxStage_PlainQuery(t *testing.T) {
+	annotations := make(Annotations)
+	out, err := (&QuerySyntaxStage{}).ProcessAnnotated("red  running shoes", map[string]interface{}{}, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, "red  running shoes", out)
+	assert.NotContains(t, annotations, AnnotationQueryTree)
+}
+
+func TestQuerySyntaxStage_InvalidConfig(t *testing.T) {
+	_, err := (&QuerySyntaxStage{}).Process("a", map[string]interface{}{"default_operator": "xor"})
+	assert.Error(t, err)
+	_, err = (&QuerySyntaxStage{}).Process("a", map[string]interface{}{"fields": "title"})
+	assert.Error(t, err)
+}
+
+func TestQueryNode_Filters(t *testing.T) {
+	tree := &QueryNode{Type: NodeBool,
+		Must:    []*QueryNode{term("brand", "acme"), term("", "shoes"), {Type: NodeBool, Should: []*QueryNode{term("color", "red")}}},
+		Should:  []*QueryNode{term("size", "42")},
+		MustNot: []*QueryNode{{Type: NodePhrase, Field: "condition", Text: "very used"}},
+	}
+	assert.Equal(t, []FieldFilter{
+		{Field: "brand", Value: "acme"},
+		{Field: "condition", Value: "very used", Exclude: true},
+	}, tree.Filters())
+	assert.Equal(t, []FieldFilter{{Field: "title", Value: "shoes"}}, term("title", "shoes").Filters())
+	assert.Empty(t, term("", "shoes").Filters())
+	assert.Empty(t, (*QueryNode)(nil).Filters())
+}
@@ -1,8 +1,239 @@
 package query_understanding
 
-import "testing"
+import (
+	"context"
+	"testing"
+
+	"query_understanding/config"
+	"query_understanding/lexicon"
+	"query_understanding/processing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
 func TestPlaceholder(t *testing.T) {
 	// This is a placeholder test.
 	// Add actual tests here later.
 }
+
+func TestLoadConfiguration(t *testing.T) {
+	cfg, err := LoadConfiguration("config/config.yaml")
+	require.NoError(t, err)
+	assert.NotEmpty(t, cfg.QueryPlanningPipelines)
+}
+
+func TestProcessClientQueryStructured(t *testing.T) {
+	cfg := &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"detect_language", "lowercase", "tokenize", "remove_stopwords"}},
+		},
+	}
+
+	sq, err := ProcessClientQueryStructured("Where is the Best Pizza", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "Where is the Best Pizza", sq.RawQuery)
+	assert.Equal(t, "where best pizza", sq.ProcessedQuery)
+	assert.Equal(t, []string{"where", "best", "pizza"}, sq.Keywords)
+	assert.Equal(t, "en", sq.Language)
+}
+
+func TestProcessClientQueryStructured_MissingPipeline(t *testing.T) {
+	_, err := ProcessClientQueryStructured("query", &config.Configuration{})
+	assert.Error(t, err)
+}
+
+func TestProcessClientQueryWithOptions_Pipeline(t *testing.T) {
+	cfg := &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"tokenize"}},
+			{Name: "lowercase_pipeline", Steps: []string{"lowercase", "tokenize"}},
+		},
+	}
+
+	sq, err := ProcessClientQueryWithOptions("Red Shoes", cfg, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "Red Shoes", sq.ProcessedQuery)
+
+	sq, err = ProcessClientQueryWithOptions("Red Shoes", cfg, ProcessOptions{Pipeline: "lowercase_pipeline"})
+	require.NoError(t, err)
+	assert.Equal(t, "red shoes", sq.ProcessedQuery)
+
+	_, err = ProcessClientQueryWithOptions("Red Shoes", cfg, ProcessOptions{Pipeline: "missing"})
+	assert.ErrorIs(t, err, ErrUnknownPipeline)
+}
+
+func TestProcessClientQueryWithOptions_Language(t *testing.T) {
+	cfg := &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"detect_language", "lowercase", "tokenize", "remove_stopwords"}},
+			{Name: "french_pipeline", Steps: []string{"detect_language", "tokenize", "remove_stopwords"}},
+		},
+		LanguagePipelines: map[string]string{"fr": "french_pipeline"},
+	}
+
+	// French queries run their pipeline, without lowercasing, and drop French stopwords.
+	sq, err := ProcessClientQueryWithOptions("Chaussures pour le Marathon", cfg, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "fr", sq.Language)
+	assert.Equal(t, "Chaussures Marathon", sq.ProcessedQuery)
+
+	// An explicit language overrides the detected one.
+	sq, err = ProcessClientQueryWithOptions("die Schuhe für den Marathon", cfg, ProcessOptions{Language: "DE"})
+	require.NoError(t, err)
+	assert.Equal(t, "de", sq.Language)
+	assert.Equal(t, "schuhe marathon", sq.ProcessedQuery)
+
+	// A named pipeline wins over the language's.
+	sq, err = ProcessClientQueryWithOptions("Chaussures pour le Marathon", cfg, ProcessOptions{Pipeline: "default_pipeline", Language: "fr"})
+	require.NoError(t, err)
+	assert.Equal(t, "chaussures marathon", sq.ProcessedQuery)
+
+	// Short and ambiguous English queries aren't taken for another language.
+	cfg.LanguageDetection = config.LanguageDetectionConfig{MinConfidence: 0.95, MinTokens: 3}
+	for _, query := range []string{"die hard", "red shoes under $50"} {
+		sq, err = ProcessClientQueryWithOptions(query, cfg, ProcessOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "en", sq.Language, query)
+	}
+	sq, err = ProcessClientQueryWithOptions("die hard", cfg, ProcessOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "die hard", sq.ProcessedQuery)
+}
+
+func TestProcessClientQueryWithOptions_Explain(t *testing.T) {
+	cfg := &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"lowercase", "rewrite_query", "tokenize"}},
+		},
+		RewriteRules: []config.RewriteRule{
+			{Name: "sneakers", Match: "literal", Pattern: "sneakers", Replace: "running shoes"},
+		},
+	}
+
+	sq, err := ProcessClientQueryWithOptions("Red Sneakers", cfg, ProcessOptions{Explain: true})
+	require.NoError(t, err)
+	assert.Equal(t, "red running shoes", sq.ProcessedQuery)
+	require.Len(t, sq.Rewrites, 1)
+	assert.Equal(t, "sneakers", sq.Rewrites[0].Rule)
+
+	sq, err = ProcessClientQueryStructured("Red Sneakers", cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "red running shoes", sq.ProcessedQuery)
+	assert.Empty(t, sq.Rewrites, "rewrites are only reported in explain mode")
+}
+
+func TestProcessClientQueryStructured_QuerySyntax(t *testing.T) {
+	cfg := &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"parse_syntax", "lowercase", "tokenize", "remove_stopwords"}},
+		},
+		QuerySyntax: config.QuerySyntaxConfig{Fields: []string{"title"}},
+	}
+
+	sq, err := ProcessClientQueryStructured(`title:"Red Shoes" OR boots -used`, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"red", "shoes", "boots"}, sq.Keywords)
+	require.NotNil(t, sq.Query)
+	assert.Equal(t, processing.NodeBool, sq.Query.Type)
+	require.Len(t, sq.Query.Should, 2)
+	assert.Equal(t, &processing.QueryNode{Type: processing.NodePhrase, Field: "title", Text: "Red Shoes"}, sq.Query.Should[0])
+
+	sq, err = ProcessClientQueryStructured("red shoes", cfg)
+	require.NoError(t, err)
+	assert.Nil(t, sq.Query, "plain keyword queries have no query tree")
+}
+
+func TestProcessClientQueryWithOptions_Lexicon(t *testing.T) {
+	l, err := lexicon.New(lexicon.NewMemoryStore())
+	require.NoError(t, err)
+	SetLexicon(l)
+	t.Cleanup(func() { SetLexicon(nil) })
+	cfg := &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"detect_language", "lowercase", "tokenize", "remove_stopwords", "synonym_expansion"}},
+		},
+	}
+
+	// Without a matching list, the default stopwords apply.
+	sq, err := ProcessClientQueryWithOptions("The cheap TV", cfg, ProcessOptions{Collection: "products"})
+	require.NoError(t, err)
+	assert.Equal(t, "cheap tv", sq.ProcessedQuery)
+
+	// Changes apply to the next query.
+	_, err = l.PutStopwordList(lexicon.StopwordList{Scope: lexicon.Scope{Collection: "products"}, Words: []string{"the", "cheap"}})
+	require.NoError(t, err)
+	_, err = l.CreateSynonymSet(lexicon.SynonymSet{ID: "tv", Scope: lexicon.Scope{Collection: "products"}, Terms: []string{"tv", "television"}})
+	require.NoError(t, err)
+
+	sq, err = ProcessClientQueryWithOptions("The cheap TV", cfg, ProcessOptions{Collection: "products"})
+	require.NoError(t, err)
+	assert.Equal(t, "tv television", sq.ProcessedQuery)
+
+	sq, err = ProcessClientQueryWithOptions("The cheap TV", cfg, ProcessOptions{Collection: "articles"})
+	require.NoError(t, err)
+	assert.Equal(t, "cheap tv", sq.ProcessedQuery)
+}
+
+func TestDebugPipeline(t *testing.T) {
+	cfg := &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"detect_language", "lowercase", "tokenize", "remove_stopwords"}},
+		},
+		RewriteRules: []config.RewriteRule{{Name: "broken", Match: "regex", Pattern: "("}},
+	}
+
+	debug, err := DebugPipeline(context.Background(), "Where is the Best Pizza", cfg, DebugOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "default_pipeline", debug.Pipeline)
+	require.Len(t, debug.Stages, 4)
+	assert.Equal(t, "detect_language", debug.Stages[0].Stage)
+	assert.Equal(t, "en", debug.Stages[0].Annotations[processing.AnnotationLanguage])
+	assert.Equal(t, StageDebug{Stage: "lowercase", Input: "Where is the Best Pizza", Output: "where is the best pizza", TookMs: debug.Stages[1].TookMs}, debug.Stages[1])
+	assert.Equal(t, "where best pizza", debug.Stages[3].Output)
+	require.NotNil(t, debug.Result)
+	assert.Equal(t, "where best pizza", debug.Result.ProcessedQuery)
+	assert.Empty(t, debug.Error)
+
+	debug, err = DebugPipeline(context.Background(), "Best Pizza", cfg, DebugOptions{Steps: []string{"lowercase", "rewrite_query", "tokenize"}})
+	require.NoError(t, err)
+	assert.Equal(t, "ad_hoc", debug.Pipeline)
+	require.Len(t, debug.Stages, 2)
+	assert.NotEmpty(t, debug.Stages[1].Error)
+	assert.NotEmpty(t, debug.Error)
+	assert.Nil(t, debug.Result)
+
+	_, err = DebugPipeline(context.Background(), "pizza", cfg, DebugOptions{Steps: []string{"lowercase", "stem"}})
+	assert.ErrorIs(t, err, processing.ErrUnknownStage)
+	_, err = DebugPipeline(context.Background(), "pizza", cfg, DebugOptions{ProcessOptions: ProcessOptions{Pipeline: "missing"}})
+	assert.ErrorIs(t, err, ErrUnknownPipeline)
+}
+
+func TestProcessClientQueryStructured_Embedding(t *testing.T) {
+	cfg := &config.Configuration{
+		QueryPlanningPipelines: []config.QueryPlanningPipeline{
+			{Name: "default_pipeline", Steps: []string{"lowercase", "tokenize", "embed_query"}},
+		},
+		VectorCollections: []string{"products"},
+	}
+	products := ProcessOptions{Collection: "products"}
+
+	sq, err := ProcessClientQueryWithOptions("Red Shoes", cfg, products)
+	require.NoError(t, err)
+	assert.Nil(t, sq.Embedding, "Expected no embedding without an embedder")
+
+	SetEmbedder(processing.HashEmbedder{Dims: 16})
+	defer SetEmbedder(nil)
+	sq, err = ProcessClientQueryWithOptions("Red Shoes", cfg, products)
+	require.NoError(t, err)
+	want, _ := processing.HashEmbedder{Dims: 16}.Embed(context.Background(), "red shoes")
+	assert.Equal(t, want, sq.Embedding)
+	assert.Equal(t, want, sq.Proto().GetEmbedding())
+
+	// Collections without vector fields skip the embedding model.
+	for _, opts := range []ProcessOptions{{Collection: "articles"}, {}} {
+		sq, err = ProcessClientQueryWithOptions("Red Shoes", cfg, opts)
+		require.NoError(t, err)
+		assert.Nil(t, sq.Embedding, "collection %q", opts.Collection)
+	}
+}
@@ -0,0 +1,62 @@
+package query_understanding
+
+import (
+	"sync/atomic"
+
+	"query_understanding/config"
+	"query_understanding/lexicon"
+)
+
+// LiveConfiguration is the configuration queries are processed with while the service
+// runs. Reload replaces it, together with the default stopwords and the lexicon, once
+// every part of the new configuration has loaded, so queries never see a mix of both.
+type LiveConfiguration struct {
+	path    string
+	lexicon *lexicon.Lexicon
+	current atomic.Pointer[config.Configuration]
+}
+
+// NewLiveConfiguration loads the configuration file at path like LoadConfiguration.
+// Reloads also reload lex from its store, if it isn't nil.
+func NewLiveConfiguration(path string, lex *lexicon.Lexicon) (*LiveConfiguration, error) {
+	cfg, err := LoadConfiguration(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &LiveConfiguration{path: path, lexicon: lex}
+	l.current.Store(cfg)
+	return l, nil
+}
+
+// Get returns the current configuration, which must not be modified.
+func (l *LiveConfiguration) Get() *config.Configuration {
+	return l.current.Load()
+}
+
+// Reload re-reads the configuration file, the default and per-language stopwords and the
+// lexicon. If any of them fails to load or validate, the running configuration is kept
+// and the error returned.
+func (l *LiveConfiguration) Reload() error {
+	cfg, err := LoadConfiguration(l.path)
+	if err != nil {
+		return err
+	}
+	stopwords, err := loadStopwords(stopwordsFilePath)
+	if err != nil {
+		return err
+	}
+	byLanguage, err := loadLanguageStopwords(stopwordsDir)
+	if err != nil {
+		return err
+	}
+	if l.lexicon != nil {
+		// The lexicon is the last to load and is only replaced if its store loads.
+		if err := l.lexicon.Reload(); err != nil {
+			return err
+		}
+	}
+	defaultStopwords.Store(&stopwords)
+	languageStopwords.Store(&byLanguage)
+	l.current.Store(cfg)
+	return nil
+}
@@ -0,0 +1,49 @@
+package query_understanding
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reloadTestConfig = `
+index_schemas:
+  - name: products
+    fields:
+      - name: name
+        type: text
+query_planning_pipelines:
+  - name: default_pipeline
+    steps: [%s]
+`
+
+func writeReloadTestConfig(t *testing.T, path, steps string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(reloadTestConfig, steps)), 0o644))
+}
+
+func TestLiveConfiguration_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadTestConfig(t, path, "lowercase")
+	live, err := NewLiveConfiguration(path, nil)
+	require.NoError(t, err)
+	sq, err := ProcessClientQueryStructured("Red Shoes", live.Get())
+	require.NoError(t, err)
+	assert.Equal(t, "red shoes", sq.ProcessedQuery)
+
+	// An unknown stage is rejected and the running configuration kept.
+	writeReloadTestConfig(t, path, "lowercase, spell_check")
+	err = live.Reload()
+	assert.ErrorContains(t, err, "unknown step 'spell_check'")
+	assert.Equal(t, []string{"lowercase"}, live.Get().QueryPlanningPipelines[0].Steps)
+
+	writeReloadTestConfig(t, path, "lowercase, tokenize")
+	require.NoError(t, live.Reload())
+	sq, err = ProcessClientQueryStructured("Red Shoes", live.Get())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"red", "shoes"}, sq.Keywords)
+}
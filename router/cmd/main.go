@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/config"
+	"common/graceful"
+	"common/logging"
+	"common/shard"
+	"common/tlsconfig"
+	"router"
+)
+
+// Config holds the router's settings, read from a YAML file (-config-file), environment
+// variables and flags, in increasing order of precedence.
+type Config struct {
+	ListenAddr      string           `yaml:"listen_addr" env:"LISTEN_ADDR" flag:"listen-addr" usage:"Address to listen on"`
+	Indexers        string           `yaml:"indexers" env:"INDEXERS" flag:"indexers" usage:"Comma-separated shardID=url indexers, numbering the shards from 0"`
+	RoutingTable    string           `yaml:"routing_table" env:"ROUTING_TABLE" flag:"routing-table" usage:"Routing table file written by resharding, used instead of indexers and reloaded when replaced"`
+	RoutingReload   time.Duration    `yaml:"routing_reload" env:"ROUTING_RELOAD" flag:"routing-reload" usage:"How often the routing table file is checked for a new version"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"How long in-flight writes are drained on shutdown"`
+	TLS             tlsconfig.Config `yaml:"tls"`
+	// Log sets the level and format of the logs; the level can be changed at runtime
+	// through /admin/log-level.
+	Log logging.Config `yaml:"log"`
+}
+
+// parseIndexers parses a comma-separated list of shardID=url pairs.
+func parseIndexers(s string) (map[int]string, error) {
+	indexers := make(map[int]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, url, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid indexer %q, expected shardID=url", entry)
+		}
+		shardID, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shard ID in %q: %w", entry, err)
+		}
+		if _, dup := indexers[shardID]; dup {
+			return nil, fmt.Errorf("shard %d is listed twice", shardID)
+		}
+		indexers[shardID] = url
+	}
+	return indexers, nil
+}
+
+func main() {
+	cfg := Config{
+		ListenAddr:      ":8083",
+		RoutingReload:   10 * time.Second,
+		ShutdownTimeout: graceful.DefaultTimeout,
+		Log:             logging.DefaultConfig(),
+	}
+	config.MustLoad(&cfg)
+	if err := logging.Setup(cfg.Log); err != nil {
+		log.Fatalf("Invalid log configuration: %v", err)
+	}
+
+	indexers, err := parseIndexers(cfg.Indexers)
+	if err != nil {
+		log.Fatalf("Invalid indexers: %v", err)
+	}
+	if cfg.RoutingTable != "" {
+		table, err := shard.ReadRoutingTable(cfg.RoutingTable)
+		if err != nil {
+			log.Fatalf("Invalid routing table: %v", err)
+		}
+		indexers = make(map[int]string, len(table.Shards))
+		for i, u := range table.Shards {
+			indexers[i] = u
+		}
+	}
+	transport, err := tlsconfig.ClientTransport(cfg.TLS)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	// Writes made through the router's Go API carry the request ID of their context too.
+	client := &http.Client{Transport: logging.Transport(transport)}
+	r, err := router.New(indexers, client)
+	if err != nil {
+		log.Fatalf("Invalid indexers: %v", err)
+	}
+	if cfg.RoutingTable != "" {
+		if _, err := r.LoadRoutingTable(cfg.RoutingTable); err != nil {
+			log.Fatalf("Invalid routing table: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.WatchRoutingTable(ctx, cfg.RoutingTable, cfg.RoutingReload)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", r.Handler())
+	mux.Handle(logging.LevelPath, logging.LevelHandler())
+	// Forwarded writes carry the request ID of the client's request, or one given by the router.
+	server, err := tlsconfig.NewServer(cfg.ListenAddr, logging.Middleware(mux), cfg.TLS)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	slog.Info("Router listening", "addr", cfg.ListenAddr, "shards", r.Shards())
+	if err := graceful.Serve(server, cfg.ShutdownTimeout); err != nil {
+		log.Fatalf("Router failed: %v", err)
+	}
+	slog.Info("Router stopped")
+}
@@ -0,0 +1,9 @@
+module router
+
+go 1.21
+
+require common v0.0.0
+
+require gopkg.in/yaml.v2 v2.4.0 // indirect
+
+replace common => ../common
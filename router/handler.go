@@ -0,0 +1,209 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"common/shard"
+)
+
+// ShardHeader reports the shard a single-document write was routed to.
+const ShardHeader = "X-Shard-ID"
+
+// Handler returns the HTTP API of the router, mirroring the indexers' write endpoints:
+// /index and /delete are forwarded to the shard of the document, /bulk_index is split by
+// shard. Query parameters and headers, such as the tenant and the ingest pipeline, are
+// forwarded as they are. While a resharding is under way, writes are then mirrored to the
+// new shards, and fail if they can't be.
+func (r *Router) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index", r.handleDocument)
+	mux.HandleFunc("/delete", r.handleDocument)
+	mux.HandleFunc("/bulk_index", r.handleBulkIndex)
+	return mux
+}
+
+// readBody reads the body of a POST request, writing the error response of other
+// requests.
+func readBody(w http.ResponseWriter, req *http.Request) ([]byte, bool) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return nil, false
+	}
+	return body, true
+}
+
+// handleDocument forwards an index or delete request to the shard of its document and
+// relays the indexer's response.
+func (r *Router) handleDocument(w http.ResponseWriter, req *http.Request) {
+	body, ok := readBody(w, req)
+	if !ok {
+		return
+	}
+	var doc struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if doc.ID == "" {
+		http.Error(w, "Document ID is required", http.StatusBadRequest)
+		return
+	}
+	s := r.ShardFor(doc.ID)
+	res, err := r.forward(req.Context(), s, req.URL.Path, req.URL.Query(), req.Header, body)
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Error routing document", "path", req.URL.Path, "id", doc.ID, "error", err)
+		http.Error(w, fmt.Sprintf("Failed to reach the indexer of shard %d", s), http.StatusBadGateway)
+		return
+	}
+	if next := r.next.Load(); next != nil && res.status == http.StatusOK {
+		ns := shard.For(doc.ID, len(*next))
+		mirrored, err := r.forwardTo(req.Context(), *next, ns, req.URL.Path, req.URL.Query(), req.Header, body)
+		if err == nil && mirrored.status != http.StatusOK {
+			err = fmt.Errorf("new shard %d answered %d: %s", ns, mirrored.status, strings.TrimSpace(string(mirrored.body)))
+		}
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error mirroring document to the new shards", "path", req.URL.Path, "id", doc.ID, "error", err)
+			http.Error(w, fmt.Sprintf("Failed to mirror the write to new shard %d", ns), http.StatusBadGateway)
+			return
+		}
+	}
+	for name, values := range res.header {
+		if name != "Content-Length" {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set(ShardHeader, strconv.Itoa(s))
+	w.WriteHeader(res.status)
+	w.Write(res.body)
+}
+
+// ShardResult is the outcome of the part of a bulk request routed to a shard.
+type ShardResult struct {
+	Shard     int    `json:"shard"`
+	Documents int    `json:"documents"`
+	Status    int    `json:"status"`          // Status of the indexer's response; 0 if it wasn't reached
+	Error     string `json:"error,omitempty"` // Response of a failed write
+
+	docs map[string]json.RawMessage
+}
+
+// BulkResponse is the response of the router to a bulk index request.
+type BulkResponse struct {
+	Documents int           `json:"documents"`
+	Shards    []ShardResult `json:"shards"`
+	Mirrors   []ShardResult `json:"mirrors,omitempty"` // Writes mirrored to the new shards of a resharding
+}
+
+// handleBulkIndex splits a bulk index request by shard and forwards the parts
+// concurrently. The documents the shards indexed are then mirrored to the new shards, if
+// a resharding is under way. It answers 200 if every shard indexed its documents, 207 if
+// indexers reported documents they failed on, 502 if a shard failed as a whole, with the
+// outcome of every shard.
+func (r *Router) handleBulkIndex(w http.ResponseWriter, req *http.Request) {
+	body, ok := readBody(w, req)
+	if !ok {
+		return
+	}
+	var docs map[string]json.RawMessage
+	if err := json.Unmarshal(body, &docs); err != nil {
+		http.Error(w, "Error parsing request body: invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(docs) == 0 {
+		http.Error(w, "Request body is empty", http.StatusBadRequest)
+		return
+	}
+	resp := BulkResponse{Documents: len(docs)}
+	resp.Shards = r.forwardBulk(req, *r.indexers.Load(), docs)
+	if next := r.next.Load(); next != nil {
+		indexed := make(map[string]json.RawMessage, len(docs))
+		for _, result := range resp.Shards {
+			// Documents an indexer failed on fail alike on the new shards, so a partial write
+			// is mirrored whole.
+			if result.Status == http.StatusOK || result.Status == http.StatusMultiStatus {
+				for id, doc := range result.docs {
+					indexed[id] = doc
+				}
+			}
+		}
+		if len(indexed) > 0 {
+			resp.Mirrors = r.forwardBulk(req, *next, indexed)
+		}
+	}
+
+	status := http.StatusOK
+	for _, result := range resp.Shards {
+		if result.Status == http.StatusMultiStatus && status == http.StatusOK {
+			status = http.StatusMultiStatus
+		} else if result.Status != http.StatusOK && result.Status != http.StatusMultiStatus {
+			status = http.StatusBadGateway
+			slog.ErrorContext(req.Context(), "Bulk index failed on a shard", "shard", result.Shard, "documents", result.Documents, "error", result.Error)
+		}
+	}
+	for _, result := range resp.Mirrors {
+		if result.Status != http.StatusOK && result.Status != http.StatusMultiStatus {
+			status = http.StatusBadGateway
+			slog.ErrorContext(req.Context(), "Bulk index failed to mirror to a new shard", "shard", result.Shard, "documents", result.Documents, "error", result.Error)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// forwardBulk splits docs by their shard of indexers and forwards the parts of the bulk
+// request concurrently, returning the outcome of every shard, sorted.
+func (r *Router) forwardBulk(req *http.Request, indexers shard.Table, docs map[string]json.RawMessage) []ShardResult {
+	byShard := make(map[int]map[string]json.RawMessage)
+	for id, doc := range docs {
+		s := shard.For(id, len(indexers))
+		if byShard[s] == nil {
+			byShard[s] = make(map[string]json.RawMessage)
+		}
+		byShard[s][id] = doc
+	}
+
+	var (
+		results []ShardResult
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	for s, shardDocs := range byShard {
+		wg.Add(1)
+		go func(s int, shardDocs map[string]json.RawMessage) {
+			defer wg.Done()
+			result := ShardResult{Shard: s, Documents: len(shardDocs), docs: shardDocs}
+			part, _ := json.Marshal(shardDocs)
+			res, err := r.forwardTo(req.Context(), indexers, s, "/bulk_index", req.URL.Query(), req.Header, part)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Status = res.status
+				if res.status != http.StatusOK {
+					result.Error = string(res.body)
+				}
+			}
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}(s, shardDocs)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Shard < results[j].Shard })
+	return results
+}
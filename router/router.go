@@ -0,0 +1,217 @@
+// Package router routes the writes of documents to the indexer of their shard, hashing
+// document IDs like common/shard, so the write topology matches the broker's read
+// sharding. It can be embedded as a library or run as a standalone service in front of
+// the indexers, accepting their /index, /delete and /bulk_index requests.
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"common/shard"
+	"common/tenant"
+)
+
+// ErrShardWrite is returned when an indexer rejects the writes routed to its shard.
+var ErrShardWrite = errors.New("shard write failed")
+
+// Router forwards writes to the indexers of their documents' shards.
+type Router struct {
+	indexers atomic.Pointer[shard.Table] // Base URLs of the indexers by shard
+	next     atomic.Pointer[shard.Table] // Indexers of the shards a resharding migrates to; nil if none
+	version  atomic.Int64                // Version of the routing table loaded last, if any
+	client   *http.Client
+}
+
+// New returns a router writing to the indexers of indexers, keyed by shard ID, which must
+// number the shards from 0 without gaps. A nil client uses http.DefaultClient.
+func New(indexers map[int]string, client *http.Client) (*Router, error) {
+	table, err := shard.NewTable(indexers)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r := &Router{client: client}
+	r.SetTable(table)
+	return r, nil
+}
+
+// SetTable replaces the indexers of the shards, as after a resharding. Writes already
+// routed complete on the indexers they were routed to.
+func (r *Router) SetTable(table shard.Table) {
+	r.indexers.Store(trimTable(table))
+}
+
+// SetNextTable makes the router mirror every write to the indexer of its document's
+// shard in table, once the current shard applied it, while a resharding builds the shards
+// of table; nil stops mirroring.
+func (r *Router) SetNextTable(table shard.Table) {
+	if len(table) == 0 {
+		r.next.Store(nil)
+		return
+	}
+	r.next.Store(trimTable(table))
+}
+
+// trimTable returns a copy of table without trailing slashes in its URLs.
+func trimTable(table shard.Table) *shard.Table {
+	trimmed := make(shard.Table, len(table))
+	for i, u := range table {
+		trimmed[i] = strings.TrimSuffix(u, "/")
+	}
+	return &trimmed
+}
+
+// Shards returns the number of shards.
+func (r *Router) Shards() int {
+	return len(*r.indexers.Load())
+}
+
+// ShardFor returns the shard of the document id.
+func (r *Router) ShardFor(id string) int {
+	return shard.For(id, r.Shards())
+}
+
+// shardResponse is the response of an indexer to a forwarded request.
+type shardResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// forward posts body to path of the indexer of shard s, with the query and headers of the
+// original request.
+func (r *Router) forward(ctx context.Context, s int, path string, query url.Values, header http.Header, body []byte) (*shardResponse, error) {
+	return r.forwardTo(ctx, *r.indexers.Load(), s, path, query, header, body)
+}
+
+// forwardTo is forward to the indexers of a table.
+func (r *Router) forwardTo(ctx context.Context, indexers shard.Table, s int, path string, query url.Values, header http.Header, body []byte) (*shardResponse, error) {
+	if s >= len(indexers) {
+		return nil, fmt.Errorf("%w: shard %d was removed by a resharding", ErrShardWrite, s)
+	}
+	target := indexers[s] + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		if name != "Content-Length" {
+			req.Header[name] = values
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: shard %d: %v", ErrShardWrite, s, err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: shard %d: failed to read the response: %v", ErrShardWrite, s, err)
+	}
+	return &shardResponse{status: res.StatusCode, header: res.Header, body: data}, nil
+}
+
+// post forwards a write built by the router to shard s of indexers and checks its status.
+func (r *Router) post(ctx context.Context, indexers shard.Table, tenantID string, s int, path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if tenantID != "" && tenantID != tenant.Default {
+		header.Set(tenant.Header, tenantID)
+	}
+	res, err := r.forwardTo(ctx, indexers, s, path, nil, header, body)
+	if err != nil {
+		return err
+	}
+	if res.status != http.StatusOK {
+		return fmt.Errorf("%w: shard %d answered %d: %s", ErrShardWrite, s, res.status, strings.TrimSpace(string(res.body)))
+	}
+	return nil
+}
+
+// postDocument forwards a write of the document id to its shard, then mirrors it to its
+// shard in the next table, if any.
+func (r *Router) postDocument(ctx context.Context, tenantID, id, path string, payload interface{}) error {
+	if err := r.post(ctx, *r.indexers.Load(), tenantID, r.ShardFor(id), path, payload); err != nil {
+		return err
+	}
+	if next := r.next.Load(); next != nil {
+		if err := r.post(ctx, *next, tenantID, shard.For(id, len(*next)), path, payload); err != nil {
+			return fmt.Errorf("failed to mirror the write to the new shards: %w", err)
+		}
+	}
+	return nil
+}
+
+// Index indexes a document of a tenant, empty for the default one, on its shard.
+func (r *Router) Index(ctx context.Context, tenantID, id string, data interface{}) error {
+	return r.postDocument(ctx, tenantID, id, "/index", map[string]interface{}{"id": id, "data": data})
+}
+
+// Delete deletes a document of a tenant from its shard.
+func (r *Router) Delete(ctx context.Context, tenantID, id string) error {
+	return r.postDocument(ctx, tenantID, id, "/delete", map[string]string{"id": id})
+}
+
+// BulkIndex indexes documents of a tenant, keyed by ID, sending each shard its documents
+// in one bulk request, then mirrors them to their shards in the next table, if any. The
+// shards are written concurrently; the errors of those that failed are joined.
+func (r *Router) BulkIndex(ctx context.Context, tenantID string, docs map[string]interface{}) error {
+	if err := r.bulkIndex(ctx, *r.indexers.Load(), tenantID, docs); err != nil {
+		return err
+	}
+	if next := r.next.Load(); next != nil {
+		if err := r.bulkIndex(ctx, *next, tenantID, docs); err != nil {
+			return fmt.Errorf("failed to mirror the writes to the new shards: %w", err)
+		}
+	}
+	return nil
+}
+
+// bulkIndex writes docs to their shards of indexers.
+func (r *Router) bulkIndex(ctx context.Context, indexers shard.Table, tenantID string, docs map[string]interface{}) error {
+	byShard := make(map[int]map[string]interface{})
+	for id, doc := range docs {
+		s := shard.For(id, len(indexers))
+		if byShard[s] == nil {
+			byShard[s] = make(map[string]interface{})
+		}
+		byShard[s][id] = doc
+	}
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for s, shardDocs := range byShard {
+		wg.Add(1)
+		go func(s int, shardDocs map[string]interface{}) {
+			defer wg.Done()
+			if err := r.post(ctx, indexers, tenantID, s, "/bulk_index", shardDocs); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(s, shardDocs)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
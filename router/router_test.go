@@ -0,0 +1,244 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"common/logging"
+	"common/shard"
+	"common/tenant"
+)
+
+// fakeIndexer records the documents written to a shard.
+type fakeIndexer struct {
+	mu         sync.Mutex
+	docs       map[string]bool
+	tenants    []string
+	requestIDs []string
+	fail       bool
+	partial    bool // Bulk writes report some documents failed
+}
+
+func (f *fakeIndexer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		http.Error(w, "Failed to bulk index documents", http.StatusInternalServerError)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.tenants = append(f.tenants, r.Header.Get(tenant.Header))
+	f.requestIDs = append(f.requestIDs, r.Header.Get(logging.RequestIDHeader))
+	switch r.URL.Path {
+	case "/index", "/delete":
+		var doc struct{ ID string }
+		json.Unmarshal(body, &doc)
+		f.docs[doc.ID] = r.URL.Path == "/index"
+		w.Header().Set("X-Document-Version", "1")
+	case "/bulk_index":
+		var docs map[string]json.RawMessage
+		json.Unmarshal(body, &docs)
+		for id := range docs {
+			f.docs[id] = true
+		}
+		if f.partial {
+			w.WriteHeader(http.StatusMultiStatus)
+		}
+	}
+	w.Write([]byte("ok"))
+}
+
+func newTestRouter(t *testing.T) (*Router, []*fakeIndexer) {
+	t.Helper()
+	fakes := make([]*fakeIndexer, 3)
+	urls := make(map[int]string)
+	for i := range fakes {
+		fakes[i] = &fakeIndexer{docs: map[string]bool{}}
+		server := httptest.NewServer(fakes[i])
+		t.Cleanup(server.Close)
+		urls[i] = server.URL
+	}
+	r, err := New(urls, nil)
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	return r, fakes
+}
+
+func TestRouter_Library(t *testing.T) {
+	r, fakes := newTestRouter(t)
+	ctx := context.Background()
+	if err := r.Index(ctx, "acme", "doc-1", map[string]string{"title": "a"}); err != nil {
+		t.Fatalf("Index returned an error: %v", err)
+	}
+	s := r.ShardFor("doc-1")
+	if !fakes[s].docs["doc-1"] || fakes[s].tenants[0] != "acme" {
+		t.Errorf("Expected doc-1 to be indexed for acme on shard %d, got %v %v", s, fakes[s].docs, fakes[s].tenants)
+	}
+	if err := r.Delete(ctx, "", "doc-1"); err != nil || fakes[s].docs["doc-1"] {
+		t.Errorf("Expected doc-1 to be deleted, got %v", err)
+	}
+
+	docs := make(map[string]interface{})
+	for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		docs[id] = map[string]string{"title": id}
+	}
+	if err := r.BulkIndex(ctx, "", docs); err != nil {
+		t.Fatalf("BulkIndex returned an error: %v", err)
+	}
+	for id := range docs {
+		if !fakes[r.ShardFor(id)].docs[id] {
+			t.Errorf("Expected %s on shard %d", id, r.ShardFor(id))
+		}
+	}
+}
+
+func TestRouter_Handler(t *testing.T) {
+	r, fakes := newTestRouter(t)
+	handler := r.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/index?pipeline=logs", strings.NewReader(`{"id": "doc-1", "data": {}}`)))
+	s := r.ShardFor("doc-1")
+	if rec.Code != http.StatusOK || rec.Header().Get(ShardHeader) != strconv.Itoa(s) || rec.Header().Get("X-Document-Version") != "1" {
+		t.Errorf("Expected the indexer's response with the shard, got %d %v", rec.Code, rec.Header())
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/index", strings.NewReader(`{"data": {}}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without an ID, got %d", rec.Code)
+	}
+
+	fakes[0].fail = true
+	bulk := make(map[string]interface{})
+	for i := 0; i < 30; i++ {
+		bulk[fmt.Sprintf("doc-%d", i)] = map[string]string{}
+	}
+	body, _ := json.Marshal(bulk)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/bulk_index", bytes.NewReader(body)))
+	var resp BulkResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode the bulk response %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusBadGateway || resp.Documents != 30 || len(resp.Shards) != 3 {
+		t.Fatalf("Expected a 502 reporting the 3 shards, got %d %+v", rec.Code, resp)
+	}
+	if resp.Shards[0].Status != http.StatusInternalServerError || resp.Shards[1].Status != http.StatusOK {
+		t.Errorf("Expected only shard 0 to fail, got %+v", resp.Shards)
+	}
+
+	// Documents an indexer failed on make the bulk write partial, not failed.
+	fakes[0].fail, fakes[1].partial = false, true
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/bulk_index", bytes.NewReader(body)))
+	if rec.Code != http.StatusMultiStatus {
+		t.Errorf("Expected a 207 for documents an indexer failed on, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouter_Handler_RequestID(t *testing.T) {
+	r, fakes := newTestRouter(t)
+	handler := logging.Middleware(r.Handler())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/index", strings.NewReader(`{"id": "doc-1", "data": {}}`)))
+	id := rec.Header().Get(logging.RequestIDHeader)
+	s := r.ShardFor("doc-1")
+	if id == "" || len(fakes[s].requestIDs) != 1 || fakes[s].requestIDs[0] != id {
+		t.Errorf("Expected the request ID %q given by the router to reach the indexer, got %v", id, fakes[s].requestIDs)
+	}
+}
+
+func TestRouter_LoadRoutingTable(t *testing.T) {
+	r, fakes := newTestRouter(t)
+	path := filepath.Join(t.TempDir(), "routing.json")
+	single := httptest.NewServer(fakes[0])
+	t.Cleanup(single.Close)
+	if err := shard.WriteRoutingTable(path, &shard.RoutingTable{Version: 2, Shards: shard.Table{single.URL + "/"}}); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := r.LoadRoutingTable(path); err != nil || !changed {
+		t.Fatalf("Expected the routing table to be loaded, got %v %v", changed, err)
+	}
+	if changed, err := r.LoadRoutingTable(path); err != nil || changed {
+		t.Errorf("Expected the same version not to be reloaded, got %v %v", changed, err)
+	}
+	if r.Shards() != 1 {
+		t.Fatalf("Expected 1 shard after loading the table, got %d", r.Shards())
+	}
+	if err := r.Index(context.Background(), "", "doc-9", map[string]string{}); err != nil || !fakes[0].docs["doc-9"] {
+		t.Errorf("Expected doc-9 on the only shard, got %v", err)
+	}
+}
+
+func TestRouter_MirrorsWritesToNextShards(t *testing.T) {
+	r, fakes := newTestRouter(t)
+	next := make([]*fakeIndexer, 2)
+	table := make(shard.Table, len(next))
+	for i := range next {
+		next[i] = &fakeIndexer{docs: map[string]bool{}}
+		server := httptest.NewServer(next[i])
+		t.Cleanup(server.Close)
+		table[i] = server.URL
+	}
+	path := filepath.Join(t.TempDir(), "routing.json")
+	if err := shard.WriteRoutingTable(path, &shard.RoutingTable{Version: 2, Shards: *r.indexers.Load(), Next: table}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LoadRoutingTable(path); err != nil {
+		t.Fatalf("LoadRoutingTable returned an error: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := r.Index(ctx, "", "doc-1", map[string]string{}); err != nil {
+		t.Fatalf("Index returned an error: %v", err)
+	}
+	if !fakes[r.ShardFor("doc-1")].docs["doc-1"] || !next[shard.For("doc-1", 2)].docs["doc-1"] {
+		t.Errorf("Expected doc-1 on its current and its new shard")
+	}
+	handler := r.Handler()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/delete", strings.NewReader(`{"id": "doc-1"}`)))
+	if rec.Code != http.StatusOK || next[shard.For("doc-1", 2)].docs["doc-1"] {
+		t.Errorf("Expected the deletion to be mirrored, got %d", rec.Code)
+	}
+
+	bulk := make(map[string]interface{})
+	for i := 0; i < 30; i++ {
+		bulk[fmt.Sprintf("doc-%d", i)] = map[string]string{}
+	}
+	body, _ := json.Marshal(bulk)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/bulk_index", bytes.NewReader(body)))
+	var resp BulkResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || len(resp.Mirrors) != 2 {
+		t.Fatalf("Expected the bulk to be mirrored to the 2 new shards, got %d %s", rec.Code, rec.Body.String())
+	}
+	for id := range bulk {
+		if !next[shard.For(id, 2)].docs[id] {
+			t.Errorf("Expected %s on new shard %d", id, shard.For(id, 2))
+		}
+	}
+
+	next[0].fail = true
+	if err := r.BulkIndex(ctx, "", bulk); err == nil {
+		t.Error("Expected an error when a new shard fails")
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/bulk_index", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 when a new shard fails, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,45 @@
+package router
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"common/shard"
+)
+
+// LoadRoutingTable replaces the indexers of the shards with the routing table at path,
+// unless its version is already loaded, reporting whether it changed. Writes are mirrored
+// to the next shards of the table, if it has any.
+func (r *Router) LoadRoutingTable(path string) (bool, error) {
+	table, err := shard.ReadRoutingTable(path)
+	if err != nil {
+		return false, err
+	}
+	if int64(table.Version) == r.version.Load() {
+		return false, nil
+	}
+	r.SetTable(table.Shards)
+	r.SetNextTable(table.Next)
+	r.version.Store(int64(table.Version))
+	slog.Info("Loaded routing table", "version", table.Version, "shards", len(table.Shards), "next_shards", len(table.Next))
+	return true, nil
+}
+
+// WatchRoutingTable reloads the routing table at path every interval until ctx is done,
+// so writes follow a resharding without restarting the router. Errors keep the current
+// table.
+func (r *Router) WatchRoutingTable(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.LoadRoutingTable(path); err != nil {
+				slog.ErrorContext(ctx, "Failed to reload routing table", "error", err)
+			}
+		}
+	}
+}
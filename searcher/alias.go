@@ -0,0 +1,131 @@
+package searcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// aliasFile is the alias the Indexer uploads next to the segments of a collection whose
+// index was rolled over.
+const aliasFile = "alias.json"
+
+// uploadTimestampLayout is the suffix the Indexer's S3Storage names the uploads of an
+// index with, e.g. index_20240601T120000Z.
+const uploadTimestampLayout = "20060102T150405Z"
+
+// Roles of the physical indexes of an alias.
+const (
+	// RoleWrite is the role of the index receiving the writes of the collection.
+	RoleWrite = "write"
+	// RoleReadOnly is the role of the indexes the write index was rolled over from.
+	RoleReadOnly = "read_only"
+)
+
+// IndexAlias maps an index of the Indexer to its physical indexes, each uploaded as
+// segments of its own name: the write index and the read-only ones it rolled over from.
+type IndexAlias struct {
+	Name       string          `json:"name"`
+	WriteIndex string          `json:"write_index"`
+	Indices    []PhysicalIndex `json:"indices"` // Oldest first
+}
+
+// PhysicalIndex is an index of an alias.
+type PhysicalIndex struct {
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ReadOnly     bool       `json:"read_only"`
+	RolledOverAt *time.Time `json:"rolled_over_at,omitempty"`
+	DocCount     uint64     `json:"doc_count,omitempty"`
+}
+
+// AliasStore is implemented by SegmentStores that hold the alias of the collection.
+type AliasStore interface {
+	// ReadAlias returns the alias, nil if the index was never rolled over.
+	ReadAlias() (*IndexAlias, error)
+}
+
+// ReadAlias returns the alias uploaded next to the segments, nil if there is none.
+func (d *DirSegmentStore) ReadAlias() (*IndexAlias, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, aliasFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the index alias: %w", err)
+	}
+	var alias IndexAlias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the index alias: %w", err)
+	}
+	if err := alias.validate(); err != nil {
+		return nil, err
+	}
+	return &alias, nil
+}
+
+// validate rejects aliases whose index names aren't segment names.
+func (a *IndexAlias) validate() error {
+	if err := validateSegmentName(a.WriteIndex); err != nil {
+		return fmt.Errorf("index alias has an invalid write index: %w", err)
+	}
+	for _, physical := range a.Indices {
+		if err := validateSegmentName(physical.Name); err != nil {
+			return fmt.Errorf("index alias has an invalid index: %w", err)
+		}
+	}
+	return nil
+}
+
+// role returns the role of the physical index named name, "" if it isn't in the alias.
+func (a *IndexAlias) role(name string) string {
+	for _, physical := range a.Indices {
+		switch {
+		case physical.Name != name:
+		case physical.ReadOnly:
+			return RoleReadOnly
+		default:
+			return RoleWrite
+		}
+	}
+	return ""
+}
+
+// uploadIndexName returns the name of the index a segment is an upload of, stripping the
+// timestamp of S3 uploads.
+func uploadIndexName(segment string) string {
+	i := strings.LastIndexByte(segment, '_')
+	if i < 0 {
+		return segment
+	}
+	if _, err := time.Parse(uploadTimestampLayout, segment[i+1:]); err != nil {
+		return segment
+	}
+	return segment[:i]
+}
+
+// aliasSegments returns the segments, oldest first, that hold the physical indexes of
+// alias: the latest upload of each. Earlier uploads are superseded versions of their
+// index, and uploads of indexes outside the alias aren't part of the collection.
+func aliasSegments(segments []SegmentInfo, alias *IndexAlias) []SegmentInfo {
+	latest := make(map[string]SegmentInfo)
+	for _, info := range segments {
+		index := uploadIndexName(info.Name)
+		if alias.role(index) == "" {
+			continue
+		}
+		// Oldest first, so later uploads replace earlier ones.
+		latest[index] = info
+	}
+	kept := make([]SegmentInfo, 0, len(latest))
+	for _, info := range latest {
+		kept = append(kept, info)
+	}
+	sortSegments(kept)
+	return kept
+}
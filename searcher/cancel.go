@@ -0,0 +1,33 @@
+package searcher
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// StatusClientClosedRequest is the status recorded for searches abandoned because their
+// client disconnected. The client never sees it.
+const StatusClientClosedRequest = 499
+
+var cancelledSearchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "searcher_cancelled_searches_total",
+	Help: "Searches aborted because their client disconnected before they completed",
+})
+
+// abortCancelled ends a search that failed because its client disconnected, reporting
+// whether it did. Bleve stops collecting hits once the request context is canceled, and
+// searches still queued by the concurrency limit give up their slot.
+func abortCancelled(c *gin.Context, err error) bool {
+	if err == nil || !errors.Is(c.Request.Context().Err(), context.Canceled) {
+		return false
+	}
+	cancelledSearchesTotal.Inc()
+	slog.InfoContext(c.Request.Context(), "Client disconnected, request aborted", "path", c.Request.URL.Path, "error", err)
+	c.AbortWithStatus(StatusClientClosedRequest)
+	return true
+}
@@ -0,0 +1,57 @@
+package searcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSearchHandler_ClientDisconnect(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	if err := svc.SetConcurrencyLimit(ConcurrencyConfig{MaxConcurrent: 1, MaxQueue: 1}); err != nil {
+		t.Fatalf("SetConcurrencyLimit returned an error: %v", err)
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+	before := testutil.ToFloat64(cancelledSearchesTotal)
+
+	// A search queued behind a running one gives up its slot when its client leaves.
+	release, err := svc.limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire returned an error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sample", nil).WithContext(ctx))
+		close(done)
+	}()
+	for len(svc.limiter.slots) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	release()
+	if rec.Code != StatusClientClosedRequest || len(svc.limiter.slots) != 0 {
+		t.Errorf("Expected the queued search to be aborted with %d, got %d and %d slots taken", StatusClientClosedRequest, rec.Code, len(svc.limiter.slots))
+	}
+	if got := testutil.ToFloat64(cancelledSearchesTotal) - before; got != 1 {
+		t.Errorf("Expected 1 cancelled search to be counted, got %g", got)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sample", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 once the client stays, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
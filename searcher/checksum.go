@@ -0,0 +1,76 @@
+package searcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxFetchAttempts bounds the downloads of a segment found corrupt before it is given up
+// on, keeping the segments fetched before it.
+const maxFetchAttempts = 3
+
+// ErrCorruptSegment is returned for downloaded segments whose files don't match the sizes
+// and checksums of their manifest.
+var ErrCorruptSegment = errors.New("corrupt segment")
+
+// segmentCorruptionsTotal counts the corrupt segment downloads, exposed by promhttp.Handler().
+var segmentCorruptionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "searcher_segment_corruptions_total",
+	Help: "Total number of downloaded segments whose files didn't match their manifest.",
+})
+
+// verifySegment checks the files of a segment directory against the sizes and SHA-256
+// checksums of its manifest. Files listed without a SHA-256, by older Indexers, are only
+// checked for size.
+func verifySegment(segmentDir string, manifest *segmentManifest) error {
+	for _, file := range manifest.Files {
+		path := filepath.Join(segmentDir, filepath.FromSlash(file.Path))
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("%w: segment %s is missing %s: %v", ErrCorruptSegment, segmentDir, file.Path, err)
+		}
+		if info.Size() != file.Size {
+			return fmt.Errorf("%w: segment %s: %s has size %d, expected %d", ErrCorruptSegment, segmentDir, file.Path, info.Size(), file.Size)
+		}
+		if file.SHA256 == "" {
+			continue
+		}
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if sum != file.SHA256 {
+			return fmt.Errorf("%w: segment %s: %s has SHA-256 %s, expected %s", ErrCorruptSegment, segmentDir, file.Path, sum, file.SHA256)
+		}
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s for checksum: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read %s for checksum: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reportCorruption counts a corrupt segment download and raises an alert in the log.
+func reportCorruption(collection string, err error) {
+	segmentCorruptionsTotal.Inc()
+	slog.Error("ALERT: corrupt segment downloaded", "collection", collection, "error", err)
+}
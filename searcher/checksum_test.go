@@ -0,0 +1,94 @@
+package searcher
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setStoredChecksum records sha as the SHA-256 of every file in the manifest of a segment
+// written by writeStoredSegment.
+func setStoredChecksum(t *testing.T, storeDir, name, sha string) {
+	t.Helper()
+	path := filepath.Join(storeDir, "products", name, segmentManifestFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range manifest["files"].([]interface{}) {
+		f.(map[string]interface{})["sha256"] = sha
+	}
+	data, _ = json.Marshal(manifest)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifySegment(t *testing.T) {
+	storeDir := t.TempDir()
+	writeStoredSegment(t, storeDir, "a_old", time.Now(), map[string]string{"store/root.bolt": "missing"})
+	dir := filepath.Join(storeDir, "products", "a_old")
+	manifest, err := readSegmentManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Files = manifest.Files[:1]
+
+	// SHA-256 of 50 times "a"
+	manifest.Files[0].SHA256 = "160b4e433e384e05e537dc59b467f7cb2403f0214db15c5db58862a3f1156d2e"
+	if err := verifySegment(dir, manifest); err != nil {
+		t.Errorf("Expected the segment to verify, got %v", err)
+	}
+	manifest.Files[0].SHA256 = "0000"
+	if err := verifySegment(dir, manifest); !errors.Is(err, ErrCorruptSegment) {
+		t.Errorf("Expected ErrCorruptSegment for a checksum mismatch, got %v", err)
+	}
+	manifest.Files[0].SHA256, manifest.Files[0].Size = "", 49
+	if err := verifySegment(dir, manifest); !errors.Is(err, ErrCorruptSegment) {
+		t.Errorf("Expected ErrCorruptSegment for a size mismatch, got %v", err)
+	}
+}
+
+func TestSegmentTiers_CorruptSegment(t *testing.T) {
+	storeDir, cacheDir := t.TempDir(), t.TempDir()
+	now := time.Now()
+	writeStoredSegment(t, storeDir, "a_old", now.Add(-time.Hour), nil)
+	svc := newTieredSearcher(t, storeDir, TieringConfig{CacheDir: cacheDir, WarmSegments: 1})
+	if err := svc.tiers.refresh(); err != nil {
+		t.Fatalf("refresh returned an error: %v", err)
+	}
+
+	// A compaction replaces the segment by a corrupt one: the previous segment is kept.
+	writeStoredSegment(t, storeDir, "b_merged", now, nil)
+	setStoredChecksum(t, storeDir, "b_merged", "0000")
+	if err := os.RemoveAll(filepath.Join(storeDir, "products", "a_old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.tiers.refresh(); !errors.Is(err, ErrCorruptSegment) {
+		t.Fatalf("Expected ErrCorruptSegment, got %v", err)
+	}
+	statuses := segmentStatuses(t, svc)
+	if !statuses["a_old"].Local || statuses["b_merged"].Local {
+		t.Errorf("Expected the known-good segment to be kept and the corrupt one not cached, got %+v", statuses)
+	}
+	if _, err := os.Stat(filepath.Join(svc.tiers.dir, ".b_merged")); !os.IsNotExist(err) {
+		t.Errorf("Expected the corrupt download to be removed, got %v", err)
+	}
+
+	// Once the segment is uploaded intact, it replaces the previous one.
+	setStoredChecksum(t, storeDir, "b_merged", "")
+	if err := svc.tiers.refresh(); err != nil {
+		t.Fatalf("refresh returned an error: %v", err)
+	}
+	statuses = segmentStatuses(t, svc)
+	if _, ok := statuses["a_old"]; ok || !statuses["b_merged"].Local {
+		t.Errorf("Expected the repaired segment to replace the previous one, got %+v", statuses)
+	}
+}
@@ -3,34 +3,167 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
+	"runtime"
 	"searcher"
+	"time"
+
+	"common/commitbus"
+	"common/config"
+	"common/graceful"
+	"common/logging"
+	"common/slowlog"
+	"common/tlsconfig"
+	"common/tracing"
+	"common/vector"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-const (
-	port = ":8081" // Port for the Searcher service API
-)
+// Config holds the searcher's settings, read from a YAML file (-config-file), environment
+// variables and flags, in increasing order of precedence.
+type Config struct {
+	ListenAddr      string           `yaml:"listen_addr" env:"LISTEN_ADDR" flag:"listen-addr" usage:"Address to listen on"`
+	Collection      string           `yaml:"collection" env:"COLLECTION" flag:"collection" usage:"Collection served by this searcher"`
+	Tenant          string           `yaml:"tenant" env:"TENANT" flag:"tenant" usage:"Tenant owning the collection; empty for the default tenant"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"How long in-flight searches are drained on shutdown"`
+	TLS             tlsconfig.Config `yaml:"tls"`
+	// Log sets the level and format of the logs; the level can be changed at runtime
+	// through /admin/log-level.
+	Log logging.Config `yaml:"log"`
+	// Concurrency bounds the searches run at once; searches over capacity get a 503.
+	Concurrency searcher.ConcurrencyConfig `yaml:"concurrency"`
+	// SegmentPollInterval is a fallback: segments are downloaded when the Indexer announces
+	// a commit on the commit bus, and polled for in case a notification was lost.
+	SegmentPollInterval time.Duration `yaml:"segment_poll_interval" env:"SEGMENT_POLL_INTERVAL" flag:"segment-poll-interval" usage:"How often segments are checked besides commit notifications; 0 disables polling"`
+	// LoadSubscribers are notified of every new segment the searcher loads: brokers rerun
+	// their live queries once it is served. Deliveries are authenticated by CommitToken,
+	// which has no flag so it doesn't show up in process listings.
+	LoadSubscribers []string `yaml:"load_subscribers" env:"LOAD_SUBSCRIBERS" flag:"load-subscribers" usage:"Comma-separated base URLs notified of every segment loaded"`
+	CommitToken     string   `yaml:"commit_token" env:"COMMIT_TOKEN" usage:"Shared secret sent with the segments loaded"`
+	// VectorFields gives the dimensions and similarity of the vector fields searched by kNN
+	// queries, as in the index schema; other fields are compared by cosine similarity.
+	VectorFields map[string]vector.Field `yaml:"vector_fields"`
+	// Index sets how the index is opened: in memory, or persisted by scorch with its segment
+	// files memory-mapped and closed while idle once over the open shard or memory budget.
+	Index searcher.IndexConfig `yaml:"index"`
+	// Tiering keeps recent segments on local disk and fetches older ones from the segment
+	// store when a request needs them; it is enabled by a store directory.
+	Tiering searcher.TieringConfig `yaml:"tiering"`
+	// SlowQueryLog records the searches taking at least its threshold, with the Bleve
+	// request and its stage timings.
+	SlowQueryLog slowlog.Config `yaml:"slow_query_log"`
+}
 
 func main() {
+	cfg := Config{
+		ListenAddr:      ":8081",
+		Collection:      searcher.DefaultCollection,
+		ShutdownTimeout: graceful.DefaultTimeout,
+		Concurrency: searcher.ConcurrencyConfig{
+			MaxConcurrent: runtime.GOMAXPROCS(0) * 2,
+			MaxQueue:      64,
+			QueueTimeout:  time.Second,
+		},
+		// Commit notifications deliver segments right away; polling only catches lost ones.
+		SegmentPollInterval: 5 * time.Minute,
+		Index:               searcher.IndexConfig{Storage: searcher.StorageMemory},
+		Tiering:             searcher.TieringConfig{CacheDir: "./segment_cache", WarmSegments: 1},
+		Log:                 logging.DefaultConfig(),
+		SlowQueryLog:        slowlog.DefaultConfig(),
+	}
+	config.MustLoad(&cfg)
+	if err := logging.Setup(cfg.Log); err != nil {
+		log.Fatalf("Invalid log configuration: %v", err)
+	}
+
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.ConfigFromEnv("searcher"))
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize Searcher
-	svc, err := searcher.NewSearcher()
+	shards, err := searcher.NewShardCache(cfg.Index)
+	if err != nil {
+		log.Fatalf("Invalid index configuration: %v", err)
+	}
+	svc, err := shards.Open(cfg.Collection, cfg.Tenant)
 	if err != nil {
 		log.Fatalf("Failed to initialize Searcher: %v", err)
 	}
+	if err := svc.SetConcurrencyLimit(cfg.Concurrency); err != nil {
+		log.Fatalf("Invalid concurrency limits: %v", err)
+	}
+	if err := svc.SetVectorFields(cfg.VectorFields); err != nil {
+		log.Fatalf("Invalid vector fields: %v", err)
+	}
+	if cfg.Tiering.StoreDir != "" {
+		store := searcher.NewDirSegmentStore(cfg.Tiering.StoreDir, cfg.Tenant, cfg.Collection)
+		if err := svc.SetTiering(store, cfg.Tiering); err != nil {
+			log.Fatalf("Invalid segment tiering: %v", err)
+		}
+	}
+
+	slowLog, err := slowlog.New(cfg.SlowQueryLog)
+	if err != nil {
+		log.Fatalf("Invalid slow query log configuration: %v", err)
+	}
+	defer slowLog.Close()
+	svc.SetSlowQueryLog(slowLog)
+
+	if len(cfg.LoadSubscribers) > 0 {
+		loads, err := commitbus.NewLoadPublisher(cfg.LoadSubscribers, nil)
+		if err != nil {
+			log.Fatalf("Invalid load subscribers: %v", err)
+		}
+		loads.SetToken(cfg.CommitToken)
+		svc.SetLoadPublisher(loads)
+		slog.Info("Notifying load subscribers of loaded segments", "subscribers", len(cfg.LoadSubscribers))
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start routine to update index segments
-	go svc.UpdateIndex(ctx)
+	// Start routine to update index segments, woken up by commit notifications
+	go svc.UpdateIndex(ctx, cfg.SegmentPollInterval)
 
 	// Set up Gin router
 	router := gin.Default()
 	router.GET("/search", svc.SearchHandler)
+	router.GET("/doc/:id", svc.DocumentHandler)
+	router.GET("/docs", svc.DocumentsHandler)
+	router.GET("/suggest", svc.SuggestHandler)
+	router.GET("/spell", svc.SpellHandler)
+	router.GET("/stats", svc.StatsHandler)
+	router.GET("/routing", svc.RoutingHandler)
+	// Segment admin API: list segments by tier, fetch cold ones and pin them on local disk.
+	router.GET("/segments", svc.SegmentsHandler)
+	router.GET("/segments/:name", svc.SegmentHandler)
+	router.PUT("/segments/:name/pin", svc.PinSegmentHandler)
+	router.DELETE("/segments/:name/pin", svc.UnpinSegmentHandler)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler())) // Prometheus scrape endpoint, including index memory
+	router.GET(logging.LevelPath, gin.WrapH(logging.LevelHandler()))
+	router.PUT(logging.LevelPath, gin.WrapH(logging.LevelHandler()))
+	// The Indexer announces uploaded segments here; list this searcher in its commit_subscribers.
+	router.POST(commitbus.Path, gin.WrapH(commitbus.Handler(svc.NotifyCommit)))
 
-	log.Printf("Searcher Service started on port %s", port)
-	if err := router.Run(port); err != nil {
+	slog.Info("Searcher Service started", "addr", cfg.ListenAddr)
+	// Wrap the router so incoming trace context and request IDs from the Broker are extracted
+	// for every request.
+	// The TLS settings enable TLS, and client_auth requires the Broker to use mTLS.
+	server, err := tlsconfig.NewServer(cfg.ListenAddr, tracing.Middleware(logging.Middleware(router), "searcher"), cfg.TLS)
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	// On SIGTERM/SIGINT in-flight searches are drained before the index is closed.
+	if err := graceful.Serve(server, cfg.ShutdownTimeout); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	cancel()
+	if err := svc.Close(); err != nil {
+		slog.Error("Failed to close index", "error", err)
+	}
+	slog.Info("Searcher Service stopped")
 }
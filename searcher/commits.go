@@ -0,0 +1,40 @@
+package searcher
+
+import (
+	"log/slog"
+
+	"common/commitbus"
+)
+
+// NotifyCommit handles a commit event published by the Indexer: a segment uploaded for the
+// tenant and collection served by this searcher makes UpdateIndex download it right away.
+// Events for other collections are ignored, and events arriving while a download is
+// pending are coalesced into it.
+func (s *Searcher) NotifyCommit(event commitbus.Event) {
+	collection := event.Collection
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	if event.Tenant != s.tenant || collection != s.collection {
+		return
+	}
+	select {
+	case s.commits <- event.Segment:
+		slog.Info("Segment committed, downloading segments", "segment", event.Segment)
+	default: // A download is already pending
+	}
+}
+
+// SetLoadPublisher makes the searcher announce every new segment it loads to the
+// subscribers of p, e.g. brokers rerunning their live queries once the segment is served.
+func (s *Searcher) SetLoadPublisher(p *commitbus.Publisher) {
+	s.loads = p
+}
+
+// loaded records segment as the latest one loaded, announcing it if it is new.
+func (s *Searcher) loaded(segment string) {
+	if previous := s.segment.Swap(&segment); previous != nil && *previous == segment {
+		return
+	}
+	s.loads.PublishAsync(commitbus.Event{Tenant: s.tenant, Collection: s.collection, Segment: segment})
+}
@@ -0,0 +1,88 @@
+package searcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"common/commitbus"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearcher_NotifyCommit(t *testing.T) {
+	svc, err := NewCollectionSearcher("articles")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	defer svc.Close()
+	router := gin.New()
+	router.POST(commitbus.Path, gin.WrapH(commitbus.Handler(svc.NotifyCommit)))
+	post := func(body string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, commitbus.Path, strings.NewReader(body)))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// Commits to other collections or tenants don't trigger a download.
+	post(`{"collection":"products","segment":"index_1"}`)
+	post(`{"tenant":"acme","collection":"articles","segment":"index_2"}`)
+	if len(svc.commits) != 0 {
+		t.Fatalf("Expected no pending download, got %d", len(svc.commits))
+	}
+
+	// Commits arriving while a download is pending are coalesced into it.
+	post(`{"collection":"articles","segment":"index_3"}`)
+	post(`{"collection":"articles","segment":"index_4"}`)
+	if len(svc.commits) != 1 {
+		t.Fatalf("Expected one pending download, got %d", len(svc.commits))
+	}
+	if segment := <-svc.commits; segment != "index_3" {
+		t.Errorf("Expected the download of index_3, got %s", segment)
+	}
+}
+
+func TestSearcher_AnnounceLoadedSegments(t *testing.T) {
+	events := make(chan commitbus.Event, 2)
+	server := httptest.NewServer(commitbus.AuthHandler("secret", func(e commitbus.Event) { events <- e }))
+	defer server.Close()
+	loads, err := commitbus.NewLoadPublisher([]string{server.URL}, nil)
+	if err != nil {
+		t.Fatalf("NewLoadPublisher returned an error: %v", err)
+	}
+	loads.SetToken("secret")
+	svc, err := NewCollectionSearcher("articles")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	defer svc.Close()
+	svc.SetLoadPublisher(loads)
+
+	// Only new segments are announced, once they're served.
+	for _, segment := range []string{"index_1", "index_1", "index_2"} {
+		svc.loaded(segment)
+	}
+	for _, want := range []string{"index_1", "index_2"} {
+		select {
+		case e := <-events:
+			if e.Collection != "articles" || e.Segment == "" {
+				t.Errorf("Unexpected event %+v", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the load of %s to be announced", want)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("Expected a segment loaded again not to be announced, got %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := svc.Segment(); got != "index_2" {
+		t.Errorf("Expected the searcher to serve index_2, got %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+package searcher
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// DocumentHandler returns the stored fields of the document at GET /doc/:id, mirroring
+// the Indexer's endpoint so the two can be compared. The optional "fields" query
+// parameter is a comma-separated projection.
+func (s *Searcher) DocumentHandler(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "document ID is required"})
+		return
+	}
+	if !s.checkScope(c) {
+		return
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery([]string{id}), 1, 0, false)
+	req.Fields = ParseFieldList(c.Query("fields"), []string{AllFields})
+	result, err := s.executeSearch(c.Request.Context(), req)
+	if shedLoad(c, err) || abortCancelled(c, err) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to get document", "id", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get document"})
+		return
+	}
+	if len(result.Hits) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("document '%s' not found", id)})
+		return
+	}
+
+	stored := result.Hits[0].Fields
+	if stored == nil {
+		stored = map[string]interface{}{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":         id,
+		"collection": s.collection,
+		"fields":     stored,
+	})
+}
+
+// maxDocumentIDs is the largest number of documents returned by one GET /docs request.
+const maxDocumentIDs = 1000
+
+// DocumentsHandler returns the stored fields of several documents at GET /docs, given
+// their comma-separated "ids", as the broker fetches the results of a query-then-fetch
+// search. The optional "fields" query parameter is a comma-separated projection. Missing
+// documents are left out of the response.
+func (s *Searcher) DocumentsHandler(c *gin.Context) {
+	defer s.traceSlowSearch(c)()
+	var ids []string
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'ids' is required"})
+		return
+	}
+	if len(ids) > maxDocumentIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many documents, at most %d can be fetched at once", maxDocumentIDs)})
+		return
+	}
+	if !s.checkScope(c) {
+		return
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery(ids), len(ids), 0, false)
+	req.Fields = ParseFieldList(c.Query("fields"), []string{AllFields})
+	result, err := s.executeSearch(c.Request.Context(), req)
+	if shedLoad(c, err) || abortCancelled(c, err) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to get documents", "ids", ids, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get documents"})
+		return
+	}
+	documents := make([]gin.H, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		stored := hit.Fields
+		if stored == nil {
+			stored = map[string]interface{}{}
+		}
+		documents = append(documents, gin.H{"id": hit.ID, "fields": stored})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"collection": s.collection,
+		"documents":  documents,
+	})
+}
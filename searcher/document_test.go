@@ -0,0 +1,92 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDocumentHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewSearcher()
+	if err != nil {
+		t.Fatalf("NewSearcher returned an error: %v", err)
+	}
+	if err := svc.index.Index("doc1", map[string]interface{}{"title": "hello", "price": 10.0}); err != nil {
+		t.Fatalf("Failed to index document: %v", err)
+	}
+	router := gin.New()
+	router.GET("/doc/:id", svc.DocumentHandler)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/doc/doc1?fields=title", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		ID     string                 `json:"id"`
+		Fields map[string]interface{} `json:"fields"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.ID != "doc1" || !reflect.DeepEqual(body.Fields, map[string]interface{}{"title": "hello"}) {
+		t.Errorf("Unexpected document: %+v", body)
+	}
+
+	for target, status := range map[string]int{
+		"/doc/missing":                 http.StatusNotFound,
+		"/doc/doc1?collection=archive": http.StatusNotFound,
+		"/doc/doc1":                    http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != status {
+			t.Errorf("%s: expected status %d, got %d", target, status, rec.Code)
+		}
+	}
+}
+
+func TestDocumentsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewSearcher()
+	if err != nil {
+		t.Fatalf("NewSearcher returned an error: %v", err)
+	}
+	for _, id := range []string{"doc1", "doc2", "doc3"} {
+		if err := svc.index.Index(id, map[string]interface{}{"title": id, "price": 10.0}); err != nil {
+			t.Fatalf("Failed to index document: %v", err)
+		}
+	}
+	router := gin.New()
+	router.GET("/docs", svc.DocumentsHandler)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs?ids=doc1,doc3,missing&fields=title", nil))
+	var body struct {
+		Documents []struct {
+			ID     string                 `json:"id"`
+			Fields map[string]interface{} `json:"fields"`
+		} `json:"documents"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	found := make(map[string]map[string]interface{})
+	for _, doc := range body.Documents {
+		found[doc.ID] = doc.Fields
+	}
+	if len(found) != 2 || !reflect.DeepEqual(found["doc3"], map[string]interface{}{"title": "doc3"}) {
+		t.Errorf("Expected doc1 and doc3 with their title, got %+v", body.Documents)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without IDs, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,25 @@
+package searcher
+
+import (
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// ExpiresAtField is the datetime field after which documents expire. The indexer deletes
+// expired documents periodically; until it does, searches leave them out.
+const ExpiresAtField = "expires_at"
+
+// excludeExpired restricts q to the documents that haven't expired at now; documents
+// without an expiration date never expire.
+func excludeExpired(q query.Query, now time.Time) query.Query {
+	expired := bleve.NewDateRangeQuery(time.Time{}, now)
+	inclusive := true
+	expired.InclusiveEnd = &inclusive
+	expired.SetField(ExpiresAtField)
+	live := bleve.NewBooleanQuery()
+	live.AddMust(q)
+	live.AddMustNot(expired)
+	return live
+}
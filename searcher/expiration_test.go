@@ -0,0 +1,52 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchHandler_ExcludesExpired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("catalog")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	docs := map[string]map[string]interface{}{
+		"expired":   {"text": "summer sale", ExpiresAtField: time.Now().Add(-time.Hour).Format(time.RFC3339)},
+		"live":      {"text": "summer sale", ExpiresAtField: time.Now().Add(time.Hour).Format(time.RFC3339)},
+		"permanent": {"text": "summer sale"},
+	}
+	for id, doc := range docs {
+		if err := svc.index.Index(id, doc); err != nil {
+			t.Fatalf("Failed to index document: %v", err)
+		}
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sale", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		TotalHits uint64      `json:"total_hits"`
+		Results   []SearchHit `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.TotalHits != 2 || len(body.Results) != 2 {
+		t.Fatalf("Expected the 2 unexpired documents, got %+v", body)
+	}
+	for _, hit := range body.Results {
+		if hit.ID == "expired" {
+			t.Errorf("Expected the expired document to be left out, got %+v", body.Results)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package searcher
+
+import "strings"
+
+// AllFields selects every stored field of a document.
+const AllFields = "*"
+
+// NoFields selects no stored field: hits only carry their ID, score and sort values, as
+// in the query phase of the broker's query-then-fetch searches.
+const NoFields = "_none"
+
+// DefaultResultFields are the stored fields returned with search hits when the request
+// doesn't select any; the Broker reads the title and URL of its results from them.
+var DefaultResultFields = []string{"title", "url"}
+
+// ParseFieldList splits a comma-separated list of field names such as
+// "title,url,price". It returns def if the list names no field, and an empty list if it
+// names NoFields.
+func ParseFieldList(param string, def []string) []string {
+	var fields []string
+	for _, f := range strings.Split(param, ",") {
+		if f = strings.TrimSpace(f); f == NoFields {
+			return []string{}
+		} else if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return def
+	}
+	return fields
+}
+
+// selectFields returns the stored values of the requested fields, or every stored value
+// if fields contains AllFields. Hits also load the fields they are sorted on, which are
+// only returned when selected. It returns nil if no requested field is stored.
+func selectFields(stored map[string]interface{}, fields []string) map[string]interface{} {
+	selected := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if f == AllFields {
+			return stored
+		}
+		if v, ok := stored[f]; ok {
+			selected[f] = v
+		}
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	return selected
+}
@@ -0,0 +1,69 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseFieldList(t *testing.T) {
+	if got := ParseFieldList(" title, ,price ", nil); !reflect.DeepEqual(got, []string{"title", "price"}) {
+		t.Errorf("Expected [title price], got %v", got)
+	}
+	if got := ParseFieldList(" , ", DefaultResultFields); !reflect.DeepEqual(got, DefaultResultFields) {
+		t.Errorf("Expected the default fields, got %v", got)
+	}
+	if got := ParseFieldList(NoFields, DefaultResultFields); got == nil || len(got) != 0 {
+		t.Errorf("Expected no fields, got %v", got)
+	}
+}
+
+func TestSearchHandler_Fields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("fields")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	doc := map[string]interface{}{
+		"title":    "Red shoes",
+		"url":      "http://example.com/red-shoes",
+		"price":    59.9,
+		"in_stock": true,
+		"text":     "comfortable red running shoes",
+	}
+	if err := svc.index.Index("shoes", doc); err != nil {
+		t.Fatalf("Failed to index document: %v", err)
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	search := func(query string) map[string]interface{} {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoes"+query, nil))
+		var body struct {
+			Results []SearchHit `json:"results"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Results) != 1 {
+			t.Fatalf("Expected one hit, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return body.Results[0].Fields
+	}
+
+	want := map[string]interface{}{"title": "Red shoes", "url": "http://example.com/red-shoes"}
+	if got := search(""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the default fields %v, got %v", want, got)
+	}
+	// Values keep their type, and fields only loaded for sorting aren't returned.
+	want = map[string]interface{}{"title": "Red shoes", "price": 59.9, "in_stock": true}
+	if got := search("&fields=title,price,in_stock,missing&sort=url"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := search("&fields=*"); len(got) != len(doc) {
+		t.Errorf("Expected every stored field, got %v", got)
+	}
+}
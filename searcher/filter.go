@@ -0,0 +1,152 @@
+package searcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/geo"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Filter types understood by the Searcher.
+const (
+	FilterTerm        = "term"         // Exact match of Value on Field
+	FilterRange       = "range"        // Numeric range [Min, Max] on Field
+	FilterDateRange   = "date_range"   // RFC 3339 date range [Start, End] on Field
+	FilterGeoDistance = "geo_distance" // Points of Field within Distance of (Lat, Lon)
+	FilterNested      = "nested"       // A nested object at path Field matching all of Filters
+)
+
+// Filter is a structured restriction on the documents matched by a search.
+// Filters are passed as a JSON array in the "filters" query parameter and are
+// combined with the text query in a conjunction, so a document must satisfy all of them.
+// Range bounds are optional; omitting one leaves that side of the range open.
+type Filter struct {
+	Type  string `json:"type"`
+	Field string `json:"field"`
+
+	// Term filter.
+	Value string `json:"value,omitempty"`
+
+	// Numeric range filter.
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	ExclusiveMin bool     `json:"exclusive_min,omitempty"`
+	ExclusiveMax bool     `json:"exclusive_max,omitempty"`
+
+	// Date range filter, RFC 3339 timestamps.
+	Start          string `json:"start,omitempty"`
+	End            string `json:"end,omitempty"`
+	ExclusiveStart bool   `json:"exclusive_start,omitempty"`
+	ExclusiveEnd   bool   `json:"exclusive_end,omitempty"`
+
+	// Geo distance filter. Distance uses Bleve's syntax, e.g. "10km" or "5mi".
+	Lat      float64 `json:"lat,omitempty"`
+	Lon      float64 `json:"lon,omitempty"`
+	Distance string  `json:"distance,omitempty"`
+
+	// Nested filter: the filters one object of the array at Field must match together.
+	Filters []Filter `json:"filters,omitempty"`
+}
+
+// ParseFilters decodes the JSON array of filters from the "filters" query parameter.
+// An empty parameter returns no filters.
+func ParseFilters(param string) ([]Filter, error) {
+	if param == "" {
+		return nil, nil
+	}
+	var filters []Filter
+	if err := json.Unmarshal([]byte(param), &filters); err != nil {
+		return nil, fmt.Errorf("invalid filters: %w", err)
+	}
+	for i := range filters {
+		if _, err := filters[i].Query(); err != nil {
+			return nil, fmt.Errorf("invalid filter %d: %w", i, err)
+		}
+	}
+	return filters, nil
+}
+
+// Query translates the filter into the equivalent Bleve query.
+func (f Filter) Query() (query.Query, error) {
+	if f.Field == "" {
+		return nil, fmt.Errorf("%s filter requires a field", f.Type)
+	}
+
+	switch f.Type {
+	case FilterTerm:
+		if f.Value == "" {
+			return nil, fmt.Errorf("term filter on '%s' requires a value", f.Field)
+		}
+		q := bleve.NewTermQuery(f.Value)
+		q.SetField(f.Field)
+		return q, nil
+
+	case FilterRange:
+		if f.Min == nil && f.Max == nil {
+			return nil, fmt.Errorf("range filter on '%s' requires min or max", f.Field)
+		}
+		minInclusive, maxInclusive := !f.ExclusiveMin, !f.ExclusiveMax
+		q := bleve.NewNumericRangeInclusiveQuery(f.Min, f.Max, &minInclusive, &maxInclusive)
+		q.SetField(f.Field)
+		return q, nil
+
+	case FilterDateRange:
+		if f.Start == "" && f.End == "" {
+			return nil, fmt.Errorf("date_range filter on '%s' requires start or end", f.Field)
+		}
+		var start, end time.Time
+		var err error
+		if f.Start != "" {
+			if start, err = time.Parse(time.RFC3339, f.Start); err != nil {
+				return nil, fmt.Errorf("invalid start date for '%s': %w", f.Field, err)
+			}
+		}
+		if f.End != "" {
+			if end, err = time.Parse(time.RFC3339, f.End); err != nil {
+				return nil, fmt.Errorf("invalid end date for '%s': %w", f.Field, err)
+			}
+		}
+		startInclusive, endInclusive := !f.ExclusiveStart, !f.ExclusiveEnd
+		q := bleve.NewDateRangeInclusiveQuery(start, end, &startInclusive, &endInclusive)
+		q.SetField(f.Field)
+		return q, nil
+
+	case FilterGeoDistance:
+		if f.Distance == "" {
+			return nil, fmt.Errorf("geo_distance filter on '%s' requires a distance", f.Field)
+		}
+		if _, err := geo.ParseDistance(f.Distance); err != nil {
+			return nil, fmt.Errorf("invalid distance for '%s': %w", f.Field, err)
+		}
+		if f.Lat < -90 || f.Lat > 90 || f.Lon < -180 || f.Lon > 180 {
+			return nil, fmt.Errorf("invalid coordinates for '%s': lat=%v lon=%v", f.Field, f.Lat, f.Lon)
+		}
+		q := bleve.NewGeoDistanceQuery(f.Lon, f.Lat, f.Distance)
+		q.SetField(f.Field)
+		return q, nil
+
+	case FilterNested:
+		return nestedFilterQuery(f)
+	}
+	return nil, fmt.Errorf("unknown filter type '%s'", f.Type)
+}
+
+// applyFilters combines the text query with the filters in a conjunction query.
+// The text query is returned unchanged if there are no filters.
+func applyFilters(textQuery query.Query, filters []Filter) (query.Query, error) {
+	if len(filters) == 0 {
+		return textQuery, nil
+	}
+	conjuncts := []query.Query{textQuery}
+	for _, f := range filters {
+		q, err := f.Query()
+		if err != nil {
+			return nil, err
+		}
+		conjuncts = append(conjuncts, q)
+	}
+	return bleve.NewConjunctionQuery(conjuncts...), nil
+}
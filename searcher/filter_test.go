@@ -0,0 +1,85 @@
+package searcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+func TestParseFilters(t *testing.T) {
+	filters, err := ParseFilters(`[{"type":"term","field":"color","value":"red"},{"type":"range","field":"price","max":20}]`)
+	if err != nil {
+		t.Fatalf("ParseFilters returned an error: %v", err)
+	}
+	if len(filters) != 2 || filters[1].Max == nil || *filters[1].Max != 20 {
+		t.Errorf("Unexpected filters: %+v", filters)
+	}
+
+	for _, param := range []string{
+		`not json`,
+		`[{"type":"term","field":"color"}]`,
+		`[{"type":"range","field":"price"}]`,
+		`[{"type":"date_range","field":"created_at","start":"yesterday"}]`,
+		`[{"type":"geo_distance","field":"location","lat":10,"lon":10,"distance":"far"}]`,
+		`[{"type":"unknown","field":"x"}]`,
+	} {
+		if _, err := ParseFilters(param); err == nil {
+			t.Errorf("Expected an error for filters %s", param)
+		}
+	}
+
+	q, err := Filter{Type: FilterGeoDistance, Field: "location", Lat: 48.85, Lon: 2.35, Distance: "10km"}.Query()
+	if err != nil {
+		t.Fatalf("Query returned an error for a geo filter: %v", err)
+	}
+	if _, ok := q.(*query.GeoDistanceQuery); !ok {
+		t.Errorf("Expected a GeoDistanceQuery, got %T", q)
+	}
+}
+
+func TestApplyFilters(t *testing.T) {
+	svc, err := NewSearcher()
+	if err != nil {
+		t.Fatalf("NewSearcher returned an error: %v", err)
+	}
+	docs := map[string]map[string]interface{}{
+		"cheap-red":  {"text": "running shoes", "color": "red", "price": 10.0, "created_at": "2024-01-10T00:00:00Z"},
+		"pricey-red": {"text": "running shoes", "color": "red", "price": 120.0, "created_at": "2024-03-10T00:00:00Z"},
+		"cheap-blue": {"text": "running shoes", "color": "blue", "price": 15.0, "created_at": "2024-01-20T00:00:00Z"},
+	}
+	for id, doc := range docs {
+		if err := svc.index.Index(id, doc); err != nil {
+			t.Fatalf("Failed to index %s: %v", id, err)
+		}
+	}
+
+	maxPrice := 50.0
+	tests := []struct {
+		name     string
+		filters  []Filter
+		expected int
+	}{
+		{"no filters", nil, 3},
+		{"term", []Filter{{Type: FilterTerm, Field: "color", Value: "red"}}, 2},
+		{"term and range", []Filter{{Type: FilterTerm, Field: "color", Value: "red"}, {Type: FilterRange, Field: "price", Max: &maxPrice}}, 1},
+		{"date range", []Filter{{Type: FilterDateRange, Field: "created_at", Start: "2024-01-01T00:00:00Z", End: "2024-01-31T00:00:00Z"}}, 2},
+		{"exclusive date range", []Filter{{Type: FilterDateRange, Field: "created_at", Start: "2024-01-10T00:00:00Z", End: "2024-03-10T00:00:00Z", ExclusiveStart: true, ExclusiveEnd: true}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := applyFilters(bleve.NewMatchQuery("shoes"), tt.filters)
+			if err != nil {
+				t.Fatalf("applyFilters returned an error: %v", err)
+			}
+			result, err := svc.executeSearch(context.Background(), bleve.NewSearchRequest(q))
+			if err != nil {
+				t.Fatalf("Search returned an error: %v", err)
+			}
+			if int(result.Total) != tt.expected {
+				t.Errorf("Expected %d hits, got %d", tt.expected, result.Total)
+			}
+		})
+	}
+}
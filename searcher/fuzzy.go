@@ -0,0 +1,45 @@
+package searcher
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/blevesearch/bleve/v2/search/searcher"
+)
+
+// Fuzziness makes term matching typo tolerant: a query term also matches the indexed
+// terms within Edits insertions, deletions or substitutions of it that share its first
+// Prefix characters. Phrases are always matched exactly. The zero value disables fuzzy
+// matching.
+type Fuzziness struct {
+	Edits  int
+	Prefix int
+}
+
+// ParseFuzziness reads the "fuzziness" (maximum edit distance, 0 to
+// searcher.MaxFuzziness) and "prefix_length" parameters. Empty parameters default to 0.
+func ParseFuzziness(fuzziness, prefixLength string) (Fuzziness, error) {
+	var f Fuzziness
+	if fuzziness != "" {
+		v, err := strconv.Atoi(fuzziness)
+		if err != nil || v < 0 || v > searcher.MaxFuzziness {
+			return f, fmt.Errorf("invalid fuzziness '%s', must be between 0 and %d", fuzziness, searcher.MaxFuzziness)
+		}
+		f.Edits = v
+	}
+	if prefixLength != "" {
+		v, err := strconv.Atoi(prefixLength)
+		if err != nil || v < 0 {
+			return f, fmt.Errorf("invalid prefix_length '%s', must be a non-negative integer", prefixLength)
+		}
+		f.Prefix = v
+	}
+	return f, nil
+}
+
+// apply sets the fuzziness of a match query.
+func (f Fuzziness) apply(q *query.MatchQuery) {
+	q.SetFuzziness(f.Edits)
+	q.SetPrefix(f.Prefix)
+}
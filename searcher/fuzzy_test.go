@@ -0,0 +1,68 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseFuzziness(t *testing.T) {
+	f, err := ParseFuzziness("2", "1")
+	if err != nil || f != (Fuzziness{Edits: 2, Prefix: 1}) {
+		t.Errorf("Expected 2 edits after a 1 character prefix, got %+v (%v)", f, err)
+	}
+	if f, err := ParseFuzziness("", ""); err != nil || f != (Fuzziness{}) {
+		t.Errorf("Expected no fuzziness by default, got %+v (%v)", f, err)
+	}
+	for _, params := range [][2]string{{"3", ""}, {"-1", ""}, {"one", ""}, {"1", "-2"}, {"1", "x"}} {
+		if _, err := ParseFuzziness(params[0], params[1]); err == nil {
+			t.Errorf("Expected an error for fuzziness=%q prefix_length=%q", params[0], params[1])
+		}
+	}
+}
+
+func TestSearchHandler_Fuzziness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("fuzzy")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	if err := svc.index.Index("shoes", map[string]interface{}{"text": "red running shoes"}); err != nil {
+		t.Fatalf("Failed to index document: %v", err)
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	search := func(query string) (int, int) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+		var body struct {
+			TotalHits int `json:"total_hits"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body.TotalHits
+	}
+
+	if code, total := search("q=runing+shoos"); code != http.StatusOK || total != 0 {
+		t.Errorf("Expected no match without fuzziness, got status %d and %d hits", code, total)
+	}
+	if code, total := search("q=runing+shoos&fuzziness=1"); code != http.StatusOK || total != 1 {
+		t.Errorf("Expected a fuzzy match, got status %d and %d hits", code, total)
+	}
+	// The misspelled "rad" doesn't share the 2 character prefix of "red".
+	if _, total := search("q=rad&fuzziness=1&prefix_length=2"); total != 0 {
+		t.Errorf("Expected the prefix length to prevent the match, got %d hits", total)
+	}
+	// Fuzziness applies to the term nodes of a query tree.
+	tree := `{"type":"bool","must":[{"type":"term","text":"rad"},{"type":"phrase","text":"running shoes"}]}`
+	if _, total := search("fuzziness=1&query=" + url.QueryEscape(tree)); total != 1 {
+		t.Errorf("Expected the query tree to match with fuzziness, got %d hits", total)
+	}
+	if code, _ := search("q=shoes&fuzziness=5"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a fuzziness above the maximum, got %d", code)
+	}
+}
@@ -0,0 +1,96 @@
+package searcher
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/geo"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+const (
+	// DefaultGeoField is the geopoint field declared in the Indexer's default mapping.
+	DefaultGeoField = "location"
+
+	sortFieldDistance = "_distance" // Pseudo-field sorting by distance from the geo query origin
+)
+
+// GeoQuery restricts a search to documents within Radius of a point and enables
+// distance computation and sorting.
+type GeoQuery struct {
+	Field  string  // Geopoint field; defaults to DefaultGeoField
+	Lat    float64 // Latitude of the origin
+	Lon    float64 // Longitude of the origin
+	Radius string  // Maximum distance in Bleve's syntax, e.g. "10km"; empty means no radius limit
+}
+
+// newIndexMapping returns the mapping used by in-memory indexes. Like the Indexer's
+// default mapping, it declares a stored geopoint "location" field so geo queries work,
+// and a stored datetime "expires_at" field.
+func newIndexMapping() *mapping.IndexMappingImpl {
+	indexMapping := bleve.NewIndexMapping()
+	geoFieldMapping := bleve.NewGeoPointFieldMapping()
+	geoFieldMapping.Store = true
+	indexMapping.DefaultMapping.AddFieldMappingsAt(DefaultGeoField, geoFieldMapping)
+	expiresAtFieldMapping := bleve.NewDateTimeFieldMapping()
+	expiresAtFieldMapping.Store = true
+	expiresAtFieldMapping.IncludeInAll = false
+	indexMapping.DefaultMapping.AddFieldMappingsAt(ExpiresAtField, expiresAtFieldMapping)
+	return indexMapping
+}
+
+// ParseGeoQuery reads the "lat", "lon", "radius" and "geo_field" query parameters.
+// It returns nil if neither lat nor lon is set; both are required otherwise.
+func ParseGeoQuery(lat, lon, radius, field string) (*GeoQuery, error) {
+	if lat == "" && lon == "" {
+		if radius != "" {
+			return nil, fmt.Errorf("radius requires lat and lon")
+		}
+		return nil, nil
+	}
+	if lat == "" || lon == "" {
+		return nil, fmt.Errorf("both lat and lon are required for a geo query")
+	}
+	q := &GeoQuery{Field: field, Radius: radius}
+	if q.Field == "" {
+		q.Field = DefaultGeoField
+	}
+	var err error
+	if q.Lat, err = strconv.ParseFloat(lat, 64); err != nil || q.Lat < -90 || q.Lat > 90 {
+		return nil, fmt.Errorf("invalid latitude '%s'", lat)
+	}
+	if q.Lon, err = strconv.ParseFloat(lon, 64); err != nil || q.Lon < -180 || q.Lon > 180 {
+		return nil, fmt.Errorf("invalid longitude '%s'", lon)
+	}
+	if radius != "" {
+		if _, err := geo.ParseDistance(radius); err != nil {
+			return nil, fmt.Errorf("invalid radius '%s': %w", radius, err)
+		}
+	}
+	return q, nil
+}
+
+// Filter returns the geo distance filter enforcing the radius, or false if there is no radius.
+func (q *GeoQuery) Filter() (Filter, bool) {
+	if q.Radius == "" {
+		return Filter{}, false
+	}
+	return Filter{Type: FilterGeoDistance, Field: q.Field, Lat: q.Lat, Lon: q.Lon, Distance: q.Radius}, true
+}
+
+// sortOrder returns the Bleve sort on the distance from the origin, in kilometres.
+func (q *GeoQuery) sortOrder(desc bool) search.SearchSort {
+	return &search.SortGeoDistance{Field: q.Field, Lat: q.Lat, Lon: q.Lon, Unit: "km", Desc: desc}
+}
+
+// DistanceKm returns the distance in kilometres between the origin and the stored
+// geopoint of the hit, or false if the hit has no valid geopoint.
+func (q *GeoQuery) DistanceKm(hit *search.DocumentMatch) (float64, bool) {
+	lon, lat, ok := geo.ExtractGeoPoint(hit.Fields[q.Field])
+	if !ok {
+		return 0, false
+	}
+	return geo.Haversin(q.Lon, q.Lat, lon, lat), true
+}
@@ -0,0 +1,79 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseGeoQuery(t *testing.T) {
+	q, err := ParseGeoQuery("48.85", "2.35", "10km", "")
+	if err != nil {
+		t.Fatalf("ParseGeoQuery returned an error: %v", err)
+	}
+	if q.Field != DefaultGeoField || q.Lat != 48.85 || q.Lon != 2.35 || q.Radius != "10km" {
+		t.Errorf("Unexpected geo query: %+v", q)
+	}
+	if q, err := ParseGeoQuery("", "", "", ""); q != nil || err != nil {
+		t.Errorf("Expected no geo query without coordinates, got %+v, %v", q, err)
+	}
+	for _, args := range [][3]string{{"48.85", "", ""}, {"", "", "5km"}, {"91", "0", ""}, {"0", "0", "far"}} {
+		if _, err := ParseGeoQuery(args[0], args[1], args[2], ""); err == nil {
+			t.Errorf("Expected an error for lat=%q lon=%q radius=%q", args[0], args[1], args[2])
+		}
+	}
+}
+
+func TestSearchHandler_GeoDistance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewSearcher()
+	if err != nil {
+		t.Fatalf("NewSearcher returned an error: %v", err)
+	}
+	docs := map[string]map[string]interface{}{
+		"louvre":  {"text": "museum", "location": map[string]interface{}{"lat": 48.8606, "lon": 2.3376}},
+		"orsay":   {"text": "museum", "location": map[string]interface{}{"lat": 48.8600, "lon": 2.3266}},
+		"prado":   {"text": "museum", "location": map[string]interface{}{"lat": 40.4138, "lon": -3.6921}},
+		"unknown": {"text": "museum"},
+	}
+	for id, doc := range docs {
+		if err := svc.index.Index(id, doc); err != nil {
+			t.Fatalf("Failed to index %s: %v", id, err)
+		}
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	// Origin near Orsay: Orsay is closer than the Louvre, the Prado is outside the radius.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=museum&lat=48.8595&lon=2.3250&radius=50km&sort=_distance", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Results []SearchHit `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Results) != 2 || body.Results[0].ID != "orsay" || body.Results[1].ID != "louvre" {
+		t.Fatalf("Expected [orsay louvre], got %+v", body.Results)
+	}
+	for _, hit := range body.Results {
+		if hit.DistanceKm == nil || *hit.DistanceKm > 2 {
+			t.Errorf("Expected a distance below 2km for %s, got %v", hit.ID, hit.DistanceKm)
+		}
+		if len(hit.SortValues) != 1 || hit.SortValues[0] != *hit.DistanceKm {
+			t.Errorf("Expected the distance as sort value for %s, got %v", hit.ID, hit.SortValues)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=museum&sort=_distance", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 when sorting by distance without an origin, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,313 @@
+package searcher
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+
+	"common/vector"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/document"
+	"github.com/blevesearch/bleve/v2/search/query"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+const (
+	// defaultK is the number of neighbors returned by kNN queries without k.
+	defaultK = 10
+	// maxK bounds the neighbors of a kNN query.
+	maxK = 1000
+	// minNumCandidates is the default number of candidates of kNN queries asking for fewer
+	// neighbors: filters are applied to the candidates, so a wider set keeps k results.
+	minNumCandidates = 100
+	// maxNumCandidates bounds the candidates of a kNN query.
+	maxNumCandidates = 10000
+)
+
+// KNNQuery finds the K documents whose vector in Field is the most similar to Vector.
+// The NumCandidates nearest vectors are looked up in the field's HNSW graph, then
+// restricted to the documents matching the text query and filters of the search.
+type KNNQuery struct {
+	Field         string
+	Vector        []float32
+	K             int
+	NumCandidates int
+}
+
+// ParseKNNQuery reads the "knn_field", "knn_vector" (comma-separated numbers), "k" and
+// "num_candidates" parameters. It returns nil without field and vector.
+func ParseKNNQuery(field, rawVector, k, numCandidates string) (*KNNQuery, error) {
+	if field == "" && rawVector == "" {
+		if k != "" || numCandidates != "" {
+			return nil, fmt.Errorf("k and num_candidates require knn_field and knn_vector")
+		}
+		return nil, nil
+	}
+	if field == "" || rawVector == "" {
+		return nil, fmt.Errorf("knn_field and knn_vector must be given together")
+	}
+	v, err := vector.Parse(rawVector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid knn_vector: %w", err)
+	}
+	q := &KNNQuery{Field: field, Vector: v, K: defaultK}
+	if k != "" {
+		if q.K, err = strconv.Atoi(k); err != nil || q.K <= 0 || q.K > maxK {
+			return nil, fmt.Errorf("invalid k %q, must be between 1 and %d", k, maxK)
+		}
+	}
+	q.NumCandidates = max(q.K, minNumCandidates)
+	if numCandidates != "" {
+		n, err := strconv.Atoi(numCandidates)
+		if err != nil || n < q.K || n > maxNumCandidates {
+			return nil, fmt.Errorf("invalid num_candidates %q, must be between k and %d", numCandidates, maxNumCandidates)
+		}
+		q.NumCandidates = n
+	}
+	return q, nil
+}
+
+// restrictToNeighbors restricts q to the candidates of a kNN query.
+func restrictToNeighbors(q query.Query, neighbors []vector.Neighbor) query.Query {
+	ids := make([]string, len(neighbors))
+	for i, n := range neighbors {
+		ids[i] = n.ID
+	}
+	return bleve.NewConjunctionQuery(bleve.NewDocIDQuery(ids), q)
+}
+
+// scoreNeighbors sets the score of every hit to its similarity to the query vector,
+// sorting the hits by it if they are ordered by score (byScore); the total is the number
+// of neighbors found.
+func scoreNeighbors(result *bleve.SearchResult, neighbors []vector.Neighbor, byScore bool) {
+	scores := make(map[string]float64, len(neighbors))
+	for _, n := range neighbors {
+		scores[n.ID] = n.Score
+	}
+	for _, hit := range result.Hits {
+		hit.Score = scores[hit.ID]
+	}
+	if byScore {
+		sort.SliceStable(result.Hits, func(i, j int) bool { return result.Hits[i].Score > result.Hits[j].Score })
+	}
+	result.Total = uint64(len(result.Hits))
+	result.MaxScore = 0
+	for _, hit := range result.Hits {
+		result.MaxScore = max(result.MaxScore, hit.Score)
+	}
+}
+
+// SetVectorFields sets the dimensions and similarity of vector fields by name, e.g. those
+// of the index schema. Fields missing from it are compared by cosine similarity.
+func (s *Searcher) SetVectorFields(fields map[string]vector.Field) error {
+	checked := make(map[string]vector.Field, len(fields))
+	for name, f := range fields {
+		if err := f.Validate(); err != nil {
+			return fmt.Errorf("vector field %s: %w", name, err)
+		}
+		checked[name] = f
+	}
+	s.vectorMu.Lock()
+	defer s.vectorMu.Unlock()
+	s.vectorFields = checked
+	s.vectorIndexes = nil
+	s.vectorGen++
+	return nil
+}
+
+// vectorIndex is the HNSW graph of the vectors of a field as of an index version.
+type vectorIndex struct {
+	graph   *vector.HNSW
+	field   vector.Field
+	version indexVersion
+}
+
+// indexVersion identifies the content of the Bleve index: it changes with every write.
+type indexVersion struct {
+	docs, updates, deletes uint64
+}
+
+// currentVersion returns the version of an index.
+func currentVersion(index bleve.Index) (indexVersion, error) {
+	docs, err := index.DocCount()
+	if err != nil {
+		return indexVersion{}, err
+	}
+	v := indexVersion{docs: docs}
+	if stats, ok := index.StatsMap()["index"].(map[string]interface{}); ok {
+		v.updates, _ = stats["updates"].(uint64)
+		v.deletes, _ = stats["deletes"].(uint64)
+	}
+	return v, nil
+}
+
+// nearestNeighbors returns the candidates of q, best first.
+func (s *Searcher) nearestNeighbors(q *KNNQuery) ([]vector.Neighbor, error) {
+	vi, err := s.vectorIndex(q.Field)
+	if err != nil {
+		return nil, err
+	}
+	f := vi.field
+	if f.Dims == 0 && vi.graph.Len() == 0 {
+		f.Dims = len(q.Vector) // An empty field accepts any query
+	}
+	if err := f.Check(q.Vector); err != nil {
+		return nil, fmt.Errorf("invalid knn_vector for field %s: %w", q.Field, err)
+	}
+	return vi.graph.Search(q.Vector, q.NumCandidates, q.NumCandidates), nil
+}
+
+// vectorIndex returns the graph of a vector field. While the graphs are rebuilt in the
+// background, the previous one is returned until it is swapped; otherwise it is rebuilt
+// if the index changed since it was built.
+func (s *Searcher) vectorIndex(name string) (*vectorIndex, error) {
+	s.vectorMu.Lock()
+	vi, ok := s.vectorIndexes[name]
+	rebuilding := s.vectorRebuilds > 0
+	s.vectorMu.Unlock()
+	if ok && rebuilding {
+		return vi, nil
+	}
+	return s.refreshVectorIndex(name)
+}
+
+// refreshVectorIndex returns the graph of a vector field, rebuilding it if the index
+// changed since it was built. s.vectorMu isn't held while building, so queries of other
+// fields and those served by the previous graph go on.
+func (s *Searcher) refreshVectorIndex(name string) (*vectorIndex, error) {
+	index, release, err := s.acquireIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	version, err := currentVersion(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the index version: %w", err)
+	}
+	s.vectorMu.Lock()
+	vi, ok := s.vectorIndexes[name]
+	f, known := s.vectorFields[name]
+	gen := s.vectorGen
+	s.vectorMu.Unlock()
+	if ok && vi.version == version {
+		return vi, nil
+	}
+	if !known {
+		f = vector.Field{Similarity: vector.Cosine}
+	}
+	if vi, err = buildVectorIndex(index, name, f, version); err != nil {
+		return nil, err
+	}
+
+	s.vectorMu.Lock()
+	defer s.vectorMu.Unlock()
+	if s.vectorGen != gen {
+		return vi, nil // The fields changed or the index closed while building
+	}
+	if s.vectorIndexes == nil {
+		s.vectorIndexes = make(map[string]*vectorIndex)
+	}
+	s.vectorIndexes[name] = vi
+	return vi, nil
+}
+
+// rebuildVectorIndexes rebuilds the graphs built so far in the background, e.g. after the
+// searcher loaded new segments, and swaps them in as they are built.
+func (s *Searcher) rebuildVectorIndexes() {
+	s.vectorMu.Lock()
+	defer s.vectorMu.Unlock()
+	if len(s.vectorIndexes) == 0 {
+		return
+	}
+	names := make([]string, 0, len(s.vectorIndexes))
+	for name := range s.vectorIndexes {
+		names = append(names, name)
+	}
+	s.vectorRebuilds++
+	go func() {
+		defer func() {
+			s.vectorMu.Lock()
+			s.vectorRebuilds--
+			s.vectorMu.Unlock()
+		}()
+		for _, name := range names {
+			if _, err := s.refreshVectorIndex(name); err != nil {
+				slog.Error("Failed to rebuild the kNN graph", "collection", s.collection, "field", name, "error", err)
+			}
+		}
+	}()
+}
+
+// buildVectorIndex adds the vectors stored in a field of index to a new graph. Vectors that
+// don't fit the field are skipped; for fields without dimensions, the first vector sets them.
+func buildVectorIndex(index bleve.Index, name string, f vector.Field, version indexVersion) (*vectorIndex, error) {
+	req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(version.docs), 0, false)
+	result, err := index.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	graph := vector.NewHNSW(f.Similarity, 0, 0)
+	skipped := 0
+	for _, hit := range result.Hits {
+		doc, err := index.Document(hit.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load document %s: %w", hit.ID, err)
+		}
+		v := storedVector(doc, name)
+		if v == nil {
+			continue
+		}
+		if f.Dims == 0 {
+			f.Dims = len(v)
+		}
+		if f.Check(v) != nil {
+			skipped++
+			continue
+		}
+		graph.Add(hit.ID, v)
+	}
+	if skipped > 0 {
+		slog.Warn("Skipped invalid vectors", "field", name, "vectors", skipped)
+	}
+	slog.Info("Built the kNN graph", "field", name, "vectors", graph.Len())
+	return &vectorIndex{graph: graph, field: f, version: version}, nil
+}
+
+// storedVector returns the vector stored in a field of doc, nil if it has none. Bleve
+// stores arrays as one value per element, ordered here by array position.
+func storedVector(doc index.Document, name string) []float32 {
+	type element struct {
+		pos   uint64
+		value float64
+	}
+	var elements []element
+	if doc == nil {
+		return nil
+	}
+	doc.VisitFields(func(f index.Field) {
+		nf, ok := f.(*document.NumericField)
+		if !ok || f.Name() != name {
+			return
+		}
+		value, err := nf.Number()
+		if err != nil {
+			return
+		}
+		var pos uint64
+		if positions := f.ArrayPositions(); len(positions) > 0 {
+			pos = positions[0]
+		}
+		elements = append(elements, element{pos, value})
+	})
+	if len(elements) == 0 {
+		return nil
+	}
+	sort.Slice(elements, func(i, j int) bool { return elements[i].pos < elements[j].pos })
+	v := make([]float32, len(elements))
+	for i, e := range elements {
+		v[i] = float32(e.value)
+	}
+	return v
+}
@@ -0,0 +1,160 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"common/vector"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseKNNQuery(t *testing.T) {
+	if q, err := ParseKNNQuery("", "", "", ""); q != nil || err != nil {
+		t.Errorf("Expected no kNN query, got %+v (%v)", q, err)
+	}
+	q, err := ParseKNNQuery("embedding", "0.5, 1,-2", "", "")
+	if err != nil || len(q.Vector) != 3 || q.Vector[2] != -2 || q.K != defaultK || q.NumCandidates != minNumCandidates {
+		t.Errorf("Unexpected kNN query %+v (%v)", q, err)
+	}
+	if q, err := ParseKNNQuery("embedding", "1", "500", ""); err != nil || q.NumCandidates != 500 {
+		t.Errorf("Expected the candidates to be at least k, got %+v (%v)", q, err)
+	}
+	invalid := [][4]string{
+		{"embedding", "", "", ""},
+		{"", "1,2", "", ""},
+		{"", "", "5", ""},
+		{"embedding", "1,x", "", ""},
+		{"embedding", "1,2", "0", ""},
+		{"embedding", "1,2", "20", "10"},
+	}
+	for _, params := range invalid {
+		if _, err := ParseKNNQuery(params[0], params[1], params[2], params[3]); err == nil {
+			t.Errorf("Expected an error for %q", params)
+		}
+	}
+}
+
+func TestSearchHandler_KNN(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("catalog")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	if err := svc.SetVectorFields(map[string]vector.Field{"embedding": {Dims: 3}}); err != nil {
+		t.Fatalf("SetVectorFields returned an error: %v", err)
+	}
+	docs := map[string]map[string]interface{}{
+		"boots":   {"text": "leather boots", "category": "shoes", "embedding": []float64{1, 0, 0}},
+		"sandals": {"text": "beach sandals", "category": "shoes", "embedding": []float64{0.9, 0, 0.1}},
+		"hat":     {"text": "straw hat", "category": "hats", "embedding": []float64{0.8, 0, 0.2}},
+		"scarf":   {"text": "wool scarf", "category": "scarves", "embedding": []float64{0, 0, 1}},
+		"plain":   {"text": "boots without embedding"},
+	}
+	for id, doc := range docs {
+		if err := svc.index.Index(id, doc); err != nil {
+			t.Fatalf("Failed to index document: %v", err)
+		}
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	search := func(params url.Values) (int, []SearchHit) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?"+params.Encode(), nil))
+		var body struct {
+			Results []SearchHit `json:"results"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body.Results
+	}
+	ids := func(hits []SearchHit) []string {
+		var out []string
+		for _, h := range hits {
+			out = append(out, h.ID)
+		}
+		return out
+	}
+
+	code, hits := search(url.Values{"knn_field": {"embedding"}, "knn_vector": {"1,0,0"}, "k": {"3"}})
+	if code != http.StatusOK || len(hits) != 3 || hits[0].ID != "boots" || hits[1].ID != "sandals" || hits[2].ID != "hat" {
+		t.Fatalf("Expected the 3 nearest neighbors, got %d %v", code, ids(hits))
+	}
+	if hits[0].Score != 1 || hits[1].Score >= 1 {
+		t.Errorf("Expected the similarities as scores, got %+v", hits)
+	}
+
+	filters := `[{"type":"term","field":"category","value":"shoes"}]`
+	code, hits = search(url.Values{"knn_field": {"embedding"}, "knn_vector": {"0,0,1"}, "k": {"1"}, "filters": {filters}})
+	if code != http.StatusOK || len(hits) != 1 || hits[0].ID != "sandals" {
+		t.Errorf("Expected the nearest neighbor matching the filters, got %d %v", code, ids(hits))
+	}
+	code, hits = search(url.Values{"q": {"straw"}, "knn_field": {"embedding"}, "knn_vector": {"1,0,0"}})
+	if code != http.StatusOK || len(hits) != 1 || hits[0].ID != "hat" {
+		t.Errorf("Expected the neighbors matching the text query, got %d %v", code, ids(hits))
+	}
+
+	// Replacing a document updates its vector.
+	if err := svc.index.Index("scarf", map[string]interface{}{"text": "wool scarf", "embedding": []float64{1, 0, 0}}); err != nil {
+		t.Fatalf("Failed to index document: %v", err)
+	}
+	code, hits = search(url.Values{"knn_field": {"embedding"}, "knn_vector": {"1,0,0"}, "k": {"2"}})
+	if code != http.StatusOK || len(hits) != 2 || hits[1].Score != 1 {
+		t.Errorf("Expected the replaced vector to be searched, got %d %+v", code, hits)
+	}
+
+	if code, _ := search(url.Values{"knn_field": {"embedding"}, "knn_vector": {"1,0"}}); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a vector with the wrong dimensions, got %d", code)
+	}
+}
+
+func TestSearcher_RebuildVectorIndexes(t *testing.T) {
+	svc, err := NewCollectionSearcher("catalog")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	if err := svc.index.Index("boots", map[string]interface{}{"embedding": []float64{1, 0, 0}}); err != nil {
+		t.Fatalf("Failed to index document: %v", err)
+	}
+	q := &KNNQuery{Field: "embedding", Vector: []float32{1, 0, 0}, K: 10, NumCandidates: 10}
+	if neighbors, err := svc.nearestNeighbors(q); err != nil || len(neighbors) != 1 {
+		t.Fatalf("Expected one neighbor, got %v (%v)", neighbors, err)
+	}
+
+	// While the graph is rebuilt, the previous one serves the queries.
+	if err := svc.index.Index("hat", map[string]interface{}{"embedding": []float64{0, 0, 1}}); err != nil {
+		t.Fatalf("Failed to index document: %v", err)
+	}
+	svc.vectorMu.Lock()
+	svc.vectorRebuilds++
+	svc.vectorMu.Unlock()
+	if neighbors, err := svc.nearestNeighbors(q); err != nil || len(neighbors) != 1 {
+		t.Errorf("Expected the previous graph during the rebuild, got %v (%v)", neighbors, err)
+	}
+	svc.vectorMu.Lock()
+	svc.vectorRebuilds--
+	svc.vectorMu.Unlock()
+
+	// A load rebuilds it in the background and swaps it in.
+	svc.rebuildVectorIndexes()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		svc.vectorMu.Lock()
+		rebuilds, vectors := svc.vectorRebuilds, svc.vectorIndexes["embedding"].graph.Len()
+		svc.vectorMu.Unlock()
+		if rebuilds == 0 {
+			if vectors != 2 {
+				t.Errorf("Expected the rebuilt graph to hold 2 vectors, got %d", vectors)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the rebuild to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
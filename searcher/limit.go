@@ -0,0 +1,104 @@
+package searcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrOverloaded is returned for searches shed because the searcher is over capacity.
+var ErrOverloaded = errors.New("searcher is over capacity")
+
+// ConcurrencyConfig bounds the Bleve searches the searcher runs at once. Searches beyond
+// MaxConcurrent wait in a queue of up to MaxQueue searches, for at most QueueTimeout;
+// the others are shed with a 503 so the Broker can try another replica.
+type ConcurrencyConfig struct {
+	MaxConcurrent int           `yaml:"max_concurrent" env:"MAX_CONCURRENT_SEARCHES" flag:"max-concurrent-searches" usage:"Bleve searches run at once; 0 is unlimited"`
+	MaxQueue      int           `yaml:"max_queue" env:"MAX_QUEUED_SEARCHES" flag:"max-queued-searches" usage:"Searches waiting for a slot before new ones are shed"`
+	QueueTimeout  time.Duration `yaml:"queue_timeout" env:"SEARCH_QUEUE_TIMEOUT" flag:"search-queue-timeout" usage:"How long a search waits for a slot before it is shed; 0 waits for the request deadline"`
+}
+
+// Validate checks the limits.
+func (c ConcurrencyConfig) Validate() error {
+	if c.MaxConcurrent < 0 || c.MaxQueue < 0 || c.QueueTimeout < 0 {
+		return fmt.Errorf("search concurrency limits must not be negative")
+	}
+	return nil
+}
+
+// searchLimiter enforces a ConcurrencyConfig. A search takes a queue slot, which also
+// covers running searches, then a running slot.
+type searchLimiter struct {
+	config  ConcurrencyConfig
+	slots   chan struct{} // Running and queued searches
+	running chan struct{} // Running searches
+}
+
+// SetConcurrencyLimit bounds the concurrent searches; a zero MaxConcurrent lifts the limit.
+// It must be called before the searcher serves requests.
+func (s *Searcher) SetConcurrencyLimit(config ConcurrencyConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.MaxConcurrent == 0 {
+		s.limiter = nil
+		return nil
+	}
+	s.limiter = &searchLimiter{
+		config:  config,
+		slots:   make(chan struct{}, config.MaxConcurrent+config.MaxQueue),
+		running: make(chan struct{}, config.MaxConcurrent),
+	}
+	return nil
+}
+
+// acquire waits for a running slot and returns a function releasing it. It fails with an
+// error wrapping ErrOverloaded when the queue is full or the queue timeout elapses, and
+// with the context's error when the request is canceled first. A nil limiter admits
+// every search.
+func (l *searchLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		return nil, fmt.Errorf("%w: %d searches running and %d queued", ErrOverloaded, l.config.MaxConcurrent, l.config.MaxQueue)
+	}
+	var timeout <-chan time.Time
+	if l.config.QueueTimeout > 0 {
+		timer := time.NewTimer(l.config.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.running <- struct{}{}:
+		return func() {
+			<-l.running
+			<-l.slots
+		}, nil
+	case <-timeout:
+		<-l.slots
+		return nil, fmt.Errorf("%w: queued for longer than %s", ErrOverloaded, l.config.QueueTimeout)
+	case <-ctx.Done():
+		<-l.slots
+		return nil, ctx.Err()
+	}
+}
+
+// shedLoad writes the response of a search shed with ErrOverloaded, reporting whether err
+// was one.
+func shedLoad(c *gin.Context, err error) bool {
+	if !errors.Is(err, ErrOverloaded) {
+		return false
+	}
+	slog.WarnContext(c.Request.Context(), "Shedding request", "path", c.Request.URL.Path, "error", err)
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	return true
+}
@@ -0,0 +1,85 @@
+package searcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchLimiter_Acquire(t *testing.T) {
+	svc := &Searcher{}
+	if err := svc.SetConcurrencyLimit(ConcurrencyConfig{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("SetConcurrencyLimit returned an error: %v", err)
+	}
+	l := svc.limiter
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the first search to run, got %v", err)
+	}
+
+	queued := make(chan error)
+	go func() {
+		_, err := l.acquire(context.Background())
+		queued <- err
+	}()
+	for len(l.slots) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := l.acquire(context.Background()); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("Expected ErrOverloaded with a full queue, got %v", err)
+	}
+	if err := <-queued; !errors.Is(err, ErrOverloaded) {
+		t.Errorf("Expected ErrOverloaded after the queue timeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a canceled search to stop waiting, got %v", err)
+	}
+
+	release()
+	release, err = l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Expected a search to run once the slot is released, got %v", err)
+	}
+	release()
+	if len(l.slots) != 0 || len(l.running) != 0 {
+		t.Errorf("Expected every slot to be released, got %d queued and %d running", len(l.slots), len(l.running))
+	}
+}
+
+func TestSearchHandler_LoadShedding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	if err := svc.SetConcurrencyLimit(ConcurrencyConfig{MaxConcurrent: 1}); err != nil {
+		t.Fatalf("SetConcurrencyLimit returned an error: %v", err)
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	release, err := svc.limiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire returned an error: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sample", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a 503 with Retry-After over capacity, got %d", rec.Code)
+	}
+
+	release()
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sample", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 under capacity, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
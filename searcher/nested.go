@@ -0,0 +1,99 @@
+package searcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/blevesearch/bleve/v2/search/searcher"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+// NestedPathField names the field of the parent holding the nested object a sub-document
+// was flattened from by the indexer; sub-documents have the ID <parent>#<path>.<n>.
+// Searches leave sub-documents out; nested filters match the parents of those matching.
+const NestedPathField = "_nested_path"
+
+// excludeNested restricts q to the documents that aren't sub-documents of nested objects.
+func excludeNested(q query.Query) query.Query {
+	nested := bleve.NewWildcardQuery("*")
+	nested.SetField(NestedPathField)
+	top := bleve.NewBooleanQuery()
+	top.AddMust(q)
+	top.AddMustNot(nested)
+	return top
+}
+
+// nestedQuery matches the documents having a nested object at path that matches every
+// filter: the sub-documents of path matching the filters are searched first, and the
+// query then matches their parents.
+type nestedQuery struct {
+	path    string
+	filters query.Query
+}
+
+// Searcher implements query.Query.
+func (q *nestedQuery) Searcher(ctx context.Context, i index.IndexReader, m mapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	path := bleve.NewMatchQuery(q.path)
+	path.SetField(NestedPathField)
+	path.SetOperator(query.MatchQueryOperatorAnd)
+	children, err := bleve.NewConjunctionQuery(path, q.filters).Searcher(ctx, i, m, search.SearcherOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer children.Close()
+
+	sctx := &search.SearchContext{
+		DocumentMatchPool: search.NewDocumentMatchPool(children.DocumentMatchPoolSize(), 0),
+		IndexReader:       i,
+	}
+	suffix := "#" + q.path + "."
+	seen := make(map[string]bool)
+	var parents []string
+	for {
+		match, err := children.Next(sctx)
+		if err != nil {
+			return nil, err
+		}
+		if match == nil {
+			break
+		}
+		id, err := i.ExternalID(match.IndexInternalID)
+		sctx.DocumentMatchPool.Put(match)
+		if err != nil {
+			return nil, err
+		}
+		if n := strings.LastIndex(id, suffix); n > 0 && !seen[id[:n]] {
+			seen[id[:n]] = true
+			parents = append(parents, id[:n])
+		}
+	}
+	return searcher.NewDocIDSearcher(ctx, i, parents, 1.0, options)
+}
+
+// nestedFilterQuery translates a nested filter, whose filters must all be on fields of
+// the objects at its path, e.g. items.price and items.color for items.
+func nestedFilterQuery(f Filter) (query.Query, error) {
+	if len(f.Filters) == 0 {
+		return nil, fmt.Errorf("nested filter on '%s' requires filters", f.Field)
+	}
+	conjuncts := make([]query.Query, 0, len(f.Filters))
+	for _, inner := range f.Filters {
+		if inner.Type == FilterNested {
+			return nil, fmt.Errorf("nested filter on '%s' can't hold nested filters", f.Field)
+		}
+		if !strings.HasPrefix(inner.Field, f.Field+".") {
+			return nil, fmt.Errorf("nested filter on '%s' holds a filter on '%s', outside of it", f.Field, inner.Field)
+		}
+		q, err := inner.Query()
+		if err != nil {
+			return nil, err
+		}
+		conjuncts = append(conjuncts, q)
+	}
+	return &nestedQuery{path: f.Field, filters: bleve.NewConjunctionQuery(conjuncts...)}, nil
+}
@@ -0,0 +1,83 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchHandler_NestedFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("orders")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	// The orders and the sub-documents the indexer flattens their items into.
+	orders := map[string][]map[string]interface{}{
+		"match":   {{"color": "red", "price": 5.0}, {"color": "blue", "price": 50.0}},
+		"crossed": {{"color": "red", "price": 50.0}, {"color": "blue", "price": 5.0}},
+		"none":    {{"color": "green", "price": 1.0}},
+	}
+	for id, items := range orders {
+		if err := svc.index.Index(id, map[string]interface{}{"text": "order", "items": items}); err != nil {
+			t.Fatalf("Failed to index document: %v", err)
+		}
+		for n, item := range items {
+			child := map[string]interface{}{"items": item, "_parent": id, NestedPathField: "items"}
+			if err := svc.index.Index(id+"#items."+strconv.Itoa(n), child); err != nil {
+				t.Fatalf("Failed to index sub-document: %v", err)
+			}
+		}
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	search := func(filters string) []string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=order&filters="+url.QueryEscape(filters), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Results []SearchHit `json:"results"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, hit := range body.Results {
+			ids = append(ids, hit.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	// Flat filters match the items of an order together; sub-documents are never returned.
+	flat := `[{"type":"term","field":"items.color","value":"red"},{"type":"range","field":"items.price","max":10}]`
+	if got := search(flat); len(got) != 2 || got[0] != "crossed" || got[1] != "match" {
+		t.Errorf("Expected both orders with a red item and a cheap one, got %v", got)
+	}
+	nested := `[{"type":"nested","field":"items","filters":[{"type":"term","field":"items.color","value":"red"},{"type":"range","field":"items.price","max":10,"exclusive_max":true}]}]`
+	if got := search(nested); len(got) != 1 || got[0] != "match" {
+		t.Errorf("Expected only the order with a cheap red item, got %v", got)
+	}
+}
+
+func TestParseFilters_Nested(t *testing.T) {
+	for _, param := range []string{
+		`[{"type":"nested","field":"items"}]`,
+		`[{"type":"nested","field":"items","filters":[{"type":"term","field":"color","value":"red"}]}]`,
+		`[{"type":"nested","field":"items","filters":[{"type":"nested","field":"items.parts","filters":[{"type":"term","field":"items.parts.id","value":"1"}]}]}]`,
+	} {
+		if _, err := ParseFilters(param); err == nil {
+			t.Errorf("Expected an error for filters %s", param)
+		}
+	}
+}
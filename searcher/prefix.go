@@ -0,0 +1,69 @@
+package searcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// PrefixMatch matches queries as typed by a user who hasn't finished typing them, for
+// search-as-you-type: the last term is matched as the beginning of a word. The zero value
+// matches queries as they are.
+type PrefixMatch struct {
+	Enabled bool
+	// Fields are indexed with edge n-grams (e.g. with the "autocomplete" analyzer), so the
+	// last term matches as a whole against them. Without fields the last term matches as
+	// the prefix of a term of any field.
+	Fields []string
+}
+
+// ParsePrefixMatch reads the "prefix" (a boolean) and "prefix_fields" (a comma-separated
+// list) parameters.
+func ParsePrefixMatch(prefix, fields string) (PrefixMatch, error) {
+	var p PrefixMatch
+	if prefix != "" {
+		v, err := strconv.ParseBool(prefix)
+		if err != nil {
+			return p, fmt.Errorf("invalid prefix parameter %q", prefix)
+		}
+		p.Enabled = v
+	}
+	for _, field := range strings.Split(fields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			p.Fields = append(p.Fields, field)
+		}
+	}
+	return p, nil
+}
+
+// query builds the query of text: every term but the last must match, with fuzz, and the
+// last must match as a prefix.
+func (p PrefixMatch) query(text string, fuzz Fuzziness) query.Query {
+	terms := strings.Fields(strings.ToLower(text))
+	if len(terms) == 0 {
+		q := bleve.NewMatchQuery(text)
+		fuzz.apply(q)
+		return q
+	}
+	last := terms[len(terms)-1]
+	var prefix query.Query = bleve.NewPrefixQuery(last)
+	if len(p.Fields) > 0 {
+		alternatives := make([]query.Query, 0, len(p.Fields))
+		for _, field := range p.Fields {
+			q := bleve.NewMatchQuery(last)
+			q.SetField(field)
+			alternatives = append(alternatives, q)
+		}
+		prefix = bleve.NewDisjunctionQuery(alternatives...)
+	}
+	if len(terms) == 1 {
+		return prefix
+	}
+	complete := bleve.NewMatchQuery(strings.Join(terms[:len(terms)-1], " "))
+	complete.SetOperator(query.MatchQueryOperatorAnd)
+	fuzz.apply(complete)
+	return bleve.NewConjunctionQuery(complete, prefix)
+}
@@ -0,0 +1,65 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParsePrefixMatch(t *testing.T) {
+	p, err := ParsePrefixMatch("true", "title.autocomplete, name.autocomplete")
+	if err != nil || !p.Enabled || len(p.Fields) != 2 || p.Fields[1] != "name.autocomplete" {
+		t.Errorf("Unexpected prefix match %+v (%v)", p, err)
+	}
+	if p, err := ParsePrefixMatch("", ""); err != nil || p.Enabled || p.Fields != nil {
+		t.Errorf("Expected no prefix match by default, got %+v (%v)", p, err)
+	}
+	if _, err := ParsePrefixMatch("maybe", ""); err == nil {
+		t.Error("Expected an error for an invalid prefix parameter")
+	}
+}
+
+func TestSearchHandler_Prefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("instant")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	for id, text := range map[string]string{"shoes": "red running shoes", "shirt": "red shirt"} {
+		if err := svc.index.Index(id, map[string]interface{}{"text": text}); err != nil {
+			t.Fatalf("Failed to index document: %v", err)
+		}
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	search := func(query string) []string {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Results []SearchHit `json:"results"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		var ids []string
+		for _, hit := range body.Results {
+			ids = append(ids, hit.ID)
+		}
+		return ids
+	}
+
+	if ids := search("q=Red+Sh&prefix=true"); len(ids) != 2 {
+		t.Errorf("Expected the partial term to match both documents, got %v", ids)
+	}
+	if ids := search("q=red+run&prefix=true"); len(ids) != 1 || ids[0] != "shoes" {
+		t.Errorf("Expected only the running shoes to match, got %v", ids)
+	}
+	if ids := search("q=red+run"); len(ids) != 2 {
+		t.Errorf("Expected a plain match on red without prefix, got %v", ids)
+	}
+}
@@ -0,0 +1,134 @@
+package searcher
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Query tree node types understood by the Searcher.
+const (
+	NodeTerm     = "term"      // A single word, analyzed like a match query
+	NodePhrase   = "phrase"    // Words that must appear next to each other, in order
+	NodeBool     = "bool"      // A combination of Must, Should and MustNot clauses
+	NodeMatchAll = "match_all" // Every document
+)
+
+// maxQueryDepth bounds the nesting of a query tree.
+const maxQueryDepth = 32
+
+// QueryNode is a node of a boolean query tree, passed as JSON in the "query" parameter
+// in place of the plain "q" text. Term and phrase nodes match Text, on Field if set or on
+// every field otherwise. A bool node matches the documents matching every Must clause and
+// no MustNot clause, and at least one Should clause when it has no Must clauses.
+type QueryNode struct {
+	Type    string       `json:"type"`
+	Field   string       `json:"field,omitempty"`
+	Text    string       `json:"text,omitempty"`
+	Must    []*QueryNode `json:"must,omitempty"`
+	Should  []*QueryNode `json:"should,omitempty"`
+	MustNot []*QueryNode `json:"must_not,omitempty"`
+}
+
+// ParseQueryTree decodes the query tree from the "query" parameter and checks that it
+// translates into a Bleve query. An empty parameter returns nil.
+func ParseQueryTree(param string) (*QueryNode, error) {
+	if param == "" {
+		return nil, nil
+	}
+	var node QueryNode
+	if err := json.Unmarshal([]byte(param), &node); err != nil {
+		return nil, fmt.Errorf("invalid query tree: %w", err)
+	}
+	if _, err := node.Query(Fuzziness{}); err != nil {
+		return nil, fmt.Errorf("invalid query tree: %w", err)
+	}
+	return &node, nil
+}
+
+// buildTextQuery returns the query matching text, or the query tree if one was given,
+// with the terms matched with fuzz. Text is matched with prefix when it's enabled; query
+// trees are always matched as they are. Without either, every document matches.
+func buildTextQuery(text string, tree *QueryNode, fuzz Fuzziness, prefix PrefixMatch) (query.Query, error) {
+	if tree != nil {
+		return tree.Query(fuzz)
+	}
+	if text == "" {
+		return bleve.NewMatchAllQuery(), nil // A kNN query without text
+	}
+	if prefix.Enabled {
+		return prefix.query(text, fuzz), nil
+	}
+	q := bleve.NewMatchQuery(text)
+	fuzz.apply(q)
+	return q, nil
+}
+
+// Query translates the tree into the equivalent Bleve query, matching term nodes with
+// fuzz.
+func (n *QueryNode) Query(fuzz Fuzziness) (query.Query, error) {
+	return n.query(0, fuzz)
+}
+
+func (n *QueryNode) query(depth int, fuzz Fuzziness) (query.Query, error) {
+	if n == nil {
+		return nil, fmt.Errorf("empty query node")
+	}
+	if depth > maxQueryDepth {
+		return nil, fmt.Errorf("query tree is nested deeper than %d levels", maxQueryDepth)
+	}
+
+	switch n.Type {
+	case NodeTerm, NodePhrase:
+		if n.Text == "" {
+			return nil, fmt.Errorf("%s node requires text", n.Type)
+		}
+		if n.Type == NodeTerm {
+			q := bleve.NewMatchQuery(n.Text)
+			q.SetField(n.Field)
+			fuzz.apply(q)
+			return q, nil
+		}
+		q := bleve.NewMatchPhraseQuery(n.Text)
+		q.SetField(n.Field)
+		return q, nil
+
+	case NodeBool:
+		if len(n.Must)+len(n.Should)+len(n.MustNot) == 0 {
+			return nil, fmt.Errorf("bool node requires at least one clause")
+		}
+		q := bleve.NewBooleanQuery()
+		for _, clauses := range []struct {
+			nodes []*QueryNode
+			add   func(...query.Query)
+		}{
+			{n.Must, q.AddMust},
+			{n.Should, q.AddShould},
+			{n.MustNot, q.AddMustNot},
+		} {
+			for _, c := range clauses.nodes {
+				cq, err := c.query(depth+1, fuzz)
+				if err != nil {
+					return nil, err
+				}
+				clauses.add(cq)
+			}
+		}
+		switch {
+		case len(n.Must) == 0 && len(n.Should) > 0:
+			q.SetMinShould(1)
+		case len(n.Must) == 0:
+			// Only exclusions: everything else matches.
+			q.AddMust(bleve.NewMatchAllQuery())
+		}
+		return q, nil
+
+	case NodeMatchAll:
+		return bleve.NewMatchAllQuery(), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported query node type '%s'", n.Type)
+	}
+}
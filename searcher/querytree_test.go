@@ -0,0 +1,91 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseQueryTree(t *testing.T) {
+	if tree, err := ParseQueryTree(""); err != nil || tree != nil {
+		t.Errorf("Expected no tree for an empty parameter, got %+v (%v)", tree, err)
+	}
+	valid := `{"type":"bool","should":[{"type":"term","text":"red"},{"type":"phrase","field":"title","text":"red shoes"}],"must_not":[{"type":"term","text":"blue"}]}`
+	if _, err := ParseQueryTree(valid); err != nil {
+		t.Errorf("Expected a valid tree, got %v", err)
+	}
+	for _, invalid := range []string{
+		`not json`,
+		`{"type":"fuzzy","text":"red"}`,
+		`{"type":"term"}`,
+		`{"type":"bool"}`,
+		`{"type":"bool","must":[{"type":"phrase"}]}`,
+	} {
+		if _, err := ParseQueryTree(invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestSearchHandler_QueryTree(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("querytree")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	docs := map[string]map[string]interface{}{
+		"red-shoes":  {"title": "red running shoes"},
+		"shoes-red":  {"title": "shoes in red"},
+		"blue-shoes": {"title": "blue running shoes"},
+	}
+	for id, doc := range docs {
+		if err := svc.index.Index(id, doc); err != nil {
+			t.Fatalf("Failed to index %s: %v", id, err)
+		}
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	search := func(tree string) map[string]bool {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?query="+url.QueryEscape(tree), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d: %s", tree, rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Results []SearchHit `json:"results"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		ids := make(map[string]bool)
+		for _, hit := range body.Results {
+			ids[hit.ID] = true
+		}
+		return ids
+	}
+
+	if ids := search(`{"type":"phrase","field":"title","text":"red running shoes"}`); len(ids) != 1 || !ids["red-shoes"] {
+		t.Errorf("Expected the phrase to match only red-shoes, got %v", ids)
+	}
+	if ids := search(`{"type":"bool","must":[{"type":"term","text":"shoes"}],"must_not":[{"type":"term","text":"red"}]}`); len(ids) != 1 || !ids["blue-shoes"] {
+		t.Errorf("Expected shoes without red to match only blue-shoes, got %v", ids)
+	}
+	if ids := search(`{"type":"bool","must_not":[{"type":"term","text":"running"}]}`); !ids["shoes-red"] || ids["red-shoes"] || ids["blue-shoes"] {
+		t.Errorf("Expected exclusions alone to match everything else, got %v", ids)
+	}
+	if ids := search(`{"type":"match_all"}`); len(ids) != 3 {
+		t.Errorf("Expected match_all to match every document, got %v", ids)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?query="+url.QueryEscape(`{"type":"bool"}`), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid query tree, got %d", rec.Code)
+	}
+}
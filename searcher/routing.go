@@ -0,0 +1,117 @@
+package searcher
+
+import (
+	"fmt"
+	"net/http"
+
+	"common/bloom"
+
+	"github.com/blevesearch/bleve/v2/numeric"
+	"github.com/gin-gonic/gin"
+)
+
+// routingFalsePositiveRate is the false positive rate of the Bloom filters of routing
+// summaries: the share of term filters on values missing from a shard that still search it.
+const routingFalsePositiveRate = 0.01
+
+// RoutingSummary summarizes the values of a field of the index, such as the routing key
+// documents are sharded by, so that the broker skips the shard for filters it can't match.
+type RoutingSummary struct {
+	Field     string        `json:"field"`
+	Documents uint64        `json:"documents"` // Documents in the index
+	Terms     *bloom.Filter `json:"terms"`     // Bloom filter of the terms of the field
+	// MinTerm and MaxTerm bound the terms of the field; they are empty if it has none.
+	MinTerm string `json:"min_term,omitempty"`
+	MaxTerm string `json:"max_term,omitempty"`
+	// Min and Max bound the numeric values of the field; they are nil if it has none.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// Segment is the segment summarized; it is empty if unknown.
+	Segment string `json:"segment,omitempty"`
+}
+
+// RoutingSummary returns the summary of the values of field in the index. Numeric values,
+// indexed as prefix-coded terms, are summarized by their range only.
+func (s *Searcher) RoutingSummary(field string) (*RoutingSummary, error) {
+	// The segment is read before the index, so a segment loaded meanwhile is not claimed.
+	segment := s.Segment()
+	index, release, err := s.acquireIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	documents, err := index.DocCount()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count documents: %w", err)
+	}
+	dict, err := index.FieldDict(field)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the term dictionary of field %s: %w", field, err)
+	}
+	defer dict.Close()
+
+	summary := &RoutingSummary{Field: field, Documents: documents, Segment: segment}
+	var terms []string
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the term dictionary of field %s: %w", field, err)
+		}
+		if entry == nil {
+			break
+		}
+		if shift, err := numeric.PrefixCoded(entry.Term).Shift(); err == nil {
+			// Only full precision terms hold a value; the others index its ranges.
+			if shift == 0 {
+				summary.addNumber(numeric.PrefixCoded(entry.Term))
+			}
+			continue
+		}
+		terms = append(terms, entry.Term)
+	}
+	summary.Terms = bloom.New(len(terms), routingFalsePositiveRate)
+	for _, term := range terms {
+		summary.Terms.Add(term)
+	}
+	// The dictionary is sorted, so its first and last terms bound it.
+	if len(terms) > 0 {
+		summary.MinTerm, summary.MaxTerm = terms[0], terms[len(terms)-1]
+	}
+	return summary, nil
+}
+
+// addNumber widens the numeric range of the summary to the value of a prefix-coded term.
+func (r *RoutingSummary) addNumber(term numeric.PrefixCoded) {
+	i, err := term.Int64()
+	if err != nil {
+		return
+	}
+	v := numeric.Int64ToFloat64(i)
+	if r.Min == nil || v < *r.Min {
+		r.Min = &v
+	}
+	if r.Max == nil || v > *r.Max {
+		// Min and Max mustn't share the variable.
+		max := v
+		r.Max = &max
+	}
+}
+
+// RoutingHandler returns the summary of the values of a field at GET /routing?field=..., which
+// the broker prunes the shards of searches filtering on the field with.
+func (s *Searcher) RoutingHandler(c *gin.Context) {
+	if !s.checkScope(c) {
+		return
+	}
+	field := c.Query("field")
+	if field == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'field' is required"})
+		return
+	}
+	summary, err := s.RoutingSummary(field)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
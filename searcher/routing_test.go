@@ -0,0 +1,63 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearcher_RoutingSummary(t *testing.T) {
+	svc, err := NewCollectionSearcher("orders")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	docs := map[string]map[string]interface{}{
+		"1": {"customer": "acme", "total": 12.5},
+		"2": {"customer": "globex", "total": 99.0},
+		"3": {"customer": "initech", "total": -3.0},
+	}
+	for id, doc := range docs {
+		if err := svc.index.Index(id, doc); err != nil {
+			t.Fatalf("Failed to index document %s: %v", id, err)
+		}
+	}
+
+	summary, err := svc.RoutingSummary("customer")
+	if err != nil {
+		t.Fatalf("RoutingSummary returned an error: %v", err)
+	}
+	if summary.Documents != 3 || summary.MinTerm != "acme" || summary.MaxTerm != "initech" || summary.Min != nil {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if !summary.Terms.Test("globex") || summary.Terms.Test("umbrella") {
+		t.Error("Expected the Bloom filter to hold the customers only")
+	}
+	numbers, err := svc.RoutingSummary("total")
+	if err != nil {
+		t.Fatalf("RoutingSummary returned an error: %v", err)
+	}
+	if numbers.Min == nil || *numbers.Min != -3 || numbers.Max == nil || *numbers.Max != 99 || numbers.MinTerm != "" {
+		t.Errorf("Expected the numeric range [-3, 99], got %+v", numbers)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/routing", svc.RoutingHandler)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/routing?field=customer", nil))
+	var resp RoutingSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	if !resp.Terms.Test("acme") {
+		t.Errorf("Expected the decoded filter to hold acme, got %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/routing", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a field, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,111 @@
+package searcher
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Score modes of a ScoreScript: how the value of its expression combines with the score
+// Bleve computed.
+const (
+	ScoreModeReplace  = "replace"  // The value is the score
+	ScoreModeMultiply = "multiply" // The score is multiplied by the value
+	ScoreModeSum      = "sum"      // The value is added to the score
+)
+
+const (
+	// ScoreVariable holds the Bleve score of the hit in score expressions.
+	ScoreVariable = "_score"
+	// scoreScriptWindow is the number of top hits rescored by a script, of which the best
+	// page is returned.
+	scoreScriptWindow = 100
+	// maxScoreScriptLength bounds the length of score expressions.
+	maxScoreScriptLength = 1024
+)
+
+// ScoreScript rescores hits with an expression in the expr language evaluated against
+// their stored fields, e.g. "_score * log(1 + popularity)", so the ranking can follow
+// business rules without redeploying.
+type ScoreScript struct {
+	Expression string
+	Mode       string
+	program    *vm.Program
+}
+
+// ParseScoreScript reads the "score_script" and "score_mode" parameters. It returns nil
+// without expression; the mode defaults to ScoreModeReplace.
+func ParseScoreScript(expression, mode string) (*ScoreScript, error) {
+	if expression == "" {
+		if mode != "" {
+			return nil, fmt.Errorf("score_mode requires a score_script")
+		}
+		return nil, nil
+	}
+	if len(expression) > maxScoreScriptLength {
+		return nil, fmt.Errorf("score_script is longer than %d characters", maxScoreScriptLength)
+	}
+	switch mode {
+	case "":
+		mode = ScoreModeReplace
+	case ScoreModeReplace, ScoreModeMultiply, ScoreModeSum:
+	default:
+		return nil, fmt.Errorf("invalid score_mode %q, must be %s, %s or %s", mode, ScoreModeReplace, ScoreModeMultiply, ScoreModeSum)
+	}
+	program, err := expr.Compile(expression, expr.AllowUndefinedVariables())
+	if err != nil {
+		return nil, fmt.Errorf("invalid score_script: %w", err)
+	}
+	return &ScoreScript{Expression: expression, Mode: mode, program: program}, nil
+}
+
+// rescore sets the score of every hit from the expression, evaluated against its stored
+// fields and ScoreVariable. Hits are sorted by their new scores unless byScore is false.
+func (s *ScoreScript) rescore(hits search.DocumentMatchCollection, byScore bool) error {
+	for _, hit := range hits {
+		env := make(map[string]interface{}, len(hit.Fields)+1)
+		for name, value := range hit.Fields {
+			env[name] = value
+		}
+		env[ScoreVariable] = hit.Score
+		out, err := expr.Run(s.program, env)
+		if err != nil {
+			return fmt.Errorf("score_script failed on document %s: %w", hit.ID, err)
+		}
+		value, ok := toFloat(out)
+		if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("score_script returned %v for document %s, expected a number", out, hit.ID)
+		}
+		switch s.Mode {
+		case ScoreModeMultiply:
+			hit.Score *= value
+		case ScoreModeSum:
+			hit.Score += value
+		default:
+			hit.Score = value
+		}
+	}
+	if byScore {
+		sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	}
+	return nil
+}
+
+// toFloat converts the numbers of expressions to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
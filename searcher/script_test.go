@@ -0,0 +1,79 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseScoreScript(t *testing.T) {
+	if s, err := ParseScoreScript("", ""); s != nil || err != nil {
+		t.Errorf("Expected no script, got %+v (%v)", s, err)
+	}
+	s, err := ParseScoreScript("_score * boost", "")
+	if err != nil || s.Mode != ScoreModeReplace {
+		t.Errorf("Expected the replace mode by default, got %+v (%v)", s, err)
+	}
+	invalid := [][2]string{{"", "sum"}, {"_score *", ""}, {"_score", "max"}}
+	for _, params := range invalid {
+		if _, err := ParseScoreScript(params[0], params[1]); err == nil {
+			t.Errorf("Expected an error for %q", params)
+		}
+	}
+}
+
+func TestSearchHandler_ScoreScript(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("catalog")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	docs := map[string]map[string]interface{}{
+		"best":    {"text": "boots boots boots", "popularity": 1},
+		"popular": {"text": "boots", "popularity": 50},
+		"plain":   {"text": "boots and shoes"},
+	}
+	for id, doc := range docs {
+		if err := svc.index.Index(id, doc); err != nil {
+			t.Fatalf("Failed to index document: %v", err)
+		}
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	search := func(params url.Values) (int, []SearchHit) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?"+params.Encode(), nil))
+		var body struct {
+			Results []SearchHit `json:"results"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body.Results
+	}
+
+	code, hits := search(url.Values{"q": {"boots"}, "score_script": {"popularity ?? 0"}})
+	if code != http.StatusOK || len(hits) != 3 || hits[0].ID != "popular" || hits[0].Score != 50 || hits[2].Score != 0 {
+		t.Errorf("Expected the results ranked by popularity, got %d %+v", code, hits)
+	}
+	if _, ok := hits[0].Fields["popularity"]; ok {
+		t.Errorf("Expected only the requested fields, got %v", hits[0].Fields)
+	}
+
+	_, plain := search(url.Values{"q": {"boots"}})
+	_, summed := search(url.Values{"q": {"boots"}, "score_script": {"popularity ?? 0"}, "score_mode": {ScoreModeSum}})
+	scores := make(map[string]float64)
+	for _, hit := range plain {
+		scores[hit.ID] = hit.Score
+	}
+	if len(summed) != 3 || summed[0].ID != "popular" || summed[0].Score != scores["popular"]+50 {
+		t.Errorf("Expected the popularity added to the scores, got %+v", summed)
+	}
+
+	if code, _ := search(url.Values{"q": {"boots"}, "score_script": {`"high"`}}); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a script returning a string, got %d", code)
+	}
+}
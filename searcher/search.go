@@ -2,50 +2,152 @@ package searcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"common/commitbus"
+	"common/slowlog"
+	"common/suggest"
+	"common/tenant"
+	"common/vector"
+
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// tracer is used to create spans around Bleve query execution.
+var tracer = otel.Tracer("searcher")
+
 const (
-	segmentsDir = "./segments" // Directory to store downloaded segments
+	segmentsDir       = "./segments" // Directory to store downloaded segments
+	DefaultCollection = "default"    // Collection served when none is configured
+	defaultSearchSize = 10           // Hits returned when the request doesn't set a size
+	maxSearchSize     = 1000         // Largest size of a request, as scrolls page through hits
 )
 
 // Searcher represents the search service
 type Searcher struct {
-	index bleve.Index
+	index      bleve.Index            // Nil while evicted by shards; use acquireIndex
+	shards     *ShardCache            // Opens and evicts the index; nil keeps it open
+	shard      *shard                 // State of the index in shards
+	collection string                 // Logical collection served by this searcher
+	tenant     string                 // Tenant owning the collection; empty for the default tenant
+	limiter    *searchLimiter         // Bounds the concurrent searches; nil is unlimited
+	commits    chan string            // Segments announced by the Indexer and not downloaded yet
+	tiers      *segmentTiers          // Local cache of the segments by tier; nil simulates downloads
+	segment    atomic.Pointer[string] // Latest segment loaded, reported with every search; nil before the first
+	loads      *commitbus.Publisher   // Announces the segments loaded; nil announces none
+
+	suggestMu   sync.RWMutex
+	suggestions *suggest.Index // Completions served by SuggestHandler; nil serves none
+
+	slowLog *slowlog.Logger // Records the searches slower than its threshold; nil disables it
+
+	vectorMu       sync.Mutex
+	vectorFields   map[string]vector.Field // Dimensions and similarity of vector fields; others are compared by cosine
+	vectorIndexes  map[string]*vectorIndex // kNN graphs by field, built on their first query and rebuilt on loads
+	vectorGen      uint64                  // Incremented when vectorIndexes is reset; graphs built before are dropped
+	vectorRebuilds int                     // Rebuilds of the graphs running in the background
 }
 
-// NewSearcher initializes a new Searcher instance
+// NewSearcher initializes a new Searcher instance serving the default collection.
 func NewSearcher() (*Searcher, error) {
+	return NewCollectionSearcher(DefaultCollection)
+}
+
+// NewCollectionSearcher initializes a new Searcher instance serving the given collection.
+func NewCollectionSearcher(collection string) (*Searcher, error) {
 	// For demonstration, we'll create a new in-memory index.
 	// In a real scenario, this would involve loading/opening an existing Lucene index
 	// potentially from downloaded segments.
-	mapping := bleve.NewIndexMapping()
-	index, err := bleve.NewMemOnly(mapping) // Using in-memory for statelessness example
+	index, err := bleve.NewMemOnly(newIndexMapping()) // Using in-memory for statelessness example
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Bleve index: %w", err)
 	}
-	return &Searcher{index: index}, nil
+	return &Searcher{index: index, collection: collection, commits: make(chan string, 1)}, nil
+}
+
+// Collection returns the name of the collection served by this searcher.
+func (s *Searcher) Collection() string {
+	return s.collection
 }
 
-// downloadSegments simulates downloading index segments from a storage layer.
-// In a real implementation, this would involve interacting with S3, GCS, etc.
-func (s *Searcher) downloadSegments(ctx context.Context) error {
-	log.Println("Simulating downloading latest index segments...")
+// Close closes the index. The searcher must not serve requests afterwards.
+func (s *Searcher) Close() error {
+	if s.shards != nil {
+		return s.shards.close(s)
+	}
+	return s.index.Close()
+}
+
+// Segment returns the latest segment the searcher loaded, empty before the first. Search
+// responses report it, so the Broker knows which version of the collection they hold.
+func (s *Searcher) Segment() string {
+	if segment := s.segment.Load(); segment != nil {
+		return *segment
+	}
+	return ""
+}
+
+// downloadSegments brings the local segments up to date with the storage layer: with
+// tiering, the segment tiers are refreshed from the segment store; otherwise a download
+// of announced, the segment of the last commit, is simulated. Segments are kept in a
+// per-tenant and per-collection subdirectory, mirroring the Indexer's storage layout.
+func (s *Searcher) downloadSegments(ctx context.Context, announced string) error {
+	collectionDir := filepath.Join(segmentsDir, filepath.FromSlash(tenant.StoragePrefix(s.tenant)), s.collection)
+	if s.tiers != nil {
+		// Warm segments are fetched right away, cold ones when a request needs them.
+		slog.Debug("Refreshing the segment tiers", "collection", s.collection)
+		if err := s.tiers.refresh(); err != nil {
+			return fmt.Errorf("failed to refresh segment tiers: %w", err)
+		}
+		collectionDir = s.tiers.dir
+		announced = s.tiers.latestSegment()
+	} else if err := simulateSegmentDownload(collectionDir); err != nil {
+		return err
+	}
+
+	// Segments packaged by the Indexer as a single archive are unpacked transparently.
+	if err := unpackSegments(collectionDir); err != nil {
+		return err
+	}
+	// The Indexer ships the completion dictionary with every segment.
+	if err := s.loadLatestSuggestions(collectionDir); err != nil {
+		return err
+	}
+	// The kNN graphs are rebuilt from the new segments in the background.
+	s.rebuildVectorIndexes()
+
+	// In a real Lucene implementation, you would then load these segments
+	// into a Directory and open an IndexReader.
+	if announced != "" {
+		s.loaded(announced)
+	}
+	return nil
+}
+
+// simulateSegmentDownload writes a dummy segment file into collectionDir.
+func simulateSegmentDownload(collectionDir string) error {
+	slog.Debug("Simulating downloading latest index segments", "dir", collectionDir)
 	// Ensure segments directory exists
-	if err := os.MkdirAll(segmentsDir, 0755); err != nil {
+	if err := os.MkdirAll(collectionDir, 0755); err != nil {
 		return fmt.Errorf("failed to create segments directory: %w", err)
 	}
 
 	// Simulate downloading a segment file
-	segmentFilePath := filepath.Join(segmentsDir, fmt.Sprintf("segment_%d.txt", time.Now().Unix()))
+	segmentFilePath := filepath.Join(collectionDir, fmt.Sprintf("segment_%d.txt", time.Now().Unix()))
 	file, err := os.Create(segmentFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to create dummy segment file: %w", err)
@@ -53,81 +155,346 @@ func (s *Searcher) downloadSegments(ctx context.Context) error {
 	file.WriteString("This is a dummy index segment content.")
 	file.Close()
 
-	log.Printf("Dummy segment downloaded to: %s\n", segmentFilePath)
-
-	// In a real Lucene implementation, you would then load these segments
-	// into a Directory and open an IndexReader.
+	slog.Debug("Dummy segment downloaded", "path", segmentFilePath)
 	return nil
 }
 
-// updateIndex periodically checks for and downloads new segments.
-func (s *Searcher) UpdateIndex(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Minute) // Check for new segments every 5 minutes
-	defer ticker.Stop()
+// UpdateIndex downloads new segments as soon as the Indexer announces them through
+// NotifyCommit. Segments are also checked every pollInterval, so commits whose
+// notification was lost are picked up eventually; a zero pollInterval disables polling.
+func (s *Searcher) UpdateIndex(ctx context.Context, pollInterval time.Duration) {
+	var poll <-chan time.Time
+	if pollInterval > 0 {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
 
 	for {
+		var segment string
 		select {
-		case <-ticker.C:
-			log.Println("Checking for new index segments...")
-			if err := s.downloadSegments(ctx); err != nil {
-				log.Printf("Error downloading segments: %v\n", err)
-			}
-			// After downloading, you would typically rebuild/reopen your Lucene index
-			// with the new segments.
+		case <-poll:
+			slog.Debug("Checking for new index segments")
+		case segment = <-s.commits:
+			slog.Info("Downloading index segments after commit", "segment", segment)
 		case <-ctx.Done():
-			log.Println("Stopping index update routine.")
+			slog.Info("Stopping index update routine")
 			return
 		}
+		if err := s.downloadSegments(ctx, segment); err != nil {
+			slog.Error("Failed to download segments", "error", err)
+		}
+		// After downloading, you would typically rebuild/reopen your Lucene index
+		// with the new segments.
 	}
 }
 
 // SearchHandler handles search queries from the Broker.
 func (s *Searcher) SearchHandler(c *gin.Context) {
+	defer s.traceSlowSearch(c)()
 	query := c.Query("q")
-	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'q' is required"})
+	tree, err := ParseQueryTree(c.Query("query"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	knn, err := ParseKNNQuery(c.Query("knn_field"), c.Query("knn_vector"), c.Query("k"), c.Query("num_candidates"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query == "" && tree == nil && knn == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'q', 'query' or 'knn_vector' is required"})
+		return
+	}
+	if !s.checkScope(c) {
 		return
 	}
 
 	// In a real Lucene implementation, you would parse the query,
 	// execute it against your Lucene index, and format results.
 	// For this Bleve example, we'll perform a simple query.
-	searchQuery := bleve.NewMatchQuery(query)
+	sortSpecs, err := ParseSortParam(c.Query("sort"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters, err := ParseFilters(c.Query("filters"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	geoQuery, err := ParseGeoQuery(c.Query("lat"), c.Query("lon"), c.Query("radius"), c.Query("geo_field"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if geoQuery != nil {
+		if f, ok := geoQuery.Filter(); ok {
+			filters = append(filters, f)
+		}
+	}
+
+	fuzz, err := ParseFuzziness(c.Query("fuzziness"), c.Query("prefix_length"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	prefix, err := ParsePrefixMatch(c.Query("prefix"), c.Query("prefix_fields"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	textQuery, err := buildTextQuery(query, tree, fuzz, prefix)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	searchQuery, err := applyFilters(textQuery, filters)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	typeField, defaultType := s.documentTypes()
+	searchQuery = restrictTypes(searchQuery, ParseTypes(c.Query("types")), typeField, defaultType)
+	searchQuery = excludeExpired(searchQuery, time.Now())
+	searchQuery = excludeNested(searchQuery)
+	var neighbors []vector.Neighbor
+	if knn != nil {
+		knnStart := time.Now()
+		neighbors, err = s.nearestNeighbors(knn)
+		slowTraceFrom(c.Request.Context()).record(StageKNN, knnStart)
+		if err != nil {
+			if errors.Is(err, vector.ErrInvalidVector) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else {
+				slog.ErrorContext(c.Request.Context(), "Failed to find nearest neighbors", "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to perform search"})
+			}
+			return
+		}
+		searchQuery = restrictToNeighbors(searchQuery, neighbors)
+	}
+	explain := false
+	if raw := c.Query("explain"); raw != "" {
+		if explain, err = strconv.ParseBool(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid explain parameter %q", raw)})
+			return
+		}
+	}
+
+	script, err := ParseScoreScript(c.Query("score_script"), c.Query("score_mode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	searchAfter, err := ParseSearchAfter(c.Query("search_after"), sortSpecs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pageSize := defaultSearchSize
+	if raw := c.Query("size"); raw != "" {
+		if pageSize, err = strconv.Atoi(raw); err != nil || pageSize <= 0 || pageSize > maxSearchSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be between 1 and %d", maxSearchSize)})
+			return
+		}
+	}
+
+	fields := ParseFieldList(c.Query("fields"), DefaultResultFields)
+
 	searchRequest := bleve.NewSearchRequest(searchQuery)
-	searchResults, err := s.index.Search(searchRequest)
+	searchRequest.Size = pageSize
+	searchRequest.SearchAfter = searchAfter
+	searchRequest.Explain = explain
+	searchRequest.Fields = append(searchRequest.Fields, fields...)
+	if len(sortSpecs) > 0 {
+		order, err := toBleveSortOrder(sortSpecs, geoQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		searchRequest.SortByCustom(order)
+		searchRequest.Fields = append(searchRequest.Fields, sortFieldNames(sortSpecs)...)
+	}
+	if geoQuery != nil {
+		// The geopoint is needed to compute each hit's distance.
+		searchRequest.Fields = append(searchRequest.Fields, geoQuery.Field)
+	}
+	if knn != nil {
+		// Every candidate matching the query is fetched, then ranked by similarity.
+		searchRequest.Size = len(neighbors)
+	}
+	size := searchRequest.Size
+	if knn != nil && size > knn.K {
+		size = knn.K
+	}
+	if script != nil {
+		// The script may read any stored field, and rescores the top hits of a wider
+		// window unless they are sorted otherwise.
+		searchRequest.Fields = append(searchRequest.Fields, AllFields)
+		if len(sortSpecs) == 0 && searchRequest.Size < scoreScriptWindow {
+			searchRequest.Size = scoreScriptWindow
+		}
+	}
+	searchResults, err := s.executeSearch(c.Request.Context(), searchRequest)
+	if shedLoad(c, err) || abortCancelled(c, err) {
+		return
+	}
 	if err != nil {
-		log.Printf("Error executing search: %v\n", err)
+		slog.ErrorContext(c.Request.Context(), "Failed to execute search", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to perform search"})
 		return
 	}
 
 	// Simulate adding some dummy documents for search to work with Bleve
-	if searchResults.Total == 0 {
+	if searchResults.Total == 0 && knn == nil {
 		// Only index if no documents found (first run)
-		log.Println("No documents in index, adding dummy document...")
+		slog.InfoContext(c.Request.Context(), "No documents in index, adding dummy document")
 		docID := "doc1"
 		data := map[string]interface{}{
 			"text":    "This is a sample document for testing the searcher service.",
 			"another": "another field content",
 		}
-		if err := s.index.Index(docID, data); err != nil {
-			log.Printf("Error indexing dummy document: %v\n", err)
+		if err := s.indexDocument(docID, data); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to index dummy document", "error", err)
 		} else {
-			log.Println("Dummy document indexed.")
+			slog.InfoContext(c.Request.Context(), "Dummy document indexed")
 			// Re-run search after indexing
-			searchResults, err = s.index.Search(searchRequest)
+			searchResults, err = s.executeSearch(c.Request.Context(), searchRequest)
+			if shedLoad(c, err) || abortCancelled(c, err) {
+				return
+			}
 			if err != nil {
-				log.Printf("Error re-executing search after indexing: %v\n", err)
+				slog.ErrorContext(c.Request.Context(), "Failed to re-execute search after indexing", "error", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to perform search after indexing"})
 				return
 			}
 		}
 	}
 
-	log.Printf("Search query: '%s', Results: %d hits\n", query, searchResults.Total)
+	if knn != nil {
+		scoreNeighbors(searchResults, neighbors, len(sortSpecs) == 0)
+		if len(searchResults.Hits) > size {
+			searchResults.Hits = searchResults.Hits[:size]
+		}
+	}
+	if script != nil {
+		rescoreStart := time.Now()
+		err = script.rescore(searchResults.Hits, len(sortSpecs) == 0)
+		slowTraceFrom(c.Request.Context()).record(StageRescore, rescoreStart)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(searchResults.Hits) > size {
+			searchResults.Hits = searchResults.Hits[:size]
+		}
+	}
+
+	slog.DebugContext(c.Request.Context(), "Searched", "query", query, "hits", searchResults.Total)
+	hits := toSearchHits(searchResults.Hits, fields, sortSpecs, geoQuery)
+	fillDefaultType(hits, fields, typeField, defaultType)
 	c.JSON(http.StatusOK, gin.H{
 		"query":      query,
-		"results":    searchResults.Hits,
+		"collection": s.collection,
+		"segment":    s.Segment(),
+		"results":    hits,
 		"total_hits": searchResults.Total,
 	})
 }
+
+// executeSearch runs the search request against the Bleve index inside a tracing span,
+// once the concurrency limit admits it.
+func (s *Searcher) executeSearch(ctx context.Context, req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	ctx, span := tracer.Start(ctx, "bleve.Search")
+	defer span.End()
+	trace := slowTraceFrom(ctx)
+
+	queueStart := time.Now()
+	release, err := s.limiter.acquire(ctx)
+	trace.record(StageQueue, queueStart)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer release()
+	openStart := time.Now()
+	if s.tiers != nil {
+		if err := s.tiers.openAll(); err != nil {
+			trace.record(StageOpen, openStart)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, fmt.Errorf("failed to fetch cold segments: %w", err)
+		}
+	}
+	index, releaseIndex, err := s.acquireIndex()
+	trace.record(StageOpen, openStart)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer releaseIndex()
+	searchStart := time.Now()
+	result, err := index.SearchInContext(ctx, req)
+	trace.record(StageSearch, searchStart)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	trace.searched(req, result)
+	span.SetAttributes(
+		attribute.Int64("search.total_hits", int64(result.Total)),
+		attribute.Int64("search.took_us", result.Took.Microseconds()),
+	)
+	return result, nil
+}
+
+// indexDocument adds a document to the index.
+func (s *Searcher) indexDocument(id string, data interface{}) error {
+	index, release, err := s.acquireIndex()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return index.Index(id, data)
+}
+
+// SearchHit is a single search result returned by the SearchHandler.
+type SearchHit struct {
+	ID         string                 `json:"id"`
+	Score      float64                `json:"score"`
+	Fields     map[string]interface{} `json:"fields,omitempty"`
+	SortValues []interface{}          `json:"sort_values,omitempty"` // Typed sort key, one value per sort field
+	SortKey    []string               `json:"sort_key,omitempty"`    // Opaque sort key the search_after parameter resumes after
+	DistanceKm *float64               `json:"distance_km,omitempty"` // Distance from the geo query origin
+	// Explanation breaks the score down into its components; set when explain is requested.
+	Explanation *search.Explanation `json:"explanation,omitempty"`
+}
+
+// toSearchHits converts Bleve hits into SearchHits carrying the selected stored fields,
+// attaching sort values when sorting was requested and distances when the search has a
+// geo query.
+func toSearchHits(hits search.DocumentMatchCollection, fields []string, sortSpecs []SortSpec, geoQuery *GeoQuery) []SearchHit {
+	result := make([]SearchHit, 0, len(hits))
+	for _, hit := range hits {
+		h := SearchHit{ID: hit.ID, Score: hit.Score, Fields: selectFields(hit.Fields, fields), Explanation: hit.Expl}
+		if len(sortSpecs) > 0 {
+			h.SortValues = sortValues(hit, sortSpecs, geoQuery)
+			h.SortKey = sortKey(hit, sortSpecs)
+		}
+		if geoQuery != nil {
+			if d, ok := geoQuery.DistanceKm(hit); ok {
+				h.DistanceKm = &d
+			}
+		}
+		result = append(result, h)
+	}
+	return result
+}
@@ -1,8 +1,110 @@
 package searcher
 
-import "testing"
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
 
 func TestPlaceholder(t *testing.T) {
 	// This is a placeholder test.
 	// Add actual tests here later.
 }
+
+func TestSearchHandler_Collection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sample&collection=articles", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a collection not served by the searcher, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sample&collection=products", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body["collection"] != "products" {
+		t.Errorf("Expected collection 'products' in response, got %v", body["collection"])
+	}
+}
+
+func TestSearchHandler_Tenant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	if err := svc.SetTenant("Not Valid"); err == nil {
+		t.Error("Expected an error for an invalid tenant, got nil")
+	}
+	if err := svc.SetTenant("shop"); err != nil {
+		t.Fatalf("SetTenant returned an error: %v", err)
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	for target, want := range map[string]int{
+		"/search?q=sample":               http.StatusNotFound, // The default tenant
+		"/search?q=sample&tenant=blog":   http.StatusNotFound,
+		"/search?q=sample&tenant=..%2Fx": http.StatusBadRequest,
+		"/search?q=sample&tenant=shop":   http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected status %d, got %d: %s", target, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestSearchHandler_Explain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("explain")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	search := func(query string) (int, []SearchHit) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=sample"+query, nil))
+		var body struct {
+			Results []SearchHit `json:"results"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body.Results
+	}
+
+	code, hits := search("&explain=true")
+	if code != http.StatusOK || len(hits) == 0 {
+		t.Fatalf("Expected hits with status 200, got %d and %d hits", code, len(hits))
+	}
+	for _, hit := range hits {
+		if hit.Explanation == nil || hit.Explanation.Value != hit.Score {
+			t.Errorf("Expected an explanation matching the score of %s, got %+v", hit.ID, hit.Explanation)
+		}
+	}
+
+	if _, hits := search(""); len(hits) == 0 || hits[0].Explanation != nil {
+		t.Errorf("Expected no explanation without explain, got %+v", hits)
+	}
+	if code, _ := search("&explain=maybe"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid explain parameter, got %d", code)
+	}
+}
@@ -0,0 +1,116 @@
+package searcher
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"common/archive"
+)
+
+// segmentManifestFile is the manifest the Indexer stores alongside every uploaded segment.
+const segmentManifestFile = "manifest.json"
+
+// segmentManifest holds the parts of the Indexer's segment manifest needed to fetch and
+// unpack a segment.
+type segmentManifest struct {
+	Segment     string    `json:"segment"`
+	CreatedAt   time.Time `json:"created_at"`
+	Compression string    `json:"compression,omitempty"`
+	Archive     string    `json:"archive,omitempty"`
+	Files       []struct {
+		Path    string `json:"path"`
+		Size    int64  `json:"size"`
+		Segment string `json:"segment,omitempty"` // Earlier segment holding the file, if unchanged
+		SHA256  string `json:"sha256,omitempty"`
+	} `json:"files"`
+}
+
+// validate rejects manifests naming an archive or an earlier segment that isn't a single
+// path element, or files outside of the segment, which would be read or written outside
+// of the segment directories.
+func (m *segmentManifest) validate() error {
+	if m.Archive != "" && validateSegmentName(m.Archive) != nil {
+		return fmt.Errorf("invalid archive name %q", m.Archive)
+	}
+	for _, f := range m.Files {
+		if f.Segment != "" {
+			if err := validateSegmentName(f.Segment); err != nil {
+				return err
+			}
+		}
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) {
+			return fmt.Errorf("invalid file path %q", f.Path)
+		}
+	}
+	return nil
+}
+
+// unpackSegment extracts the archive of a downloaded segment directory in place, so the
+// segment can be opened like one transferred file by file. Segments without an archive,
+// or whose archive was already extracted, are left untouched.
+func unpackSegment(segmentDir string) error {
+	manifest, err := readSegmentManifest(segmentDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // Not a manifest-described segment
+	}
+	if err != nil {
+		return err
+	}
+	if manifest.Archive == "" {
+		return nil
+	}
+	archivePath := filepath.Join(segmentDir, manifest.Archive)
+	if _, err := os.Stat(archivePath); errors.Is(err, fs.ErrNotExist) {
+		return nil // Already unpacked
+	}
+
+	compression, err := archive.ParseCompression(manifest.Compression)
+	if err != nil {
+		return fmt.Errorf("segment %s: %w", segmentDir, err)
+	}
+	if compression != archive.CompressionGzip {
+		return fmt.Errorf("segment %s has archive %s but compression %q", segmentDir, manifest.Archive, manifest.Compression)
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open segment archive %s: %w", archivePath, err)
+	}
+	err = archive.UnpackTarGz(f, segmentDir)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to unpack segment archive %s: %w", archivePath, err)
+	}
+
+	// Check the extracted files against the manifest before discarding the archive.
+	if err := verifySegment(segmentDir, manifest); err != nil {
+		return err
+	}
+	if err := os.Remove(archivePath); err != nil {
+		return fmt.Errorf("failed to remove segment archive %s: %w", archivePath, err)
+	}
+	slog.Info("Unpacked segment", "segment", segmentDir, "files", len(manifest.Files))
+	return nil
+}
+
+// unpackSegments unpacks every archived segment directory directly under collectionDir.
+func unpackSegments(collectionDir string) error {
+	entries, err := os.ReadDir(collectionDir)
+	if err != nil {
+		return fmt.Errorf("failed to list segments in %s: %w", collectionDir, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if err := unpackSegment(filepath.Join(collectionDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
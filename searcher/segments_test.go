@@ -0,0 +1,54 @@
+package searcher
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"common/archive"
+)
+
+func TestUnpackSegments(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "index_meta.json"), []byte(`{"storage":"scorch"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	collectionDir := t.TempDir()
+	segmentDir := filepath.Join(collectionDir, "index_20240101T000000Z")
+	if err := os.MkdirAll(segmentDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(segmentDir, "segment.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.PackTarGz(src, f); err != nil {
+		t.Fatalf("PackTarGz returned an error: %v", err)
+	}
+	f.Close()
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"segment":     "index_20240101T000000Z",
+		"compression": "gzip",
+		"archive":     "segment.tar.gz",
+		"files":       []map[string]interface{}{{"path": "index_meta.json", "size": 20}},
+	})
+	if err := os.WriteFile(filepath.Join(segmentDir, segmentManifestFile), manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unpackSegments(collectionDir); err != nil {
+		t.Fatalf("unpackSegments returned an error: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(segmentDir, "index_meta.json")); err != nil || string(data) != `{"storage":"scorch"}` {
+		t.Errorf("Expected the segment file to be extracted, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(segmentDir, "segment.tar.gz")); !os.IsNotExist(err) {
+		t.Errorf("Expected the archive to be removed after unpacking, got %v", err)
+	}
+	// Unpacking again is a no-op.
+	if err := unpackSegments(collectionDir); err != nil {
+		t.Errorf("Second unpackSegments returned an error: %v", err)
+	}
+}
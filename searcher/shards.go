@@ -0,0 +1,311 @@
+package searcher
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"common/tenant"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/index/scorch"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Storage of the indexes opened by a ShardCache.
+const (
+	// StorageMemory keeps indexes in memory only: they start empty with every process and
+	// are never evicted, since closing them would drop their documents.
+	StorageMemory = "memory"
+	// StorageScorch persists indexes under IndexConfig.Dir. Their segment files are
+	// memory-mapped rather than read into the heap, and idle indexes are closed to keep
+	// within the budget, then reopened by their next request.
+	StorageScorch = "scorch"
+)
+
+// ErrSearcherClosed is returned for requests reaching a searcher after Close.
+var ErrSearcherClosed = errors.New("searcher is closed")
+
+// Prometheus collectors for the shards of the ShardCache, exposed by promhttp.Handler().
+var (
+	openShardsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "searcher_open_shards",
+		Help: "Number of shard indexes currently open.",
+	})
+
+	residentIndexBytesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "searcher_index_resident_bytes",
+		Help: "Memory used by the open shard indexes, as reported by Bleve.",
+	})
+
+	shardOpensTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "searcher_shard_opens_total",
+		Help: "Total number of shard indexes opened, including reopens after eviction.",
+	})
+
+	shardEvictionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "searcher_shard_evictions_total",
+		Help: "Total number of idle shard indexes closed to keep within the memory budget.",
+	})
+)
+
+// IndexConfig controls how the searcher opens the indexes of its shards, a shard being the
+// index of a tenant's collection, and how many it keeps open. MaxOpenShards and
+// MemoryBudget only apply to StorageScorch: least recently used shards are closed once
+// either is exceeded, unless a request is using them.
+type IndexConfig struct {
+	Storage       string `yaml:"storage" env:"INDEX_STORAGE" flag:"index-storage" usage:"How shard indexes are opened: memory, or scorch to persist them under the index directory"`
+	Dir           string `yaml:"dir" env:"INDEX_DIR" flag:"index-dir" usage:"Directory of the persisted shard indexes"`
+	MaxOpenShards int    `yaml:"max_open_shards" env:"MAX_OPEN_SHARDS" flag:"max-open-shards" usage:"Persisted shard indexes kept open at once; 0 is unlimited"`
+	MemoryBudget  int64  `yaml:"memory_budget" env:"INDEX_MEMORY_BUDGET" flag:"index-memory-budget" usage:"Bytes of index memory kept resident before idle shards are closed; 0 is unlimited"`
+}
+
+// Validate checks the storage and limits.
+func (c IndexConfig) Validate() error {
+	switch c.Storage {
+	case "", StorageMemory:
+		if c.MaxOpenShards != 0 || c.MemoryBudget != 0 {
+			return fmt.Errorf("max_open_shards and memory_budget require %s storage: %s indexes can't be closed", StorageScorch, StorageMemory)
+		}
+	case StorageScorch:
+		if c.Dir == "" {
+			return fmt.Errorf("%s storage requires an index directory", StorageScorch)
+		}
+	default:
+		return fmt.Errorf("invalid index storage %q, must be %s or %s", c.Storage, StorageMemory, StorageScorch)
+	}
+	if c.MaxOpenShards < 0 || c.MemoryBudget < 0 {
+		return fmt.Errorf("max_open_shards and memory_budget must not be negative")
+	}
+	return nil
+}
+
+// ShardCache opens the shard indexes of the searchers of a process and closes the least
+// recently used ones to keep within the limits of its IndexConfig. It is safe for
+// concurrent use.
+type ShardCache struct {
+	config IndexConfig
+
+	mu        sync.Mutex // Also guards the index of its searchers
+	lru       *list.List // Searchers with an open index, most recently used first
+	opens     int64
+	evictions int64
+}
+
+// shard is the state of a searcher's index in its ShardCache, guarded by the cache mutex.
+type shard struct {
+	path   string        // Index directory; empty for StorageMemory
+	refs   int           // Requests using the index, which can't be closed until they're done
+	elem   *list.Element // Entry in the LRU list while the index is open
+	closed bool          // The searcher was closed
+}
+
+// NewShardCache creates a cache opening indexes as configured.
+func NewShardCache(config IndexConfig) (*ShardCache, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Storage == "" {
+		config.Storage = StorageMemory
+	}
+	return &ShardCache{config: config, lru: list.New()}, nil
+}
+
+// Open returns a searcher for a tenant's collection whose index is opened by the cache.
+// Persisted indexes are kept in a per-tenant and per-collection directory, like segments,
+// and created if missing.
+func (c *ShardCache) Open(collection, tenantID string) (*Searcher, error) {
+	s := &Searcher{collection: collection, commits: make(chan string, 1), shards: c, shard: &shard{}}
+	if err := s.SetTenant(tenantID); err != nil {
+		return nil, err
+	}
+	if c.config.Storage == StorageScorch {
+		s.shard.path = filepath.Join(c.config.Dir, filepath.FromSlash(tenant.StoragePrefix(tenantID)), collection)
+	}
+	// Open the index right away, so that a broken index fails at startup.
+	_, release, err := c.acquire(s)
+	if err != nil {
+		return nil, err
+	}
+	release()
+	return s, nil
+}
+
+// acquireIndex returns the index of the searcher, opened if it was evicted, and a function
+// to call once done with it: the index isn't closed in between.
+func (s *Searcher) acquireIndex() (bleve.Index, func(), error) {
+	if s.shards == nil {
+		return s.index, func() {}, nil
+	}
+	return s.shards.acquire(s)
+}
+
+// acquire opens the index of s if needed, marks it as used and evicts others if the cache
+// is over its limits. Indexes are opened under the cache mutex, so opens are serialized.
+func (c *ShardCache) acquire(s *Searcher) (bleve.Index, func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.shard.closed {
+		return nil, nil, ErrSearcherClosed
+	}
+	if s.index == nil {
+		index, err := c.openIndex(s.shard.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.index = index
+		s.shard.elem = c.lru.PushFront(s)
+		c.opens++
+		shardOpensTotal.Inc()
+	} else {
+		c.lru.MoveToFront(s.shard.elem)
+	}
+	s.shard.refs++
+	index := s.index
+	c.evict()
+
+	var once sync.Once
+	return index, func() { once.Do(func() { c.release(s) }) }, nil
+}
+
+// release marks a use of the index of s as done.
+func (c *ShardCache) release(s *Searcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.shard.refs--
+	c.evict()
+}
+
+// openIndex opens or creates an index in the configured storage.
+func (c *ShardCache) openIndex(path string) (bleve.Index, error) {
+	if c.config.Storage == StorageMemory {
+		index, err := bleve.NewUsing("", newIndexMapping(), scorch.Name, scorch.Name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Bleve index: %w", err)
+		}
+		return index, nil
+	}
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create index directory: %w", err)
+		}
+		slog.Info("Creating new index", "path", path)
+		index, err = bleve.NewUsing(path, newIndexMapping(), scorch.Name, scorch.Name, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Bleve index at %s: %w", path, err)
+	}
+	slog.Info("Opened index", "path", path)
+	return index, nil
+}
+
+// evict closes the least recently used idle indexes while the cache is over its limits,
+// and updates the metrics. Callers must hold c.mu.
+func (c *ShardCache) evict() {
+	open, resident := c.lru.Len(), c.residentBytes()
+	over := func() bool {
+		return (c.config.MaxOpenShards > 0 && open > c.config.MaxOpenShards) ||
+			(c.config.MemoryBudget > 0 && resident > uint64(c.config.MemoryBudget))
+	}
+	for e := c.lru.Back(); e != nil && c.config.Storage == StorageScorch && over(); {
+		prev := e.Prev()
+		s := e.Value.(*Searcher)
+		if s.shard.refs == 0 {
+			used := indexMemory(s.index)
+			c.closeIndex(s)
+			c.evictions++
+			shardEvictionsTotal.Inc()
+			open, resident = open-1, resident-min(used, resident)
+			slog.Info("Evicted the index of collection", "collection", s.collection, "open_shards", open, "resident_bytes", resident)
+		}
+		e = prev
+	}
+	openShardsGauge.Set(float64(open))
+	residentIndexBytesGauge.Set(float64(resident))
+}
+
+// closeIndex closes the index of s and forgets its kNN graphs, which are rebuilt by the
+// next kNN query. Callers must hold c.mu.
+func (c *ShardCache) closeIndex(s *Searcher) {
+	c.lru.Remove(s.shard.elem)
+	if err := s.index.Close(); err != nil {
+		slog.Error("Failed to close the index of collection", "collection", s.collection, "error", err)
+	}
+	s.index, s.shard.elem = nil, nil
+	s.vectorMu.Lock()
+	s.vectorIndexes = nil
+	s.vectorGen++
+	s.vectorMu.Unlock()
+}
+
+// close closes the index of s for good.
+func (c *ShardCache) close(s *Searcher) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.shard.closed {
+		return nil
+	}
+	s.shard.closed = true
+	if s.index == nil {
+		return nil
+	}
+	c.lru.Remove(s.shard.elem)
+	err := s.index.Close()
+	s.index, s.shard.elem = nil, nil
+	openShardsGauge.Set(float64(c.lru.Len()))
+	residentIndexBytesGauge.Set(float64(c.residentBytes()))
+	return err
+}
+
+// residentBytes returns the memory used by the open indexes. Callers must hold c.mu.
+func (c *ShardCache) residentBytes() uint64 {
+	var total uint64
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		total += indexMemory(e.Value.(*Searcher).index)
+	}
+	return total
+}
+
+// indexMemory returns the memory used by a scorch index, 0 for other indexes.
+func indexMemory(index bleve.Index) uint64 {
+	advanced, err := index.Advanced()
+	if err != nil {
+		return 0
+	}
+	if engine, ok := advanced.(*scorch.Scorch); ok {
+		return engine.MemoryUsed()
+	}
+	return 0
+}
+
+// ShardCacheStats reports the open shards of a ShardCache.
+type ShardCacheStats struct {
+	Storage       string `json:"storage"`
+	OpenShards    int    `json:"open_shards"`
+	MaxOpenShards int    `json:"max_open_shards,omitempty"`
+	ResidentBytes uint64 `json:"resident_bytes"` // Memory used by the open indexes
+	MemoryBudget  int64  `json:"memory_budget,omitempty"`
+	Opens         int64  `json:"opens"`     // Indexes opened, including reopens
+	Evictions     int64  `json:"evictions"` // Idle indexes closed to keep within the limits
+}
+
+// Stats returns the open shards and their memory.
+func (c *ShardCache) Stats() ShardCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ShardCacheStats{
+		Storage:       c.config.Storage,
+		OpenShards:    c.lru.Len(),
+		MaxOpenShards: c.config.MaxOpenShards,
+		ResidentBytes: c.residentBytes(),
+		MemoryBudget:  c.config.MemoryBudget,
+		Opens:         c.opens,
+		Evictions:     c.evictions,
+	}
+}
@@ -0,0 +1,143 @@
+package searcher
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIndexConfig_Validate(t *testing.T) {
+	for _, c := range []IndexConfig{
+		{},
+		{Storage: StorageMemory},
+		{Storage: StorageScorch, Dir: "/data", MaxOpenShards: 4, MemoryBudget: 1 << 30},
+	} {
+		if err := c.Validate(); err != nil {
+			t.Errorf("Unexpected error for %+v: %v", c, err)
+		}
+	}
+	for _, c := range []IndexConfig{
+		{Storage: "mmap"},
+		{Storage: StorageScorch},
+		{Storage: StorageMemory, MaxOpenShards: 2},
+		{Storage: StorageScorch, Dir: "/data", MemoryBudget: -1},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
+
+func TestShardCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	shards, err := NewShardCache(IndexConfig{Storage: StorageScorch, Dir: t.TempDir(), MaxOpenShards: 2})
+	if err != nil {
+		t.Fatalf("NewShardCache returned an error: %v", err)
+	}
+	searchers := make(map[string]*Searcher)
+	for _, collection := range []string{"products", "articles", "users"} {
+		s, err := shards.Open(collection, "")
+		if err != nil {
+			t.Fatalf("Open returned an error: %v", err)
+		}
+		defer s.Close()
+		searchers[collection] = s
+	}
+	if stats := shards.Stats(); stats.OpenShards != 2 || stats.Opens != 3 || stats.Evictions != 1 {
+		t.Fatalf("Expected the first shard to be evicted, got %+v", stats)
+	}
+	if searchers["products"].index != nil {
+		t.Errorf("Expected the least recently used shard to be closed")
+	}
+
+	// The evicted shard is reopened with its persisted documents, evicting the next one.
+	products := searchers["products"]
+	if err := products.indexDocument("shoes", map[string]interface{}{"text": "red running shoes"}); err != nil {
+		t.Fatalf("indexDocument returned an error: %v", err)
+	}
+	if searchers["articles"].index != nil || searchers["users"].index == nil {
+		t.Errorf("Expected the articles shard to be evicted")
+	}
+	for _, collection := range []string{"users", "articles"} {
+		if err := searchers[collection].indexDocument("news", map[string]interface{}{"text": "daily news"}); err != nil {
+			t.Fatalf("indexDocument returned an error: %v", err)
+		}
+	}
+	if products.index != nil {
+		t.Fatalf("Expected the products shard to be evicted")
+	}
+
+	router := gin.New()
+	router.GET("/doc/:id", products.DocumentHandler)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/doc/shoes", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected the document of the reopened shard, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if stats := shards.Stats(); stats.OpenShards != 2 || stats.Opens != 6 || stats.Evictions != 4 || stats.ResidentBytes == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestShardCache_KeepsShardsInUse(t *testing.T) {
+	shards, err := NewShardCache(IndexConfig{Storage: StorageScorch, Dir: t.TempDir(), MemoryBudget: 1})
+	if err != nil {
+		t.Fatalf("NewShardCache returned an error: %v", err)
+	}
+	s, err := shards.Open("products", "")
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	if s.index != nil {
+		t.Errorf("Expected the idle shard over the budget to be closed")
+	}
+	index, release, err := s.acquireIndex()
+	if err != nil {
+		t.Fatalf("acquireIndex returned an error: %v", err)
+	}
+	if err := index.Index("shoes", map[string]interface{}{"text": "red running shoes"}); err != nil {
+		t.Fatalf("Index returned an error: %v", err)
+	}
+	if s.index == nil {
+		t.Errorf("Expected the shard in use to stay open")
+	}
+	release()
+	release() // Releasing twice is harmless
+	if s.index != nil {
+		t.Errorf("Expected the shard to be closed once released")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+	if _, _, err := s.acquireIndex(); !errors.Is(err, ErrSearcherClosed) {
+		t.Errorf("Expected ErrSearcherClosed, got %v", err)
+	}
+}
+
+func TestShardCache_MemoryStorage(t *testing.T) {
+	shards, err := NewShardCache(IndexConfig{})
+	if err != nil {
+		t.Fatalf("NewShardCache returned an error: %v", err)
+	}
+	s, err := shards.Open("products", "acme")
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	defer s.Close()
+	if s.Tenant() != "acme" {
+		t.Errorf("Expected tenant acme, got %q", s.Tenant())
+	}
+	if err := s.indexDocument("shoes", map[string]interface{}{"text": "red running shoes"}); err != nil {
+		t.Fatalf("indexDocument returned an error: %v", err)
+	}
+	if stats := shards.Stats(); stats.Storage != StorageMemory || stats.OpenShards != 1 || stats.ResidentBytes == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if _, err := shards.Open("products", "not a tenant!"); err == nil {
+		t.Errorf("Expected an error for an invalid tenant")
+	}
+}
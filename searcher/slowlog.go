@@ -0,0 +1,125 @@
+package searcher
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"common/slowlog"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// Search stages reported in the slow query log.
+const (
+	StageQueue   = "queue"   // Waiting for a slot of the concurrency limit
+	StageOpen    = "open"    // Acquiring the index, reopened if it was evicted
+	StageKNN     = "knn"     // Finding the nearest neighbors of a kNN query
+	StageSearch  = "search"  // Running the Bleve search
+	StageRescore = "rescore" // Rescoring the hits with a script
+)
+
+// StageTiming reports the time a search spent in one of its stages.
+type StageTiming struct {
+	Stage  string `json:"stage"`
+	TookMs int64  `json:"took_ms"`
+}
+
+// SlowSearchRecord is the record written to the slow query log for requests that took
+// at least its threshold.
+type SlowSearchRecord struct {
+	Timestamp   time.Time            `json:"timestamp"`
+	Path        string               `json:"path"`
+	Tenant      string               `json:"tenant,omitempty"`
+	Collection  string               `json:"collection"`
+	Params      string               `json:"params"`            // Query string of the request
+	Request     *bleve.SearchRequest `json:"request,omitempty"` // Last search run by Bleve
+	Status      int                  `json:"status"`
+	TookMs      int64                `json:"took_ms"`
+	ThresholdMs int64                `json:"threshold_ms"`
+	Stages      []StageTiming        `json:"stages,omitempty"`
+	TotalHits   uint64               `json:"total_hits"`
+	Hits        int                  `json:"hits"` // Hits returned by Bleve
+}
+
+// SetSlowQueryLog writes the searches slower than the threshold of l to it; nil disables
+// the slow query log.
+func (s *Searcher) SetSlowQueryLog(l *slowlog.Logger) {
+	s.slowLog = l
+}
+
+type slowTraceKey struct{}
+
+// slowTrace collects the stage timings and Bleve search of a request. A nil slowTrace
+// records nothing.
+type slowTrace struct {
+	mu      sync.Mutex
+	stages  []StageTiming
+	request *bleve.SearchRequest
+	total   uint64
+	hits    int
+}
+
+// slowTraceFrom returns the trace of the request of ctx, or nil if it isn't traced.
+func slowTraceFrom(ctx context.Context) *slowTrace {
+	t, _ := ctx.Value(slowTraceKey{}).(*slowTrace)
+	return t
+}
+
+// record adds the timing of a stage that started at start.
+func (t *slowTrace) record(stage string, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stages = append(t.stages, StageTiming{Stage: stage, TookMs: time.Since(start).Milliseconds()})
+}
+
+// searched records a search run by Bleve and its result.
+func (t *slowTrace) searched(req *bleve.SearchRequest, result *bleve.SearchResult) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.request, t.total, t.hits = req, result.Total, len(result.Hits)
+}
+
+// traceSlowSearch times the request of c for the slow query log, returning the function
+// that logs it once it is served if it took too long.
+func (s *Searcher) traceSlowSearch(c *gin.Context) func() {
+	if s.slowLog == nil {
+		return func() {}
+	}
+	start := time.Now()
+	trace := &slowTrace{}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), slowTraceKey{}, trace))
+	return func() {
+		took := time.Since(start)
+		if !s.slowLog.Slow(took) {
+			return
+		}
+		trace.mu.Lock()
+		defer trace.mu.Unlock()
+		record := SlowSearchRecord{
+			Timestamp:   start.UTC(),
+			Path:        c.Request.URL.Path,
+			Tenant:      s.tenant,
+			Collection:  s.collection,
+			Params:      c.Request.URL.RawQuery,
+			Request:     trace.request,
+			Status:      c.Writer.Status(),
+			TookMs:      took.Milliseconds(),
+			ThresholdMs: s.slowLog.Threshold().Milliseconds(),
+			Stages:      trace.stages,
+			TotalHits:   trace.total,
+			Hits:        trace.hits,
+		}
+		if err := s.slowLog.Log(record); err != nil {
+			slog.Error("Failed to log slow search", "error", err)
+		}
+	}
+}
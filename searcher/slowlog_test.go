@@ -0,0 +1,62 @@
+package searcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"common/slowlog"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchHandler_SlowQueryLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	if err := svc.indexDocument("doc-1", map[string]interface{}{"text": "red running shoes"}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	svc.SetSlowQueryLog(slowlog.NewWriter(0, &buf)) // Every search is slow
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoes&size=5", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var record struct {
+		SlowSearchRecord
+		Request map[string]interface{} `json:"request"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a slow search record, got %q: %v", buf.String(), err)
+	}
+	if record.Path != "/search" || record.Collection != "products" || record.Params != "q=shoes&size=5" || record.Status != http.StatusOK {
+		t.Errorf("Unexpected slow search record %+v", record.SlowSearchRecord)
+	}
+	if record.TotalHits != 1 || record.Hits != 1 || record.Request["query"] == nil || record.Request["size"] != float64(5) {
+		t.Errorf("Expected the Bleve search and its hits, got %+v %v", record.SlowSearchRecord, record.Request)
+	}
+	stages := make(map[string]bool)
+	for _, stage := range record.Stages {
+		stages[stage.Stage] = true
+	}
+	if !stages[StageQueue] || !stages[StageOpen] || !stages[StageSearch] {
+		t.Errorf("Expected the stage timings, got %+v", record.Stages)
+	}
+
+	buf.Reset()
+	svc.SetSlowQueryLog(slowlog.NewWriter(time.Hour, &buf))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search?q=shoes", nil))
+	if buf.Len() != 0 {
+		t.Errorf("Expected searches under the threshold not to be logged, got %q", buf.String())
+	}
+}
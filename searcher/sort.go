@@ -0,0 +1,161 @@
+package searcher
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+const (
+	sortFieldScore = "_score" // Pseudo-field sorting by relevance score
+	sortFieldID    = "_id"    // Pseudo-field sorting by document ID
+)
+
+// SortSpec is a single sort criterion parsed from a "field:asc|desc" expression.
+type SortSpec struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSortParam parses a comma-separated sort parameter such as "price:asc,created_at:desc".
+// The direction defaults to ascending, except for "_score" which defaults to descending.
+func ParseSortParam(param string) ([]SortSpec, error) {
+	var specs []SortSpec
+	for _, part := range strings.Split(param, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		field, direction, _ := strings.Cut(part, ":")
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return nil, fmt.Errorf("invalid sort expression %q: missing field name", part)
+		}
+		spec := SortSpec{Field: field, Desc: field == sortFieldScore}
+		switch strings.ToLower(strings.TrimSpace(direction)) {
+		case "":
+		case "asc":
+			spec.Desc = false
+		case "desc":
+			spec.Desc = true
+		default:
+			return nil, fmt.Errorf("invalid sort direction %q for field %q, expected asc or desc", direction, field)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// toBleveSortOrder converts sort specs into a Bleve sort order. Documents missing a
+// sort field are placed last regardless of direction. Sorting by "_distance" requires
+// a geo query providing the origin.
+func toBleveSortOrder(specs []SortSpec, geoQuery *GeoQuery) (search.SortOrder, error) {
+	order := make(search.SortOrder, 0, len(specs))
+	for _, spec := range specs {
+		switch spec.Field {
+		case sortFieldScore:
+			order = append(order, &search.SortScore{Desc: spec.Desc})
+		case sortFieldID:
+			order = append(order, &search.SortDocID{Desc: spec.Desc})
+		case sortFieldDistance:
+			if geoQuery == nil {
+				return nil, fmt.Errorf("sorting by %s requires lat and lon", sortFieldDistance)
+			}
+			order = append(order, geoQuery.sortOrder(spec.Desc))
+		default:
+			order = append(order, &search.SortField{
+				Field:   spec.Field,
+				Desc:    spec.Desc,
+				Missing: search.SortFieldMissingLast,
+			})
+		}
+	}
+	return order, nil
+}
+
+// sortFieldNames returns the stored fields that must be loaded to report sort values.
+func sortFieldNames(specs []SortSpec) []string {
+	var fields []string
+	for _, spec := range specs {
+		if spec.Field != sortFieldScore && spec.Field != sortFieldID && spec.Field != sortFieldDistance {
+			fields = append(fields, spec.Field)
+		}
+	}
+	return fields
+}
+
+// sortValues returns the typed sort key of a hit, one value per sort spec. Bleve's own
+// hit.Sort values are prefix-coded terms, so the stored field values are reported instead
+// to let the Broker merge results from different shards. Missing values are reported as nil.
+// Distances are reported in kilometres from the geo query origin.
+func sortValues(hit *search.DocumentMatch, specs []SortSpec, geoQuery *GeoQuery) []interface{} {
+	values := make([]interface{}, len(specs))
+	for i, spec := range specs {
+		switch spec.Field {
+		case sortFieldScore:
+			values[i] = hit.Score
+		case sortFieldID:
+			values[i] = hit.ID
+		case sortFieldDistance:
+			if geoQuery != nil {
+				if d, ok := geoQuery.DistanceKm(hit); ok {
+					values[i] = d
+				}
+			}
+		default:
+			v := hit.Fields[spec.Field]
+			// Multi-valued fields sort by their first value, matching Bleve's default mode.
+			if list, ok := v.([]interface{}); ok {
+				v = nil
+				if len(list) > 0 {
+					v = list[0]
+				}
+			}
+			values[i] = v
+		}
+	}
+	return values
+}
+
+// sortKey returns the opaque sort key of a hit that search_after resumes after: Bleve's
+// sort terms, base64url-encoded as they are binary, with scores written out the way
+// Bleve's search-after reads them.
+func sortKey(hit *search.DocumentMatch, specs []SortSpec) []string {
+	key := make([]string, len(specs))
+	for i, spec := range specs {
+		term := ""
+		if i < len(hit.Sort) {
+			term = hit.Sort[i]
+		}
+		if spec.Field == sortFieldScore {
+			term = strconv.FormatFloat(hit.Score, 'g', -1, 64)
+		}
+		key[i] = base64.RawURLEncoding.EncodeToString([]byte(term))
+	}
+	return key
+}
+
+// ParseSearchAfter decodes the comma-separated sort key of the search_after parameter, as
+// returned with a hit of a sorted search. It needs one value per sort spec; an empty
+// parameter returns nil.
+func ParseSearchAfter(param string, specs []SortSpec) ([]string, error) {
+	if param == "" {
+		return nil, nil
+	}
+	parts := strings.Split(param, ",")
+	if len(parts) != len(specs) {
+		return nil, fmt.Errorf("search_after needs one value per sort field, got %d for %d fields", len(parts), len(specs))
+	}
+	after := make([]string, len(parts))
+	for i, part := range parts {
+		term, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid search_after value %q", part)
+		}
+		after[i] = string(term)
+	}
+	return after, nil
+}
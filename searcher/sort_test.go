@@ -0,0 +1,109 @@
+package searcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseSortParam(t *testing.T) {
+	specs, err := ParseSortParam("price:asc,created_at:desc,_score")
+	if err != nil {
+		t.Fatalf("ParseSortParam returned an error: %v", err)
+	}
+	if len(specs) != 3 || specs[0] != (SortSpec{Field: "price"}) ||
+		specs[1] != (SortSpec{Field: "created_at", Desc: true}) || specs[2] != (SortSpec{Field: "_score", Desc: true}) {
+		t.Errorf("Unexpected sort specs: %+v", specs)
+	}
+	if _, err := ParseSortParam("price:sideways"); err == nil {
+		t.Error("Expected an error for an invalid sort direction")
+	}
+
+	order, err := toBleveSortOrder(specs, nil)
+	if err != nil {
+		t.Fatalf("toBleveSortOrder returned an error: %v", err)
+	}
+	if field, ok := order[0].(*search.SortField); !ok || field.Field != "price" || field.Missing != search.SortFieldMissingLast {
+		t.Errorf("Expected a SortField on price with missing values last, got %#v", order[0])
+	}
+	if _, ok := order[2].(*search.SortScore); !ok {
+		t.Errorf("Expected a SortScore for _score, got %#v", order[2])
+	}
+}
+
+func TestSortValues(t *testing.T) {
+	hit := &search.DocumentMatch{
+		ID:     "doc1",
+		Score:  1.5,
+		Fields: map[string]interface{}{"price": 9.99, "tags": []interface{}{"b", "a"}},
+	}
+	specs := []SortSpec{{Field: "price"}, {Field: "tags"}, {Field: "missing"}, {Field: "_score"}, {Field: "_id"}}
+	values := sortValues(hit, specs, nil)
+	expected := []interface{}{9.99, "b", nil, 1.5, "doc1"}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("Sort value %d: expected %v, got %v", i, expected[i], values[i])
+		}
+	}
+}
+
+func TestSearchHandler_SearchAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("scroll")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		doc := map[string]interface{}{"text": "red shoes", "price": float64(i % 3)}
+		if err := svc.index.Index(fmt.Sprintf("doc%d", i), doc); err != nil {
+			t.Fatalf("Failed to index document: %v", err)
+		}
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	for _, sortParam := range []string{"price:desc,_id", "_score,_id"} {
+		var ids []string
+		after := ""
+		for page := 0; page < 5; page++ {
+			target := "/search?q=shoes&size=3&sort=" + url.QueryEscape(sortParam)
+			if after != "" {
+				target += "&search_after=" + url.QueryEscape(after)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+			var body struct {
+				Results []SearchHit `json:"results"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+				t.Fatalf("Unexpected response %d %s", rec.Code, rec.Body.String())
+			}
+			if len(body.Results) == 0 {
+				break
+			}
+			for _, hit := range body.Results {
+				ids = append(ids, hit.ID)
+			}
+			after = strings.Join(body.Results[len(body.Results)-1].SortKey, ",")
+		}
+		if len(ids) != 7 {
+			t.Errorf("%s: expected the 7 documents across pages, got %v", sortParam, ids)
+		}
+		if sortParam == "price:desc,_id" && strings.Join(ids, ",") != "doc2,doc5,doc1,doc4,doc0,doc3,doc6" {
+			t.Errorf("Unexpected order %v", ids)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=shoes&sort=price&search_after=a,b", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a sort key of the wrong length, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,152 @@
+package searcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/v2/search"
+	index "github.com/blevesearch/bleve_index_api"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultSpellField is the field whose term dictionary corrections are looked up in; it
+	// holds the terms of every field.
+	DefaultSpellField = "_all"
+
+	minCorrectedTermLength = 3  // Shorter terms are never corrected
+	maxSpellTerms          = 32 // Largest number of terms corrected in one request
+)
+
+// TermCorrection reports how often a query term occurs in the index and, if it doesn't,
+// the closest term of the dictionary.
+type TermCorrection struct {
+	Term                string `json:"term"`
+	Frequency           uint64 `json:"frequency"`                      // Documents containing Term
+	Correction          string `json:"correction,omitempty"`           // Closest known term, if Term is unknown
+	CorrectionFrequency uint64 `json:"correction_frequency,omitempty"` // Documents containing Correction
+	Distance            int    `json:"distance,omitempty"`             // Edit distance between Term and Correction
+}
+
+// maxEdits returns the number of edits allowed when correcting term: one for terms of up
+// to four runes, two for longer ones.
+func maxEdits(term string) int {
+	if utf8.RuneCountInString(term) <= 4 {
+		return 1
+	}
+	return 2
+}
+
+// Corrections looks every term up in the term dictionary of field. Terms found in no
+// document get the dictionary term within maxEdits edits having the smallest edit
+// distance, then the highest document frequency, as correction. Terms are compared
+// lowercased, like the standard analyzer indexes them.
+func (s *Searcher) Corrections(terms []string, field string) ([]TermCorrection, error) {
+	idx, release, err := s.acquireIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	advanced, err := idx.Advanced()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the index: %w", err)
+	}
+	reader, err := advanced.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open an index reader: %w", err)
+	}
+	defer reader.Close()
+
+	corrections := make([]TermCorrection, len(terms))
+	for i, term := range terms {
+		c := TermCorrection{Term: strings.ToLower(term)}
+		if c.Frequency, err = termFrequency(reader, field, c.Term); err != nil {
+			return nil, err
+		}
+		// Known terms are kept as they are, even if a more frequent spelling is close.
+		if c.Frequency == 0 && utf8.RuneCountInString(c.Term) >= minCorrectedTermLength {
+			if err := correct(reader, field, &c); err != nil {
+				return nil, err
+			}
+		}
+		corrections[i] = c
+	}
+	return corrections, nil
+}
+
+// termFrequency returns the number of documents containing term in field.
+func termFrequency(reader index.IndexReader, field, term string) (uint64, error) {
+	postings, err := reader.TermFieldReader(context.Background(), []byte(term), field, false, false, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look term %s up in field %s: %w", term, field, err)
+	}
+	defer postings.Close()
+	return postings.Count(), nil
+}
+
+// correct sets the correction of c among the candidates of its term, which are read from
+// a Levenshtein automaton on indexes supporting one, scorch ones, and from the terms
+// sharing its first letter on others, rather than from the whole dictionary.
+func correct(reader index.IndexReader, field string, c *TermCorrection) error {
+	edits := maxEdits(c.Term)
+	var dict index.FieldDict
+	var err error
+	if fuzzy, ok := reader.(index.IndexReaderFuzzy); ok {
+		dict, err = fuzzy.FieldDictFuzzy(field, c.Term, edits, "")
+	} else {
+		first, _ := utf8.DecodeRuneInString(c.Term)
+		dict, err = reader.FieldDictPrefix(field, []byte(string(first)))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open the term dictionary of field %s: %w", field, err)
+	}
+	defer dict.Close()
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return fmt.Errorf("failed to read the term dictionary of field %s: %w", field, err)
+		}
+		if entry == nil {
+			return nil
+		}
+		distance, exceeded := search.LevenshteinDistanceMax(c.Term, entry.Term, edits)
+		if exceeded || distance > edits {
+			continue
+		}
+		if c.Correction == "" || distance < c.Distance ||
+			(distance == c.Distance && entry.Count > c.CorrectionFrequency) {
+			c.Correction, c.CorrectionFrequency, c.Distance = entry.Term, entry.Count, distance
+		}
+	}
+}
+
+// SpellHandler returns the term corrections of the words of "q" at GET /spell. The
+// optional "field" parameter selects the term dictionary, DefaultSpellField by default.
+func (s *Searcher) SpellHandler(c *gin.Context) {
+	terms := strings.Fields(c.Query("q"))
+	if len(terms) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'q' is required"})
+		return
+	}
+	if len(terms) > maxSpellTerms {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many terms, at most %d can be corrected", maxSpellTerms)})
+		return
+	}
+	if !s.checkScope(c) {
+		return
+	}
+	field := c.DefaultQuery("field", DefaultSpellField)
+
+	corrections, err := s.Corrections(terms, field)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"collection":  s.collection,
+		"corrections": corrections,
+	})
+}
@@ -0,0 +1,121 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/index/scorch"
+	"github.com/gin-gonic/gin"
+)
+
+func newSpellTestSearcher(t *testing.T) *Searcher {
+	t.Helper()
+	svc, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	return indexSpellTestDocuments(t, svc)
+}
+
+// newScorchSpellTestSearcher indexes the spell test documents in a scorch index, whose
+// corrections are read from a Levenshtein automaton.
+func newScorchSpellTestSearcher(t *testing.T) *Searcher {
+	t.Helper()
+	svc, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	svc.index, err = bleve.NewUsing(filepath.Join(t.TempDir(), "index"), newIndexMapping(), scorch.Name, scorch.Name, nil)
+	if err != nil {
+		t.Fatalf("Failed to create the scorch index: %v", err)
+	}
+	t.Cleanup(func() { svc.index.Close() })
+	return indexSpellTestDocuments(t, svc)
+}
+
+func indexSpellTestDocuments(t *testing.T, svc *Searcher) *Searcher {
+	t.Helper()
+	docs := map[string]string{
+		"1": "red running shoes",
+		"2": "blue running shoes",
+		"3": "red boots",
+		"4": "shoe polish",
+	}
+	for id, text := range docs {
+		if err := svc.index.Index(id, map[string]interface{}{"text": text}); err != nil {
+			t.Fatalf("Failed to index document %s: %v", id, err)
+		}
+	}
+	return svc
+}
+
+func TestSearcher_Corrections(t *testing.T) {
+	for name, newSearcher := range map[string]func(*testing.T) *Searcher{
+		"upsidedown": newSpellTestSearcher,
+		"scorch":     newScorchSpellTestSearcher,
+	} {
+		t.Run(name, func(t *testing.T) {
+			testCorrections(t, newSearcher(t))
+		})
+	}
+}
+
+func testCorrections(t *testing.T, svc *Searcher) {
+	corrections, err := svc.Corrections([]string{"Runing", "shoos", "red", "xyzzy", "bx"}, DefaultSpellField)
+	if err != nil {
+		t.Fatalf("Corrections returned an error: %v", err)
+	}
+	want := []TermCorrection{
+		{Term: "runing", Correction: "running", CorrectionFrequency: 2, Distance: 1},
+		// "shoes" and "shoe" are both one edit away; the more frequent one wins.
+		{Term: "shoos", Correction: "shoes", CorrectionFrequency: 2, Distance: 1},
+		{Term: "red", Frequency: 2},
+		{Term: "xyzzy"},
+		{Term: "bx"}, // Too short to be corrected
+	}
+	if len(corrections) != len(want) {
+		t.Fatalf("Expected %d corrections, got %+v", len(want), corrections)
+	}
+	for i := range want {
+		if corrections[i] != want[i] {
+			t.Errorf("Correction %d: expected %+v, got %+v", i, want[i], corrections[i])
+		}
+	}
+}
+
+func TestSpellHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc := newSpellTestSearcher(t)
+	router := gin.New()
+	router.GET("/spell", svc.SpellHandler)
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/spell?"+query, nil))
+		return rec
+	}
+
+	rec := get("q=red+bots&collection=products")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Corrections []TermCorrection `json:"corrections"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Corrections) != 2 || body.Corrections[1].Correction != "boots" {
+		t.Errorf("Expected 'bots' to be corrected to 'boots', got %+v", body.Corrections)
+	}
+
+	if rec := get("q="); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a query, got %d", rec.Code)
+	}
+	if rec := get("q=red&collection=other"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for another collection, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package searcher
+
+import (
+	"container/heap"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultStatsTerms is the number of terms, the most frequent ones, reported by TermStats
+// by default.
+const DefaultStatsTerms = 10000
+
+// TermStats summarizes the term statistics of the index, which the broker sums across
+// shards into global document frequencies so that scores of different shards are
+// comparable.
+type TermStats struct {
+	Field     string            `json:"field"`
+	Documents uint64            `json:"documents"` // Documents in the index
+	Terms     map[string]uint64 `json:"terms"`     // Documents containing each term
+	Truncated bool              `json:"truncated"` // Whether less frequent terms were left out
+}
+
+// termCount is a term of the dictionary and its document frequency.
+type termCount struct {
+	term  string
+	count uint64
+}
+
+// termHeap is a min-heap of term counts, keeping the most frequent terms seen.
+type termHeap []termCount
+
+func (h termHeap) Len() int            { return len(h) }
+func (h termHeap) Less(i, j int) bool  { return h[i].count < h[j].count }
+func (h termHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *termHeap) Push(x interface{}) { *h = append(*h, x.(termCount)) }
+func (h *termHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// TermStats returns the number of documents of the index and the document frequencies of
+// the maxTerms most frequent terms of field. Rarer terms are left out to bound the size of
+// the summary; their frequencies are close to 0 on every shard anyway.
+func (s *Searcher) TermStats(field string, maxTerms int) (*TermStats, error) {
+	index, release, err := s.acquireIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	documents, err := index.DocCount()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count documents: %w", err)
+	}
+	dict, err := index.FieldDict(field)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the term dictionary of field %s: %w", field, err)
+	}
+	defer dict.Close()
+
+	stats := &TermStats{Field: field, Documents: documents}
+	top := &termHeap{}
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the term dictionary of field %s: %w", field, err)
+		}
+		if entry == nil {
+			break
+		}
+		if top.Len() < maxTerms {
+			heap.Push(top, termCount{entry.Term, entry.Count})
+		} else if entry.Count > (*top)[0].count {
+			(*top)[0] = termCount{entry.Term, entry.Count}
+			heap.Fix(top, 0)
+			stats.Truncated = true
+		} else {
+			stats.Truncated = true
+		}
+	}
+	stats.Terms = make(map[string]uint64, top.Len())
+	for _, t := range *top {
+		stats.Terms[t.term] = t.count
+	}
+	return stats, nil
+}
+
+// StatsHandler returns the term statistics of the index at GET /stats. The optional
+// "field" parameter selects the term dictionary, DefaultSpellField by default, and
+// "max_terms" the number of terms reported, DefaultStatsTerms by default.
+func (s *Searcher) StatsHandler(c *gin.Context) {
+	if !s.checkScope(c) {
+		return
+	}
+	maxTerms := DefaultStatsTerms
+	if v := c.Query("max_terms"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_terms must be a positive integer"})
+			return
+		}
+		maxTerms = n
+	}
+	stats, err := s.TermStats(c.DefaultQuery("field", DefaultSpellField), maxTerms)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,45 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearcher_TermStats(t *testing.T) {
+	svc := newSpellTestSearcher(t)
+
+	stats, err := svc.TermStats(DefaultSpellField, 2)
+	if err != nil {
+		t.Fatalf("TermStats returned an error: %v", err)
+	}
+	if stats.Documents != 4 || !stats.Truncated || len(stats.Terms) != 2 {
+		t.Fatalf("Expected the 2 most frequent terms of 4 documents, got %+v", stats)
+	}
+	for term, count := range stats.Terms {
+		if count != 2 {
+			t.Errorf("Expected only terms found in 2 documents, got %s: %d", term, count)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/stats", svc.StatsHandler)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var resp TermStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	if resp.Truncated || resp.Terms["polish"] != 1 || resp.Terms["red"] != 2 {
+		t.Errorf("Expected every term by default, got %+v", resp)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats?max_terms=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid max_terms, got %d", rec.Code)
+	}
+}
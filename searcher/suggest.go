@@ -0,0 +1,109 @@
+package searcher
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"common/suggest"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSuggestSize = 10  // Completions returned when "size" isn't given
+	maxSuggestSize     = 100 // Largest accepted "size"
+)
+
+// SetSuggestions replaces the completions served by SuggestHandler.
+func (s *Searcher) SetSuggestions(entries []suggest.Entry) {
+	idx := suggest.NewIndex(entries)
+	s.suggestMu.Lock()
+	s.suggestions = idx
+	s.suggestMu.Unlock()
+}
+
+// LoadSuggestions replaces the completions with the dictionary the Indexer stored at path.
+func (s *Searcher) LoadSuggestions(path string) error {
+	entries, err := suggest.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	s.SetSuggestions(entries)
+	slog.Info("Loaded completions", "completions", len(entries), "path", path)
+	return nil
+}
+
+// loadLatestSuggestions loads the most recent completion dictionary among the segments
+// under collectionDir. Collections without one keep their current completions.
+func (s *Searcher) loadLatestSuggestions(collectionDir string) error {
+	entries, err := os.ReadDir(collectionDir)
+	if err != nil {
+		return fmt.Errorf("failed to list segments in %s: %w", collectionDir, err)
+	}
+	var (
+		latest   string
+		latestAt time.Time
+	)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(collectionDir, entry.Name(), suggest.FileName)
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if info.ModTime().After(latestAt) {
+			latest, latestAt = path, info.ModTime()
+		}
+	}
+	if latest == "" {
+		return nil
+	}
+	return s.LoadSuggestions(latest)
+}
+
+// SuggestHandler returns completions for the prefix in "q" at GET /suggest, by
+// descending score. The optional "size" parameter limits the number of completions.
+func (s *Searcher) SuggestHandler(c *gin.Context) {
+	prefix := c.Query("q")
+	if prefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'q' is required"})
+		return
+	}
+	if !s.checkScope(c) {
+		return
+	}
+	size := defaultSuggestSize
+	if raw := c.Query("size"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 || v > maxSuggestSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid size parameter, must be between 1 and %d", maxSuggestSize)})
+			return
+		}
+		size = v
+	}
+
+	s.suggestMu.RLock()
+	idx := s.suggestions
+	s.suggestMu.RUnlock()
+	suggestions := idx.Suggest(prefix, size)
+	if suggestions == nil {
+		suggestions = []suggest.Suggestion{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"query":       prefix,
+		"collection":  s.collection,
+		"suggestions": suggestions,
+	})
+}
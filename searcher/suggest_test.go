@@ -0,0 +1,95 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"common/suggest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadLatestSuggestions(t *testing.T) {
+	collectionDir := t.TempDir()
+	write := func(segment string, entries []suggest.Entry, modTime time.Time) {
+		t.Helper()
+		dir := filepath.Join(collectionDir, segment)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create segment dir: %v", err)
+		}
+		path := filepath.Join(dir, suggest.FileName)
+		if err := suggest.WriteFile(path, entries); err != nil {
+			t.Fatalf("Failed to write dictionary: %v", err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	now := time.Now()
+	write("index_old", []suggest.Entry{{Text: "old shoes", Weight: 1}}, now.Add(-time.Hour))
+	write("index_new", []suggest.Entry{{Text: "new shoes", Weight: 1}}, now)
+	os.MkdirAll(filepath.Join(collectionDir, "index_empty"), 0755)
+
+	svc, err := NewCollectionSearcher("suggest")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	if err := svc.loadLatestSuggestions(collectionDir); err != nil {
+		t.Fatalf("loadLatestSuggestions returned an error: %v", err)
+	}
+	if got := svc.suggestions.Suggest("shoes", 10); len(got) != 1 || got[0].Text != "new shoes" {
+		t.Errorf("Expected completions from the latest segment, got %+v", got)
+	}
+}
+
+func TestSuggestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	router := gin.New()
+	router.GET("/suggest", svc.SuggestHandler)
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/suggest?"+query, nil))
+		return rec
+	}
+
+	// Without a dictionary there are no completions.
+	if rec := get("q=re"); rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	svc.SetSuggestions([]suggest.Entry{
+		{Text: "red shoes", Weight: 3},
+		{Text: "rain jacket", Weight: 5},
+		{Text: "boots", Weight: 9},
+	})
+	rec := get("q=r&size=1&collection=products")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Suggestions []suggest.Suggestion `json:"suggestions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Suggestions) != 1 || body.Suggestions[0] != (suggest.Suggestion{Text: "rain jacket", Score: 5}) {
+		t.Errorf("Unexpected suggestions: %+v", body.Suggestions)
+	}
+
+	for query, status := range map[string]int{
+		"":                       http.StatusBadRequest,
+		"q=r&size=0":             http.StatusBadRequest,
+		"q=r&collection=article": http.StatusNotFound,
+	} {
+		if rec := get(query); rec.Code != status {
+			t.Errorf("Expected status %d for %q, got %d", status, query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package searcher
+
+import (
+	"fmt"
+	"net/http"
+
+	"common/tenant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetTenant makes the searcher serve the collection of the given tenant: segments are
+// read from the tenant's storage prefix, and requests must name the tenant. Searchers
+// opened by a ShardCache are given their tenant by Open, which also locates their index.
+func (s *Searcher) SetTenant(id string) error {
+	if err := tenant.Validate(id); err != nil {
+		return err
+	}
+	s.tenant = id
+	return nil
+}
+
+// Tenant returns the tenant served by this searcher, empty for the default tenant.
+func (s *Searcher) Tenant() string {
+	return s.tenant
+}
+
+// checkScope reports whether the request is for the tenant and collection served by this
+// searcher; otherwise it writes the error response. Requests without a collection are
+// for the served one, whereas requests without a tenant are for the default tenant, so
+// a tenant's data is never served to a request that doesn't name it.
+func (s *Searcher) checkScope(c *gin.Context) bool {
+	id, err := tenant.FromRequest(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if id != s.tenant {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("tenant '%s' is not served by this searcher", id)})
+		return false
+	}
+	if collection := c.Query("collection"); collection != "" && collection != s.collection {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("collection '%s' is not served by this searcher", collection)})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,792 @@
+package searcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"common/segcrypt"
+	"common/tenant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tiers of the segments of a collection.
+const (
+	// TierWarm segments, the most recent ones, are kept on local disk.
+	TierWarm = "warm"
+	// TierCold segments stay in object storage until a request needs them; fetched copies
+	// are evicted from the local cache when it runs out of space.
+	TierCold = "cold"
+)
+
+// pinsFile records the pinned segments in the cache directory of a collection.
+const pinsFile = "pins.json"
+
+var (
+	// ErrSegmentNotFound is returned for segments missing from the segment store.
+	ErrSegmentNotFound = errors.New("segment not found")
+	// ErrInvalidSegmentName is returned for segment names that aren't a directory name.
+	ErrInvalidSegmentName = errors.New("invalid segment name")
+	// ErrTieringDisabled is returned by the segment admin API of searchers without tiering.
+	ErrTieringDisabled = errors.New("segment tiering is not configured")
+)
+
+// SegmentInfo describes a segment of the segment store.
+type SegmentInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"` // Bytes of its files
+}
+
+// SegmentStore is the object storage the Indexer uploads the segments of a collection to.
+type SegmentStore interface {
+	// ListSegments returns the complete segments, oldest first.
+	ListSegments() ([]SegmentInfo, error)
+	// FetchSegment copies a segment and its manifest into destDir.
+	FetchSegment(name, destDir string) error
+}
+
+// DirSegmentStore is a SegmentStore reading segments laid out like the Indexer's
+// LocalFileStorage keeps them, e.g. on a mounted bucket: one directory per segment, under
+// a per-tenant and per-collection directory, holding its files and manifest.
+type DirSegmentStore struct {
+	dir string
+}
+
+// NewDirSegmentStore returns the store of a tenant's collection under root.
+func NewDirSegmentStore(root, tenantID, collection string) *DirSegmentStore {
+	return &DirSegmentStore{dir: filepath.Join(root, filepath.FromSlash(tenant.StoragePrefix(tenantID)), collection)}
+}
+
+// ListSegments returns the segments with a manifest, oldest first.
+func (d *DirSegmentStore) ListSegments() ([]SegmentInfo, error) {
+	entries, err := os.ReadDir(d.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil // Nothing uploaded yet
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments in %s: %w", d.dir, err)
+	}
+	var segments []SegmentInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		manifest, err := readSegmentManifest(filepath.Join(d.dir, entry.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // Not uploaded completely
+		}
+		if err != nil {
+			return nil, err
+		}
+		info := SegmentInfo{Name: entry.Name(), CreatedAt: manifest.CreatedAt}
+		for _, f := range manifest.Files {
+			info.Size += f.Size
+		}
+		segments = append(segments, info)
+	}
+	sortSegments(segments)
+	return segments, nil
+}
+
+// FetchSegment copies the files of a segment listed by its manifest into destDir, reading
+// files an incremental upload left in an earlier segment from there, then its manifest.
+func (d *DirSegmentStore) FetchSegment(name, destDir string) error {
+	if err := validateSegmentName(name); err != nil {
+		return err
+	}
+	segmentDir := filepath.Join(d.dir, name)
+	manifest, err := readSegmentManifest(segmentDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrSegmentNotFound, name)
+	}
+	if err != nil {
+		return err
+	}
+	if manifest.Archive != "" {
+		err = copyFile(filepath.Join(segmentDir, manifest.Archive), filepath.Join(destDir, manifest.Archive))
+	} else {
+		for _, f := range manifest.Files {
+			from := name
+			if f.Segment != "" {
+				from = f.Segment
+			}
+			path := filepath.FromSlash(f.Path)
+			if err = copyFile(filepath.Join(d.dir, from, path), filepath.Join(destDir, path)); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch segment %s: %w", name, err)
+	}
+	return copyFile(filepath.Join(segmentDir, segmentManifestFile), filepath.Join(destDir, segmentManifestFile))
+}
+
+// readSegmentManifest reads the manifest of a segment directory, rejecting those whose
+// names would lead outside of the segment directories as corrupt.
+func readSegmentManifest(segmentDir string) (*segmentManifest, error) {
+	data, err := os.ReadFile(filepath.Join(segmentDir, segmentManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest segmentManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal segment manifest in %s: %w", segmentDir, err)
+	}
+	if err := manifest.validate(); err != nil {
+		return nil, fmt.Errorf("%w: manifest in %s: %v", ErrCorruptSegment, segmentDir, err)
+	}
+	return &manifest, nil
+}
+
+// validateSegmentName rejects names that aren't a single path element.
+func validateSegmentName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w %q", ErrInvalidSegmentName, name)
+	}
+	return nil
+}
+
+// sortSegments sorts segments oldest first.
+func sortSegments(segments []SegmentInfo) {
+	sort.Slice(segments, func(i, j int) bool {
+		if !segments[i].CreatedAt.Equal(segments[j].CreatedAt) {
+			return segments[i].CreatedAt.Before(segments[j].CreatedAt)
+		}
+		return segments[i].Name < segments[j].Name
+	})
+}
+
+// copyFile copies src to dst, creating the directories of dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// TieringConfig sets which segments are kept on local disk. Segments are warm if they are
+// among the WarmSegments most recent or younger than WarmAge: they're fetched as soon as
+// they're uploaded and never evicted. The others are cold: fetched when a request needs
+// them, and evicted least recently used first once the cache exceeds CacheSize. Pinned
+// segments are kept like warm ones.
+type TieringConfig struct {
+	StoreDir     string        `yaml:"store_dir" env:"SEGMENT_STORE_DIR" flag:"segment-store-dir" usage:"Directory of the segments uploaded by the Indexer, e.g. a mounted bucket; empty disables tiering"`
+	CacheDir     string        `yaml:"cache_dir" env:"SEGMENT_CACHE_DIR" flag:"segment-cache-dir" usage:"Local directory segments are fetched into"`
+	CacheSize    int64         `yaml:"cache_size" env:"SEGMENT_CACHE_SIZE" flag:"segment-cache-size" usage:"Bytes of segments kept on local disk before cold ones are evicted; 0 is unlimited"`
+	WarmSegments int           `yaml:"warm_segments" env:"WARM_SEGMENTS" flag:"warm-segments" usage:"Most recent segments kept on local disk"`
+	WarmAge      time.Duration `yaml:"warm_age" env:"WARM_SEGMENT_AGE" flag:"warm-segment-age" usage:"Segments created more recently than this are kept on local disk"`
+	// EncryptionKey decrypts the segments of an Indexer encrypting them before upload; it
+	// must be the Indexer's encryption_key. Empty reads segments as they are stored.
+	EncryptionKey string `yaml:"encryption_key" env:"SEGMENT_ENCRYPTION_KEY" usage:"Hex-encoded AES key the Indexer encrypts segments with"`
+}
+
+// Validate checks the cache settings.
+func (c TieringConfig) Validate() error {
+	if c.CacheDir == "" {
+		return fmt.Errorf("segment tiering requires a cache directory")
+	}
+	if c.CacheSize < 0 || c.WarmSegments < 0 || c.WarmAge < 0 {
+		return fmt.Errorf("cache_size, warm_segments and warm_age must not be negative")
+	}
+	if c.EncryptionKey != "" {
+		if _, err := segcrypt.ParseKey(c.EncryptionKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentTiers keeps the segments of a collection in the local cache by tier.
+type segmentTiers struct {
+	store  SegmentStore
+	config TieringConfig
+	dir    string           // Cache directory of the collection
+	cipher *segcrypt.Cipher // Decrypts the fetched segments; nil if they aren't encrypted
+
+	mu       sync.Mutex
+	segments map[string]*tieredSegment // Segments of the store, and local ones gone from it
+	pinned   map[string]bool           // Saved to pinsFile
+	alias    *IndexAlias               // Physical indexes of a rolled-over index; nil if there are none
+	latest   string                    // Newest segment of the store loaded by the last refresh
+	fetching map[string]chan struct{}  // Closed once the fetch of a segment is over
+}
+
+// tieredSegment is the state of a segment in the cache.
+type tieredSegment struct {
+	info       SegmentInfo
+	warm       bool
+	local      bool
+	localBytes int64
+	lastAccess time.Time
+}
+
+// SetTiering makes the searcher read segments from store, keeping them in a local cache by
+// tier. The cache of the collection is kept in a per-tenant and per-collection directory of
+// CacheDir, so SetTiering must be called after SetTenant. Segments already in the cache are
+// kept.
+func (s *Searcher) SetTiering(store SegmentStore, config TieringConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	t := &segmentTiers{
+		store:    store,
+		config:   config,
+		dir:      filepath.Join(config.CacheDir, filepath.FromSlash(tenant.StoragePrefix(s.tenant)), s.collection),
+		segments: make(map[string]*tieredSegment),
+		pinned:   make(map[string]bool),
+		fetching: make(map[string]chan struct{}),
+	}
+	if config.EncryptionKey != "" {
+		key, err := segcrypt.ParseKey(config.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		if t.cipher, err = segcrypt.New(key); err != nil {
+			return err
+		}
+	}
+	if err := t.load(); err != nil {
+		return err
+	}
+	s.tiers = t
+	return nil
+}
+
+// load reads the pins and the segments already in the cache.
+func (t *segmentTiers) load() error {
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create segment cache directory: %w", err)
+	}
+	data, err := os.ReadFile(filepath.Join(t.dir, pinsFile))
+	if err == nil {
+		var pins []string
+		if err := json.Unmarshal(data, &pins); err != nil {
+			return fmt.Errorf("failed to read pinned segments: %w", err)
+		}
+		for _, name := range pins {
+			t.pinned[name] = true
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read pinned segments: %w", err)
+	}
+
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list cached segments: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(t.dir, entry.Name())
+		if !entry.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			os.RemoveAll(path) // An interrupted fetch
+			continue
+		}
+		manifest, err := readSegmentManifest(path)
+		if err != nil {
+			continue // Not a segment
+		}
+		info, _ := entry.Info()
+		t.segments[entry.Name()] = &tieredSegment{
+			info:       SegmentInfo{Name: entry.Name(), CreatedAt: manifest.CreatedAt},
+			local:      true,
+			localBytes: dirSize(path),
+			lastAccess: info.ModTime(),
+		}
+	}
+	return nil
+}
+
+// savePins writes the pinned segments. Callers must hold t.mu.
+func (t *segmentTiers) savePins() error {
+	pins := make([]string, 0, len(t.pinned))
+	for name := range t.pinned {
+		pins = append(pins, name)
+	}
+	sort.Strings(pins)
+	data, err := json.Marshal(pins)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(t.dir, pinsFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to save pinned segments: %w", err)
+	}
+	return nil
+}
+
+// refresh lists the segments of the store, fetches the warm and pinned ones missing from
+// the cache, drops local copies of segments deleted from the store and evicts cold ones
+// over the cache size. Local copies of deleted segments are kept while a fetched segment
+// is corrupt, so the searcher falls back to the last known-good segment set. Once the
+// index was rolled over, the segments are those of the physical indexes of its alias, so
+// superseded uploads are dropped like deleted segments.
+func (t *segmentTiers) refresh() error {
+	listed, err := t.store.ListSegments()
+	if err != nil {
+		return err
+	}
+	var alias *IndexAlias
+	if aliases, ok := t.store.(AliasStore); ok {
+		if alias, err = aliases.ReadAlias(); err != nil {
+			return err
+		}
+	}
+	if alias != nil {
+		listed = aliasSegments(listed, alias)
+	}
+	now := time.Now()
+	t.mu.Lock()
+	t.alias = alias
+	known := make(map[string]bool, len(listed))
+	var fetch []string
+	for i, info := range listed {
+		known[info.Name] = true
+		seg, ok := t.segments[info.Name]
+		if !ok {
+			seg = &tieredSegment{}
+			t.segments[info.Name] = seg
+		}
+		seg.info = info
+		seg.warm = len(listed)-i <= t.config.WarmSegments || (t.config.WarmAge > 0 && now.Sub(info.CreatedAt) < t.config.WarmAge)
+		if !seg.local && (seg.warm || t.pinned[info.Name]) {
+			fetch = append(fetch, info.Name)
+		}
+	}
+	t.mu.Unlock()
+
+	var firstErr error
+	corrupt := false
+	for _, name := range fetch {
+		if _, err := t.open(name); err != nil {
+			corrupt = corrupt || errors.Is(err, ErrCorruptSegment)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	// Segments deleted from the store, e.g. merged by a compaction, are the last known-good
+	// set: they are kept while one of their replacements stays corrupt.
+	t.mu.Lock()
+	var deleted []string
+	for name, seg := range t.segments {
+		if known[name] || (corrupt && seg.local) {
+			continue
+		}
+		delete(t.segments, name)
+		if seg.local {
+			deleted = append(deleted, name)
+		}
+	}
+	if corrupt && len(t.segments) > len(known) {
+		slog.Error("ALERT: keeping segments deleted from the store until their replacements download intact", "segments", len(t.segments)-len(known))
+	}
+	t.evict("")
+	if firstErr == nil && len(listed) > 0 {
+		t.latest = listed[len(listed)-1].Name
+	}
+	t.mu.Unlock()
+
+	for _, name := range deleted {
+		slog.Info("Removing segment deleted from the segment store", "segment", name)
+		if err := os.RemoveAll(filepath.Join(t.dir, name)); err != nil {
+			slog.Error("Failed to remove segment", "segment", name, "error", err)
+		}
+	}
+	return firstErr
+}
+
+// latestSegment returns the newest segment of the store as of the last refresh that
+// loaded every warm and pinned segment, empty before the first.
+func (t *segmentTiers) latestSegment() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.latest
+}
+
+// openAll fetches the segments missing from the cache: a search covers every segment of
+// the collection, so cold ones are fetched before it runs. Segments deleted from the store
+// meanwhile are skipped.
+func (t *segmentTiers) openAll() error {
+	t.mu.Lock()
+	var missing []string
+	for name, seg := range t.segments {
+		if !seg.local {
+			missing = append(missing, name)
+		}
+	}
+	t.mu.Unlock()
+	sort.Strings(missing)
+	for _, name := range missing {
+		if _, err := t.open(name); err != nil && !errors.Is(err, ErrSegmentNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// open returns the local directory of a segment, fetching it from the store if it isn't
+// in the cache. Concurrent opens of a segment wait for the same fetch, and retry it if it
+// failed.
+func (t *segmentTiers) open(name string) (string, error) {
+	if err := validateSegmentName(name); err != nil {
+		return "", err
+	}
+	path := filepath.Join(t.dir, name)
+	t.mu.Lock()
+	for {
+		seg, ok := t.segments[name]
+		if !ok {
+			t.mu.Unlock()
+			return "", fmt.Errorf("%w: %s", ErrSegmentNotFound, name)
+		}
+		if seg.local {
+			seg.lastAccess = time.Now()
+			t.mu.Unlock()
+			return path, nil
+		}
+		if done, ok := t.fetching[name]; ok {
+			t.mu.Unlock()
+			<-done
+			t.mu.Lock()
+			continue
+		}
+		break
+	}
+	done := make(chan struct{})
+	t.fetching[name] = done
+	t.mu.Unlock()
+
+	size, err := t.fetch(name, path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.fetching, name)
+	close(done)
+	if err != nil {
+		return "", err
+	}
+	if seg, ok := t.segments[name]; ok {
+		seg.local, seg.localBytes, seg.lastAccess = true, size, time.Now()
+	}
+	t.evict(name)
+	return path, nil
+}
+
+// fetch copies a segment from the store into path through a temporary directory, so that
+// the cache never holds part of a segment, and returns its size on disk. Segments whose
+// files don't match their manifest are downloaded again, up to maxFetchAttempts times.
+func (t *segmentTiers) fetch(name, path string) (int64, error) {
+	start := time.Now()
+	tmp := filepath.Join(t.dir, "."+name)
+	var err error
+	for attempt := 1; attempt <= maxFetchAttempts; attempt++ {
+		if err = t.download(name, tmp); !errors.Is(err, ErrCorruptSegment) {
+			break
+		}
+		reportCorruption(filepath.Base(t.dir), err)
+		if attempt < maxFetchAttempts {
+			slog.Warn("Downloading segment again", "segment", name, "attempt", attempt+1, "max_attempts", maxFetchAttempts)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return 0, err
+	}
+	os.RemoveAll(path)
+	if err := os.Rename(tmp, path); err != nil {
+		os.RemoveAll(tmp)
+		return 0, fmt.Errorf("failed to move fetched segment %s into the cache: %w", name, err)
+	}
+	size := dirSize(path)
+	slog.Info("Fetched segment", "segment", name, "bytes", size, "took", time.Since(start).Round(time.Millisecond))
+	return size, nil
+}
+
+// download copies a segment from the store into dir, unpacks it, checks its files against
+// its manifest and decrypts them. The manifest lists the files as uploaded, so encrypted
+// files are checked before they are decrypted.
+func (t *segmentTiers) download(name, dir string) error {
+	os.RemoveAll(dir)
+	if err := t.store.FetchSegment(name, dir); err != nil {
+		return err
+	}
+	manifest, err := readSegmentManifest(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read the manifest of segment %s: %w", name, err)
+	}
+	if manifest.Archive != "" {
+		err = unpackSegment(dir) // Verifies the extracted files
+	} else {
+		err = verifySegment(dir, manifest)
+	}
+	if err != nil || t.cipher == nil {
+		return err
+	}
+	if err := t.cipher.DecryptDir(dir, segmentManifestFile); err != nil {
+		return fmt.Errorf("failed to decrypt segment %s: %w", name, err)
+	}
+	return nil
+}
+
+// evict removes cold segments, least recently used first, while the cache exceeds its
+// size. Warm and pinned segments, and keep, are never evicted. Callers must hold t.mu.
+func (t *segmentTiers) evict(keep string) {
+	if t.config.CacheSize == 0 {
+		return
+	}
+	var used int64
+	var candidates []string
+	for name, seg := range t.segments {
+		if !seg.local {
+			continue
+		}
+		used += seg.localBytes
+		if !seg.warm && !t.pinned[name] && name != keep {
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return t.segments[candidates[i]].lastAccess.Before(t.segments[candidates[j]].lastAccess)
+	})
+	for _, name := range candidates {
+		if used <= t.config.CacheSize {
+			break
+		}
+		seg := t.segments[name]
+		if err := os.RemoveAll(filepath.Join(t.dir, name)); err != nil {
+			slog.Error("Failed to evict segment", "segment", name, "error", err)
+			continue
+		}
+		used -= seg.localBytes
+		seg.local, seg.localBytes = false, 0
+		slog.Info("Evicted cold segment from the local cache", "segment", name)
+	}
+	if used > t.config.CacheSize {
+		slog.Warn("Warm and pinned segments take more than the cache size", "bytes", used, "cache_size", t.config.CacheSize)
+	}
+}
+
+// pin keeps a segment in the cache, fetching it if needed.
+func (t *segmentTiers) pin(name string) error {
+	t.mu.Lock()
+	if _, ok := t.segments[name]; !ok {
+		t.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrSegmentNotFound, name)
+	}
+	t.pinned[name] = true
+	err := t.savePins()
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	_, err = t.open(name)
+	return err
+}
+
+// unpin lets a cold segment be evicted again.
+func (t *segmentTiers) unpin(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.pinned[name] {
+		if _, ok := t.segments[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrSegmentNotFound, name)
+		}
+		return nil
+	}
+	delete(t.pinned, name)
+	if err := t.savePins(); err != nil {
+		return err
+	}
+	t.evict("")
+	return nil
+}
+
+// SegmentStatus reports the tier and cache state of a segment, and the physical index it
+// holds once the index was rolled over.
+type SegmentStatus struct {
+	SegmentInfo
+	Index      string     `json:"index,omitempty"`
+	Role       string     `json:"role,omitempty"` // RoleWrite or RoleReadOnly
+	Tier       string     `json:"tier"`
+	Pinned     bool       `json:"pinned"`
+	Local      bool       `json:"local"` // Fetched into the local cache
+	LocalBytes int64      `json:"local_bytes,omitempty"`
+	LastAccess *time.Time `json:"last_access,omitempty"`
+}
+
+// status returns the segments, oldest first, and the bytes of the cache in use.
+func (t *segmentTiers) status() ([]SegmentStatus, int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := make([]SegmentStatus, 0, len(t.segments))
+	var used int64
+	for name := range t.segments {
+		st := t.segmentStatus(name)
+		used += st.LocalBytes
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if !statuses[i].CreatedAt.Equal(statuses[j].CreatedAt) {
+			return statuses[i].CreatedAt.Before(statuses[j].CreatedAt)
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, used
+}
+
+// segmentStatus returns the status of a known segment. Callers must hold t.mu.
+func (t *segmentTiers) segmentStatus(name string) SegmentStatus {
+	seg := t.segments[name]
+	st := SegmentStatus{SegmentInfo: seg.info, Tier: TierCold, Pinned: t.pinned[name], Local: seg.local, LocalBytes: seg.localBytes}
+	if seg.warm {
+		st.Tier = TierWarm
+	}
+	if t.alias != nil {
+		st.Index = uploadIndexName(name)
+		st.Role = t.alias.role(st.Index)
+	}
+	if !seg.lastAccess.IsZero() {
+		lastAccess := seg.lastAccess
+		st.LastAccess = &lastAccess
+	}
+	return st
+}
+
+// dirSize returns the bytes of the files under dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// SegmentsHandler lists the segments of the collection with their tier, and the alias of
+// a rolled-over index, at GET /segments.
+func (s *Searcher) SegmentsHandler(c *gin.Context) {
+	if !s.checkScope(c) || !s.checkTiering(c) {
+		return
+	}
+	segments, used := s.tiers.status()
+	s.tiers.mu.Lock()
+	alias := s.tiers.alias
+	s.tiers.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{
+		"collection":  s.collection,
+		"alias":       alias,
+		"segments":    segments,
+		"cache_bytes": used,
+		"cache_size":  s.tiers.config.CacheSize,
+	})
+}
+
+// SegmentHandler returns a segment at GET /segments/:name, fetching it into the local
+// cache if it is cold.
+func (s *Searcher) SegmentHandler(c *gin.Context) {
+	if !s.checkScope(c) || !s.checkTiering(c) {
+		return
+	}
+	if _, err := s.tiers.open(c.Param("name")); err != nil {
+		segmentError(c, err)
+		return
+	}
+	s.segmentResponse(c)
+}
+
+// PinSegmentHandler pins a segment in the local cache at PUT /segments/:name/pin.
+func (s *Searcher) PinSegmentHandler(c *gin.Context) {
+	if !s.checkScope(c) || !s.checkTiering(c) {
+		return
+	}
+	if err := s.tiers.pin(c.Param("name")); err != nil {
+		segmentError(c, err)
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Pinned segment", "segment", c.Param("name"))
+	s.segmentResponse(c)
+}
+
+// UnpinSegmentHandler unpins a segment at DELETE /segments/:name/pin; cold segments may
+// then be evicted.
+func (s *Searcher) UnpinSegmentHandler(c *gin.Context) {
+	if !s.checkScope(c) || !s.checkTiering(c) {
+		return
+	}
+	if err := s.tiers.unpin(c.Param("name")); err != nil {
+		segmentError(c, err)
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Unpinned segment", "segment", c.Param("name"))
+	s.segmentResponse(c)
+}
+
+// checkTiering writes a 501 if the searcher has no tiering.
+func (s *Searcher) checkTiering(c *gin.Context) bool {
+	if s.tiers == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": ErrTieringDisabled.Error()})
+		return false
+	}
+	return true
+}
+
+// segmentResponse writes the status of the segment named in the path.
+func (s *Searcher) segmentResponse(c *gin.Context) {
+	s.tiers.mu.Lock()
+	_, ok := s.tiers.segments[c.Param("name")]
+	var st SegmentStatus
+	if ok {
+		st = s.tiers.segmentStatus(c.Param("name"))
+	}
+	s.tiers.mu.Unlock()
+	if !ok {
+		segmentError(c, fmt.Errorf("%w: %s", ErrSegmentNotFound, c.Param("name")))
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+// segmentError writes the response of a failed segment request.
+func segmentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrSegmentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidSegmentName):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		slog.ErrorContext(c.Request.Context(), "Segment request failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch segment"})
+	}
+}
@@ -0,0 +1,332 @@
+package searcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"common/segcrypt"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// writeStoredSegment writes a segment of 100 bytes, some 250 with its manifest, in the
+// layout of the Indexer's LocalFileStorage, created at the given time. Files listed in from
+// are left in earlier segments, as by incremental uploads.
+func writeStoredSegment(t *testing.T, storeDir, name string, createdAt time.Time, from map[string]string) {
+	t.Helper()
+	dir := filepath.Join(storeDir, "products", name)
+	var files []map[string]interface{}
+	for _, path := range []string{"index_meta.json", "store/root.bolt"} {
+		f := map[string]interface{}{"path": path, "size": 50}
+		if segment, ok := from[path]; ok {
+			f["segment"] = segment
+		} else {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, path), []byte(strings.Repeat(name[:1], 50)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		files = append(files, f)
+	}
+	manifest, _ := json.Marshal(map[string]interface{}{"segment": name, "created_at": createdAt, "files": files})
+	if err := os.WriteFile(filepath.Join(dir, segmentManifestFile), manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTieredSearcher(t *testing.T, storeDir string, config TieringConfig) *Searcher {
+	t.Helper()
+	svc, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	if err := svc.SetTiering(NewDirSegmentStore(storeDir, "", "products"), config); err != nil {
+		t.Fatalf("SetTiering returned an error: %v", err)
+	}
+	return svc
+}
+
+func segmentStatuses(t *testing.T, svc *Searcher) map[string]SegmentStatus {
+	t.Helper()
+	statuses, _ := svc.tiers.status()
+	byName := make(map[string]SegmentStatus, len(statuses))
+	for _, st := range statuses {
+		byName[st.Name] = st
+	}
+	return byName
+}
+
+func TestSegmentTiers(t *testing.T) {
+	storeDir, cacheDir := t.TempDir(), t.TempDir()
+	now := time.Now()
+	writeStoredSegment(t, storeDir, "a_old", now.Add(-72*time.Hour), nil)
+	writeStoredSegment(t, storeDir, "b_older", now.Add(-48*time.Hour), nil)
+	writeStoredSegment(t, storeDir, "c_recent", now.Add(-time.Hour), map[string]string{"index_meta.json": "b_older"})
+	svc := newTieredSearcher(t, storeDir, TieringConfig{CacheDir: cacheDir, CacheSize: 600, WarmSegments: 1})
+
+	if err := svc.downloadSegments(context.Background(), ""); err != nil {
+		t.Fatalf("downloadSegments returned an error: %v", err)
+	}
+	if got := svc.Segment(); got != "c_recent" {
+		t.Errorf("Expected the searcher to report the newest segment, got %q", got)
+	}
+	statuses := segmentStatuses(t, svc)
+	if st := statuses["c_recent"]; st.Tier != TierWarm || !st.Local || st.LocalBytes == 0 {
+		t.Errorf("Expected the recent segment to be warm and local, got %+v", st)
+	}
+	if st := statuses["a_old"]; st.Tier != TierCold || st.Local {
+		t.Errorf("Expected the old segment to stay cold, got %+v", st)
+	}
+	// The file left in an earlier segment is fetched from there.
+	data, err := os.ReadFile(filepath.Join(svc.tiers.dir, "c_recent", "index_meta.json"))
+	if err != nil || string(data) != strings.Repeat("b", 50) {
+		t.Errorf("Expected the file of the earlier segment, got %q (%v)", data, err)
+	}
+
+	// Cold segments are fetched on demand, evicting the least recently used one.
+	for _, name := range []string{"a_old", "b_older"} {
+		if _, err := svc.tiers.open(name); err != nil {
+			t.Fatalf("open returned an error: %v", err)
+		}
+	}
+	statuses = segmentStatuses(t, svc)
+	if !statuses["b_older"].Local || statuses["a_old"].Local || !statuses["c_recent"].Local {
+		t.Errorf("Expected the least recently used cold segment to be evicted, got %+v", statuses)
+	}
+	if _, err := os.Stat(filepath.Join(svc.tiers.dir, "a_old")); !os.IsNotExist(err) {
+		t.Errorf("Expected the evicted segment to be removed from disk, got %v", err)
+	}
+
+	// A pinned segment is kept over the cache size, and survives a restart.
+	if err := svc.tiers.pin("a_old"); err != nil {
+		t.Fatalf("pin returned an error: %v", err)
+	}
+	if _, err := svc.tiers.open("b_older"); err != nil {
+		t.Fatalf("open returned an error: %v", err)
+	}
+	statuses = segmentStatuses(t, svc)
+	if !statuses["a_old"].Local || !statuses["a_old"].Pinned {
+		t.Errorf("Expected the pinned segment to stay local, got %+v", statuses["a_old"])
+	}
+	restarted := newTieredSearcher(t, storeDir, TieringConfig{CacheDir: cacheDir, CacheSize: 600, WarmSegments: 1})
+	if err := restarted.tiers.refresh(); err != nil {
+		t.Fatalf("refresh returned an error: %v", err)
+	}
+	if st := segmentStatuses(t, restarted)["a_old"]; !st.Pinned || !st.Local {
+		t.Errorf("Expected the pin to survive a restart, got %+v", st)
+	}
+
+	if err := svc.tiers.unpin("a_old"); err != nil {
+		t.Fatalf("unpin returned an error: %v", err)
+	}
+	if _, err := svc.tiers.open("missing"); !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("Expected ErrSegmentNotFound, got %v", err)
+	}
+
+	// Segments deleted from the store are dropped from the cache.
+	if err := os.RemoveAll(filepath.Join(storeDir, "products", "a_old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.tiers.refresh(); err != nil {
+		t.Fatalf("refresh returned an error: %v", err)
+	}
+	if _, ok := segmentStatuses(t, svc)["a_old"]; ok {
+		t.Errorf("Expected the deleted segment to be dropped")
+	}
+}
+
+func TestSegmentTiers_Search(t *testing.T) {
+	storeDir := t.TempDir()
+	now := time.Now()
+	writeStoredSegment(t, storeDir, "a_old", now.Add(-48*time.Hour), nil)
+	writeStoredSegment(t, storeDir, "b_recent", now.Add(-time.Hour), nil)
+	svc := newTieredSearcher(t, storeDir, TieringConfig{CacheDir: t.TempDir(), WarmSegments: 1})
+	if err := svc.downloadSegments(context.Background(), ""); err != nil {
+		t.Fatalf("downloadSegments returned an error: %v", err)
+	}
+	if segmentStatuses(t, svc)["a_old"].Local {
+		t.Fatal("Expected the old segment to stay cold until a search")
+	}
+
+	// A search covers every segment, so it fetches the cold ones.
+	if _, err := svc.executeSearch(context.Background(), bleve.NewSearchRequest(bleve.NewMatchAllQuery())); err != nil {
+		t.Fatalf("executeSearch returned an error: %v", err)
+	}
+	if !segmentStatuses(t, svc)["a_old"].Local {
+		t.Errorf("Expected the search to fetch the cold segment")
+	}
+}
+
+func TestDirSegmentStore_InvalidManifest(t *testing.T) {
+	storeDir := t.TempDir()
+	writeStoredSegment(t, storeDir, "a_escape", time.Now(), map[string]string{"index_meta.json": ".."})
+	store := NewDirSegmentStore(storeDir, "", "products")
+	if _, err := store.ListSegments(); !errors.Is(err, ErrCorruptSegment) {
+		t.Errorf("Expected a manifest naming a parent directory to be corrupt, got %v", err)
+	}
+	if err := store.FetchSegment("a_escape", t.TempDir()); !errors.Is(err, ErrCorruptSegment) {
+		t.Errorf("Expected the segment not to be fetched, got %v", err)
+	}
+}
+
+func TestSegmentHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storeDir := t.TempDir()
+	writeStoredSegment(t, storeDir, "a_old", time.Now().Add(-48*time.Hour), nil)
+	writeStoredSegment(t, storeDir, "b_recent", time.Now(), nil)
+	svc := newTieredSearcher(t, storeDir, TieringConfig{CacheDir: t.TempDir(), WarmAge: 24 * time.Hour})
+	if err := svc.tiers.refresh(); err != nil {
+		t.Fatalf("refresh returned an error: %v", err)
+	}
+	router := gin.New()
+	router.GET("/segments", svc.SegmentsHandler)
+	router.GET("/segments/:name", svc.SegmentHandler)
+	router.PUT("/segments/:name/pin", svc.PinSegmentHandler)
+	router.DELETE("/segments/:name/pin", svc.UnpinSegmentHandler)
+	serve := func(method, path string) (int, SegmentStatus) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		var st SegmentStatus
+		json.Unmarshal(rec.Body.Bytes(), &st)
+		return rec.Code, st
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/segments", nil))
+	var list struct {
+		Segments   []SegmentStatus `json:"segments"`
+		CacheBytes int64           `json:"cache_bytes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(list.Segments) != 2 || list.Segments[0].Name != "a_old" || list.Segments[0].Tier != TierCold || list.Segments[1].Tier != TierWarm || list.CacheBytes != list.Segments[1].LocalBytes {
+		t.Errorf("Unexpected segments %+v", list)
+	}
+
+	if code, st := serve(http.MethodGet, "/segments/a_old"); code != http.StatusOK || !st.Local || st.Pinned {
+		t.Errorf("Expected the cold segment to be fetched, got %d %+v", code, st)
+	}
+	if code, st := serve(http.MethodPut, "/segments/a_old/pin"); code != http.StatusOK || !st.Pinned {
+		t.Errorf("Expected the segment to be pinned, got %d %+v", code, st)
+	}
+	if code, st := serve(http.MethodDelete, "/segments/a_old/pin"); code != http.StatusOK || st.Pinned {
+		t.Errorf("Expected the segment to be unpinned, got %d %+v", code, st)
+	}
+	if code, _ := serve(http.MethodPut, "/segments/missing/pin"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing segment, got %d", code)
+	}
+	if code, _ := serve(http.MethodGet, "/segments/.."); code != http.StatusBadRequest && code != http.StatusNotFound {
+		t.Errorf("Expected the segment name to be rejected, got %d", code)
+	}
+
+	untiered, err := NewCollectionSearcher("products")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	router = gin.New()
+	router.GET("/segments", untiered.SegmentsHandler)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/segments", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 without tiering, got %d", rec.Code)
+	}
+}
+
+func TestSegmentTiers_Encrypted(t *testing.T) {
+	storeDir, cacheDir, plainDir := t.TempDir(), t.TempDir(), t.TempDir()
+	key := strings.Repeat("07", 32)
+	raw, _ := segcrypt.ParseKey(key)
+	c, err := segcrypt.New(raw)
+	if err != nil {
+		t.Fatalf("segcrypt.New returned an error: %v", err)
+	}
+	os.WriteFile(filepath.Join(plainDir, "root.bolt"), []byte("top secret"), 0644)
+	segmentDir := filepath.Join(storeDir, "products", "seg_1")
+	if err := c.EncryptFile(filepath.Join(plainDir, "root.bolt"), filepath.Join(segmentDir, "store", "root.bolt")); err != nil {
+		t.Fatalf("EncryptFile returned an error: %v", err)
+	}
+	info, _ := os.Stat(filepath.Join(segmentDir, "store", "root.bolt"))
+	manifest, _ := json.Marshal(map[string]interface{}{"segment": "seg_1", "created_at": time.Now(), "files": []map[string]interface{}{{"path": "store/root.bolt", "size": info.Size()}}})
+	os.WriteFile(filepath.Join(segmentDir, segmentManifestFile), manifest, 0644)
+
+	svc := newTieredSearcher(t, storeDir, TieringConfig{CacheDir: cacheDir, WarmSegments: 1, EncryptionKey: key})
+	if err := svc.tiers.refresh(); err != nil {
+		t.Fatalf("refresh returned an error: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(svc.tiers.dir, "seg_1", "store", "root.bolt")); string(data) != "top secret" {
+		t.Errorf("Expected the fetched segment to be decrypted, got %q", data)
+	}
+
+	// Another key can't read the segment, which is kept out of the cache.
+	other := newTieredSearcher(t, storeDir, TieringConfig{CacheDir: t.TempDir(), WarmSegments: 1, EncryptionKey: strings.Repeat("08", 32)})
+	if err := other.tiers.refresh(); !errors.Is(err, segcrypt.ErrDecryption) {
+		t.Errorf("Expected ErrDecryption with another key, got %v", err)
+	}
+	if st := segmentStatuses(t, other)["seg_1"]; st.Local {
+		t.Errorf("Expected the undecryptable segment not to be cached, got %+v", st)
+	}
+	if err := (TieringConfig{CacheDir: cacheDir, EncryptionKey: "not hex"}).Validate(); err == nil {
+		t.Error("Expected an error for an invalid encryption key")
+	}
+}
+
+func TestSegmentTiers_Alias(t *testing.T) {
+	storeDir, cacheDir := t.TempDir(), t.TempDir()
+	now := time.Now()
+	writeStoredSegment(t, storeDir, "products_20240101T000000Z", now.Add(-72*time.Hour), nil)
+	writeStoredSegment(t, storeDir, "products_20240102T000000Z", now.Add(-48*time.Hour), nil)
+	writeStoredSegment(t, storeDir, "products-000001_20240103T000000Z", now.Add(-time.Hour), nil)
+	writeStoredSegment(t, storeDir, "orphan", now, nil)
+	svc := newTieredSearcher(t, storeDir, TieringConfig{CacheDir: cacheDir, WarmSegments: 10})
+
+	// Without an alias every segment is served.
+	if err := svc.tiers.refresh(); err != nil {
+		t.Fatalf("refresh returned an error: %v", err)
+	}
+	if statuses := segmentStatuses(t, svc); len(statuses) != 4 {
+		t.Fatalf("Expected 4 segments without an alias, got %+v", statuses)
+	}
+
+	alias := `{"name": "products", "write_index": "products-000001", "indices": [
+		{"name": "products", "read_only": true},
+		{"name": "products-000001"}]}`
+	if err := os.WriteFile(filepath.Join(storeDir, "products", aliasFile), []byte(alias), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.tiers.refresh(); err != nil {
+		t.Fatalf("refresh returned an error: %v", err)
+	}
+	statuses := segmentStatuses(t, svc)
+	if len(statuses) != 2 {
+		t.Fatalf("Expected the latest upload of each index of the alias, got %+v", statuses)
+	}
+	if st := statuses["products_20240102T000000Z"]; st.Index != "products" || st.Role != RoleReadOnly || !st.Local {
+		t.Errorf("Expected the read-only index, got %+v", st)
+	}
+	if st := statuses["products-000001_20240103T000000Z"]; st.Index != "products-000001" || st.Role != RoleWrite {
+		t.Errorf("Expected the write index, got %+v", st)
+	}
+	if _, err := os.Stat(filepath.Join(svc.tiers.dir, "products_20240101T000000Z")); !os.IsNotExist(err) {
+		t.Errorf("Expected the superseded upload to be removed from the cache, got %v", err)
+	}
+
+	invalid := `{"name": "products", "write_index": "../products", "indices": []}`
+	if err := os.WriteFile(filepath.Join(storeDir, "products", aliasFile), []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.tiers.refresh(); !errors.Is(err, ErrInvalidSegmentName) {
+		t.Errorf("Expected ErrInvalidSegmentName for an alias escaping the store, got %v", err)
+	}
+}
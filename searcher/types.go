@@ -0,0 +1,86 @@
+package searcher
+
+import (
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Bleve's defaults for the field naming the document type of a document, whose type
+// mapping indexes it, and for the type of documents without one.
+const (
+	TypeField   = "_type"
+	DefaultType = "_default"
+)
+
+// ParseTypes splits the comma-separated "types" parameter, ignoring empty entries.
+func ParseTypes(param string) []string {
+	var types []string
+	for _, t := range strings.Split(param, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// documentTypes returns the type field and default type of the index mapping, the type of
+// documents without a type field.
+func (s *Searcher) documentTypes() (string, string) {
+	index, release, err := s.acquireIndex()
+	if err != nil {
+		return TypeField, DefaultType
+	}
+	defer release()
+	if m, ok := index.Mapping().(*mapping.IndexMappingImpl); ok {
+		return m.TypeField, m.DefaultType
+	}
+	return TypeField, DefaultType
+}
+
+// restrictTypes restricts q to the documents of types. Types are matched with the
+// analyzer of the type field; the default type also matches documents without one.
+func restrictTypes(q query.Query, types []string, typeField, defaultType string) query.Query {
+	if len(types) == 0 {
+		return q
+	}
+	alternatives := make([]query.Query, 0, len(types)+1)
+	for _, t := range types {
+		match := bleve.NewMatchQuery(t)
+		match.SetField(typeField)
+		match.SetOperator(query.MatchQueryOperatorAnd)
+		alternatives = append(alternatives, match)
+		if t == defaultType {
+			typed := bleve.NewWildcardQuery("*")
+			typed.SetField(typeField)
+			untyped := bleve.NewBooleanQuery()
+			untyped.AddMust(bleve.NewMatchAllQuery())
+			untyped.AddMustNot(typed)
+			alternatives = append(alternatives, untyped)
+		}
+	}
+	return bleve.NewConjunctionQuery(q, bleve.NewDisjunctionQuery(alternatives...))
+}
+
+// fillDefaultType sets the type field of the hits of untyped documents to the default
+// type, so every hit reports its type when the field is asked for by name.
+func fillDefaultType(hits []SearchHit, fields []string, typeField, defaultType string) {
+	requested := false
+	for _, f := range fields {
+		requested = requested || f == typeField
+	}
+	if !requested {
+		return
+	}
+	for i := range hits {
+		if _, ok := hits[i].Fields[typeField]; ok {
+			continue
+		}
+		if hits[i].Fields == nil {
+			hits[i].Fields = make(map[string]interface{})
+		}
+		hits[i].Fields[typeField] = defaultType
+	}
+}
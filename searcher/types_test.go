@@ -0,0 +1,76 @@
+package searcher
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSearchHandler_Types(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, err := NewCollectionSearcher("catalog")
+	if err != nil {
+		t.Fatalf("NewCollectionSearcher returned an error: %v", err)
+	}
+	docs := map[string]map[string]interface{}{
+		"boots":   {"_type": "product", "text": "red boots"},
+		"review":  {"_type": "review", "text": "red boots are great"},
+		"untyped": {"text": "red boots sale"},
+	}
+	for id, doc := range docs {
+		if err := svc.index.Index(id, doc); err != nil {
+			t.Fatalf("Failed to index document: %v", err)
+		}
+	}
+	router := gin.New()
+	router.GET("/search", svc.SearchHandler)
+
+	search := func(query string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Results []SearchHit `json:"results"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		types := make(map[string]interface{})
+		for _, hit := range body.Results {
+			types[hit.ID] = hit.Fields[TypeField]
+		}
+		return types
+	}
+	ids := func(types map[string]interface{}) []string {
+		var ids []string
+		for id := range types {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	if got := ids(search("q=boots&types=product")); len(got) != 1 || got[0] != "boots" {
+		t.Errorf("Expected only the product, got %v", got)
+	}
+	if got := ids(search("q=boots&types=review,_default")); len(got) != 2 || got[0] != "review" || got[1] != "untyped" {
+		t.Errorf("Expected the review and the untyped document, got %v", got)
+	}
+	types := search("q=boots&fields=" + TypeField)
+	if len(types) != 3 || types["boots"] != "product" || types["untyped"] != DefaultType {
+		t.Errorf("Expected every hit to report its type, got %v", types)
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	if got := ParseTypes(" product, ,review "); len(got) != 2 || got[0] != "product" || got[1] != "review" {
+		t.Errorf("Unexpected types %v", got)
+	}
+	if got := ParseTypes(""); got != nil {
+		t.Errorf("Expected no types, got %v", got)
+	}
+}